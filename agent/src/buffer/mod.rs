@@ -152,6 +152,7 @@ mod tests {
             is_initial: false,
             metrics_type: 0,
             user_sessions: vec![],
+            collector_status: vec![],
         }
     }
 
@@ -3,7 +3,7 @@ use std::time::{Duration, Instant};
 
 use parking_lot::RwLock;
 
-use crate::utils::safe_command::exec_with_timeout;
+use crate::utils::safe_command::{exec_with_timeout, program_in_path};
 
 /// Default cache duration for GPU metrics (5 seconds)
 const GPU_CACHE_DURATION: Duration = Duration::from_secs(5);
@@ -46,6 +46,10 @@ pub struct GpuCollector {
     driver_version: String,
     /// Cached metrics with timestamp for reducing nvidia-smi calls
     cached_metrics: RwLock<Option<(Vec<GpuMetrics>, Instant)>>,
+    /// Set when a vendor tool is installed but failed the startup probe
+    probe_error: Option<String>,
+    /// Failure from the most recent fresh collection, cleared on success
+    last_error: RwLock<Option<String>>,
 }
 
 impl GpuCollector {
@@ -62,6 +66,15 @@ impl GpuCollector {
         let intel_gpu_top_available = Self::check_intel_gpu_top_available();
         let xpu_smi_available = Self::check_xpu_smi_available();
 
+        // nvidia-smi on PATH that fails to query usually means a broken driver
+        // (e.g. NVML driver/library version mismatch), not a GPU-less host
+        let probe_error = if !nvidia_available && program_in_path("nvidia-smi") {
+            tracing::warn!("nvidia-smi is installed but failed to query GPUs");
+            Some("nvidia-smi is installed but failed to query GPUs".to_string())
+        } else {
+            None
+        };
+
         tracing::info!(
             "GpuCollector initialized: nvidia={}, amd={}, intel_gpu_top={}, xpu_smi={}",
             nvidia_available,
@@ -77,9 +90,21 @@ impl GpuCollector {
             xpu_smi_available,
             driver_version,
             cached_metrics: RwLock::new(None),
+            probe_error,
+            last_error: RwLock::new(None),
         }
     }
 
+    /// Reason the collector is failing, or None if it is healthy
+    ///
+    /// A host without GPUs is healthy; only tools that are present but
+    /// failing are reported.
+    pub fn error(&self) -> Option<String> {
+        self.probe_error
+            .clone()
+            .or_else(|| self.last_error.read().clone())
+    }
+
     fn check_nvidia_available() -> bool {
         let mut cmd = Command::new("nvidia-smi");
         cmd.args(["--query-gpu=name", "--format=csv,noheader"]);
@@ -170,11 +195,13 @@ impl GpuCollector {
 
         // Cache miss or expired - collect fresh metrics
         let mut gpus = Vec::new();
+        let mut error = None;
 
         // NVIDIA is supported on all platforms via nvidia-smi
         if self.nvidia_available {
-            if let Some(nvidia_gpus) = self.collect_nvidia() {
-                gpus.extend(nvidia_gpus);
+            match self.collect_nvidia() {
+                Some(nvidia_gpus) => gpus.extend(nvidia_gpus),
+                None => error = Some("nvidia-smi query failed".to_string()),
             }
         }
 
@@ -183,8 +210,11 @@ impl GpuCollector {
         {
             // AMD via rocm-smi (Linux only)
             if self.amd_available {
-                if let Some(amd_gpus) = self.collect_amd() {
-                    gpus.extend(amd_gpus);
+                match self.collect_amd() {
+                    Some(amd_gpus) => gpus.extend(amd_gpus),
+                    None => {
+                        error.get_or_insert_with(|| "rocm-smi query failed".to_string());
+                    }
                 }
             }
 
@@ -223,6 +253,7 @@ impl GpuCollector {
 
         // Update cache
         *self.cached_metrics.write() = Some((gpus.clone(), Instant::now()));
+        *self.last_error.write() = error;
 
         gpus
     }
@@ -21,7 +21,7 @@ use crate::proto::{
 
 use super::{
     CpuCollector, DiskCollector, GpuCollector, MemoryCollector, NetworkCollector, NpuCollector,
-    SessionCollector, SystemInfoCollector,
+    SessionCollector, SystemInfoCollector, collector_status,
 };
 
 /// Messages that can be sent from the layered collector
@@ -429,6 +429,8 @@ impl LayeredCollector {
             load_average,
             gpu_usage,
             npu_usage,
+            delta: false,
+            changed_fields: 0,
         })
     }
 
@@ -441,6 +443,7 @@ impl LayeredCollector {
             disk_usage: Vec::new(),
             user_sessions: Vec::new(),
             network_updates: Vec::new(),
+            collector_status: Vec::new(),
         };
 
         // Check disk usage interval
@@ -544,6 +547,7 @@ impl LayeredCollector {
                 .duration_since(std::time::UNIX_EPOCH)
                 .map(|d| d.as_millis() as u64)
                 .unwrap_or(0);
+            periodic.collector_status = collector_status(&self.gpu_collector, &self.npu_collector);
             Some(periodic)
         } else {
             None
@@ -643,6 +647,7 @@ impl LayeredCollector {
             npus,
             metrics_type: MetricsType::MetricsFull as i32,
             is_initial,
+            collector_status: collector_status(&self.gpu_collector, &self.npu_collector),
         })
     }
 
@@ -683,6 +688,7 @@ impl LayeredCollector {
                     disk_usage,
                     user_sessions: Vec::new(),
                     network_updates: Vec::new(),
+                    collector_status: collector_status(&self.gpu_collector, &self.npu_collector),
                 };
                 let _ = tx.send(LayeredMetricsMessage::Periodic(periodic)).await;
             }
@@ -713,6 +719,7 @@ impl LayeredCollector {
                     disk_usage: Vec::new(),
                     user_sessions,
                     network_updates: Vec::new(),
+                    collector_status: collector_status(&self.gpu_collector, &self.npu_collector),
                 };
                 let _ = tx.send(LayeredMetricsMessage::Periodic(periodic)).await;
             }
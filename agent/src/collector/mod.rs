@@ -5,6 +5,7 @@ pub mod layered;
 mod memory;
 mod network;
 mod npu;
+pub mod realtime_delta;
 mod sessions;
 mod system;
 
@@ -16,7 +17,7 @@ use tracing::{debug, error, info};
 
 use crate::buffer::RingBuffer;
 use crate::config::Config;
-use crate::proto::Metrics;
+use crate::proto::{CollectorState, CollectorStatus, Metrics};
 
 pub use cpu::CpuCollector;
 pub use disk::DiskCollector;
@@ -221,6 +222,7 @@ impl MetricsCollector {
             npus,
             metrics_type: crate::proto::MetricsType::MetricsFull as i32,
             is_initial: false,
+            collector_status: collector_status(&self.gpu_collector, &self.npu_collector),
         })
     }
 
@@ -237,3 +239,25 @@ impl MetricsCollector {
         vec![]
     }
 }
+
+/// Health of the collectors that depend on external vendor tools
+///
+/// Lets the server tell "no GPU" apart from "GPU collector failed". Reflects
+/// the most recent collection, so call it after collecting.
+pub(crate) fn collector_status(gpu: &GpuCollector, npu: &NpuCollector) -> Vec<CollectorStatus> {
+    [("gpu", gpu.error()), ("npu", npu.error())]
+        .into_iter()
+        .map(|(name, error)| match error {
+            Some(reason) => CollectorStatus {
+                name: name.to_string(),
+                state: CollectorState::CollectorError as i32,
+                reason,
+            },
+            None => CollectorStatus {
+                name: name.to_string(),
+                state: CollectorState::CollectorOk as i32,
+                reason: String::new(),
+            },
+        })
+        .collect()
+}
@@ -8,7 +8,9 @@
 use std::process::Command;
 use std::time::Duration;
 
-use crate::utils::safe_command::exec_with_timeout;
+use parking_lot::RwLock;
+
+use crate::utils::safe_command::{exec_with_timeout, program_in_path};
 
 /// NPU command timeout - 15 seconds (drivers can be slow)
 const NPU_COMMAND_TIMEOUT: Duration = Duration::from_secs(15);
@@ -33,16 +35,36 @@ pub struct NpuMetrics {
 pub struct NpuCollector {
     intel_available: bool,
     huawei_available: bool,
+    /// Set when a vendor tool is installed but failed the startup probe
+    probe_error: Option<String>,
+    /// Failure from the most recent collection, cleared on success
+    last_error: RwLock<Option<String>>,
 }
 
 impl NpuCollector {
     pub fn new() -> Self {
+        let huawei_available = Self::check_huawei_npu_available();
+        let probe_error = if !huawei_available && program_in_path("npu-smi") {
+            Some("npu-smi is installed but failed to query NPUs".to_string())
+        } else {
+            None
+        };
+
         Self {
             intel_available: Self::check_intel_npu_available(),
-            huawei_available: Self::check_huawei_npu_available(),
+            huawei_available,
+            probe_error,
+            last_error: RwLock::new(None),
         }
     }
 
+    /// Reason the collector is failing, or None if it is healthy
+    pub fn error(&self) -> Option<String> {
+        self.probe_error
+            .clone()
+            .or_else(|| self.last_error.read().clone())
+    }
+
     fn check_intel_npu_available() -> bool {
         let mut cmd = Command::new("xpu-smi");
         cmd.arg("discovery");
@@ -74,6 +96,7 @@ impl NpuCollector {
 
     pub fn collect(&self) -> Vec<NpuMetrics> {
         let mut npus = Vec::new();
+        let mut error = None;
 
         if self.intel_available {
             if let Some(intel_npus) = self.collect_intel() {
@@ -82,8 +105,9 @@ impl NpuCollector {
         }
 
         if self.huawei_available {
-            if let Some(huawei_npus) = self.collect_huawei() {
-                npus.extend(huawei_npus);
+            match self.collect_huawei() {
+                Some(huawei_npus) => npus.extend(huawei_npus),
+                None => error = Some("npu-smi query failed".to_string()),
             }
         }
 
@@ -94,6 +118,7 @@ impl NpuCollector {
             }
         }
 
+        *self.last_error.write() = error;
         npus
     }
 
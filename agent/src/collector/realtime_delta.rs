@@ -0,0 +1,203 @@
+//! Delta encoding of realtime metrics for slow links
+//!
+//! When the server advises delta reporting (in HeartbeatAck), realtime
+//! messages only carry the field groups that changed since the previous
+//! message, flagged in `changed_fields`. Device lists only carry the devices
+//! that changed; the server merges them by device name or index.
+
+use crate::proto::{RealtimeField, RealtimeMetrics};
+
+/// Capability advertised in AgentInit so the server sends reporting advice
+pub const DELTA_REALTIME_CAPABILITY: &str = "delta_realtime";
+
+/// A full message is sent after this many deltas, so a server that lost an
+/// update converges again
+const FULL_RESYNC_INTERVAL: u32 = 60;
+
+/// Reduces realtime metrics to what changed since the last message sent
+#[derive(Debug, Default)]
+pub struct RealtimeDeltaEncoder {
+    last: Option<RealtimeMetrics>,
+    deltas_since_full: u32,
+}
+
+impl RealtimeDeltaEncoder {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Record a message sent in full, the baseline for later deltas
+    pub fn sent_full(&mut self, metrics: &RealtimeMetrics) {
+        self.last = Some(metrics.clone());
+        self.deltas_since_full = 0;
+    }
+
+    /// Encode metrics as a delta against the last message sent. Without a
+    /// baseline, or when a resync is due, they are returned in full.
+    pub fn encode(&mut self, metrics: RealtimeMetrics) -> RealtimeMetrics {
+        let last = match self.last.take() {
+            Some(last) if self.deltas_since_full < FULL_RESYNC_INTERVAL => last,
+            _ => {
+                self.sent_full(&metrics);
+                return metrics;
+            }
+        };
+
+        let mut delta = RealtimeMetrics {
+            timestamp: metrics.timestamp,
+            delta: true,
+            ..Default::default()
+        };
+        let mut changed = 0u32;
+
+        if metrics.cpu_usage_percent != last.cpu_usage_percent {
+            changed |= RealtimeField::CpuUsage as u32;
+            delta.cpu_usage_percent = metrics.cpu_usage_percent;
+        }
+        if metrics.cpu_per_core != last.cpu_per_core {
+            changed |= RealtimeField::CpuPerCore as u32;
+            delta.cpu_per_core = metrics.cpu_per_core.clone();
+        }
+        if metrics.cpu_temperature != last.cpu_temperature {
+            changed |= RealtimeField::CpuTemperature as u32;
+            delta.cpu_temperature = metrics.cpu_temperature;
+        }
+        if metrics.cpu_frequency_mhz != last.cpu_frequency_mhz {
+            changed |= RealtimeField::CpuFrequency as u32;
+            delta.cpu_frequency_mhz = metrics.cpu_frequency_mhz;
+        }
+        if metrics.memory_used != last.memory_used
+            || metrics.memory_cached != last.memory_cached
+            || metrics.swap_used != last.swap_used
+        {
+            changed |= RealtimeField::Memory as u32;
+            delta.memory_used = metrics.memory_used;
+            delta.memory_cached = metrics.memory_cached;
+            delta.swap_used = metrics.swap_used;
+        }
+        if metrics.load_average != last.load_average {
+            changed |= RealtimeField::LoadAverage as u32;
+            delta.load_average = metrics.load_average.clone();
+        }
+
+        delta.disk_io = changed_devices(&metrics.disk_io, &last.disk_io, |d| &d.device);
+        if !delta.disk_io.is_empty() {
+            changed |= RealtimeField::DiskIo as u32;
+        }
+        delta.network_io = changed_devices(&metrics.network_io, &last.network_io, |n| &n.interface);
+        if !delta.network_io.is_empty() {
+            changed |= RealtimeField::NetworkIo as u32;
+        }
+        delta.gpu_usage = changed_devices(&metrics.gpu_usage, &last.gpu_usage, |g| &g.index);
+        if !delta.gpu_usage.is_empty() {
+            changed |= RealtimeField::GpuUsage as u32;
+        }
+        delta.npu_usage = changed_devices(&metrics.npu_usage, &last.npu_usage, |n| &n.index);
+        if !delta.npu_usage.is_empty() {
+            changed |= RealtimeField::NpuUsage as u32;
+        }
+
+        delta.changed_fields = changed;
+        self.last = Some(metrics);
+        self.deltas_since_full += 1;
+        delta
+    }
+}
+
+/// Devices whose values differ from the previous message, or that are new
+fn changed_devices<T, K>(current: &[T], previous: &[T], key: impl Fn(&T) -> &K) -> Vec<T>
+where
+    T: Clone + PartialEq,
+    K: PartialEq + ?Sized,
+{
+    current
+        .iter()
+        .filter(|&d| !previous.iter().any(|p| key(p) == key(d) && p == d))
+        .cloned()
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::proto::{DiskIo, GpuUsage};
+
+    fn sample() -> RealtimeMetrics {
+        RealtimeMetrics {
+            timestamp: 1,
+            cpu_usage_percent: 10.0,
+            memory_used: 100,
+            disk_io: vec![
+                DiskIo {
+                    device: "sda".to_string(),
+                    read_bytes_sec: 10,
+                    ..Default::default()
+                },
+                DiskIo {
+                    device: "sdb".to_string(),
+                    read_bytes_sec: 20,
+                    ..Default::default()
+                },
+            ],
+            gpu_usage: vec![GpuUsage {
+                index: 0,
+                usage_percent: 50.0,
+                ..Default::default()
+            }],
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_first_message_is_full() {
+        let mut encoder = RealtimeDeltaEncoder::new();
+        let encoded = encoder.encode(sample());
+        assert!(!encoded.delta);
+        assert_eq!(encoded, sample());
+    }
+
+    #[test]
+    fn test_delta_carries_only_changed_fields() {
+        let mut encoder = RealtimeDeltaEncoder::new();
+        encoder.sent_full(&sample());
+
+        let mut next = sample();
+        next.timestamp = 2;
+        next.cpu_usage_percent = 20.0;
+        next.disk_io[1].read_bytes_sec = 30;
+
+        let delta = encoder.encode(next);
+        assert!(delta.delta);
+        assert_eq!(
+            delta.changed_fields,
+            RealtimeField::CpuUsage as u32 | RealtimeField::DiskIo as u32
+        );
+        assert_eq!(delta.timestamp, 2);
+        assert_eq!(delta.cpu_usage_percent, 20.0);
+        assert_eq!(delta.memory_used, 0);
+        assert_eq!(delta.disk_io.len(), 1);
+        assert_eq!(delta.disk_io[0].device, "sdb");
+        assert!(delta.gpu_usage.is_empty());
+
+        // The baseline moves on, so an unchanged tick carries nothing
+        let mut same = sample();
+        same.timestamp = 3;
+        same.cpu_usage_percent = 20.0;
+        same.disk_io[1].read_bytes_sec = 30;
+        let delta = encoder.encode(same);
+        assert_eq!(delta.changed_fields, 0);
+        assert!(delta.disk_io.is_empty());
+    }
+
+    #[test]
+    fn test_full_resync_after_interval() {
+        let mut encoder = RealtimeDeltaEncoder::new();
+        encoder.sent_full(&sample());
+        for _ in 0..FULL_RESYNC_INTERVAL {
+            assert!(encoder.encode(sample()).delta);
+        }
+        let resync = encoder.encode(sample());
+        assert!(!resync.delta);
+        assert_eq!(resync, sample());
+    }
+}
@@ -1,5 +1,6 @@
 use anyhow::{Context, Result};
 use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
 use std::path::Path;
 
 /// Current config version for migration support
@@ -426,6 +427,11 @@ pub struct AgentConfig {
     /// Preferred language (en/zh). If not set, auto-detect from system locale.
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub language: Option<String>,
+
+    /// Labels for grouping and filtering agents on the server (e.g. env: prod).
+    /// Labels assigned in the server's agent_labels take precedence.
+    #[serde(default, skip_serializing_if = "HashMap::is_empty")]
+    pub labels: HashMap<String, String>,
 }
 
 impl Default for AgentConfig {
@@ -437,6 +443,7 @@ impl Default for AgentConfig {
             reconnect_delay: default_reconnect_delay(),
             max_reconnect_delay: default_max_reconnect_delay(),
             language: None,
+            labels: HashMap::new(),
         }
     }
 }
@@ -3,23 +3,30 @@
 //! Provides high-performance bidirectional streaming for metrics and commands.
 
 use std::sync::Arc;
-use std::time::Duration;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tokio::sync::mpsc;
+use tokio::sync::{broadcast, mpsc};
 use tokio::task::JoinHandle;
 use tokio::time;
 use tokio_stream::wrappers::ReceiverStream;
+use tonic::codec::CompressionEncoding;
 use tonic::transport::{Channel, ClientTlsConfig, Endpoint};
 use tonic::{Request, Streaming};
 use tracing::{debug, error, info, warn};
 
+use super::ConnectionSignal;
+use super::heartbeat::HeartbeatRtt;
 use crate::buffer::RingBuffer;
 use crate::collector::layered::{DataRequest, LayeredCollector, LayeredMetricsMessage};
-use crate::config::{Config, ServerConfig};
+use crate::collector::realtime_delta::{DELTA_REALTIME_CAPABILITY, RealtimeDeltaEncoder};
+use crate::config::{Config, ServerConfig, ShellConfig};
+use crate::executor::LogStreams;
 use crate::proto::{
-    AgentInit, AuthRequest, AuthResponse, Command, CommandResult, DataRequestType, Heartbeat,
-    Metrics, MetricsStreamRequest, MetricsStreamResponse, metrics_stream_request,
+    AgentInit, AuthRequest, AuthResponse, Command, CommandPolicy, CommandResult, DataRequestType,
+    GracefulDisconnect, Heartbeat, LogChunk, Metrics, MetricsStreamRequest, MetricsStreamResponse,
+    MetricsSyncRequest, MetricsSyncResponse, RealtimeReportMode, metrics_stream_request,
     metrics_stream_response, nano_link_service_client::NanoLinkServiceClient,
 };
 
@@ -61,8 +68,21 @@ pub struct GrpcClient {
     config: Arc<Config>,
     server_config: ServerConfig,
     permission_level: i32,
+    session_token: String,
 }
 
+/// Metadata key carrying the agent token on metrics streams, which the server
+/// checks when a stream does not resume a session
+const AGENT_TOKEN_METADATA: &str = "x-agent-token";
+
+/// Metadata key carrying the session token on unary calls the agent makes
+/// about itself, such as SyncMetrics
+const SESSION_TOKEN_METADATA: &str = "x-session-token";
+
+/// How long to wait for the server to end its side of a stream after the
+/// agent said it is shutting down
+const GRACEFUL_DISCONNECT_TIMEOUT: Duration = Duration::from_secs(3);
+
 impl GrpcClient {
     /// Connect to a gRPC server
     pub async fn connect(server_config: &ServerConfig, config: &Arc<Config>) -> Result<Self> {
@@ -98,13 +118,18 @@ impl GrpcClient {
             .await
             .context("Failed to connect to gRPC server")?;
 
-        let client = NanoLinkServiceClient::new(channel);
+        // Metrics messages shrink by about three quarters with gzip, which
+        // the server registers; its responses are compressed to match
+        let client = NanoLinkServiceClient::new(channel)
+            .send_compressed(CompressionEncoding::Gzip)
+            .accept_compressed(CompressionEncoding::Gzip);
 
         Ok(Self {
             client,
             config: config.clone(),
             server_config: server_config.clone(),
             permission_level: 0,
+            session_token: String::new(),
         })
     }
 
@@ -122,6 +147,10 @@ impl GrpcClient {
             agent_version: env!("CARGO_PKG_VERSION").to_string(),
             os: std::env::consts::OS.to_string(),
             arch: std::env::consts::ARCH.to_string(),
+            request_metrics_ack: false,
+            session_token: self.session_token.clone(),
+            labels: self.config.agent.labels.clone(),
+            command_policy: Some(command_policy(&self.config.shell)),
         });
 
         let response = self
@@ -134,6 +163,7 @@ impl GrpcClient {
 
         if auth_response.success {
             self.permission_level = auth_response.permission_level;
+            self.session_token = auth_response.session_token.clone();
             info!(
                 "Authenticated with permission level: {}",
                 self.permission_level
@@ -145,10 +175,61 @@ impl GrpcClient {
         Ok(auth_response)
     }
 
+    /// Session token issued by the last successful authentication
+    pub fn session_token(&self) -> &str {
+        &self.session_token
+    }
+
+    /// Resume a session from an earlier connection on the next authentication
+    pub fn set_session_token(&mut self, token: String) {
+        self.session_token = token;
+    }
+
+    /// Ask the server which metrics it recorded after last_sync_timestamp, so
+    /// compensation only resends what it is missing
+    pub async fn sync_metrics(&mut self, last_sync_timestamp: u64) -> Result<MetricsSyncResponse> {
+        let agent_id = self.config.agent.agent_id.clone().unwrap_or_default();
+        if agent_id.is_empty() || self.session_token.is_empty() {
+            anyhow::bail!("No agent ID or session to sync with");
+        }
+        let mut request = Request::new(MetricsSyncRequest {
+            agent_id,
+            last_sync_timestamp,
+        });
+        request.metadata_mut().insert(
+            SESSION_TOKEN_METADATA,
+            self.session_token
+                .parse()
+                .context("Session token is not valid metadata")?,
+        );
+        let response = self
+            .client
+            .sync_metrics(request)
+            .await
+            .context("Failed to sync metrics")?;
+        Ok(response.into_inner())
+    }
+
+    /// Wrap a metrics stream in a request carrying the agent token, so the
+    /// server accepts it even when the session cannot be resumed
+    fn stream_request<T>(&self, stream: T) -> Result<Request<T>> {
+        let token = self
+            .server_config
+            .resolve_token()
+            .map_err(|e| anyhow::anyhow!("Token resolution failed: {e}"))?;
+        let mut request = Request::new(stream);
+        request.metadata_mut().insert(
+            AGENT_TOKEN_METADATA,
+            token.parse().context("Agent token is not valid metadata")?,
+        );
+        Ok(request)
+    }
+
     /// Start bidirectional streaming for metrics and commands
     pub async fn stream_metrics<F, Fut>(
         &mut self,
         buffer: Arc<RingBuffer>,
+        mut shutdown: broadcast::Receiver<ConnectionSignal>,
         command_handler: F,
     ) -> Result<()>
     where
@@ -160,9 +241,10 @@ impl GrpcClient {
         let request_stream = ReceiverStream::new(rx);
 
         // Start the bidirectional stream
+        let request = self.stream_request(request_stream)?;
         let response = self
             .client
-            .stream_metrics(Request::new(request_stream))
+            .stream_metrics(request)
             .await
             .context("Failed to start metrics stream")?;
 
@@ -176,6 +258,9 @@ impl GrpcClient {
         // Use cleanup guard to ensure task is aborted on any exit (including ? early returns)
         let mut cleanup_guard = TaskCleanupGuard::new();
 
+        let heartbeat_rtt = Arc::new(HeartbeatRtt::new());
+        let sender_rtt = heartbeat_rtt.clone();
+
         let sender_handle = tokio::spawn(async move {
             let mut interval =
                 time::interval(Duration::from_millis(config.collector.cpu_interval_ms));
@@ -189,6 +274,7 @@ impl GrpcClient {
                         if let Some(metrics) = buffer_clone.latest() {
                             let request = MetricsStreamRequest {
                                 request: Some(metrics_stream_request::Request::Metrics(metrics)),
+                                sequence: 0,
                             };
                             if tx_clone.send(request).await.is_err() {
                                 break;
@@ -199,9 +285,11 @@ impl GrpcClient {
                         let heartbeat = Heartbeat {
                             timestamp: chrono::Utc::now().timestamp_millis() as u64,
                             uptime_seconds: 0, // TODO: Calculate uptime
+                            rtt_ms: sender_rtt.sent(Instant::now()),
                         };
                         let request = MetricsStreamRequest {
                             request: Some(metrics_stream_request::Request::Heartbeat(heartbeat)),
+                            sequence: 0,
                         };
                         if tx_clone.send(request).await.is_err() {
                             break;
@@ -214,7 +302,21 @@ impl GrpcClient {
 
         // Handle responses from server
         // Note: cleanup_guard will abort tasks when dropped (including on ? early return)
-        while let Some(response) = response_stream.message().await? {
+        let mut shutting_down = false;
+        loop {
+            let response = tokio::select! {
+                response = response_stream.message() => match response? {
+                    Some(response) => response,
+                    None => break,
+                },
+                signal = shutdown.recv() => match signal {
+                    Ok(ConnectionSignal::Shutdown) | Err(broadcast::error::RecvError::Closed) => {
+                        shutting_down = true;
+                        break;
+                    }
+                    _ => continue,
+                },
+            };
             match response.response {
                 Some(metrics_stream_response::Response::Command(cmd)) => {
                     info!("Received command: {:?}", cmd.r#type);
@@ -223,6 +325,7 @@ impl GrpcClient {
                     // Send command result back
                     let request = MetricsStreamRequest {
                         request: Some(metrics_stream_request::Request::CommandResult(result)),
+                        sequence: 0,
                     };
                     if tx.send(request).await.is_err() {
                         break;
@@ -230,6 +333,12 @@ impl GrpcClient {
                 }
                 Some(metrics_stream_response::Response::HeartbeatAck(ack)) => {
                     debug!("Heartbeat acknowledged: {}", ack.timestamp);
+                    if let Some(rtt) = heartbeat_rtt.acked(Instant::now()) {
+                        debug!("Heartbeat round trip: {}ms", rtt);
+                    }
+                }
+                Some(metrics_stream_response::Response::MetricsAck(ack)) => {
+                    debug!("Metrics acknowledged: seq {}", ack.sequence);
                 }
                 Some(metrics_stream_response::Response::ConfigUpdate(_config)) => {
                     info!("Received config update from server");
@@ -240,10 +349,32 @@ impl GrpcClient {
                     // In legacy stream_metrics, we don't have layered support
                     // Just log the request for now
                 }
+                Some(metrics_stream_response::Response::StartLogStream(start)) => {
+                    // Log streaming needs the layered stream; end it right away
+                    let chunk = LogChunk {
+                        stream_id: start.stream_id,
+                        eof: true,
+                        error: "log streaming is not supported by this stream".to_string(),
+                        ..Default::default()
+                    };
+                    let request = MetricsStreamRequest {
+                        request: Some(metrics_stream_request::Request::LogChunk(chunk)),
+                        sequence: 0,
+                    };
+                    if tx.send(request).await.is_err() {
+                        break;
+                    }
+                }
+                Some(metrics_stream_response::Response::StopLogStream(_)) => {}
                 None => {}
             }
         }
 
+        if shutting_down {
+            drop(cleanup_guard);
+            close_gracefully(tx, &mut response_stream).await;
+        }
+
         // cleanup_guard is dropped here and aborts the task
         Ok(())
     }
@@ -319,6 +450,10 @@ impl GrpcClient {
             agent_version: env!("CARGO_PKG_VERSION").to_string(),
             os: std::env::consts::OS.to_string(),
             arch: std::env::consts::ARCH.to_string(),
+            request_metrics_ack: false,
+            session_token: String::new(),
+            labels: Default::default(),
+            command_policy: None,
         });
 
         let response = client
@@ -345,10 +480,15 @@ impl GrpcClient {
     /// Start bidirectional streaming with layered metrics support
     ///
     /// This method uses the LayeredCollector to send different types of metrics
-    /// at different intervals (realtime, periodic, static).
-    pub async fn stream_layered_metrics<F, Fut>(&mut self, command_handler: F) -> Result<()>
+    /// at different intervals (realtime, periodic, static). Commands are given
+    /// a sender for output streamed while they run.
+    pub async fn stream_layered_metrics<F, Fut>(
+        &mut self,
+        mut shutdown: broadcast::Receiver<ConnectionSignal>,
+        command_handler: F,
+    ) -> Result<()>
     where
-        F: Fn(Command) -> Fut + Send + Sync + 'static,
+        F: Fn(Command, mpsc::Sender<MetricsStreamRequest>) -> Fut + Send + Sync + 'static,
         Fut: std::future::Future<Output = CommandResult> + Send,
     {
         // Create channel for sending requests
@@ -356,9 +496,10 @@ impl GrpcClient {
         let request_stream = ReceiverStream::new(rx);
 
         // Start the bidirectional stream
+        let request = self.stream_request(request_stream)?;
         let response = self
             .client
-            .stream_metrics(Request::new(request_stream))
+            .stream_metrics(request)
             .await
             .context("Failed to start metrics stream")?;
 
@@ -371,10 +512,14 @@ impl GrpcClient {
             os: std::env::consts::OS.to_string(),
             arch: std::env::consts::ARCH.to_string(),
             agent_version: env!("CARGO_PKG_VERSION").to_string(),
+            capabilities: vec![DELTA_REALTIME_CAPABILITY.to_string()],
+            session_token: self.session_token.clone(),
+            labels: self.config.agent.labels.clone(),
         };
         info!("Sending AgentInit with agent_id: {}", agent_init.agent_id);
         let init_request = MetricsStreamRequest {
             request: Some(metrics_stream_request::Request::AgentInit(agent_init)),
+            sequence: 0,
         };
         tx.send(init_request)
             .await
@@ -400,8 +545,15 @@ impl GrpcClient {
         let tx_clone = tx.clone();
         let heartbeat_interval = self.config.agent.heartbeat_interval;
 
+        // Set while the server advises delta realtime reporting for this link
+        let delta_mode = Arc::new(AtomicBool::new(false));
+        let sender_delta_mode = delta_mode.clone();
+        let heartbeat_rtt = Arc::new(HeartbeatRtt::new());
+        let sender_rtt = heartbeat_rtt.clone();
+
         let sender_handle = tokio::spawn(async move {
             let mut heartbeat_ticker = time::interval(Duration::from_secs(heartbeat_interval));
+            let mut delta_encoder = RealtimeDeltaEncoder::new();
 
             loop {
                 tokio::select! {
@@ -411,23 +563,33 @@ impl GrpcClient {
                                 debug!("Sending static info");
                                 MetricsStreamRequest {
                                     request: Some(metrics_stream_request::Request::StaticInfo(static_info)),
+                                    sequence: 0,
                                 }
                             }
                             LayeredMetricsMessage::Realtime(realtime) => {
+                                let realtime = if sender_delta_mode.load(Ordering::Relaxed) {
+                                    delta_encoder.encode(realtime)
+                                } else {
+                                    delta_encoder.sent_full(&realtime);
+                                    realtime
+                                };
                                 MetricsStreamRequest {
                                     request: Some(metrics_stream_request::Request::Realtime(realtime)),
+                                    sequence: 0,
                                 }
                             }
                             LayeredMetricsMessage::Periodic(periodic) => {
                                 debug!("Sending periodic data");
                                 MetricsStreamRequest {
                                     request: Some(metrics_stream_request::Request::Periodic(periodic)),
+                                    sequence: 0,
                                 }
                             }
                             LayeredMetricsMessage::Full(metrics) => {
                                 debug!("Sending full metrics (initial={})", metrics.is_initial);
                                 MetricsStreamRequest {
                                     request: Some(metrics_stream_request::Request::Metrics(metrics)),
+                                    sequence: 0,
                                 }
                             }
                         };
@@ -441,9 +603,11 @@ impl GrpcClient {
                         let heartbeat = Heartbeat {
                             timestamp: chrono::Utc::now().timestamp_millis() as u64,
                             uptime_seconds: 0, // TODO: Calculate uptime
+                            rtt_ms: sender_rtt.sent(Instant::now()),
                         };
                         let request = MetricsStreamRequest {
                             request: Some(metrics_stream_request::Request::Heartbeat(heartbeat)),
+                            sequence: 0,
                         };
                         if tx_clone.send(request).await.is_err() {
                             error!("Failed to send heartbeat");
@@ -455,17 +619,35 @@ impl GrpcClient {
         });
         cleanup_guard.add(sender_handle);
 
+        // Log files the server asked to stream; stopped when the stream ends
+        let mut log_streams = LogStreams::new();
+
         // Handle responses from server
         // Note: cleanup_guard will abort tasks when dropped (including on ? early return)
-        while let Some(response) = response_stream.message().await? {
+        let mut shutting_down = false;
+        loop {
+            let response = tokio::select! {
+                response = response_stream.message() => match response? {
+                    Some(response) => response,
+                    None => break,
+                },
+                signal = shutdown.recv() => match signal {
+                    Ok(ConnectionSignal::Shutdown) | Err(broadcast::error::RecvError::Closed) => {
+                        shutting_down = true;
+                        break;
+                    }
+                    _ => continue,
+                },
+            };
             match response.response {
                 Some(metrics_stream_response::Response::Command(cmd)) => {
                     info!("Received command: {:?}", cmd.r#type);
-                    let result = command_handler(cmd).await;
+                    let result = command_handler(cmd, tx.clone()).await;
 
                     // Send command result back
                     let request = MetricsStreamRequest {
                         request: Some(metrics_stream_request::Request::CommandResult(result)),
+                        sequence: 0,
                     };
                     if tx.send(request).await.is_err() {
                         break;
@@ -473,6 +655,22 @@ impl GrpcClient {
                 }
                 Some(metrics_stream_response::Response::HeartbeatAck(ack)) => {
                     debug!("Heartbeat acknowledged: {}", ack.timestamp);
+                    if let Some(rtt) = heartbeat_rtt.acked(Instant::now()) {
+                        debug!("Heartbeat round trip: {}ms", rtt);
+                    }
+                    let delta = matches!(
+                        RealtimeReportMode::try_from(ack.realtime_mode),
+                        Ok(RealtimeReportMode::RealtimeReportDelta)
+                    );
+                    if delta_mode.swap(delta, Ordering::Relaxed) != delta {
+                        info!(
+                            "Server advised {} realtime reporting",
+                            if delta { "delta" } else { "full" }
+                        );
+                    }
+                }
+                Some(metrics_stream_response::Response::MetricsAck(ack)) => {
+                    debug!("Metrics acknowledged: seq {}", ack.sequence);
                 }
                 Some(metrics_stream_response::Response::ConfigUpdate(_config)) => {
                     info!("Received config update from server");
@@ -485,12 +683,65 @@ impl GrpcClient {
                         .unwrap_or(DataRequestType::DataRequestFull);
                     let _ = request_tx.send(DataRequest::from(request_type)).await;
                 }
+                Some(metrics_stream_response::Response::StartLogStream(start)) => {
+                    log_streams.start(start, tx.clone());
+                }
+                Some(metrics_stream_response::Response::StopLogStream(stop)) => {
+                    log_streams.stop(&stop.stream_id);
+                }
                 None => {}
             }
         }
 
-        // cleanup_guard is dropped here and aborts both tasks
+        if shutting_down {
+            drop(cleanup_guard);
+            drop(log_streams);
+            close_gracefully(tx, &mut response_stream).await;
+        }
+
+        // cleanup_guard and log_streams are dropped here and abort their tasks
         debug!("Layered metrics stream ended, cleanup guard will abort tasks");
         Ok(())
     }
 }
+
+/// Tells the server the agent is going offline on purpose, so it does not
+/// report a crash, then closes the stream. The tasks holding other senders
+/// must be stopped first; waiting for the server to end its side makes sure
+/// the message is delivered before the connection is dropped.
+async fn close_gracefully(
+    tx: mpsc::Sender<MetricsStreamRequest>,
+    response_stream: &mut Streaming<MetricsStreamResponse>,
+) {
+    info!("Shutting down, telling the server this is a graceful disconnect");
+    let request = MetricsStreamRequest {
+        request: Some(metrics_stream_request::Request::GracefulDisconnect(
+            GracefulDisconnect {
+                reason: "shutdown".to_string(),
+            },
+        )),
+        sequence: 0,
+    };
+    if tx.send(request).await.is_err() {
+        return;
+    }
+    drop(tx);
+
+    let drain = async { while let Ok(Some(_)) = response_stream.message().await {} };
+    if time::timeout(GRACEFUL_DISCONNECT_TIMEOUT, drain)
+        .await
+        .is_err()
+    {
+        warn!("Server did not close the stream after the graceful disconnect");
+    }
+}
+
+/// The shell policy reported to the server, so it can refuse commands this
+/// agent would reject without sending them
+fn command_policy(shell: &ShellConfig) -> CommandPolicy {
+    CommandPolicy {
+        shell_enabled: shell.enabled,
+        shell_whitelist: shell.whitelist.iter().map(|p| p.pattern.clone()).collect(),
+        shell_blacklist: shell.blacklist.clone(),
+    }
+}
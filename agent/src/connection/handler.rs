@@ -1,4 +1,5 @@
 use std::sync::Arc;
+use tokio::sync::mpsc;
 use tracing::{info, warn};
 
 use crate::buffer::RingBuffer;
@@ -7,7 +8,7 @@ use crate::executor::{
     ConfigManager, DockerExecutor, FileExecutor, LogExecutor, PackageManager, ProcessExecutor,
     ScriptExecutor, ServiceExecutor, ShellExecutor, UpdateExecutor,
 };
-use crate::proto::{Command, CommandResult, CommandType};
+use crate::proto::{Command, CommandResult, CommandType, MetricsStreamRequest};
 use crate::security::PermissionChecker;
 
 /// Handles incoming commands from the server
@@ -53,6 +54,16 @@ impl MessageHandler {
 
     /// Handle a command
     pub async fn handle_command(&self, command: Command) -> CommandResult {
+        self.handle_command_with_output(command, None).await
+    }
+
+    /// Handle a command, streaming a shell command's output on output while
+    /// it runs when the server asked for it with the stream_output param
+    pub async fn handle_command_with_output(
+        &self,
+        command: Command,
+        output: Option<mpsc::Sender<MetricsStreamRequest>>,
+    ) -> CommandResult {
         let command_type =
             CommandType::try_from(command.r#type).unwrap_or(CommandType::Unspecified);
 
@@ -147,11 +158,28 @@ impl MessageHandler {
             CommandType::SystemReboot => self.execute_system_reboot().await,
 
             // Shell command
-            CommandType::ShellExecute => {
-                self.shell_executor
-                    .execute(&command.target, &command.super_token)
-                    .await
-            }
+            CommandType::ShellExecute => match output {
+                Some(tx)
+                    if command
+                        .params
+                        .get("stream_output")
+                        .is_some_and(|v| v == "true") =>
+                {
+                    self.shell_executor
+                        .execute_streaming(
+                            &command.command_id,
+                            &command.target,
+                            &command.super_token,
+                            tx,
+                        )
+                        .await
+                }
+                _ => {
+                    self.shell_executor
+                        .execute(&command.target, &command.super_token)
+                        .await
+                }
+            },
 
             // Agent update commands
             CommandType::AgentCheckUpdate => self.update_executor.check_update().await,
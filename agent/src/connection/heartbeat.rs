@@ -0,0 +1,91 @@
+//! Heartbeat round trip measurement
+//!
+//! The agent times each heartbeat until the server's HeartbeatAck arrives and
+//! reports that round trip in its next heartbeat. The server uses it for clock
+//! skew estimates and realtime reporting advice.
+
+use std::sync::atomic::{AtomicU32, AtomicU64, Ordering};
+use std::time::Instant;
+
+/// Round trip tracker shared by the heartbeat sender and the ack handler
+#[derive(Debug)]
+pub struct HeartbeatRtt {
+    start: Instant,
+    /// Milliseconds since `start` when the pending heartbeat was sent, plus
+    /// one; zero while no heartbeat awaits its ack
+    pending: AtomicU64,
+    last_rtt_ms: AtomicU32,
+}
+
+impl HeartbeatRtt {
+    pub fn new() -> Self {
+        Self {
+            start: Instant::now(),
+            pending: AtomicU64::new(0),
+            last_rtt_ms: AtomicU32::new(0),
+        }
+    }
+
+    /// Mark a heartbeat as sent at `now` and return the round trip to report
+    /// in it, zero until one has been measured
+    pub fn sent(&self, now: Instant) -> u32 {
+        self.pending.store(self.millis(now) + 1, Ordering::Relaxed);
+        self.last_rtt_ms.load(Ordering::Relaxed)
+    }
+
+    /// Record the ack of the pending heartbeat received at `now`. Acks with no
+    /// heartbeat pending, like the one sent when the stream opens, are
+    /// ignored. Round trips are at least 1ms, as zero means unmeasured.
+    pub fn acked(&self, now: Instant) -> Option<u32> {
+        let sent = self.pending.swap(0, Ordering::Relaxed);
+        if sent == 0 {
+            return None;
+        }
+        let rtt = (self.millis(now) + 1).saturating_sub(sent).max(1);
+        let rtt = u32::try_from(rtt).unwrap_or(u32::MAX);
+        self.last_rtt_ms.store(rtt, Ordering::Relaxed);
+        Some(rtt)
+    }
+
+    fn millis(&self, now: Instant) -> u64 {
+        now.saturating_duration_since(self.start).as_millis() as u64
+    }
+}
+
+impl Default for HeartbeatRtt {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::time::Duration;
+
+    #[test]
+    fn test_reports_previous_round_trip() {
+        let rtt = HeartbeatRtt::new();
+        let t0 = rtt.start + Duration::from_secs(10);
+
+        // Nothing measured yet, and the stream-open ack is not a round trip
+        assert_eq!(rtt.acked(rtt.start), None);
+        assert_eq!(rtt.sent(t0), 0);
+        assert_eq!(rtt.acked(t0 + Duration::from_millis(120)), Some(120));
+
+        // The next heartbeat carries the measured round trip
+        let t1 = t0 + Duration::from_secs(30);
+        assert_eq!(rtt.sent(t1), 120);
+        assert_eq!(rtt.acked(t1 + Duration::from_millis(80)), Some(80));
+        assert_eq!(rtt.acked(t1 + Duration::from_millis(90)), None);
+        assert_eq!(rtt.sent(t1 + Duration::from_secs(30)), 80);
+    }
+
+    #[test]
+    fn test_sub_millisecond_round_trip_is_reported() {
+        let rtt = HeartbeatRtt::new();
+        let t0 = rtt.start + Duration::from_secs(1);
+        rtt.sent(t0);
+        assert_eq!(rtt.acked(t0 + Duration::from_micros(300)), Some(1));
+    }
+}
@@ -4,12 +4,14 @@
 
 pub mod grpc;
 mod handler;
+mod heartbeat;
 
+use std::collections::HashSet;
 use std::sync::Arc;
 use std::time::Duration;
 use tokio::sync::{RwLock, broadcast};
 use tokio::time;
-use tracing::{error, info, warn};
+use tracing::{debug, error, info, warn};
 
 use crate::buffer::RingBuffer;
 use crate::config::{Config, ServerConfig};
@@ -131,6 +133,9 @@ impl ConnectionManager {
         let mut total_connected_time: u64 = 0;
         let mut was_previously_connected = false;
         let mut reconnect_delay = initial_delay;
+        // Session from the last connection, resumed on reconnect so the
+        // server can tell which metrics it already has
+        let mut session_token = String::new();
 
         loop {
             connection_attempts += 1;
@@ -154,6 +159,10 @@ impl ConnectionManager {
                 reconnect_delay = initial_delay; // Reset to initial delay for quick reconnect
             }
 
+            // Retry advice from a failed authentication
+            let mut advised_delay: Option<u64> = None;
+            let mut stop_retrying = false;
+
             let connect_start = std::time::Instant::now();
             match grpc::GrpcClient::connect(&server, &config).await {
                 Ok(mut client) => {
@@ -180,8 +189,10 @@ impl ConnectionManager {
                     }
 
                     // Authenticate
+                    client.set_session_token(session_token.clone());
                     match client.authenticate().await {
                         Ok(auth) if auth.success => {
+                            session_token = client.session_token().to_string();
                             info!(
                                 "gRPC authenticated with permission level: {}",
                                 auth.permission_level
@@ -203,10 +214,17 @@ impl ConnectionManager {
                                 ));
 
                                 client
-                                    .stream_layered_metrics(move |cmd| {
-                                        let handler = message_handler.clone();
-                                        async move { handler.handle_command(cmd).await }
-                                    })
+                                    .stream_layered_metrics(
+                                        signal_rx.resubscribe(),
+                                        move |cmd, output| {
+                                            let handler = message_handler.clone();
+                                            async move {
+                                                handler
+                                                    .handle_command_with_output(cmd, Some(output))
+                                                    .await
+                                            }
+                                        },
+                                    )
                                     .await
                             } else {
                                 info!("Using legacy metrics stream");
@@ -218,10 +236,14 @@ impl ConnectionManager {
                                 ));
 
                                 client
-                                    .stream_metrics(buffer.clone(), move |cmd| {
-                                        let handler = message_handler.clone();
-                                        async move { handler.handle_command(cmd).await }
-                                    })
+                                    .stream_metrics(
+                                        buffer.clone(),
+                                        signal_rx.resubscribe(),
+                                        move |cmd| {
+                                            let handler = message_handler.clone();
+                                            async move { handler.handle_command(cmd).await }
+                                        },
+                                    )
                                     .await
                             };
 
@@ -257,6 +279,12 @@ impl ConnectionManager {
                             if let Some(st) = s.get_mut(status_idx) {
                                 st.last_error = Some(auth.error_message.clone());
                             }
+                            // Older servers send no reason; keep backing off as before
+                            if auth.failure_reason != 0 && !auth.retryable {
+                                stop_retrying = true;
+                            } else if auth.retry_after_ms > 0 {
+                                advised_delay = Some(u64::from(auth.retry_after_ms.div_ceil(1000)));
+                            }
                         }
                         Err(e) => {
                             error!("gRPC authentication error for {}: {}", grpc_url, e);
@@ -294,6 +322,28 @@ impl ConnectionManager {
                 }
             }
 
+            // Retrying cannot succeed (e.g. invalid token, agent too old), so wait
+            // until a reconnect is requested, typically after a config change
+            if stop_retrying {
+                error!(
+                    "Authentication to {} failed permanently, not reconnecting until requested",
+                    grpc_url
+                );
+                loop {
+                    match signal_rx.recv().await {
+                        Ok(ConnectionSignal::ImmediateReconnect) => break,
+                        Ok(ConnectionSignal::Shutdown)
+                        | Err(broadcast::error::RecvError::Closed) => {
+                            info!("Received shutdown signal, stopping connection manager");
+                            return;
+                        }
+                        Err(broadcast::error::RecvError::Lagged(_)) => {}
+                    }
+                }
+                reconnect_delay = initial_delay;
+                continue;
+            }
+
             // Update status before waiting
             {
                 let mut s = status.write().await;
@@ -312,7 +362,7 @@ impl ConnectionManager {
             );
 
             // Use select to either wait for timeout or receive immediate reconnect signal
-            let sleep_duration = Duration::from_secs(reconnect_delay);
+            let sleep_duration = Duration::from_secs(advised_delay.unwrap_or(reconnect_delay));
             tokio::select! {
                 _ = time::sleep(sleep_duration) => {
                     // Normal timeout, continue with backoff
@@ -346,11 +396,27 @@ impl ConnectionManager {
         buffer: &Arc<RingBuffer>,
         config: &Arc<Config>,
     ) {
-        let unsynced = buffer.get_unsynced();
-        let count = unsynced.len();
+        let mut unsynced = buffer.get_unsynced();
+        if unsynced.is_empty() {
+            info!("No unsynced data to compensate");
+            return;
+        }
 
+        // Skip metrics the server already recorded; without an answer, or
+        // when its buffer does not reach back far enough, resend everything
+        match client.sync_metrics(buffer.get_last_sync_timestamp()).await {
+            Ok(sync) if sync.success && !sync.resend_buffer => {
+                let recorded: HashSet<u64> = sync.metrics.iter().map(|m| m.timestamp).collect();
+                unsynced.retain(|m| !recorded.contains(&m.timestamp));
+            }
+            Ok(_) => debug!("Server cannot tell which metrics it has, resending all"),
+            Err(e) => warn!("Metrics sync failed, resending all unsynced metrics: {}", e),
+        }
+
+        let count = unsynced.len();
         if count == 0 {
-            info!("No unsynced data to compensate");
+            buffer.mark_all_synced();
+            info!("Server already has all unsynced data");
             return;
         }
 
@@ -154,7 +154,7 @@ impl LogExecutor {
     }
 
     /// Sanitize a log line to redact sensitive information
-    fn sanitize_line(&self, line: &str) -> (String, bool) {
+    pub(crate) fn sanitize_line(&self, line: &str) -> (String, bool) {
         if !self.sanitize {
             return (line.to_string(), false);
         }
@@ -577,7 +577,7 @@ impl LogExecutor {
     }
 
     /// Check if a log path is in the allowed whitelist
-    fn is_allowed_log_path(&self, path: &str) -> bool {
+    pub(crate) fn is_allowed_log_path(&self, path: &str) -> bool {
         use std::path::Path;
 
         // First check for obvious path traversal
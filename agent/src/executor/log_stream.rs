@@ -0,0 +1,320 @@
+//! Log file streaming for the dashboard's live log view
+//!
+//! The server sends StartLogStream and the agent answers with LogChunk
+//! messages: the last lines of the file first and, when following, lines
+//! appended afterwards. Only files allowed for log queries can be streamed,
+//! and lines are sanitized the same way as query results.
+
+use std::collections::HashMap;
+use std::io::SeekFrom;
+use std::time::{Duration, Instant};
+
+use tokio::fs::File;
+use tokio::io::{AsyncReadExt, AsyncSeekExt};
+use tokio::sync::mpsc;
+use tokio::task::JoinHandle;
+use tracing::{info, warn};
+
+use super::LogExecutor;
+use crate::proto::{LogChunk, MetricsStreamRequest, StartLogStream, metrics_stream_request};
+
+/// Lines sent first when the server does not ask for a number
+const DEFAULT_TAIL_LINES: u32 = 100;
+
+/// Bytes read from the end of the file to find the initial lines
+const MAX_TAIL_BYTES: u64 = 1024 * 1024;
+
+/// Lines per LogChunk message
+const MAX_CHUNK_LINES: usize = 500;
+
+/// How often a followed file is checked for new lines
+const POLL_INTERVAL: Duration = Duration::from_millis(500);
+
+/// Log streams running on this agent, by stream ID
+pub struct LogStreams {
+    tasks: HashMap<String, JoinHandle<()>>,
+}
+
+impl LogStreams {
+    pub fn new() -> Self {
+        Self {
+            tasks: HashMap::new(),
+        }
+    }
+
+    /// Start streaming a log file, sending chunks to tx
+    pub fn start(&mut self, req: StartLogStream, tx: mpsc::Sender<MetricsStreamRequest>) {
+        self.tasks.retain(|_, handle| !handle.is_finished());
+        if let Some(previous) = self.tasks.remove(&req.stream_id) {
+            previous.abort();
+        }
+        info!(
+            "[AUDIT] LogStream start: id={}, file={}, follow={}",
+            req.stream_id, req.path, req.follow
+        );
+        let stream_id = req.stream_id.clone();
+        let handle = tokio::spawn(run_log_stream(req, tx));
+        self.tasks.insert(stream_id, handle);
+    }
+
+    /// Stop a log stream; unknown IDs are ignored
+    pub fn stop(&mut self, stream_id: &str) {
+        if let Some(handle) = self.tasks.remove(stream_id) {
+            info!("[AUDIT] LogStream stop: id={}", stream_id);
+            handle.abort();
+        }
+    }
+}
+
+impl Default for LogStreams {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl Drop for LogStreams {
+    fn drop(&mut self) {
+        for handle in self.tasks.values() {
+            handle.abort();
+        }
+    }
+}
+
+/// Caps the bytes sent per second; lines over the cap are dropped
+struct ByteLimiter {
+    rate: f64,
+    tokens: f64,
+    last: Instant,
+}
+
+impl ByteLimiter {
+    /// A rate of 0 lets everything through
+    fn new(bytes_per_sec: u32) -> Self {
+        Self {
+            rate: bytes_per_sec as f64,
+            tokens: bytes_per_sec as f64,
+            last: Instant::now(),
+        }
+    }
+
+    fn allow(&mut self, bytes: usize) -> bool {
+        if self.rate <= 0.0 {
+            return true;
+        }
+        let now = Instant::now();
+        self.tokens =
+            (self.tokens + now.duration_since(self.last).as_secs_f64() * self.rate).min(self.rate);
+        self.last = now;
+        let bytes = (bytes as f64).min(self.rate);
+        if self.tokens < bytes {
+            return false;
+        }
+        self.tokens -= bytes;
+        true
+    }
+}
+
+/// Sends sanitized lines as LogChunk messages within the throughput limit
+struct ChunkSender {
+    stream_id: String,
+    tx: mpsc::Sender<MetricsStreamRequest>,
+    executor: LogExecutor,
+    limiter: ByteLimiter,
+    dropped: u32,
+}
+
+impl ChunkSender {
+    /// Send lines; returns false once the server stream has closed
+    async fn send_lines(&mut self, lines: Vec<String>) -> bool {
+        let mut kept = Vec::with_capacity(lines.len());
+        for line in lines {
+            if self.limiter.allow(line.len() + 1) {
+                kept.push(self.executor.sanitize_line(&line).0);
+            } else {
+                self.dropped += 1;
+            }
+        }
+        if kept.is_empty() {
+            return true;
+        }
+        for batch in kept.chunks(MAX_CHUNK_LINES) {
+            if !self.send(batch.to_vec(), false, String::new()).await {
+                return false;
+            }
+        }
+        true
+    }
+
+    /// Send the last chunk of the stream
+    async fn finish(&mut self, error: String) {
+        self.send(Vec::new(), true, error).await;
+    }
+
+    async fn send(&mut self, lines: Vec<String>, eof: bool, error: String) -> bool {
+        let chunk = LogChunk {
+            stream_id: self.stream_id.clone(),
+            lines,
+            dropped_lines: std::mem::take(&mut self.dropped),
+            eof,
+            error,
+        };
+        let request = MetricsStreamRequest {
+            request: Some(metrics_stream_request::Request::LogChunk(chunk)),
+            sequence: 0,
+        };
+        self.tx.send(request).await.is_ok()
+    }
+}
+
+async fn run_log_stream(req: StartLogStream, tx: mpsc::Sender<MetricsStreamRequest>) {
+    let executor = LogExecutor::new();
+    let allowed = executor.is_allowed_log_path(&req.path);
+    let mut sender = ChunkSender {
+        stream_id: req.stream_id.clone(),
+        tx,
+        executor,
+        limiter: ByteLimiter::new(req.max_bytes_per_sec),
+        dropped: 0,
+    };
+
+    if !allowed {
+        warn!(
+            "[SECURITY] Blocked log stream of non-whitelisted file: {}",
+            req.path
+        );
+        sender
+            .finish(format!(
+                "Access denied: '{}' is not in the allowed log paths",
+                req.path
+            ))
+            .await;
+        return;
+    }
+
+    let tail_lines = match req.tail_lines {
+        0 => DEFAULT_TAIL_LINES,
+        n => n,
+    };
+    let (lines, mut offset) = match read_tail(&req.path, tail_lines as usize).await {
+        Ok(tail) => tail,
+        Err(e) => {
+            sender.finish(format!("Failed to read log file: {e}")).await;
+            return;
+        }
+    };
+    if !sender.send_lines(lines).await {
+        return;
+    }
+    if !req.follow {
+        sender.finish(String::new()).await;
+        return;
+    }
+
+    // Poll for appended lines; a file shorter than what was read has been
+    // truncated or rotated, so it is read again from the start
+    let mut partial = String::new();
+    let mut ticker = tokio::time::interval(POLL_INTERVAL);
+    loop {
+        ticker.tick().await;
+        let len = match tokio::fs::metadata(&req.path).await {
+            Ok(meta) => meta.len(),
+            Err(_) => continue, // Between rotation and the new file being created
+        };
+        if len < offset {
+            offset = 0;
+            partial.clear();
+        }
+        if len == offset {
+            continue;
+        }
+        let data = match read_range(&req.path, offset, len).await {
+            Ok(data) => data,
+            Err(e) => {
+                sender.finish(format!("Failed to read log file: {e}")).await;
+                return;
+            }
+        };
+        offset += data.len() as u64;
+        partial.push_str(&String::from_utf8_lossy(&data));
+
+        // Hold back an unterminated last line until the rest is written
+        let complete = match partial.rfind('\n') {
+            Some(end) => {
+                let rest = partial.split_off(end + 1);
+                std::mem::replace(&mut partial, rest)
+            }
+            None => continue,
+        };
+        let lines = complete
+            .lines()
+            .filter(|line| !line.trim().is_empty())
+            .map(str::to_string)
+            .collect();
+        if !sender.send_lines(lines).await {
+            return;
+        }
+    }
+}
+
+/// Read the last lines of a file, returning them with the file's length
+async fn read_tail(path: &str, lines: usize) -> std::io::Result<(Vec<String>, u64)> {
+    let len = tokio::fs::metadata(path).await?.len();
+    let start = len.saturating_sub(MAX_TAIL_BYTES);
+    let data = read_range(path, start, len).await?;
+    let text = String::from_utf8_lossy(&data);
+
+    let mut all: Vec<&str> = text.lines().filter(|l| !l.trim().is_empty()).collect();
+    if start > 0 && !all.is_empty() {
+        all.remove(0); // Probably cut off by the start of the read
+    }
+    let skip = all.len().saturating_sub(lines);
+    let tail = all[skip..].iter().map(|l| l.to_string()).collect();
+    Ok((tail, start + data.len() as u64))
+}
+
+/// Read bytes [start, end) of a file
+async fn read_range(path: &str, start: u64, end: u64) -> std::io::Result<Vec<u8>> {
+    let mut file = File::open(path).await?;
+    file.seek(SeekFrom::Start(start)).await?;
+    let mut data = Vec::with_capacity(end.saturating_sub(start) as usize);
+    file.take(end.saturating_sub(start))
+        .read_to_end(&mut data)
+        .await?;
+    Ok(data)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_byte_limiter() {
+        let mut limiter = ByteLimiter::new(10);
+        assert!(limiter.allow(9));
+        assert!(!limiter.allow(9));
+
+        let mut unlimited = ByteLimiter::new(0);
+        assert!(unlimited.allow(1 << 20));
+    }
+
+    #[tokio::test]
+    async fn test_blocked_path_ends_stream() {
+        let (tx, mut rx) = mpsc::channel(4);
+        let req = StartLogStream {
+            stream_id: "s1".to_string(),
+            path: "/etc/shadow".to_string(),
+            follow: true,
+            tail_lines: 0,
+            max_bytes_per_sec: 0,
+        };
+        run_log_stream(req, tx).await;
+
+        match rx.recv().await.and_then(|r| r.request) {
+            Some(metrics_stream_request::Request::LogChunk(chunk)) => {
+                assert!(chunk.eof);
+                assert!(chunk.error.contains("Access denied"));
+            }
+            other => panic!("expected a LogChunk, got {other:?}"),
+        }
+    }
+}
@@ -2,6 +2,7 @@ mod config_mgr;
 mod docker_ops;
 mod file_ops;
 mod log_ops;
+mod log_stream;
 mod package_mgr;
 mod process_mgr;
 mod script_executor;
@@ -13,6 +14,7 @@ pub use config_mgr::ConfigManager;
 pub use docker_ops::DockerExecutor;
 pub use file_ops::FileExecutor;
 pub use log_ops::LogExecutor;
+pub use log_stream::LogStreams;
 pub use package_mgr::PackageManager;
 pub use process_mgr::ProcessExecutor;
 pub use script_executor::ScriptExecutor;
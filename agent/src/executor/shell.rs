@@ -1,12 +1,18 @@
 use std::process::Command;
 use std::sync::Arc;
 use std::time::Duration;
+use tokio::io::{AsyncRead, AsyncReadExt};
+use tokio::sync::mpsc;
+use tokio::task::JoinHandle;
 use tracing::{info, warn};
 
 use crate::config::Config;
-use crate::proto::CommandResult;
+use crate::proto::{CommandOutput, CommandResult, MetricsStreamRequest, metrics_stream_request};
 use crate::security::PermissionChecker;
 
+/// Bytes read from a streamed command's stdout or stderr at a time
+const OUTPUT_CHUNK_SIZE: usize = 4096;
+
 /// Shell command executor with security controls
 pub struct ShellExecutor {
     config: Arc<Config>,
@@ -35,6 +41,7 @@ impl ShellExecutor {
                 success: false,
                 output: String::new(),
                 error: e,
+                exit_code: -1,
                 ..Default::default()
             };
         }
@@ -61,6 +68,100 @@ impl ShellExecutor {
         result
     }
 
+    /// Execute a shell command, sending its stdout and stderr to the server
+    /// as CommandOutput messages while it runs. The result still holds the
+    /// complete output and the exit code.
+    pub async fn execute_streaming(
+        &self,
+        command_id: &str,
+        command: &str,
+        super_token: &str,
+        tx: mpsc::Sender<MetricsStreamRequest>,
+    ) -> CommandResult {
+        if let Err(e) = self
+            .permission_checker
+            .check_shell_command(command, super_token)
+        {
+            warn!("Shell command denied: {} - {}", command, e);
+            return CommandResult {
+                success: false,
+                error: e,
+                exit_code: -1,
+                ..Default::default()
+            };
+        }
+
+        info!("Executing shell command with streamed output: {}", command);
+
+        #[cfg(unix)]
+        let (shell, flag) = ("sh", "-c");
+        #[cfg(windows)]
+        let (shell, flag) = ("cmd", "/C");
+
+        let mut child = match tokio::process::Command::new(shell)
+            .args([flag, command])
+            .stdout(std::process::Stdio::piped())
+            .stderr(std::process::Stdio::piped())
+            .kill_on_drop(true)
+            .spawn()
+        {
+            Ok(child) => child,
+            Err(e) => {
+                return CommandResult {
+                    success: false,
+                    error: format!("Failed to spawn shell: {e}"),
+                    exit_code: -1,
+                    ..Default::default()
+                };
+            }
+        };
+        let stdout = forward_output(child.stdout.take(), "stdout", command_id, tx.clone());
+        let stderr = forward_output(child.stderr.take(), "stderr", command_id, tx);
+
+        let timeout_secs = self.config.shell.timeout_seconds;
+        let status =
+            match tokio::time::timeout(Duration::from_secs(timeout_secs), child.wait()).await {
+                Ok(Ok(status)) => status,
+                Ok(Err(e)) => {
+                    stdout.abort();
+                    stderr.abort();
+                    return CommandResult {
+                        success: false,
+                        error: format!("Failed to wait for process: {e}"),
+                        exit_code: -1,
+                        ..Default::default()
+                    };
+                }
+                Err(_) => {
+                    let _ = child.kill().await;
+                    stdout.abort();
+                    stderr.abort();
+                    warn!("Shell command failed: timed out after {timeout_secs} seconds");
+                    return CommandResult {
+                        success: false,
+                        error: format!("Command timed out after {timeout_secs} seconds"),
+                        exit_code: -1,
+                        ..Default::default()
+                    };
+                }
+            };
+
+        let (stdout, stderr) = tokio::join!(stdout, stderr);
+        let result = CommandResult {
+            success: status.success(),
+            output: stdout.unwrap_or_default(),
+            error: stderr.unwrap_or_default(),
+            exit_code: status.code().unwrap_or(-1),
+            ..Default::default()
+        };
+        if result.success {
+            info!("Shell command completed successfully");
+        } else {
+            warn!("Shell command failed with exit code {}", result.exit_code);
+        }
+        result
+    }
+
     /// Execute command on Unix systems
     #[cfg(unix)]
     fn execute_unix(&self, command: &str, timeout_secs: u64) -> CommandResult {
@@ -80,6 +181,7 @@ impl ShellExecutor {
                     success: false,
                     output: String::new(),
                     error: format!("Failed to spawn shell: {}", e),
+                    exit_code: -1,
                     ..Default::default()
                 };
             }
@@ -108,6 +210,7 @@ impl ShellExecutor {
                         success: status.success(),
                         output: stdout,
                         error: stderr,
+                        exit_code: status.code().unwrap_or(-1),
                         ..Default::default()
                     };
                 }
@@ -121,6 +224,7 @@ impl ShellExecutor {
                             success: false,
                             output: String::new(),
                             error: format!("Command timed out after {} seconds", timeout_secs),
+                            exit_code: -1,
                             ..Default::default()
                         };
                     }
@@ -132,6 +236,7 @@ impl ShellExecutor {
                         success: false,
                         output: String::new(),
                         error: format!("Failed to wait for process: {}", e),
+                        exit_code: -1,
                         ..Default::default()
                     };
                 }
@@ -158,6 +263,7 @@ impl ShellExecutor {
                     success: false,
                     output: String::new(),
                     error: format!("Failed to spawn shell: {e}"),
+                    exit_code: -1,
                     ..Default::default()
                 };
             }
@@ -186,6 +292,7 @@ impl ShellExecutor {
                         success: status.success(),
                         output: stdout,
                         error: stderr,
+                        exit_code: status.code().unwrap_or(-1),
                         ..Default::default()
                     };
                 }
@@ -199,6 +306,7 @@ impl ShellExecutor {
                             success: false,
                             output: String::new(),
                             error: format!("Command timed out after {timeout_secs} seconds"),
+                            exit_code: -1,
                             ..Default::default()
                         };
                     }
@@ -210,6 +318,7 @@ impl ShellExecutor {
                         success: false,
                         output: String::new(),
                         error: format!("Failed to wait for process: {e}"),
+                        exit_code: -1,
                         ..Default::default()
                     };
                 }
@@ -217,3 +326,72 @@ impl ShellExecutor {
         }
     }
 }
+
+/// Send a command's output as it is read, returning all of it once the pipe
+/// closes. Multi-byte characters split across reads are held back until
+/// complete. Output keeps being collected after the server stream closes.
+fn forward_output<R>(
+    pipe: Option<R>,
+    stream: &'static str,
+    command_id: &str,
+    tx: mpsc::Sender<MetricsStreamRequest>,
+) -> JoinHandle<String>
+where
+    R: AsyncRead + Unpin + Send + 'static,
+{
+    let command_id = command_id.to_string();
+    tokio::spawn(async move {
+        let Some(mut pipe) = pipe else {
+            return String::new();
+        };
+        let mut collected = String::new();
+        let mut pending = Vec::new();
+        let mut buf = [0u8; OUTPUT_CHUNK_SIZE];
+        let mut connected = true;
+        loop {
+            let n = match pipe.read(&mut buf).await {
+                Ok(0) | Err(_) => break,
+                Ok(n) => n,
+            };
+            pending.extend_from_slice(&buf[..n]);
+            let valid = match std::str::from_utf8(&pending) {
+                Ok(text) => text.len(),
+                Err(e) if e.error_len().is_none() => e.valid_up_to(),
+                Err(_) => pending.len(), // Not UTF-8; sent lossily
+            };
+            let data = String::from_utf8_lossy(&pending[..valid]).into_owned();
+            pending.drain(..valid);
+            if data.is_empty() {
+                continue;
+            }
+            collected.push_str(&data);
+            if connected {
+                connected = tx
+                    .send(output_request(&command_id, stream, data))
+                    .await
+                    .is_ok();
+            }
+        }
+        if !pending.is_empty() {
+            let data = String::from_utf8_lossy(&pending).into_owned();
+            collected.push_str(&data);
+            if connected {
+                let _ = tx.send(output_request(&command_id, stream, data)).await;
+            }
+        }
+        collected
+    })
+}
+
+fn output_request(command_id: &str, stream: &str, data: String) -> MetricsStreamRequest {
+    MetricsStreamRequest {
+        request: Some(metrics_stream_request::Request::CommandOutput(
+            CommandOutput {
+                command_id: command_id.to_string(),
+                stream: stream.to_string(),
+                data,
+            },
+        )),
+        sequence: 0,
+    }
+}
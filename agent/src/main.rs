@@ -28,9 +28,12 @@ use tracing_subscriber::FmtSubscriber;
 use crate::buffer::RingBuffer;
 use crate::collector::MetricsCollector;
 use crate::config::Config;
-use crate::connection::ConnectionManager;
+use crate::connection::{ConnectionManager, ConnectionSignal};
 use crate::management::ManagementServer;
 
+/// How long connections get to disconnect gracefully on shutdown
+const GRACEFUL_SHUTDOWN_TIMEOUT: std::time::Duration = std::time::Duration::from_secs(5);
+
 /// Default config file search paths (in order of priority)
 const CONFIG_SEARCH_PATHS: &[&str] = &[
     "nanolink.yaml",
@@ -3460,6 +3463,7 @@ pub async fn run_agent(config_path: PathBuf) -> Result<()> {
         ConnectionManager::new(Arc::new((*config_guard).clone()), ring_buffer.clone())
     };
     let connection_signal_tx = connection_manager.get_signal_sender();
+    let shutdown_signal_tx = connection_manager.get_signal_sender();
     let connection_status = connection_manager.get_status();
 
     // Start management API if enabled (with connection control)
@@ -3505,7 +3509,7 @@ pub async fn run_agent(config_path: PathBuf) -> Result<()> {
     };
 
     // Start connection manager (already created above)
-    let connection_handle = {
+    let mut connection_handle = {
         let mut shutdown_rx = shutdown_tx.subscribe();
         tokio::spawn(async move {
             tokio::select! {
@@ -3529,11 +3533,22 @@ pub async fn run_agent(config_path: PathBuf) -> Result<()> {
         }
     }
 
+    // Let connections tell their servers this is a graceful disconnect
+    // before everything is stopped
+    let _ = shutdown_signal_tx.send(ConnectionSignal::Shutdown);
+    let connections_closed =
+        tokio::time::timeout(GRACEFUL_SHUTDOWN_TIMEOUT, &mut connection_handle)
+            .await
+            .is_ok();
+
     // Send shutdown signal
     let _ = shutdown_tx.send(());
 
     // Wait for tasks to complete
-    let _ = tokio::join!(collector_handle, connection_handle);
+    let _ = collector_handle.await;
+    if !connections_closed {
+        let _ = connection_handle.await;
+    }
     if let Some(handle) = management_handle {
         let _ = handle.await;
     }
@@ -122,6 +122,20 @@ pub fn exec_with_timeout(mut cmd: Command, timeout: Duration) -> Option<Output>
     }
 }
 
+/// Check whether a program is present on PATH without running it
+///
+/// Used to tell "tool not installed" apart from "tool installed but failing",
+/// since exec_with_timeout returns None for both.
+pub fn program_in_path(program: &str) -> bool {
+    let Some(paths) = std::env::var_os("PATH") else {
+        return false;
+    };
+    std::env::split_paths(&paths).any(|dir| {
+        let candidate = dir.join(program);
+        candidate.is_file() || (cfg!(windows) && candidate.with_extension("exe").is_file())
+    })
+}
+
 /// Execute a command with the default timeout (5 seconds)
 pub fn exec_safe(cmd: Command) -> Option<Output> {
     exec_with_timeout(cmd, DEFAULT_COMMAND_TIMEOUT)
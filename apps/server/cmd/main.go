@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -20,6 +21,7 @@ import (
 	"github.com/chenqi92/NanoLink/apps/server/internal/service"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var (
@@ -30,8 +32,11 @@ var (
 func main() {
 	flag.Parse()
 
-	// Initialize logger
-	logger, _ := zap.NewProduction()
+	// Initialize logger, keeping recent warnings and errors for diagnostics
+	recentLogs := service.NewRecentLogs(service.DefaultRecentLogSize)
+	logger, _ := zap.NewProduction(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, recentLogs.Core())
+	}))
 	defer logger.Sync()
 	sugar := logger.Sugar()
 
@@ -56,15 +61,104 @@ func main() {
 		Database: cfg.Database.Database,
 		Username: cfg.Database.Username,
 		Password: cfg.Database.Password,
+
+		AutoMigrate: cfg.Database.AutoMigrate,
 	}
 	if err := database.Initialize(dbCfg, sugar); err != nil {
 		sugar.Fatalf("Failed to initialize database: %v", err)
 	}
-	defer database.Close()
+
+	// Background services are drained in reverse registration order on shutdown
+	lifecycle := service.NewLifecycle(sugar)
+	lifecycle.Register("database", service.ShutdownFunc(func(context.Context) error {
+		return database.Close()
+	}))
+
+	// Load stored agent tokens, generating one on first start if none exist
+	agentTokens := service.NewAgentTokenService(database.GetDB(), sugar)
+	if _, err := agentTokens.Bootstrap(&cfg.Auth); err != nil {
+		sugar.Fatalf("Failed to set up agent tokens: %v", err)
+	}
 
 	// Initialize services
-	metricsService := service.NewMetricsService(sugar)
+	metricsService := service.NewMetricsServiceWithShards(sugar, cfg.Metrics.Shards)
+	if err := metricsService.SetPartialMetricsMode(cfg.Metrics.PartialMetrics); err != nil {
+		sugar.Fatalf("Invalid partial metrics mode %q: %v", cfg.Metrics.PartialMetrics, err)
+	}
 	agentService := service.NewAgentService(sugar, metricsService)
+	metricFilter, err := service.NewMetricFilter(cfg.Metrics.Filter, cfg.Metrics.AgentFilters)
+	if err != nil {
+		sugar.Fatalf("Invalid metrics filter: %v", err)
+	}
+	metricsService.SetMetricFilter(metricFilter)
+	metricsService.SetMaxUserSessions(cfg.Metrics.MaxUserSessions)
+	for agentID, weight := range cfg.Metrics.AgentWeights {
+		metricsService.SetAgentWeight(agentID, weight)
+	}
+	if err := metricsService.SetAcceleratorThresholds(cfg.Metrics.AcceleratorHealth); err != nil {
+		sugar.Fatalf("Invalid accelerator health thresholds: %v", err)
+	}
+
+	// Flag metrics as stale once they age out or their agent disconnects
+	metricsService.SetStaleAfter(time.Duration(cfg.Metrics.StaleAfterSec) * time.Second)
+	metricsService.SetLivenessCheck(func(agentID string) bool {
+		return agentService.GetAgent(agentID) != nil
+	})
+
+	// Track agent source IPs to detect unexpected relocation/compromise
+	ipTracker := service.NewIPTracker(sugar, cfg.Security.AlertOnIPChange)
+	agentService.SetIPTracker(ipTracker)
+
+	// Bounded in-memory history of alerts and agent events
+	alertHistoryAge := time.Duration(cfg.Alerts.History.MaxAgeSec) * time.Second
+	alertStore := service.NewAlertStore(cfg.Alerts.History.MaxAlerts, alertHistoryAge)
+	eventStore := service.NewAlertStore(cfg.Alerts.History.MaxEvents, alertHistoryAge)
+
+	// Measure heartbeat latency and clock skew, alerting on drifting clocks
+	heartbeatMonitor := service.NewHeartbeatMonitor(time.Duration(cfg.Alerts.MaxClockSkewSec) * time.Second)
+	heartbeatMonitor.SetStore(alertStore)
+	agentService.SetHeartbeatMonitor(heartbeatMonitor)
+
+	// Server-wide events (degraded mode, mass disconnects) streamed to admins
+	serverEvents := service.NewServerEventBus(cfg.Alerts.History.MaxServerEvents, sugar)
+
+	// Identify this instance so multi-instance deployments can tell which
+	// server holds each agent's connection. A generated ID is kept next to
+	// the database so it survives restarts.
+	dataDir := "./data"
+	if cfg.Database.Path != "" {
+		dataDir = filepath.Dir(cfg.Database.Path)
+	}
+	instanceID, err := service.ResolveInstanceID(cfg.Server.InstanceID, filepath.Join(dataDir, "instance_id"))
+	if err != nil {
+		sugar.Fatalf("Failed to resolve server instance ID: %v", err)
+	}
+	agentService.SetInstance(instanceID, service.NewMemoryDirectory())
+	sugar.Infof("Server instance ID: %s", instanceID)
+
+	// Keep a hardware baseline per agent to spot swapped or missing hardware
+	hardwareBaselines := service.NewHardwareBaselineService(database.GetDB(), sugar)
+	agentService.SetHardwareBaselines(hardwareBaselines)
+
+	// Keep a configuration baseline per agent to spot unplanned changes
+	driftService := service.NewDriftService(hardwareBaselines, metricsService, sugar)
+	agentService.SetDriftService(driftService)
+
+	// Report agent discovery, hardware changes and removal to an external CMDB
+	if cfg.Webhooks.Lifecycle.URL != "" {
+		lifecycleNotifier := service.NewLifecycleNotifier(database.GetDB(), cfg.Webhooks.Lifecycle, sugar)
+		agentService.SetLifecycleNotifier(lifecycleNotifier)
+		lifecycle.Register("lifecycle webhooks", lifecycleNotifier)
+		sugar.Infof("Agent lifecycle webhook enabled: %s", cfg.Webhooks.Lifecycle.URL)
+	}
+
+	// Page incident tooling when agents connect, disconnect or raise alerts
+	var webhookNotifier *service.WebhookNotifier
+	if len(cfg.Webhooks.Agents.URLs) > 0 {
+		webhookNotifier = service.NewWebhookNotifier(cfg.Webhooks.Agents, agentService, metricsService, sugar)
+		lifecycle.Register("agent webhooks", webhookNotifier)
+		sugar.Infof("Agent webhooks enabled for %d URL(s)", len(cfg.Webhooks.Agents.URLs))
+	}
 
 	// Initialize metrics persistence if enabled
 	// Default to true if not explicitly set
@@ -77,14 +171,53 @@ func main() {
 		cfg.Metrics.HourlyRetentionDays = 30
 		cfg.Metrics.DailyRetentionDays = 365
 	}
-	sugar.Infof("Metrics persistence config: enabled=%v, retention=%d days", persistEnabled, cfg.Metrics.RetentionDays)
+	sugar.Infof("Metrics persistence config: enabled=%v, backend=%s, retention=%d days", persistEnabled, cfg.Metrics.Backend, cfg.Metrics.RetentionDays)
 	if persistEnabled {
-		metricsPersistence = service.NewMetricsPersistence(database.GetDB(), cfg.Metrics, sugar)
+		backend, err := service.NewPersistenceBackend(database.GetDB(), cfg.Metrics, sugar)
+		if err != nil {
+			sugar.Fatalf("Failed to set up metrics backend %q: %v", cfg.Metrics.Backend, err)
+		}
+		metricsPersistence = service.NewMetricsPersistenceWithBackend(backend, cfg.Metrics, sugar)
 		metricsService.SetPersistence(metricsPersistence)
+		metricsPersistence.SetServerEvents(serverEvents)
 		metricsPersistence.Start()
-		defer metricsPersistence.Stop()
+		lifecycle.Register("metrics persistence", metricsPersistence)
 		sugar.Info("Metrics persistence enabled")
 	}
+	switch cfg.Metrics.HistoryStore {
+	case service.HistoryStoreMemory, "":
+	case service.HistoryStoreDatabase:
+		if metricsPersistence == nil {
+			sugar.Warn("Metrics history store is database but persistence is disabled; serving history from memory")
+			break
+		}
+		metricsService.SetHistoryStore(metricsPersistence)
+		sugar.Info("Serving metrics history from persistence")
+	default:
+		sugar.Fatalf("Invalid metrics history store %q", cfg.Metrics.HistoryStore)
+	}
+
+	// Per-agent and per-label overrides of the history size, retention and
+	// persistence interval
+	retentionPolicies := service.NewRetentionPolicyService(database.GetDB(), cfg.Metrics, sugar)
+	if err := retentionPolicies.Load(); err != nil {
+		sugar.Fatalf("Failed to load retention policies: %v", err)
+	}
+	retentionPolicies.SetLabelSource(func(agentID string) map[string]string {
+		agent := agentService.GetAgent(agentID)
+		if agent == nil {
+			return nil
+		}
+		if agent.Labels == nil {
+			return map[string]string{}
+		}
+		return agent.Labels
+	})
+	retentionPolicies.SetVolatileIDCheck(agentService.HasVolatileID)
+	metricsService.SetRetentionPolicies(retentionPolicies)
+	if metricsPersistence != nil {
+		metricsPersistence.SetRetentionPolicies(retentionPolicies)
+	}
 
 	// Initialize auth services
 	jwtExpire := time.Duration(cfg.JWT.ExpireHour) * time.Hour
@@ -96,6 +229,23 @@ func main() {
 		JWTExpire: jwtExpire,
 		AdminUser: cfg.SuperAdmin.Username,
 		AdminPass: cfg.SuperAdmin.Password,
+
+		AccessExpire:  time.Duration(cfg.JWT.AccessExpireMin) * time.Minute,
+		RefreshExpire: time.Duration(cfg.JWT.RefreshExpireHour) * time.Hour,
+
+		JWTIssuer:         cfg.JWT.Issuer,
+		JWTAudience:       cfg.JWT.Audience,
+		JWTValidateClaims: cfg.JWT.ValidateClaims,
+
+		PasswordPolicy: &service.PasswordPolicy{
+			MinLength:      cfg.Passwords.MinLength,
+			MaxLength:      cfg.Passwords.MaxLength,
+			RequireLetter:  cfg.Passwords.RequireLetter,
+			RequireNumber:  cfg.Passwords.RequireNumber,
+			RequireUpper:   cfg.Passwords.RequireUpper,
+			RequireSpecial: cfg.Passwords.RequireSpecial,
+			Disallowed:     cfg.Passwords.Disallowed,
+		},
 	}
 	// Debug log for super admin configuration
 	if cfg.SuperAdmin.Username != "" {
@@ -104,9 +254,56 @@ func main() {
 		sugar.Warn("No super admin username configured from NANOLINK_ADMIN_USERNAME")
 	}
 	authService := service.NewAuthService(database.GetDB(), authConfig, sugar)
+	if cfg.OIDC.Enabled {
+		// Single sign-on alongside local accounts
+		authService.SetOIDCProvider(service.NewOIDCProvider(service.OIDCConfig{
+			IssuerURL:     cfg.OIDC.IssuerURL,
+			ClientID:      cfg.OIDC.ClientID,
+			ClientSecret:  cfg.OIDC.ClientSecret,
+			RedirectURL:   cfg.OIDC.RedirectURL,
+			Scopes:        cfg.OIDC.Scopes,
+			UsernameClaim: cfg.OIDC.UsernameClaim,
+			GroupsClaim:   cfg.OIDC.GroupsClaim,
+			GroupMapping:  cfg.OIDC.GroupMapping,
+			PostLoginURL:  cfg.OIDC.PostLoginURL,
+		}, sugar))
+		sugar.Infof("OIDC login enabled with issuer %s", cfg.OIDC.IssuerURL)
+	}
 	groupService := service.NewGroupService(database.GetDB(), sugar)
 	permService := service.NewPermissionService(database.GetDB(), sugar)
+	// Dashboard commands must match one of these before reaching an agent
+	commandTemplates := service.DefaultCommandTemplates()
 	auditService := service.NewAuditService(database.GetDB(), sugar)
+	outputMasker, err := service.NewOutputMasker(cfg.Commands.OutputMasking)
+	if err != nil {
+		sugar.Fatalf("Invalid command output masking: %v", err)
+	}
+	auditService.SetOutputMasker(outputMasker)
+	// Track dispatched commands (optionally with before/after metrics
+	// snapshots kept on their audit entries)
+	commandTracker := service.NewCommandTracker(metricsService, cfg.Commands, sugar)
+	commandTracker.SetOutputMasker(outputMasker)
+	commandTracker.SetAuditService(auditService)
+	groupService.SetAuditService(auditService)
+	permService.SetAuditService(auditService)
+	// Hostname pattern and label selector grants match connected agents
+	permService.SetAgents(agentService)
+	// Resolved agent permissions are cached briefly; every service that
+	// changes users, groups or grants invalidates the cache
+	permCache := service.NewPermissionCache(time.Duration(cfg.Security.PermissionCacheTTLSec) * time.Second)
+	permService.SetCache(permCache)
+	groupService.SetPermissionCache(permCache)
+	authService.SetPermissionCache(permCache)
+
+	// Place connecting agents into groups by subnet
+	if len(cfg.Groups.AutoAssign) > 0 {
+		autoGrouper, err := service.NewAutoGrouper(cfg.Groups.AutoAssign, groupService, permService, sugar)
+		if err != nil {
+			sugar.Fatalf("Invalid group auto-assign rules: %v", err)
+		}
+		agentService.SetAutoGrouper(autoGrouper)
+		sugar.Infof("Group auto-assignment enabled with %d rules", len(cfg.Groups.AutoAssign))
+	}
 
 	// Setup Gin router
 	if cfg.Server.Mode == "release" {
@@ -117,6 +314,20 @@ func main() {
 	router.Use(gin.Recovery())
 	router.Use(corsMiddleware())
 
+	// Agent routes accept a hostname wherever an agent ID is expected
+	resolveAgentID := handler.ResolveAgentParam(agentService, "id")
+	resolveAgentIDParam := handler.ResolveAgentParam(agentService, "agentId")
+
+	// GraphQL over the same services, for the dashboard
+	graphQLHandler := handler.NewGraphQLHandler(agentService, metricsService, permService, authService, sugar)
+	if metricsPersistence != nil {
+		graphQLHandler.SetMetricsPersistence(metricsPersistence)
+	}
+
+	// Agent config and token management; revoking a token also ends the
+	// agent connections it authenticated once the gRPC server exists
+	configGen := handler.NewConfigGenHandler(cfg, agentTokens, sugar)
+
 	// API routes
 	api := router.Group("/api")
 	{
@@ -124,12 +335,22 @@ func main() {
 		authHandler := handler.NewAuthHandler(authService, sugar)
 		api.POST("/auth/register", authHandler.Register)
 		api.POST("/auth/login", authHandler.Login)
+		api.POST("/auth/refresh", authHandler.Refresh)
+		api.POST("/auth/logout", authHandler.Logout)
+		api.GET("/auth/providers", authHandler.GetAuthProviders)
+		api.GET("/auth/oidc/login", authHandler.OIDCLogin)
+		api.GET("/auth/oidc/callback", authHandler.OIDCCallback)
 
 		// Health check (public)
 		h := handler.NewHandlerWithPermissions(agentService, metricsService, permService, sugar)
 		if metricsPersistence != nil {
 			h.SetMetricsPersistence(metricsPersistence)
 		}
+		h.SetAlertStores(alertStore, eventStore)
+		h.SetServerEvents(serverEvents)
+		h.SetDriftService(driftService)
+		h.SetCommandTemplates(commandTemplates)
+		h.SetRetentionPolicies(retentionPolicies)
 		api.GET("/health", h.Health)
 
 		// Protected routes (require authentication)
@@ -142,16 +363,32 @@ func main() {
 
 			// Agent routes (with permission filtering)
 			protected.GET("/agents", h.GetAgents)
-			protected.GET("/agents/:id", h.GetAgent)
-			protected.GET("/agents/:id/metrics", h.GetAgentMetrics)
+			protected.GET("/agents/:id", resolveAgentID, h.GetAgent)
+			protected.GET("/agents/:id/metrics", resolveAgentID, h.GetAgentMetrics)
+			protected.GET("/agents/:id/metrics/export", resolveAgentID, h.ExportMetricsHistory)
+			protected.GET("/agents/:id/instance", resolveAgentID, h.GetAgentInstance)
+			protected.GET("/agents/:id/forecast", resolveAgentID, h.GetAgentForecast)
+			protected.GET("/agents/:id/drift", resolveAgentID, h.GetAgentDrift)
 			protected.GET("/metrics", h.GetAllMetrics)
 			protected.GET("/metrics/history", h.GetMetricsHistory)
+			protected.GET("/metrics/units", h.GetMetricUnits)
+			protected.POST("/metrics/query", h.QueryMetrics)
+			protected.POST("/metrics/batch", h.GetBatchMetrics)
 			protected.GET("/summary", h.GetSummary)
+			protected.GET("/summary/weighted", h.GetWeightedSummary)
+			protected.POST("/graphql", graphQLHandler.HandleQuery)
+			protected.GET("/alerts", h.GetAlerts)
+			protected.POST("/alerts/:id/ack", h.AckAlert)
+			protected.POST("/alerts/rules/test", h.TestAlertRule)
+			protected.GET("/events", h.GetEvents)
 
 			// Command execution (requires permission check)
-			protected.POST("/agents/:id/command",
+			protected.POST("/agents/:id/command", resolveAgentID,
 				handler.RequireAgentPermission(permService, database.PermissionBasicWrite),
 				h.SendCommand)
+			protected.GET("/agents/:id/commands/:commandId", resolveAgentID,
+				handler.RequireAgentPermission(permService, database.PermissionReadOnly),
+				handler.NewCommandStatusHandler(commandTracker).GetCommandStatus)
 
 			// Group routes
 			groupHandler := handler.NewGroupHandler(groupService, sugar)
@@ -161,7 +398,7 @@ func main() {
 			// Permission check route
 			permHandler := handler.NewPermissionHandler(permService, sugar)
 			protected.POST("/permissions/check", permHandler.CheckPermission)
-			protected.GET("/agents/:id/groups", permHandler.GetAgentGroups)
+			protected.GET("/agents/:id/groups", resolveAgentID, permHandler.GetAgentGroups)
 
 			// Super admin only routes
 			admin := protected.Group("")
@@ -169,6 +406,7 @@ func main() {
 			{
 				// User management (full CRUD)
 				userHandler := handler.NewUserHandler(database.GetDB(), sugar, authService, groupService)
+				userHandler.SetPermissionCache(permCache)
 				admin.GET("/users", userHandler.ListUsers)
 				admin.GET("/users/:id", userHandler.GetUser)
 				admin.POST("/users", userHandler.CreateUser)
@@ -190,6 +428,29 @@ func main() {
 				admin.DELETE("/permissions/:userId/:agentId", permHandler.RemoveUserPermission)
 				admin.GET("/permissions/:userId", permHandler.GetUserPermissions)
 
+				// Agent importance weights for the weighted summary
+				admin.PUT("/agents/:id/weight", resolveAgentID, h.SetAgentWeight)
+
+				// Per-agent and per-label metrics retention overrides
+				admin.GET("/retention/policies", h.GetRetentionPolicies)
+				admin.PUT("/retention/labels/:label", h.SetLabelRetention)
+				admin.DELETE("/retention/labels/:label", h.DeleteLabelRetention)
+				admin.GET("/retention/agents/:id", resolveAgentID, h.GetAgentRetention)
+				admin.PUT("/retention/agents/:id", resolveAgentID, h.SetAgentRetention)
+				admin.DELETE("/retention/agents/:id", resolveAgentID, h.DeleteAgentRetention)
+
+				// Accept an agent's current configuration as its drift baseline
+				admin.POST("/agents/:id/drift/baseline", resolveAgentID, h.RebaselineAgentDrift)
+
+				// Force-disconnect a misbehaving or compromised agent
+				agentControlHandler := handler.NewAgentControlHandler(agentService, auditService, sugar)
+				admin.POST("/agents/:id/disconnect", resolveAgentID, agentControlHandler.DisconnectAgent)
+				admin.DELETE("/agents/:agentId", resolveAgentIDParam, agentControlHandler.DeregisterAgent)
+
+				// Agent connection diagnostics
+				admin.GET("/stats/streams", h.GetStreamStats)
+				admin.GET("/server/events", h.GetServerEvents)
+
 				// Audit log routes (super admin only)
 				auditHandler := handler.NewAuditHandler(auditService, sugar)
 				admin.GET("/audit/logs", auditHandler.QueryAuditLogs)
@@ -197,17 +458,46 @@ func main() {
 				admin.GET("/audit/logs/agent/:agentId", auditHandler.GetAgentAuditLogs)
 				admin.GET("/audit/stats", auditHandler.GetAuditStats)
 				admin.GET("/audit/recent", auditHandler.GetRecentLogs)
+				admin.GET("/audit/permissions", auditHandler.GetPermissionChanges)
+
+				// Configuration backup (disaster recovery)
+				backupService := service.NewConfigBackupService(database.GetDB(), sugar)
+				backupService.SetPermissionCache(permCache)
+				backupHandler := handler.NewConfigBackupHandler(backupService, sugar)
+				backupHandler.SetServerEvents(serverEvents)
+				admin.GET("/config/export", backupHandler.ExportConfig)
+				admin.POST("/config/import", backupHandler.ImportConfig)
+
+				// Agent tokens: issuing one creates a credential agents can use
+				admin.GET("/config/tokens", configGen.ListTokens)
+				admin.POST("/config/generate-token", configGen.GenerateToken)
+				admin.POST("/config/revoke-token", configGen.RevokeToken)
+
+				// Support bundle with secrets redacted
+				diagnostics := service.NewDiagnosticsService(cfg, agentService, metricsService, version)
+				diagnostics.SetServerEvents(serverEvents)
+				diagnostics.SetRecentLogs(recentLogs)
+				admin.GET("/diagnostics", handler.NewDiagnosticsHandler(diagnostics, sugar).GetDiagnostics)
 			}
 		}
 
 		// Configuration generator routes (protected)
-		configGen := handler.NewConfigGenHandler(cfg, sugar)
 		api.GET("/server-info", configGen.GetServerURLInfo)
 		api.POST("/config/generate", configGen.GenerateConfig)
 		api.POST("/config/add-server", configGen.GenerateAddServerCommand)
 		api.POST("/config/remove-server", configGen.GenerateRemoveServerCommand)
-		api.GET("/config/tokens", configGen.ListTokens)
-		api.POST("/config/generate-token", configGen.GenerateToken)
+	}
+
+	// Prometheus scrape endpoint, outside the JWT-protected API. It lists
+	// every agent's hostname and utilization, so it is opt-in.
+	if cfg.Metrics.Prometheus.Enabled {
+		if cfg.Metrics.Prometheus.BearerToken == "" {
+			sugar.Warn("Prometheus /metrics is enabled without a bearer_token; anyone who can reach the HTTP port can scrape it")
+		}
+		prometheusHandler := handler.NewPrometheusHandler(metricsService)
+		prometheusHandler.SetPerCoreCPU(cfg.Metrics.Prometheus.PerCoreCPU)
+		prometheusHandler.SetBearerToken(cfg.Metrics.Prometheus.BearerToken)
+		router.GET("/metrics", prometheusHandler.Metrics)
 	}
 
 	// Serve embedded web UI
@@ -222,6 +512,13 @@ func main() {
 	// Shell WebSocket endpoint (needs gRPC server reference, so created after)
 	// Will be registered after gRPC server is created
 
+	// Agent WebSocket, either on the HTTP port or its own
+	wsHandler := handler.NewWebSocketHandler(agentService, metricsService, cfg, sugar)
+	wsHandler.SetAgentTokens(agentTokens)
+	if cfg.Server.SinglePort {
+		wsHandler.RegisterRoute(router)
+	}
+
 	// Start HTTP server
 	httpServer := &http.Server{
 		Addr:    fmt.Sprintf(":%d", cfg.Server.HTTPPort),
@@ -234,42 +531,75 @@ func main() {
 			sugar.Fatalf("HTTP server error: %v", err)
 		}
 	}()
+	lifecycle.Register("HTTP server", httpServer)
 
 	// Start WebSocket server
-	wsHandler := handler.NewWebSocketHandler(agentService, metricsService, cfg, sugar)
-	wsServer := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.Server.WSPort),
-		Handler: wsHandler,
-	}
-
-	go func() {
-		sugar.Infof("WebSocket server starting on port %d", cfg.Server.WSPort)
-		if err := wsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			sugar.Fatalf("WebSocket server error: %v", err)
+	var wsServer *http.Server
+	if !cfg.Server.SinglePort {
+		wsServer = &http.Server{
+			Addr:    fmt.Sprintf(":%d", cfg.Server.WSPort),
+			Handler: wsHandler,
 		}
-	}()
+
+		go func() {
+			sugar.Infof("WebSocket server starting on port %d", cfg.Server.WSPort)
+			if err := wsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				sugar.Fatalf("WebSocket server error: %v", err)
+			}
+		}()
+		lifecycle.Register("WebSocket server", wsServer)
+	}
 
 	// Start gRPC server with auth interceptor
 	grpcAuthInterceptor := grpcserver.NewAuthInterceptor(authService, permService, sugar)
 	grpcServer := grpcserver.NewServerWithAuth(cfg, agentService, metricsService, grpcAuthInterceptor, sugar)
+	grpcServer.SetServerEvents(serverEvents)
+	grpcServer.SetCommandTemplates(commandTemplates)
+	grpcServer.SetAgentTokens(agentTokens)
+	configGen.SetTokenRevoker(grpcServer)
+	if webhookNotifier != nil {
+		grpcServer.SetWebhookNotifier(webhookNotifier)
+	}
+	if err := grpcServer.SetUnknownAgentPolicy(cfg.Security.UnknownAgentMetrics); err != nil {
+		sugar.Fatalf("Invalid unknown agent metrics policy: %v", err)
+	}
+	if err := grpcServer.SetConnectionLimit(cfg.Security.MaxConnectionsPerIP, cfg.Security.ConnectionLimitExempt); err != nil {
+		sugar.Fatalf("Invalid connection limit: %v", err)
+	}
+	if cfg.Metrics.DeltaRealtime.Enabled {
+		grpcServer.SetRealtimeModeAdvisor(service.NewRealtimeModeAdvisor(sugar, cfg.Metrics.DeltaRealtime))
+	}
 	go func() {
 		sugar.Infof("gRPC server starting on port %d", cfg.Server.GRPCPort)
 		if err := grpcServer.Start(cfg.Server.GRPCPort, cfg.Server.TLSCert, cfg.Server.TLSKey); err != nil {
 			sugar.Fatalf("gRPC server error: %v", err)
 		}
 	}()
+	lifecycle.Register("gRPC server", grpcServer)
 
 	// Register shell WebSocket handler (after gRPC server is available)
 	shellHandler := handler.NewShellHandler(sugar, authService, grpcServer)
+	shellHandler.SetAgentService(agentService)
 	router.GET("/ws/shell/:id", shellHandler.HandleShellWS)
 
+	// Run allowlisted shell commands with output streamed as Server-Sent
+	// Events: Level 3 (SYSTEM_ADMIN, the super token level) required
+	shellStreamHandler := handler.NewShellStreamHandler(grpcServer, sugar)
+	shellApi := router.Group("/api")
+	shellApi.Use(handler.AuthMiddleware(authService))
+	{
+		shellApi.POST("/agents/:id/shell", resolveAgentID,
+			handler.RequireAgentPermission(permService, database.PermissionSystemAdmin),
+			shellStreamHandler.RunShellCommand)
+	}
+
 	// Register data request API (after gRPC server is available)
 	dataRequestHandler := handler.NewDataRequestHandler(grpcServer, sugar)
 	dataRequestApi := router.Group("/api")
 	dataRequestApi.Use(handler.AuthMiddleware(authService))
 	{
 		// Data request endpoints - request specific data from agents on demand
-		dataRequestApi.POST("/agents/:id/data-request",
+		dataRequestApi.POST("/agents/:id/data-request", resolveAgentID,
 			handler.RequireAgentPermission(permService, database.PermissionReadOnly),
 			dataRequestHandler.RequestData)
 		dataRequestApi.POST("/agents/data-request",
@@ -277,26 +607,41 @@ func main() {
 			dataRequestHandler.RequestDataFromAll)
 	}
 
+	// Agent software versions across the fleet, for targeting upgrades
+	agentVersionHandler := handler.NewAgentVersionHandler(grpcServer, permService, sugar)
+	versionApi := router.Group("/api")
+	versionApi.Use(handler.AuthMiddleware(authService))
+	{
+		versionApi.GET("/agents/versions", agentVersionHandler.GetAgentVersions)
+	}
+
 	// Register log query API (after gRPC server is available)
-	logQueryHandler := handler.NewLogQueryHandler(grpcServer, auditService, sugar)
+	logQueryHandler := handler.NewLogQueryHandler(grpcServer, sugar)
 	logQueryApi := router.Group("/api")
 	logQueryApi.Use(handler.AuthMiddleware(authService))
 	{
 		// Log query endpoints - query logs from agents
 		// SERVICE_LOGS: Level 0+ (all users can query, output sanitized)
-		logQueryApi.POST("/agents/:id/logs/service",
+		logQueryApi.POST("/agents/:id/logs/service", resolveAgentID,
 			handler.RequireAgentPermission(permService, database.PermissionReadOnly),
 			logQueryHandler.QueryServiceLogs)
 		// SYSTEM_LOGS: Level 1+ (BASIC_WRITE required)
-		logQueryApi.POST("/agents/:id/logs/system",
+		logQueryApi.POST("/agents/:id/logs/system", resolveAgentID,
 			handler.RequireAgentPermission(permService, database.PermissionBasicWrite),
 			logQueryHandler.QuerySystemLogs)
 		// AUDIT_LOGS: Level 2+ (SERVICE_CONTROL required)
-		logQueryApi.POST("/agents/:id/logs/audit",
+		logQueryApi.POST("/agents/:id/logs/audit", resolveAgentID,
 			handler.RequireAgentPermission(permService, database.PermissionServiceControl),
 			logQueryHandler.QueryAuditLogs)
+		// Tail a log file as Server-Sent Events: Level 2+ (SERVICE_CONTROL required)
+		logQueryApi.GET("/agents/:id/logs/stream", resolveAgentID,
+			handler.RequireAgentPermission(permService, database.PermissionServiceControl),
+			handler.NewLogStreamHandler(grpcServer, sugar).StreamLogs)
 	}
 
+	grpcServer.SetCommandTracker(commandTracker)
+	grpcServer.SetAuditService(auditService)
+
 	// Connect gRPC command results to shell WebSocket sessions
 	grpcServer.SetCommandResultHandler(func(agentID, commandID, output string, success bool) {
 		shellHandler.SendOutputToSession(agentID, commandID, output)
@@ -305,12 +650,133 @@ func main() {
 	// Register dashboard WebSocket handler for real-time metrics push
 	dashboardWSHandler := handler.NewDashboardWSHandler(sugar, authService, agentService, metricsService)
 	router.GET("/ws/dashboard", dashboardWSHandler.HandleDashboardWS)
+	// Same broadcasts over SSE, for proxies that break WebSocket upgrades
+	router.GET("/api/stream", dashboardWSHandler.HandleStream)
+	dashboardWSHandler.SetServerEvents(serverEvents)
+	dashboardWSHandler.SetPermissionService(permService)
+	dashboardWSHandler.SetLogStreams(grpcServer, permService)
+	// GraphQL subscriptions authenticate like the dashboard WebSocket
+	graphQLHandler.SetDashboard(dashboardWSHandler)
+	router.GET("/api/graphql", graphQLHandler.HandleSubscriptions)
+
+	// Push the fleet summary on a steady cadence, decoupled from per-agent metrics
+	dashboardWSHandler.StartSummaryTicker(time.Duration(cfg.Metrics.SummaryIntervalSec) * time.Second)
+	lifecycle.Register("dashboard summary ticker", service.ShutdownFunc(func(context.Context) error {
+		dashboardWSHandler.StopSummaryTicker()
+		return nil
+	}))
+
+	// Push agent metrics and server counters to an OpenTelemetry collector
+	if cfg.Metrics.OTel.Enabled {
+		otelExporter, err := service.NewOTelExporter(cfg.Metrics.OTel, agentService, metricsService, sugar)
+		if err != nil {
+			sugar.Fatalf("Failed to create OTLP exporter: %v", err)
+		}
+		otelExporter.SetCommandsDispatched(grpcServer.CommandsDispatched)
+		otelExporter.SetBroadcastDrops(dashboardWSHandler.BroadcastDrops)
+		otelExporter.Start()
+		lifecycle.Register("OTLP exporter", otelExporter)
+		sugar.Infof("OTLP metrics export enabled: %s every %ds", cfg.Metrics.OTel.Endpoint, cfg.Metrics.OTel.IntervalSec)
+	}
+
+	// Report agents losing contact before they drop, as heartbeats age
+	agentService.SetHealthThresholds(time.Duration(cfg.Server.HeartbeatStaleSec)*time.Second,
+		time.Duration(cfg.Server.HeartbeatDeadSec)*time.Second)
+	agentService.StartHealthChecks(5*time.Second, dashboardWSHandler.BroadcastAgentStatus)
+	lifecycle.Register("agent health checks", service.ShutdownFunc(func(context.Context) error {
+		agentService.StopHealthChecks()
+		return nil
+	}))
 
 	// Set broadcast callback in metrics service for real-time push
 	metricsService.SetBroadcastCallback(func(agentID string, metrics interface{}) {
 		dashboardWSHandler.BroadcastMetrics(agentID, metrics)
 	})
 
+	// Push source IP change alerts to dashboard clients
+	ipTracker.SetChangeHandler(func(event service.IPChangeEvent) {
+		eventStore.Record(service.AlertRecord{
+			Kind:     service.EventKindIPChange,
+			AgentID:  event.AgentID,
+			Severity: "warning",
+			Message:  fmt.Sprintf("source IP changed from %s to %s", event.PreviousIP, event.CurrentIP),
+			Data:     event,
+			FiredAt:  event.Timestamp,
+		})
+		dashboardWSHandler.BroadcastSecurityAlert(event.AgentID, event)
+	})
+
+	// Host metric threshold alerts, pushed as they fire and resolve
+	if len(cfg.Alerts.Rules) > 0 {
+		alertEngine, err := service.NewAlertEngine(sugar, service.MetricAlertRulesFromConfig(cfg.Alerts.Rules))
+		if err != nil {
+			sugar.Fatalf("Invalid metric alert rules: %v", err)
+		}
+		alertEngine.SetAlertHandler(func(event service.AlertEvent) {
+			dashboardWSHandler.BroadcastAlert(event.AgentID, event)
+			if webhookNotifier != nil {
+				webhookNotifier.Alert(event.AgentID, event)
+			}
+		})
+		alertEngine.SetStore(alertStore)
+		alertEngine.SetRenotifyInterval(time.Duration(cfg.Alerts.RenotifySec) * time.Second)
+		metricsService.SetAlertEngine(alertEngine)
+	}
+
+	// Per-device GPU/NPU threshold alerts
+	if len(cfg.Alerts.Accelerators) > 0 {
+		acceleratorAlerter, err := service.NewAcceleratorAlerter(sugar, cfg.Alerts.Accelerators)
+		if err != nil {
+			sugar.Fatalf("Invalid accelerator alert rules: %v", err)
+		}
+		acceleratorAlerter.SetAlertHandler(func(alert service.AcceleratorAlert) {
+			dashboardWSHandler.BroadcastAlert(alert.AgentID, alert)
+			if webhookNotifier != nil {
+				webhookNotifier.Alert(alert.AgentID, alert)
+			}
+		})
+		acceleratorAlerter.SetStore(alertStore)
+		metricsService.SetAcceleratorAlerter(acceleratorAlerter)
+	}
+
+	// Push clock skew alerts to dashboard clients
+	heartbeatMonitor.SetAlertHandler(func(alert service.ClockSkewAlert) {
+		if alert.Firing {
+			dashboardWSHandler.BroadcastAlert(alert.AgentID, alert)
+			if webhookNotifier != nil {
+				webhookNotifier.Alert(alert.AgentID, alert)
+			}
+		}
+	})
+
+	// Raise a server event when many agents drop at once
+	var disconnectBursts *service.DisconnectBurstDetector
+	massDisconnectWindow := time.Duration(cfg.Alerts.MassDisconnect.WindowSec) * time.Second
+	if cfg.Alerts.MassDisconnect.Threshold > 0 {
+		disconnectBursts = service.NewDisconnectBurstDetector(cfg.Alerts.MassDisconnect.Threshold, massDisconnectWindow)
+	}
+
+	// Record an event when an agent drops without a graceful disconnect
+	agentService.SetDisconnectHandler(func(event service.DisconnectEvent) {
+		if disconnectBursts != nil {
+			if agents, burst := disconnectBursts.Observe(event.AgentID); burst {
+				serverEvents.PublishMassDisconnect(agents, massDisconnectWindow)
+			}
+		}
+		eventStore.Record(service.AlertRecord{
+			Kind:     service.EventKindDisconnect,
+			AgentID:  event.AgentID,
+			Severity: "warning",
+			Message:  fmt.Sprintf("agent %s disconnected unexpectedly", event.Hostname),
+			Data:     event,
+			FiredAt:  event.Timestamp,
+		})
+	})
+	// Dashboards drop every agent that goes offline, including graceful shutdowns
+	agentService.SetOfflineHandler(func(event service.DisconnectEvent) {
+		dashboardWSHandler.BroadcastAgentOffline(event.AgentID)
+	})
+
 	// Start MCP server if enabled
 	var mcpServer *mcp.Server
 	if cfg.MCP.Enabled {
@@ -331,19 +797,35 @@ func main() {
 			mcp.WithTransport(transport),
 			mcp.WithAuditService(auditService),
 			mcp.WithGRPCServer(grpcServer),
+			mcp.WithHardwareBaselines(hardwareBaselines),
+			mcp.WithToolConcurrency(cfg.MCP.MaxConcurrentTools, cfg.MCP.ToolOverflow),
+			mcp.WithToolTimeout(time.Duration(cfg.MCP.ToolTimeoutSec)*time.Second),
+			mcp.WithToolFilter(cfg.MCP.Tools.Allow, cfg.MCP.Tools.Deny),
+			mcp.WithResourceFilter(cfg.MCP.Resources.Allow, cfg.MCP.Resources.Deny),
+			mcp.WithPromptFilter(cfg.MCP.Prompts.Allow, cfg.MCP.Prompts.Deny),
 		)
 		go func() {
 			if err := mcpServer.Serve(context.Background()); err != nil {
 				sugar.Errorf("MCP server error: %v", err)
 			}
 		}()
+		lifecycle.Register("MCP server", service.ShutdownFunc(func(context.Context) error {
+			mcpServer.Stop()
+			return nil
+		}))
 	}
 
 	sugar.Infof("NanoLink Server started successfully")
+	serverEvents.Publish(service.ServerEventStarted, "info", "server started",
+		map[string]string{"version": handler.ServerVersion, "instance": instanceID})
 	sugar.Infof("  Dashboard: http://localhost:%d/dashboard", cfg.Server.HTTPPort)
 	sugar.Infof("  API: http://localhost:%d/api", cfg.Server.HTTPPort)
 	sugar.Infof("  Dashboard WS: ws://localhost:%d/ws/dashboard", cfg.Server.HTTPPort)
-	sugar.Infof("  Agent WS: ws://localhost:%d", cfg.Server.WSPort)
+	if cfg.Server.SinglePort {
+		sugar.Infof("  Agent WS: ws://localhost:%d%s", cfg.Server.HTTPPort, handler.AgentWebSocketPath)
+	} else {
+		sugar.Infof("  Agent WS: ws://localhost:%d", cfg.Server.WSPort)
+	}
 	sugar.Infof("  gRPC: grpc://localhost:%d", cfg.Server.GRPCPort)
 	if cfg.MCP.Enabled {
 		if cfg.MCP.Transport == "sse" {
@@ -359,27 +841,19 @@ func main() {
 	<-quit
 
 	sugar.Info("Shutting down server...")
+	serverEvents.Publish(service.ServerEventStopping, "info", "server shutting down", nil)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	if err := httpServer.Shutdown(ctx); err != nil {
-		sugar.Errorf("HTTP server shutdown error: %v", err)
-	}
-
-	if err := wsServer.Shutdown(ctx); err != nil {
-		sugar.Errorf("WebSocket server shutdown error: %v", err)
+	shutdownTimeout := time.Duration(cfg.Server.ShutdownTimeoutSec) * time.Second
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = 10 * time.Second
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	// Stop MCP server if enabled
-	if mcpServer != nil {
-		mcpServer.Stop()
-		sugar.Info("MCP server stopped")
+	if err := lifecycle.Shutdown(ctx); err != nil {
+		sugar.Errorf("Shutdown incomplete: %v", err)
 	}
 
-	grpcServer.Stop()
-	sugar.Info("gRPC server stopped")
-
 	sugar.Info("Server stopped")
 }
 
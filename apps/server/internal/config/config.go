@@ -2,42 +2,95 @@ package config
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 )
 
 // Config holds all configuration
 type Config struct {
-	Server     ServerConfig     `mapstructure:"server"`
-	Auth       AuthConfig       `mapstructure:"auth"`
-	Storage    StorageConfig    `mapstructure:"storage"`
-	Metrics    MetricsConfig    `mapstructure:"metrics"`
-	Database   DatabaseConfig   `mapstructure:"database"`
-	TimeSeries TimeSeriesConfig `mapstructure:"timeseries"`
-	JWT        JWTConfig        `mapstructure:"jwt"`
-	SuperAdmin SuperAdminConfig `mapstructure:"superadmin"`
-	MCP        MCPConfig        `mapstructure:"mcp"`
+	Server     ServerConfig         `mapstructure:"server"`
+	Auth       AuthConfig           `mapstructure:"auth"`
+	Storage    StorageConfig        `mapstructure:"storage"`
+	Metrics    MetricsConfig        `mapstructure:"metrics"`
+	Database   DatabaseConfig       `mapstructure:"database"`
+	TimeSeries TimeSeriesConfig     `mapstructure:"timeseries"`
+	JWT        JWTConfig            `mapstructure:"jwt"`
+	SuperAdmin SuperAdminConfig     `mapstructure:"superadmin"`
+	Passwords  PasswordPolicyConfig `mapstructure:"password_policy"`
+	OIDC       OIDCConfig           `mapstructure:"oidc"`
+	MCP        MCPConfig            `mapstructure:"mcp"`
+	Security   SecurityConfig       `mapstructure:"security"`
+	Alerts     AlertsConfig         `mapstructure:"alerts"`
+	Commands   CommandsConfig       `mapstructure:"commands"`
+	Webhooks   WebhooksConfig       `mapstructure:"webhooks"`
+	Groups     GroupsConfig         `mapstructure:"groups"`
+
+	// Guards Auth.Tokens, which changes at runtime as tokens are issued and
+	// revoked
+	tokensMu sync.RWMutex
 }
 
 // ServerConfig holds server configuration
 type ServerConfig struct {
 	HTTPPort       int      `mapstructure:"http_port"`
 	WSPort         int      `mapstructure:"ws_port"`
+	SinglePort     bool     `mapstructure:"single_port"` // Serve the agent WebSocket on the HTTP port at /ws instead of ws_port (default false)
 	GRPCPort       int      `mapstructure:"grpc_port"`
 	Mode           string   `mapstructure:"mode"`
 	TLSCert        string   `mapstructure:"tls_cert"`
 	TLSKey         string   `mapstructure:"tls_key"`
 	AllowedOrigins []string `mapstructure:"allowed_origins"` // CORS whitelist for WebSocket connections
+	InstanceID     string   `mapstructure:"instance_id"`     // Identifies this server in multi-instance deployments (default: generated from the hostname once and kept in the data directory)
+
+	ClientCAFile      string `mapstructure:"client_ca_file"`      // CA that signs agent client certificates for gRPC; agents presenting one may only claim its hostname
+	RequireClientCert bool   `mapstructure:"require_client_cert"` // Refuse gRPC agents without a certificate signed by client_ca_file (default false)
+	ClientCRLFile     string `mapstructure:"client_crl_file"`     // CRL signed by client_ca_file listing revoked agent certificates, re-read when it changes
+
+	GRPCCompressionLevel int `mapstructure:"grpc_compression_level"` // gzip level (1-9) for agents that negotiate compression (default 0, gzip's default)
+
+	AgentLabels map[string]map[string]string `mapstructure:"agent_labels"` // Agent ID -> labels assigned by the server, overriding those the agent sends
+
+	AgentSessionTTLSec         int `mapstructure:"agent_session_ttl_sec"`          // How long a disconnected agent can resume its session (default 600)
+	AgentSessionMaxLifetimeSec int `mapstructure:"agent_session_max_lifetime_sec"` // Seconds after authentication a session can no longer be resumed (default 86400)
+	ShutdownTimeoutSec         int `mapstructure:"shutdown_timeout_sec"`           // Time shared by all services to drain on shutdown (default 10)
+
+	// Heartbeat ages at which a connected agent is reported as losing
+	// contact and then as dead
+	HeartbeatStaleSec int `mapstructure:"heartbeat_stale_sec"` // Stale after this long without a heartbeat (default 60)
+	HeartbeatDeadSec  int `mapstructure:"heartbeat_dead_sec"`  // Dead after this long without a heartbeat (default 90)
+
+	// Agent log files tailed from the dashboard
+	LogStreamMaxBytesPerSec int `mapstructure:"log_stream_max_bytes_per_sec"` // Throughput of each stream; lines beyond it are dropped (default 65536)
+	LogStreamsPerAgent      int `mapstructure:"log_streams_per_agent"`        // Concurrent streams from one agent (default 4)
 }
 
 // AuthConfig holds authentication configuration
 type AuthConfig struct {
 	Enabled bool          `mapstructure:"enabled"`
 	Tokens  []TokenConfig `mapstructure:"tokens"`
+
+	GenerateToken bool `mapstructure:"generate_token"` // With auth enabled and no tokens configured or stored, generate one on first start (default true)
+
+	MinAgentVersion string `mapstructure:"min_agent_version"` // Refuse agents older than this, e.g. "0.3.0" (default "" accepts any)
+	// Agents older than this are still accepted but reported as outdated,
+	// e.g. "0.4.0" during a gradual upgrade (default "" flags none)
+	SupportedAgentVersion string `mapstructure:"supported_agent_version"`
+	// Invalid tokens are a permanent failure unless set, e.g. while tokens are
+	// being rotated; agents are then told to retry after RetryAfterSec
+	RetryInvalidToken bool `mapstructure:"retry_invalid_token"`
+	RetryAfterSec     int  `mapstructure:"retry_after_sec"` // Retry delay advised for invalid tokens (default 300)
 }
 
 // TokenConfig holds token configuration
@@ -45,6 +98,76 @@ type TokenConfig struct {
 	Token      string `mapstructure:"token"`
 	Permission int    `mapstructure:"permission"`
 	Name       string `mapstructure:"name"`
+
+	ExpiresAt *time.Time        `mapstructure:"expires_at"` // RFC 3339; the token is refused from then on (default never)
+	Hostnames []string          `mapstructure:"hostnames"`  // Agent hostnames allowed to use the token, "*" globs allowed (default any)
+	Labels    map[string]string `mapstructure:"labels"`     // Labels an agent must report to use the token (default none)
+	// Hostnames and labels are those the agent reports, so they only keep
+	// honest agents apart: a leaked token can still be used by claiming an
+	// allowed hostname, unless agents authenticate with client certificates,
+	// whose names must match the hostname they report
+
+	// Tokens issued by the server are stored hashed: Hash is the token's
+	// HashToken and Token is empty. ID is the stored token's ID.
+	ID   uint   `mapstructure:"-"`
+	Hash string `mapstructure:"-"`
+}
+
+// Token validation errors returned by ValidateAgentToken
+var (
+	ErrInvalidToken = errors.New("invalid authentication token")
+	ErrTokenExpired = errors.New("authentication token has expired")
+	ErrTokenScope   = errors.New("authentication token is not valid for this agent")
+)
+
+// TokenScope describes the agent presenting a token, checked against the
+// token's hostname and label restrictions
+type TokenScope struct {
+	Hostname string
+	Labels   map[string]string
+}
+
+// HashToken returns the hash a token issued by the server is stored under
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// matches reports whether token is this token, in constant time
+func (t TokenConfig) matches(token, hash string) bool {
+	if t.Hash != "" {
+		return subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) == 1
+	}
+	return subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1
+}
+
+// Expired reports whether the token has expired at now
+func (t TokenConfig) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// CheckScope returns ErrTokenScope when the agent is outside the token's
+// hostname or label restrictions
+func (t TokenConfig) CheckScope(scope TokenScope) error {
+	if len(t.Hostnames) > 0 {
+		hostname := strings.ToLower(scope.Hostname)
+		allowed := false
+		for _, pattern := range t.Hostnames {
+			if ok, _ := path.Match(strings.ToLower(pattern), hostname); ok && hostname != "" {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w: hostname %q is not allowed", ErrTokenScope, scope.Hostname)
+		}
+	}
+	for key, value := range t.Labels {
+		if got, ok := scope.Labels[key]; !ok || got != value {
+			return fmt.Errorf("%w: label %s=%s is required", ErrTokenScope, key, value)
+		}
+	}
+	return nil
 }
 
 // StorageConfig holds storage configuration
@@ -60,12 +183,116 @@ type StorageConfig struct {
 
 // MetricsConfig holds metrics configuration
 type MetricsConfig struct {
-	RetentionDays       int  `mapstructure:"retention_days"`        // Raw data retention (default 7 days)
-	HourlyRetentionDays int  `mapstructure:"hourly_retention_days"` // Hourly data retention (default 30 days)
-	DailyRetentionDays  int  `mapstructure:"daily_retention_days"`  // Daily data retention (default 365 days)
-	MaxAgents           int  `mapstructure:"max_agents"`
-	PersistToDB         bool `mapstructure:"persist_to_db"`      // Enable DB persistence (default true)
-	MaxMemoryHistory    int  `mapstructure:"max_memory_history"` // Max entries in memory per agent (default 600)
+	RetentionDays       int    `mapstructure:"retention_days"`        // Raw data retention (default 7 days)
+	HourlyRetentionDays int    `mapstructure:"hourly_retention_days"` // Hourly data retention (default 30 days)
+	DailyRetentionDays  int    `mapstructure:"daily_retention_days"`  // Daily data retention (default 365 days)
+	MaxAgents           int    `mapstructure:"max_agents"`
+	PersistToDB         bool   `mapstructure:"persist_to_db"`        // Enable DB persistence (default true)
+	PersistIntervalSec  int    `mapstructure:"persist_interval_sec"` // Persist only the latest sample of each interval, independent of live broadcasts (default 0 = every sample)
+	Backend             string `mapstructure:"backend"`              // Persistence backend: sql or none (default sql)
+	MaxMemoryHistory    int    `mapstructure:"max_memory_history"`   // Max entries in memory per agent (default 600)
+	HistoryStore        string `mapstructure:"history_store"`        // Where history queries are served from: "memory" or "database" (default memory)
+
+	// Raw samples older than this are collapsed into one average per bucket
+	// while still within retention, trading resolution for space. Per-device
+	// breakdowns are averaged too; samples arriving after their period was
+	// compacted stay raw.
+	CompactAfterHours int `mapstructure:"compact_after_hours"` // (default 0, disabled)
+	CompactBucketSec  int `mapstructure:"compact_bucket_sec"`  // Width of a compacted sample (default 60)
+
+	PerDeviceBreakdown bool `mapstructure:"per_device_breakdown"` // Also persist per-disk and per-interface throughput with each sample (default false)
+
+	// On PostgreSQL, keep raw samples in one table natively partitioned by
+	// month instead of separate monthly tables; ignored on other databases
+	NativePartitions bool `mapstructure:"native_partitions"` // (default false)
+
+	AgentWeights map[string]float64 `mapstructure:"agent_weights"` // Agent ID -> importance weight for weighted summary (default 1)
+
+	SummaryIntervalSec int `mapstructure:"summary_interval_sec"` // Fleet summary push interval to dashboards (default 5, 0 disables)
+	StaleAfterSec      int `mapstructure:"stale_after_sec"`      // Flag metrics older than this as stale (default 30)
+
+	DeltaRealtime DeltaRealtimeConfig `mapstructure:"delta_realtime"`
+
+	AllowAcks bool `mapstructure:"allow_acks"` // Grant per-message metrics acks to agents that request them (default true)
+
+	Shards int `mapstructure:"shards"` // Lock stripes for per-agent metrics; 1 serializes all agents (default 64)
+
+	PartialMetrics string `mapstructure:"partial_metrics"` // Before an agent's first full snapshot: "hold" broadcasts, or "mark" them incomplete (default hold)
+
+	Filter       MetricFilterConfig            `mapstructure:"filter"`        // Interfaces, mount points and disks dropped at ingestion (default: keep all)
+	AgentFilters map[string]MetricFilterConfig `mapstructure:"agent_filters"` // Agent ID -> extra filter applied on top of the global one
+
+	MaxUserSessions int `mapstructure:"max_user_sessions"` // Logged-in user sessions kept per agent, most recently active first (default 100, 0 = no limit)
+
+	// Per-agent stream ingestion limits; excess messages are dropped
+	MaxMetricsPerSec  float64 `mapstructure:"max_metrics_per_sec"`  // Full metrics messages per second (default 10, 0 = no limit)
+	MaxRealtimePerSec float64 `mapstructure:"max_realtime_per_sec"` // Realtime messages per second (default 50, 0 = no limit)
+
+	// Recently received metrics kept per agent for SyncMetrics after a reconnect
+	SyncBufferSize         int `mapstructure:"sync_buffer_size"`          // Metrics messages kept (default 120, 0 disables)
+	SyncBufferRetentionSec int `mapstructure:"sync_buffer_retention_sec"` // Age after which they are dropped (default 600, 0 = no limit)
+
+	Prometheus PrometheusConfig `mapstructure:"prometheus"`
+	OTel       OTelConfig       `mapstructure:"otel"`
+
+	// Vendor (e.g. nvidia, amd, huawei, intel, or default for the rest) ->
+	// temperatures used in GPU/NPU health scores, replacing the built-in ones
+	AcceleratorHealth map[string]AcceleratorThresholds `mapstructure:"accelerator_health"`
+}
+
+// AcceleratorThresholds are the temperatures between which a GPU or NPU
+// health score's temperature part falls from 100 to 0
+type AcceleratorThresholds struct {
+	WarnTemp     float64 `mapstructure:"warn_temp"`     // Celsius at which throttling may start
+	CriticalTemp float64 `mapstructure:"critical_temp"` // Celsius at which the device is at risk of shutting down
+}
+
+// PrometheusConfig controls the /metrics scrape endpoint
+type PrometheusConfig struct {
+	Enabled     bool   `mapstructure:"enabled"`      // Serve /metrics (default false)
+	PerCoreCPU  bool   `mapstructure:"per_core_cpu"` // Expose a series per CPU core (default true)
+	BearerToken string `mapstructure:"bearer_token"` // Require this bearer token from scrapers (default: none)
+}
+
+// OTelConfig controls pushing agent metrics and server counters to an
+// OpenTelemetry collector over OTLP/HTTP
+type OTelConfig struct {
+	Enabled     bool              `mapstructure:"enabled"`      // Push metrics to the collector (default false)
+	Endpoint    string            `mapstructure:"endpoint"`     // Collector metrics URL (default http://localhost:4318/v1/metrics)
+	IntervalSec int               `mapstructure:"interval_sec"` // Seconds between exports (default 60)
+	Headers     map[string]string `mapstructure:"headers"`      // Extra request headers, e.g. for collector authentication
+}
+
+// MetricFilterConfig selects which network interfaces, mount points and
+// disk devices of an agent are kept
+type MetricFilterConfig struct {
+	Interfaces  FilterListConfig `mapstructure:"interfaces"`   // e.g. deny ["lo", "veth*", "docker*"]
+	MountPoints FilterListConfig `mapstructure:"mount_points"` // e.g. deny ["/snap/*", "/run/*"]; a deny also covers mounts below the match
+	Devices     FilterListConfig `mapstructure:"devices"`      // e.g. deny ["loop*"]; matches the full path or base name
+}
+
+// Empty reports whether the filter keeps everything
+func (c MetricFilterConfig) Empty() bool {
+	return c.Interfaces.Empty() && c.MountPoints.Empty() && c.Devices.Empty()
+}
+
+// FilterListConfig selects names by exact name or glob pattern. Deny takes
+// precedence over allow.
+type FilterListConfig struct {
+	Allow []string `mapstructure:"allow"` // Only these are kept (empty = all)
+	Deny  []string `mapstructure:"deny"`  // These are dropped
+}
+
+// Empty reports whether the list keeps everything
+func (c FilterListConfig) Empty() bool {
+	return len(c.Allow) == 0 && len(c.Deny) == 0
+}
+
+// DeltaRealtimeConfig controls advising agents on slow links to send delta-only realtime metrics
+type DeltaRealtimeConfig struct {
+	Enabled           bool `mapstructure:"enabled"`             // Advise capable agents to switch modes (default false)
+	SlowRTTMs         int  `mapstructure:"slow_rtt_ms"`         // Smoothed heartbeat RTT that marks a link as slow (default 500)
+	LargeMessageBytes int  `mapstructure:"large_message_bytes"` // Average full realtime message size that warrants deltas (default 8192)
 }
 
 // DatabaseConfig holds database configuration
@@ -77,6 +304,8 @@ type DatabaseConfig struct {
 	Database string `mapstructure:"database"` // PostgreSQL database name
 	Username string `mapstructure:"username"` // PostgreSQL username
 	Password string `mapstructure:"password"` // PostgreSQL password
+
+	AutoMigrate bool `mapstructure:"auto_migrate"` // Upgrade an outdated schema at startup instead of refusing to run (default true)
 }
 
 // TimeSeriesConfig holds time-series storage configuration
@@ -97,6 +326,14 @@ type TimeSeriesConfig struct {
 type JWTConfig struct {
 	Secret     string `mapstructure:"secret"`
 	ExpireHour int    `mapstructure:"expire_hour"` // Token expiration in hours
+	// Logins get a short-lived access token and a refresh token to rotate it
+	AccessExpireMin   int    `mapstructure:"access_expire_min"`   // Access token lifetime in minutes (default 15)
+	RefreshExpireHour int    `mapstructure:"refresh_expire_hour"` // Refresh token lifetime in hours (default 168)
+	Issuer            string `mapstructure:"issuer"`              // Issuer set on tokens (default "nanolink-server")
+	Audience          string `mapstructure:"audience"`            // Audience set on tokens (default none)
+	// Reject tokens whose issuer or audience differ from the above, for
+	// signing keys shared with other services
+	ValidateClaims bool `mapstructure:"validate_claims"` // (default false)
 }
 
 // SuperAdminConfig holds super admin configuration
@@ -105,11 +342,183 @@ type SuperAdminConfig struct {
 	Password string `mapstructure:"password"` // From NANOLINK_ADMIN_PASSWORD
 }
 
+// PasswordPolicyConfig holds the rules user passwords must satisfy
+type PasswordPolicyConfig struct {
+	MinLength      int      `mapstructure:"min_length"`      // Minimum length in characters (default 8)
+	MaxLength      int      `mapstructure:"max_length"`      // Maximum length in characters (default 0, no limit)
+	RequireLetter  bool     `mapstructure:"require_letter"`  // At least one letter (default true)
+	RequireNumber  bool     `mapstructure:"require_number"`  // At least one digit (default true)
+	RequireUpper   bool     `mapstructure:"require_upper"`   // At least one uppercase letter (default false)
+	RequireSpecial bool     `mapstructure:"require_special"` // At least one special character (default false)
+	Disallowed     []string `mapstructure:"disallowed"`      // Common passwords to reject, e.g. "Password123"
+}
+
+// OIDCConfig enables single sign-on through an OpenID Connect provider
+// (authorization-code flow). Users are provisioned on first login and
+// cannot log in with a password; local accounts keep working.
+type OIDCConfig struct {
+	Enabled       bool     `mapstructure:"enabled"`        // (default false)
+	IssuerURL     string   `mapstructure:"issuer_url"`     // e.g. https://example.okta.com/oauth2/default
+	ClientID      string   `mapstructure:"client_id"`      // From NANOLINK_OIDC_CLIENT_ID
+	ClientSecret  string   `mapstructure:"client_secret"`  // From NANOLINK_OIDC_CLIENT_SECRET
+	RedirectURL   string   `mapstructure:"redirect_url"`   // Must point at /api/auth/oidc/callback on this server
+	Scopes        []string `mapstructure:"scopes"`         // (default openid, profile, email)
+	UsernameClaim string   `mapstructure:"username_claim"` // Claim used as the NanoLink username (default preferred_username)
+	GroupsClaim   string   `mapstructure:"groups_claim"`   // Claim listing the user's groups (default groups)
+	// Provider group -> NanoLink group. When set, membership of the mapped
+	// NanoLink groups follows the provider on every login; otherwise users
+	// join existing NanoLink groups with the same names.
+	GroupMapping map[string]string `mapstructure:"group_mapping"`
+	PostLoginURL string            `mapstructure:"post_login_url"` // Where the browser is sent after login (default "/")
+}
+
 // MCPConfig holds MCP (Model Context Protocol) configuration
 type MCPConfig struct {
 	Enabled   bool   `mapstructure:"enabled"`   // Enable MCP server
 	Transport string `mapstructure:"transport"` // "stdio" or "sse"
 	SSEPort   int    `mapstructure:"sse_port"`  // Port for SSE transport
+
+	MaxConcurrentTools int    `mapstructure:"max_concurrent_tools"` // Tool calls run at once per session (default 4, 0 = unlimited)
+	ToolOverflow       string `mapstructure:"tool_overflow"`        // "reject" or "queue" calls beyond the limit (default "reject")
+	ToolTimeoutSec     int    `mapstructure:"tool_timeout_sec"`     // Per tool call timeout (default 30)
+
+	Tools     MCPFilterConfig `mapstructure:"tools"`     // Which tools are exposed
+	Resources MCPFilterConfig `mapstructure:"resources"` // Which resources are exposed, by URI
+	Prompts   MCPFilterConfig `mapstructure:"prompts"`   // Which prompts are exposed
+}
+
+// MCPFilterConfig selects MCP tools, resources or prompts by name or glob
+// pattern (e.g. "*audit*"). Deny takes precedence over allow.
+type MCPFilterConfig struct {
+	Allow []string `mapstructure:"allow"` // Only these are exposed (empty = all)
+	Deny  []string `mapstructure:"deny"`  // These are never exposed
+}
+
+// SecurityConfig holds agent connection security configuration
+type SecurityConfig struct {
+	TrackSourceIP   bool `mapstructure:"track_source_ip"`    // Record agent source IP on connect (default true)
+	AlertOnIPChange bool `mapstructure:"alert_on_ip_change"` // Alert when a known agent connects from a new IP (default true)
+	// Metrics from an agent that is not registered either register it, are
+	// stored without registering it, or are refused
+	UnknownAgentMetrics string `mapstructure:"unknown_agent_metrics"` // "register", "accept" or "reject" (default "register")
+	// A host normally runs one agent, so many streams from one IP are suspicious
+	MaxConnectionsPerIP   int      `mapstructure:"max_connections_per_ip"`  // Agent streams a source IP may hold at once (default 0, unlimited)
+	ConnectionLimitExempt []string `mapstructure:"connection_limit_exempt"` // IPs or CIDRs not limited, e.g. NAT gateways
+	// Users' resolved agent permissions are cached this long; permission
+	// and group changes made through the server invalidate them at once
+	PermissionCacheTTLSec int `mapstructure:"permission_cache_ttl_sec"` // 0 disables the cache (default 30)
+}
+
+// CommandsConfig holds command tracking configuration
+type CommandsConfig struct {
+	SnapshotMetrics      bool `mapstructure:"snapshot_metrics"`        // Record metrics before and after each command (default false)
+	PostSnapshotDelaySec int  `mapstructure:"post_snapshot_delay_sec"` // Wait this long after completion before the post snapshot (default 10)
+	MaxRecords           int  `mapstructure:"max_records"`             // Command records kept in memory (default 1000)
+	ResultTimeoutSec     int  `mapstructure:"result_timeout_sec"`      // How long a dashboard command waits for the agent's result (default 30)
+	MaxResultTimeoutSec  int  `mapstructure:"max_result_timeout_sec"`  // Longest wait a request may ask for (default 300)
+
+	OutputMasking OutputMaskingConfig `mapstructure:"output_masking"`
+}
+
+// OutputMaskingConfig controls how command output is stored in command
+// records and the audit log, since output can contain secrets
+type OutputMaskingConfig struct {
+	Patterns        []string `mapstructure:"patterns"`         // Extra regexes whose matches are replaced with [REDACTED]
+	DisableDefaults bool     `mapstructure:"disable_defaults"` // Skip the built-in password/token/key patterns
+	HashOnlyTypes   []string `mapstructure:"hash_only_types"`  // Command types whose output is stored only as a SHA-256 hash and length
+}
+
+// WebhooksConfig holds outbound webhook configuration
+type WebhooksConfig struct {
+	Lifecycle LifecycleWebhookConfig `mapstructure:"lifecycle"`
+	Agents    AgentWebhookConfig     `mapstructure:"agents"`
+}
+
+// LifecycleWebhookConfig posts agent lifecycle events (discovery, reconnect,
+// hardware change, de-registration) to an external inventory such as a CMDB
+type LifecycleWebhookConfig struct {
+	URL        string   `mapstructure:"url"`         // Endpoint to POST events to (empty disables)
+	Secret     string   `mapstructure:"secret"`      // Signs the body with HMAC-SHA256 when set
+	TimeoutSec int      `mapstructure:"timeout_sec"` // Per-request timeout (default 10)
+	Events     []string `mapstructure:"events"`      // Event types to send (default: all)
+}
+
+// AgentWebhookConfig posts agent connects, disconnects and alerts to
+// incident tooling such as Slack or PagerDuty
+type AgentWebhookConfig struct {
+	URLs            []string `mapstructure:"urls"`              // Endpoints to POST events to (empty disables)
+	Secret          string   `mapstructure:"secret"`            // Signs the body with HMAC-SHA256 when set
+	Events          []string `mapstructure:"events"`            // Event types to send: connect, disconnect, alert (default: all)
+	TimeoutSec      int      `mapstructure:"timeout_sec"`       // Per-request timeout (default 10)
+	MaxRetries      int      `mapstructure:"max_retries"`       // Retries after a failed delivery (default 3)
+	RetryBackoffSec int      `mapstructure:"retry_backoff_sec"` // Delay before the first retry, doubled for each retry after (default 2)
+	DeadLetterFile  string   `mapstructure:"dead_letter_file"`  // Events that failed every retry are appended here as JSON lines (default: only logged)
+}
+
+// GroupsConfig holds agent group configuration
+type GroupsConfig struct {
+	AutoAssign []AutoGroupRule `mapstructure:"auto_assign"` // Rules placing agents into groups when they connect
+}
+
+// AutoGroupRule places agents into a group when they connect. Groups that
+// do not exist yet are created.
+type AutoGroupRule struct {
+	Group      string   `mapstructure:"group"`      // Group name
+	Type       string   `mapstructure:"type"`       // "cidr" (default)
+	CIDRs      []string `mapstructure:"cidrs"`      // Agents with any reported or source IP in these ranges match
+	Permission int      `mapstructure:"permission"` // Permission level the group gets on matching agents (default 0, read only)
+}
+
+// AlertsConfig holds alert rule configuration
+type AlertsConfig struct {
+	Rules        []MetricAlertRule      `mapstructure:"rules"`        // Host metric threshold rules
+	RenotifySec  int                    `mapstructure:"renotify_sec"` // Repeat unacknowledged host metric alerts this often (default 0 = notify once)
+	Accelerators []AcceleratorAlertRule `mapstructure:"accelerators"` // Per-GPU/NPU threshold rules
+	History      AlertHistoryConfig     `mapstructure:"history"`      // In-memory alert and event retention
+
+	MassDisconnect  MassDisconnectConfig `mapstructure:"mass_disconnect"`    // Server event when many agents drop at once
+	MaxClockSkewSec int                  `mapstructure:"max_clock_skew_sec"` // Alert when an agent's clock is off by more than this (default 30, 0 disables)
+}
+
+// MassDisconnectConfig raises a server event when many agents disconnect
+// unexpectedly within a short window
+type MassDisconnectConfig struct {
+	Threshold int `mapstructure:"threshold"`  // Distinct agents that make a burst (default 10, 0 disables)
+	WindowSec int `mapstructure:"window_sec"` // Window the disconnects must fall in (default 60)
+}
+
+// AlertHistoryConfig bounds the in-memory alert and agent event buffers.
+// Firing alerts are always kept; the limits apply to resolved alerts and events.
+type AlertHistoryConfig struct {
+	MaxAlerts int `mapstructure:"max_alerts"`  // Alerts kept in memory (default 1000)
+	MaxEvents int `mapstructure:"max_events"`  // Agent events kept in memory (default 1000)
+	MaxAgeSec int `mapstructure:"max_age_sec"` // Drop entries resolved longer ago than this (default 86400, 0 = no limit)
+
+	MaxServerEvents int `mapstructure:"max_server_events"` // Server-wide events kept for replay (default 500)
+}
+
+// MetricAlertRule fires when a host metric stays past the threshold for ForSec
+type MetricAlertRule struct {
+	Name       string  `mapstructure:"name"`
+	Metric     string  `mapstructure:"metric"`   // "cpu", "memory", "disk", "gpu" or "load1"
+	Operator   string  `mapstructure:"operator"` // ">", ">=", "<" or "<=" (default ">")
+	Threshold  float64 `mapstructure:"threshold"`
+	ForSec     int     `mapstructure:"for_sec"`     // How long the breach must last before firing (default 0)
+	Severity   string  `mapstructure:"severity"`    // "info", "warning" or "critical" (default "warning")
+	MountPoint string  `mapstructure:"mount_point"` // Disk rules: this mount, or "*" for each mount (default: fullest disk)
+	GPUIndex   *int    `mapstructure:"gpu_index"`   // GPU rules: this GPU (default: average across GPUs)
+}
+
+// AcceleratorAlertRule fires for each GPU or NPU whose field crosses the threshold
+type AcceleratorAlertRule struct {
+	Name       string  `mapstructure:"name"`
+	Device     string  `mapstructure:"device"`      // "gpu" or "npu"
+	Index      *int    `mapstructure:"index"`       // Only the device with this index (default: all)
+	DeviceName string  `mapstructure:"device_name"` // Only devices whose name contains this, case-insensitive
+	Field      string  `mapstructure:"field"`       // "usage", "memory_percent", "temperature" or "power"
+	Operator   string  `mapstructure:"operator"`    // ">", ">=", "<" or "<=" (default ">")
+	Threshold  float64 `mapstructure:"threshold"`
+	Severity   string  `mapstructure:"severity"` // "info", "warning" or "critical" (default "warning")
 }
 
 // Default returns default configuration
@@ -120,10 +529,22 @@ func Default() *Config {
 			WSPort:   9100,
 			GRPCPort: 9200,
 			Mode:     "release",
+
+			AgentSessionTTLSec:         600,
+			AgentSessionMaxLifetimeSec: 86400,
+			ShutdownTimeoutSec:         10,
+			HeartbeatStaleSec:          60,
+			HeartbeatDeadSec:           90,
+
+			LogStreamMaxBytesPerSec: 65536,
+			LogStreamsPerAgent:      4,
 		},
 		Auth: AuthConfig{
 			Enabled: false,
 			Tokens:  []TokenConfig{},
+
+			GenerateToken: true,
+			RetryAfterSec: 300,
 		},
 		Storage: StorageConfig{
 			Type: "memory",
@@ -135,11 +556,39 @@ func Default() *Config {
 			DailyRetentionDays:  365,
 			MaxAgents:           100,
 			PersistToDB:         true,
+			Backend:             "sql",
 			MaxMemoryHistory:    600,
+			HistoryStore:        "memory",
+			CompactBucketSec:    60,
+			SummaryIntervalSec:  5,
+			StaleAfterSec:       30,
+			DeltaRealtime: DeltaRealtimeConfig{
+				SlowRTTMs:         500,
+				LargeMessageBytes: 8192,
+			},
+			AllowAcks:      true,
+			Shards:         64,
+			PartialMetrics: "hold",
+
+			MaxUserSessions:   100,
+			MaxMetricsPerSec:  10,
+			MaxRealtimePerSec: 50,
+
+			SyncBufferSize:         120,
+			SyncBufferRetentionSec: 600,
+
+			Prometheus: PrometheusConfig{
+				PerCoreCPU: true,
+			},
+			OTel: OTelConfig{
+				Endpoint:    "http://localhost:4318/v1/metrics",
+				IntervalSec: 60,
+			},
 		},
 		Database: DatabaseConfig{
-			Type: "sqlite",
-			Path: "./data/nanolink.db",
+			Type:        "sqlite",
+			Path:        "./data/nanolink.db",
+			AutoMigrate: true,
 		},
 		TimeSeries: TimeSeriesConfig{
 			Type:          "memory",
@@ -149,12 +598,69 @@ func Default() *Config {
 		JWT: JWTConfig{
 			Secret:     "",
 			ExpireHour: 24,
+			Issuer:     "nanolink-server",
+
+			AccessExpireMin:   15,
+			RefreshExpireHour: 168,
 		},
 		SuperAdmin: SuperAdminConfig{},
+		Passwords: PasswordPolicyConfig{
+			MinLength:     8,
+			RequireLetter: true,
+			RequireNumber: true,
+		},
+		OIDC: OIDCConfig{
+			Scopes:        []string{"openid", "profile", "email"},
+			UsernameClaim: "preferred_username",
+			GroupsClaim:   "groups",
+			PostLoginURL:  "/",
+		},
 		MCP: MCPConfig{
 			Enabled:   false,
 			Transport: "stdio",
 			SSEPort:   8081,
+
+			MaxConcurrentTools: 4,
+			ToolOverflow:       "reject",
+			ToolTimeoutSec:     30,
+		},
+		Security: SecurityConfig{
+			TrackSourceIP:   true,
+			AlertOnIPChange: true,
+
+			UnknownAgentMetrics:   "register",
+			MaxConnectionsPerIP:   0,
+			PermissionCacheTTLSec: 30,
+		},
+		Commands: CommandsConfig{
+			PostSnapshotDelaySec: 10,
+			MaxRecords:           1000,
+			ResultTimeoutSec:     30,
+			MaxResultTimeoutSec:  300,
+		},
+		Alerts: AlertsConfig{
+			History: AlertHistoryConfig{
+				MaxAlerts: 1000,
+				MaxEvents: 1000,
+				MaxAgeSec: 86400,
+
+				MaxServerEvents: 500,
+			},
+			MassDisconnect: MassDisconnectConfig{
+				Threshold: 10,
+				WindowSec: 60,
+			},
+			MaxClockSkewSec: 30,
+		},
+		Webhooks: WebhooksConfig{
+			Lifecycle: LifecycleWebhookConfig{
+				TimeoutSec: 10,
+			},
+			Agents: AgentWebhookConfig{
+				TimeoutSec:      10,
+				MaxRetries:      3,
+				RetryBackoffSec: 2,
+			},
 		},
 	}
 }
@@ -167,17 +673,98 @@ func Load(path string) (*Config, error) {
 	// Set defaults
 	viper.SetDefault("server.http_port", 8080)
 	viper.SetDefault("server.ws_port", 9100)
+	viper.SetDefault("server.single_port", false)
+	viper.SetDefault("server.shutdown_timeout_sec", 10)
 	viper.SetDefault("server.grpc_port", 9200)
 	viper.SetDefault("server.mode", "release")
+	viper.SetDefault("server.agent_session_ttl_sec", 600)
+	viper.SetDefault("server.agent_session_max_lifetime_sec", 86400)
+	viper.SetDefault("server.heartbeat_stale_sec", 60)
+	viper.SetDefault("server.heartbeat_dead_sec", 90)
+	viper.SetDefault("server.log_stream_max_bytes_per_sec", 65536)
+	viper.SetDefault("server.log_streams_per_agent", 4)
+	viper.SetDefault("server.require_client_cert", false)
+	viper.SetDefault("server.grpc_compression_level", 0)
 	viper.SetDefault("auth.enabled", false)
+	viper.SetDefault("auth.generate_token", true)
+	viper.SetDefault("auth.min_agent_version", "")
+	viper.SetDefault("auth.supported_agent_version", "")
+	viper.SetDefault("auth.retry_invalid_token", false)
+	viper.SetDefault("auth.retry_after_sec", 300)
+	viper.SetDefault("jwt.issuer", "nanolink-server")
+	viper.SetDefault("jwt.access_expire_min", 15)
+	viper.SetDefault("jwt.refresh_expire_hour", 168)
+	viper.SetDefault("jwt.validate_claims", false)
+	viper.SetDefault("oidc.enabled", false)
+	viper.SetDefault("oidc.scopes", []string{"openid", "profile", "email"})
+	viper.SetDefault("oidc.username_claim", "preferred_username")
+	viper.SetDefault("oidc.groups_claim", "groups")
+	viper.SetDefault("oidc.post_login_url", "/")
+	viper.SetDefault("password_policy.min_length", 8)
+	viper.SetDefault("password_policy.max_length", 0)
+	viper.SetDefault("password_policy.require_letter", true)
+	viper.SetDefault("password_policy.require_number", true)
+	viper.SetDefault("password_policy.require_upper", false)
+	viper.SetDefault("password_policy.require_special", false)
+	viper.SetDefault("database.auto_migrate", true)
 	viper.SetDefault("storage.type", "memory")
 	viper.SetDefault("storage.path", "./data/nanolink.db")
 	viper.SetDefault("metrics.retention_days", 7)
 	viper.SetDefault("metrics.hourly_retention_days", 30)
 	viper.SetDefault("metrics.daily_retention_days", 365)
 	viper.SetDefault("metrics.max_agents", 100)
+	viper.SetDefault("metrics.max_user_sessions", 100)
+	viper.SetDefault("metrics.max_metrics_per_sec", 10)
+	viper.SetDefault("metrics.max_realtime_per_sec", 50)
+	viper.SetDefault("metrics.sync_buffer_size", 120)
+	viper.SetDefault("metrics.sync_buffer_retention_sec", 600)
 	viper.SetDefault("metrics.persist_to_db", true)
+	viper.SetDefault("metrics.persist_interval_sec", 0)
+	viper.SetDefault("metrics.prometheus.enabled", false)
+	viper.SetDefault("metrics.prometheus.per_core_cpu", true)
+	viper.SetDefault("metrics.otel.enabled", false)
+	viper.SetDefault("metrics.otel.endpoint", "http://localhost:4318/v1/metrics")
+	viper.SetDefault("metrics.otel.interval_sec", 60)
+	viper.SetDefault("metrics.backend", "sql")
 	viper.SetDefault("metrics.max_memory_history", 600)
+	viper.SetDefault("metrics.history_store", "memory")
+	viper.SetDefault("metrics.compact_after_hours", 0)
+	viper.SetDefault("metrics.compact_bucket_sec", 60)
+	viper.SetDefault("metrics.per_device_breakdown", false)
+	viper.SetDefault("metrics.native_partitions", false)
+	viper.SetDefault("metrics.summary_interval_sec", 5)
+	viper.SetDefault("metrics.stale_after_sec", 30)
+	viper.SetDefault("metrics.delta_realtime.enabled", false)
+	viper.SetDefault("metrics.delta_realtime.slow_rtt_ms", 500)
+	viper.SetDefault("metrics.delta_realtime.large_message_bytes", 8192)
+	viper.SetDefault("metrics.allow_acks", true)
+	viper.SetDefault("metrics.shards", 64)
+	viper.SetDefault("metrics.partial_metrics", "hold")
+	viper.SetDefault("mcp.max_concurrent_tools", 4)
+	viper.SetDefault("mcp.tool_overflow", "reject")
+	viper.SetDefault("mcp.tool_timeout_sec", 30)
+	viper.SetDefault("security.track_source_ip", true)
+	viper.SetDefault("security.alert_on_ip_change", true)
+	viper.SetDefault("security.unknown_agent_metrics", "register")
+	viper.SetDefault("security.max_connections_per_ip", 0)
+	viper.SetDefault("security.permission_cache_ttl_sec", 30)
+	viper.SetDefault("commands.snapshot_metrics", false)
+	viper.SetDefault("commands.post_snapshot_delay_sec", 10)
+	viper.SetDefault("commands.max_records", 1000)
+	viper.SetDefault("commands.result_timeout_sec", 30)
+	viper.SetDefault("commands.max_result_timeout_sec", 300)
+	viper.SetDefault("webhooks.lifecycle.timeout_sec", 10)
+	viper.SetDefault("webhooks.agents.timeout_sec", 10)
+	viper.SetDefault("webhooks.agents.max_retries", 3)
+	viper.SetDefault("webhooks.agents.retry_backoff_sec", 2)
+	viper.SetDefault("alerts.history.max_alerts", 1000)
+	viper.SetDefault("alerts.history.max_events", 1000)
+	viper.SetDefault("alerts.history.max_age_sec", 86400)
+	viper.SetDefault("alerts.history.max_server_events", 500)
+	viper.SetDefault("alerts.mass_disconnect.threshold", 10)
+	viper.SetDefault("alerts.mass_disconnect.window_sec", 60)
+	viper.SetDefault("alerts.max_clock_skew_sec", 30)
+	viper.SetDefault("alerts.renotify_sec", 0)
 
 	// Environment variable support
 	viper.SetEnvPrefix("NANOLINK")
@@ -201,7 +788,12 @@ func Load(path string) (*Config, error) {
 
 	// Always unmarshal to pick up environment variables and defaults
 	var cfg Config
-	if err := viper.Unmarshal(&cfg); err != nil {
+	decodeHook := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		mapstructure.StringToTimeHookFunc(time.RFC3339),
+	))
+	if err := viper.Unmarshal(&cfg, decodeHook); err != nil {
 		return Default(), err
 	}
 
@@ -212,6 +804,12 @@ func Load(path string) (*Config, error) {
 	if password := os.Getenv("NANOLINK_ADMIN_PASSWORD"); password != "" {
 		cfg.SuperAdmin.Password = password
 	}
+	if clientID := os.Getenv("NANOLINK_OIDC_CLIENT_ID"); clientID != "" {
+		cfg.OIDC.ClientID = clientID
+	}
+	if clientSecret := os.Getenv("NANOLINK_OIDC_CLIENT_SECRET"); clientSecret != "" {
+		cfg.OIDC.ClientSecret = clientSecret
+	}
 	if jwtSecret := os.Getenv("NANOLINK_JWT_SECRET"); jwtSecret != "" {
 		cfg.JWT.Secret = jwtSecret
 	}
@@ -241,21 +839,70 @@ func (c *Config) ValidateAndSecure() {
 	}
 }
 
-// ValidateToken validates a token and returns permission level
-// Uses timing-safe comparison to prevent timing attacks
+// ValidateToken validates a token and returns permission level. Tokens
+// restricted to hostnames or labels are refused, since the caller is not
+// identified; agents authenticate with ValidateAgentToken.
 func (c *Config) ValidateToken(token string) (bool, int) {
+	t, err := c.ValidateAgentToken(token, TokenScope{})
+	if err != nil {
+		return false, 0
+	}
+	return true, t.Permission
+}
+
+// ValidateAgentToken validates a token presented by the agent described by
+// scope and returns it. Expired tokens fail with ErrTokenExpired and agents
+// outside the token's restrictions with ErrTokenScope. Uses timing-safe
+// comparison to prevent timing attacks.
+func (c *Config) ValidateAgentToken(token string, scope TokenScope) (TokenConfig, error) {
 	if !c.Auth.Enabled {
 		log.Println("[SECURITY] Auth disabled, granting full access")
-		return true, 3 // Full access when auth disabled
+		return TokenConfig{Permission: 3}, nil // Full access when auth disabled
 	}
 
-	tokenBytes := []byte(token)
+	hash := HashToken(token)
+	c.tokensMu.RLock()
+	defer c.tokensMu.RUnlock()
 	for _, t := range c.Auth.Tokens {
-		// Use constant-time comparison to prevent timing attacks
-		if subtle.ConstantTimeCompare([]byte(t.Token), tokenBytes) == 1 {
-			return true, t.Permission
+		if !t.matches(token, hash) {
+			continue
+		}
+		if t.Expired(time.Now()) {
+			return t, fmt.Errorf("%w: expired at %s", ErrTokenExpired, t.ExpiresAt.Format(time.RFC3339))
+		}
+		if err := t.CheckScope(scope); err != nil {
+			return t, err
 		}
+		return t, nil
 	}
 
-	return false, 0
+	return TokenConfig{}, ErrInvalidToken
+}
+
+// AddToken starts accepting a token
+func (c *Config) AddToken(t TokenConfig) {
+	c.tokensMu.Lock()
+	defer c.tokensMu.Unlock()
+	c.Auth.Tokens = append(c.Auth.Tokens, t)
+}
+
+// RemoveToken stops accepting the issued token with the given ID, reporting
+// whether it was accepted
+func (c *Config) RemoveToken(id uint) bool {
+	c.tokensMu.Lock()
+	defer c.tokensMu.Unlock()
+	for i, t := range c.Auth.Tokens {
+		if t.ID != 0 && t.ID == id {
+			c.Auth.Tokens = append(c.Auth.Tokens[:i:i], c.Auth.Tokens[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// TokenList returns a copy of the accepted tokens
+func (c *Config) TokenList() []TokenConfig {
+	c.tokensMu.RLock()
+	defer c.tokensMu.RUnlock()
+	return append([]TokenConfig(nil), c.Auth.Tokens...)
 }
@@ -25,6 +25,10 @@ type Config struct {
 	Database string // MySQL/PostgreSQL database name
 	Username string // MySQL/PostgreSQL username
 	Password string // MySQL/PostgreSQL password
+
+	// AutoMigrate upgrades an outdated schema at startup; when false the
+	// server refuses to start until the schema is current
+	AutoMigrate bool
 }
 
 // Initialize initializes the database connection
@@ -76,14 +80,8 @@ func Initialize(cfg Config, log *zap.SugaredLogger) error {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	// Auto migrate schema
-	if err := db.AutoMigrate(
-		&User{},
-		&Group{},
-		&AgentGroup{},
-		&UserAgentPermission{},
-		&AuditLog{},
-	); err != nil {
+	// Check the schema version and apply pending migrations
+	if err := Migrate(db, cfg.AutoMigrate, log); err != nil {
 		return fmt.Errorf("failed to migrate database: %w", err)
 	}
 
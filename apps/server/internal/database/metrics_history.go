@@ -17,10 +17,54 @@ type MetricsHistory struct {
 	MemPercent  float64   `json:"memPercent"`
 	DiskReadPS  uint64    `json:"diskReadPS"`  // bytes per second
 	DiskWritePS uint64    `json:"diskWritePS"` // bytes per second
+	DiskPercent *float64  `json:"diskPercent"` // fullest disk's usage; nil on rows from before it was recorded
 	NetRxPS     uint64    `json:"netRxPS"`     // bytes per second
 	NetTxPS     uint64    `json:"netTxPS"`     // bytes per second
 	GPUPercent  float64   `json:"gpuPercent"`
 	LoadAvg1    float64   `json:"loadAvg1"`
+
+	// Per-disk and per-interface values behind the totals above, when
+	// metrics.per_device_breakdown is enabled; aggregated samples average
+	// each device over the samples that reported it
+	Devices *DeviceMetrics `gorm:"serializer:json" json:"devices,omitempty"`
+
+	// Spread behind an aggregated sample's averages; never stored
+	Range *MetricsRange `gorm:"-" json:"range,omitempty"`
+}
+
+// MetricsRange is the lowest and highest value seen in an aggregation
+// bucket, so spikes hidden by the average can still be charted
+type MetricsRange struct {
+	CPUMin  float64 `json:"cpuMin"`
+	CPUMax  float64 `json:"cpuMax"`
+	MemMin  float64 `json:"memMin"`
+	MemMax  float64 `json:"memMax"`
+	GPUMin  float64 `json:"gpuMin"`
+	GPUMax  float64 `json:"gpuMax"`
+	Samples int     `json:"samples"`
+}
+
+// DeviceMetrics breaks a sample's disk and network totals down by device
+type DeviceMetrics struct {
+	Disks    []DiskIO `json:"disks,omitempty"`
+	Networks []NetIO  `json:"networks,omitempty"`
+}
+
+// DiskIO is one physical disk's throughput; a disk mounted more than once
+// appears once, with all its mount points
+type DiskIO struct {
+	Device       string   `json:"device"`
+	MountPoints  []string `json:"mountPoints,omitempty"`
+	ReadPS       uint64   `json:"readPS"`       // bytes per second
+	WritePS      uint64   `json:"writePS"`      // bytes per second
+	UsagePercent float64  `json:"usagePercent"` // fullest mount's usage
+}
+
+// NetIO is one network interface's throughput
+type NetIO struct {
+	Interface string `json:"interface"`
+	RxPS      uint64 `json:"rxPS"` // bytes per second
+	TxPS      uint64 `json:"txPS"` // bytes per second
 }
 
 // MetricsHourly stores aggregated hourly metrics
@@ -51,6 +95,18 @@ type MetricsDaily struct {
 	DataPoints int       `json:"dataPoints"` // Number of data points aggregated
 }
 
+// MetricsCompaction records how far an agent's raw samples in one metrics
+// table have been compacted, so each run picks up where the last stopped
+type MetricsCompaction struct {
+	MetricsTable   string    `gorm:"primaryKey;size:64" json:"metricsTable"`
+	AgentID        string    `gorm:"primaryKey;size:64" json:"agentId"`
+	CompactedUntil time.Time `json:"compactedUntil"` // Samples before this are compacted
+}
+
+func (MetricsCompaction) TableName() string {
+	return "metrics_compactions"
+}
+
 // GetMetricsTableName returns the monthly partitioned table name
 func GetMetricsTableName(t time.Time) string {
 	return fmt.Sprintf("metrics_history_%04d_%02d", t.Year(), t.Month())
@@ -80,7 +136,7 @@ func EnsureCurrentMetricsTable(db *gorm.DB) error {
 // InitMetricsTables initializes aggregation tables and ensures current month table exists
 func InitMetricsTables(db *gorm.DB) error {
 	// Auto migrate aggregation tables (single tables, not partitioned)
-	if err := db.AutoMigrate(&MetricsHourly{}, &MetricsDaily{}); err != nil {
+	if err := db.AutoMigrate(&MetricsHourly{}, &MetricsDaily{}, &MetricsCompaction{}); err != nil {
 		return fmt.Errorf("failed to migrate metrics aggregation tables: %w", err)
 	}
 
@@ -92,12 +148,8 @@ func InitMetricsTables(db *gorm.DB) error {
 	return nil
 }
 
-// CleanupOldMetricsTables removes old monthly tables beyond retention period
-func CleanupOldMetricsTables(db *gorm.DB, retentionDays int) error {
-	cutoff := time.Now().AddDate(0, 0, -retentionDays)
-	cutoffMonth := time.Date(cutoff.Year(), cutoff.Month(), 1, 0, 0, 0, 0, time.Local)
-
-	// Get list of all metrics tables
+// ListMetricsTables returns the names of all monthly metrics tables
+func ListMetricsTables(db *gorm.DB) []string {
 	var tables []string
 	switch db.Dialector.Name() {
 	case "sqlite":
@@ -105,8 +157,25 @@ func CleanupOldMetricsTables(db *gorm.DB, retentionDays int) error {
 	case "mysql":
 		db.Raw("SHOW TABLES LIKE 'metrics_history_%'").Scan(&tables)
 	case "postgres":
-		db.Raw("SELECT tablename FROM pg_tables WHERE tablename LIKE 'metrics_history_%'").Scan(&tables)
+		// Partitions of the native partitioned table are managed through it
+		db.Raw(`SELECT c.relname FROM pg_class c
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relkind = 'r' AND NOT c.relispartition AND n.nspname = current_schema()
+			AND c.relname LIKE 'metrics_history_%'`).Scan(&tables)
 	}
+	return tables
+}
+
+// CleanupOldMetricsTables removes old monthly tables beyond retention period
+func CleanupOldMetricsTables(db *gorm.DB, retentionDays int) error {
+	return dropMetricsTablesBefore(db, ListMetricsTables(db), retentionDays)
+}
+
+// dropMetricsTablesBefore drops the monthly tables among tables whose whole
+// month is beyond the retention period
+func dropMetricsTablesBefore(db *gorm.DB, tables []string, retentionDays int) error {
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	cutoffMonth := time.Date(cutoff.Year(), cutoff.Month(), 1, 0, 0, 0, 0, time.Local)
 
 	for _, table := range tables {
 		// Parse table name to get year and month
@@ -121,6 +190,9 @@ func CleanupOldMetricsTables(db *gorm.DB, retentionDays int) error {
 			if err := db.Migrator().DropTable(table); err != nil {
 				return fmt.Errorf("failed to drop old metrics table %s: %w", table, err)
 			}
+			if err := db.Where("metrics_table = ?", table).Delete(&MetricsCompaction{}).Error; err != nil {
+				return fmt.Errorf("failed to clear compaction progress of %s: %w", table, err)
+			}
 		}
 	}
 
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MetricsParentTable is the partitioned table raw metrics are written to
+// when PostgreSQL native partitioning is enabled. Its monthly partitions
+// are named like the manual monthly tables.
+const MetricsParentTable = "metrics_history"
+
+// SupportsNativePartitions reports whether db can hold the partitioned
+// metrics table
+func SupportsNativePartitions(db *gorm.DB) bool {
+	return db.Dialector.Name() == "postgres"
+}
+
+// MetricsPartitionBounds returns the month holding t, in local time like
+// the manual monthly tables
+func MetricsPartitionBounds(t time.Time) (from, to time.Time) {
+	t = t.In(time.Local)
+	from = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+	return from, from.AddDate(0, 1, 0)
+}
+
+// MetricsPartitionName returns the partition holding t
+func MetricsPartitionName(t time.Time) string {
+	return GetMetricsTableName(t.In(time.Local))
+}
+
+// InitPartitionedMetricsTables creates the aggregation tables and the
+// partitioned metrics table, attaches any existing monthly tables to it
+// and ensures the current month's partition exists. Monthly tables that
+// cannot be attached are left as they are and reported in the error, as
+// their history would otherwise silently disappear from queries.
+func InitPartitionedMetricsTables(db *gorm.DB) error {
+	if err := db.AutoMigrate(&MetricsHourly{}, &MetricsDaily{}, &MetricsCompaction{}); err != nil {
+		return fmt.Errorf("failed to migrate metrics aggregation tables: %w", err)
+	}
+
+	ddl, err := partitionedMetricsTableSQL(db)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range ddl {
+		if err := db.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("failed to create partitioned metrics table: %w", err)
+		}
+	}
+
+	attachErr := attachMetricsTables(db)
+	if err := EnsureMetricsPartition(db, time.Now()); err != nil {
+		return fmt.Errorf("failed to create current metrics partition: %w", err)
+	}
+	return attachErr
+}
+
+// EnsureMetricsPartition ensures the partition holding t exists
+func EnsureMetricsPartition(db *gorm.DB, t time.Time) error {
+	return db.Exec(metricsPartitionSQL(db, t)).Error
+}
+
+// ListMetricsPartitions returns the names of the partitioned metrics
+// table's partitions
+func ListMetricsPartitions(db *gorm.DB) []string {
+	var partitions []string
+	db.Raw(`SELECT c.relname FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = ?`, MetricsParentTable).Scan(&partitions)
+	return partitions
+}
+
+// CleanupOldMetricsPartitions drops partitions beyond retention period
+func CleanupOldMetricsPartitions(db *gorm.DB, retentionDays int) error {
+	return dropMetricsTablesBefore(db, ListMetricsPartitions(db), retentionDays)
+}
+
+// partitionedMetricsTableSQL returns the statements creating the parent
+// table, with the columns AutoMigrate would give a monthly table. The
+// primary key has to include the partition key.
+func partitionedMetricsTableSQL(db *gorm.DB) ([]string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&MetricsHistory{}); err != nil {
+		return nil, fmt.Errorf("failed to parse metrics schema: %w", err)
+	}
+
+	columns := make([]string, 0, len(stmt.Schema.DBNames)+1)
+	for _, name := range stmt.Schema.DBNames {
+		field := stmt.Schema.FieldsByDBName[name]
+		columns = append(columns, stmt.Quote(name)+" "+db.Migrator().FullDataTypeOf(field).SQL)
+	}
+	columns = append(columns, fmt.Sprintf("PRIMARY KEY (%s, %s)", stmt.Quote("id"), stmt.Quote("timestamp")))
+
+	parent := stmt.Quote(MetricsParentTable)
+	return []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s) PARTITION BY RANGE (%s)",
+			parent, strings.Join(columns, ", "), stmt.Quote("timestamp")),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s, %s)",
+			stmt.Quote("idx_metrics_history_agent_time"), parent, stmt.Quote("agent_id"), stmt.Quote("timestamp")),
+	}, nil
+}
+
+// metricsPartitionSQL returns the statement creating the partition for t
+func metricsPartitionSQL(db *gorm.DB, t time.Time) string {
+	from, to := MetricsPartitionBounds(t)
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF %s %s",
+		db.Statement.Quote(MetricsPartitionName(t)), db.Statement.Quote(MetricsParentTable), partitionBoundsSQL(from, to))
+}
+
+func partitionBoundsSQL(from, to time.Time) string {
+	return fmt.Sprintf("FOR VALUES FROM ('%s') TO ('%s')", from.Format(time.RFC3339), to.Format(time.RFC3339))
+}
+
+// attachMetricsTables makes existing monthly tables partitions of the
+// parent table, so history written before partitioning stays queryable.
+// Each table is first brought to the parent's columns and primary key,
+// and the parent's ID sequence is then moved past the IDs they hold.
+func attachMetricsTables(db *gorm.DB) error {
+	var errs []error
+	attached := false
+	for _, table := range ListMetricsTables(db) {
+		var year, month int
+		if _, err := fmt.Sscanf(table, "metrics_history_%d_%d", &year, &month); err != nil {
+			continue
+		}
+		if err := migrateMetricsTable(db, table); err != nil {
+			errs = append(errs, fmt.Errorf("failed to prepare %s for attaching: %w", table, err))
+			continue
+		}
+		from, to := MetricsPartitionBounds(time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local))
+		sql := fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION %s %s",
+			db.Statement.Quote(MetricsParentTable), db.Statement.Quote(table), partitionBoundsSQL(from, to))
+		if err := db.Exec(sql).Error; err != nil {
+			errs = append(errs, fmt.Errorf("failed to attach %s: %w", table, err))
+			continue
+		}
+		attached = true
+	}
+	if attached {
+		sql := fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', 'id'), MAX(%s)) FROM %s HAVING MAX(%s) IS NOT NULL",
+			MetricsParentTable, db.Statement.Quote("id"), db.Statement.Quote(MetricsParentTable), db.Statement.Quote("id"))
+		if err := db.Exec(sql).Error; err != nil {
+			errs = append(errs, fmt.Errorf("failed to advance the metrics ID sequence: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// migrateMetricsTable gives a monthly table created before partitioning the
+// parent's columns and its (id, timestamp) primary key, which ATTACH
+// PARTITION requires
+func migrateMetricsTable(db *gorm.DB, table string) error {
+	if err := db.Table(table).AutoMigrate(&MetricsHistory{}); err != nil {
+		return err
+	}
+
+	var key []struct {
+		Conname string
+		Attname string
+	}
+	err := db.Raw(`SELECT c.conname, a.attname FROM pg_constraint c
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = ANY(c.conkey)
+		WHERE c.conrelid = ?::regclass AND c.contype = 'p'`, db.Statement.Quote(table)).Scan(&key).Error
+	if err != nil {
+		return fmt.Errorf("failed to read primary key: %w", err)
+	}
+	for _, column := range key {
+		if column.Attname == "timestamp" {
+			return nil
+		}
+	}
+
+	sql := fmt.Sprintf("ALTER TABLE %s ", db.Statement.Quote(table))
+	if len(key) > 0 {
+		sql += fmt.Sprintf("DROP CONSTRAINT %s, ", db.Statement.Quote(key[0].Conname))
+	}
+	sql += fmt.Sprintf("ADD PRIMARY KEY (%s, %s)", db.Statement.Quote("id"), db.Statement.Quote("timestamp"))
+	return db.Exec(sql).Error
+}
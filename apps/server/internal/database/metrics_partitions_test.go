@@ -0,0 +1,103 @@
+package database
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openPostgres connects to the PostgreSQL database named by
+// NANOLINK_TEST_POSTGRES_DSN, skipping the test when it is unset. The
+// metrics tables are dropped before and after the test.
+func openPostgres(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("NANOLINK_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("NANOLINK_TEST_POSTGRES_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Failed to open PostgreSQL: %v", err)
+	}
+	drop := func() {
+		db.Exec("DROP TABLE IF EXISTS " + MetricsParentTable + " CASCADE")
+		for _, table := range ListMetricsTables(db) {
+			db.Migrator().DropTable(table)
+		}
+	}
+	drop()
+	t.Cleanup(drop)
+	return db
+}
+
+func TestMetricsPartitionBounds(t *testing.T) {
+	from, to := MetricsPartitionBounds(time.Date(2026, 12, 31, 23, 59, 0, 0, time.Local))
+	if !from.Equal(time.Date(2026, 12, 1, 0, 0, 0, 0, time.Local)) || !to.Equal(time.Date(2027, 1, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("Expected December 2026, got %v to %v", from, to)
+	}
+	if got := MetricsPartitionName(from); got != "metrics_history_2026_12" {
+		t.Errorf("Expected the monthly table name, got %s", got)
+	}
+}
+
+func TestPartitionedMetricsTables(t *testing.T) {
+	if SupportsNativePartitions(openTestDB(t)) {
+		t.Error("Expected no native partitions on SQLite")
+	}
+	db := openPostgres(t)
+	if !SupportsNativePartitions(db) {
+		t.Fatal("Expected native partitions on PostgreSQL")
+	}
+
+	// Monthly tables from before partitioning are keyed by id alone. One
+	// holding samples outside its month cannot be attached; index names
+	// are schema-wide, so it is dropped before the next one is made.
+	legacy := time.Date(2025, 1, 15, 12, 0, 0, 0, time.Local)
+	stray := MetricsPartitionName(legacy.AddDate(0, -1, 0))
+	if err := EnsureMetricsTable(db, stray); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Table(stray).Create(&MetricsHistory{AgentID: "agent-1", Timestamp: legacy}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := InitPartitionedMetricsTables(db); err == nil || !strings.Contains(err.Error(), stray) {
+		t.Errorf("Expected attaching %s to fail, got %v", stray, err)
+	}
+	if err := db.Migrator().DropTable(stray); err != nil {
+		t.Fatal(err)
+	}
+
+	legacyTable := MetricsPartitionName(legacy)
+	if err := EnsureMetricsTable(db, legacyTable); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Table(legacyTable).Create(&MetricsHistory{AgentID: "agent-1", Timestamp: legacy}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := InitPartitionedMetricsTables(db); err != nil {
+		t.Fatalf("Expected the legacy table to be attached, got %v", err)
+	}
+	partitions := ListMetricsPartitions(db)
+	for _, want := range []string{legacyTable, MetricsPartitionName(time.Now())} {
+		if !slices.Contains(partitions, want) {
+			t.Errorf("Expected partition %s, got %v", want, partitions)
+		}
+	}
+
+	// New samples in the legacy month go through the parent without
+	// reusing the legacy table's IDs
+	if err := db.Table(MetricsParentTable).Create(&MetricsHistory{AgentID: "agent-1", Timestamp: legacy}).Error; err != nil {
+		t.Fatalf("Expected to write to the attached partition, got %v", err)
+	}
+	var ids []uint64
+	db.Table(MetricsParentTable).Where("agent_id = ?", "agent-1").Order("id").Pluck("id", &ids)
+	if len(ids) != 2 || ids[0] == ids[1] {
+		t.Errorf("Expected two samples with distinct IDs, got %v", ids)
+	}
+}
@@ -0,0 +1,286 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Schema version errors returned by Migrate
+var (
+	ErrSchemaTooNew   = errors.New("database schema is newer than this server supports")
+	ErrSchemaOutdated = errors.New("database schema is outdated and auto migration is disabled")
+)
+
+// SchemaVersion records one applied migration
+type SchemaVersion struct {
+	Version     int       `gorm:"primaryKey;autoIncrement:false" json:"version"`
+	Description string    `gorm:"size:200" json:"description"`
+	AppliedAt   time.Time `json:"appliedAt"`
+}
+
+// Migration upgrades the schema by one version. Up must be idempotent:
+// databases created before schema versioning start at version 0 and replay
+// every migration over tables that may already exist.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(db *gorm.DB) error
+}
+
+// migrations are applied in order; append new ones with the next version
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create users, groups, permissions and audit log",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(
+				&User{},
+				&Group{},
+				&AgentGroup{},
+				&UserAgentPermission{},
+				&AuditLog{},
+			)
+		},
+	},
+	{
+		Version:     2,
+		Description: "create hourly and daily metrics rollups",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&MetricsHourly{}, &MetricsDaily{})
+		},
+	},
+	{
+		Version:     3,
+		Description: "add disk usage to monthly metrics tables",
+		Up: func(db *gorm.DB) error {
+			for _, table := range ListMetricsTables(db) {
+				if db.Migrator().HasColumn(table, "disk_percent") {
+					continue
+				}
+				if err := db.Table(table).Migrator().AddColumn(&MetricsHistory{}, "DiskPercent"); err != nil {
+					return fmt.Errorf("%s: %w", table, err)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     4,
+		Description: "create agent tokens",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&AgentToken{})
+		},
+	},
+	{
+		Version:     5,
+		Description: "create hardware baselines",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&HardwareBaseline{})
+		},
+	},
+	{
+		Version:     6,
+		Description: "create drift baselines",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&DriftBaseline{})
+		},
+	},
+	{
+		Version:     7,
+		Description: "create refresh and revoked tokens",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&RefreshToken{}, &RevokedToken{})
+		},
+	},
+	{
+		Version:     8,
+		Description: "add external identity provider to users",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&User{})
+		},
+	},
+	{
+		Version:     9,
+		Description: "add per-device breakdown to monthly metrics tables",
+		Up: func(db *gorm.DB) error {
+			for _, table := range ListMetricsTables(db) {
+				if db.Migrator().HasColumn(table, "devices") {
+					continue
+				}
+				if err := db.Table(table).Migrator().AddColumn(&MetricsHistory{}, "Devices"); err != nil {
+					return fmt.Errorf("%s: %w", table, err)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     10,
+		Description: "create metrics retention policies",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&RetentionPolicy{})
+		},
+	},
+	{
+		Version:     11,
+		Description: "hash agent tokens and add expiry, scope and usage",
+		Up: func(db *gorm.DB) error {
+			if err := db.AutoMigrate(&AgentToken{}); err != nil {
+				return err
+			}
+			if !db.Migrator().HasColumn(&AgentToken{}, "token") {
+				return nil
+			}
+			var plain []struct {
+				ID    uint
+				Token string
+			}
+			if err := db.Table("agent_tokens").Select("id, token").Find(&plain).Error; err != nil {
+				return err
+			}
+			for _, t := range plain {
+				sum := sha256.Sum256([]byte(t.Token))
+				hint := "****"
+				if len(t.Token) > 8 {
+					hint = t.Token[:4] + "****" + t.Token[len(t.Token)-4:]
+				}
+				update := map[string]any{"token_hash": hex.EncodeToString(sum[:]), "hint": hint}
+				if err := db.Model(&AgentToken{}).Where("id = ?", t.ID).Updates(update).Error; err != nil {
+					return err
+				}
+			}
+			if err := db.Migrator().DropColumn(&AgentToken{}, "token"); err != nil {
+				return err
+			}
+			// SQLite drops columns by rebuilding the table, losing its indexes
+			return db.AutoMigrate(&AgentToken{})
+		},
+	},
+	{
+		Version:     12,
+		Description: "add grant types to user agent permissions",
+		Up: func(db *gorm.DB) error {
+			// Existing grants are for exact agent IDs, which the column defaults to
+			return db.AutoMigrate(&UserAgentPermission{})
+		},
+	},
+	{
+		Version:     13,
+		Description: "widen user agent permission agent IDs for patterns",
+		Up: func(db *gorm.DB) error {
+			// Label selectors outgrow the 50 characters agent IDs were sized for
+			return db.Migrator().AlterColumn(&UserAgentPermission{}, "AgentID")
+		},
+	},
+	{
+		Version:     14,
+		Description: "move drift baselines into hardware baselines",
+		Up: func(db *gorm.DB) error {
+			if err := db.AutoMigrate(&HardwareBaseline{}); err != nil {
+				return err
+			}
+			if !db.Migrator().HasTable(&DriftBaseline{}) {
+				return nil
+			}
+			var drift []DriftBaseline
+			if err := db.Find(&drift).Error; err != nil {
+				return err
+			}
+			for _, d := range drift {
+				if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&HardwareBaseline{AgentID: d.AgentID}).Error; err != nil {
+					return fmt.Errorf("%s: %w", d.AgentID, err)
+				}
+				err := db.Model(&HardwareBaseline{}).Where("agent_id = ?", d.AgentID).
+					Updates(map[string]interface{}{"config_snapshot": d.Snapshot, "config_captured_at": d.CapturedAt}).Error
+				if err != nil {
+					return fmt.Errorf("%s: %w", d.AgentID, err)
+				}
+			}
+			return db.Migrator().DropTable(&DriftBaseline{})
+		},
+	},
+	{
+		Version:     15,
+		Description: "add command metrics snapshots to the audit log",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&AuditLog{})
+		},
+	},
+	{
+		Version:     16,
+		Description: "create agent inventories for the lifecycle webhook",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&AgentInventory{})
+		},
+	},
+}
+
+// LatestSchemaVersion is the schema version this server expects
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// CurrentSchemaVersion returns the highest applied migration, or 0 for a
+// database that predates schema versioning
+func CurrentSchemaVersion(db *gorm.DB) (int, error) {
+	if !db.Migrator().HasTable(&SchemaVersion{}) {
+		return 0, nil
+	}
+	var version int
+	if err := db.Model(&SchemaVersion{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Migrate brings the schema to LatestSchemaVersion. It refuses to touch a
+// schema written by a newer server, and when autoMigrate is false it only
+// checks that the schema is current.
+func Migrate(db *gorm.DB, autoMigrate bool, log *zap.SugaredLogger) error {
+	return runMigrations(db, migrations, autoMigrate, log)
+}
+
+func runMigrations(db *gorm.DB, migrations []Migration, autoMigrate bool, log *zap.SugaredLogger) error {
+	latest := migrations[len(migrations)-1].Version
+	current, err := CurrentSchemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	if current > latest {
+		return fmt.Errorf("%w: database is at version %d but this server only knows up to %d; upgrade the server or restore a database backup from this version",
+			ErrSchemaTooNew, current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+	if !autoMigrate {
+		return fmt.Errorf("%w: database is at version %d, server requires %d; set database.auto_migrate to true to upgrade it",
+			ErrSchemaOutdated, current, latest)
+	}
+
+	if err := db.AutoMigrate(&SchemaVersion{}); err != nil {
+		return fmt.Errorf("failed to create schema version table: %w", err)
+	}
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		log.Infof("Applying database migration %d: %s", m.Version, m.Description)
+		if err := m.Up(db); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+		record := SchemaVersion{Version: m.Version, Description: m.Description, AppliedAt: time.Now()}
+		if err := db.Create(&record).Error; err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+		}
+	}
+	return nil
+}
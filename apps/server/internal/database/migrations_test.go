@@ -0,0 +1,226 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("Failed to get sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	db := openTestDB(t)
+	log := zap.NewNop().Sugar()
+
+	if err := Migrate(db, true, log); err != nil {
+		t.Fatal(err)
+	}
+	version, err := CurrentSchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != LatestSchemaVersion() {
+		t.Errorf("Expected schema version %d, got %d", LatestSchemaVersion(), version)
+	}
+	for _, table := range []interface{}{&User{}, &Group{}, &AuditLog{}, &MetricsHourly{}, &MetricsDaily{}} {
+		if !db.Migrator().HasTable(table) {
+			t.Errorf("Expected table for %T to exist", table)
+		}
+	}
+
+	// Running again is a no-op
+	if err := Migrate(db, false, log); err != nil {
+		t.Fatalf("Expected a current schema to pass without migrating, got %v", err)
+	}
+	var applied int64
+	db.Model(&SchemaVersion{}).Count(&applied)
+	if int(applied) != len(migrations) {
+		t.Errorf("Expected %d recorded migrations, got %d", len(migrations), applied)
+	}
+}
+
+func TestMigrateUnversionedDatabase(t *testing.T) {
+	db := openTestDB(t)
+
+	// A database from before schema versioning: core tables exist and a
+	// monthly metrics table lacks the disk usage column
+	if err := db.AutoMigrate(&User{}, &AuditLog{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("CREATE TABLE metrics_history_2026_01 (id integer PRIMARY KEY, agent_id text, timestamp datetime, cpu_percent real)").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&User{Username: "admin", PasswordHash: "x"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db, false, zap.NewNop().Sugar()); !errors.Is(err, ErrSchemaOutdated) {
+		t.Fatalf("Expected ErrSchemaOutdated with auto migration off, got %v", err)
+	}
+
+	if err := Migrate(db, true, zap.NewNop().Sugar()); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Migrator().HasColumn("metrics_history_2026_01", "disk_percent") {
+		t.Error("Expected disk_percent to be added to the existing metrics table")
+	}
+	if !db.Migrator().HasColumn("metrics_history_2026_01", "devices") {
+		t.Error("Expected devices to be added to the existing metrics table")
+	}
+	var users int64
+	db.Model(&User{}).Count(&users)
+	if users != 1 {
+		t.Errorf("Expected existing data to survive, got %d users", users)
+	}
+}
+
+func TestMigrateRejectsNewerSchema(t *testing.T) {
+	db := openTestDB(t)
+	log := zap.NewNop().Sugar()
+	if err := Migrate(db, true, log); err != nil {
+		t.Fatal(err)
+	}
+	future := SchemaVersion{Version: LatestSchemaVersion() + 1, Description: "from a newer server", AppliedAt: time.Now()}
+	if err := db.Create(&future).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db, true, log); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Expected ErrSchemaTooNew, got %v", err)
+	}
+}
+
+func TestMigrationFailureIsRetried(t *testing.T) {
+	db := openTestDB(t)
+	log := zap.NewNop().Sugar()
+
+	failing := true
+	steps := []Migration{
+		{Version: 1, Description: "first", Up: func(db *gorm.DB) error { return db.AutoMigrate(&User{}) }},
+		{Version: 2, Description: "second", Up: func(db *gorm.DB) error {
+			if failing {
+				return errors.New("boom")
+			}
+			return db.AutoMigrate(&Group{})
+		}},
+	}
+
+	if err := runMigrations(db, steps, true, log); err == nil {
+		t.Fatal("Expected the failing migration to be reported")
+	}
+	if version, _ := CurrentSchemaVersion(db); version != 1 {
+		t.Fatalf("Expected version 1 after a failed second migration, got %d", version)
+	}
+
+	failing = false
+	if err := runMigrations(db, steps, true, log); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := CurrentSchemaVersion(db); version != 2 {
+		t.Errorf("Expected version 2 after retrying, got %d", version)
+	}
+}
+
+func TestMigrateHashesPlaintextAgentTokens(t *testing.T) {
+	db := openTestDB(t)
+
+	// Agent tokens as stored before they were hashed
+	if err := db.AutoMigrate(&legacyAgentToken{}); err != nil {
+		t.Fatal(err)
+	}
+	legacy := []legacyAgentToken{{Name: "a", Token: "abcd1234efgh5678", Permission: 2}, {Name: "b", Token: "short"}}
+	if err := db.Create(&legacy).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db, true, zap.NewNop().Sugar()); err != nil {
+		t.Fatal(err)
+	}
+	if db.Migrator().HasColumn(&AgentToken{}, "token") {
+		t.Error("Expected the plaintext token column to be dropped")
+	}
+	var tokens []AgentToken
+	db.Order("id").Find(&tokens)
+	// sha256("abcd1234efgh5678")
+	want := "3b3914d512245a2a07078354f7fb2bb1434b51dc914b4817e11ef7f3b1dcb913"
+	if len(tokens) != 2 || tokens[0].Hint != "abcd****5678" || tokens[0].Permission != 2 || tokens[1].Hint != "****" {
+		t.Fatalf("Expected both tokens to survive with hints, got %+v", tokens)
+	}
+	if tokens[0].TokenHash != want {
+		t.Errorf("Expected the token to be stored as its hash, got %q", tokens[0].TokenHash)
+	}
+	if !db.Migrator().HasIndex(&AgentToken{}, "TokenHash") {
+		t.Error("Expected token hashes to be indexed")
+	}
+}
+
+// legacyAgentToken is AgentToken before migration 11
+type legacyAgentToken struct {
+	ID         uint   `gorm:"primarykey"`
+	Name       string `gorm:"size:100"`
+	Token      string `gorm:"size:128;uniqueIndex;not null"`
+	Permission int    `gorm:"default:0"`
+	CreatedAt  time.Time
+}
+
+func (legacyAgentToken) TableName() string {
+	return "agent_tokens"
+}
+
+func TestMigrateMovesDriftBaselines(t *testing.T) {
+	db := openTestDB(t)
+	log := zap.NewNop().Sugar()
+	if err := runMigrations(db, migrations[:13], true, log); err != nil {
+		t.Fatal(err)
+	}
+
+	captured := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := db.Create(&HardwareBaseline{AgentID: "agent-1", Inventory: `{"cpuModel":"Xeon"}`, Pinned: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+	drift := []DriftBaseline{
+		{AgentID: "agent-1", Snapshot: `{"kernelVersion":"6.8.0"}`, CapturedAt: captured},
+		{AgentID: "agent-2", Snapshot: `{"osName":"Ubuntu"}`, CapturedAt: captured},
+	}
+	if err := db.Create(&drift).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db, true, log); err != nil {
+		t.Fatal(err)
+	}
+	if db.Migrator().HasTable(&DriftBaseline{}) {
+		t.Error("Expected the drift baselines table to be dropped")
+	}
+	var baselines []HardwareBaseline
+	db.Order("agent_id").Find(&baselines)
+	if len(baselines) != 2 {
+		t.Fatalf("Expected a row per agent, got %+v", baselines)
+	}
+	if b := baselines[0]; b.Inventory == "" || !b.Pinned || b.ConfigSnapshot != `{"kernelVersion":"6.8.0"}` {
+		t.Errorf("Expected the snapshot to join the hardware baseline, got %+v", b)
+	}
+	if b := baselines[1]; b.Inventory != "" || b.ConfigSnapshot != `{"osName":"Ubuntu"}` || !b.ConfigCapturedAt.Equal(captured) {
+		t.Errorf("Expected a row holding only the snapshot, got %+v", b)
+	}
+}
@@ -17,6 +17,11 @@ type User struct {
 	UpdatedAt    time.Time      `json:"updatedAt"`
 	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
 
+	// Externally-managed accounts sign in through their identity provider
+	// and cannot use password login
+	AuthProvider string `gorm:"size:50;index:idx_users_external" json:"authProvider,omitempty"` // e.g. "oidc"; empty for local accounts
+	ExternalID   string `gorm:"size:255;index:idx_users_external" json:"-"`                     // Subject at the identity provider
+
 	// Relations
 	Groups []Group `gorm:"many2many:user_groups;" json:"groups,omitempty"`
 }
@@ -41,6 +46,7 @@ type AgentGroup struct {
 	AgentID         string         `gorm:"size:50;index;not null" json:"agentId"`
 	GroupID         uint           `gorm:"index;not null" json:"groupId"`
 	PermissionLevel int            `gorm:"default:0" json:"permissionLevel"` // 0-3, max permission for this group
+	Capabilities    int            `gorm:"default:0" json:"capabilities"`    // Capability* bits, 0 = view and control
 	CreatedAt       time.Time      `json:"createdAt"`
 	UpdatedAt       time.Time      `json:"updatedAt"`
 	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
@@ -53,9 +59,11 @@ type AgentGroup struct {
 type UserAgentPermission struct {
 	ID              uint           `gorm:"primarykey" json:"id"`
 	UserID          uint           `gorm:"index;not null" json:"userId"`
-	AgentID         string         `gorm:"size:50;index;not null" json:"agentId"`
-	PermissionLevel int            `gorm:"default:0" json:"permissionLevel"` // 0=READ_ONLY, 1=BASIC_WRITE, 2=SERVICE_CONTROL, 3=SYSTEM_ADMIN
-	GrantedBy       uint           `json:"grantedBy"`                        // SuperAdmin who granted this permission
+	AgentID         string         `gorm:"size:255;index;not null" json:"agentId"`          // Agent ID, or a pattern for the other grant types
+	GrantType       string         `gorm:"size:20;not null;default:agent" json:"grantType"` // GrantType* the agent ID is matched as
+	PermissionLevel int            `gorm:"default:0" json:"permissionLevel"`                // 0=READ_ONLY, 1=BASIC_WRITE, 2=SERVICE_CONTROL, 3=SYSTEM_ADMIN
+	Capabilities    int            `gorm:"default:0" json:"capabilities"`                   // Capability* bits, 0 = view and control
+	GrantedBy       uint           `json:"grantedBy"`                                       // SuperAdmin who granted this permission
 	CreatedAt       time.Time      `json:"createdAt"`
 	UpdatedAt       time.Time      `json:"updatedAt"`
 	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
@@ -65,6 +73,16 @@ type UserAgentPermission struct {
 	Granter User `gorm:"foreignKey:GrantedBy" json:"granter,omitempty"`
 }
 
+// Grant types of a UserAgentPermission. Pattern grants also cover agents
+// that connect after they are made. Hostnames and labels are those the
+// agents report, with labels from server.agent_labels taking precedence, so
+// pattern grants are capped at the level the agent's groups allow.
+const (
+	GrantTypeAgent    = "agent"    // AgentID is an agent ID
+	GrantTypeHostname = "hostname" // AgentID is a glob such as web-*, matched against hostnames and agent IDs
+	GrantTypeLabels   = "labels"   // AgentID is a label selector such as env=prod,role=web
+)
+
 // Permission level constants
 const (
 	PermissionReadOnly       = 0 // Read monitoring data, view process list, view logs
@@ -73,6 +91,24 @@ const (
 	PermissionSystemAdmin    = 3 // Reboot server, execute shell commands (requires SuperToken)
 )
 
+// Capability bits narrow a grant independently of its permission level, e.g.
+// to hand out command rights during an incident without permanent visibility.
+// A grant with no bits set allows both, which is how grants behaved before
+// capabilities existed.
+const (
+	CapabilityView    = 1 << 0 // See the agent and read its metrics and logs
+	CapabilityControl = 1 << 1 // Run commands up to the grant's permission level
+	CapabilityAll     = CapabilityView | CapabilityControl
+)
+
+// EffectiveCapabilities resolves a grant's stored capability bits
+func EffectiveCapabilities(caps int) int {
+	if caps == 0 {
+		return CapabilityAll
+	}
+	return caps & CapabilityAll
+}
+
 // PermissionLevelName returns the human-readable name for a permission level
 func PermissionLevelName(level int) string {
 	switch level {
@@ -116,14 +152,128 @@ type AuditLog struct {
 	AgentHostname string    `gorm:"size:255" json:"agentHostname"`
 	CommandType   string    `gorm:"size:50;index" json:"commandType"`
 	CommandID     string    `gorm:"size:50;index" json:"commandId"`
-	Target        string    `gorm:"size:500" json:"target"`        // What was operated on (service name, file path, etc.)
-	Params        string    `gorm:"type:text" json:"params"`       // JSON params
+	Target        string    `gorm:"size:500" json:"target"`  // What was operated on (service name, file path, etc.)
+	Params        string    `gorm:"type:text" json:"params"` // JSON params
 	Success       bool      `gorm:"default:false" json:"success"`
 	Error         string    `gorm:"type:text" json:"error"`
+	Output        string    `gorm:"type:text" json:"output,omitempty"` // Masked command output
 	DurationMs    int64     `gorm:"default:0" json:"durationMs"`
 	IPAddress     string    `gorm:"size:50" json:"ipAddress"`
+	PreSnapshot   string    `gorm:"type:text" json:"-"` // JSON-encoded service.MetricsData before dispatch
+	PostSnapshot  string    `gorm:"type:text" json:"-"` // JSON-encoded service.MetricsData after completion
 }
 
 func (AuditLog) TableName() string {
 	return "audit_logs"
 }
+
+// AgentToken is an agent authentication token issued by the server, in
+// addition to the tokens in the config file. Only the token's hash is
+// kept; it is shown once when issued.
+type AgentToken struct {
+	ID           uint       `gorm:"primarykey" json:"id"`
+	Name         string     `gorm:"size:100" json:"name"`
+	TokenHash    string     `gorm:"size:64;index" json:"-"` // config.HashToken of the token
+	Hint         string     `gorm:"size:16" json:"hint"`    // Masked token, to tell tokens apart
+	Permission   int        `gorm:"default:0" json:"permission"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"` // Unset never expires
+	Hostnames    string     `gorm:"type:text" json:"-"`  // JSON-encoded []string of allowed hostname patterns
+	Labels       string     `gorm:"type:text" json:"-"`  // JSON-encoded map of labels agents must report
+	UseCount     int64      `gorm:"default:0" json:"useCount"`
+	LastUsedAt   *time.Time `json:"lastUsedAt,omitempty"`
+	LastUsedHost string     `gorm:"size:255" json:"lastUsedHost,omitempty"`
+	RevokedAt    *time.Time `gorm:"index" json:"revokedAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+func (AgentToken) TableName() string {
+	return "agent_tokens"
+}
+
+// HardwareBaseline is the hardware inventory an agent's current hardware is
+// compared against: the first one reported, or one pinned by an operator.
+// The configuration snapshot drift is detected against is kept with it.
+type HardwareBaseline struct {
+	AgentID    string    `gorm:"primaryKey;size:64" json:"agentId"`
+	Inventory  string    `gorm:"type:text" json:"-"` // JSON-encoded service.HardwareInventory
+	Pinned     bool      `json:"pinned"`
+	CapturedAt time.Time `json:"capturedAt"`
+
+	ConfigSnapshot   string    `gorm:"type:text" json:"-"` // JSON-encoded service.ConfigSnapshot
+	ConfigCapturedAt time.Time `json:"configCapturedAt"`
+}
+
+func (HardwareBaseline) TableName() string {
+	return "hardware_baselines"
+}
+
+// DriftBaseline is the configuration snapshot an agent's current state was
+// compared against before it moved into HardwareBaseline; only migrations
+// use it
+type DriftBaseline struct {
+	AgentID    string    `gorm:"primaryKey;size:64" json:"agentId"`
+	Snapshot   string    `gorm:"type:text" json:"-"` // JSON-encoded service.ConfigSnapshot
+	CapturedAt time.Time `json:"capturedAt"`
+}
+
+func (DriftBaseline) TableName() string {
+	return "drift_baselines"
+}
+
+// AgentInventory is the last hardware inventory an agent reported. The
+// lifecycle webhook uses it to tell newly discovered agents and hardware
+// changes apart across server restarts.
+type AgentInventory struct {
+	Identity    string    `gorm:"primaryKey;size:255" json:"identity"`
+	Inventory   string    `gorm:"type:text" json:"-"` // JSON-encoded service.HardwareInventory, empty until reported
+	FirstSeenAt time.Time `json:"firstSeenAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+func (AgentInventory) TableName() string {
+	return "agent_inventories"
+}
+
+// RefreshToken lets a user obtain a new access token without logging in
+// again. Only a hash of the token is stored.
+type RefreshToken struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"userId"`
+	TokenHash string    `gorm:"uniqueIndex;size:64;not null" json:"-"` // Hex SHA-256 of the token
+	ExpiresAt time.Time `gorm:"index" json:"expiresAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// RevokedToken denylists an access token by its ID (jti) until it expires.
+// An ID of the form "user:<id>" revokes every token issued to the user up
+// to RevokedAt.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey;size:64" json:"jti"`
+	UserID    uint      `gorm:"index" json:"userId"`
+	RevokedAt time.Time `json:"revokedAt"`
+	ExpiresAt time.Time `gorm:"index" json:"expiresAt"`
+}
+
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
+// RetentionPolicy overrides how much metrics history is kept for one agent,
+// or for every agent carrying a label. Unset fields keep the global setting.
+type RetentionPolicy struct {
+	ID                 uint      `gorm:"primarykey" json:"id"`
+	AgentID            string    `gorm:"size:64;uniqueIndex:idx_retention_target" json:"agentId,omitempty"`
+	Label              string    `gorm:"size:255;uniqueIndex:idx_retention_target" json:"label,omitempty"` // key=value
+	MaxMemoryHistory   *int      `json:"maxMemoryHistory,omitempty"`
+	RetentionDays      *int      `json:"retentionDays,omitempty"`
+	PersistIntervalSec *int      `json:"persistIntervalSec,omitempty"`
+	UpdatedAt          time.Time `json:"updatedAt"`
+}
+
+func (RetentionPolicy) TableName() string {
+	return "retention_policies"
+}
@@ -0,0 +1,87 @@
+package grpc
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+)
+
+// authFailure builds a failed AuthResponse carrying the advice agents use
+// to decide between retrying and giving up
+func authFailure(reason pb.AuthFailureReason, message string, retryable bool, retryAfter time.Duration) *pb.AuthResponse {
+	resp := &pb.AuthResponse{
+		Success:       false,
+		ErrorMessage:  message,
+		FailureReason: reason,
+		Retryable:     retryable,
+	}
+	if retryable && retryAfter > 0 {
+		resp.RetryAfterMs = uint32(retryAfter.Milliseconds())
+	}
+	return resp
+}
+
+// invalidTokenFailure is permanent unless the server is configured to let
+// agents keep retrying, e.g. while tokens are being rotated. Expired and
+// out-of-scope tokens say so; unknown tokens get no detail.
+func (s *Server) invalidTokenFailure(err error) *pb.AuthResponse {
+	message := "Invalid authentication token"
+	if errors.Is(err, config.ErrTokenExpired) || errors.Is(err, config.ErrTokenScope) {
+		message = "Token rejected: " + err.Error()
+	}
+	retryAfter := time.Duration(s.config.Auth.RetryAfterSec) * time.Second
+	return authFailure(pb.AuthFailureReason_AUTH_FAILURE_INVALID_TOKEN,
+		message, s.config.Auth.RetryInvalidToken, retryAfter)
+}
+
+// agentVersionBelow reports whether version is older than min. Versions are
+// compared by their numeric dotted components, ignoring a leading "v" and
+// any pre-release or build suffix. A version that cannot be parsed is
+// treated as older.
+func agentVersionBelow(version, min string) bool {
+	have, ok := parseAgentVersion(version)
+	if !ok {
+		return true
+	}
+	want, ok := parseAgentVersion(min)
+	if !ok {
+		return false
+	}
+	for i := 0; i < len(have) || i < len(want); i++ {
+		var h, w int
+		if i < len(have) {
+			h = have[i]
+		}
+		if i < len(want) {
+			w = want[i]
+		}
+		if h != w {
+			return h < w
+		}
+	}
+	return false
+}
+
+func parseAgentVersion(version string) ([]int, bool) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	if version == "" {
+		return nil, false
+	}
+	parts := strings.Split(version, ".")
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		numbers[i] = n
+	}
+	return numbers, true
+}
@@ -0,0 +1,147 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+)
+
+func expectAuthFailure(t *testing.T, resp *pb.AuthResponse, reason pb.AuthFailureReason, retryable bool) {
+	t.Helper()
+	if resp.Success {
+		t.Fatalf("Expected authentication to fail, got %+v", resp)
+	}
+	if resp.FailureReason != reason || resp.Retryable != retryable {
+		t.Errorf("Expected %v with retryable=%v, got %v with retryable=%v",
+			reason, retryable, resp.FailureReason, resp.Retryable)
+	}
+	if resp.ErrorMessage == "" {
+		t.Error("Expected an error message for older agents")
+	}
+}
+
+func TestAuthFailureInvalidTokenIsPermanent(t *testing.T) {
+	s, _, _ := newSessionTestServer(t)
+
+	resp, err := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", Token: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectAuthFailure(t, resp, pb.AuthFailureReason_AUTH_FAILURE_INVALID_TOKEN, false)
+	if resp.RetryAfterMs != 0 {
+		t.Errorf("Expected no retry delay for a permanent failure, got %d", resp.RetryAfterMs)
+	}
+
+	// Operators rotating tokens can keep agents retrying
+	s.config.Auth.RetryInvalidToken = true
+	s.config.Auth.RetryAfterSec = 120
+	resp, _ = s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", Token: "wrong"})
+	expectAuthFailure(t, resp, pb.AuthFailureReason_AUTH_FAILURE_INVALID_TOKEN, true)
+	if resp.RetryAfterMs != 120000 {
+		t.Errorf("Expected a 120s retry delay, got %dms", resp.RetryAfterMs)
+	}
+}
+
+func TestAuthFailureExpiredSessionRetriesNow(t *testing.T) {
+	s, _, _ := newSessionTestServer(t)
+
+	resp, _ := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", SessionToken: "unknown"})
+	expectAuthFailure(t, resp, pb.AuthFailureReason_AUTH_FAILURE_SESSION_EXPIRED, true)
+	if resp.RetryAfterMs != 0 {
+		t.Errorf("Expected an immediate retry with the token, got %dms", resp.RetryAfterMs)
+	}
+}
+
+func TestAuthFailureDeniedAgentRetriesAfterDenial(t *testing.T) {
+	s, agents, clock := newSessionTestServer(t)
+	agents.SetClock(clock)
+
+	resp, _ := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", Token: "agent-secret"})
+	agentID := connectWithSession(t, s, agents, "agent-1", resp.SessionToken)
+
+	agents.RegisterGrpcAgent(agentID, service.AgentInfo{Hostname: "web-1"}, 2)
+	if _, err := agents.ForceDisconnect(agentID, "maintenance", 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(30 * time.Second)
+
+	denied, _ := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", SessionToken: resp.SessionToken})
+	expectAuthFailure(t, denied, pb.AuthFailureReason_AUTH_FAILURE_AGENT_DENIED, true)
+	if denied.RetryAfterMs != uint32((9*time.Minute + 30*time.Second).Milliseconds()) {
+		t.Errorf("Expected to retry once the denial lapses in 9m30s, got %dms", denied.RetryAfterMs)
+	}
+}
+
+func TestAuthFailureVersionTooOld(t *testing.T) {
+	s, _, _ := newSessionTestServer(t)
+	s.config.Auth.MinAgentVersion = "0.3.0"
+
+	for _, version := range []string{"0.2.9", "", "dev"} {
+		resp, _ := s.Authenticate(context.Background(), &pb.AuthRequest{
+			Hostname: "web-1", Token: "agent-secret", AgentVersion: version,
+		})
+		expectAuthFailure(t, resp, pb.AuthFailureReason_AUTH_FAILURE_VERSION_TOO_OLD, false)
+	}
+
+	resp, _ := s.Authenticate(context.Background(), &pb.AuthRequest{
+		Hostname: "web-1", Token: "agent-secret", AgentVersion: "v0.3.1-beta",
+	})
+	if !resp.Success || resp.FailureReason != pb.AuthFailureReason_AUTH_FAILURE_NONE {
+		t.Errorf("Expected a newer agent to authenticate, got %+v", resp)
+	}
+}
+
+func TestAgentVersionBelow(t *testing.T) {
+	cases := []struct {
+		version, min string
+		below        bool
+	}{
+		{"0.3.0", "0.3.0", false},
+		{"0.3", "0.3.0", false},
+		{"0.10.0", "0.9.2", false},
+		{"1.0.0+build5", "1.0", false},
+		{"0.2.14", "0.3.0", true},
+		{"garbage", "0.1.0", true},
+	}
+	for _, c := range cases {
+		if got := agentVersionBelow(c.version, c.min); got != c.below {
+			t.Errorf("agentVersionBelow(%q, %q) = %v, want %v", c.version, c.min, got, c.below)
+		}
+	}
+}
+
+func TestAuthRejectsExpiredAndOutOfScopeTokens(t *testing.T) {
+	s, _, _ := newSessionTestServer(t)
+	expired := time.Now().Add(-time.Hour)
+	s.config.Auth.Tokens = []config.TokenConfig{
+		{Token: "old-secret", ExpiresAt: &expired},
+		{Token: "web-secret", Permission: 1, Hostnames: []string{"web-*"}, Labels: map[string]string{"env": "prod"}},
+	}
+
+	for _, tc := range []struct {
+		req  *pb.AuthRequest
+		want string
+	}{
+		{&pb.AuthRequest{Hostname: "web-1", Token: "old-secret"}, "expired"},
+		{&pb.AuthRequest{Hostname: "db-1", Token: "web-secret", Labels: map[string]string{"env": "prod"}}, `hostname "db-1"`},
+		{&pb.AuthRequest{Hostname: "web-1", Token: "web-secret", Labels: map[string]string{"env": "dev"}}, "env=prod"},
+	} {
+		resp, _ := s.Authenticate(context.Background(), tc.req)
+		expectAuthFailure(t, resp, pb.AuthFailureReason_AUTH_FAILURE_INVALID_TOKEN, false)
+		if !strings.Contains(resp.ErrorMessage, tc.want) {
+			t.Errorf("%s with %s: expected %q in %q", tc.req.Hostname, tc.req.Token, tc.want, resp.ErrorMessage)
+		}
+	}
+
+	resp, _ := s.Authenticate(context.Background(), &pb.AuthRequest{
+		Hostname: "WEB-2", Token: "web-secret", Labels: map[string]string{"env": "prod", "zone": "a"},
+	})
+	if !resp.Success || resp.PermissionLevel != 1 {
+		t.Errorf("Expected a matching agent to authenticate, got %+v", resp)
+	}
+}
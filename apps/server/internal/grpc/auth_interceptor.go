@@ -53,6 +53,8 @@ func NewAuthInterceptor(
 			"/nanolink.NanoLinkService/SendHeartbeat":  true,
 			"/nanolink.NanoLinkService/SendMetrics":    true,
 			"/nanolink.NanoLinkService/ExecuteCommand": true,
+			// Agents sync their own metrics, checked against their session
+			"/nanolink.NanoLinkService/SyncMetrics": true,
 		},
 	}
 }
@@ -0,0 +1,197 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// serverTLSConfig loads the server certificate and, when clientCAFile is
+// set, verifies agent certificates against it. With requireClientCert
+// agents without a certificate signed by the CA cannot connect; otherwise
+// a certificate is verified only if the agent presents one.
+func serverTLSConfig(certFile, keyFile, clientCAFile string, requireClientCert bool) (*tls.Config, error) {
+	if requireClientCert && clientCAFile == "" {
+		return nil, errors.New("require_client_cert needs client_ca_file")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile == "" {
+		return cfg, nil
+	}
+
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA %s", clientCAFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	if requireClientCert {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// clientCertificate returns the verified certificate the agent connected
+// with, or nil if it presented none
+func clientCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return info.State.VerifiedChains[0][0]
+}
+
+// certMatchesHostname reports whether cert was issued to hostname, by its
+// DNS SANs (wildcards included) or its common name
+func certMatchesHostname(cert *x509.Certificate, hostname string) bool {
+	if hostname == "" {
+		return false
+	}
+	return cert.VerifyHostname(hostname) == nil || strings.EqualFold(cert.Subject.CommonName, hostname)
+}
+
+// certIdentity describes cert for logs
+func certIdentity(cert *x509.Certificate) string {
+	if len(cert.DNSNames) == 0 {
+		return fmt.Sprintf("CN=%s", cert.Subject.CommonName)
+	}
+	return fmt.Sprintf("CN=%s SAN=%s", cert.Subject.CommonName, strings.Join(cert.DNSNames, ","))
+}
+
+// crlChecker refuses client certificates revoked by a CRL the client CA
+// issued. The CRL is read again whenever its file changes, so revoking one
+// agent's certificate needs no restart.
+type crlChecker struct {
+	path    string
+	issuers []*x509.Certificate
+
+	mu      sync.Mutex
+	modTime time.Time
+	revoked map[string]struct{} // Serial numbers of revoked certificates
+}
+
+// newCRLChecker loads the CRL at crlFile, which must be signed by a
+// certificate in clientCAFile
+func newCRLChecker(crlFile, clientCAFile string) (*crlChecker, error) {
+	if clientCAFile == "" {
+		return nil, errors.New("client_crl_file needs client_ca_file")
+	}
+	data, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+	c := &crlChecker{path: crlFile}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			c.issuers = append(c.issuers, cert)
+		}
+	}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload reads the CRL if its file changed since it was last read. Callers
+// hold c.mu or have not shared c yet.
+func (c *crlChecker) reload() error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to read client CRL: %w", err)
+	}
+	if c.revoked != nil && info.ModTime().Equal(c.modTime) {
+		return nil
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to read client CRL: %w", err)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse client CRL %s: %w", c.path, err)
+	}
+	signed := false
+	for _, issuer := range c.issuers {
+		if crl.CheckSignatureFrom(issuer) == nil {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return fmt.Errorf("client CRL %s is not signed by the client CA", c.path)
+	}
+
+	revoked := make(map[string]struct{}, len(crl.RevokedCertificateEntries))
+	for _, entry := range crl.RevokedCertificateEntries {
+		revoked[entry.SerialNumber.String()] = struct{}{}
+	}
+	c.revoked, c.modTime = revoked, info.ModTime()
+	return nil
+}
+
+// check returns an error when cert has been revoked. A CRL that can no
+// longer be read fails every certificate rather than none.
+func (c *crlChecker) check(cert *x509.Certificate) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.reload(); err != nil {
+		return err
+	}
+	if _, ok := c.revoked[cert.SerialNumber.String()]; ok {
+		return fmt.Errorf("client certificate %s has been revoked", certIdentity(cert))
+	}
+	return nil
+}
+
+// verifyPeer is a tls.Config.VerifyPeerCertificate refusing revoked
+// certificates during the handshake
+func (c *crlChecker) verifyPeer(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+		return nil
+	}
+	return c.check(verifiedChains[0][0])
+}
+
+// checkClientCert returns an error when an agent that connected with cert
+// claims a hostname it was not issued for, or cert has been revoked since
+// the connection was made. Agents without a certificate pass.
+func (s *Server) checkClientCert(cert *x509.Certificate, hostname string) error {
+	if cert == nil {
+		return nil
+	}
+	if !certMatchesHostname(cert, hostname) {
+		return fmt.Errorf("client certificate %s does not match hostname %q", certIdentity(cert), hostname)
+	}
+	if s.crl != nil {
+		return s.crl.check(cert)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package grpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issueCert creates a certificate for cn and dnsNames, self-signed when
+// parent is nil
+func issueCert(t *testing.T, parent *testCert, cn string, dnsNames ...string) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// writePEM writes c's certificate and key to dir
+func (c *testCert) writePEM(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	certFile = filepath.Join(dir, name+".crt")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile = filepath.Join(dir, name+".key")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// certContext is a peer context for an agent that connected with cert
+func certContext(cert *x509.Certificate) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 50000},
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{cert}},
+		}},
+	})
+}
+
+func TestServerTLSConfigClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, nil, "NanoLink Agents CA")
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	serverCert, serverKey := issueCert(t, ca, "nanolink-server", "nanolink.example.com").writePEM(t, dir, "server")
+
+	cfg, err := serverTLSConfig(serverCert, serverKey, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ClientAuth != tls.NoClientCert {
+		t.Errorf("Expected no client certificates without a CA, got %v", cfg.ClientAuth)
+	}
+
+	cfg, err = serverTLSConfig(serverCert, serverKey, caFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Errorf("Expected optional client certificates, got %v", cfg.ClientAuth)
+	}
+
+	cfg, err = serverTLSConfig(serverCert, serverKey, caFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("Expected required client certificates, got %v", cfg.ClientAuth)
+	}
+	agent := issueCert(t, ca, "web-1", "web-1.example.com")
+	if _, err := agent.cert.Verify(x509.VerifyOptions{Roots: cfg.ClientCAs, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("Expected the agent certificate to verify against the client CA: %v", err)
+	}
+
+	if _, err := serverTLSConfig(serverCert, serverKey, "", true); err == nil {
+		t.Error("Expected requiring client certificates without a CA to fail")
+	}
+	if _, err := serverTLSConfig(serverCert, serverKey, serverKey, true); err == nil {
+		t.Error("Expected a CA file without certificates to fail")
+	}
+}
+
+func TestCertMatchesHostname(t *testing.T) {
+	ca := issueCert(t, nil, "NanoLink Agents CA")
+	cert := issueCert(t, ca, "web-1", "web-1.example.com", "*.db.example.com").cert
+
+	for hostname, want := range map[string]bool{
+		"web-1":             true,
+		"WEB-1":             true,
+		"web-1.example.com": true,
+		"pg.db.example.com": true,
+		"web-2":             false,
+		"":                  false,
+	} {
+		if got := certMatchesHostname(cert, hostname); got != want {
+			t.Errorf("certMatchesHostname(%q) = %v, want %v", hostname, got, want)
+		}
+	}
+}
+
+func TestAuthenticateCrossChecksClientCert(t *testing.T) {
+	s, _, _ := newSessionTestServer(t)
+	ca := issueCert(t, nil, "NanoLink Agents CA")
+	ctx := certContext(issueCert(t, ca, "web-1").cert)
+
+	resp, err := s.Authenticate(ctx, &pb.AuthRequest{Hostname: "web-1", Token: "agent-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Success {
+		t.Fatalf("Expected the agent to authenticate as its certificate's hostname, got %+v", resp)
+	}
+
+	// A valid token is not enough to impersonate another host
+	resp, _ = s.Authenticate(ctx, &pb.AuthRequest{Hostname: "db-1", Token: "agent-secret"})
+	expectAuthFailure(t, resp, pb.AuthFailureReason_AUTH_FAILURE_INVALID_TOKEN, false)
+
+	// Nor is a session issued to the real host
+	ok, _ := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "db-1", Token: "agent-secret"})
+	resp, _ = s.Authenticate(ctx, &pb.AuthRequest{Hostname: "db-1", SessionToken: ok.SessionToken})
+	expectAuthFailure(t, resp, pb.AuthFailureReason_AUTH_FAILURE_INVALID_TOKEN, false)
+
+	// The stream is held to the same hostname
+	stream := &fakeMetricsStream{recv: make(chan *pb.MetricsStreamRequest, 1), ctx: ctx}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_AgentInit{
+		AgentInit: &pb.AgentInit{AgentId: "agent-1", Hostname: "db-1"},
+	}}
+	if err := s.StreamMetrics(stream); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied for a mismatched stream, got %v", err)
+	}
+
+	// And cannot leave the hostname out to claim one later
+	stream = &fakeMetricsStream{recv: make(chan *pb.MetricsStreamRequest, 1), ctx: ctx}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_Realtime{Realtime: &pb.RealtimeMetrics{}}}
+	if err := s.StreamMetrics(stream); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied for a stream without a hostname, got %v", err)
+	}
+}
+
+// writeCRL writes a CRL issued by ca revoking revoked to dir
+func writeCRL(t *testing.T, dir string, ca *testCert, number int64, revoked ...*testCert) string {
+	t.Helper()
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(number),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, cert := range revoked {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries,
+			x509.RevocationListEntry{SerialNumber: cert.cert.SerialNumber, RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "agents.crl")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRevokedClientCertsAreRefused(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, nil, "NanoLink Agents CA")
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	web, db := issueCert(t, ca, "web-1"), issueCert(t, ca, "db-1")
+
+	if _, err := newCRLChecker(writeCRL(t, dir, issueCert(t, nil, "Other CA"), 1), caFile); err == nil {
+		t.Error("Expected a CRL from another CA to be refused")
+	}
+	crl, err := newCRLChecker(writeCRL(t, dir, ca, 1, web), caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.verifyPeer(nil, [][]*x509.Certificate{{web.cert, ca.cert}}); err == nil {
+		t.Error("Expected the handshake of a revoked certificate to fail")
+	}
+	if err := crl.verifyPeer(nil, [][]*x509.Certificate{{db.cert, ca.cert}}); err != nil {
+		t.Errorf("Expected a certificate that is not revoked to pass, got %v", err)
+	}
+
+	s, _, _ := newSessionTestServer(t)
+	s.crl = crl
+	resp, _ := s.Authenticate(certContext(web.cert), &pb.AuthRequest{Hostname: "web-1", Token: "agent-secret"})
+	expectAuthFailure(t, resp, pb.AuthFailureReason_AUTH_FAILURE_INVALID_TOKEN, false)
+
+	// Revoking another certificate takes effect without a restart
+	path := writeCRL(t, dir, ca, 2, web, db)
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ = s.Authenticate(certContext(db.cert), &pb.AuthRequest{Hostname: "db-1", Token: "agent-secret"})
+	expectAuthFailure(t, resp, pb.AuthFailureReason_AUTH_FAILURE_INVALID_TOKEN, false)
+}
@@ -0,0 +1,62 @@
+package grpc
+
+import (
+	"context"
+
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+)
+
+// SetAuditService records every command dispatched to a local agent, and
+// its result, in the audit log
+func (s *Server) SetAuditService(audit *service.AuditService) {
+	s.auditService = audit
+}
+
+// commandActor returns who a dashboard command request runs as: the
+// authenticated user, else the dashboard user named in the request
+func commandActor(ctx context.Context, req *pb.DashboardCommandRequest) service.AuditActor {
+	actor := service.AuditActor{IPAddress: peerIP(ctx)}
+	if userID, username, _, ok := GetUserFromContext(ctx); ok {
+		actor.UserID = userID
+		actor.Username = username
+	} else {
+		actor.Username = req.DashboardUser
+	}
+	return actor
+}
+
+// auditDispatch records a command as it is sent to an agent, or as failed
+// when it could not be sent. The result fills in the outcome later.
+func (s *Server) auditDispatch(agentID, hostname string, cmd *pb.Command, actor service.AuditActor, dispatchErr error) {
+	if s.auditService == nil {
+		return
+	}
+	entry := service.AuditEntry{
+		UserID:        actor.UserID,
+		Username:      actor.Username,
+		AgentID:       agentID,
+		AgentHostname: hostname,
+		CommandType:   cmd.Type.String(),
+		CommandID:     cmd.CommandId,
+		Target:        cmd.Target,
+		Params:        cmd.Params,
+		IPAddress:     actor.IPAddress,
+	}
+	if dispatchErr != nil {
+		entry.Error = dispatchErr.Error()
+	}
+	if err := s.auditService.LogCommand(entry); err != nil {
+		s.logger.Warnf("Failed to audit command %s for agent %s: %v", cmd.CommandId, agentID, err)
+	}
+}
+
+// auditCompletion records the outcome of an audited command
+func (s *Server) auditCompletion(agentID, commandID string, success bool, errMsg, output string) {
+	if s.auditService == nil {
+		return
+	}
+	if err := s.auditService.CompleteCommand(agentID, commandID, success, errMsg, output); err != nil {
+		s.logger.Warnf("Failed to audit result of command %s: %v", commandID, err)
+	}
+}
@@ -0,0 +1,107 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestAuditService(t *testing.T) *service.AuditService {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("Failed to get sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&database.AuditLog{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	return service.NewAuditService(db, zap.NewNop().Sugar())
+}
+
+func auditLogs(t *testing.T, audit *service.AuditService) []database.AuditLog {
+	t.Helper()
+	result, err := audit.QueryLogs(service.AuditQuery{AgentID: "agent-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.Logs
+}
+
+func TestSendCommandIsAudited(t *testing.T) {
+	s, stream, done := startStream(t)
+	audit := newTestAuditService(t)
+	s.SetAuditService(audit)
+
+	results := make(chan *pb.CommandResult, 1)
+	go func() {
+		result, _ := s.SendCommand(context.Background(), &pb.DashboardCommandRequest{
+			AgentId:       "agent-1",
+			Command:       &pb.Command{CommandId: "cmd-1", Type: pb.CommandType_SERVICE_RESTART, Target: "nginx"},
+			DashboardUser: "alice",
+		})
+		results <- result
+	}()
+	waitFor(t, stream.sentCommand)
+
+	logs := auditLogs(t, audit)
+	if len(logs) != 1 {
+		t.Fatalf("Expected the dispatch to be audited, got %+v", logs)
+	}
+	entry := logs[0]
+	if entry.Username != "alice" || entry.AgentHostname != "web-1" ||
+		entry.CommandType != "SERVICE_RESTART" || entry.Target != "nginx" || entry.Success {
+		t.Errorf("Expected a pending entry for alice restarting nginx on web-1, got %+v", entry)
+	}
+
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_CommandResult{
+		CommandResult: &pb.CommandResult{CommandId: "cmd-1", Success: true, Output: "restarted"},
+	}}
+	select {
+	case <-results:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected SendCommand to return the agent's result")
+	}
+
+	logs = auditLogs(t, audit)
+	if len(logs) != 1 || !logs[0].Success || logs[0].Output != "restarted" {
+		t.Errorf("Expected the entry to record the result, got %+v", logs)
+	}
+
+	close(stream.recv)
+	<-done
+}
+
+func TestUnsentCommandIsAuditedAsFailed(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	metrics := service.NewMetricsService(logger)
+	s := NewServer(config.Default(), service.NewAgentService(logger, metrics), metrics, logger)
+	audit := newTestAuditService(t)
+	s.SetAuditService(audit)
+
+	cmd := &pb.Command{CommandId: "cmd-1", Type: pb.CommandType_SHELL_EXECUTE, Target: "uptime"}
+	if err := s.SendCommandToAgentAs("agent-1", cmd, service.AuditActor{Username: "bob"}); err == nil {
+		t.Fatal("Expected sending to an unknown agent to fail")
+	}
+
+	logs := auditLogs(t, audit)
+	if len(logs) != 1 || logs[0].Username != "bob" || logs[0].Success || logs[0].Error == "" {
+		t.Errorf("Expected a failed entry for bob, got %+v", logs)
+	}
+}
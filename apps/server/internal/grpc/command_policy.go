@@ -0,0 +1,78 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+)
+
+// ErrCommandNotPermitted is returned for commands the agent's reported
+// shell policy would refuse
+var ErrCommandNotPermitted = errors.New("command not permitted on this agent")
+
+// checkCommandPolicy rejects a shell command the agent would refuse anyway,
+// saving the round trip. Agents that reported no policy are not checked; the
+// agent still enforces its own policy, super token and injection checks.
+func checkCommandPolicy(policy *pb.CommandPolicy, cmd *pb.Command) error {
+	if policy == nil || cmd.GetType() != pb.CommandType_SHELL_EXECUTE {
+		return nil
+	}
+	command := cmd.GetTarget()
+	if !policy.ShellEnabled {
+		return fmt.Errorf("%w: shell commands are disabled", ErrCommandNotPermitted)
+	}
+	for _, pattern := range policy.ShellBlacklist {
+		if pattern != "" && strings.Contains(command, pattern) {
+			return fmt.Errorf("%w: contains blacklisted pattern %q", ErrCommandNotPermitted, pattern)
+		}
+	}
+	if len(policy.ShellWhitelist) == 0 {
+		return nil
+	}
+	for _, pattern := range policy.ShellWhitelist {
+		if matchesShellPattern(pattern, command) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q is not in the whitelist", ErrCommandNotPermitted, command)
+}
+
+// matchesShellPattern matches a whitelist pattern the way the agent does:
+// without "*" the command must be equal, otherwise the first part is a
+// prefix, the last a suffix and the middle parts appear in order
+func matchesShellPattern(pattern, command string) bool {
+	if pattern == "*" {
+		return true
+	}
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == command
+	}
+
+	pos := 0
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		switch i {
+		case 0:
+			if !strings.HasPrefix(command, part) {
+				return false
+			}
+			pos = len(part)
+		case len(parts) - 1:
+			if !strings.HasSuffix(command, part) {
+				return false
+			}
+		default:
+			found := strings.Index(command[pos:], part)
+			if found < 0 {
+				return false
+			}
+			pos += found + len(part)
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+)
+
+func TestCheckCommandPolicy(t *testing.T) {
+	policy := &pb.CommandPolicy{
+		ShellEnabled:   true,
+		ShellWhitelist: []string{"df -h", "systemctl status *", "journalctl * --no-pager"},
+		ShellBlacklist: []string{"rm -rf"},
+	}
+	shell := func(command string) *pb.Command {
+		return &pb.Command{Type: pb.CommandType_SHELL_EXECUTE, Target: command}
+	}
+
+	for _, command := range []string{"df -h", "systemctl status nginx", "journalctl -u nginx --no-pager"} {
+		if err := checkCommandPolicy(policy, shell(command)); err != nil {
+			t.Errorf("Expected %q to be permitted, got %v", command, err)
+		}
+	}
+	for _, command := range []string{"df -h /", "reboot", "systemctl status x; rm -rf /"} {
+		if err := checkCommandPolicy(policy, shell(command)); !errors.Is(err, ErrCommandNotPermitted) {
+			t.Errorf("Expected %q to be refused, got %v", command, err)
+		}
+	}
+
+	// Only shell commands are covered by the policy
+	disabled := &pb.CommandPolicy{}
+	if err := checkCommandPolicy(disabled, &pb.Command{Type: pb.CommandType_SERVICE_STATUS, Target: "nginx"}); err != nil {
+		t.Errorf("Expected non-shell commands to pass, got %v", err)
+	}
+	if err := checkCommandPolicy(disabled, shell("uptime")); !errors.Is(err, ErrCommandNotPermitted) {
+		t.Errorf("Expected shell commands to be refused when the shell is disabled, got %v", err)
+	}
+	if err := checkCommandPolicy(nil, shell("uptime")); err != nil {
+		t.Errorf("Expected agents without a policy to be unchecked, got %v", err)
+	}
+}
+
+func TestSendCommandRefusesCommandsOutsideAgentPolicy(t *testing.T) {
+	s, _, _ := newSessionTestServer(t)
+	policy := &pb.CommandPolicy{ShellEnabled: true, ShellWhitelist: []string{"uptime"}}
+	resp, err := s.Authenticate(context.Background(), &pb.AuthRequest{
+		Hostname: "web-1", Token: "agent-secret", CommandPolicy: policy,
+	})
+	if err != nil || !resp.Success {
+		t.Fatalf("Authenticate failed: %v %+v", err, resp)
+	}
+
+	stream := &fakeMetricsStream{recv: make(chan *pb.MetricsStreamRequest, 1)}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_AgentInit{
+		AgentInit: &pb.AgentInit{AgentId: "agent-1", Hostname: "web-1", SessionToken: resp.SessionToken},
+	}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.StreamMetrics(stream)
+	}()
+	defer func() {
+		close(stream.recv)
+		<-done
+	}()
+	waitFor(t, func() bool { return s.GetAgent("agent-1") != nil })
+
+	info, err := s.GetAgentInfo(context.Background(), &pb.AgentInfoRequest{AgentId: "agent-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.GetCommandPolicy().GetShellWhitelist(); len(got) != 1 || got[0] != "uptime" {
+		t.Errorf("Expected the reported whitelist in the agent info, got %v", got)
+	}
+
+	result, err := s.SendCommand(context.Background(), &pb.DashboardCommandRequest{
+		AgentId: "agent-1",
+		Command: &pb.Command{CommandId: "cmd-1", Type: pb.CommandType_SHELL_EXECUTE, Target: "reboot"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Success || !strings.Contains(result.Error, "command not permitted on this agent") {
+		t.Errorf("Expected the command to be refused, got %+v", result)
+	}
+	if stream.sentCommand() {
+		t.Error("Expected the refused command not to reach the agent")
+	}
+
+	if err := s.SendCommandToAgent("agent-1", &pb.Command{CommandId: "cmd-2", Type: pb.CommandType_SHELL_EXECUTE, Target: "reboot"}); !errors.Is(err, ErrCommandNotPermitted) {
+		t.Errorf("Expected SendCommandToAgent to refuse the command, got %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package grpc
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/encoding/gzip"
+)
+
+// Importing the gzip codec registers it, so agents can opt in to compressing
+// their metrics stream with grpc-encoding: gzip. The server then compresses
+// its responses on that stream the same way.
+
+// setCompressionLevel sets the gzip level for compressed messages, leaving
+// gzip's default when level is 0
+func setCompressionLevel(level int) error {
+	if level == 0 {
+		return nil
+	}
+	if err := gzip.SetLevel(level); err != nil {
+		return fmt.Errorf("invalid grpc_compression_level %d: %w", level, err)
+	}
+	return nil
+}
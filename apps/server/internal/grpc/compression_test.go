@@ -0,0 +1,51 @@
+package grpc
+
+import (
+	"testing"
+
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestGzipRegisteredForAgents(t *testing.T) {
+	compressor := encoding.GetCompressor("gzip")
+	if compressor == nil {
+		t.Fatal("Expected the gzip compressor to be registered")
+	}
+
+	metrics := &pb.Metrics{Hostname: "web-1", Cpu: &pb.CpuMetrics{Model: "Intel Xeon Gold 6338", CoreCount: 64}}
+	for i := 0; i < 16; i++ {
+		metrics.Disks = append(metrics.Disks, &pb.DiskMetrics{MountPoint: "/data", FsType: "ext4", Model: "Samsung SSD PM9A3", DiskType: "NVMe"})
+	}
+	data, err := proto.Marshal(metrics)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var buf countingWriter
+	w, err := compressor.Compress(&buf)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	w.Write(data)
+	w.Close()
+	if int(buf) >= len(data)/2 {
+		t.Errorf("Expected gzip to at least halve %d bytes, got %d", len(data), buf)
+	}
+}
+
+func TestSetCompressionLevel(t *testing.T) {
+	if err := setCompressionLevel(0); err != nil {
+		t.Errorf("Expected 0 to keep the default, got %v", err)
+	}
+	if err := setCompressionLevel(12); err == nil {
+		t.Error("Expected an out of range level to be rejected")
+	}
+}
+
+type countingWriter int
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
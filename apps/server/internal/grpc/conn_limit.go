@@ -0,0 +1,102 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidConnectionExempt is returned for exemptions that are neither
+// an IP nor a CIDR
+var ErrInvalidConnectionExempt = errors.New("invalid connection limit exemption")
+
+// connLimiter counts the agent streams each source IP holds. The zero
+// value imposes no limit.
+type connLimiter struct {
+	mu     sync.Mutex
+	max    int
+	exempt []*net.IPNet
+	open   map[string]int
+}
+
+// SetConnectionLimit caps the agent streams a single source IP may hold at
+// once; 0 removes the cap. IPs in the exempt IPs or CIDRs, such as NAT
+// gateways, are never limited.
+func (s *Server) SetConnectionLimit(max int, exempt []string) error {
+	var networks []*net.IPNet
+	for _, entry := range exempt {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return fmt.Errorf("%w: %q", ErrInvalidConnectionExempt, entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidConnectionExempt, err)
+		}
+		networks = append(networks, network)
+	}
+
+	s.connLimit.mu.Lock()
+	defer s.connLimit.mu.Unlock()
+	s.connLimit.max = max
+	s.connLimit.exempt = networks
+	return nil
+}
+
+// limited reports whether ip is subject to the limit. Unknown IPs are not,
+// since they cannot be told apart.
+func (l *connLimiter) limited(ip string) bool {
+	if l.max <= 0 || ip == "" {
+		return false
+	}
+	parsed := net.ParseIP(ip)
+	for _, network := range l.exempt {
+		if parsed != nil && network.Contains(parsed) {
+			return false
+		}
+	}
+	return true
+}
+
+// full reports whether ip already holds as many streams as it may
+func (l *connLimiter) full(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limited(ip) && l.open[ip] >= l.max
+}
+
+// acquire counts a new stream from ip, returning false if ip is full
+func (l *connLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limited(ip) && l.open[ip] >= l.max {
+		return false
+	}
+	if l.open == nil {
+		l.open = make(map[string]int)
+	}
+	l.open[ip]++
+	return true
+}
+
+// release uncounts a stream acquired for ip
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open[ip] <= 1 {
+		delete(l.open, ip)
+		return
+	}
+	l.open[ip]--
+}
@@ -0,0 +1,141 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func peerContext(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000},
+	})
+}
+
+// openStream starts a stream from ip and returns its result channel
+func openStream(s *Server, ip, agentID string) (*fakeMetricsStream, <-chan error) {
+	stream := &fakeMetricsStream{recv: make(chan *pb.MetricsStreamRequest, 4), ctx: peerContext(ip)}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_AgentInit{
+		AgentInit: &pb.AgentInit{AgentId: agentID, Hostname: agentID},
+	}}
+	done := make(chan error, 1)
+	go func() { done <- s.StreamMetrics(stream) }()
+	return stream, done
+}
+
+func newLimitedServer(t *testing.T, max int, exempt ...string) *Server {
+	t.Helper()
+	logger := zap.NewNop().Sugar()
+	metrics := service.NewMetricsService(logger)
+	cfg := config.Default()
+	cfg.Auth.Tokens = []config.TokenConfig{{Token: "secret", Permission: 0}}
+	s := NewServer(cfg, service.NewAgentService(logger, metrics), metrics, logger)
+	if err := s.SetConnectionLimit(max, exempt); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestConnectionLimitRejectsExcessStreams(t *testing.T) {
+	s := newLimitedServer(t, 2)
+
+	first, firstDone := openStream(s, "203.0.113.7", "agent-1")
+	second, secondDone := openStream(s, "203.0.113.7", "agent-2")
+	waitFor(t, func() bool { return s.GetAgent("agent-1") != nil && s.GetAgent("agent-2") != nil })
+
+	_, thirdDone := openStream(s, "203.0.113.7", "agent-3")
+	select {
+	case err := <-thirdDone:
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("Expected ResourceExhausted, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the third stream to be rejected")
+	}
+	resp, err := s.Authenticate(peerContext("203.0.113.7"), &pb.AuthRequest{Token: "secret", Hostname: "agent-3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectAuthFailure(t, resp, pb.AuthFailureReason_AUTH_FAILURE_TOO_MANY_CONNECTIONS, true)
+
+	// Other addresses are counted separately
+	other, otherDone := openStream(s, "203.0.113.8", "agent-4")
+	waitFor(t, func() bool { return s.GetAgent("agent-4") != nil })
+
+	// Closing a stream frees its slot
+	close(first.recv)
+	<-firstDone
+	if resp, _ := s.Authenticate(peerContext("203.0.113.7"), &pb.AuthRequest{Token: "secret", Hostname: "agent-3"}); !resp.Success {
+		t.Errorf("Expected authentication once a stream closed, got %+v", resp)
+	}
+	close(second.recv)
+	close(other.recv)
+	<-secondDone
+	<-otherDone
+}
+
+func TestConnectionLimitAppliesToSessionResumes(t *testing.T) {
+	s := newLimitedServer(t, 1)
+	s.config.Server.AgentSessionTTLSec = 60
+
+	resp, err := s.Authenticate(peerContext("203.0.113.7"), &pb.AuthRequest{Token: "secret", Hostname: "agent-1"})
+	if err != nil || !resp.Success || resp.SessionToken == "" {
+		t.Fatalf("Expected a session to be issued, got %+v, %v", resp, err)
+	}
+	session := resp.SessionToken
+
+	stream, done := openStream(s, "203.0.113.7", "agent-2")
+	waitFor(t, func() bool { return s.GetAgent("agent-2") != nil })
+
+	// Resuming the session from the full address is refused like a token login
+	resp, err = s.Authenticate(peerContext("203.0.113.7"), &pb.AuthRequest{SessionToken: session, Hostname: "agent-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectAuthFailure(t, resp, pb.AuthFailureReason_AUTH_FAILURE_TOO_MANY_CONNECTIONS, true)
+
+	close(stream.recv)
+	<-done
+	if resp, _ := s.Authenticate(peerContext("203.0.113.7"), &pb.AuthRequest{SessionToken: session, Hostname: "agent-1"}); !resp.Success {
+		t.Errorf("Expected the session to resume once a slot is free, got %+v", resp)
+	}
+}
+
+func TestConnectionLimitExemptions(t *testing.T) {
+	s := newLimitedServer(t, 1, "10.0.0.0/8", "192.0.2.1")
+
+	for _, ip := range []string{"10.1.2.3", "192.0.2.1"} {
+		var streams []*fakeMetricsStream
+		var dones []<-chan error
+		for i := 0; i < 3; i++ {
+			agentID := ip + "-" + string(rune('a'+i))
+			stream, done := openStream(s, ip, agentID)
+			waitFor(t, func() bool { return s.GetAgent(agentID) != nil })
+			streams = append(streams, stream)
+			dones = append(dones, done)
+		}
+		for i := range streams {
+			close(streams[i].recv)
+			<-dones[i]
+		}
+	}
+}
+
+func TestSetConnectionLimitRejectsInvalidExemptions(t *testing.T) {
+	s := newLimitedServer(t, 0)
+	for _, entry := range []string{"nat-gateway", "10.0.0.0/33"} {
+		if err := s.SetConnectionLimit(1, []string{entry}); !errors.Is(err, ErrInvalidConnectionExempt) {
+			t.Errorf("Expected ErrInvalidConnectionExempt for %q, got %v", entry, err)
+		}
+	}
+}
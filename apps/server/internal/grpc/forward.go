@@ -0,0 +1,41 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+)
+
+// CommandForwarder delivers a command to an agent whose stream is held by
+// another server instance. Implementations decide the transport (an
+// internal RPC between instances, a message bus, ...); the owning instance
+// is looked up through the AgentService's AgentDirectory.
+type CommandForwarder interface {
+	Forward(ctx context.Context, instanceID, agentID string, cmd *pb.Command) error
+}
+
+// SetCommandForwarder enables forwarding of commands for agents connected
+// to other server instances
+func (s *Server) SetCommandForwarder(forwarder CommandForwarder) {
+	s.commandForwarder = forwarder
+}
+
+// forwardCommand hands a command for a non-local agent to its owning
+// instance. It reports whether the agent is known elsewhere, in which case
+// err is the forwarding result.
+func (s *Server) forwardCommand(ctx context.Context, agentID string, cmd *pb.Command) (bool, error) {
+	owner, local, ok := s.agentService.AgentOwner(agentID)
+	if !ok || local {
+		return false, nil
+	}
+	if s.commandForwarder == nil {
+		return true, fmt.Errorf("agent %s is connected to instance %s and command forwarding is not configured", agentID, owner)
+	}
+
+	s.logger.Debugf("Forwarding command %s for agent %s to instance %s", cmd.CommandId, agentID, owner)
+	if err := s.commandForwarder.Forward(ctx, owner, agentID, cmd); err != nil {
+		return true, fmt.Errorf("forward to instance %s: %w", owner, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,74 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"go.uber.org/zap"
+)
+
+func newHeartbeatTestServer() (*Server, *service.AgentService) {
+	logger := zap.NewNop().Sugar()
+	metrics := service.NewMetricsService(logger)
+	agents := service.NewAgentService(logger, metrics)
+	agents.SetHeartbeatMonitor(service.NewHeartbeatMonitor(30 * time.Second))
+	return NewServer(config.Default(), agents, metrics, logger), agents
+}
+
+func TestStreamHeartbeatRecordsAgentRoundTrip(t *testing.T) {
+	s, agents := newHeartbeatTestServer()
+
+	stream := &fakeMetricsStream{recv: make(chan *pb.MetricsStreamRequest, 4)}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_AgentInit{
+		AgentInit: &pb.AgentInit{AgentId: "agent-1", Hostname: "web-1"},
+	}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.StreamMetrics(stream)
+	}()
+
+	// The agent's first heartbeat has no round trip measured yet
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_Heartbeat{Heartbeat: &pb.Heartbeat{
+		Timestamp: uint64(time.Now().UnixMilli()),
+	}}}
+	waitFor(t, func() bool { return stream.heartbeatAcks() >= 2 })
+	if stats, ok := agents.HeartbeatStats("agent-1"); !ok || stats.RTTMs != 0 {
+		t.Errorf("Expected no round trip before one is measured, got %+v", stats)
+	}
+
+	// Later heartbeats carry the round trip the agent timed for the previous one
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_Heartbeat{Heartbeat: &pb.Heartbeat{
+		Timestamp: uint64(time.Now().UnixMilli()),
+		RttMs:     35,
+	}}}
+	waitFor(t, func() bool { return stream.heartbeatAcks() >= 3 })
+	stats, ok := agents.HeartbeatStats("agent-1")
+	if !ok || stats.RTTMs != 35 || stats.SkewExceeded {
+		t.Errorf("Expected a 35ms round trip and no skew, got %+v", stats)
+	}
+
+	close(stream.recv)
+	<-done
+}
+
+func TestUnaryHeartbeatRecordsRoundTrip(t *testing.T) {
+	s, agents := newHeartbeatTestServer()
+	agents.RegisterGrpcAgent("agent-1", service.AgentInfo{Hostname: "web-1"}, 0)
+
+	_, err := s.Heartbeat(context.Background(), &pb.HeartbeatRequest{
+		AgentId:   "agent-1",
+		Timestamp: uint64(time.Now().UnixMilli()),
+		RttMs:     120,
+	})
+	if err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	if stats, ok := agents.HeartbeatStats("agent-1"); !ok || stats.RTTMs != 120 {
+		t.Errorf("Expected a 120ms round trip, got %+v", stats)
+	}
+}
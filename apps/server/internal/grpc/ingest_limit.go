@@ -0,0 +1,80 @@
+package grpc
+
+import (
+	"time"
+
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+)
+
+// ingestBucket is a token bucket capping how fast one kind of metrics
+// message is accepted from an agent. A nil bucket accepts everything.
+type ingestBucket struct {
+	rate    float64 // tokens per second, also the burst size
+	tokens  float64
+	last    time.Time
+	dropped int // messages dropped since the last accepted one
+}
+
+// newIngestBucket returns a full bucket, or nil for a rate of 0 or less
+func newIngestBucket(rate float64) *ingestBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &ingestBucket{rate: rate, tokens: max(rate, 1)}
+}
+
+// allow takes a token if one is available at now
+func (b *ingestBucket) allow(now time.Time) bool {
+	return b.allowN(now, 1)
+}
+
+// allowN takes n tokens if they are available at now. More than the burst
+// size is taken as the whole burst, so oversized requests wait for a full
+// bucket instead of never passing.
+func (b *ingestBucket) allowN(now time.Time, n float64) bool {
+	if b == nil {
+		return true
+	}
+	burst := max(b.rate, 1)
+	if !b.last.IsZero() {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, burst)
+	}
+	b.last = now
+	n = min(n, burst)
+	if b.tokens < n {
+		b.dropped++
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// admitIngest applies the agent's ingestion limits to a metrics message,
+// reporting whether it should be processed. Excess messages are dropped so
+// one chatty agent cannot flood storage and dashboard broadcasts.
+func (s *Server) admitIngest(agent *GrpcAgent, msg *pb.MetricsStreamRequest) bool {
+	var bucket *ingestBucket
+	var kind string
+	switch msg.GetRequest().(type) {
+	case *pb.MetricsStreamRequest_Metrics:
+		bucket, kind = agent.metricsLimit, "metrics"
+	case *pb.MetricsStreamRequest_Realtime:
+		bucket, kind = agent.realtimeLimit, "realtime"
+	default:
+		return true
+	}
+
+	if !bucket.allow(time.Now()) {
+		if bucket.dropped == 1 {
+			s.logger.Warnf("Agent %s (%s) exceeds %g %s messages/sec, dropping the excess",
+				agent.Hostname, agent.AgentID, bucket.rate, kind)
+		}
+		return false
+	}
+	if bucket != nil && bucket.dropped > 0 {
+		s.logger.Warnf("Dropped %d %s messages from %s (%s) over the rate limit",
+			bucket.dropped, kind, agent.Hostname, agent.AgentID)
+		bucket.dropped = 0
+	}
+	return true
+}
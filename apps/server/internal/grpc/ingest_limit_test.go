@@ -0,0 +1,126 @@
+package grpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"go.uber.org/zap"
+)
+
+func TestIngestBucketRefills(t *testing.T) {
+	b := newIngestBucket(2)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if !b.allow(now) || !b.allow(now) {
+		t.Fatal("Expected a burst up to the rate to be allowed")
+	}
+	if b.allow(now) || b.dropped != 1 {
+		t.Fatalf("Expected the third message to be dropped, dropped=%d", b.dropped)
+	}
+	if !b.allow(now.Add(500 * time.Millisecond)) {
+		t.Error("Expected a token after half a second at 2/sec")
+	}
+	if b.allow(now.Add(500 * time.Millisecond)) {
+		t.Error("Expected only one token to have refilled")
+	}
+	// Idle time never fills the bucket beyond its burst
+	later := now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		if !b.allow(later) {
+			t.Fatalf("Expected message %d after idling to be allowed", i)
+		}
+	}
+	if b.allow(later) {
+		t.Error("Expected the bucket to hold no more than its burst")
+	}
+
+	if newIngestBucket(0) != nil || !newIngestBucket(0).allow(now) {
+		t.Error("Expected a zero rate to mean no limit")
+	}
+}
+
+func (f *fakeMetricsStream) heartbeatAcks() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, resp := range f.sent {
+		if resp.GetHeartbeatAck() != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func TestStreamDropsMetricsOverTheLimit(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	metrics := service.NewMetricsService(logger)
+	cfg := config.Default()
+	cfg.Metrics.MaxMetricsPerSec = 2
+	cfg.Metrics.MaxRealtimePerSec = 5
+	s := NewServer(cfg, service.NewAgentService(logger, metrics), metrics, logger)
+
+	stream := &fakeMetricsStream{recv: make(chan *pb.MetricsStreamRequest, 16)}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_AgentInit{
+		AgentInit: &pb.AgentInit{AgentId: "agent-1", Hostname: "web-1"},
+	}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.StreamMetrics(stream)
+	}()
+
+	// A flood of full metrics is cut to the burst
+	for i := 0; i < 6; i++ {
+		stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_Metrics{
+			Metrics: &pb.Metrics{Hostname: "web-1", Cpu: &pb.CpuMetrics{UsagePercent: float64(i)}},
+		}}
+	}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_Heartbeat{Heartbeat: &pb.Heartbeat{}}}
+	// The initial ack plus the one for the heartbeat, sent after the rest
+	waitFor(t, func() bool { return stream.heartbeatAcks() >= 2 })
+	if history := metrics.GetMetricsHistory("agent-1", 0); len(history) != 2 {
+		t.Errorf("Expected 2 of 6 metrics messages to be stored, got %d", len(history))
+	}
+
+	// Realtime messages have their own budget
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_Realtime{
+		Realtime: &pb.RealtimeMetrics{CpuUsagePercent: 77},
+	}}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_Heartbeat{Heartbeat: &pb.Heartbeat{}}}
+	waitFor(t, func() bool { return stream.heartbeatAcks() >= 3 })
+	if current := metrics.GetCurrentMetrics("agent-1"); current == nil || current.CPU.UsagePercent != 77 {
+		t.Errorf("Expected the realtime update to be applied, got %+v", current)
+	}
+
+	close(stream.recv)
+	<-done
+}
+
+func TestAckModeSkipsMetricsOverTheLimit(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	metrics := service.NewMetricsService(logger)
+	cfg := config.Default()
+	cfg.Metrics.MaxRealtimePerSec = 2
+	s := NewServer(cfg, service.NewAgentService(logger, metrics), metrics, logger)
+
+	stream := &fakeMetricsStream{recv: make(chan *pb.MetricsStreamRequest, 8)}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_AgentInit{
+		AgentInit: &pb.AgentInit{AgentId: "agent-1", Hostname: "web-1", Capabilities: []string{MetricsAckCapability}},
+	}}
+	for seq := uint64(1); seq <= 5; seq++ {
+		stream.recv <- &pb.MetricsStreamRequest{Sequence: seq, Request: &pb.MetricsStreamRequest_Realtime{
+			Realtime: &pb.RealtimeMetrics{CpuUsagePercent: float64(seq)},
+		}}
+	}
+	close(stream.recv)
+	if err := s.StreamMetrics(stream); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dropped messages stay unacked, so the agent knows they were not stored
+	if got := stream.ackedSequences(); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("Expected only the 2 admitted messages to be acked, got %v", got)
+	}
+}
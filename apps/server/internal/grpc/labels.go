@@ -0,0 +1,34 @@
+package grpc
+
+// agentLabels merges the labels configured for an agent in
+// server.agent_labels over the ones it sent; configured labels win
+func (s *Server) agentLabels(agentID string, sent map[string]string) map[string]string {
+	configured := s.config.Server.AgentLabels[agentID]
+	if len(configured) == 0 {
+		return sent
+	}
+	labels := make(map[string]string, len(sent)+len(configured))
+	for k, v := range sent {
+		labels[k] = v
+	}
+	for k, v := range configured {
+		labels[k] = v
+	}
+	return labels
+}
+
+// GetAgentsByLabel returns the connected agents carrying a label. An empty
+// value matches any agent that has the key.
+func (s *Server) GetAgentsByLabel(key, value string) map[string]*GrpcAgent {
+	s.agentsMu.RLock()
+	defer s.agentsMu.RUnlock()
+
+	result := make(map[string]*GrpcAgent)
+	for k, v := range s.agents {
+		got, ok := v.Labels[key]
+		if ok && (value == "" || got == value) {
+			result[k] = v
+		}
+	}
+	return result
+}
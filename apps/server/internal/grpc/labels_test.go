@@ -0,0 +1,59 @@
+package grpc
+
+import (
+	"testing"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"go.uber.org/zap"
+)
+
+func TestStreamAgentLabels(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	metrics := service.NewMetricsService(logger)
+	cfg := config.Default()
+	cfg.Server.AgentLabels = map[string]map[string]string{
+		"agent-1": {"env": "prod", "owner": "ops"},
+	}
+	s := NewServer(cfg, service.NewAgentService(logger, metrics), metrics, logger)
+
+	stream := &fakeMetricsStream{recv: make(chan *pb.MetricsStreamRequest, 4)}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_AgentInit{
+		AgentInit: &pb.AgentInit{AgentId: "agent-1", Hostname: "web-1", Labels: map[string]string{"env": "staging", "role": "web"}},
+	}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.StreamMetrics(stream)
+	}()
+	waitFor(t, func() bool { return s.GetAgent("agent-1") != nil })
+
+	// Server-assigned labels override the ones the agent sent
+	want := map[string]string{"env": "prod", "owner": "ops", "role": "web"}
+	labels := s.agentService.GetAgent("agent-1").Labels
+	if len(labels) != len(want) {
+		t.Fatalf("Expected labels %v, got %v", want, labels)
+	}
+	for k, v := range want {
+		if labels[k] != v {
+			t.Errorf("Expected label %s=%s, got %q", k, v, labels[k])
+		}
+	}
+
+	if got := s.GetAgentsByLabel("role", "web"); len(got) != 1 {
+		t.Errorf("Expected role=web to match the agent, got %d", len(got))
+	}
+	if got := s.GetAgentsByLabel("env", "staging"); len(got) != 0 {
+		t.Errorf("Expected the overridden env=staging not to match, got %d", len(got))
+	}
+	if got := s.GetAgentsByLabel("owner", ""); len(got) != 1 {
+		t.Errorf("Expected any owner to match the agent, got %d", len(got))
+	}
+	if got := s.GetAgentsByLabel("zone", ""); len(got) != 0 {
+		t.Errorf("Expected no agent without a zone label, got %d", len(got))
+	}
+
+	close(stream.recv)
+	<-done
+}
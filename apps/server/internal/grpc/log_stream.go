@@ -0,0 +1,207 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/google/uuid"
+)
+
+// Log stream errors
+var (
+	ErrLogStreamPath     = errors.New("log path is required")
+	ErrTooManyLogStreams = errors.New("too many log streams for agent")
+)
+
+// Chunks buffered for a log stream's reader; more are dropped and counted
+const logStreamBuffer = 64
+
+// LogChunk is part of a log file streamed from an agent
+type LogChunk struct {
+	StreamID     string   `json:"streamId"`
+	AgentID      string   `json:"agentId"`
+	Path         string   `json:"path"`
+	Lines        []string `json:"lines,omitempty"`
+	DroppedLines int      `json:"droppedLines,omitempty"` // Lines left out since the previous chunk
+	EOF          bool     `json:"eof,omitempty"`          // No more chunks follow
+	Error        string   `json:"error,omitempty"`
+}
+
+// LogStream is a log file an agent is streaming. Chunks arrive on C, which
+// is closed when the stream ends; call Stop when no longer reading.
+type LogStream struct {
+	ID      string
+	AgentID string
+	Path    string
+	Follow  bool
+	C       <-chan LogChunk
+
+	chunks  chan LogChunk
+	limit   *ingestBucket // Caps bytes per second
+	dropped int           // Lines dropped since the last delivered chunk
+	server  *Server
+}
+
+// Stop ends the stream and tells the agent to stop sending it
+func (l *LogStream) Stop() {
+	l.server.endLogStream(l.ID, "", true)
+}
+
+// StartLogStream asks an agent to stream a log file, sending the last
+// tailLines lines first and, with follow, lines appended after them. The
+// agent decides which files may be streamed.
+func (s *Server) StartLogStream(agentID, path string, follow bool, tailLines int) (*LogStream, error) {
+	if path == "" {
+		return nil, ErrLogStreamPath
+	}
+	s.agentsMu.RLock()
+	agent, exists := s.agents[agentID]
+	s.agentsMu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("agent not found: %s", agentID)
+	}
+
+	rate := s.config.Server.LogStreamMaxBytesPerSec
+	chunks := make(chan LogChunk, logStreamBuffer)
+	stream := &LogStream{
+		ID:      uuid.NewString(),
+		AgentID: agentID,
+		Path:    path,
+		Follow:  follow,
+		C:       chunks,
+		chunks:  chunks,
+		limit:   newIngestBucket(float64(rate)),
+		server:  s,
+	}
+
+	s.logStreamsMu.Lock()
+	if s.logStreams == nil {
+		s.logStreams = make(map[string]*LogStream)
+	}
+	if limit := s.config.Server.LogStreamsPerAgent; limit > 0 {
+		open := 0
+		for _, other := range s.logStreams {
+			if other.AgentID == agentID {
+				open++
+			}
+		}
+		if open >= limit {
+			s.logStreamsMu.Unlock()
+			return nil, fmt.Errorf("%w: %d open", ErrTooManyLogStreams, open)
+		}
+	}
+	s.logStreams[stream.ID] = stream
+	s.logStreamsMu.Unlock()
+
+	err := agent.send(&pb.MetricsStreamResponse{
+		Response: &pb.MetricsStreamResponse_StartLogStream{
+			StartLogStream: &pb.StartLogStream{
+				StreamId:       stream.ID,
+				Path:           path,
+				Follow:         follow,
+				TailLines:      uint32(max(tailLines, 0)),
+				MaxBytesPerSec: uint32(max(rate, 0)),
+			},
+		},
+	})
+	if err != nil {
+		s.endLogStream(stream.ID, "", false)
+		return nil, fmt.Errorf("failed to start log stream on %s: %w", agentID, err)
+	}
+	s.logger.Infof("Started log stream %s of %s on agent %s (follow=%v)", stream.ID, path, agent.Hostname, follow)
+	return stream, nil
+}
+
+// deliverLogChunk passes a chunk from an agent to its stream's reader,
+// dropping lines over the stream's throughput limit or that the reader is
+// too slow for
+func (s *Server) deliverLogChunk(agentID string, chunk *pb.LogChunk) {
+	s.logStreamsMu.Lock()
+	stream, ok := s.logStreams[chunk.StreamId]
+	if !ok || stream.AgentID != agentID {
+		s.logStreamsMu.Unlock()
+		return
+	}
+	stream.dropped += int(chunk.DroppedLines)
+
+	size := 0
+	for _, line := range chunk.Lines {
+		size += len(line) + 1
+	}
+	if len(chunk.Lines) > 0 && !stream.limit.allowN(time.Now(), float64(size)) {
+		stream.dropped += len(chunk.Lines)
+	} else if len(chunk.Lines) > 0 || stream.dropped > 0 {
+		delivered := LogChunk{
+			StreamID:     stream.ID,
+			AgentID:      agentID,
+			Path:         stream.Path,
+			Lines:        chunk.Lines,
+			DroppedLines: stream.dropped,
+		}
+		select {
+		case stream.chunks <- delivered:
+			stream.dropped = 0
+		default:
+			stream.dropped += len(chunk.Lines)
+		}
+	}
+	s.logStreamsMu.Unlock()
+
+	if chunk.Eof {
+		s.endLogStream(chunk.StreamId, chunk.Error, false)
+	}
+}
+
+// endLogStream removes a stream, sending its reader a final chunk with
+// reason as the error and closing C. With notifyAgent the agent is told to
+// stop streaming.
+func (s *Server) endLogStream(streamID, reason string, notifyAgent bool) {
+	s.logStreamsMu.Lock()
+	stream, ok := s.logStreams[streamID]
+	if ok {
+		delete(s.logStreams, streamID)
+		last := LogChunk{StreamID: stream.ID, AgentID: stream.AgentID, Path: stream.Path,
+			DroppedLines: stream.dropped, EOF: true, Error: reason}
+		select {
+		case stream.chunks <- last:
+		default:
+		}
+		close(stream.chunks)
+	}
+	s.logStreamsMu.Unlock()
+	if !ok || !notifyAgent {
+		return
+	}
+
+	s.agentsMu.RLock()
+	agent, exists := s.agents[stream.AgentID]
+	s.agentsMu.RUnlock()
+	if !exists {
+		return
+	}
+	err := agent.send(&pb.MetricsStreamResponse{
+		Response: &pb.MetricsStreamResponse_StopLogStream{
+			StopLogStream: &pb.StopLogStream{StreamId: streamID},
+		},
+	})
+	if err != nil {
+		s.logger.Warnf("Failed to stop log stream %s on %s: %v", streamID, agent.Hostname, err)
+	}
+}
+
+// endAgentLogStreams ends the streams of a disconnected agent
+func (s *Server) endAgentLogStreams(agentID string) {
+	s.logStreamsMu.Lock()
+	var ids []string
+	for id, stream := range s.logStreams {
+		if stream.AgentID == agentID {
+			ids = append(ids, id)
+		}
+	}
+	s.logStreamsMu.Unlock()
+	for _, id := range ids {
+		s.endLogStream(id, "agent disconnected", false)
+	}
+}
@@ -0,0 +1,119 @@
+package grpc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"go.uber.org/zap"
+)
+
+func newLogStreamServer(t *testing.T, maxBytesPerSec int) (*Server, *GrpcAgent, *fakeMetricsStream) {
+	t.Helper()
+	logger := zap.NewNop().Sugar()
+	metrics := service.NewMetricsService(logger)
+	cfg := config.Default()
+	cfg.Server.LogStreamMaxBytesPerSec = maxBytesPerSec
+	cfg.Server.LogStreamsPerAgent = 2
+	s := NewServer(cfg, service.NewAgentService(logger, metrics), metrics, logger)
+
+	stream := &fakeMetricsStream{}
+	agent := &GrpcAgent{AgentID: "agent-1", Hostname: "web-1", stream: stream}
+	s.agents[agent.AgentID] = agent
+	return s, agent, stream
+}
+
+func logChunk(streamID string, eof bool, lines ...string) *pb.MetricsStreamRequest {
+	return &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_LogChunk{
+		LogChunk: &pb.LogChunk{StreamId: streamID, Lines: lines, Eof: eof},
+	}}
+}
+
+func TestLogStreamDeliversChunksUntilEOF(t *testing.T) {
+	s, agent, stream := newLogStreamServer(t, 0)
+
+	logs, err := s.StartLogStream("agent-1", "/var/log/syslog", false, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := stream.sent[0].GetStartLogStream()
+	if start == nil || start.StreamId != logs.ID || start.Path != "/var/log/syslog" || start.TailLines != 50 {
+		t.Fatalf("Expected a StartLogStream request, got %v", stream.sent)
+	}
+
+	s.processStreamMessage(agent, logChunk(logs.ID, false, "one", "two"))
+	s.processStreamMessage(agent, logChunk("other", false, "not ours"))
+	s.processStreamMessage(agent, logChunk(logs.ID, true, "three"))
+
+	var lines []string
+	var last LogChunk
+	for chunk := range logs.C {
+		lines = append(lines, chunk.Lines...)
+		last = chunk
+	}
+	if strings.Join(lines, ",") != "one,two,three" || !last.EOF {
+		t.Errorf("Expected three lines then EOF, got %v (last %+v)", lines, last)
+	}
+	if len(stream.sent) != 1 {
+		t.Errorf("Expected no stop request for a stream the agent ended, got %v", stream.sent)
+	}
+}
+
+func TestLogStreamDropsLinesOverThroughputLimit(t *testing.T) {
+	s, agent, _ := newLogStreamServer(t, 10)
+	logs, err := s.StartLogStream("agent-1", "/var/log/syslog", true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.processStreamMessage(agent, logChunk(logs.ID, false, "12345678")) // 9 bytes
+	s.processStreamMessage(agent, logChunk(logs.ID, false, "abc", "def"))
+	logs.Stop()
+
+	var chunks []LogChunk
+	for chunk := range logs.C {
+		chunks = append(chunks, chunk)
+	}
+	if len(chunks) != 2 || len(chunks[0].Lines) != 1 {
+		t.Fatalf("Expected the first line then the end of the stream, got %+v", chunks)
+	}
+	if !chunks[1].EOF || chunks[1].DroppedLines != 2 {
+		t.Errorf("Expected the final chunk to count 2 dropped lines, got %+v", chunks[1])
+	}
+}
+
+func TestLogStreamStopAndDisconnect(t *testing.T) {
+	s, _, stream := newLogStreamServer(t, 0)
+	first, _ := s.StartLogStream("agent-1", "/var/log/syslog", true, 0)
+	second, _ := s.StartLogStream("agent-1", "/var/log/messages", true, 0)
+
+	if _, err := s.StartLogStream("agent-1", "/var/log/auth.log", true, 0); !errors.Is(err, ErrTooManyLogStreams) {
+		t.Errorf("Expected ErrTooManyLogStreams, got %v", err)
+	}
+	if _, err := s.StartLogStream("agent-1", "", true, 0); !errors.Is(err, ErrLogStreamPath) {
+		t.Errorf("Expected ErrLogStreamPath, got %v", err)
+	}
+
+	// The client going away stops the agent's stream
+	first.Stop()
+	if stop := stream.sent[len(stream.sent)-1].GetStopLogStream(); stop == nil || stop.StreamId != first.ID {
+		t.Errorf("Expected a StopLogStream request, got %v", stream.sent)
+	}
+	first.Stop() // Already stopped
+
+	// The agent going away ends its streams with an error
+	s.endAgentLogStreams("agent-1")
+	var last LogChunk
+	for chunk := range second.C {
+		last = chunk
+	}
+	if !last.EOF || last.Error != "agent disconnected" {
+		t.Errorf("Expected the stream to end with the disconnect, got %+v", last)
+	}
+	if len(s.logStreams) != 0 {
+		t.Errorf("Expected no open streams, got %d", len(s.logStreams))
+	}
+}
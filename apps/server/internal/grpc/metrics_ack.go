@@ -0,0 +1,56 @@
+package grpc
+
+import (
+	"time"
+
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+)
+
+// MetricsAckCapability is advertised in AgentInit by agents that were
+// granted per-message metrics acks at authentication
+const MetricsAckCapability = "metrics_ack"
+
+// send writes a response to the agent's stream. gRPC streams do not allow
+// concurrent sends, and commands, heartbeat acks and metrics acks are sent
+// from different goroutines.
+func (a *GrpcAgent) send(resp *pb.MetricsStreamResponse) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stream.Send(resp)
+}
+
+// ackMetrics acknowledges a sequenced metrics message for agents in ack
+// mode. Heartbeats, command results and unsequenced messages are not acked.
+func (s *Server) ackMetrics(agent *GrpcAgent, msg *pb.MetricsStreamRequest) {
+	if !agent.metricsAck || msg.Sequence == 0 {
+		return
+	}
+	switch msg.GetRequest().(type) {
+	case *pb.MetricsStreamRequest_Metrics, *pb.MetricsStreamRequest_Realtime,
+		*pb.MetricsStreamRequest_StaticInfo, *pb.MetricsStreamRequest_Periodic:
+	default:
+		return
+	}
+
+	ack := &pb.MetricsStreamResponse{
+		Response: &pb.MetricsStreamResponse_MetricsAck{
+			MetricsAck: &pb.MetricsAck{
+				Success:   true,
+				Timestamp: uint64(time.Now().UnixMilli()),
+				Sequence:  msg.Sequence,
+			},
+		},
+	}
+	if err := agent.send(ack); err != nil {
+		s.logger.Warnf("Failed to ack metrics seq %d to %s: %v", msg.Sequence, agent.Hostname, err)
+	}
+}
+
+func hasCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"go.uber.org/zap"
+)
+
+// fakeMetricsStream replays queued agent messages and records what the
+// server sends back
+type fakeMetricsStream struct {
+	pb.NanoLinkService_StreamMetricsServer
+	recv chan *pb.MetricsStreamRequest
+	ctx  context.Context
+	mu   sync.Mutex
+	sent []*pb.MetricsStreamResponse
+}
+
+func (f *fakeMetricsStream) Context() context.Context {
+	if f.ctx != nil {
+		return f.ctx
+	}
+	return context.Background()
+}
+
+func (f *fakeMetricsStream) Recv() (*pb.MetricsStreamRequest, error) {
+	msg, ok := <-f.recv
+	if !ok {
+		return nil, io.EOF
+	}
+	return msg, nil
+}
+
+func (f *fakeMetricsStream) Send(resp *pb.MetricsStreamResponse) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, resp)
+	return nil
+}
+
+func (f *fakeMetricsStream) ackedSequences() []uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var seqs []uint64
+	for _, resp := range f.sent {
+		if ack := resp.GetMetricsAck(); ack != nil {
+			seqs = append(seqs, ack.Sequence)
+		}
+	}
+	return seqs
+}
+
+// runStream feeds messages through StreamMetrics until the agent hangs up
+func runStream(t *testing.T, capabilities []string, msgs ...*pb.MetricsStreamRequest) *fakeMetricsStream {
+	t.Helper()
+	logger := zap.NewNop().Sugar()
+	metrics := service.NewMetricsService(logger)
+	s := NewServer(config.Default(), service.NewAgentService(logger, metrics), metrics, logger)
+
+	stream := &fakeMetricsStream{recv: make(chan *pb.MetricsStreamRequest, len(msgs)+1)}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_AgentInit{
+		AgentInit: &pb.AgentInit{AgentId: "agent-1", Hostname: "web-1", Capabilities: capabilities},
+	}}
+	for _, msg := range msgs {
+		stream.recv <- msg
+	}
+	close(stream.recv)
+
+	if err := s.StreamMetrics(stream); err != nil {
+		t.Fatalf("StreamMetrics failed: %v", err)
+	}
+	return stream
+}
+
+func sequencedMessages() []*pb.MetricsStreamRequest {
+	return []*pb.MetricsStreamRequest{
+		{Sequence: 1, Request: &pb.MetricsStreamRequest_Realtime{Realtime: &pb.RealtimeMetrics{CpuUsagePercent: 10}}},
+		{Request: &pb.MetricsStreamRequest_Heartbeat{Heartbeat: &pb.Heartbeat{}}},
+		{Sequence: 2, Request: &pb.MetricsStreamRequest_StaticInfo{StaticInfo: &pb.StaticInfo{}}},
+		{Sequence: 3, Request: &pb.MetricsStreamRequest_Periodic{Periodic: &pb.PeriodicData{}}},
+		{Sequence: 4, Request: &pb.MetricsStreamRequest_Realtime{Realtime: &pb.RealtimeMetrics{CpuUsagePercent: 20}}},
+	}
+}
+
+func TestAckModeAcksEveryMetricsMessage(t *testing.T) {
+	stream := runStream(t, []string{MetricsAckCapability}, sequencedMessages()...)
+
+	got := stream.ackedSequences()
+	want := []uint64{1, 2, 3, 4}
+	if len(got) != len(want) {
+		t.Fatalf("Expected acks for %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected ack %d to reference seq %d, got %d", i, want[i], got[i])
+		}
+	}
+}
+
+func TestNoAcksWithoutAckMode(t *testing.T) {
+	stream := runStream(t, nil, sequencedMessages()...)
+	if got := stream.ackedSequences(); len(got) != 0 {
+		t.Errorf("Expected no acks by default, got %v", got)
+	}
+}
+
+func TestAuthenticateGrantsAckMode(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	cfg := config.Default()
+	s := NewServer(cfg, service.NewAgentService(logger, nil), nil, logger)
+
+	resp, err := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", RequestMetricsAck: true})
+	if err != nil || !resp.Success {
+		t.Fatalf("Authenticate failed: %v %+v", err, resp)
+	}
+	if !resp.MetricsAck {
+		t.Error("Expected ack mode to be granted when requested")
+	}
+
+	cfg.Metrics.AllowAcks = false
+	resp, _ = s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", RequestMetricsAck: true})
+	if resp.MetricsAck {
+		t.Error("Expected ack mode to be refused when disabled by config")
+	}
+}
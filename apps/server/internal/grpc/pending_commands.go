@@ -0,0 +1,165 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultCommandResultTimeout is how long a dispatched command waits for the
+// agent's result when no timeout is configured
+const DefaultCommandResultTimeout = 30 * time.Second
+
+// DefaultMaxCommandResultTimeout bounds per-request timeouts when no maximum
+// is configured
+const DefaultMaxCommandResultTimeout = 5 * time.Minute
+
+// CommandTimeoutMetadata is the gRPC metadata key a caller can set instead
+// of DashboardCommandRequest.timeout_ms, in milliseconds
+const CommandTimeoutMetadata = "x-command-timeout-ms"
+
+// commandResultTimeout returns how long to wait for an agent's result
+func (s *Server) commandResultTimeout() time.Duration {
+	if timeout := time.Duration(s.config.Commands.ResultTimeoutSec) * time.Second; timeout > 0 {
+		return timeout
+	}
+	return DefaultCommandResultTimeout
+}
+
+// maxCommandResultTimeout returns the longest wait a request may ask for
+func (s *Server) maxCommandResultTimeout() time.Duration {
+	if max := time.Duration(s.config.Commands.MaxResultTimeoutSec) * time.Second; max > 0 {
+		return max
+	}
+	return DefaultMaxCommandResultTimeout
+}
+
+// requestedResultTimeout returns how long to wait for the result of a
+// dashboard command: the request's timeout_ms, else the timeout metadata,
+// else the configured default. Overrides above the maximum are rejected.
+func (s *Server) requestedResultTimeout(ctx context.Context, req *pb.DashboardCommandRequest) (time.Duration, error) {
+	override := time.Duration(req.TimeoutMs) * time.Millisecond
+	if override == 0 {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(CommandTimeoutMetadata); len(values) > 0 {
+				ms, err := strconv.ParseUint(values[0], 10, 32)
+				if err != nil {
+					return 0, status.Errorf(codes.InvalidArgument, "invalid %s: %q", CommandTimeoutMetadata, values[0])
+				}
+				override = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	if override == 0 {
+		return s.commandResultTimeout(), nil
+	}
+	if max := s.maxCommandResultTimeout(); override > max {
+		return 0, status.Errorf(codes.InvalidArgument, "command timeout %s exceeds the maximum of %s", override, max)
+	}
+	return override, nil
+}
+
+// registerPendingCommand opens the channel the agent's result for a
+// command is delivered on. It holds one result, so a result arriving
+// before anyone waits is not lost.
+func (s *Server) registerPendingCommand(agentID, commandID string) chan *pb.CommandResult {
+	ch := make(chan *pb.CommandResult, 1)
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if s.pendingCommands == nil {
+		s.pendingCommands = make(map[string]chan *pb.CommandResult)
+		s.pendingCommandAgents = make(map[string]string)
+	}
+	s.pendingCommands[commandID] = ch
+	s.pendingCommandAgents[commandID] = agentID
+	return ch
+}
+
+// resolvePendingCommand delivers a result to the command it answers. Results
+// are only accepted from the agent the command was sent to.
+func (s *Server) resolvePendingCommand(agentID string, result *pb.CommandResult) bool {
+	s.pendingMu.Lock()
+	ch, ok := s.pendingCommands[result.CommandId]
+	if !ok || s.pendingCommandAgents[result.CommandId] != agentID {
+		s.pendingMu.Unlock()
+		return false
+	}
+	delete(s.pendingCommands, result.CommandId)
+	delete(s.pendingCommandAgents, result.CommandId)
+	s.pendingMu.Unlock()
+
+	ch <- result
+	return true
+}
+
+// cancelPendingCommand stops waiting for a command's result
+func (s *Server) cancelPendingCommand(commandID string) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	delete(s.pendingCommands, commandID)
+	delete(s.pendingCommandAgents, commandID)
+}
+
+// failPendingCommands resolves every command still waiting on an agent as
+// failed, once the agent disconnects
+func (s *Server) failPendingCommands(agentID string) {
+	s.pendingMu.Lock()
+	var failed []chan *pb.CommandResult
+	var ids []string
+	for commandID, owner := range s.pendingCommandAgents {
+		if owner != agentID {
+			continue
+		}
+		failed = append(failed, s.pendingCommands[commandID])
+		ids = append(ids, commandID)
+		delete(s.pendingCommands, commandID)
+		delete(s.pendingCommandAgents, commandID)
+	}
+	s.pendingMu.Unlock()
+
+	const reason = "agent disconnected before returning a result"
+	for i, ch := range failed {
+		s.auditCompletion(agentID, ids[i], false, reason, "")
+		ch <- &pb.CommandResult{
+			CommandId: ids[i],
+			Success:   false,
+			Error:     reason,
+		}
+	}
+}
+
+// awaitCommandResult waits for the result of a dispatched command until
+// timeout or ctx ends, whichever is first
+func (s *Server) awaitCommandResult(ctx context.Context, agentID, commandID string, ch <-chan *pb.CommandResult, timeout time.Duration) *pb.CommandResult {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-ch:
+		return result
+	case <-timer.C:
+		s.cancelPendingCommand(commandID)
+		result := &pb.CommandResult{
+			CommandId: commandID,
+			Success:   false,
+			Error:     fmt.Sprintf("timed out waiting for agent after %s", timeout),
+			TimedOut:  true,
+		}
+		// A result arriving later still updates the audit record
+		s.auditCompletion(agentID, commandID, false, result.Error, "")
+		return result
+	case <-ctx.Done():
+		s.cancelPendingCommand(commandID)
+		return &pb.CommandResult{
+			CommandId: commandID,
+			Success:   false,
+			Error:     ctx.Err().Error(),
+		}
+	}
+}
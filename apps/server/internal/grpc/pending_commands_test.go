@@ -0,0 +1,230 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// startStream connects agent-1 through StreamMetrics and leaves the stream
+// open until recv is closed
+func startStream(t *testing.T) (*Server, *fakeMetricsStream, <-chan struct{}) {
+	t.Helper()
+	logger := zap.NewNop().Sugar()
+	metrics := service.NewMetricsService(logger)
+	s := NewServer(config.Default(), service.NewAgentService(logger, metrics), metrics, logger)
+
+	stream := &fakeMetricsStream{recv: make(chan *pb.MetricsStreamRequest, 4)}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_AgentInit{
+		AgentInit: &pb.AgentInit{AgentId: "agent-1", Hostname: "web-1"},
+	}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.StreamMetrics(stream)
+	}()
+	waitFor(t, func() bool { return s.GetAgent("agent-1") != nil })
+	return s, stream, done
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (f *fakeMetricsStream) sentCommand() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, resp := range f.sent {
+		if resp.GetCommand() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func sendCommandAsync(s *Server, ctx context.Context) <-chan *pb.CommandResult {
+	return sendCommandWithTimeout(s, ctx, 0)
+}
+
+func sendCommandWithTimeout(s *Server, ctx context.Context, timeoutMs uint32) <-chan *pb.CommandResult {
+	results := make(chan *pb.CommandResult, 1)
+	go func() {
+		result, _ := s.SendCommand(ctx, &pb.DashboardCommandRequest{
+			AgentId:   "agent-1",
+			Command:   &pb.Command{CommandId: "cmd-1", Type: pb.CommandType_SERVICE_STATUS, Target: "nginx"},
+			TimeoutMs: timeoutMs,
+		})
+		results <- result
+	}()
+	return results
+}
+
+func TestSendCommandReturnsAgentResult(t *testing.T) {
+	s, stream, done := startStream(t)
+	results := sendCommandAsync(s, context.Background())
+	waitFor(t, stream.sentCommand)
+
+	// A result for the command from another agent is ignored
+	if s.resolvePendingCommand("agent-2", &pb.CommandResult{CommandId: "cmd-1", Success: true}) {
+		t.Error("Expected a result from another agent to be rejected")
+	}
+
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_CommandResult{
+		CommandResult: &pb.CommandResult{CommandId: "cmd-1", Success: true, Output: "active (running)"},
+	}}
+	select {
+	case result := <-results:
+		if !result.Success || result.Output != "active (running)" {
+			t.Errorf("Expected the agent's result, got %+v", result)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected SendCommand to return the agent's result")
+	}
+
+	close(stream.recv)
+	<-done
+	if len(s.pendingCommands) != 0 {
+		t.Errorf("Expected no pending commands left, got %d", len(s.pendingCommands))
+	}
+}
+
+func TestSendCommandFailsOnDisconnect(t *testing.T) {
+	s, stream, done := startStream(t)
+	results := sendCommandAsync(s, context.Background())
+	waitFor(t, stream.sentCommand)
+
+	close(stream.recv)
+	<-done
+	select {
+	case result := <-results:
+		if result.Success || !strings.Contains(result.Error, "disconnected") {
+			t.Errorf("Expected a disconnect failure, got %+v", result)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected SendCommand to return once the agent disconnected")
+	}
+	if len(s.pendingCommands) != 0 {
+		t.Errorf("Expected no pending commands left, got %d", len(s.pendingCommands))
+	}
+}
+
+func TestSendCommandGivesUpWaiting(t *testing.T) {
+	s, stream, done := startStream(t)
+	defer func() {
+		close(stream.recv)
+		<-done
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	result := <-sendCommandAsync(s, ctx)
+	if result.Success || result.Error == "" {
+		t.Errorf("Expected a failure when the wait ends, got %+v", result)
+	}
+
+	s.pendingMu.Lock()
+	pending := len(s.pendingCommands)
+	s.pendingMu.Unlock()
+	if pending != 0 {
+		t.Errorf("Expected the pending command to be removed, got %d", pending)
+	}
+
+	s.config.Commands.ResultTimeoutSec = 0
+	if timeout := s.commandResultTimeout(); timeout != DefaultCommandResultTimeout {
+		t.Errorf("Expected the default timeout, got %s", timeout)
+	}
+}
+
+func TestSendCommandTimeoutOverride(t *testing.T) {
+	s, stream, done := startStream(t)
+	defer func() {
+		close(stream.recv)
+		<-done
+	}()
+
+	// The default of 30s would outlast the test; the request asks for 50ms
+	start := time.Now()
+	var result *pb.CommandResult
+	select {
+	case result = <-sendCommandWithTimeout(s, context.Background(), 50):
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the per-request timeout to fire")
+	}
+	if !result.TimedOut || result.Success || !strings.Contains(result.Error, "timed out waiting for agent") {
+		t.Errorf("Expected a timed out result, got %+v", result)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected to wait the requested 50ms, returned after %s", elapsed)
+	}
+
+	s.pendingMu.Lock()
+	pending := len(s.pendingCommands)
+	s.pendingMu.Unlock()
+	if pending != 0 {
+		t.Errorf("Expected the pending command to be cleaned up, got %d", pending)
+	}
+
+	// A late result for the timed out command is dropped
+	if s.resolvePendingCommand("agent-1", &pb.CommandResult{CommandId: "cmd-1", Success: true}) {
+		t.Error("Expected a late result to find no pending command")
+	}
+}
+
+func TestRequestedResultTimeout(t *testing.T) {
+	s, stream, done := startStream(t)
+	defer func() {
+		close(stream.recv)
+		<-done
+	}()
+	s.config.Commands.ResultTimeoutSec = 45
+	s.config.Commands.MaxResultTimeoutSec = 60
+
+	timeout, err := s.requestedResultTimeout(context.Background(), &pb.DashboardCommandRequest{})
+	if err != nil || timeout != 45*time.Second {
+		t.Errorf("Expected the configured default of 45s, got %s (%v)", timeout, err)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(CommandTimeoutMetadata, "1500"))
+	timeout, err = s.requestedResultTimeout(ctx, &pb.DashboardCommandRequest{})
+	if err != nil || timeout != 1500*time.Millisecond {
+		t.Errorf("Expected the metadata override of 1.5s, got %s (%v)", timeout, err)
+	}
+	// The request field takes precedence over metadata
+	timeout, _ = s.requestedResultTimeout(ctx, &pb.DashboardCommandRequest{TimeoutMs: 2000})
+	if timeout != 2*time.Second {
+		t.Errorf("Expected the request field to win, got %s", timeout)
+	}
+
+	// Overrides above the maximum are rejected before anything is sent
+	_, err = s.SendCommand(context.Background(), &pb.DashboardCommandRequest{
+		AgentId:   "agent-1",
+		Command:   &pb.Command{CommandId: "cmd-2", Type: pb.CommandType_SERVICE_STATUS},
+		TimeoutMs: 61000,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for a timeout above the maximum, got %v", err)
+	}
+	if stream.sentCommand() {
+		t.Error("Expected the command not to be sent")
+	}
+	bad := metadata.NewIncomingContext(context.Background(), metadata.Pairs(CommandTimeoutMetadata, "soon"))
+	if _, err := s.requestedResultTimeout(bad, &pb.DashboardCommandRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for a malformed timeout, got %v", err)
+	}
+}
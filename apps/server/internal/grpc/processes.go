@@ -0,0 +1,69 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/google/uuid"
+)
+
+// Process listing errors
+var (
+	ErrAgentNotConnected = errors.New("agent not connected to this server")
+	ErrProcessListDenied = errors.New("agent permission level does not allow listing processes")
+	ErrProcessListFailed = errors.New("process listing failed")
+)
+
+// ListProcesses asks an agent for its process list and waits for the
+// result, which becomes the agent's latest process snapshot
+func (s *Server) ListProcesses(ctx context.Context, agentID string, actor service.AuditActor) (*service.ProcessSnapshot, error) {
+	agent := s.GetAgent(agentID)
+	if agent == nil {
+		return nil, fmt.Errorf("%w: %s", ErrAgentNotConnected, agentID)
+	}
+
+	cmd := &pb.Command{CommandId: uuid.NewString(), Type: pb.CommandType_PROCESS_LIST}
+	results := s.registerPendingCommand(agentID, cmd.CommandId)
+	s.beginCommand(agent, cmd, actor)
+	select {
+	case agent.commandChan <- cmd:
+	default:
+		s.cancelPendingCommand(cmd.CommandId)
+		s.discardCommand(agentID, cmd, "Command channel full")
+		return nil, fmt.Errorf("command channel full for agent: %s", agentID)
+	}
+
+	result := s.awaitCommandResult(ctx, agentID, cmd.CommandId, results, s.commandResultTimeout())
+	if !result.Success {
+		// The agent checks the command against its permission level
+		if strings.HasPrefix(result.Error, "Permission denied") {
+			return nil, fmt.Errorf("%w: %s", ErrProcessListDenied, result.Error)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrProcessListFailed, result.Error)
+	}
+	// A non-empty list was stored as the result arrived
+	if snapshot := s.metricsService.GetProcesses(agentID); snapshot != nil && len(result.Processes) > 0 {
+		return snapshot, nil
+	}
+	return s.metricsService.StoreProcesses(agentID, convertProcesses(result.Processes)), nil
+}
+
+// convertProcesses converts an agent's process list to the service type
+func convertProcesses(processes []*pb.ProcessInfo) []service.ProcessInfo {
+	out := make([]service.ProcessInfo, 0, len(processes))
+	for _, p := range processes {
+		out = append(out, service.ProcessInfo{
+			PID:         p.Pid,
+			Name:        p.Name,
+			User:        p.User,
+			CPUPercent:  p.CpuPercent,
+			MemoryBytes: p.MemoryBytes,
+			Status:      p.Status,
+		})
+	}
+	return out
+}
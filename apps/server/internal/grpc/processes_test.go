@@ -0,0 +1,102 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+)
+
+// lastCommandID returns the ID of the last command sent on the stream
+func (f *fakeMetricsStream) lastCommandID() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.sent) - 1; i >= 0; i-- {
+		if cmd := f.sent[i].GetCommand(); cmd != nil {
+			return cmd.CommandId
+		}
+	}
+	return ""
+}
+
+type processListResult struct {
+	snapshot *service.ProcessSnapshot
+	err      error
+}
+
+func listProcessesAsync(s *Server) <-chan processListResult {
+	results := make(chan processListResult, 1)
+	go func() {
+		snapshot, err := s.ListProcesses(context.Background(), "agent-1", service.AuditActor{})
+		results <- processListResult{snapshot, err}
+	}()
+	return results
+}
+
+func TestListProcessesStoresAgentResult(t *testing.T) {
+	s, stream, done := startStream(t)
+	defer func() {
+		close(stream.recv)
+		<-done
+	}()
+
+	results := listProcessesAsync(s)
+	waitFor(t, stream.sentCommand)
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_CommandResult{
+		CommandResult: &pb.CommandResult{CommandId: stream.lastCommandID(), Success: true, Processes: []*pb.ProcessInfo{
+			{Pid: 1, Name: "systemd", User: "root", CpuPercent: 0.1, MemoryBytes: 12 << 20},
+			{Pid: 812, Name: "postgres", User: "postgres", CpuPercent: 35.5, MemoryBytes: 900 << 20},
+		}},
+	}}
+
+	select {
+	case r := <-results:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if len(r.snapshot.Processes) != 2 || r.snapshot.Processes[1].Name != "postgres" || r.snapshot.Processes[1].User != "postgres" {
+			t.Errorf("Expected the agent's processes, got %+v", r.snapshot.Processes)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected ListProcesses to return the agent's result")
+	}
+	if stored := s.metricsService.GetProcesses("agent-1"); stored == nil || len(stored.Processes) != 2 {
+		t.Errorf("Expected the snapshot to be stored, got %+v", stored)
+	}
+}
+
+func TestListProcessesReportsPermissionDenied(t *testing.T) {
+	s, stream, done := startStream(t)
+	defer func() {
+		close(stream.recv)
+		<-done
+	}()
+
+	results := listProcessesAsync(s)
+	waitFor(t, stream.sentCommand)
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_CommandResult{
+		CommandResult: &pb.CommandResult{CommandId: stream.lastCommandID(), Error: "Permission denied. Required level: 1, your level: 0"},
+	}}
+
+	select {
+	case r := <-results:
+		if !errors.Is(r.err, ErrProcessListDenied) {
+			t.Errorf("Expected ErrProcessListDenied, got %v", r.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected ListProcesses to return the agent's refusal")
+	}
+	if s.metricsService.GetProcesses("agent-1") != nil {
+		t.Error("Expected no snapshot to be stored")
+	}
+}
+
+func TestListProcessesForUnknownAgent(t *testing.T) {
+	s := newPolicyServer(t, UnknownAgentRegister)
+	if _, err := s.ListProcesses(context.Background(), "missing", service.AuditActor{}); !errors.Is(err, ErrAgentNotConnected) {
+		t.Errorf("Expected ErrAgentNotConnected, got %v", err)
+	}
+}
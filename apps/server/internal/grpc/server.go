@@ -2,10 +2,11 @@ package grpc
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
-	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/chenqi92/NanoLink/apps/server/internal/config"
@@ -14,8 +15,12 @@ import (
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 // GrpcAgent represents a connected agent via gRPC
@@ -28,8 +33,21 @@ type GrpcAgent struct {
 	PermissionLevel int32
 	ConnectedAt     time.Time
 	LastMetricsAt   time.Time
+	SourceIP        string
+	Capabilities    []string
+	Labels          map[string]string
+	CommandPolicy   *pb.CommandPolicy // Shell policy the agent reported, nil if none
 	stream          pb.NanoLinkService_StreamMetricsServer
+	metricsAck      bool   // Acks each sequenced metrics message
+	sessionToken    string // Resumable session the stream was started with
 	commandChan     chan *pb.Command
+	closeStream     context.CancelFunc // Ends the stream from the server side
+	tokenID         uint               // Stored agent token the stream authenticated with, 0 for config tokens
+	clientCert      *x509.Certificate  // Verified certificate the agent connected with, if any
+	volatileID      bool               // The server generated the ID, so it changes on reconnect
+	registered      *service.Agent     // AgentService entry of this stream, guarded by mu
+	metricsLimit    *ingestBucket      // Caps full metrics messages
+	realtimeLimit   *ingestBucket      // Caps realtime messages
 	mu              sync.Mutex
 }
 
@@ -55,6 +73,60 @@ type Server struct {
 
 	// Command result handler for shell sessions
 	commandResultHandler func(agentID, commandID, output string, success bool)
+
+	// Advises capable agents on slow links to send delta realtime metrics
+	realtimeAdvisor *service.RealtimeModeAdvisor
+
+	// Records dispatched commands and their results
+	commandTracker *service.CommandTracker
+	auditService   *service.AuditService
+
+	// Commands dispatched to agents since startup
+	dispatched atomic.Int64
+
+	// Delivers commands for agents connected to other server instances
+	commandForwarder CommandForwarder
+
+	// How metrics from unregistered agents are handled
+	unknownAgentPolicy string
+
+	// Caps the agent streams held by each source IP
+	connLimit connLimiter
+
+	// Recently received metrics per agent, served by SyncMetrics
+	syncBuffer syncBuffer
+
+	// Resumable agent sessions issued by Authenticate
+	sessions *SessionStore
+
+	// Revoked agent client certificates, when a CRL is configured
+	crl *crlChecker
+
+	// Server-wide events streamed to admins
+	serverEvents *service.ServerEventBus
+
+	// Incident webhooks told about agents connecting and disconnecting
+	webhooks *service.WebhookNotifier
+
+	// Dashboard commands must match a template before reaching an agent
+	commandTemplates *service.CommandTemplates
+
+	// Records agents authenticating with tokens issued by the server
+	agentTokens *service.AgentTokenService
+
+	// Dispatched commands waiting for the agent's result, by command ID,
+	// and the agent each was sent to
+	pendingCommands      map[string]chan *pb.CommandResult
+	pendingCommandAgents map[string]string
+	pendingMu            sync.Mutex
+
+	// Log files being streamed from agents, by stream ID
+	logStreams   map[string]*LogStream
+	logStreamsMu sync.Mutex
+
+	// Shell commands whose output is streamed back, by command ID
+	shellCommands map[string]*ShellCommand
+	shellMu       sync.Mutex
 }
 
 // NewServer creates a new gRPC server (without auth interceptor for backward compatibility)
@@ -71,6 +143,7 @@ func NewServer(
 		logger:             logger,
 		agents:             make(map[string]*GrpcAgent),
 		metricsSubscribers: make(map[string][]chan *pb.Metrics),
+		sessions:           NewSessionStore(time.Duration(cfg.Server.AgentSessionTTLSec)*time.Second, time.Duration(cfg.Server.AgentSessionMaxLifetimeSec)*time.Second),
 	}
 }
 
@@ -90,11 +163,16 @@ func NewServerWithAuth(
 		authInterceptor:    authInterceptor,
 		agents:             make(map[string]*GrpcAgent),
 		metricsSubscribers: make(map[string][]chan *pb.Metrics),
+		sessions:           NewSessionStore(time.Duration(cfg.Server.AgentSessionTTLSec)*time.Second, time.Duration(cfg.Server.AgentSessionMaxLifetimeSec)*time.Second),
 	}
 }
 
 // Start starts the gRPC server
 func (s *Server) Start(port int, tlsCert, tlsKey string) error {
+	if err := setCompressionLevel(s.config.Server.GRPCCompressionLevel); err != nil {
+		return err
+	}
+
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return fmt.Errorf("failed to listen: %w", err)
@@ -102,13 +180,22 @@ func (s *Server) Start(port int, tlsCert, tlsKey string) error {
 
 	var opts []grpc.ServerOption
 
-	// Configure TLS if provided
+	// Configure TLS if provided, optionally verifying agent certificates
+	clientCAFile, requireClientCert := s.config.Server.ClientCAFile, s.config.Server.RequireClientCert
 	if tlsCert != "" && tlsKey != "" {
-		creds, err := credentials.NewServerTLSFromFile(tlsCert, tlsKey)
+		tlsConfig, err := serverTLSConfig(tlsCert, tlsKey, clientCAFile, requireClientCert)
 		if err != nil {
 			return fmt.Errorf("failed to load TLS credentials: %w", err)
 		}
-		opts = append(opts, grpc.Creds(creds))
+		if crlFile := s.config.Server.ClientCRLFile; crlFile != "" {
+			if s.crl, err = newCRLChecker(crlFile, clientCAFile); err != nil {
+				return err
+			}
+			tlsConfig.VerifyPeerCertificate = s.crl.verifyPeer
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	} else if requireClientCert || clientCAFile != "" || s.config.Server.ClientCRLFile != "" {
+		return fmt.Errorf("client certificates need tls_cert and tls_key")
 	}
 
 	// Configure keepalive
@@ -147,46 +234,175 @@ func (s *Server) Stop() {
 	}
 }
 
+// Shutdown stops the gRPC server gracefully, closing connections that are
+// still open, such as agent metrics streams, once ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.grpcServer == nil {
+		return nil
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.grpcServer.Stop()
+		<-stopped
+		return nil
+	}
+}
+
 // SetCommandResultHandler sets the callback for handling command results from agents
 func (s *Server) SetCommandResultHandler(handler func(agentID, commandID, output string, success bool)) {
 	s.commandResultHandler = handler
 }
 
+// SetRealtimeModeAdvisor enables realtime reporting mode advice for agents
+// that advertise delta support
+func (s *Server) SetRealtimeModeAdvisor(advisor *service.RealtimeModeAdvisor) {
+	s.realtimeAdvisor = advisor
+}
+
+// SetCommandTracker enables recording of dispatched commands
+func (s *Server) SetCommandTracker(tracker *service.CommandTracker) {
+	s.commandTracker = tracker
+}
+
+// SetWebhookNotifier sets the notifier told about agents connecting and
+// disconnecting
+func (s *Server) SetWebhookNotifier(notifier *service.WebhookNotifier) {
+	s.webhooks = notifier
+}
+
+// SetAgentTokens records use of tokens issued by the server
+func (s *Server) SetAgentTokens(tokens *service.AgentTokenService) {
+	s.agentTokens = tokens
+}
+
+// SetCommandTemplates rejects dashboard commands that do not match a
+// registered template
+func (s *Server) SetCommandTemplates(templates *service.CommandTemplates) {
+	s.commandTemplates = templates
+}
+
+// SetServerEvents sets the bus streamed by WatchServerEvents
+func (s *Server) SetServerEvents(bus *service.ServerEventBus) {
+	s.serverEvents = bus
+}
+
 // ============== NanoLinkService Implementation ==============
 
 // Authenticate handles agent authentication
 func (s *Server) Authenticate(ctx context.Context, req *pb.AuthRequest) (*pb.AuthResponse, error) {
 	s.logger.Infof("gRPC authentication request from %s", req.Hostname)
 
-	// Validate token
-	valid, permissionLevel := s.config.ValidateToken(req.Token)
-	if !valid {
-		s.logger.Warnf("Authentication failed for %s: invalid token", req.Hostname)
-		return &pb.AuthResponse{
-			Success:      false,
-			ErrorMessage: "Invalid authentication token",
-		}, nil
+	metricsAck := req.RequestMetricsAck && s.config.Metrics.AllowAcks
+
+	if min := s.config.Auth.MinAgentVersion; min != "" && agentVersionBelow(req.AgentVersion, min) {
+		s.logger.Warnf("Authentication failed for %s: agent version %q is older than %s", req.Hostname, req.AgentVersion, min)
+		return authFailure(pb.AuthFailureReason_AUTH_FAILURE_VERSION_TOO_OLD,
+			fmt.Sprintf("Agent version %q is older than the required %s", req.AgentVersion, min), false, 0), nil
+	}
+
+	// An agent with a client certificate may only claim the hostname it was issued for
+	if cert := clientCertificate(ctx); cert != nil {
+		if err := s.checkClientCert(cert, req.Hostname); err != nil {
+			s.logger.Warnf("Authentication failed for %s: %v", req.Hostname, err)
+			return authFailure(pb.AuthFailureReason_AUTH_FAILURE_INVALID_TOKEN,
+				"Client certificate does not match hostname or has been revoked", false, 0), nil
+		}
+		s.logger.Infof("Agent %s presented client certificate %s", req.Hostname, certIdentity(cert))
+	}
+
+	// Checked before session resumes too, so sessions cannot bypass the limit
+	if ip := peerIP(ctx); s.connLimit.full(ip) {
+		s.logger.Warnf("Authentication failed for %s: too many connections from %s", req.Hostname, ip)
+		return authFailure(pb.AuthFailureReason_AUTH_FAILURE_TOO_MANY_CONNECTIONS,
+			"Too many connections from this address", true, 0), nil
+	}
+
+	// A live session lets a reconnecting agent skip the token and keep its identity
+	if req.SessionToken != "" {
+		if agentID, level, expiresAt, ok := s.sessions.Resume(req.SessionToken); ok {
+			if deniedFor, denied := s.agentService.DeniedFor(agentID); denied {
+				s.logger.Warnf("Authentication failed for %s: agent %s is denied for %v", req.Hostname, agentID, deniedFor)
+				return authFailure(pb.AuthFailureReason_AUTH_FAILURE_AGENT_DENIED,
+					"Agent is temporarily denied", true, deniedFor), nil
+			}
+			if req.CommandPolicy != nil {
+				s.sessions.SetCommandPolicy(req.SessionToken, req.CommandPolicy)
+			}
+			s.logger.Infof("Agent %s resumed session (agent ID %s)", req.Hostname, agentID)
+			return &pb.AuthResponse{
+				Success:          true,
+				PermissionLevel:  int32(level),
+				MetricsAck:       metricsAck,
+				SessionToken:     req.SessionToken,
+				SessionExpiresAt: uint64(expiresAt.UnixMilli()),
+				AgentId:          agentID,
+			}, nil
+		}
+		if req.Token == "" {
+			s.logger.Warnf("Authentication failed for %s: session expired", req.Hostname)
+			return authFailure(pb.AuthFailureReason_AUTH_FAILURE_SESSION_EXPIRED,
+				"Session expired or unknown, authenticate with a token", true, 0), nil
+		}
+		s.logger.Infof("Session for %s expired, authenticating with token", req.Hostname)
+	}
+
+	// Validate token, including its expiry and hostname/label restrictions
+	matched, err := s.config.ValidateAgentToken(req.Token, config.TokenScope{Hostname: req.Hostname, Labels: req.Labels})
+	if err != nil {
+		s.logger.Warnf("Authentication failed for %s: %v", req.Hostname, err)
+		return s.invalidTokenFailure(err), nil
+	}
+	permissionLevel := matched.Permission
+	if matched.ID != 0 && s.agentTokens != nil {
+		s.agentTokens.RecordUse(matched.ID, req.Hostname)
 	}
 
 	s.logger.Infof("Agent %s authenticated with permission level %d", req.Hostname, permissionLevel)
 
-	return &pb.AuthResponse{
+	resp := &pb.AuthResponse{
 		Success:         true,
 		PermissionLevel: int32(permissionLevel),
-	}, nil
+		MetricsAck:      metricsAck,
+	}
+	token, expiresAt, err := s.sessions.Issue(matched, req.Labels, req.CommandPolicy)
+	if err != nil {
+		// Agents still work without a session, they just cannot resume one
+		s.logger.Warnf("Failed to issue session for %s: %v", req.Hostname, err)
+		return resp, nil
+	}
+	resp.SessionToken = token
+	resp.SessionExpiresAt = uint64(expiresAt.UnixMilli())
+	return resp, nil
 }
 
 // StreamMetrics handles bidirectional streaming for metrics and commands
 func (s *Server) StreamMetrics(stream pb.NanoLinkService_StreamMetricsServer) error {
 	s.logger.Info("StreamMetrics: New connection started")
 
+	sourceIP := peerIP(stream.Context())
+	if !s.connLimit.acquire(sourceIP) {
+		s.logger.Warnf("StreamMetrics: Rejecting connection from %s: too many connections", sourceIP)
+		return status.Errorf(codes.ResourceExhausted, "too many connections from %s", sourceIP)
+	}
+	defer s.connLimit.release(sourceIP)
+
 	// Will be populated from AgentInit or generated if old agent
 	var agentID string
 
 	agent := &GrpcAgent{
-		ConnectedAt: time.Now(),
-		stream:      stream,
-		commandChan: make(chan *pb.Command, 10),
+		ConnectedAt:   time.Now(),
+		SourceIP:      sourceIP,
+		stream:        stream,
+		commandChan:   make(chan *pb.Command, 10),
+		metricsLimit:  newIngestBucket(s.config.Metrics.MaxMetricsPerSec),
+		realtimeLimit: newIngestBucket(s.config.Metrics.MaxRealtimePerSec),
 	}
 	// Send immediate HeartbeatAck to prevent client-side timeout
 	// (Some clients have RPC timeout that kills the stream if no response is received)
@@ -229,6 +445,7 @@ func (s *Server) StreamMetrics(stream pb.NanoLinkService_StreamMetricsServer) er
 		agent.OS = req.AgentInit.Os
 		agent.Arch = req.AgentInit.Arch
 		agent.Version = req.AgentInit.AgentVersion
+		agent.Capabilities = req.AgentInit.Capabilities
 	case *pb.MetricsStreamRequest_Metrics:
 		// Legacy: old agent without AgentInit support
 		agentID = uuid.New().String()
@@ -256,7 +473,61 @@ func (s *Server) StreamMetrics(stream pb.NanoLinkService_StreamMetricsServer) er
 		s.logger.Warnf("StreamMetrics: Unknown first message type, generated new ID: %s", agentID)
 	}
 
+	// A valid session restores the agent ID and permission level it was
+	// issued for. It is only bound once the stream has been admitted.
+	if token := firstMsg.GetAgentInit().GetSessionToken(); token != "" {
+		if session, ok := s.sessions.Lookup(token); ok {
+			if session.AgentID != "" && session.AgentID != agentID {
+				s.logger.Infof("StreamMetrics: Session restores agent ID %s (agent sent %q)", session.AgentID, agentID)
+				agentID = session.AgentID
+			}
+			agent.PermissionLevel = int32(session.PermissionLevel)
+			agent.tokenID = session.TokenID
+			agent.sessionToken = token
+			agent.Labels = session.Labels
+			agent.CommandPolicy = session.CommandPolicy
+		} else {
+			s.logger.Infof("StreamMetrics: Ignoring expired or unknown session token from %s", agent.Hostname)
+		}
+	}
+
 	agent.AgentID = agentID
+	agent.volatileID = firstMsg.GetAgentInit().GetAgentId() == ""
+	if labels := firstMsg.GetAgentInit().GetLabels(); len(labels) > 0 {
+		agent.Labels = labels
+	}
+	agent.Labels = s.agentLabels(agentID, agent.Labels)
+	agent.metricsAck = s.config.Metrics.AllowAcks && hasCapability(agent.Capabilities, MetricsAckCapability)
+
+	// An agent with a client certificate must name the host it was issued
+	// for up front, and is held to it when it reports a hostname later
+	agent.clientCert = clientCertificate(stream.Context())
+	if err := s.checkClientCert(agent.clientCert, agent.Hostname); err != nil {
+		s.logger.Warnf("StreamMetrics: Rejecting %s (%s): %v", agent.Hostname, agentID, err)
+		return status.Error(codes.PermissionDenied, "client certificate does not match hostname or has been revoked")
+	}
+
+	// Refuse agents that were recently force-disconnected by an admin
+	if s.agentService.IsDenied(agent.identity()) {
+		s.logger.Warnf("StreamMetrics: Rejecting denied agent %s (%s)", agent.Hostname, agentID)
+		return status.Error(codes.PermissionDenied, "agent is temporarily denied")
+	}
+
+	if err := s.authenticateStream(stream.Context(), agent); err != nil {
+		s.logger.Warnf("StreamMetrics: Rejecting %s (%s): %v", agent.Hostname, agentID, err)
+		return err
+	}
+	if agent.sessionToken != "" {
+		if boundID, _, ok := s.sessions.Bind(agent.sessionToken, agentID); !ok || boundID != agentID {
+			s.logger.Warnf("StreamMetrics: Session of %s (%s) ended before the stream started", agent.Hostname, agentID)
+			return status.Error(codes.Unauthenticated, "session expired or revoked")
+		}
+	}
+
+	// Cancelled to end the stream from the server side (forced disconnect)
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	agent.closeStream = cancel
 
 	// Register agent in gRPC server's internal map
 	s.agentsMu.Lock()
@@ -264,35 +535,67 @@ func (s *Server) StreamMetrics(stream pb.NanoLinkService_StreamMetricsServer) er
 	s.agentsMu.Unlock()
 
 	// Also register to AgentService so it appears in dashboard API
-	s.agentService.RegisterGrpcAgent(agentID, service.AgentInfo{
-		Hostname: agent.Hostname,
-		OS:       agent.OS,
-		Arch:     agent.Arch,
-		Version:  agent.Version,
-	}, int(agent.PermissionLevel))
+	s.registerStreamAgent(agent)
 
-	s.logger.Infof("gRPC agent connected: %s (%s)", agent.Hostname, agentID)
+	if s.realtimeAdvisor != nil && s.realtimeAdvisor.Register(agentID, agent.Capabilities) {
+		defer s.realtimeAdvisor.Forget(agentID)
+	}
+
+	if s.config.Security.TrackSourceIP {
+		s.agentService.RecordSourceIP(agentID, agent.SourceIP)
+	}
+	s.agentService.AutoGroup(agentID, agent.SourceIP)
+
+	s.logger.Infof("gRPC agent connected: %s (%s) from %s", agent.Hostname, agentID, agent.SourceIP)
 
 	// Notify subscribers
 	s.notifyAgentEvent(pb.AgentEvent_CONNECTED, agent)
 
 	// Handle disconnection
 	defer func() {
+		// A forced disconnect may already have made way for a reconnect
 		s.agentsMu.Lock()
-		delete(s.agents, agentID)
+		if s.agents[agentID] == agent {
+			delete(s.agents, agentID)
+		}
 		s.agentsMu.Unlock()
 
 		// Unregister from AgentService
-		s.agentService.UnregisterAgent(agentID)
-
+		agent.mu.Lock()
+		registered := agent.registered
+		agent.mu.Unlock()
+		s.agentService.UnregisterConnection(registered)
+		s.failPendingCommands(agentID)
+		s.endAgentLogStreams(agentID)
+		s.syncBuffer.release(agentID, time.Now(), s.syncRetention())
+
+		// Keep undelivered commands for an agent that may resume its session
+		if agent.sessionToken != "" {
+			s.sessions.Suspend(agent.sessionToken, drainCommands(agent.commandChan))
+		}
 		close(agent.commandChan)
 
 		s.logger.Infof("gRPC agent disconnected: %s (%s)", agent.Hostname, agentID)
 		s.notifyAgentEvent(pb.AgentEvent_DISCONNECTED, agent)
 	}()
 
+	// Re-queue commands left undelivered when the previous stream ended
+	if agent.sessionToken != "" {
+		for _, cmd := range s.sessions.TakePending(agent.sessionToken) {
+			select {
+			case agent.commandChan <- cmd:
+			default:
+				s.logger.Warnf("Dropping restored command %s for %s: queue full", cmd.CommandId, agentID)
+				s.discardCommand(agentID, cmd, "restored command dropped: queue full")
+			}
+		}
+	}
+
 	// Process first message
-	s.processStreamMessage(agent, firstMsg)
+	if err := s.processStreamMessage(agent, firstMsg); err != nil {
+		s.recordStreamEnd(agentID, service.StreamEndServerClosed, nil)
+		return err
+	}
 
 	// Start goroutine to send commands
 	go func() {
@@ -302,36 +605,91 @@ func (s *Server) StreamMetrics(stream pb.NanoLinkService_StreamMetricsServer) er
 					Command: cmd,
 				},
 			}
-			if err := stream.Send(resp); err != nil {
+			if err := agent.send(resp); err != nil {
 				s.logger.Errorf("Failed to send command to %s: %v", agent.Hostname, err)
 				return
 			}
 		}
 	}()
 
-	// Receive messages from agent
-	for {
-		msg, err := stream.Recv()
-		if err == io.EOF {
-			return nil
+	// Receive messages from agent in the background so that cancelling
+	// ctx can end the stream even while Recv is blocked
+	msgs := make(chan *pb.MetricsStreamRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+				return
+			}
 		}
-		if err != nil {
-			s.logger.Errorf("Stream error from %s: %v", agent.Hostname, err)
+	}()
+
+	for {
+		select {
+		case msg := <-msgs:
+			if err := s.processStreamMessage(agent, msg); err != nil {
+				s.recordStreamEnd(agentID, service.StreamEndServerClosed, nil)
+				s.logger.Warnf("Closing stream for %s (%s): %v", agent.Hostname, agentID, err)
+				return err
+			}
+		case err := <-recvErr:
+			kind := classifyStreamError(err)
+			if kind == service.StreamEndEOF {
+				s.recordStreamEnd(agentID, kind, nil)
+				return nil
+			}
+			s.recordStreamEnd(agentID, kind, err)
+			s.logger.Errorf("Stream error (%s) from %s: %v", kind, agent.Hostname, err)
 			return err
+		case <-ctx.Done():
+			if err := stream.Context().Err(); err != nil {
+				// Client went away
+				s.recordStreamEnd(agentID, classifyStreamError(err), err)
+				return err
+			}
+			s.recordStreamEnd(agentID, service.StreamEndServerClosed, nil)
+			s.logger.Infof("Stream for %s (%s) closed by server", agent.Hostname, agentID)
+			return status.Error(codes.Aborted, "disconnected by server")
 		}
+	}
+}
 
-		s.processStreamMessage(agent, msg)
+// peerIP returns the source IP of the gRPC peer, or "" if unavailable
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
 	}
+	return service.HostFromAddr(p.Addr.String())
 }
 
-// processStreamMessage processes a message from the stream
-func (s *Server) processStreamMessage(agent *GrpcAgent, msg *pb.MetricsStreamRequest) {
+// processStreamMessage processes a message from the stream, acknowledging
+// metrics it stores to agents in ack mode. An error means the stream must
+// be closed.
+func (s *Server) processStreamMessage(agent *GrpcAgent, msg *pb.MetricsStreamRequest) error {
+	if err := s.admitStreamMetrics(agent, msg); err != nil {
+		return err
+	}
+	if !s.admitIngest(agent, msg) {
+		return nil
+	}
+
 	switch req := msg.GetRequest().(type) {
 	case *pb.MetricsStreamRequest_Metrics:
 		agent.LastMetricsAt = time.Now()
 
 		// Update hostname if not set
 		if agent.Hostname == "" {
+			if err := s.checkClientCert(agent.clientCert, req.Metrics.Hostname); err != nil {
+				return status.Error(codes.PermissionDenied, err.Error())
+			}
 			agent.Hostname = req.Metrics.Hostname
 			osName := ""
 			if req.Metrics.SystemInfo != nil {
@@ -346,25 +704,36 @@ func (s *Server) processStreamMessage(agent *GrpcAgent, msg *pb.MetricsStreamReq
 
 		// Forward to metrics service (convert proto to service format)
 		s.metricsService.StoreMetrics(agent.AgentID, convertProtoMetrics(req.Metrics))
+		s.agentService.ReportFullMetrics(agent.AgentID)
+		s.bufferForSync(agent.AgentID, req.Metrics)
+
+		s.agentService.UpdateCollectorStatus(agent.AgentID, convertCollectorStatus(req.Metrics.CollectorStatus))
 
 		// Notify metrics subscribers
 		s.notifyMetrics(agent.AgentID, req.Metrics)
 
 	case *pb.MetricsStreamRequest_Realtime:
 		agent.LastMetricsAt = time.Now()
+		if s.realtimeAdvisor != nil {
+			s.realtimeAdvisor.ObserveRealtimeSize(agent.AgentID, proto.Size(req.Realtime), req.Realtime.Delta)
+		}
 		// Merge realtime data into current metrics
 		s.metricsService.MergeRealtimeMetrics(agent.AgentID, convertRealtimeMetrics(req.Realtime))
 		// Notify subscribers with updated metrics
-		if current := s.metricsService.GetCurrentMetrics(agent.AgentID); current != nil {
+		if current := s.metricsService.GetCurrentMetrics(agent.AgentID); s.metricsService.Publishable(current) {
 			s.notifyMetrics(agent.AgentID, convertServiceMetrics(current))
 		}
 
 	case *pb.MetricsStreamRequest_StaticInfo:
 		// Merge static info into current metrics
-		s.metricsService.MergeStaticInfo(agent.AgentID, convertStaticInfo(req.StaticInfo))
+		static := convertStaticInfo(req.StaticInfo)
+		s.metricsService.MergeStaticInfo(agent.AgentID, static)
 		// Update agent info from static info
 		if req.StaticInfo.SystemInfo != nil {
 			if agent.Hostname == "" {
+				if err := s.checkClientCert(agent.clientCert, req.StaticInfo.SystemInfo.Hostname); err != nil {
+					return status.Error(codes.PermissionDenied, err.Error())
+				}
 				agent.Hostname = req.StaticInfo.SystemInfo.Hostname
 			}
 			agent.OS = req.StaticInfo.SystemInfo.OsName
@@ -375,27 +744,53 @@ func (s *Server) processStreamMessage(agent *GrpcAgent, msg *pb.MetricsStreamReq
 				OS:       agent.OS,
 			})
 		}
+		s.agentService.ReportStaticInfo(agent.AgentID, static)
 
 	case *pb.MetricsStreamRequest_Periodic:
 		// Merge periodic data into current metrics
 		s.metricsService.MergePeriodicData(agent.AgentID, convertPeriodicData(req.Periodic))
+		s.agentService.UpdateCollectorStatus(agent.AgentID, convertCollectorStatus(req.Periodic.CollectorStatus))
 
 	case *pb.MetricsStreamRequest_Heartbeat:
+		s.agentService.RecordHeartbeat(agent.AgentID, heartbeatTime(req.Heartbeat.Timestamp),
+			time.Duration(req.Heartbeat.RttMs)*time.Millisecond)
+
+		heartbeatAck := &pb.HeartbeatAck{
+			Timestamp: uint64(time.Now().UnixMilli()),
+		}
+		// Piggyback realtime reporting advice for agents that opted in
+		if s.realtimeAdvisor != nil {
+			if req.Heartbeat.RttMs > 0 {
+				s.realtimeAdvisor.ObserveRTT(agent.AgentID, time.Duration(req.Heartbeat.RttMs)*time.Millisecond)
+			}
+			if mode, ok := s.realtimeAdvisor.Advise(agent.AgentID); ok {
+				heartbeatAck.RealtimeMode = pb.RealtimeReportMode(mode)
+			}
+		}
+
 		// Send heartbeat acknowledgment
 		ack := &pb.MetricsStreamResponse{
 			Response: &pb.MetricsStreamResponse_HeartbeatAck{
-				HeartbeatAck: &pb.HeartbeatAck{
-					Timestamp: uint64(time.Now().UnixMilli()),
-				},
+				HeartbeatAck: heartbeatAck,
 			},
 		}
-		if err := agent.stream.Send(ack); err != nil {
+		if err := agent.send(ack); err != nil {
 			s.logger.Errorf("Failed to send heartbeat ack to %s: %v", agent.Hostname, err)
 		}
 
 	case *pb.MetricsStreamRequest_CommandResult:
 		s.logger.Infof("Command result from %s: %s (success=%v)",
 			agent.Hostname, req.CommandResult.CommandId, req.CommandResult.Success)
+		if s.commandTracker != nil {
+			s.commandTracker.Complete(agent.AgentID, req.CommandResult.CommandId, req.CommandResult.Success,
+				req.CommandResult.Error, req.CommandResult.Output)
+		}
+		s.auditCompletion(agent.AgentID, req.CommandResult.CommandId, req.CommandResult.Success,
+			req.CommandResult.Error, req.CommandResult.Output)
+		if len(req.CommandResult.Processes) > 0 {
+			s.metricsService.StoreProcesses(agent.AgentID, convertProcesses(req.CommandResult.Processes))
+		}
+		s.resolvePendingCommand(agent.AgentID, req.CommandResult)
 		// Forward command result to shell session handler
 		if s.commandResultHandler != nil {
 			output := req.CommandResult.Output
@@ -404,7 +799,22 @@ func (s *Server) processStreamMessage(agent *GrpcAgent, msg *pb.MetricsStreamReq
 			}
 			s.commandResultHandler(agent.AgentID, req.CommandResult.CommandId, output, req.CommandResult.Success)
 		}
+
+	case *pb.MetricsStreamRequest_LogChunk:
+		s.deliverLogChunk(agent.AgentID, req.LogChunk)
+
+	case *pb.MetricsStreamRequest_CommandOutput:
+		s.deliverCommandOutput(agent.AgentID, req.CommandOutput)
+
+	case *pb.MetricsStreamRequest_GracefulDisconnect:
+		// Agent is shutting down cleanly; the stream close that follows
+		// must not be reported as a crash
+		s.agentService.MarkGracefulDisconnect(agent.AgentID, req.GracefulDisconnect.Reason)
 	}
+
+	// Only what was admitted and stored is acknowledged
+	s.ackMetrics(agent, msg)
+	return nil
 }
 
 // ReportMetrics handles one-time metrics report
@@ -416,7 +826,7 @@ func (s *Server) ReportMetrics(ctx context.Context, metrics *pb.Metrics) (*pb.Me
 	}
 
 	// Register/update agent in AgentService so it shows in dashboard
-	if existing := s.agentService.GetAgent(agentID); existing == nil {
+	err := s.admitMetrics(agentID, func() {
 		// Register new agent
 		osName := ""
 		arch := ""
@@ -429,13 +839,18 @@ func (s *Server) ReportMetrics(ctx context.Context, metrics *pb.Metrics) (*pb.Me
 			Arch:     arch,
 		}, 3) // Default to system admin permission
 		s.logger.Infof("Agent registered via ReportMetrics: %s", metrics.Hostname)
-	} else {
-		// Update heartbeat for existing agent
-		s.agentService.UpdateHeartbeat(agentID)
+	})
+	if err != nil {
+		s.logger.Warnf("ReportMetrics: rejected metrics from %s: %v", agentID, err)
+		return nil, err
 	}
+	// Update heartbeat for existing agent
+	s.agentService.UpdateHeartbeat(agentID)
 
 	// Record metrics
 	s.metricsService.StoreMetrics(agentID, convertProtoMetrics(metrics))
+	s.agentService.ReportFullMetrics(agentID)
+	s.bufferForSync(agentID, metrics)
 
 	return &pb.MetricsAck{
 		Success:   true,
@@ -456,20 +871,39 @@ func (s *Server) ExecuteCommand(ctx context.Context, cmd *pb.Command) (*pb.Comma
 
 // Heartbeat handles heartbeat requests
 func (s *Server) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	s.agentService.RecordHeartbeat(req.AgentId, heartbeatTime(req.Timestamp),
+		time.Duration(req.RttMs)*time.Millisecond)
 	return &pb.HeartbeatResponse{
 		ServerTimestamp: uint64(time.Now().UnixMilli()),
 		ConfigChanged:   false,
 	}, nil
 }
 
-// SyncMetrics handles metrics synchronization after reconnection
+// heartbeatTime converts an agent's millisecond heartbeat timestamp, which
+// is zero when not set
+func heartbeatTime(ms uint64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(int64(ms))
+}
+
+// SyncMetrics handles metrics synchronization after reconnection. It
+// returns the metrics the server recorded for the agent after its last sync
+// timestamp, and asks the agent to resend its own buffer when the server's
+// buffer does not reach back that far. Agents may only sync their own
+// metrics, proven by a session resumed for them.
 func (s *Server) SyncMetrics(ctx context.Context, req *pb.MetricsSyncRequest) (*pb.MetricsSyncResponse, error) {
-	// Get buffered metrics from service
-	// For now, return empty (metrics are not persisted in current implementation)
+	if err := s.authorizeAgentCall(ctx, req.AgentId); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	metrics, covered := s.syncBuffer.since(req.AgentId, req.LastSyncTimestamp, now, s.syncRetention())
 	return &pb.MetricsSyncResponse{
 		Success:         true,
-		Metrics:         []*pb.Metrics{},
-		ServerTimestamp: uint64(time.Now().UnixMilli()),
+		Metrics:         metrics,
+		ServerTimestamp: uint64(now.UnixMilli()),
+		ResendBuffer:    !covered,
 	}, nil
 }
 
@@ -492,6 +926,9 @@ func (s *Server) GetAgentInfo(ctx context.Context, req *pb.AgentInfoRequest) (*p
 		PermissionLevel: agent.PermissionLevel,
 		ConnectedAt:     uint64(agent.ConnectedAt.UnixMilli()),
 		LastMetricsAt:   uint64(agent.LastMetricsAt.UnixMilli()),
+		Labels:          agent.Labels,
+		CommandPolicy:   agent.CommandPolicy,
+		Outdated:        s.agentOutdated(agent.Version),
 	}, nil
 }
 
@@ -546,6 +983,56 @@ func (s *Server) WatchAgents(req *pb.WatchAgentsRequest, stream pb.DashboardServ
 	return nil
 }
 
+// WatchServerEvents streams server-wide events to super admins, replaying
+// recent ones first when requested
+func (s *Server) WatchServerEvents(req *pb.WatchServerEventsRequest, stream pb.DashboardService_WatchServerEventsServer) error {
+	if _, _, isSuperAdmin, ok := GetUserFromContext(stream.Context()); !ok || !isSuperAdmin {
+		return status.Error(codes.PermissionDenied, "server events are restricted to admins")
+	}
+	if s.serverEvents == nil {
+		return status.Error(codes.Unavailable, "server events are not enabled")
+	}
+
+	events, unsubscribe := s.serverEvents.Subscribe(100)
+	defer unsubscribe()
+
+	// Subscribed before replaying, so skip events the replay already sent
+	var lastSent uint64
+	if req.Recent > 0 {
+		for _, event := range s.serverEvents.Recent(int(req.Recent)) {
+			if err := stream.Send(serverEventToProto(event)); err != nil {
+				return err
+			}
+			lastSent = event.ID
+		}
+	}
+
+	for {
+		select {
+		case event := <-events:
+			if event.ID <= lastSent {
+				continue
+			}
+			if err := stream.Send(serverEventToProto(event)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func serverEventToProto(event service.ServerEvent) *pb.ServerEvent {
+	return &pb.ServerEvent{
+		Id:         event.ID,
+		Kind:       event.Kind,
+		Severity:   event.Severity,
+		Message:    event.Message,
+		Attributes: event.Attributes,
+		Timestamp:  uint64(event.Timestamp.UnixMilli()),
+	}
+}
+
 // WatchMetrics streams metrics to dashboard
 func (s *Server) WatchMetrics(req *pb.WatchMetricsRequest, stream pb.DashboardService_WatchMetricsServer) error {
 	metricsChan := make(chan *pb.Metrics, 100)
@@ -615,27 +1102,66 @@ func (s *Server) GetAgentMetrics(ctx context.Context, req *pb.GetAgentMetricsReq
 
 // SendCommand sends a command to an agent from dashboard
 func (s *Server) SendCommand(ctx context.Context, req *pb.DashboardCommandRequest) (*pb.CommandResult, error) {
+	timeout, err := s.requestedResultTimeout(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if s.commandTemplates != nil {
+		cmd := req.GetCommand()
+		if err := s.commandTemplates.Validate(cmd.GetType().String(), cmd.GetTarget(), cmd.GetParams()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
 	s.agentsMu.RLock()
 	agent, exists := s.agents[req.AgentId]
 	s.agentsMu.RUnlock()
 
 	if !exists {
+		if forwarded, err := s.forwardCommand(ctx, req.AgentId, req.Command); forwarded {
+			if err != nil {
+				return &pb.CommandResult{
+					CommandId: req.Command.CommandId,
+					Success:   false,
+					Error:     err.Error(),
+				}, nil
+			}
+			return &pb.CommandResult{
+				CommandId: req.Command.CommandId,
+				Success:   true,
+				Output:    "Command forwarded to owning instance",
+			}, nil
+		}
+		err := fmt.Errorf("agent not found: %s", req.AgentId)
+		s.auditDispatch(req.AgentId, "", req.Command, commandActor(ctx, req), err)
 		return &pb.CommandResult{
 			CommandId: req.Command.CommandId,
 			Success:   false,
-			Error:     fmt.Sprintf("agent not found: %s", req.AgentId),
+			Error:     err.Error(),
 		}, nil
 	}
 
-	// Send command to agent via stream
-	select {
-	case agent.commandChan <- req.Command:
+	if req.Command.CommandId == "" {
+		req.Command.CommandId = uuid.NewString()
+	}
+	if err := checkCommandPolicy(agent.CommandPolicy, req.Command); err != nil {
+		s.auditDispatch(agent.AgentID, agent.Hostname, req.Command, commandActor(ctx, req), err)
 		return &pb.CommandResult{
 			CommandId: req.Command.CommandId,
-			Success:   true,
-			Output:    "Command sent to agent",
+			Success:   false,
+			Error:     err.Error(),
 		}, nil
+	}
+	results := s.registerPendingCommand(req.AgentId, req.Command.CommandId)
+	s.beginCommand(agent, req.Command, commandActor(ctx, req))
+
+	// Send command to agent via stream, then wait for its result
+	select {
+	case agent.commandChan <- req.Command:
+		return s.awaitCommandResult(ctx, req.AgentId, req.Command.CommandId, results, timeout), nil
 	default:
+		s.cancelPendingCommand(req.Command.CommandId)
+		s.discardCommand(agent.AgentID, req.Command, "Command channel full")
 		return &pb.CommandResult{
 			CommandId: req.Command.CommandId,
 			Success:   false,
@@ -656,10 +1182,22 @@ func (s *Server) agentToProto(agent *GrpcAgent) *pb.AgentInfoResponse {
 		PermissionLevel: agent.PermissionLevel,
 		ConnectedAt:     uint64(agent.ConnectedAt.UnixMilli()),
 		LastMetricsAt:   uint64(agent.LastMetricsAt.UnixMilli()),
+		Labels:          agent.Labels,
+		CommandPolicy:   agent.CommandPolicy,
+		Outdated:        s.agentOutdated(agent.Version),
 	}
 }
 
 func (s *Server) notifyAgentEvent(eventType pb.AgentEvent_EventType, agent *GrpcAgent) {
+	if s.webhooks != nil {
+		switch eventType {
+		case pb.AgentEvent_CONNECTED:
+			s.webhooks.AgentConnected(agent.AgentID, agent.Hostname)
+		case pb.AgentEvent_DISCONNECTED:
+			s.webhooks.AgentDisconnected(agent.AgentID, agent.Hostname)
+		}
+	}
+
 	event := &pb.AgentEvent{
 		EventType: eventType,
 		Agent:     s.agentToProto(agent),
@@ -720,20 +1258,69 @@ func (s *Server) GetAllAgents() map[string]*GrpcAgent {
 
 // SendCommandToAgent sends a command to a specific agent
 func (s *Server) SendCommandToAgent(agentID string, cmd *pb.Command) error {
+	return s.SendCommandToAgentAs(agentID, cmd, service.AuditActor{})
+}
+
+// SendCommandToAgentAs sends a command to a specific agent on behalf of a
+// user, who is recorded with the command in the audit log
+func (s *Server) SendCommandToAgentAs(agentID string, cmd *pb.Command, actor service.AuditActor) error {
 	s.agentsMu.RLock()
 	agent, exists := s.agents[agentID]
 	s.agentsMu.RUnlock()
 
 	if !exists {
-		return fmt.Errorf("agent not found: %s", agentID)
+		// The owning instance audits commands forwarded to it
+		if forwarded, err := s.forwardCommand(context.Background(), agentID, cmd); forwarded {
+			return err
+		}
+		err := fmt.Errorf("agent not found: %s", agentID)
+		s.auditDispatch(agentID, "", cmd, actor, err)
+		return err
+	}
+	if err := checkCommandPolicy(agent.CommandPolicy, cmd); err != nil {
+		s.auditDispatch(agentID, agent.Hostname, cmd, actor, err)
+		return err
 	}
 
+	// Callers learn the result through the command result handler; the
+	// pending entry only lives until the result or the timeout
+	s.registerPendingCommand(agentID, cmd.CommandId)
+	time.AfterFunc(s.commandResultTimeout(), func() { s.cancelPendingCommand(cmd.CommandId) })
+	s.beginCommand(agent, cmd, actor)
+
 	select {
 	case agent.commandChan <- cmd:
 		return nil
 	default:
-		return fmt.Errorf("command channel full for agent: %s", agentID)
+		err := fmt.Errorf("command channel full for agent: %s", agentID)
+		s.cancelPendingCommand(cmd.CommandId)
+		s.discardCommand(agentID, cmd, err.Error())
+		return err
+	}
+}
+
+// CommandsDispatched returns the number of commands dispatched to agents since startup
+func (s *Server) CommandsDispatched() int64 {
+	return s.dispatched.Load()
+}
+
+// beginCommand records a command about to be dispatched
+func (s *Server) beginCommand(agent *GrpcAgent, cmd *pb.Command, actor service.AuditActor) {
+	s.dispatched.Add(1)
+	// The audit entry comes first so the tracker can attach snapshots to it
+	s.auditDispatch(agent.AgentID, agent.Hostname, cmd, actor, nil)
+	if s.commandTracker != nil {
+		s.commandTracker.Begin(agent.AgentID, cmd.CommandId, cmd.Type.String(), cmd.Target)
+	}
+}
+
+// discardCommand drops the record of a command that could not be
+// dispatched, auditing it as failed with reason
+func (s *Server) discardCommand(agentID string, cmd *pb.Command, reason string) {
+	if s.commandTracker != nil {
+		s.commandTracker.Discard(cmd.CommandId)
 	}
+	s.auditCompletion(agentID, cmd.CommandId, false, reason, "")
 }
 
 // RequestDataFromAgent sends a data request to a specific agent
@@ -1106,6 +1693,8 @@ func convertRealtimeMetrics(r *pb.RealtimeMetrics) *service.RealtimeUpdate {
 		MemoryCached: r.MemoryCached,
 		SwapUsed:     r.SwapUsed,
 		LoadAverage:  r.LoadAverage,
+		Delta:        r.Delta,
+		Fields:       r.ChangedFields,
 	}
 
 	for _, d := range r.DiskIo {
@@ -1155,23 +1744,12 @@ func convertRealtimeMetrics(r *pb.RealtimeMetrics) *service.RealtimeUpdate {
 	return data
 }
 
-// StaticData holds static hardware info for merging
-type StaticData struct {
-	CPU        *service.CPUData
-	Memory     *service.MemData
-	Disks      []service.DiskData
-	Networks   []service.NetData
-	GPUs       []service.GPUData
-	NPUs       []service.NPUData
-	SystemInfo *service.SystemInfo
-}
-
-func convertStaticInfo(s *pb.StaticInfo) *StaticData {
+func convertStaticInfo(s *pb.StaticInfo) *service.StaticUpdate {
 	if s == nil {
 		return nil
 	}
 
-	data := &StaticData{}
+	data := &service.StaticUpdate{}
 
 	if s.Cpu != nil {
 		data.CPU = &service.CPUData{
@@ -1257,19 +1835,12 @@ func convertStaticInfo(s *pb.StaticInfo) *StaticData {
 	return data
 }
 
-// PeriodicData holds periodic data for merging
-type PeriodicData struct {
-	DiskUsage      []service.DiskData
-	UserSessions   []service.UserSession
-	NetworkUpdates []service.NetData
-}
-
-func convertPeriodicData(p *pb.PeriodicData) *PeriodicData {
+func convertPeriodicData(p *pb.PeriodicData) *service.PeriodicUpdate {
 	if p == nil {
 		return nil
 	}
 
-	data := &PeriodicData{}
+	data := &service.PeriodicUpdate{}
 
 	for _, d := range p.DiskUsage {
 		usagePercent := 0.0
@@ -1308,3 +1879,26 @@ func convertPeriodicData(p *pb.PeriodicData) *PeriodicData {
 
 	return data
 }
+
+func convertCollectorStatus(statuses []*pb.CollectorStatus) []service.CollectorStatus {
+	if len(statuses) == 0 {
+		return nil
+	}
+
+	result := make([]service.CollectorStatus, 0, len(statuses))
+	for _, st := range statuses {
+		state := service.CollectorStateOK
+		switch st.State {
+		case pb.CollectorState_COLLECTOR_ERROR:
+			state = service.CollectorStateError
+		case pb.CollectorState_COLLECTOR_DISABLED:
+			state = service.CollectorStateDisabled
+		}
+		result = append(result, service.CollectorStatus{
+			Name:   st.Name,
+			State:  state,
+			Reason: st.Reason,
+		})
+	}
+	return result
+}
@@ -0,0 +1,323 @@
+package grpc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+)
+
+// DefaultSessionTTL is how long an agent session survives without the agent
+// connected
+const DefaultSessionTTL = 10 * time.Minute
+
+// DefaultSessionMaxLifetime is how long after authentication a session can
+// be resumed at most, however often the agent reconnects
+const DefaultSessionMaxLifetime = 24 * time.Hour
+
+// maxSessionCommands bounds the commands kept for a disconnected agent
+const maxSessionCommands = 10
+
+// agentSession is the identity an agent can resume after reconnecting
+type agentSession struct {
+	agentID         string
+	tokenID         uint // ID of the stored agent token authenticated with, 0 for config tokens
+	permissionLevel int
+	labels          map[string]string // Sent by the agent at authentication
+	commandPolicy   *pb.CommandPolicy // Shell policy the agent reported, if any
+	expiresAt       time.Time
+	// Neither resuming nor staying connected keeps a session past this:
+	// its maximum lifetime or the expiry of its token, whichever is first
+	notAfter time.Time
+	// Sessions do not expire while their agent is connected
+	connected bool
+	// Commands that were queued but not delivered when the stream ended
+	pending []*pb.Command
+}
+
+// SessionStore issues and validates agent session tokens. A session lives
+// while its agent is connected and for the TTL after the agent last
+// disconnected or authenticated, so an agent that reconnects within the TTL
+// keeps its agent ID and undelivered commands. No session outlives its
+// maximum lifetime or the token it was issued for.
+type SessionStore struct {
+	sessions    map[string]*agentSession
+	ttl         time.Duration
+	maxLifetime time.Duration
+	clock       service.Clock
+	mu          sync.Mutex
+}
+
+// SessionInfo is what a session restores for a stream that presents it
+type SessionInfo struct {
+	AgentID         string // "" until a stream has started with the session
+	TokenID         uint   // Stored agent token the session was issued for, 0 for config tokens
+	PermissionLevel int
+	Labels          map[string]string
+	CommandPolicy   *pb.CommandPolicy
+}
+
+// NewSessionStore creates a session store; ttl <= 0 uses DefaultSessionTTL
+// and maxLifetime <= 0 uses DefaultSessionMaxLifetime
+func NewSessionStore(ttl, maxLifetime time.Duration) *SessionStore {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	if maxLifetime <= 0 {
+		maxLifetime = DefaultSessionMaxLifetime
+	}
+	return &SessionStore{
+		sessions:    make(map[string]*agentSession),
+		ttl:         ttl,
+		maxLifetime: maxLifetime,
+		clock:       service.RealClock,
+	}
+}
+
+// SetClock replaces the time source (for tests)
+func (s *SessionStore) SetClock(clock service.Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+// Issue creates a session for an agent freshly authenticated with token,
+// with the labels and command policy it sent
+func (s *SessionStore) Issue(token config.TokenConfig, labels map[string]string, policy *pb.CommandPolicy) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	sessionToken := hex.EncodeToString(buf)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	notAfter := s.clock.Now().Add(s.maxLifetime)
+	if token.ExpiresAt != nil && token.ExpiresAt.Before(notAfter) {
+		notAfter = *token.ExpiresAt
+	}
+	session := &agentSession{
+		tokenID:         token.ID,
+		permissionLevel: token.Permission,
+		labels:          labels,
+		commandPolicy:   policy,
+		notAfter:        notAfter,
+	}
+	s.extend(session)
+	s.sessions[sessionToken] = session
+	return sessionToken, session.expiresAt, nil
+}
+
+// Resume validates a token and extends its lifetime. It returns the agent
+// ID bound to the session ("" until a stream has started with it).
+func (s *SessionStore) Resume(token string) (agentID string, permissionLevel int, expiresAt time.Time, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.valid(token)
+	if session == nil {
+		return "", 0, time.Time{}, false
+	}
+	s.extend(session)
+	return session.agentID, session.permissionLevel, session.expiresAt, true
+}
+
+// Lookup returns what a valid session restores, without binding it
+func (s *SessionStore) Lookup(token string) (SessionInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.valid(token)
+	if session == nil {
+		return SessionInfo{}, false
+	}
+	return SessionInfo{
+		AgentID:         session.agentID,
+		TokenID:         session.tokenID,
+		PermissionLevel: session.permissionLevel,
+		Labels:          session.labels,
+		CommandPolicy:   session.commandPolicy,
+	}, true
+}
+
+// Bind ties a session to the agent ID its stream uses and marks the agent
+// connected. A session already bound keeps its original agent ID, which is
+// returned along with the permission level granted at authentication.
+func (s *SessionStore) Bind(token, agentID string) (boundID string, permissionLevel int, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.valid(token)
+	if session == nil {
+		return "", 0, false
+	}
+	if session.agentID == "" {
+		session.agentID = agentID
+	}
+	session.connected = true
+	return session.agentID, session.permissionLevel, true
+}
+
+// Labels returns the labels the agent sent when the session was issued
+func (s *SessionStore) Labels(token string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.valid(token)
+	if session == nil {
+		return nil
+	}
+	return session.labels
+}
+
+// CommandPolicy returns the command policy the agent last reported for the
+// session, nil if it reported none
+func (s *SessionStore) CommandPolicy(token string) *pb.CommandPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.valid(token)
+	if session == nil {
+		return nil
+	}
+	return session.commandPolicy
+}
+
+// SetCommandPolicy replaces the command policy of a session, for an agent
+// that resumes it with a changed config
+func (s *SessionStore) SetCommandPolicy(token string, policy *pb.CommandPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session := s.valid(token); session != nil {
+		session.commandPolicy = policy
+	}
+}
+
+// Suspend keeps a disconnected agent's undelivered commands and restarts the
+// session TTL so the agent has the full TTL to come back
+func (s *SessionStore) Suspend(token string, pending []*pb.Command) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.valid(token)
+	if session == nil {
+		return
+	}
+	session.connected = false
+	session.pending = append(session.pending, pending...)
+	if len(session.pending) > maxSessionCommands {
+		session.pending = session.pending[len(session.pending)-maxSessionCommands:]
+	}
+	s.extend(session)
+}
+
+// TakePending returns and clears the commands kept for a session
+func (s *SessionStore) TakePending(token string) []*pb.Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.valid(token)
+	if session == nil {
+		return nil
+	}
+	pending := session.pending
+	session.pending = nil
+	return pending
+}
+
+// RevokeToken ends the sessions issued for a stored agent token, returning
+// how many there were
+func (s *SessionStore) RevokeToken(tokenID uint) int {
+	if tokenID == 0 {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revoked := 0
+	for token, session := range s.sessions {
+		if session.tokenID == tokenID {
+			delete(s.sessions, token)
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// RevokeAgent ends the sessions bound to an agent, so it has to
+// authenticate with a token again
+func (s *SessionStore) RevokeAgent(agentID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, session := range s.sessions {
+		if session.agentID == agentID {
+			delete(s.sessions, token)
+		}
+	}
+}
+
+// extend restarts a session's TTL, up to its lifetime. The caller holds
+// s.mu.
+func (s *SessionStore) extend(session *agentSession) {
+	session.expiresAt = s.clock.Now().Add(s.ttl)
+	if session.notAfter.Before(session.expiresAt) {
+		session.expiresAt = session.notAfter
+	}
+}
+
+// expired reports whether a session can no longer be used. The caller
+// holds s.mu.
+func (s *SessionStore) expired(session *agentSession, now time.Time) bool {
+	if !now.Before(session.notAfter) {
+		return true
+	}
+	return !session.connected && !now.Before(session.expiresAt)
+}
+
+// valid returns an unexpired session, dropping it if expired. The caller
+// holds s.mu.
+func (s *SessionStore) valid(token string) *agentSession {
+	if token == "" {
+		return nil
+	}
+	session, ok := s.sessions[token]
+	if !ok {
+		return nil
+	}
+	if s.expired(session, s.clock.Now()) {
+		delete(s.sessions, token)
+		return nil
+	}
+	return session
+}
+
+// sweep drops expired sessions. The caller holds s.mu.
+func (s *SessionStore) sweep() {
+	now := s.clock.Now()
+	for token, session := range s.sessions {
+		if s.expired(session, now) {
+			delete(s.sessions, token)
+		}
+	}
+}
+
+// drainCommands empties a command queue without blocking
+func drainCommands(commands chan *pb.Command) []*pb.Command {
+	var drained []*pb.Command
+	for {
+		select {
+		case cmd := <-commands:
+			drained = append(drained, cmd)
+		default:
+			return drained
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newSessionTestServer(t *testing.T) (*Server, *service.AgentService, *service.FakeClock) {
+	t.Helper()
+	logger := zap.NewNop().Sugar()
+	cfg := config.Default()
+	cfg.Auth.Enabled = true
+	cfg.Auth.Tokens = []config.TokenConfig{{Token: "agent-secret", Permission: 2}}
+	cfg.Server.AgentSessionTTLSec = 60
+
+	metrics := service.NewMetricsService(logger)
+	agents := service.NewAgentService(logger, metrics)
+	s := NewServer(cfg, agents, metrics, logger)
+	clock := service.NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	s.sessions.SetClock(clock)
+	return s, agents, clock
+}
+
+// connectWithSession runs a stream that starts with AgentInit and hangs up
+// right away, returning the agent ID the server registered
+func connectWithSession(t *testing.T, s *Server, agents *service.AgentService, agentID, token string) string {
+	t.Helper()
+	var registered string
+	before := agents.GetAllAgents()
+	known := make(map[string]bool, len(before))
+	for _, a := range before {
+		known[a.ID] = true
+	}
+
+	stream := &fakeMetricsStream{recv: make(chan *pb.MetricsStreamRequest, 2)}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_AgentInit{
+		AgentInit: &pb.AgentInit{AgentId: agentID, Hostname: "web-1", SessionToken: token},
+	}}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_Heartbeat{Heartbeat: &pb.Heartbeat{}}}
+
+	done := make(chan error, 1)
+	go func() { done <- s.StreamMetrics(stream) }()
+
+	deadline := time.After(2 * time.Second)
+	for registered == "" {
+		for _, a := range agents.GetAllAgents() {
+			if !known[a.ID] {
+				registered = a.ID
+			}
+		}
+		select {
+		case <-deadline:
+			t.Fatal("Agent never registered")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	close(stream.recv)
+	if err := <-done; err != nil {
+		t.Fatalf("StreamMetrics failed: %v", err)
+	}
+	return registered
+}
+
+func TestSessionResumePreservesAgentID(t *testing.T) {
+	s, agents, clock := newSessionTestServer(t)
+
+	resp, err := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", Token: "agent-secret"})
+	if err != nil || !resp.Success {
+		t.Fatalf("Authenticate failed: %v %+v", err, resp)
+	}
+	if resp.SessionToken == "" || resp.SessionExpiresAt == 0 {
+		t.Fatalf("Expected a session token, got %+v", resp)
+	}
+
+	// The agent has no persistent ID, so the server generates one
+	first := connectWithSession(t, s, agents, "", resp.SessionToken)
+
+	// Reconnect within the TTL without presenting the token again
+	clock.Advance(30 * time.Second)
+	resumed, err := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", SessionToken: resp.SessionToken})
+	if err != nil || !resumed.Success {
+		t.Fatalf("Expected session to resume, got %v %+v", err, resumed)
+	}
+	if resumed.AgentId != first {
+		t.Errorf("Expected resumed agent ID %s, got %s", first, resumed.AgentId)
+	}
+	if resumed.PermissionLevel != 2 {
+		t.Errorf("Expected permission level 2 from the session, got %d", resumed.PermissionLevel)
+	}
+
+	second := connectWithSession(t, s, agents, "", resp.SessionToken)
+	if second != first {
+		t.Errorf("Expected reconnect to keep agent ID %s, got %s", first, second)
+	}
+}
+
+func TestExpiredSessionRequiresFreshAuth(t *testing.T) {
+	s, agents, clock := newSessionTestServer(t)
+
+	resp, _ := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", Token: "agent-secret"})
+	connectWithSession(t, s, agents, "", resp.SessionToken)
+
+	clock.Advance(61 * time.Second)
+
+	expired, err := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", SessionToken: resp.SessionToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired.Success {
+		t.Fatal("Expected an expired session to be refused")
+	}
+
+	// A stream presenting only the expired session is refused
+	stream := &fakeMetricsStream{recv: make(chan *pb.MetricsStreamRequest, 1)}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_AgentInit{
+		AgentInit: &pb.AgentInit{Hostname: "web-1", SessionToken: resp.SessionToken},
+	}}
+	if err := s.StreamMetrics(stream); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated for an expired session, got %v", err)
+	}
+	if len(agents.GetAllAgents()) != 0 {
+		t.Errorf("Expected the refused stream not to register, got %d agents", len(agents.GetAllAgents()))
+	}
+
+	// With the token as well, the agent re-authenticates and gets a new session
+	fresh, _ := s.Authenticate(context.Background(), &pb.AuthRequest{
+		Hostname: "web-1", Token: "agent-secret", SessionToken: resp.SessionToken,
+	})
+	if !fresh.Success || fresh.SessionToken == "" || fresh.SessionToken == resp.SessionToken {
+		t.Errorf("Expected fresh auth with a new session, got %+v", fresh)
+	}
+}
+
+func TestSessionDoesNotExpireWhileConnected(t *testing.T) {
+	store := NewSessionStore(time.Minute, 0)
+	clock := service.NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	store.SetClock(clock)
+
+	token, _, err := store.Issue(config.TokenConfig{Permission: 1}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := store.Bind(token, "agent-1"); !ok {
+		t.Fatal("Expected bind to succeed")
+	}
+
+	clock.Advance(time.Hour)
+	if _, _, _, ok := store.Resume(token); !ok {
+		t.Fatal("Expected session to stay valid while the agent is connected")
+	}
+
+	store.Suspend(token, nil)
+	clock.Advance(2 * time.Minute)
+	if _, _, _, ok := store.Resume(token); ok {
+		t.Error("Expected session to expire a TTL after the agent disconnected")
+	}
+}
+
+func TestSessionRestoresPendingCommands(t *testing.T) {
+	store := NewSessionStore(time.Minute, 0)
+	token, _, _ := store.Issue(config.TokenConfig{Permission: 1}, nil, nil)
+	store.Bind(token, "agent-1")
+
+	queue := make(chan *pb.Command, 4)
+	queue <- &pb.Command{CommandId: "cmd-1"}
+	queue <- &pb.Command{CommandId: "cmd-2"}
+	store.Suspend(token, drainCommands(queue))
+
+	if len(queue) != 0 {
+		t.Errorf("Expected the queue to be drained, %d left", len(queue))
+	}
+
+	pending := store.TakePending(token)
+	if len(pending) != 2 || pending[0].CommandId != "cmd-1" || pending[1].CommandId != "cmd-2" {
+		t.Fatalf("Expected cmd-1 and cmd-2 to be restored in order, got %v", pending)
+	}
+	if again := store.TakePending(token); len(again) != 0 {
+		t.Errorf("Expected pending commands to be handed out once, got %v", again)
+	}
+}
+
+func TestSessionKeepsLabels(t *testing.T) {
+	store := NewSessionStore(time.Minute, 0)
+	token, _, err := store.Issue(config.TokenConfig{Permission: 1}, map[string]string{"env": "prod"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := store.Labels(token); got["env"] != "prod" {
+		t.Errorf("Expected the session to keep the agent's labels, got %v", got)
+	}
+	if got := store.Labels("unknown"); got != nil {
+		t.Errorf("Expected no labels for an unknown token, got %v", got)
+	}
+}
+
+func TestSessionLifetimeIsCapped(t *testing.T) {
+	store := NewSessionStore(time.Minute, 5*time.Minute)
+	clock := service.NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	store.SetClock(clock)
+
+	token, _, _ := store.Issue(config.TokenConfig{Permission: 1}, nil, nil)
+	for range 5 {
+		clock.Advance(50 * time.Second)
+		if _, _, _, ok := store.Resume(token); !ok {
+			t.Fatal("Expected the session to resume within its lifetime")
+		}
+	}
+	clock.Advance(50 * time.Second)
+	if _, _, _, ok := store.Resume(token); ok {
+		t.Error("Expected resuming to stop extending a session past its lifetime")
+	}
+
+	// A connected agent does not keep it alive either
+	connected, _, _ := store.Issue(config.TokenConfig{Permission: 1}, nil, nil)
+	store.Bind(connected, "agent-1")
+	clock.Advance(5 * time.Minute)
+	if _, ok := store.Lookup(connected); ok {
+		t.Error("Expected a connected session to end at its lifetime")
+	}
+
+	// Nor does it outlive the token it was issued for
+	expiresAt := clock.Now().Add(2 * time.Minute)
+	short, sessionExpiry, _ := store.Issue(config.TokenConfig{Permission: 1, ExpiresAt: &expiresAt}, nil, nil)
+	clock.Advance(50 * time.Second)
+	store.Resume(short)
+	clock.Advance(50 * time.Second)
+	if _, _, exp, ok := store.Resume(short); !ok || exp.After(expiresAt) || sessionExpiry.After(expiresAt) {
+		t.Errorf("Expected the session to expire with its token at %v, got %v %v", expiresAt, exp, ok)
+	}
+	clock.Advance(30 * time.Second)
+	if _, _, _, ok := store.Resume(short); ok {
+		t.Error("Expected the session to end when its token expired")
+	}
+}
+
+func TestSessionsRevokedWithTheirToken(t *testing.T) {
+	store := NewSessionStore(time.Minute, 0)
+	issued, _, _ := store.Issue(config.TokenConfig{ID: 7, Permission: 1}, nil, nil)
+	other, _, _ := store.Issue(config.TokenConfig{ID: 8, Permission: 1}, nil, nil)
+	fromConfig, _, _ := store.Issue(config.TokenConfig{Permission: 1}, nil, nil)
+
+	if n := store.RevokeToken(7); n != 1 {
+		t.Errorf("Expected one session to be revoked, got %d", n)
+	}
+	if _, ok := store.Lookup(issued); ok {
+		t.Error("Expected the revoked token's session to end")
+	}
+	if n := store.RevokeToken(0); n != 0 {
+		t.Errorf("Expected config tokens to be left alone, got %d revoked", n)
+	}
+	for _, token := range []string{other, fromConfig} {
+		if _, ok := store.Lookup(token); !ok {
+			t.Error("Expected other sessions to survive")
+		}
+	}
+}
+
+func TestForceDisconnectEndsSession(t *testing.T) {
+	s, agents, _ := newSessionTestServer(t)
+	resp, _ := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", Token: "agent-secret"})
+
+	stream := &fakeMetricsStream{recv: make(chan *pb.MetricsStreamRequest, 1)}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_AgentInit{
+		AgentInit: &pb.AgentInit{AgentId: "agent-1", Hostname: "web-1", SessionToken: resp.SessionToken},
+	}}
+	done := make(chan error, 1)
+	go func() { done <- s.StreamMetrics(stream) }()
+	deadline := time.After(2 * time.Second)
+	for agents.GetAgent("agent-1") == nil {
+		select {
+		case <-deadline:
+			t.Fatal("Agent never registered")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	if _, err := agents.ForceDisconnect("agent-1", "compromised", 0); err != nil {
+		t.Fatal(err)
+	}
+	close(stream.recv)
+	<-done
+
+	resumed, _ := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", SessionToken: resp.SessionToken})
+	if resumed.Success {
+		t.Error("Expected the session to end with the forced disconnect")
+	}
+}
+
+func TestRejectedStreamDoesNotBindSession(t *testing.T) {
+	s, _, _ := newSessionTestServer(t)
+	ca := issueCert(t, nil, "NanoLink Agents CA")
+	resp, _ := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", Token: "agent-secret"})
+
+	stream := &fakeMetricsStream{recv: make(chan *pb.MetricsStreamRequest, 1), ctx: certContext(issueCert(t, ca, "db-1").cert)}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_AgentInit{
+		AgentInit: &pb.AgentInit{AgentId: "intruder", Hostname: "web-1", SessionToken: resp.SessionToken},
+	}}
+	if err := s.StreamMetrics(stream); err == nil {
+		t.Fatal("Expected the mismatched stream to be rejected")
+	}
+	if session, ok := s.sessions.Lookup(resp.SessionToken); !ok || session.AgentID != "" {
+		t.Errorf("Expected the session to stay unbound, got %+v %v", session, ok)
+	}
+}
@@ -0,0 +1,150 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/google/uuid"
+)
+
+// ErrShellCommandRequired is returned for an empty streamed shell command
+var ErrShellCommandRequired = errors.New("shell command is required")
+
+// Output messages buffered for a shell command's reader; more are dropped
+// and counted
+const shellOutputBuffer = 256
+
+// ShellOutput is output of a running shell command
+type ShellOutput struct {
+	Stream string `json:"stream"` // "stdout" or "stderr"
+	Data   string `json:"data"`
+}
+
+// ShellCommand is a shell command running on an agent with its output
+// streamed back. Output is closed when the command ends, after which Result
+// holds its result.
+type ShellCommand struct {
+	ID      string
+	AgentID string
+	Command string
+	Output  <-chan ShellOutput
+	Result  <-chan *pb.CommandResult
+
+	output  chan ShellOutput
+	dropped int // Output messages the reader was too slow for
+}
+
+// Dropped returns how many output messages were dropped because the reader
+// fell behind. Only meaningful once Output is closed.
+func (c *ShellCommand) Dropped() int {
+	return c.dropped
+}
+
+// StartShellCommand runs a shell command on an agent, streaming its output.
+// The command must pass the command templates and the agent's reported
+// shell policy; agents that reported no policy are refused, since their
+// allowlist cannot be checked. The agent still checks superToken. Canceling
+// ctx or reaching timeout stops waiting for the result; timeout defaults to
+// the command result timeout and is capped at the maximum.
+func (s *Server) StartShellCommand(ctx context.Context, agentID, command, superToken string, timeout time.Duration, actor service.AuditActor) (*ShellCommand, error) {
+	if command == "" {
+		return nil, ErrShellCommandRequired
+	}
+	cmd := &pb.Command{
+		CommandId:  uuid.NewString(),
+		Type:       pb.CommandType_SHELL_EXECUTE,
+		Target:     command,
+		SuperToken: superToken,
+	}
+	if s.commandTemplates != nil {
+		if err := s.commandTemplates.Validate(cmd.Type.String(), cmd.Target, cmd.Params); err != nil {
+			return nil, err
+		}
+	}
+
+	agent := s.GetAgent(agentID)
+	if agent == nil {
+		err := fmt.Errorf("%w: %s", ErrAgentNotConnected, agentID)
+		s.auditDispatch(agentID, "", cmd, actor, err)
+		return nil, err
+	}
+	policyErr := checkCommandPolicy(agent.CommandPolicy, cmd)
+	if agent.CommandPolicy == nil {
+		policyErr = fmt.Errorf("%w: agent did not report its shell policy", ErrCommandNotPermitted)
+	}
+	if policyErr != nil {
+		s.auditDispatch(agentID, agent.Hostname, cmd, actor, policyErr)
+		return nil, policyErr
+	}
+	if timeout <= 0 {
+		timeout = s.commandResultTimeout()
+	}
+	timeout = min(timeout, s.maxCommandResultTimeout())
+
+	cmd.Params = map[string]string{"stream_output": "true"}
+	output := make(chan ShellOutput, shellOutputBuffer)
+	done := make(chan *pb.CommandResult, 1)
+	shell := &ShellCommand{
+		ID:      cmd.CommandId,
+		AgentID: agentID,
+		Command: command,
+		Output:  output,
+		Result:  done,
+		output:  output,
+	}
+	s.shellMu.Lock()
+	if s.shellCommands == nil {
+		s.shellCommands = make(map[string]*ShellCommand)
+	}
+	s.shellCommands[shell.ID] = shell
+	s.shellMu.Unlock()
+
+	results := s.registerPendingCommand(agentID, cmd.CommandId)
+	s.beginCommand(agent, cmd, actor)
+	select {
+	case agent.commandChan <- cmd:
+	default:
+		s.cancelPendingCommand(cmd.CommandId)
+		s.discardCommand(agentID, cmd, "Command channel full")
+		s.endShellCommand(shell.ID)
+		return nil, fmt.Errorf("command channel full for agent: %s", agentID)
+	}
+
+	go func() {
+		result := s.awaitCommandResult(ctx, agentID, cmd.CommandId, results, timeout)
+		// Output arrives on the agent stream before the result, so all of it
+		// has been delivered by now
+		s.endShellCommand(shell.ID)
+		done <- result
+	}()
+	return shell, nil
+}
+
+// deliverCommandOutput passes output of a streamed command to its reader
+func (s *Server) deliverCommandOutput(agentID string, out *pb.CommandOutput) {
+	s.shellMu.Lock()
+	defer s.shellMu.Unlock()
+	shell, ok := s.shellCommands[out.CommandId]
+	if !ok || shell.AgentID != agentID {
+		return
+	}
+	select {
+	case shell.output <- ShellOutput{Stream: out.Stream, Data: out.Data}:
+	default:
+		shell.dropped++
+	}
+}
+
+// endShellCommand stops delivering a command's output and closes Output
+func (s *Server) endShellCommand(commandID string) {
+	s.shellMu.Lock()
+	defer s.shellMu.Unlock()
+	if shell, ok := s.shellCommands[commandID]; ok {
+		delete(s.shellCommands, commandID)
+		close(shell.output)
+	}
+}
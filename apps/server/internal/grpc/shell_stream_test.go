@@ -0,0 +1,99 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+)
+
+func newShellTestAgent(s *Server, policy *pb.CommandPolicy) *GrpcAgent {
+	agent := &GrpcAgent{AgentID: "agent-1", Hostname: "web-1", CommandPolicy: policy,
+		commandChan: make(chan *pb.Command, 1), stream: &fakeMetricsStream{}}
+	s.agents[agent.AgentID] = agent
+	return agent
+}
+
+func commandOutput(commandID, stream, data string) *pb.MetricsStreamRequest {
+	return &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_CommandOutput{
+		CommandOutput: &pb.CommandOutput{CommandId: commandID, Stream: stream, Data: data},
+	}}
+}
+
+func TestShellCommandStreamsOutputThenResult(t *testing.T) {
+	s, _, _ := newLogStreamServer(t, 0)
+	agent := newShellTestAgent(s, &pb.CommandPolicy{ShellEnabled: true, ShellWhitelist: []string{"uptime"}})
+
+	shell, err := s.StartShellCommand(context.Background(), "agent-1", "uptime", "super", time.Minute, service.AuditActor{Username: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := <-agent.commandChan
+	if cmd.Type != pb.CommandType_SHELL_EXECUTE || cmd.Target != "uptime" || cmd.SuperToken != "super" || cmd.Params["stream_output"] != "true" {
+		t.Fatalf("Expected a streamed shell command, got %+v", cmd)
+	}
+
+	s.processStreamMessage(agent, commandOutput(cmd.CommandId, "stdout", "up 3 days\n"))
+	s.processStreamMessage(agent, commandOutput("other", "stdout", "not ours"))
+	s.processStreamMessage(agent, commandOutput(cmd.CommandId, "stderr", "warning\n"))
+	s.processStreamMessage(agent, &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_CommandResult{
+		CommandResult: &pb.CommandResult{CommandId: cmd.CommandId, Success: true, Output: "up 3 days\n", ExitCode: 0},
+	}})
+
+	var got []string
+	for out := range shell.Output {
+		got = append(got, out.Stream+":"+out.Data)
+	}
+	if strings.Join(got, "|") != "stdout:up 3 days\n|stderr:warning\n" {
+		t.Errorf("Expected stdout then stderr, got %q", got)
+	}
+	if result := <-shell.Result; !result.Success {
+		t.Errorf("Expected the agent's result, got %+v", result)
+	}
+	if len(s.shellCommands) != 0 {
+		t.Errorf("Expected the command to be forgotten, got %d", len(s.shellCommands))
+	}
+}
+
+func TestShellCommandRefusals(t *testing.T) {
+	s, _, _ := newLogStreamServer(t, 0)
+	actor := service.AuditActor{}
+
+	if _, err := s.StartShellCommand(context.Background(), "agent-1", "", "", 0, actor); !errors.Is(err, ErrShellCommandRequired) {
+		t.Errorf("Expected ErrShellCommandRequired, got %v", err)
+	}
+	if _, err := s.StartShellCommand(context.Background(), "missing", "uptime", "", 0, actor); !errors.Is(err, ErrAgentNotConnected) {
+		t.Errorf("Expected ErrAgentNotConnected, got %v", err)
+	}
+
+	// The agent fixture has no reported policy, so its allowlist is unknown
+	agent := s.agents["agent-1"]
+	if _, err := s.StartShellCommand(context.Background(), "agent-1", "uptime", "", 0, actor); !errors.Is(err, ErrCommandNotPermitted) {
+		t.Errorf("Expected agents without a policy to be refused, got %v", err)
+	}
+	agent.CommandPolicy = &pb.CommandPolicy{ShellEnabled: true, ShellWhitelist: []string{"uptime"}}
+	if _, err := s.StartShellCommand(context.Background(), "agent-1", "reboot", "", 0, actor); !errors.Is(err, ErrCommandNotPermitted) {
+		t.Errorf("Expected commands outside the whitelist to be refused, got %v", err)
+	}
+}
+
+func TestShellCommandEndsWhenContextCanceled(t *testing.T) {
+	s, _, _ := newLogStreamServer(t, 0)
+	newShellTestAgent(s, &pb.CommandPolicy{ShellEnabled: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	shell, err := s.StartShellCommand(ctx, "agent-1", "sleep 60", "", time.Minute, service.AuditActor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	for range shell.Output {
+	}
+	if result := <-shell.Result; result.Success || result.Error == "" {
+		t.Errorf("Expected a failed result, got %+v", result)
+	}
+}
@@ -0,0 +1,87 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AgentTokenMetadata carries an agent token on a metrics stream that does
+// not resume a session, e.g. one opened by an agent that starts with
+// legacy messages instead of AgentInit
+const AgentTokenMetadata = "x-agent-token"
+
+// SessionTokenMetadata carries an agent's session token on unary calls it
+// makes about itself, such as SyncMetrics
+const SessionTokenMetadata = "x-session-token"
+
+// metadataValue returns the first value of an incoming metadata key
+func metadataValue(ctx context.Context, key string) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// authenticateStream checks that a metrics stream may register an agent.
+// With auth enabled it must resume a live session, which the caller has
+// looked up, or carry an agent token in its metadata. The token is checked
+// for expiry and revocation now and against the hostname and labels the
+// stream reports.
+func (s *Server) authenticateStream(ctx context.Context, agent *GrpcAgent) error {
+	if agent.sessionToken != "" || !s.config.Auth.Enabled {
+		return nil
+	}
+
+	token := metadataValue(ctx, AgentTokenMetadata)
+	if token == "" {
+		return status.Error(codes.Unauthenticated, "resume a session or send an agent token")
+	}
+
+	matched, err := s.config.ValidateAgentToken(token, config.TokenScope{Hostname: agent.Hostname, Labels: agent.Labels})
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, "token rejected: %v", err)
+	}
+	agent.PermissionLevel = int32(matched.Permission)
+	agent.tokenID = matched.ID
+	if matched.ID != 0 && s.agentTokens != nil {
+		s.agentTokens.RecordUse(matched.ID, agent.Hostname)
+	}
+	return nil
+}
+
+// authorizeAgentCall checks that a unary call about agentID is made by
+// that agent: with auth enabled it must carry a live session bound to it
+func (s *Server) authorizeAgentCall(ctx context.Context, agentID string) error {
+	if !s.config.Auth.Enabled {
+		return nil
+	}
+	token := metadataValue(ctx, SessionTokenMetadata)
+	if token == "" {
+		return status.Error(codes.Unauthenticated, "session token required")
+	}
+	session, ok := s.sessions.Lookup(token)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "session expired or revoked")
+	}
+	if session.AgentID == "" || session.AgentID != agentID {
+		return status.Error(codes.PermissionDenied, "session belongs to another agent")
+	}
+	return nil
+}
+
+// RevokeAgentToken ends everything a revoked stored agent token
+// authenticated: the sessions issued for it, which can no longer be
+// resumed, and the agents connected with it
+func (s *Server) RevokeAgentToken(tokenID uint) {
+	sessions := s.sessions.RevokeToken(tokenID)
+	disconnected := s.agentService.DisconnectToken(tokenID, "agent token revoked")
+	if sessions > 0 || len(disconnected) > 0 {
+		s.logger.Infof("Agent token %d revoked: ended %d sessions and disconnected %v", tokenID, sessions, disconnected)
+	}
+}
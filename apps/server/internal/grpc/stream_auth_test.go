@@ -0,0 +1,103 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// tokenStream is a metrics stream that starts with AgentInit, carrying
+// token in its metadata when set
+func tokenStream(token, agentID, sessionToken string) *fakeMetricsStream {
+	ctx := context.Background()
+	if token != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(AgentTokenMetadata, token))
+	}
+	stream := &fakeMetricsStream{recv: make(chan *pb.MetricsStreamRequest, 1), ctx: ctx}
+	stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_AgentInit{
+		AgentInit: &pb.AgentInit{AgentId: agentID, Hostname: "web-1", SessionToken: sessionToken},
+	}}
+	return stream
+}
+
+func TestStreamMetricsRequiresSessionOrToken(t *testing.T) {
+	s, agents, _ := newSessionTestServer(t)
+	expired := time.Now().Add(-time.Hour)
+	s.config.Auth.Tokens = append(s.config.Auth.Tokens,
+		config.TokenConfig{Token: "expired-secret", Permission: 3, ExpiresAt: &expired},
+		config.TokenConfig{Token: "db-only", Permission: 3, Hostnames: []string{"db-*"}},
+	)
+
+	for _, token := range []string{"", "wrong", "expired-secret", "db-only"} {
+		if err := s.StreamMetrics(tokenStream(token, "agent-1", "")); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("Token %q: expected Unauthenticated, got %v", token, err)
+		}
+	}
+	if n := len(agents.GetAllAgents()); n != 0 {
+		t.Fatalf("Expected refused streams not to register, got %d agents", n)
+	}
+
+	stream := tokenStream("agent-secret", "agent-1", "")
+	done := make(chan error, 1)
+	go func() { done <- s.StreamMetrics(stream) }()
+	waitForAgent(t, s, "agent-1")
+	if level := s.GetAgent("agent-1").PermissionLevel; level != 2 {
+		t.Errorf("Expected the token's permission level 2, got %d", level)
+	}
+	close(stream.recv)
+	if err := <-done; err != nil {
+		t.Errorf("Expected a stream with a valid token to run, got %v", err)
+	}
+}
+
+func TestRevokeAgentTokenEndsSessionsAndStreams(t *testing.T) {
+	s, agents, _ := newSessionTestServer(t)
+	issued := config.TokenConfig{ID: 5, Hash: config.HashToken("issued-secret"), Permission: 1}
+	s.config.Auth.Tokens = append(s.config.Auth.Tokens, issued)
+
+	// One agent streams with the token itself, another with a session for it
+	direct := tokenStream("issued-secret", "agent-1", "")
+	resp, _ := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", Token: "issued-secret"})
+	resumed := tokenStream("", "agent-2", resp.SessionToken)
+	done := make(chan error, 2)
+	for _, stream := range []*fakeMetricsStream{direct, resumed} {
+		go func() { done <- s.StreamMetrics(stream) }()
+	}
+	waitForAgent(t, s, "agent-1")
+	waitForAgent(t, s, "agent-2")
+
+	s.config.RemoveToken(issued.ID)
+	s.RevokeAgentToken(issued.ID)
+	for _, id := range []string{"agent-1", "agent-2"} {
+		if agents.GetAgent(id) != nil {
+			t.Errorf("Expected %s to be disconnected", id)
+		}
+	}
+	close(direct.recv)
+	close(resumed.recv)
+	<-done
+	<-done
+
+	again, _ := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", SessionToken: resp.SessionToken})
+	if again.Success {
+		t.Error("Expected the revoked token's session to be gone")
+	}
+}
+
+func waitForAgent(t *testing.T, s *Server, agentID string) {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	for s.GetAgent(agentID) == nil {
+		select {
+		case <-deadline:
+			t.Fatalf("Agent %s never registered", agentID)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
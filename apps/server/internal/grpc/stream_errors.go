@@ -0,0 +1,43 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// classifyStreamError maps the error that ended an agent stream to one of
+// the service.StreamEnd* kinds
+func classifyStreamError(err error) string {
+	switch {
+	case err == nil, errors.Is(err, io.EOF):
+		return service.StreamEndEOF
+	case errors.Is(err, context.Canceled):
+		return service.StreamEndCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return service.StreamEndDeadline
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return service.StreamEndOther
+	}
+	switch st.Code() {
+	case codes.Canceled:
+		return service.StreamEndCanceled
+	case codes.DeadlineExceeded:
+		return service.StreamEndDeadline
+	case codes.Unavailable, codes.Internal, codes.Unknown, codes.ResourceExhausted:
+		return service.StreamEndTransport
+	}
+	return service.StreamEndOther
+}
+
+// recordStreamEnd counts how an agent's stream ended
+func (s *Server) recordStreamEnd(agentID, kind string, err error) {
+	s.agentService.RecordStreamEnd(agentID, kind, err)
+}
@@ -0,0 +1,82 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassifyStreamError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{io.EOF, service.StreamEndEOF},
+		{context.Canceled, service.StreamEndCanceled},
+		{fmt.Errorf("recv: %w", context.Canceled), service.StreamEndCanceled},
+		{status.Error(codes.Canceled, "context canceled"), service.StreamEndCanceled},
+		{context.DeadlineExceeded, service.StreamEndDeadline},
+		{status.Error(codes.DeadlineExceeded, "keepalive"), service.StreamEndDeadline},
+		{status.Error(codes.Unavailable, "transport is closing"), service.StreamEndTransport},
+		{status.Error(codes.Internal, "connection reset by peer"), service.StreamEndTransport},
+		{status.Error(codes.InvalidArgument, "bad message"), service.StreamEndOther},
+		{errors.New("boom"), service.StreamEndOther},
+	}
+	for _, tt := range tests {
+		if got := classifyStreamError(tt.err); got != tt.want {
+			t.Errorf("classifyStreamError(%v) = %s, expected %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestStreamErrorsIncrementCounters(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	agents := service.NewAgentService(logger, service.NewMetricsService(logger))
+	s := &Server{agentService: agents, logger: logger}
+
+	// Simulate an agent on a flaky link: each stream ends differently
+	ends := []error{
+		status.Error(codes.Unavailable, "transport is closing"),
+		status.Error(codes.Unavailable, "connection reset"),
+		context.Canceled,
+		io.EOF,
+	}
+	for _, err := range ends {
+		agents.RegisterGrpcAgent("agent-1", service.AgentInfo{Hostname: "flaky"}, 0)
+		s.recordStreamEnd("agent-1", classifyStreamError(err), err)
+		agents.UnregisterAgent("agent-1")
+	}
+
+	stats, ok := agents.StreamStats().Agent("agent-1")
+	if !ok {
+		t.Fatal("Expected stats for agent-1")
+	}
+	if stats.Connects != 4 || stats.Reconnects != 3 {
+		t.Errorf("Expected 4 connects and 3 reconnects, got %d and %d", stats.Connects, stats.Reconnects)
+	}
+	want := map[string]int{
+		service.StreamEndTransport: 2,
+		service.StreamEndCanceled:  1,
+		service.StreamEndEOF:       1,
+	}
+	for kind, n := range want {
+		if stats.StreamEnds[kind] != n {
+			t.Errorf("Expected %d %s stream ends, got %d", n, kind, stats.StreamEnds[kind])
+		}
+	}
+	if stats.LastEnd != service.StreamEndEOF {
+		t.Errorf("Expected last end eof, got %s", stats.LastEnd)
+	}
+
+	totals := agents.StreamStats().Totals()
+	if totals.Agents != 1 || totals.Reconnects != 3 || totals.StreamEnds[service.StreamEndTransport] != 2 {
+		t.Errorf("Unexpected totals: %+v", totals)
+	}
+}
@@ -0,0 +1,127 @@
+package grpc
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+)
+
+// bufferedMetrics is a metrics message kept for SyncMetrics
+type bufferedMetrics struct {
+	timestamp  uint64 // The agent's timestamp in milliseconds
+	receivedAt time.Time
+	metrics    *pb.Metrics
+}
+
+// syncBuffer keeps each agent's recently received metrics so an agent that
+// reconnects can learn what the server already has. The zero value is
+// ready to use.
+type syncBuffer struct {
+	mu     sync.Mutex
+	agents map[string][]bufferedMetrics
+	gone   map[string]time.Time // When agents whose buffers are kept disconnected
+}
+
+// add records a metrics message, keeping at most size entries per agent and
+// none older than retention (0 = no age limit)
+func (b *syncBuffer) add(agentID string, m *pb.Metrics, now time.Time, size int, retention time.Duration) {
+	if size <= 0 {
+		return
+	}
+	ts := m.Timestamp
+	if ts == 0 {
+		ts = uint64(now.UnixMilli())
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.agents == nil {
+		b.agents = make(map[string][]bufferedMetrics)
+	}
+	delete(b.gone, agentID)
+	entries := append(b.agents[agentID], bufferedMetrics{timestamp: ts, receivedAt: now, metrics: m})
+	// Agents send in order, but a resent buffer may arrive after newer metrics
+	if n := len(entries); n > 1 && entries[n-2].timestamp > ts {
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].timestamp < entries[j].timestamp })
+	}
+	if len(entries) > size {
+		entries = append(entries[:0:0], entries[len(entries)-size:]...)
+	}
+	b.agents[agentID] = pruneBuffered(entries, now, retention)
+}
+
+// since returns the agent's buffered metrics newer than ts, oldest first,
+// and whether the buffer reaches back to ts. An agent whose buffer does not
+// may have metrics the server never received.
+func (b *syncBuffer) since(agentID string, ts uint64, now time.Time, retention time.Duration) ([]*pb.Metrics, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entries := pruneBuffered(b.agents[agentID], now, retention)
+	if len(entries) == 0 {
+		delete(b.agents, agentID)
+		delete(b.gone, agentID)
+		return nil, false
+	}
+	b.agents[agentID] = entries
+
+	var out []*pb.Metrics
+	for _, e := range entries {
+		if e.timestamp > ts {
+			out = append(out, e.metrics)
+		}
+	}
+	return out, entries[0].timestamp <= ts
+}
+
+// release is called when an agent disconnects. Its buffer is kept for
+// retention in case it reconnects and syncs, and the buffers of agents gone
+// longer than that are dropped. Without a retention nothing would expire
+// the buffer, so it is dropped right away.
+func (b *syncBuffer) release(agentID string, now time.Time, retention time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if retention <= 0 {
+		delete(b.agents, agentID)
+		return
+	}
+	if _, ok := b.agents[agentID]; ok {
+		if b.gone == nil {
+			b.gone = make(map[string]time.Time)
+		}
+		b.gone[agentID] = now
+	}
+	cutoff := now.Add(-retention)
+	for id, at := range b.gone {
+		if at.Before(cutoff) {
+			delete(b.agents, id)
+			delete(b.gone, id)
+		}
+	}
+}
+
+// pruneBuffered drops entries received longer than retention before now
+func pruneBuffered(entries []bufferedMetrics, now time.Time, retention time.Duration) []bufferedMetrics {
+	if retention <= 0 {
+		return entries
+	}
+	cutoff := now.Add(-retention)
+	kept := entries[:0]
+	for _, e := range entries {
+		// Resent metrics are ordered by timestamp, not arrival, so check all
+		if !e.receivedAt.Before(cutoff) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// bufferForSync records metrics from an agent for SyncMetrics
+func (s *Server) bufferForSync(agentID string, m *pb.Metrics) {
+	s.syncBuffer.add(agentID, m, time.Now(), s.config.Metrics.SyncBufferSize, s.syncRetention())
+}
+
+func (s *Server) syncRetention() time.Duration {
+	return time.Duration(s.config.Metrics.SyncBufferRetentionSec) * time.Second
+}
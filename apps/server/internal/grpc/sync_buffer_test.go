@@ -0,0 +1,132 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestSyncMetricsReturnsMetricsAfterLastSync(t *testing.T) {
+	cfg := config.Default()
+	cfg.Metrics.SyncBufferSize = 3
+	s := &Server{config: cfg}
+
+	base := uint64(time.Now().UnixMilli())
+	for i := uint64(0); i < 4; i++ {
+		s.bufferForSync("agent-1", &pb.Metrics{Timestamp: base + i*1000})
+	}
+
+	// The oldest message was evicted, so base+1000 is the earliest known
+	resp, err := s.SyncMetrics(context.Background(), &pb.MetricsSyncRequest{AgentId: "agent-1", LastSyncTimestamp: base + 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Metrics) != 2 || resp.Metrics[0].Timestamp != base+2000 || resp.Metrics[1].Timestamp != base+3000 {
+		t.Errorf("Expected the two metrics after the last sync, got %v", resp.Metrics)
+	}
+	if resp.ResendBuffer {
+		t.Error("Expected no resend when the buffer covers the last sync")
+	}
+
+	resp, err = s.SyncMetrics(context.Background(), &pb.MetricsSyncRequest{AgentId: "agent-1", LastSyncTimestamp: base})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Metrics) != 3 || !resp.ResendBuffer {
+		t.Errorf("Expected a resend request when the buffer starts after the last sync, got %d metrics, resend=%v",
+			len(resp.Metrics), resp.ResendBuffer)
+	}
+
+	resp, err = s.SyncMetrics(context.Background(), &pb.MetricsSyncRequest{AgentId: "agent-2", LastSyncTimestamp: base})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Metrics) != 0 || !resp.ResendBuffer {
+		t.Errorf("Expected an unknown agent to be asked to resend, got %+v", resp)
+	}
+}
+
+func TestSyncBufferDropsExpiredMetrics(t *testing.T) {
+	var b syncBuffer
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	b.add("agent-1", &pb.Metrics{Timestamp: 1000}, start, 10, time.Minute)
+	b.add("agent-1", &pb.Metrics{Timestamp: 3000}, start.Add(30*time.Second), 10, time.Minute)
+	// A resent message older than the rest is kept in timestamp order
+	b.add("agent-1", &pb.Metrics{Timestamp: 2000}, start.Add(40*time.Second), 10, time.Minute)
+
+	metrics, covered := b.since("agent-1", 1000, start.Add(50*time.Second), time.Minute)
+	if !covered || len(metrics) != 2 || metrics[0].Timestamp != 2000 || metrics[1].Timestamp != 3000 {
+		t.Errorf("Expected 2000 and 3000 in order, got %v (covered=%v)", metrics, covered)
+	}
+
+	// Expiry follows arrival, so the resent 2000 outlives 3000
+	metrics, covered = b.since("agent-1", 1000, start.Add(95*time.Second), time.Minute)
+	if covered || len(metrics) != 1 || metrics[0].Timestamp != 2000 {
+		t.Errorf("Expected only the resent message to remain, got %v (covered=%v)", metrics, covered)
+	}
+}
+
+func TestSyncMetricsNeedsTheAgentsSession(t *testing.T) {
+	s, agents, _ := newSessionTestServer(t)
+	s.config.Metrics.SyncBufferSize = 10
+	s.bufferForSync("agent-1", &pb.Metrics{Timestamp: 1000})
+
+	resp, _ := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-1", Token: "agent-secret"})
+	connectWithSession(t, s, agents, "agent-1", resp.SessionToken)
+	other, _ := s.Authenticate(context.Background(), &pb.AuthRequest{Hostname: "web-2", Token: "agent-secret"})
+	connectWithSession(t, s, agents, "agent-2", other.SessionToken)
+
+	sync := func(token string) error {
+		ctx := context.Background()
+		if token != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(SessionTokenMetadata, token))
+		}
+		_, err := s.SyncMetrics(ctx, &pb.MetricsSyncRequest{AgentId: "agent-1"})
+		return err
+	}
+	if err := sync(""); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected a call without a session to be refused, got %v", err)
+	}
+	if err := sync(other.SessionToken); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected another agent's session to be refused, got %v", err)
+	}
+	if err := sync(resp.SessionToken); err != nil {
+		t.Errorf("Expected the agent to sync its own metrics, got %v", err)
+	}
+}
+
+func TestSyncBufferReleasesDisconnectedAgents(t *testing.T) {
+	var b syncBuffer
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, id := range []string{"agent-1", "agent-2", "agent-3"} {
+		b.add(id, &pb.Metrics{Timestamp: 1000}, start, 10, time.Minute)
+	}
+
+	// A disconnected agent keeps its buffer for the retention, in case it
+	// reconnects; one that does is no longer released
+	b.release("agent-1", start, time.Minute)
+	b.release("agent-2", start, time.Minute)
+	b.add("agent-2", &pb.Metrics{Timestamp: 2000}, start.Add(30*time.Second), 10, time.Minute)
+	b.release("agent-3", start.Add(2*time.Minute), time.Minute)
+	if _, ok := b.agents["agent-1"]; ok {
+		t.Error("Expected the buffer of an agent gone past the retention to be dropped")
+	}
+	if _, ok := b.agents["agent-2"]; !ok {
+		t.Error("Expected a reconnected agent's buffer to be kept")
+	}
+	if _, ok := b.agents["agent-3"]; !ok {
+		t.Error("Expected a just disconnected agent's buffer to be kept")
+	}
+
+	// Without a retention nothing would expire it
+	b.release("agent-2", start, 0)
+	if _, ok := b.agents["agent-2"]; ok {
+		t.Error("Expected the buffer to be dropped without a retention")
+	}
+}
@@ -0,0 +1,111 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Policies for metrics that arrive for an agent that is not registered,
+// either one that never connected or one that has since been unregistered
+const (
+	// UnknownAgentRegister registers the agent and stores its metrics
+	UnknownAgentRegister = "register"
+	// UnknownAgentAccept stores the metrics without registering the agent
+	UnknownAgentAccept = "accept"
+	// UnknownAgentReject refuses the metrics; a stream is closed so the
+	// agent reconnects and registers again
+	UnknownAgentReject = "reject"
+)
+
+// ErrInvalidUnknownAgentPolicy is returned for unknown policies
+var ErrInvalidUnknownAgentPolicy = errors.New("invalid unknown agent policy")
+
+// SetUnknownAgentPolicy sets how metrics from unregistered agents are
+// handled by ReportMetrics and StreamMetrics
+func (s *Server) SetUnknownAgentPolicy(policy string) error {
+	switch policy {
+	case UnknownAgentRegister, UnknownAgentAccept, UnknownAgentReject:
+		s.unknownAgentPolicy = policy
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrInvalidUnknownAgentPolicy, policy)
+}
+
+// admitMetrics applies the unknown agent policy before an agent's metrics
+// are stored, calling register when the agent should be registered. An
+// error means the metrics must be dropped.
+func (s *Server) admitMetrics(agentID string, register func()) error {
+	if s.agentService.GetAgent(agentID) != nil {
+		return nil
+	}
+	switch s.unknownAgentPolicy {
+	case UnknownAgentReject:
+		return status.Errorf(codes.PermissionDenied, "agent %s is not registered", agentID)
+	case UnknownAgentAccept:
+		return nil
+	}
+	register()
+	return nil
+}
+
+// admitStreamMetrics applies the unknown agent policy to a metrics message
+// on an agent's stream. The stream registered the agent when it connected,
+// so this only matters once the agent has been unregistered since.
+func (s *Server) admitStreamMetrics(agent *GrpcAgent, msg *pb.MetricsStreamRequest) error {
+	switch msg.GetRequest().(type) {
+	case *pb.MetricsStreamRequest_Metrics, *pb.MetricsStreamRequest_Realtime,
+		*pb.MetricsStreamRequest_StaticInfo, *pb.MetricsStreamRequest_Periodic:
+	default:
+		return nil
+	}
+	if s.agentService.IsDenied(agent.identity()) {
+		// Force-disconnected while this message was in flight
+		return status.Error(codes.PermissionDenied, "agent is temporarily denied")
+	}
+	return s.admitMetrics(agent.AgentID, func() {
+		s.logger.Infof("Re-registering agent %s (%s) from its metrics", agent.Hostname, agent.AgentID)
+		s.agentsMu.Lock()
+		s.agents[agent.AgentID] = agent
+		s.agentsMu.Unlock()
+		s.registerStreamAgent(agent)
+	})
+}
+
+// registerStreamAgent adds a streaming agent to AgentService so it appears
+// in the dashboard and can be force-disconnected
+func (s *Server) registerStreamAgent(agent *GrpcAgent) {
+	registered := s.agentService.RegisterGrpcAgent(agent.AgentID, service.AgentInfo{
+		Hostname:   agent.Hostname,
+		OS:         agent.OS,
+		Arch:       agent.Arch,
+		Version:    agent.Version,
+		Labels:     agent.Labels,
+		VolatileID: agent.volatileID,
+	}, int(agent.PermissionLevel))
+	agent.mu.Lock()
+	agent.registered = registered
+	agent.mu.Unlock()
+	if agent.tokenID != 0 {
+		s.agentService.SetAgentToken(agent.AgentID, agent.tokenID)
+	}
+	// A forced disconnect also ends the agent's sessions, so it cannot
+	// resume one without authenticating again
+	s.agentService.SetAgentCloser(agent.AgentID, func() {
+		s.sessions.RevokeAgent(agent.AgentID)
+		agent.closeStream()
+	})
+}
+
+// identity is the key the agent is denied under after a forced disconnect
+// (see AgentService.IsDenied)
+func (a *GrpcAgent) identity() string {
+	if a.volatileID {
+		return service.VolatileIdentity(a.Hostname)
+	}
+	return a.AgentID
+}
@@ -0,0 +1,120 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newPolicyServer(t *testing.T, policy string) *Server {
+	t.Helper()
+	logger := zap.NewNop().Sugar()
+	metrics := service.NewMetricsService(logger)
+	s := NewServer(config.Default(), service.NewAgentService(logger, metrics), metrics, logger)
+	if err := s.SetUnknownAgentPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestReportMetricsFromUnknownAgent(t *testing.T) {
+	tests := []struct {
+		policy     string
+		registered bool
+		stored     bool
+	}{
+		{UnknownAgentRegister, true, true},
+		{UnknownAgentAccept, false, true},
+		{UnknownAgentReject, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			s := newPolicyServer(t, tt.policy)
+
+			_, err := s.ReportMetrics(context.Background(), &pb.Metrics{Hostname: "stranger", Cpu: &pb.CpuMetrics{UsagePercent: 42}})
+			if tt.stored != (err == nil) {
+				t.Fatalf("Expected stored=%v, got error %v", tt.stored, err)
+			}
+			if !tt.stored && status.Code(err) != codes.PermissionDenied {
+				t.Errorf("Expected PermissionDenied, got %v", err)
+			}
+			if registered := s.agentService.GetAgent("stranger") != nil; registered != tt.registered {
+				t.Errorf("Expected registered=%v, got %v", tt.registered, registered)
+			}
+			if stored := s.metricsService.GetCurrentMetrics("stranger") != nil; stored != tt.stored {
+				t.Errorf("Expected stored=%v, got %v", tt.stored, stored)
+			}
+		})
+	}
+}
+
+func TestReportMetricsFromRegisteredAgentIgnoresPolicy(t *testing.T) {
+	s := newPolicyServer(t, UnknownAgentReject)
+	s.agentService.RegisterGrpcAgent("web-1", service.AgentInfo{Hostname: "web-1"}, 0)
+
+	if _, err := s.ReportMetrics(context.Background(), &pb.Metrics{Hostname: "web-1"}); err != nil {
+		t.Fatalf("Expected metrics from a registered agent to be accepted, got %v", err)
+	}
+	if s.metricsService.GetCurrentMetrics("web-1") == nil {
+		t.Error("Expected the metrics to be stored")
+	}
+}
+
+func TestStreamMetricsAfterUnregister(t *testing.T) {
+	tests := []struct {
+		policy     string
+		registered bool
+		closed     bool
+	}{
+		{UnknownAgentRegister, true, false},
+		{UnknownAgentAccept, false, false},
+		{UnknownAgentReject, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			s, stream, done := startStream(t)
+			if err := s.SetUnknownAgentPolicy(tt.policy); err != nil {
+				t.Fatal(err)
+			}
+			// The stream stays open while the agent is dropped from the registry
+			s.agentService.UnregisterAgent("agent-1")
+
+			stream.recv <- &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_Metrics{
+				Metrics: &pb.Metrics{Hostname: "web-1", Cpu: &pb.CpuMetrics{UsagePercent: 42}},
+			}}
+			if tt.closed {
+				select {
+				case <-done:
+				case <-time.After(2 * time.Second):
+					t.Fatal("Expected the stream to be closed")
+				}
+				if s.metricsService.GetCurrentMetrics("agent-1") != nil {
+					t.Error("Expected the metrics to be dropped")
+				}
+				return
+			}
+
+			waitFor(t, func() bool { return s.metricsService.GetCurrentMetrics("agent-1") != nil })
+			if registered := s.agentService.GetAgent("agent-1") != nil; registered != tt.registered {
+				t.Errorf("Expected registered=%v, got %v", tt.registered, registered)
+			}
+			close(stream.recv)
+			<-done
+		})
+	}
+}
+
+func TestSetUnknownAgentPolicyRejectsUnknownValues(t *testing.T) {
+	s := newPolicyServer(t, UnknownAgentRegister)
+	if err := s.SetUnknownAgentPolicy("ignore"); !errors.Is(err, ErrInvalidUnknownAgentPolicy) {
+		t.Errorf("Expected ErrInvalidUnknownAgentPolicy, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package grpc
+
+import "sort"
+
+// Version key counting agents that did not report one
+const unknownAgentVersion = "unknown"
+
+// AgentVersionReport summarises the agent versions in the fleet
+type AgentVersionReport struct {
+	// Versions counts agents by reported version
+	Versions map[string]int `json:"versions"`
+	// SupportedVersion is the configured supported agent version, if any
+	SupportedVersion string `json:"supportedVersion,omitempty"`
+	// Outdated lists the agents below SupportedVersion, oldest first
+	Outdated []OutdatedAgent `json:"outdated"`
+}
+
+// OutdatedAgent is an agent running a version below the supported one
+type OutdatedAgent struct {
+	AgentID  string `json:"agentId"`
+	Hostname string `json:"hostname"`
+	Version  string `json:"version"`
+}
+
+// GetAgentVersionDistribution counts connected agents by reported version
+func (s *Server) GetAgentVersionDistribution() map[string]int {
+	return s.AgentVersionReport(nil).Versions
+}
+
+// AgentVersionReport reports the versions of the connected agents that
+// include accepts, or of all of them when include is nil. WebSocket agents
+// are counted too, as the report is built from AgentService.
+func (s *Server) AgentVersionReport(include func(agentID string) bool) AgentVersionReport {
+	report := AgentVersionReport{
+		Versions:         make(map[string]int),
+		SupportedVersion: s.config.Auth.SupportedAgentVersion,
+		Outdated:         []OutdatedAgent{},
+	}
+
+	for agentID, info := range s.agentService.GetAllAgentInfo() {
+		if include != nil && !include(agentID) {
+			continue
+		}
+		version := info.Version
+		if version == "" {
+			version = unknownAgentVersion
+		}
+		report.Versions[version]++
+		if s.agentOutdated(info.Version) {
+			report.Outdated = append(report.Outdated, OutdatedAgent{
+				AgentID:  agentID,
+				Hostname: info.Hostname,
+				Version:  info.Version,
+			})
+		}
+	}
+
+	sort.Slice(report.Outdated, func(i, j int) bool {
+		a, b := report.Outdated[i], report.Outdated[j]
+		// Unparseable versions are below everything, including each other
+		if below := agentVersionBelow(a.Version, b.Version); below != agentVersionBelow(b.Version, a.Version) {
+			return below
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Hostname < b.Hostname
+	})
+	return report
+}
+
+// agentOutdated reports whether version is below the supported agent
+// version. Versions that cannot be parsed count as outdated.
+func (s *Server) agentOutdated(version string) bool {
+	supported := s.config.Auth.SupportedAgentVersion
+	return supported != "" && agentVersionBelow(version, supported)
+}
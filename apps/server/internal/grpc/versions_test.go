@@ -0,0 +1,69 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"go.uber.org/zap"
+)
+
+func TestAgentVersionReport(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	metrics := service.NewMetricsService(logger)
+	cfg := config.Default()
+	cfg.Auth.SupportedAgentVersion = "0.4.0"
+	agents := service.NewAgentService(logger, metrics)
+	s := NewServer(cfg, agents, metrics, logger)
+	for id, version := range map[string]string{
+		"a": "0.4.1", "b": "0.4.1", "c": "v0.3.9", "d": "0.2.0", "e": "",
+	} {
+		s.agents[id] = &GrpcAgent{AgentID: id, Hostname: "host-" + id, Version: version}
+		agents.RegisterGrpcAgent(id, service.AgentInfo{Hostname: "host-" + id, Version: version}, 0)
+	}
+	// WebSocket agents are only known to AgentService
+	ws := agents.RegisterAgent(nil, service.AgentInfo{Hostname: "host-f", Version: "0.4.1"}, 0)
+
+	dist := s.GetAgentVersionDistribution()
+	want := map[string]int{"0.4.1": 3, "v0.3.9": 1, "0.2.0": 1, unknownAgentVersion: 1}
+	if len(dist) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, dist)
+	}
+	for version, count := range want {
+		if dist[version] != count {
+			t.Errorf("Expected %d agent(s) on %q, got %d", count, version, dist[version])
+		}
+	}
+
+	// Oldest first, with an unreported version below everything
+	report := s.AgentVersionReport(nil)
+	var outdated []string
+	for _, agent := range report.Outdated {
+		outdated = append(outdated, agent.AgentID)
+	}
+	if len(outdated) != 3 || outdated[0] != "e" || outdated[1] != "d" || outdated[2] != "c" {
+		t.Errorf("Expected agents e, d and c outdated, got %v", outdated)
+	}
+
+	report = s.AgentVersionReport(func(agentID string) bool { return agentID == ws.ID || agentID == "d" })
+	if len(report.Versions) != 2 || len(report.Outdated) != 1 || report.Outdated[0].AgentID != "d" {
+		t.Errorf("Expected only the included agents, got %+v", report)
+	}
+
+	info, err := s.GetAgentInfo(context.Background(), &pb.AgentInfoRequest{AgentId: "c"})
+	if err != nil || !info.Outdated {
+		t.Errorf("Expected agent c to be flagged outdated, got %v (%v)", info, err)
+	}
+	info, err = s.GetAgentInfo(context.Background(), &pb.AgentInfoRequest{AgentId: "a"})
+	if err != nil || info.Outdated {
+		t.Errorf("Expected agent a to be current, got %v (%v)", info, err)
+	}
+
+	// Without a supported version no agent is flagged
+	cfg.Auth.SupportedAgentVersion = ""
+	if report := s.AgentVersionReport(nil); len(report.Outdated) != 0 {
+		t.Errorf("Expected no outdated agents, got %v", report.Outdated)
+	}
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// maxDenySeconds caps how long a force-disconnected agent can be refused
+const maxDenySeconds = 24 * 60 * 60
+
+// AgentControlHandler handles administrative actions on connected agents
+type AgentControlHandler struct {
+	agentService *service.AgentService
+	auditService *service.AuditService
+	logger       *zap.SugaredLogger
+}
+
+// NewAgentControlHandler creates a new agent control handler
+func NewAgentControlHandler(agentService *service.AgentService, auditService *service.AuditService, logger *zap.SugaredLogger) *AgentControlHandler {
+	return &AgentControlHandler{
+		agentService: agentService,
+		auditService: auditService,
+		logger:       logger,
+	}
+}
+
+// DisconnectAgentRequest represents a force-disconnect request
+type DisconnectAgentRequest struct {
+	Reason string `json:"reason"`
+	// DenySeconds keeps the agent from reconnecting for this long (0 = allow immediately)
+	DenySeconds int `json:"denySeconds" binding:"min=0"`
+}
+
+// DisconnectAgent closes an agent's connection and optionally denylists it
+// POST /api/agents/:id/disconnect
+func (h *AgentControlHandler) DisconnectAgent(c *gin.Context) {
+	agentID := c.Param("id")
+
+	var req DisconnectAgentRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.DenySeconds > maxDenySeconds {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "denySeconds must not exceed 86400"})
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "disconnected by administrator"
+	}
+
+	denyFor := time.Duration(req.DenySeconds) * time.Second
+	agent, err := h.agentService.ForceDisconnect(agentID, req.Reason, denyFor)
+
+	if h.auditService != nil {
+		entry := service.AuditEntry{
+			AgentID:     agentID,
+			CommandType: "FORCE_DISCONNECT",
+			Params: map[string]string{
+				"reason":      req.Reason,
+				"denySeconds": strconv.Itoa(req.DenySeconds),
+			},
+			Success:   err == nil,
+			IPAddress: c.ClientIP(),
+		}
+		if user := GetCurrentUser(c); user != nil {
+			entry.UserID = user.ID
+			entry.Username = user.Username
+		}
+		if agent != nil {
+			entry.AgentHostname = agent.Hostname
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		h.auditService.LogCommand(entry)
+	}
+
+	if err != nil {
+		if errors.Is(err, service.ErrAgentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+			return
+		}
+		h.logger.Errorf("Failed to disconnect agent %s: %v", agentID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disconnect agent"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"agentId":     agentID,
+		"hostname":    agent.Hostname,
+		"denySeconds": req.DenySeconds,
+	})
+}
+
+// DeregisterAgent permanently removes an agent, notifying the lifecycle
+// webhook when one is configured
+// DELETE /api/agents/:agentId
+func (h *AgentControlHandler) DeregisterAgent(c *gin.Context) {
+	agentID := c.Param("agentId")
+
+	var hostname string
+	if agent := h.agentService.GetAgent(agentID); agent != nil {
+		hostname = agent.Hostname
+	} else if event, ok := h.agentService.GetOfflineStatus(agentID); ok {
+		hostname = event.Hostname
+	}
+
+	err := h.agentService.DeregisterAgent(agentID)
+
+	if h.auditService != nil {
+		entry := service.AuditEntry{
+			AgentID:       agentID,
+			AgentHostname: hostname,
+			CommandType:   "DEREGISTER",
+			Success:       err == nil,
+			IPAddress:     c.ClientIP(),
+		}
+		if user := GetCurrentUser(c); user != nil {
+			entry.UserID = user.ID
+			entry.Username = user.Username
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		h.auditService.LogCommand(entry)
+	}
+
+	if err != nil {
+		if errors.Is(err, service.ErrAgentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+			return
+		}
+		h.logger.Errorf("Failed to deregister agent %s: %v", agentID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to deregister agent"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"agentId": agentID, "hostname": hostname})
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"net/http"
+
+	grpcserver "github.com/chenqi92/NanoLink/apps/server/internal/grpc"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// AgentVersionHandler reports the agent versions in the fleet
+type AgentVersionHandler struct {
+	grpcServer  *grpcserver.Server
+	permService *service.PermissionService
+	logger      *zap.SugaredLogger
+}
+
+// NewAgentVersionHandler creates a new agent version handler
+func NewAgentVersionHandler(grpcServer *grpcserver.Server, permService *service.PermissionService, logger *zap.SugaredLogger) *AgentVersionHandler {
+	return &AgentVersionHandler{
+		grpcServer:  grpcServer,
+		permService: permService,
+		logger:      logger,
+	}
+}
+
+// GetAgentVersions counts the agents the user can see by version and lists
+// those below the supported agent version
+// GET /api/agents/versions
+func (h *AgentVersionHandler) GetAgentVersions(c *gin.Context) {
+	user := GetCurrentUser(c)
+	if h.permService == nil || (user != nil && user.IsSuperAdmin) {
+		c.JSON(http.StatusOK, h.grpcServer.AgentVersionReport(nil))
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+
+	visibleAgents, err := h.permService.GetVisibleAgents(user.ID)
+	if err != nil {
+		h.logger.Errorf("Failed to get visible agents: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get visible agents"})
+		return
+	}
+	// nil means all agents are visible
+	if visibleAgents == nil {
+		c.JSON(http.StatusOK, h.grpcServer.AgentVersionReport(nil))
+		return
+	}
+	visible := make(map[string]bool, len(visibleAgents))
+	for _, id := range visibleAgents {
+		visible[id] = true
+	}
+	c.JSON(http.StatusOK, h.grpcServer.AgentVersionReport(func(agentID string) bool { return visible[agentID] }))
+}
@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// GetAlerts returns a page of recent alerts, newest first
+// GET /api/alerts?agentId=&kind=&status=firing|resolved&limit=&offset=
+func (h *Handler) GetAlerts(c *gin.Context) {
+	h.queryAlertStore(c, h.alertStore)
+}
+
+// GetEvents returns a page of recent agent events, newest first
+// GET /api/events?agentId=&kind=&limit=&offset=
+func (h *Handler) GetEvents(c *gin.Context) {
+	h.queryAlertStore(c, h.eventStore)
+}
+
+func (h *Handler) queryAlertStore(c *gin.Context, store *service.AlertStore) {
+	query := service.AlertQuery{
+		AgentID: c.Query("agentId"),
+		Kind:    c.Query("kind"),
+		Limit:   100,
+	}
+
+	switch c.Query("status") {
+	case "":
+	case "firing":
+		firing := true
+		query.Firing = &firing
+	case "resolved":
+		firing := false
+		query.Firing = &firing
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be firing or resolved"})
+		return
+	}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil {
+			// Cap maximum limit to prevent resource exhaustion
+			if l > 1000 {
+				l = 1000
+			}
+			if l < 1 {
+				l = 1
+			}
+			query.Limit = l
+		}
+	}
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o > 0 {
+			query.Offset = o
+		}
+	}
+
+	user := GetCurrentUser(c)
+	if h.permService != nil && (user == nil || !user.IsSuperAdmin) {
+		if user == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+			return
+		}
+		visibleAgents, err := h.permService.GetVisibleAgents(user.ID)
+		if err != nil {
+			h.logger.Errorf("Failed to get visible agents: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get visible agents"})
+			return
+		}
+		// nil means all agents are visible
+		if visibleAgents != nil {
+			query.Visible = make(map[string]bool, len(visibleAgents))
+			for _, id := range visibleAgents {
+				query.Visible[id] = true
+			}
+		}
+	}
+
+	if store == nil {
+		c.JSON(http.StatusOK, service.AlertPage{Items: []service.AlertRecord{}, Limit: query.Limit, Offset: query.Offset})
+		return
+	}
+	c.JSON(http.StatusOK, store.Query(query))
+}
+
+// AckAlertRequest is the body of POST /api/alerts/:id/ack
+type AckAlertRequest struct {
+	Note string `json:"note" binding:"max=1000"`
+}
+
+// AckAlert acknowledges a firing alert, optionally with a note, which stops
+// repeat notifications for it until it resolves
+// POST /api/alerts/:id/ack
+func (h *Handler) AckAlert(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid alert id"})
+		return
+	}
+	var req AckAlertRequest
+	// The body is optional
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if h.alertStore == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": service.ErrAlertNotFound.Error()})
+		return
+	}
+	alert, ok := h.alertStore.Get(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": service.ErrAlertNotFound.Error()})
+		return
+	}
+
+	user := GetCurrentUser(c)
+	if h.permService != nil && (user == nil || !user.IsSuperAdmin) {
+		if user == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+			return
+		}
+		canAccess, err := h.permService.CanUserAccessAgent(user.ID, alert.AgentID)
+		if err != nil || !canAccess {
+			// Alerts on hidden agents are indistinguishable from missing ones
+			c.JSON(http.StatusNotFound, gin.H{"error": service.ErrAlertNotFound.Error()})
+			return
+		}
+	}
+
+	ackedBy := ""
+	if user != nil {
+		ackedBy = user.Username
+	}
+	alert, err = h.alertStore.Acknowledge(id, ackedBy, req.Note)
+	switch {
+	case errors.Is(err, service.ErrAlertNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrAlertNotFiring):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, alert)
+	}
+}
+
+// TestAlertRuleRequest is the body of POST /api/alerts/rules/test
+type TestAlertRuleRequest struct {
+	Rule     service.MetricAlertRule `json:"rule"`
+	Start    string                  `json:"start" binding:"required"` // ISO8601 or Unix milliseconds
+	End      string                  `json:"end"`                      // defaults to now
+	AgentIDs []string                `json:"agentIds"`                 // defaults to every agent with history
+}
+
+// TestAlertRule replays a rule against persisted history and reports when it
+// would have fired for each agent, without creating any alerts
+// POST /api/alerts/rules/test
+func (h *Handler) TestAlertRule(c *gin.Context) {
+	var req TestAlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if h.metricsPersistence == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "metrics persistence is not enabled"})
+		return
+	}
+
+	start, _ := parseTimestamp(req.Start)
+	if start.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid start timestamp"})
+		return
+	}
+	end := time.Now()
+	if req.End != "" {
+		if end, _ = parseTimestamp(req.End); end.IsZero() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid end timestamp"})
+			return
+		}
+	}
+	if !end.After(start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end must be after start"})
+		return
+	}
+	if end.Sub(start) > service.MaxRuleSimulationRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "time range must not exceed 31 days"})
+		return
+	}
+
+	agentIDs := req.AgentIDs
+	if len(agentIDs) == 0 {
+		var err error
+		if agentIDs, err = h.metricsPersistence.AgentsWithHistory(start, end); err != nil {
+			h.logger.Errorf("Failed to list agents with history: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list agents"})
+			return
+		}
+	}
+
+	user := GetCurrentUser(c)
+	if h.permService != nil && (user == nil || !user.IsSuperAdmin) {
+		if user == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+			return
+		}
+		visibleAgents, err := h.permService.GetVisibleAgents(user.ID)
+		if err != nil {
+			h.logger.Errorf("Failed to get visible agents: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get visible agents"})
+			return
+		}
+		// nil means all agents are visible
+		if visibleAgents != nil {
+			visible := make(map[string]bool, len(visibleAgents))
+			for _, id := range visibleAgents {
+				visible[id] = true
+			}
+			allowed := make([]string, 0, len(agentIDs))
+			for _, id := range agentIDs {
+				if visible[id] {
+					allowed = append(allowed, id)
+				} else if len(req.AgentIDs) > 0 {
+					// Explicitly requested agents must all be accessible
+					c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+					return
+				}
+			}
+			agentIDs = allowed
+		}
+	}
+
+	simulation, err := h.metricsPersistence.SimulateRule(req.Rule, agentIDs, start, end)
+	if errors.Is(err, service.ErrInvalidAlertRule) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to simulate alert rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to simulate rule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, simulation)
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -24,7 +25,9 @@ func NewAuditHandler(auditService *service.AuditService, logger *zap.SugaredLogg
 	}
 }
 
-// QueryAuditLogs queries audit logs with filters
+// QueryAuditLogs queries audit logs with filters, sorted by orderBy
+// (timestamp, username, agentId, commandType, success or durationMs;
+// ascending unless desc=true) or newest first
 // GET /api/audit/logs
 func (h *AuditHandler) QueryAuditLogs(c *gin.Context) {
 	query := service.AuditQuery{}
@@ -36,8 +39,11 @@ func (h *AuditHandler) QueryAuditLogs(c *gin.Context) {
 		}
 	}
 
+	query.Username = c.Query("username")
 	query.AgentID = c.Query("agentId")
 	query.CommandType = c.Query("commandType")
+	query.OrderBy = c.Query("orderBy")
+	query.Desc = c.Query("desc") == "true"
 
 	if successStr := c.Query("success"); successStr != "" {
 		success := successStr == "true"
@@ -78,6 +84,10 @@ func (h *AuditHandler) QueryAuditLogs(c *gin.Context) {
 	}
 
 	result, err := h.auditService.QueryLogs(query)
+	if errors.Is(err, service.ErrInvalidAuditOrder) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		h.logger.Errorf("Failed to query audit logs: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query audit logs"})
@@ -213,3 +223,59 @@ func (h *AuditHandler) GetRecentLogs(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"logs": logs})
 }
+
+// GetPermissionChanges lists audited permission and group membership changes
+// GET /api/audit/permissions
+func (h *AuditHandler) GetPermissionChanges(c *gin.Context) {
+	query := service.PermissionChangeQuery{
+		AgentID: c.Query("agentId"),
+		Action:  c.Query("action"),
+		Limit:   100,
+	}
+
+	if actorIDStr := c.Query("actorId"); actorIDStr != "" {
+		if actorID, err := strconv.ParseUint(actorIDStr, 10, 32); err == nil {
+			query.ActorID = uint(actorID)
+		}
+	}
+
+	if startStr := c.Query("startTime"); startStr != "" {
+		if t, err := time.Parse(time.RFC3339, startStr); err == nil {
+			query.StartTime = &t
+		}
+	}
+
+	if endStr := c.Query("endTime"); endStr != "" {
+		if t, err := time.Parse(time.RFC3339, endStr); err == nil {
+			query.EndTime = &t
+		}
+	}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil {
+			// Cap maximum limit to prevent resource exhaustion
+			if l > 1000 {
+				l = 1000
+			}
+			if l < 1 {
+				l = 1
+			}
+			query.Limit = l
+		}
+	}
+
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil {
+			query.Offset = o
+		}
+	}
+
+	result, err := h.auditService.QueryPermissionChanges(query)
+	if err != nil {
+		h.logger.Errorf("Failed to query permission changes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query permission changes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -1,8 +1,12 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
 	"github.com/chenqi92/NanoLink/apps/server/internal/service"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -25,7 +29,7 @@ func NewAuthHandler(authService *service.AuthService, logger *zap.SugaredLogger)
 // RegisterRequest represents a user registration request
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=50"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
 	Email    string `json:"email" binding:"omitempty,email"`
 }
 
@@ -35,10 +39,47 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
-// AuthResponse represents an authentication response
+// AuthResponse represents an authentication response. Token is a
+// short-lived access token; RefreshToken obtains a new one from
+// /auth/refresh.
 type AuthResponse struct {
-	Token string       `json:"token"`
-	User  UserResponse `json:"user"`
+	Token        string       `json:"token"`
+	RefreshToken string       `json:"refreshToken"`
+	ExpiresAt    time.Time    `json:"expiresAt"`
+	User         UserResponse `json:"user"`
+}
+
+// RefreshRequest carries the refresh token to rotate or revoke
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+func newAuthResponse(pair *service.TokenPair, user *database.User) AuthResponse {
+	return AuthResponse{
+		Token:        pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		ExpiresAt:    pair.ExpiresAt,
+		User: UserResponse{
+			ID:           user.ID,
+			Username:     user.Username,
+			Email:        user.Email,
+			IsSuperAdmin: user.IsSuperAdmin,
+		},
+	}
+}
+
+// writePasswordPolicyError writes a 400 listing every password requirement
+// err reports as failed. It returns false if err is not a policy violation.
+func writePasswordPolicyError(c *gin.Context, err error) bool {
+	var policyErr *service.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":        policyErr.Error(),
+		"requirements": policyErr.Failures,
+	})
+	return true
 }
 
 // UserResponse represents a user in API responses
@@ -63,28 +104,23 @@ func (h *AuthHandler) Register(c *gin.Context) {
 			c.JSON(http.StatusConflict, gin.H{"error": "username already exists"})
 			return
 		}
+		if writePasswordPolicyError(c, err) {
+			return
+		}
 		h.logger.Errorf("Registration failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "registration failed"})
 		return
 	}
 
-	// Generate token for the new user
-	token, err := h.authService.GenerateToken(user)
+	// Generate tokens for the new user
+	pair, err := h.authService.GenerateTokenPair(user)
 	if err != nil {
 		h.logger.Errorf("Token generation failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "token generation failed"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, AuthResponse{
-		Token: token,
-		User: UserResponse{
-			ID:           user.ID,
-			Username:     user.Username,
-			Email:        user.Email,
-			IsSuperAdmin: user.IsSuperAdmin,
-		},
-	})
+	c.JSON(http.StatusCreated, newAuthResponse(pair, user))
 }
 
 // Login handles user login
@@ -95,26 +131,74 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	token, user, err := h.authService.LoginUser(req.Username, req.Password)
+	pair, user, err := h.authService.LoginUser(req.Username, req.Password)
 	if err != nil {
 		if err == service.ErrUserNotFound || err == service.ErrInvalidPassword {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid username or password"})
 			return
 		}
+		if errors.Is(err, service.ErrExternalAccount) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "this account signs in with single sign-on"})
+			return
+		}
 		h.logger.Errorf("Login failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "login failed"})
 		return
 	}
 
-	c.JSON(http.StatusOK, AuthResponse{
-		Token: token,
-		User: UserResponse{
-			ID:           user.ID,
-			Username:     user.Username,
-			Email:        user.Email,
-			IsSuperAdmin: user.IsSuperAdmin,
-		},
-	})
+	c.JSON(http.StatusOK, newAuthResponse(pair, user))
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token.
+// The old refresh token stops working.
+// POST /api/auth/refresh
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pair, user, err := h.authService.RefreshToken(req.RefreshToken)
+	if errors.Is(err, service.ErrInvalidToken) || errors.Is(err, service.ErrTokenExpired) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+		return
+	}
+	if err != nil {
+		h.logger.Errorf("Token refresh failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token refresh failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newAuthResponse(pair, user))
+}
+
+// Logout revokes the bearer access token, if any, and the refresh token in
+// the body, if given. It works with an expired access token too.
+// POST /api/auth/logout
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	// The body is optional
+	_ = c.ShouldBindJSON(&req)
+
+	if parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2); len(parts) == 2 && strings.ToLower(parts[0]) == "bearer" {
+		if err := h.authService.RevokeToken(parts[1]); err != nil && !errors.Is(err, service.ErrInvalidToken) {
+			h.logger.Errorf("Logout failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "logout failed"})
+			return
+		}
+	}
+	if req.RefreshToken != "" {
+		if err := h.authService.RevokeRefreshToken(req.RefreshToken); err != nil {
+			h.logger.Errorf("Logout failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "logout failed"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
 }
 
 // GetMe returns the current authenticated user
@@ -190,7 +274,7 @@ func (h *AuthHandler) DeleteUser(c *gin.Context) {
 
 // UpdatePasswordRequest represents a password update request
 type UpdatePasswordRequest struct {
-	NewPassword string `json:"newPassword" binding:"required,min=6"`
+	NewPassword string `json:"newPassword" binding:"required"`
 }
 
 // UpdatePassword updates user's password
@@ -208,6 +292,13 @@ func (h *AuthHandler) UpdatePassword(c *gin.Context) {
 	}
 
 	if err := h.authService.UpdatePassword(user.ID, req.NewPassword); err != nil {
+		if writePasswordPolicyError(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrExternalAccount) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "password is managed by the identity provider"})
+			return
+		}
 		h.logger.Errorf("Password update failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update password"})
 		return
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// MaxBatchItems bounds the number of items in one batch request
+const MaxBatchItems = 500
+
+// Per-item batch errors
+var (
+	errAgentNotFound         = errors.New("agent not found")
+	errNoMetrics             = errors.New("no metrics available for agent")
+	errPermissionCheckFailed = errors.New("permission check failed")
+)
+
+// BatchError reports one failed item of a batch operation
+type BatchError struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// BatchResult reports one successful item of a batch operation, under the
+// same ID a failure of the item would be reported with
+type BatchResult struct {
+	ID   string      `json:"id"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// BatchResponse is the partial-success envelope returned by every batch
+// endpoint. One failing item never fails the whole request; callers check
+// errors to see exactly which items did not work.
+type BatchResponse struct {
+	Results   []BatchResult `json:"results"`
+	Errors    []BatchError  `json:"errors"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+}
+
+// NewBatchResponse creates an empty batch envelope
+func NewBatchResponse() *BatchResponse {
+	return &BatchResponse{
+		Results: make([]BatchResult, 0),
+		Errors:  make([]BatchError, 0),
+	}
+}
+
+// Succeed records a successful item and its data, if any
+func (b *BatchResponse) Succeed(id string, data interface{}) {
+	b.Results = append(b.Results, BatchResult{ID: id, Data: data})
+	b.Succeeded++
+}
+
+// Fail records a failed item
+func (b *BatchResponse) Fail(id string, err error) {
+	b.Errors = append(b.Errors, BatchError{ID: id, Error: err.Error()})
+	b.Failed++
+}
+
+// Status is 200 when every item succeeded and 207 Multi-Status otherwise
+func (b *BatchResponse) Status() int {
+	if b.Failed > 0 {
+		return http.StatusMultiStatus
+	}
+	return http.StatusOK
+}
+
+func writeBatch(c *gin.Context, b *BatchResponse) {
+	c.JSON(b.Status(), b)
+}
+
+// BatchMetricsRequest selects the agents for a batch metrics request
+type BatchMetricsRequest struct {
+	AgentIDs []string `json:"agentIds" binding:"required,min=1"`
+	PerCore  *bool    `json:"perCore"`
+}
+
+// GetBatchMetrics returns current metrics for several agents at once
+// POST /api/metrics/batch
+func (h *Handler) GetBatchMetrics(c *gin.Context) {
+	var req BatchMetricsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.AgentIDs) > MaxBatchItems {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many agents in one batch", "max": MaxBatchItems})
+		return
+	}
+
+	user := GetCurrentUser(c)
+	if user == nil && h.permService != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+
+	batch := NewBatchResponse()
+	for _, agentID := range req.AgentIDs {
+		if h.permService != nil && !user.IsSuperAdmin {
+			canAccess, err := h.permService.CanUserAccessAgent(user.ID, agentID)
+			if err != nil {
+				h.logger.Warnf("Permission check for agent %s failed: %v", agentID, err)
+				batch.Fail(agentID, errPermissionCheckFailed)
+				continue
+			}
+			// Agents the user cannot see are reported like unknown agents
+			if !canAccess {
+				batch.Fail(agentID, errAgentNotFound)
+				continue
+			}
+		}
+
+		metrics := h.metricsService.GetCurrentMetrics(agentID)
+		if metrics == nil {
+			batch.Fail(agentID, errNoMetrics)
+			continue
+		}
+		if req.PerCore != nil && !*req.PerCore {
+			metrics = service.OverviewMetrics(metrics)
+		}
+		batch.Succeed(agentID, metrics)
+	}
+
+	writeBatch(c, batch)
+}
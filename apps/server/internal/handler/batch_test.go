@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	grpcserver "github.com/chenqi92/NanoLink/apps/server/internal/grpc"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestBatchMetricsPartialFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(logger)
+	h := NewHandler(service.NewAgentService(logger, ms), ms, logger)
+
+	ms.StoreMetrics("agent-1", &service.MetricsData{AgentID: "agent-1", CPU: service.CPUData{UsagePercent: 12}})
+	ms.StoreMetrics("agent-3", &service.MetricsData{AgentID: "agent-3", CPU: service.CPUData{UsagePercent: 34}})
+
+	router := gin.New()
+	router.POST("/api/metrics/batch", h.GetBatchMetrics)
+
+	body := `{"agentIds": ["agent-1", "agent-2", "agent-3"]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/metrics/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("Expected status 207, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp struct {
+		Results []struct {
+			ID   string              `json:"id"`
+			Data service.MetricsData `json:"data"`
+		} `json:"results"`
+		Errors    []BatchError `json:"errors"`
+		Succeeded int          `json:"succeeded"`
+		Failed    int          `json:"failed"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Succeeded != 2 || resp.Failed != 1 {
+		t.Errorf("Expected 2 succeeded and 1 failed, got %d and %d", resp.Succeeded, resp.Failed)
+	}
+	if len(resp.Results) != 2 || resp.Results[0].ID != "agent-1" || resp.Results[1].ID != "agent-3" {
+		t.Errorf("Expected results for agent-1 and agent-3, got %+v", resp.Results)
+	}
+	if resp.Results[1].Data.CPU.UsagePercent != 34 {
+		t.Errorf("Expected agent-3's metrics in its result, got %+v", resp.Results[1].Data)
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].ID != "agent-2" || resp.Errors[0].Error == "" {
+		t.Errorf("Expected one error for agent-2, got %+v", resp.Errors)
+	}
+}
+
+func TestBatchResponseStatus(t *testing.T) {
+	batch := NewBatchResponse()
+	batch.Succeed("item-1", nil)
+	if batch.Status() != http.StatusOK {
+		t.Errorf("Expected 200 when every item succeeded, got %d", batch.Status())
+	}
+	batch.Fail("item-2", errNoMetrics)
+	if batch.Status() != http.StatusMultiStatus {
+		t.Errorf("Expected 207 with a failed item, got %d", batch.Status())
+	}
+
+	data, err := json.Marshal(NewBatchResponse())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"results":[]`) || !strings.Contains(string(data), `"errors":[]`) {
+		t.Errorf("Expected empty lists rather than null, got %s", data)
+	}
+}
+
+func TestRequestDataFromAllKeepsSummaryFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(logger)
+	gs := grpcserver.NewServer(config.Default(), service.NewAgentService(logger, ms), ms, logger)
+
+	router := gin.New()
+	router.POST("/api/agents/data-request", NewDataRequestHandler(gs, logger).RequestDataFromAll)
+	req := httptest.NewRequest(http.MethodPost, "/api/agents/data-request", strings.NewReader(`{"requestType":"full"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	// The envelope is added next to the fields callers already read
+	for _, field := range []string{"results", "errors", "succeeded", "failed", "success", "totalAgents", "successCount", "failedAgents", "requestType", "message"} {
+		if _, ok := resp[field]; !ok {
+			t.Errorf("Expected %q in the response, got %s", field, rec.Body.String())
+		}
+	}
+}
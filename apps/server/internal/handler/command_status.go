@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// CommandStatusHandler reports the state of commands dispatched to agents
+type CommandStatusHandler struct {
+	tracker *service.CommandTracker
+}
+
+// NewCommandStatusHandler creates a new command status handler
+func NewCommandStatusHandler(tracker *service.CommandTracker) *CommandStatusHandler {
+	return &CommandStatusHandler{tracker: tracker}
+}
+
+// GetCommandStatus returns a command record, including the metrics
+// snapshots taken before and after it ran when snapshots are enabled
+// GET /api/agents/:id/commands/:commandId
+func (h *CommandStatusHandler) GetCommandStatus(c *gin.Context) {
+	record, ok := h.tracker.Get(c.Param("commandId"))
+	if !ok || record.AgentID != c.Param("id") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "command not found"})
+		return
+	}
+	c.JSON(http.StatusOK, record)
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ConfigBackupHandler handles configuration export/import API requests
+type ConfigBackupHandler struct {
+	backupService *service.ConfigBackupService
+	serverEvents  *service.ServerEventBus
+	logger        *zap.SugaredLogger
+}
+
+// NewConfigBackupHandler creates a new config backup handler
+func NewConfigBackupHandler(backupService *service.ConfigBackupService, logger *zap.SugaredLogger) *ConfigBackupHandler {
+	return &ConfigBackupHandler{
+		backupService: backupService,
+		logger:        logger,
+	}
+}
+
+// SetServerEvents publishes completed imports on bus
+func (h *ConfigBackupHandler) SetServerEvents(bus *service.ServerEventBus) {
+	h.serverEvents = bus
+}
+
+// ExportConfig returns groups, agent assignments, user permissions and roles
+// GET /api/config/export
+func (h *ConfigBackupHandler) ExportConfig(c *gin.Context) {
+	export, err := h.backupService.Export()
+	if err != nil {
+		h.logger.Errorf("Config export failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export configuration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, export)
+}
+
+// ImportConfig restores configuration from an export document
+// POST /api/config/import?dryRun=true
+func (h *ConfigBackupHandler) ImportConfig(c *gin.Context) {
+	var export service.ConfigExport
+	if err := c.ShouldBindJSON(&export); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dryRun := c.Query("dryRun") == "true"
+
+	result, err := h.backupService.Import(&export, dryRun)
+	if err != nil {
+		if errors.Is(err, service.ErrUnsupportedExportVersion) ||
+			errors.Is(err, service.ErrInvalidConfigReference) ||
+			errors.Is(err, service.ErrInvalidPermissionLevel) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Errorf("Config import failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to import configuration"})
+		return
+	}
+
+	if !dryRun {
+		username := ""
+		if user := GetCurrentUser(c); user != nil {
+			username = user.Username
+			h.logger.Infof("Configuration imported by %s", user.Username)
+		}
+		if h.serverEvents != nil {
+			h.serverEvents.Publish(service.ServerEventConfigImported, "info",
+				"groups and permissions restored from a configuration import",
+				map[string]string{"user": username})
+		}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -1,32 +1,57 @@
 package handler
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
 	"github.com/gin-gonic/gin"
+	"github.com/pelletier/go-toml/v2"
 	"go.uber.org/zap"
+	"go.yaml.in/yaml/v3"
 )
 
 // ConfigGenHandler handles agent configuration generation
 type ConfigGenHandler struct {
-	cfg    *config.Config
-	logger *zap.SugaredLogger
+	cfg     *config.Config
+	tokens  *service.AgentTokenService
+	revoker AgentTokenRevoker
+	logger  *zap.SugaredLogger
 }
 
-// NewConfigGenHandler creates a new configuration generator handler
-func NewConfigGenHandler(cfg *config.Config, logger *zap.SugaredLogger) *ConfigGenHandler {
+// AgentTokenRevoker ends the sessions and connections of agents that
+// authenticated with a revoked token
+type AgentTokenRevoker interface {
+	RevokeAgentToken(tokenID uint)
+}
+
+// NewConfigGenHandler creates a new configuration generator handler. Tokens
+// are issued into tokens, which may be nil when no database is available.
+func NewConfigGenHandler(cfg *config.Config, tokens *service.AgentTokenService, logger *zap.SugaredLogger) *ConfigGenHandler {
 	return &ConfigGenHandler{
 		cfg:    cfg,
+		tokens: tokens,
 		logger: logger,
 	}
 }
 
+// defaultAgentGRPCPort is the gRPC port agents connect to when the server
+// URL names none
+const defaultAgentGRPCPort = 39102
+
 // GenerateConfigRequest represents a request to generate agent configuration
 type GenerateConfigRequest struct {
 	// Server URL (ws:// or wss://) - can be IP or domain
@@ -43,12 +68,35 @@ type GenerateConfigRequest struct {
 	ShellEnabled bool `json:"shellEnabled"`
 	// Super token for shell commands
 	SuperToken string `json:"superToken"`
+	// Preview only: missing tokens are filled with placeholders instead of
+	// being generated
+	DryRun bool `json:"dryRun"`
+	// Config file format: yaml (default), toml or json
+	Format string `json:"format"`
 }
 
+// Agent config file formats
+const (
+	ConfigFormatYAML = "yaml"
+	ConfigFormatTOML = "toml"
+	ConfigFormatJSON = "json"
+)
+
+// Placeholders used in dry-run previews in place of generated tokens
+const (
+	PlaceholderToken      = "<AGENT_TOKEN_PLACEHOLDER>"
+	PlaceholderSuperToken = "<SUPER_TOKEN_PLACEHOLDER>"
+)
+
 // GenerateConfigResponse represents the generated configuration
 type GenerateConfigResponse struct {
-	// YAML configuration content
-	ConfigYAML string `json:"configYaml"`
+	// Configuration content in the requested format
+	Config string `json:"config"`
+	// Format of Config
+	Format string `json:"format"`
+	// YAML configuration content, only set for the yaml format. Kept for
+	// clients that predate Format.
+	ConfigYAML string `json:"configYaml,omitempty"`
 	// Installation command for Linux/macOS
 	InstallCommandUnix string `json:"installCommandUnix"`
 	// Installation command for Windows
@@ -57,6 +105,8 @@ type GenerateConfigResponse struct {
 	GeneratedToken string `json:"generatedToken,omitempty"`
 	// Server ID (hash of URL for identification)
 	ServerID string `json:"serverId"`
+	// Set for dry-run previews, whose tokens may be placeholders
+	DryRun bool `json:"dryRun,omitempty"`
 }
 
 // GenerateConfig generates agent configuration
@@ -66,6 +116,15 @@ func (h *ConfigGenHandler) GenerateConfig(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	format := strings.ToLower(req.Format)
+	switch format {
+	case "":
+		format = ConfigFormatYAML
+	case ConfigFormatYAML, ConfigFormatTOML, ConfigFormatJSON:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be yaml, toml or json"})
+		return
+	}
 
 	// Validate server URL - support both legacy ws:// and new host:port format
 	serverURL := req.ServerURL
@@ -79,11 +138,10 @@ func (h *ConfigGenHandler) GenerateConfig(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid serverUrl: " + err.Error()})
 			return
 		}
-		host = parsedURL.Hostname()
-		if parsedURL.Port() != "" {
-			fmt.Sscanf(parsedURL.Port(), "%d", &port)
-		} else {
-			port = 39102 // Default gRPC port
+		host, port, err = parseHostPort(parsedURL.Host, defaultAgentGRPCPort)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid serverUrl: " + err.Error()})
+			return
 		}
 	} else {
 		// New format: host:port or just host
@@ -91,25 +149,29 @@ func (h *ConfigGenHandler) GenerateConfig(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "serverUrl should be host:port format, not URL"})
 			return
 		}
-		parts := strings.Split(serverURL, ":")
-		host = parts[0]
-		if len(parts) > 1 {
-			fmt.Sscanf(parts[1], "%d", &port)
-		} else {
-			port = 39102 // Default gRPC port
+		var err error
+		host, port, err = parseHostPort(serverURL, defaultAgentGRPCPort)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid serverUrl: " + err.Error()})
+			return
 		}
 	}
 
-	// Build the final connection string for gRPC
-	connString := fmt.Sprintf("%s:%d", host, port)
+	// Build the final connection string for gRPC; IPv6 hosts are bracketed
+	connString := net.JoinHostPort(host, strconv.Itoa(port))
 
-	// Generate token if not provided
+	// Generate token if not provided; previews only show where it goes
 	generatedToken := ""
 	token := req.Token
-	if token == "" {
+	if token == "" && req.DryRun {
+		token = PlaceholderToken
+	} else if token == "" {
 		token = generateSecureToken(32)
 		generatedToken = token
 	}
+	if req.ShellEnabled && req.SuperToken == "" && req.DryRun {
+		req.SuperToken = PlaceholderSuperToken
+	}
 
 	// Validate permission level
 	if req.Permission < 0 || req.Permission > 3 {
@@ -119,19 +181,37 @@ func (h *ConfigGenHandler) GenerateConfig(c *gin.Context) {
 	// Generate server ID from URL
 	serverID := generateServerID(req.ServerURL)
 
-	// Generate YAML configuration (using gRPC format: host:port)
-	configYAML := generateYAMLConfig(req, token, connString)
+	configText, err := marshalAgentConfig(buildAgentConfig(req, token, host, port), format)
+	if err != nil {
+		h.logger.Errorf("Failed to marshal agent config as %s: %v", format, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate config"})
+		return
+	}
+	// JSON has no comments, so only the dryRun field marks it as a preview
+	if format != ConfigFormatJSON {
+		configText = "# NanoLink Agent Configuration\n# Generated by NanoLink Server\n\n" + configText
+		if req.DryRun {
+			configText = "# PREVIEW ONLY: placeholder tokens must be replaced before use\n" + configText
+		}
+	}
+	configYAML := ""
+	if format == ConfigFormatYAML {
+		configYAML = configText
+	}
 
 	// Generate installation commands
 	installUnix := generateUnixInstallCommand(req, token, connString)
 	installWindows := generateWindowsInstallCommand(req, token, connString)
 
 	c.JSON(http.StatusOK, GenerateConfigResponse{
+		Config:                configText,
+		Format:                format,
 		ConfigYAML:            configYAML,
 		InstallCommandUnix:    installUnix,
 		InstallCommandWindows: installWindows,
 		GeneratedToken:        generatedToken,
 		ServerID:              serverID,
+		DryRun:                req.DryRun,
 	})
 }
 
@@ -212,7 +292,7 @@ func (h *ConfigGenHandler) GetServerURLInfo(c *gin.Context) {
 	grpcPort := h.cfg.Server.GRPCPort
 
 	// Build gRPC connection URL (host:port format for gRPC)
-	grpcURL := fmt.Sprintf("%s:%d", stripPort(host), grpcPort)
+	grpcURL := net.JoinHostPort(stripPort(host), strconv.Itoa(grpcPort))
 
 	c.JSON(http.StatusOK, gin.H{
 		"wsUrl":       grpcURL, // Keep field name for backward compatibility
@@ -227,20 +307,69 @@ func (h *ConfigGenHandler) GetServerURLInfo(c *gin.Context) {
 
 // TokenInfo represents token information for the UI
 type TokenInfo struct {
-	Token      string `json:"token"`
-	Permission int    `json:"permission"`
-	Name       string `json:"name"`
+	ID           uint              `json:"id,omitempty"` // Set for tokens issued by the server
+	Token        string            `json:"token"`        // Masked
+	Permission   int               `json:"permission"`
+	Name         string            `json:"name"`
+	Source       string            `json:"source"` // "config" or "issued"
+	ExpiresAt    *time.Time        `json:"expiresAt,omitempty"`
+	Expired      bool              `json:"expired,omitempty"`
+	Hostnames    []string          `json:"hostnames,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	UseCount     int64             `json:"useCount"`
+	LastUsedAt   *time.Time        `json:"lastUsedAt,omitempty"`
+	LastUsedHost string            `json:"lastUsedHost,omitempty"`
+	RevokedAt    *time.Time        `json:"revokedAt,omitempty"`
 }
 
-// ListTokens returns configured tokens (for admin UI)
+// ListTokens returns configured and issued tokens with their expiry and
+// usage (for admin UI). Usage is only recorded for issued tokens.
 func (h *ConfigGenHandler) ListTokens(c *gin.Context) {
-	tokens := make([]TokenInfo, 0, len(h.cfg.Auth.Tokens))
-	for _, t := range h.cfg.Auth.Tokens {
-		tokens = append(tokens, TokenInfo{
+	now := time.Now()
+	configured := h.cfg.TokenList()
+	tokens := make([]TokenInfo, 0, len(configured))
+	for _, t := range configured {
+		if t.ID != 0 {
+			continue // Listed from the database below
+		}
+		info := TokenInfo{
 			Token:      maskToken(t.Token),
 			Permission: t.Permission,
 			Name:       t.Name,
-		})
+			Source:     "config",
+			ExpiresAt:  t.ExpiresAt,
+			Expired:    t.Expired(now),
+			Hostnames:  t.Hostnames,
+			Labels:     t.Labels,
+		}
+		tokens = append(tokens, info)
+	}
+
+	if h.tokens != nil {
+		stored, err := h.tokens.List()
+		if err != nil {
+			h.logger.Errorf("Failed to list agent tokens: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list tokens"})
+			return
+		}
+		for _, record := range stored {
+			t := service.AgentTokenConfig(record)
+			tokens = append(tokens, TokenInfo{
+				ID:           record.ID,
+				Token:        record.Hint,
+				Permission:   record.Permission,
+				Name:         record.Name,
+				Source:       "issued",
+				ExpiresAt:    record.ExpiresAt,
+				Expired:      t.Expired(now),
+				Hostnames:    t.Hostnames,
+				Labels:       t.Labels,
+				UseCount:     record.UseCount,
+				LastUsedAt:   record.LastUsedAt,
+				LastUsedHost: record.LastUsedHost,
+				RevokedAt:    record.RevokedAt,
+			})
+		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -249,12 +378,104 @@ func (h *ConfigGenHandler) ListTokens(c *gin.Context) {
 	})
 }
 
-// GenerateToken generates a new secure token
+// GenerateTokenRequest describes a token to issue. An empty body issues a
+// read-only token that never expires and any agent may use.
+type GenerateTokenRequest struct {
+	Name           string            `json:"name" binding:"max=100"`
+	Permission     int               `json:"permission" binding:"min=0,max=3"`
+	ExpiresInHours int               `json:"expiresInHours" binding:"min=0"` // 0 = never expires
+	Hostnames      []string          `json:"hostnames"`                      // Hostname patterns allowed to use the token, "*" globs allowed
+	Labels         map[string]string `json:"labels"`                         // Labels agents must report to use the token
+}
+
+// GenerateToken issues a new agent token, stored hashed so it is shown only
+// in this response
 func (h *ConfigGenHandler) GenerateToken(c *gin.Context) {
-	token := generateSecureToken(32)
-	c.JSON(http.StatusOK, gin.H{
-		"token": token,
-	})
+	var req GenerateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, pattern := range req.Hostnames {
+		if _, err := path.Match(pattern, ""); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid hostname pattern %q", pattern)})
+			return
+		}
+	}
+	if h.tokens == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "token storage is not available"})
+		return
+	}
+
+	spec := service.AgentTokenSpec{
+		Name:       req.Name,
+		Permission: req.Permission,
+		Hostnames:  req.Hostnames,
+		Labels:     req.Labels,
+	}
+	if req.ExpiresInHours > 0 {
+		spec.ExpiresAt = time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour)
+	}
+	token, issued, err := h.tokens.Issue(spec)
+	if err != nil {
+		h.logger.Errorf("Failed to issue agent token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
+		return
+	}
+	h.cfg.AddToken(issued)
+	h.logger.Infof("Issued agent token %d (%q, permission %d)", issued.ID, issued.Name, issued.Permission)
+
+	resp := gin.H{
+		"token":      token,
+		"id":         issued.ID,
+		"permission": issued.Permission,
+	}
+	if !spec.ExpiresAt.IsZero() {
+		resp["expiresAt"] = spec.ExpiresAt
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// RevokeTokenRequest names an issued token to revoke
+type RevokeTokenRequest struct {
+	ID uint `json:"id" binding:"required"`
+}
+
+// SetTokenRevoker disconnects the agents using a token when it is revoked
+func (h *ConfigGenHandler) SetTokenRevoker(revoker AgentTokenRevoker) {
+	h.revoker = revoker
+}
+
+// RevokeToken revokes an issued agent token. Agents using it can no longer
+// authenticate with it or resume sessions issued for it, and connected ones
+// are disconnected. Tokens from the config file are removed by editing the
+// file.
+// POST /api/config/revoke-token
+func (h *ConfigGenHandler) RevokeToken(c *gin.Context) {
+	var req RevokeTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if h.tokens == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "token storage is not available"})
+		return
+	}
+	if err := h.tokens.Revoke(req.ID); err != nil {
+		if errors.Is(err, service.ErrAgentTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to revoke agent token %d: %v", req.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke token"})
+		return
+	}
+	h.cfg.RemoveToken(req.ID)
+	if h.revoker != nil {
+		h.revoker.RevokeAgentToken(req.ID)
+	}
+	h.logger.Infof("Revoked agent token %d", req.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
 }
 
 // Helper functions
@@ -272,14 +493,28 @@ func generateServerID(serverURL string) string {
 	return hex.EncodeToString(bytes)
 }
 
+// stripPort returns host without its port. IPv6 literals are returned
+// without brackets, ready for net.JoinHostPort.
 func stripPort(host string) string {
-	if idx := strings.LastIndex(host, ":"); idx != -1 {
-		// Check if it's not an IPv6 address
-		if !strings.Contains(host[idx:], "]") {
-			return host[:idx]
-		}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
 	}
-	return host
+	// No port, though a bare IPv6 literal may still be bracketed
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// parseHostPort splits host[:port], where an IPv6 host must be bracketed
+// when followed by a port. Without a port defaultPort is used.
+func parseHostPort(hostport string, defaultPort int) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return stripPort(hostport), defaultPort, nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+	return host, port, nil
 }
 
 func maskToken(token string) string {
@@ -289,71 +524,133 @@ func maskToken(token string) string {
 	return token[:4] + "****" + token[len(token)-4:]
 }
 
-func generateYAMLConfig(req GenerateConfigRequest, token string, connString string) string {
-	hostnameConfig := ""
-	if req.Hostname != "" {
-		hostnameConfig = fmt.Sprintf("  hostname: \"%s\"", req.Hostname)
+// agentConfigFile is the part of the agent's config file the generator
+// fills in. Field order is the order written to the file.
+type agentConfigFile struct {
+	Agent     agentConfigAgent     `yaml:"agent" toml:"agent" json:"agent"`
+	Servers   []agentConfigServer  `yaml:"servers" toml:"servers" json:"servers"`
+	Collector agentConfigCollector `yaml:"collector" toml:"collector" json:"collector"`
+	Buffer    agentConfigBuffer    `yaml:"buffer" toml:"buffer" json:"buffer"`
+	Shell     *agentConfigShell    `yaml:"shell,omitempty" toml:"shell,omitempty" json:"shell,omitempty"`
+	Logging   agentConfigLogging   `yaml:"logging" toml:"logging" json:"logging"`
+}
+
+type agentConfigAgent struct {
+	HeartbeatInterval int    `yaml:"heartbeat_interval" toml:"heartbeat_interval" json:"heartbeat_interval"`
+	ReconnectDelay    int    `yaml:"reconnect_delay" toml:"reconnect_delay" json:"reconnect_delay"`
+	MaxReconnectDelay int    `yaml:"max_reconnect_delay" toml:"max_reconnect_delay" json:"max_reconnect_delay"`
+	Hostname          string `yaml:"hostname,omitempty" toml:"hostname,omitempty" json:"hostname,omitempty"`
+}
+
+type agentConfigServer struct {
+	Host       string `yaml:"host" toml:"host" json:"host"`
+	Port       int    `yaml:"port" toml:"port" json:"port"`
+	Token      string `yaml:"token" toml:"token" json:"token"`
+	Permission int    `yaml:"permission" toml:"permission" json:"permission"`
+	TLSEnabled bool   `yaml:"tls_enabled" toml:"tls_enabled" json:"tls_enabled"`
+	TLSVerify  bool   `yaml:"tls_verify" toml:"tls_verify" json:"tls_verify"`
+}
+
+type agentConfigCollector struct {
+	RealtimeIntervalMs int  `yaml:"realtime_interval_ms" toml:"realtime_interval_ms" json:"realtime_interval_ms"`
+	EnablePerCoreCPU   bool `yaml:"enable_per_core_cpu" toml:"enable_per_core_cpu" json:"enable_per_core_cpu"`
+}
+
+type agentConfigBuffer struct {
+	Capacity int `yaml:"capacity" toml:"capacity" json:"capacity"`
+}
+
+type agentConfigShell struct {
+	Enabled        bool                    `yaml:"enabled" toml:"enabled" json:"enabled"`
+	SuperToken     string                  `yaml:"super_token" toml:"super_token" json:"super_token"`
+	TimeoutSeconds int                     `yaml:"timeout_seconds" toml:"timeout_seconds" json:"timeout_seconds"`
+	Whitelist      []agentConfigShellAllow `yaml:"whitelist" toml:"whitelist" json:"whitelist"`
+	Blacklist      []string                `yaml:"blacklist" toml:"blacklist" json:"blacklist"`
+}
+
+type agentConfigShellAllow struct {
+	Pattern     string `yaml:"pattern" toml:"pattern" json:"pattern"`
+	Description string `yaml:"description" toml:"description" json:"description"`
+}
+
+type agentConfigLogging struct {
+	Level        string `yaml:"level" toml:"level" json:"level"`
+	AuditEnabled bool   `yaml:"audit_enabled" toml:"audit_enabled" json:"audit_enabled"`
+}
+
+// buildAgentConfig builds the config file for an agent connecting to
+// host:port with token
+func buildAgentConfig(req GenerateConfigRequest, token, host string, port int) agentConfigFile {
+	cfg := agentConfigFile{
+		Agent: agentConfigAgent{
+			HeartbeatInterval: 30,
+			ReconnectDelay:    5,
+			MaxReconnectDelay: 300,
+			Hostname:          req.Hostname,
+		},
+		Servers: []agentConfigServer{{
+			Host:       host,
+			Port:       port,
+			Token:      token,
+			Permission: req.Permission,
+			TLSEnabled: req.TLSVerify,
+			TLSVerify:  req.TLSVerify,
+		}},
+		Collector: agentConfigCollector{RealtimeIntervalMs: 1000, EnablePerCoreCPU: true},
+		Buffer:    agentConfigBuffer{Capacity: 600},
+		Logging:   agentConfigLogging{Level: "info", AuditEnabled: true},
 	}
 
-	shellConfig := ""
 	if req.ShellEnabled {
 		superToken := req.SuperToken
 		if superToken == "" {
 			superToken = generateSecureToken(32)
 		}
-		shellConfig = fmt.Sprintf(`
-shell:
-  enabled: true
-  super_token: "%s"
-  timeout_seconds: 30
-  whitelist:
-    - pattern: "df -h"
-      description: "Show disk space"
-    - pattern: "free -m"
-      description: "Show memory"
-  blacklist:
-    - "rm -rf"
-    - "mkfs"
-    - "> /dev"`, superToken)
-	}
-
-	// Extract host and port from connString
-	parts := strings.Split(connString, ":")
-	host := parts[0]
-	port := "39102"
-	if len(parts) > 1 {
-		port = parts[1]
-	}
-
-	return fmt.Sprintf(`# NanoLink Agent Configuration
-# Generated by NanoLink Server
-
-agent:
-  heartbeat_interval: 30
-  reconnect_delay: 5
-  max_reconnect_delay: 300
-%s
-
-servers:
-  - host: "%s"
-    port: %s
-    token: "%s"
-    permission: %d
-    tls_enabled: %v
-    tls_verify: %v
-
-collector:
-  realtime_interval_ms: 1000
-  enable_per_core_cpu: true
-
-buffer:
-  capacity: 600
-%s
-
-logging:
-  level: info
-  audit_enabled: true
-`, hostnameConfig, host, port, token, req.Permission, req.TLSVerify, req.TLSVerify, shellConfig)
+		cfg.Shell = &agentConfigShell{
+			Enabled:        true,
+			SuperToken:     superToken,
+			TimeoutSeconds: 30,
+			Whitelist: []agentConfigShellAllow{
+				{Pattern: "df -h", Description: "Show disk space"},
+				{Pattern: "free -m", Description: "Show memory"},
+			},
+			Blacklist: []string{"rm -rf", "mkfs", "> /dev"},
+		}
+	}
+	return cfg
+}
+
+// marshalAgentConfig writes an agent config in format, which must be one of
+// the ConfigFormat constants
+func marshalAgentConfig(cfg agentConfigFile, format string) (string, error) {
+	var buf bytes.Buffer
+	switch format {
+	case ConfigFormatYAML:
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(cfg); err != nil {
+			return "", err
+		}
+		if err := enc.Close(); err != nil {
+			return "", err
+		}
+	case ConfigFormatTOML:
+		enc := toml.NewEncoder(&buf)
+		enc.SetIndentTables(true)
+		if err := enc.Encode(cfg); err != nil {
+			return "", err
+		}
+	case ConfigFormatJSON:
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(cfg); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown config format %q", format)
+	}
+	return buf.String(), nil
 }
 
 func generateUnixInstallCommand(req GenerateConfigRequest, token string, connString string) string {
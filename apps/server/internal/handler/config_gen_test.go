@@ -0,0 +1,263 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/pelletier/go-toml/v2"
+	"go.uber.org/zap"
+	"go.yaml.in/yaml/v3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func generateConfig(t *testing.T, h *ConfigGenHandler, body string) GenerateConfigResponse {
+	t.Helper()
+	router := gin.New()
+	router.POST("/config/generate", h.GenerateConfig)
+	req := httptest.NewRequest(http.MethodPost, "/config/generate", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp GenerateConfigResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestGenerateConfigDryRunUsesPlaceholders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.Default()
+	h := NewConfigGenHandler(cfg, nil, zap.NewNop().Sugar())
+
+	resp := generateConfig(t, h, `{"serverUrl":"nanolink.example.com:39100","shellEnabled":true,"dryRun":true}`)
+	if !resp.DryRun || resp.GeneratedToken != "" {
+		t.Errorf("Expected a dry run without a generated token, got %+v", resp)
+	}
+	for name, text := range map[string]string{
+		"config":          resp.ConfigYAML,
+		"unix command":    resp.InstallCommandUnix,
+		"windows command": resp.InstallCommandWindows,
+	} {
+		if !strings.Contains(text, PlaceholderToken) {
+			t.Errorf("Expected the %s to contain the placeholder token, got %s", name, text)
+		}
+	}
+	if !strings.Contains(resp.ConfigYAML, PlaceholderSuperToken) || !strings.HasPrefix(resp.ConfigYAML, "# PREVIEW ONLY") {
+		t.Errorf("Expected the config to be marked as a preview with a placeholder super token, got %s", resp.ConfigYAML)
+	}
+	if len(cfg.Auth.Tokens) != 0 {
+		t.Errorf("Expected no token to be recorded, got %+v", cfg.Auth.Tokens)
+	}
+
+	// A real request still generates the token
+	resp = generateConfig(t, h, `{"serverUrl":"nanolink.example.com:39100"}`)
+	if resp.DryRun || resp.GeneratedToken == "" || !strings.Contains(resp.ConfigYAML, resp.GeneratedToken) {
+		t.Errorf("Expected a generated token without dry run, got %+v", resp)
+	}
+}
+
+func TestStripPortAndParseHostPort(t *testing.T) {
+	for _, tc := range []struct {
+		in       string
+		stripped string
+		host     string
+		port     int
+	}{
+		{"192.168.1.10", "192.168.1.10", "192.168.1.10", 39102},
+		{"192.168.1.10:9100", "192.168.1.10", "192.168.1.10", 9100},
+		{"::1", "::1", "::1", 39102},
+		{"fe80::1", "fe80::1", "fe80::1", 39102},
+		{"[::1]", "::1", "::1", 39102},
+		{"[::1]:9100", "::1", "::1", 9100},
+		{"nanolink.example.com:39100", "nanolink.example.com", "nanolink.example.com", 39100},
+	} {
+		if got := stripPort(tc.in); got != tc.stripped {
+			t.Errorf("stripPort(%q) = %q, expected %q", tc.in, got, tc.stripped)
+		}
+		host, port, err := parseHostPort(tc.in, 39102)
+		if err != nil || host != tc.host || port != tc.port {
+			t.Errorf("parseHostPort(%q) = %q, %d, %v, expected %q, %d", tc.in, host, port, err, tc.host, tc.port)
+		}
+	}
+	if _, _, err := parseHostPort("example.com:http", 39102); err == nil {
+		t.Error("Expected a non-numeric port to be rejected")
+	}
+}
+
+func TestConfigGenBracketsIPv6Hosts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.Default()
+	cfg.Server.GRPCPort = 9100
+	h := NewConfigGenHandler(cfg, nil, zap.NewNop().Sugar())
+
+	router := gin.New()
+	router.GET("/config/server-info", h.GetServerURLInfo)
+	for host, want := range map[string]string{
+		"10.0.0.5:8080": "10.0.0.5:9100",
+		"10.0.0.5":      "10.0.0.5:9100",
+		"[::1]:8080":    "[::1]:9100",
+		"[::1]":         "[::1]:9100",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/config/server-info", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var info struct {
+			GrpcURL string `json:"grpcUrl"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &info)
+		if info.GrpcURL != want {
+			t.Errorf("Host %q: expected %q, got %q", host, want, info.GrpcURL)
+		}
+	}
+
+	for _, tc := range []struct{ serverURL, conn, host string }{
+		{"[::1]:39100", "[::1]:39100", "::1"},
+		{"::1", "[::1]:39102", "::1"},
+		{"ws://[fe80::2]:9000", "[fe80::2]:9000", "fe80::2"},
+	} {
+		resp := generateConfig(t, h, `{"serverUrl":"`+tc.serverURL+`","dryRun":true}`)
+		if !strings.Contains(resp.InstallCommandUnix, `--url "`+tc.conn+`"`) {
+			t.Errorf("%s: expected %s in %s", tc.serverURL, tc.conn, resp.InstallCommandUnix)
+		}
+		var file agentConfigFile
+		if err := yaml.Unmarshal([]byte(resp.ConfigYAML), &file); err != nil || file.Servers[0].Host != tc.host {
+			t.Errorf("%s: expected host %s in %s (%v)", tc.serverURL, tc.host, resp.ConfigYAML, err)
+		}
+	}
+}
+
+func TestGenerateConfigFormats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewConfigGenHandler(config.Default(), nil, zap.NewNop().Sugar())
+
+	// Quotes, colons and comment markers must survive every format
+	hostname := `web "01": #edge`
+	token := `tok'en"#1`
+	unmarshal := map[string]func([]byte, any) error{
+		ConfigFormatYAML: yaml.Unmarshal,
+		ConfigFormatTOML: toml.Unmarshal,
+		ConfigFormatJSON: json.Unmarshal,
+	}
+	for format, decode := range unmarshal {
+		body, _ := json.Marshal(map[string]any{
+			"serverUrl":    "[::1]:39100",
+			"token":        token,
+			"hostname":     hostname,
+			"shellEnabled": true,
+			"superToken":   "s: 'x'",
+			"format":       format,
+		})
+		resp := generateConfig(t, h, string(body))
+		if resp.Format != format || (resp.ConfigYAML != "") != (format == ConfigFormatYAML) {
+			t.Errorf("%s: unexpected format fields %q, configYaml set: %v", format, resp.Format, resp.ConfigYAML != "")
+		}
+		var file agentConfigFile
+		if err := decode([]byte(resp.Config), &file); err != nil {
+			t.Fatalf("%s: config does not parse: %v\n%s", format, err, resp.Config)
+		}
+		server := file.Servers[0]
+		if file.Agent.Hostname != hostname || server.Host != "::1" || server.Port != 39100 || server.Token != token {
+			t.Errorf("%s: unexpected agent or server %+v %+v", format, file.Agent, server)
+		}
+		if file.Shell == nil || file.Shell.SuperToken != "s: 'x'" || len(file.Shell.Whitelist) != 2 {
+			t.Errorf("%s: unexpected shell section %+v", format, file.Shell)
+		}
+	}
+
+	// YAML stays the default
+	if resp := generateConfig(t, h, `{"serverUrl":"10.0.0.5"}`); resp.Format != ConfigFormatYAML || resp.Config != resp.ConfigYAML {
+		t.Errorf("Expected YAML by default, got %q", resp.Format)
+	}
+
+	router := gin.New()
+	router.POST("/config/generate", h.GenerateConfig)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/config/generate", strings.NewReader(`{"serverUrl":"10.0.0.5","format":"ini"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown format to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestAgentTokenIssueListAndRevoke(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&database.AgentToken{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default()
+	cfg.Auth.Enabled = true
+	cfg.Auth.Tokens = []config.TokenConfig{{Token: "configured-secret", Name: "file"}}
+	h := NewConfigGenHandler(cfg, service.NewAgentTokenService(db, zap.NewNop().Sugar()), zap.NewNop().Sugar())
+	router := gin.New()
+	router.GET("/config/tokens", h.ListTokens)
+	router.POST("/config/generate-token", h.GenerateToken)
+	router.POST("/config/revoke-token", h.RevokeToken)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/config/generate-token", `{"name":"web","permission":1,"expiresInHours":24,"hostnames":["web-*"]}`)
+	var issued struct {
+		Token string `json:"token"`
+		ID    uint   `json:"id"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &issued)
+	if rec.Code != http.StatusOK || issued.Token == "" || issued.ID == 0 {
+		t.Fatalf("Expected a token to be issued, got %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := cfg.ValidateAgentToken(issued.Token, config.TokenScope{Hostname: "web-1"}); err != nil {
+		t.Errorf("Expected the issued token to be accepted right away, got %v", err)
+	}
+	if rec := do(http.MethodPost, "/config/generate-token", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected an empty body to issue a default token, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPost, "/config/generate-token", `{"hostnames":["web-["]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a malformed hostname pattern to be rejected, got %d", rec.Code)
+	}
+
+	if rec := do(http.MethodPost, "/config/revoke-token", fmt.Sprintf(`{"id":%d}`, issued.ID)); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the token to be revoked, got %d: %s", rec.Code, rec.Body)
+	}
+	if valid, _ := cfg.ValidateToken(issued.Token); valid {
+		t.Error("Expected a revoked token to be refused")
+	}
+	if rec := do(http.MethodPost, "/config/revoke-token", fmt.Sprintf(`{"id":%d}`, issued.ID)); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected revoking twice to be 404, got %d", rec.Code)
+	}
+
+	var list struct {
+		Tokens []TokenInfo `json:"tokens"`
+	}
+	json.Unmarshal(do(http.MethodGet, "/config/tokens", "").Body.Bytes(), &list)
+	if len(list.Tokens) != 3 || list.Tokens[0].Source != "config" || list.Tokens[0].Token != "conf****cret" {
+		t.Fatalf("Expected the configured and both issued tokens, got %+v", list.Tokens)
+	}
+	web := list.Tokens[1]
+	if web.ID != issued.ID || web.ExpiresAt == nil || web.RevokedAt == nil || web.Hostnames[0] != "web-*" || strings.Contains(web.Token, issued.Token) {
+		t.Errorf("Expected the revoked token's expiry, scope and hint, got %+v", web)
+	}
+}
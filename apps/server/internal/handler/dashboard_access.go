@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+)
+
+// How long a dashboard client's visible agents are cached before being
+// looked up again, so permission changes reach open connections
+const visibilityTTL = 30 * time.Second
+
+// VisibleAgentsInterface looks up the agents a user may see
+type VisibleAgentsInterface interface {
+	// GetVisibleAgents returns nil when every agent is visible
+	GetVisibleAgents(userID uint) ([]string, error)
+}
+
+// agentVisibility caches the agents a dashboard client's user may see
+type agentVisibility struct {
+	mu      sync.Mutex
+	agents  map[string]bool // nil when every agent is visible
+	expires time.Time
+}
+
+// SetPermissionService limits what dashboard clients receive to the agents
+// their user can see. Without it every client receives every agent.
+func (h *DashboardWSHandler) SetPermissionService(perms VisibleAgentsInterface) {
+	h.permService = perms
+}
+
+// agentVisible reports whether the user behind v may see agentID. Messages
+// not about an agent are visible to everyone. A failed lookup hides every
+// agent until the next one.
+func (h *DashboardWSHandler) agentVisible(userID uint, isSuperAdmin bool, v *agentVisibility, agentID string) bool {
+	if agentID == "" || isSuperAdmin || h.permService == nil {
+		return true
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if now := time.Now(); now.After(v.expires) {
+		v.expires = now.Add(visibilityTTL)
+		agents, err := h.permService.GetVisibleAgents(userID)
+		switch {
+		case err != nil:
+			h.logger.Warnf("Failed to get visible agents for dashboard user %d: %v", userID, err)
+			v.agents = map[string]bool{}
+		case agents == nil:
+			v.agents = nil
+		default:
+			v.agents = make(map[string]bool, len(agents))
+			for _, id := range agents {
+				v.agents[id] = true
+			}
+		}
+	}
+	return v.agents == nil || v.agents[agentID]
+}
+
+// wants reports whether client should receive msg: admin-only messages go
+// to super admins, agent messages only for visible agents, and metrics
+// only for subscribed agents when the client subscribed to any
+func (h *DashboardWSHandler) wants(client *dashboardClient, msg *BroadcastMessage) bool {
+	if msg.AdminOnly && !client.isSuperAdmin {
+		return false
+	}
+	if msg.Type == MsgTypeMetrics && msg.AgentID != "" {
+		client.mu.Lock()
+		subscribed := len(client.subscriptions) == 0 || client.subscriptions[msg.AgentID]
+		client.mu.Unlock()
+		if !subscribed {
+			return false
+		}
+	}
+	return h.agentVisible(client.userID, client.isSuperAdmin, &client.visibility, msg.AgentID)
+}
+
+// visibleAgents filters agents down to those the user may see
+func (h *DashboardWSHandler) visibleAgents(userID uint, isSuperAdmin bool, v *agentVisibility, agents []*service.Agent) []*service.Agent {
+	visible := make([]*service.Agent, 0, len(agents))
+	for _, agent := range agents {
+		if h.agentVisible(userID, isSuperAdmin, v, agent.ID) {
+			visible = append(visible, agent)
+		}
+	}
+	return visible
+}
+
+// visibleMetrics filters metrics down to the agents the user may see
+func (h *DashboardWSHandler) visibleMetrics(userID uint, isSuperAdmin bool, v *agentVisibility, metrics map[string]*service.MetricsData) map[string]*service.MetricsData {
+	visible := make(map[string]*service.MetricsData, len(metrics))
+	for agentID, data := range metrics {
+		if h.agentVisible(userID, isSuperAdmin, v, agentID) {
+			visible[agentID] = data
+		}
+	}
+	return visible
+}
@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Broadcasts kept for SSE clients resuming with Last-Event-ID
+const streamReplay = 256
+
+// Default interval between SSE keepalive comments, short enough for
+// proxies that close idle connections
+const streamKeepAlive = 15 * time.Second
+
+// streamEvent is a broadcast as sent to SSE clients
+type streamEvent struct {
+	id        uint64
+	agentID   string
+	data      []byte // DashboardMessage JSON
+	adminOnly bool
+}
+
+// streamClient is a dashboard connected over SSE
+type streamClient struct {
+	userID       uint
+	isSuperAdmin bool
+	events       chan *streamEvent
+	visibility   agentVisibility
+}
+
+// HandleStream streams dashboard broadcasts as Server-Sent Events, for
+// dashboards behind proxies that break WebSocket upgrades. Each data frame
+// is a DashboardMessage, as on the WebSocket. A client reconnecting with
+// Last-Event-ID gets the broadcasts it missed; one without, or too far
+// behind, gets the current agents, metrics and summary first.
+// GET /api/stream?token=
+func (h *DashboardWSHandler) HandleStream(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "token required"})
+		return
+	}
+
+	claims, err := h.authService.VerifyToken(token)
+	if err != nil {
+		h.logger.Warnf("Dashboard SSE auth failed: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return
+	}
+
+	lastEventID, resume := uint64(0), false
+	if header := c.GetHeader("Last-Event-ID"); header != "" {
+		if id, err := strconv.ParseUint(header, 10, 64); err == nil {
+			lastEventID, resume = id, true
+		}
+	}
+
+	client, replay, resumed := h.subscribeStream(claims.UserID, claims.IsSuperAdmin, lastEventID, resume)
+	defer h.unsubscribeStream(client)
+	h.logger.Infof("Dashboard SSE client connected: user=%s", claims.Username)
+	defer h.logger.Infof("Dashboard SSE client disconnected: user=%s", claims.Username)
+
+	w := c.Writer
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no") // Disable nginx response buffering
+	w.WriteHeader(http.StatusOK)
+
+	if !resumed {
+		for _, msg := range h.initialMessages(client.userID, client.isSuperAdmin, &client.visibility) {
+			data, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+			writeStreamFrame(w, 0, data)
+		}
+	}
+	for _, event := range replay {
+		writeStreamFrame(w, event.id, event.data)
+	}
+	w.Flush()
+
+	keepAlive := h.streamKeepAlive
+	if keepAlive <= 0 {
+		keepAlive = streamKeepAlive
+	}
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-client.events:
+			if _, err := writeStreamFrame(w, event.id, event.data); err != nil {
+				return
+			}
+			w.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			w.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// writeStreamFrame writes one SSE frame; id 0 frames carry no id, so they
+// do not move the client's Last-Event-ID
+func writeStreamFrame(w gin.ResponseWriter, id uint64, data []byte) (int, error) {
+	if id == 0 {
+		return fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	return fmt.Fprintf(w, "id: %d\ndata: %s\n\n", id, data)
+}
+
+// subscribeStream registers an SSE client. When resuming after
+// lastEventID, it also returns the broadcasts since then and whether they
+// are complete; if not the client needs the current state instead.
+func (h *DashboardWSHandler) subscribeStream(userID uint, isSuperAdmin bool, lastEventID uint64, resume bool) (*streamClient, []*streamEvent, bool) {
+	client := &streamClient{
+		userID:       userID,
+		isSuperAdmin: isSuperAdmin,
+		events:       make(chan *streamEvent, 256),
+	}
+
+	h.streamMu.Lock()
+	defer h.streamMu.Unlock()
+	h.streams[client] = true
+
+	// IDs restart with the server, and the oldest broadcasts are dropped
+	if !resume || lastEventID > h.streamSeq ||
+		(len(h.streamRecent) > 0 && h.streamRecent[0].id > lastEventID+1) {
+		return client, nil, false
+	}
+	var replay []*streamEvent
+	for _, event := range h.streamRecent {
+		if event.id > lastEventID && h.streamWants(client, event) {
+			replay = append(replay, event)
+		}
+	}
+	return client, replay, true
+}
+
+func (h *DashboardWSHandler) unsubscribeStream(client *streamClient) {
+	h.streamMu.Lock()
+	delete(h.streams, client)
+	h.streamMu.Unlock()
+}
+
+// publishStream numbers a broadcast, keeps it for replay and sends it to
+// SSE clients
+func (h *DashboardWSHandler) publishStream(msg *BroadcastMessage, data []byte) {
+	h.streamMu.Lock()
+	defer h.streamMu.Unlock()
+
+	h.streamSeq++
+	event := &streamEvent{id: h.streamSeq, agentID: msg.AgentID, data: data, adminOnly: msg.AdminOnly}
+	if len(h.streamRecent) >= streamReplay {
+		h.streamRecent = h.streamRecent[1:]
+	}
+	h.streamRecent = append(h.streamRecent, event)
+
+	for client := range h.streams {
+		if !h.streamWants(client, event) {
+			continue
+		}
+		select {
+		case client.events <- event:
+		default:
+			// Buffer full, skip this client
+		}
+	}
+}
+
+// streamWants reports whether an SSE client should receive event
+func (h *DashboardWSHandler) streamWants(client *streamClient, event *streamEvent) bool {
+	if event.adminOnly && !client.isSuperAdmin {
+		return false
+	}
+	return h.agentVisible(client.userID, client.isSuperAdmin, &client.visibility, event.agentID)
+}
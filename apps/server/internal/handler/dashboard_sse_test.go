@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// staticAuth accepts a single token
+type staticAuth struct {
+	token  string
+	claims *service.JWTClaims
+}
+
+func (a staticAuth) VerifyToken(token string) (*service.JWTClaims, error) {
+	if token != a.token {
+		return nil, errors.New("invalid token")
+	}
+	return a.claims, nil
+}
+
+// sseFrame is one SSE frame; comment is set for comment-only frames
+type sseFrame struct {
+	id      string
+	msg     DashboardMessage
+	comment string
+}
+
+type sseStream struct {
+	t      *testing.T
+	frames chan sseFrame
+	cancel context.CancelFunc
+}
+
+func openStream(t *testing.T, url, lastEventID string) *sseStream {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		cancel()
+		t.Fatalf("Expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	s := &sseStream{t: t, frames: make(chan sseFrame, 64), cancel: cancel}
+	go func() {
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		var frame sseFrame
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				s.frames <- frame
+				frame = sseFrame{}
+			case strings.HasPrefix(line, ":"):
+				frame.comment = strings.TrimSpace(line[1:])
+			case strings.HasPrefix(line, "id: "):
+				frame.id = line[len("id: "):]
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(line[len("data: "):]), &frame.msg)
+			}
+		}
+	}()
+	t.Cleanup(cancel)
+	return s
+}
+
+func (s *sseStream) next() sseFrame {
+	s.t.Helper()
+	select {
+	case frame := <-s.frames:
+		return frame
+	case <-time.After(2 * time.Second):
+		s.t.Fatal("Timed out waiting for an SSE frame")
+		return sseFrame{}
+	}
+}
+
+// nextEvent skips keepalives
+func (s *sseStream) nextEvent() sseFrame {
+	s.t.Helper()
+	for {
+		if frame := s.next(); frame.comment == "" {
+			return frame
+		}
+	}
+}
+
+func newStreamTestServer(t *testing.T) (*DashboardWSHandler, string) {
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(logger)
+	auth := staticAuth{token: "dash-token", claims: &service.JWTClaims{UserID: 1, Username: "viewer"}}
+	h := NewDashboardWSHandler(logger, auth, service.NewAgentService(logger, ms), ms)
+
+	router := gin.New()
+	router.GET("/api/stream", h.HandleStream)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return h, srv.URL + "/api/stream"
+}
+
+// waitPublished waits for the broadcast loop to number n broadcasts
+func waitPublished(t *testing.T, h *DashboardWSHandler, n uint64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		h.streamMu.Lock()
+		seq := h.streamSeq
+		h.streamMu.Unlock()
+		if seq >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d broadcasts, got %d", n, seq)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStreamRequiresToken(t *testing.T) {
+	_, url := newStreamTestServer(t)
+	for _, target := range []string{url, url + "?token=wrong"} {
+		resp, err := http.Get(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for %s, got %d", target, resp.StatusCode)
+		}
+	}
+}
+
+func TestStreamSendsBroadcastsAndResumes(t *testing.T) {
+	h, url := newStreamTestServer(t)
+	url += "?token=dash-token"
+
+	stream := openStream(t, url, "")
+	for _, want := range []DashboardMsgType{MsgTypeWelcome, MsgTypeAgents, MsgTypeMetrics, MsgTypeSummary} {
+		if frame := stream.nextEvent(); frame.msg.Type != want || frame.id != "" {
+			t.Fatalf("Expected the initial %s without an id, got %+v", want, frame)
+		}
+	}
+	if h.ClientCount() != 1 {
+		t.Errorf("Expected the SSE client to be counted, got %d", h.ClientCount())
+	}
+
+	h.BroadcastAgentUpdate("agent-1", map[string]string{"hostname": "web-1"})
+	if frame := stream.nextEvent(); frame.id != "1" || frame.msg.Type != MsgTypeAgentUpdate {
+		t.Fatalf("Expected agent_update with id 1, got %+v", frame)
+	}
+	stream.cancel()
+
+	// Broadcasts missed while disconnected are replayed, without the
+	// initial state
+	h.BroadcastMetrics("agent-1", map[string]float64{"cpu": 42})
+	h.BroadcastSummary(map[string]int{"online": 1})
+	waitPublished(t, h, 3)
+
+	resumed := openStream(t, url, "1")
+	if frame := resumed.nextEvent(); frame.id != "2" || frame.msg.Type != MsgTypeMetrics {
+		t.Fatalf("Expected the missed metrics first, got %+v", frame)
+	}
+	if frame := resumed.nextEvent(); frame.id != "3" || frame.msg.Type != MsgTypeSummary {
+		t.Fatalf("Expected the missed summary next, got %+v", frame)
+	}
+
+	// An id from before a restart gets the current state instead
+	restarted := openStream(t, url, "99")
+	if frame := restarted.nextEvent(); frame.msg.Type != MsgTypeWelcome {
+		t.Errorf("Expected the initial state for an unknown id, got %+v", frame)
+	}
+}
+
+func TestStreamKeepAlive(t *testing.T) {
+	h, url := newStreamTestServer(t)
+	h.streamKeepAlive = 20 * time.Millisecond
+
+	stream := openStream(t, url+"?token=dash-token", "")
+	for i := 0; i < 10; i++ {
+		if frame := stream.next(); frame.comment == "keepalive" {
+			return
+		}
+	}
+	t.Error("Expected a keepalive comment")
+}
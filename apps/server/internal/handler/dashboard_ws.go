@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	grpcserver "github.com/chenqi92/NanoLink/apps/server/internal/grpc"
 	"github.com/chenqi92/NanoLink/apps/server/internal/service"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -26,6 +28,34 @@ type DashboardWSHandler struct {
 	// Broadcast channel
 	broadcast chan *BroadcastMessage
 
+	// Stops the periodic summary broadcast
+	summaryStop chan struct{}
+	summaryOnce sync.Once
+
+	// Server-wide events, sent to super admins only
+	serverEvents *service.ServerEventBus
+
+	// Limits clients to the agents their user can see, when set
+	permService VisibleAgentsInterface
+
+	// SSE clients, and the numbered broadcasts they can resume from
+	streams         map[*streamClient]bool
+	streamSeq       uint64
+	streamRecent    []*streamEvent
+	streamKeepAlive time.Duration
+	streamMu        sync.Mutex
+
+	// In-process broadcast listeners, such as GraphQL subscriptions
+	listeners   map[chan *BroadcastMessage]bool
+	listenersMu sync.Mutex
+
+	// Messages dropped because a client or listener fell behind
+	drops atomic.Int64
+
+	// Starts agent log streams for clients with ServiceControl permission
+	logStreamer LogStreamer
+	logPerms    AgentPermissionChecker
+
 	upgrader websocket.Upgrader
 }
 
@@ -38,37 +68,56 @@ type dashboardClient struct {
 	conn          *websocket.Conn
 	userID        uint
 	username      string
+	isSuperAdmin  bool
 	send          chan []byte
-	subscriptions map[string]bool // agentIDs subscribed to
+	subscriptions map[string]bool // agentIDs subscribed to; none means all visible agents
 	closed        bool            // true if channel is closed
 	mu            sync.Mutex
+	visibility    agentVisibility
+	logStreams    map[string]*grpcserver.LogStream // Open log streams by ID
 }
 
 // DashboardMessage types
 type DashboardMsgType string
 
 const (
-	MsgTypeWelcome      DashboardMsgType = "welcome"
-	MsgTypeAgents       DashboardMsgType = "agents"
-	MsgTypeMetrics      DashboardMsgType = "metrics"
-	MsgTypeAgentUpdate  DashboardMsgType = "agent_update"
-	MsgTypeAgentOffline DashboardMsgType = "agent_offline"
-	MsgTypeSummary      DashboardMsgType = "summary"
-	MsgTypeSubscribe    DashboardMsgType = "subscribe"
-	MsgTypeUnsubscribe  DashboardMsgType = "unsubscribe"
-	MsgTypePing         DashboardMsgType = "ping"
-	MsgTypePong         DashboardMsgType = "pong"
+	MsgTypeWelcome       DashboardMsgType = "welcome"
+	MsgTypeAgents        DashboardMsgType = "agents"
+	MsgTypeMetrics       DashboardMsgType = "metrics"
+	MsgTypeAgentUpdate   DashboardMsgType = "agent_update"
+	MsgTypeAgentOffline  DashboardMsgType = "agent_offline"
+	MsgTypeAgentStatus   DashboardMsgType = "agent_status"
+	MsgTypeSummary       DashboardMsgType = "summary"
+	MsgTypeSecurityAlert DashboardMsgType = "security_alert"
+	MsgTypeAlert         DashboardMsgType = "alert"
+	MsgTypeServerEvent   DashboardMsgType = "server_event"
+	MsgTypeServerEvents  DashboardMsgType = "server_events" // recent history, on connect
+	MsgTypeSubscribe     DashboardMsgType = "subscribe"
+	MsgTypeUnsubscribe   DashboardMsgType = "unsubscribe"
+	MsgTypePing          DashboardMsgType = "ping"
+	MsgTypePong          DashboardMsgType = "pong"
+
+	// Agent log streaming: the client sends log_stream_start with a
+	// LogStreamRequest and log_stream_stop with a stream ID; the server
+	// answers with log_stream, then sends log_chunk messages
+	MsgTypeLogStreamStart DashboardMsgType = "log_stream_start"
+	MsgTypeLogStreamStop  DashboardMsgType = "log_stream_stop"
+	MsgTypeLogStream      DashboardMsgType = "log_stream"
+	MsgTypeLogChunk       DashboardMsgType = "log_chunk"
 )
 
 // ServerVersion is the current server version
 const ServerVersion = "0.3.3"
 
+// Recent server events sent to admins when they connect
+const serverEventReplay = 50
+
 // WelcomeData contains server information sent on connection
 type WelcomeData struct {
-	Version     string `json:"version"`
-	MinVersion  string `json:"minVersion"`
-	ServerTime  int64  `json:"serverTime"`
-	Features    []string `json:"features"`
+	Version    string   `json:"version"`
+	MinVersion string   `json:"minVersion"`
+	ServerTime int64    `json:"serverTime"`
+	Features   []string `json:"features"`
 }
 
 // DashboardMessage is the WebSocket message format
@@ -83,6 +132,8 @@ type BroadcastMessage struct {
 	Type    DashboardMsgType
 	AgentID string // optional, for agent-specific updates
 	Data    interface{}
+	// Only delivered to super admins
+	AdminOnly bool
 }
 
 // NewDashboardWSHandler creates a new dashboard WebSocket handler
@@ -99,6 +150,9 @@ func NewDashboardWSHandler(
 		metricsService: metricsService,
 		clients:        make(map[*dashboardClient]bool),
 		broadcast:      make(chan *BroadcastMessage, 256),
+		summaryStop:    make(chan struct{}),
+		streams:        make(map[*streamClient]bool),
+		listeners:      make(map[chan *BroadcastMessage]bool),
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  4096,
 			WriteBufferSize: 4096,
@@ -139,6 +193,7 @@ func (h *DashboardWSHandler) HandleDashboardWS(c *gin.Context) {
 		conn:          conn,
 		userID:        claims.UserID,
 		username:      claims.Username,
+		isSuperAdmin:  claims.IsSuperAdmin,
 		send:          make(chan []byte, 256),
 		subscriptions: make(map[string]bool),
 	}
@@ -171,44 +226,69 @@ func (h *DashboardWSHandler) unregisterClient(client *dashboardClient) {
 		close(client.send)
 	}
 	h.clientsMu.Unlock()
+	h.stopLogStreams(client)
 }
 
 func (h *DashboardWSHandler) sendInitialData(client *dashboardClient) {
-	// Send welcome message with version info
-	h.sendToClient(client, &DashboardMessage{
+	for _, msg := range h.initialMessages(client.userID, client.isSuperAdmin, &client.visibility) {
+		h.sendToClient(client, msg)
+	}
+}
+
+// initialMessages returns the state a dashboard needs when it connects,
+// limited to the agents its user can see
+func (h *DashboardWSHandler) initialMessages(userID uint, isSuperAdmin bool, v *agentVisibility) []*DashboardMessage {
+	var msgs []*DashboardMessage
+
+	// Welcome message with version info
+	features := []string{"websocket", "sse", "metrics", "agents", "commands", "layered_metrics"}
+	if h.logStreamer != nil {
+		features = append(features, "log_stream")
+	}
+	msgs = append(msgs, &DashboardMessage{
 		Type:      MsgTypeWelcome,
 		Timestamp: time.Now().UnixMilli(),
 		Data: WelcomeData{
 			Version:    ServerVersion,
 			MinVersion: "0.3.0", // Minimum compatible client version
 			ServerTime: time.Now().UnixMilli(),
-			Features:   []string{"websocket", "metrics", "agents", "commands", "layered_metrics"},
+			Features:   features,
 		},
 	})
 
-	// Send all agents
-	agents := h.agentService.GetAllAgents()
-	h.sendToClient(client, &DashboardMessage{
+	// All agents
+	agents := h.visibleAgents(userID, isSuperAdmin, v, h.agentService.GetAllAgents())
+	msgs = append(msgs, &DashboardMessage{
 		Type:      MsgTypeAgents,
 		Timestamp: time.Now().UnixMilli(),
 		Data:      agents,
 	})
 
-	// Send all metrics
-	metrics := h.metricsService.GetAllCurrentMetrics()
-	h.sendToClient(client, &DashboardMessage{
+	// All metrics
+	metrics := h.visibleMetrics(userID, isSuperAdmin, v, h.metricsService.GetAllCurrentMetrics())
+	msgs = append(msgs, &DashboardMessage{
 		Type:      MsgTypeMetrics,
 		Timestamp: time.Now().UnixMilli(),
 		Data:      metrics,
 	})
 
-	// Send summary
+	// Summary
 	summary := h.metricsService.GetSummary()
-	h.sendToClient(client, &DashboardMessage{
+	msgs = append(msgs, &DashboardMessage{
 		Type:      MsgTypeSummary,
 		Timestamp: time.Now().UnixMilli(),
 		Data:      summary,
 	})
+
+	// Recent server events for admins
+	if isSuperAdmin && h.serverEvents != nil {
+		msgs = append(msgs, &DashboardMessage{
+			Type:      MsgTypeServerEvents,
+			Timestamp: time.Now().UnixMilli(),
+			Data:      h.serverEvents.Recent(serverEventReplay),
+		})
+	}
+	return msgs
 }
 
 func (h *DashboardWSHandler) sendToClient(client *dashboardClient, msg *DashboardMessage) {
@@ -218,16 +298,17 @@ func (h *DashboardWSHandler) sendToClient(client *dashboardClient, msg *Dashboar
 		return
 	}
 
+	// Held while sending, so the channel cannot be closed meanwhile
 	client.mu.Lock()
+	defer client.mu.Unlock()
 	if client.closed {
-		client.mu.Unlock()
 		return
 	}
-	client.mu.Unlock()
 
 	select {
 	case client.send <- data:
 	default:
+		h.drops.Add(1)
 		h.logger.Warnf("Client send buffer full, dropping message")
 	}
 }
@@ -280,6 +361,14 @@ func (h *DashboardWSHandler) readPump(client *dashboardClient) {
 				delete(client.subscriptions, agentID)
 				client.mu.Unlock()
 			}
+
+		case MsgTypeLogStreamStart:
+			h.startLogStream(client, msg.Data)
+
+		case MsgTypeLogStreamStop:
+			if streamID, ok := msg.Data.(string); ok {
+				h.stopLogStream(client, streamID)
+			}
 		}
 	}
 }
@@ -326,6 +415,9 @@ func (h *DashboardWSHandler) broadcastLoop() {
 
 		h.clientsMu.RLock()
 		for client := range h.clients {
+			if !h.wants(client, msg) {
+				continue
+			}
 			select {
 			case client.send <- data:
 			default:
@@ -333,6 +425,39 @@ func (h *DashboardWSHandler) broadcastLoop() {
 			}
 		}
 		h.clientsMu.RUnlock()
+
+		h.publishStream(msg, data)
+
+		h.listenersMu.Lock()
+		for listener := range h.listeners {
+			select {
+			case listener <- msg:
+			default:
+				// Listener is behind, skip it
+				h.drops.Add(1)
+			}
+		}
+		h.listenersMu.Unlock()
+	}
+}
+
+// BroadcastDrops returns the number of messages dropped since startup
+// because a client or listener could not keep up
+func (h *DashboardWSHandler) BroadcastDrops() int64 {
+	return h.drops.Load()
+}
+
+// listen returns a channel receiving every broadcast, unfiltered, and a
+// function that stops it. Broadcasts are dropped while the channel is full.
+func (h *DashboardWSHandler) listen() (<-chan *BroadcastMessage, func()) {
+	listener := make(chan *BroadcastMessage, 64)
+	h.listenersMu.Lock()
+	h.listeners[listener] = true
+	h.listenersMu.Unlock()
+	return listener, func() {
+		h.listenersMu.Lock()
+		delete(h.listeners, listener)
+		h.listenersMu.Unlock()
 	}
 }
 
@@ -345,7 +470,8 @@ func (h *DashboardWSHandler) BroadcastAgentUpdate(agentID string, agent interfac
 	}
 }
 
-// BroadcastMetrics broadcasts metrics to all connected clients
+// BroadcastMetrics broadcasts metrics to the clients that can see the agent
+// and are subscribed to it, or to no agent in particular
 func (h *DashboardWSHandler) BroadcastMetrics(agentID string, metrics interface{}) {
 	h.broadcast <- &BroadcastMessage{
 		Type:    MsgTypeMetrics,
@@ -366,6 +492,16 @@ func (h *DashboardWSHandler) BroadcastAgentOffline(agentID string) {
 	}
 }
 
+// BroadcastAgentStatus broadcasts an agent's health status change, such
+// as going stale when heartbeats stop arriving
+func (h *DashboardWSHandler) BroadcastAgentStatus(change service.AgentStatusChange) {
+	h.broadcast <- &BroadcastMessage{
+		Type:    MsgTypeAgentStatus,
+		AgentID: change.AgentID,
+		Data:    change,
+	}
+}
+
 // BroadcastSummary broadcasts summary update
 func (h *DashboardWSHandler) BroadcastSummary(summary interface{}) {
 	h.broadcast <- &BroadcastMessage{
@@ -374,9 +510,80 @@ func (h *DashboardWSHandler) BroadcastSummary(summary interface{}) {
 	}
 }
 
-// ClientCount returns the number of connected clients
+// BroadcastSecurityAlert broadcasts a security alert (e.g. agent source IP change)
+func (h *DashboardWSHandler) BroadcastSecurityAlert(agentID string, alert interface{}) {
+	h.broadcast <- &BroadcastMessage{
+		Type:    MsgTypeSecurityAlert,
+		AgentID: agentID,
+		Data:    alert,
+	}
+}
+
+// BroadcastAlert broadcasts a metrics threshold alert (e.g. GPU temperature)
+func (h *DashboardWSHandler) BroadcastAlert(agentID string, alert interface{}) {
+	h.broadcast <- &BroadcastMessage{
+		Type:    MsgTypeAlert,
+		AgentID: agentID,
+		Data:    alert,
+	}
+}
+
+// SetServerEvents forwards events published on bus to connected super admins
+func (h *DashboardWSHandler) SetServerEvents(bus *service.ServerEventBus) {
+	h.serverEvents = bus
+	events, _ := bus.Subscribe(100)
+	go func() {
+		for event := range events {
+			h.broadcast <- &BroadcastMessage{
+				Type:      MsgTypeServerEvent,
+				Data:      event,
+				AdminOnly: true,
+			}
+		}
+	}()
+}
+
+// StartSummaryTicker recomputes and broadcasts the fleet summary every
+// interval, independent of how often agents push metrics. The summary is
+// skipped while no dashboard clients are connected.
+func (h *DashboardWSHandler) StartSummaryTicker(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if h.ClientCount() == 0 {
+					continue
+				}
+				h.BroadcastSummary(h.metricsService.GetSummary())
+			case <-h.summaryStop:
+				return
+			}
+		}
+	}()
+	h.logger.Infof("Dashboard summary broadcast started (interval: %v)", interval)
+}
+
+// StopSummaryTicker stops the periodic summary broadcast
+func (h *DashboardWSHandler) StopSummaryTicker() {
+	h.summaryOnce.Do(func() {
+		close(h.summaryStop)
+	})
+}
+
+// ClientCount returns the number of connected WebSocket and SSE clients
 func (h *DashboardWSHandler) ClientCount() int {
 	h.clientsMu.RLock()
-	defer h.clientsMu.RUnlock()
-	return len(h.clients)
+	count := len(h.clients)
+	h.clientsMu.RUnlock()
+
+	h.streamMu.Lock()
+	defer h.streamMu.Unlock()
+	return count + len(h.streams)
 }
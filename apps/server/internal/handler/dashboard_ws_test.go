@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"go.uber.org/zap"
+)
+
+func TestSummaryBroadcastOnInterval(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(logger)
+	as := service.NewAgentService(logger, ms)
+	h := NewDashboardWSHandler(logger, nil, as, ms)
+
+	client := &dashboardClient{
+		send:          make(chan []byte, 16),
+		subscriptions: make(map[string]bool),
+	}
+	h.registerClient(client)
+
+	h.StartSummaryTicker(20 * time.Millisecond)
+	defer h.StopSummaryTicker()
+
+	// No metrics are ever stored; summaries must still arrive on the ticker
+	timeout := time.After(2 * time.Second)
+	for received := 0; received < 2; {
+		select {
+		case data := <-client.send:
+			var msg DashboardMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatalf("Failed to decode broadcast: %v", err)
+			}
+			if msg.Type != MsgTypeSummary {
+				t.Fatalf("Expected summary message, got %s", msg.Type)
+			}
+			received++
+		case <-timeout:
+			t.Fatalf("Expected 2 summary broadcasts, got %d", received)
+		}
+	}
+}
+
+func TestSummaryTickerDisabled(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(logger)
+	h := NewDashboardWSHandler(logger, nil, service.NewAgentService(logger, ms), ms)
+
+	client := &dashboardClient{
+		send:          make(chan []byte, 16),
+		subscriptions: make(map[string]bool),
+	}
+	h.registerClient(client)
+
+	h.StartSummaryTicker(0)
+	defer h.StopSummaryTicker()
+
+	select {
+	case <-client.send:
+		t.Error("Expected no summary broadcast when the interval is 0")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// fixedVisibility grants each user a fixed set of agents
+type fixedVisibility map[uint][]string
+
+func (f fixedVisibility) GetVisibleAgents(userID uint) ([]string, error) {
+	return f[userID], nil
+}
+
+// nextBroadcast decodes the next message sent to client
+func nextBroadcast(t *testing.T, client *dashboardClient) DashboardMessage {
+	t.Helper()
+	select {
+	case data := <-client.send:
+		var msg DashboardMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("Failed to decode broadcast: %v", err)
+		}
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for a broadcast")
+		return DashboardMessage{}
+	}
+}
+
+func TestBroadcastsRespectVisibilityAndSubscriptions(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(logger)
+	h := NewDashboardWSHandler(logger, nil, service.NewAgentService(logger, ms), ms)
+	h.SetPermissionService(fixedVisibility{7: {"agent-1", "agent-2"}})
+
+	viewer := &dashboardClient{userID: 7, send: make(chan []byte, 16), subscriptions: make(map[string]bool)}
+	admin := &dashboardClient{userID: 1, isSuperAdmin: true, send: make(chan []byte, 16), subscriptions: make(map[string]bool)}
+	h.registerClient(viewer)
+	h.registerClient(admin)
+
+	// The restricted agent's broadcasts never reach the viewer; the summary
+	// marks the end of what it could have received
+	h.BroadcastAgentUpdate("restricted", map[string]string{"id": "restricted"})
+	h.BroadcastMetrics("restricted", map[string]float64{"cpu": 99})
+	h.BroadcastMetrics("agent-1", map[string]float64{"cpu": 10})
+	h.BroadcastSummary(map[string]int{"online": 3})
+
+	if msg := nextBroadcast(t, viewer); msg.Type != MsgTypeMetrics || msg.Data.(map[string]interface{})["agentId"] != "agent-1" {
+		t.Fatalf("Expected only agent-1's metrics, got %+v", msg)
+	}
+	if msg := nextBroadcast(t, viewer); msg.Type != MsgTypeSummary {
+		t.Fatalf("Expected the summary next, got %+v", msg)
+	}
+	for _, want := range []DashboardMsgType{MsgTypeAgentUpdate, MsgTypeMetrics, MsgTypeMetrics, MsgTypeSummary} {
+		if msg := nextBroadcast(t, admin); msg.Type != want {
+			t.Fatalf("Expected super admins to get every broadcast, got %s instead of %s", msg.Type, want)
+		}
+	}
+
+	// Once subscribed, only the subscribed agent's metrics arrive
+	viewer.mu.Lock()
+	viewer.subscriptions["agent-2"] = true
+	viewer.mu.Unlock()
+	h.BroadcastMetrics("agent-1", map[string]float64{"cpu": 11})
+	h.BroadcastMetrics("agent-2", map[string]float64{"cpu": 20})
+	if msg := nextBroadcast(t, viewer); msg.Data.(map[string]interface{})["agentId"] != "agent-2" {
+		t.Errorf("Expected only the subscribed agent's metrics, got %+v", msg)
+	}
+}
+
+func TestInitialDataOnlyListsVisibleAgents(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(logger)
+	h := NewDashboardWSHandler(logger, nil, service.NewAgentService(logger, ms), ms)
+	h.SetPermissionService(fixedVisibility{7: {"agent-1"}})
+	ms.StoreMetrics("agent-1", &service.MetricsData{})
+	ms.StoreMetrics("restricted", &service.MetricsData{})
+
+	viewer := &dashboardClient{userID: 7, send: make(chan []byte, 16), subscriptions: make(map[string]bool)}
+	for _, msg := range h.initialMessages(viewer.userID, viewer.isSuperAdmin, &viewer.visibility) {
+		if metrics, ok := msg.Data.(map[string]*service.MetricsData); ok && msg.Type == MsgTypeMetrics {
+			if len(metrics) != 1 || metrics["agent-1"] == nil {
+				t.Errorf("Expected only agent-1's metrics, got %v", metrics)
+			}
+			return
+		}
+	}
+	t.Error("Expected the initial metrics")
+}
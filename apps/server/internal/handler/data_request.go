@@ -2,6 +2,7 @@ package handler
 
 import (
 	"net/http"
+	"sort"
 
 	grpcserver "github.com/chenqi92/NanoLink/apps/server/internal/grpc"
 	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
@@ -97,24 +98,42 @@ func (h *DataRequestHandler) RequestDataFromAll(c *gin.Context) {
 
 	results := h.grpcServer.RequestDataFromAllAgents(reqType, input.Target)
 
-	// Count successes and failures
-	successCount := 0
-	failedAgents := make([]string, 0)
-	for agentID, err := range results {
-		if err != nil {
-			failedAgents = append(failedAgents, agentID)
+	agentIDs := make([]string, 0, len(results))
+	for agentID := range results {
+		agentIDs = append(agentIDs, agentID)
+	}
+	sort.Strings(agentIDs)
+
+	resp := broadcastDataResponse{
+		BatchResponse: NewBatchResponse(),
+		TotalAgents:   len(results),
+		FailedAgents:  make([]string, 0),
+		RequestType:   input.RequestType,
+		Message:       "Data request sent to agents",
+	}
+	for _, agentID := range agentIDs {
+		if err := results[agentID]; err != nil {
 			h.logger.Warnf("Failed to send data request to agent %s: %v", agentID, err)
-		} else {
-			successCount++
+			resp.Fail(agentID, err)
+			resp.FailedAgents = append(resp.FailedAgents, agentID)
+			continue
 		}
+		resp.Succeed(agentID, nil)
 	}
+	resp.Success = resp.Succeeded > 0
+	resp.SuccessCount = resp.Succeeded
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":      successCount > 0,
-		"totalAgents":  len(results),
-		"successCount": successCount,
-		"failedAgents": failedAgents,
-		"requestType":  input.RequestType,
-		"message":      "Data request sent to agents",
-	})
+	c.JSON(resp.Status(), resp)
+}
+
+// broadcastDataResponse is the batch envelope for a data request sent to
+// every agent, with the summary fields the endpoint returned before it
+type broadcastDataResponse struct {
+	*BatchResponse
+	Success      bool     `json:"success"`
+	TotalAgents  int      `json:"totalAgents"`
+	SuccessCount int      `json:"successCount"`
+	FailedAgents []string `json:"failedAgents"`
+	RequestType  string   `json:"requestType"`
+	Message      string   `json:"message"`
 }
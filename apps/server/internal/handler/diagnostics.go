@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// DiagnosticsHandler serves support bundles
+type DiagnosticsHandler struct {
+	diagnostics *service.DiagnosticsService
+	logger      *zap.SugaredLogger
+}
+
+// NewDiagnosticsHandler creates a new diagnostics handler
+func NewDiagnosticsHandler(diagnostics *service.DiagnosticsService, logger *zap.SugaredLogger) *DiagnosticsHandler {
+	return &DiagnosticsHandler{
+		diagnostics: diagnostics,
+		logger:      logger,
+	}
+}
+
+// GetDiagnostics streams a zip of sanitized config, server stats, connected
+// agents, recent server events, recent errors and version info
+// GET /api/diagnostics
+func (h *DiagnosticsHandler) GetDiagnostics(c *gin.Context) {
+	filename := fmt.Sprintf("nanolink-diagnostics-%s.zip", time.Now().UTC().Format("20060102-150405"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	if err := h.diagnostics.WriteBundle(c.Writer); err != nil {
+		// The archive is already being streamed, so it is cut short
+		h.logger.Errorf("Failed to write diagnostics bundle: %v", err)
+		return
+	}
+	if user := GetCurrentUser(c); user != nil {
+		h.logger.Infof("Diagnostics bundle downloaded by %s", user.Username)
+	}
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// GetAgentDrift reports how an agent's mounts, network configuration,
+// kernel, firmware and hardware differ from its baseline, recorded from the
+// first full metrics it reported
+// GET /api/agents/:id/drift
+func (h *Handler) GetAgentDrift(c *gin.Context) {
+	agentID := c.Param("id")
+
+	// Check permission if service is available
+	if h.permService != nil {
+		user := GetCurrentUser(c)
+		if user != nil && !user.IsSuperAdmin {
+			canAccess, err := h.permService.CanUserAccessAgent(user.ID, agentID)
+			if err != nil || !canAccess {
+				c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+				return
+			}
+		}
+	}
+
+	if h.driftService == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "drift detection is not enabled"})
+		return
+	}
+
+	report, err := h.driftService.Report(agentID, h.agentService.Identity(agentID))
+	if errors.Is(err, service.ErrNoDriftSnapshot) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no full metrics reported for agent"})
+		return
+	}
+	if errors.Is(err, service.ErrNoDriftBaseline) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no drift baseline recorded for agent"})
+		return
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to compute drift for agent %s: %v", agentID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute drift"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// RebaselineAgentDrift records an agent's current state as its drift
+// baseline, e.g. after planned maintenance
+// POST /api/agents/:id/drift/baseline
+func (h *Handler) RebaselineAgentDrift(c *gin.Context) {
+	agentID := c.Param("id")
+
+	if h.driftService == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "drift detection is not enabled"})
+		return
+	}
+
+	baseline, err := h.driftService.Rebaseline(agentID, h.agentService.Identity(agentID))
+	if errors.Is(err, service.ErrNoDriftSnapshot) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no full metrics reported for agent"})
+		return
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to record drift baseline for agent %s: %v", agentID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record drift baseline"})
+		return
+	}
+
+	c.JSON(http.StatusOK, baseline)
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultForecastWindow = 7 * 24 * time.Hour
+	maxForecastWindow     = 90 * 24 * time.Hour
+)
+
+// GetAgentForecast projects when an agent's CPU, memory or disk usage will
+// cross a threshold, based on the trend in persisted history
+// GET /api/agents/:id/forecast
+// Query params:
+// - metric: cpu, memory or disk (default disk)
+// - threshold: percent to project against (default 90)
+// - window: history to fit, as a duration such as 24h (default 168h)
+func (h *Handler) GetAgentForecast(c *gin.Context) {
+	agentID := c.Param("id")
+
+	// Check permission if service is available
+	if h.permService != nil {
+		user := GetCurrentUser(c)
+		if user != nil && !user.IsSuperAdmin {
+			canAccess, err := h.permService.CanUserAccessAgent(user.ID, agentID)
+			if err != nil || !canAccess {
+				c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+				return
+			}
+		}
+	}
+
+	if h.metricsPersistence == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "metrics persistence is not enabled"})
+		return
+	}
+
+	metric := c.DefaultQuery("metric", service.ForecastDisk)
+	threshold, err := strconv.ParseFloat(c.DefaultQuery("threshold", "90"), 64)
+	if err != nil || threshold <= 0 || threshold > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "threshold must be a percentage between 0 and 100"})
+		return
+	}
+	window := defaultForecastWindow
+	if raw := c.Query("window"); raw != "" {
+		window, err = time.ParseDuration(raw)
+		if err != nil || window <= 0 || window > maxForecastWindow {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "window must be a duration up to 2160h"})
+			return
+		}
+	}
+
+	forecast, err := h.metricsPersistence.Forecast(agentID, metric, threshold, window)
+	if errors.Is(err, service.ErrUnknownForecastMetric) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "metric must be cpu, memory or disk"})
+		return
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to forecast %s for agent %s: %v", metric, agentID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute forecast"})
+		return
+	}
+
+	c.JSON(http.StatusOK, forecast)
+}
@@ -0,0 +1,419 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	graphql "github.com/graph-gophers/graphql-go"
+	"go.uber.org/zap"
+)
+
+// Range returned by the metrics query when from is omitted
+const defaultGraphQLMetricsRange = time.Hour
+
+// graphQLSchema is the dashboard's GraphQL API. It covers what the dashboard
+// otherwise fetches with several REST calls; REST remains the full API.
+const graphQLSchema = `
+schema {
+	query: Query
+	subscription: Subscription
+}
+
+scalar Time
+
+type Query {
+	agents: [Agent!]!
+	agent(id: ID!): Agent
+	# Persisted history when enabled, otherwise in-memory history; defaults to the last hour
+	metrics(agentId: ID!, from: Time, to: Time): [Metrics!]!
+	summary: Summary!
+}
+
+type Subscription {
+	# Metrics of visible agents as they arrive, or of one agent
+	metricsUpdated(agentId: ID): Metrics!
+}
+
+type Agent {
+	id: ID!
+	hostname: String!
+	os: String!
+	arch: String!
+	version: String!
+	permissionLevel: Int!
+	connectedAt: Time!
+	lastHeartbeat: Time!
+	# healthy, stale or dead, from the age of the last heartbeat
+	status: String!
+	labels: [Label!]!
+	# Current metrics, null until the agent has reported
+	metrics: Metrics
+}
+
+type Label {
+	key: String!
+	value: String!
+}
+
+type Metrics {
+	agentId: ID!
+	timestamp: Time!
+	cpuPercent: Float!
+	memPercent: Float!
+	diskReadPs: Float!
+	diskWritePs: Float!
+	diskPercent: Float
+	netRxPs: Float!
+	netTxPs: Float!
+	gpuPercent: Float!
+	loadAvg1: Float!
+}
+
+type Summary {
+	agentCount: Int!
+	connectedAgents: Int!
+	avgCpuPercent: Float!
+	totalMemory: Float!
+	usedMemory: Float!
+	memoryPercent: Float!
+	staleCount: Int!
+}
+`
+
+var errGraphQLAccessDenied = errors.New("access denied")
+
+// GraphQLHandler serves the dashboard GraphQL API, applying the same agent
+// permissions as the REST handlers
+type GraphQLHandler struct {
+	schema             *graphql.Schema
+	agentService       *service.AgentService
+	metricsService     *service.MetricsService
+	permService        *service.PermissionService
+	metricsPersistence *service.MetricsPersistence
+	authService        AuthServiceInterface
+	// Source of metricsUpdated events; subscriptions fail without it
+	dashboard *DashboardWSHandler
+	logger    *zap.SugaredLogger
+	upgrader  websocket.Upgrader
+}
+
+// NewGraphQLHandler creates a GraphQL handler. permService may be nil, in
+// which case every user sees every agent.
+func NewGraphQLHandler(as *service.AgentService, ms *service.MetricsService, ps *service.PermissionService, authService AuthServiceInterface, logger *zap.SugaredLogger) *GraphQLHandler {
+	h := &GraphQLHandler{
+		agentService:   as,
+		metricsService: ms,
+		permService:    ps,
+		authService:    authService,
+		logger:         logger,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  4096,
+			WriteBufferSize: 4096,
+			Subprotocols:    []string{graphQLWSProtocol},
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		},
+	}
+	h.schema = graphql.MustParseSchema(graphQLSchema, &graphQLResolver{h: h},
+		graphql.UseFieldResolvers(), graphql.MaxDepth(8))
+	return h
+}
+
+// SetMetricsPersistence serves the metrics query from persisted history
+func (h *GraphQLHandler) SetMetricsPersistence(mp *service.MetricsPersistence) {
+	h.metricsPersistence = mp
+}
+
+// SetDashboard enables the metricsUpdated subscription, fed by the
+// dashboard broadcast loop
+func (h *GraphQLHandler) SetDashboard(dashboard *DashboardWSHandler) {
+	h.dashboard = dashboard
+}
+
+// graphQLRequest is a GraphQL query over HTTP or a WebSocket
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// HandleQuery executes a GraphQL query for the current user
+// POST /api/graphql
+func (h *GraphQLHandler) HandleQuery(c *gin.Context) {
+	user := GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+
+	var req graphQLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := withGraphQLViewer(c.Request.Context(), graphQLViewer{userID: user.ID, isSuperAdmin: user.IsSuperAdmin})
+	c.JSON(http.StatusOK, h.schema.Exec(ctx, req.Query, req.OperationName, req.Variables))
+}
+
+// graphQLViewer is the user a GraphQL operation runs as
+type graphQLViewer struct {
+	userID       uint
+	isSuperAdmin bool
+}
+
+type graphQLViewerKey struct{}
+
+func withGraphQLViewer(ctx context.Context, viewer graphQLViewer) context.Context {
+	return context.WithValue(ctx, graphQLViewerKey{}, viewer)
+}
+
+// canSee reports whether the operation's user may see agentID
+func (h *GraphQLHandler) canSee(ctx context.Context, agentID string) (bool, error) {
+	viewer, ok := ctx.Value(graphQLViewerKey{}).(graphQLViewer)
+	if h.permService == nil || (ok && viewer.isSuperAdmin) {
+		return true, nil
+	}
+	if !ok {
+		return false, nil
+	}
+	return h.permService.CanUserAccessAgent(viewer.userID, agentID)
+}
+
+// graphQLResolver resolves the Query and Subscription root fields
+type graphQLResolver struct {
+	h *GraphQLHandler
+}
+
+func (r *graphQLResolver) Agents(ctx context.Context) ([]*graphQLAgent, error) {
+	h := r.h
+	agents := h.agentService.GetAllAgents()
+	viewer, ok := ctx.Value(graphQLViewerKey{}).(graphQLViewer)
+	if h.permService != nil && !(ok && viewer.isSuperAdmin) {
+		if !ok {
+			return nil, errGraphQLAccessDenied
+		}
+		visible, err := h.permService.GetVisibleAgents(viewer.userID)
+		if err != nil {
+			h.logger.Errorf("Failed to get visible agents: %v", err)
+			return nil, errors.New("failed to get visible agents")
+		}
+		// nil means all agents are visible
+		if visible != nil {
+			visibleSet := make(map[string]bool, len(visible))
+			for _, id := range visible {
+				visibleSet[id] = true
+			}
+			filtered := agents[:0:0]
+			for _, agent := range agents {
+				if visibleSet[agent.ID] {
+					filtered = append(filtered, agent)
+				}
+			}
+			agents = filtered
+		}
+	}
+
+	result := make([]*graphQLAgent, 0, len(agents))
+	for _, agent := range agents {
+		result = append(result, &graphQLAgent{h: h, agent: agent})
+	}
+	return result, nil
+}
+
+func (r *graphQLResolver) Agent(ctx context.Context, args struct{ ID graphql.ID }) (*graphQLAgent, error) {
+	agentID := string(args.ID)
+	if ok, err := r.h.canSee(ctx, agentID); err != nil || !ok {
+		return nil, errGraphQLAccessDenied
+	}
+	agent := r.h.agentService.GetAgent(agentID)
+	if agent == nil {
+		return nil, nil
+	}
+	return &graphQLAgent{h: r.h, agent: agent}, nil
+}
+
+func (r *graphQLResolver) Metrics(ctx context.Context, args struct {
+	AgentID graphql.ID
+	From    *graphql.Time
+	To      *graphql.Time
+}) ([]*graphQLMetrics, error) {
+	h := r.h
+	agentID := string(args.AgentID)
+	if ok, err := h.canSee(ctx, agentID); err != nil || !ok {
+		return nil, errGraphQLAccessDenied
+	}
+
+	to := time.Now()
+	if args.To != nil {
+		to = args.To.Time
+	}
+	from := to.Add(-defaultGraphQLMetricsRange)
+	if args.From != nil {
+		from = args.From.Time
+	}
+
+	var history []database.MetricsHistory
+	if h.metricsPersistence != nil {
+		var err error
+		if history, err = h.metricsPersistence.QueryAggregated(agentID, from, to, "auto"); err != nil {
+			h.logger.Errorf("Failed to query metrics history: %v", err)
+			return nil, errors.New("failed to query history")
+		}
+	} else {
+		history = service.HistoryRecords(agentID, h.metricsService.GetMetricsHistoryRange(agentID, from, to))
+	}
+
+	result := make([]*graphQLMetrics, 0, len(history))
+	for i := range history {
+		result = append(result, &graphQLMetrics{m: history[i]})
+	}
+	return result, nil
+}
+
+func (r *graphQLResolver) Summary() *graphQLSummary {
+	summary := r.h.metricsService.GetSummary()
+	s := &graphQLSummary{ConnectedAgents: int32(r.h.agentService.GetAgentCount())}
+	if v, ok := summary["agentCount"].(int); ok {
+		s.AgentCount = int32(v)
+	}
+	if v, ok := summary["staleCount"].(int); ok {
+		s.StaleCount = int32(v)
+	}
+	s.AvgCpuPercent, _ = summary["avgCpuPercent"].(float64)
+	s.MemoryPercent, _ = summary["memoryPercent"].(float64)
+	if v, ok := summary["totalMemory"].(uint64); ok {
+		s.TotalMemory = float64(v)
+	}
+	if v, ok := summary["usedMemory"].(uint64); ok {
+		s.UsedMemory = float64(v)
+	}
+	return s
+}
+
+// MetricsUpdated streams metrics broadcasts for the agents the user may
+// see, or for one agent
+func (r *graphQLResolver) MetricsUpdated(ctx context.Context, args struct{ AgentID *graphql.ID }) (<-chan *graphQLMetrics, error) {
+	h := r.h
+	if h.dashboard == nil {
+		return nil, errors.New("subscriptions are not available")
+	}
+	viewer, ok := ctx.Value(graphQLViewerKey{}).(graphQLViewer)
+	if !ok {
+		return nil, errGraphQLAccessDenied
+	}
+	agentID := ""
+	if args.AgentID != nil {
+		agentID = string(*args.AgentID)
+		if ok, err := h.canSee(ctx, agentID); err != nil || !ok {
+			return nil, errGraphQLAccessDenied
+		}
+	}
+
+	broadcasts, stop := h.dashboard.listen()
+	updates := make(chan *graphQLMetrics)
+	go func() {
+		defer close(updates)
+		defer stop()
+		var visibility agentVisibility
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-broadcasts:
+				if msg.Type != MsgTypeMetrics || (agentID != "" && msg.AgentID != agentID) ||
+					!h.dashboard.agentVisible(viewer.userID, viewer.isSuperAdmin, &visibility, msg.AgentID) {
+					continue
+				}
+				data, _ := msg.Data.(map[string]interface{})
+				metrics, ok := data["metrics"].(*service.MetricsData)
+				if !ok {
+					continue
+				}
+				update := &graphQLMetrics{m: service.HistoryRecords(msg.AgentID, []*service.MetricsData{metrics})[0]}
+				select {
+				case updates <- update:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return updates, nil
+}
+
+type graphQLAgent struct {
+	h     *GraphQLHandler
+	agent *service.Agent
+}
+
+func (a *graphQLAgent) ID() graphql.ID            { return graphql.ID(a.agent.ID) }
+func (a *graphQLAgent) Hostname() string          { return a.agent.Hostname }
+func (a *graphQLAgent) Os() string                { return a.agent.OS }
+func (a *graphQLAgent) Arch() string              { return a.agent.Arch }
+func (a *graphQLAgent) Version() string           { return a.agent.Version }
+func (a *graphQLAgent) PermissionLevel() int32    { return int32(a.agent.PermissionLevel) }
+func (a *graphQLAgent) ConnectedAt() graphql.Time { return graphql.Time{Time: a.agent.ConnectedAt} }
+func (a *graphQLAgent) LastHeartbeat() graphql.Time {
+	return graphql.Time{Time: a.agent.LastHeartbeat}
+}
+
+// Status is computed from the heartbeat age, like the REST API's
+func (a *graphQLAgent) Status() string { return a.h.agentService.AgentStatus(a.agent) }
+
+func (a *graphQLAgent) Labels() []*graphQLLabel {
+	labels := make([]*graphQLLabel, 0, len(a.agent.Labels))
+	for key, value := range a.agent.Labels {
+		labels = append(labels, &graphQLLabel{Key: key, Value: value})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Key < labels[j].Key })
+	return labels
+}
+
+func (a *graphQLAgent) Metrics() *graphQLMetrics {
+	data := a.h.metricsService.GetCurrentMetrics(a.agent.ID)
+	if data == nil {
+		return nil
+	}
+	return &graphQLMetrics{m: service.HistoryRecords(a.agent.ID, []*service.MetricsData{data})[0]}
+}
+
+type graphQLLabel struct {
+	Key   string
+	Value string
+}
+
+// graphQLMetrics exposes a sample with the values kept in history
+type graphQLMetrics struct {
+	m database.MetricsHistory
+}
+
+func (m *graphQLMetrics) AgentID() graphql.ID     { return graphql.ID(m.m.AgentID) }
+func (m *graphQLMetrics) Timestamp() graphql.Time { return graphql.Time{Time: m.m.Timestamp} }
+func (m *graphQLMetrics) CpuPercent() float64     { return m.m.CPUPercent }
+func (m *graphQLMetrics) MemPercent() float64     { return m.m.MemPercent }
+func (m *graphQLMetrics) DiskReadPs() float64     { return float64(m.m.DiskReadPS) }
+func (m *graphQLMetrics) DiskWritePs() float64    { return float64(m.m.DiskWritePS) }
+func (m *graphQLMetrics) DiskPercent() *float64   { return m.m.DiskPercent }
+func (m *graphQLMetrics) NetRxPs() float64        { return float64(m.m.NetRxPS) }
+func (m *graphQLMetrics) NetTxPs() float64        { return float64(m.m.NetTxPS) }
+func (m *graphQLMetrics) GpuPercent() float64     { return m.m.GPUPercent }
+func (m *graphQLMetrics) LoadAvg1() float64       { return m.m.LoadAvg1 }
+
+type graphQLSummary struct {
+	AgentCount      int32
+	ConnectedAgents int32
+	AvgCpuPercent   float64
+	TotalMemory     float64
+	UsedMemory      float64
+	MemoryPercent   float64
+	StaleCount      int32
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// newGraphQLTestHandler returns a GraphQL handler over agent-1 and a
+// restricted agent, with a router running queries as a user granted
+// agent-1 only
+func newGraphQLTestHandler(t *testing.T) (*GraphQLHandler, *gin.Engine, graphQLViewer) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	perms, viewer := newViewerPermissions(t)
+
+	log := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(log)
+	agents := service.NewAgentService(log, ms)
+	for _, id := range []string{"agent-1", "restricted"} {
+		agents.RegisterGrpcAgent(id, service.AgentInfo{Hostname: id, Labels: map[string]string{"env": "prod"}}, 1)
+		ms.StoreMetrics(id, &service.MetricsData{AgentID: id, Timestamp: time.Now(), CPU: service.CPUData{UsagePercent: 42.5}})
+	}
+
+	h := NewGraphQLHandler(agents, ms, perms, nil, log)
+	dashboard := NewDashboardWSHandler(log, nil, agents, ms)
+	dashboard.SetPermissionService(perms)
+	h.SetDashboard(dashboard)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(ContextKeyUser, viewer) })
+	router.POST("/api/graphql", h.HandleQuery)
+	return h, router, graphQLViewer{userID: viewer.ID}
+}
+
+func TestGraphQLQueriesApplyAgentPermissions(t *testing.T) {
+	_, router, _ := newGraphQLTestHandler(t)
+	query := func(q string) map[string]interface{} {
+		t.Helper()
+		body, _ := json.Marshal(graphQLRequest{Query: q})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/graphql", strings.NewReader(string(body))))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := query(`{ agents { id labels { key value } metrics { cpuPercent } } summary { connectedAgents } }`)
+	data, _ := resp["data"].(map[string]interface{})
+	agents, _ := data["agents"].([]interface{})
+	if len(agents) != 1 {
+		t.Fatalf("Expected only the visible agent, got %v", resp)
+	}
+	agent := agents[0].(map[string]interface{})
+	if agent["id"] != "agent-1" || agent["metrics"].(map[string]interface{})["cpuPercent"] != 42.5 {
+		t.Errorf("Expected agent-1 with its current metrics, got %v", agent)
+	}
+	if labels := agent["labels"].([]interface{}); len(labels) != 1 || labels[0].(map[string]interface{})["value"] != "prod" {
+		t.Errorf("Expected agent-1's labels, got %v", labels)
+	}
+	if summary := data["summary"].(map[string]interface{}); summary["connectedAgents"] != 2.0 {
+		t.Errorf("Expected the fleet summary, got %v", summary)
+	}
+
+	resp = query(`{ agent(id: "restricted") { id } }`)
+	if resp["errors"] == nil || resp["data"].(map[string]interface{})["agent"] != nil {
+		t.Errorf("Expected the restricted agent to be denied, got %v", resp)
+	}
+	resp = query(`{ metrics(agentId: "restricted") { cpuPercent } }`)
+	if resp["errors"] == nil {
+		t.Errorf("Expected the restricted agent's metrics to be denied, got %v", resp)
+	}
+
+	resp = query(`{ metrics(agentId: "agent-1") { agentId cpuPercent } }`)
+	history, _ := resp["data"].(map[string]interface{})["metrics"].([]interface{})
+	if len(history) != 1 {
+		t.Errorf("Expected agent-1's in-memory history, got %v", resp)
+	}
+}
+
+func TestGraphQLStatusFollowsHeartbeats(t *testing.T) {
+	h, router, _ := newGraphQLTestHandler(t)
+	status := func() string {
+		t.Helper()
+		body, _ := json.Marshal(graphQLRequest{Query: `{ agent(id: "agent-1") { status } }`})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/graphql", strings.NewReader(string(body))))
+		var resp struct {
+			Data struct {
+				Agent struct{ Status string } `json:"agent"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data.Agent.Status
+	}
+
+	if got := status(); got != service.AgentHealthy {
+		t.Errorf("Expected a fresh agent to be healthy, got %q", got)
+	}
+	// No health check has run since, so only a live status sees the silence
+	h.agentService.SetClock(service.NewFakeClock(time.Now().Add(time.Hour)))
+	if got := status(); got != service.AgentDead {
+		t.Errorf("Expected an agent silent for an hour to be dead, got %q", got)
+	}
+}
+
+func TestGraphQLMetricsUpdatedSkipsHiddenAgents(t *testing.T) {
+	h, _, viewer := newGraphQLTestHandler(t)
+	ctx, cancel := context.WithCancel(withGraphQLViewer(context.Background(), viewer))
+	defer cancel()
+
+	results, err := h.schema.Subscribe(ctx, `subscription { metricsUpdated { agentId cpuPercent } }`, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		h.dashboard.listenersMu.Lock()
+		listening := len(h.dashboard.listeners) > 0
+		h.dashboard.listenersMu.Unlock()
+		if listening {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Subscription never started listening")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	h.dashboard.BroadcastMetrics("restricted", &service.MetricsData{CPU: service.CPUData{UsagePercent: 99}})
+	h.dashboard.BroadcastMetrics("agent-1", &service.MetricsData{CPU: service.CPUData{UsagePercent: 10}})
+
+	select {
+	case result := <-results:
+		data, _ := json.Marshal(result)
+		if !strings.Contains(string(data), `"agentId":"agent-1"`) || !strings.Contains(string(data), `"cpuPercent":10`) {
+			t.Errorf("Expected only agent-1's update, got %s", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for a metrics update")
+	}
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// graphQLWSProtocol is the graphql-ws WebSocket subprotocol
+const graphQLWSProtocol = "graphql-transport-ws"
+
+// How long a client has to send connection_init after connecting
+const graphQLInitTimeout = 10 * time.Second
+
+// graphql-ws message types
+const (
+	gqlConnectionInit = "connection_init"
+	gqlConnectionAck  = "connection_ack"
+	gqlPing           = "ping"
+	gqlPong           = "pong"
+	gqlSubscribe      = "subscribe"
+	gqlNext           = "next"
+	gqlError          = "error"
+	gqlComplete       = "complete"
+)
+
+// graphql-ws close codes
+const (
+	gqlCloseUnauthorized = 4401
+	gqlCloseInitTimeout  = 4408
+	gqlCloseBadMessage   = 4400
+	gqlCloseDuplicateID  = 4409
+)
+
+// graphQLWSMessage is a graphql-ws protocol message
+type graphQLWSMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// graphQLWSConn is one graphql-ws connection and its running operations
+type graphQLWSConn struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	ops     map[string]*graphQLOperation
+	opsMu   sync.Mutex
+}
+
+// graphQLOperation is a running operation, stopped through cancel
+type graphQLOperation struct {
+	cancel context.CancelFunc
+}
+
+func (c *graphQLWSConn) write(msg graphQLWSMessage) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.conn.WriteJSON(msg)
+}
+
+func (c *graphQLWSConn) close(code int, reason string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+}
+
+// HandleSubscriptions runs GraphQL operations, including metricsUpdated
+// subscriptions, over the graphql-ws protocol. The JWT is sent as ?token=
+// or as "token" in the connection_init payload.
+// GET /api/graphql
+func (h *GraphQLHandler) HandleSubscriptions(c *gin.Context) {
+	ws, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		h.logger.Errorf("GraphQL WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer ws.Close()
+	conn := &graphQLWSConn{conn: ws, ops: make(map[string]*graphQLOperation)}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	defer conn.cancelAll()
+
+	ws.SetReadLimit(64 * 1024)
+	ws.SetReadDeadline(time.Now().Add(graphQLInitTimeout))
+	var init graphQLWSMessage
+	if err := ws.ReadJSON(&init); err != nil {
+		conn.close(gqlCloseInitTimeout, "connection initialisation timeout")
+		return
+	}
+	if init.Type != gqlConnectionInit {
+		conn.close(gqlCloseBadMessage, "expected connection_init")
+		return
+	}
+	token := c.Query("token")
+	var payload struct {
+		Token string `json:"token"`
+	}
+	if len(init.Payload) > 0 && json.Unmarshal(init.Payload, &payload) == nil && payload.Token != "" {
+		token = payload.Token
+	}
+	claims, err := h.authService.VerifyToken(token)
+	if token == "" || err != nil {
+		conn.close(gqlCloseUnauthorized, "unauthorized")
+		return
+	}
+	ctx = withGraphQLViewer(ctx, graphQLViewer{userID: claims.UserID, isSuperAdmin: claims.IsSuperAdmin})
+	if err := conn.write(graphQLWSMessage{Type: gqlConnectionAck}); err != nil {
+		return
+	}
+	ws.SetReadDeadline(time.Time{})
+
+	for {
+		var msg graphQLWSMessage
+		if err := ws.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				h.logger.Debugf("GraphQL WebSocket read error: %v", err)
+			}
+			return
+		}
+
+		switch msg.Type {
+		case gqlPing:
+			conn.write(graphQLWSMessage{Type: gqlPong})
+		case gqlPong:
+		case gqlSubscribe:
+			var req graphQLRequest
+			if msg.ID == "" || json.Unmarshal(msg.Payload, &req) != nil {
+				conn.close(gqlCloseBadMessage, "invalid subscribe message")
+				return
+			}
+			if !conn.start(ctx, msg.ID, func(opCtx context.Context) { h.runOperation(opCtx, conn, msg.ID, req) }) {
+				conn.close(gqlCloseDuplicateID, "subscriber for "+msg.ID+" already exists")
+				return
+			}
+		case gqlComplete:
+			conn.stop(msg.ID)
+		default:
+			conn.close(gqlCloseBadMessage, "unexpected message type "+msg.Type)
+			return
+		}
+	}
+}
+
+// runOperation sends an operation's results until it ends or is stopped
+func (h *GraphQLHandler) runOperation(ctx context.Context, conn *graphQLWSConn, id string, req graphQLRequest) {
+	results, err := h.schema.Subscribe(ctx, req.Query, req.OperationName, req.Variables)
+	if err != nil {
+		payload, _ := json.Marshal([]map[string]string{{"message": err.Error()}})
+		conn.write(graphQLWSMessage{ID: id, Type: gqlError, Payload: payload})
+		return
+	}
+	for result := range results {
+		payload, err := json.Marshal(result)
+		if err != nil {
+			continue
+		}
+		if err := conn.write(graphQLWSMessage{ID: id, Type: gqlNext, Payload: payload}); err != nil {
+			return
+		}
+	}
+	if ctx.Err() == nil {
+		conn.write(graphQLWSMessage{ID: id, Type: gqlComplete})
+	}
+}
+
+// start runs an operation under id, reporting false if id is in use
+func (c *graphQLWSConn) start(ctx context.Context, id string, run func(context.Context)) bool {
+	c.opsMu.Lock()
+	defer c.opsMu.Unlock()
+	if _, exists := c.ops[id]; exists {
+		return false
+	}
+	opCtx, cancel := context.WithCancel(ctx)
+	op := &graphQLOperation{cancel: cancel}
+	c.ops[id] = op
+	go func() {
+		run(opCtx)
+		cancel()
+		// The id may have been reused once the client completed it
+		c.opsMu.Lock()
+		if c.ops[id] == op {
+			delete(c.ops, id)
+		}
+		c.opsMu.Unlock()
+	}()
+	return true
+}
+
+// stop ends the operation running under id, if any
+func (c *graphQLWSConn) stop(id string) {
+	c.opsMu.Lock()
+	defer c.opsMu.Unlock()
+	if op, ok := c.ops[id]; ok {
+		op.cancel()
+		delete(c.ops, id)
+	}
+}
+
+func (c *graphQLWSConn) cancelAll() {
+	c.opsMu.Lock()
+	defer c.opsMu.Unlock()
+	for id, op := range c.ops {
+		op.cancel()
+		delete(c.ops, id)
+	}
+}
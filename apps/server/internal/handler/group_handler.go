@@ -205,7 +205,7 @@ func (h *GroupHandler) AddUserToGroup(c *gin.Context) {
 		return
 	}
 
-	if err := h.groupService.AddUserToGroup(req.UserID, uint(groupID)); err != nil {
+	if err := h.groupService.WithActor(GetAuditActor(c)).AddUserToGroup(req.UserID, uint(groupID)); err != nil {
 		if err == service.ErrUserNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 			return
@@ -236,7 +236,7 @@ func (h *GroupHandler) RemoveUserFromGroup(c *gin.Context) {
 		return
 	}
 
-	if err := h.groupService.RemoveUserFromGroup(uint(userID), uint(groupID)); err != nil {
+	if err := h.groupService.WithActor(GetAuditActor(c)).RemoveUserFromGroup(uint(userID), uint(groupID)); err != nil {
 		if err == service.ErrUserNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 			return
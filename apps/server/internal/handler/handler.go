@@ -16,6 +16,12 @@ type Handler struct {
 	metricsService     *service.MetricsService
 	permService        *service.PermissionService
 	metricsPersistence *service.MetricsPersistence
+	alertStore         *service.AlertStore
+	eventStore         *service.AlertStore
+	serverEvents       *service.ServerEventBus
+	driftService       *service.DriftService
+	commandTemplates   *service.CommandTemplates
+	retentionPolicies  *service.RetentionPolicyService
 	logger             *zap.SugaredLogger
 }
 
@@ -43,6 +49,33 @@ func (h *Handler) SetMetricsPersistence(mp *service.MetricsPersistence) {
 	h.metricsPersistence = mp
 }
 
+// SetAlertStores sets the in-memory alert and agent event stores
+func (h *Handler) SetAlertStores(alerts, events *service.AlertStore) {
+	h.alertStore = alerts
+	h.eventStore = events
+}
+
+// SetServerEvents sets the server-wide event bus
+func (h *Handler) SetServerEvents(bus *service.ServerEventBus) {
+	h.serverEvents = bus
+}
+
+// SetDriftService sets the service reporting configuration drift
+func (h *Handler) SetDriftService(ds *service.DriftService) {
+	h.driftService = ds
+}
+
+// SetCommandTemplates rejects commands that do not match a registered
+// template
+func (h *Handler) SetCommandTemplates(templates *service.CommandTemplates) {
+	h.commandTemplates = templates
+}
+
+// SetRetentionPolicies sets the service managing per-agent metrics retention
+func (h *Handler) SetRetentionPolicies(policies *service.RetentionPolicyService) {
+	h.retentionPolicies = policies
+}
+
 // Health returns health status
 func (h *Handler) Health(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
@@ -68,9 +101,11 @@ func (h *Handler) GetAgents(c *gin.Context) {
 				"os":              agent.OS,
 				"arch":            agent.Arch,
 				"version":         agent.Version,
+				"labels":          agent.Labels,
 				"permissionLevel": agent.PermissionLevel,
 				"connectedAt":     agent.ConnectedAt,
 				"lastHeartbeat":   agent.LastHeartbeat,
+				"status":          h.agentService.AgentStatus(agent),
 			})
 		}
 		c.JSON(http.StatusOK, result)
@@ -113,9 +148,11 @@ func (h *Handler) GetAgents(c *gin.Context) {
 				"os":              agent.OS,
 				"arch":            agent.Arch,
 				"version":         agent.Version,
+				"labels":          agent.Labels,
 				"permissionLevel": agent.PermissionLevel,
 				"connectedAt":     agent.ConnectedAt,
 				"lastHeartbeat":   agent.LastHeartbeat,
+				"status":          h.agentService.AgentStatus(agent),
 			})
 		}
 	}
@@ -141,10 +178,29 @@ func (h *Handler) GetAgent(c *gin.Context) {
 
 	agent := h.agentService.GetAgent(agentID)
 	if agent == nil {
+		// A recently seen agent is known, just offline; tell intentional
+		// shutdowns apart from crashes
+		if offline, ok := h.agentService.GetOfflineStatus(agentID); ok {
+			c.JSON(http.StatusOK, gin.H{
+				"id":       offline.AgentID,
+				"hostname": offline.Hostname,
+				"status":   service.AgentOffline,
+				"offline":  offline,
+			})
+			return
+		}
 		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
 		return
 	}
 
+	streamStats, _ := h.agentService.StreamStats().Agent(h.agentService.Identity(agent.ID))
+	sourceIP, geo := agent.Source()
+	// Heartbeat round trip and clock skew, once the agent has sent one
+	var heartbeat *service.HeartbeatStats
+	if stats, ok := h.agentService.HeartbeatStats(agent.ID); ok {
+		heartbeat = &stats
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"id":              agent.ID,
 		"hostname":        agent.Hostname,
@@ -154,10 +210,61 @@ func (h *Handler) GetAgent(c *gin.Context) {
 		"permissionLevel": agent.PermissionLevel,
 		"connectedAt":     agent.ConnectedAt,
 		"lastHeartbeat":   agent.LastHeartbeat,
+		"status":          h.agentService.AgentStatus(agent),
+		"sourceIp":        sourceIP,
+		"geo":             geo,
+		"collectors":      agent.CollectorStatuses(),
+		"instanceId":      agent.InstanceID,
+		"streamStats":     streamStats,
+		"heartbeat":       heartbeat,
+	})
+}
+
+// GetStreamStats returns agent reconnect and stream error counters, in
+// total and per agent (most reconnects first)
+// GET /api/stats/streams
+func (h *Handler) GetStreamStats(c *gin.Context) {
+	stats := h.agentService.StreamStats()
+	c.JSON(http.StatusOK, gin.H{
+		"totals": stats.Totals(),
+		"agents": stats.All(),
+	})
+}
+
+// GetAgentInstance reports which server instance holds an agent's connection
+// GET /api/agents/:id/instance
+func (h *Handler) GetAgentInstance(c *gin.Context) {
+	agentID := c.Param("id")
+
+	// Check permission if service is available
+	if h.permService != nil {
+		user := GetCurrentUser(c)
+		if user != nil && !user.IsSuperAdmin {
+			canAccess, err := h.permService.CanUserAccessAgent(user.ID, agentID)
+			if err != nil || !canAccess {
+				c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+				return
+			}
+		}
+	}
+
+	instanceID, local, ok := h.agentService.AgentOwner(agentID)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not connected to any instance"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"agentId":    agentID,
+		"instanceId": instanceID,
+		"local":      local,
+		"servedBy":   h.agentService.InstanceID(),
 	})
 }
 
 // GetAgentMetrics returns metrics for a specific agent
+// Query params:
+// - units: set to "human" to add pre-formatted values under "formatted"
 func (h *Handler) GetAgentMetrics(c *gin.Context) {
 	agentID := c.Param("id")
 
@@ -179,12 +286,43 @@ func (h *Handler) GetAgentMetrics(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, metrics)
+	response := struct {
+		*service.MetricsData
+		Formatted         map[string]string           `json:"formatted,omitempty"`
+		AcceleratorHealth []service.AcceleratorHealth `json:"acceleratorHealth,omitempty"`
+	}{MetricsData: metrics, AcceleratorHealth: h.metricsService.AcceleratorHealth(metrics)}
+
+	// Pre-formatted values alongside the raw ones
+	if c.Query("units") == "human" {
+		response.Formatted = service.HumanizeMetrics(metrics)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetMetricUnits describes the unit and type of each numeric metric field,
+// keyed by JSON path ("disks[].total" for list entries)
+// GET /api/metrics/units
+func (h *Handler) GetMetricUnits(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"units": service.MetricUnits()})
 }
 
 // GetAllMetrics returns current metrics for all agents (filtered by user permission)
+// Query params:
+// - perCore: set to "false" to omit raw per-core CPU usage (coreStats is kept)
+// - sessions: set to "count" to omit the user session list (userSessionCount is kept)
 func (h *Handler) GetAllMetrics(c *gin.Context) {
 	allMetrics := h.metricsService.GetAllCurrentMetrics()
+	if c.Query("perCore") == "false" {
+		for agentID, metrics := range allMetrics {
+			allMetrics[agentID] = service.OverviewMetrics(metrics)
+		}
+	}
+	if c.Query("sessions") == "count" {
+		for agentID, metrics := range allMetrics {
+			allMetrics[agentID] = service.SummarizeUserSessions(metrics)
+		}
+	}
 
 	// Get current user for filtering
 	user := GetCurrentUser(c)
@@ -253,6 +391,10 @@ func (h *Handler) GetMetricsHistory(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "agentId is required"})
 		return
 	}
+	agentID, ok := resolveAgentID(c, h.agentService, agentID)
+	if !ok {
+		return
+	}
 
 	// Check permission if service is available
 	if h.permService != nil {
@@ -291,7 +433,7 @@ func (h *Handler) GetMetricsHistory(c *gin.Context) {
 		// Convert to frontend-compatible format
 		result := make([]gin.H, 0, len(history))
 		for _, m := range history {
-			result = append(result, gin.H{
+			entry := gin.H{
 				"timestamp": m.Timestamp,
 				"agentId":   m.AgentID,
 				"cpu":       gin.H{"usagePercent": m.CPUPercent},
@@ -304,18 +446,108 @@ func (h *Handler) GetMetricsHistory(c *gin.Context) {
 				},
 				"gpus":        []gin.H{{"usagePercent": m.GPUPercent}},
 				"loadAverage": []float64{m.LoadAvg1},
-			})
+			}
+			// Per-device drill-down, alongside the totals
+			if m.Devices != nil {
+				entry["devices"] = m.Devices
+			}
+			// Min/max behind the averages, for band charts
+			if m.Range != nil {
+				entry["range"] = m.Range
+			}
+			result = append(result, entry)
 		}
 
 		c.JSON(http.StatusOK, result)
 		return
 	}
 
+	// from/to select a time range of the in-memory history; either may be omitted
+	fromStr, toStr := c.Query("from"), c.Query("to")
+	if fromStr != "" || toStr != "" {
+		var from, to time.Time
+		if fromStr != "" {
+			if from, err = parseTimestamp(fromStr); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from timestamp"})
+				return
+			}
+		}
+		if toStr != "" {
+			if to, err = parseTimestamp(toStr); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to timestamp"})
+				return
+			}
+		}
+		history := h.metricsService.GetMetricsHistoryRange(agentID, from, to)
+		if history == nil {
+			history = []*service.MetricsData{}
+		}
+		c.JSON(http.StatusOK, history)
+		return
+	}
+
 	// Fall back to in-memory history
 	history := h.metricsService.GetMetricsHistory(agentID, limit)
 	c.JSON(http.StatusOK, history)
 }
 
+// MetricsQueryRequest represents a metrics expression query
+type MetricsQueryRequest struct {
+	AgentID    string `json:"agentId" binding:"required"`
+	Expression string `json:"expression" binding:"required"`
+	Start      string `json:"start"` // ISO8601 or Unix milliseconds; open when empty
+	End        string `json:"end"`
+}
+
+// QueryMetrics evaluates an expression such as memory.used/memory.total*100
+// or sum(networks.rxBytesPerSec) over an agent's history
+// POST /api/metrics/query
+func (h *Handler) QueryMetrics(c *gin.Context) {
+	var req MetricsQueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check permission if service is available
+	if h.permService != nil {
+		user := GetCurrentUser(c)
+		if user != nil && !user.IsSuperAdmin {
+			canAccess, err := h.permService.CanUserAccessAgent(user.ID, req.AgentID)
+			if err != nil || !canAccess {
+				c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+				return
+			}
+		}
+	}
+
+	expr, err := service.CompileMetricsExpression(req.Expression)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var start, end time.Time
+	if req.Start != "" {
+		if start, err = parseTimestamp(req.Start); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid start timestamp"})
+			return
+		}
+	}
+	if req.End != "" {
+		if end, err = parseTimestamp(req.End); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid end timestamp"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"agentId":    req.AgentID,
+		"expression": expr.String(),
+		"points":     h.metricsService.QueryExpression(req.AgentID, expr, start, end),
+	})
+}
+
 // parseTimestamp parses a timestamp string (ISO8601 or Unix milliseconds)
 func parseTimestamp(s string) (time.Time, error) {
 	// Try Unix milliseconds first
@@ -348,6 +580,72 @@ func (h *Handler) GetSummary(c *gin.Context) {
 	c.JSON(http.StatusOK, summary)
 }
 
+// GetWeightedSummary returns an importance-weighted summary of the agents
+// the user can see
+func (h *Handler) GetWeightedSummary(c *gin.Context) {
+	user := GetCurrentUser(c)
+	if h.permService == nil || (user != nil && user.IsSuperAdmin) {
+		summary := h.metricsService.GetWeightedSummary(nil)
+		summary["connectedAgents"] = h.agentService.GetAgentCount()
+		c.JSON(http.StatusOK, summary)
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+
+	visibleAgents, err := h.permService.GetVisibleAgents(user.ID)
+	if err != nil {
+		h.logger.Errorf("Failed to get visible agents: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get visible agents"})
+		return
+	}
+	// nil means all agents are visible
+	if visibleAgents == nil {
+		summary := h.metricsService.GetWeightedSummary(nil)
+		summary["connectedAgents"] = h.agentService.GetAgentCount()
+		c.JSON(http.StatusOK, summary)
+		return
+	}
+	visible := make(map[string]bool, len(visibleAgents))
+	for _, id := range visibleAgents {
+		visible[id] = true
+	}
+
+	summary := h.metricsService.GetWeightedSummary(func(agentID string) bool { return visible[agentID] })
+	connected := 0
+	for _, agent := range h.agentService.GetAllAgents() {
+		if visible[agent.ID] {
+			connected++
+		}
+	}
+	summary["connectedAgents"] = connected
+	c.JSON(http.StatusOK, summary)
+}
+
+// SetAgentWeightRequest represents a set agent weight request
+type SetAgentWeightRequest struct {
+	Weight float64 `json:"weight" binding:"min=0"`
+}
+
+// SetAgentWeight sets the importance weight of an agent (0 resets to default)
+func (h *Handler) SetAgentWeight(c *gin.Context) {
+	agentID := c.Param("id")
+
+	var req SetAgentWeightRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.metricsService.SetAgentWeight(agentID, req.Weight)
+	c.JSON(http.StatusOK, gin.H{
+		"agentId": agentID,
+		"weight":  h.metricsService.GetAgentWeight(agentID),
+	})
+}
+
 // CommandRequest represents a command request
 type CommandRequest struct {
 	Type   string            `json:"type" binding:"required"`
@@ -364,6 +662,12 @@ func (h *Handler) SendCommand(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if h.commandTemplates != nil {
+		if err := h.commandTemplates.Validate(req.Type, req.Target, req.Params); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	agent := h.agentService.GetAgent(agentID)
 	if agent == nil {
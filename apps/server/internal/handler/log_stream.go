@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	grpcserver "github.com/chenqi92/NanoLink/apps/server/internal/grpc"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Lines from the end of the file a log stream starts with by default
+const defaultLogStreamLines = 100
+
+// LogStreamer starts log file streams on agents
+type LogStreamer interface {
+	StartLogStream(agentID, path string, follow bool, tailLines int) (*grpcserver.LogStream, error)
+}
+
+// AgentPermissionChecker checks a user's permission level on an agent
+type AgentPermissionChecker interface {
+	CanUserExecuteCommand(userID uint, agentID string, minLevel int) (bool, error)
+}
+
+// LogStreamHandler tails agent log files over Server-Sent Events
+type LogStreamHandler struct {
+	streamer  LogStreamer
+	logger    *zap.SugaredLogger
+	keepAlive time.Duration
+}
+
+// NewLogStreamHandler creates a log stream handler
+func NewLogStreamHandler(streamer LogStreamer, logger *zap.SugaredLogger) *LogStreamHandler {
+	return &LogStreamHandler{streamer: streamer, logger: logger, keepAlive: streamKeepAlive}
+}
+
+// StreamLogs tails a log file on an agent. Each SSE data frame is a
+// LogChunk; the last has eof set. The agent stops streaming when the
+// client disconnects.
+// GET /api/agents/:id/logs/stream?path=&follow=true&lines=100
+func (h *LogStreamHandler) StreamLogs(c *gin.Context) {
+	agentID := c.Param("id")
+	follow, err := strconv.ParseBool(c.DefaultQuery("follow", "true"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid follow"})
+		return
+	}
+	lines, err := strconv.Atoi(c.DefaultQuery("lines", strconv.Itoa(defaultLogStreamLines)))
+	if err != nil || lines < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid lines"})
+		return
+	}
+
+	stream, err := h.streamer.StartLogStream(agentID, c.Query("path"), follow, lines)
+	if err != nil {
+		c.JSON(logStreamErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	defer stream.Stop()
+	if user := GetCurrentUser(c); user != nil {
+		h.logger.Infof("User %s streaming %s from agent %s", user.Username, stream.Path, agentID)
+	}
+
+	w := c.Writer
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	ticker := time.NewTicker(h.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case chunk, ok := <-stream.C:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(chunk)
+			if err != nil {
+				continue
+			}
+			if _, err := writeStreamFrame(w, 0, data); err != nil {
+				return
+			}
+			w.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			w.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// logStreamErrorStatus maps a failure to start a log stream to a status code
+func logStreamErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, grpcserver.ErrLogStreamPath):
+		return http.StatusBadRequest
+	case errors.Is(err, grpcserver.ErrTooManyLogStreams):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
+
+// LogStreamRequest starts a log stream from the dashboard WebSocket
+type LogStreamRequest struct {
+	AgentID string `json:"agentId"`
+	Path    string `json:"path"`
+	Follow  bool   `json:"follow"`
+	Lines   *int   `json:"lines,omitempty"` // Default 100
+}
+
+// LogStreamStatus answers a LogStreamRequest
+type LogStreamStatus struct {
+	StreamID string `json:"streamId,omitempty"`
+	AgentID  string `json:"agentId"`
+	Path     string `json:"path"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SetLogStreams lets dashboard clients tail agent log files, which needs
+// ServiceControl permission on the agent
+func (h *DashboardWSHandler) SetLogStreams(streamer LogStreamer, perms AgentPermissionChecker) {
+	h.logStreamer = streamer
+	h.logPerms = perms
+}
+
+// startLogStream starts a stream for a dashboard client, forwarding its
+// chunks as log_chunk messages
+func (h *DashboardWSHandler) startLogStream(client *dashboardClient, data interface{}) {
+	var req LogStreamRequest
+	raw, _ := json.Marshal(data)
+	if err := json.Unmarshal(raw, &req); err != nil || req.AgentID == "" {
+		h.sendLogStreamStatus(client, LogStreamStatus{Error: "agentId and path are required"})
+		return
+	}
+	if agentID, err := h.agentService.Resolve(req.AgentID); err == nil {
+		req.AgentID = agentID
+	}
+	status := LogStreamStatus{AgentID: req.AgentID, Path: req.Path}
+
+	if h.logStreamer == nil {
+		status.Error = "log streaming is not available"
+		h.sendLogStreamStatus(client, status)
+		return
+	}
+	if !client.isSuperAdmin {
+		allowed := false
+		if h.logPerms != nil {
+			var err error
+			allowed, err = h.logPerms.CanUserExecuteCommand(client.userID, req.AgentID, database.PermissionServiceControl)
+			if err != nil {
+				h.logger.Warnf("Log stream permission check failed for user %d: %v", client.userID, err)
+			}
+		}
+		if !allowed {
+			status.Error = "insufficient permissions"
+			h.sendLogStreamStatus(client, status)
+			return
+		}
+	}
+
+	lines := defaultLogStreamLines
+	if req.Lines != nil {
+		lines = *req.Lines
+	}
+	stream, err := h.logStreamer.StartLogStream(req.AgentID, req.Path, req.Follow, lines)
+	if err != nil {
+		status.Error = err.Error()
+		h.sendLogStreamStatus(client, status)
+		return
+	}
+
+	client.mu.Lock()
+	if client.closed {
+		client.mu.Unlock()
+		stream.Stop()
+		return
+	}
+	if client.logStreams == nil {
+		client.logStreams = make(map[string]*grpcserver.LogStream)
+	}
+	client.logStreams[stream.ID] = stream
+	client.mu.Unlock()
+	h.logger.Infof("Dashboard user %s streaming %s from agent %s", client.username, stream.Path, req.AgentID)
+
+	status.StreamID = stream.ID
+	h.sendLogStreamStatus(client, status)
+	go func() {
+		for chunk := range stream.C {
+			h.sendToClient(client, &DashboardMessage{
+				Type:      MsgTypeLogChunk,
+				Timestamp: time.Now().UnixMilli(),
+				Data:      chunk,
+			})
+		}
+		client.mu.Lock()
+		delete(client.logStreams, stream.ID)
+		client.mu.Unlock()
+	}()
+}
+
+// stopLogStream stops one of a dashboard client's streams
+func (h *DashboardWSHandler) stopLogStream(client *dashboardClient, streamID string) {
+	client.mu.Lock()
+	stream := client.logStreams[streamID]
+	client.mu.Unlock()
+	if stream != nil {
+		stream.Stop()
+	}
+}
+
+// stopLogStreams stops every stream of a disconnected dashboard client
+func (h *DashboardWSHandler) stopLogStreams(client *dashboardClient) {
+	client.mu.Lock()
+	streams := make([]*grpcserver.LogStream, 0, len(client.logStreams))
+	for _, stream := range client.logStreams {
+		streams = append(streams, stream)
+	}
+	client.mu.Unlock()
+	for _, stream := range streams {
+		stream.Stop()
+	}
+}
+
+func (h *DashboardWSHandler) sendLogStreamStatus(client *dashboardClient, status LogStreamStatus) {
+	h.sendToClient(client, &DashboardMessage{
+		Type:      MsgTypeLogStream,
+		Timestamp: time.Now().UnixMilli(),
+		Data:      status,
+	})
+}
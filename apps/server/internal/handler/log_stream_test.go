@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	grpcserver "github.com/chenqi92/NanoLink/apps/server/internal/grpc"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// failingStreamer refuses every log stream with err
+type failingStreamer struct {
+	err   error
+	calls int
+}
+
+func (f *failingStreamer) StartLogStream(agentID, path string, follow bool, tailLines int) (*grpcserver.LogStream, error) {
+	f.calls++
+	return nil, f.err
+}
+
+// fixedPermission grants ServiceControl to the listed users
+type fixedPermission map[uint]bool
+
+func (f fixedPermission) CanUserExecuteCommand(userID uint, agentID string, minLevel int) (bool, error) {
+	return f[userID], nil
+}
+
+func TestStreamLogsRejections(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	streamer := &failingStreamer{err: fmt.Errorf("%w: 4 open", grpcserver.ErrTooManyLogStreams)}
+	router := gin.New()
+	router.GET("/agents/:id/logs/stream", NewLogStreamHandler(streamer, zap.NewNop().Sugar()).StreamLogs)
+
+	for _, tc := range []struct {
+		query string
+		want  int
+	}{
+		{"?path=/var/log/syslog&follow=maybe", http.StatusBadRequest},
+		{"?path=/var/log/syslog&lines=-1", http.StatusBadRequest},
+		{"?path=/var/log/syslog", http.StatusTooManyRequests},
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/agents/agent-1/logs/stream"+tc.query, nil))
+		if rec.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d: %s", tc.query, tc.want, rec.Code, rec.Body)
+		}
+	}
+	if streamer.calls != 1 {
+		t.Errorf("Expected invalid requests not to reach the agent, got %d calls", streamer.calls)
+	}
+}
+
+func TestDashboardLogStreamNeedsServiceControl(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(logger)
+	h := NewDashboardWSHandler(logger, nil, service.NewAgentService(logger, ms), ms)
+	streamer := &failingStreamer{err: grpcserver.ErrLogStreamPath}
+	h.SetLogStreams(streamer, fixedPermission{8: true})
+
+	request := map[string]interface{}{"agentId": "agent-1", "path": "/var/log/syslog", "follow": true}
+	viewer := &dashboardClient{userID: 7, send: make(chan []byte, 4), subscriptions: make(map[string]bool)}
+	h.startLogStream(viewer, request)
+	msg := nextBroadcast(t, viewer)
+	if data, _ := msg.Data.(map[string]interface{}); msg.Type != MsgTypeLogStream || data["error"] != "insufficient permissions" {
+		t.Errorf("Expected the stream to be refused, got %+v", msg)
+	}
+	if streamer.calls != 0 {
+		t.Errorf("Expected no stream started without permission, got %d", streamer.calls)
+	}
+
+	// Permitted users reach the agent, and learn why it failed
+	operator := &dashboardClient{userID: 8, send: make(chan []byte, 4), subscriptions: make(map[string]bool)}
+	h.startLogStream(operator, request)
+	msg = nextBroadcast(t, operator)
+	if data, _ := msg.Data.(map[string]interface{}); streamer.calls != 1 || data["error"] != grpcserver.ErrLogStreamPath.Error() {
+		t.Errorf("Expected the agent's error, got %+v", msg)
+	}
+}
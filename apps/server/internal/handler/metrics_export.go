@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// Range exported when from is omitted
+const defaultExportRange = 7 * 24 * time.Hour
+
+// metricsExportHeader is the CSV header row of a metrics export
+var metricsExportHeader = []string{
+	"timestamp", "cpu_percent", "mem_percent", "disk_read_ps", "disk_write_ps",
+	"disk_percent", "net_rx_ps", "net_tx_ps", "gpu_percent", "load_avg_1",
+}
+
+// ExportMetricsHistory streams an agent's metrics history as CSV or a JSON
+// array, for spreadsheets and offline analysis. Samples come from metrics
+// persistence when enabled, otherwise from in-memory history.
+// GET /api/agents/:id/metrics/export
+// Query params:
+// - format: csv or json (default csv)
+// - from, to: ISO8601 or Unix milliseconds (default the last 7 days)
+func (h *Handler) ExportMetricsHistory(c *gin.Context) {
+	agentID := c.Param("id")
+
+	// Check permission if service is available
+	if h.permService != nil {
+		user := GetCurrentUser(c)
+		if user != nil && !user.IsSuperAdmin {
+			canAccess, err := h.permService.CanUserAccessAgent(user.ID, agentID)
+			if err != nil || !canAccess {
+				c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+				return
+			}
+		}
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or json"})
+		return
+	}
+
+	to := time.Now()
+	if raw := c.Query("to"); raw != "" {
+		t, err := parseTimestamp(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to timestamp"})
+			return
+		}
+		to = t
+	}
+	from := to.Add(-defaultExportRange)
+	if raw := c.Query("from"); raw != "" {
+		t, err := parseTimestamp(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from timestamp"})
+			return
+		}
+		from = t
+	}
+	if from.After(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be after to"})
+		return
+	}
+
+	var w metricsExportWriter
+	if format == "json" {
+		w = &jsonExportWriter{w: c.Writer}
+		c.Header("Content-Type", "application/json")
+	} else {
+		w = &csvExportWriter{w: csv.NewWriter(c.Writer)}
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-metrics.%s"`, agentID, format))
+	c.Status(http.StatusOK)
+
+	write := func(batch []database.MetricsHistory) error {
+		if err := w.write(batch); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+
+	err := w.begin()
+	if err == nil {
+		if h.metricsPersistence != nil {
+			err = h.metricsPersistence.EachHistoryBatch(agentID, from, to, write)
+		} else {
+			err = write(service.HistoryRecords(agentID, h.metricsService.GetMetricsHistoryRange(agentID, from, to)))
+		}
+	}
+	if err == nil {
+		err = w.end()
+	}
+	// The status is already sent, so a failure can only cut the export short
+	if err != nil {
+		h.logger.Errorf("Failed to export metrics history for %s: %v", agentID, err)
+		return
+	}
+	c.Writer.Flush()
+}
+
+// metricsExportWriter writes samples in one export format
+type metricsExportWriter interface {
+	begin() error
+	write(batch []database.MetricsHistory) error
+	end() error
+}
+
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func (e *csvExportWriter) begin() error {
+	return e.w.Write(metricsExportHeader)
+}
+
+func (e *csvExportWriter) write(batch []database.MetricsHistory) error {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	u := func(v uint64) string { return strconv.FormatUint(v, 10) }
+	// Disk usage is left blank on rows from before it was recorded
+	opt := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return f(*v)
+	}
+	for _, m := range batch {
+		if err := e.w.Write([]string{
+			m.Timestamp.UTC().Format(time.RFC3339), f(m.CPUPercent), f(m.MemPercent), u(m.DiskReadPS), u(m.DiskWritePS),
+			opt(m.DiskPercent), u(m.NetRxPS), u(m.NetTxPS), f(m.GPUPercent), f(m.LoadAvg1),
+		}); err != nil {
+			return err
+		}
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExportWriter) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonExportWriter streams samples as one JSON array
+type jsonExportWriter struct {
+	w     gin.ResponseWriter
+	count int
+}
+
+func (e *jsonExportWriter) begin() error {
+	_, err := e.w.WriteString("[")
+	return err
+}
+
+func (e *jsonExportWriter) write(batch []database.MetricsHistory) error {
+	for i := range batch {
+		data, err := json.Marshal(&batch[i])
+		if err != nil {
+			return err
+		}
+		if e.count > 0 {
+			if _, err := e.w.WriteString(","); err != nil {
+				return err
+			}
+		}
+		if _, err := e.w.Write(data); err != nil {
+			return err
+		}
+		e.count++
+	}
+	return nil
+}
+
+func (e *jsonExportWriter) end() error {
+	_, err := e.w.WriteString("]\n")
+	return err
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
@@ -43,6 +44,8 @@ func AuthMiddleware(authService *service.AuthService) gin.HandlerFunc {
 			errMsg := "invalid token"
 			if err == service.ErrTokenExpired {
 				errMsg = "token expired"
+			} else if err == service.ErrTokenRevoked {
+				errMsg = "token revoked"
 			}
 			c.JSON(statusCode, gin.H{"error": errMsg})
 			c.Abort()
@@ -171,6 +174,41 @@ func RequireAgentPermission(permService *service.PermissionService, minLevel int
 	}
 }
 
+// ResolveAgentParam lets an agent path parameter name an agent by hostname
+// as well as ID, replacing a hostname with the agent's ID for later
+// handlers and permission checks. Values matching no connected agent are
+// left as they are, e.g. for offline agents.
+func ResolveAgentParam(agentService *service.AgentService, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		agentID, ok := resolveAgentID(c, agentService, c.Param(param))
+		if !ok {
+			c.Abort()
+			return
+		}
+		for i := range c.Params {
+			if c.Params[i].Key == param {
+				c.Params[i].Value = agentID
+			}
+		}
+		c.Next()
+	}
+}
+
+// resolveAgentID resolves an agent ID or hostname, writing a 409 response
+// for a hostname shared by several agents. The response does not name them,
+// since the caller may not be allowed to see every one.
+func resolveAgentID(c *gin.Context, agentService *service.AgentService, idOrHostname string) (string, bool) {
+	agentID, err := agentService.Resolve(idOrHostname)
+	if errors.Is(err, service.ErrAmbiguousHostname) {
+		c.JSON(http.StatusConflict, gin.H{"error": "hostname is shared by several agents; use an agent ID"})
+		return "", false
+	}
+	if err != nil {
+		return idOrHostname, true
+	}
+	return agentID, true
+}
+
 // GetCurrentUser returns the current authenticated user from context
 func GetCurrentUser(c *gin.Context) *database.User {
 	user, exists := c.Get(ContextKeyUser)
@@ -196,3 +234,13 @@ func GetCurrentClaims(c *gin.Context) *service.JWTClaims {
 	}
 	return c_
 }
+
+// GetAuditActor returns the current user as the actor for audited changes
+func GetAuditActor(c *gin.Context) service.AuditActor {
+	actor := service.AuditActor{IPAddress: c.ClientIP()}
+	if u := GetCurrentUser(c); u != nil {
+		actor.UserID = u.ID
+		actor.Username = u.Username
+	}
+	return actor
+}
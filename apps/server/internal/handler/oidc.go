@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// GetAuthProviders reports which login methods are available, so the login
+// page can offer single sign-on
+// GET /api/auth/providers
+func (h *AuthHandler) GetAuthProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"local": true,
+		"oidc":  h.authService.OIDC() != nil,
+	})
+}
+
+// OIDCLogin sends the browser to the OIDC provider to sign in
+// GET /api/auth/oidc/login
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	provider := h.authService.OIDC()
+	if provider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not configured"})
+		return
+	}
+
+	authURL, err := provider.AuthURL(c.Request.Context())
+	if err != nil {
+		h.logger.Errorf("OIDC login failed: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider unavailable"})
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback completes an OIDC login and sends the browser back to the
+// dashboard with the NanoLink tokens (or an error) in the URL fragment,
+// which is never sent to servers
+// GET /api/auth/oidc/callback?state=&code=
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	provider := h.authService.OIDC()
+	if provider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not configured"})
+		return
+	}
+	fail := func(message string) {
+		c.Redirect(http.StatusFound, provider.PostLoginURL()+"#"+url.Values{"oidcError": {message}}.Encode())
+	}
+
+	if idpErr := c.Query("error"); idpErr != "" {
+		h.logger.Warnf("OIDC provider returned an error: %s: %s", idpErr, c.Query("error_description"))
+		fail("sign-in was cancelled or refused by the identity provider")
+		return
+	}
+
+	identity, err := provider.Exchange(c.Request.Context(), c.Query("state"), c.Query("code"))
+	if err != nil {
+		h.logger.Warnf("OIDC callback failed: %v", err)
+		if errors.Is(err, service.ErrOIDCState) {
+			fail("sign-in expired, please try again")
+		} else {
+			fail("sign-in could not be verified")
+		}
+		return
+	}
+
+	pair, _, err := h.authService.LoginOIDC(identity)
+	if err != nil {
+		h.logger.Warnf("OIDC login for %s failed: %v", identity.Username, err)
+		if errors.Is(err, service.ErrUserExists) {
+			fail("a local account already uses this username")
+		} else {
+			fail("sign-in failed")
+		}
+		return
+	}
+
+	fragment := url.Values{
+		"token":        {pair.AccessToken},
+		"refreshToken": {pair.RefreshToken},
+		"expiresAt":    {pair.ExpiresAt.Format(time.RFC3339)},
+	}
+	c.Redirect(http.StatusFound, provider.PostLoginURL()+"#"+fragment.Encode())
+}
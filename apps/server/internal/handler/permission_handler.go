@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -29,13 +30,17 @@ type AssignAgentToGroupRequest struct {
 	AgentID         string `json:"agentId" binding:"required"`
 	GroupID         uint   `json:"groupId" binding:"required"`
 	PermissionLevel int    `json:"permissionLevel" binding:"min=0,max=3"`
+	// Capabilities optionally narrows the grant to view and/or control bits (0 = both)
+	Capabilities *int `json:"capabilities" binding:"omitempty,min=0,max=3"`
 }
 
 // SetUserPermissionRequest represents a set user permission request
 type SetUserPermissionRequest struct {
 	UserID          uint   `json:"userId" binding:"required"`
-	AgentID         string `json:"agentId" binding:"required"`
+	AgentID         string `json:"agentId" binding:"required"` // Agent ID, hostname pattern such as web-* or label selector such as env=prod
 	PermissionLevel int    `json:"permissionLevel" binding:"min=0,max=3"`
+	// Capabilities optionally narrows the grant to view and/or control bits (0 = both)
+	Capabilities *int `json:"capabilities" binding:"omitempty,min=0,max=3"`
 }
 
 // AgentGroupResponse represents an agent-group assignment in API responses
@@ -46,6 +51,7 @@ type AgentGroupResponse struct {
 	GroupName       string `json:"groupName,omitempty"`
 	PermissionLevel int    `json:"permissionLevel"`
 	PermissionName  string `json:"permissionName"`
+	Capabilities    int    `json:"capabilities"`
 }
 
 // UserPermissionResponse represents a user permission in API responses
@@ -54,8 +60,10 @@ type UserPermissionResponse struct {
 	UserID          uint   `json:"userId"`
 	Username        string `json:"username,omitempty"`
 	AgentID         string `json:"agentId"`
+	GrantType       string `json:"grantType"`
 	PermissionLevel int    `json:"permissionLevel"`
 	PermissionName  string `json:"permissionName"`
+	Capabilities    int    `json:"capabilities"`
 }
 
 // AssignAgentToGroup assigns an agent to a group
@@ -66,7 +74,8 @@ func (h *PermissionHandler) AssignAgentToGroup(c *gin.Context) {
 		return
 	}
 
-	if err := h.permService.AssignAgentToGroup(req.AgentID, req.GroupID, req.PermissionLevel); err != nil {
+	perms := h.permService.WithActor(GetAuditActor(c))
+	if err := perms.AssignAgentToGroup(req.AgentID, req.GroupID, req.PermissionLevel); err != nil {
 		if err == service.ErrGroupNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
 			return
@@ -80,12 +89,22 @@ func (h *PermissionHandler) AssignAgentToGroup(c *gin.Context) {
 		return
 	}
 
+	// Capabilities are left untouched unless given
+	if req.Capabilities != nil {
+		if err := perms.SetAgentGroupCapabilities(req.AgentID, req.GroupID, *req.Capabilities); err != nil {
+			h.logger.Errorf("Set agent group capabilities failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set capabilities"})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":         "agent assigned to group",
 		"agentId":         req.AgentID,
 		"groupId":         req.GroupID,
 		"permissionLevel": req.PermissionLevel,
 		"permissionName":  database.PermissionLevelName(req.PermissionLevel),
+		"capabilities":    req.Capabilities,
 	})
 }
 
@@ -100,7 +119,7 @@ func (h *PermissionHandler) RemoveAgentFromGroup(c *gin.Context) {
 		return
 	}
 
-	if err := h.permService.RemoveAgentFromGroup(agentID, uint(groupID)); err != nil {
+	if err := h.permService.WithActor(GetAuditActor(c)).RemoveAgentFromGroup(agentID, uint(groupID)); err != nil {
 		if err == service.ErrAgentNotAssigned {
 			c.JSON(http.StatusNotFound, gin.H{"error": "agent not assigned to this group"})
 			return
@@ -128,24 +147,29 @@ func (h *PermissionHandler) SetUserPermission(c *gin.Context) {
 		return
 	}
 
-	// Check if the permission level is within the agent's max level
-	maxLevel, err := h.permService.GetAgentMaxPermissionLevel(req.AgentID)
-	if err != nil {
-		h.logger.Errorf("Get agent max permission failed: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check permission level"})
-		return
-	}
+	// Check if the permission level is within the agent's max level. Pattern
+	// grants also cover agents not known yet, so they are capped at each
+	// matched agent's max level when resolved instead.
+	if service.AgentGrantType(req.AgentID) == database.GrantTypeAgent {
+		maxLevel, err := h.permService.GetAgentMaxPermissionLevel(req.AgentID)
+		if err != nil {
+			h.logger.Errorf("Get agent max permission failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check permission level"})
+			return
+		}
 
-	if req.PermissionLevel > maxLevel {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":    "permission level exceeds agent's maximum",
-			"maxLevel": maxLevel,
-			"maxName":  database.PermissionLevelName(maxLevel),
-		})
-		return
+		if req.PermissionLevel > maxLevel {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":    "permission level exceeds agent's maximum",
+				"maxLevel": maxLevel,
+				"maxName":  database.PermissionLevelName(maxLevel),
+			})
+			return
+		}
 	}
 
-	if err := h.permService.SetUserAgentPermission(req.UserID, req.AgentID, req.PermissionLevel, currentUser.ID); err != nil {
+	perms := h.permService.WithActor(GetAuditActor(c))
+	if err := perms.SetUserAgentPermission(req.UserID, req.AgentID, req.PermissionLevel, currentUser.ID); err != nil {
 		if err == service.ErrUserNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 			return
@@ -154,17 +178,32 @@ func (h *PermissionHandler) SetUserPermission(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid permission level"})
 			return
 		}
+		if errors.Is(err, service.ErrInvalidAgentPattern) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		h.logger.Errorf("Set user permission failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set permission"})
 		return
 	}
 
+	// Capabilities are left untouched unless given
+	if req.Capabilities != nil {
+		if err := perms.SetUserAgentCapabilities(req.UserID, req.AgentID, *req.Capabilities); err != nil {
+			h.logger.Errorf("Set user capabilities failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set capabilities"})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":         "permission set",
 		"userId":          req.UserID,
 		"agentId":         req.AgentID,
+		"grantType":       service.AgentGrantType(req.AgentID),
 		"permissionLevel": req.PermissionLevel,
 		"permissionName":  database.PermissionLevelName(req.PermissionLevel),
+		"capabilities":    req.Capabilities,
 	})
 }
 
@@ -179,7 +218,7 @@ func (h *PermissionHandler) RemoveUserPermission(c *gin.Context) {
 		return
 	}
 
-	if err := h.permService.RemoveUserAgentPermission(uint(userID), agentID); err != nil {
+	if err := h.permService.WithActor(GetAuditActor(c)).RemoveUserAgentPermission(uint(userID), agentID); err != nil {
 		h.logger.Errorf("Remove user permission failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove permission"})
 		return
@@ -211,8 +250,10 @@ func (h *PermissionHandler) GetUserPermissions(c *gin.Context) {
 			ID:              p.ID,
 			UserID:          p.UserID,
 			AgentID:         p.AgentID,
+			GrantType:       p.GrantType,
 			PermissionLevel: p.PermissionLevel,
 			PermissionName:  database.PermissionLevelName(p.PermissionLevel),
+			Capabilities:    database.EffectiveCapabilities(p.Capabilities),
 		}
 	}
 
@@ -239,6 +280,7 @@ func (h *PermissionHandler) GetAgentGroups(c *gin.Context) {
 			GroupName:       g.Group.Name,
 			PermissionLevel: g.PermissionLevel,
 			PermissionName:  database.PermissionLevelName(g.PermissionLevel),
+			Capabilities:    database.EffectiveCapabilities(g.Capabilities),
 		}
 	}
 
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// prometheusContentType is the text exposition format Prometheus scrapes
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// PrometheusHandler exposes current agent metrics in the Prometheus text
+// exposition format
+type PrometheusHandler struct {
+	metricsService *service.MetricsService
+	perCoreCPU     bool
+	token          string
+}
+
+// NewPrometheusHandler creates a Prometheus handler exposing per-core CPU
+// series and requiring no authentication
+func NewPrometheusHandler(ms *service.MetricsService) *PrometheusHandler {
+	return &PrometheusHandler{metricsService: ms, perCoreCPU: true}
+}
+
+// SetPerCoreCPU sets whether a series is exposed for every CPU core, which
+// multiplies cardinality on large hosts
+func (h *PrometheusHandler) SetPerCoreCPU(enabled bool) {
+	h.perCoreCPU = enabled
+}
+
+// SetBearerToken requires scrapes to send this bearer token; empty allows
+// anonymous scrapes
+func (h *PrometheusHandler) SetBearerToken(token string) {
+	h.token = token
+}
+
+// Metrics writes the current metrics of every agent
+// GET /metrics
+func (h *PrometheusHandler) Metrics(c *gin.Context) {
+	if h.token != "" {
+		got := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(h.token)) != 1 {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.String(http.StatusUnauthorized, "unauthorized\n")
+			return
+		}
+	}
+
+	all := h.metricsService.GetAllCurrentMetrics()
+	agentIDs := make([]string, 0, len(all))
+	for id := range all {
+		agentIDs = append(agentIDs, id)
+	}
+	sort.Strings(agentIDs)
+
+	var w promWriter
+	for _, id := range agentIDs {
+		data := all[id]
+		// Static fields such as total memory are unknown until the first full snapshot
+		if data.Incomplete {
+			continue
+		}
+		h.writeAgent(&w, id, data)
+	}
+	c.Data(http.StatusOK, prometheusContentType, w.bytes())
+}
+
+func (h *PrometheusHandler) writeAgent(w *promWriter, agentID string, data *service.MetricsData) {
+	hostname := ""
+	if data.SystemInfo != nil {
+		hostname = data.SystemInfo.Hostname
+	}
+	agent := []string{"agent", agentID, "hostname", hostname}
+	with := func(labels ...string) []string {
+		return append(append([]string(nil), agent...), labels...)
+	}
+
+	stale := 0.0
+	if data.Stale {
+		stale = 1
+	}
+	w.gauge("nanolink_metrics_age_seconds", "Seconds since the agent last reported metrics", data.AgeSeconds, agent)
+	w.gauge("nanolink_metrics_stale", "1 if the agent's metrics are stale", stale, agent)
+
+	w.gauge("nanolink_cpu_usage_percent", "CPU usage across all cores", data.CPU.UsagePercent, agent)
+	if h.perCoreCPU {
+		for i, usage := range data.CPU.PerCoreUsage {
+			w.gauge("nanolink_cpu_core_usage_percent", "CPU usage of one core", usage, with("core", strconv.Itoa(i)))
+		}
+	}
+	if len(data.LoadAverage) > 0 {
+		w.gauge("nanolink_load1", "1 minute load average", data.LoadAverage[0], agent)
+	}
+
+	w.gauge("nanolink_memory_used_bytes", "Memory in use", float64(data.Memory.Used), agent)
+	w.gauge("nanolink_memory_total_bytes", "Total memory", float64(data.Memory.Total), agent)
+
+	for _, d := range data.Disks {
+		labels := with("mount", d.MountPoint, "device", d.Device)
+		w.gauge("nanolink_disk_usage_percent", "Percent of disk space in use", d.UsagePercent, labels)
+		w.gauge("nanolink_disk_used_bytes", "Disk space in use", float64(d.Used), labels)
+		w.gauge("nanolink_disk_total_bytes", "Disk size", float64(d.Total), labels)
+	}
+
+	for _, n := range data.Networks {
+		labels := with("interface", n.Interface)
+		w.gauge("nanolink_network_receive_bytes_per_second", "Network receive rate", float64(n.RxBytesPS), labels)
+		w.gauge("nanolink_network_transmit_bytes_per_second", "Network transmit rate", float64(n.TxBytesPS), labels)
+	}
+
+	for _, g := range data.GPUs {
+		labels := with("index", strconv.Itoa(g.Index), "name", g.Name)
+		w.gauge("nanolink_gpu_usage_percent", "GPU utilization", g.UsagePercent, labels)
+		w.gauge("nanolink_gpu_memory_used_bytes", "GPU memory in use", float64(g.MemoryUsed), labels)
+		w.gauge("nanolink_gpu_memory_total_bytes", "GPU memory size", float64(g.MemoryTotal), labels)
+		w.gauge("nanolink_gpu_temperature_celsius", "GPU temperature", g.Temperature, labels)
+		w.gauge("nanolink_gpu_power_watts", "GPU power draw", float64(g.PowerWatts), labels)
+	}
+}
+
+// promFamily is one metric name with its HELP text and samples
+type promFamily struct {
+	name    string
+	help    string
+	samples []string
+}
+
+// promWriter groups samples by metric name, keeping names in first-seen order
+type promWriter struct {
+	families []*promFamily
+	index    map[string]*promFamily
+}
+
+// gauge adds a sample; labels are name/value pairs
+func (w *promWriter) gauge(name, help string, value float64, labels []string) {
+	if w.index == nil {
+		w.index = make(map[string]*promFamily)
+	}
+	family := w.index[name]
+	if family == nil {
+		family = &promFamily{name: name, help: help}
+		w.index[name] = family
+		w.families = append(w.families, family)
+	}
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(promLabelEscaper.Replace(labels[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteString("} ")
+	b.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	family.samples = append(family.samples, b.String())
+}
+
+func (w *promWriter) bytes() []byte {
+	var b strings.Builder
+	for _, f := range w.families {
+		b.WriteString("# HELP " + f.name + " " + f.help + "\n")
+		b.WriteString("# TYPE " + f.name + " gauge\n")
+		for _, sample := range f.samples {
+			b.WriteString(sample)
+			b.WriteByte('\n')
+		}
+	}
+	return []byte(b.String())
+}
+
+// promLabelEscaper escapes label values as the exposition format requires
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func scrape(t *testing.T, h *PrometheusHandler, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	router := gin.New()
+	router.GET("/metrics", h.Metrics)
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPrometheusExposition(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ms := service.NewMetricsService(zap.NewNop().Sugar())
+	ms.StoreMetrics("agent-1", &service.MetricsData{
+		CPU:        service.CPUData{UsagePercent: 42.5, PerCoreUsage: []float64{40, 45}},
+		Memory:     service.MemData{Total: 8 << 30, Used: 2 << 30},
+		Disks:      []service.DiskData{{MountPoint: `C:\`, Device: "disk0", UsagePercent: 71}},
+		GPUs:       []service.GPUData{{Index: 1, Name: "RTX 4090", UsagePercent: 88}},
+		SystemInfo: &service.SystemInfo{Hostname: "web-1"},
+	})
+	h := NewPrometheusHandler(ms)
+
+	rec := scrape(t, h, "")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("Expected the text exposition format, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE nanolink_cpu_usage_percent gauge\n",
+		`nanolink_cpu_usage_percent{agent="agent-1",hostname="web-1"} 42.5` + "\n",
+		`nanolink_cpu_core_usage_percent{agent="agent-1",hostname="web-1",core="1"} 45` + "\n",
+		`nanolink_memory_used_bytes{agent="agent-1",hostname="web-1"} 2147483648` + "\n",
+		`nanolink_disk_usage_percent{agent="agent-1",hostname="web-1",mount="C:\\",device="disk0"} 71` + "\n",
+		`nanolink_gpu_usage_percent{agent="agent-1",hostname="web-1",index="1",name="RTX 4090"} 88` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in:\n%s", want, body)
+		}
+	}
+	if strings.Count(body, "# TYPE nanolink_cpu_core_usage_percent") != 1 {
+		t.Errorf("Expected one TYPE line per metric, got:\n%s", body)
+	}
+
+	h.SetPerCoreCPU(false)
+	if body := scrape(t, h, "").Body.String(); strings.Contains(body, "nanolink_cpu_core_usage_percent") {
+		t.Errorf("Expected per-core series to be excluded, got:\n%s", body)
+	}
+}
+
+func TestPrometheusBearerToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewPrometheusHandler(service.NewMetricsService(zap.NewNop().Sugar()))
+	h.SetBearerToken("scrape-secret")
+
+	if rec := scrape(t, h, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected anonymous scrapes to be refused, got %d", rec.Code)
+	}
+	if rec := scrape(t, h, "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong token to be refused, got %d", rec.Code)
+	}
+	if rec := scrape(t, h, "scrape-secret"); rec.Code != http.StatusOK {
+		t.Errorf("Expected the token to be accepted, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestResolveAgentParam(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(logger)
+	as := service.NewAgentService(logger, ms)
+	as.RegisterGrpcAgent("agent-1", service.AgentInfo{Hostname: "web-1"}, 0)
+	as.RegisterGrpcAgent("agent-2", service.AgentInfo{Hostname: "db-1"}, 0)
+	as.RegisterGrpcAgent("agent-3", service.AgentInfo{Hostname: "db-1"}, 0)
+	ms.StoreMetrics("agent-1", &service.MetricsData{AgentID: "agent-1", CPU: service.CPUData{UsagePercent: 12}})
+	h := NewHandler(as, ms, logger)
+
+	router := gin.New()
+	router.GET("/api/agents/:id/metrics", ResolveAgentParam(as, "id"), h.GetAgentMetrics)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/api/agents/agent-1/metrics", http.StatusOK},
+		{"/api/agents/web-1/metrics", http.StatusOK},
+		{"/api/agents/db-1/metrics", http.StatusConflict},
+		{"/api/agents/missing/metrics", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("GET %s: expected status %d, got %d: %s", tt.path, tt.status, rec.Code, rec.Body.String())
+		}
+		if tt.status == http.StatusOK && !strings.Contains(rec.Body.String(), `"agentId":"agent-1"`) {
+			t.Errorf("GET %s: expected agent-1's metrics, got %s", tt.path, rec.Body.String())
+		}
+		if tt.status == http.StatusConflict && strings.Contains(rec.Body.String(), "agent-") {
+			t.Errorf("GET %s: expected the conflict not to name agents, got %s", tt.path, rec.Body.String())
+		}
+	}
+}
+
+func TestShellResolvesHostnameAfterAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop().Sugar()
+	as := service.NewAgentService(logger, service.NewMetricsService(logger))
+	as.RegisterGrpcAgent("agent-2", service.AgentInfo{Hostname: "db-1"}, 0)
+	as.RegisterGrpcAgent("agent-3", service.AgentInfo{Hostname: "db-1"}, 0)
+	shell := NewShellHandler(logger, rejectingVerifier{}, nil)
+	shell.SetAgentService(as)
+
+	router := gin.New()
+	router.GET("/ws/shell/:id", shell.HandleShellWS)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ws/shell/db-1?token=forged", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an unauthenticated request to learn nothing about db-1, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// rejectingVerifier refuses every token
+type rejectingVerifier struct{}
+
+func (rejectingVerifier) VerifyToken(string) (*service.JWTClaims, error) {
+	return nil, service.ErrInvalidToken
+}
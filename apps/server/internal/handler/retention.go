@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// GetRetentionPolicies lists the per-agent and per-label metrics retention
+// overrides and the global settings they fall back to
+// GET /api/retention/policies
+func (h *Handler) GetRetentionPolicies(c *gin.Context) {
+	if !h.retentionEnabled(c) {
+		return
+	}
+	c.JSON(http.StatusOK, h.retentionPolicies.List())
+}
+
+// GetAgentRetention returns the retention settings that apply to an agent
+// GET /api/retention/agents/:id
+func (h *Handler) GetAgentRetention(c *gin.Context) {
+	if !h.retentionEnabled(c) {
+		return
+	}
+	agentID := c.Param("id")
+	c.JSON(http.StatusOK, gin.H{
+		"agentId":   agentID,
+		"retention": h.retentionPolicies.Resolve(agentID),
+	})
+}
+
+// SetAgentRetention overrides an agent's metrics retention settings
+// PUT /api/retention/agents/:id
+func (h *Handler) SetAgentRetention(c *gin.Context) {
+	if !h.retentionEnabled(c) {
+		return
+	}
+	var req service.RetentionOverride
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	agentID := c.Param("id")
+	if !h.retentionResult(c, h.retentionPolicies.SetAgentPolicy(agentID, req)) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"agentId":   agentID,
+		"retention": h.retentionPolicies.Resolve(agentID),
+	})
+}
+
+// DeleteAgentRetention removes an agent's retention override
+// DELETE /api/retention/agents/:id
+func (h *Handler) DeleteAgentRetention(c *gin.Context) {
+	if !h.retentionEnabled(c) {
+		return
+	}
+	if !h.retentionResult(c, h.retentionPolicies.DeleteAgentPolicy(c.Param("id"))) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// SetLabelRetention overrides the metrics retention settings of agents
+// carrying a label, given as key=value
+// PUT /api/retention/labels/:label
+func (h *Handler) SetLabelRetention(c *gin.Context) {
+	if !h.retentionEnabled(c) {
+		return
+	}
+	var req service.RetentionOverride
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	label := c.Param("label")
+	if !h.retentionResult(c, h.retentionPolicies.SetLabelPolicy(label, req)) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"label": label, "override": req})
+}
+
+// DeleteLabelRetention removes the retention override for a label
+// DELETE /api/retention/labels/:label
+func (h *Handler) DeleteLabelRetention(c *gin.Context) {
+	if !h.retentionEnabled(c) {
+		return
+	}
+	if !h.retentionResult(c, h.retentionPolicies.DeleteLabelPolicy(c.Param("label"))) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// retentionEnabled writes a 503 response when retention policies are not set up
+func (h *Handler) retentionEnabled(c *gin.Context) bool {
+	if h.retentionPolicies == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "retention policies are not enabled"})
+		return false
+	}
+	return true
+}
+
+// retentionResult writes the error response for a failed policy change
+func (h *Handler) retentionResult(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, service.ErrInvalidRetentionPolicy):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrRetentionPolicyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		h.logger.Errorf("Failed to update retention policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update retention policy"})
+	}
+	return false
+}
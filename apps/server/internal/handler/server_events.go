@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetServerEvents returns recent server-wide events, oldest first, and the
+// components currently keeping the server in degraded mode
+// GET /api/server/events?limit=
+func (h *Handler) GetServerEvents(c *gin.Context) {
+	if h.serverEvents == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "server events are not enabled"})
+		return
+	}
+
+	limit := 100
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events":   h.serverEvents.Recent(limit),
+		"degraded": h.serverEvents.Degraded(),
+	})
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	grpcserver "github.com/chenqi92/NanoLink/apps/server/internal/grpc"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ShellCommandRunner runs shell commands on agents with streamed output
+type ShellCommandRunner interface {
+	StartShellCommand(ctx context.Context, agentID, command, superToken string, timeout time.Duration, actor service.AuditActor) (*grpcserver.ShellCommand, error)
+}
+
+// ShellStreamHandler runs allowlisted shell commands, streaming their output
+// over Server-Sent Events
+type ShellStreamHandler struct {
+	runner    ShellCommandRunner
+	logger    *zap.SugaredLogger
+	keepAlive time.Duration
+}
+
+// NewShellStreamHandler creates a shell stream handler
+func NewShellStreamHandler(runner ShellCommandRunner, logger *zap.SugaredLogger) *ShellStreamHandler {
+	return &ShellStreamHandler{runner: runner, logger: logger, keepAlive: streamKeepAlive}
+}
+
+// ShellCommandRequest is a shell command to run on an agent
+type ShellCommandRequest struct {
+	Command    string `json:"command" binding:"required"`
+	SuperToken string `json:"superToken"`
+	TimeoutSec int    `json:"timeoutSec" binding:"min=0"` // 0 = the command result timeout
+}
+
+// ShellStreamEvent is an SSE data frame of a streamed shell command: output
+// frames as the command runs, then one exit frame
+type ShellStreamEvent struct {
+	Type          string `json:"type"` // "output" or "exit"
+	CommandID     string `json:"commandId"`
+	Stream        string `json:"stream,omitempty"` // "stdout" or "stderr"
+	Data          string `json:"data,omitempty"`
+	ExitCode      *int32 `json:"exitCode,omitempty"` // Unset when the command did not run to completion
+	Success       bool   `json:"success,omitempty"`
+	Error         string `json:"error,omitempty"`
+	TimedOut      bool   `json:"timedOut,omitempty"`
+	DroppedOutput int    `json:"droppedOutput,omitempty"` // Output frames left out because the client fell behind
+}
+
+// RunShellCommand runs a shell command on an agent and streams its stdout
+// and stderr, ending with the exit code. The command must be allowed by the
+// agent's shell policy and is audited with its output.
+// POST /api/agents/:id/shell
+func (h *ShellStreamHandler) RunShellCommand(c *gin.Context) {
+	agentID := c.Param("id")
+	var req ShellCommandRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	actor := service.AuditActor{IPAddress: c.ClientIP()}
+	if user := GetCurrentUser(c); user != nil {
+		actor.UserID = user.ID
+		actor.Username = user.Username
+	}
+	timeout := time.Duration(req.TimeoutSec) * time.Second
+	shell, err := h.runner.StartShellCommand(c.Request.Context(), agentID, req.Command, req.SuperToken, timeout, actor)
+	if err != nil {
+		c.JSON(shellCommandErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	h.logger.Infof("User %s running shell command %s on agent %s", actor.Username, shell.ID, agentID)
+
+	w := c.Writer
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	writeEvent := func(event ShellStreamEvent) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := writeStreamFrame(w, 0, data); err != nil {
+			return err
+		}
+		w.Flush()
+		return nil
+	}
+
+	// The request context ending stops the wait for the result
+	ticker := time.NewTicker(h.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case out, ok := <-shell.Output:
+			if !ok {
+				result := <-shell.Result
+				event := ShellStreamEvent{
+					Type:          "exit",
+					CommandID:     shell.ID,
+					Success:       result.Success,
+					TimedOut:      result.TimedOut,
+					DroppedOutput: shell.Dropped(),
+				}
+				// Results the agent did not produce by running the command
+				// (timeouts, refusals, disconnects) carry no exit code
+				if result.Success || result.ExitCode != 0 {
+					event.ExitCode = &result.ExitCode
+				}
+				if !result.Success {
+					event.Error = result.Error
+				}
+				writeEvent(event)
+				return
+			}
+			event := ShellStreamEvent{Type: "output", CommandID: shell.ID, Stream: out.Stream, Data: out.Data}
+			if err := writeEvent(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			w.Flush()
+		}
+	}
+}
+
+// shellCommandErrorStatus maps a failure to start a shell command to a
+// status code
+func shellCommandErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, grpcserver.ErrShellCommandRequired),
+		errors.Is(err, service.ErrUnknownCommand), errors.Is(err, service.ErrInvalidCommand):
+		return http.StatusBadRequest
+	case errors.Is(err, grpcserver.ErrCommandNotPermitted):
+		return http.StatusForbidden
+	case errors.Is(err, grpcserver.ErrAgentNotConnected):
+		return http.StatusNotFound
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
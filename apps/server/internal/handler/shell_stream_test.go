@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	grpcserver "github.com/chenqi92/NanoLink/apps/server/internal/grpc"
+	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// fakeShellRunner plays back output and a result, or fails with err
+type fakeShellRunner struct {
+	err    error
+	output []grpcserver.ShellOutput
+	result *pb.CommandResult
+	actor  service.AuditActor
+}
+
+func (f *fakeShellRunner) StartShellCommand(ctx context.Context, agentID, command, superToken string, timeout time.Duration, actor service.AuditActor) (*grpcserver.ShellCommand, error) {
+	f.actor = actor
+	if f.err != nil {
+		return nil, f.err
+	}
+	output := make(chan grpcserver.ShellOutput, len(f.output))
+	for _, out := range f.output {
+		output <- out
+	}
+	close(output)
+	result := make(chan *pb.CommandResult, 1)
+	result <- f.result
+	return &grpcserver.ShellCommand{ID: "cmd-1", AgentID: agentID, Command: command, Output: output, Result: result}, nil
+}
+
+func TestRunShellCommandStreamsOutputAndExitCode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	runner := &fakeShellRunner{
+		output: []grpcserver.ShellOutput{{Stream: "stdout", Data: "ok\n"}, {Stream: "stderr", Data: "warn\n"}},
+		result: &pb.CommandResult{CommandId: "cmd-1", Success: false, Error: "warn\n", ExitCode: 2},
+	}
+	router := gin.New()
+	router.POST("/agents/:id/shell", NewShellStreamHandler(runner, zap.NewNop().Sugar()).RunShellCommand)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/agents/agent-1/shell", strings.NewReader(`{"command":"check.sh","superToken":"s"}`))
+	router.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	want := []string{
+		`data: {"type":"output","commandId":"cmd-1","stream":"stdout","data":"ok\n"}`,
+		`data: {"type":"output","commandId":"cmd-1","stream":"stderr","data":"warn\n"}`,
+		`data: {"type":"exit","commandId":"cmd-1","exitCode":2,"error":"warn\n"}`,
+	}
+	if rec.Code != http.StatusOK || body != strings.Join(want, "\n\n")+"\n\n" {
+		t.Errorf("Unexpected stream (%d):\n%s", rec.Code, body)
+	}
+}
+
+func TestRunShellCommandRejections(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tc := range []struct {
+		body string
+		err  error
+		want int
+	}{
+		{`{}`, nil, http.StatusBadRequest},
+		{`{"command":"reboot"}`, fmt.Errorf("%w: not in the whitelist", grpcserver.ErrCommandNotPermitted), http.StatusForbidden},
+		{`{"command":"uptime"}`, fmt.Errorf("%w: agent-1", grpcserver.ErrAgentNotConnected), http.StatusNotFound},
+		{`{"command":"uptime"}`, fmt.Errorf("%w: bad", service.ErrInvalidCommand), http.StatusBadRequest},
+	} {
+		router := gin.New()
+		router.POST("/agents/:id/shell", NewShellStreamHandler(&fakeShellRunner{err: tc.err}, zap.NewNop().Sugar()).RunShellCommand)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/agents/agent-1/shell", strings.NewReader(tc.body)))
+		if rec.Code != tc.want {
+			t.Errorf("%s (%v): expected %d, got %d: %s", tc.body, tc.err, tc.want, rec.Code, rec.Body)
+		}
+	}
+}
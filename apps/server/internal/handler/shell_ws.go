@@ -21,8 +21,9 @@ type ShellHandler struct {
 		VerifyToken(tokenString string) (*service.JWTClaims, error)
 	}
 	grpcServer interface {
-		SendCommandToAgent(agentID string, cmd *pb.Command) error
+		SendCommandToAgentAs(agentID string, cmd *pb.Command, actor service.AuditActor) error
 	}
+	agents   *service.AgentService // Resolves hostnames once the user is authenticated
 	upgrader websocket.Upgrader
 	sessions sync.Map // agentID -> []*shellSession
 }
@@ -32,6 +33,7 @@ type shellSession struct {
 	agentID   string
 	userID    uint
 	username  string
+	ipAddress string
 	createdAt time.Time
 }
 
@@ -49,7 +51,7 @@ func NewShellHandler(
 		VerifyToken(tokenString string) (*service.JWTClaims, error)
 	},
 	grpcServer interface {
-		SendCommandToAgent(agentID string, cmd *pb.Command) error
+		SendCommandToAgentAs(agentID string, cmd *pb.Command, actor service.AuditActor) error
 	},
 ) *ShellHandler {
 	return &ShellHandler{
@@ -66,6 +68,13 @@ func NewShellHandler(
 	}
 }
 
+// SetAgentService lets sessions name the agent by hostname. The route
+// authenticates inside the handler, so hostnames are resolved here rather
+// than by ResolveAgentParam.
+func (h *ShellHandler) SetAgentService(agents *service.AgentService) {
+	h.agents = agents
+}
+
 // HandleShellWS handles WebSocket shell connections
 func (h *ShellHandler) HandleShellWS(c *gin.Context) {
 	agentID := c.Param("id")
@@ -83,6 +92,12 @@ func (h *ShellHandler) HandleShellWS(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 		return
 	}
+	if h.agents != nil {
+		var ok bool
+		if agentID, ok = resolveAgentID(c, h.agents, agentID); !ok {
+			return
+		}
+	}
 
 	// Upgrade to WebSocket
 	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
@@ -96,6 +111,7 @@ func (h *ShellHandler) HandleShellWS(c *gin.Context) {
 		agentID:   agentID,
 		userID:    claims.UserID,
 		username:  claims.Username,
+		ipAddress: c.ClientIP(),
 		createdAt: time.Now(),
 	}
 
@@ -142,7 +158,8 @@ func (h *ShellHandler) handleSession(session *shellSession) {
 				Target:    msg.Data, // Shell command to execute
 			}
 
-			if err := h.grpcServer.SendCommandToAgent(session.agentID, cmd); err != nil {
+			actor := service.AuditActor{UserID: session.userID, Username: session.username, IPAddress: session.ipAddress}
+			if err := h.grpcServer.SendCommandToAgentAs(session.agentID, cmd, actor); err != nil {
 				h.sendError(session.conn, "failed to send command: "+err.Error())
 				continue
 			}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -17,6 +18,7 @@ type UserHandler struct {
 	logger       *zap.SugaredLogger
 	authService  *service.AuthService
 	groupService *service.GroupService
+	permCache    *service.PermissionCache
 }
 
 // NewUserHandler creates a new user handler
@@ -34,6 +36,11 @@ func NewUserHandler(
 	}
 }
 
+// SetPermissionCache invalidates cached permissions of deleted users
+func (h *UserHandler) SetPermissionCache(cache *service.PermissionCache) {
+	h.permCache = cache
+}
+
 // UserDetailResponse is the detailed API response for a user (with groups)
 type UserDetailResponse struct {
 	ID           uint             `json:"id"`
@@ -53,7 +60,7 @@ type GroupBriefInfo struct {
 // CreateUserRequest is the request body for creating a user
 type CreateUserRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=50"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
 	Email    string `json:"email" binding:"omitempty,email"`
 	GroupIDs []uint `json:"groupIds,omitempty"`
 }
@@ -67,7 +74,7 @@ type UpdateUserRequest struct {
 // ChangePasswordRequest is the request body for changing password
 type ChangePasswordRequest struct {
 	CurrentPassword string `json:"currentPassword" binding:"required_without=ForceChange"`
-	NewPassword     string `json:"newPassword" binding:"required,min=6"`
+	NewPassword     string `json:"newPassword" binding:"required"`
 	ForceChange     bool   `json:"forceChange"` // SuperAdmin can force change
 }
 
@@ -128,14 +135,18 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 			c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
 			return
 		}
+		if writePasswordPolicyError(c, err) {
+			return
+		}
 		h.logger.Errorf("Failed to create user: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
 	// Add user to groups if specified
+	groups := h.groupService.WithActor(GetAuditActor(c))
 	for _, groupID := range req.GroupIDs {
-		if err := h.groupService.AddUserToGroup(user.ID, groupID); err != nil {
+		if err := groups.AddUserToGroup(user.ID, groupID); err != nil {
 			h.logger.Warnf("Failed to add user to group %d: %v", groupID, err)
 		}
 	}
@@ -185,12 +196,35 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 
 	// Update group memberships if specified
 	if req.GroupIDs != nil {
-		// Clear existing groups
-		h.db.Model(&user).Association("Groups").Clear()
+		groups := h.groupService.WithActor(GetAuditActor(c))
+		current, err := groups.GetUserGroups(user.ID)
+		if err != nil {
+			h.logger.Errorf("Failed to load user groups: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user groups"})
+			return
+		}
 
-		// Add new groups
+		// Membership changes go through the group service one by one so
+		// each is audited
+		wanted := make(map[uint]bool, len(req.GroupIDs))
+		for _, groupID := range req.GroupIDs {
+			wanted[groupID] = true
+		}
+		member := make(map[uint]bool, len(current))
+		for _, group := range current {
+			member[group.ID] = true
+			if wanted[group.ID] {
+				continue
+			}
+			if err := groups.RemoveUserFromGroup(user.ID, group.ID); err != nil {
+				h.logger.Warnf("Failed to remove user from group %d: %v", group.ID, err)
+			}
+		}
 		for _, groupID := range req.GroupIDs {
-			if err := h.groupService.AddUserToGroup(user.ID, groupID); err != nil {
+			if member[groupID] {
+				continue
+			}
+			if err := groups.AddUserToGroup(user.ID, groupID); err != nil {
 				h.logger.Warnf("Failed to add user to group %d: %v", groupID, err)
 			}
 		}
@@ -241,6 +275,7 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 		return
 	}
+	h.permCache.InvalidateUser(user.ID)
 
 	h.logger.Infof("User '%s' deleted", user.Username)
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
@@ -295,6 +330,13 @@ func (h *UserHandler) ChangePassword(c *gin.Context) {
 
 	// Change password
 	if err := h.authService.ChangePassword(uint(id), req.NewPassword); err != nil {
+		if writePasswordPolicyError(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrExternalAccount) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Password is managed by the identity provider"})
+			return
+		}
 		h.logger.Errorf("Failed to change password: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
 		return
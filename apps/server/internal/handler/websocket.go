@@ -2,12 +2,16 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/chenqi92/NanoLink/apps/server/internal/config"
 	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
 )
@@ -87,6 +91,7 @@ type WebSocketHandler struct {
 	agentService   *service.AgentService
 	metricsService *service.MetricsService
 	config         *config.Config
+	agentTokens    *service.AgentTokenService
 	logger         *zap.SugaredLogger
 }
 
@@ -100,6 +105,21 @@ func NewWebSocketHandler(as *service.AgentService, ms *service.MetricsService, c
 	}
 }
 
+// SetAgentTokens records the use of stored agent tokens
+func (h *WebSocketHandler) SetAgentTokens(tokens *service.AgentTokenService) {
+	h.agentTokens = tokens
+}
+
+// AgentWebSocketPath is where agents connect when the WebSocket shares the
+// HTTP port
+const AgentWebSocketPath = "/ws"
+
+// RegisterRoute mounts the handler on the HTTP router so agents can connect
+// on the same port as the API and dashboard
+func (h *WebSocketHandler) RegisterRoute(router gin.IRouter) {
+	router.GET(AgentWebSocketPath, gin.WrapH(h))
+}
+
 // ServeHTTP implements http.Handler
 func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Get token from Header first (preferred), then fallback to query
@@ -121,10 +141,11 @@ func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Validate token
-	valid, permission := h.config.ValidateToken(token)
-	if !valid {
-		h.logger.Warnf("Invalid token from %s", r.RemoteAddr)
+	// Validate token; its hostname and label restrictions are checked once
+	// the agent has described itself in the auth message
+	matched, err := h.config.ValidateAgentToken(token, config.TokenScope{})
+	if err != nil && !errors.Is(err, config.ErrTokenScope) {
+		h.logger.Warnf("Invalid token from %s: %v", r.RemoteAddr, err)
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -142,7 +163,7 @@ func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Handle the connection
-	h.handleConnection(conn, permission)
+	h.handleConnection(conn, matched)
 }
 
 // Message types
@@ -154,6 +175,7 @@ const (
 	MsgHeartbeat MessageType = "heartbeat"
 	MsgCommand   MessageType = "command"
 	MsgResult    MessageType = "result"
+	MsgGoodbye   MessageType = "goodbye"
 )
 
 // Message represents a WebSocket message
@@ -169,6 +191,11 @@ type AuthPayload struct {
 	service.AgentInfo
 }
 
+// GoodbyePayload is sent by an agent before a clean shutdown
+type GoodbyePayload struct {
+	Reason string `json:"reason"`
+}
+
 // MetricsPayload represents metrics data
 type MetricsPayload struct {
 	CPU      service.CPUData    `json:"cpu"`
@@ -179,7 +206,7 @@ type MetricsPayload struct {
 	NPUs     []service.NPUData  `json:"npus,omitempty"`
 }
 
-func (h *WebSocketHandler) handleConnection(conn *websocket.Conn, permission int) {
+func (h *WebSocketHandler) handleConnection(conn *websocket.Conn, token config.TokenConfig) {
 	defer conn.Close()
 
 	// Wait for auth message
@@ -214,9 +241,38 @@ func (h *WebSocketHandler) handleConnection(conn *websocket.Conn, permission int
 		return
 	}
 
+	if h.config.Auth.Enabled {
+		scope := config.TokenScope{Hostname: authPayload.Hostname, Labels: authPayload.Labels}
+		if err := token.CheckScope(scope); err != nil {
+			h.logger.Warnf("Token refused for %s: %v", authPayload.Hostname, err)
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token is not valid for this agent"))
+			return
+		}
+	}
+
+	// Refuse agents that were recently force-disconnected by an admin. The
+	// server assigns WebSocket agents their ID, so they are denied by host.
+	if h.agentService.IsDenied(service.VolatileIdentity(authPayload.Hostname)) {
+		h.logger.Warnf("Rejecting denied agent %s", authPayload.Hostname)
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "agent is temporarily denied"))
+		return
+	}
+
 	// Register agent
-	agent := h.agentService.RegisterAgent(conn, authPayload.AgentInfo, permission)
-	defer h.agentService.UnregisterAgent(agent.ID)
+	agent := h.agentService.RegisterAgent(conn, authPayload.AgentInfo, token.Permission)
+	defer h.agentService.UnregisterConnection(agent)
+	if token.ID != 0 {
+		h.agentService.SetAgentToken(agent.ID, token.ID)
+		if h.agentTokens != nil {
+			h.agentTokens.RecordUse(token.ID, authPayload.Hostname)
+		}
+	}
+
+	sourceIP := service.HostFromAddr(conn.RemoteAddr().String())
+	if h.config.Security.TrackSourceIP {
+		h.agentService.RecordSourceIP(agent.ID, sourceIP)
+	}
+	h.agentService.AutoGroup(agent.ID, sourceIP)
 
 	// Start writer goroutine
 	go h.writePump(agent, conn)
@@ -228,6 +284,11 @@ func (h *WebSocketHandler) handleConnection(conn *websocket.Conn, permission int
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				h.logger.Errorf("WebSocket error: %v", err)
 			}
+			kind := classifyWebSocketError(err)
+			if kind == service.StreamEndEOF {
+				err = nil
+			}
+			h.agentService.RecordStreamEnd(agent.ID, kind, err)
 			break
 		}
 
@@ -259,6 +320,7 @@ func (h *WebSocketHandler) handleMessage(agent *service.Agent, msg Message) {
 			NPUs:     payload.NPUs,
 		}
 		h.metricsService.StoreMetrics(agent.ID, metrics)
+		h.agentService.ReportFullMetrics(agent.ID)
 
 	case MsgHeartbeat:
 		h.agentService.UpdateHeartbeat(agent.ID)
@@ -267,6 +329,15 @@ func (h *WebSocketHandler) handleMessage(agent *service.Agent, msg Message) {
 		// Handle command result
 		h.logger.Infof("Received command result from agent %s", agent.ID)
 
+	case MsgGoodbye:
+		var payload GoodbyePayload
+		if len(msg.Payload) > 0 {
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+				h.logger.Warnf("Failed to parse goodbye: %v", err)
+			}
+		}
+		h.agentService.MarkGracefulDisconnect(agent.ID, payload.Reason)
+
 	default:
 		h.logger.Warnf("Unknown message type: %s", msg.Type)
 	}
@@ -297,3 +368,28 @@ func (h *WebSocketHandler) writePump(agent *service.Agent, conn *websocket.Conn)
 		}
 	}
 }
+
+// classifyWebSocketError maps the error that ended an agent's WebSocket to
+// one of the service.StreamEnd* kinds
+func classifyWebSocketError(err error) string {
+	var closeErr *websocket.CloseError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &closeErr):
+		switch closeErr.Code {
+		case websocket.CloseNormalClosure, websocket.CloseGoingAway:
+			return service.StreamEndEOF
+		case websocket.CloseAbnormalClosure:
+			return service.StreamEndTransport
+		}
+		return service.StreamEndOther
+	case errors.Is(err, net.ErrClosed):
+		// The server closed the connection, e.g. a forced disconnect
+		return service.StreamEndServerClosed
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return service.StreamEndDeadline
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &netErr):
+		return service.StreamEndTransport
+	}
+	return service.StreamEndOther
+}
@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+func TestAgentWebSocketOnSharedPort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(logger)
+	as := service.NewAgentService(logger, ms)
+	cfg := config.Default()
+	cfg.Server.SinglePort = true
+	cfg.Auth = config.AuthConfig{Enabled: true, Tokens: []config.TokenConfig{{Token: "agent-token", Permission: 1}}}
+
+	// The same router serves the API and the agent WebSocket
+	router := gin.New()
+	router.GET("/api/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+	NewWebSocketHandler(as, ms, cfg, logger).RegisterRoute(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/health")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the API on the shared port, got %v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + AgentWebSocketPath
+	if _, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer wrong"}}); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected an invalid token to be rejected, got %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer agent-token"}})
+	if err != nil {
+		t.Fatalf("Expected the agent to connect on the shared port, got %v", err)
+	}
+	defer conn.Close()
+
+	payload, _ := json.Marshal(AuthPayload{Token: "agent-token", AgentInfo: service.AgentInfo{Hostname: "web-1"}})
+	if err := conn.WriteJSON(Message{Type: MsgAuth, Payload: payload}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for as.GetAgentByHostname("web-1") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the agent to be registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAgentWebSocketChecksTokenScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(logger)
+	as := service.NewAgentService(logger, ms)
+	cfg := config.Default()
+	cfg.Auth = config.AuthConfig{Enabled: true, Tokens: []config.TokenConfig{
+		{Token: "web-token", Permission: 1, Hostnames: []string{"web-*"}},
+	}}
+	server := httptest.NewServer(NewWebSocketHandler(as, ms, cfg, logger))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	connect := func(hostname string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer web-token"}})
+		if err != nil {
+			t.Fatalf("Expected a scoped token to connect, got %v", err)
+		}
+		payload, _ := json.Marshal(AuthPayload{Token: "web-token", AgentInfo: service.AgentInfo{Hostname: hostname}})
+		if err := conn.WriteJSON(Message{Type: MsgAuth, Payload: payload}); err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	refused := connect("db-1")
+	defer refused.Close()
+	refused.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := refused.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("Expected an agent outside the token's hostnames to be closed, got %v", err)
+	}
+
+	allowed := connect("web-1")
+	defer allowed.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for as.GetAgentByHostname("web-1") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected an agent within the token's hostnames to be registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if as.GetAgentByHostname("db-1") != nil {
+		t.Error("Expected the refused agent not to be registered")
+	}
+}
+
+func TestAgentWebSocketRefusesDeniedAgents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(logger)
+	as := service.NewAgentService(logger, ms)
+	cfg := config.Default()
+	cfg.Auth = config.AuthConfig{Enabled: true, Tokens: []config.TokenConfig{{Token: "agent-token", Permission: 1}}}
+	server := httptest.NewServer(NewWebSocketHandler(as, ms, cfg, logger))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	connect := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer agent-token"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, _ := json.Marshal(AuthPayload{Token: "agent-token", AgentInfo: service.AgentInfo{Hostname: "web-1"}})
+		if err := conn.WriteJSON(Message{Type: MsgAuth, Payload: payload}); err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	first := connect()
+	defer first.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	var agent *service.Agent
+	for agent == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the agent to be registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+		agent = as.GetAgentByHostname("web-1")
+	}
+	if _, err := as.ForceDisconnect(agent.ID, "compromised", 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reconnect gets a new ID, but the host is still refused
+	second := connect()
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := second.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("Expected the denied agent to be closed, got %v", err)
+	}
+	if as.GetAgentByHostname("web-1") != nil {
+		t.Error("Expected the denied agent not to be registered")
+	}
+}
+
+func TestAgentWebSocketCountsReconnectsAndStreamEnds(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop().Sugar()
+	ms := service.NewMetricsService(logger)
+	as := service.NewAgentService(logger, ms)
+	cfg := config.Default()
+	cfg.Auth = config.AuthConfig{Enabled: true, Tokens: []config.TokenConfig{{Token: "agent-token", Permission: 1}}}
+	server := httptest.NewServer(NewWebSocketHandler(as, ms, cfg, logger))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// connect registers the agent and waits for it, returning its new ID
+	connect := func(previous string) (*websocket.Conn, string) {
+		conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer agent-token"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, _ := json.Marshal(AuthPayload{Token: "agent-token", AgentInfo: service.AgentInfo{Hostname: "web-1"}})
+		if err := conn.WriteJSON(Message{Type: MsgAuth, Payload: payload}); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			if agent := as.GetAgentByHostname("web-1"); agent != nil && agent.ID != previous {
+				return conn, agent.ID
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Expected the agent to be registered")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForEnds := func(want int) service.AgentStreamStats {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			stats, _ := as.StreamStats().Agent(service.VolatileIdentity("web-1"))
+			ends := 0
+			for _, n := range stats.StreamEnds {
+				ends += n
+			}
+			if ends >= want {
+				return stats
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d stream ends, got %+v", want, stats)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	first, firstID := connect("")
+	first.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	first.Close()
+	waitForEnds(1)
+
+	// The reconnect gets a new ID but counts against the same host
+	second, _ := connect(firstID)
+	second.Close() // dropped without a close frame
+	stats := waitForEnds(2)
+	if stats.Connects != 2 || stats.Reconnects != 1 {
+		t.Errorf("Expected 2 connects and 1 reconnect, got %+v", stats)
+	}
+	if stats.StreamEnds[service.StreamEndEOF] != 1 || stats.StreamEnds[service.StreamEndTransport] != 1 {
+		t.Errorf("Expected one clean close and one transport error, got %v", stats.StreamEnds)
+	}
+}
+
+func TestClassifyWebSocketError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&websocket.CloseError{Code: websocket.CloseNormalClosure}, service.StreamEndEOF},
+		{&websocket.CloseError{Code: websocket.CloseGoingAway}, service.StreamEndEOF},
+		{&websocket.CloseError{Code: websocket.CloseAbnormalClosure}, service.StreamEndTransport},
+		{&websocket.CloseError{Code: websocket.ClosePolicyViolation}, service.StreamEndOther},
+		{fmt.Errorf("read: %w", net.ErrClosed), service.StreamEndServerClosed},
+		{&net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, service.StreamEndDeadline},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, service.StreamEndTransport},
+		{io.ErrUnexpectedEOF, service.StreamEndTransport},
+		{errors.New("boom"), service.StreamEndOther},
+	}
+	for _, tt := range tests {
+		if got := classifyWebSocketError(tt.err); got != tt.want {
+			t.Errorf("%v: expected %s, got %s", tt.err, tt.want, got)
+		}
+	}
+}
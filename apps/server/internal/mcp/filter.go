@@ -0,0 +1,24 @@
+package mcp
+
+import "github.com/chenqi92/NanoLink/apps/server/internal/service"
+
+// WithToolFilter limits the tools the server exposes
+func WithToolFilter(allow, deny []string) Option {
+	return func(s *Server) {
+		s.toolFilter = service.NameFilter{Allow: allow, Deny: deny}
+	}
+}
+
+// WithResourceFilter limits the resources the server exposes, by URI
+func WithResourceFilter(allow, deny []string) Option {
+	return func(s *Server) {
+		s.resourceFilter = service.NameFilter{Allow: allow, Deny: deny}
+	}
+}
+
+// WithPromptFilter limits the prompts the server exposes
+func WithPromptFilter(allow, deny []string) Option {
+	return func(s *Server) {
+		s.promptFilter = service.NameFilter{Allow: allow, Deny: deny}
+	}
+}
@@ -3,9 +3,11 @@
 package mcp
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -19,6 +21,7 @@ type Server struct {
 	agentService   *service.AgentService
 	metricsService *service.MetricsService
 	auditService   *service.AuditService
+	hardware       *service.HardwareBaselineService
 	grpcServer     *grpcserver.Server
 	transport      Transport
 	logger         *zap.SugaredLogger
@@ -27,11 +30,70 @@ type Server struct {
 	resources map[string]*Resource
 	prompts   map[string]*Prompt
 
+	// Administrator-configured limits on what is exposed to clients
+	toolFilter     service.NameFilter
+	resourceFilter service.NameFilter
+	promptFilter   service.NameFilter
+
+	// protocolVersions are the MCP revisions offered to clients, newest first
+	protocolVersions []string
+
+	// toolSlots bounds the tool calls executing at once in this session
+	// (nil = unlimited); calls beyond it are queued or rejected
+	toolSlots   chan struct{}
+	queueTools  bool
+	toolTimeout time.Duration
+
 	mu       sync.RWMutex
 	started  bool
 	shutdown chan struct{}
 }
 
+// SupportedProtocolVersions lists the MCP protocol revisions the server speaks, newest first
+var SupportedProtocolVersions = []string{"2025-03-26", "2024-11-05"}
+
+// batchProtocolVersion is the first revision that allows JSON-RPC batches
+const batchProtocolVersion = "2025-03-26"
+
+// session holds the state of one client connection
+type session struct {
+	mu              sync.RWMutex
+	protocolVersion string // negotiated during initialize
+}
+
+type sessionKey struct{}
+
+// ProtocolVersion returns the MCP protocol revision negotiated with the
+// client whose request is being handled, or an empty string before the
+// session has been initialized
+func ProtocolVersion(ctx context.Context) string {
+	if sess, ok := ctx.Value(sessionKey{}).(*session); ok {
+		return sess.version()
+	}
+	return ""
+}
+
+func (sess *session) version() string {
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	return sess.protocolVersion
+}
+
+// Tool call limits used unless configured otherwise
+const (
+	DefaultMaxConcurrentTools = 4
+	DefaultToolTimeout        = 30 * time.Second
+)
+
+// What to do with tool calls beyond the concurrency limit
+const (
+	ToolOverflowReject = "reject"
+	ToolOverflowQueue  = "queue"
+)
+
+// protocolVersionLayout is the date format MCP protocol revisions use
+const protocolVersionLayout = "2006-01-02"
+
 // ServerInfo contains MCP server metadata
 type ServerInfo struct {
 	Name    string `json:"name"`
@@ -78,6 +140,13 @@ func WithAuditService(as *service.AuditService) Option {
 	}
 }
 
+// WithHardwareBaselines sets the hardware baseline service for the MCP server
+func WithHardwareBaselines(hb *service.HardwareBaselineService) Option {
+	return func(s *Server) {
+		s.hardware = hb
+	}
+}
+
 // WithGRPCServer sets the gRPC server for the MCP server
 func WithGRPCServer(gs *grpcserver.Server) Option {
 	return func(s *Server) {
@@ -85,6 +154,45 @@ func WithGRPCServer(gs *grpcserver.Server) Option {
 	}
 }
 
+// WithProtocolVersions restricts the MCP protocol revisions offered to
+// clients. Revisions the server does not implement are ignored.
+func WithProtocolVersions(versions ...string) Option {
+	return func(s *Server) {
+		var offered []string
+		for _, v := range versions {
+			for _, supported := range SupportedProtocolVersions {
+				if v == supported {
+					offered = append(offered, v)
+					break
+				}
+			}
+		}
+		s.protocolVersions = sortProtocolVersions(offered)
+	}
+}
+
+// WithToolConcurrency limits how many tool calls run at once. Calls beyond
+// the limit are rejected, or with ToolOverflowQueue wait for a free slot for
+// up to the tool timeout. A limit of 0 disables the check.
+func WithToolConcurrency(limit int, overflow string) Option {
+	return func(s *Server) {
+		s.toolSlots = nil
+		if limit > 0 {
+			s.toolSlots = make(chan struct{}, limit)
+		}
+		s.queueTools = overflow == ToolOverflowQueue
+	}
+}
+
+// WithToolTimeout sets how long a single tool call may run
+func WithToolTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		if timeout > 0 {
+			s.toolTimeout = timeout
+		}
+	}
+}
+
 // NewServer creates a new MCP server
 func NewServer(
 	agentService *service.AgentService,
@@ -100,6 +208,10 @@ func NewServer(
 		resources:      make(map[string]*Resource),
 		prompts:        make(map[string]*Prompt),
 		shutdown:       make(chan struct{}),
+
+		protocolVersions: sortProtocolVersions(SupportedProtocolVersions),
+		toolSlots:        make(chan struct{}, DefaultMaxConcurrentTools),
+		toolTimeout:      DefaultToolTimeout,
 	}
 
 	for _, opt := range opts {
@@ -114,26 +226,43 @@ func NewServer(
 	// Register optional tools based on available services
 	s.registerAuditTools()
 	s.registerDataRequestTools()
+	s.registerVersionTools()
+	s.registerHardwareTools()
 
 	return s
 }
 
-// RegisterTool registers a tool with the MCP server
+// RegisterTool registers a tool with the MCP server. Tools excluded by the
+// tool filter are skipped.
 func (s *Server) RegisterTool(tool *Tool) {
+	if !s.toolFilter.Allows(tool.Name) {
+		s.logger.Debugf("MCP tool %s disabled by configuration", tool.Name)
+		return
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.tools[tool.Name] = tool
 }
 
-// RegisterResource registers a resource with the MCP server
+// RegisterResource registers a resource with the MCP server. Resources
+// excluded by the resource filter are skipped.
 func (s *Server) RegisterResource(resource *Resource) {
+	if !s.resourceFilter.Allows(resource.URI) {
+		s.logger.Debugf("MCP resource %s disabled by configuration", resource.URI)
+		return
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.resources[resource.URI] = resource
 }
 
-// RegisterPrompt registers a prompt with the MCP server
+// RegisterPrompt registers a prompt with the MCP server. Prompts excluded
+// by the prompt filter are skipped.
 func (s *Server) RegisterPrompt(prompt *Prompt) {
+	if !s.promptFilter.Allows(prompt.Name) {
+		s.logger.Debugf("MCP prompt %s disabled by configuration", prompt.Name)
+		return
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.prompts[prompt.Name] = prompt
@@ -155,6 +284,9 @@ func (s *Server) Serve(ctx context.Context) error {
 
 	s.logger.Info("MCP server starting...")
 
+	sess := &session{}
+	ctx = context.WithValue(ctx, sessionKey{}, sess)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -187,29 +319,59 @@ func (s *Server) Serve(ctx context.Context) error {
 				s.logger.Debugf("Failed to read message: %v", err)
 				continue
 			case msg := <-msgChan:
-				response, err := s.handleMessage(ctx, msg)
-				if err != nil {
-					s.logger.Errorf("Failed to handle message: %v", err)
+				// Tool calls can run for a while, so they and batches that may
+				// contain them are handled in the background to keep ping and
+				// list requests responsive
+				if isBatch(msg) || isToolCall(msg) {
+					go func(msg []byte) {
+						s.respond(s.handleMessage(ctx, sess, msg))
+					}(msg)
 					continue
 				}
-
-				if response != nil {
-					if err := s.transport.WriteMessage(response); err != nil {
-						s.logger.Errorf("Failed to write response: %v", err)
-					}
-				}
+				s.respond(s.handleMessage(ctx, sess, msg))
 			}
 		}
 	}
 }
 
+// respond writes the response to a handled message, if any
+func (s *Server) respond(response []byte, err error) {
+	if err != nil {
+		s.logger.Errorf("Failed to handle message: %v", err)
+		return
+	}
+	if response != nil {
+		if err := s.transport.WriteMessage(response); err != nil {
+			s.logger.Errorf("Failed to write response: %v", err)
+		}
+	}
+}
+
+// isToolCall reports whether a raw message is a tools/call request
+func isToolCall(data []byte) bool {
+	var msg struct {
+		Method string `json:"method"`
+	}
+	return json.Unmarshal(data, &msg) == nil && msg.Method == "tools/call"
+}
+
+// isBatch reports whether a raw message is a JSON-RPC batch
+func isBatch(data []byte) bool {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
 // Stop stops the MCP server
 func (s *Server) Stop() {
 	close(s.shutdown)
 }
 
-// handleMessage processes an incoming JSON-RPC message
-func (s *Server) handleMessage(ctx context.Context, data []byte) ([]byte, error) {
+// handleMessage processes an incoming JSON-RPC message or batch from a client session
+func (s *Server) handleMessage(ctx context.Context, sess *session, data []byte) ([]byte, error) {
+	if isBatch(data) {
+		return s.handleBatch(ctx, sess, data)
+	}
+
 	var msg JSONRPCMessage
 	if err := json.Unmarshal(data, &msg); err != nil {
 		return s.errorResponse(nil, ParseError, "Parse error", nil)
@@ -223,7 +385,7 @@ func (s *Server) handleMessage(ctx context.Context, data []byte) ([]byte, error)
 
 	switch msg.Method {
 	case "initialize":
-		return s.handleInitialize(msg)
+		return s.handleInitialize(sess, msg)
 	case "initialized":
 		// Notification, no response needed
 		return nil, nil
@@ -246,10 +408,71 @@ func (s *Server) handleMessage(ctx context.Context, data []byte) ([]byte, error)
 	}
 }
 
+// handleBatch processes a JSON-RPC batch, which sessions may send once they
+// have negotiated a revision that allows them. Requests in a batch are
+// handled in order and their responses returned together; a batch of
+// notifications gets no response.
+func (s *Server) handleBatch(ctx context.Context, sess *session, data []byte) ([]byte, error) {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return s.errorResponse(nil, ParseError, "Parse error", nil)
+	}
+	if version := sess.version(); version < batchProtocolVersion {
+		return s.errorResponse(nil, InvalidRequest, fmt.Sprintf("Batches are not supported by protocol version %q", version), nil)
+	}
+	if len(batch) == 0 {
+		return s.errorResponse(nil, InvalidRequest, "Empty batch", nil)
+	}
+
+	var responses []json.RawMessage
+	for _, raw := range batch {
+		var response []byte
+		var err error
+		if isBatch(raw) {
+			response, err = s.errorResponse(nil, InvalidRequest, "Nested batch", nil)
+		} else {
+			response, err = s.handleMessage(ctx, sess, raw)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if response != nil {
+			responses = append(responses, response)
+		}
+	}
+	if len(responses) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(responses)
+}
+
 // handleInitialize handles the initialize request
-func (s *Server) handleInitialize(msg JSONRPCMessage) ([]byte, error) {
+func (s *Server) handleInitialize(sess *session, msg JSONRPCMessage) ([]byte, error) {
+	var params struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return s.errorResponse(msg.ID, InvalidParams, "Invalid parameters", nil)
+		}
+	}
+
+	version, ok := negotiateProtocolVersion(params.ProtocolVersion, s.protocolVersions)
+	supported := s.protocolVersions
+	if !ok {
+		s.logger.Warnf("MCP client requested unsupported protocol version %q", params.ProtocolVersion)
+		return s.errorResponse(msg.ID, InvalidParams, "Unsupported protocol version", map[string]interface{}{
+			"requested": params.ProtocolVersion,
+			"supported": supported,
+		})
+	}
+	sess.mu.Lock()
+	sess.protocolVersion = version
+	sess.mu.Unlock()
+	s.logger.Debugf("Negotiated MCP protocol version %s (client requested %q)", version, params.ProtocolVersion)
+
 	result := map[string]interface{}{
-		"protocolVersion": "2024-11-05",
+		"protocolVersion": version,
 		"serverInfo": ServerInfo{
 			Name:    "nanolink",
 			Version: "0.3.1",
@@ -270,6 +493,36 @@ func (s *Server) handleInitialize(msg JSONRPCMessage) ([]byte, error) {
 	return s.successResponse(msg.ID, result)
 }
 
+// negotiateProtocolVersion picks the newest supported revision that is not
+// newer than the one requested. Clients that omit the version get the oldest
+// revision. Malformed versions and versions older than all supported ones
+// cannot be negotiated.
+func negotiateProtocolVersion(requested string, supported []string) (string, bool) {
+	if len(supported) == 0 {
+		return "", false
+	}
+	if requested == "" {
+		return supported[len(supported)-1], true
+	}
+	if _, err := time.Parse(protocolVersionLayout, requested); err != nil {
+		return "", false
+	}
+	// Revisions are ISO dates, so string order is chronological
+	for _, v := range supported {
+		if v <= requested {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// sortProtocolVersions returns a newest-first copy of versions
+func sortProtocolVersions(versions []string) []string {
+	sorted := append([]string(nil), versions...)
+	sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+	return sorted
+}
+
 // handleToolsList returns the list of available tools
 func (s *Server) handleToolsList(msg JSONRPCMessage) ([]byte, error) {
 	s.mu.RLock()
@@ -307,8 +560,16 @@ func (s *Server) handleToolsCall(ctx context.Context, msg JSONRPCMessage) ([]byt
 		return s.errorResponse(msg.ID, InvalidParams, fmt.Sprintf("Unknown tool: %s", params.Name), nil)
 	}
 
-	// Execute tool with timeout (30 seconds)
-	toolCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	if !s.acquireToolSlot(ctx) {
+		s.logger.Warnf("Rejected MCP tool call %s: %d calls already running", params.Name, cap(s.toolSlots))
+		return s.errorResponse(msg.ID, ServerBusy, "Too many concurrent tool calls", map[string]interface{}{
+			"limit": cap(s.toolSlots),
+		})
+	}
+	defer s.releaseToolSlot()
+
+	// Execute tool with timeout
+	toolCtx, cancel := context.WithTimeout(ctx, s.toolTimeout)
 	defer cancel()
 
 	result, err := tool.Handler(toolCtx, params.Arguments)
@@ -331,6 +592,39 @@ func (s *Server) handleToolsCall(ctx context.Context, msg JSONRPCMessage) ([]byt
 	})
 }
 
+// acquireToolSlot reserves a tool execution slot. When all slots are taken
+// it fails immediately, or in queue mode waits up to the tool timeout.
+func (s *Server) acquireToolSlot(ctx context.Context) bool {
+	if s.toolSlots == nil {
+		return true
+	}
+	select {
+	case s.toolSlots <- struct{}{}:
+		return true
+	default:
+	}
+	if !s.queueTools {
+		return false
+	}
+
+	timer := time.NewTimer(s.toolTimeout)
+	defer timer.Stop()
+	select {
+	case s.toolSlots <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	case <-s.shutdown:
+	}
+	return false
+}
+
+func (s *Server) releaseToolSlot() {
+	if s.toolSlots != nil {
+		<-s.toolSlots
+	}
+}
+
 // handleResourcesList returns the list of available resources
 func (s *Server) handleResourcesList(msg JSONRPCMessage) ([]byte, error) {
 	s.mu.RLock()
@@ -3,7 +3,12 @@ package mcp
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
+
+	"go.uber.org/zap"
 )
 
 // mockAgentService implements a minimal AgentService for testing
@@ -255,6 +260,135 @@ func TestInitializeResponse(t *testing.T) {
 	}
 }
 
+func TestInitializeNegotiatesProtocolVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested string
+		want      string
+		wantErr   bool
+	}{
+		{name: "supported version is echoed", requested: "2025-03-26", want: "2025-03-26"},
+		{name: "oldest version is echoed", requested: "2024-11-05", want: "2024-11-05"},
+		{name: "unlisted version negotiates down", requested: "2025-01-15", want: "2024-11-05"},
+		{name: "newer client negotiates down", requested: "2099-01-01", want: SupportedProtocolVersions[0]},
+		{name: "missing version uses oldest", requested: "", want: "2024-11-05"},
+		{name: "too old version is rejected", requested: "2024-01-01", wantErr: true},
+		{name: "malformed version is rejected", requested: "v1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(nil, nil, zap.NewNop().Sugar())
+			sess := &session{}
+			params, _ := json.Marshal(map[string]string{"protocolVersion": tt.requested})
+			data, err := s.handleInitialize(sess, JSONRPCMessage{JSONRPC: "2.0", ID: 1, Method: "initialize", Params: params})
+			if err != nil {
+				t.Fatalf("handleInitialize failed: %v", err)
+			}
+
+			var resp struct {
+				Result struct {
+					ProtocolVersion string `json:"protocolVersion"`
+				} `json:"result"`
+				Error *JSONRPCError `json:"error"`
+			}
+			if err := json.Unmarshal(data, &resp); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+
+			if tt.wantErr {
+				if resp.Error == nil || resp.Error.Code != InvalidParams {
+					t.Fatalf("Expected InvalidParams error, got %s", data)
+				}
+				if sess.version() != "" {
+					t.Errorf("Expected no negotiated version, got %q", sess.version())
+				}
+				return
+			}
+			if resp.Error != nil {
+				t.Fatalf("Expected success, got error %+v", resp.Error)
+			}
+			if resp.Result.ProtocolVersion != tt.want {
+				t.Errorf("Expected version %s, got %s", tt.want, resp.Result.ProtocolVersion)
+			}
+			if sess.version() != tt.want {
+				t.Errorf("Expected session version %s, got %s", tt.want, sess.version())
+			}
+		})
+	}
+}
+
+func TestWithProtocolVersionsRestrictsNegotiation(t *testing.T) {
+	// Revisions the server does not implement are never offered
+	s := NewServer(nil, nil, zap.NewNop().Sugar(), WithProtocolVersions("2024-11-05", "2025-06-18"))
+	sess := &session{}
+	params := json.RawMessage(`{"protocolVersion":"2025-06-18"}`)
+	if _, err := s.handleInitialize(sess, JSONRPCMessage{JSONRPC: "2.0", ID: 1, Params: params}); err != nil {
+		t.Fatal(err)
+	}
+	if sess.version() != "2024-11-05" {
+		t.Errorf("Expected 2024-11-05, got %s", sess.version())
+	}
+}
+
+func TestNegotiatedVersionIsKeptPerSession(t *testing.T) {
+	s := NewServer(nil, nil, zap.NewNop().Sugar())
+	older, newer := &session{}, &session{}
+	for sess, version := range map[*session]string{older: "2024-11-05", newer: "2025-03-26"} {
+		params := json.RawMessage(`{"protocolVersion":"` + version + `"}`)
+		if _, err := s.handleInitialize(sess, JSONRPCMessage{JSONRPC: "2.0", ID: 1, Params: params}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if older.version() != "2024-11-05" || newer.version() != "2025-03-26" {
+		t.Errorf("Expected each session to keep its own version, got %q and %q", older.version(), newer.version())
+	}
+	ctx := context.WithValue(context.Background(), sessionKey{}, newer)
+	if got := ProtocolVersion(ctx); got != "2025-03-26" {
+		t.Errorf("Expected the request context to carry the session version, got %q", got)
+	}
+}
+
+func TestBatchesRequireNegotiatedSupport(t *testing.T) {
+	s := NewServer(nil, nil, zap.NewNop().Sugar())
+	batch := []byte(`[{"jsonrpc":"2.0","id":1,"method":"ping"},{"jsonrpc":"2.0","method":"initialized"},{"jsonrpc":"2.0","id":2,"method":"nope"}]`)
+
+	for _, version := range []string{"", "2024-11-05"} {
+		data, err := s.handleMessage(context.Background(), &session{protocolVersion: version}, batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp JSONRPCMessage
+		if err := json.Unmarshal(data, &resp); err != nil || resp.Error == nil || resp.Error.Code != InvalidRequest {
+			t.Errorf("%q: expected an InvalidRequest error, got %s", version, data)
+		}
+	}
+
+	sess := &session{protocolVersion: "2025-03-26"}
+	data, err := s.handleMessage(context.Background(), sess, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var responses []JSONRPCMessage
+	if err := json.Unmarshal(data, &responses); err != nil {
+		t.Fatalf("Expected a batch response, got %s", data)
+	}
+	if len(responses) != 2 || responses[0].Error != nil || responses[1].Error == nil || responses[1].Error.Code != MethodNotFound {
+		t.Errorf("Expected a ping result and a MethodNotFound error, got %s", data)
+	}
+
+	// Notifications alone get no response
+	if data, _ := s.handleMessage(context.Background(), sess, []byte(`[{"jsonrpc":"2.0","method":"initialized"}]`)); data != nil {
+		t.Errorf("Expected no response to a batch of notifications, got %s", data)
+	}
+	for _, invalid := range []string{`[]`, `[[{"jsonrpc":"2.0","id":1,"method":"ping"}]]`} {
+		data, _ := s.handleMessage(context.Background(), sess, []byte(invalid))
+		if !strings.Contains(string(data), `"code":-32600`) {
+			t.Errorf("%s: expected InvalidRequest, got %s", invalid, data)
+		}
+	}
+}
+
 func TestResourceURIParsing(t *testing.T) {
 	tests := []struct {
 		uri         string
@@ -336,3 +470,194 @@ func TestMessageHandlingFlow(t *testing.T) {
 		// OK
 	}
 }
+
+// newBlockingToolServer registers a tool that signals when it starts and
+// blocks until release is closed
+func newBlockingToolServer(opts ...Option) (*Server, chan struct{}, chan struct{}) {
+	s := NewServer(nil, nil, zap.NewNop().Sugar(), opts...)
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	s.RegisterTool(&Tool{
+		Name: "block",
+		Handler: func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+			started <- struct{}{}
+			<-release
+			return "done", nil
+		},
+	})
+	return s, started, release
+}
+
+func callTool(s *Server, id int) JSONRPCMessage {
+	req := fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"tools/call","params":{"name":"block"}}`, id)
+	data, _ := s.handleMessage(context.Background(), &session{}, []byte(req))
+	var resp JSONRPCMessage
+	json.Unmarshal(data, &resp)
+	return resp
+}
+
+func TestToolCallsBeyondLimitAreRejected(t *testing.T) {
+	s, started, release := newBlockingToolServer(WithToolConcurrency(1, ToolOverflowReject))
+
+	first := make(chan JSONRPCMessage, 1)
+	go func() { first <- callTool(s, 1) }()
+	<-started
+
+	resp := callTool(s, 2)
+	if resp.Error == nil || resp.Error.Code != ServerBusy {
+		t.Fatalf("Expected ServerBusy error, got %+v", resp)
+	}
+
+	// ping and tools/list are not throttled
+	for _, method := range []string{"ping", "tools/list"} {
+		data, _ := s.handleMessage(context.Background(), &session{}, []byte(`{"jsonrpc":"2.0","id":3,"method":"`+method+`"}`))
+		var r JSONRPCMessage
+		json.Unmarshal(data, &r)
+		if r.Error != nil {
+			t.Errorf("Expected %s to succeed while tools are busy, got %+v", method, r.Error)
+		}
+	}
+
+	close(release)
+	if r := <-first; r.Error != nil {
+		t.Errorf("Expected first call to succeed, got %+v", r.Error)
+	}
+
+	// The slot is free again
+	if r := callTool(s, 4); r.Error != nil {
+		t.Errorf("Expected call after release to succeed, got %+v", r.Error)
+	}
+}
+
+func TestToolCallsBeyondLimitAreQueued(t *testing.T) {
+	s, started, release := newBlockingToolServer(WithToolConcurrency(1, ToolOverflowQueue), WithToolTimeout(5*time.Second))
+
+	first := make(chan JSONRPCMessage, 1)
+	go func() { first <- callTool(s, 1) }()
+	<-started
+
+	second := make(chan JSONRPCMessage, 1)
+	go func() { second <- callTool(s, 2) }()
+
+	select {
+	case <-started:
+		t.Fatal("Expected second call to wait for a free slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	for _, ch := range []chan JSONRPCMessage{first, second} {
+		select {
+		case r := <-ch:
+			if r.Error != nil {
+				t.Errorf("Expected queued calls to succeed, got %+v", r.Error)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected queued call to complete")
+		}
+	}
+}
+
+func TestQueuedToolCallGivesUpAfterTimeout(t *testing.T) {
+	s, started, release := newBlockingToolServer(WithToolConcurrency(1, ToolOverflowQueue), WithToolTimeout(50*time.Millisecond))
+	defer close(release)
+
+	go callTool(s, 1)
+	<-started
+
+	resp := callTool(s, 2)
+	if resp.Error == nil || resp.Error.Code != ServerBusy {
+		t.Errorf("Expected ServerBusy after waiting, got %+v", resp)
+	}
+}
+
+// request sends a JSON-RPC request through the server and decodes the response
+func request(t *testing.T, s *Server, method, params string) JSONRPCMessage {
+	t.Helper()
+	raw := `{"jsonrpc":"2.0","id":1,"method":"` + method + `"`
+	if params != "" {
+		raw += `,"params":` + params
+	}
+	data, err := s.handleMessage(context.Background(), &session{}, []byte(raw+"}"))
+	if err != nil {
+		t.Fatalf("%s failed: %v", method, err)
+	}
+	var resp JSONRPCMessage
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatalf("Invalid %s response: %v", method, err)
+	}
+	return resp
+}
+
+// listedNames extracts the given key from each entry of a list response
+func listedNames(resp JSONRPCMessage, list, key string) map[string]bool {
+	names := make(map[string]bool)
+	result, _ := resp.Result.(map[string]interface{})
+	entries, _ := result[list].([]interface{})
+	for _, e := range entries {
+		if m, ok := e.(map[string]interface{}); ok {
+			names[m[key].(string)] = true
+		}
+	}
+	return names
+}
+
+func TestDeniedToolIsHiddenAndUncallable(t *testing.T) {
+	s := NewServer(nil, nil, zap.NewNop().Sugar(), WithToolFilter(nil, []string{"request_*", "danger"}))
+	echo := func(ctx context.Context, args map[string]interface{}) (interface{}, error) { return "ok", nil }
+	s.RegisterTool(&Tool{Name: "danger", Handler: echo})
+	s.RegisterTool(&Tool{Name: "echo", Handler: echo})
+
+	names := listedNames(request(t, s, "tools/list", ""), "tools", "name")
+	if names["danger"] || names["request_agent_data"] {
+		t.Errorf("Expected denied tools to be absent from tools/list, got %v", names)
+	}
+	if !names["echo"] || !names["list_agents"] {
+		t.Errorf("Expected allowed tools to be listed, got %v", names)
+	}
+
+	resp := request(t, s, "tools/call", `{"name":"danger"}`)
+	if resp.Error == nil || resp.Error.Code != InvalidParams {
+		t.Errorf("Expected denied tool to be rejected as unknown, got %+v", resp)
+	}
+
+	resp = request(t, s, "tools/call", `{"name":"echo"}`)
+	if resp.Error != nil {
+		t.Errorf("Expected allowed tool to run, got %+v", resp.Error)
+	}
+}
+
+func TestToolAllowListExposesOnlyListedTools(t *testing.T) {
+	s := NewServer(nil, nil, zap.NewNop().Sugar(), WithToolFilter([]string{"list_agents", "get_*"}, []string{"get_audit_stats"}))
+
+	names := listedNames(request(t, s, "tools/list", ""), "tools", "name")
+	if !names["list_agents"] || !names["get_system_summary"] {
+		t.Errorf("Expected allowed tools to be listed, got %v", names)
+	}
+	if names["find_high_cpu_agents"] || names["get_audit_stats"] {
+		t.Errorf("Expected tools outside the allow list or denied to be hidden, got %v", names)
+	}
+}
+
+func TestResourceAndPromptFilters(t *testing.T) {
+	s := NewServer(nil, nil, zap.NewNop().Sugar(),
+		WithResourceFilter(nil, []string{"nanolink://metrics"}),
+		WithPromptFilter([]string{"cluster_health_report"}, nil),
+	)
+
+	resources := listedNames(request(t, s, "resources/list", ""), "resources", "uri")
+	if resources["nanolink://metrics"] || !resources["nanolink://agents"] {
+		t.Errorf("Expected metrics resource hidden and agents listed, got %v", resources)
+	}
+	if resp := request(t, s, "resources/read", `{"uri":"nanolink://metrics"}`); resp.Error == nil {
+		t.Error("Expected denied resource to be unreadable")
+	}
+
+	prompts := listedNames(request(t, s, "prompts/list", ""), "prompts", "name")
+	if len(prompts) != 1 || !prompts["cluster_health_report"] {
+		t.Errorf("Expected only cluster_health_report, got %v", prompts)
+	}
+	if resp := request(t, s, "prompts/get", `{"name":"diagnose_high_cpu"}`); resp.Error == nil {
+		t.Error("Expected prompt outside the allow list to be rejected")
+	}
+}
@@ -3,9 +3,12 @@ package mcp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"time"
 
+	grpcserver "github.com/chenqi92/NanoLink/apps/server/internal/grpc"
 	pb "github.com/chenqi92/NanoLink/apps/server/internal/proto"
 	"github.com/chenqi92/NanoLink/apps/server/internal/service"
 )
@@ -124,6 +127,28 @@ func (s *Server) registerDefaultTools() {
 		},
 		Handler: s.toolGetAgentProcesses,
 	})
+
+	// gpu_health - Score GPU and NPU health
+	s.RegisterTool(&Tool{
+		Name:        "gpu_health",
+		Description: "Score the health (0-100) of GPUs and NPUs from temperature against vendor-specific thresholds, power draw against the power limit, and utilization, to spot cards that are throttling or about to fail. Lowest scores first.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"agent_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The unique identifier or hostname of the agent (default: all agents)",
+				},
+				"max_score": map[string]interface{}{
+					"type":        "number",
+					"description": "Only return devices scoring at or below this (default: 100)",
+					"default":     100,
+				},
+			},
+			"required": []string{},
+		},
+		Handler: s.toolGPUHealth,
+	})
 }
 
 // Tool handlers
@@ -146,6 +171,7 @@ func (s *Server) toolListAgents(ctx context.Context, args map[string]interface{}
 			"os":           agent.OS,
 			"arch":         agent.Arch,
 			"version":      agent.Version,
+			"labels":       agent.Labels,
 			"connected_at": agent.ConnectedAt,
 		})
 	}
@@ -156,21 +182,28 @@ func (s *Server) toolListAgents(ctx context.Context, args map[string]interface{}
 	}, nil
 }
 
-func (s *Server) toolGetAgentMetrics(ctx context.Context, args map[string]interface{}) (interface{}, error) {
-	agentID, ok := args["agent_id"].(string)
-	if !ok || agentID == "" {
-		return nil, fmt.Errorf("agent_id is required")
+// agentArg returns the agent named by the agent_id argument, which may be an
+// agent ID or hostname. Names matching no connected agent are returned as
+// given so data kept for offline agents can still be looked up.
+func (s *Server) agentArg(args map[string]interface{}) (string, error) {
+	idOrHostname, ok := args["agent_id"].(string)
+	if !ok || idOrHostname == "" {
+		return "", fmt.Errorf("agent_id is required")
+	}
+	agentID, err := s.agentService.Resolve(idOrHostname)
+	if errors.Is(err, service.ErrAgentNotFound) {
+		return idOrHostname, nil
 	}
+	return agentID, err
+}
 
-	metrics := s.metricsService.GetCurrentMetrics(agentID)
-	if metrics == nil {
-		// Try to find by hostname
-		agent := s.agentService.GetAgentByHostname(agentID)
-		if agent != nil {
-			metrics = s.metricsService.GetCurrentMetrics(agent.ID)
-		}
+func (s *Server) toolGetAgentMetrics(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	agentID, err := s.agentArg(args)
+	if err != nil {
+		return nil, err
 	}
 
+	metrics := s.metricsService.GetCurrentMetrics(agentID)
 	if metrics == nil {
 		return nil, fmt.Errorf("agent not found or no metrics available: %s", agentID)
 	}
@@ -276,18 +309,124 @@ func (s *Server) toolFindLowDiskAgents(ctx context.Context, args map[string]inte
 	}, nil
 }
 
+func (s *Server) toolGPUHealth(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	maxScore := 100.0
+	if m, ok := args["max_score"].(float64); ok {
+		maxScore = m
+	}
+	if maxScore < 0 || maxScore > 100 {
+		return nil, fmt.Errorf("max_score must be between 0 and 100, got: %.1f", maxScore)
+	}
+
+	snapshots := make(map[string]*service.MetricsData)
+	if _, ok := args["agent_id"]; ok {
+		agentID, err := s.agentArg(args)
+		if err != nil {
+			return nil, err
+		}
+		metrics := s.metricsService.GetCurrentMetrics(agentID)
+		if metrics == nil {
+			return nil, fmt.Errorf("agent not found or no metrics available: %s", agentID)
+		}
+		snapshots[agentID] = metrics
+	} else {
+		for _, agent := range s.agentService.GetAllAgents() {
+			if metrics := s.metricsService.GetCurrentMetrics(agent.ID); metrics != nil {
+				snapshots[agent.ID] = metrics
+			}
+		}
+	}
+
+	type deviceHealth struct {
+		AgentID  string `json:"agent_id"`
+		Hostname string `json:"hostname"`
+		service.AcceleratorHealth
+	}
+	devices := make([]deviceHealth, 0)
+	total := 0
+	for agentID, metrics := range snapshots {
+		hostname := agentID
+		if agent := s.agentService.GetAgent(agentID); agent != nil {
+			hostname = agent.Hostname
+		}
+		for _, health := range s.metricsService.AcceleratorHealth(metrics) {
+			total++
+			if health.Score <= maxScore {
+				devices = append(devices, deviceHealth{agentID, hostname, health})
+			}
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Score != devices[j].Score {
+			return devices[i].Score < devices[j].Score
+		}
+		if devices[i].AgentID != devices[j].AgentID {
+			return devices[i].AgentID < devices[j].AgentID
+		}
+		return devices[i].Index < devices[j].Index
+	})
+
+	message := fmt.Sprintf("Scored %d GPU/NPU device(s)", total)
+	if maxScore < 100 {
+		message = fmt.Sprintf("Found %d of %d GPU/NPU device(s) scoring %.0f or below", len(devices), total, maxScore)
+	}
+	return map[string]interface{}{
+		"message":   message,
+		"max_score": maxScore,
+		"count":     len(devices),
+		"devices":   devices,
+	}, nil
+}
+
 func (s *Server) toolGetAgentProcesses(ctx context.Context, args map[string]interface{}) (interface{}, error) {
-	agentID, ok := args["agent_id"].(string)
-	if !ok || agentID == "" {
-		return nil, fmt.Errorf("agent_id is required")
+	agentID, err := s.agentArg(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.grpcServer == nil {
+		return nil, fmt.Errorf("gRPC server not available")
+	}
+
+	sortBy := service.ProcessSortCPU
+	if sb, ok := args["sort_by"].(string); ok && sb != "" {
+		if sb != service.ProcessSortCPU && sb != service.ProcessSortMemory {
+			return nil, fmt.Errorf("sort_by must be 'cpu' or 'memory', got: %s", sb)
+		}
+		sortBy = sb
+	}
+	limit := 10
+	if l, ok := args["limit"].(float64); ok && l > 0 {
+		limit = int(l)
+	}
+
+	snapshot, err := s.grpcServer.ListProcesses(ctx, agentID, service.AuditActor{Username: "mcp"})
+	if errors.Is(err, grpcserver.ErrProcessListDenied) {
+		return nil, fmt.Errorf("agent %s does not have the permission level to list processes: %v", agentID, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes for %s: %v", agentID, err)
+	}
+
+	top := service.TopProcesses(snapshot.Processes, sortBy, limit)
+	processes := make([]map[string]interface{}, 0, len(top))
+	for _, p := range top {
+		processes = append(processes, map[string]interface{}{
+			"pid":          p.PID,
+			"name":         p.Name,
+			"user":         p.User,
+			"cpu_percent":  p.CPUPercent,
+			"memory_bytes": p.MemoryBytes,
+		})
 	}
 
-	// Note: This would require sending a command to the agent
-	// For now, return a placeholder indicating this needs gRPC command execution
 	return map[string]interface{}{
-		"message":  "Process listing requires sending a command to the agent",
-		"agent_id": agentID,
-		"note":     "Use the execute_command tool with PROCESS_LIST command type",
+		"agent_id":    agentID,
+		"sort_by":     sortBy,
+		"total":       len(snapshot.Processes),
+		"count":       len(processes),
+		"captured_at": snapshot.CapturedAt,
+		"processes":   processes,
 	}, nil
 }
 
@@ -310,7 +449,7 @@ func (s *Server) registerAuditTools() {
 	// query_audit_logs - Query audit logs
 	s.RegisterTool(&Tool{
 		Name:        "query_audit_logs",
-		Description: "Query audit logs with optional filtering by user, agent, command type, or time range.",
+		Description: "Query audit logs with optional filtering by user, agent, command type, outcome, or time range, and sorting.",
 		InputSchema: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
@@ -322,11 +461,40 @@ func (s *Server) registerAuditTools() {
 					"type":        "string",
 					"description": "Filter by command type (optional)",
 				},
+				"username": map[string]interface{}{
+					"type":        "string",
+					"description": "Filter by the user who sent the command (optional)",
+				},
+				"success": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Only successful (true) or failed (false) commands (optional)",
+				},
+				"start_time": map[string]interface{}{
+					"type":        "string",
+					"description": "Only logs at or after this RFC 3339 time (optional)",
+				},
+				"end_time": map[string]interface{}{
+					"type":        "string",
+					"description": "Only logs at or before this RFC 3339 time (optional)",
+				},
+				"order_by": map[string]interface{}{
+					"type":        "string",
+					"description": "Field to sort by (default: newest first)",
+					"enum":        []string{"timestamp", "username", "agentId", "commandType", "success", "durationMs"},
+				},
+				"desc": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Sort order_by descending (default: false)",
+				},
 				"limit": map[string]interface{}{
 					"type":        "number",
 					"description": "Maximum number of logs to return (default: 50)",
 					"default":     50,
 				},
+				"offset": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of logs to skip, for paging (default: 0)",
+				},
 			},
 			"required": []string{},
 		},
@@ -381,6 +549,54 @@ func (s *Server) registerDataRequestTools() {
 	})
 }
 
+// registerVersionTools registers agent version tools (only if gRPC server is available)
+func (s *Server) registerVersionTools() {
+	if s.grpcServer == nil {
+		return
+	}
+
+	// agent_version_report - Agent versions across the fleet
+	s.RegisterTool(&Tool{
+		Name:        "agent_version_report",
+		Description: "Count connected agents by software version and list the agents running a version below the server's supported agent version, to target upgrades.",
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+			"required":   []string{},
+		},
+		Handler: s.toolAgentVersionReport,
+	})
+}
+
+// registerHardwareTools registers hardware inventory tools (only if hardware baselines are recorded)
+func (s *Server) registerHardwareTools() {
+	if s.hardware == nil {
+		return
+	}
+
+	// get_hardware_changes - Compare an agent's hardware with its baseline
+	s.RegisterTool(&Tool{
+		Name:        "get_hardware_changes",
+		Description: "Compare an agent's current hardware inventory with its baseline (the first one seen, or a pinned one) and list added, removed and changed components such as disk serials, memory size or GPUs.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"agent_id": map[string]interface{}{
+					"type":        "string",
+					"description": "The unique identifier or hostname of the agent",
+				},
+				"pin_baseline": map[string]interface{}{
+					"type":        "boolean",
+					"description": "After comparing, make the current inventory the new baseline (default: false)",
+					"default":     false,
+				},
+			},
+			"required": []string{"agent_id"},
+		},
+		Handler: s.toolGetHardwareChanges,
+	})
+}
+
 func (s *Server) toolQueryAuditLogs(ctx context.Context, args map[string]interface{}) (interface{}, error) {
 	if s.auditService == nil {
 		return nil, fmt.Errorf("audit service not available")
@@ -398,12 +614,32 @@ func (s *Server) toolQueryAuditLogs(ctx context.Context, args map[string]interfa
 		Limit: limit,
 	}
 
-	if agentID, ok := args["agent_id"].(string); ok && agentID != "" {
+	if name, ok := args["agent_id"].(string); ok && name != "" {
+		agentID, err := s.agentArg(args)
+		if err != nil {
+			return nil, err
+		}
 		query.AgentID = agentID
 	}
 	if cmdType, ok := args["command_type"].(string); ok && cmdType != "" {
 		query.CommandType = cmdType
 	}
+	query.Username, _ = args["username"].(string)
+	if success, ok := args["success"].(bool); ok {
+		query.Success = &success
+	}
+	var err error
+	if query.StartTime, err = timeArg(args, "start_time"); err != nil {
+		return nil, err
+	}
+	if query.EndTime, err = timeArg(args, "end_time"); err != nil {
+		return nil, err
+	}
+	query.OrderBy, _ = args["order_by"].(string)
+	query.Desc, _ = args["desc"].(bool)
+	if o, ok := args["offset"].(float64); ok && o > 0 {
+		query.Offset = int(o)
+	}
 
 	result, err := s.auditService.QueryLogs(query)
 	if err != nil {
@@ -427,11 +663,25 @@ func (s *Server) toolQueryAuditLogs(ctx context.Context, args map[string]interfa
 	return map[string]interface{}{
 		"total":   result.Total,
 		"limit":   result.Limit,
+		"offset":  result.Offset,
 		"hasMore": result.HasMore,
 		"logs":    logs,
 	}, nil
 }
 
+// timeArg parses an optional RFC 3339 time argument
+func timeArg(args map[string]interface{}, name string) (*time.Time, error) {
+	value, ok := args[name].(string)
+	if !ok || value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return &t, nil
+}
+
 func (s *Server) toolGetAuditStats(ctx context.Context, args map[string]interface{}) (interface{}, error) {
 	if s.auditService == nil {
 		return nil, fmt.Errorf("audit service not available")
@@ -464,9 +714,9 @@ func (s *Server) toolRequestAgentData(ctx context.Context, args map[string]inter
 		return nil, fmt.Errorf("gRPC server not available")
 	}
 
-	agentID, ok := args["agent_id"].(string)
-	if !ok || agentID == "" {
-		return nil, fmt.Errorf("agent_id is required")
+	agentID, err := s.agentArg(args)
+	if err != nil {
+		return nil, err
 	}
 
 	requestType, ok := args["request_type"].(string)
@@ -477,8 +727,7 @@ func (s *Server) toolRequestAgentData(ctx context.Context, args map[string]inter
 	// Map string to proto enum
 	reqType := s.mapRequestType(requestType)
 
-	err := s.grpcServer.RequestDataFromAgent(agentID, reqType, "")
-	if err != nil {
+	if err := s.grpcServer.RequestDataFromAgent(agentID, reqType, ""); err != nil {
 		return nil, fmt.Errorf("failed to send data request: %v", err)
 	}
 
@@ -490,6 +739,64 @@ func (s *Server) toolRequestAgentData(ctx context.Context, args map[string]inter
 	}, nil
 }
 
+func (s *Server) toolAgentVersionReport(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if s.grpcServer == nil {
+		return nil, fmt.Errorf("gRPC server not available")
+	}
+
+	report := s.grpcServer.AgentVersionReport(nil)
+	message := fmt.Sprintf("%d agent version(s) in use", len(report.Versions))
+	if report.SupportedVersion != "" {
+		message += fmt.Sprintf("; %d agent(s) below the supported version %s", len(report.Outdated), report.SupportedVersion)
+	}
+	return map[string]interface{}{
+		"message":           message,
+		"versions":          report.Versions,
+		"supported_version": report.SupportedVersion,
+		"outdated":          report.Outdated,
+	}, nil
+}
+
+func (s *Server) toolGetHardwareChanges(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if s.hardware == nil {
+		return nil, fmt.Errorf("hardware baselines not available")
+	}
+
+	agentID, err := s.agentArg(args)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := s.agentService.Identity(agentID)
+	report, err := s.hardware.Compare(identity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare hardware for %s: %v", agentID, err)
+	}
+
+	message := fmt.Sprintf("No hardware changes since the baseline of %s", report.Baseline.CapturedAt.Format("2006-01-02 15:04:05"))
+	if len(report.Changes) > 0 {
+		message = fmt.Sprintf("Found %d hardware change(s) since the baseline of %s",
+			len(report.Changes), report.Baseline.CapturedAt.Format("2006-01-02 15:04:05"))
+	}
+	result := map[string]interface{}{
+		"agent_id":             agentID,
+		"message":              message,
+		"baseline_captured_at": report.Baseline.CapturedAt,
+		"baseline_pinned":      report.Baseline.Pinned,
+		"count":                len(report.Changes),
+		"changes":              report.Changes,
+	}
+
+	if pin, _ := args["pin_baseline"].(bool); pin {
+		baseline, err := s.hardware.Pin(identity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pin hardware baseline for %s: %v", agentID, err)
+		}
+		result["pinned_at"] = baseline.CapturedAt
+	}
+	return result, nil
+}
+
 // mapRequestType maps string to proto DataRequestType
 func (s *Server) mapRequestType(reqType string) pb.DataRequestType {
 	switch reqType {
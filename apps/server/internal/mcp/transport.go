@@ -178,4 +178,7 @@ const (
 	MethodNotFound = -32601
 	InvalidParams  = -32602
 	InternalError  = -32603
+
+	// ServerBusy is returned when a session has too many tool calls running
+	ServerBusy = -32000
 )
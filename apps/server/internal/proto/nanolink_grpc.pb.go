@@ -364,11 +364,12 @@ var NanoLinkService_ServiceDesc = grpc.ServiceDesc{
 }
 
 const (
-	DashboardService_WatchAgents_FullMethodName     = "/nanolink.DashboardService/WatchAgents"
-	DashboardService_WatchMetrics_FullMethodName    = "/nanolink.DashboardService/WatchMetrics"
-	DashboardService_GetAgents_FullMethodName       = "/nanolink.DashboardService/GetAgents"
-	DashboardService_GetAgentMetrics_FullMethodName = "/nanolink.DashboardService/GetAgentMetrics"
-	DashboardService_SendCommand_FullMethodName     = "/nanolink.DashboardService/SendCommand"
+	DashboardService_WatchAgents_FullMethodName       = "/nanolink.DashboardService/WatchAgents"
+	DashboardService_WatchMetrics_FullMethodName      = "/nanolink.DashboardService/WatchMetrics"
+	DashboardService_GetAgents_FullMethodName         = "/nanolink.DashboardService/GetAgents"
+	DashboardService_GetAgentMetrics_FullMethodName   = "/nanolink.DashboardService/GetAgentMetrics"
+	DashboardService_SendCommand_FullMethodName       = "/nanolink.DashboardService/SendCommand"
+	DashboardService_WatchServerEvents_FullMethodName = "/nanolink.DashboardService/WatchServerEvents"
 )
 
 // DashboardServiceClient is the client API for DashboardService service.
@@ -387,6 +388,8 @@ type DashboardServiceClient interface {
 	GetAgentMetrics(ctx context.Context, in *GetAgentMetricsRequest, opts ...grpc.CallOption) (*Metrics, error)
 	// SendCommand sends command to agent through dashboard
 	SendCommand(ctx context.Context, in *DashboardCommandRequest, opts ...grpc.CallOption) (*CommandResult, error)
+	// WatchServerEvents streams server-wide events (admins only)
+	WatchServerEvents(ctx context.Context, in *WatchServerEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ServerEvent], error)
 }
 
 type dashboardServiceClient struct {
@@ -465,6 +468,25 @@ func (c *dashboardServiceClient) SendCommand(ctx context.Context, in *DashboardC
 	return out, nil
 }
 
+func (c *dashboardServiceClient) WatchServerEvents(ctx context.Context, in *WatchServerEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ServerEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &DashboardService_ServiceDesc.Streams[2], DashboardService_WatchServerEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchServerEventsRequest, ServerEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DashboardService_WatchServerEventsClient = grpc.ServerStreamingClient[ServerEvent]
+
 // DashboardServiceServer is the server API for DashboardService service.
 // All implementations must embed UnimplementedDashboardServiceServer
 // for forward compatibility.
@@ -481,6 +503,8 @@ type DashboardServiceServer interface {
 	GetAgentMetrics(context.Context, *GetAgentMetricsRequest) (*Metrics, error)
 	// SendCommand sends command to agent through dashboard
 	SendCommand(context.Context, *DashboardCommandRequest) (*CommandResult, error)
+	// WatchServerEvents streams server-wide events (admins only)
+	WatchServerEvents(*WatchServerEventsRequest, grpc.ServerStreamingServer[ServerEvent]) error
 	mustEmbedUnimplementedDashboardServiceServer()
 }
 
@@ -506,6 +530,9 @@ func (UnimplementedDashboardServiceServer) GetAgentMetrics(context.Context, *Get
 func (UnimplementedDashboardServiceServer) SendCommand(context.Context, *DashboardCommandRequest) (*CommandResult, error) {
 	return nil, status.Error(codes.Unimplemented, "method SendCommand not implemented")
 }
+func (UnimplementedDashboardServiceServer) WatchServerEvents(*WatchServerEventsRequest, grpc.ServerStreamingServer[ServerEvent]) error {
+	return status.Error(codes.Unimplemented, "method WatchServerEvents not implemented")
+}
 func (UnimplementedDashboardServiceServer) mustEmbedUnimplementedDashboardServiceServer() {}
 func (UnimplementedDashboardServiceServer) testEmbeddedByValue()                          {}
 
@@ -603,6 +630,17 @@ func _DashboardService_SendCommand_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DashboardService_WatchServerEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchServerEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DashboardServiceServer).WatchServerEvents(m, &grpc.GenericServerStream[WatchServerEventsRequest, ServerEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DashboardService_WatchServerEventsServer = grpc.ServerStreamingServer[ServerEvent]
+
 // DashboardService_ServiceDesc is the grpc.ServiceDesc for DashboardService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -634,6 +672,11 @@ var DashboardService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _DashboardService_WatchMetrics_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "WatchServerEvents",
+			Handler:       _DashboardService_WatchServerEvents_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "nanolink.proto",
 }
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"go.uber.org/zap"
+)
+
+// Accelerator device kinds
+const (
+	AcceleratorGPU = "gpu"
+	AcceleratorNPU = "npu"
+)
+
+// Accelerator fields that rules can compare
+const (
+	AcceleratorFieldUsage         = "usage"
+	AcceleratorFieldMemoryPercent = "memory_percent"
+	AcceleratorFieldTemperature   = "temperature"
+	AcceleratorFieldPower         = "power"
+)
+
+// ErrInvalidAlertRule is returned when an alert rule cannot be evaluated
+var ErrInvalidAlertRule = errors.New("invalid alert rule")
+
+// AcceleratorAlert is raised for a single GPU/NPU matching a rule
+type AcceleratorAlert struct {
+	Rule        string    `json:"rule"`
+	Severity    string    `json:"severity"`
+	AgentID     string    `json:"agentId"`
+	Device      string    `json:"device"`
+	DeviceIndex int       `json:"deviceIndex"`
+	DeviceName  string    `json:"deviceName"`
+	Field       string    `json:"field"`
+	Operator    string    `json:"operator"`
+	Value       float64   `json:"value"`
+	Threshold   float64   `json:"threshold"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// acceleratorDevice is the common view of a GPU or NPU used for evaluation
+type acceleratorDevice struct {
+	kind   string
+	index  int
+	name   string
+	fields map[string]float64
+}
+
+// AcceleratorAlerter evaluates per-device rules against GPU and NPU metrics.
+// An alert is raised when a device starts matching a rule and is not raised
+// again until the device stops matching.
+type AcceleratorAlerter struct {
+	rules   []config.AcceleratorAlertRule
+	active  map[string]AcceleratorAlert
+	onAlert func(AcceleratorAlert)
+	store   *AlertStore
+	mu      sync.Mutex
+	logger  *zap.SugaredLogger
+}
+
+// NewAcceleratorAlerter validates the rules and creates an alerter
+func NewAcceleratorAlerter(logger *zap.SugaredLogger, rules []config.AcceleratorAlertRule) (*AcceleratorAlerter, error) {
+	normalized := make([]config.AcceleratorAlertRule, 0, len(rules))
+	for i, rule := range rules {
+		rule, err := normalizeAcceleratorRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		normalized = append(normalized, rule)
+	}
+
+	return &AcceleratorAlerter{
+		rules:  normalized,
+		active: make(map[string]AcceleratorAlert),
+		logger: logger,
+	}, nil
+}
+
+func normalizeAcceleratorRule(rule config.AcceleratorAlertRule) (config.AcceleratorAlertRule, error) {
+	rule.Device = strings.ToLower(rule.Device)
+	if rule.Device != AcceleratorGPU && rule.Device != AcceleratorNPU {
+		return rule, fmt.Errorf("%w: unknown device %q", ErrInvalidAlertRule, rule.Device)
+	}
+
+	switch rule.Field {
+	case AcceleratorFieldUsage, AcceleratorFieldMemoryPercent, AcceleratorFieldTemperature, AcceleratorFieldPower:
+	default:
+		return rule, fmt.Errorf("%w: unknown field %q", ErrInvalidAlertRule, rule.Field)
+	}
+
+	switch rule.Operator {
+	case "":
+		rule.Operator = ">"
+	case ">", ">=", "<", "<=":
+	default:
+		return rule, fmt.Errorf("%w: unknown operator %q", ErrInvalidAlertRule, rule.Operator)
+	}
+
+	if rule.Severity == "" {
+		rule.Severity = "warning"
+	}
+	if rule.Name == "" {
+		rule.Name = fmt.Sprintf("%s %s %s %g", rule.Device, rule.Field, rule.Operator, rule.Threshold)
+	}
+	return rule, nil
+}
+
+// SetAlertHandler sets the callback invoked for newly raised alerts
+func (a *AcceleratorAlerter) SetAlertHandler(handler func(AcceleratorAlert)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onAlert = handler
+}
+
+// SetStore sets the store that keeps alert history, resolving alerts when
+// their device stops matching
+func (a *AcceleratorAlerter) SetStore(store *AlertStore) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.store = store
+}
+
+// Evaluate checks an agent's GPUs and NPUs against all rules and returns
+// the alerts that started firing with this sample
+func (a *AcceleratorAlerter) Evaluate(agentID string, data *MetricsData) []AcceleratorAlert {
+	if data == nil || len(a.rules) == 0 {
+		return nil
+	}
+
+	devices := acceleratorDevices(data)
+	now := data.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var raised []AcceleratorAlert
+	evaluated := make(map[string]bool)
+	for _, rule := range a.rules {
+		for _, dev := range devices {
+			if !ruleSelectsDevice(rule, dev) {
+				continue
+			}
+
+			key := fmt.Sprintf("%s|%s|%s|%d", agentID, rule.Name, dev.kind, dev.index)
+			evaluated[key] = true
+			value := dev.fields[rule.Field]
+
+			if !compareThreshold(value, rule.Operator, rule.Threshold) {
+				a.resolve(key)
+				continue
+			}
+			if _, firing := a.active[key]; firing {
+				continue
+			}
+
+			alert := AcceleratorAlert{
+				Rule:        rule.Name,
+				Severity:    rule.Severity,
+				AgentID:     agentID,
+				Device:      dev.kind,
+				DeviceIndex: dev.index,
+				DeviceName:  dev.name,
+				Field:       rule.Field,
+				Operator:    rule.Operator,
+				Value:       value,
+				Threshold:   rule.Threshold,
+				Timestamp:   now,
+			}
+			a.active[key] = alert
+			raised = append(raised, alert)
+			if a.store != nil {
+				a.store.Fire(key, AlertRecord{
+					Kind:     AlertKindAccelerator,
+					AgentID:  agentID,
+					Severity: rule.Severity,
+					Message: fmt.Sprintf("%s: %s%d %s=%.1f %s %.1f",
+						rule.Name, dev.kind, dev.index, rule.Field, value, rule.Operator, rule.Threshold),
+					Data:    alert,
+					FiredAt: now,
+				})
+			}
+			a.logger.Warnf("Alert %q on agent %s %s%d (%s): %s=%.1f %s %.1f",
+				rule.Name, agentID, dev.kind, dev.index, dev.name, rule.Field, value, rule.Operator, rule.Threshold)
+		}
+	}
+
+	// Samples carry every device, so one missing from this sample is gone
+	for key, alert := range a.active {
+		if alert.AgentID == agentID && !evaluated[key] {
+			a.resolve(key)
+		}
+	}
+	return raised
+}
+
+// Forget resolves an agent's firing alerts and drops its state, for when
+// the agent goes offline or is removed
+func (a *AcceleratorAlerter) Forget(agentID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for key, alert := range a.active {
+		if alert.AgentID == agentID {
+			a.resolve(key)
+		}
+	}
+}
+
+// resolve ends a firing alert. Must be called with a.mu held.
+func (a *AcceleratorAlerter) resolve(key string) {
+	if _, firing := a.active[key]; !firing {
+		return
+	}
+	delete(a.active, key)
+	if a.store != nil {
+		a.store.Resolve(key)
+	}
+}
+
+// dispatch delivers raised alerts to the handler
+func (a *AcceleratorAlerter) dispatch(alerts []AcceleratorAlert) {
+	a.mu.Lock()
+	handler := a.onAlert
+	a.mu.Unlock()
+
+	if handler == nil {
+		return
+	}
+	for _, alert := range alerts {
+		handler(alert)
+	}
+}
+
+// ActiveAlerts returns the alerts currently firing for an agent
+func (a *AcceleratorAlerter) ActiveAlerts(agentID string) []AcceleratorAlert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var result []AcceleratorAlert
+	for _, alert := range a.active {
+		if alert.AgentID == agentID {
+			result = append(result, alert)
+		}
+	}
+	return result
+}
+
+func acceleratorDevices(data *MetricsData) []acceleratorDevice {
+	devices := make([]acceleratorDevice, 0, len(data.GPUs)+len(data.NPUs))
+	for _, g := range data.GPUs {
+		devices = append(devices, acceleratorDevice{
+			kind:  AcceleratorGPU,
+			index: g.Index,
+			name:  g.Name,
+			fields: map[string]float64{
+				AcceleratorFieldUsage:         g.UsagePercent,
+				AcceleratorFieldMemoryPercent: percentOf(g.MemoryUsed, g.MemoryTotal),
+				AcceleratorFieldTemperature:   g.Temperature,
+				AcceleratorFieldPower:         float64(g.PowerWatts),
+			},
+		})
+	}
+	for _, n := range data.NPUs {
+		devices = append(devices, acceleratorDevice{
+			kind:  AcceleratorNPU,
+			index: n.Index,
+			name:  n.Name,
+			fields: map[string]float64{
+				AcceleratorFieldUsage:         n.UsagePercent,
+				AcceleratorFieldMemoryPercent: percentOf(n.MemoryUsed, n.MemoryTotal),
+				AcceleratorFieldTemperature:   n.Temperature,
+				AcceleratorFieldPower:         float64(n.PowerWatts),
+			},
+		})
+	}
+	return devices
+}
+
+func ruleSelectsDevice(rule config.AcceleratorAlertRule, dev acceleratorDevice) bool {
+	if rule.Device != dev.kind {
+		return false
+	}
+	if rule.Index != nil && *rule.Index != dev.index {
+		return false
+	}
+	if rule.DeviceName != "" && !strings.Contains(strings.ToLower(dev.name), strings.ToLower(rule.DeviceName)) {
+		return false
+	}
+	return true
+}
+
+func compareThreshold(value float64, operator string, threshold float64) bool {
+	switch operator {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	}
+	return false
+}
+
+func percentOf(used, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(used) / float64(total) * 100
+}
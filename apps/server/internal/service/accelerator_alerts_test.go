@@ -0,0 +1,182 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"go.uber.org/zap"
+)
+
+func TestGPUTemperatureRuleFiresPerDevice(t *testing.T) {
+	alerter, err := NewAcceleratorAlerter(zap.NewNop().Sugar(), []config.AcceleratorAlertRule{
+		{Name: "gpu-hot", Device: "gpu", Field: AcceleratorFieldTemperature, Threshold: 85, Severity: "critical"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := &MetricsData{GPUs: []GPUData{
+		{Index: 0, Name: "NVIDIA A100", Temperature: 70},
+		{Index: 1, Name: "NVIDIA A100", Temperature: 91},
+		{Index: 2, Name: "NVIDIA A100", Temperature: 85},
+	}}
+
+	alerts := alerter.Evaluate("agent-1", data)
+	if len(alerts) != 1 {
+		t.Fatalf("Expected 1 alert, got %d: %+v", len(alerts), alerts)
+	}
+	a := alerts[0]
+	if a.Device != AcceleratorGPU || a.DeviceIndex != 1 || a.DeviceName != "NVIDIA A100" {
+		t.Errorf("Expected alert for gpu 1, got %+v", a)
+	}
+	if a.Value != 91 || a.Severity != "critical" || a.Rule != "gpu-hot" {
+		t.Errorf("Unexpected alert details: %+v", a)
+	}
+
+	// Still hot: already firing, not raised again
+	if again := alerter.Evaluate("agent-1", data); len(again) != 0 {
+		t.Errorf("Expected no duplicate alert, got %+v", again)
+	}
+
+	// Cools down, then heats up again
+	data.GPUs[1].Temperature = 60
+	alerter.Evaluate("agent-1", data)
+	if active := alerter.ActiveAlerts("agent-1"); len(active) != 0 {
+		t.Errorf("Expected alert to resolve, got %+v", active)
+	}
+	data.GPUs[1].Temperature = 90
+	if again := alerter.Evaluate("agent-1", data); len(again) != 1 {
+		t.Errorf("Expected alert to fire again after resolving, got %+v", again)
+	}
+}
+
+func TestNPUUtilizationRule(t *testing.T) {
+	index := 0
+	alerter, err := NewAcceleratorAlerter(zap.NewNop().Sugar(), []config.AcceleratorAlertRule{
+		{Name: "npu0-busy", Device: "NPU", Index: &index, Field: AcceleratorFieldUsage, Operator: ">=", Threshold: 95},
+		{Name: "ascend-memory", Device: "npu", DeviceName: "ascend", Field: AcceleratorFieldMemoryPercent, Threshold: 80},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := &MetricsData{
+		GPUs: []GPUData{{Index: 0, Name: "GPU", UsagePercent: 99}},
+		NPUs: []NPUData{
+			{Index: 0, Name: "Ascend 910B", UsagePercent: 95, MemoryTotal: 100, MemoryUsed: 50},
+			{Index: 1, Name: "Ascend 910B", UsagePercent: 99, MemoryTotal: 100, MemoryUsed: 90},
+		},
+	}
+
+	alerts := alerter.Evaluate("agent-1", data)
+	if len(alerts) != 2 {
+		t.Fatalf("Expected 2 alerts, got %d: %+v", len(alerts), alerts)
+	}
+	for _, a := range alerts {
+		switch a.Rule {
+		case "npu0-busy":
+			if a.Device != AcceleratorNPU || a.DeviceIndex != 0 || a.Value != 95 {
+				t.Errorf("Unexpected utilization alert: %+v", a)
+			}
+		case "ascend-memory":
+			if a.DeviceIndex != 1 || a.Value != 90 {
+				t.Errorf("Unexpected memory alert: %+v", a)
+			}
+		default:
+			t.Errorf("Unexpected alert %+v", a)
+		}
+	}
+}
+
+func TestAcceleratorAlertsFromMetricsService(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	ms := NewMetricsService(logger)
+	alerter, err := NewAcceleratorAlerter(logger, []config.AcceleratorAlertRule{
+		{Device: "gpu", Field: AcceleratorFieldPower, Threshold: 300},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raised := make(chan AcceleratorAlert, 1)
+	alerter.SetAlertHandler(func(a AcceleratorAlert) { raised <- a })
+	ms.SetAcceleratorAlerter(alerter)
+
+	ms.StoreMetrics("agent-1", &MetricsData{GPUs: []GPUData{{Index: 0, Name: "GPU", PowerWatts: 350}}})
+
+	a := <-raised
+	if a.AgentID != "agent-1" || a.Field != AcceleratorFieldPower || a.Value != 350 {
+		t.Errorf("Unexpected alert: %+v", a)
+	}
+}
+
+func TestInvalidAcceleratorRules(t *testing.T) {
+	tests := []config.AcceleratorAlertRule{
+		{Device: "tpu", Field: AcceleratorFieldUsage},
+		{Device: "gpu", Field: "fan"},
+		{Device: "gpu", Field: AcceleratorFieldUsage, Operator: "=="},
+	}
+	for _, rule := range tests {
+		if _, err := NewAcceleratorAlerter(zap.NewNop().Sugar(), []config.AcceleratorAlertRule{rule}); !errors.Is(err, ErrInvalidAlertRule) {
+			t.Errorf("Expected ErrInvalidAlertRule for %+v, got %v", rule, err)
+		}
+	}
+}
+
+func TestAcceleratorAlertsResolveWhenAgentGoesOffline(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	alerter, err := NewAcceleratorAlerter(logger, []config.AcceleratorAlertRule{
+		{Name: "gpu-hot", Device: "gpu", Field: AcceleratorFieldTemperature, Threshold: 85},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewAlertStore(10, 0)
+	alerter.SetStore(store)
+	ms := NewMetricsService(logger)
+	ms.SetAcceleratorAlerter(alerter)
+	agents := NewAgentService(logger, ms)
+	agents.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "gpu-1"}, 0)
+
+	if alerts := alerter.Evaluate("agent-1", &MetricsData{GPUs: []GPUData{{Index: 0, Temperature: 95}}}); len(alerts) != 1 {
+		t.Fatalf("Expected the alert to fire, got %+v", alerts)
+	}
+	agents.UnregisterAgent("agent-1")
+
+	firing := true
+	if alerts := store.Query(AlertQuery{Firing: &firing}).Items; len(alerts) != 0 {
+		t.Errorf("Expected the alert to resolve once the agent is offline, got %+v", alerts)
+	}
+	alerter.mu.Lock()
+	defer alerter.mu.Unlock()
+	if len(alerter.active) != 0 {
+		t.Errorf("Expected the agent's alert state to be dropped, got %v", alerter.active)
+	}
+}
+
+func TestAcceleratorAlertResolvesWhenDeviceDisappears(t *testing.T) {
+	alerter, err := NewAcceleratorAlerter(zap.NewNop().Sugar(), []config.AcceleratorAlertRule{
+		{Name: "gpu-hot", Device: "gpu", Field: AcceleratorFieldTemperature, Threshold: 85},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewAlertStore(10, 0)
+	alerter.SetStore(store)
+
+	alerter.Evaluate("agent-1", &MetricsData{GPUs: []GPUData{{Index: 0, Temperature: 70}, {Index: 1, Temperature: 95}}})
+	alerter.Evaluate("agent-2", &MetricsData{GPUs: []GPUData{{Index: 1, Temperature: 95}}})
+	// GPU 1 falls off the bus
+	alerter.Evaluate("agent-1", &MetricsData{GPUs: []GPUData{{Index: 0, Temperature: 70}}})
+
+	if active := alerter.ActiveAlerts("agent-1"); len(active) != 0 {
+		t.Errorf("Expected the missing device's alert to resolve, got %+v", active)
+	}
+	if active := alerter.ActiveAlerts("agent-2"); len(active) != 1 {
+		t.Errorf("Expected other agents' alerts to keep firing, got %+v", active)
+	}
+	firing := true
+	if alerts := store.Query(AlertQuery{Firing: &firing}).Items; len(alerts) != 1 {
+		t.Errorf("Expected one firing alert in the store, got %+v", alerts)
+	}
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+)
+
+// ErrInvalidAcceleratorThresholds is returned for unusable health thresholds
+var ErrInvalidAcceleratorThresholds = errors.New("invalid accelerator health thresholds")
+
+// DefaultAcceleratorVendor is the threshold table entry for vendors without one
+const DefaultAcceleratorVendor = "default"
+
+// Accelerator health statuses
+const (
+	AcceleratorHealthy  = "healthy"
+	AcceleratorDegraded = "degraded"
+	AcceleratorCritical = "critical"
+)
+
+// DefaultAcceleratorThresholds returns the built-in temperature thresholds
+// per vendor, from the vendors' published slowdown and shutdown points
+func DefaultAcceleratorThresholds() map[string]config.AcceleratorThresholds {
+	return map[string]config.AcceleratorThresholds{
+		"nvidia":                 {WarnTemp: 83, CriticalTemp: 93},
+		"amd":                    {WarnTemp: 90, CriticalTemp: 105}, // junction temperature
+		"huawei":                 {WarnTemp: 80, CriticalTemp: 95},
+		"intel":                  {WarnTemp: 90, CriticalTemp: 100},
+		DefaultAcceleratorVendor: {WarnTemp: 85, CriticalTemp: 95},
+	}
+}
+
+// Weights of the health score's parts
+const (
+	healthWeightTemperature = 0.6
+	healthWeightPower       = 0.25
+	healthWeightUtilization = 0.15
+)
+
+// AcceleratorHealth is a 0-100 health score for one GPU or NPU, from its
+// temperature against its vendor's thresholds, power draw against its limit
+// and utilization. Parts a device does not report are left out.
+type AcceleratorHealth struct {
+	Device string  `json:"device"` // gpu or npu
+	Index  int     `json:"index"`
+	Name   string  `json:"name"`
+	Vendor string  `json:"vendor"`
+	Score  float64 `json:"score"`
+	Status string  `json:"status"` // healthy, degraded or critical
+
+	TemperatureScore *float64 `json:"temperatureScore,omitempty"`
+	PowerScore       *float64 `json:"powerScore,omitempty"`
+	UtilizationScore *float64 `json:"utilizationScore,omitempty"`
+	// Why the score is below 100
+	Issues []string `json:"issues,omitempty"`
+}
+
+// SetAcceleratorThresholds replaces built-in per-vendor temperature
+// thresholds used in health scores; vendors are matched case-insensitively
+func (s *MetricsService) SetAcceleratorThresholds(overrides map[string]config.AcceleratorThresholds) error {
+	thresholds := DefaultAcceleratorThresholds()
+	for vendor, t := range overrides {
+		if t.WarnTemp <= 0 || t.CriticalTemp <= t.WarnTemp {
+			return fmt.Errorf("%w: %s needs 0 < warn_temp < critical_temp", ErrInvalidAcceleratorThresholds, vendor)
+		}
+		thresholds[strings.ToLower(vendor)] = t
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acceleratorThresholds = thresholds
+	return nil
+}
+
+// thresholdsFor returns the thresholds for a vendor: an exact match, then
+// the first table entry contained in the vendor name, then the default
+func (s *MetricsService) thresholdsFor(vendor string) (string, config.AcceleratorThresholds) {
+	s.mu.RLock()
+	table := s.acceleratorThresholds
+	s.mu.RUnlock()
+	if table == nil {
+		table = DefaultAcceleratorThresholds()
+	}
+
+	vendor = strings.ToLower(strings.TrimSpace(vendor))
+	if t, ok := table[vendor]; ok && vendor != "" {
+		return vendor, t
+	}
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key != DefaultAcceleratorVendor && vendor != "" && strings.Contains(vendor, key) {
+			return key, table[key]
+		}
+	}
+	return DefaultAcceleratorVendor, table[DefaultAcceleratorVendor]
+}
+
+// GPUHealth scores a GPU's health
+func (s *MetricsService) GPUHealth(gpu GPUData) AcceleratorHealth {
+	h := AcceleratorHealth{Device: AcceleratorGPU, Index: gpu.Index, Name: gpu.Name, Vendor: gpu.Vendor}
+	s.scoreAccelerator(&h, gpu.Temperature, gpu.PowerWatts, gpu.PowerLimitWatts, gpu.UsagePercent)
+	return h
+}
+
+// NPUHealth scores an NPU's health. NPUs report no power limit, so power
+// draw is not scored.
+func (s *MetricsService) NPUHealth(npu NPUData) AcceleratorHealth {
+	h := AcceleratorHealth{Device: AcceleratorNPU, Index: npu.Index, Name: npu.Name, Vendor: npu.Vendor}
+	s.scoreAccelerator(&h, npu.Temperature, npu.PowerWatts, 0, npu.UsagePercent)
+	return h
+}
+
+// AcceleratorHealth scores each GPU and NPU in a metrics snapshot
+func (s *MetricsService) AcceleratorHealth(m *MetricsData) []AcceleratorHealth {
+	health := make([]AcceleratorHealth, 0, len(m.GPUs)+len(m.NPUs))
+	for _, gpu := range m.GPUs {
+		health = append(health, s.GPUHealth(gpu))
+	}
+	for _, npu := range m.NPUs {
+		health = append(health, s.NPUHealth(npu))
+	}
+	return health
+}
+
+func (s *MetricsService) scoreAccelerator(h *AcceleratorHealth, temperature float64, powerWatts, powerLimitWatts int, usage float64) {
+	var total, weights float64
+	add := func(part *float64, weight float64) {
+		total += *part * weight
+		weights += weight
+	}
+
+	if temperature > 0 {
+		vendor, t := s.thresholdsFor(h.Vendor)
+		score := falloff(temperature, t.WarnTemp, t.CriticalTemp)
+		h.TemperatureScore = &score
+		add(h.TemperatureScore, healthWeightTemperature)
+		if score < 100 {
+			h.Issues = append(h.Issues, fmt.Sprintf("temperature %.0f°C above the %s warning threshold of %.0f°C", temperature, vendor, t.WarnTemp))
+		}
+	}
+	if powerWatts > 0 && powerLimitWatts > 0 {
+		// Drawing at the limit means the device is being power capped
+		ratio := float64(powerWatts) / float64(powerLimitWatts)
+		score := falloff(ratio, 0.95, 1.10)
+		h.PowerScore = &score
+		add(h.PowerScore, healthWeightPower)
+		if score < 100 {
+			h.Issues = append(h.Issues, fmt.Sprintf("power draw %dW is %.0f%% of the %dW limit", powerWatts, ratio*100, powerLimitWatts))
+		}
+	}
+	if usage > 0 {
+		// A saturated device has no headroom, but is not failing
+		score := 50 + falloff(usage, 90, 100)/2
+		h.UtilizationScore = &score
+		add(h.UtilizationScore, healthWeightUtilization)
+		if score < 100 {
+			h.Issues = append(h.Issues, fmt.Sprintf("utilization %.0f%%", usage))
+		}
+	}
+
+	h.Score = 100
+	if weights > 0 {
+		h.Score = total / weights
+	}
+	switch {
+	case h.Score < 50 || (h.TemperatureScore != nil && *h.TemperatureScore == 0):
+		h.Status = AcceleratorCritical
+	case h.Score < 80:
+		h.Status = AcceleratorDegraded
+	default:
+		h.Status = AcceleratorHealthy
+	}
+}
+
+// falloff is 100 up to warn, 0 from critical and linear in between
+func falloff(value, warn, critical float64) float64 {
+	switch {
+	case value <= warn:
+		return 100
+	case value >= critical:
+		return 0
+	}
+	return 100 * (critical - value) / (critical - warn)
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"go.uber.org/zap"
+)
+
+func TestAcceleratorHealthScores(t *testing.T) {
+	s := NewMetricsService(zap.NewNop().Sugar())
+
+	// Busy but within limits: only saturation costs points
+	busy := s.GPUHealth(GPUData{Vendor: "NVIDIA", Temperature: 70, PowerWatts: 300, PowerLimitWatts: 400, UsagePercent: 100})
+	if busy.Status != AcceleratorHealthy || math.Abs(busy.Score-92.5) > 0.01 {
+		t.Errorf("Expected a healthy score of 92.5, got %+v", busy)
+	}
+
+	// The same temperature is critical for NVIDIA but only warm for AMD
+	hot := GPUData{Temperature: 93, PowerWatts: 200, PowerLimitWatts: 400, UsagePercent: 50}
+	hot.Vendor = "NVIDIA Corporation"
+	if h := s.GPUHealth(hot); h.Status != AcceleratorCritical || *h.TemperatureScore != 0 || len(h.Issues) != 1 {
+		t.Errorf("Expected nvidia at 93°C to be critical, got %+v", h)
+	}
+	hot.Vendor = "Advanced Micro Devices (AMD)"
+	if h := s.GPUHealth(hot); h.Status != AcceleratorHealthy || math.Abs(*h.TemperatureScore-80) > 0.01 {
+		t.Errorf("Expected amd at 93°C to be healthy, got %+v", h)
+	}
+
+	// Throttling at the power limit and warm
+	throttled := s.GPUHealth(GPUData{Vendor: "nvidia", Temperature: 88, PowerWatts: 420, PowerLimitWatts: 400, UsagePercent: 80})
+	if throttled.Status != AcceleratorDegraded || len(throttled.Issues) != 2 {
+		t.Errorf("Expected a degraded score with two issues, got %+v", throttled)
+	}
+
+	// NPUs have no power limit, and unknown vendors use the default thresholds
+	npu := s.NPUHealth(NPUData{Vendor: "Cambricon", Temperature: 90, PowerWatts: 300})
+	if npu.PowerScore != nil || npu.UtilizationScore != nil || npu.Score != 50 || npu.Status != AcceleratorDegraded {
+		t.Errorf("Expected only the temperature scored against the defaults, got %+v", npu)
+	}
+
+	// Nothing reported: nothing wrong
+	if h := s.GPUHealth(GPUData{}); h.Score != 100 || h.Status != AcceleratorHealthy {
+		t.Errorf("Expected an unreported device to score 100, got %+v", h)
+	}
+
+	all := s.AcceleratorHealth(&MetricsData{GPUs: []GPUData{{Index: 0}, {Index: 1}}, NPUs: []NPUData{{Index: 0}}})
+	if len(all) != 3 || all[2].Device != AcceleratorNPU {
+		t.Errorf("Expected two gpus then an npu, got %+v", all)
+	}
+}
+
+func TestAcceleratorThresholdOverrides(t *testing.T) {
+	s := NewMetricsService(zap.NewNop().Sugar())
+	if err := s.SetAcceleratorThresholds(map[string]config.AcceleratorThresholds{
+		"NVIDIA": {WarnTemp: 70, CriticalTemp: 80},
+		"biren":  {WarnTemp: 60, CriticalTemp: 70},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if h := s.GPUHealth(GPUData{Vendor: "nvidia", Temperature: 75}); h.Score != 50 {
+		t.Errorf("Expected the nvidia override to apply, got %+v", h)
+	}
+	if h := s.GPUHealth(GPUData{Vendor: "Biren Technology", Temperature: 65}); h.Score != 50 {
+		t.Errorf("Expected a new vendor's thresholds to apply, got %+v", h)
+	}
+	// Vendors not overridden keep the built-in thresholds
+	if h := s.GPUHealth(GPUData{Vendor: "amd", Temperature: 90}); h.Score != 100 {
+		t.Errorf("Expected the built-in amd thresholds, got %+v", h)
+	}
+
+	err := s.SetAcceleratorThresholds(map[string]config.AcceleratorThresholds{"amd": {WarnTemp: 90, CriticalTemp: 90}})
+	if !errors.Is(err, ErrInvalidAcceleratorThresholds) {
+		t.Errorf("Expected ErrInvalidAcceleratorThresholds, got %v", err)
+	}
+}
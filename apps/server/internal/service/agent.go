@@ -1,6 +1,9 @@
 package service
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,6 +22,28 @@ type Agent struct {
 	PermissionLevel int       `json:"permissionLevel"`
 	ConnectedAt     time.Time `json:"connectedAt"`
 	LastHeartbeat   time.Time `json:"lastHeartbeat"`
+	SourceIP        string    `json:"sourceIp,omitempty"`
+	Geo             *GeoInfo  `json:"geo,omitempty"`
+	InstanceID      string    `json:"instanceId,omitempty"`
+
+	// Labels such as env=prod, from the agent or assigned by the server
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Health as of the last health check: healthy, stale or dead
+	Status string `json:"status"`
+
+	collectors map[string]CollectorStatus
+
+	// Set when the agent announced a clean shutdown before disconnecting
+	graceful       bool
+	gracefulReason string
+
+	// Tears down the underlying transport on a forced disconnect
+	closer func()
+	// Stored agent token the agent authenticated with, 0 for config tokens
+	tokenID uint
+	// Set when the server assigned the ID for this connection only
+	volatileID bool
 
 	conn   *websocket.Conn
 	send   chan []byte
@@ -32,17 +57,63 @@ type AgentService struct {
 	mu             sync.RWMutex
 	logger         *zap.SugaredLogger
 	metricsService *MetricsService
+	ipTracker      *IPTracker
+	lifecycle      *LifecycleNotifier
+	autoGrouper    *AutoGrouper
+	heartbeats     *HeartbeatMonitor
+	hardware       *HardwareBaselineService
+	drift          *DriftService
+
+	// Server instance holding these connections, and where ownership is
+	// shared with other instances
+	instanceID string
+	directory  AgentDirectory
+
+	// Reconnect and stream error counters per agent
+	streamStats *StreamStats
+
+	offline      map[string]DisconnectEvent
+	onOffline    func(DisconnectEvent)
+	onDisconnect func(DisconnectEvent)
+
+	// Agent IDs refused until the given time after a forced disconnect
+	denylist map[string]time.Time
+	clock    Clock
+
+	// Heartbeat ages after which agents are stale and dead
+	staleAfter time.Duration
+	deadAfter  time.Duration
+	healthStop chan struct{}
+	healthOnce sync.Once
 }
 
 // NewAgentService creates a new agent service
 func NewAgentService(logger *zap.SugaredLogger, ms *MetricsService) *AgentService {
 	return &AgentService{
 		agents:         make(map[string]*Agent),
+		offline:        make(map[string]DisconnectEvent),
+		denylist:       make(map[string]time.Time),
+		clock:          RealClock,
+		staleAfter:     DefaultHeartbeatStaleAfter,
+		deadAfter:      DefaultHeartbeatDeadAfter,
+		healthStop:     make(chan struct{}),
 		logger:         logger,
 		metricsService: ms,
+		streamStats:    NewStreamStats(),
 	}
 }
 
+// StreamStats returns the tracker of agent reconnects and stream errors
+func (s *AgentService) StreamStats() *StreamStats {
+	return s.streamStats
+}
+
+// RecordStreamEnd counts how an agent's stream ended, under its identity so
+// agents that get a new ID per connection keep one set of counters
+func (s *AgentService) RecordStreamEnd(agentID, kind string, err error) {
+	s.streamStats.RecordStreamEnd(s.Identity(agentID), kind, err)
+}
+
 // RegisterAgent registers a new agent connection
 func (s *AgentService) RegisterAgent(conn *websocket.Conn, info AgentInfo, permission int) *Agent {
 	agent := &Agent{
@@ -51,18 +122,29 @@ func (s *AgentService) RegisterAgent(conn *websocket.Conn, info AgentInfo, permi
 		OS:              info.OS,
 		Arch:            info.Arch,
 		Version:         info.Version,
+		Labels:          info.Labels,
 		PermissionLevel: permission,
 		ConnectedAt:     time.Now(),
 		LastHeartbeat:   time.Now(),
+		Status:          AgentHealthy,
+		volatileID:      true,
 		conn:            conn,
 		send:            make(chan []byte, 256),
 	}
+	if conn != nil {
+		agent.closer = func() { conn.Close() }
+	}
+	s.claimAgent(agent)
+	s.streamStats.RecordConnect(identityOf(agent))
 
 	s.mu.Lock()
 	s.agents[agent.ID] = agent
 	s.mu.Unlock()
 
 	s.logger.Infof("Agent registered: %s (%s) - %s/%s", agent.Hostname, agent.ID, agent.OS, agent.Arch)
+	if s.lifecycle != nil {
+		s.lifecycle.AgentConnected(agent.ID, s.Identity(agent.ID), info)
+	}
 
 	return agent
 }
@@ -75,18 +157,27 @@ func (s *AgentService) RegisterGrpcAgent(agentID string, info AgentInfo, permiss
 		OS:              info.OS,
 		Arch:            info.Arch,
 		Version:         info.Version,
+		Labels:          info.Labels,
 		PermissionLevel: permission,
 		ConnectedAt:     time.Now(),
 		LastHeartbeat:   time.Now(),
+		Status:          AgentHealthy,
+		volatileID:      info.VolatileID,
 		conn:            nil, // gRPC agents don't have WebSocket connection
 		send:            nil, // gRPC agents don't use this channel
 	}
+	s.claimAgent(agent)
+	s.streamStats.RecordConnect(identityOf(agent))
 
 	s.mu.Lock()
 	s.agents[agentID] = agent
+	delete(s.offline, agentID)
 	s.mu.Unlock()
 
 	s.logger.Infof("gRPC Agent registered: %s (%s) - %s/%s", agent.Hostname, agentID, agent.OS, agent.Arch)
+	if s.lifecycle != nil {
+		s.lifecycle.AgentConnected(agentID, s.Identity(agentID), info)
+	}
 
 	return agent
 }
@@ -113,23 +204,268 @@ func (s *AgentService) UpdateAgent(agentID string, info AgentInfo) {
 	s.mu.Unlock()
 }
 
+// SetIPTracker sets the tracker used to detect source IP changes
+func (s *AgentService) SetIPTracker(tracker *IPTracker) {
+	s.ipTracker = tracker
+}
+
+// SetLifecycleNotifier sets the notifier told about agent discovery,
+// hardware changes and de-registration
+func (s *AgentService) SetLifecycleNotifier(notifier *LifecycleNotifier) {
+	s.lifecycle = notifier
+}
+
+// SetAutoGrouper sets the rules that place agents into groups on connect
+func (s *AgentService) SetAutoGrouper(grouper *AutoGrouper) {
+	s.autoGrouper = grouper
+}
+
+// SetHardwareBaselines sets the service recording agents' hardware baselines
+func (s *AgentService) SetHardwareBaselines(baselines *HardwareBaselineService) {
+	s.hardware = baselines
+}
+
+// SetDriftService sets the service keeping agents' configuration drift
+// baselines, so a baseline is recorded when an agent reports full metrics
+func (s *AgentService) SetDriftService(drift *DriftService) {
+	s.drift = drift
+}
+
+// SetHeartbeatMonitor sets the monitor measuring heartbeat latency and
+// clock skew
+func (s *AgentService) SetHeartbeatMonitor(monitor *HeartbeatMonitor) {
+	s.heartbeats = monitor
+}
+
+// HeartbeatStats returns an agent's latest heartbeat latency and clock skew
+func (s *AgentService) HeartbeatStats(agentID string) (HeartbeatStats, bool) {
+	if s.heartbeats == nil {
+		return HeartbeatStats{}, false
+	}
+	return s.heartbeats.Stats(agentID)
+}
+
+// AutoGroup places an agent into the groups whose rules match any of its
+// IPs, when auto-grouping is configured. Group assignments are keyed by
+// agent ID, so agents whose ID the server assigns per connection are left
+// out: every reconnect would leave an assignment behind.
+func (s *AgentService) AutoGroup(agentID string, ips ...string) {
+	if s.autoGrouper == nil || s.HasVolatileID(agentID) {
+		return
+	}
+	assigned, err := s.autoGrouper.Assign(agentID, ips...)
+	if err != nil {
+		s.logger.Warnf("Failed to auto-group agent %s: %v", agentID, err)
+	}
+	for _, group := range assigned {
+		s.logger.Infof("Agent %s auto-assigned to group '%s'", agentID, group)
+	}
+}
+
+// ReportStaticInfo passes an agent's static hardware info to the lifecycle
+// notifier and hardware baselines so hardware changes can be detected, and
+// auto-groups the agent by the interface addresses it reports
+func (s *AgentService) ReportStaticInfo(agentID string, update *StaticUpdate) {
+	if s.hardware != nil && update != nil {
+		if err := s.hardware.Observe(s.Identity(agentID), InventoryFromStatic(update)); err != nil {
+			s.logger.Warnf("Failed to record hardware baseline for agent %s: %v", agentID, err)
+		}
+	}
+	s.ReportFullMetrics(agentID)
+	if s.autoGrouper != nil && update != nil {
+		var ips []string
+		for _, n := range update.Networks {
+			ips = append(ips, n.IpAddresses...)
+		}
+		s.AutoGroup(agentID, ips...)
+	}
+	if s.lifecycle == nil {
+		return
+	}
+	s.mu.RLock()
+	agent, exists := s.agents[agentID]
+	var info AgentInfo
+	var identity string
+	if exists {
+		info = agent.info()
+		identity = identityOf(agent)
+	}
+	s.mu.RUnlock()
+	if !exists {
+		return
+	}
+	s.lifecycle.StaticInfoReported(agentID, identity, info, update)
+}
+
+// ReportFullMetrics records an agent's drift baseline from its current
+// metrics if it has none yet. Call it after storing full metrics.
+func (s *AgentService) ReportFullMetrics(agentID string) {
+	if s.drift == nil {
+		return
+	}
+	if err := s.drift.Observe(agentID, s.Identity(agentID)); err != nil {
+		s.logger.Warnf("Failed to record drift baseline for agent %s: %v", agentID, err)
+	}
+}
+
+// DeregisterAgent permanently removes an agent: it is disconnected if still
+// online and its metrics and offline record are dropped
+func (s *AgentService) DeregisterAgent(agentID string) error {
+	s.mu.RLock()
+	agent, online := s.agents[agentID]
+	offline, known := s.offline[agentID]
+	var info AgentInfo
+	identity := agentID
+	if online {
+		info = agent.info()
+		identity = identityOf(agent)
+	}
+	s.mu.RUnlock()
+
+	switch {
+	case online:
+		if _, err := s.ForceDisconnect(agentID, "deregistered", 0); err != nil {
+			return err
+		}
+	case known:
+		info = AgentInfo{Hostname: offline.Hostname}
+	case s.metricsService != nil && s.metricsService.GetCurrentMetrics(agentID) != nil:
+		// Only metrics are left, e.g. restored from persistence
+	default:
+		return ErrAgentNotFound
+	}
+
+	if s.metricsService != nil {
+		s.metricsService.RemoveAgent(agentID)
+	}
+	s.streamStats.Forget(identity)
+	if s.autoGrouper != nil {
+		s.autoGrouper.Forget(agentID)
+	}
+	s.mu.Lock()
+	delete(s.offline, agentID)
+	s.mu.Unlock()
+	if s.hardware != nil {
+		// Drops the drift baseline kept with it too
+		if err := s.hardware.Forget(identity); err != nil {
+			s.logger.Warnf("Failed to drop hardware baseline for agent %s: %v", agentID, err)
+		}
+	}
+
+	s.logger.Infof("Agent deregistered: %s (%s)", info.Hostname, agentID)
+	if s.lifecycle != nil {
+		s.lifecycle.AgentDeregistered(agentID, identity, info)
+	}
+	return nil
+}
+
+// RecordSourceIP stores the source IP an agent connected from, enriching it
+// and checking for unexpected changes when an IP tracker is configured
+func (s *AgentService) RecordSourceIP(agentID, ip string) {
+	s.mu.RLock()
+	agent, exists := s.agents[agentID]
+	var hostname, identity string
+	if exists {
+		hostname, identity = agent.Hostname, identityOf(agent)
+	}
+	s.mu.RUnlock()
+
+	if !exists || ip == "" {
+		return
+	}
+
+	var geo *GeoInfo
+	if s.ipTracker != nil {
+		geo = s.ipTracker.Track(identity, agentID, hostname, ip)
+	}
+
+	agent.mu.Lock()
+	agent.SourceIP = ip
+	agent.Geo = geo
+	agent.mu.Unlock()
+}
+
+// UpdateCollectorStatus merges per-collector health reported by an agent
+func (s *AgentService) UpdateCollectorStatus(agentID string, statuses []CollectorStatus) {
+	s.mu.RLock()
+	agent, exists := s.agents[agentID]
+	s.mu.RUnlock()
+
+	if !exists || len(statuses) == 0 {
+		return
+	}
+
+	now := time.Now()
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	if agent.collectors == nil {
+		agent.collectors = make(map[string]CollectorStatus)
+	}
+	for _, st := range statuses {
+		if st.Name == "" {
+			continue
+		}
+		prev, known := agent.collectors[st.Name]
+		if st.State == CollectorStateError && (!known || prev.State != CollectorStateError) {
+			s.logger.Warnf("Agent %s collector %s failed: %s", agent.Hostname, st.Name, st.Reason)
+		}
+		st.UpdatedAt = now
+		agent.collectors[st.Name] = st
+	}
+}
+
 // UnregisterAgent removes an agent
 func (s *AgentService) UnregisterAgent(agentID string) {
+	s.unregister(agentID, nil)
+}
+
+// UnregisterConnection unregisters agent when its connection ends. If it
+// was already unregistered, e.g. force-disconnected, a connection that has
+// since registered under the same ID is left alone.
+func (s *AgentService) UnregisterConnection(agent *Agent) {
+	if agent != nil {
+		s.unregister(agent.ID, agent)
+	}
+}
+
+// unregister removes the agent registered under agentID; when registration
+// is set, only if it is still that agent
+func (s *AgentService) unregister(agentID string, registration *Agent) {
 	s.mu.Lock()
 	agent, exists := s.agents[agentID]
+	if exists && registration != nil && agent != registration {
+		exists = false
+	}
 	if exists {
 		delete(s.agents, agentID)
 	}
+	now := s.clock.Now()
 	s.mu.Unlock()
 
 	if exists {
+		s.releaseAgent(agentID)
+		if s.heartbeats != nil {
+			// Skew is unknown while offline; it is measured again on reconnect
+			s.heartbeats.Forget(agentID)
+		}
+		if s.metricsService != nil {
+			s.metricsService.AgentOffline(agentID)
+		}
 		agent.mu.Lock()
 		agent.closed = true
 		if agent.send != nil {
 			close(agent.send)
 		}
+		event := DisconnectEvent{
+			AgentID:     agent.ID,
+			Hostname:    agent.Hostname,
+			Intentional: agent.graceful,
+			Reason:      agent.gracefulReason,
+			Timestamp:   now,
+		}
 		agent.mu.Unlock()
 		s.logger.Infof("Agent unregistered: %s (%s)", agent.Hostname, agent.ID)
+		s.recordDisconnect(event)
 	}
 }
 
@@ -140,6 +476,41 @@ func (s *AgentService) GetAgent(agentID string) *Agent {
 	return s.agents[agentID]
 }
 
+// HasVolatileID reports whether an agent is connected under an ID the
+// server assigned for this connection, which it loses on reconnect.
+// Settings keyed by such an ID would not follow the agent.
+func (s *AgentService) HasVolatileID(agentID string) bool {
+	agent := s.GetAgent(agentID)
+	return agent != nil && agent.volatileID
+}
+
+// Identity returns the key state about an agent is kept under across
+// reconnects: its ID when the agent keeps it, otherwise its hostname, as
+// the server assigns a new ID on every connection. Agents that are not
+// connected are assumed to keep their ID.
+func (s *AgentService) Identity(agentID string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if agent, ok := s.agents[agentID]; ok {
+		return identityOf(agent)
+	}
+	return agentID
+}
+
+// identityOf returns an agent's identity; must be called with s.mu held
+func identityOf(agent *Agent) string {
+	if agent.volatileID {
+		return VolatileIdentity(agent.Hostname)
+	}
+	return agent.ID
+}
+
+// VolatileIdentity is the identity of an agent on hostname whose ID the
+// server assigns per connection
+func VolatileIdentity(hostname string) string {
+	return "host:" + hostname
+}
+
 // GetAgentByHostname returns an agent by hostname
 func (s *AgentService) GetAgentByHostname(hostname string) *Agent {
 	s.mu.RLock()
@@ -153,6 +524,35 @@ func (s *AgentService) GetAgentByHostname(hostname string) *Agent {
 	return nil
 }
 
+// Resolve returns the ID of the connected agent with the given ID or, failing
+// that, hostname (case-insensitive). It returns ErrAgentNotFound when
+// neither matches and ErrAmbiguousHostname when several agents share the
+// hostname.
+func (s *AgentService) Resolve(idOrHostname string) (string, error) {
+	if idOrHostname == "" {
+		return "", ErrAgentNotFound
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.agents[idOrHostname]; ok {
+		return idOrHostname, nil
+	}
+	var matches []string
+	for id, agent := range s.agents {
+		if strings.EqualFold(agent.Hostname, idOrHostname) {
+			matches = append(matches, id)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", ErrAgentNotFound
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("%w: %s is used by %d agents", ErrAmbiguousHostname, idOrHostname, len(matches))
+}
+
 // GetAllAgents returns all connected agents
 func (s *AgentService) GetAllAgents() []*Agent {
 	s.mu.RLock()
@@ -165,6 +565,19 @@ func (s *AgentService) GetAllAgents() []*Agent {
 	return agents
 }
 
+// GetAllAgentInfo returns the registration info of every connected agent
+// by agent ID
+func (s *AgentService) GetAllAgentInfo() map[string]AgentInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	infos := make(map[string]AgentInfo, len(s.agents))
+	for id, agent := range s.agents {
+		infos[id] = agent.info()
+	}
+	return infos
+}
+
 // GetAgentCount returns the number of connected agents
 func (s *AgentService) GetAgentCount() int {
 	s.mu.RLock()
@@ -185,6 +598,19 @@ func (s *AgentService) UpdateHeartbeat(agentID string) {
 	}
 }
 
+// RecordHeartbeat updates an agent's last heartbeat time and feeds the
+// agent's send timestamp and measured round trip to the heartbeat monitor.
+// Heartbeats from unknown agents are ignored.
+func (s *AgentService) RecordHeartbeat(agentID string, agentTimestamp time.Time, rtt time.Duration) {
+	if s.GetAgent(agentID) == nil {
+		return
+	}
+	s.UpdateHeartbeat(agentID)
+	if s.heartbeats != nil {
+		s.heartbeats.Observe(agentID, agentTimestamp, rtt)
+	}
+}
+
 // SendToAgent sends a message to a specific agent
 func (s *AgentService) SendToAgent(agentID string, message []byte) error {
 	s.mu.RLock()
@@ -227,12 +653,40 @@ func (s *AgentService) BroadcastToAgents(message []byte) {
 	}
 }
 
+// Collector states reported by agents
+const (
+	CollectorStateOK       = "ok"
+	CollectorStateError    = "error"
+	CollectorStateDisabled = "disabled"
+)
+
+// CollectorStatus is the health of a single agent-side collector, used to
+// tell e.g. "no GPU present" apart from "GPU collector failed"
+type CollectorStatus struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Reason    string    `json:"reason,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// info returns the agent's registration info. The caller must hold the
+// service lock, since UpdateAgent writes these fields under it.
+func (a *Agent) info() AgentInfo {
+	return AgentInfo{Hostname: a.Hostname, OS: a.OS, Arch: a.Arch, Version: a.Version, Labels: a.Labels}
+}
+
 // AgentInfo holds agent registration information
 type AgentInfo struct {
 	Hostname string `json:"hostname"`
 	OS       string `json:"os"`
 	Arch     string `json:"arch"`
 	Version  string `json:"agentVersion"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Set when the server assigned the agent's ID, which then changes
+	// every time it reconnects
+	VolatileID bool `json:"-"`
 }
 
 // Errors
@@ -240,6 +694,8 @@ var (
 	ErrAgentNotFound     = &AgentError{"agent not found"}
 	ErrAgentDisconnected = &AgentError{"agent disconnected"}
 	ErrAgentBufferFull   = &AgentError{"agent send buffer full"}
+	ErrAgentDenied       = &AgentError{"agent is temporarily denied"}
+	ErrAmbiguousHostname = &AgentError{"hostname matches multiple agents"}
 )
 
 type AgentError struct {
@@ -250,6 +706,35 @@ func (e *AgentError) Error() string {
 	return e.msg
 }
 
+// CollectorStatus returns the last reported status of a collector.
+// ok is false if the agent never reported on that collector.
+func (a *Agent) CollectorStatus(name string) (status CollectorStatus, ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	status, ok = a.collectors[name]
+	return status, ok
+}
+
+// CollectorStatuses returns all reported collector statuses sorted by name
+func (a *Agent) CollectorStatuses() []CollectorStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	result := make([]CollectorStatus, 0, len(a.collectors))
+	for _, st := range a.collectors {
+		result = append(result, st)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// Source returns the IP the agent connected from and its enrichment
+func (a *Agent) Source() (string, *GeoInfo) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.SourceIP, a.Geo
+}
+
 // GetSendChannel returns the agent's send channel
 func (a *Agent) GetSendChannel() <-chan []byte {
 	return a.send
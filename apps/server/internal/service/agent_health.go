@@ -0,0 +1,120 @@
+package service
+
+import "time"
+
+// Agent health derived from heartbeat age
+const (
+	AgentHealthy = "healthy" // Heartbeats arriving on schedule
+	AgentStale   = "stale"   // Heartbeats late; losing contact
+	AgentDead    = "dead"    // No heartbeat within the dead threshold
+	AgentOffline = "offline" // Disconnected, reported for recently seen agents
+)
+
+// Default heartbeat age thresholds: two missed 30s heartbeats, then three
+const (
+	DefaultHeartbeatStaleAfter = 60 * time.Second
+	DefaultHeartbeatDeadAfter  = 90 * time.Second
+)
+
+// AgentStatusChange reports an agent whose health status changed
+type AgentStatusChange struct {
+	AgentID         string    `json:"agentId"`
+	Hostname        string    `json:"hostname"`
+	Status          string    `json:"status"`
+	PreviousStatus  string    `json:"previousStatus"`
+	HeartbeatAgeSec float64   `json:"heartbeatAgeSec"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// SetHealthThresholds sets the heartbeat ages after which an agent is
+// stale and dead. Zero or negative values keep the defaults.
+func (s *AgentService) SetHealthThresholds(staleAfter, deadAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if staleAfter > 0 {
+		s.staleAfter = staleAfter
+	}
+	if deadAfter > 0 {
+		s.deadAfter = deadAfter
+	}
+}
+
+// AgentStatus returns an agent's health status from its heartbeat age
+func (s *AgentService) AgentStatus(agent *Agent) string {
+	s.mu.RLock()
+	staleAfter, deadAfter, now := s.staleAfter, s.deadAfter, s.clock.Now()
+	s.mu.RUnlock()
+	return agent.healthStatus(now, staleAfter, deadAfter)
+}
+
+func (a *Agent) healthStatus(now time.Time, staleAfter, deadAfter time.Duration) string {
+	a.mu.Lock()
+	age := now.Sub(a.LastHeartbeat)
+	a.mu.Unlock()
+	switch {
+	case age >= deadAfter:
+		return AgentDead
+	case age >= staleAfter:
+		return AgentStale
+	default:
+		return AgentHealthy
+	}
+}
+
+// CheckHealth recomputes every agent's health status, storing it in the
+// agent's Status, and returns the agents whose status changed
+func (s *AgentService) CheckHealth() []AgentStatusChange {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := s.clock.Now()
+
+	var changes []AgentStatusChange
+	for id, agent := range s.agents {
+		status := agent.healthStatus(now, s.staleAfter, s.deadAfter)
+		agent.mu.Lock()
+		previous := agent.Status
+		agent.Status = status
+		age := now.Sub(agent.LastHeartbeat)
+		agent.mu.Unlock()
+		if status == previous {
+			continue
+		}
+		changes = append(changes, AgentStatusChange{
+			AgentID:         id,
+			Hostname:        agent.Hostname,
+			Status:          status,
+			PreviousStatus:  previous,
+			HeartbeatAgeSec: age.Seconds(),
+			Timestamp:       now,
+		})
+	}
+	return changes
+}
+
+// StartHealthChecks runs CheckHealth every interval, passing each status
+// change to onChange, until StopHealthChecks is called
+func (s *AgentService) StartHealthChecks(interval time.Duration, onChange func(AgentStatusChange)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, change := range s.CheckHealth() {
+					s.logger.Infof("Agent %s (%s) is %s, was %s (last heartbeat %.0fs ago)",
+						change.Hostname, change.AgentID, change.Status, change.PreviousStatus, change.HeartbeatAgeSec)
+					onChange(change)
+				}
+			case <-s.healthStop:
+				return
+			}
+		}
+	}()
+}
+
+// StopHealthChecks stops the periodic health checks
+func (s *AgentService) StopHealthChecks() {
+	s.healthOnce.Do(func() {
+		close(s.healthStop)
+	})
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestCheckHealthReportsTransitions(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	as.SetClock(clock)
+
+	agent := as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	agent.LastHeartbeat = clock.Now()
+	if changes := as.CheckHealth(); len(changes) != 0 {
+		t.Fatalf("Expected a new agent to stay healthy, got %v", changes)
+	}
+
+	clock.Advance(DefaultHeartbeatStaleAfter)
+	changes := as.CheckHealth()
+	if len(changes) != 1 || changes[0].Status != AgentStale || changes[0].PreviousStatus != AgentHealthy {
+		t.Fatalf("Expected the agent to go stale, got %v", changes)
+	}
+	if agent.Status != AgentStale || as.AgentStatus(agent) != AgentStale {
+		t.Errorf("Expected stored status stale, got %s", agent.Status)
+	}
+	if changes := as.CheckHealth(); len(changes) != 0 {
+		t.Errorf("Expected no repeat change, got %v", changes)
+	}
+
+	clock.Advance(DefaultHeartbeatDeadAfter - DefaultHeartbeatStaleAfter)
+	changes = as.CheckHealth()
+	if len(changes) != 1 || changes[0].Status != AgentDead || changes[0].HeartbeatAgeSec != 90 {
+		t.Fatalf("Expected the agent to be dead, got %v", changes)
+	}
+
+	agent.LastHeartbeat = clock.Now()
+	changes = as.CheckHealth()
+	if len(changes) != 1 || changes[0].Status != AgentHealthy || changes[0].PreviousStatus != AgentDead {
+		t.Errorf("Expected the agent to recover, got %v", changes)
+	}
+}
+
+func TestSetHealthThresholds(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	as.SetClock(clock)
+	as.SetHealthThresholds(10*time.Second, 0)
+
+	agent := as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	agent.LastHeartbeat = clock.Now()
+	clock.Advance(10 * time.Second)
+	if status := as.AgentStatus(agent); status != AgentStale {
+		t.Errorf("Expected stale after 10s, got %s", status)
+	}
+	clock.Advance(DefaultHeartbeatDeadAfter)
+	if status := as.AgentStatus(agent); status != AgentDead {
+		t.Errorf("Expected the default dead threshold to be kept, got %s", status)
+	}
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestCollectorErrorDistinctFromAbsentData(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	ms := NewMetricsService(logger)
+	as := NewAgentService(logger, ms)
+
+	// Both agents report no GPUs, but only one reports a broken collector
+	as.RegisterGrpcAgent("broken", AgentInfo{Hostname: "gpu-host"}, 0)
+	as.RegisterGrpcAgent("nogpu", AgentInfo{Hostname: "cpu-host"}, 0)
+	ms.StoreMetrics("broken", &MetricsData{})
+	ms.StoreMetrics("nogpu", &MetricsData{})
+
+	as.UpdateCollectorStatus("broken", []CollectorStatus{
+		{Name: "cpu", State: CollectorStateOK},
+		{Name: "gpu", State: CollectorStateError, Reason: "driver/library version mismatch"},
+	})
+	as.UpdateCollectorStatus("nogpu", []CollectorStatus{
+		{Name: "cpu", State: CollectorStateOK},
+		{Name: "gpu", State: CollectorStateOK},
+	})
+
+	if len(ms.GetCurrentMetrics("broken").GPUs) != 0 || len(ms.GetCurrentMetrics("nogpu").GPUs) != 0 {
+		t.Fatal("Expected both agents to have empty GPU lists")
+	}
+
+	st, ok := as.GetAgent("broken").CollectorStatus("gpu")
+	if !ok || st.State != CollectorStateError {
+		t.Fatalf("Expected gpu collector error, got %+v (reported=%v)", st, ok)
+	}
+	if st.Reason != "driver/library version mismatch" {
+		t.Errorf("Expected reason to be kept, got %q", st.Reason)
+	}
+
+	st, ok = as.GetAgent("nogpu").CollectorStatus("gpu")
+	if !ok || st.State != CollectorStateOK {
+		t.Errorf("Expected gpu collector ok, got %+v (reported=%v)", st, ok)
+	}
+
+	if _, ok := as.GetAgent("nogpu").CollectorStatus("npu"); ok {
+		t.Error("Expected unreported collector to be absent")
+	}
+}
+
+func TestCollectorStatusMerge(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+
+	as.UpdateCollectorStatus("agent-1", []CollectorStatus{
+		{Name: "gpu", State: CollectorStateError, Reason: "nvml not found"},
+		{Name: "disk", State: CollectorStateOK},
+	})
+	// A later periodic report only covers the GPU collector
+	as.UpdateCollectorStatus("agent-1", []CollectorStatus{
+		{Name: "gpu", State: CollectorStateOK},
+	})
+
+	statuses := as.GetAgent("agent-1").CollectorStatuses()
+	if len(statuses) != 2 {
+		t.Fatalf("Expected 2 collectors, got %d", len(statuses))
+	}
+	if statuses[0].Name != "disk" || statuses[1].Name != "gpu" {
+		t.Errorf("Expected statuses sorted by name, got %+v", statuses)
+	}
+	if statuses[1].State != CollectorStateOK || statuses[1].Reason != "" {
+		t.Errorf("Expected gpu collector to recover, got %+v", statuses[1])
+	}
+}
+
+func TestResolve(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "web-1"}, 0)
+	as.RegisterGrpcAgent("agent-2", AgentInfo{Hostname: "db-1"}, 0)
+	as.RegisterGrpcAgent("agent-3", AgentInfo{Hostname: "DB-1"}, 0)
+	// A hostname that is also another agent's ID resolves as the ID
+	as.RegisterGrpcAgent("agent-4", AgentInfo{Hostname: "agent-1"}, 0)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"agent-1", "agent-1"},
+		{"agent-2", "agent-2"},
+		{"web-1", "agent-1"},
+		{"WEB-1", "agent-1"},
+	}
+	for _, tt := range tests {
+		got, err := as.Resolve(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("Resolve(%q) = %q, %v; expected %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	if _, err := as.Resolve("missing"); !errors.Is(err, ErrAgentNotFound) {
+		t.Errorf("Expected ErrAgentNotFound for an unknown agent, got %v", err)
+	}
+	_, err := as.Resolve("db-1")
+	if !errors.Is(err, ErrAmbiguousHostname) {
+		t.Fatalf("Expected ErrAmbiguousHostname for a shared hostname, got %v", err)
+	}
+	if strings.Contains(err.Error(), "agent-2") || strings.Contains(err.Error(), "agent-3") {
+		t.Errorf("Expected the error not to name agents the caller may not see, got %q", err)
+	}
+}
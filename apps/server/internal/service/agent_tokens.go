@@ -0,0 +1,165 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// DefaultAgentTokenName names the token generated on first start
+const DefaultAgentTokenName = "default (generated)"
+
+// ErrAgentTokenNotFound is returned for unknown or already revoked tokens
+var ErrAgentTokenNotFound = errors.New("agent token not found")
+
+// AgentTokenService keeps agent tokens in the database, alongside the ones
+// in the config file
+type AgentTokenService struct {
+	db     *gorm.DB
+	logger *zap.SugaredLogger
+}
+
+// NewAgentTokenService creates a new agent token service
+func NewAgentTokenService(db *gorm.DB, logger *zap.SugaredLogger) *AgentTokenService {
+	return &AgentTokenService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// AgentTokenSpec describes a token to issue
+type AgentTokenSpec struct {
+	Name       string
+	Permission int
+	ExpiresAt  time.Time         // Zero never expires
+	Hostnames  []string          // Hostname patterns allowed to use the token; empty allows any
+	Labels     map[string]string // Labels agents must report to use the token
+}
+
+// Tokens returns the stored tokens that have not been revoked
+func (s *AgentTokenService) Tokens() ([]config.TokenConfig, error) {
+	var stored []database.AgentToken
+	if err := s.db.Where("revoked_at IS NULL").Order("id").Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	tokens := make([]config.TokenConfig, 0, len(stored))
+	for _, t := range stored {
+		tokens = append(tokens, AgentTokenConfig(t))
+	}
+	return tokens, nil
+}
+
+// List returns every stored token, revoked ones included
+func (s *AgentTokenService) List() ([]database.AgentToken, error) {
+	var stored []database.AgentToken
+	if err := s.db.Order("id").Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// AgentTokenConfig converts a stored token to the form tokens are
+// validated in
+func AgentTokenConfig(t database.AgentToken) config.TokenConfig {
+	tc := config.TokenConfig{ID: t.ID, Hash: t.TokenHash, Permission: t.Permission, Name: t.Name, ExpiresAt: t.ExpiresAt}
+	if t.Hostnames != "" {
+		json.Unmarshal([]byte(t.Hostnames), &tc.Hostnames)
+	}
+	if t.Labels != "" {
+		json.Unmarshal([]byte(t.Labels), &tc.Labels)
+	}
+	return tc
+}
+
+// Issue generates and stores a token, returning it with its stored form.
+// Only the hash is stored, so the token cannot be shown again.
+func (s *AgentTokenService) Issue(spec AgentTokenSpec) (string, config.TokenConfig, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", config.TokenConfig{}, fmt.Errorf("failed to generate agent token: %w", err)
+	}
+	token := hex.EncodeToString(secret)
+
+	record := database.AgentToken{
+		Name:       spec.Name,
+		TokenHash:  config.HashToken(token),
+		Hint:       token[:4] + "****" + token[len(token)-4:],
+		Permission: spec.Permission,
+	}
+	if !spec.ExpiresAt.IsZero() {
+		expiresAt := spec.ExpiresAt
+		record.ExpiresAt = &expiresAt
+	}
+	if len(spec.Hostnames) > 0 {
+		data, _ := json.Marshal(spec.Hostnames)
+		record.Hostnames = string(data)
+	}
+	if len(spec.Labels) > 0 {
+		data, _ := json.Marshal(spec.Labels)
+		record.Labels = string(data)
+	}
+	if err := s.db.Create(&record).Error; err != nil {
+		return "", config.TokenConfig{}, fmt.Errorf("failed to store agent token: %w", err)
+	}
+	return token, AgentTokenConfig(record), nil
+}
+
+// Revoke marks a stored token revoked; it is no longer loaded on start
+func (s *AgentTokenService) Revoke(id uint) error {
+	result := s.db.Model(&database.AgentToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %d", ErrAgentTokenNotFound, id)
+	}
+	return nil
+}
+
+// RecordUse counts an agent authenticating with a stored token
+func (s *AgentTokenService) RecordUse(id uint, hostname string) {
+	err := s.db.Model(&database.AgentToken{}).Where("id = ?", id).Updates(map[string]any{
+		"use_count":      gorm.Expr("use_count + 1"),
+		"last_used_at":   time.Now(),
+		"last_used_host": hostname,
+	}).Error
+	if err != nil {
+		s.logger.Warnf("Failed to record use of agent token %d: %v", id, err)
+	}
+}
+
+// Bootstrap adds the stored tokens to auth. When auth is enabled, token
+// generation is on and there are still no tokens, it generates and stores
+// a read-only agent token so agents can connect on first start, and
+// returns it. The token is logged only when generated.
+func (s *AgentTokenService) Bootstrap(auth *config.AuthConfig) (string, error) {
+	stored, err := s.Tokens()
+	if err != nil {
+		return "", fmt.Errorf("failed to load agent tokens: %w", err)
+	}
+	auth.Tokens = append(auth.Tokens, stored...)
+
+	if !auth.Enabled || !auth.GenerateToken || len(auth.Tokens) > 0 {
+		return "", nil
+	}
+
+	token, record, err := s.Issue(AgentTokenSpec{Name: DefaultAgentTokenName, Permission: database.PermissionReadOnly})
+	if err != nil {
+		return "", err
+	}
+	auth.Tokens = append(auth.Tokens, record)
+
+	s.logger.Warnf("No agent tokens configured; generated a read-only agent token (shown only once): %s", token)
+	s.logger.Warn("Rotate it by adding your own tokens under auth.tokens and revoking the generated one")
+	return token, nil
+}
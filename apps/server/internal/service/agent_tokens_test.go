@@ -0,0 +1,127 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+)
+
+func TestBootstrapGeneratesTokenOnFirstStartOnly(t *testing.T) {
+	db := newTestDB(t)
+	tokens := NewAgentTokenService(db, zap.NewNop().Sugar())
+
+	first := config.AuthConfig{Enabled: true, GenerateToken: true}
+	generated, err := tokens.Bootstrap(&first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(generated) != 64 {
+		t.Fatalf("Expected a generated 64 character token, got %q", generated)
+	}
+	if valid, permission := (&config.Config{Auth: first}).ValidateToken(generated); !valid || permission != 0 {
+		t.Errorf("Expected the generated token to be valid and read-only, got %v %d", valid, permission)
+	}
+
+	// The next start loads the stored token instead of generating another
+	second := config.AuthConfig{Enabled: true, GenerateToken: true}
+	again, err := tokens.Bootstrap(&second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != "" {
+		t.Errorf("Expected no token on a later start, got %q", again)
+	}
+	if len(second.Tokens) != 1 || second.Tokens[0].Hash != config.HashToken(generated) || second.Tokens[0].Token != "" {
+		t.Errorf("Expected the stored token to be loaded by its hash, got %+v", second.Tokens)
+	}
+}
+
+func TestBootstrapKeepsConfiguredTokens(t *testing.T) {
+	tokens := NewAgentTokenService(newTestDB(t), zap.NewNop().Sugar())
+
+	configured := config.AuthConfig{Enabled: true, GenerateToken: true, Tokens: []config.TokenConfig{{Token: "mine"}}}
+	if generated, err := tokens.Bootstrap(&configured); err != nil || generated != "" {
+		t.Errorf("Expected no token when one is configured, got %q %v", generated, err)
+	}
+
+	disabled := config.AuthConfig{Enabled: true}
+	if generated, _ := tokens.Bootstrap(&disabled); generated != "" {
+		t.Errorf("Expected no token with generation off, got %q", generated)
+	}
+	if stored, _ := tokens.Tokens(); len(stored) != 0 {
+		t.Errorf("Expected nothing stored, got %+v", stored)
+	}
+}
+
+func TestIssuedTokensAreHashedScopedAndRevocable(t *testing.T) {
+	db := newTestDB(t)
+	tokens := NewAgentTokenService(db, zap.NewNop().Sugar())
+	cfg := &config.Config{Auth: config.AuthConfig{Enabled: true}}
+
+	token, issued, err := tokens.Issue(AgentTokenSpec{
+		Name:       "web",
+		Permission: 1,
+		ExpiresAt:  time.Now().Add(time.Hour),
+		Hostnames:  []string{"web-*"},
+		Labels:     map[string]string{"env": "prod"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.AddToken(issued)
+
+	var record database.AgentToken
+	db.First(&record, issued.ID)
+	if record.TokenHash != config.HashToken(token) || strings.Contains(record.Hint, token) {
+		t.Errorf("Expected only the token's hash and a hint to be stored, got %+v", record)
+	}
+
+	web := config.TokenScope{Hostname: "web-1", Labels: map[string]string{"env": "prod"}}
+	if matched, err := cfg.ValidateAgentToken(token, web); err != nil || matched.ID != issued.ID || matched.Permission != 1 {
+		t.Errorf("Expected the token to be valid for web-1, got %+v %v", matched, err)
+	}
+	if _, err := cfg.ValidateAgentToken(token, config.TokenScope{Hostname: "db-1", Labels: web.Labels}); !errors.Is(err, config.ErrTokenScope) {
+		t.Errorf("Expected ErrTokenScope for another host, got %v", err)
+	}
+	if valid, _ := cfg.ValidateToken(token); valid {
+		t.Error("Expected a scoped token to be refused when the caller is unknown")
+	}
+
+	tokens.RecordUse(issued.ID, "web-1")
+	tokens.RecordUse(issued.ID, "web-2")
+	db.First(&record, issued.ID)
+	if record.UseCount != 2 || record.LastUsedHost != "web-2" || record.LastUsedAt == nil {
+		t.Errorf("Expected usage to be recorded, got %+v", record)
+	}
+
+	if err := tokens.Revoke(issued.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.Revoke(issued.ID); !errors.Is(err, ErrAgentTokenNotFound) {
+		t.Errorf("Expected revoking twice to fail with ErrAgentTokenNotFound, got %v", err)
+	}
+	if active, _ := tokens.Tokens(); len(active) != 0 {
+		t.Errorf("Expected revoked tokens not to be loaded, got %+v", active)
+	}
+	if all, _ := tokens.List(); len(all) != 1 || all[0].RevokedAt == nil {
+		t.Errorf("Expected the revoked token to stay listed, got %+v", all)
+	}
+}
+
+func TestValidateAgentTokenRejectsExpiredTokens(t *testing.T) {
+	expired := time.Now().Add(-time.Minute)
+	cfg := &config.Config{Auth: config.AuthConfig{Enabled: true, Tokens: []config.TokenConfig{
+		{Token: "old", ExpiresAt: &expired},
+	}}}
+	if _, err := cfg.ValidateAgentToken("old", config.TokenScope{Hostname: "web-1"}); !errors.Is(err, config.ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+	if _, err := cfg.ValidateAgentToken("unknown", config.TokenScope{}); !errors.Is(err, config.ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+}
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+)
+
+// AlertEvent reports a host metric rule starting or stopping firing on an agent
+type AlertEvent struct {
+	AgentID   string          `json:"agentId"`
+	Rule      MetricAlertRule `json:"rule"`
+	Target    string          `json:"target,omitempty"` // Mount point or GPU index for scoped rules
+	Value     float64         `json:"value"`
+	Firing    bool            `json:"firing"`             // false once the rule resolves
+	Renotify  bool            `json:"renotify,omitempty"` // repeated for an alert still firing
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// trackedRule is one agent's progress through a rule for a single target
+type trackedRule struct {
+	rule       MetricAlertRule
+	target     string
+	state      ruleState
+	notifiedAt time.Time
+}
+
+// ruleTarget is a value a rule is compared against
+type ruleTarget struct {
+	name  string
+	value float64
+}
+
+// AlertEngine evaluates host metric rules against live metrics. A rule fires
+// once a breach has lasted its ForSec and is not raised again until the
+// metric recovers, at which point it resolves. With a renotify interval an
+// alert still firing is repeated until it is acknowledged in the store.
+type AlertEngine struct {
+	rules    []MetricAlertRule
+	agents   map[string]map[string]*trackedRule // agent -> rule|target
+	onEvent  func(AlertEvent)
+	store    *AlertStore
+	renotify time.Duration
+	mu       sync.Mutex
+	logger   *zap.SugaredLogger
+}
+
+// MetricAlertRulesFromConfig converts configured rules
+func MetricAlertRulesFromConfig(rules []config.MetricAlertRule) []MetricAlertRule {
+	result := make([]MetricAlertRule, 0, len(rules))
+	for _, r := range rules {
+		result = append(result, MetricAlertRule{
+			Name:       r.Name,
+			Metric:     r.Metric,
+			Operator:   r.Operator,
+			Threshold:  r.Threshold,
+			ForSec:     r.ForSec,
+			Severity:   r.Severity,
+			MountPoint: r.MountPoint,
+			GPUIndex:   r.GPUIndex,
+		})
+	}
+	return result
+}
+
+// NewAlertEngine validates the rules and creates an engine
+func NewAlertEngine(logger *zap.SugaredLogger, rules []MetricAlertRule) (*AlertEngine, error) {
+	normalized := make([]MetricAlertRule, 0, len(rules))
+	names := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		rule, err := rule.Normalize()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %d: %w: duplicate name %q", i, ErrInvalidAlertRule, rule.Name)
+		}
+		names[rule.Name] = true
+		normalized = append(normalized, rule)
+	}
+
+	return &AlertEngine{
+		rules:  normalized,
+		agents: make(map[string]map[string]*trackedRule),
+		logger: logger,
+	}, nil
+}
+
+// SetAlertHandler sets the callback invoked when a rule fires or resolves
+func (e *AlertEngine) SetAlertHandler(handler func(AlertEvent)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onEvent = handler
+}
+
+// SetStore sets the store that keeps alert history
+func (e *AlertEngine) SetStore(store *AlertStore) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.store = store
+}
+
+// SetRenotifyInterval repeats firing alerts that have not been acknowledged
+// this often; 0 notifies once per firing
+func (e *AlertEngine) SetRenotifyInterval(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.renotify = d
+}
+
+// Evaluate feeds an agent's metrics to all rules and returns the rules that
+// fired, resolved or are due a repeat notification with this sample.
+// Incomplete samples are skipped.
+func (e *AlertEngine) Evaluate(agentID string, data *MetricsData) []AlertEvent {
+	if data == nil || data.Incomplete || len(e.rules) == 0 {
+		return nil
+	}
+
+	now := data.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	sample := historyRecord(agentID, data)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	tracked := e.agents[agentID]
+	if tracked == nil {
+		tracked = make(map[string]*trackedRule)
+		e.agents[agentID] = tracked
+	}
+
+	var events []AlertEvent
+	seen := make(map[string]bool, len(tracked))
+	for _, rule := range e.rules {
+		for _, target := range liveTargets(rule, data, sample) {
+			key := rule.Name + "|" + target.name
+			seen[key] = true
+			t := tracked[key]
+			if t == nil {
+				t = &trackedRule{rule: rule, target: target.name}
+				tracked[key] = t
+			}
+			fired, resolved := t.state.step(rule, now, target.value)
+			switch {
+			case fired || resolved:
+				t.notifiedAt = now
+				events = append(events, e.record(agentID, key, t, target.value, fired, now))
+			case e.renotifyDue(agentID, key, t, now):
+				t.notifiedAt = now
+				events = append(events, AlertEvent{
+					AgentID:   agentID,
+					Rule:      rule,
+					Target:    t.target,
+					Value:     target.value,
+					Firing:    true,
+					Renotify:  true,
+					Timestamp: now,
+				})
+			}
+		}
+	}
+
+	// A disk or GPU that is no longer reported cannot keep a rule firing
+	for key, t := range tracked {
+		if seen[key] {
+			continue
+		}
+		if t.state.firing {
+			events = append(events, e.record(agentID, key, t, 0, false, now))
+		}
+		delete(tracked, key)
+	}
+	return events
+}
+
+// record logs and stores a rule firing or resolving (must hold e.mu)
+func (e *AlertEngine) record(agentID, key string, t *trackedRule, value float64, firing bool, now time.Time) AlertEvent {
+	event := AlertEvent{
+		AgentID:   agentID,
+		Rule:      t.rule,
+		Target:    t.target,
+		Value:     value,
+		Firing:    firing,
+		Timestamp: now,
+	}
+
+	storeKey := metricAlertKey(agentID, key)
+	if !firing {
+		if e.store != nil {
+			e.store.Resolve(storeKey)
+		}
+		e.logger.Infof("Alert %q resolved on agent %s", t.rule.Name, agentID)
+		return event
+	}
+
+	subject := t.rule.Metric
+	if t.target != "" {
+		subject = fmt.Sprintf("%s[%s]", t.rule.Metric, t.target)
+	}
+	message := fmt.Sprintf("%s: %s=%.1f %s %g", t.rule.Name, subject, value, t.rule.Operator, t.rule.Threshold)
+	if e.store != nil {
+		e.store.Fire(storeKey, AlertRecord{
+			Kind:     AlertKindMetric,
+			AgentID:  agentID,
+			Severity: t.rule.Severity,
+			Message:  message,
+			Data:     event,
+			FiredAt:  now,
+		})
+	}
+	e.logger.Warnf("Alert on agent %s: %s", agentID, message)
+	return event
+}
+
+// renotifyDue reports whether a firing alert should be repeated (must hold e.mu)
+func (e *AlertEngine) renotifyDue(agentID, key string, t *trackedRule, now time.Time) bool {
+	if !t.state.firing || e.renotify <= 0 || now.Sub(t.notifiedAt) < e.renotify {
+		return false
+	}
+	return e.store == nil || !e.store.Acknowledged(metricAlertKey(agentID, key))
+}
+
+// metricAlertKey is the alert store key for an agent's rule|target
+func metricAlertKey(agentID, key string) string {
+	return "metric|" + agentID + "|" + key
+}
+
+// dispatch delivers events to the handler
+func (e *AlertEngine) dispatch(events []AlertEvent) {
+	e.mu.Lock()
+	handler := e.onEvent
+	e.mu.Unlock()
+
+	if handler == nil {
+		return
+	}
+	for _, event := range events {
+		handler(event)
+	}
+}
+
+// Forget drops an agent's rule state, resolving its firing alerts in the store
+func (e *AlertEngine) Forget(agentID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for key, t := range e.agents[agentID] {
+		if t.state.firing && e.store != nil {
+			e.store.Resolve(metricAlertKey(agentID, key))
+		}
+	}
+	delete(e.agents, agentID)
+}
+
+// liveTargets returns the values a rule compares in a live sample: one per
+// matching mount or GPU for scoped rules, otherwise the host-level value
+func liveTargets(rule MetricAlertRule, data *MetricsData, sample database.MetricsHistory) []ruleTarget {
+	switch {
+	case rule.MountPoint != "":
+		var targets []ruleTarget
+		for _, d := range data.Disks {
+			if rule.MountPoint == AllMountPoints || d.MountPoint == rule.MountPoint {
+				targets = append(targets, ruleTarget{name: d.MountPoint, value: d.UsagePercent})
+			}
+		}
+		return targets
+	case rule.GPUIndex != nil:
+		for _, g := range data.GPUs {
+			if g.Index == *rule.GPUIndex {
+				return []ruleTarget{{name: strconv.Itoa(g.Index), value: g.UsagePercent}}
+			}
+		}
+		return nil
+	}
+	value, ok := rule.value(sample)
+	if !ok {
+		return nil
+	}
+	return []ruleTarget{{value: value}}
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestAlertEngineFiresOncePerSustainedBreach(t *testing.T) {
+	engine, err := NewAlertEngine(zap.NewNop().Sugar(), []MetricAlertRule{
+		{Name: "cpu-hot", Metric: RuleMetricCPU, Threshold: 90, ForSec: 120, Severity: "critical"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan AlertEvent, 4)
+	engine.SetAlertHandler(func(e AlertEvent) { events <- e })
+	store := NewAlertStore(10, 0)
+	engine.SetStore(store)
+	firing := true
+	active := func() []AlertRecord {
+		return store.Query(AlertQuery{Kind: AlertKindMetric, Firing: &firing}).Items
+	}
+
+	clock := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	metrics := NewMetricsService(zap.NewNop().Sugar())
+	metrics.SetClock(clock)
+	metrics.SetAlertEngine(engine)
+
+	report := func(cpu float64) {
+		metrics.StoreMetrics("agent-1", &MetricsData{CPU: CPUData{UsagePercent: cpu}})
+		clock.Advance(time.Minute)
+	}
+	expect := func(firing bool) AlertEvent {
+		t.Helper()
+		select {
+		case e := <-events:
+			if e.Firing != firing {
+				t.Fatalf("Expected firing=%v, got %+v", firing, e)
+			}
+			return e
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected an event with firing=%v", firing)
+		}
+		return AlertEvent{}
+	}
+
+	// A short spike does not fire
+	report(95)
+	report(50)
+	// A breach held for two minutes fires once
+	report(95)
+	report(97)
+	report(99)
+	e := expect(true)
+	if e.AgentID != "agent-1" || e.Rule.Name != "cpu-hot" || e.Value != 99 {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	report(98)
+	if len(active()) != 1 {
+		t.Errorf("Expected one firing alert in the store, got %+v", active())
+	}
+
+	report(40)
+	expect(false)
+	if len(active()) != 0 {
+		t.Errorf("Expected the alert to resolve in the store, got %+v", active())
+	}
+	select {
+	case e := <-events:
+		t.Errorf("Expected no further events, got %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAlertEngineScopedRules(t *testing.T) {
+	gpu := 1
+	engine, err := NewAlertEngine(zap.NewNop().Sugar(), []MetricAlertRule{
+		{Name: "disk-full", Metric: RuleMetricDisk, Threshold: 90, MountPoint: AllMountPoints},
+		{Name: "data-full", Metric: RuleMetricDisk, Threshold: 80, MountPoint: "/data"},
+		{Name: "gpu1-busy", Metric: RuleMetricGPU, Threshold: 95, GPUIndex: &gpu},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := &MetricsData{
+		Timestamp: time.Now(),
+		Disks: []DiskData{
+			{MountPoint: "/", UsagePercent: 93},
+			{MountPoint: "/data", UsagePercent: 85},
+		},
+		GPUs: []GPUData{{Index: 0, UsagePercent: 99}, {Index: 1, UsagePercent: 97}},
+	}
+	fired := make(map[string]AlertEvent)
+	for _, e := range engine.Evaluate("agent-1", data) {
+		fired[e.Rule.Name+" "+e.Target] = e
+	}
+	if len(fired) != 3 {
+		t.Fatalf("Expected 3 alerts, got %+v", fired)
+	}
+	for _, key := range []string{"disk-full /", "data-full /data", "gpu1-busy 1"} {
+		if e, ok := fired[key]; !ok || !e.Firing {
+			t.Errorf("Expected %q to fire, got %+v", key, fired)
+		}
+	}
+
+	// An unmounted disk resolves the alert it was raising
+	data.Disks = data.Disks[1:]
+	resolved := engine.Evaluate("agent-1", data)
+	if len(resolved) != 1 || resolved[0].Firing || resolved[0].Target != "/" {
+		t.Errorf("Expected the / alert to resolve, got %+v", resolved)
+	}
+}
+
+func TestAlertEngineRejectsInvalidRules(t *testing.T) {
+	gpu := 0
+	cases := []MetricAlertRule{
+		{Metric: "swap", Threshold: 50},
+		{Metric: RuleMetricCPU, Threshold: 50, MountPoint: "/"},
+		{Metric: RuleMetricDisk, Threshold: 50, GPUIndex: &gpu},
+	}
+	for _, rule := range cases {
+		if _, err := NewAlertEngine(zap.NewNop().Sugar(), []MetricAlertRule{rule}); !errors.Is(err, ErrInvalidAlertRule) {
+			t.Errorf("Expected ErrInvalidAlertRule for %+v, got %v", rule, err)
+		}
+	}
+
+	_, err := NewAlertEngine(zap.NewNop().Sugar(), []MetricAlertRule{
+		{Name: "busy", Metric: RuleMetricCPU, Threshold: 90},
+		{Name: "busy", Metric: RuleMetricLoad1, Threshold: 8},
+	})
+	if !errors.Is(err, ErrInvalidAlertRule) {
+		t.Errorf("Expected duplicate names to be rejected, got %v", err)
+	}
+}
+
+func TestAcknowledgedAlertIsNotRenotified(t *testing.T) {
+	engine, err := NewAlertEngine(zap.NewNop().Sugar(), []MetricAlertRule{
+		{Name: "load-high", Metric: RuleMetricLoad1, Threshold: 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := NewAlertStore(10, 0)
+	store.SetClock(NewFakeClock(start))
+	engine.SetStore(store)
+	engine.SetRenotifyInterval(5 * time.Minute)
+
+	at := func(minute int, load float64) []AlertEvent {
+		return engine.Evaluate("agent-1", &MetricsData{
+			Timestamp:   start.Add(time.Duration(minute) * time.Minute),
+			LoadAverage: []float64{load},
+		})
+	}
+
+	if events := at(0, 12); len(events) != 1 || !events[0].Firing || events[0].Renotify {
+		t.Fatalf("Expected the alert to fire, got %+v", events)
+	}
+	if events := at(3, 12); len(events) != 0 {
+		t.Errorf("Expected no repeat before the interval, got %+v", events)
+	}
+	if events := at(5, 12); len(events) != 1 || !events[0].Renotify {
+		t.Errorf("Expected a repeat notification, got %+v", events)
+	}
+
+	firing := true
+	alert := store.Query(AlertQuery{Firing: &firing}).Items[0]
+	acked, err := store.Acknowledge(alert.ID, "oncall", "known issue, ticket #123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acked.Acknowledged || acked.AckedBy != "oncall" || !acked.AckedAt.Equal(start) || acked.Note != "known issue, ticket #123" {
+		t.Errorf("Expected who and when to be recorded, got %+v", acked)
+	}
+	if events := at(10, 12); len(events) != 0 {
+		t.Errorf("Expected no repeat once acknowledged, got %+v", events)
+	}
+
+	// Clearing resets the acknowledgment: the next firing notifies again
+	at(11, 2)
+	if _, err := store.Acknowledge(alert.ID, "oncall", ""); !errors.Is(err, ErrAlertNotFiring) {
+		t.Errorf("Expected ErrAlertNotFiring for a resolved alert, got %v", err)
+	}
+	if events := at(12, 12); len(events) != 1 || events[0].Renotify {
+		t.Fatalf("Expected the alert to fire again, got %+v", events)
+	}
+	refired := store.Query(AlertQuery{Firing: &firing}).Items[0]
+	if refired.ID == alert.ID || refired.Acknowledged {
+		t.Errorf("Expected a new unacknowledged alert, got %+v", refired)
+	}
+	if events := at(17, 12); len(events) != 1 || !events[0].Renotify {
+		t.Errorf("Expected repeats to resume, got %+v", events)
+	}
+
+	if _, err := store.Acknowledge(999, "oncall", ""); !errors.Is(err, ErrAlertNotFound) {
+		t.Errorf("Expected ErrAlertNotFound, got %v", err)
+	}
+}
+
+func TestAlertsResolveWhenAgentGoesOffline(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	engine, err := NewAlertEngine(logger, []MetricAlertRule{
+		{Name: "load-high", Metric: RuleMetricLoad1, Threshold: 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewAlertStore(10, 0)
+	engine.SetStore(store)
+	ms := NewMetricsService(logger)
+	ms.SetAlertEngine(engine)
+	agents := NewAgentService(logger, ms)
+	agents.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "web-1"}, 0)
+
+	if events := engine.Evaluate("agent-1", &MetricsData{Timestamp: time.Now(), LoadAverage: []float64{12}}); len(events) != 1 {
+		t.Fatalf("Expected the alert to fire, got %+v", events)
+	}
+	agents.UnregisterAgent("agent-1")
+
+	firing := true
+	if alerts := store.Query(AlertQuery{Firing: &firing}).Items; len(alerts) != 0 {
+		t.Errorf("Expected the alert to resolve once the agent is offline, got %+v", alerts)
+	}
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if len(engine.agents) != 0 {
+		t.Errorf("Expected the agent's rule state to be dropped, got %v", engine.agents)
+	}
+}
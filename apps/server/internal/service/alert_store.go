@@ -0,0 +1,296 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Kinds of stored alerts and agent events
+const (
+	AlertKindMetric      = "metric"
+	AlertKindAccelerator = "accelerator"
+	AlertKindClockSkew   = "clock_skew"
+	EventKindIPChange    = "ip_change"
+	EventKindDisconnect  = "disconnect"
+)
+
+// AlertRecord is one alert or agent event kept in memory. Alerts fire and
+// later resolve; events are resolved the moment they are recorded.
+type AlertRecord struct {
+	ID         uint64      `json:"id"`
+	Kind       string      `json:"kind"`
+	AgentID    string      `json:"agentId"`
+	Severity   string      `json:"severity,omitempty"`
+	Message    string      `json:"message"`
+	Data       interface{} `json:"data,omitempty"`
+	Firing     bool        `json:"firing"`
+	FiredAt    time.Time   `json:"firedAt"`
+	ResolvedAt *time.Time  `json:"resolvedAt,omitempty"`
+
+	// Set while an on-call engineer has acknowledged a firing alert
+	Acknowledged bool       `json:"acknowledged"`
+	AckedBy      string     `json:"ackedBy,omitempty"`
+	AckedAt      *time.Time `json:"ackedAt,omitempty"`
+	Note         string     `json:"note,omitempty"`
+
+	key string
+}
+
+// Alert acknowledgment errors
+var (
+	ErrAlertNotFound  = errors.New("alert not found")
+	ErrAlertNotFiring = errors.New("alert is not firing")
+)
+
+// AlertQuery filters and paginates stored alerts
+type AlertQuery struct {
+	AgentID string
+	Kind    string
+	// Firing selects only firing (true) or only resolved (false) entries
+	Firing *bool
+	// Visible limits results to these agents; nil means all agents
+	Visible map[string]bool
+	Limit   int
+	Offset  int
+}
+
+// AlertPage is one page of stored alerts, newest first
+type AlertPage struct {
+	Items   []AlertRecord `json:"items"`
+	Total   int           `json:"total"`
+	Limit   int           `json:"limit"`
+	Offset  int           `json:"offset"`
+	HasMore bool          `json:"hasMore"`
+}
+
+// AlertStore keeps recent alerts or agent events in a bounded buffer. When
+// over maxCount, or when entries resolved longer than maxAge ago exist, the
+// oldest resolved entries are evicted first. Firing alerts are never evicted,
+// so an incident cannot push its own active alerts out of view.
+type AlertStore struct {
+	records  []*AlertRecord // oldest first
+	firing   map[string]*AlertRecord
+	nextID   uint64
+	maxCount int
+	maxAge   time.Duration
+	clock    Clock
+	mu       sync.Mutex
+}
+
+// NewAlertStore creates a store holding up to maxCount entries. maxAge of 0
+// keeps resolved entries until the count limit evicts them.
+func NewAlertStore(maxCount int, maxAge time.Duration) *AlertStore {
+	if maxCount <= 0 {
+		maxCount = 1000
+	}
+	return &AlertStore{
+		firing:   make(map[string]*AlertRecord),
+		maxCount: maxCount,
+		maxAge:   maxAge,
+		clock:    RealClock,
+	}
+}
+
+// SetClock replaces the time source (for tests)
+func (s *AlertStore) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+// Fire stores a new firing alert under key. An alert already firing under
+// the same key is left as is.
+func (s *AlertStore) Fire(key string, record AlertRecord) AlertRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.firing[key]; ok {
+		return *existing
+	}
+	if record.FiredAt.IsZero() {
+		record.FiredAt = s.clock.Now()
+	}
+	record.Firing = true
+	record.ResolvedAt = nil
+	record.key = key
+	stored := s.append(record)
+	s.firing[key] = stored
+	s.evict()
+	return *stored
+}
+
+// Resolve marks the alert firing under key as resolved
+func (s *AlertStore) Resolve(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.firing[key]
+	if !ok {
+		return false
+	}
+	delete(s.firing, key)
+	now := s.clock.Now()
+	record.Firing = false
+	record.ResolvedAt = &now
+	s.evict()
+	return true
+}
+
+// Get returns the stored entry with the given ID
+func (s *AlertStore) Get(id uint64) (AlertRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record := s.find(id); record != nil {
+		return *record, true
+	}
+	return AlertRecord{}, false
+}
+
+// Acknowledge records that by has seen a firing alert, with an optional
+// note. Notifications for the alert are suppressed until it resolves; if it
+// fires again it has to be acknowledged again.
+func (s *AlertStore) Acknowledge(id uint64, by, note string) (AlertRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.find(id)
+	if record == nil {
+		return AlertRecord{}, ErrAlertNotFound
+	}
+	if !record.Firing {
+		return AlertRecord{}, ErrAlertNotFiring
+	}
+	now := s.clock.Now()
+	record.Acknowledged = true
+	record.AckedBy = by
+	record.AckedAt = &now
+	record.Note = note
+	return *record, nil
+}
+
+// Acknowledged reports whether the alert firing under key has been acknowledged
+func (s *AlertStore) Acknowledged(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.firing[key]
+	return ok && record.Acknowledged
+}
+
+// Record stores a point-in-time event, which is resolved immediately
+func (s *AlertStore) Record(record AlertRecord) AlertRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	if record.FiredAt.IsZero() {
+		record.FiredAt = now
+	}
+	record.Firing = false
+	record.ResolvedAt = &now
+	record.key = ""
+	stored := s.append(record)
+	s.evict()
+	return *stored
+}
+
+// Query returns a page of stored entries, newest first
+func (s *AlertStore) Query(query AlertQuery) AlertPage {
+	if query.Limit <= 0 || query.Limit > 1000 {
+		query.Limit = 100
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict()
+
+	page := AlertPage{Items: make([]AlertRecord, 0), Limit: query.Limit, Offset: query.Offset}
+	for i := len(s.records) - 1; i >= 0; i-- {
+		record := s.records[i]
+		if !query.matches(record) {
+			continue
+		}
+		if page.Total >= query.Offset && len(page.Items) < query.Limit {
+			page.Items = append(page.Items, *record)
+		}
+		page.Total++
+	}
+	page.HasMore = query.Offset+len(page.Items) < page.Total
+	return page
+}
+
+// Len returns the number of stored entries
+func (s *AlertStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
+
+func (q AlertQuery) matches(record *AlertRecord) bool {
+	if q.AgentID != "" && record.AgentID != q.AgentID {
+		return false
+	}
+	if q.Kind != "" && record.Kind != q.Kind {
+		return false
+	}
+	if q.Firing != nil && record.Firing != *q.Firing {
+		return false
+	}
+	if q.Visible != nil && !q.Visible[record.AgentID] {
+		return false
+	}
+	return true
+}
+
+// find returns the stored entry with the given ID. The caller holds s.mu.
+func (s *AlertStore) find(id uint64) *AlertRecord {
+	for i := len(s.records) - 1; i >= 0; i-- {
+		if s.records[i].ID == id {
+			return s.records[i]
+		}
+	}
+	return nil
+}
+
+func (s *AlertStore) append(record AlertRecord) *AlertRecord {
+	s.nextID++
+	record.ID = s.nextID
+	stored := &record
+	s.records = append(s.records, stored)
+	return stored
+}
+
+// evict drops the oldest resolved entries while over the count limit, and
+// any resolved entries older than the age limit. The caller holds s.mu.
+func (s *AlertStore) evict() {
+	excess := len(s.records) - s.maxCount
+	var cutoff time.Time
+	if s.maxAge > 0 {
+		cutoff = s.clock.Now().Add(-s.maxAge)
+	}
+	if excess <= 0 && cutoff.IsZero() {
+		return
+	}
+
+	kept := s.records[:0]
+	for _, record := range s.records {
+		if !record.Firing {
+			expired := !cutoff.IsZero() && record.ResolvedAt.Before(cutoff)
+			if excess > 0 || expired {
+				excess--
+				continue
+			}
+		}
+		kept = append(kept, record)
+	}
+	// Clear the tail so evicted records can be collected
+	for i := len(kept); i < len(s.records); i++ {
+		s.records[i] = nil
+	}
+	s.records = kept
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"go.uber.org/zap"
+)
+
+func TestAlertStoreEvictsResolvedBeyondCap(t *testing.T) {
+	store := NewAlertStore(3, 0)
+
+	// Two alerts stay firing for the whole test
+	store.Fire("active-1", AlertRecord{Kind: AlertKindAccelerator, AgentID: "agent-1"})
+	store.Fire("active-2", AlertRecord{Kind: AlertKindAccelerator, AgentID: "agent-2"})
+
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("flap-%d", i)
+		store.Fire(key, AlertRecord{Kind: AlertKindAccelerator, AgentID: "agent-3"})
+		store.Resolve(key)
+	}
+
+	if n := store.Len(); n != 3 {
+		t.Fatalf("Expected 3 stored alerts, got %d", n)
+	}
+
+	firing := true
+	page := store.Query(AlertQuery{Firing: &firing})
+	if page.Total != 2 {
+		t.Errorf("Expected both firing alerts to be retained, got %d", page.Total)
+	}
+
+	resolved := false
+	page = store.Query(AlertQuery{Firing: &resolved})
+	if page.Total != 1 || page.Items[0].AgentID != "agent-3" {
+		t.Fatalf("Expected only the newest resolved alert, got %+v", page.Items)
+	}
+	if page.Items[0].ID != 7 {
+		t.Errorf("Expected the most recent resolved alert (id 7), got id %d", page.Items[0].ID)
+	}
+}
+
+func TestAlertStoreKeepsFiringAlertsOverCap(t *testing.T) {
+	store := NewAlertStore(2, 0)
+	for i := 0; i < 4; i++ {
+		store.Fire(fmt.Sprintf("active-%d", i), AlertRecord{AgentID: "agent-1"})
+	}
+	if n := store.Len(); n != 4 {
+		t.Errorf("Expected all 4 firing alerts to be kept, got %d", n)
+	}
+
+	// Once resolved they become evictable
+	store.Resolve("active-0")
+	store.Resolve("active-1")
+	if n := store.Len(); n != 2 {
+		t.Errorf("Expected resolved alerts to be evicted down to the cap, got %d", n)
+	}
+}
+
+func TestAlertStoreEvictsByAge(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := NewAlertStore(100, time.Hour)
+	store.SetClock(clock)
+
+	store.Record(AlertRecord{Kind: EventKindDisconnect, AgentID: "agent-1"})
+	store.Fire("long-running", AlertRecord{AgentID: "agent-2"})
+
+	clock.Advance(2 * time.Hour)
+	store.Record(AlertRecord{Kind: EventKindIPChange, AgentID: "agent-3"})
+
+	page := store.Query(AlertQuery{})
+	if page.Total != 2 {
+		t.Fatalf("Expected the old event to expire, got %+v", page.Items)
+	}
+	if page.Items[0].Kind != EventKindIPChange || !page.Items[1].Firing {
+		t.Errorf("Expected the new event and the firing alert, got %+v", page.Items)
+	}
+}
+
+func TestAlertStoreQueryPaginates(t *testing.T) {
+	store := NewAlertStore(100, 0)
+	for i := 0; i < 25; i++ {
+		agentID := "agent-1"
+		if i%5 == 0 {
+			agentID = "agent-2"
+		}
+		store.Record(AlertRecord{Kind: EventKindDisconnect, AgentID: agentID})
+	}
+
+	page := store.Query(AlertQuery{Limit: 10, Offset: 10})
+	if page.Total != 25 || len(page.Items) != 10 || !page.HasMore {
+		t.Errorf("Expected 10 of 25 with more to come, got %d of %d (hasMore=%v)", len(page.Items), page.Total, page.HasMore)
+	}
+	if page.Items[0].ID != 15 {
+		t.Errorf("Expected newest-first paging to start at id 15, got %d", page.Items[0].ID)
+	}
+
+	page = store.Query(AlertQuery{Visible: map[string]bool{"agent-2": true}, Limit: 3})
+	if page.Total != 5 || len(page.Items) != 3 || !page.HasMore {
+		t.Errorf("Expected 3 of 5 visible events, got %d of %d", len(page.Items), page.Total)
+	}
+	for _, item := range page.Items {
+		if item.AgentID != "agent-2" {
+			t.Errorf("Expected only agent-2 events, got %s", item.AgentID)
+		}
+	}
+}
+
+func TestAcceleratorAlerterRecordsHistory(t *testing.T) {
+	alerter, err := NewAcceleratorAlerter(zap.NewNop().Sugar(), []config.AcceleratorAlertRule{
+		{Name: "gpu-hot", Device: "gpu", Field: AcceleratorFieldTemperature, Threshold: 85},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewAlertStore(10, 0)
+	alerter.SetStore(store)
+
+	data := &MetricsData{GPUs: []GPUData{{Index: 0, Name: "A100", Temperature: 90}}}
+	alerter.Evaluate("agent-1", data)
+	alerter.Evaluate("agent-1", data)
+
+	page := store.Query(AlertQuery{})
+	if page.Total != 1 || !page.Items[0].Firing || page.Items[0].Severity != "warning" {
+		t.Fatalf("Expected one firing alert, got %+v", page.Items)
+	}
+
+	data.GPUs[0].Temperature = 60
+	alerter.Evaluate("agent-1", data)
+	page = store.Query(AlertQuery{})
+	if page.Total != 1 || page.Items[0].Firing || page.Items[0].ResolvedAt == nil {
+		t.Errorf("Expected the alert to be resolved, got %+v", page.Items)
+	}
+}
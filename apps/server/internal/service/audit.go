@@ -2,6 +2,8 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/chenqi92/NanoLink/apps/server/internal/database"
@@ -12,6 +14,7 @@ import (
 // AuditService handles operation audit logging
 type AuditService struct {
 	db     *gorm.DB
+	masker *OutputMasker
 	logger *zap.SugaredLogger
 }
 
@@ -23,6 +26,12 @@ func NewAuditService(db *gorm.DB, logger *zap.SugaredLogger) *AuditService {
 	}
 }
 
+// SetOutputMasker sets how command output and errors are masked before
+// they are written to the audit log
+func (s *AuditService) SetOutputMasker(masker *OutputMasker) {
+	s.masker = masker
+}
+
 // AuditEntry represents audit log input
 type AuditEntry struct {
 	UserID        uint
@@ -35,6 +44,7 @@ type AuditEntry struct {
 	Params        map[string]string
 	Success       bool
 	Error         string
+	Output        string
 	DurationMs    int64
 	IPAddress     string
 }
@@ -59,7 +69,8 @@ func (s *AuditService) LogCommand(entry AuditEntry) error {
 		Target:        entry.Target,
 		Params:        paramsJSON,
 		Success:       entry.Success,
-		Error:         entry.Error,
+		Error:         s.masker.Mask(entry.CommandType, entry.Error),
+		Output:        s.masker.Mask(entry.CommandType, entry.Output),
 		DurationMs:    entry.DurationMs,
 		IPAddress:     entry.IPAddress,
 	}
@@ -74,16 +85,128 @@ func (s *AuditService) LogCommand(entry AuditEntry) error {
 	return nil
 }
 
+// CompleteCommand records the outcome of a command that was audited when
+// it was dispatched to agentID, with its duration since then
+func (s *AuditService) CompleteCommand(agentID, commandID string, success bool, errMsg, output string) error {
+	if commandID == "" {
+		return nil
+	}
+	var entry database.AuditLog
+	if err := s.db.Where("agent_id = ? AND command_id = ?", agentID, commandID).First(&entry).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	return s.db.Model(&entry).Updates(map[string]interface{}{
+		"success":     success,
+		"error":       s.masker.Mask(entry.CommandType, errMsg),
+		"output":      s.masker.Mask(entry.CommandType, output),
+		"duration_ms": time.Since(entry.Timestamp).Milliseconds(),
+	}).Error
+}
+
+// RecordSnapshots keeps the metrics snapshots taken around a command on its
+// audit entry. Nil snapshots leave the stored ones untouched.
+func (s *AuditService) RecordSnapshots(agentID, commandID string, pre, post *MetricsData) error {
+	updates := map[string]interface{}{}
+	for column, snapshot := range map[string]*MetricsData{"pre_snapshot": pre, "post_snapshot": post} {
+		if snapshot == nil {
+			continue
+		}
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		updates[column] = string(data)
+	}
+	if commandID == "" || len(updates) == 0 {
+		return nil
+	}
+	return s.db.Model(&database.AuditLog{}).
+		Where("agent_id = ? AND command_id = ?", agentID, commandID).
+		Updates(updates).Error
+}
+
+// CommandRecord rebuilds the record of an audited command, including its
+// metrics snapshots, for commands no longer tracked in memory
+func (s *AuditService) CommandRecord(commandID string) (*CommandRecord, error) {
+	var entry database.AuditLog
+	if err := s.db.Where("command_id = ?", commandID).Order("id DESC").First(&entry).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCommandNotFound
+		}
+		return nil, err
+	}
+
+	record := &CommandRecord{
+		CommandID: entry.CommandID,
+		AgentID:   entry.AgentID,
+		Type:      entry.CommandType,
+		Target:    entry.Target,
+		Status:    CommandStatusPending,
+		Error:     entry.Error,
+		Output:    entry.Output,
+		IssuedAt:  entry.Timestamp,
+	}
+	if entry.Success || entry.Error != "" || entry.DurationMs > 0 {
+		completed := entry.Timestamp.Add(time.Duration(entry.DurationMs) * time.Millisecond)
+		record.CompletedAt = &completed
+		record.Status = CommandStatusFailed
+		if entry.Success {
+			record.Status = CommandStatusSucceeded
+		}
+	}
+	var err error
+	if record.PreSnapshot, err = decodeSnapshot(entry.PreSnapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot for command %s: %w", commandID, err)
+	}
+	if record.PostSnapshot, err = decodeSnapshot(entry.PostSnapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot for command %s: %w", commandID, err)
+	}
+	return record, nil
+}
+
+// decodeSnapshot decodes a stored metrics snapshot, if any
+func decodeSnapshot(stored string) (*MetricsData, error) {
+	if stored == "" {
+		return nil, nil
+	}
+	var data MetricsData
+	if err := json.Unmarshal([]byte(stored), &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// ErrInvalidAuditOrder is returned for an AuditQuery.OrderBy that is not a
+// sortable audit log field
+var ErrInvalidAuditOrder = errors.New("invalid audit log order")
+
+// auditOrderColumns maps the fields audit logs can be sorted by to columns
+var auditOrderColumns = map[string]string{
+	"timestamp":   "timestamp",
+	"username":    "username",
+	"agentId":     "agent_id",
+	"commandType": "command_type",
+	"success":     "success",
+	"durationMs":  "duration_ms",
+}
+
 // AuditQuery represents query parameters for audit logs
 type AuditQuery struct {
 	UserID      uint
+	Username    string
 	AgentID     string
 	CommandType string
 	Success     *bool
 	StartTime   *time.Time
 	EndTime     *time.Time
-	Limit       int
-	Offset      int
+	// Field to sort by (see auditOrderColumns); empty sorts newest first
+	OrderBy string
+	Desc    bool
+	Limit   int
+	Offset  int
 }
 
 // AuditQueryResult contains paginated audit logs
@@ -101,12 +224,26 @@ func (s *AuditService) QueryLogs(query AuditQuery) (*AuditQueryResult, error) {
 		query.Limit = 100
 	}
 
+	column, direction := "timestamp", "DESC"
+	if query.OrderBy != "" {
+		var ok bool
+		if column, ok = auditOrderColumns[query.OrderBy]; !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidAuditOrder, query.OrderBy)
+		}
+		if !query.Desc {
+			direction = "ASC"
+		}
+	}
+
 	db := s.db.Model(&database.AuditLog{})
 
 	// Apply filters
 	if query.UserID > 0 {
 		db = db.Where("user_id = ?", query.UserID)
 	}
+	if query.Username != "" {
+		db = db.Where("username = ?", query.Username)
+	}
 	if query.AgentID != "" {
 		db = db.Where("agent_id = ?", query.AgentID)
 	}
@@ -129,9 +266,10 @@ func (s *AuditService) QueryLogs(query AuditQuery) (*AuditQueryResult, error) {
 		return nil, err
 	}
 
-	// Get paginated results
+	// Get paginated results; the id tiebreak keeps pages stable when the
+	// sort field has repeated values
 	var logs []database.AuditLog
-	if err := db.Order("timestamp DESC").
+	if err := db.Order(column + " " + direction).Order("id " + direction).
 		Offset(query.Offset).
 		Limit(query.Limit).
 		Find(&logs).Error; err != nil {
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestQueryLogsFiltersAndSorts(t *testing.T) {
+	audit := NewAuditService(newTestDB(t), zap.NewNop().Sugar())
+	for _, entry := range []AuditEntry{
+		{Username: "alice", AgentID: "agent-1", CommandType: "SERVICE_RESTART", Success: true, DurationMs: 300},
+		{Username: "bob", AgentID: "agent-1", CommandType: "SERVICE_RESTART", Success: false, DurationMs: 100},
+		{Username: "alice", AgentID: "agent-2", CommandType: "SHELL_EXECUTE", Success: false, DurationMs: 200},
+		{Username: "alice", AgentID: "agent-1", CommandType: "PROCESS_LIST", Success: false, DurationMs: 50},
+	} {
+		if err := audit.LogCommand(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failed := false
+	result, err := audit.QueryLogs(AuditQuery{Username: "alice", Success: &failed, OrderBy: "durationMs", Desc: true, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 2 || !result.HasMore {
+		t.Errorf("Expected 2 of alice's failures with more to page through, got total %d hasMore %v", result.Total, result.HasMore)
+	}
+	if len(result.Logs) != 1 || result.Logs[0].DurationMs != 200 {
+		t.Fatalf("Expected the slowest failure first, got %+v", result.Logs)
+	}
+
+	result, err = audit.QueryLogs(AuditQuery{Username: "alice", Success: &failed, OrderBy: "durationMs", Desc: true, Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Logs) != 1 || result.Logs[0].DurationMs != 50 || result.HasMore {
+		t.Errorf("Expected the last failure on the second page, got %+v hasMore %v", result.Logs, result.HasMore)
+	}
+
+	result, err = audit.QueryLogs(AuditQuery{OrderBy: "username"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Logs) != 4 || result.Logs[0].Username != "alice" || result.Logs[3].Username != "bob" {
+		t.Errorf("Expected logs sorted by username ascending, got %+v", result.Logs)
+	}
+
+	future := time.Now().Add(time.Hour)
+	if result, err := audit.QueryLogs(AuditQuery{StartTime: &future}); err != nil || result.Total != 0 {
+		t.Errorf("Expected no logs after the time range, got %v %v", result, err)
+	}
+
+	if _, err := audit.QueryLogs(AuditQuery{OrderBy: "params; DROP TABLE audit_logs"}); !errors.Is(err, ErrInvalidAuditOrder) {
+		t.Errorf("Expected ErrInvalidAuditOrder, got %v", err)
+	}
+}
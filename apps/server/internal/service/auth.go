@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"sync"
 	"time"
-	"unicode"
 
 	"github.com/chenqi92/NanoLink/apps/server/internal/database"
 	"github.com/golang-jwt/jwt/v5"
@@ -20,10 +19,21 @@ type AuthService struct {
 	logger       *zap.SugaredLogger
 	jwtSecret    []byte
 	jwtExpire    time.Duration
+	accessExpire time.Duration
+	refreshTTL   time.Duration
+	jwtIssuer    string
+	jwtAudience  string
 	loginLimiter *LoginRateLimiter
+	passwords    PasswordPolicy
+	oidc         *OIDCProvider
+	permCache    *PermissionCache
+
+	// Whether VerifyToken checks the issuer and audience
+	validateClaims bool
 }
 
-// JWTClaims represents JWT claims
+// JWTClaims represents JWT claims. RegisteredClaims.ID carries the jti
+// checked against the revocation denylist.
 type JWTClaims struct {
 	UserID       uint   `json:"userId"`
 	Username     string `json:"username"`
@@ -31,12 +41,33 @@ type JWTClaims struct {
 	jwt.RegisteredClaims
 }
 
+// TokenPair is a short-lived access token and the refresh token that
+// rotates it
+type TokenPair struct {
+	AccessToken  string    `json:"token"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"` // When the access token expires
+}
+
 // AuthConfig holds authentication configuration
 type AuthConfig struct {
 	JWTSecret string
 	JWTExpire time.Duration
 	AdminUser string
 	AdminPass string
+
+	// Lifetimes of the access and refresh tokens from GenerateTokenPair
+	AccessExpire  time.Duration // default 15m
+	RefreshExpire time.Duration // default 7 days
+
+	// Issuer and audience set on tokens, and checked on verify when
+	// JWTValidateClaims is set
+	JWTIssuer         string
+	JWTAudience       string
+	JWTValidateClaims bool
+
+	// Rules for new passwords (nil = DefaultPasswordPolicy)
+	PasswordPolicy *PasswordPolicy
 }
 
 // NewAuthService creates a new authentication service
@@ -44,6 +75,19 @@ func NewAuthService(db *gorm.DB, cfg AuthConfig, logger *zap.SugaredLogger) *Aut
 	if cfg.JWTExpire == 0 {
 		cfg.JWTExpire = 24 * time.Hour
 	}
+	if cfg.JWTIssuer == "" {
+		cfg.JWTIssuer = "nanolink-server"
+	}
+	if cfg.AccessExpire == 0 {
+		cfg.AccessExpire = 15 * time.Minute
+	}
+	if cfg.RefreshExpire == 0 {
+		cfg.RefreshExpire = 7 * 24 * time.Hour
+	}
+	passwords := DefaultPasswordPolicy()
+	if cfg.PasswordPolicy != nil {
+		passwords = *cfg.PasswordPolicy
+	}
 	if cfg.JWTSecret == "" {
 		// No more fallback default - must be configured
 		logger.Error("[SECURITY CRITICAL] JWT secret is not set! Please set NANOLINK_JWT_SECRET environment variable.")
@@ -55,7 +99,14 @@ func NewAuthService(db *gorm.DB, cfg AuthConfig, logger *zap.SugaredLogger) *Aut
 		logger:       logger,
 		jwtSecret:    []byte(cfg.JWTSecret),
 		jwtExpire:    cfg.JWTExpire,
+		accessExpire: cfg.AccessExpire,
+		refreshTTL:   cfg.RefreshExpire,
+		jwtIssuer:    cfg.JWTIssuer,
+		jwtAudience:  cfg.JWTAudience,
 		loginLimiter: NewLoginRateLimiter(5, 5*time.Minute), // 5 attempts, 5 min lockout
+		passwords:    passwords,
+
+		validateClaims: cfg.JWTValidateClaims,
 	}
 
 	// Initialize super admin if configured
@@ -75,9 +126,11 @@ var (
 	ErrUserExists       = errors.New("user already exists")
 	ErrInvalidToken     = errors.New("invalid token")
 	ErrTokenExpired     = errors.New("token expired")
+	ErrTokenRevoked     = errors.New("token revoked")
 	ErrPermissionDenied = errors.New("permission denied")
 	ErrWeakPassword     = errors.New("password does not meet strength requirements")
 	ErrTooManyAttempts  = errors.New("too many login attempts, please try again later")
+	ErrExternalAccount  = errors.New("account is managed by an external identity provider")
 )
 
 // LoginRateLimiter implements a simple in-memory rate limiter for login attempts
@@ -86,6 +139,7 @@ type LoginRateLimiter struct {
 	attempts    map[string]*loginAttempt
 	maxAttempts int
 	lockoutTime time.Duration
+	clock       Clock
 }
 
 type loginAttempt struct {
@@ -99,9 +153,17 @@ func NewLoginRateLimiter(maxAttempts int, lockoutTime time.Duration) *LoginRateL
 		attempts:    make(map[string]*loginAttempt),
 		maxAttempts: maxAttempts,
 		lockoutTime: lockoutTime,
+		clock:       RealClock,
 	}
 }
 
+// SetClock sets the time source used for lockout windows
+func (l *LoginRateLimiter) SetClock(clock Clock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clock = clock
+}
+
 func (l *LoginRateLimiter) Check(key string) error {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
@@ -112,7 +174,7 @@ func (l *LoginRateLimiter) Check(key string) error {
 	}
 
 	if attempt.lockedAt != nil {
-		if time.Since(*attempt.lockedAt) < l.lockoutTime {
+		if l.clock.Since(*attempt.lockedAt) < l.lockoutTime {
 			return ErrTooManyAttempts
 		}
 	}
@@ -126,20 +188,20 @@ func (l *LoginRateLimiter) RecordFailure(key string) {
 
 	attempt, exists := l.attempts[key]
 	if !exists {
-		attempt = &loginAttempt{lastReset: time.Now()}
+		attempt = &loginAttempt{lastReset: l.clock.Now()}
 		l.attempts[key] = attempt
 	}
 
 	// Reset if enough time has passed
-	if time.Since(attempt.lastReset) > l.lockoutTime {
+	if l.clock.Since(attempt.lastReset) > l.lockoutTime {
 		attempt.count = 0
 		attempt.lockedAt = nil
-		attempt.lastReset = time.Now()
+		attempt.lastReset = l.clock.Now()
 	}
 
 	attempt.count++
 	if attempt.count >= l.maxAttempts {
-		now := time.Now()
+		now := l.clock.Now()
 		attempt.lockedAt = &now
 	}
 }
@@ -150,30 +212,20 @@ func (l *LoginRateLimiter) RecordSuccess(key string) {
 	delete(l.attempts, key)
 }
 
-// ValidatePasswordStrength checks if a password meets minimum requirements
+// ValidatePasswordStrength checks a password against DefaultPasswordPolicy
 func ValidatePasswordStrength(password string) error {
-	if len(password) < 8 {
-		return fmt.Errorf("%w: password must be at least 8 characters", ErrWeakPassword)
-	}
-
-	var hasNumber, hasLetter bool
-	for _, c := range password {
-		if unicode.IsDigit(c) {
-			hasNumber = true
-		}
-		if unicode.IsLetter(c) {
-			hasLetter = true
-		}
-	}
+	return DefaultPasswordPolicy().Validate(password)
+}
 
-	if !hasNumber {
-		return fmt.Errorf("%w: password must contain at least one number", ErrWeakPassword)
-	}
-	if !hasLetter {
-		return fmt.Errorf("%w: password must contain at least one letter", ErrWeakPassword)
-	}
+// PasswordPolicy returns the rules new passwords must satisfy
+func (s *AuthService) PasswordPolicy() PasswordPolicy {
+	return s.passwords
+}
 
-	return nil
+// SetPermissionCache invalidates cached permissions of users promoted,
+// deleted or regrouped by the auth service
+func (s *AuthService) SetPermissionCache(cache *PermissionCache) {
+	s.permCache = cache
 }
 
 // InitSuperAdmin creates or updates the super admin account
@@ -209,6 +261,7 @@ func (s *AuthService) InitSuperAdmin(username, password string) error {
 		if updateErr := s.db.Save(&user).Error; updateErr != nil {
 			return fmt.Errorf("failed to update super admin: %w", updateErr)
 		}
+		s.permCache.InvalidateUser(user.ID)
 		s.logger.Infof("User '%s' promoted to super admin", username)
 	}
 
@@ -218,7 +271,7 @@ func (s *AuthService) InitSuperAdmin(username, password string) error {
 // RegisterUser creates a new user account
 func (s *AuthService) RegisterUser(username, password, email string) (*database.User, error) {
 	// Validate password strength
-	if err := ValidatePasswordStrength(password); err != nil {
+	if err := s.passwords.Validate(password); err != nil {
 		return nil, err
 	}
 
@@ -254,70 +307,100 @@ func (s *AuthService) RegisterUser(username, password, email string) (*database.
 	return user, nil
 }
 
-// LoginUser authenticates a user and returns a JWT token
-func (s *AuthService) LoginUser(username, password string) (string, *database.User, error) {
+// LoginUser authenticates a user and returns an access and refresh token
+func (s *AuthService) LoginUser(username, password string) (*TokenPair, *database.User, error) {
 	// Check rate limiter
 	if err := s.loginLimiter.Check(username); err != nil {
 		s.logger.Warnf("Login blocked for user '%s': too many attempts", username)
-		return "", nil, err
+		return nil, nil, err
 	}
 
 	var user database.User
 	if err := s.db.Where("username = ?", username).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			s.loginLimiter.RecordFailure(username)
-			return "", nil, ErrUserNotFound
+			return nil, nil, ErrUserNotFound
 		}
-		return "", nil, fmt.Errorf("database error: %w", err)
+		return nil, nil, fmt.Errorf("database error: %w", err)
+	}
+
+	// Externally-managed accounts sign in through their provider
+	if user.AuthProvider != "" {
+		s.loginLimiter.RecordFailure(username)
+		return nil, nil, ErrExternalAccount
 	}
 
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
 		s.loginLimiter.RecordFailure(username)
-		return "", nil, ErrInvalidPassword
+		return nil, nil, ErrInvalidPassword
 	}
 
 	// Clear rate limiter on success
 	s.loginLimiter.RecordSuccess(username)
 
-	// Generate JWT token
-	token, err := s.GenerateToken(&user)
+	pair, err := s.GenerateTokenPair(&user)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+		return nil, nil, fmt.Errorf("failed to generate token: %w", err)
 	}
 
 	s.logger.Infof("User '%s' logged in successfully", username)
-	return token, &user, nil
+	return pair, &user, nil
 }
 
-// GenerateToken generates a JWT token for a user
+// GenerateToken generates a long-lived JWT token for a user. Prefer
+// GenerateTokenPair, whose access tokens expire quickly.
 func (s *AuthService) GenerateToken(user *database.User) (string, error) {
+	token, _, err := s.signToken(user, s.jwtExpire)
+	return token, err
+}
+
+// signToken signs an access token with a random jti
+func (s *AuthService) signToken(user *database.User, lifetime time.Duration) (string, time.Time, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
 	now := time.Now()
+	expiresAt := now.Add(lifetime)
 	claims := JWTClaims{
 		UserID:       user.ID,
 		Username:     user.Username,
 		IsSuperAdmin: user.IsSuperAdmin,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(now.Add(s.jwtExpire)),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(now),
 			NotBefore: jwt.NewNumericDate(now),
-			Issuer:    "nanolink-server",
+			Issuer:    s.jwtIssuer,
 			Subject:   fmt.Sprintf("%d", user.ID),
+			ID:        jti,
 		},
 	}
+	if s.jwtAudience != "" {
+		claims.Audience = jwt.ClaimStrings{s.jwtAudience}
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.jwtSecret)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.jwtSecret)
+	return token, expiresAt, err
 }
 
-// VerifyToken verifies a JWT token and returns the claims
+// VerifyToken verifies a JWT token and returns the claims. With claim
+// validation on, tokens from another issuer or for another audience are
+// rejected as ErrInvalidToken.
 func (s *AuthService) VerifyToken(tokenString string) (*JWTClaims, error) {
+	var opts []jwt.ParserOption
+	if s.validateClaims {
+		opts = append(opts, jwt.WithIssuer(s.jwtIssuer))
+		if s.jwtAudience != "" {
+			opts = append(opts, jwt.WithAudience(s.jwtAudience))
+		}
+	}
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return s.jwtSecret, nil
-	})
+	}, opts...)
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
@@ -330,6 +413,9 @@ func (s *AuthService) VerifyToken(tokenString string) (*JWTClaims, error) {
 	if !ok || !token.Valid {
 		return nil, ErrInvalidToken
 	}
+	if err := s.checkRevoked(claims); err != nil {
+		return nil, err
+	}
 
 	return claims, nil
 }
@@ -383,13 +469,34 @@ func (s *AuthService) DeleteUser(userID uint) error {
 	if err := s.db.Delete(&database.User{}, userID).Error; err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
+	s.permCache.InvalidateUser(userID)
+
+	// Lock the user out now rather than when their tokens expire
+	if err := s.RevokeAllForUser(userID); err != nil {
+		return fmt.Errorf("failed to revoke user tokens: %w", err)
+	}
 
 	s.logger.Infof("User ID %d deleted", userID)
 	return nil
 }
 
-// UpdatePassword updates a user's password
+// UpdatePassword updates a user's password after checking it against the
+// password policy, and revokes the tokens issued to the user so far
 func (s *AuthService) UpdatePassword(userID uint, newPassword string) error {
+	var user database.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("database error: %w", err)
+	}
+	if user.AuthProvider != "" {
+		return ErrExternalAccount
+	}
+	if err := s.passwords.Validate(newPassword); err != nil {
+		return err
+	}
+
 	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
 	if err != nil {
 		return fmt.Errorf("failed to hash password: %w", err)
@@ -399,6 +506,11 @@ func (s *AuthService) UpdatePassword(userID uint, newPassword string) error {
 		return fmt.Errorf("failed to update password: %w", err)
 	}
 
+	// Sessions opened with the old password end with it
+	if err := s.RevokeAllForUser(userID); err != nil {
+		return fmt.Errorf("failed to revoke user tokens: %w", err)
+	}
+
 	return nil
 }
 
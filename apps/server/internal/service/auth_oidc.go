@@ -0,0 +1,140 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"gorm.io/gorm"
+)
+
+// SetOIDCProvider enables single sign-on through provider alongside local
+// accounts
+func (s *AuthService) SetOIDCProvider(provider *OIDCProvider) {
+	s.oidc = provider
+}
+
+// OIDC returns the single sign-on provider, or nil if none is configured
+func (s *AuthService) OIDC() *OIDCProvider {
+	return s.oidc
+}
+
+// LoginOIDC signs in a user verified by the OIDC provider, provisioning an
+// externally-managed account on first login. NanoLink group membership
+// follows the identity's groups when the provider sends them.
+func (s *AuthService) LoginOIDC(identity *OIDCIdentity) (*TokenPair, *database.User, error) {
+	if s.oidc == nil {
+		return nil, nil, ErrOIDCNotConfigured
+	}
+
+	var user database.User
+	err := s.db.Where("auth_provider = ? AND external_id = ?", AuthProviderOIDC, identity.Subject).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = s.provisionOIDCUser(identity, &user)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if identity.Groups != nil {
+		if err := s.syncOIDCGroups(&user, identity.Groups); err != nil {
+			s.logger.Warnf("Failed to sync groups for OIDC user '%s': %v", user.Username, err)
+		}
+		s.permCache.InvalidateUser(user.ID)
+	}
+
+	pair, err := s.GenerateTokenPair(&user)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	s.logger.Infof("User '%s' logged in through OIDC", user.Username)
+	return pair, &user, nil
+}
+
+// provisionOIDCUser creates the account for a first OIDC login. It has no
+// usable password, and never takes over an existing username.
+func (s *AuthService) provisionOIDCUser(identity *OIDCIdentity, user *database.User) error {
+	var existing database.User
+	if err := s.db.Where("username = ?", identity.Username).First(&existing).Error; err == nil {
+		return fmt.Errorf("%w: %s", ErrUserExists, identity.Username)
+	}
+	unusable, err := randomToken(16)
+	if err != nil {
+		return err
+	}
+
+	*user = database.User{
+		Username:     identity.Username,
+		PasswordHash: "!" + unusable,
+		AuthProvider: AuthProviderOIDC,
+		ExternalID:   identity.Subject,
+	}
+	// Email is unique; leave it empty rather than fail if a local account has it
+	if identity.Email != "" {
+		if err := s.db.Where("email = ?", identity.Email).First(&existing).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+			user.Email = identity.Email
+		}
+	}
+	if err := s.db.Create(user).Error; err != nil {
+		return fmt.Errorf("failed to provision OIDC user: %w", err)
+	}
+	s.logger.Infof("Provisioned OIDC user '%s'", user.Username)
+	return nil
+}
+
+// syncOIDCGroups updates a user's NanoLink groups from the provider's
+// groups. With a group mapping the mapped NanoLink groups are managed
+// entirely by the provider; otherwise the user joins existing groups with
+// matching names and other memberships are left alone.
+func (s *AuthService) syncOIDCGroups(user *database.User, groups []string) error {
+	mapping := s.oidc.GroupMapping()
+	names := groups
+	if len(mapping) > 0 {
+		names = nil
+		for _, g := range groups {
+			if target, ok := mapping[strings.ToLower(g)]; ok {
+				names = append(names, target)
+			}
+		}
+	}
+
+	var member []database.Group
+	if len(names) > 0 {
+		if err := s.db.Where("name IN ?", names).Find(&member).Error; err != nil {
+			return err
+		}
+	}
+	assoc := s.db.Model(user).Association("Groups")
+	if len(member) > 0 {
+		if err := assoc.Append(&member); err != nil {
+			return err
+		}
+	}
+	if len(mapping) == 0 {
+		return nil
+	}
+
+	managed := make([]string, 0, len(mapping))
+	for _, target := range mapping {
+		managed = append(managed, target)
+	}
+	keep := make(map[uint]bool, len(member))
+	for _, g := range member {
+		keep[g.ID] = true
+	}
+	var stale []database.Group
+	if err := s.db.Where("name IN ?", managed).Find(&stale).Error; err != nil {
+		return err
+	}
+	removed := stale[:0]
+	for _, g := range stale {
+		if !keep[g.ID] {
+			removed = append(removed, g)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	return assoc.Delete(&removed)
+}
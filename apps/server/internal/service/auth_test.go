@@ -0,0 +1,136 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+)
+
+func TestLoginRateLimiterLockoutExpiry(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	limiter := NewLoginRateLimiter(3, 5*time.Minute)
+	limiter.SetClock(clock)
+
+	for i := 0; i < 2; i++ {
+		limiter.RecordFailure("alice")
+	}
+	if err := limiter.Check("alice"); err != nil {
+		t.Fatalf("Expected no lockout below the limit, got %v", err)
+	}
+
+	limiter.RecordFailure("alice")
+	if err := limiter.Check("alice"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("Expected lockout after 3 failures, got %v", err)
+	}
+
+	clock.Advance(5*time.Minute - time.Second)
+	if err := limiter.Check("alice"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("Expected lockout to hold just before expiry, got %v", err)
+	}
+
+	clock.Advance(time.Second)
+	if err := limiter.Check("alice"); err != nil {
+		t.Errorf("Expected lockout to expire after 5 minutes, got %v", err)
+	}
+
+	if err := limiter.Check("bob"); err != nil {
+		t.Errorf("Expected other keys to be unaffected, got %v", err)
+	}
+}
+
+func TestLoginRateLimiterFailureWindowResets(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	limiter := NewLoginRateLimiter(3, 5*time.Minute)
+	limiter.SetClock(clock)
+
+	limiter.RecordFailure("alice")
+	limiter.RecordFailure("alice")
+
+	// Old failures fall out of the window and don't count towards a lockout
+	clock.Advance(6 * time.Minute)
+	limiter.RecordFailure("alice")
+	if err := limiter.Check("alice"); err != nil {
+		t.Errorf("Expected failure count to reset after the window, got %v", err)
+	}
+
+	limiter.RecordSuccess("alice")
+	limiter.RecordFailure("alice")
+	limiter.RecordFailure("alice")
+	if err := limiter.Check("alice"); err != nil {
+		t.Errorf("Expected success to clear previous failures, got %v", err)
+	}
+}
+
+func TestMetricsServiceUsesClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	ms := NewMetricsService(zap.NewNop().Sugar())
+	ms.SetClock(clock)
+
+	ms.StoreMetrics("agent-1", &MetricsData{})
+	if got := ms.GetCurrentMetrics("agent-1").Timestamp; !got.Equal(clock.Now()) {
+		t.Errorf("Expected timestamp %v, got %v", clock.Now(), got)
+	}
+}
+
+func newTestAuthService(audience string, validate bool) *AuthService {
+	return NewAuthService(nil, AuthConfig{
+		JWTSecret:         "shared-signing-key",
+		JWTAudience:       audience,
+		JWTValidateClaims: validate,
+	}, zap.NewNop().Sugar())
+}
+
+func TestVerifyTokenChecksAudience(t *testing.T) {
+	user := &database.User{ID: 7, Username: "alice"}
+	nanolink := newTestAuthService("nanolink", true)
+
+	token, err := nanolink.GenerateToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := nanolink.VerifyToken(token)
+	if err != nil {
+		t.Fatalf("Expected a token for the right audience to be accepted, got %v", err)
+	}
+	if claims.Username != "alice" || claims.Issuer != "nanolink-server" || len(claims.Audience) != 1 || claims.Audience[0] != "nanolink" {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+
+	// Another service sharing the signing key issues tokens for its own audience
+	billing := newTestAuthService("billing", true)
+	foreign, err := billing.GenerateToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nanolink.VerifyToken(foreign); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a token for another audience to be rejected, got %v", err)
+	}
+
+	// Tokens from another issuer are rejected too
+	other := NewAuthService(nil, AuthConfig{
+		JWTSecret:   "shared-signing-key",
+		JWTIssuer:   "billing-server",
+		JWTAudience: "nanolink",
+	}, zap.NewNop().Sugar())
+	foreign, err = other.GenerateToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nanolink.VerifyToken(foreign); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a token from another issuer to be rejected, got %v", err)
+	}
+}
+
+func TestVerifyTokenSkipsClaimsByDefault(t *testing.T) {
+	billing := newTestAuthService("billing", false)
+	token, err := billing.GenerateToken(&database.User{ID: 7, Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTestAuthService("nanolink", false).VerifyToken(token); err != nil {
+		t.Errorf("Expected the audience to be ignored without validation, got %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"gorm.io/gorm"
+)
+
+// GenerateTokenPair issues a short-lived access token and a refresh token
+// stored in the database, which RefreshToken exchanges for a new pair
+func (s *AuthService) GenerateTokenPair(user *database.User) (*TokenPair, error) {
+	access, expiresAt, err := s.signToken(user, s.accessExpire)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	record := database.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(refresh),
+		ExpiresAt: time.Now().Add(s.refreshTTL),
+	}
+	if err := s.db.Create(&record).Error; err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return &TokenPair{AccessToken: access, RefreshToken: refresh, ExpiresAt: expiresAt}, nil
+}
+
+// RefreshToken rotates a refresh token: it is consumed and a new access and
+// refresh token are issued. A refresh token can only be used once.
+func (s *AuthService) RefreshToken(refreshToken string) (*TokenPair, *database.User, error) {
+	var record database.RefreshToken
+	if err := s.db.Where("token_hash = ?", hashToken(refreshToken)).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidToken
+		}
+		return nil, nil, fmt.Errorf("database error: %w", err)
+	}
+	// Deleting first means a concurrent refresh with the same token loses
+	result := s.db.Delete(&database.RefreshToken{}, record.ID)
+	if result.Error != nil {
+		return nil, nil, fmt.Errorf("database error: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil, ErrInvalidToken
+	}
+	if time.Now().After(record.ExpiresAt) {
+		return nil, nil, ErrTokenExpired
+	}
+
+	user, err := s.GetUserByID(record.UserID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	pair, err := s.GenerateTokenPair(user)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.purgeExpiredTokens()
+	return pair, user, nil
+}
+
+// RevokeToken denylists an access token until it expires. Expired and
+// already revoked tokens are ignored.
+func (s *AuthService) RevokeToken(tokenString string) error {
+	claims, err := s.VerifyToken(tokenString)
+	if errors.Is(err, ErrTokenExpired) || errors.Is(err, ErrTokenRevoked) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if claims.ID == "" {
+		// Issued before tokens carried an ID; only RevokeAllForUser applies
+		return ErrInvalidToken
+	}
+	now := time.Now()
+	record := database.RevokedToken{JTI: claims.ID, UserID: claims.UserID, RevokedAt: now, ExpiresAt: now.Add(s.jwtExpire)}
+	if claims.ExpiresAt != nil {
+		record.ExpiresAt = claims.ExpiresAt.Time
+	}
+	if err := s.db.Save(&record).Error; err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	s.purgeExpiredTokens()
+	return nil
+}
+
+// RevokeRefreshToken deletes a refresh token so it can no longer be used
+func (s *AuthService) RevokeRefreshToken(refreshToken string) error {
+	return s.db.Where("token_hash = ?", hashToken(refreshToken)).Delete(&database.RefreshToken{}).Error
+}
+
+// RevokeAllForUser revokes every access and refresh token issued to a user
+// so far, e.g. when the user is deleted or disabled
+func (s *AuthService) RevokeAllForUser(userID uint) error {
+	if err := s.db.Where("user_id = ?", userID).Delete(&database.RefreshToken{}).Error; err != nil {
+		return err
+	}
+	now := time.Now()
+	record := database.RevokedToken{
+		JTI:       userRevocationID(userID),
+		UserID:    userID,
+		RevokedAt: now,
+		// Every token issued before now has expired by then
+		ExpiresAt: now.Add(max(s.jwtExpire, s.accessExpire)),
+	}
+	if err := s.db.Save(&record).Error; err != nil {
+		return err
+	}
+	s.logger.Infof("Revoked all tokens of user ID %d", userID)
+	return nil
+}
+
+// checkRevoked rejects tokens on the denylist and tokens issued before
+// their user's tokens were all revoked
+func (s *AuthService) checkRevoked(claims *JWTClaims) error {
+	if s.db == nil {
+		return nil
+	}
+	ids := []string{userRevocationID(claims.UserID)}
+	if claims.ID != "" {
+		ids = append(ids, claims.ID)
+	}
+	var revoked []database.RevokedToken
+	if err := s.db.Where("jti IN ?", ids).Find(&revoked).Error; err != nil {
+		return fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	for _, r := range revoked {
+		if r.JTI == claims.ID {
+			return ErrTokenRevoked
+		}
+		// iat has second precision, so a token issued in the same second
+		// as the revocation is treated as revoked
+		if claims.IssuedAt == nil || !claims.IssuedAt.Time.After(r.RevokedAt.Truncate(time.Second)) {
+			return ErrTokenRevoked
+		}
+	}
+	return nil
+}
+
+// purgeExpiredTokens drops refresh tokens and denylist entries that no
+// longer matter
+func (s *AuthService) purgeExpiredTokens() {
+	now := time.Now()
+	if err := s.db.Where("expires_at < ?", now).Delete(&database.RefreshToken{}).Error; err != nil {
+		s.logger.Warnf("Failed to purge expired refresh tokens: %v", err)
+	}
+	if err := s.db.Where("expires_at < ?", now).Delete(&database.RevokedToken{}).Error; err != nil {
+		s.logger.Warnf("Failed to purge expired revoked tokens: %v", err)
+	}
+}
+
+// userRevocationID is the denylist ID revoking all of a user's tokens
+func userRevocationID(userID uint) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+)
+
+func newTokenTestAuthService(t *testing.T) (*AuthService, *database.User) {
+	t.Helper()
+	db := newTestDB(t)
+	auth := NewAuthService(db, AuthConfig{JWTSecret: "signing-key"}, zap.NewNop().Sugar())
+	user, err := auth.RegisterUser("alice", "correct-horse-1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth, user
+}
+
+func TestRefreshTokenRotates(t *testing.T) {
+	auth, _ := newTokenTestAuthService(t)
+
+	pair, _, err := auth.LoginUser("alice", "correct-horse-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := auth.VerifyToken(pair.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.ID == "" {
+		t.Error("Expected the access token to carry a jti")
+	}
+	if lifetime := claims.ExpiresAt.Sub(claims.IssuedAt.Time); lifetime != 15*time.Minute {
+		t.Errorf("Expected a 15 minute access token, got %s", lifetime)
+	}
+
+	rotated, user, err := auth.RefreshToken(pair.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "alice" || rotated.RefreshToken == pair.RefreshToken {
+		t.Errorf("Expected a new pair for alice, got %+v for %s", rotated, user.Username)
+	}
+	if _, err := auth.VerifyToken(rotated.AccessToken); err != nil {
+		t.Errorf("Expected the new access token to be valid, got %v", err)
+	}
+
+	// A refresh token is single use
+	if _, _, err := auth.RefreshToken(pair.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a used refresh token to be rejected, got %v", err)
+	}
+	if _, _, err := auth.RefreshToken("not-a-token"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected an unknown refresh token to be rejected, got %v", err)
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	auth, _ := newTokenTestAuthService(t)
+
+	first, _, err := auth.LoginUser("alice", "correct-horse-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := auth.LoginUser("alice", "correct-horse-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := auth.RevokeToken(first.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.VerifyToken(first.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Expected the revoked token to be rejected, got %v", err)
+	}
+	if _, err := auth.VerifyToken(second.AccessToken); err != nil {
+		t.Errorf("Expected the other session to stay valid, got %v", err)
+	}
+	// Revoking twice is harmless
+	if err := auth.RevokeToken(first.AccessToken); err != nil {
+		t.Errorf("Expected revoking again to succeed, got %v", err)
+	}
+
+	if err := auth.RevokeRefreshToken(first.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := auth.RefreshToken(first.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected the revoked refresh token to be rejected, got %v", err)
+	}
+}
+
+func TestDeleteUserRevokesAllTokens(t *testing.T) {
+	auth, user := newTokenTestAuthService(t)
+
+	pair, _, err := auth.LoginUser("alice", "correct-horse-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := auth.GenerateToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := auth.DeleteUser(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"access": pair.AccessToken, "long-lived": legacy} {
+		if _, err := auth.VerifyToken(token); !errors.Is(err, ErrTokenRevoked) {
+			t.Errorf("Expected the %s token to be revoked, got %v", name, err)
+		}
+	}
+	if _, _, err := auth.RefreshToken(pair.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected the refresh token to be revoked, got %v", err)
+	}
+}
+
+func TestUpdatePasswordRevokesAllTokens(t *testing.T) {
+	auth, user := newTokenTestAuthService(t)
+
+	pair, _, err := auth.LoginUser("alice", "correct-horse-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.UpdatePassword(user.ID, "battery-staple-2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.VerifyToken(pair.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Expected the access token to be revoked, got %v", err)
+	}
+	if _, _, err := auth.RefreshToken(pair.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected the refresh token to be revoked, got %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+)
+
+// ErrInvalidAutoGroupRule is returned for auto-group rules that cannot be used
+var ErrInvalidAutoGroupRule = errors.New("invalid auto-group rule")
+
+// Auto-group rule types
+const (
+	AutoGroupCIDR = "cidr" // match agents by reported or source IP
+)
+
+type autoGroupRule struct {
+	group      string
+	permission int
+	networks   []*net.IPNet
+}
+
+// matches reports whether any of the IPs falls within the rule's ranges
+func (r autoGroupRule) matches(ips []net.IP) bool {
+	for _, ip := range ips {
+		for _, network := range r.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AutoGrouper assigns connecting agents to groups by configured rules
+type AutoGrouper struct {
+	rules  []autoGroupRule
+	groups *GroupService
+	perms  *PermissionService
+	logger *zap.SugaredLogger
+	// Groups last matched per agent, so static updates that match the same
+	// groups again skip the database
+	matched map[string]string
+	// Serializes group creation and assignment checks
+	mu sync.Mutex
+}
+
+// NewAutoGrouper validates the rules and creates an auto-grouper
+func NewAutoGrouper(rules []config.AutoGroupRule, groups *GroupService, perms *PermissionService, logger *zap.SugaredLogger) (*AutoGrouper, error) {
+	g := &AutoGrouper{groups: groups, perms: perms, logger: logger, matched: make(map[string]string)}
+	for i, rule := range rules {
+		if strings.TrimSpace(rule.Group) == "" {
+			return nil, fmt.Errorf("%w: rule %d has no group", ErrInvalidAutoGroupRule, i)
+		}
+		if rule.Permission < database.PermissionReadOnly || rule.Permission > database.PermissionSystemAdmin {
+			return nil, fmt.Errorf("%w: rule for %q has invalid permission %d", ErrInvalidAutoGroupRule, rule.Group, rule.Permission)
+		}
+		if rule.Type != "" && rule.Type != AutoGroupCIDR {
+			return nil, fmt.Errorf("%w: rule for %q has unknown type %q", ErrInvalidAutoGroupRule, rule.Group, rule.Type)
+		}
+		if len(rule.CIDRs) == 0 {
+			return nil, fmt.Errorf("%w: rule for %q has no CIDRs", ErrInvalidAutoGroupRule, rule.Group)
+		}
+
+		parsed := autoGroupRule{group: strings.TrimSpace(rule.Group), permission: rule.Permission}
+		for _, cidr := range rule.CIDRs {
+			_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+			if err != nil {
+				return nil, fmt.Errorf("%w: rule for %q: %v", ErrInvalidAutoGroupRule, rule.Group, err)
+			}
+			parsed.networks = append(parsed.networks, network)
+		}
+		g.rules = append(g.rules, parsed)
+	}
+	return g, nil
+}
+
+// Match returns the groups whose rules match any of the IPs. IPs may be
+// plain addresses or interface addresses in CIDR form; unparsable ones are
+// ignored.
+func (g *AutoGrouper) Match(ips ...string) []string {
+	parsed := parseIPs(ips)
+	if len(parsed) == 0 {
+		return nil
+	}
+	var groups []string
+	for _, rule := range g.rules {
+		if rule.matches(parsed) {
+			groups = append(groups, rule.group)
+		}
+	}
+	return groups
+}
+
+// Assign places an agent into every group whose rule matches any of its IPs
+// and returns the groups it was newly added to. Existing assignments are
+// left alone so permission levels set by an admin are not overwritten. The
+// agent's assignments are only checked again once its IPs match other
+// groups.
+func (g *AutoGrouper) Assign(agentID string, ips ...string) ([]string, error) {
+	parsed := parseIPs(ips)
+	if len(parsed) == 0 {
+		return nil, nil
+	}
+	var rules []autoGroupRule
+	var names []string
+	for _, rule := range g.rules {
+		if rule.matches(parsed) {
+			rules = append(rules, rule)
+			names = append(names, rule.group)
+		}
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	matched := strings.Join(names, "\n")
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.matched[agentID] == matched {
+		return nil, nil
+	}
+
+	current, err := g.perms.GetAgentGroups(agentID)
+	if err != nil {
+		return nil, err
+	}
+	member := make(map[uint]bool, len(current))
+	for _, ag := range current {
+		member[ag.GroupID] = true
+	}
+
+	var assigned []string
+	for _, rule := range rules {
+		group, err := g.groupByName(rule.group)
+		if err != nil {
+			return assigned, err
+		}
+		if member[group.ID] {
+			continue
+		}
+		if err := g.perms.AssignAgentToGroup(agentID, group.ID, rule.permission); err != nil {
+			return assigned, err
+		}
+		assigned = append(assigned, rule.group)
+	}
+	g.matched[agentID] = matched
+	return assigned, nil
+}
+
+// Forget drops what is remembered about an agent's groups, e.g. when it is
+// deregistered
+func (g *AutoGrouper) Forget(agentID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.matched, agentID)
+}
+
+// groupByName returns the named group, creating it on first use
+func (g *AutoGrouper) groupByName(name string) (*database.Group, error) {
+	group, err := g.groups.GetGroupByName(name)
+	if errors.Is(err, ErrGroupNotFound) {
+		return g.groups.CreateGroup(name, "Created by auto-group rule")
+	}
+	return group, err
+}
+
+// parseIPs parses addresses such as "10.0.1.5", "10.0.1.5/24" or "fe80::1%eth0"
+func parseIPs(raw []string) []net.IP {
+	ips := make([]net.IP, 0, len(raw))
+	for _, s := range raw {
+		s = strings.TrimSpace(s)
+		if i := strings.IndexByte(s, '%'); i >= 0 {
+			s = s[:i]
+		}
+		if i := strings.IndexByte(s, '/'); i >= 0 {
+			s = s[:i]
+		}
+		if ip := net.ParseIP(s); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
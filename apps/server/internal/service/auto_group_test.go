@@ -0,0 +1,132 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func newTestAutoGrouper(t *testing.T, rules []config.AutoGroupRule) (*AutoGrouper, *PermissionService) {
+	t.Helper()
+	db := newTestDB(t)
+	logger := zap.NewNop().Sugar()
+	perms := NewPermissionService(db, logger)
+	grouper, err := NewAutoGrouper(rules, NewGroupService(db, logger), perms, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return grouper, perms
+}
+
+func TestAutoGrouperAssignsBySubnet(t *testing.T) {
+	grouper, perms := newTestAutoGrouper(t, []config.AutoGroupRule{
+		{Group: "office", CIDRs: []string{"10.0.1.0/24"}},
+		{Group: "lab", Type: AutoGroupCIDR, CIDRs: []string{"192.168.50.0/24", "fd00:50::/64"}, Permission: database.PermissionBasicWrite},
+	})
+
+	assigned, err := grouper.Assign("agent-in", "10.0.1.17")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assigned) != 1 || assigned[0] != "office" {
+		t.Fatalf("Expected agent to be assigned to office, got %v", assigned)
+	}
+	groups, err := perms.GetAgentGroups("agent-in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Group.Name != "office" || groups[0].PermissionLevel != database.PermissionReadOnly {
+		t.Errorf("Expected a read-only office assignment, got %+v", groups)
+	}
+
+	assigned, err = grouper.Assign("agent-out", "10.0.2.17", "172.16.0.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assigned) != 0 {
+		t.Errorf("Expected agent outside all ranges not to be grouped, got %v", assigned)
+	}
+	if groups, _ := perms.GetAgentGroups("agent-out"); len(groups) != 0 {
+		t.Errorf("Expected no group assignments, got %d", len(groups))
+	}
+}
+
+func TestAutoGrouperMatchesAnyIP(t *testing.T) {
+	grouper, perms := newTestAutoGrouper(t, []config.AutoGroupRule{
+		{Group: "lab", CIDRs: []string{"192.168.50.0/24", "fd00:50::/64"}, Permission: database.PermissionBasicWrite},
+	})
+
+	// Source IP outside, one reported interface address inside
+	assigned, err := grouper.Assign("agent-1", "203.0.113.9", "fe80::1%eth0", "fd00:50::12/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assigned) != 1 || assigned[0] != "lab" {
+		t.Fatalf("Expected agent to be assigned to lab, got %v", assigned)
+	}
+
+	// Reconnecting does not reassign or overwrite an admin's change
+	groups, _ := perms.GetAgentGroups("agent-1")
+	if err := perms.AssignAgentToGroup("agent-1", groups[0].GroupID, database.PermissionServiceControl); err != nil {
+		t.Fatal(err)
+	}
+	if assigned, _ := grouper.Assign("agent-1", "192.168.50.3"); len(assigned) != 0 {
+		t.Errorf("Expected no new assignment on reconnect, got %v", assigned)
+	}
+	groups, _ = perms.GetAgentGroups("agent-1")
+	if groups[0].PermissionLevel != database.PermissionServiceControl {
+		t.Errorf("Expected admin-set level to be kept, got %d", groups[0].PermissionLevel)
+	}
+}
+
+func TestAutoGroupSkipsUnchangedMatchesAndVolatileIDs(t *testing.T) {
+	grouper, perms := newTestAutoGrouper(t, []config.AutoGroupRule{
+		{Group: "office", CIDRs: []string{"10.0.1.0/24"}},
+	})
+	var queries int
+	if err := perms.db.Callback().Query().After("gorm:query").Register("count_queries", func(*gorm.DB) { queries++ }); err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.NewNop().Sugar()
+	agents := NewAgentService(logger, NewMetricsService(logger))
+	agents.SetAutoGrouper(grouper)
+
+	agents.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "web-1"}, 0)
+	agents.AutoGroup("agent-1", "10.0.1.17")
+	if groups, _ := perms.GetAgentGroups("agent-1"); len(groups) != 1 {
+		t.Fatalf("Expected agent-1 in the office group, got %+v", groups)
+	}
+
+	// Static updates matching the same groups do not touch the database
+	queries = 0
+	agents.AutoGroup("agent-1", "10.0.1.17", "10.0.1.18")
+	if queries != 0 {
+		t.Errorf("Expected no queries for unchanged matches, got %d", queries)
+	}
+
+	// An ID assigned per connection would leave a row behind on reconnect
+	ws := agents.RegisterAgent(nil, AgentInfo{Hostname: "web-2"}, 0)
+	agents.AutoGroup(ws.ID, "10.0.1.19")
+	if groups, _ := perms.GetAgentGroups(ws.ID); len(groups) != 0 {
+		t.Errorf("Expected no assignment for a server-assigned ID, got %+v", groups)
+	}
+}
+
+func TestNewAutoGrouperRejectsInvalidRules(t *testing.T) {
+	invalid := []config.AutoGroupRule{
+		{Group: "", CIDRs: []string{"10.0.0.0/8"}},
+		{Group: "a", CIDRs: []string{"10.0.0.0/33"}},
+		{Group: "a"},
+		{Group: "a", Type: "hostname", CIDRs: []string{"10.0.0.0/8"}},
+		{Group: "a", CIDRs: []string{"10.0.0.0/8"}, Permission: 4},
+	}
+	for _, rule := range invalid {
+		if _, err := NewAutoGrouper([]config.AutoGroupRule{rule}, nil, nil, zap.NewNop().Sugar()); !errors.Is(err, ErrInvalidAutoGroupRule) {
+			t.Errorf("Expected ErrInvalidAutoGroupRule for %+v, got %v", rule, err)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownCommand is returned for command types without a template
+var ErrUnknownCommand = errors.New("unknown command type")
+
+// ErrInvalidCommand is returned for commands whose target or params do not
+// match their template
+var ErrInvalidCommand = errors.New("invalid command")
+
+// Value shapes shared by the built-in templates. Targets handed to service
+// managers, docker and process tools never contain shell metacharacters.
+var (
+	serviceNamePattern   = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+	containerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+	processPattern       = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`) // name or PID
+	signalPattern        = regexp.MustCompile(`^[A-Z0-9]+$`)
+	// Absolute POSIX or Windows path, which may contain spaces; a segment
+	// may start with one dot but ".." is rejected
+	filePathPattern = regexp.MustCompile(`^([a-zA-Z]:)?([\\/]\.?[a-zA-Z0-9_@+()-][a-zA-Z0-9._@+() -]*)+$`)
+	linesPattern    = regexp.MustCompile(`^[0-9]{1,6}$`)
+	limitPattern    = regexp.MustCompile(`^[0-9]{1,4}$`)
+	timePattern     = regexp.MustCompile(`^[a-zA-Z0-9 :+._-]+$`)
+	packagePattern  = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9.+_:~-]*$`)
+	urlPattern      = regexp.MustCompile(`^https?://[^\s"'<>` + "`" + `]+$`)
+	checksumPattern = regexp.MustCompile(`^(sha256:)?[a-fA-F0-9]{32,128}$`)
+	boolPattern     = regexp.MustCompile(`^(true|false)$`)
+	formatPattern   = regexp.MustCompile(`^[a-z]+$`)
+)
+
+// CommandParam describes a command's target or one of its params
+type CommandParam struct {
+	Name     string
+	Required bool
+	// Values must match; nil accepts any value
+	Pattern *regexp.Regexp
+}
+
+// CommandTemplate is a command type dashboards may send, with the target
+// and params it accepts
+type CommandTemplate struct {
+	Type string // Command type name, e.g. SERVICE_RESTART
+	// Nil when the command takes no target
+	Target *CommandParam
+	Params []CommandParam
+}
+
+// CommandTemplates is the registry commands are checked against before
+// being sent to an agent. Command types without a template are rejected.
+type CommandTemplates struct {
+	mu        sync.RWMutex
+	templates map[string]CommandTemplate
+}
+
+// NewCommandTemplates creates an empty registry
+func NewCommandTemplates() *CommandTemplates {
+	return &CommandTemplates{templates: make(map[string]CommandTemplate)}
+}
+
+// DefaultCommandTemplates creates a registry with templates for the
+// commands agents understand
+func DefaultCommandTemplates() *CommandTemplates {
+	r := NewCommandTemplates()
+	target := func(name string, pattern *regexp.Regexp) *CommandParam {
+		return &CommandParam{Name: name, Required: true, Pattern: pattern}
+	}
+	optional := func(name string, pattern *regexp.Regexp) CommandParam {
+		return CommandParam{Name: name, Pattern: pattern}
+	}
+	required := func(name string, pattern *regexp.Regexp) CommandParam {
+		return CommandParam{Name: name, Required: true, Pattern: pattern}
+	}
+
+	for _, t := range []CommandTemplate{
+		{Type: "PROCESS_LIST"},
+		{Type: "PROCESS_KILL", Target: target("process", processPattern), Params: []CommandParam{optional("signal", signalPattern)}},
+
+		{Type: "SERVICE_START", Target: target("service", serviceNamePattern)},
+		{Type: "SERVICE_STOP", Target: target("service", serviceNamePattern)},
+		{Type: "SERVICE_RESTART", Target: target("service", serviceNamePattern)},
+		{Type: "SERVICE_STATUS", Target: target("service", serviceNamePattern)},
+
+		{Type: "FILE_TAIL", Target: target("path", filePathPattern), Params: []CommandParam{optional("lines", linesPattern)}},
+		{Type: "FILE_DOWNLOAD", Target: target("path", filePathPattern)},
+		{Type: "FILE_UPLOAD", Target: target("path", filePathPattern), Params: []CommandParam{required("content", nil)}},
+		{Type: "FILE_TRUNCATE", Target: target("path", filePathPattern)},
+
+		{Type: "DOCKER_LIST"},
+		{Type: "DOCKER_START", Target: target("container", containerNamePattern)},
+		{Type: "DOCKER_STOP", Target: target("container", containerNamePattern)},
+		{Type: "DOCKER_RESTART", Target: target("container", containerNamePattern)},
+		{Type: "DOCKER_LOGS", Target: target("container", containerNamePattern), Params: []CommandParam{optional("lines", linesPattern)}},
+
+		{Type: "SYSTEM_REBOOT"},
+		// The target is the shell command itself, guarded by the super token
+		{Type: "SHELL_EXECUTE", Target: target("command", nil)},
+
+		{Type: "AGENT_CHECK_UPDATE"},
+		{Type: "AGENT_DOWNLOAD_UPDATE", Params: []CommandParam{required("url", urlPattern), optional("checksum", checksumPattern)}},
+		{Type: "AGENT_APPLY_UPDATE", Params: []CommandParam{required("path", filePathPattern)}},
+		{Type: "AGENT_GET_VERSION"},
+
+		{Type: "SERVICE_LOGS", Params: []CommandParam{
+			required("service", serviceNamePattern), optional("lines", linesPattern),
+			optional("since", timePattern), optional("until", timePattern), optional("filter", nil),
+		}},
+		{Type: "SYSTEM_LOGS", Params: []CommandParam{
+			optional("path", filePathPattern), optional("lines", linesPattern), optional("filter", nil),
+		}},
+		{Type: "AUDIT_LOGS", Params: []CommandParam{
+			optional("lines", linesPattern), optional("since", timePattern), optional("filter", nil),
+		}},
+		{Type: "LOG_STREAM", Params: []CommandParam{
+			optional("service", serviceNamePattern), optional("path", filePathPattern), optional("filter", nil),
+		}},
+
+		{Type: "PACKAGE_LIST", Params: []CommandParam{optional("filter", nil), optional("limit", limitPattern)}},
+		{Type: "PACKAGE_CHECK_UPDATES", Params: []CommandParam{optional("filter", nil)}},
+		{Type: "PACKAGE_UPDATE", Params: []CommandParam{required("package", packagePattern)}},
+		{Type: "SYSTEM_UPDATE"},
+
+		{Type: "SCRIPT_LIST", Params: []CommandParam{optional("filter", nil)}},
+		// Scripts are predefined on the agent, which passes args without a shell
+		{Type: "SCRIPT_EXECUTE", Params: []CommandParam{required("name", serviceNamePattern), optional("args", nil)}},
+
+		{Type: "CONFIG_READ", Params: []CommandParam{required("path", filePathPattern), optional("sanitize", boolPattern)}},
+		{Type: "CONFIG_WRITE", Params: []CommandParam{required("path", filePathPattern), required("content", nil)}},
+		{Type: "CONFIG_VALIDATE", Params: []CommandParam{
+			optional("path", filePathPattern), optional("content", nil), optional("format", formatPattern),
+		}},
+		{Type: "CONFIG_ROLLBACK", Params: []CommandParam{required("path", filePathPattern)}},
+		{Type: "CONFIG_LIST_BACKUPS", Params: []CommandParam{required("path", filePathPattern)}},
+	} {
+		r.Register(t)
+	}
+	return r
+}
+
+// Register adds or replaces the template for t.Type
+func (r *CommandTemplates) Register(t CommandTemplate) {
+	t.Type = strings.ToUpper(t.Type)
+	r.mu.Lock()
+	r.templates[t.Type] = t
+	r.mu.Unlock()
+}
+
+// Get returns the template for a command type
+func (r *CommandTemplates) Get(cmdType string) (CommandTemplate, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.templates[strings.ToUpper(cmdType)]
+	return t, ok
+}
+
+// Validate checks a command against its template. The error wraps
+// ErrUnknownCommand or ErrInvalidCommand and names what failed.
+func (r *CommandTemplates) Validate(cmdType, target string, params map[string]string) error {
+	t, ok := r.Get(cmdType)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownCommand, cmdType)
+	}
+
+	if t.Target == nil {
+		if target != "" {
+			return fmt.Errorf("%w: %s takes no target", ErrInvalidCommand, t.Type)
+		}
+	} else if err := t.Target.check(t.Type, target, target != ""); err != nil {
+		return err
+	}
+
+	allowed := make(map[string]CommandParam, len(t.Params))
+	for _, p := range t.Params {
+		allowed[p.Name] = p
+		value, present := params[p.Name]
+		if err := p.check(t.Type, value, present); err != nil {
+			return err
+		}
+	}
+
+	// Sorted, so the same command always reports the same param
+	var unknown []string
+	for name := range params {
+		if _, ok := allowed[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("%w: %s does not accept param %q", ErrInvalidCommand, t.Type, unknown[0])
+	}
+	return nil
+}
+
+func (p CommandParam) check(cmdType, value string, present bool) error {
+	if !present {
+		if p.Required {
+			return fmt.Errorf("%w: %s requires %s", ErrInvalidCommand, cmdType, p.Name)
+		}
+		return nil
+	}
+	if p.Pattern != nil && !p.Pattern.MatchString(value) {
+		return fmt.Errorf("%w: %s %s %q does not match %s", ErrInvalidCommand, cmdType, p.Name, value, p.Pattern)
+	}
+	return nil
+}
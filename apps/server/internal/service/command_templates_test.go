@@ -0,0 +1,77 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCommandTemplatesValidate(t *testing.T) {
+	templates := DefaultCommandTemplates()
+
+	for _, tc := range []struct {
+		name    string
+		cmdType string
+		target  string
+		params  map[string]string
+		wantErr error
+		detail  string
+	}{
+		{name: "valid restart", cmdType: "SERVICE_RESTART", target: "nginx.service"},
+		{name: "lower-case type", cmdType: "service_restart", target: "nginx"},
+		{name: "valid tail", cmdType: "FILE_TAIL", target: "/var/log/.hidden/app.log", params: map[string]string{"lines": "200"}},
+		{name: "path with spaces", cmdType: "FILE_TAIL", target: "/srv/My Logs/app.log"},
+		{name: "windows path", cmdType: "CONFIG_READ", params: map[string]string{"path": `C:\Program Files (x86)\App\app.ini`}},
+		{name: "package limit", cmdType: "PACKAGE_LIST", params: map[string]string{"filter": "lib", "limit": "50"}},
+		{name: "valid kill", cmdType: "PROCESS_KILL", target: "4242", params: map[string]string{"signal": "TERM"}},
+		{name: "unknown type", cmdType: "FORMAT_DISK", wantErr: ErrUnknownCommand},
+		{name: "shell in service", cmdType: "SERVICE_RESTART", target: "nginx; rm -rf /", wantErr: ErrInvalidCommand, detail: "service"},
+		{name: "subshell in container", cmdType: "DOCKER_STOP", target: "$(reboot)", wantErr: ErrInvalidCommand, detail: "container"},
+		{name: "pipe in process", cmdType: "PROCESS_KILL", target: "sshd|true", wantErr: ErrInvalidCommand, detail: "process"},
+		{name: "path traversal", cmdType: "FILE_DOWNLOAD", target: "/var/log/../../etc/shadow", wantErr: ErrInvalidCommand, detail: "path"},
+		{name: "windows path traversal", cmdType: "FILE_DOWNLOAD", target: `C:\logs\..\Windows\win.ini`, wantErr: ErrInvalidCommand, detail: "path"},
+		{name: "relative windows path", cmdType: "FILE_DOWNLOAD", target: `C:logs\app.log`, wantErr: ErrInvalidCommand, detail: "path"},
+		{name: "unimplemented type", cmdType: "HEALTH_CHECK", wantErr: ErrUnknownCommand},
+		{name: "missing target", cmdType: "DOCKER_RESTART", wantErr: ErrInvalidCommand, detail: "requires container"},
+		{name: "unexpected target", cmdType: "SYSTEM_REBOOT", target: "now", wantErr: ErrInvalidCommand, detail: "no target"},
+		{name: "missing param", cmdType: "PACKAGE_UPDATE", wantErr: ErrInvalidCommand, detail: "requires package"},
+		{name: "bad param", cmdType: "FILE_TAIL", target: "/var/log/syslog", params: map[string]string{"lines": "10 && id"}, wantErr: ErrInvalidCommand, detail: "lines"},
+		{name: "unknown param", cmdType: "SERVICE_STATUS", target: "nginx", params: map[string]string{"unit": "x"}, wantErr: ErrInvalidCommand, detail: `"unit"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := templates.Validate(tc.cmdType, tc.target, tc.params)
+			if tc.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Expected the command to be accepted, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Expected %v, got %v", tc.wantErr, err)
+			}
+			if !strings.Contains(err.Error(), tc.detail) {
+				t.Errorf("Expected the error to mention %q, got %v", tc.detail, err)
+			}
+		})
+	}
+}
+
+func TestCommandTemplatesRegister(t *testing.T) {
+	templates := NewCommandTemplates()
+	if err := templates.Validate("SERVICE_RESTART", "nginx", nil); !errors.Is(err, ErrUnknownCommand) {
+		t.Fatalf("Expected an empty registry to reject everything, got %v", err)
+	}
+
+	// Allow systemd template units on this registry only
+	templates.Register(CommandTemplate{
+		Type:   "service_restart",
+		Target: &CommandParam{Name: "service", Required: true, Pattern: regexp.MustCompile(`^[a-zA-Z0-9@._-]+$`)},
+	})
+	if err := templates.Validate("SERVICE_RESTART", "getty@tty1.service", nil); err != nil {
+		t.Errorf("Expected the registered template to be used, got %v", err)
+	}
+	if err := DefaultCommandTemplates().Validate("SERVICE_RESTART", "getty@tty1.service", nil); !errors.Is(err, ErrInvalidCommand) {
+		t.Errorf("Expected the default template to be unaffected, got %v", err)
+	}
+}
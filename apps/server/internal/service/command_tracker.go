@@ -0,0 +1,232 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"go.uber.org/zap"
+)
+
+// Command record states
+const (
+	CommandStatusPending   = "pending"
+	CommandStatusSucceeded = "succeeded"
+	CommandStatusFailed    = "failed"
+)
+
+// ErrCommandNotFound is returned for a command that was never recorded
+var ErrCommandNotFound = errors.New("command not found")
+
+// CommandRecord tracks a command dispatched to an agent. When metrics
+// snapshots are enabled it also records the agent's metrics right before
+// dispatch and a while after completion, to show the command's impact.
+type CommandRecord struct {
+	CommandID   string     `json:"commandId"`
+	AgentID     string     `json:"agentId"`
+	Type        string     `json:"type"`
+	Target      string     `json:"target,omitempty"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	Output      string     `json:"output,omitempty"` // Masked before it is stored
+	IssuedAt    time.Time  `json:"issuedAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+
+	PreSnapshot         *MetricsData `json:"preSnapshot,omitempty"`
+	PostSnapshot        *MetricsData `json:"postSnapshot,omitempty"`
+	PostSnapshotPending bool         `json:"postSnapshotPending,omitempty"`
+}
+
+// CommandTracker keeps recent command records in memory. With an audit
+// service, snapshots are also kept on the commands' audit entries so they
+// outlive eviction and restarts.
+type CommandTracker struct {
+	metricsService *MetricsService
+	snapshots      bool
+	postDelay      time.Duration
+	maxRecords     int
+
+	records map[string]*CommandRecord
+	order   []string
+	masker  *OutputMasker
+	audit   *AuditService
+	mu      sync.Mutex
+
+	clock     Clock
+	afterFunc func(time.Duration, func())
+	logger    *zap.SugaredLogger
+}
+
+// NewCommandTracker creates a command tracker
+func NewCommandTracker(metricsService *MetricsService, cfg config.CommandsConfig, logger *zap.SugaredLogger) *CommandTracker {
+	maxRecords := cfg.MaxRecords
+	if maxRecords <= 0 {
+		maxRecords = 1000
+	}
+	return &CommandTracker{
+		metricsService: metricsService,
+		snapshots:      cfg.SnapshotMetrics,
+		postDelay:      time.Duration(cfg.PostSnapshotDelaySec) * time.Second,
+		maxRecords:     maxRecords,
+		records:        make(map[string]*CommandRecord),
+		clock:          RealClock,
+		afterFunc:      func(d time.Duration, f func()) { time.AfterFunc(d, f) },
+		logger:         logger,
+	}
+}
+
+// SetClock replaces the time source (for tests)
+func (t *CommandTracker) SetClock(clock Clock) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clock = clock
+}
+
+// SetOutputMasker sets how command output and errors are masked before
+// they are recorded
+func (t *CommandTracker) SetOutputMasker(masker *OutputMasker) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.masker = masker
+}
+
+// SetAuditService keeps command snapshots on audit entries, and looks up
+// commands no longer held in memory there
+func (t *CommandTracker) SetAuditService(audit *AuditService) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.audit = audit
+}
+
+// Begin records a command that is about to be dispatched, taking the pre
+// snapshot when enabled
+func (t *CommandTracker) Begin(agentID, commandID, commandType, target string) {
+	record := &CommandRecord{
+		CommandID: commandID,
+		AgentID:   agentID,
+		Type:      commandType,
+		Target:    target,
+		Status:    CommandStatusPending,
+	}
+	if t.snapshots {
+		record.PreSnapshot = t.metricsService.SnapshotMetrics(agentID)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	record.IssuedAt = t.clock.Now()
+	if _, exists := t.records[commandID]; !exists {
+		t.order = append(t.order, commandID)
+	}
+	t.records[commandID] = record
+
+	// Evict the oldest records
+	for len(t.order) > t.maxRecords {
+		delete(t.records, t.order[0])
+		t.order = t.order[1:]
+	}
+	t.persistSnapshots(agentID, commandID, record.PreSnapshot, nil)
+}
+
+// persistSnapshots stores snapshots on the command's audit entry. Callers
+// hold t.mu.
+func (t *CommandTracker) persistSnapshots(agentID, commandID string, pre, post *MetricsData) {
+	if t.audit == nil || (pre == nil && post == nil) {
+		return
+	}
+	if err := t.audit.RecordSnapshots(agentID, commandID, pre, post); err != nil {
+		t.logger.Warnf("Failed to store snapshots of command %s: %v", commandID, err)
+	}
+}
+
+// Discard drops the record of a command that could not be dispatched
+func (t *CommandTracker) Discard(commandID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, exists := t.records[commandID]; !exists {
+		return
+	}
+	delete(t.records, commandID)
+	for i, id := range t.order {
+		if id == commandID {
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Complete records a command's result and schedules the post snapshot
+func (t *CommandTracker) Complete(agentID, commandID string, success bool, errMsg, output string) {
+	t.mu.Lock()
+	record := t.records[commandID]
+	if record == nil || record.AgentID != agentID {
+		t.mu.Unlock()
+		return
+	}
+
+	now := t.clock.Now()
+	record.CompletedAt = &now
+	record.Error = t.masker.Mask(record.Type, errMsg)
+	record.Output = t.masker.Mask(record.Type, output)
+	record.Status = CommandStatusFailed
+	if success {
+		record.Status = CommandStatusSucceeded
+	}
+	record.PostSnapshotPending = t.snapshots
+	t.mu.Unlock()
+
+	if !t.snapshots {
+		return
+	}
+	if t.postDelay <= 0 {
+		t.capturePostSnapshot(commandID)
+		return
+	}
+	t.afterFunc(t.postDelay, func() { t.capturePostSnapshot(commandID) })
+}
+
+func (t *CommandTracker) capturePostSnapshot(commandID string) {
+	t.mu.Lock()
+	record := t.records[commandID]
+	t.mu.Unlock()
+	if record == nil {
+		return
+	}
+
+	snapshot := t.metricsService.SnapshotMetrics(record.AgentID)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	record.PostSnapshot = snapshot
+	record.PostSnapshotPending = false
+	t.persistSnapshots(record.AgentID, commandID, nil, snapshot)
+	t.logger.Debugf("Captured post-command snapshot for %s on agent %s", commandID, record.AgentID)
+}
+
+// Get returns a copy of a command record, falling back to the audit log
+// for commands no longer held in memory
+func (t *CommandTracker) Get(commandID string) (*CommandRecord, bool) {
+	t.mu.Lock()
+	record, audit := t.records[commandID], t.audit
+	if record != nil {
+		copied := *record
+		t.mu.Unlock()
+		return &copied, true
+	}
+	t.mu.Unlock()
+
+	if audit == nil {
+		return nil, false
+	}
+	stored, err := audit.CommandRecord(commandID)
+	if err != nil {
+		if !errors.Is(err, ErrCommandNotFound) {
+			t.logger.Warnf("Failed to look up command %s: %v", commandID, err)
+		}
+		return nil, false
+	}
+	return stored, true
+}
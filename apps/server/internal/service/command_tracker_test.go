@@ -0,0 +1,137 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"go.uber.org/zap"
+)
+
+func TestCommandRecordIncludesSnapshots(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	ms := NewMetricsService(logger)
+	ms.StoreMetrics("agent-1", &MetricsData{CPU: CPUData{UsagePercent: 95}, Disks: []DiskData{{Device: "sda", ReadBytesPS: 500}}})
+
+	tracker := NewCommandTracker(ms, config.CommandsConfig{SnapshotMetrics: true, PostSnapshotDelaySec: 10}, logger)
+	var scheduled time.Duration
+	var pending func()
+	tracker.afterFunc = func(d time.Duration, f func()) {
+		scheduled = d
+		pending = f
+	}
+
+	tracker.Begin("agent-1", "cmd-1", "SERVICE_RESTART", "nginx")
+
+	// Metrics change while the command runs
+	ms.MergeRealtimeMetrics("agent-1", &RealtimeUpdate{
+		CPUUsage: 20,
+		DiskIO:   []DiskData{{Device: "sda", ReadBytesPS: 50}},
+	})
+	tracker.Complete("agent-1", "cmd-1", true, "", "")
+
+	record, ok := tracker.Get("cmd-1")
+	if !ok {
+		t.Fatal("Expected command record")
+	}
+	if record.Status != CommandStatusSucceeded || record.CompletedAt == nil {
+		t.Errorf("Expected completed record, got %+v", record)
+	}
+	if !record.PostSnapshotPending || record.PostSnapshot != nil {
+		t.Error("Expected post snapshot to wait for the delay")
+	}
+	if scheduled != 10*time.Second || pending == nil {
+		t.Fatalf("Expected post snapshot scheduled after 10s, got %v", scheduled)
+	}
+
+	pending()
+
+	record, _ = tracker.Get("cmd-1")
+	if record.PreSnapshot == nil || record.PostSnapshot == nil {
+		t.Fatalf("Expected pre and post snapshots, got %+v", record)
+	}
+	if record.PostSnapshotPending {
+		t.Error("Expected post snapshot to be captured")
+	}
+	if record.PreSnapshot.CPU.UsagePercent != 95 || record.PreSnapshot.Disks[0].ReadBytesPS != 500 {
+		t.Errorf("Expected pre snapshot to be unaffected by later merges, got %+v", record.PreSnapshot)
+	}
+	if record.PostSnapshot.CPU.UsagePercent != 20 || record.PostSnapshot.Disks[0].ReadBytesPS != 50 {
+		t.Errorf("Expected post snapshot to reflect the new state, got %+v", record.PostSnapshot)
+	}
+}
+
+func TestCommandTrackerWithoutSnapshots(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	ms := NewMetricsService(logger)
+	ms.StoreMetrics("agent-1", &MetricsData{})
+
+	tracker := NewCommandTracker(ms, config.CommandsConfig{MaxRecords: 2}, logger)
+	tracker.Begin("agent-1", "cmd-1", "PROCESS_LIST", "")
+	tracker.Complete("agent-1", "cmd-1", false, "permission denied", "")
+
+	record, _ := tracker.Get("cmd-1")
+	if record.Status != CommandStatusFailed || record.Error != "permission denied" {
+		t.Errorf("Expected failed record, got %+v", record)
+	}
+	if record.PreSnapshot != nil || record.PostSnapshot != nil || record.PostSnapshotPending {
+		t.Errorf("Expected no snapshots, got %+v", record)
+	}
+
+	// Results from another agent are ignored
+	tracker.Begin("agent-1", "cmd-2", "PROCESS_LIST", "")
+	tracker.Complete("agent-2", "cmd-2", true, "", "")
+	if record, _ := tracker.Get("cmd-2"); record.Status != CommandStatusPending {
+		t.Errorf("Expected cmd-2 to stay pending, got %s", record.Status)
+	}
+
+	// Oldest records are evicted
+	tracker.Begin("agent-1", "cmd-3", "PROCESS_LIST", "")
+	if _, ok := tracker.Get("cmd-1"); ok {
+		t.Error("Expected cmd-1 to be evicted")
+	}
+
+	tracker.Discard("cmd-3")
+	if _, ok := tracker.Get("cmd-3"); ok {
+		t.Error("Expected discarded record to be gone")
+	}
+}
+
+func TestCommandSnapshotsOutliveTheTracker(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	ms := NewMetricsService(logger)
+	ms.StoreMetrics("agent-1", &MetricsData{CPU: CPUData{UsagePercent: 95}})
+	audit := NewAuditService(newTestDB(t), logger)
+
+	tracker := NewCommandTracker(ms, config.CommandsConfig{SnapshotMetrics: true}, logger)
+	tracker.SetAuditService(audit)
+	if err := audit.LogCommand(AuditEntry{AgentID: "agent-1", CommandID: "cmd-1", CommandType: "SERVICE_RESTART", Target: "nginx"}); err != nil {
+		t.Fatal(err)
+	}
+	tracker.Begin("agent-1", "cmd-1", "SERVICE_RESTART", "nginx")
+	ms.MergeRealtimeMetrics("agent-1", &RealtimeUpdate{CPUUsage: 20})
+	if err := audit.CompleteCommand("agent-1", "cmd-1", true, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	tracker.Complete("agent-1", "cmd-1", true, "", "")
+
+	// A restarted server only has the audit log
+	restarted := NewCommandTracker(ms, config.CommandsConfig{SnapshotMetrics: true}, logger)
+	restarted.SetAuditService(audit)
+	record, ok := restarted.Get("cmd-1")
+	if !ok {
+		t.Fatal("Expected the command record from the audit log")
+	}
+	if record.AgentID != "agent-1" || record.Status != CommandStatusSucceeded || record.CompletedAt == nil {
+		t.Errorf("Expected a completed record for agent-1, got %+v", record)
+	}
+	if record.PreSnapshot == nil || record.PreSnapshot.CPU.UsagePercent != 95 {
+		t.Errorf("Expected the stored pre snapshot, got %+v", record.PreSnapshot)
+	}
+	if record.PostSnapshot == nil || record.PostSnapshot.CPU.UsagePercent != 20 {
+		t.Errorf("Expected the stored post snapshot, got %+v", record.PostSnapshot)
+	}
+	if _, ok := restarted.Get("cmd-unknown"); ok {
+		t.Error("Expected no record for an unknown command")
+	}
+}
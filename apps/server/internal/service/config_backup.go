@@ -0,0 +1,459 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ConfigExportVersion is the current format version of exported configuration
+const ConfigExportVersion = 1
+
+// ConfigExport is a portable snapshot of the access-control configuration.
+// Users and groups are referenced by name rather than ID so the document can
+// be restored into a fresh database.
+type ConfigExport struct {
+	Version         int                      `json:"version"`
+	ExportedAt      time.Time                `json:"exportedAt"`
+	Groups          []ExportedGroup          `json:"groups"`
+	AgentGroups     []ExportedAgentGroup     `json:"agentGroups"`
+	UserPermissions []ExportedUserPermission `json:"userPermissions"`
+	Roles           []ExportedRole           `json:"roles"`
+}
+
+// ExportedGroup is a group in a configuration export
+type ExportedGroup struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ExportedAgentGroup is an agent-group assignment in a configuration export
+type ExportedAgentGroup struct {
+	AgentID         string `json:"agentId"`
+	Group           string `json:"group"`
+	PermissionLevel int    `json:"permissionLevel"`
+	Capabilities    int    `json:"capabilities,omitempty"`
+}
+
+// ExportedUserPermission is a direct user-agent grant in a configuration export
+type ExportedUserPermission struct {
+	Username        string `json:"username"`
+	AgentID         string `json:"agentId"`
+	PermissionLevel int    `json:"permissionLevel"`
+	Capabilities    int    `json:"capabilities,omitempty"`
+	GrantedBy       string `json:"grantedBy,omitempty"`
+}
+
+// ExportedRole describes a user's role: super admin flag and group memberships
+type ExportedRole struct {
+	Username     string   `json:"username"`
+	IsSuperAdmin bool     `json:"isSuperAdmin"`
+	Groups       []string `json:"groups"`
+}
+
+// ConfigChange describes a single change applied (or planned) by an import
+type ConfigChange struct {
+	Kind   string `json:"kind"`   // group, agent_group, user_permission, role
+	Action string `json:"action"` // create, update, unchanged
+	Key    string `json:"key"`
+}
+
+// ConfigImportResult summarizes an import run
+type ConfigImportResult struct {
+	DryRun  bool           `json:"dryRun"`
+	Applied bool           `json:"applied"`
+	Changes []ConfigChange `json:"changes"`
+}
+
+// Config import errors
+var (
+	ErrUnsupportedExportVersion = errors.New("unsupported config export version")
+	ErrInvalidConfigReference   = errors.New("invalid reference in config import")
+)
+
+// errDryRunRollback aborts the import transaction after changes were computed
+var errDryRunRollback = errors.New("dry run rollback")
+
+// ConfigBackupService exports and restores access-control configuration
+type ConfigBackupService struct {
+	db     *gorm.DB
+	logger *zap.SugaredLogger
+	cache  *PermissionCache
+}
+
+// NewConfigBackupService creates a new config backup service
+func NewConfigBackupService(db *gorm.DB, logger *zap.SugaredLogger) *ConfigBackupService {
+	return &ConfigBackupService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// SetPermissionCache invalidates cached permissions when an import
+// changes groups or grants
+func (s *ConfigBackupService) SetPermissionCache(cache *PermissionCache) {
+	s.cache = cache
+}
+
+// Export returns the current groups, agent-group assignments, user-agent
+// permissions and user roles
+func (s *ConfigBackupService) Export() (*ConfigExport, error) {
+	var groups []database.Group
+	if err := s.db.Order("name").Find(&groups).Error; err != nil {
+		return nil, fmt.Errorf("failed to load groups: %w", err)
+	}
+	groupNames := make(map[uint]string, len(groups))
+	export := &ConfigExport{
+		Version:         ConfigExportVersion,
+		ExportedAt:      time.Now(),
+		Groups:          make([]ExportedGroup, 0, len(groups)),
+		AgentGroups:     make([]ExportedAgentGroup, 0),
+		UserPermissions: make([]ExportedUserPermission, 0),
+		Roles:           make([]ExportedRole, 0),
+	}
+	for _, g := range groups {
+		groupNames[g.ID] = g.Name
+		export.Groups = append(export.Groups, ExportedGroup{Name: g.Name, Description: g.Description})
+	}
+
+	var users []database.User
+	if err := s.db.Preload("Groups").Order("username").Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to load users: %w", err)
+	}
+	usernames := make(map[uint]string, len(users))
+	for _, u := range users {
+		usernames[u.ID] = u.Username
+		role := ExportedRole{
+			Username:     u.Username,
+			IsSuperAdmin: u.IsSuperAdmin,
+			Groups:       make([]string, 0, len(u.Groups)),
+		}
+		for _, g := range u.Groups {
+			role.Groups = append(role.Groups, g.Name)
+		}
+		export.Roles = append(export.Roles, role)
+	}
+
+	var agentGroups []database.AgentGroup
+	if err := s.db.Order("agent_id, group_id").Find(&agentGroups).Error; err != nil {
+		return nil, fmt.Errorf("failed to load agent groups: %w", err)
+	}
+	for _, ag := range agentGroups {
+		name, ok := groupNames[ag.GroupID]
+		if !ok {
+			continue // orphaned assignment
+		}
+		export.AgentGroups = append(export.AgentGroups, ExportedAgentGroup{
+			AgentID:         ag.AgentID,
+			Group:           name,
+			PermissionLevel: ag.PermissionLevel,
+			Capabilities:    ag.Capabilities,
+		})
+	}
+
+	var perms []database.UserAgentPermission
+	if err := s.db.Order("user_id, agent_id").Find(&perms).Error; err != nil {
+		return nil, fmt.Errorf("failed to load user permissions: %w", err)
+	}
+	for _, p := range perms {
+		name, ok := usernames[p.UserID]
+		if !ok {
+			continue // orphaned permission
+		}
+		export.UserPermissions = append(export.UserPermissions, ExportedUserPermission{
+			Username:        name,
+			AgentID:         p.AgentID,
+			PermissionLevel: p.PermissionLevel,
+			Capabilities:    p.Capabilities,
+			GrantedBy:       usernames[p.GrantedBy],
+		})
+	}
+
+	return export, nil
+}
+
+// Import restores configuration from an export inside a single transaction.
+// Existing entries are updated and missing ones created; nothing is deleted.
+// Users are not created by import and must already exist. With dryRun the
+// transaction is rolled back and only the planned changes are reported.
+func (s *ConfigBackupService) Import(export *ConfigExport, dryRun bool) (*ConfigImportResult, error) {
+	if export.Version != ConfigExportVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedExportVersion, export.Version)
+	}
+
+	result := &ConfigImportResult{DryRun: dryRun, Changes: make([]ConfigChange, 0)}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.validateReferences(tx, export); err != nil {
+			return err
+		}
+
+		groupIDs, err := s.importGroups(tx, export.Groups, result)
+		if err != nil {
+			return err
+		}
+
+		userIDs := make(map[string]uint)
+		var users []database.User
+		if err := tx.Find(&users).Error; err != nil {
+			return fmt.Errorf("database error: %w", err)
+		}
+		for _, u := range users {
+			userIDs[u.Username] = u.ID
+		}
+
+		if err := s.importAgentGroups(tx, export.AgentGroups, groupIDs, result); err != nil {
+			return err
+		}
+		if err := s.importUserPermissions(tx, export.UserPermissions, userIDs, result); err != nil {
+			return err
+		}
+		if err := s.importRoles(tx, export.Roles, userIDs, groupIDs, result); err != nil {
+			return err
+		}
+
+		if dryRun {
+			return errDryRunRollback
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRunRollback) {
+		return nil, err
+	}
+
+	result.Applied = !dryRun
+	if !dryRun {
+		s.cache.InvalidateAll()
+		s.logger.Infof("Configuration imported: %d entries processed", len(result.Changes))
+	}
+	return result, nil
+}
+
+// validateReferences checks that every group and user referenced by the
+// export exists either in the export itself or in the database
+func (s *ConfigBackupService) validateReferences(tx *gorm.DB, export *ConfigExport) error {
+	groups := make(map[string]bool)
+	for _, g := range export.Groups {
+		if g.Name == "" {
+			return fmt.Errorf("%w: group with empty name", ErrInvalidConfigReference)
+		}
+		groups[g.Name] = true
+	}
+	var existingGroups []database.Group
+	if err := tx.Find(&existingGroups).Error; err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	for _, g := range existingGroups {
+		groups[g.Name] = true
+	}
+
+	users := make(map[string]bool)
+	var existingUsers []database.User
+	if err := tx.Find(&existingUsers).Error; err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	for _, u := range existingUsers {
+		users[u.Username] = true
+	}
+
+	for _, ag := range export.AgentGroups {
+		if ag.AgentID == "" {
+			return fmt.Errorf("%w: agent group with empty agent ID", ErrInvalidConfigReference)
+		}
+		if !groups[ag.Group] {
+			return fmt.Errorf("%w: unknown group %q for agent %s", ErrInvalidConfigReference, ag.Group, ag.AgentID)
+		}
+		if ag.PermissionLevel < 0 || ag.PermissionLevel > 3 {
+			return ErrInvalidPermissionLevel
+		}
+		if !validCapabilities(ag.Capabilities) {
+			return ErrInvalidCapabilities
+		}
+	}
+	for _, p := range export.UserPermissions {
+		if p.AgentID == "" {
+			return fmt.Errorf("%w: user permission with empty agent ID", ErrInvalidConfigReference)
+		}
+		if !users[p.Username] {
+			return fmt.Errorf("%w: unknown user %q", ErrInvalidConfigReference, p.Username)
+		}
+		if p.GrantedBy != "" && !users[p.GrantedBy] {
+			return fmt.Errorf("%w: unknown granting user %q", ErrInvalidConfigReference, p.GrantedBy)
+		}
+		if p.PermissionLevel < 0 || p.PermissionLevel > 3 {
+			return ErrInvalidPermissionLevel
+		}
+		if !validCapabilities(p.Capabilities) {
+			return ErrInvalidCapabilities
+		}
+	}
+	for _, r := range export.Roles {
+		if !users[r.Username] {
+			return fmt.Errorf("%w: unknown user %q", ErrInvalidConfigReference, r.Username)
+		}
+		for _, g := range r.Groups {
+			if !groups[g] {
+				return fmt.Errorf("%w: unknown group %q for user %s", ErrInvalidConfigReference, g, r.Username)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *ConfigBackupService) importGroups(tx *gorm.DB, groups []ExportedGroup, result *ConfigImportResult) (map[string]uint, error) {
+	for _, g := range groups {
+		var existing database.Group
+		err := tx.Where("name = ?", g.Name).First(&existing).Error
+		switch {
+		case err == nil:
+			if existing.Description == g.Description {
+				result.add("group", "unchanged", g.Name)
+				continue
+			}
+			existing.Description = g.Description
+			if err := tx.Save(&existing).Error; err != nil {
+				return nil, fmt.Errorf("failed to update group: %w", err)
+			}
+			result.add("group", "update", g.Name)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if err := tx.Create(&database.Group{Name: g.Name, Description: g.Description}).Error; err != nil {
+				return nil, fmt.Errorf("failed to create group: %w", err)
+			}
+			result.add("group", "create", g.Name)
+		default:
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+	}
+
+	var all []database.Group
+	if err := tx.Find(&all).Error; err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	ids := make(map[string]uint, len(all))
+	for _, g := range all {
+		ids[g.Name] = g.ID
+	}
+	return ids, nil
+}
+
+func (s *ConfigBackupService) importAgentGroups(tx *gorm.DB, assignments []ExportedAgentGroup, groupIDs map[string]uint, result *ConfigImportResult) error {
+	for _, ag := range assignments {
+		key := ag.AgentID + "/" + ag.Group
+		groupID := groupIDs[ag.Group]
+
+		var existing database.AgentGroup
+		err := tx.Where("agent_id = ? AND group_id = ?", ag.AgentID, groupID).First(&existing).Error
+		switch {
+		case err == nil:
+			if existing.PermissionLevel == ag.PermissionLevel && existing.Capabilities == ag.Capabilities {
+				result.add("agent_group", "unchanged", key)
+				continue
+			}
+			existing.PermissionLevel = ag.PermissionLevel
+			existing.Capabilities = ag.Capabilities
+			if err := tx.Save(&existing).Error; err != nil {
+				return fmt.Errorf("failed to update agent-group assignment: %w", err)
+			}
+			result.add("agent_group", "update", key)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if err := tx.Create(&database.AgentGroup{
+				AgentID:         ag.AgentID,
+				GroupID:         groupID,
+				PermissionLevel: ag.PermissionLevel,
+				Capabilities:    ag.Capabilities,
+			}).Error; err != nil {
+				return fmt.Errorf("failed to create agent-group assignment: %w", err)
+			}
+			result.add("agent_group", "create", key)
+		default:
+			return fmt.Errorf("database error: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *ConfigBackupService) importUserPermissions(tx *gorm.DB, perms []ExportedUserPermission, userIDs map[string]uint, result *ConfigImportResult) error {
+	for _, p := range perms {
+		key := p.Username + "/" + p.AgentID
+		userID := userIDs[p.Username]
+		grantedBy := userIDs[p.GrantedBy]
+
+		var existing database.UserAgentPermission
+		err := tx.Where("user_id = ? AND agent_id = ?", userID, p.AgentID).First(&existing).Error
+		switch {
+		case err == nil:
+			if existing.PermissionLevel == p.PermissionLevel && existing.Capabilities == p.Capabilities && existing.GrantedBy == grantedBy {
+				result.add("user_permission", "unchanged", key)
+				continue
+			}
+			existing.PermissionLevel = p.PermissionLevel
+			existing.Capabilities = p.Capabilities
+			existing.GrantedBy = grantedBy
+			if err := tx.Save(&existing).Error; err != nil {
+				return fmt.Errorf("failed to update permission: %w", err)
+			}
+			result.add("user_permission", "update", key)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if err := tx.Create(&database.UserAgentPermission{
+				UserID:          userID,
+				AgentID:         p.AgentID,
+				PermissionLevel: p.PermissionLevel,
+				Capabilities:    p.Capabilities,
+				GrantedBy:       grantedBy,
+			}).Error; err != nil {
+				return fmt.Errorf("failed to create permission: %w", err)
+			}
+			result.add("user_permission", "create", key)
+		default:
+			return fmt.Errorf("database error: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *ConfigBackupService) importRoles(tx *gorm.DB, roles []ExportedRole, userIDs map[string]uint, groupIDs map[string]uint, result *ConfigImportResult) error {
+	for _, r := range roles {
+		var user database.User
+		if err := tx.Preload("Groups").First(&user, userIDs[r.Username]).Error; err != nil {
+			return fmt.Errorf("database error: %w", err)
+		}
+
+		changed := false
+		if user.IsSuperAdmin != r.IsSuperAdmin {
+			if err := tx.Model(&user).Update("is_super_admin", r.IsSuperAdmin).Error; err != nil {
+				return fmt.Errorf("failed to update user role: %w", err)
+			}
+			changed = true
+		}
+
+		member := make(map[string]bool, len(user.Groups))
+		for _, g := range user.Groups {
+			member[g.Name] = true
+		}
+		for _, name := range r.Groups {
+			if member[name] {
+				continue
+			}
+			group := database.Group{ID: groupIDs[name]}
+			if err := tx.Model(&group).Association("Users").Append(&user); err != nil {
+				return fmt.Errorf("failed to add user to group: %w", err)
+			}
+			changed = true
+		}
+
+		if changed {
+			result.add("role", "update", r.Username)
+		} else {
+			result.add("role", "unchanged", r.Username)
+		}
+	}
+	return nil
+}
+
+func (r *ConfigImportResult) add(kind, action, key string) {
+	r.Changes = append(r.Changes, ConfigChange{Kind: kind, Action: action, Key: key})
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB opens an isolated in-memory SQLite database with the schema migrated
+func newTestDB(t testing.TB) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("Failed to get sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(
+		&database.User{},
+		&database.Group{},
+		&database.AgentGroup{},
+		&database.UserAgentPermission{},
+		&database.AuditLog{},
+		&database.AgentToken{},
+		&database.HardwareBaseline{},
+		&database.RefreshToken{},
+		&database.RevokedToken{},
+		&database.RetentionPolicy{},
+		&database.AgentInventory{},
+	); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	return db
+}
+
+func seedBackupFixtures(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	logger := zap.NewNop().Sugar()
+	groups := NewGroupService(db, logger)
+	perms := NewPermissionService(db, logger)
+
+	admin := &database.User{Username: "admin", PasswordHash: "x", Email: "admin@example.com", IsSuperAdmin: true}
+	alice := &database.User{Username: "alice", PasswordHash: "x", Email: "alice@example.com"}
+	if err := db.Create(admin).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(alice).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	ops, err := groups.CreateGroup("ops", "Operations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := groups.AddUserToGroup(alice.ID, ops.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := perms.AssignAgentToGroup("agent-1", ops.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := perms.SetUserAgentPermission(alice.ID, "agent-2", 1, admin.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigExportImportRoundTrip(t *testing.T) {
+	src := newTestDB(t)
+	seedBackupFixtures(t, src)
+	logger := zap.NewNop().Sugar()
+
+	export, err := NewConfigBackupService(src, logger).Export()
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if len(export.Groups) != 1 || len(export.AgentGroups) != 1 || len(export.UserPermissions) != 1 || len(export.Roles) != 2 {
+		t.Fatalf("Unexpected export contents: %+v", export)
+	}
+
+	// Restore into a fresh database that only has the user accounts
+	dst := newTestDB(t)
+	for _, u := range []database.User{
+		{Username: "admin", PasswordHash: "x", Email: "admin@example.com"},
+		{Username: "alice", PasswordHash: "x", Email: "alice@example.com"},
+	} {
+		u := u
+		if err := dst.Create(&u).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backup := NewConfigBackupService(dst, logger)
+	result, err := backup.Import(export, false)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if !result.Applied {
+		t.Error("Expected import to be applied")
+	}
+
+	restored, err := backup.Export()
+	if err != nil {
+		t.Fatalf("Export after import failed: %v", err)
+	}
+
+	if restored.Groups[0] != export.Groups[0] {
+		t.Errorf("Group mismatch: %+v vs %+v", restored.Groups[0], export.Groups[0])
+	}
+	if restored.AgentGroups[0] != export.AgentGroups[0] {
+		t.Errorf("Agent group mismatch: %+v vs %+v", restored.AgentGroups[0], export.AgentGroups[0])
+	}
+	if restored.UserPermissions[0] != export.UserPermissions[0] {
+		t.Errorf("User permission mismatch: %+v vs %+v", restored.UserPermissions[0], export.UserPermissions[0])
+	}
+	for i, role := range export.Roles {
+		got := restored.Roles[i]
+		if got.Username != role.Username || got.IsSuperAdmin != role.IsSuperAdmin || len(got.Groups) != len(role.Groups) {
+			t.Errorf("Role mismatch: %+v vs %+v", got, role)
+		}
+	}
+
+	// Re-importing the same document is a no-op
+	again, err := backup.Import(export, false)
+	if err != nil {
+		t.Fatalf("Second import failed: %v", err)
+	}
+	for _, c := range again.Changes {
+		if c.Action != "unchanged" {
+			t.Errorf("Expected no changes on re-import, got %+v", c)
+		}
+	}
+}
+
+func TestConfigImportDryRun(t *testing.T) {
+	db := newTestDB(t)
+	seedBackupFixtures(t, db)
+	backup := NewConfigBackupService(db, zap.NewNop().Sugar())
+
+	export, err := backup.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	export.Groups = append(export.Groups, ExportedGroup{Name: "dev", Description: "Developers"})
+	export.AgentGroups = append(export.AgentGroups, ExportedAgentGroup{AgentID: "agent-3", Group: "dev", PermissionLevel: 0})
+
+	result, err := backup.Import(export, true)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if result.Applied || !result.DryRun {
+		t.Errorf("Expected dry run result, got %+v", result)
+	}
+
+	created := map[string]bool{}
+	for _, c := range result.Changes {
+		if c.Action == "create" {
+			created[c.Kind+":"+c.Key] = true
+		}
+	}
+	if !created["group:dev"] || !created["agent_group:agent-3/dev"] {
+		t.Errorf("Expected planned creations to be reported, got %+v", result.Changes)
+	}
+
+	var count int64
+	db.Model(&database.Group{}).Where("name = ?", "dev").Count(&count)
+	if count != 0 {
+		t.Error("Dry run must not create groups")
+	}
+	db.Model(&database.AgentGroup{}).Where("agent_id = ?", "agent-3").Count(&count)
+	if count != 0 {
+		t.Error("Dry run must not create agent assignments")
+	}
+}
+
+func TestConfigImportRejectsDanglingReferences(t *testing.T) {
+	db := newTestDB(t)
+	seedBackupFixtures(t, db)
+	backup := NewConfigBackupService(db, zap.NewNop().Sugar())
+
+	tests := []struct {
+		name   string
+		export *ConfigExport
+	}{
+		{
+			name: "unknown group",
+			export: &ConfigExport{
+				Version:     ConfigExportVersion,
+				AgentGroups: []ExportedAgentGroup{{AgentID: "agent-9", Group: "missing"}},
+			},
+		},
+		{
+			name: "unknown user",
+			export: &ConfigExport{
+				Version:         ConfigExportVersion,
+				UserPermissions: []ExportedUserPermission{{Username: "nobody", AgentID: "agent-9"}},
+			},
+		},
+		{
+			name: "unsupported version",
+			export: &ConfigExport{
+				Version: 99,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := backup.Import(tt.export, false); err == nil {
+				t.Error("Expected import to fail")
+			}
+		})
+	}
+
+	var count int64
+	db.Model(&database.AgentGroup{}).Where("agent_id = ?", "agent-9").Count(&count)
+	if count != 0 {
+		t.Error("Failed import must not leave partial changes")
+	}
+}
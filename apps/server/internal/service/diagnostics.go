@@ -0,0 +1,248 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+)
+
+// Redacted replaces secrets in diagnostics bundles
+const Redacted = "[REDACTED]"
+
+// Config keys whose string values are redacted, matched case-insensitively
+// as substrings of the field name. Every value in a map under such a key,
+// such as request headers, is redacted whatever its own key.
+var secretKeyParts = []string{"secret", "password", "token", "apikey", "api_key", "bearer", "credential", "privatekey", "hash", "header", "cookie"}
+
+// DiagnosticsService builds support bundles describing the server's
+// configuration and state, with secrets removed
+type DiagnosticsService struct {
+	cfg     *config.Config
+	agents  *AgentService
+	metrics *MetricsService
+	events  *ServerEventBus
+	logs    *RecentLogs
+	version string
+	started time.Time
+	clock   Clock
+}
+
+// NewDiagnosticsService creates a diagnostics service for a server running
+// version with configuration cfg
+func NewDiagnosticsService(cfg *config.Config, agents *AgentService, metrics *MetricsService, version string) *DiagnosticsService {
+	return &DiagnosticsService{
+		cfg:     cfg,
+		agents:  agents,
+		metrics: metrics,
+		version: version,
+		started: RealClock.Now(),
+		clock:   RealClock,
+	}
+}
+
+// SetServerEvents includes recent server events in bundles
+func (s *DiagnosticsService) SetServerEvents(bus *ServerEventBus) {
+	s.events = bus
+}
+
+// SetRecentLogs includes recent warnings and errors in bundles
+func (s *DiagnosticsService) SetRecentLogs(logs *RecentLogs) {
+	s.logs = logs
+}
+
+// SetClock replaces the time source (for tests)
+func (s *DiagnosticsService) SetClock(clock Clock) {
+	s.clock = clock
+	s.started = clock.Now()
+}
+
+// WriteBundle writes a zip archive to w with one JSON file each for the
+// version, sanitized config, server stats, connected agents, recent server
+// events and recent errors. Files are written as they are built, so w can
+// be a response being streamed.
+func (s *DiagnosticsService) WriteBundle(w io.Writer) error {
+	cfg, secrets := SanitizeConfig(s.cfg)
+	// Longest first, so a secret containing another is scrubbed whole
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	files := []struct {
+		name string
+		data func() interface{}
+	}{
+		{"version.json", s.versionInfo},
+		{"config.json", func() interface{} { return cfg }},
+		{"stats.json", s.stats},
+		{"agents.json", s.agentSummary},
+		{"events.json", s.recentEvents},
+		{"errors.json", s.recentErrors},
+	}
+
+	zw := zip.NewWriter(w)
+	now := s.clock.Now()
+	for _, f := range files {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(f.data()); err != nil {
+			return fmt.Errorf("failed to encode %s: %w", f.name, err)
+		}
+		data := buf.Bytes()
+		// Secrets can still turn up in log messages or labels
+		for _, secret := range secrets {
+			data = bytes.ReplaceAll(data, []byte(secret), []byte(Redacted))
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (s *DiagnosticsService) versionInfo() interface{} {
+	return map[string]interface{}{
+		"version":    s.version,
+		"goVersion":  runtime.Version(),
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+		"instanceId": s.agents.InstanceID(),
+		"startedAt":  s.started,
+		"generated":  s.clock.Now(),
+	}
+}
+
+func (s *DiagnosticsService) stats() interface{} {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := map[string]interface{}{
+		"uptimeSec":       int64(s.clock.Now().Sub(s.started).Seconds()),
+		"goroutines":      runtime.NumGoroutine(),
+		"heapAllocBytes":  mem.HeapAlloc,
+		"sysBytes":        mem.Sys,
+		"gcCycles":        mem.NumGC,
+		"connectedAgents": s.agents.GetAgentCount(),
+		"streams":         s.agents.StreamStats().Totals(),
+		"metrics":         s.metrics.GetSummary(),
+	}
+	if s.events != nil {
+		stats["degraded"] = s.events.Degraded()
+	}
+	return stats
+}
+
+func (s *DiagnosticsService) agentSummary() interface{} {
+	agents := s.agents.GetAllAgents()
+	summary := make([]map[string]interface{}, 0, len(agents))
+	for _, a := range agents {
+		summary = append(summary, map[string]interface{}{
+			"id":              a.ID,
+			"hostname":        a.Hostname,
+			"os":              a.OS,
+			"arch":            a.Arch,
+			"version":         a.Version,
+			"permissionLevel": a.PermissionLevel,
+			"connectedAt":     a.ConnectedAt,
+			"lastHeartbeat":   a.LastHeartbeat,
+			"instanceId":      a.InstanceID,
+		})
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		return summary[i]["id"].(string) < summary[j]["id"].(string)
+	})
+	return summary
+}
+
+func (s *DiagnosticsService) recentEvents() interface{} {
+	if s.events == nil {
+		return []ServerEvent{}
+	}
+	return s.events.Recent(200)
+}
+
+func (s *DiagnosticsService) recentErrors() interface{} {
+	if s.logs == nil {
+		return []LogEntry{}
+	}
+	return s.logs.Entries()
+}
+
+// SanitizeConfig converts cfg to a generic map with the values of
+// secret-looking fields redacted and credentials stripped from URLs. It
+// also returns the redacted values so they can be scrubbed elsewhere.
+func SanitizeConfig(cfg interface{}) (map[string]interface{}, []string) {
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}, nil
+	}
+	var generic map[string]interface{}
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return map[string]interface{}{"error": err.Error()}, nil
+	}
+	var secrets []string
+	sanitized, _ := sanitizeValue(generic, false, &secrets).(map[string]interface{})
+	return sanitized, secrets
+}
+
+func sanitizeValue(v interface{}, secret bool, secrets *[]string) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			val[k] = sanitizeValue(child, secret || isSecretKey(k), secrets)
+		}
+		return val
+	case []interface{}:
+		for i, child := range val {
+			val[i] = sanitizeValue(child, secret, secrets)
+		}
+		return val
+	case string:
+		if val == "" {
+			return val
+		}
+		if secret {
+			// Short values would scrub unrelated text
+			if len(val) >= 4 {
+				*secrets = append(*secrets, val)
+			}
+			return Redacted
+		}
+		return sanitizeURL(val, secrets)
+	}
+	return v
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, part := range secretKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeURL reduces URLs to their scheme and host, since webhook and
+// database credentials often live in the user info, path or query
+func sanitizeURL(value string, secrets *[]string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return value
+	}
+	if u.User == nil && (u.Path == "" || u.Path == "/") && u.RawQuery == "" {
+		return value
+	}
+	*secrets = append(*secrets, value)
+	return u.Scheme + "://" + u.Host + "/" + Redacted
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"go.uber.org/zap"
+)
+
+func TestDiagnosticsBundleRedactsSecrets(t *testing.T) {
+	secrets := []string{
+		"jwt-signing-secret",
+		"admin-Passw0rd",
+		"agent-token-abc123",
+		"db-password-xyz",
+		"hooks-path-secret",
+		"Basic b3RlbC1jb2xsZWN0b3I=",
+		"tenant-7f3a9c",
+		config.HashToken("issued-agent-token"),
+	}
+	cfg := config.Default()
+	cfg.JWT.Secret = secrets[0]
+	cfg.SuperAdmin.Password = secrets[1]
+	cfg.Auth.Tokens = []config.TokenConfig{{Token: secrets[2], Permission: 2}}
+	cfg.Database.Password = secrets[3]
+	cfg.Webhooks.Lifecycle.URL = "https://cmdb.example.com/" + secrets[4] + "?key=1"
+	// Header names say nothing about whether their values are secret
+	cfg.Metrics.OTel.Headers = map[string]string{"Authorization": secrets[5], "X-Scope-OrgID": secrets[6]}
+	cfg.Auth.Tokens = append(cfg.Auth.Tokens, config.TokenConfig{ID: 1, Hash: secrets[7]})
+
+	logs := NewRecentLogs(10)
+	logger := zap.New(logs.Core()).Sugar()
+	ms := NewMetricsService(logger)
+	as := NewAgentService(logger, ms)
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "web-1", Version: "0.4.1"}, 2)
+	events := NewServerEventBus(10, logger)
+	events.Publish(ServerEventMassDisconnect, "critical", "3 agents disconnected", nil)
+	// A secret leaking into a log message is scrubbed too
+	logger.Errorf("Rejected agent token %s", secrets[2])
+	logger.Info("Not captured")
+
+	diagnostics := NewDiagnosticsService(cfg, as, ms, "0.4.1")
+	diagnostics.SetServerEvents(events)
+	diagnostics.SetRecentLogs(logs)
+	diagnostics.SetClock(NewFakeClock(time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)))
+
+	var buf bytes.Buffer
+	if err := diagnostics.WriteBundle(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(data)
+	}
+	for _, name := range []string{"version.json", "config.json", "stats.json", "agents.json", "events.json", "errors.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("Expected %s in the bundle, got %d files", name, len(files))
+		}
+	}
+	for name, content := range files {
+		for _, secret := range secrets {
+			if strings.Contains(content, secret) {
+				t.Errorf("Expected %s to be redacted from %s", secret, name)
+			}
+		}
+	}
+
+	var sanitized config.Config
+	if err := json.Unmarshal([]byte(files["config.json"]), &sanitized); err != nil {
+		t.Fatal(err)
+	}
+	if sanitized.JWT.Secret != Redacted || sanitized.Auth.Tokens[0].Token != Redacted || sanitized.Database.Password != Redacted {
+		t.Errorf("Expected secret fields to read %s, got %+v", Redacted, sanitized.JWT)
+	}
+	if sanitized.Auth.Tokens[0].Permission != 2 || sanitized.Server.HTTPPort != cfg.Server.HTTPPort {
+		t.Error("Expected non-secret settings to be kept")
+	}
+	if sanitized.Webhooks.Lifecycle.URL != "https://cmdb.example.com/"+Redacted {
+		t.Errorf("Expected the webhook URL to be reduced to its host, got %q", sanitized.Webhooks.Lifecycle.URL)
+	}
+
+	if !strings.Contains(files["agents.json"], `"hostname": "web-1"`) {
+		t.Errorf("Expected the connected agent to be listed, got %s", files["agents.json"])
+	}
+	if !strings.Contains(files["events.json"], "3 agents disconnected") {
+		t.Errorf("Expected recent server events, got %s", files["events.json"])
+	}
+	if !strings.Contains(files["errors.json"], "Rejected agent token "+Redacted) || strings.Contains(files["errors.json"], "Not captured") {
+		t.Errorf("Expected only warnings and errors, scrubbed, got %s", files["errors.json"])
+	}
+	if !strings.Contains(files["version.json"], `"version": "0.4.1"`) {
+		t.Errorf("Expected version info, got %s", files["version.json"])
+	}
+}
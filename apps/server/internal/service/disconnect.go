@@ -0,0 +1,229 @@
+package service
+
+import "time"
+
+// How long and how many offline records are kept. Agents with
+// server-assigned IDs leave a new record on every reconnect, so both bound
+// the map.
+const (
+	OfflineRetention  = 24 * time.Hour
+	MaxOfflineRecords = 10000
+)
+
+// DisconnectEvent describes an agent going offline. Intentional is true when
+// the agent announced a clean shutdown before closing its connection; anything
+// else (stream error, keepalive timeout, network drop) is treated as a crash.
+type DisconnectEvent struct {
+	AgentID     string    `json:"agentId"`
+	Hostname    string    `json:"hostname"`
+	Intentional bool      `json:"intentional"`
+	Reason      string    `json:"reason,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// SetDisconnectHandler sets the callback invoked when an agent disconnects
+// unexpectedly. Agents that announced a graceful disconnect do not trigger it.
+func (s *AgentService) SetDisconnectHandler(handler func(DisconnectEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onDisconnect = handler
+}
+
+// SetOfflineHandler sets the callback invoked whenever an agent goes
+// offline, graceful or not, e.g. to update dashboards
+func (s *AgentService) SetOfflineHandler(handler func(DisconnectEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onOffline = handler
+}
+
+// MarkGracefulDisconnect flags an agent as going offline intentionally.
+// It must be called before UnregisterAgent to suppress the disconnect alert.
+func (s *AgentService) MarkGracefulDisconnect(agentID, reason string) {
+	s.mu.RLock()
+	agent, exists := s.agents[agentID]
+	s.mu.RUnlock()
+
+	if !exists {
+		return
+	}
+
+	agent.mu.Lock()
+	agent.graceful = true
+	agent.gracefulReason = reason
+	agent.mu.Unlock()
+
+	s.logger.Infof("Agent %s (%s) announced graceful disconnect: %s", agent.Hostname, agentID, reason)
+}
+
+// GetOfflineStatus returns how an agent last went offline. The record is
+// cleared when the agent registers again and expires after OfflineRetention.
+func (s *AgentService) GetOfflineStatus(agentID string) (DisconnectEvent, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	event, ok := s.offline[agentID]
+	if !ok || s.clock.Now().Sub(event.Timestamp) > OfflineRetention {
+		return DisconnectEvent{}, false
+	}
+	return event, true
+}
+
+// recordDisconnect stores the offline state, tells the offline handler and
+// raises an alert for unexpected disconnects
+func (s *AgentService) recordDisconnect(event DisconnectEvent) {
+	s.mu.Lock()
+	s.offline[event.AgentID] = event
+	s.pruneOfflineLocked(event.Timestamp)
+	offline := s.onOffline
+	handler := s.onDisconnect
+	s.mu.Unlock()
+
+	if offline != nil {
+		offline(event)
+	}
+	if event.Intentional {
+		return
+	}
+
+	s.logger.Warnf("Agent %s (%s) disconnected unexpectedly", event.Hostname, event.AgentID)
+	if handler != nil {
+		handler(event)
+	}
+}
+
+// pruneOfflineLocked keeps the offline records within MaxOfflineRecords,
+// dropping expired ones and then the oldest. Expired records below the cap
+// are only hidden by GetOfflineStatus, which saves a sweep per disconnect.
+func (s *AgentService) pruneOfflineLocked(now time.Time) {
+	if len(s.offline) <= MaxOfflineRecords {
+		return
+	}
+	var oldest DisconnectEvent
+	for id, event := range s.offline {
+		if now.Sub(event.Timestamp) > OfflineRetention {
+			delete(s.offline, id)
+		} else if oldest.AgentID == "" || event.Timestamp.Before(oldest.Timestamp) {
+			oldest = event
+		}
+	}
+	if len(s.offline) > MaxOfflineRecords {
+		delete(s.offline, oldest.AgentID)
+	}
+}
+
+// SetClock sets the time source used for denylist and offline record expiry
+func (s *AgentService) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+// SetAgentCloser registers the function that tears down an agent's
+// connection, e.g. cancelling its gRPC stream context
+func (s *AgentService) SetAgentCloser(agentID string, closer func()) {
+	s.mu.RLock()
+	agent, exists := s.agents[agentID]
+	s.mu.RUnlock()
+
+	if exists {
+		agent.mu.Lock()
+		agent.closer = closer
+		agent.mu.Unlock()
+	}
+}
+
+// SetAgentToken records the stored agent token an agent authenticated
+// with, so revoking the token disconnects it
+func (s *AgentService) SetAgentToken(agentID string, tokenID uint) {
+	s.mu.RLock()
+	agent, exists := s.agents[agentID]
+	s.mu.RUnlock()
+
+	if exists {
+		agent.mu.Lock()
+		agent.tokenID = tokenID
+		agent.mu.Unlock()
+	}
+}
+
+// DisconnectToken force-disconnects the agents that authenticated with a
+// stored agent token, returning their IDs
+func (s *AgentService) DisconnectToken(tokenID uint, reason string) []string {
+	if tokenID == 0 {
+		return nil
+	}
+	var matched []string
+	for _, agent := range s.GetAllAgents() {
+		agent.mu.Lock()
+		uses := agent.tokenID == tokenID
+		agent.mu.Unlock()
+		if uses {
+			matched = append(matched, agent.ID)
+		}
+	}
+
+	var disconnected []string
+	for _, agentID := range matched {
+		if _, err := s.ForceDisconnect(agentID, reason, 0); err == nil {
+			disconnected = append(disconnected, agentID)
+		}
+	}
+	return disconnected
+}
+
+// ForceDisconnect closes an agent's connection and unregisters it. When
+// denyFor is positive the agent's identity (see Identity) is refused for
+// that long so it cannot immediately reconnect, even under a new ID. The
+// disconnect is recorded as intentional.
+func (s *AgentService) ForceDisconnect(agentID, reason string, denyFor time.Duration) (*Agent, error) {
+	s.mu.Lock()
+	agent, exists := s.agents[agentID]
+	if !exists {
+		s.mu.Unlock()
+		return nil, ErrAgentNotFound
+	}
+	if denyFor > 0 {
+		s.denylist[identityOf(agent)] = s.clock.Now().Add(denyFor)
+	}
+	s.mu.Unlock()
+
+	agent.mu.Lock()
+	agent.graceful = true
+	agent.gracefulReason = reason
+	closer := agent.closer
+	agent.mu.Unlock()
+
+	if closer != nil {
+		closer()
+	}
+	s.UnregisterAgent(agentID)
+
+	s.logger.Warnf("Agent %s (%s) force-disconnected: %s (denied for %v)", agent.Hostname, agentID, reason, denyFor)
+	return agent, nil
+}
+
+// IsDenied reports whether an agent identity (its ID, or VolatileIdentity
+// for agents whose ID the server assigns) is currently refused after a
+// forced disconnect
+func (s *AgentService) IsDenied(identity string) bool {
+	_, denied := s.DeniedFor(identity)
+	return denied
+}
+
+// DeniedFor returns how long an agent identity stays refused after a forced
+// disconnect, and whether it is currently refused
+func (s *AgentService) DeniedFor(identity string) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until, ok := s.denylist[identity]
+	if !ok {
+		return 0, false
+	}
+	now := s.clock.Now()
+	if !now.Before(until) {
+		delete(s.denylist, identity)
+		return 0, false
+	}
+	return until.Sub(now), true
+}
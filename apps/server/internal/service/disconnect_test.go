@@ -0,0 +1,224 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestGracefulDisconnectSuppressesAlert(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+
+	var alerts []DisconnectEvent
+	as.SetDisconnectHandler(func(e DisconnectEvent) {
+		alerts = append(alerts, e)
+	})
+
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	as.MarkGracefulDisconnect("agent-1", "shutdown")
+	as.UnregisterAgent("agent-1")
+
+	if len(alerts) != 0 {
+		t.Fatalf("Expected no alert for graceful disconnect, got %+v", alerts)
+	}
+	status, ok := as.GetOfflineStatus("agent-1")
+	if !ok || !status.Intentional {
+		t.Fatalf("Expected agent to be marked intentionally offline, got %+v (recorded=%v)", status, ok)
+	}
+	if status.Reason != "shutdown" {
+		t.Errorf("Expected reason shutdown, got %q", status.Reason)
+	}
+}
+
+func TestAbruptDisconnectTriggersAlert(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+
+	var alerts []DisconnectEvent
+	as.SetDisconnectHandler(func(e DisconnectEvent) {
+		alerts = append(alerts, e)
+	})
+
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	as.UnregisterAgent("agent-1")
+
+	if len(alerts) != 1 {
+		t.Fatalf("Expected 1 alert, got %d", len(alerts))
+	}
+	if alerts[0].AgentID != "agent-1" || alerts[0].Hostname != "host-1" || alerts[0].Intentional {
+		t.Errorf("Unexpected alert: %+v", alerts[0])
+	}
+}
+
+func TestGracefulFlagDoesNotSurviveReconnect(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+
+	alerts := 0
+	as.SetDisconnectHandler(func(e DisconnectEvent) {
+		alerts++
+	})
+
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	as.MarkGracefulDisconnect("agent-1", "upgrade")
+	as.UnregisterAgent("agent-1")
+
+	// The next session starts clean and crashes
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	if _, ok := as.GetOfflineStatus("agent-1"); ok {
+		t.Error("Expected offline status to be cleared on reconnect")
+	}
+	as.UnregisterAgent("agent-1")
+
+	if alerts != 1 {
+		t.Errorf("Expected crash after reconnect to alert, got %d alerts", alerts)
+	}
+}
+
+func TestForceDisconnectClosesConnection(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+
+	alerts := 0
+	as.SetDisconnectHandler(func(e DisconnectEvent) {
+		alerts++
+	})
+
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	closed := false
+	as.SetAgentCloser("agent-1", func() { closed = true })
+
+	agent, err := as.ForceDisconnect("agent-1", "compromised", 0)
+	if err != nil {
+		t.Fatalf("ForceDisconnect failed: %v", err)
+	}
+	if agent.Hostname != "host-1" {
+		t.Errorf("Expected disconnected agent host-1, got %q", agent.Hostname)
+	}
+	if !closed {
+		t.Error("Expected agent connection to be closed")
+	}
+	if as.GetAgent("agent-1") != nil {
+		t.Error("Expected agent to be unregistered")
+	}
+	if alerts != 0 {
+		t.Errorf("Expected forced disconnect not to raise a crash alert, got %d", alerts)
+	}
+	if as.IsDenied("agent-1") {
+		t.Error("Expected agent not to be denied without a denylist duration")
+	}
+
+	if _, err := as.ForceDisconnect("agent-1", "again", 0); err != ErrAgentNotFound {
+		t.Errorf("Expected ErrAgentNotFound, got %v", err)
+	}
+}
+
+func TestForceDisconnectDenylist(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	as.SetClock(clock)
+
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	if _, err := as.ForceDisconnect("agent-1", "misbehaving", 10*time.Minute); err != nil {
+		t.Fatalf("ForceDisconnect failed: %v", err)
+	}
+
+	if !as.IsDenied("agent-1") {
+		t.Error("Expected immediate reconnection to be denied")
+	}
+	if as.IsDenied("agent-2") {
+		t.Error("Expected other agents not to be denied")
+	}
+
+	clock.Advance(10 * time.Minute)
+	if as.IsDenied("agent-1") {
+		t.Error("Expected denylist entry to expire")
+	}
+}
+
+func TestForceDisconnectDeniesVolatileAgentsByHost(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+
+	ws := as.RegisterAgent(nil, AgentInfo{Hostname: "web-1"}, 0)
+	if _, err := as.ForceDisconnect(ws.ID, "misbehaving", 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if !as.IsDenied(VolatileIdentity("web-1")) {
+		t.Error("Expected the host to be denied, as its next connection gets a new ID")
+	}
+}
+
+func TestUnregisterConnectionLeavesReconnectAlone(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+
+	old := as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	if _, err := as.ForceDisconnect("agent-1", "restart", 0); err != nil {
+		t.Fatal(err)
+	}
+	// The agent reconnects before the old stream has wound down
+	current := as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	as.UnregisterConnection(old)
+	if as.GetAgent("agent-1") != current {
+		t.Error("Expected the old connection ending not to unregister the reconnected agent")
+	}
+	as.UnregisterConnection(current)
+	if as.GetAgent("agent-1") != nil {
+		t.Error("Expected the current connection ending to unregister the agent")
+	}
+}
+
+func TestOfflineHandlerSeesEveryDisconnect(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+
+	var offline []DisconnectEvent
+	as.SetOfflineHandler(func(e DisconnectEvent) {
+		offline = append(offline, e)
+	})
+
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	as.RegisterGrpcAgent("agent-2", AgentInfo{Hostname: "host-2"}, 0)
+	as.MarkGracefulDisconnect("agent-1", "shutdown")
+	as.UnregisterAgent("agent-1")
+	as.UnregisterAgent("agent-2")
+
+	if len(offline) != 2 || !offline[0].Intentional || offline[1].Intentional {
+		t.Errorf("Expected both the graceful and the abrupt disconnect, got %+v", offline)
+	}
+}
+
+func TestOfflineRecordsAreBounded(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	as.SetClock(clock)
+
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	as.UnregisterAgent("agent-1")
+	clock.Advance(OfflineRetention + time.Second)
+	if _, ok := as.GetOfflineStatus("agent-1"); ok {
+		t.Error("Expected the offline record to expire")
+	}
+
+	for i := 0; i <= MaxOfflineRecords; i++ {
+		id := fmt.Sprintf("agent-%d", i+2)
+		as.RegisterGrpcAgent(id, AgentInfo{Hostname: id}, 0)
+		as.UnregisterAgent(id)
+		clock.Advance(time.Millisecond)
+	}
+	if n := len(as.offline); n != MaxOfflineRecords {
+		t.Errorf("Expected at most %d offline records, got %d", MaxOfflineRecords, n)
+	}
+	if _, ok := as.GetOfflineStatus("agent-2"); ok {
+		t.Error("Expected the oldest record to be dropped first")
+	}
+	if _, ok := as.GetOfflineStatus(fmt.Sprintf("agent-%d", MaxOfflineRecords+2)); !ok {
+		t.Error("Expected the newest record to be kept")
+	}
+}
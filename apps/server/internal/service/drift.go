@@ -0,0 +1,255 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Drift errors
+var (
+	ErrNoDriftBaseline = errors.New("no drift baseline recorded")
+	ErrNoDriftSnapshot = errors.New("no full metrics reported for agent")
+)
+
+// Categories of configuration drift
+const (
+	DriftMounts   = "mounts"
+	DriftNetwork  = "network"
+	DriftKernel   = "kernel"
+	DriftFirmware = "firmware"
+	DriftOS       = "os"
+	DriftHardware = "hardware"
+)
+
+// ConfigSnapshot is the part of an agent's state expected to stay stable:
+// its mounts, network configuration, OS, kernel, firmware and hardware
+type ConfigSnapshot struct {
+	OsName            string              `json:"osName,omitempty"`
+	OsVersion         string              `json:"osVersion,omitempty"`
+	KernelVersion     string              `json:"kernelVersion,omitempty"`
+	BiosVersion       string              `json:"biosVersion,omitempty"`
+	MotherboardModel  string              `json:"motherboardModel,omitempty"`
+	MotherboardVendor string              `json:"motherboardVendor,omitempty"`
+	SystemModel       string              `json:"systemModel,omitempty"`
+	SystemVendor      string              `json:"systemVendor,omitempty"`
+	CPUModel          string              `json:"cpuModel,omitempty"`
+	LogicalCores      int                 `json:"logicalCores,omitempty"`
+	MemoryTotal       uint64              `json:"memoryTotal,omitempty"`
+	Mounts            []SnapshotMount     `json:"mounts,omitempty"`
+	Interfaces        []SnapshotInterface `json:"interfaces,omitempty"`
+}
+
+// SnapshotMount describes a mounted filesystem
+type SnapshotMount struct {
+	MountPoint string `json:"mountPoint"`
+	Device     string `json:"device,omitempty"`
+	FsType     string `json:"fsType,omitempty"`
+}
+
+// SnapshotInterface describes a network interface's configuration
+type SnapshotInterface struct {
+	Interface   string   `json:"interface"`
+	MacAddress  string   `json:"macAddress,omitempty"`
+	IpAddresses []string `json:"ipAddresses,omitempty"`
+}
+
+// DriftChange is a single difference between a snapshot and its baseline
+type DriftChange struct {
+	Category string `json:"category"`
+	HardwareChange
+}
+
+// DriftBaseline is the snapshot an agent's state is compared against
+type DriftBaseline struct {
+	Snapshot   *ConfigSnapshot `json:"snapshot"`
+	CapturedAt time.Time       `json:"capturedAt"`
+}
+
+// DriftReport compares an agent's current state with its baseline
+type DriftReport struct {
+	AgentID  string          `json:"agentId"`
+	Baseline DriftBaseline   `json:"baseline"`
+	Current  *ConfigSnapshot `json:"current"`
+	Changes  []DriftChange   `json:"changes"`
+}
+
+// SnapshotFromMetrics extracts the configuration snapshot from full metrics
+func SnapshotFromMetrics(m *MetricsData) *ConfigSnapshot {
+	snap := &ConfigSnapshot{
+		CPUModel:     m.CPU.Model,
+		LogicalCores: m.CPU.LogicalCores,
+		MemoryTotal:  m.Memory.Total,
+	}
+	if si := m.SystemInfo; si != nil {
+		snap.OsName = si.OsName
+		snap.OsVersion = si.OsVersion
+		snap.KernelVersion = si.KernelVersion
+		snap.BiosVersion = si.BiosVersion
+		snap.MotherboardModel = si.MotherboardModel
+		snap.MotherboardVendor = si.MotherboardVendor
+		snap.SystemModel = si.SystemModel
+		snap.SystemVendor = si.SystemVendor
+	}
+	for _, d := range m.Disks {
+		if d.MountPoint == "" {
+			continue
+		}
+		snap.Mounts = append(snap.Mounts, SnapshotMount{MountPoint: d.MountPoint, Device: d.Device, FsType: d.FsType})
+	}
+	for _, n := range m.Networks {
+		ips := append([]string(nil), n.IpAddresses...)
+		sort.Strings(ips)
+		snap.Interfaces = append(snap.Interfaces, SnapshotInterface{Interface: n.Interface, MacAddress: n.MacAddress, IpAddresses: ips})
+	}
+	return snap
+}
+
+// DiffSnapshots lists the differences between a baseline snapshot and the
+// current one: mounts and interfaces added or removed, and changed
+// addresses, kernel, firmware, OS and hardware
+func DiffSnapshots(baseline, current *ConfigSnapshot) []DriftChange {
+	changes := diffFields(flattenSnapshot(baseline), flattenSnapshot(current))
+	drift := make([]DriftChange, 0, len(changes))
+	for _, c := range changes {
+		drift = append(drift, DriftChange{Category: driftCategory(c.Field), HardwareChange: c})
+	}
+	return drift
+}
+
+// flattenSnapshot turns a snapshot into field path -> value pairs
+func flattenSnapshot(snap *ConfigSnapshot) map[string]string {
+	out := make(map[string]string)
+	set := func(key, value string) {
+		if value != "" && value != "0" {
+			out[key] = value
+		}
+	}
+
+	set("system.osName", snap.OsName)
+	set("system.osVersion", snap.OsVersion)
+	set("system.kernelVersion", snap.KernelVersion)
+	set("system.biosVersion", snap.BiosVersion)
+	set("system.motherboardModel", snap.MotherboardModel)
+	set("system.motherboardVendor", snap.MotherboardVendor)
+	set("system.model", snap.SystemModel)
+	set("system.vendor", snap.SystemVendor)
+	set("cpu.model", snap.CPUModel)
+	set("cpu.logicalCores", strconv.Itoa(snap.LogicalCores))
+	set("memory.total", strconv.FormatUint(snap.MemoryTotal, 10))
+	for _, m := range snap.Mounts {
+		prefix := "mounts[" + m.MountPoint + "]."
+		// Always present, so a mount without device info is still seen
+		out[prefix+"mounted"] = "true"
+		set(prefix+"device", m.Device)
+		set(prefix+"fsType", m.FsType)
+	}
+	for _, n := range snap.Interfaces {
+		prefix := "networks[" + n.Interface + "]."
+		out[prefix+"present"] = "true"
+		set(prefix+"macAddress", n.MacAddress)
+		set(prefix+"ipAddresses", strings.Join(n.IpAddresses, ","))
+	}
+	return out
+}
+
+// driftCategory maps a snapshot field path to its drift category
+func driftCategory(field string) string {
+	switch {
+	case strings.HasPrefix(field, "mounts["):
+		return DriftMounts
+	case strings.HasPrefix(field, "networks["):
+		return DriftNetwork
+	case field == "system.kernelVersion":
+		return DriftKernel
+	case field == "system.osName", field == "system.osVersion":
+		return DriftOS
+	case strings.HasPrefix(field, "system."):
+		return DriftFirmware
+	}
+	return DriftHardware
+}
+
+// DriftService keeps a configuration baseline per agent so unplanned
+// changes to supposedly stable hosts can be spotted. The first full metrics
+// an agent reports become its baseline until an operator re-baselines.
+// Baselines are kept with the agent's hardware baseline, keyed by identity
+// (see AgentService.Identity).
+type DriftService struct {
+	store   *HardwareBaselineService
+	metrics *MetricsService
+	logger  *zap.SugaredLogger
+}
+
+// NewDriftService creates a new drift service
+func NewDriftService(store *HardwareBaselineService, metrics *MetricsService, logger *zap.SugaredLogger) *DriftService {
+	return &DriftService{
+		store:   store,
+		metrics: metrics,
+		logger:  logger,
+	}
+}
+
+// current returns the snapshot of an agent's latest full metrics
+func (s *DriftService) current(agentID string) (*ConfigSnapshot, error) {
+	m := s.metrics.GetCurrentMetrics(agentID)
+	if m == nil || m.Incomplete {
+		return nil, ErrNoDriftSnapshot
+	}
+	return SnapshotFromMetrics(m), nil
+}
+
+// Observe records an agent's current state as its baseline if it has none
+// yet. Agents without full metrics are skipped.
+func (s *DriftService) Observe(agentID, identity string) error {
+	snap, err := s.current(agentID)
+	if errors.Is(err, ErrNoDriftSnapshot) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.store.ObserveConfig(identity, snap)
+}
+
+// Rebaseline makes an agent's current state its baseline, e.g. after
+// planned maintenance
+func (s *DriftService) Rebaseline(agentID, identity string) (*DriftBaseline, error) {
+	snap, err := s.current(agentID)
+	if err != nil {
+		return nil, err
+	}
+	baseline, err := s.store.PinConfig(identity, snap)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Infof("Recorded drift baseline for agent %s", agentID)
+	return baseline, nil
+}
+
+// Baseline returns an agent's stored baseline
+func (s *DriftService) Baseline(identity string) (*DriftBaseline, error) {
+	return s.store.ConfigBaseline(identity)
+}
+
+// Report diffs an agent's current state against its baseline
+func (s *DriftService) Report(agentID, identity string) (*DriftReport, error) {
+	current, err := s.current(agentID)
+	if err != nil {
+		return nil, err
+	}
+	baseline, err := s.Baseline(identity)
+	if err != nil {
+		return nil, err
+	}
+	return &DriftReport{
+		AgentID:  agentID,
+		Baseline: *baseline,
+		Current:  current,
+		Changes:  DiffSnapshots(baseline.Snapshot, current),
+	}, nil
+}
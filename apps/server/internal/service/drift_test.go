@@ -0,0 +1,155 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func driftMetrics(kernel, bios string, mounts []DiskData, networks []NetData) *MetricsData {
+	return &MetricsData{
+		CPU:    CPUData{Model: "Xeon E-2288G", LogicalCores: 16},
+		Memory: MemData{Total: 32 << 30},
+		Disks:  mounts,
+		SystemInfo: &SystemInfo{
+			OsName:        "Ubuntu",
+			OsVersion:     "22.04",
+			KernelVersion: kernel,
+			BiosVersion:   bios,
+		},
+		Networks: networks,
+	}
+}
+
+func TestDriftReportsEachChange(t *testing.T) {
+	db := newTestDB(t)
+	logger := zap.NewNop().Sugar()
+	ms := NewMetricsService(logger)
+	store := NewHardwareBaselineService(db, logger)
+	captured := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(captured)
+	store.SetClock(clock)
+	drift := NewDriftService(store, ms, logger)
+
+	if _, err := drift.Report("agent-1", "agent-1"); !errors.Is(err, ErrNoDriftSnapshot) {
+		t.Fatalf("Expected ErrNoDriftSnapshot before any metrics, got %v", err)
+	}
+	if err := drift.Observe("agent-1", "agent-1"); err != nil {
+		t.Fatalf("Expected agents without full metrics to be skipped, got %v", err)
+	}
+
+	ms.StoreMetrics("agent-1", driftMetrics("5.15.0-91", "1.4.2",
+		[]DiskData{
+			{MountPoint: "/", Device: "/dev/sda1", FsType: "ext4"},
+			{MountPoint: "/data", Device: "/dev/sdb1", FsType: "xfs"},
+		},
+		[]NetData{{Interface: "eth0", MacAddress: "52:54:00:12:34:56", IpAddresses: []string{"10.0.0.5/24"}}},
+	))
+	// Reports are read-only; the baseline is recorded on ingestion
+	if _, err := drift.Report("agent-1", "agent-1"); !errors.Is(err, ErrNoDriftBaseline) {
+		t.Fatalf("Expected ErrNoDriftBaseline before a baseline is recorded, got %v", err)
+	}
+	if err := drift.Observe("agent-1", "agent-1"); err != nil {
+		t.Fatal(err)
+	}
+	report, err := drift.Report("agent-1", "agent-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changes) != 0 || !report.Baseline.CapturedAt.Equal(captured) {
+		t.Fatalf("Expected a fresh baseline without changes, got %+v", report)
+	}
+
+	clock.Advance(time.Hour)
+	// /data is unmounted, a backup volume appears, the kernel and BIOS are
+	// upgraded and the network is reconfigured
+	ms.StoreMetrics("agent-1", driftMetrics("6.8.0-40", "1.5.0",
+		[]DiskData{
+			{MountPoint: "/", Device: "/dev/sda1", FsType: "ext4"},
+			{MountPoint: "/backup", Device: "/dev/sdc1", FsType: "ext4"},
+		},
+		[]NetData{
+			{Interface: "eth0", MacAddress: "52:54:00:12:34:56", IpAddresses: []string{"10.0.1.9/24"}},
+			{Interface: "eth1", MacAddress: "52:54:00:ab:cd:ef"},
+		},
+	))
+	if err := drift.Observe("agent-1", "agent-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = drift.Report("agent-1", "agent-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := make(map[string]DriftChange)
+	for _, c := range report.Changes {
+		changes[c.Field] = c
+	}
+	tests := []struct {
+		field    string
+		category string
+		kind     string
+	}{
+		{"mounts[/data].mounted", DriftMounts, HardwareRemoved},
+		{"mounts[/data].device", DriftMounts, HardwareRemoved},
+		{"mounts[/backup].mounted", DriftMounts, HardwareAdded},
+		{"system.kernelVersion", DriftKernel, HardwareChanged},
+		{"system.biosVersion", DriftFirmware, HardwareChanged},
+		{"networks[eth0].ipAddresses", DriftNetwork, HardwareChanged},
+		{"networks[eth1].present", DriftNetwork, HardwareAdded},
+	}
+	for _, tt := range tests {
+		c, ok := changes[tt.field]
+		if !ok || c.Category != tt.category || c.Kind != tt.kind {
+			t.Errorf("Expected %s to be reported as %s/%s, got %+v", tt.field, tt.category, tt.kind, c)
+		}
+	}
+	if c := changes["system.kernelVersion"]; c.Previous != "5.15.0-91" || c.Current != "6.8.0-40" {
+		t.Errorf("Expected the kernel versions to be reported, got %+v", c)
+	}
+	if _, ok := changes["mounts[/].device"]; ok {
+		t.Error("Expected unchanged mounts not to be reported")
+	}
+
+	// The baseline is persisted across restarts, and recording the hardware
+	// baseline kept in the same row leaves it alone
+	restarted := NewHardwareBaselineService(db, logger)
+	if err := restarted.Observe("agent-1", &HardwareInventory{CPUModel: "Xeon E-2288G"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewDriftService(restarted, ms, logger).Observe("agent-1", "agent-1"); err != nil {
+		t.Fatal(err)
+	}
+	report, err = NewDriftService(restarted, ms, logger).Report("agent-1", "agent-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Baseline.CapturedAt.Equal(captured) || len(report.Changes) != len(changes) {
+		t.Errorf("Expected the stored baseline to be kept, got %+v", report.Baseline)
+	}
+
+	// Re-baselining accepts the current state
+	if _, err := drift.Rebaseline("agent-1", "agent-1"); err != nil {
+		t.Fatal(err)
+	}
+	report, err = drift.Report("agent-1", "agent-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changes) != 0 || !report.Baseline.CapturedAt.Equal(captured.Add(time.Hour)) {
+		t.Errorf("Expected no drift after re-baselining, got %+v", report.Changes)
+	}
+	if _, err := restarted.Baseline("agent-1"); err != nil {
+		t.Errorf("Expected the hardware baseline to survive re-baselining, got %v", err)
+	}
+
+	// Forgetting the agent drops both baselines
+	if err := store.Forget("agent-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := drift.Baseline("agent-1"); !errors.Is(err, ErrNoDriftBaseline) {
+		t.Errorf("Expected ErrNoDriftBaseline after forgetting, got %v", err)
+	}
+}
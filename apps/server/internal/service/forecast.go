@@ -0,0 +1,181 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+)
+
+// ErrUnknownForecastMetric is returned for metrics that cannot be forecast
+var ErrUnknownForecastMetric = errors.New("unknown forecast metric")
+
+// Metrics that can be forecast, all in percent
+const (
+	ForecastCPU    = "cpu"
+	ForecastMemory = "memory"
+	ForecastDisk   = "disk"
+)
+
+// Forecast outcomes
+const (
+	ForecastCrossing     = "crossing"          // rising and projected to cross
+	ForecastExceeded     = "exceeded"          // already at or over the threshold
+	ForecastStable       = "stable"            // no meaningful trend
+	ForecastDecreasing   = "decreasing"        // trending down
+	ForecastNoisy        = "noisy"             // rising, but too erratic to project
+	ForecastInsufficient = "insufficient_data" // too few samples or too short a span
+)
+
+const (
+	minForecastSamples = 6
+	minForecastSpan    = 30 * time.Minute
+	// Fits explaining less of the variance than this (R²) are not projected
+	minForecastConfidence = 0.3
+	// A fitted change smaller than this over the window, in percentage
+	// points, is treated as flat
+	stableForecastChange = 1.0
+)
+
+// Forecast is the trend-based projection of when a metric crosses a threshold
+type Forecast struct {
+	AgentID   string  `json:"agentId"`
+	Metric    string  `json:"metric"`
+	Threshold float64 `json:"threshold"`
+	Status    string  `json:"status"`
+	// Current is the latest observed value
+	Current      float64 `json:"current"`
+	SlopePerHour float64 `json:"slopePerHour"`
+	// Confidence is the R² of the linear fit, from 0 to 1
+	Confidence  float64    `json:"confidence"`
+	ProjectedAt *time.Time `json:"projectedAt,omitempty"`
+	Samples     int        `json:"samples"`
+	WindowStart time.Time  `json:"windowStart"`
+	WindowEnd   time.Time  `json:"windowEnd"`
+}
+
+// ForecastValue extracts a forecast metric from a persisted sample. ok is
+// false when the sample predates the metric being recorded.
+func ForecastValue(metric string, m database.MetricsHistory) (value float64, ok bool, err error) {
+	switch metric {
+	case ForecastCPU:
+		return m.CPUPercent, true, nil
+	case ForecastMemory:
+		return m.MemPercent, true, nil
+	case ForecastDisk:
+		if m.DiskPercent == nil {
+			return 0, false, nil
+		}
+		return *m.DiskPercent, true, nil
+	}
+	return 0, false, ErrUnknownForecastMetric
+}
+
+// ForecastThreshold fits a least-squares line through points (oldest first)
+// and projects when it reaches threshold. now is the end of the window.
+func ForecastThreshold(points []MetricsPoint, threshold float64, now time.Time) Forecast {
+	forecast := Forecast{Threshold: threshold, Samples: len(points), WindowEnd: now}
+	if len(points) == 0 {
+		forecast.Status = ForecastInsufficient
+		return forecast
+	}
+
+	first := points[0].Timestamp
+	forecast.WindowStart = first
+	forecast.Current = points[len(points)-1].Value
+	if len(points) < minForecastSamples || points[len(points)-1].Timestamp.Sub(first) < minForecastSpan {
+		forecast.Status = ForecastInsufficient
+		return forecast
+	}
+
+	// x is hours since the first sample
+	n := float64(len(points))
+	var sumX, sumY float64
+	for _, p := range points {
+		sumX += p.Timestamp.Sub(first).Hours()
+		sumY += p.Value
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var sxx, sxy, syy float64
+	for _, p := range points {
+		dx := p.Timestamp.Sub(first).Hours() - meanX
+		dy := p.Value - meanY
+		sxx += dx * dx
+		sxy += dx * dy
+		syy += dy * dy
+	}
+	if sxx == 0 {
+		forecast.Status = ForecastInsufficient
+		return forecast
+	}
+
+	slope := sxy / sxx
+	intercept := meanY - slope*meanX
+	forecast.SlopePerHour = slope
+	if syy == 0 {
+		forecast.Confidence = 1
+	} else {
+		forecast.Confidence = sxy * sxy / (sxx * syy)
+	}
+
+	nowX := now.Sub(first).Hours()
+	if forecast.Current >= threshold || intercept+slope*nowX >= threshold {
+		forecast.Status = ForecastExceeded
+		return forecast
+	}
+
+	change := slope * points[len(points)-1].Timestamp.Sub(first).Hours()
+	switch {
+	case math.Abs(change) < stableForecastChange:
+		forecast.Status = ForecastStable
+		return forecast
+	case slope < 0:
+		forecast.Status = ForecastDecreasing
+		return forecast
+	case forecast.Confidence < minForecastConfidence:
+		forecast.Status = ForecastNoisy
+		return forecast
+	}
+
+	hours := (threshold - intercept) / slope
+	projected := first.Add(time.Duration(hours * float64(time.Hour)))
+	forecast.Status = ForecastCrossing
+	forecast.ProjectedAt = &projected
+	return forecast
+}
+
+// Forecast projects when an agent's metric crosses threshold from the
+// persisted history over the last window
+func (mp *MetricsPersistence) Forecast(agentID, metric string, threshold float64, window time.Duration) (Forecast, error) {
+	if _, _, err := ForecastValue(metric, database.MetricsHistory{}); err != nil {
+		return Forecast{}, err
+	}
+
+	mp.mu.Lock()
+	now := mp.clock.Now()
+	mp.mu.Unlock()
+
+	// Bucketed averages smooth out sample-to-sample jitter
+	history, err := mp.QueryAggregated(agentID, now.Add(-window), now, "auto")
+	if err != nil {
+		return Forecast{}, err
+	}
+
+	points := make([]MetricsPoint, 0, len(history))
+	for _, m := range history {
+		value, ok, _ := ForecastValue(metric, m)
+		if !ok {
+			continue
+		}
+		points = append(points, MetricsPoint{Timestamp: m.Timestamp, Value: value})
+	}
+
+	forecast := ForecastThreshold(points, threshold, now)
+	forecast.AgentID = agentID
+	forecast.Metric = metric
+	if len(points) == 0 {
+		forecast.WindowStart = now.Add(-window)
+	}
+	return forecast, nil
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+)
+
+func seriesPoints(start time.Time, count int, step time.Duration, value func(i int) float64) []MetricsPoint {
+	points := make([]MetricsPoint, count)
+	for i := range points {
+		points[i] = MetricsPoint{Timestamp: start.Add(time.Duration(i) * step), Value: value(i)}
+	}
+	return points
+}
+
+func TestForecastRisingDiskProjectsCrossing(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	// 50% rising 1 point per hour, sampled every 10 minutes for a day
+	points := seriesPoints(start, 145, 10*time.Minute, func(i int) float64 {
+		return 50 + float64(i)/6
+	})
+	now := start.Add(24 * time.Hour)
+
+	forecast := ForecastThreshold(points, 90, now)
+	if forecast.Status != ForecastCrossing {
+		t.Fatalf("Expected status %s, got %s", ForecastCrossing, forecast.Status)
+	}
+	if forecast.ProjectedAt == nil {
+		t.Fatal("Expected a projected crossing time")
+	}
+	want := start.Add(40 * time.Hour)
+	if diff := forecast.ProjectedAt.Sub(want); diff < -time.Minute || diff > time.Minute {
+		t.Errorf("Expected crossing near %v, got %v", want, *forecast.ProjectedAt)
+	}
+	if math.Abs(forecast.SlopePerHour-1) > 0.01 {
+		t.Errorf("Expected slope of 1/hour, got %f", forecast.SlopePerHour)
+	}
+	if forecast.Confidence < 0.99 {
+		t.Errorf("Expected high confidence for a clean trend, got %f", forecast.Confidence)
+	}
+}
+
+func TestForecastFlatSeriesIsStable(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	points := seriesPoints(start, 145, 10*time.Minute, func(i int) float64 {
+		// Small jitter around 60%
+		return 60 + 0.2*float64(i%3-1)
+	})
+
+	forecast := ForecastThreshold(points, 90, start.Add(24*time.Hour))
+	if forecast.Status != ForecastStable {
+		t.Fatalf("Expected status %s, got %s", ForecastStable, forecast.Status)
+	}
+	if forecast.ProjectedAt != nil {
+		t.Errorf("Expected no crossing for a flat series, got %v", *forecast.ProjectedAt)
+	}
+}
+
+func TestForecastEdgeCases(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	now := start.Add(24 * time.Hour)
+
+	tests := []struct {
+		name   string
+		points []MetricsPoint
+		want   string
+	}{
+		{"no data", nil, ForecastInsufficient},
+		{"too few samples", seriesPoints(start, 3, time.Hour, func(i int) float64 { return float64(i) }), ForecastInsufficient},
+		{"too short a span", seriesPoints(start, 20, time.Minute, func(i int) float64 { return float64(i) }), ForecastInsufficient},
+		{"decreasing", seriesPoints(start, 25, time.Hour, func(i int) float64 { return 80 - float64(i) }), ForecastDecreasing},
+		{"already exceeded", seriesPoints(start, 25, time.Hour, func(i int) float64 { return 70 + float64(i) }), ForecastExceeded},
+		{"noisy", seriesPoints(start, 25, time.Hour, func(i int) float64 {
+			// Swings of 30 points with a slight upward drift
+			return 40 + 0.1*float64(i) + 30*float64(i%2)
+		}), ForecastNoisy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forecast := ForecastThreshold(tt.points, 90, now)
+			if forecast.Status != tt.want {
+				t.Errorf("Expected status %s, got %s", tt.want, forecast.Status)
+			}
+			if forecast.ProjectedAt != nil {
+				t.Errorf("Expected no projection, got %v", *forecast.ProjectedAt)
+			}
+		})
+	}
+}
+
+func TestMetricsPersistenceForecast(t *testing.T) {
+	db := newTestDB(t)
+	mp, err := NewMetricsPersistence(db, config.MetricsConfig{PersistToDB: true}, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SQLite index names are database-wide, so keep to one monthly table
+	if err := db.Migrator().DropTable(database.GetCurrentMetricsTableName()); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local)
+	clock := NewFakeClock(start)
+	mp.SetClock(clock)
+
+	for i := 0; i <= 144; i++ {
+		data := &MetricsData{
+			Timestamp: clock.Now(),
+			Disks: []DiskData{
+				{MountPoint: "/", UsagePercent: 50 + float64(i)/6},
+				{MountPoint: "/boot", UsagePercent: 20},
+			},
+		}
+		if err := mp.SaveMetrics("agent-1", data); err != nil {
+			t.Fatal(err)
+		}
+		if i < 144 {
+			clock.Advance(10 * time.Minute)
+		}
+	}
+
+	forecast, err := mp.Forecast("agent-1", ForecastDisk, 90, 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forecast.Status != ForecastCrossing || forecast.ProjectedAt == nil {
+		t.Fatalf("Expected a projected crossing, got %s", forecast.Status)
+	}
+	// Hourly buckets shift the fit slightly; the crossing is still ~16h out
+	want := start.Add(40 * time.Hour)
+	if diff := forecast.ProjectedAt.Sub(want); diff < -time.Hour || diff > time.Hour {
+		t.Errorf("Expected crossing near %v, got %v", want, *forecast.ProjectedAt)
+	}
+
+	if _, err := mp.Forecast("agent-1", "swap", 90, time.Hour); !errors.Is(err, ErrUnknownForecastMetric) {
+		t.Errorf("Expected ErrUnknownForecastMetric, got %v", err)
+	}
+
+	empty, err := mp.Forecast("agent-2", ForecastDisk, 90, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty.Status != ForecastInsufficient {
+		t.Errorf("Expected status %s for an agent without history, got %s", ForecastInsufficient, empty.Status)
+	}
+}
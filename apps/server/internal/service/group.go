@@ -13,6 +13,9 @@ import (
 type GroupService struct {
 	db     *gorm.DB
 	logger *zap.SugaredLogger
+	audit  *AuditService
+	actor  AuditActor
+	cache  *PermissionCache
 }
 
 // NewGroupService creates a new group service
@@ -23,6 +26,25 @@ func NewGroupService(db *gorm.DB, logger *zap.SugaredLogger) *GroupService {
 	}
 }
 
+// SetAuditService records group membership changes in the audit trail
+func (s *GroupService) SetAuditService(audit *AuditService) {
+	s.audit = audit
+}
+
+// SetPermissionCache invalidates cached permissions when group membership
+// changes
+func (s *GroupService) SetPermissionCache(cache *PermissionCache) {
+	s.cache = cache
+}
+
+// WithActor returns a view of the service that attributes the changes it
+// makes to actor in the audit trail
+func (s *GroupService) WithActor(actor AuditActor) *GroupService {
+	scoped := *s
+	scoped.actor = actor
+	return &scoped
+}
+
 // Group errors
 var (
 	ErrGroupNotFound = errors.New("group not found")
@@ -62,6 +84,18 @@ func (s *GroupService) GetGroup(groupID uint) (*database.Group, error) {
 	return &group, nil
 }
 
+// GetGroupByName retrieves a group by name
+func (s *GroupService) GetGroupByName(name string) (*database.Group, error) {
+	var group database.Group
+	if err := s.db.Where("name = ?", name).First(&group).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrGroupNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return &group, nil
+}
+
 // ListGroups returns all groups
 func (s *GroupService) ListGroups() ([]database.Group, error) {
 	var groups []database.Group
@@ -118,6 +152,7 @@ func (s *GroupService) DeleteGroup(groupID uint) error {
 	if err := s.db.Delete(&database.Group{}, groupID).Error; err != nil {
 		return fmt.Errorf("failed to delete group: %w", err)
 	}
+	s.cache.InvalidateAll()
 
 	s.logger.Infof("Group ID %d deleted", groupID)
 	return nil
@@ -145,7 +180,9 @@ func (s *GroupService) AddUserToGroup(userID, groupID uint) error {
 	if err := s.db.Model(&group).Association("Users").Append(&user); err != nil {
 		return fmt.Errorf("failed to add user to group: %w", err)
 	}
+	s.cache.InvalidateUser(userID)
 
+	recordPermissionChange(s.audit, s.actor, PermissionChange{Action: AuditGroupMemberAdd, UserID: userID, GroupID: groupID})
 	s.logger.Infof("User '%s' added to group '%s'", user.Username, group.Name)
 	return nil
 }
@@ -171,7 +208,9 @@ func (s *GroupService) RemoveUserFromGroup(userID, groupID uint) error {
 	if err := s.db.Model(&group).Association("Users").Delete(&user); err != nil {
 		return fmt.Errorf("failed to remove user from group: %w", err)
 	}
+	s.cache.InvalidateUser(userID)
 
+	recordPermissionChange(s.audit, s.actor, PermissionChange{Action: AuditGroupMemberRemove, UserID: userID, GroupID: groupID})
 	s.logger.Infof("User '%s' removed from group '%s'", user.Username, group.Name)
 	return nil
 }
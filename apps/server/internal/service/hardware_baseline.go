@@ -0,0 +1,261 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Hardware baseline errors
+var (
+	ErrNoHardwareBaseline  = errors.New("no hardware baseline recorded")
+	ErrNoHardwareInventory = errors.New("no hardware inventory reported since the server started")
+)
+
+// HardwareBaseline is the inventory an agent's hardware is compared against
+type HardwareBaseline struct {
+	Inventory  *HardwareInventory `json:"inventory"`
+	Pinned     bool               `json:"pinned"`
+	CapturedAt time.Time          `json:"capturedAt"`
+}
+
+// HardwareReport compares an agent's latest inventory with its baseline
+type HardwareReport struct {
+	AgentID  string             `json:"agentId"` // the agent's identity
+	Baseline HardwareBaseline   `json:"baseline"`
+	Current  *HardwareInventory `json:"current"`
+	Changes  []HardwareChange   `json:"changes"`
+}
+
+// HardwareBaselineService keeps a hardware baseline per agent so disks
+// being swapped, memory shrinking or a GPU disappearing can be spotted. The
+// first inventory an agent reports becomes its baseline unless an operator
+// pins another one. The configuration snapshot drift is detected against
+// (see DriftService) is stored the same way. Agents are keyed by identity
+// (see AgentService.Identity) so an agent whose ID the server assigns per
+// connection keeps one baseline across reconnects.
+type HardwareBaselineService struct {
+	db     *gorm.DB
+	clock  Clock
+	logger *zap.SugaredLogger
+
+	latest         map[string]*HardwareInventory
+	recorded       map[string]bool // identities known to have a stored inventory
+	configRecorded map[string]bool // identities known to have a stored snapshot
+	mu             sync.Mutex
+}
+
+// NewHardwareBaselineService creates a new hardware baseline service
+func NewHardwareBaselineService(db *gorm.DB, logger *zap.SugaredLogger) *HardwareBaselineService {
+	return &HardwareBaselineService{
+		db:             db,
+		clock:          RealClock,
+		logger:         logger,
+		latest:         make(map[string]*HardwareInventory),
+		recorded:       make(map[string]bool),
+		configRecorded: make(map[string]bool),
+	}
+}
+
+// SetClock replaces the time source (for tests)
+func (s *HardwareBaselineService) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+func (s *HardwareBaselineService) now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clock.Now()
+}
+
+// Observe records an agent's latest inventory and stores it as the baseline
+// if the agent has none yet
+func (s *HardwareBaselineService) Observe(identity string, inventory *HardwareInventory) error {
+	s.mu.Lock()
+	s.latest[identity] = inventory
+	recorded := s.recorded[identity]
+	s.mu.Unlock()
+	if recorded {
+		return nil
+	}
+
+	data, err := json.Marshal(inventory)
+	if err != nil {
+		return err
+	}
+	// A baseline stored before a restart is kept
+	if err := s.store(identity, "inventory", map[string]interface{}{"inventory": string(data), "captured_at": s.now()}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.recorded[identity] = true
+	s.mu.Unlock()
+	return nil
+}
+
+// Pin makes an agent's latest inventory its baseline, e.g. after planned
+// hardware work
+func (s *HardwareBaselineService) Pin(identity string) (*HardwareBaseline, error) {
+	s.mu.Lock()
+	inventory := s.latest[identity]
+	s.mu.Unlock()
+	if inventory == nil {
+		return nil, ErrNoHardwareInventory
+	}
+
+	data, err := json.Marshal(inventory)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	if err := s.store(identity, "", map[string]interface{}{"inventory": string(data), "pinned": true, "captured_at": now}); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.recorded[identity] = true
+	s.mu.Unlock()
+	s.logger.Infof("Pinned hardware baseline for %s", identity)
+	return &HardwareBaseline{Inventory: inventory, Pinned: true, CapturedAt: now}, nil
+}
+
+// Baseline returns an agent's stored baseline
+func (s *HardwareBaselineService) Baseline(identity string) (*HardwareBaseline, error) {
+	record, err := s.record(identity)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || record.Inventory == "" {
+		return nil, ErrNoHardwareBaseline
+	}
+	var inventory HardwareInventory
+	if err := json.Unmarshal([]byte(record.Inventory), &inventory); err != nil {
+		return nil, err
+	}
+	return &HardwareBaseline{Inventory: &inventory, Pinned: record.Pinned, CapturedAt: record.CapturedAt}, nil
+}
+
+// Compare diffs an agent's latest inventory against its baseline
+func (s *HardwareBaselineService) Compare(identity string) (*HardwareReport, error) {
+	s.mu.Lock()
+	current := s.latest[identity]
+	s.mu.Unlock()
+	if current == nil {
+		return nil, ErrNoHardwareInventory
+	}
+
+	baseline, err := s.Baseline(identity)
+	if err != nil {
+		return nil, err
+	}
+	return &HardwareReport{
+		AgentID:  identity,
+		Baseline: *baseline,
+		Current:  current,
+		Changes:  DiffInventory(baseline.Inventory, current),
+	}, nil
+}
+
+// ObserveConfig stores an agent's configuration snapshot as its drift
+// baseline if it has none yet
+func (s *HardwareBaselineService) ObserveConfig(identity string, snap *ConfigSnapshot) error {
+	s.mu.Lock()
+	recorded := s.configRecorded[identity]
+	s.mu.Unlock()
+	if recorded {
+		return nil
+	}
+	if _, err := s.storeConfig(identity, snap, "config_snapshot"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.configRecorded[identity] = true
+	s.mu.Unlock()
+	return nil
+}
+
+// PinConfig makes snap an agent's drift baseline
+func (s *HardwareBaselineService) PinConfig(identity string, snap *ConfigSnapshot) (*DriftBaseline, error) {
+	baseline, err := s.storeConfig(identity, snap, "")
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.configRecorded[identity] = true
+	s.mu.Unlock()
+	return baseline, nil
+}
+
+func (s *HardwareBaselineService) storeConfig(identity string, snap *ConfigSnapshot, unlessSet string) (*DriftBaseline, error) {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	if err := s.store(identity, unlessSet, map[string]interface{}{"config_snapshot": string(data), "config_captured_at": now}); err != nil {
+		return nil, err
+	}
+	return &DriftBaseline{Snapshot: snap, CapturedAt: now}, nil
+}
+
+// ConfigBaseline returns an agent's stored drift baseline
+func (s *HardwareBaselineService) ConfigBaseline(identity string) (*DriftBaseline, error) {
+	record, err := s.record(identity)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || record.ConfigSnapshot == "" {
+		return nil, ErrNoDriftBaseline
+	}
+	var snap ConfigSnapshot
+	if err := json.Unmarshal([]byte(record.ConfigSnapshot), &snap); err != nil {
+		return nil, err
+	}
+	return &DriftBaseline{Snapshot: &snap, CapturedAt: record.ConfigCapturedAt}, nil
+}
+
+// Forget drops an agent's baselines, e.g. when it is deregistered
+func (s *HardwareBaselineService) Forget(identity string) error {
+	s.mu.Lock()
+	delete(s.latest, identity)
+	delete(s.recorded, identity)
+	delete(s.configRecorded, identity)
+	s.mu.Unlock()
+	return s.db.Where("agent_id = ?", identity).Delete(&database.HardwareBaseline{}).Error
+}
+
+// record returns an agent's stored row, nil when there is none
+func (s *HardwareBaselineService) record(identity string) (*database.HardwareBaseline, error) {
+	var record database.HardwareBaseline
+	if err := s.db.Where("agent_id = ?", identity).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &record, nil
+}
+
+// store sets columns on an agent's row, creating the row first. With
+// unlessSet they are only written while that column is still empty, so the
+// first baseline recorded is kept.
+func (s *HardwareBaselineService) store(identity, unlessSet string, columns map[string]interface{}) error {
+	err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&database.HardwareBaseline{AgentID: identity}).Error
+	if err != nil {
+		return err
+	}
+	query := s.db.Model(&database.HardwareBaseline{}).Where("agent_id = ?", identity)
+	if unlessSet != "" {
+		query = query.Where(unlessSet + " IS NULL OR " + unlessSet + " = ''")
+	}
+	return query.Updates(columns).Error
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+)
+
+func testInventory(serial string, memory uint64, gpus ...string) *HardwareInventory {
+	inv := &HardwareInventory{
+		CPUModel:    "Xeon E-2288G",
+		MemoryTotal: memory,
+		Disks:       []InventoryDisk{{Device: "/dev/sda", Model: "Samsung 870", Serial: serial, Total: 1 << 40}},
+	}
+	for i, name := range gpus {
+		inv.GPUs = append(inv.GPUs, InventoryChip{Index: i, Name: name})
+	}
+	return inv
+}
+
+func TestHardwareBaselineReportsDiskSerialChange(t *testing.T) {
+	db := newTestDB(t)
+	baselines := NewHardwareBaselineService(db, zap.NewNop().Sugar())
+	captured := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(captured)
+	baselines.SetClock(clock)
+
+	if err := baselines.Observe("agent-1", testInventory("S4EWNX0R100001", 32<<30, "RTX 4090")); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	// The disk is swapped, memory shrinks and the GPU is pulled
+	if err := baselines.Observe("agent-1", testInventory("S4EWNX0R999999", 16<<30)); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := baselines.Compare("agent-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Baseline.CapturedAt.Equal(captured) || report.Baseline.Pinned {
+		t.Errorf("Expected the first inventory to stay the baseline, got %+v", report.Baseline)
+	}
+	changes := make(map[string]HardwareChange)
+	for _, c := range report.Changes {
+		changes[c.Field] = c
+	}
+	serial := changes["disks[/dev/sda].serial"]
+	if serial.Kind != HardwareChanged || serial.Previous != "S4EWNX0R100001" || serial.Current != "S4EWNX0R999999" {
+		t.Errorf("Expected the disk serial change to be reported, got %+v", report.Changes)
+	}
+	if memory := changes["memory.total"]; memory.Kind != HardwareChanged {
+		t.Errorf("Expected reduced memory to be reported, got %+v", memory)
+	}
+	if gpu := changes["gpus[0].name"]; gpu.Kind != HardwareRemoved || gpu.Previous != "RTX 4090" {
+		t.Errorf("Expected the GPU to be reported as removed, got %+v", gpu)
+	}
+
+	// A restarted server keeps the stored baseline
+	restarted := NewHardwareBaselineService(db, zap.NewNop().Sugar())
+	if err := restarted.Observe("agent-1", testInventory("S4EWNX0R999999", 16<<30)); err != nil {
+		t.Fatal(err)
+	}
+	if report, _ := restarted.Compare("agent-1"); len(report.Changes) != len(changes) {
+		t.Errorf("Expected the baseline to survive a restart, got %+v", report.Changes)
+	}
+}
+
+func TestHardwareBaselinePin(t *testing.T) {
+	baselines := NewHardwareBaselineService(newTestDB(t), zap.NewNop().Sugar())
+
+	if _, err := baselines.Pin("agent-1"); !errors.Is(err, ErrNoHardwareInventory) {
+		t.Errorf("Expected ErrNoHardwareInventory before any report, got %v", err)
+	}
+	if _, err := baselines.Baseline("agent-1"); !errors.Is(err, ErrNoHardwareBaseline) {
+		t.Errorf("Expected ErrNoHardwareBaseline, got %v", err)
+	}
+
+	baselines.Observe("agent-1", testInventory("A", 16<<30))
+	baselines.Observe("agent-1", testInventory("B", 16<<30))
+	if _, err := baselines.Pin("agent-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := baselines.Compare("agent-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Baseline.Pinned || len(report.Changes) != 0 {
+		t.Errorf("Expected no changes against the pinned baseline, got %+v", report)
+	}
+
+	if err := baselines.Forget("agent-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := baselines.Baseline("agent-1"); !errors.Is(err, ErrNoHardwareBaseline) {
+		t.Errorf("Expected the baseline to be dropped, got %v", err)
+	}
+}
+
+func TestHardwareBaselineOutlivesServerAssignedIDs(t *testing.T) {
+	db := newTestDB(t)
+	logger := zap.NewNop().Sugar()
+	baselines := NewHardwareBaselineService(db, logger)
+	agents := NewAgentService(logger, NewMetricsService(logger))
+	agents.SetHardwareBaselines(baselines)
+
+	// A WebSocket agent gets a new ID on every connection
+	for _, memory := range []uint64{32 << 30, 16 << 30} {
+		agent := agents.RegisterAgent(nil, AgentInfo{Hostname: "web-1"}, 0)
+		agents.ReportStaticInfo(agent.ID, &StaticUpdate{Memory: &MemData{Total: memory}})
+		agents.UnregisterAgent(agent.ID)
+	}
+
+	var rows int64
+	db.Model(&database.HardwareBaseline{}).Count(&rows)
+	if rows != 1 {
+		t.Fatalf("Expected one baseline for the host, got %d", rows)
+	}
+	report, err := baselines.Compare("host:web-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changes) != 1 || report.Changes[0].Field != "memory.total" {
+		t.Errorf("Expected the memory change across reconnects, got %+v", report.Changes)
+	}
+}
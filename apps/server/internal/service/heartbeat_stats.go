@@ -0,0 +1,155 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// HeartbeatStats is the latest heartbeat timing of an agent
+type HeartbeatStats struct {
+	// Round trip of the agent's previous heartbeat as measured by the agent,
+	// 0 when not reported
+	RTTMs int64 `json:"rttMs"`
+	// Agent clock minus server clock; positive when the agent is ahead
+	ClockSkewMs    int64     `json:"clockSkewMs"`
+	AgentTimestamp time.Time `json:"agentTimestamp"`
+	ReceivedAt     time.Time `json:"receivedAt"`
+	SkewExceeded   bool      `json:"skewExceeded"`
+}
+
+// ClockSkewAlert is raised when an agent's clock drifts past the limit and
+// again when it comes back within it
+type ClockSkewAlert struct {
+	AgentID     string    `json:"agentId"`
+	ClockSkewMs int64     `json:"clockSkewMs"`
+	MaxSkewMs   int64     `json:"maxSkewMs"`
+	Firing      bool      `json:"firing"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// HeartbeatMonitor estimates each agent's round-trip latency and clock skew
+// from its heartbeats. Large skew breaks features keyed on agent timestamps,
+// such as history queries and alert durations, so it is alerted on.
+type HeartbeatMonitor struct {
+	maxSkew time.Duration
+	stats   map[string]HeartbeatStats
+	onAlert func(ClockSkewAlert)
+	store   *AlertStore
+	clock   Clock
+	mu      sync.Mutex
+}
+
+// NewHeartbeatMonitor creates a monitor alerting when an agent's clock is
+// off by more than maxSkew. A maxSkew of 0 only measures.
+func NewHeartbeatMonitor(maxSkew time.Duration) *HeartbeatMonitor {
+	return &HeartbeatMonitor{
+		maxSkew: maxSkew,
+		stats:   make(map[string]HeartbeatStats),
+		clock:   RealClock,
+	}
+}
+
+// SetClock replaces the time source (for tests)
+func (m *HeartbeatMonitor) SetClock(clock Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clock = clock
+}
+
+// SetAlertHandler sets the callback invoked when a skew alert fires or resolves
+func (m *HeartbeatMonitor) SetAlertHandler(handler func(ClockSkewAlert)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onAlert = handler
+}
+
+// SetStore sets the store that keeps skew alert history
+func (m *HeartbeatMonitor) SetStore(store *AlertStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store = store
+}
+
+// Observe records a heartbeat sent at agentTimestamp by the agent's clock.
+// The agent sent it about half a round trip before it arrived, so skew is
+// measured against the receive time minus rtt/2. A zero agentTimestamp
+// only updates the round trip.
+func (m *HeartbeatMonitor) Observe(agentID string, agentTimestamp time.Time, rtt time.Duration) HeartbeatStats {
+	m.mu.Lock()
+	now := m.clock.Now()
+	stats := m.stats[agentID]
+	wasExceeded := stats.SkewExceeded
+	stats.ReceivedAt = now
+	if rtt > 0 {
+		stats.RTTMs = rtt.Milliseconds()
+	}
+	if !agentTimestamp.IsZero() {
+		sentAt := now.Add(-rtt / 2)
+		skew := agentTimestamp.Sub(sentAt)
+		stats.AgentTimestamp = agentTimestamp
+		stats.ClockSkewMs = skew.Milliseconds()
+		stats.SkewExceeded = m.maxSkew > 0 && (skew > m.maxSkew || skew < -m.maxSkew)
+	}
+	m.stats[agentID] = stats
+	handler, store := m.onAlert, m.store
+	m.mu.Unlock()
+
+	if stats.SkewExceeded != wasExceeded {
+		m.dispatch(handler, store, ClockSkewAlert{
+			AgentID:     agentID,
+			ClockSkewMs: stats.ClockSkewMs,
+			MaxSkewMs:   m.maxSkew.Milliseconds(),
+			Firing:      stats.SkewExceeded,
+			Timestamp:   now,
+		})
+	}
+	return stats
+}
+
+// Stats returns the latest heartbeat timing of an agent
+func (m *HeartbeatMonitor) Stats(agentID string) (HeartbeatStats, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, ok := m.stats[agentID]
+	return stats, ok
+}
+
+// Forget drops an agent's stats, resolving its skew alert if firing
+func (m *HeartbeatMonitor) Forget(agentID string) {
+	m.mu.Lock()
+	_, ok := m.stats[agentID]
+	delete(m.stats, agentID)
+	store := m.store
+	m.mu.Unlock()
+
+	if ok && store != nil {
+		store.Resolve(clockSkewAlertKey(agentID))
+	}
+}
+
+func (m *HeartbeatMonitor) dispatch(handler func(ClockSkewAlert), store *AlertStore, alert ClockSkewAlert) {
+	if store != nil {
+		key := clockSkewAlertKey(alert.AgentID)
+		if alert.Firing {
+			store.Fire(key, AlertRecord{
+				Kind:     AlertKindClockSkew,
+				AgentID:  alert.AgentID,
+				Severity: "warning",
+				Message: fmt.Sprintf("agent clock is off by %s (limit %s)",
+					time.Duration(alert.ClockSkewMs)*time.Millisecond, time.Duration(alert.MaxSkewMs)*time.Millisecond),
+				Data:    alert,
+				FiredAt: alert.Timestamp,
+			})
+		} else {
+			store.Resolve(key)
+		}
+	}
+	if handler != nil {
+		handler(alert)
+	}
+}
+
+func clockSkewAlertKey(agentID string) string {
+	return AlertKindClockSkew + "/" + agentID
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestHeartbeatMonitorComputesClockSkew(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	monitor := NewHeartbeatMonitor(30 * time.Second)
+	monitor.SetClock(NewFakeClock(now))
+	store := NewAlertStore(10, 0)
+	monitor.SetStore(store)
+	var alerts []ClockSkewAlert
+	monitor.SetAlertHandler(func(alert ClockSkewAlert) { alerts = append(alerts, alert) })
+
+	// Agent clock 5 minutes ahead; sent half of a 200ms round trip ago
+	stats := monitor.Observe("agent-1", now.Add(5*time.Minute-100*time.Millisecond), 200*time.Millisecond)
+	if stats.ClockSkewMs != (5 * time.Minute).Milliseconds() {
+		t.Errorf("Expected skew of 300000ms, got %d", stats.ClockSkewMs)
+	}
+	if stats.RTTMs != 200 || !stats.SkewExceeded {
+		t.Errorf("Expected 200ms round trip and exceeded skew, got %+v", stats)
+	}
+	if len(alerts) != 1 || !alerts[0].Firing || alerts[0].MaxSkewMs != 30000 {
+		t.Fatalf("Expected one firing alert, got %+v", alerts)
+	}
+	if page := store.Query(AlertQuery{Kind: AlertKindClockSkew}); page.Total != 1 || !page.Items[0].Firing {
+		t.Errorf("Expected a firing clock skew alert in the store, got %+v", page.Items)
+	}
+
+	// Still skewed: no repeat alert
+	monitor.Observe("agent-1", now.Add(4*time.Minute), 0)
+	if len(alerts) != 1 {
+		t.Errorf("Expected no repeated alert, got %d", len(alerts))
+	}
+
+	// Clock fixed, a little behind: resolves; a zero round trip keeps the last one
+	stats = monitor.Observe("agent-1", now.Add(-2*time.Second), 0)
+	if stats.ClockSkewMs != -2000 || stats.SkewExceeded || stats.RTTMs != 200 {
+		t.Errorf("Expected -2000ms skew within limits, got %+v", stats)
+	}
+	if len(alerts) != 2 || alerts[1].Firing {
+		t.Errorf("Expected a resolving alert, got %+v", alerts)
+	}
+	if page := store.Query(AlertQuery{Kind: AlertKindClockSkew}); page.Items[0].Firing {
+		t.Error("Expected the stored alert to be resolved")
+	}
+}
+
+func TestRecordHeartbeatFeedsMonitor(t *testing.T) {
+	agents := NewAgentService(zap.NewNop().Sugar(), nil)
+	monitor := NewHeartbeatMonitor(0)
+	agents.SetHeartbeatMonitor(monitor)
+
+	// Unknown agents are ignored
+	agents.RecordHeartbeat("agent-1", time.Now(), 0)
+	if _, ok := agents.HeartbeatStats("agent-1"); ok {
+		t.Fatal("Expected no stats for an unknown agent")
+	}
+
+	agents.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "web-1"}, 0)
+	agents.RecordHeartbeat("agent-1", time.Now().Add(time.Hour), 40*time.Millisecond)
+	stats, ok := agents.HeartbeatStats("agent-1")
+	if !ok || stats.ClockSkewMs < (59*time.Minute).Milliseconds() || stats.RTTMs != 40 {
+		t.Errorf("Expected about an hour of skew and 40ms round trip, got %+v", stats)
+	}
+	if stats.SkewExceeded {
+		t.Error("Expected no alerting with a zero limit")
+	}
+
+	agents.UnregisterAgent("agent-1")
+	if _, ok := agents.HeartbeatStats("agent-1"); ok {
+		t.Error("Expected stats to be dropped on disconnect")
+	}
+}
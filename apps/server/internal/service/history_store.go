@@ -0,0 +1,89 @@
+package service
+
+import (
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+)
+
+// HistoryStore keeps the metrics history served by GetMetricsHistory and
+// GetMetricsHistoryRange. Without one, MetricsService serves each agent's
+// most recent samples from its in-memory ring buffer.
+type HistoryStore interface {
+	// Append adds a sample. It is called in arrival order with the agent's
+	// metrics locked, so it must not block.
+	Append(agentID string, data *MetricsData)
+	// Query returns an agent's samples between start and end inclusive,
+	// oldest first. A zero start or end leaves that side of the range open.
+	Query(agentID string, start, end time.Time) []*MetricsData
+}
+
+// History stores selectable with metrics.history_store
+const (
+	HistoryStoreMemory   = "memory"   // the in-memory ring buffer
+	HistoryStoreDatabase = "database" // metrics persistence
+)
+
+// Append persists a full sample, so MetricsPersistence can serve as a
+// history store
+func (mp *MetricsPersistence) Append(agentID string, data *MetricsData) {
+	if data.Incomplete {
+		return
+	}
+	mp.SaveMetricsAsync(agentID, data)
+}
+
+// Query returns an agent's persisted samples between start and end. They
+// hold only the aggregate values persistence keeps. An open start reaches
+// back over the raw retention period.
+func (mp *MetricsPersistence) Query(agentID string, start, end time.Time) []*MetricsData {
+	if end.IsZero() {
+		mp.mu.Lock()
+		end = mp.clock.Now()
+		mp.mu.Unlock()
+	}
+	if start.IsZero() {
+		days := mp.cfg.RetentionDays
+		if days <= 0 {
+			days = 7
+		}
+		start = end.AddDate(0, 0, -days)
+	}
+
+	records, err := mp.QueryHistory(agentID, start, end, 0)
+	if err != nil {
+		mp.logger.Warnf("Failed to query metrics history for %s: %v", agentID, err)
+		return nil
+	}
+	samples := make([]*MetricsData, 0, len(records))
+	for _, r := range records {
+		samples = append(samples, historySample(r))
+	}
+	return samples
+}
+
+// historySample expands a persisted record into a sample, with the
+// aggregates as single "total" devices and memory as a ratio of 10000
+func historySample(r database.MetricsHistory) *MetricsData {
+	// The live sample shape has no unknown disk usage; rows from before it
+	// was recorded read as empty
+	var diskPercent float64
+	if r.DiskPercent != nil {
+		diskPercent = *r.DiskPercent
+	}
+	return &MetricsData{
+		AgentID:   r.AgentID,
+		Timestamp: r.Timestamp,
+		CPU:       CPUData{UsagePercent: r.CPUPercent},
+		Memory:    MemData{Total: 10000, Used: uint64(r.MemPercent * 100)},
+		Disks: []DiskData{{
+			Device:       "total",
+			UsagePercent: diskPercent,
+			ReadBytesPS:  r.DiskReadPS,
+			WriteBytesPS: r.DiskWritePS,
+		}},
+		Networks:    []NetData{{Interface: "total", RxBytesPS: r.NetRxPS, TxBytesPS: r.NetTxPS}},
+		GPUs:        []GPUData{{UsagePercent: r.GPUPercent}},
+		LoadAverage: []float64{r.LoadAvg1},
+	}
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"go.uber.org/zap"
+)
+
+// sliceHistoryStore keeps every sample, like an external TSDB would
+type sliceHistoryStore struct {
+	samples map[string][]*MetricsData
+	queried [2]time.Time // the range last asked for
+}
+
+func (s *sliceHistoryStore) Append(agentID string, data *MetricsData) {
+	s.samples[agentID] = append(s.samples[agentID], data)
+}
+
+func (s *sliceHistoryStore) Query(agentID string, start, end time.Time) []*MetricsData {
+	s.queried = [2]time.Time{start, end}
+	var result []*MetricsData
+	for _, d := range s.samples[agentID] {
+		if !d.Timestamp.Before(start) && (end.IsZero() || !d.Timestamp.After(end)) {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+func TestHistoryStoreServesHistoryQueries(t *testing.T) {
+	base := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(base)
+	ms := NewMetricsService(zap.NewNop().Sugar())
+	ms.SetClock(clock)
+	store := &sliceHistoryStore{samples: make(map[string][]*MetricsData)}
+	ms.SetHistoryStore(store)
+
+	for i := 0; i < 5; i++ {
+		ms.StoreMetrics("agent-1", &MetricsData{CPU: CPUData{UsagePercent: float64(i)}})
+		clock.Advance(time.Minute)
+	}
+	if len(store.samples["agent-1"]) != 5 {
+		t.Fatalf("Expected every sample to reach the store, got %d", len(store.samples["agent-1"]))
+	}
+
+	recent := ms.GetMetricsHistory("agent-1", 2)
+	if len(recent) != 2 || recent[0].CPU.UsagePercent != 3 || recent[1].CPU.UsagePercent != 4 {
+		t.Errorf("Expected the two most recent samples from the store, got %d", len(recent))
+	}
+	if got := ms.GetMetricsHistoryRange("agent-1", base.Add(time.Minute), base.Add(2*time.Minute)); len(got) != 2 {
+		t.Errorf("Expected the range to be served by the store, got %d samples", len(got))
+	}
+
+	// The ring buffer is still kept for fleet-wide reads and as the fallback
+	if got := ms.GetAllMetricsHistory(0)["agent-1"]; len(got) != 5 {
+		t.Errorf("Expected the ring buffer to keep filling, got %d samples", len(got))
+	}
+	store.samples = make(map[string][]*MetricsData)
+	ms.SetHistoryStore(nil)
+	if got := ms.GetMetricsHistory("agent-1", 0); len(got) != 5 {
+		t.Errorf("Expected the ring buffer to serve queries without a store, got %d", len(got))
+	}
+}
+
+func TestPersistenceAsHistoryStore(t *testing.T) {
+	base := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(base)
+	backend := &memoryBackend{}
+	mp := NewMetricsPersistenceWithBackend(backend, config.MetricsConfig{PersistToDB: true, RetentionDays: 7}, zap.NewNop().Sugar())
+	mp.SetClock(clock)
+	ms := NewMetricsService(zap.NewNop().Sugar())
+	ms.SetClock(clock)
+	ms.SetPersistence(mp)
+	ms.SetHistoryStore(mp)
+
+	for i := 0; i < 3; i++ {
+		ms.StoreMetrics("agent-1", &MetricsData{
+			CPU:    CPUData{UsagePercent: float64(10 * i)},
+			Memory: MemData{Total: 1000, Used: 250},
+		})
+		clock.Advance(time.Minute)
+	}
+	if err := mp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Persistence that is also the history store saves each sample once
+	if len(backend.records) != 3 {
+		t.Fatalf("Expected 3 persisted samples, got %d", len(backend.records))
+	}
+	history := ms.GetMetricsHistory("agent-1", 0)
+	if len(history) != 3 || history[2].CPU.UsagePercent != 20 || history[2].Memory.Used != 2500 {
+		t.Errorf("Expected the persisted samples back, got %+v", history)
+	}
+	if got := ms.GetMetricsHistoryRange("agent-1", base.Add(time.Minute), time.Time{}); len(got) != 2 {
+		t.Errorf("Expected 2 samples from the second minute on, got %d", len(got))
+	}
+}
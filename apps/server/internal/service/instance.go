@@ -0,0 +1,156 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// AgentDirectory records which server instance holds each agent's
+// connection. Multi-instance deployments plug in a shared implementation
+// (database, cache, gossip) so any instance can locate any agent and route
+// commands to the instance that owns its stream.
+type AgentDirectory interface {
+	// Claim records instanceID as the owner of the agent's connection
+	Claim(agentID, instanceID string) error
+	// Release drops the claim if instanceID still owns the agent
+	Release(agentID, instanceID string)
+	// Owner returns the instance currently holding the agent's connection
+	Owner(agentID string) (string, bool)
+}
+
+// ResolveInstanceID returns the configured instance ID. Without one, the ID
+// stored in stateFile is reused so it stays the same across restarts; on
+// first start one is generated from the hostname and stored there.
+func ResolveInstanceID(configured, stateFile string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+	data, err := os.ReadFile(stateFile)
+	if err == nil {
+		if stored := strings.TrimSpace(string(data)); stored != "" {
+			return stored, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read instance ID: %w", err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "nanolink"
+	}
+	instanceID := hostname + "-" + uuid.New().String()[:8]
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0755); err != nil {
+		return "", fmt.Errorf("failed to create instance ID directory: %w", err)
+	}
+	if err := os.WriteFile(stateFile, []byte(instanceID+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("failed to store instance ID: %w", err)
+	}
+	return instanceID, nil
+}
+
+// MemoryDirectory is an in-process AgentDirectory. It only knows about
+// agents on instances sharing the same value, which makes it suitable for
+// single-instance deployments and tests.
+type MemoryDirectory struct {
+	owners map[string]string
+	mu     sync.RWMutex
+}
+
+// NewMemoryDirectory creates an empty in-process directory
+func NewMemoryDirectory() *MemoryDirectory {
+	return &MemoryDirectory{owners: make(map[string]string)}
+}
+
+// Claim records the owner of an agent's connection
+func (d *MemoryDirectory) Claim(agentID, instanceID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.owners[agentID] = instanceID
+	return nil
+}
+
+// Release drops an ownership claim unless another instance has taken over
+func (d *MemoryDirectory) Release(agentID, instanceID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.owners[agentID] == instanceID {
+		delete(d.owners, agentID)
+	}
+}
+
+// Owner returns the instance holding an agent's connection
+func (d *MemoryDirectory) Owner(agentID string) (string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	owner, ok := d.owners[agentID]
+	return owner, ok
+}
+
+// SetInstance sets this server's instance ID and the directory used to
+// share agent ownership with other instances (nil for a single instance)
+func (s *AgentService) SetInstance(instanceID string, directory AgentDirectory) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instanceID = instanceID
+	s.directory = directory
+}
+
+// InstanceID returns this server's instance ID
+func (s *AgentService) InstanceID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.instanceID
+}
+
+// AgentOwner returns the instance holding an agent's connection and whether
+// that is this instance. Agents connected elsewhere are found through the
+// directory.
+func (s *AgentService) AgentOwner(agentID string) (instanceID string, local bool, ok bool) {
+	s.mu.RLock()
+	_, connected := s.agents[agentID]
+	instanceID, directory := s.instanceID, s.directory
+	s.mu.RUnlock()
+
+	if connected {
+		return instanceID, true, true
+	}
+	if directory == nil {
+		return "", false, false
+	}
+	owner, found := directory.Owner(agentID)
+	if !found {
+		return "", false, false
+	}
+	return owner, owner == instanceID, true
+}
+
+// claimAgent publishes this instance as the owner of a new connection
+func (s *AgentService) claimAgent(agent *Agent) {
+	s.mu.RLock()
+	instanceID, directory := s.instanceID, s.directory
+	s.mu.RUnlock()
+
+	agent.InstanceID = instanceID
+	if directory == nil {
+		return
+	}
+	if err := directory.Claim(agent.ID, instanceID); err != nil {
+		s.logger.Warnf("Failed to publish ownership of agent %s: %v", agent.ID, err)
+	}
+}
+
+// releaseAgent withdraws this instance's ownership claim
+func (s *AgentService) releaseAgent(agentID string) {
+	s.mu.RLock()
+	instanceID, directory := s.instanceID, s.directory
+	s.mu.RUnlock()
+
+	if directory != nil {
+		directory.Release(agentID, instanceID)
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestAgentOwnerReportsOwningInstance(t *testing.T) {
+	directory := NewMemoryDirectory()
+	instanceA := NewAgentService(zap.NewNop().Sugar(), NewMetricsService(zap.NewNop().Sugar()))
+	instanceA.SetInstance("server-a", directory)
+	instanceB := NewAgentService(zap.NewNop().Sugar(), NewMetricsService(zap.NewNop().Sugar()))
+	instanceB.SetInstance("server-b", directory)
+
+	agent := instanceB.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "web-1"}, 0)
+	if agent.InstanceID != "server-b" {
+		t.Errorf("Expected agent instance server-b, got %s", agent.InstanceID)
+	}
+
+	owner, local, ok := instanceB.AgentOwner("agent-1")
+	if !ok || !local || owner != "server-b" {
+		t.Errorf("Expected server-b to own agent locally, got owner=%s local=%v ok=%v", owner, local, ok)
+	}
+
+	owner, local, ok = instanceA.AgentOwner("agent-1")
+	if !ok || local || owner != "server-b" {
+		t.Errorf("Expected server-a to report remote owner server-b, got owner=%s local=%v ok=%v", owner, local, ok)
+	}
+
+	instanceB.UnregisterAgent("agent-1")
+	if _, _, ok := instanceA.AgentOwner("agent-1"); ok {
+		t.Error("Expected no owner after the agent disconnected")
+	}
+}
+
+func TestAgentMovingInstancesKeepsNewOwner(t *testing.T) {
+	directory := NewMemoryDirectory()
+	instanceA := NewAgentService(zap.NewNop().Sugar(), NewMetricsService(zap.NewNop().Sugar()))
+	instanceA.SetInstance("server-a", directory)
+	instanceB := NewAgentService(zap.NewNop().Sugar(), NewMetricsService(zap.NewNop().Sugar()))
+	instanceB.SetInstance("server-b", directory)
+
+	// The agent reconnects to B before A notices the old stream is gone
+	instanceA.RegisterGrpcAgent("agent-1", AgentInfo{}, 0)
+	instanceB.RegisterGrpcAgent("agent-1", AgentInfo{}, 0)
+	instanceA.UnregisterAgent("agent-1")
+
+	owner, _, ok := instanceA.AgentOwner("agent-1")
+	if !ok || owner != "server-b" {
+		t.Errorf("Expected server-b to keep ownership, got owner=%s ok=%v", owner, ok)
+	}
+}
+
+func TestAgentOwnerWithoutDirectory(t *testing.T) {
+	agents := NewAgentService(zap.NewNop().Sugar(), NewMetricsService(zap.NewNop().Sugar()))
+	agents.SetInstance("solo", nil)
+	agents.RegisterGrpcAgent("agent-1", AgentInfo{}, 0)
+
+	if owner, local, ok := agents.AgentOwner("agent-1"); !ok || !local || owner != "solo" {
+		t.Errorf("Expected local owner solo, got owner=%s local=%v ok=%v", owner, local, ok)
+	}
+	if _, _, ok := agents.AgentOwner("unknown"); ok {
+		t.Error("Expected unknown agent to have no owner")
+	}
+}
+
+func TestResolveInstanceIDIsStableAcrossRestarts(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "data", "instance_id")
+
+	first, err := ResolveInstanceID("", stateFile)
+	if err != nil || first == "" {
+		t.Fatalf("Expected a generated instance ID, got %q (%v)", first, err)
+	}
+	again, err := ResolveInstanceID("", stateFile)
+	if err != nil || again != first {
+		t.Errorf("Expected the stored instance ID %q after a restart, got %q (%v)", first, again, err)
+	}
+	if configured, _ := ResolveInstanceID("server-a", stateFile); configured != "server-a" {
+		t.Errorf("Expected the configured instance ID to win, got %q", configured)
+	}
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// GeoInfo holds optional ASN/country enrichment for an agent's source IP
+type GeoInfo struct {
+	Country string `json:"country,omitempty"`
+	ASN     uint32 `json:"asn,omitempty"`
+	Org     string `json:"org,omitempty"`
+}
+
+// IPLookup resolves geo/ASN information for an IP address.
+// Implementations may wrap a local GeoIP database or an external service.
+type IPLookup interface {
+	Lookup(ip string) (*GeoInfo, error)
+}
+
+// IPChangeEvent is raised when a known agent connects from a different source IP
+type IPChangeEvent struct {
+	AgentID    string    `json:"agentId"`
+	Hostname   string    `json:"hostname"`
+	PreviousIP string    `json:"previousIp"`
+	CurrentIP  string    `json:"currentIp"`
+	Geo        *GeoInfo  `json:"geo,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// IPTracker remembers the last source IP seen for each agent identity so
+// that unexpected changes (possible compromise or relocation) can be
+// flagged. State survives agent disconnects for the lifetime of the server.
+type IPTracker struct {
+	lastIPs       map[string]string
+	lookup        IPLookup
+	alertOnChange bool
+	onChange      func(IPChangeEvent)
+	mu            sync.RWMutex
+	logger        *zap.SugaredLogger
+}
+
+// NewIPTracker creates a new IP tracker
+func NewIPTracker(logger *zap.SugaredLogger, alertOnChange bool) *IPTracker {
+	return &IPTracker{
+		lastIPs:       make(map[string]string),
+		alertOnChange: alertOnChange,
+		logger:        logger,
+	}
+}
+
+// SetLookup sets the optional geo/ASN lookup used to enrich source IPs
+func (t *IPTracker) SetLookup(lookup IPLookup) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lookup = lookup
+}
+
+// SetChangeHandler sets the callback invoked when an agent's source IP changes
+func (t *IPTracker) SetChangeHandler(handler func(IPChangeEvent)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onChange = handler
+}
+
+// Track records the source IP for an agent and returns enrichment data if a
+// lookup is configured. An alert is raised when the IP differs from the last
+// one seen for the same identity, which outlives the agent's connection ID
+// (see AgentService.Identity).
+func (t *IPTracker) Track(identity, agentID, hostname, ip string) *GeoInfo {
+	if ip == "" {
+		return nil
+	}
+
+	t.mu.Lock()
+	previous, known := t.lastIPs[identity]
+	t.lastIPs[identity] = ip
+	lookup := t.lookup
+	onChange := t.onChange
+	t.mu.Unlock()
+
+	var geo *GeoInfo
+	if lookup != nil {
+		info, err := lookup.Lookup(ip)
+		if err != nil {
+			t.logger.Debugf("IP lookup failed for %s: %v", ip, err)
+		} else {
+			geo = info
+		}
+	}
+
+	if known && previous != ip && t.alertOnChange {
+		t.logger.Warnf("Agent %s (%s) source IP changed: %s -> %s", hostname, agentID, previous, ip)
+		if onChange != nil {
+			onChange(IPChangeEvent{
+				AgentID:    agentID,
+				Hostname:   hostname,
+				PreviousIP: previous,
+				CurrentIP:  ip,
+				Geo:        geo,
+				Timestamp:  time.Now(),
+			})
+		}
+	}
+
+	return geo
+}
+
+// LastIP returns the last source IP recorded for an agent identity
+func (t *IPTracker) LastIP(identity string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.lastIPs[identity]
+}
+
+// HostFromAddr extracts the IP portion of a "host:port" peer address
+func HostFromAddr(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+type staticLookup struct {
+	geo *GeoInfo
+}
+
+func (l *staticLookup) Lookup(ip string) (*GeoInfo, error) {
+	return l.geo, nil
+}
+
+func TestRecordSourceIP(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+	tracker := NewIPTracker(logger, true)
+	tracker.SetLookup(&staticLookup{geo: &GeoInfo{Country: "DE", ASN: 64500}})
+	as.SetIPTracker(tracker)
+
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	as.RecordSourceIP("agent-1", "10.0.0.5")
+
+	agent := as.GetAgent("agent-1")
+	if agent.SourceIP != "10.0.0.5" {
+		t.Errorf("Expected source IP 10.0.0.5, got %q", agent.SourceIP)
+	}
+	if agent.Geo == nil || agent.Geo.Country != "DE" || agent.Geo.ASN != 64500 {
+		t.Errorf("Expected geo enrichment, got %+v", agent.Geo)
+	}
+}
+
+func TestRecordSourceIPWithoutLookup(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+	as.SetIPTracker(NewIPTracker(logger, true))
+
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	as.RecordSourceIP("agent-1", "10.0.0.5")
+
+	agent := as.GetAgent("agent-1")
+	if agent.SourceIP != "10.0.0.5" {
+		t.Errorf("Expected source IP 10.0.0.5, got %q", agent.SourceIP)
+	}
+	if agent.Geo != nil {
+		t.Errorf("Expected no geo info without lookup, got %+v", agent.Geo)
+	}
+}
+
+func TestIPChangeRaisesAlert(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+	tracker := NewIPTracker(logger, true)
+	as.SetIPTracker(tracker)
+
+	var events []IPChangeEvent
+	tracker.SetChangeHandler(func(e IPChangeEvent) {
+		events = append(events, e)
+	})
+
+	// First connection establishes the baseline
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	as.RecordSourceIP("agent-1", "10.0.0.5")
+	as.UnregisterAgent("agent-1")
+
+	// Reconnect from the same IP must not alert
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	as.RecordSourceIP("agent-1", "10.0.0.5")
+	as.UnregisterAgent("agent-1")
+	if len(events) != 0 {
+		t.Fatalf("Expected no alert for unchanged IP, got %d", len(events))
+	}
+
+	// Reconnect from a different IP
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	as.RecordSourceIP("agent-1", "203.0.113.7")
+
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 alert, got %d", len(events))
+	}
+	if events[0].PreviousIP != "10.0.0.5" || events[0].CurrentIP != "203.0.113.7" {
+		t.Errorf("Unexpected alert: %+v", events[0])
+	}
+	if events[0].Hostname != "host-1" {
+		t.Errorf("Expected hostname host-1, got %q", events[0].Hostname)
+	}
+}
+
+func TestIPChangeRaisesAlertForWebSocketAgents(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	as := NewAgentService(logger, NewMetricsService(logger))
+	tracker := NewIPTracker(logger, true)
+	as.SetIPTracker(tracker)
+
+	var events []IPChangeEvent
+	tracker.SetChangeHandler(func(e IPChangeEvent) {
+		events = append(events, e)
+	})
+
+	// WebSocket agents get a new ID per connection; the hostname carries
+	// the baseline over
+	first := as.RegisterAgent(nil, AgentInfo{Hostname: "host-1"}, 0)
+	as.RecordSourceIP(first.ID, "10.0.0.5")
+	as.UnregisterAgent(first.ID)
+
+	second := as.RegisterAgent(nil, AgentInfo{Hostname: "host-1"}, 0)
+	as.RecordSourceIP(second.ID, "203.0.113.7")
+
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 alert, got %d", len(events))
+	}
+	if events[0].AgentID != second.ID || events[0].PreviousIP != "10.0.0.5" {
+		t.Errorf("Unexpected alert: %+v", events[0])
+	}
+}
+
+func TestIPChangeAlertDisabled(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	tracker := NewIPTracker(logger, false)
+
+	alerted := false
+	tracker.SetChangeHandler(func(e IPChangeEvent) {
+		alerted = true
+	})
+
+	tracker.Track("agent-1", "agent-1", "host-1", "10.0.0.5")
+	tracker.Track("agent-1", "agent-1", "host-1", "10.0.0.6")
+
+	if alerted {
+		t.Error("Expected no alert when alerting is disabled")
+	}
+	if ip := tracker.LastIP("agent-1"); ip != "10.0.0.6" {
+		t.Errorf("Expected last IP 10.0.0.6, got %q", ip)
+	}
+}
+
+func TestHostFromAddr(t *testing.T) {
+	tests := []struct {
+		addr     string
+		expected string
+	}{
+		{"10.0.0.5:39100", "10.0.0.5"},
+		{"[2001:db8::1]:39100", "2001:db8::1"},
+		{"10.0.0.5", "10.0.0.5"},
+	}
+
+	for _, tt := range tests {
+		if got := HostFromAddr(tt.addr); got != tt.expected {
+			t.Errorf("HostFromAddr(%q) = %q, want %q", tt.addr, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Shutdowner is a background service drained when the server stops. It
+// should return once its work is flushed or ctx is done, whichever is first.
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// ShutdownFunc adapts a function to Shutdowner
+type ShutdownFunc func(ctx context.Context) error
+
+// Shutdown calls f
+func (f ShutdownFunc) Shutdown(ctx context.Context) error {
+	return f(ctx)
+}
+
+type lifecycleEntry struct {
+	name    string
+	service Shutdowner
+}
+
+// Lifecycle drains background services on shutdown. Services are stopped in
+// reverse registration order, so registering each one after the services it
+// depends on stops request intake first and storage last.
+type Lifecycle struct {
+	entries  []lifecycleEntry
+	shutdown bool
+	logger   *zap.SugaredLogger
+	mu       sync.Mutex
+}
+
+// NewLifecycle creates an empty registry
+func NewLifecycle(logger *zap.SugaredLogger) *Lifecycle {
+	return &Lifecycle{logger: logger}
+}
+
+// Register adds a service to drain on shutdown
+func (l *Lifecycle) Register(name string, service Shutdowner) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, lifecycleEntry{name: name, service: service})
+}
+
+// Shutdown stops every registered service under the shared deadline of ctx.
+// A service that fails or runs out of time does not keep the rest from
+// being stopped; all errors are returned together. Only the first call
+// does anything.
+func (l *Lifecycle) Shutdown(ctx context.Context) error {
+	l.mu.Lock()
+	if l.shutdown {
+		l.mu.Unlock()
+		return nil
+	}
+	l.shutdown = true
+	entries := l.entries
+	l.mu.Unlock()
+
+	var errs []error
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if err := entry.service.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.name, err))
+			if l.logger != nil {
+				l.logger.Errorf("Failed to stop %s: %v", entry.name, err)
+			}
+			continue
+		}
+		if l.logger != nil {
+			l.logger.Infof("Stopped %s", entry.name)
+		}
+	}
+	return errors.Join(errs...)
+}
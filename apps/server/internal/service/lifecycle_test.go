@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+)
+
+func TestLifecycleStopsServicesInReverseOrder(t *testing.T) {
+	lifecycle := NewLifecycle(zap.NewNop().Sugar())
+	var stopped []string
+	for _, name := range []string{"database", "persistence", "server"} {
+		name := name
+		lifecycle.Register(name, ShutdownFunc(func(context.Context) error {
+			stopped = append(stopped, name)
+			if name == "persistence" {
+				return errors.New("flush failed")
+			}
+			return nil
+		}))
+	}
+
+	err := lifecycle.Shutdown(context.Background())
+	if err == nil || err.Error() != "persistence: flush failed" {
+		t.Errorf("Expected the persistence error, got %v", err)
+	}
+	// A failing service does not keep later ones from stopping
+	if len(stopped) != 3 || stopped[0] != "server" || stopped[2] != "database" {
+		t.Errorf("Expected server, persistence, database, got %v", stopped)
+	}
+
+	if err := lifecycle.Shutdown(context.Background()); err != nil || len(stopped) != 3 {
+		t.Errorf("Expected a second shutdown to do nothing, got %v %v", err, stopped)
+	}
+}
+
+func TestShutdownFlushesPendingPersistenceWrites(t *testing.T) {
+	backend := &blockingBackend{release: make(chan struct{})}
+	mp := NewMetricsPersistenceWithBackend(backend, config.MetricsConfig{PersistToDB: true}, zap.NewNop().Sugar())
+	mp.Start()
+	ms := NewMetricsService(zap.NewNop().Sugar())
+	ms.SetPersistence(mp)
+
+	lifecycle := NewLifecycle(zap.NewNop().Sugar())
+	lifecycle.Register("metrics persistence", mp)
+
+	ms.StoreMetrics("agent-1", &MetricsData{})
+
+	// The write is still blocked when shutdown starts; shutdown waits for it
+	done := make(chan error)
+	go func() { done <- lifecycle.Shutdown(context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Expected shutdown to wait for the pending write, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(backend.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := backend.saved(); n != 1 {
+		t.Errorf("Expected the pending write to be flushed, got %d records", n)
+	}
+
+	// Samples after shutdown are not written
+	ms.StoreMetrics("agent-1", &MetricsData{})
+	time.Sleep(20 * time.Millisecond)
+	if n := backend.saved(); n != 1 {
+		t.Errorf("Expected no writes after shutdown, got %d records", n)
+	}
+}
+
+func TestPersistenceShutdownGivesUpAtDeadline(t *testing.T) {
+	backend := &blockingBackend{release: make(chan struct{})}
+	defer close(backend.release)
+	mp := NewMetricsPersistenceWithBackend(backend, config.MetricsConfig{PersistToDB: true}, zap.NewNop().Sugar())
+	mp.SaveMetricsAsync("agent-1", &MetricsData{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := mp.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+}
+
+// blockingBackend holds every save until release is closed
+type blockingBackend struct {
+	memoryBackend
+	release chan struct{}
+}
+
+func (b *blockingBackend) Save(record database.MetricsHistory) error {
+	<-b.release
+	return b.memoryBackend.Save(record)
+}
+
+func (b *blockingBackend) saved() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.records)
+}
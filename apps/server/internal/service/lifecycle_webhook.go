@@ -0,0 +1,479 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Agent lifecycle event types
+const (
+	LifecycleDiscovered      = "agent.discovered"
+	LifecycleReconnected     = "agent.reconnected"
+	LifecycleInventory       = "agent.inventory"
+	LifecycleHardwareChanged = "agent.hardware_changed"
+	LifecycleDeregistered    = "agent.deregistered"
+)
+
+// Headers set on lifecycle webhook requests
+const (
+	LifecycleEventHeader     = "X-NanoLink-Event"
+	LifecycleSignatureHeader = "X-NanoLink-Signature"
+)
+
+// HardwareInventory is the hardware description sent to external inventories
+type HardwareInventory struct {
+	CPUModel      string           `json:"cpuModel,omitempty"`
+	CPUVendor     string           `json:"cpuVendor,omitempty"`
+	PhysicalCores int              `json:"physicalCores,omitempty"`
+	LogicalCores  int              `json:"logicalCores,omitempty"`
+	MemoryTotal   uint64           `json:"memoryTotal,omitempty"`
+	Disks         []InventoryDisk  `json:"disks,omitempty"`
+	Networks      []InventoryNIC   `json:"networks,omitempty"`
+	GPUs          []InventoryChip  `json:"gpus,omitempty"`
+	NPUs          []InventoryChip  `json:"npus,omitempty"`
+	System        *InventorySystem `json:"system,omitempty"`
+}
+
+// InventoryDisk describes a physical disk
+type InventoryDisk struct {
+	Device string `json:"device"`
+	Model  string `json:"model,omitempty"`
+	Serial string `json:"serial,omitempty"`
+	Total  uint64 `json:"total,omitempty"`
+}
+
+// InventoryNIC describes a network interface
+type InventoryNIC struct {
+	Interface  string `json:"interface"`
+	MacAddress string `json:"macAddress,omitempty"`
+}
+
+// InventoryChip describes a GPU or NPU
+type InventoryChip struct {
+	Index       int    `json:"index"`
+	Name        string `json:"name,omitempty"`
+	Vendor      string `json:"vendor,omitempty"`
+	MemoryTotal uint64 `json:"memoryTotal,omitempty"`
+}
+
+// InventorySystem describes the machine and its operating system
+type InventorySystem struct {
+	OsName        string `json:"osName,omitempty"`
+	OsVersion     string `json:"osVersion,omitempty"`
+	KernelVersion string `json:"kernelVersion,omitempty"`
+	SystemModel   string `json:"systemModel,omitempty"`
+	SystemVendor  string `json:"systemVendor,omitempty"`
+	BiosVersion   string `json:"biosVersion,omitempty"`
+}
+
+// Kinds of hardware change
+const (
+	HardwareAdded   = "added"
+	HardwareRemoved = "removed"
+	HardwareChanged = "changed"
+)
+
+// HardwareChange is a single difference between two inventories
+type HardwareChange struct {
+	Field    string `json:"field"`
+	Kind     string `json:"kind"`
+	Previous string `json:"previous,omitempty"`
+	Current  string `json:"current,omitempty"`
+}
+
+// LifecycleEvent is the webhook payload
+type LifecycleEvent struct {
+	Event     string             `json:"event"`
+	AgentID   string             `json:"agentId"`
+	Hostname  string             `json:"hostname,omitempty"`
+	OS        string             `json:"os,omitempty"`
+	Arch      string             `json:"arch,omitempty"`
+	Version   string             `json:"version,omitempty"`
+	Inventory *HardwareInventory `json:"inventory,omitempty"`
+	Changes   []HardwareChange   `json:"changes,omitempty"`
+	Timestamp time.Time          `json:"timestamp"`
+}
+
+// LifecycleNotifier tells an external inventory about agents as they come
+// and go. Known agents and their last inventory are stored by identity (see
+// AgentService.Identity), so neither a server restart nor an agent that
+// gets a new ID per connection is reported as discovered again.
+type LifecycleNotifier struct {
+	url    string
+	secret string
+	events map[string]bool
+	client *http.Client
+	db     *gorm.DB
+	mu     sync.Mutex
+
+	clock   Clock
+	deliver func(LifecycleEvent)
+	logger  *zap.SugaredLogger
+
+	// Webhook posts still in flight
+	inFlight sync.WaitGroup
+}
+
+// NewLifecycleNotifier creates a notifier posting to the configured URL
+func NewLifecycleNotifier(db *gorm.DB, cfg config.LifecycleWebhookConfig, logger *zap.SugaredLogger) *LifecycleNotifier {
+	timeout := time.Duration(cfg.TimeoutSec) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	n := &LifecycleNotifier{
+		url:    cfg.URL,
+		secret: cfg.Secret,
+		client: &http.Client{Timeout: timeout},
+		db:     db,
+		clock:  RealClock,
+		logger: logger,
+	}
+	if len(cfg.Events) > 0 {
+		n.events = make(map[string]bool, len(cfg.Events))
+		for _, e := range cfg.Events {
+			n.events[e] = true
+		}
+	}
+	n.deliver = func(event LifecycleEvent) {
+		n.inFlight.Add(1)
+		go func() {
+			defer n.inFlight.Done()
+			if err := n.post(context.Background(), event); err != nil {
+				n.logger.Warnf("Lifecycle webhook %s for agent %s failed: %v", event.Event, event.AgentID, err)
+			}
+		}()
+	}
+	return n
+}
+
+// Shutdown waits for webhook posts in flight to finish or ctx to be done
+func (n *LifecycleNotifier) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		n.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("lifecycle webhooks still in flight: %w", ctx.Err())
+	}
+}
+
+// SetClock replaces the time source (for tests)
+func (n *LifecycleNotifier) SetClock(clock Clock) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.clock = clock
+}
+
+// AgentConnected reports an agent as discovered the first time it is seen
+// and as reconnected afterwards
+func (n *LifecycleNotifier) AgentConnected(agentID, identity string, info AgentInfo) {
+	now := n.now()
+	result := n.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&database.AgentInventory{Identity: identity, FirstSeenAt: now, UpdatedAt: now})
+	if result.Error != nil {
+		n.logger.Warnf("Failed to record agent %s for lifecycle webhooks: %v", agentID, result.Error)
+		return
+	}
+	event := LifecycleDiscovered
+	var inventory *HardwareInventory
+	if result.RowsAffected == 0 {
+		event = LifecycleReconnected
+		var err error
+		if inventory, err = n.inventory(identity); err != nil {
+			n.logger.Warnf("Failed to load inventory of agent %s: %v", agentID, err)
+		}
+	}
+
+	n.emit(LifecycleEvent{
+		Event:     event,
+		AgentID:   agentID,
+		Hostname:  info.Hostname,
+		OS:        info.OS,
+		Arch:      info.Arch,
+		Version:   info.Version,
+		Inventory: inventory,
+	})
+}
+
+// StaticInfoReported records an agent's hardware. The first report is sent
+// as the agent's inventory; later reports are diffed against the last known
+// inventory and sent as a hardware change when anything differs.
+func (n *LifecycleNotifier) StaticInfoReported(agentID, identity string, info AgentInfo, update *StaticUpdate) {
+	if update == nil {
+		return
+	}
+	inventory := InventoryFromStatic(update)
+
+	previous, err := n.inventory(identity)
+	if err == nil {
+		err = n.storeInventory(identity, inventory)
+	}
+	if err != nil {
+		n.logger.Warnf("Failed to record inventory of agent %s: %v", agentID, err)
+		return
+	}
+
+	event := LifecycleEvent{
+		Event:     LifecycleInventory,
+		AgentID:   agentID,
+		Hostname:  info.Hostname,
+		OS:        info.OS,
+		Arch:      info.Arch,
+		Version:   info.Version,
+		Inventory: inventory,
+	}
+	if previous != nil {
+		event.Changes = DiffInventory(previous, inventory)
+		if len(event.Changes) == 0 {
+			return
+		}
+		event.Event = LifecycleHardwareChanged
+		n.logger.Infof("Hardware change detected on agent %s (%s): %d field(s)", info.Hostname, agentID, len(event.Changes))
+	}
+	n.emit(event)
+}
+
+// AgentDeregistered reports an agent as permanently removed and forgets it
+func (n *LifecycleNotifier) AgentDeregistered(agentID, identity string, info AgentInfo) {
+	inventory, err := n.inventory(identity)
+	if err == nil {
+		err = n.db.Delete(&database.AgentInventory{}, "identity = ?", identity).Error
+	}
+	if err != nil {
+		n.logger.Warnf("Failed to forget inventory of agent %s: %v", agentID, err)
+	}
+
+	n.emit(LifecycleEvent{
+		Event:     LifecycleDeregistered,
+		AgentID:   agentID,
+		Hostname:  info.Hostname,
+		OS:        info.OS,
+		Arch:      info.Arch,
+		Version:   info.Version,
+		Inventory: inventory,
+	})
+}
+
+// inventory returns the last inventory stored for an agent, if any
+func (n *LifecycleNotifier) inventory(identity string) (*HardwareInventory, error) {
+	var record database.AgentInventory
+	err := n.db.Where("identity = ?", identity).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && record.Inventory == "") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var inv HardwareInventory
+	if err := json.Unmarshal([]byte(record.Inventory), &inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// storeInventory stores an agent's latest inventory
+func (n *LifecycleNotifier) storeInventory(identity string, inv *HardwareInventory) error {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	now := n.now()
+	record := database.AgentInventory{Identity: identity, Inventory: string(data), FirstSeenAt: now, UpdatedAt: now}
+	return n.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "identity"}},
+		DoUpdates: clause.AssignmentColumns([]string{"inventory", "updated_at"}),
+	}).Create(&record).Error
+}
+
+func (n *LifecycleNotifier) now() time.Time {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.clock.Now()
+}
+
+func (n *LifecycleNotifier) emit(event LifecycleEvent) {
+	if n.events != nil && !n.events[event.Event] {
+		return
+	}
+	n.mu.Lock()
+	event.Timestamp = n.clock.Now()
+	deliver := n.deliver
+	n.mu.Unlock()
+	deliver(event)
+}
+
+// post sends one event to the webhook endpoint
+func (n *LifecycleNotifier) post(ctx context.Context, event LifecycleEvent) error {
+	if n.url == "" {
+		return nil
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(LifecycleEventHeader, event.Event)
+	if n.secret != "" {
+		req.Header.Set(LifecycleSignatureHeader, "sha256="+SignWebhookBody(n.secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SignWebhookBody returns the hex HMAC-SHA256 of body keyed with secret
+func SignWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// InventoryFromStatic extracts the hardware inventory from static info
+func InventoryFromStatic(st *StaticUpdate) *HardwareInventory {
+	inv := &HardwareInventory{}
+	if st.CPU != nil {
+		inv.CPUModel = st.CPU.Model
+		inv.CPUVendor = st.CPU.Vendor
+		inv.PhysicalCores = st.CPU.PhysicalCores
+		inv.LogicalCores = st.CPU.LogicalCores
+	}
+	if st.Memory != nil {
+		inv.MemoryTotal = st.Memory.Total
+	}
+	for _, d := range st.Disks {
+		inv.Disks = append(inv.Disks, InventoryDisk{Device: d.Device, Model: d.Model, Serial: d.Serial, Total: d.Total})
+	}
+	for _, nic := range st.Networks {
+		inv.Networks = append(inv.Networks, InventoryNIC{Interface: nic.Interface, MacAddress: nic.MacAddress})
+	}
+	for _, g := range st.GPUs {
+		inv.GPUs = append(inv.GPUs, InventoryChip{Index: g.Index, Name: g.Name, Vendor: g.Vendor, MemoryTotal: g.MemoryTotal})
+	}
+	for _, npu := range st.NPUs {
+		inv.NPUs = append(inv.NPUs, InventoryChip{Index: npu.Index, Name: npu.Name, Vendor: npu.Vendor, MemoryTotal: npu.MemoryTotal})
+	}
+	if st.SystemInfo != nil {
+		inv.System = &InventorySystem{
+			OsName:        st.SystemInfo.OsName,
+			OsVersion:     st.SystemInfo.OsVersion,
+			KernelVersion: st.SystemInfo.KernelVersion,
+			SystemModel:   st.SystemInfo.SystemModel,
+			SystemVendor:  st.SystemInfo.SystemVendor,
+			BiosVersion:   st.SystemInfo.BiosVersion,
+		}
+	}
+	return inv
+}
+
+// DiffInventory lists the fields that differ between two inventories,
+// including devices that were added or removed
+func DiffInventory(previous, current *HardwareInventory) []HardwareChange {
+	return diffFields(flattenInventory(previous), flattenInventory(current))
+}
+
+// diffFields lists the differences between two sets of field path -> value
+// pairs, sorted by field
+func diffFields(before, after map[string]string) []HardwareChange {
+	fields := make(map[string]bool, len(before)+len(after))
+	for k := range before {
+		fields[k] = true
+	}
+	for k := range after {
+		fields[k] = true
+	}
+
+	var changes []HardwareChange
+	for field := range fields {
+		if before[field] == after[field] {
+			continue
+		}
+		kind := HardwareChanged
+		switch {
+		case before[field] == "":
+			kind = HardwareAdded
+		case after[field] == "":
+			kind = HardwareRemoved
+		}
+		changes = append(changes, HardwareChange{Field: field, Kind: kind, Previous: before[field], Current: after[field]})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// flattenInventory turns an inventory into field path -> value pairs
+func flattenInventory(inv *HardwareInventory) map[string]string {
+	out := make(map[string]string)
+	set := func(key, value string) {
+		if value != "" && value != "0" {
+			out[key] = value
+		}
+	}
+
+	set("cpu.model", inv.CPUModel)
+	set("cpu.vendor", inv.CPUVendor)
+	set("cpu.physicalCores", strconv.Itoa(inv.PhysicalCores))
+	set("cpu.logicalCores", strconv.Itoa(inv.LogicalCores))
+	set("memory.total", strconv.FormatUint(inv.MemoryTotal, 10))
+	for _, d := range inv.Disks {
+		prefix := "disks[" + d.Device + "]."
+		set(prefix+"model", d.Model)
+		set(prefix+"serial", d.Serial)
+		set(prefix+"total", strconv.FormatUint(d.Total, 10))
+	}
+	for _, nic := range inv.Networks {
+		set("networks["+nic.Interface+"].macAddress", nic.MacAddress)
+	}
+	for _, g := range inv.GPUs {
+		prefix := "gpus[" + strconv.Itoa(g.Index) + "]."
+		set(prefix+"name", g.Name)
+		set(prefix+"memoryTotal", strconv.FormatUint(g.MemoryTotal, 10))
+	}
+	for _, npu := range inv.NPUs {
+		prefix := "npus[" + strconv.Itoa(npu.Index) + "]."
+		set(prefix+"name", npu.Name)
+		set(prefix+"memoryTotal", strconv.FormatUint(npu.MemoryTotal, 10))
+	}
+	if inv.System != nil {
+		set("system.osName", inv.System.OsName)
+		set("system.osVersion", inv.System.OsVersion)
+		set("system.kernelVersion", inv.System.KernelVersion)
+		set("system.model", inv.System.SystemModel)
+		set("system.vendor", inv.System.SystemVendor)
+		set("system.biosVersion", inv.System.BiosVersion)
+	}
+	return out
+}
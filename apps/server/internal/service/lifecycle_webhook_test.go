@@ -0,0 +1,202 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"go.uber.org/zap"
+)
+
+func newTestLifecycleNotifier(t *testing.T, cfg config.LifecycleWebhookConfig) (*LifecycleNotifier, *[]LifecycleEvent) {
+	t.Helper()
+	n := NewLifecycleNotifier(newTestDB(t), cfg, zap.NewNop().Sugar())
+	n.SetClock(NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+	var events []LifecycleEvent
+	n.deliver = func(e LifecycleEvent) { events = append(events, e) }
+	return n, &events
+}
+
+func testStatic(cpuModel string) *StaticUpdate {
+	return &StaticUpdate{
+		CPU:    &CPUData{Model: cpuModel, Vendor: "Intel", PhysicalCores: 8, LogicalCores: 16},
+		Memory: &MemData{Total: 32 << 30},
+		Disks:  []DiskData{{Device: "sda", Model: "Samsung 870", Serial: "S1", Total: 1 << 40}},
+	}
+}
+
+func TestLifecycleNewAgentFiresDiscovery(t *testing.T) {
+	n, events := newTestLifecycleNotifier(t, config.LifecycleWebhookConfig{})
+	agents := NewAgentService(zap.NewNop().Sugar(), NewMetricsService(zap.NewNop().Sugar()))
+	agents.SetLifecycleNotifier(n)
+
+	info := AgentInfo{Hostname: "web-1", OS: "linux", Arch: "amd64", Version: "0.3.0"}
+	agents.RegisterGrpcAgent("agent-1", info, 0)
+
+	if len(*events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(*events))
+	}
+	e := (*events)[0]
+	if e.Event != LifecycleDiscovered {
+		t.Errorf("Expected %s, got %s", LifecycleDiscovered, e.Event)
+	}
+	if e.AgentID != "agent-1" || e.Hostname != "web-1" || e.Version != "0.3.0" {
+		t.Errorf("Expected agent details in event, got %+v", e)
+	}
+
+	// The same agent connecting again is a reconnect
+	agents.UnregisterAgent("agent-1")
+	agents.RegisterGrpcAgent("agent-1", info, 0)
+	if len(*events) != 2 || (*events)[1].Event != LifecycleReconnected {
+		t.Fatalf("Expected reconnect event, got %+v", *events)
+	}
+}
+
+func TestLifecycleCPUModelChangeFiresHardwareChange(t *testing.T) {
+	n, events := newTestLifecycleNotifier(t, config.LifecycleWebhookConfig{})
+	agents := NewAgentService(zap.NewNop().Sugar(), NewMetricsService(zap.NewNop().Sugar()))
+	agents.SetLifecycleNotifier(n)
+	agents.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "web-1"}, 0)
+
+	agents.ReportStaticInfo("agent-1", testStatic("Xeon E5-2680"))
+	if len(*events) != 2 || (*events)[1].Event != LifecycleInventory {
+		t.Fatalf("Expected inventory event for first static report, got %+v", *events)
+	}
+	if (*events)[1].Inventory == nil || (*events)[1].Inventory.CPUModel != "Xeon E5-2680" {
+		t.Errorf("Expected inventory with CPU model, got %+v", (*events)[1].Inventory)
+	}
+
+	// Unchanged hardware sends nothing
+	agents.ReportStaticInfo("agent-1", testStatic("Xeon E5-2680"))
+	if len(*events) != 2 {
+		t.Fatalf("Expected no event for unchanged hardware, got %d events", len(*events))
+	}
+
+	agents.ReportStaticInfo("agent-1", testStatic("Xeon Gold 6338"))
+	if len(*events) != 3 {
+		t.Fatalf("Expected hardware change event, got %d events", len(*events))
+	}
+	e := (*events)[2]
+	if e.Event != LifecycleHardwareChanged {
+		t.Errorf("Expected %s, got %s", LifecycleHardwareChanged, e.Event)
+	}
+	if len(e.Changes) != 1 {
+		t.Fatalf("Expected 1 change, got %+v", e.Changes)
+	}
+	change := e.Changes[0]
+	if change.Field != "cpu.model" || change.Previous != "Xeon E5-2680" || change.Current != "Xeon Gold 6338" {
+		t.Errorf("Expected cpu.model change, got %+v", change)
+	}
+}
+
+func TestLifecycleDeregisterForgetsAgent(t *testing.T) {
+	n, events := newTestLifecycleNotifier(t, config.LifecycleWebhookConfig{})
+	agents := NewAgentService(zap.NewNop().Sugar(), NewMetricsService(zap.NewNop().Sugar()))
+	agents.SetLifecycleNotifier(n)
+	agents.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "web-1"}, 0)
+
+	if err := agents.DeregisterAgent("agent-1"); err != nil {
+		t.Fatalf("DeregisterAgent failed: %v", err)
+	}
+	if agents.GetAgent("agent-1") != nil {
+		t.Error("Expected agent to be removed")
+	}
+	last := (*events)[len(*events)-1]
+	if last.Event != LifecycleDeregistered || last.Hostname != "web-1" {
+		t.Errorf("Expected deregistered event, got %+v", last)
+	}
+
+	if err := agents.DeregisterAgent("agent-1"); err != ErrAgentNotFound {
+		t.Errorf("Expected ErrAgentNotFound, got %v", err)
+	}
+
+	// A deregistered agent that comes back is discovered again
+	agents.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "web-1"}, 0)
+	if last := (*events)[len(*events)-1]; last.Event != LifecycleDiscovered {
+		t.Errorf("Expected discovery after deregistration, got %s", last.Event)
+	}
+}
+
+func TestLifecycleStateSurvivesRestart(t *testing.T) {
+	n, _ := newTestLifecycleNotifier(t, config.LifecycleWebhookConfig{})
+	agents := NewAgentService(zap.NewNop().Sugar(), NewMetricsService(zap.NewNop().Sugar()))
+	agents.SetLifecycleNotifier(n)
+	agents.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "web-1"}, 0)
+	agents.ReportStaticInfo("agent-1", testStatic("Xeon E5-2680"))
+	// An agent whose ID changes with every connection
+	agents.RegisterAgent(nil, AgentInfo{Hostname: "web-2"}, 0)
+
+	// A restarted server shares only the database
+	restarted := NewLifecycleNotifier(n.db, config.LifecycleWebhookConfig{}, zap.NewNop().Sugar())
+	var events []LifecycleEvent
+	restarted.deliver = func(e LifecycleEvent) { events = append(events, e) }
+	agents = NewAgentService(zap.NewNop().Sugar(), NewMetricsService(zap.NewNop().Sugar()))
+	agents.SetLifecycleNotifier(restarted)
+
+	agents.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "web-1"}, 0)
+	ws := agents.RegisterAgent(nil, AgentInfo{Hostname: "web-2"}, 0)
+	if len(events) != 2 || events[0].Event != LifecycleReconnected || events[1].Event != LifecycleReconnected {
+		t.Fatalf("Expected both agents to reconnect after a restart, got %+v", events)
+	}
+	if events[0].Inventory == nil || events[0].Inventory.CPUModel != "Xeon E5-2680" {
+		t.Errorf("Expected the stored inventory on reconnect, got %+v", events[0].Inventory)
+	}
+	if events[1].AgentID != ws.ID {
+		t.Errorf("Expected the event to carry the new connection's ID %s, got %s", ws.ID, events[1].AgentID)
+	}
+
+	agents.ReportStaticInfo("agent-1", testStatic("Xeon Gold 6338"))
+	if last := events[len(events)-1]; last.Event != LifecycleHardwareChanged {
+		t.Errorf("Expected a hardware change against the stored inventory, got %s", last.Event)
+	}
+}
+
+func TestLifecycleEventFilter(t *testing.T) {
+	n, events := newTestLifecycleNotifier(t, config.LifecycleWebhookConfig{
+		Events: []string{LifecycleHardwareChanged},
+	})
+	n.AgentConnected("agent-1", "agent-1", AgentInfo{})
+	n.StaticInfoReported("agent-1", "agent-1", AgentInfo{}, testStatic("A"))
+	n.StaticInfoReported("agent-1", "agent-1", AgentInfo{}, testStatic("B"))
+
+	if len(*events) != 1 || (*events)[0].Event != LifecycleHardwareChanged {
+		t.Errorf("Expected only the hardware change event, got %+v", *events)
+	}
+}
+
+func TestLifecyclePostSignsBody(t *testing.T) {
+	type received struct {
+		event     string
+		signature string
+		body      []byte
+	}
+	got := make(chan received, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- received{r.Header.Get(LifecycleEventHeader), r.Header.Get(LifecycleSignatureHeader), body}
+	}))
+	defer srv.Close()
+
+	n := NewLifecycleNotifier(newTestDB(t), config.LifecycleWebhookConfig{URL: srv.URL, Secret: "s3cret"}, zap.NewNop().Sugar())
+	n.AgentConnected("agent-1", "agent-1", AgentInfo{Hostname: "web-1"})
+
+	select {
+	case r := <-got:
+		if r.event != LifecycleDiscovered {
+			t.Errorf("Expected event header %s, got %s", LifecycleDiscovered, r.event)
+		}
+		if want := "sha256=" + SignWebhookBody("s3cret", r.body); r.signature != want {
+			t.Errorf("Expected signature %s, got %s", want, r.signature)
+		}
+		var e LifecycleEvent
+		if err := json.Unmarshal(r.body, &e); err != nil || e.AgentID != "agent-1" {
+			t.Errorf("Expected JSON event for agent-1, got %s (%v)", r.body, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected webhook request")
+	}
+}
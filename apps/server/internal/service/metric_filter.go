@@ -0,0 +1,203 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+)
+
+// ErrInvalidMetricFilter is returned for filter patterns that are not valid globs
+var ErrInvalidMetricFilter = errors.New("invalid metric filter")
+
+// nameFilter is a NameFilter over the names an entry goes by, such as a
+// disk's device path and name
+type nameFilter struct {
+	NameFilter
+	// Deny paths below a denied one too, so "/snap/*" covers /snap/core/123
+	denySubtrees bool
+}
+
+func newNameFilter(cfg config.FilterListConfig) (nameFilter, error) {
+	for _, pattern := range append(append([]string{}, cfg.Allow...), cfg.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nameFilter{}, fmt.Errorf("%w: %q: %v", ErrInvalidMetricFilter, pattern, err)
+		}
+	}
+	return nameFilter{NameFilter: NameFilter{Allow: cfg.Allow, Deny: cfg.Deny}}, nil
+}
+
+// allows reports whether any of the names passes the filter. Empty names
+// are ignored, and an entry with no names is always kept.
+func (f nameFilter) allows(names ...string) bool {
+	var candidates []string
+	for _, name := range names {
+		if name != "" {
+			candidates = append(candidates, name)
+		}
+	}
+	if len(candidates) == 0 {
+		return true
+	}
+	for _, name := range candidates {
+		if MatchesAny(f.Deny, name) {
+			return false
+		}
+		if f.denySubtrees {
+			for dir := path.Dir(name); dir != "/" && dir != "."; dir = path.Dir(dir) {
+				if MatchesAny(f.Deny, dir) {
+					return false
+				}
+			}
+		}
+	}
+	if len(f.Allow) == 0 {
+		return true
+	}
+	for _, name := range candidates {
+		if MatchesAny(f.Allow, name) {
+			return true
+		}
+	}
+	return false
+}
+
+type metricFilterRules struct {
+	interfaces  nameFilter
+	mountPoints nameFilter
+	devices     nameFilter
+}
+
+func newMetricFilterRules(cfg config.MetricFilterConfig) (metricFilterRules, error) {
+	var rules metricFilterRules
+	var err error
+	if rules.interfaces, err = newNameFilter(cfg.Interfaces); err != nil {
+		return rules, err
+	}
+	if rules.mountPoints, err = newNameFilter(cfg.MountPoints); err != nil {
+		return rules, err
+	}
+	rules.mountPoints.denySubtrees = true
+	if rules.devices, err = newNameFilter(cfg.Devices); err != nil {
+		return rules, err
+	}
+	return rules, nil
+}
+
+func (r metricFilterRules) allowsDisk(d DiskData) bool {
+	// Devices match by full path or name, so "loop*" covers /dev/loop0
+	return r.mountPoints.allows(d.MountPoint) && r.devices.allows(d.Device, path.Base(d.Device))
+}
+
+// MetricFilter drops network interfaces, mount points and disk devices
+// that clutter an agent's metrics (loopbacks, container veths, snap mounts)
+// before they are stored or broadcast. An agent's entries must pass both
+// the global rules and its own.
+type MetricFilter struct {
+	global   metricFilterRules
+	perAgent map[string]metricFilterRules
+
+	// Devices of disks dropped by mount point, per agent, so realtime IO
+	// samples that carry only the device are dropped too
+	droppedDevices map[string]map[string]bool
+	mu             sync.Mutex
+}
+
+// NewMetricFilter creates a filter from global and per-agent rules. With no
+// rules configured it returns nil, which keeps everything.
+func NewMetricFilter(global config.MetricFilterConfig, perAgent map[string]config.MetricFilterConfig) (*MetricFilter, error) {
+	if global.Empty() && len(perAgent) == 0 {
+		return nil, nil
+	}
+	rules, err := newMetricFilterRules(global)
+	if err != nil {
+		return nil, err
+	}
+	f := &MetricFilter{
+		global:         rules,
+		perAgent:       make(map[string]metricFilterRules, len(perAgent)),
+		droppedDevices: make(map[string]map[string]bool),
+	}
+	for agentID, cfg := range perAgent {
+		agentRules, err := newMetricFilterRules(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("agent %s: %w", agentID, err)
+		}
+		f.perAgent[agentID] = agentRules
+	}
+	return f, nil
+}
+
+// Disks returns the disks of an agent that pass the filter. The input is
+// returned unchanged when nothing is dropped.
+func (f *MetricFilter) Disks(agentID string, disks []DiskData) []DiskData {
+	if f == nil || len(disks) == 0 {
+		return disks
+	}
+	agentRules, hasAgentRules := f.perAgent[agentID]
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	dropped := f.droppedDevices[agentID]
+	keep := func(d DiskData) bool {
+		if d.MountPoint == "" && dropped[d.Device] {
+			return false
+		}
+		if f.global.allowsDisk(d) && (!hasAgentRules || agentRules.allowsDisk(d)) {
+			return true
+		}
+		if d.MountPoint != "" && d.Device != "" {
+			if dropped == nil {
+				dropped = make(map[string]bool)
+				f.droppedDevices[agentID] = dropped
+			}
+			dropped[d.Device] = true
+		}
+		return false
+	}
+	return filterSlice(disks, keep)
+}
+
+// Forget drops what the filter remembers about an agent
+func (f *MetricFilter) Forget(agentID string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.droppedDevices, agentID)
+}
+
+// Networks returns the network interfaces of an agent that pass the filter.
+// The input is returned unchanged when nothing is dropped.
+func (f *MetricFilter) Networks(agentID string, networks []NetData) []NetData {
+	if f == nil || len(networks) == 0 {
+		return networks
+	}
+	agentRules, hasAgentRules := f.perAgent[agentID]
+	keep := func(n NetData) bool {
+		return f.global.interfaces.allows(n.Interface) && (!hasAgentRules || agentRules.interfaces.allows(n.Interface))
+	}
+	return filterSlice(networks, keep)
+}
+
+// filterSlice returns the items passing keep in a new slice, leaving the
+// caller's slice untouched
+func filterSlice[T any](items []T, keep func(T) bool) []T {
+	for i, item := range items {
+		if keep(item) {
+			continue
+		}
+		kept := make([]T, i, len(items)-1)
+		copy(kept, items[:i])
+		for _, rest := range items[i+1:] {
+			if keep(rest) {
+				kept = append(kept, rest)
+			}
+		}
+		return kept
+	}
+	return items
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"go.uber.org/zap"
+)
+
+func TestMetricFilterDropsDeniedInterfaces(t *testing.T) {
+	filter, err := NewMetricFilter(config.MetricFilterConfig{
+		Interfaces: config.FilterListConfig{Deny: []string{"lo", "veth*"}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &memoryBackend{}
+	persistence := NewMetricsPersistenceWithBackend(backend, config.MetricsConfig{PersistToDB: true}, zap.NewNop().Sugar())
+	ms := NewMetricsService(zap.NewNop().Sugar())
+	ms.SetMetricFilter(filter)
+
+	networks := []NetData{
+		{Interface: "lo", RxBytesPS: 1000},
+		{Interface: "eth0", RxBytesPS: 200},
+		{Interface: "veth3a9f", RxBytesPS: 1000},
+	}
+	ms.StoreMetrics("agent-1", &MetricsData{Networks: networks})
+
+	current := ms.GetCurrentMetrics("agent-1")
+	if len(current.Networks) != 1 || current.Networks[0].Interface != "eth0" {
+		t.Fatalf("Expected only eth0 to be stored, got %+v", current.Networks)
+	}
+	if networks[0].Interface != "lo" || len(networks) != 3 {
+		t.Error("Expected the caller's slice to be left untouched")
+	}
+
+	// Aggregates persisted to history only count real interfaces
+	if err := persistence.SaveMetrics("agent-1", current); err != nil {
+		t.Fatal(err)
+	}
+	if rx := backend.records[0].NetRxPS; rx != 200 {
+		t.Errorf("Expected persisted receive rate of 200, got %d", rx)
+	}
+
+	// Realtime updates for denied interfaces are dropped too
+	ms.MergeRealtimeMetrics("agent-1", &RealtimeUpdate{NetworkIO: []NetData{
+		{Interface: "veth77", RxBytesPS: 5},
+		{Interface: "eth0", RxBytesPS: 300},
+	}})
+	current = ms.GetCurrentMetrics("agent-1")
+	if len(current.Networks) != 1 || current.Networks[0].RxBytesPS != 300 {
+		t.Errorf("Expected eth0 to be updated and veth77 dropped, got %+v", current.Networks)
+	}
+}
+
+func TestMetricFilterMountPointsAndPerAgentRules(t *testing.T) {
+	filter, err := NewMetricFilter(config.MetricFilterConfig{
+		MountPoints: config.FilterListConfig{Deny: []string{"/snap/*"}},
+	}, map[string]config.MetricFilterConfig{
+		"db-1": {Devices: config.FilterListConfig{Allow: []string{"nvme*"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := NewMetricsService(zap.NewNop().Sugar())
+	ms.SetMetricFilter(filter)
+
+	disks := []DiskData{
+		{MountPoint: "/", Device: "/dev/sda1"},
+		{MountPoint: "/snap/core/123", Device: "/dev/loop3"},
+		{MountPoint: "/data", Device: "/dev/nvme0n1"},
+	}
+	ms.MergeStaticInfo("web-1", &StaticUpdate{Disks: disks})
+	ms.MergeStaticInfo("db-1", &StaticUpdate{Disks: disks})
+
+	// Realtime IO carries only the device; a disk dropped by mount point stays dropped
+	ms.MergeRealtimeMetrics("web-1", &RealtimeUpdate{DiskIO: []DiskData{{Device: "/dev/loop3", ReadBytesPS: 10}}})
+
+	web := ms.GetCurrentMetrics("web-1")
+	if len(web.Disks) != 2 || web.Disks[0].MountPoint != "/" || web.Disks[1].MountPoint != "/data" {
+		t.Errorf("Expected / and /data for web-1, got %+v", web.Disks)
+	}
+	db := ms.GetCurrentMetrics("db-1")
+	if len(db.Disks) != 1 || db.Disks[0].Device != "/dev/nvme0n1" {
+		t.Errorf("Expected only the NVMe disk for db-1, got %+v", db.Disks)
+	}
+}
+
+func TestMetricFilterDefaultsToKeepingEverything(t *testing.T) {
+	filter, err := NewMetricFilter(config.MetricFilterConfig{}, nil)
+	if err != nil || filter != nil {
+		t.Fatalf("Expected no filter without rules, got %v, %v", filter, err)
+	}
+	networks := []NetData{{Interface: "lo"}}
+	if got := filter.Networks("agent-1", networks); len(got) != 1 {
+		t.Errorf("Expected a nil filter to keep everything, got %+v", got)
+	}
+
+	_, err = NewMetricFilter(config.MetricFilterConfig{
+		Interfaces: config.FilterListConfig{Deny: []string{"veth["}},
+	}, nil)
+	if !errors.Is(err, ErrInvalidMetricFilter) {
+		t.Errorf("Expected ErrInvalidMetricFilter, got %v", err)
+	}
+}
+
+func TestMetricFilterAppliesToTimestampedSamples(t *testing.T) {
+	filter, _ := NewMetricFilter(config.MetricFilterConfig{
+		Interfaces: config.FilterListConfig{Allow: []string{"eth*", "ens*"}},
+	}, nil)
+	ms := NewMetricsService(zap.NewNop().Sugar())
+	ms.SetClock(NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)))
+	ms.SetMetricFilter(filter)
+
+	ms.StoreMetrics("agent-1", &MetricsData{Networks: []NetData{{Interface: "docker0"}, {Interface: "ens3"}}})
+	history := ms.GetMetricsHistory("agent-1", 0)
+	if len(history) != 1 || len(history[0].Networks) != 1 || history[0].Networks[0].Interface != "ens3" {
+		t.Errorf("Expected only ens3 in history, got %+v", history)
+	}
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+)
+
+// Host metrics that rules can compare, as kept in persisted history
+const (
+	RuleMetricCPU    = "cpu"    // percent
+	RuleMetricMemory = "memory" // percent
+	RuleMetricDisk   = "disk"   // fullest disk, percent
+	RuleMetricGPU    = "gpu"    // average across GPUs, percent
+	RuleMetricLoad1  = "load1"  // 1 minute load average
+)
+
+// MaxRuleSimulationRange bounds the history one rule simulation replays
+const MaxRuleSimulationRange = 31 * 24 * time.Hour
+
+// MetricAlertRule fires when a host metric crosses a threshold and stays
+// there for at least ForSec seconds
+type MetricAlertRule struct {
+	Name      string  `json:"name"`
+	Metric    string  `json:"metric"`
+	Operator  string  `json:"operator"` // ">", ">=", "<" or "<=" (default ">")
+	Threshold float64 `json:"threshold"`
+	ForSec    int     `json:"forSec"`
+	Severity  string  `json:"severity"`
+
+	// Live rules only: the disk mount ("*" for each mount) or GPU to check
+	// instead of the fullest disk or the GPU average
+	MountPoint string `json:"mountPoint,omitempty"`
+	GPUIndex   *int   `json:"gpuIndex,omitempty"`
+}
+
+// AllMountPoints makes a disk rule check each mount separately
+const AllMountPoints = "*"
+
+// Normalize validates a rule and fills in defaults
+func (r MetricAlertRule) Normalize() (MetricAlertRule, error) {
+	switch r.Metric {
+	case RuleMetricCPU, RuleMetricMemory, RuleMetricDisk, RuleMetricGPU, RuleMetricLoad1:
+	default:
+		return r, fmt.Errorf("%w: unknown metric %q", ErrInvalidAlertRule, r.Metric)
+	}
+
+	switch r.Operator {
+	case "":
+		r.Operator = ">"
+	case ">", ">=", "<", "<=":
+	default:
+		return r, fmt.Errorf("%w: unknown operator %q", ErrInvalidAlertRule, r.Operator)
+	}
+
+	if r.MountPoint != "" && r.Metric != RuleMetricDisk {
+		return r, fmt.Errorf("%w: mountPoint only applies to disk rules", ErrInvalidAlertRule)
+	}
+	if r.GPUIndex != nil && r.Metric != RuleMetricGPU {
+		return r, fmt.Errorf("%w: gpuIndex only applies to gpu rules", ErrInvalidAlertRule)
+	}
+
+	if r.ForSec < 0 {
+		return r, fmt.Errorf("%w: forSec must not be negative", ErrInvalidAlertRule)
+	}
+	if r.Severity == "" {
+		r.Severity = "warning"
+	}
+	if r.Name == "" {
+		r.Name = fmt.Sprintf("%s %s %g", r.Metric, r.Operator, r.Threshold)
+	}
+	return r, nil
+}
+
+// value extracts the rule's metric from a persisted sample. ok is false
+// when the sample predates the metric being recorded.
+func (r MetricAlertRule) value(m database.MetricsHistory) (value float64, ok bool) {
+	switch r.Metric {
+	case RuleMetricMemory:
+		return m.MemPercent, true
+	case RuleMetricDisk:
+		if m.DiskPercent == nil {
+			return 0, false
+		}
+		return *m.DiskPercent, true
+	case RuleMetricGPU:
+		return m.GPUPercent, true
+	case RuleMetricLoad1:
+		return m.LoadAvg1, true
+	}
+	return m.CPUPercent, true
+}
+
+// ruleState tracks one agent through a rule: matching samples make it
+// pending, and it fires once they have matched for the rule's duration
+type ruleState struct {
+	pendingSince time.Time
+	pending      bool
+	firing       bool
+}
+
+// step feeds one sample to the state machine and reports whether the rule
+// started or stopped firing with it
+func (s *ruleState) step(rule MetricAlertRule, at time.Time, value float64) (fired, resolved bool) {
+	if !compareThreshold(value, rule.Operator, rule.Threshold) {
+		resolved = s.firing
+		*s = ruleState{}
+		return false, resolved
+	}
+	if s.firing {
+		return false, false
+	}
+	if !s.pending {
+		s.pending = true
+		s.pendingSince = at
+	}
+	if at.Sub(s.pendingSince) >= time.Duration(rule.ForSec)*time.Second {
+		s.firing = true
+		return true, false
+	}
+	return false, false
+}
+
+// RuleFiring is one period a rule would have been firing
+type RuleFiring struct {
+	FiredAt    time.Time  `json:"firedAt"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+	PeakValue  float64    `json:"peakValue"`
+}
+
+// AgentRuleSimulation is how a rule would have behaved for one agent
+type AgentRuleSimulation struct {
+	AgentID string       `json:"agentId"`
+	Samples int          `json:"samples"`
+	Firings []RuleFiring `json:"firings"`
+	Count   int          `json:"count"`
+}
+
+// RuleSimulation is the result of replaying a rule over history
+type RuleSimulation struct {
+	Rule         MetricAlertRule       `json:"rule"`
+	Start        time.Time             `json:"start"`
+	End          time.Time             `json:"end"`
+	Agents       []AgentRuleSimulation `json:"agents"`
+	TotalFirings int                   `json:"totalFirings"`
+}
+
+// ReplayRule runs a normalized rule over samples (oldest first) and returns
+// each period it would have fired. A firing still open at the last sample
+// has no ResolvedAt.
+func ReplayRule(rule MetricAlertRule, samples []database.MetricsHistory) []RuleFiring {
+	firings := make([]RuleFiring, 0)
+	var state ruleState
+	for _, sample := range samples {
+		value, ok := rule.value(sample)
+		if !ok {
+			continue
+		}
+		fired, resolved := state.step(rule, sample.Timestamp, value)
+		switch {
+		case fired:
+			firings = append(firings, RuleFiring{FiredAt: sample.Timestamp, PeakValue: value})
+		case resolved:
+			at := sample.Timestamp
+			firings[len(firings)-1].ResolvedAt = &at
+		case state.firing && exceeds(rule.Operator, value, firings[len(firings)-1].PeakValue):
+			firings[len(firings)-1].PeakValue = value
+		}
+	}
+	return firings
+}
+
+// exceeds reports whether value is further past the threshold than peak
+func exceeds(operator string, value, peak float64) bool {
+	if operator == "<" || operator == "<=" {
+		return value < peak
+	}
+	return value > peak
+}
+
+// SimulateRule replays a rule over each agent's persisted samples between
+// start and end
+func (mp *MetricsPersistence) SimulateRule(rule MetricAlertRule, agentIDs []string, start, end time.Time) (*RuleSimulation, error) {
+	rule, err := rule.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	if rule.MountPoint != "" || rule.GPUIndex != nil {
+		// History keeps only the fullest disk and the GPU average
+		return nil, fmt.Errorf("%w: per-disk and per-GPU rules cannot be simulated", ErrInvalidAlertRule)
+	}
+
+	result := &RuleSimulation{
+		Rule:   rule,
+		Start:  start,
+		End:    end,
+		Agents: make([]AgentRuleSimulation, 0, len(agentIDs)),
+	}
+	for _, agentID := range agentIDs {
+		samples, err := mp.QueryHistory(agentID, start, end, 0)
+		if err != nil {
+			return nil, err
+		}
+		firings := ReplayRule(rule, samples)
+		result.Agents = append(result.Agents, AgentRuleSimulation{
+			AgentID: agentID,
+			Samples: len(samples),
+			Firings: firings,
+			Count:   len(firings),
+		})
+		result.TotalFirings += len(firings)
+	}
+	return result, nil
+}
+
+// AgentsWithHistory returns the agents with persisted samples in the range
+func (mp *MetricsPersistence) AgentsWithHistory(start, end time.Time) ([]string, error) {
+	agentIDs, err := mp.backend.Agents(start, end)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(agentIDs)
+	return agentIDs, nil
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+)
+
+// cpuSeries returns one sample per minute starting at start
+func cpuSeries(agentID string, start time.Time, values ...float64) []database.MetricsHistory {
+	samples := make([]database.MetricsHistory, len(values))
+	for i, v := range values {
+		samples[i] = database.MetricsHistory{
+			AgentID:    agentID,
+			Timestamp:  start.Add(time.Duration(i) * time.Minute),
+			CPUPercent: v,
+		}
+	}
+	return samples
+}
+
+func TestReplayRuleReportsEachSpike(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	samples := cpuSeries("agent-1", start, 40, 95, 97, 50, 45, 92, 60)
+	rule, err := MetricAlertRule{Metric: RuleMetricCPU, Operator: ">", Threshold: 90}.Normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firings := ReplayRule(rule, samples)
+	if len(firings) != 2 {
+		t.Fatalf("Expected 2 firings, got %d", len(firings))
+	}
+	if !firings[0].FiredAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected first firing at %v, got %v", start.Add(time.Minute), firings[0].FiredAt)
+	}
+	if firings[0].PeakValue != 97 {
+		t.Errorf("Expected first peak 97, got %v", firings[0].PeakValue)
+	}
+	if firings[0].ResolvedAt == nil || !firings[0].ResolvedAt.Equal(start.Add(3*time.Minute)) {
+		t.Errorf("Expected first firing resolved at %v, got %v", start.Add(3*time.Minute), firings[0].ResolvedAt)
+	}
+	if !firings[1].FiredAt.Equal(start.Add(5 * time.Minute)) {
+		t.Errorf("Expected second firing at %v, got %v", start.Add(5*time.Minute), firings[1].FiredAt)
+	}
+}
+
+func TestReplayRuleHonorsDuration(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	// A one-sample spike, then three minutes above the threshold
+	samples := cpuSeries("agent-1", start, 95, 50, 91, 93, 94, 96, 40)
+	rule, _ := MetricAlertRule{Metric: RuleMetricCPU, Threshold: 90, ForSec: 120}.Normalize()
+
+	firings := ReplayRule(rule, samples)
+	if len(firings) != 1 {
+		t.Fatalf("Expected the short spike to be ignored, got %d firings", len(firings))
+	}
+	if !firings[0].FiredAt.Equal(start.Add(4 * time.Minute)) {
+		t.Errorf("Expected firing after two minutes pending at %v, got %v", start.Add(4*time.Minute), firings[0].FiredAt)
+	}
+	if firings[0].PeakValue != 96 {
+		t.Errorf("Expected peak 96, got %v", firings[0].PeakValue)
+	}
+}
+
+func TestReplayRuleLeavesOpenFiringUnresolved(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	rule, _ := MetricAlertRule{Metric: RuleMetricCPU, Threshold: 90}.Normalize()
+
+	firings := ReplayRule(rule, cpuSeries("agent-1", start, 50, 95, 99))
+	if len(firings) != 1 || firings[0].ResolvedAt != nil {
+		t.Errorf("Expected one unresolved firing, got %+v", firings)
+	}
+}
+
+func TestMetricAlertRuleNormalize(t *testing.T) {
+	rule, err := MetricAlertRule{Metric: RuleMetricMemory, Threshold: 80}.Normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Operator != ">" || rule.Severity != "warning" || rule.Name == "" {
+		t.Errorf("Expected defaults to be filled in, got %+v", rule)
+	}
+
+	invalid := []MetricAlertRule{
+		{Metric: "swap", Threshold: 80},
+		{Metric: RuleMetricCPU, Operator: "==", Threshold: 80},
+		{Metric: RuleMetricCPU, Threshold: 80, ForSec: -1},
+	}
+	for _, r := range invalid {
+		if _, err := r.Normalize(); !errors.Is(err, ErrInvalidAlertRule) {
+			t.Errorf("Expected ErrInvalidAlertRule for %+v, got %v", r, err)
+		}
+	}
+}
+
+func TestSimulateRuleAgainstPersistedHistory(t *testing.T) {
+	backend := &memoryBackend{}
+	mp := NewMetricsPersistenceWithBackend(backend, config.MetricsConfig{PersistToDB: true}, zap.NewNop().Sugar())
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, s := range cpuSeries("agent-b", start, 40, 95, 50, 92, 50) {
+		backend.Save(s)
+	}
+	for _, s := range cpuSeries("agent-a", start, 20, 30, 40) {
+		backend.Save(s)
+	}
+
+	end := start.Add(time.Hour)
+	agentIDs, err := mp.AgentsWithHistory(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agentIDs) != 2 || agentIDs[0] != "agent-a" || agentIDs[1] != "agent-b" {
+		t.Fatalf("Expected sorted agents [agent-a agent-b], got %v", agentIDs)
+	}
+
+	sim, err := mp.SimulateRule(MetricAlertRule{Metric: RuleMetricCPU, Threshold: 90}, agentIDs, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim.TotalFirings != 2 {
+		t.Errorf("Expected 2 firings in total, got %d", sim.TotalFirings)
+	}
+	if sim.Agents[0].Count != 0 || sim.Agents[0].Samples != 3 {
+		t.Errorf("Expected agent-a to have 3 samples and no firings, got %+v", sim.Agents[0])
+	}
+	if sim.Agents[1].Count != 2 {
+		t.Errorf("Expected agent-b to fire twice, got %d", sim.Agents[1].Count)
+	}
+
+	if _, err := mp.SimulateRule(MetricAlertRule{Metric: "swap"}, agentIDs, start, end); !errors.Is(err, ErrInvalidAlertRule) {
+		t.Errorf("Expected ErrInvalidAlertRule, got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Units of metric fields
+const (
+	UnitBytes       = "bytes"
+	UnitBytesPerSec = "bytes/s"
+	UnitPercent     = "percent"
+	UnitMHz         = "MHz"
+	UnitMbps        = "Mbps"
+	UnitCelsius     = "celsius"
+	UnitWatts       = "watts"
+	UnitSeconds     = "seconds"
+	UnitUnixSeconds = "unix_seconds"
+	UnitCount       = "count"
+	UnitCountPerSec = "count/s"
+	UnitLoadAverage = "load"
+)
+
+// Numeric types of metric fields
+const (
+	MetricTypeInt   = "integer"
+	MetricTypeFloat = "float"
+)
+
+// MetricUnit describes the unit and numeric type of a metric field
+type MetricUnit struct {
+	Unit string `json:"unit"`
+	Type string `json:"type"`
+}
+
+// metricUnits maps JSON paths of MetricsData fields to their units. Fields
+// of list entries use "[]", as in "disks[].total".
+var metricUnits = map[string]MetricUnit{
+	"cpu.usagePercent":        {UnitPercent, MetricTypeFloat},
+	"cpu.coreCount":           {UnitCount, MetricTypeInt},
+	"cpu.perCoreUsage[]":      {UnitPercent, MetricTypeFloat},
+	"cpu.loadAverage[]":       {UnitLoadAverage, MetricTypeFloat},
+	"cpu.frequencyMhz":        {UnitMHz, MetricTypeInt},
+	"cpu.frequencyMaxMhz":     {UnitMHz, MetricTypeInt},
+	"cpu.physicalCores":       {UnitCount, MetricTypeInt},
+	"cpu.logicalCores":        {UnitCount, MetricTypeInt},
+	"cpu.temperature":         {UnitCelsius, MetricTypeFloat},
+	"cpu.coreStats.min":       {UnitPercent, MetricTypeFloat},
+	"cpu.coreStats.max":       {UnitPercent, MetricTypeFloat},
+	"cpu.coreStats.avg":       {UnitPercent, MetricTypeFloat},
+	"cpu.coreStats.imbalance": {UnitPercent, MetricTypeFloat},
+
+	"memory.total":          {UnitBytes, MetricTypeInt},
+	"memory.used":           {UnitBytes, MetricTypeInt},
+	"memory.available":      {UnitBytes, MetricTypeInt},
+	"memory.swapTotal":      {UnitBytes, MetricTypeInt},
+	"memory.swapUsed":       {UnitBytes, MetricTypeInt},
+	"memory.cached":         {UnitBytes, MetricTypeInt},
+	"memory.buffers":        {UnitBytes, MetricTypeInt},
+	"memory.memorySpeedMhz": {UnitMHz, MetricTypeInt},
+
+	"disks[].total":            {UnitBytes, MetricTypeInt},
+	"disks[].used":             {UnitBytes, MetricTypeInt},
+	"disks[].available":        {UnitBytes, MetricTypeInt},
+	"disks[].usagePercent":     {UnitPercent, MetricTypeFloat},
+	"disks[].readBytesPerSec":  {UnitBytesPerSec, MetricTypeInt},
+	"disks[].writeBytesPerSec": {UnitBytesPerSec, MetricTypeInt},
+	"disks[].readIops":         {UnitCountPerSec, MetricTypeInt},
+	"disks[].writeIops":        {UnitCountPerSec, MetricTypeInt},
+	"disks[].temperature":      {UnitCelsius, MetricTypeFloat},
+
+	"networks[].rxBytesPerSec":   {UnitBytesPerSec, MetricTypeInt},
+	"networks[].txBytesPerSec":   {UnitBytesPerSec, MetricTypeInt},
+	"networks[].rxPacketsPerSec": {UnitCountPerSec, MetricTypeInt},
+	"networks[].txPacketsPerSec": {UnitCountPerSec, MetricTypeInt},
+	"networks[].speedMbps":       {UnitMbps, MetricTypeInt},
+
+	"gpus[].usagePercent":    {UnitPercent, MetricTypeFloat},
+	"gpus[].memoryTotal":     {UnitBytes, MetricTypeInt},
+	"gpus[].memoryUsed":      {UnitBytes, MetricTypeInt},
+	"gpus[].temperature":     {UnitCelsius, MetricTypeFloat},
+	"gpus[].fanSpeedPercent": {UnitPercent, MetricTypeInt},
+	"gpus[].powerWatts":      {UnitWatts, MetricTypeInt},
+	"gpus[].powerLimitWatts": {UnitWatts, MetricTypeInt},
+	"gpus[].clockCoreMhz":    {UnitMHz, MetricTypeInt},
+	"gpus[].clockMemoryMhz":  {UnitMHz, MetricTypeInt},
+	"gpus[].encoderUsage":    {UnitPercent, MetricTypeFloat},
+	"gpus[].decoderUsage":    {UnitPercent, MetricTypeFloat},
+
+	"npus[].usagePercent": {UnitPercent, MetricTypeFloat},
+	"npus[].memoryTotal":  {UnitBytes, MetricTypeInt},
+	"npus[].memoryUsed":   {UnitBytes, MetricTypeInt},
+	"npus[].temperature":  {UnitCelsius, MetricTypeFloat},
+	"npus[].powerWatts":   {UnitWatts, MetricTypeInt},
+
+	"userSessions[].loginTime":   {UnitUnixSeconds, MetricTypeInt},
+	"userSessions[].idleSeconds": {UnitSeconds, MetricTypeInt},
+	"userSessionCount":           {UnitCount, MetricTypeInt},
+	"userSessionsTruncated":      {UnitCount, MetricTypeInt},
+
+	"systemInfo.bootTime":      {UnitUnixSeconds, MetricTypeInt},
+	"systemInfo.uptimeSeconds": {UnitSeconds, MetricTypeInt},
+
+	"loadAverage[]": {UnitLoadAverage, MetricTypeFloat},
+	"ageSeconds":    {UnitSeconds, MetricTypeFloat},
+}
+
+// MetricUnits returns the unit of each numeric metric field, keyed by JSON
+// path
+func MetricUnits() map[string]MetricUnit {
+	units := make(map[string]MetricUnit, len(metricUnits))
+	for path, unit := range metricUnits {
+		units[path] = unit
+	}
+	return units
+}
+
+// HumanizeMetrics formats every metric field with a known unit for display,
+// keyed by JSON path with list indexes, as in "disks[0].total"
+func HumanizeMetrics(data *MetricsData) map[string]string {
+	formatted := make(map[string]string)
+	if data != nil {
+		humanizeValue(reflect.ValueOf(*data), "", "", formatted)
+	}
+	return formatted
+}
+
+// humanizeValue walks a value, tracking both the indexed path used in the
+// output and the "[]" path used to look up units
+func humanizeValue(v reflect.Value, path, unitPath string, out map[string]string) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			humanizeValue(v.Elem(), path, unitPath, out)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			if path != "" {
+				humanizeValue(v.Field(i), path+"."+name, unitPath+"."+name, out)
+			} else {
+				humanizeValue(v.Field(i), name, name, out)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			humanizeValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), unitPath+"[]", out)
+		}
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		if unit, ok := metricUnits[unitPath]; ok {
+			out[path] = FormatMetric(unit.Unit, toFloat(v))
+		}
+	case reflect.Float32, reflect.Float64:
+		if unit, ok := metricUnits[unitPath]; ok {
+			out[path] = FormatMetric(unit.Unit, v.Float())
+		}
+	}
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	default:
+		return float64(v.Int())
+	}
+}
+
+// FormatMetric renders a value in the given unit for display, scaling bytes
+// to binary multiples and frequencies and link speeds to larger units
+func FormatMetric(unit string, value float64) string {
+	switch unit {
+	case UnitBytes:
+		return formatBytes(value)
+	case UnitBytesPerSec:
+		return formatBytes(value) + "/s"
+	case UnitPercent:
+		return fmt.Sprintf("%.1f%%", value)
+	case UnitMHz:
+		if value >= 1000 {
+			return fmt.Sprintf("%.2f GHz", value/1000)
+		}
+		return fmt.Sprintf("%.0f MHz", value)
+	case UnitMbps:
+		if value >= 1000 {
+			return fmt.Sprintf("%g Gbps", value/1000)
+		}
+		return fmt.Sprintf("%.0f Mbps", value)
+	case UnitCelsius:
+		return fmt.Sprintf("%.1f °C", value)
+	case UnitWatts:
+		return fmt.Sprintf("%.0f W", value)
+	case UnitSeconds:
+		return formatDuration(value)
+	case UnitUnixSeconds:
+		return time.Unix(int64(value), 0).UTC().Format(time.RFC3339)
+	case UnitCountPerSec:
+		return fmt.Sprintf("%.0f/s", value)
+	case UnitLoadAverage:
+		return fmt.Sprintf("%.2f", value)
+	default:
+		return fmt.Sprintf("%g", value)
+	}
+}
+
+func formatBytes(value float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", value, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
+
+func formatDuration(seconds float64) string {
+	total := int64(seconds)
+	days, hours, minutes := total/86400, total%86400/3600, total%3600/60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	case minutes > 0:
+		return fmt.Sprintf("%dm %ds", minutes, total%60)
+	default:
+		return fmt.Sprintf("%.0fs", seconds)
+	}
+}
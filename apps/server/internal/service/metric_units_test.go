@@ -0,0 +1,65 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMetricUnitsDescriptor(t *testing.T) {
+	units := MetricUnits()
+	if unit := units["memory.total"]; unit.Unit != UnitBytes || unit.Type != MetricTypeInt {
+		t.Errorf("Expected memory.total in bytes, got %+v", unit)
+	}
+	if unit := units["cpu.frequencyMhz"]; unit.Unit != UnitMHz {
+		t.Errorf("Expected cpu.frequencyMhz in MHz, got %+v", unit)
+	}
+	if unit := units["disks[].readBytesPerSec"]; unit.Unit != UnitBytesPerSec {
+		t.Errorf("Expected disk read rate in bytes/s, got %+v", unit)
+	}
+
+	// Every described field exists in a fully populated sample
+	sample := &MetricsData{
+		CPU:          CPUData{PerCoreUsage: []float64{1}, LoadAverage: []float64{1}, CoreStats: &CoreStats{}},
+		Disks:        []DiskData{{}},
+		Networks:     []NetData{{}},
+		GPUs:         []GPUData{{}},
+		NPUs:         []NPUData{{}},
+		UserSessions: []UserSession{{}},
+		SystemInfo:   &SystemInfo{},
+		LoadAverage:  []float64{1},
+	}
+	formatted := HumanizeMetrics(sample)
+	if len(formatted) != len(units) {
+		t.Errorf("Expected %d formatted fields, got %d", len(units), len(formatted))
+	}
+}
+
+func TestHumanizeMetrics(t *testing.T) {
+	data := &MetricsData{
+		CPU:    CPUData{UsagePercent: 42.25, FrequencyMhz: 3600},
+		Memory: MemData{Total: 16 * 1024 * 1024 * 1024, Used: 512},
+		Disks:  []DiskData{{MountPoint: "/", ReadBytesPS: 1536}},
+		SystemInfo: &SystemInfo{
+			BootTime:      time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC).Unix(),
+			UptimeSeconds: 90061,
+		},
+	}
+	formatted := HumanizeMetrics(data)
+	expected := map[string]string{
+		"cpu.usagePercent":         "42.2%",
+		"cpu.frequencyMhz":         "3.60 GHz",
+		"memory.total":             "16.0 GiB",
+		"memory.used":              "512 B",
+		"disks[0].readBytesPerSec": "1.5 KiB/s",
+		"systemInfo.bootTime":      "2026-03-01T12:00:00Z",
+		"systemInfo.uptimeSeconds": "1d 1h 1m",
+	}
+	for path, want := range expected {
+		if got := formatted[path]; got != want {
+			t.Errorf("Expected %s to be %q, got %q", path, want, got)
+		}
+	}
+	if _, ok := formatted["disks[0].mountPoint"]; ok {
+		t.Error("Expected fields without a unit to be left out")
+	}
+}
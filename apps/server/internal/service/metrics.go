@@ -1,9 +1,14 @@
 package service
 
 import (
+	"errors"
+	"hash/fnv"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
 	"go.uber.org/zap"
 )
 
@@ -20,6 +25,19 @@ type MetricsData struct {
 	UserSessions []UserSession `json:"userSessions"`
 	SystemInfo   *SystemInfo   `json:"systemInfo,omitempty"`
 	LoadAverage  []float64     `json:"loadAverage"`
+
+	// Sessions the agent reported; UserSessionsTruncated of the least
+	// recently active ones were dropped from UserSessions by the cap
+	UserSessionCount      int `json:"userSessionCount"`
+	UserSessionsTruncated int `json:"userSessionsTruncated,omitempty"`
+
+	// Incomplete is set while only realtime or periodic updates have arrived,
+	// so static fields such as core count and total memory are still zero
+	Incomplete bool `json:"incomplete,omitempty"`
+
+	// Freshness, computed when metrics are read; never stored
+	Stale      bool    `json:"stale"`
+	AgeSeconds float64 `json:"ageSeconds"`
 }
 
 type CPUData struct {
@@ -35,6 +53,9 @@ type CPUData struct {
 	LogicalCores  int       `json:"logicalCores"`
 	Architecture  string    `json:"architecture"`
 	Temperature   float64   `json:"temperature"`
+
+	// Summary of PerCoreUsage, computed when metrics are read; never stored
+	CoreStats *CoreStats `json:"coreStats,omitempty"`
 }
 
 type MemData struct {
@@ -137,30 +158,226 @@ type SystemInfo struct {
 
 // MetricsService manages metrics storage and retrieval
 type MetricsService struct {
-	// Current metrics per agent
-	current map[string]*MetricsData
-	// Historical metrics (ring buffer per agent)
-	history map[string][]*MetricsData
-	// Max history entries per agent
-	maxHistory int
-	mu         sync.RWMutex
-	logger     *zap.SugaredLogger
+	// Per-agent metrics, striped by agent ID so updates for different agents
+	// proceed in parallel while one agent's updates apply in order
+	shards []*metricsShard
+	// mu guards the settings below, never per-agent state. It is released
+	// before any shard lock is taken.
+	mu     sync.RWMutex
+	logger *zap.SugaredLogger
 
 	// Broadcast callback for real-time push to dashboard clients
 	broadcastCallback func(agentID string, metrics interface{})
 
 	// Persistence service for database storage
 	persistence *MetricsPersistence
+
+	// Serves history queries instead of the ring buffer when set
+	history HistoryStore
+
+	// Per-agent importance weights for the weighted summary (default 1)
+	weights map[string]float64
+
+	clock Clock
+
+	// Host metric and per-device GPU/NPU threshold alerts
+	alertEngine        *AlertEngine
+	acceleratorAlerter *AcceleratorAlerter
+
+	// Metrics older than staleAfter, or from agents reported as not live,
+	// are flagged stale when read
+	staleAfter time.Duration
+	liveness   func(agentID string) bool
+
+	// How samples of agents without a full snapshot yet are published
+	partialMode string
+
+	// Drops unwanted disks and network interfaces at ingestion
+	filter *MetricFilter
+
+	// Most user sessions kept per agent, 0 for no limit
+	maxUserSessions int
+
+	// Max history entries per agent, unless a retention policy overrides it
+	maxHistory int
+	retention  *RetentionPolicyService
+
+	// Vendor -> temperature thresholds for GPU/NPU health scores
+	acceleratorThresholds map[string]config.AcceleratorThresholds
+
+	// Full metrics snapshots stored since startup
+	ingested atomic.Int64
 }
 
+// metricsShard holds the metrics of the agents hashed to it
+type metricsShard struct {
+	// Current metrics per agent
+	current map[string]*MetricsData
+	// Historical metrics (ring buffer per agent)
+	history map[string][]*MetricsData
+	// Latest process list per agent
+	processes map[string]*ProcessSnapshot
+	mu        sync.RWMutex
+}
+
+// metricsSettings is a snapshot of the settings used while a shard is locked
+type metricsSettings struct {
+	clock       Clock
+	staleAfter  time.Duration
+	liveness    func(agentID string) bool
+	broadcast   func(agentID string, metrics interface{})
+	persistence *MetricsPersistence
+	history     HistoryStore
+	engine      *AlertEngine
+	alerter     *AcceleratorAlerter
+	partialMode string
+	filter      *MetricFilter
+
+	maxUserSessions int
+	maxHistory      int
+	retention       *RetentionPolicyService
+}
+
+// DefaultStaleAfter is how old the last sample may be before it is flagged stale
+const DefaultStaleAfter = 30 * time.Second
+
+// DefaultMetricsShards is the number of lock stripes for per-agent metrics
+const DefaultMetricsShards = 64
+
+// How metrics of agents that have not sent a full snapshot are published.
+// Incomplete samples are never persisted in either mode.
+const (
+	// PartialMetricsHold withholds broadcasts until the first full snapshot
+	PartialMetricsHold = "hold"
+	// PartialMetricsMark broadcasts them flagged as incomplete
+	PartialMetricsMark = "mark"
+)
+
+// ErrInvalidPartialMetricsMode is returned for unknown partial metrics modes
+var ErrInvalidPartialMetricsMode = errors.New("invalid partial metrics mode")
+
 // NewMetricsService creates a new metrics service
 func NewMetricsService(logger *zap.SugaredLogger) *MetricsService {
-	return &MetricsService{
-		current:    make(map[string]*MetricsData),
-		history:    make(map[string][]*MetricsData),
-		maxHistory: 600, // 10 minutes at 1-second intervals
-		logger:     logger,
+	return NewMetricsServiceWithShards(logger, DefaultMetricsShards)
+}
+
+// NewMetricsServiceWithShards creates a metrics service whose per-agent
+// state is split across the given number of independently locked shards.
+// One shard serializes all agents' updates; shards <= 0 uses the default.
+func NewMetricsServiceWithShards(logger *zap.SugaredLogger, shards int) *MetricsService {
+	if shards <= 0 {
+		shards = DefaultMetricsShards
+	}
+	s := &MetricsService{
+		shards:      make([]*metricsShard, shards),
+		maxHistory:  DefaultMaxMemoryHistory, // 10 minutes at 1-second intervals
+		logger:      logger,
+		weights:     make(map[string]float64),
+		clock:       RealClock,
+		staleAfter:  DefaultStaleAfter,
+		partialMode: PartialMetricsHold,
+	}
+	for i := range s.shards {
+		s.shards[i] = &metricsShard{
+			current:   make(map[string]*MetricsData),
+			history:   make(map[string][]*MetricsData),
+			processes: make(map[string]*ProcessSnapshot),
+		}
+	}
+	return s
+}
+
+// shard returns the shard holding an agent's metrics
+func (s *MetricsService) shard(agentID string) *metricsShard {
+	h := fnv.New32a()
+	h.Write([]byte(agentID))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// settings snapshots the settings so they can be used under a shard lock
+func (s *MetricsService) settings() metricsSettings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return metricsSettings{
+		clock:       s.clock,
+		staleAfter:  s.staleAfter,
+		liveness:    s.liveness,
+		broadcast:   s.broadcastCallback,
+		persistence: s.persistence,
+		history:     s.history,
+		engine:      s.alertEngine,
+		alerter:     s.acceleratorAlerter,
+		partialMode: s.partialMode,
+		filter:      s.filter,
+
+		maxUserSessions: s.maxUserSessions,
+		maxHistory:      s.maxHistory,
+		retention:       s.retention,
+	}
+}
+
+// historyLimit returns the number of samples kept in memory for an agent
+func (cfg metricsSettings) historyLimit(agentID string) int {
+	if cfg.retention != nil {
+		return cfg.retention.Resolve(agentID).MaxMemoryHistory
+	}
+	return cfg.maxHistory
+}
+
+// appendHistory adds a sample to an agent's ring buffer, dropping the oldest
+// ones beyond limit (internal, must hold shard lock)
+func (sh *metricsShard) appendHistory(agentID string, data *MetricsData, limit int) {
+	history, exists := sh.history[agentID]
+	if !exists {
+		history = make([]*MetricsData, 0, limit)
+	}
+	if excess := len(history) - limit + 1; excess > 0 {
+		// Remove oldest entries, more than one after the limit shrinks
+		history = history[excess:]
+	}
+	sh.history[agentID] = append(history, data)
+}
+
+// publishable reports whether a sample may be broadcast
+func (cfg metricsSettings) publishable(data *MetricsData) bool {
+	return !data.Incomplete || cfg.partialMode == PartialMetricsMark
+}
+
+// currentFor returns an agent's current metrics, creating them as
+// incomplete if needed (internal, must hold shard lock)
+func (sh *metricsShard) currentFor(agentID string) *MetricsData {
+	current := sh.current[agentID]
+	if current == nil {
+		current = &MetricsData{AgentID: agentID, Incomplete: true}
+		sh.current[agentID] = current
 	}
+	return current
+}
+
+// recentHistory returns up to limit of an agent's most recent samples
+// (internal, must hold shard lock)
+func (sh *metricsShard) recentHistory(agentID string, limit int) []*MetricsData {
+	history, exists := sh.history[agentID]
+	if !exists {
+		return nil
+	}
+
+	if limit <= 0 || limit > len(history) {
+		limit = len(history)
+	}
+
+	// Return most recent entries
+	start := len(history) - limit
+	result := make([]*MetricsData, limit)
+	copy(result, history[start:])
+	return result
+}
+
+// SetClock sets the time source used to timestamp incoming metrics
+func (s *MetricsService) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
 }
 
 // SetPersistence sets the persistence service for database storage
@@ -170,43 +387,104 @@ func (s *MetricsService) SetPersistence(p *MetricsPersistence) {
 	s.persistence = p
 }
 
-// StoreMetrics stores metrics for an agent
-func (s *MetricsService) StoreMetrics(agentID string, data *MetricsData) {
+// SetHistoryStore sets the store history queries are served from. Samples
+// are still kept in the ring buffer, which GetAllMetricsHistory reads; nil
+// serves queries from the ring buffer again.
+func (s *MetricsService) SetHistoryStore(store HistoryStore) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.history = store
+}
 
-	data.AgentID = agentID
-	data.Timestamp = time.Now()
+// SetMetricFilter sets the filter applied to disks and network interfaces
+// as metrics arrive; nil keeps everything
+func (s *MetricsService) SetMetricFilter(f *MetricFilter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filter = f
+}
 
-	// Update current
-	s.current[agentID] = data
+// SetMaxUserSessions caps the user sessions kept and broadcast per agent,
+// keeping the most recently active. 0 keeps all of them.
+func (s *MetricsService) SetMaxUserSessions(max int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxUserSessions = max
+}
 
-	// Add to history
-	if _, exists := s.history[agentID]; !exists {
-		s.history[agentID] = make([]*MetricsData, 0, s.maxHistory)
-	}
+// SetRetentionPolicies resolves each agent's in-memory history size from
+// policies instead of the global size
+func (s *MetricsService) SetRetentionPolicies(policies *RetentionPolicyService) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retention = policies
+}
 
-	history := s.history[agentID]
-	if len(history) >= s.maxHistory {
-		// Remove oldest entry
-		history = history[1:]
+// SetAcceleratorAlerter sets the alerter evaluated on every GPU/NPU update
+func (s *MetricsService) SetAcceleratorAlerter(a *AcceleratorAlerter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acceleratorAlerter = a
+}
+
+// SetAlertEngine sets the host metric rules evaluated on every update
+func (s *MetricsService) SetAlertEngine(e *AlertEngine) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alertEngine = e
+}
+
+// evaluateAlerts runs host metric and accelerator rules against an agent's
+// metrics. Must be called with the agent's shard lock held, which keeps an
+// agent's samples in order; handlers run asynchronously.
+func (cfg metricsSettings) evaluateAlerts(agentID string, data *MetricsData) {
+	if cfg.engine != nil {
+		if events := cfg.engine.Evaluate(agentID, data); len(events) > 0 {
+			go cfg.engine.dispatch(events)
+		}
+	}
+	if cfg.alerter != nil {
+		if alerts := cfg.alerter.Evaluate(agentID, data); len(alerts) > 0 {
+			go cfg.alerter.dispatch(alerts)
+		}
 	}
+}
+
+// IngestedCount returns the number of full metrics snapshots stored since startup
+func (s *MetricsService) IngestedCount() int64 {
+	return s.ingested.Load()
+}
+
+// StoreMetrics stores metrics for an agent
+func (s *MetricsService) StoreMetrics(agentID string, data *MetricsData) {
+	cfg := s.settings()
+	shard := s.shard(agentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	s.ingested.Add(1)
+	data.AgentID = agentID
+	data.Timestamp = cfg.clock.Now()
+	data.Incomplete = false
+	data.Disks = cfg.filter.Disks(agentID, data.Disks)
+	data.Networks = cfg.filter.Networks(agentID, data.Networks)
+	cfg.setUserSessions(data, data.UserSessions)
+
+	// Update current
+	shard.current[agentID] = data
 
 	// Broadcast to dashboard clients if callback is set
-	if s.broadcastCallback != nil {
-		go s.broadcastCallback(agentID, data)
+	if cfg.broadcast != nil {
+		go cfg.broadcast(agentID, data)
 	}
 
-	s.history[agentID] = append(history, data)
+	// Add to history
+	shard.appendHistory(agentID, data, cfg.historyLimit(agentID))
 
-	// Persist to database (async to not block)
-	if s.persistence != nil {
-		go func(aid string, d *MetricsData) {
-			if err := s.persistence.SaveMetrics(aid, d); err != nil {
-				s.logger.Warnf("Failed to persist metrics for %s: %v", aid, err)
-			}
-		}(agentID, data)
-	}
+	cfg.evaluateAlerts(agentID, data)
+
+	// Hand to the history store and persist to database (async to not block)
+	cfg.record(agentID, data)
 }
 
 // SetBroadcastCallback sets the callback for broadcasting metrics to dashboard clients
@@ -216,81 +494,334 @@ func (s *MetricsService) SetBroadcastCallback(callback func(agentID string, metr
 	s.broadcastCallback = callback
 }
 
-// GetCurrentMetrics returns current metrics for an agent
+// SetStaleAfter sets how old the last sample may be before it is flagged stale
+func (s *MetricsService) SetStaleAfter(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d <= 0 {
+		d = DefaultStaleAfter
+	}
+	s.staleAfter = d
+}
+
+// SetPartialMetricsMode sets how metrics of agents without a full snapshot
+// are published; "" keeps the default of PartialMetricsHold
+func (s *MetricsService) SetPartialMetricsMode(mode string) error {
+	switch mode {
+	case "":
+		mode = PartialMetricsHold
+	case PartialMetricsHold, PartialMetricsMark:
+	default:
+		return ErrInvalidPartialMetricsMode
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partialMode = mode
+	return nil
+}
+
+// Publishable reports whether an agent's current metrics may be pushed to
+// subscribers under the partial metrics mode
+func (s *MetricsService) Publishable(data *MetricsData) bool {
+	return data != nil && s.settings().publishable(data)
+}
+
+// SetLivenessCheck sets the function reporting whether an agent is still
+// connected and heartbeating. Metrics of agents that are not live are stale
+// regardless of their age.
+func (s *MetricsService) SetLivenessCheck(check func(agentID string) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.liveness = check
+}
+
+// CoreStats summarizes per-core CPU usage. Imbalance is max-min and flags
+// e.g. a single pinned core on an otherwise idle machine.
+type CoreStats struct {
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Avg       float64 `json:"avg"`
+	Imbalance float64 `json:"imbalance"`
+}
+
+// ComputeCoreStats returns min/max/avg/imbalance of per-core usage, or nil
+// if there are no cores
+func ComputeCoreStats(perCore []float64) *CoreStats {
+	if len(perCore) == 0 {
+		return nil
+	}
+
+	stats := &CoreStats{Min: perCore[0], Max: perCore[0]}
+	var sum float64
+	for _, usage := range perCore {
+		if usage < stats.Min {
+			stats.Min = usage
+		}
+		if usage > stats.Max {
+			stats.Max = usage
+		}
+		sum += usage
+	}
+	stats.Avg = sum / float64(len(perCore))
+	stats.Imbalance = stats.Max - stats.Min
+	return stats
+}
+
+// OverviewMetrics returns a copy of data without the raw per-core usage
+// array, for fleet overviews that only need CoreStats
+func OverviewMetrics(data *MetricsData) *MetricsData {
+	overview := *data
+	overview.CPU.PerCoreUsage = nil
+	return &overview
+}
+
+// SummarizeUserSessions returns a copy of data with the session list
+// dropped, leaving only UserSessionCount
+func SummarizeUserSessions(data *MetricsData) *MetricsData {
+	summary := *data
+	summary.UserSessions = nil
+	summary.UserSessionsTruncated = 0
+	return &summary
+}
+
+// setUserSessions stores an agent's reported sessions, keeping only the
+// most recently active ones when over the cap. The input is not modified.
+func (cfg metricsSettings) setUserSessions(data *MetricsData, sessions []UserSession) {
+	data.UserSessionCount = len(sessions)
+	data.UserSessionsTruncated = 0
+	if cfg.maxUserSessions <= 0 || len(sessions) <= cfg.maxUserSessions {
+		data.UserSessions = sessions
+		return
+	}
+	sorted := append([]UserSession(nil), sessions...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].IdleSeconds != sorted[j].IdleSeconds {
+			return sorted[i].IdleSeconds < sorted[j].IdleSeconds
+		}
+		return sorted[i].LoginTime > sorted[j].LoginTime
+	})
+	data.UserSessions = sorted[:cfg.maxUserSessions]
+	data.UserSessionsTruncated = len(sessions) - cfg.maxUserSessions
+}
+
+// CloneMetrics returns a copy of data that shares no slices with the
+// original, so later merges cannot change it
+func CloneMetrics(data *MetricsData) *MetricsData {
+	if data == nil {
+		return nil
+	}
+	clone := *data
+	clone.CPU.PerCoreUsage = append([]float64(nil), data.CPU.PerCoreUsage...)
+	clone.CPU.LoadAverage = append([]float64(nil), data.CPU.LoadAverage...)
+	clone.Disks = append([]DiskData(nil), data.Disks...)
+	clone.Networks = append([]NetData(nil), data.Networks...)
+	clone.GPUs = append([]GPUData(nil), data.GPUs...)
+	clone.NPUs = append([]NPUData(nil), data.NPUs...)
+	clone.UserSessions = append([]UserSession(nil), data.UserSessions...)
+	clone.LoadAverage = append([]float64(nil), data.LoadAverage...)
+	if data.SystemInfo != nil {
+		info := *data.SystemInfo
+		clone.SystemInfo = &info
+	}
+	return &clone
+}
+
+// withFreshness returns a copy of data annotated with its age, staleness by
+// age and per-core summary (internal, must hold shard lock)
+func (cfg metricsSettings) withFreshness(data *MetricsData) *MetricsData {
+	annotated := *data
+	annotated.CPU.CoreStats = ComputeCoreStats(data.CPU.PerCoreUsage)
+	age := cfg.clock.Since(data.Timestamp)
+	if age < 0 {
+		age = 0
+	}
+	annotated.AgeSeconds = age.Seconds()
+	annotated.Stale = age > cfg.staleAfter
+	return &annotated
+}
+
+// checkLiveness marks annotated metrics of an agent that is not live as
+// stale and returns them. The check takes AgentService's lock, so it must
+// run without a shard lock held.
+func (cfg metricsSettings) checkLiveness(agentID string, annotated *MetricsData) *MetricsData {
+	if annotated != nil && !annotated.Stale && cfg.liveness != nil && !cfg.liveness(agentID) {
+		annotated.Stale = true
+	}
+	return annotated
+}
+
+// forEachCurrent calls fn with every agent's current metrics, read-locking
+// one shard at a time
+func (s *MetricsService) forEachCurrent(fn func(agentID string, data *MetricsData)) {
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		for id, data := range shard.current {
+			fn(id, data)
+		}
+		shard.mu.RUnlock()
+	}
+}
+
+// GetCurrentMetrics returns current metrics for an agent, annotated with freshness
 func (s *MetricsService) GetCurrentMetrics(agentID string) *MetricsData {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.current[agentID]
+	cfg := s.settings()
+	shard := s.shard(agentID)
+	shard.mu.RLock()
+	data := shard.current[agentID]
+	if data != nil {
+		data = cfg.withFreshness(data)
+	}
+	shard.mu.RUnlock()
+	return cfg.checkLiveness(agentID, data)
 }
 
-// GetAllCurrentMetrics returns current metrics for all agents
-func (s *MetricsService) GetAllCurrentMetrics() map[string]*MetricsData {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// SnapshotMetrics returns an independent copy of an agent's current metrics
+func (s *MetricsService) SnapshotMetrics(agentID string) *MetricsData {
+	cfg := s.settings()
+	shard := s.shard(agentID)
+	shard.mu.RLock()
+	data := shard.current[agentID]
+	if data != nil {
+		data = CloneMetrics(cfg.withFreshness(data))
+	}
+	shard.mu.RUnlock()
+	return cfg.checkLiveness(agentID, data)
+}
 
+// GetAllCurrentMetrics returns current metrics for all agents, annotated with freshness
+func (s *MetricsService) GetAllCurrentMetrics() map[string]*MetricsData {
+	cfg := s.settings()
 	result := make(map[string]*MetricsData)
-	for id, data := range s.current {
-		result[id] = data
+	s.forEachCurrent(func(id string, data *MetricsData) {
+		result[id] = cfg.withFreshness(data)
+	})
+	for id, data := range result {
+		cfg.checkLiveness(id, data)
 	}
 	return result
 }
 
-// GetMetricsHistory returns historical metrics for an agent
+// GetMetricsHistory returns up to limit of an agent's most recent samples;
+// limit <= 0 returns all of them
 func (s *MetricsService) GetMetricsHistory(agentID string, limit int) []*MetricsData {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	history, exists := s.history[agentID]
-	if !exists {
-		return nil
+	if store := s.settings().history; store != nil {
+		history := store.Query(agentID, time.Time{}, time.Time{})
+		if limit > 0 && len(history) > limit {
+			history = history[len(history)-limit:]
+		}
+		return history
 	}
 
-	if limit <= 0 || limit > len(history) {
-		limit = len(history)
+	shard := s.shard(agentID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.recentHistory(agentID, limit)
+}
+
+// GetMetricsHistoryRange returns an agent's history with timestamps
+// between start and end inclusive. A zero end leaves the range open.
+func (s *MetricsService) GetMetricsHistoryRange(agentID string, start, end time.Time) []*MetricsData {
+	if store := s.settings().history; store != nil {
+		return store.Query(agentID, start, end)
 	}
 
-	// Return most recent entries
-	start := len(history) - limit
-	result := make([]*MetricsData, limit)
-	copy(result, history[start:])
+	shard := s.shard(agentID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	// History is appended in arrival order, so timestamps are ascending
+	history := shard.history[agentID]
+	from := sort.Search(len(history), func(i int) bool {
+		return !history[i].Timestamp.Before(start)
+	})
+	to := len(history)
+	if !end.IsZero() {
+		to = sort.Search(len(history), func(i int) bool {
+			return history[i].Timestamp.After(end)
+		})
+	}
+	if from >= to {
+		return nil
+	}
+	result := make([]*MetricsData, to-from)
+	copy(result, history[from:to])
 	return result
 }
 
 // GetAllMetricsHistory returns historical metrics for all agents
 func (s *MetricsService) GetAllMetricsHistory(limit int) map[string][]*MetricsData {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	result := make(map[string][]*MetricsData)
-	for id := range s.history {
-		result[id] = s.GetMetricsHistory(id, limit)
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		for id := range shard.history {
+			result[id] = shard.recentHistory(id, limit)
+		}
+		shard.mu.RUnlock()
 	}
 	return result
 }
 
+// AgentOffline drops what is only kept for a connected agent: its firing
+// alerts resolve and its sample held back for persistence is written. Its
+// metrics stay until it is removed.
+func (s *MetricsService) AgentOffline(agentID string) {
+	cfg := s.settings()
+	if cfg.engine != nil {
+		cfg.engine.Forget(agentID)
+	}
+	if cfg.alerter != nil {
+		cfg.alerter.Forget(agentID)
+	}
+	if cfg.persistence != nil {
+		cfg.persistence.Release(agentID)
+	}
+}
+
 // RemoveAgent removes metrics for an agent
 func (s *MetricsService) RemoveAgent(agentID string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	cfg := s.settings()
+	cfg.filter.Forget(agentID)
+	if cfg.engine != nil {
+		cfg.engine.Forget(agentID)
+	}
+	if cfg.alerter != nil {
+		cfg.alerter.Forget(agentID)
+	}
+	shard := s.shard(agentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
 
-	delete(s.current, agentID)
-	delete(s.history, agentID)
+	delete(shard.current, agentID)
+	delete(shard.history, agentID)
+	delete(shard.processes, agentID)
 }
 
 // GetSummary returns a summary of all metrics
 func (s *MetricsService) GetSummary() map[string]interface{} {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	cfg := s.settings()
 
 	totalCPU := 0.0
 	totalMem := uint64(0)
 	usedMem := uint64(0)
-	agentCount := len(s.current)
-
-	for _, data := range s.current {
+	agentCount := 0
+	staleCount := 0
+	var fresh []string // checked for liveness once the shards are unlocked
+
+	s.forEachCurrent(func(id string, data *MetricsData) {
+		agentCount++
+		if cfg.withFreshness(data).Stale {
+			staleCount++
+		} else {
+			fresh = append(fresh, id)
+		}
 		totalCPU += data.CPU.UsagePercent
 		totalMem += data.Memory.Total
 		usedMem += data.Memory.Used
+	})
+	for _, id := range fresh {
+		if cfg.liveness != nil && !cfg.liveness(id) {
+			staleCount++
+		}
 	}
 
 	avgCPU := 0.0
@@ -308,6 +839,7 @@ func (s *MetricsService) GetSummary() map[string]interface{} {
 		"totalMemory":   totalMem,
 		"usedMemory":    usedMem,
 		"memoryPercent": memPercent,
+		"staleCount":    staleCount,
 	}
 }
 
@@ -325,38 +857,54 @@ type RealtimeUpdate struct {
 	LoadAverage  []float64
 	GPUUsage     []GPUData
 	NPUUsage     []NPUData
+
+	// Delta updates only carry the field groups flagged in Fields
+	// (RealtimeField* bits); everything else keeps its last value
+	Delta  bool
+	Fields uint32
+}
+
+// has reports whether the update carries the given field group
+func (u *RealtimeUpdate) has(field uint32) bool {
+	return !u.Delta || u.Fields&field != 0
 }
 
 // MergeRealtimeMetrics merges realtime data into existing metrics
 func (s *MetricsService) MergeRealtimeMetrics(agentID string, update interface{}) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	cfg := s.settings()
+	shard := s.shard(agentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
 
 	// Get or create current metrics
-	current := s.current[agentID]
-	if current == nil {
-		current = &MetricsData{AgentID: agentID}
-		s.current[agentID] = current
-	}
-	current.Timestamp = time.Now()
+	current := shard.currentFor(agentID)
+	current.Timestamp = cfg.clock.Now()
 
 	// Type assert and merge
 	if rt, ok := update.(*RealtimeUpdate); ok && rt != nil {
-		current.CPU.UsagePercent = rt.CPUUsage
-		if len(rt.CPUPerCore) > 0 {
+		if rt.has(RealtimeFieldCPUUsage) {
+			current.CPU.UsagePercent = rt.CPUUsage
+		}
+		if rt.has(RealtimeFieldCPUPerCore) && len(rt.CPUPerCore) > 0 {
 			current.CPU.PerCoreUsage = rt.CPUPerCore
 		}
-		current.CPU.Temperature = rt.CPUTemp
-		current.CPU.FrequencyMhz = rt.CPUFrequency
-		current.Memory.Used = rt.MemoryUsed
-		current.Memory.Cached = rt.MemoryCached
-		current.Memory.SwapUsed = rt.SwapUsed
-		if len(rt.LoadAverage) > 0 {
+		if rt.has(RealtimeFieldCPUTemperature) {
+			current.CPU.Temperature = rt.CPUTemp
+		}
+		if rt.has(RealtimeFieldCPUFrequency) {
+			current.CPU.FrequencyMhz = rt.CPUFrequency
+		}
+		if rt.has(RealtimeFieldMemory) {
+			current.Memory.Used = rt.MemoryUsed
+			current.Memory.Cached = rt.MemoryCached
+			current.Memory.SwapUsed = rt.SwapUsed
+		}
+		if rt.has(RealtimeFieldLoadAverage) && len(rt.LoadAverage) > 0 {
 			current.LoadAverage = rt.LoadAverage
 		}
 
 		// Merge disk IO by device
-		for _, io := range rt.DiskIO {
+		for _, io := range cfg.filter.Disks(agentID, rt.DiskIO) {
 			found := false
 			for i, d := range current.Disks {
 				if d.Device == io.Device {
@@ -374,7 +922,7 @@ func (s *MetricsService) MergeRealtimeMetrics(agentID string, update interface{}
 		}
 
 		// Merge network IO by interface
-		for _, io := range rt.NetworkIO {
+		for _, io := range cfg.filter.Networks(agentID, rt.NetworkIO) {
 			found := false
 			for i, n := range current.Networks {
 				if n.Interface == io.Interface {
@@ -433,7 +981,9 @@ func (s *MetricsService) MergeRealtimeMetrics(agentID string, update interface{}
 	}
 
 	// Add to history
-	s.addToHistory(agentID, current)
+	s.addToHistory(shard, cfg, agentID, current)
+
+	cfg.evaluateAlerts(agentID, current)
 }
 
 // StaticUpdate holds static hardware info for merging
@@ -449,16 +999,17 @@ type StaticUpdate struct {
 
 // MergeStaticInfo merges static hardware info into existing metrics
 func (s *MetricsService) MergeStaticInfo(agentID string, update interface{}) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	cfg := s.settings()
+	shard := s.shard(agentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
 
-	current := s.current[agentID]
-	if current == nil {
-		current = &MetricsData{AgentID: agentID}
-		s.current[agentID] = current
-	}
+	current := shard.currentFor(agentID)
 
 	if st, ok := update.(*StaticUpdate); ok && st != nil {
+		// Static info fills in the hardware fields realtime updates lack
+		current.Incomplete = false
+
 		if st.CPU != nil {
 			current.CPU.Model = st.CPU.Model
 			current.CPU.Vendor = st.CPU.Vendor
@@ -476,7 +1027,7 @@ func (s *MetricsService) MergeStaticInfo(agentID string, update interface{}) {
 		}
 
 		// Merge disk static info
-		for _, d := range st.Disks {
+		for _, d := range cfg.filter.Disks(agentID, st.Disks) {
 			found := false
 			for i, disk := range current.Disks {
 				if disk.Device == d.Device || disk.MountPoint == d.MountPoint {
@@ -498,7 +1049,7 @@ func (s *MetricsService) MergeStaticInfo(agentID string, update interface{}) {
 		}
 
 		// Merge network static info
-		for _, n := range st.Networks {
+		for _, n := range cfg.filter.Networks(agentID, st.Networks) {
 			found := false
 			for i, net := range current.Networks {
 				if net.Interface == n.Interface {
@@ -568,18 +1119,16 @@ type PeriodicUpdate struct {
 
 // MergePeriodicData merges periodic data into existing metrics
 func (s *MetricsService) MergePeriodicData(agentID string, update interface{}) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	cfg := s.settings()
+	shard := s.shard(agentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
 
-	current := s.current[agentID]
-	if current == nil {
-		current = &MetricsData{AgentID: agentID}
-		s.current[agentID] = current
-	}
+	current := shard.currentFor(agentID)
 
 	if p, ok := update.(*PeriodicUpdate); ok && p != nil {
 		// Merge disk usage
-		for _, d := range p.DiskUsage {
+		for _, d := range cfg.filter.Disks(agentID, p.DiskUsage) {
 			found := false
 			for i, disk := range current.Disks {
 				if disk.Device == d.Device || disk.MountPoint == d.MountPoint {
@@ -603,7 +1152,7 @@ func (s *MetricsService) MergePeriodicData(agentID string, update interface{}) {
 
 		// Replace user sessions
 		if len(p.UserSessions) > 0 {
-			current.UserSessions = p.UserSessions
+			cfg.setUserSessions(current, p.UserSessions)
 		}
 
 		// Merge network updates (IP changes, status)
@@ -621,31 +1170,30 @@ func (s *MetricsService) MergePeriodicData(agentID string, update interface{}) {
 	}
 }
 
-// addToHistory adds metrics to history (internal, must hold lock)
-func (s *MetricsService) addToHistory(agentID string, data *MetricsData) {
-	if _, exists := s.history[agentID]; !exists {
-		s.history[agentID] = make([]*MetricsData, 0, s.maxHistory)
-	}
-
+// addToHistory adds metrics to history (internal, must hold shard lock)
+func (s *MetricsService) addToHistory(shard *metricsShard, cfg metricsSettings, agentID string, data *MetricsData) {
 	// Make a copy for history
 	dataCopy := *data
-	history := s.history[agentID]
-	if len(history) >= s.maxHistory {
-		history = history[1:]
-	}
-	s.history[agentID] = append(history, &dataCopy)
+	shard.appendHistory(agentID, &dataCopy, cfg.historyLimit(agentID))
 
 	// Broadcast to dashboard clients if callback is set
-	if s.broadcastCallback != nil {
-		go s.broadcastCallback(agentID, &dataCopy)
+	if cfg.broadcast != nil && cfg.publishable(&dataCopy) {
+		go cfg.broadcast(agentID, &dataCopy)
 	}
 
-	// Persist to database (async to not block)
-	if s.persistence != nil {
-		go func(aid string, d *MetricsData) {
-			if err := s.persistence.SaveMetrics(aid, d); err != nil {
-				s.logger.Warnf("Failed to persist metrics for %s: %v", aid, err)
-			}
-		}(agentID, &dataCopy)
+	// Hand to the history store and persist to database (async to not block)
+	cfg.record(agentID, &dataCopy)
+}
+
+// record hands a sample to the history store and persistence; persistence
+// set as the history store gets it once (internal, must hold shard lock)
+func (cfg metricsSettings) record(agentID string, data *MetricsData) {
+	if cfg.history != nil {
+		cfg.history.Append(agentID, data)
+	}
+	// Stored rows cannot be flagged, so zeroed static fields would read as
+	// real values
+	if cfg.persistence != nil && !data.Incomplete && cfg.history != HistoryStore(cfg.persistence) {
+		cfg.persistence.SaveMetricsAsync(agentID, data)
 	}
 }
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+)
+
+// Samples read per query when paging through history for export
+const exportBatchSize = 1000
+
+// EachHistoryBatch pages through an agent's persisted samples between start
+// and end oldest first, calling fn with each batch, so a long range is never
+// held in memory at once. It stops at the first error fn returns.
+func (mp *MetricsPersistence) EachHistoryBatch(agentID string, start, end time.Time, fn func([]database.MetricsHistory) error) error {
+	for !start.After(end) {
+		batch, err := mp.QueryHistory(agentID, start, end, exportBatchSize)
+		if err != nil {
+			return err
+		}
+		// The limit applies per monthly table; past it the samples of a
+		// later table would skip the rest of an earlier one
+		if len(batch) > exportBatchSize {
+			batch = batch[:exportBatchSize]
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+		if len(batch) < exportBatchSize {
+			return nil
+		}
+		start = batch[len(batch)-1].Timestamp.Add(time.Nanosecond)
+	}
+	return nil
+}
+
+// HistoryRecords reduces snapshots to the values persisted history keeps,
+// so in-memory history exports the same fields
+func HistoryRecords(agentID string, snapshots []*MetricsData) []database.MetricsHistory {
+	records := make([]database.MetricsHistory, 0, len(snapshots))
+	for _, data := range snapshots {
+		records = append(records, historyRecord(agentID, data))
+	}
+	return records
+}
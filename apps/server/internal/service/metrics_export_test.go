@@ -0,0 +1,38 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+)
+
+func TestEachHistoryBatchPagesThroughRange(t *testing.T) {
+	backend := &memoryBackend{}
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	const samples = 2*exportBatchSize + 500
+	for i := 0; i < samples; i++ {
+		backend.Save(database.MetricsHistory{AgentID: "agent-1", Timestamp: start.Add(time.Duration(i) * time.Second), CPUPercent: float64(i)})
+	}
+	mp := NewMetricsPersistenceWithBackend(backend, config.MetricsConfig{PersistToDB: true}, zap.NewNop().Sugar())
+
+	var batches, seen int
+	err := mp.EachHistoryBatch("agent-1", start, start.Add(time.Hour), func(batch []database.MetricsHistory) error {
+		batches++
+		for _, m := range batch {
+			if m.CPUPercent != float64(seen) {
+				t.Fatalf("Expected sample %d next, got %g", seen, m.CPUPercent)
+			}
+			seen++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batches != 3 || seen != samples {
+		t.Errorf("Expected %d samples in 3 batches, got %d in %d", samples, seen, batches)
+	}
+}
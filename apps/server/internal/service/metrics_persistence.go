@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
@@ -11,32 +12,73 @@ import (
 	"gorm.io/gorm"
 )
 
-// MetricsPersistence handles metrics data persistence to database
+// MetricsPersistence handles metrics data persistence. Samples are stored
+// by a PersistenceBackend; the default keeps them in the main database.
 type MetricsPersistence struct {
-	db                *gorm.DB
+	backend           PersistenceBackend
 	cfg               config.MetricsConfig
 	logger            *zap.SugaredLogger
 	mu                sync.Mutex
 	aggregationTicker *time.Ticker
 	cleanupTicker     *time.Ticker
 	stopChan          chan struct{}
+	stopOnce          sync.Once
+	clock             Clock
+	events            *ServerEventBus
+
+	// Background writes still running, and whether new ones are refused
+	pending sync.WaitGroup
+	closed  bool
+
+	// Latest unwritten sample per agent when persisting every PersistIntervalSec
+	held map[string]database.MetricsHistory
+
+	// Per-agent persistence intervals and retention, when set
+	retention *RetentionPolicyService
+}
+
+// NewMetricsPersistence creates a metrics persistence service backed by
+// monthly tables in db
+func NewMetricsPersistence(db *gorm.DB, cfg config.MetricsConfig, logger *zap.SugaredLogger) (*MetricsPersistence, error) {
+	backend, err := NewGormPersistenceBackend(db, cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	return NewMetricsPersistenceWithBackend(backend, cfg, logger), nil
 }
 
-// NewMetricsPersistence creates a new metrics persistence service
-func NewMetricsPersistence(db *gorm.DB, cfg config.MetricsConfig, logger *zap.SugaredLogger) *MetricsPersistence {
-	mp := &MetricsPersistence{
-		db:       db,
+// NewMetricsPersistenceWithBackend creates a metrics persistence service
+// that stores samples in backend
+func NewMetricsPersistenceWithBackend(backend PersistenceBackend, cfg config.MetricsConfig, logger *zap.SugaredLogger) *MetricsPersistence {
+	return &MetricsPersistence{
+		backend:  backend,
 		cfg:      cfg,
 		logger:   logger,
 		stopChan: make(chan struct{}),
+		clock:    RealClock,
+		held:     make(map[string]database.MetricsHistory),
 	}
+}
 
-	// Initialize tables
-	if err := database.InitMetricsTables(db); err != nil {
-		logger.Errorf("Failed to initialize metrics tables: %v", err)
-	}
+// SetClock sets the time source used for aggregation windows and forecasts
+func (mp *MetricsPersistence) SetClock(clock Clock) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.clock = clock
+}
+
+// SetServerEvents reports failing and recovered writes to the backend as
+// degraded mode on bus
+func (mp *MetricsPersistence) SetServerEvents(bus *ServerEventBus) {
+	mp.events = bus
+}
 
-	return mp
+// SetRetentionPolicies resolves each agent's persistence interval and
+// retention from policies instead of the metrics config
+func (mp *MetricsPersistence) SetRetentionPolicies(policies *RetentionPolicyService) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.retention = policies
 }
 
 // Start starts background tasks for aggregation and cleanup
@@ -64,123 +106,139 @@ func (mp *MetricsPersistence) Start() {
 
 // Stop stops background tasks
 func (mp *MetricsPersistence) Stop() {
-	close(mp.stopChan)
-	if mp.aggregationTicker != nil {
-		mp.aggregationTicker.Stop()
+	mp.stopOnce.Do(func() {
+		close(mp.stopChan)
+		if mp.aggregationTicker != nil {
+			mp.aggregationTicker.Stop()
+		}
+		if mp.cleanupTicker != nil {
+			mp.cleanupTicker.Stop()
+		}
+		mp.logger.Info("Metrics persistence stopped")
+	})
+}
+
+// Shutdown stops background tasks, refuses further writes and waits for
+// the ones in flight to finish or ctx to be done
+func (mp *MetricsPersistence) Shutdown(ctx context.Context) error {
+	mp.Stop()
+	mp.mu.Lock()
+	mp.closed = true
+	// Samples held back by the persistence interval are still written
+	for agentID, record := range mp.held {
+		mp.pending.Add(1)
+		go mp.saveRecordAsync(record)
+		delete(mp.held, agentID)
 	}
-	if mp.cleanupTicker != nil {
-		mp.cleanupTicker.Stop()
+	mp.mu.Unlock()
+
+	flushed := make(chan struct{})
+	go func() {
+		mp.pending.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("pending metrics writes not flushed: %w", ctx.Err())
 	}
-	mp.logger.Info("Metrics persistence stopped")
 }
 
-// SaveMetrics saves a metrics snapshot to the database
-func (mp *MetricsPersistence) SaveMetrics(agentID string, data *MetricsData) error {
+// SaveMetricsAsync saves a snapshot in the background. Shutdown waits for
+// such writes; once it has started, new snapshots are dropped. With a
+// persistence interval only the latest snapshot of each interval is written,
+// once the next interval's first snapshot arrives.
+func (mp *MetricsPersistence) SaveMetricsAsync(agentID string, data *MetricsData) {
 	if !mp.cfg.PersistToDB {
-		return nil
+		return
 	}
+	record := mp.historyRecord(agentID, data)
+	interval := mp.persistInterval(agentID)
 
 	mp.mu.Lock()
-	defer mp.mu.Unlock()
-
-	// Ensure current month's table exists
-	tableName := database.GetCurrentMetricsTableName()
-	if err := database.EnsureMetricsTable(mp.db, tableName); err != nil {
-		return fmt.Errorf("failed to ensure metrics table: %w", err)
+	if mp.closed {
+		mp.mu.Unlock()
+		return
 	}
-
-	// Calculate aggregated values
-	var diskReadPS, diskWritePS, netRxPS, netTxPS uint64
-	var gpuPercent float64
-
-	for _, d := range data.Disks {
-		diskReadPS += d.ReadBytesPS
-		diskWritePS += d.WriteBytesPS
+	if interval > 0 {
+		previous, ok := mp.held[agentID]
+		mp.held[agentID] = record
+		if !ok || previous.Timestamp.Truncate(interval).Equal(record.Timestamp.Truncate(interval)) {
+			mp.mu.Unlock()
+			return
+		}
+		record = previous
 	}
+	mp.pending.Add(1)
+	mp.mu.Unlock()
 
-	for _, n := range data.Networks {
-		netRxPS += n.RxBytesPS
-		netTxPS += n.TxBytesPS
-	}
+	go mp.saveRecordAsync(record)
+}
 
-	if len(data.GPUs) > 0 {
-		var total float64
-		for _, g := range data.GPUs {
-			total += g.UsagePercent
-		}
-		gpuPercent = total / float64(len(data.GPUs))
+// Release writes the sample still held back for an agent that went offline
+// and stops holding one for it
+func (mp *MetricsPersistence) Release(agentID string) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	record, ok := mp.held[agentID]
+	if !ok || mp.closed {
+		return
 	}
+	delete(mp.held, agentID)
+	mp.pending.Add(1)
+	go mp.saveRecordAsync(record)
+}
 
-	memPercent := 0.0
-	if data.Memory.Total > 0 {
-		memPercent = float64(data.Memory.Used) / float64(data.Memory.Total) * 100
+// persistInterval returns how often an agent's samples are persisted, 0 for
+// every sample
+func (mp *MetricsPersistence) persistInterval(agentID string) time.Duration {
+	mp.mu.Lock()
+	retention := mp.retention
+	mp.mu.Unlock()
+	if retention != nil {
+		return time.Duration(retention.Resolve(agentID).PersistIntervalSec) * time.Second
 	}
+	return time.Duration(mp.cfg.PersistIntervalSec) * time.Second
+}
 
-	loadAvg1 := 0.0
-	if len(data.LoadAverage) > 0 {
-		loadAvg1 = data.LoadAverage[0]
+// saveRecordAsync writes a record counted in pending
+func (mp *MetricsPersistence) saveRecordAsync(record database.MetricsHistory) {
+	defer mp.pending.Done()
+	if err := mp.saveRecord(record); err != nil {
+		mp.logger.Warnf("Failed to persist metrics for %s: %v", record.AgentID, err)
 	}
+}
 
-	record := database.MetricsHistory{
-		AgentID:     agentID,
-		Timestamp:   data.Timestamp,
-		CPUPercent:  data.CPU.UsagePercent,
-		MemPercent:  memPercent,
-		DiskReadPS:  diskReadPS,
-		DiskWritePS: diskWritePS,
-		NetRxPS:     netRxPS,
-		NetTxPS:     netTxPS,
-		GPUPercent:  gpuPercent,
-		LoadAvg1:    loadAvg1,
+// SaveMetrics saves a metrics snapshot to the database
+func (mp *MetricsPersistence) SaveMetrics(agentID string, data *MetricsData) error {
+	if !mp.cfg.PersistToDB {
+		return nil
 	}
-
-	return mp.db.Table(tableName).Create(&record).Error
+	return mp.saveRecord(mp.historyRecord(agentID, data))
 }
 
-// QueryHistory queries historical metrics for an agent within a time range
-func (mp *MetricsPersistence) QueryHistory(agentID string, start, end time.Time, limit int) ([]database.MetricsHistory, error) {
-	var results []database.MetricsHistory
-
-	// Determine which monthly tables to query
-	tables := mp.getTablesForRange(start, end)
-
-	for _, table := range tables {
-		if !mp.db.Migrator().HasTable(table) {
-			continue
-		}
-
-		var partial []database.MetricsHistory
-		query := mp.db.Table(table).
-			Where("agent_id = ? AND timestamp >= ? AND timestamp <= ?", agentID, start, end).
-			Order("timestamp ASC")
-
-		if limit > 0 {
-			query = query.Limit(limit)
+// saveRecord writes one sample to the backend, tracking degraded mode
+func (mp *MetricsPersistence) saveRecord(record database.MetricsHistory) error {
+	err := mp.backend.Save(record)
+	if mp.events != nil {
+		if err != nil {
+			mp.events.SetDegraded(DegradedMetricsPersistence, err.Error())
+		} else {
+			mp.events.ClearDegraded(DegradedMetricsPersistence)
 		}
-
-		if err := query.Find(&partial).Error; err != nil {
-			mp.logger.Warnf("Error querying table %s: %v", table, err)
-			continue
-		}
-
-		results = append(results, partial...)
 	}
+	return err
+}
 
-	return results, nil
+// QueryHistory queries historical metrics for an agent within a time range
+func (mp *MetricsPersistence) QueryHistory(agentID string, start, end time.Time, limit int) ([]database.MetricsHistory, error) {
+	return mp.backend.QueryRange(agentID, start, end, limit)
 }
 
 // QueryAggregated queries aggregated metrics with specified interval
 // interval: "1m", "5m", "1h", "1d"
 func (mp *MetricsPersistence) QueryAggregated(agentID string, start, end time.Time, interval string) ([]database.MetricsHistory, error) {
-	raw, err := mp.QueryHistory(agentID, start, end, 0)
-	if err != nil {
-		return nil, err
-	}
-
-	if len(raw) == 0 {
-		return raw, nil
-	}
-
 	// Determine bucket duration
 	var bucketDuration time.Duration
 	switch interval {
@@ -209,209 +267,208 @@ func (mp *MetricsPersistence) QueryAggregated(agentID string, start, end time.Ti
 		}
 	}
 
-	return mp.aggregateData(raw, bucketDuration), nil
+	return mp.backend.QueryAggregated(agentID, start, end, bucketDuration)
 }
 
-// aggregateData aggregates raw metrics into buckets
-func (mp *MetricsPersistence) aggregateData(raw []database.MetricsHistory, bucketDuration time.Duration) []database.MetricsHistory {
-	if len(raw) == 0 {
-		return raw
-	}
-
-	buckets := make(map[int64]*aggregationBucket)
-
-	for _, m := range raw {
-		bucketKey := m.Timestamp.Truncate(bucketDuration).Unix()
-		bucket, exists := buckets[bucketKey]
-		if !exists {
-			bucket = &aggregationBucket{
-				timestamp: time.Unix(bucketKey, 0),
-			}
-			buckets[bucketKey] = bucket
-		}
-		bucket.add(m)
+// runHourlyAggregation aggregates the last hour's data on backends that
+// keep rollups
+func (mp *MetricsPersistence) runHourlyAggregation() {
+	rollup, ok := mp.backend.(RollupBackend)
+	if !ok {
+		return
 	}
 
-	// Convert buckets to results
-	results := make([]database.MetricsHistory, 0, len(buckets))
-	for _, bucket := range buckets {
-		results = append(results, bucket.toMetrics())
-	}
+	mp.mu.Lock()
+	now := mp.clock.Now()
+	mp.mu.Unlock()
+	hour := now.Truncate(time.Hour).Add(-time.Hour) // Previous hour
 
-	// Sort by timestamp
-	for i := 0; i < len(results)-1; i++ {
-		for j := i + 1; j < len(results); j++ {
-			if results[i].Timestamp.After(results[j].Timestamp) {
-				results[i], results[j] = results[j], results[i]
-			}
-		}
+	agents, err := rollup.RollupHour(hour)
+	if err != nil {
+		mp.logger.Errorf("Hourly aggregation failed: %v", err)
+		return
 	}
-
-	return results
+	mp.logger.Infof("Hourly aggregation completed for %d agents", agents)
 }
 
-type aggregationBucket struct {
-	timestamp    time.Time
-	cpuSum       float64
-	memSum       float64
-	diskReadSum  uint64
-	diskWriteSum uint64
-	netRxSum     uint64
-	netTxSum     uint64
-	gpuSum       float64
-	loadSum      float64
-	count        int
+// runCleanup removes old data, then compacts what remains
+func (mp *MetricsPersistence) runCleanup() {
+	if err := mp.cleanup(); err != nil {
+		mp.logger.Errorf("Metrics cleanup failed: %v", err)
+		return
+	}
+	mp.logger.Info("Metrics cleanup completed")
+	mp.runCompaction()
 }
 
-func (b *aggregationBucket) add(m database.MetricsHistory) {
-	b.cpuSum += m.CPUPercent
-	b.memSum += m.MemPercent
-	b.diskReadSum += m.DiskReadPS
-	b.diskWriteSum += m.DiskWritePS
-	b.netRxSum += m.NetRxPS
-	b.netTxSum += m.NetTxPS
-	b.gpuSum += m.GPUPercent
-	b.loadSum += m.LoadAvg1
-	b.count++
-}
+// cleanup removes data past its retention. With retention policies, raw
+// samples are kept as long as any agent's policy needs, and each agent's
+// older samples are then removed separately.
+func (mp *MetricsPersistence) cleanup() error {
+	mp.mu.Lock()
+	retention, now := mp.retention, mp.clock.Now()
+	mp.mu.Unlock()
+	backend, ok := mp.backend.(AgentRetentionBackend)
+	if retention == nil || !ok {
+		return mp.backend.Cleanup()
+	}
 
-func (b *aggregationBucket) toMetrics() database.MetricsHistory {
-	if b.count == 0 {
-		return database.MetricsHistory{Timestamp: b.timestamp}
+	longest := retention.LongestRetentionDays()
+	if err := backend.CleanupKeeping(longest); err != nil {
+		return err
 	}
-	return database.MetricsHistory{
-		Timestamp:   b.timestamp,
-		CPUPercent:  b.cpuSum / float64(b.count),
-		MemPercent:  b.memSum / float64(b.count),
-		DiskReadPS:  b.diskReadSum / uint64(b.count),
-		DiskWritePS: b.diskWriteSum / uint64(b.count),
-		NetRxPS:     b.netRxSum / uint64(b.count),
-		NetTxPS:     b.netTxSum / uint64(b.count),
-		GPUPercent:  b.gpuSum / float64(b.count),
-		LoadAvg1:    b.loadSum / float64(b.count),
+	// Whole months before the longest retention's cutoff are gone
+	cutoff := now.AddDate(0, 0, -longest)
+	since := time.Date(cutoff.Year(), cutoff.Month(), 1, 0, 0, 0, 0, time.Local)
+	agents, err := mp.backend.Agents(since, now)
+	if err != nil {
+		return fmt.Errorf("failed to list agents with samples: %w", err)
 	}
+	for _, agentID := range agents {
+		days := retention.Resolve(agentID).RetentionDays
+		if days >= longest {
+			continue
+		}
+		removed, err := backend.DeleteBefore(agentID, now.AddDate(0, 0, -days))
+		if err != nil {
+			return fmt.Errorf("failed to remove old samples of %s: %w", agentID, err)
+		}
+		if removed > 0 {
+			mp.logger.Infof("Removed %d samples of agent %s older than %d days", removed, agentID, days)
+		}
+	}
+	return nil
 }
 
-// getTablesForRange returns the table names that cover the given time range
-func (mp *MetricsPersistence) getTablesForRange(start, end time.Time) []string {
-	var tables []string
-	current := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.Local)
-	endMonth := time.Date(end.Year(), end.Month(), 1, 0, 0, 0, 0, time.Local)
-
-	for !current.After(endMonth) {
-		tables = append(tables, database.GetMetricsTableName(current))
-		current = current.AddDate(0, 1, 0)
+// runCompaction collapses raw samples older than CompactAfterHours into
+// one average per CompactBucketSec on backends that support it
+func (mp *MetricsPersistence) runCompaction() {
+	age := time.Duration(mp.cfg.CompactAfterHours) * time.Hour
+	compactor, ok := mp.backend.(CompactingBackend)
+	if age <= 0 || !ok {
+		return
+	}
+	bucket := time.Duration(mp.cfg.CompactBucketSec) * time.Second
+	if bucket <= 0 {
+		bucket = time.Minute
 	}
 
-	return tables
-}
-
-// runHourlyAggregation aggregates the last hour's data
-func (mp *MetricsPersistence) runHourlyAggregation() {
 	mp.mu.Lock()
-	defer mp.mu.Unlock()
+	now := mp.clock.Now()
+	mp.mu.Unlock()
+	// Only whole buckets are compacted
+	before := now.Add(-age).Truncate(bucket)
 
-	now := time.Now()
-	hour := now.Truncate(time.Hour).Add(-time.Hour) // Previous hour
-	endHour := hour.Add(time.Hour)
-
-	// Get all agents with data in the last hour
-	agentIDs := mp.getAgentsWithData(hour, endHour)
-
-	for _, agentID := range agentIDs {
-		raw, err := mp.QueryHistory(agentID, hour, endHour, 0)
-		if err != nil || len(raw) == 0 {
-			continue
-		}
-
-		// Calculate aggregates
-		var cpuSum, memSum, gpuSum float64
-		var cpuMax, memMax float64
-		var netRxTotal, netTxTotal uint64
+	removed, err := compactor.Compact(before, bucket)
+	if err != nil {
+		mp.logger.Errorf("Metrics compaction failed: %v", err)
+		return
+	}
+	mp.logger.Infof("Metrics compaction replaced %d samples older than %s", removed, before.Format(time.RFC3339))
+}
 
-		for _, m := range raw {
-			cpuSum += m.CPUPercent
-			memSum += m.MemPercent
-			gpuSum += m.GPUPercent
-			netRxTotal += m.NetRxPS
-			netTxTotal += m.NetTxPS
+// historyRecord is the record persisted for a snapshot, with its per-device
+// breakdown if configured
+func (mp *MetricsPersistence) historyRecord(agentID string, data *MetricsData) database.MetricsHistory {
+	record := historyRecord(agentID, data)
+	if mp.cfg.PerDeviceBreakdown {
+		record.Devices = deviceMetrics(data)
+	}
+	return record
+}
 
-			if m.CPUPercent > cpuMax {
-				cpuMax = m.CPUPercent
-			}
-			if m.MemPercent > memMax {
-				memMax = m.MemPercent
-			}
+// historyRecord reduces a snapshot to the aggregate values kept in history
+func historyRecord(agentID string, data *MetricsData) database.MetricsHistory {
+	// Calculate aggregated values, counting each device once
+	var diskReadPS, diskWritePS, netRxPS, netTxPS uint64
+	var gpuPercent, diskPercent float64
+
+	devices := deviceMetrics(data)
+	for _, d := range devices.Disks {
+		diskReadPS += d.ReadPS
+		diskWritePS += d.WritePS
+		if d.UsagePercent > diskPercent {
+			diskPercent = d.UsagePercent
 		}
+	}
 
-		count := len(raw)
-		hourly := database.MetricsHourly{
-			AgentID:    agentID,
-			Hour:       hour,
-			CPUAvg:     cpuSum / float64(count),
-			CPUMax:     cpuMax,
-			MemAvg:     memSum / float64(count),
-			MemMax:     memMax,
-			NetRxTotal: netRxTotal,
-			NetTxTotal: netTxTotal,
-			DataPoints: count,
-		}
+	for _, n := range devices.Networks {
+		netRxPS += n.RxPS
+		netTxPS += n.TxPS
+	}
 
-		if err := mp.db.Create(&hourly).Error; err != nil {
-			mp.logger.Warnf("Failed to save hourly aggregation for %s: %v", agentID, err)
+	if len(data.GPUs) > 0 {
+		var total float64
+		for _, g := range data.GPUs {
+			total += g.UsagePercent
 		}
+		gpuPercent = total / float64(len(data.GPUs))
 	}
 
-	mp.logger.Infof("Hourly aggregation completed for %d agents", len(agentIDs))
-}
-
-// runCleanup removes old data
-func (mp *MetricsPersistence) runCleanup() {
-	mp.mu.Lock()
-	defer mp.mu.Unlock()
-
-	// Cleanup old monthly tables
-	if err := database.CleanupOldMetricsTables(mp.db, mp.cfg.RetentionDays); err != nil {
-		mp.logger.Errorf("Failed to cleanup old metrics tables: %v", err)
+	memPercent := 0.0
+	if data.Memory.Total > 0 {
+		memPercent = float64(data.Memory.Used) / float64(data.Memory.Total) * 100
 	}
 
-	// Cleanup old aggregated data
-	if err := database.CleanupOldAggregatedData(mp.db, mp.cfg.HourlyRetentionDays, mp.cfg.DailyRetentionDays); err != nil {
-		mp.logger.Errorf("Failed to cleanup old aggregated data: %v", err)
+	loadAvg1 := 0.0
+	if len(data.LoadAverage) > 0 {
+		loadAvg1 = data.LoadAverage[0]
 	}
 
-	mp.logger.Info("Metrics cleanup completed")
+	return database.MetricsHistory{
+		AgentID:     agentID,
+		Timestamp:   data.Timestamp,
+		CPUPercent:  data.CPU.UsagePercent,
+		MemPercent:  memPercent,
+		DiskReadPS:  diskReadPS,
+		DiskWritePS: diskWritePS,
+		DiskPercent: &diskPercent,
+		NetRxPS:     netRxPS,
+		NetTxPS:     netTxPS,
+		GPUPercent:  gpuPercent,
+		LoadAvg1:    loadAvg1,
+	}
 }
 
-// getAgentsWithData returns agent IDs that have data in the given time range
-func (mp *MetricsPersistence) getAgentsWithData(start, end time.Time) []string {
-	var agentIDs []string
-	tables := mp.getTablesForRange(start, end)
+// deviceMetrics groups a snapshot's disks by device and networks by
+// interface. The same device mounted more than once reports the same
+// throughput for each mount, so it is taken once rather than summed. Disks
+// without a device name are told apart by mount point.
+func deviceMetrics(data *MetricsData) *database.DeviceMetrics {
+	devices := &database.DeviceMetrics{}
 
-	for _, table := range tables {
-		if !mp.db.Migrator().HasTable(table) {
-			continue
+	disks := make(map[string]int, len(data.Disks))
+	for _, d := range data.Disks {
+		name := d.Device
+		if name == "" {
+			name = d.MountPoint
 		}
-
-		var ids []string
-		mp.db.Table(table).
-			Where("timestamp >= ? AND timestamp <= ?", start, end).
-			Distinct("agent_id").
-			Pluck("agent_id", &ids)
-
-		for _, id := range ids {
-			found := false
-			for _, existing := range agentIDs {
-				if existing == id {
-					found = true
-					break
-				}
-			}
-			if !found {
-				agentIDs = append(agentIDs, id)
-			}
+		i, ok := disks[name]
+		if !ok {
+			i = len(devices.Disks)
+			disks[name] = i
+			devices.Disks = append(devices.Disks, database.DiskIO{Device: name})
+		}
+		disk := &devices.Disks[i]
+		if d.MountPoint != "" {
+			disk.MountPoints = append(disk.MountPoints, d.MountPoint)
 		}
+		disk.ReadPS = max(disk.ReadPS, d.ReadBytesPS)
+		disk.WritePS = max(disk.WritePS, d.WriteBytesPS)
+		disk.UsagePercent = max(disk.UsagePercent, d.UsagePercent)
 	}
 
-	return agentIDs
+	nics := make(map[string]int, len(data.Networks))
+	for _, n := range data.Networks {
+		i, ok := nics[n.Interface]
+		if !ok {
+			i = len(devices.Networks)
+			nics[n.Interface] = i
+			devices.Networks = append(devices.Networks, database.NetIO{Interface: n.Interface})
+		}
+		nic := &devices.Networks[i]
+		nic.RxPS = max(nic.RxPS, n.RxBytesPS)
+		nic.TxPS = max(nic.TxPS, n.TxBytesPS)
+	}
+	return devices
 }
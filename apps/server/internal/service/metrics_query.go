@@ -0,0 +1,409 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidExpression is returned for metrics expressions that do not
+// parse or use anything beyond field access and basic math
+var ErrInvalidExpression = errors.New("invalid metrics expression")
+
+// MaxExpressionLength bounds the size of a metrics expression
+const MaxExpressionLength = 512
+
+// MetricsPoint is one evaluated sample of a metrics expression
+type MetricsPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// MetricsExpression is a compiled expression over MetricsData fields.
+//
+// The language is deliberately small: numbers, field paths using the JSON
+// field names (memory.used, cpu.usagePercent), + - * /, parentheses, and the
+// aggregations sum, avg, min, max and count over list fields such as
+// networks.rxBytesPerSec. Expressions are parsed into a syntax tree and
+// interpreted; nothing is ever executed.
+type MetricsExpression struct {
+	source string
+	root   exprNode
+}
+
+// exprNode evaluates part of an expression against one sample. ok is false
+// when the sample has no value, e.g. after a division by zero.
+type exprNode interface {
+	eval(data reflect.Value) (value float64, ok bool)
+}
+
+// CompileMetricsExpression parses and validates an expression
+func CompileMetricsExpression(source string) (*MetricsExpression, error) {
+	source = strings.TrimSpace(source)
+	if source == "" {
+		return nil, fmt.Errorf("%w: expression is empty", ErrInvalidExpression)
+	}
+	if len(source) > MaxExpressionLength {
+		return nil, fmt.Errorf("%w: expression longer than %d characters", ErrInvalidExpression, MaxExpressionLength)
+	}
+
+	parsed, err := parser.ParseExpr(source)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidExpression, err)
+	}
+	root, err := compileNode(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidExpression, err)
+	}
+	return &MetricsExpression{source: source, root: root}, nil
+}
+
+// String returns the expression source
+func (e *MetricsExpression) String() string {
+	return e.source
+}
+
+// Evaluate computes the expression for one sample
+func (e *MetricsExpression) Evaluate(data *MetricsData) (float64, bool) {
+	if data == nil {
+		return 0, false
+	}
+	return e.root.eval(reflect.ValueOf(data).Elem())
+}
+
+// QueryExpression evaluates an expression over an agent's history between
+// start and end (zero values leave that side open). The range is passed on
+// to the history store, so only the samples asked for are loaded. Samples
+// where the expression has no value are skipped.
+func (s *MetricsService) QueryExpression(agentID string, expr *MetricsExpression, start, end time.Time) []MetricsPoint {
+	history := s.GetMetricsHistoryRange(agentID, start, end)
+
+	points := make([]MetricsPoint, 0, len(history))
+	for _, sample := range history {
+		if value, ok := expr.Evaluate(sample); ok {
+			points = append(points, MetricsPoint{Timestamp: sample.Timestamp, Value: value})
+		}
+	}
+	return points
+}
+
+func compileNode(node ast.Expr) (exprNode, error) {
+	switch n := node.(type) {
+	case *ast.ParenExpr:
+		return compileNode(n.X)
+
+	case *ast.BasicLit:
+		if n.Kind != token.INT && n.Kind != token.FLOAT {
+			return nil, fmt.Errorf("unsupported literal %s", n.Value)
+		}
+		v, err := strconv.ParseFloat(n.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", n.Value)
+		}
+		return numberNode(v), nil
+
+	case *ast.UnaryExpr:
+		if n.Op != token.SUB && n.Op != token.ADD {
+			return nil, fmt.Errorf("unsupported operator %s", n.Op)
+		}
+		operand, err := compileNode(n.X)
+		if err != nil {
+			return nil, err
+		}
+		if n.Op == token.ADD {
+			return operand, nil
+		}
+		return negNode{operand}, nil
+
+	case *ast.BinaryExpr:
+		switch n.Op {
+		case token.ADD, token.SUB, token.MUL, token.QUO:
+		default:
+			return nil, fmt.Errorf("unsupported operator %s", n.Op)
+		}
+		left, err := compileNode(n.X)
+		if err != nil {
+			return nil, err
+		}
+		right, err := compileNode(n.Y)
+		if err != nil {
+			return nil, err
+		}
+		return binaryNode{op: n.Op, left: left, right: right}, nil
+
+	case *ast.Ident, *ast.SelectorExpr:
+		field, err := compileField(n)
+		if err != nil {
+			return nil, err
+		}
+		if field.list {
+			return nil, fmt.Errorf("%s is a list; aggregate it with sum, avg, min, max or count", field.path)
+		}
+		return scalarNode{field}, nil
+
+	case *ast.CallExpr:
+		fn, ok := n.Fun.(*ast.Ident)
+		if !ok || !isAggregation(fn.Name) {
+			return nil, fmt.Errorf("unsupported function; use sum, avg, min, max or count")
+		}
+		if len(n.Args) != 1 || n.Ellipsis.IsValid() {
+			return nil, fmt.Errorf("%s takes exactly one field", fn.Name)
+		}
+		field, err := compileField(n.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		return aggregateNode{fn: fn.Name, field: field}, nil
+	}
+	return nil, fmt.Errorf("unsupported syntax")
+}
+
+func isAggregation(name string) bool {
+	switch name {
+	case "sum", "avg", "min", "max", "count":
+		return true
+	}
+	return false
+}
+
+// fieldRef is a resolved path into MetricsData
+type fieldRef struct {
+	path    string
+	indices []int
+	// list is true when the path crosses a slice and yields many values
+	list bool
+}
+
+// compileField resolves a dotted path of JSON field names against MetricsData
+func compileField(node ast.Expr) (*fieldRef, error) {
+	names, err := selectorPath(node)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := &fieldRef{path: strings.Join(names, ".")}
+	t := reflect.TypeOf(MetricsData{})
+	for i, name := range names {
+		t = derefType(t)
+		if t.Kind() == reflect.Slice {
+			ref.list = true
+			t = derefType(t.Elem())
+		}
+		if t.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("%s has no field %s", strings.Join(names[:i], "."), name)
+		}
+		index, field, ok := jsonField(t, name)
+		if !ok {
+			return nil, fmt.Errorf("unknown field %s", strings.Join(names[:i+1], "."))
+		}
+		ref.indices = append(ref.indices, index)
+		t = field.Type
+	}
+
+	t = derefType(t)
+	if t.Kind() == reflect.Slice {
+		ref.list = true
+		t = derefType(t.Elem())
+	}
+	if !isNumericKind(t.Kind()) {
+		return nil, fmt.Errorf("%s is not numeric", ref.path)
+	}
+	return ref, nil
+}
+
+// selectorPath flattens a.b.c into its identifiers
+func selectorPath(node ast.Expr) ([]string, error) {
+	switch n := node.(type) {
+	case *ast.Ident:
+		return []string{n.Name}, nil
+	case *ast.SelectorExpr:
+		head, err := selectorPath(n.X)
+		if err != nil {
+			return nil, err
+		}
+		return append(head, n.Sel.Name), nil
+	}
+	return nil, fmt.Errorf("expected a field path")
+}
+
+// jsonField finds a struct field by its JSON name
+func jsonField(t reflect.Type, name string) (int, reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == name {
+			return i, f, true
+		}
+	}
+	return 0, reflect.StructField{}, false
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func isNumericKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Bool:
+		return true
+	}
+	return false
+}
+
+// values collects the numbers a field path yields for one sample
+func (f *fieldRef) values(v reflect.Value) []float64 {
+	var out []float64
+	var walk func(v reflect.Value, indices []int)
+	walk = func(v reflect.Value, indices []int) {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return
+			}
+			v = v.Elem()
+		}
+		if v.Kind() == reflect.Slice {
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i), indices)
+			}
+			return
+		}
+		if len(indices) > 0 {
+			walk(v.Field(indices[0]), indices[1:])
+			return
+		}
+		out = append(out, numericValue(v))
+	}
+	walk(v, f.indices)
+	return out
+}
+
+func numericValue(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Bool:
+		if v.Bool() {
+			return 1
+		}
+	}
+	return 0
+}
+
+type numberNode float64
+
+func (n numberNode) eval(reflect.Value) (float64, bool) {
+	return float64(n), true
+}
+
+type negNode struct {
+	operand exprNode
+}
+
+func (n negNode) eval(data reflect.Value) (float64, bool) {
+	v, ok := n.operand.eval(data)
+	return -v, ok
+}
+
+type binaryNode struct {
+	op          token.Token
+	left, right exprNode
+}
+
+func (n binaryNode) eval(data reflect.Value) (float64, bool) {
+	l, ok := n.left.eval(data)
+	if !ok {
+		return 0, false
+	}
+	r, ok := n.right.eval(data)
+	if !ok {
+		return 0, false
+	}
+
+	var v float64
+	switch n.op {
+	case token.ADD:
+		v = l + r
+	case token.SUB:
+		v = l - r
+	case token.MUL:
+		v = l * r
+	case token.QUO:
+		if r == 0 {
+			return 0, false
+		}
+		v = l / r
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false
+	}
+	return v, true
+}
+
+type scalarNode struct {
+	field *fieldRef
+}
+
+func (n scalarNode) eval(data reflect.Value) (float64, bool) {
+	values := n.field.values(data)
+	if len(values) != 1 {
+		return 0, false
+	}
+	return values[0], true
+}
+
+type aggregateNode struct {
+	fn    string
+	field *fieldRef
+}
+
+func (n aggregateNode) eval(data reflect.Value) (float64, bool) {
+	values := n.field.values(data)
+	switch n.fn {
+	case "count":
+		return float64(len(values)), true
+	case "sum":
+		var total float64
+		for _, v := range values {
+			total += v
+		}
+		return total, true
+	}
+
+	if len(values) == 0 {
+		return 0, false
+	}
+	result := values[0]
+	for _, v := range values[1:] {
+		switch n.fn {
+		case "avg":
+			result += v
+		case "min":
+			result = math.Min(result, v)
+		case "max":
+			result = math.Max(result, v)
+		}
+	}
+	if n.fn == "avg" {
+		result /= float64(len(values))
+	}
+	return result, true
+}
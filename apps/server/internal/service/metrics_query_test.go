@@ -0,0 +1,127 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestMetricsExpressionMemoryPercent(t *testing.T) {
+	expr, err := CompileMetricsExpression("memory.used/memory.total*100")
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	value, ok := expr.Evaluate(&MetricsData{Memory: MemData{Total: 16 << 30, Used: 4 << 30}})
+	if !ok {
+		t.Fatal("Expected a value")
+	}
+	if value != 25 {
+		t.Errorf("Expected 25, got %v", value)
+	}
+
+	// A zero total has no value rather than Inf/NaN
+	if _, ok := expr.Evaluate(&MetricsData{}); ok {
+		t.Error("Expected no value when memory.total is zero")
+	}
+}
+
+func TestMetricsExpressionNetworkSum(t *testing.T) {
+	expr, err := CompileMetricsExpression("sum(networks.rxBytesPerSec)")
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	data := &MetricsData{Networks: []NetData{
+		{Interface: "eth0", RxBytesPS: 1000},
+		{Interface: "eth1", RxBytesPS: 2500},
+		{Interface: "lo", RxBytesPS: 500},
+	}}
+	value, ok := expr.Evaluate(data)
+	if !ok || value != 4000 {
+		t.Errorf("Expected 4000, got %v (ok=%v)", value, ok)
+	}
+}
+
+func TestMetricsExpressionAggregations(t *testing.T) {
+	data := &MetricsData{CPU: CPUData{PerCoreUsage: []float64{10, 20, 60}}}
+	tests := map[string]float64{
+		"avg(cpu.perCoreUsage)":       30,
+		"min(cpu.perCoreUsage)":       10,
+		"max(cpu.perCoreUsage)":       60,
+		"count(cpu.perCoreUsage)":     3,
+		"-(1 + 2) * 2":                -6,
+		"max(cpu.perCoreUsage) / 100": 0.6,
+	}
+	for source, want := range tests {
+		expr, err := CompileMetricsExpression(source)
+		if err != nil {
+			t.Errorf("Compile %q failed: %v", source, err)
+			continue
+		}
+		got, ok := expr.Evaluate(data)
+		if !ok || math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s: expected %v, got %v (ok=%v)", source, want, got, ok)
+		}
+	}
+}
+
+func TestMetricsExpressionRejectsInvalid(t *testing.T) {
+	invalid := []string{
+		"",
+		"memory.used +",
+		"memory.bogus",
+		"networks.rxBytesPerSec * 2",
+		"cpu.model",
+		"os.Exit(1)",
+		"sum(networks.rxBytesPerSec, 1)",
+		"memory.used % 2",
+		`"text"`,
+		"disks[0].used",
+		"func() {}",
+	}
+	for _, source := range invalid {
+		if _, err := CompileMetricsExpression(source); !errors.Is(err, ErrInvalidExpression) {
+			t.Errorf("Expected %q to be rejected with ErrInvalidExpression, got %v", source, err)
+		}
+	}
+}
+
+func TestQueryExpressionOverHistory(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	ms := NewMetricsService(zap.NewNop().Sugar())
+	ms.SetClock(clock)
+
+	for _, used := range []uint64{25, 50, 75} {
+		ms.StoreMetrics("agent-1", &MetricsData{Memory: MemData{Total: 100, Used: used}})
+		clock.Advance(time.Minute)
+	}
+
+	expr, err := CompileMetricsExpression("memory.used/memory.total*100")
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	points := ms.QueryExpression("agent-1", expr, time.Time{}, time.Time{})
+	if len(points) != 3 || points[0].Value != 25 || points[2].Value != 75 {
+		t.Fatalf("Expected 3 points 25..75, got %+v", points)
+	}
+
+	start := time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC)
+	points = ms.QueryExpression("agent-1", expr, start, time.Time{})
+	if len(points) != 2 || points[0].Value != 50 {
+		t.Errorf("Expected points from 12:01 on, got %+v", points)
+	}
+
+	// The range is queried from the history store rather than filtered here
+	store := &sliceHistoryStore{samples: map[string][]*MetricsData{"agent-1": ms.GetMetricsHistory("agent-1", 0)}}
+	ms.SetHistoryStore(store)
+	end := start.Add(time.Minute)
+	points = ms.QueryExpression("agent-1", expr, start, end)
+	if len(points) != 2 || store.queried != [2]time.Time{start, end} {
+		t.Errorf("Expected the store to be asked for %v to %v, got %v and %+v", start, end, store.queried, points)
+	}
+}
@@ -0,0 +1,352 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestMetricsFreshness(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	ms := NewMetricsService(zap.NewNop().Sugar())
+	ms.SetClock(clock)
+	ms.SetStaleAfter(30 * time.Second)
+
+	ms.StoreMetrics("agent-1", &MetricsData{})
+	clock.Advance(5 * time.Second)
+
+	fresh := ms.GetCurrentMetrics("agent-1")
+	if fresh.Stale {
+		t.Error("Expected a 5s old sample not to be stale")
+	}
+	if fresh.AgeSeconds != 5 {
+		t.Errorf("Expected age 5s, got %v", fresh.AgeSeconds)
+	}
+
+	clock.Advance(40 * time.Second)
+
+	old := ms.GetAllCurrentMetrics()["agent-1"]
+	if !old.Stale {
+		t.Error("Expected a 45s old sample to be stale")
+	}
+	if old.AgeSeconds != 45 {
+		t.Errorf("Expected age 45s, got %v", old.AgeSeconds)
+	}
+
+	if stale := ms.GetSummary()["staleCount"]; stale != 1 {
+		t.Errorf("Expected summary staleCount 1, got %v", stale)
+	}
+}
+
+func TestMetricsStaleWhenAgentNotLive(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	ms := NewMetricsService(logger)
+	as := NewAgentService(logger, ms)
+	ms.SetLivenessCheck(func(agentID string) bool {
+		return as.GetAgent(agentID) != nil
+	})
+
+	as.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "host-1"}, 0)
+	ms.StoreMetrics("agent-1", &MetricsData{})
+	if ms.GetCurrentMetrics("agent-1").Stale {
+		t.Error("Expected metrics of a connected agent to be fresh")
+	}
+
+	as.UnregisterAgent("agent-1")
+	if !ms.GetCurrentMetrics("agent-1").Stale {
+		t.Error("Expected metrics of a disconnected agent to be stale")
+	}
+}
+
+func TestLivenessCheckRunsWithoutShardLock(t *testing.T) {
+	ms := NewMetricsService(zap.NewNop().Sugar())
+	ms.StoreMetrics("agent-1", &MetricsData{})
+	// Stands in for AgentService, whose lock is held while metrics are
+	// stored, so it must never be taken under a shard lock
+	ms.SetLivenessCheck(func(agentID string) bool {
+		shard := ms.shard(agentID)
+		shard.mu.Lock()
+		defer shard.mu.Unlock()
+		return true
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ms.GetCurrentMetrics("agent-1")
+		ms.SnapshotMetrics("agent-1")
+		ms.GetAllCurrentMetrics()
+		ms.GetSummary()
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the liveness check to run without the shard lock held")
+	}
+}
+
+func TestComputeCoreStats(t *testing.T) {
+	tests := []struct {
+		name     string
+		perCore  []float64
+		expected *CoreStats
+	}{
+		{"empty", nil, nil},
+		{"single core", []float64{42}, &CoreStats{Min: 42, Max: 42, Avg: 42, Imbalance: 0}},
+		{"pinned core", []float64{2, 100, 4, 6}, &CoreStats{Min: 2, Max: 100, Avg: 28, Imbalance: 98}},
+		{"balanced", []float64{50, 50, 50, 50}, &CoreStats{Min: 50, Max: 50, Avg: 50, Imbalance: 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ComputeCoreStats(tt.perCore)
+			if tt.expected == nil {
+				if got != nil {
+					t.Errorf("Expected nil, got %+v", got)
+				}
+				return
+			}
+			if got == nil || *got != *tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestOverviewOmitsPerCore(t *testing.T) {
+	ms := NewMetricsService(zap.NewNop().Sugar())
+	ms.StoreMetrics("agent-1", &MetricsData{CPU: CPUData{PerCoreUsage: []float64{10, 90}}})
+
+	detail := ms.GetCurrentMetrics("agent-1")
+	if len(detail.CPU.PerCoreUsage) != 2 {
+		t.Fatalf("Expected detail view to keep per-core usage, got %v", detail.CPU.PerCoreUsage)
+	}
+	if detail.CPU.CoreStats == nil || detail.CPU.CoreStats.Imbalance != 80 {
+		t.Errorf("Expected imbalance 80, got %+v", detail.CPU.CoreStats)
+	}
+
+	overview := OverviewMetrics(detail)
+	if overview.CPU.PerCoreUsage != nil {
+		t.Errorf("Expected overview to omit per-core usage, got %v", overview.CPU.PerCoreUsage)
+	}
+	if overview.CPU.CoreStats == nil || overview.CPU.CoreStats.Max != 90 {
+		t.Errorf("Expected overview to keep core stats, got %+v", overview.CPU.CoreStats)
+	}
+
+	// Stored metrics are untouched
+	if len(ms.GetCurrentMetrics("agent-1").CPU.PerCoreUsage) != 2 {
+		t.Error("Expected stored per-core usage to be unaffected")
+	}
+}
+
+func TestMergePreservesPerAgentOrdering(t *testing.T) {
+	// Few shards so that many agents share each lock
+	ms := NewMetricsServiceWithShards(zap.NewNop().Sugar(), 4)
+	const agents, updates = 32, 400
+
+	var wg sync.WaitGroup
+	for a := 0; a < agents; a++ {
+		wg.Add(1)
+		go func(agentID string) {
+			defer wg.Done()
+			for i := 1; i <= updates; i++ {
+				ms.MergeRealtimeMetrics(agentID, &RealtimeUpdate{CPUUsage: float64(i), MemoryUsed: uint64(i)})
+				if i%50 == 0 {
+					ms.MergePeriodicData(agentID, &PeriodicUpdate{
+						DiskUsage: []DiskData{{MountPoint: "/", UsagePercent: float64(i)}},
+					})
+				}
+			}
+		}(fmt.Sprintf("agent-%d", a))
+	}
+	wg.Wait()
+
+	for a := 0; a < agents; a++ {
+		agentID := fmt.Sprintf("agent-%d", a)
+		history := ms.GetMetricsHistory(agentID, 0)
+		if len(history) != updates {
+			t.Fatalf("Expected %d history entries for %s, got %d", updates, agentID, len(history))
+		}
+		for i, sample := range history {
+			if sample.CPU.UsagePercent != float64(i+1) {
+				t.Fatalf("Expected update %d of %s at position %d, got %v", i+1, agentID, i, sample.CPU.UsagePercent)
+			}
+		}
+		current := ms.GetCurrentMetrics(agentID)
+		if current.CPU.UsagePercent != updates || current.Disks[0].UsagePercent != updates {
+			t.Errorf("Expected %s to end on its last update, got cpu %v disk %v",
+				agentID, current.CPU.UsagePercent, current.Disks[0].UsagePercent)
+		}
+	}
+
+	if count := len(ms.GetAllCurrentMetrics()); count != agents {
+		t.Errorf("Expected %d agents, got %d", agents, count)
+	}
+	if count := ms.GetSummary()["agentCount"]; count != agents {
+		t.Errorf("Expected summary agentCount %d, got %v", agents, count)
+	}
+}
+
+// BenchmarkMergeRealtimeConcurrentAgents merges updates from many agents at
+// once; compare shards=1 (one service-wide lock) with the default striping
+func BenchmarkMergeRealtimeConcurrentAgents(b *testing.B) {
+	for _, shards := range []int{1, DefaultMetricsShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			ms := NewMetricsServiceWithShards(zap.NewNop().Sugar(), shards)
+			var nextAgent atomic.Int64
+			perCore := make([]float64, 16)
+
+			// Each parallel goroutine plays one agent
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				agentID := fmt.Sprintf("agent-%d", nextAgent.Add(1))
+				update := &RealtimeUpdate{
+					CPUUsage:   42,
+					CPUPerCore: perCore,
+					MemoryUsed: 1 << 30,
+					NetworkIO:  []NetData{{Interface: "eth0", RxBytesPS: 1000}},
+					DiskIO:     []DiskData{{Device: "sda", ReadBytesPS: 1000}},
+				}
+				for pb.Next() {
+					ms.MergeRealtimeMetrics(agentID, update)
+				}
+			})
+		})
+	}
+}
+
+func collectBroadcasts(ms *MetricsService) <-chan *MetricsData {
+	ch := make(chan *MetricsData, 16)
+	ms.SetBroadcastCallback(func(agentID string, metrics interface{}) {
+		ch <- metrics.(*MetricsData)
+	})
+	return ch
+}
+
+func TestRealtimeBeforeSnapshotIsNotBroadcast(t *testing.T) {
+	ms := NewMetricsService(zap.NewNop().Sugar())
+	broadcasts := collectBroadcasts(ms)
+
+	ms.MergeRealtimeMetrics("agent-1", &RealtimeUpdate{CPUUsage: 12, MemoryUsed: 1 << 30})
+	select {
+	case data := <-broadcasts:
+		t.Fatalf("Expected no broadcast before a full snapshot, got cores %d memory total %d",
+			data.CPU.LogicalCores, data.Memory.Total)
+	case <-time.After(50 * time.Millisecond):
+	}
+	current := ms.GetCurrentMetrics("agent-1")
+	if current == nil || !current.Incomplete {
+		t.Fatal("Expected realtime-only metrics to be kept and flagged incomplete")
+	}
+	if ms.Publishable(current) {
+		t.Error("Expected incomplete metrics not to be publishable")
+	}
+
+	ms.MergeStaticInfo("agent-1", &StaticUpdate{
+		CPU:    &CPUData{LogicalCores: 8},
+		Memory: &MemData{Total: 16 << 30},
+	})
+	ms.MergeRealtimeMetrics("agent-1", &RealtimeUpdate{CPUUsage: 15, MemoryUsed: 2 << 30})
+	select {
+	case data := <-broadcasts:
+		if data.Incomplete || data.CPU.LogicalCores != 8 || data.Memory.Total != 16<<30 {
+			t.Errorf("Expected a complete broadcast after static info, got %+v", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a broadcast once static info arrived")
+	}
+}
+
+func TestPartialMetricsMarkMode(t *testing.T) {
+	ms := NewMetricsService(zap.NewNop().Sugar())
+	if err := ms.SetPartialMetricsMode("drop"); !errors.Is(err, ErrInvalidPartialMetricsMode) {
+		t.Errorf("Expected ErrInvalidPartialMetricsMode, got %v", err)
+	}
+	if err := ms.SetPartialMetricsMode(PartialMetricsMark); err != nil {
+		t.Fatal(err)
+	}
+	broadcasts := collectBroadcasts(ms)
+
+	ms.MergeRealtimeMetrics("agent-1", &RealtimeUpdate{CPUUsage: 12})
+	select {
+	case data := <-broadcasts:
+		if !data.Incomplete {
+			t.Error("Expected the broadcast to be flagged incomplete")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected incomplete metrics to be broadcast in mark mode")
+	}
+
+	ms.StoreMetrics("agent-1", &MetricsData{CPU: CPUData{LogicalCores: 4}})
+	if ms.GetCurrentMetrics("agent-1").Incomplete {
+		t.Error("Expected a full snapshot to clear the incomplete flag")
+	}
+}
+
+func TestUserSessionsCapped(t *testing.T) {
+	ms := NewMetricsService(zap.NewNop().Sugar())
+	ms.SetMaxUserSessions(2)
+
+	sessions := []UserSession{
+		{Username: "idle", IdleSeconds: 3600},
+		{Username: "active", IdleSeconds: 0, LoginTime: 100},
+		{Username: "newer", IdleSeconds: 0, LoginTime: 200},
+		{Username: "away", IdleSeconds: 60},
+	}
+	ms.MergePeriodicData("agent-1", &PeriodicUpdate{UserSessions: sessions})
+
+	current := ms.GetCurrentMetrics("agent-1")
+	if len(current.UserSessions) != 2 || current.UserSessions[0].Username != "newer" || current.UserSessions[1].Username != "active" {
+		t.Errorf("Expected the two most recently active sessions, got %+v", current.UserSessions)
+	}
+	if current.UserSessionCount != 4 || current.UserSessionsTruncated != 2 {
+		t.Errorf("Expected 4 sessions with 2 truncated, got %d and %d", current.UserSessionCount, current.UserSessionsTruncated)
+	}
+	if sessions[0].Username != "idle" {
+		t.Error("Expected the reported sessions to be left untouched")
+	}
+
+	// The overview keeps just the count
+	summary := SummarizeUserSessions(current)
+	if summary.UserSessions != nil || summary.UserSessionCount != 4 {
+		t.Errorf("Expected only the session count in the summary, got %+v", summary)
+	}
+
+	// Under the cap nothing is truncated
+	ms.StoreMetrics("agent-1", &MetricsData{UserSessions: sessions[:1]})
+	current = ms.GetCurrentMetrics("agent-1")
+	if len(current.UserSessions) != 1 || current.UserSessionCount != 1 || current.UserSessionsTruncated != 0 {
+		t.Errorf("Expected one untruncated session, got %+v", current)
+	}
+}
+
+func TestMetricsHistoryRange(t *testing.T) {
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(base)
+	ms := NewMetricsService(zap.NewNop().Sugar())
+	ms.SetClock(clock)
+	for i := 0; i < 10; i++ {
+		ms.StoreMetrics("agent-1", &MetricsData{CPU: CPUData{UsagePercent: float64(i)}})
+		clock.Advance(time.Minute)
+	}
+
+	got := ms.GetMetricsHistoryRange("agent-1", base.Add(2*time.Minute), base.Add(5*time.Minute))
+	if len(got) != 4 || got[0].CPU.UsagePercent != 2 || got[3].CPU.UsagePercent != 5 {
+		t.Errorf("Expected samples 2 through 5 inclusive, got %d samples", len(got))
+	}
+	if got := ms.GetMetricsHistoryRange("agent-1", base.Add(8*time.Minute), time.Time{}); len(got) != 2 {
+		t.Errorf("Expected an open end to include the latest samples, got %d", len(got))
+	}
+	if got := ms.GetMetricsHistoryRange("agent-1", base.Add(time.Hour), base.Add(2*time.Hour)); got != nil {
+		t.Errorf("Expected no samples after the history, got %d", len(got))
+	}
+	if got := ms.GetMetricsHistoryRange("agent-2", base, base.Add(time.Hour)); got != nil {
+		t.Errorf("Expected no samples for an unknown agent, got %d", len(got))
+	}
+}
@@ -0,0 +1,33 @@
+package service
+
+import "path"
+
+// NameFilter matches names against allow and deny lists of names or glob
+// patterns such as "veth*" or "*audit*". Deny wins over allow; an empty
+// allow list allows everything not denied.
+type NameFilter struct {
+	Allow []string
+	Deny  []string
+}
+
+// Allows reports whether name passes the filter
+func (f NameFilter) Allows(name string) bool {
+	if MatchesAny(f.Deny, name) {
+		return false
+	}
+	return len(f.Allow) == 0 || MatchesAny(f.Allow, name)
+}
+
+// MatchesAny reports whether name equals or matches any of the glob patterns.
+// Malformed patterns match nothing.
+func MatchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if pattern == name {
+			return true
+		}
+		if ok, err := path.Match(pattern, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
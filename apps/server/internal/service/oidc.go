@@ -0,0 +1,439 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// OIDC errors
+var (
+	ErrOIDCNotConfigured = errors.New("OIDC login is not configured")
+	ErrOIDCState         = errors.New("invalid or expired OIDC login state")
+	ErrOIDCIDToken       = errors.New("invalid OIDC ID token")
+)
+
+// AuthProviderOIDC marks users provisioned through OIDC
+const AuthProviderOIDC = "oidc"
+
+// How long a login started with AuthURL can be completed
+const oidcLoginTimeout = 10 * time.Minute
+
+// OIDCConfig configures an OpenID Connect provider
+type OIDCConfig struct {
+	IssuerURL     string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string          // default openid, profile, email
+	UsernameClaim string            // default preferred_username
+	GroupsClaim   string            // default groups
+	GroupMapping  map[string]string // provider group (case-insensitive) -> NanoLink group
+	PostLoginURL  string            // where the browser goes after login (default "/")
+}
+
+// OIDCIdentity is a user verified by the provider
+type OIDCIdentity struct {
+	Subject  string
+	Username string
+	Email    string
+	// Nil when the ID token carries no groups claim
+	Groups []string
+}
+
+// OIDCProvider runs the authorization-code flow (with PKCE) against an
+// OpenID Connect provider and verifies the ID tokens it returns. The
+// provider's metadata and keys are fetched on first use.
+type OIDCProvider struct {
+	cfg    OIDCConfig
+	client *http.Client
+	clock  Clock
+	logger *zap.SugaredLogger
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]interface{}
+	keysAt    time.Time
+	logins    map[string]oidcLogin // by state
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcLogin is a login in progress
+type oidcLogin struct {
+	nonce    string
+	verifier string
+	expires  time.Time
+}
+
+// NewOIDCProvider creates a provider for cfg
+func NewOIDCProvider(cfg OIDCConfig, logger *zap.SugaredLogger) *OIDCProvider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "preferred_username"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.PostLoginURL == "" {
+		cfg.PostLoginURL = "/"
+	}
+	cfg.IssuerURL = strings.TrimSuffix(cfg.IssuerURL, "/")
+	// Config loaders lowercase map keys, so provider groups match regardless of case
+	mapping := make(map[string]string, len(cfg.GroupMapping))
+	for group, target := range cfg.GroupMapping {
+		mapping[strings.ToLower(group)] = target
+	}
+	cfg.GroupMapping = mapping
+	return &OIDCProvider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		clock:  RealClock,
+		logger: logger,
+		logins: make(map[string]oidcLogin),
+	}
+}
+
+// SetHTTPClient replaces the client used to reach the provider
+func (p *OIDCProvider) SetHTTPClient(client *http.Client) {
+	p.client = client
+}
+
+// SetClock replaces the time source (for tests)
+func (p *OIDCProvider) SetClock(clock Clock) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clock = clock
+}
+
+// GroupMapping returns the provider group -> NanoLink group mapping, keyed
+// by lowercased provider group
+func (p *OIDCProvider) GroupMapping() map[string]string {
+	return p.cfg.GroupMapping
+}
+
+// PostLoginURL returns where the browser is sent once a login completes
+func (p *OIDCProvider) PostLoginURL() string {
+	return p.cfg.PostLoginURL
+}
+
+// AuthURL starts a login, returning the provider URL to send the browser to
+func (p *OIDCProvider) AuthURL(ctx context.Context) (string, error) {
+	disc, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	state, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	p.mu.Lock()
+	now := p.clock.Now()
+	for s, login := range p.logins {
+		if now.After(login.expires) {
+			delete(p.logins, s)
+		}
+	}
+	p.logins[state] = oidcLogin{nonce: nonce, verifier: verifier, expires: now.Add(oidcLoginTimeout)}
+	p.mu.Unlock()
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(disc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return disc.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// Exchange completes a login: it redeems the authorization code returned
+// with state and verifies the resulting ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, state, code string) (*OIDCIdentity, error) {
+	p.mu.Lock()
+	login, ok := p.logins[state]
+	delete(p.logins, state)
+	now := p.clock.Now()
+	p.mu.Unlock()
+	if !ok || now.After(login.expires) {
+		return nil, ErrOIDCState
+	}
+
+	disc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {login.verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, disc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.doJSON(req, &token); err != nil {
+		if token.Error != "" {
+			return nil, fmt.Errorf("OIDC token exchange failed: %s: %s", token.Error, token.ErrorDescription)
+		}
+		return nil, fmt.Errorf("OIDC token exchange failed: %w", err)
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("%w: token response has no id_token", ErrOIDCIDToken)
+	}
+	return p.verifyIDToken(ctx, disc, token.IDToken, login.nonce)
+}
+
+// verifyIDToken checks an ID token's signature, issuer, audience, expiry
+// and nonce, and extracts the identity from its claims
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, disc *oidcDiscovery, raw, nonce string) (*OIDCIdentity, error) {
+	claims := jwt.MapClaims{}
+	keyfunc := func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, disc, kid)
+	}
+	_, err := jwt.ParseWithClaims(raw, claims, keyfunc,
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(disc.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(p.now),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCIDToken, err)
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrOIDCIDToken)
+	}
+
+	identity := &OIDCIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Username, _ = claims[p.cfg.UsernameClaim].(string)
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("%w: missing sub claim", ErrOIDCIDToken)
+	}
+	if identity.Username == "" {
+		identity.Username = identity.Email
+	}
+	if identity.Username == "" {
+		identity.Username = identity.Subject
+	}
+	switch groups := claims[p.cfg.GroupsClaim].(type) {
+	case []interface{}:
+		identity.Groups = []string{}
+		for _, g := range groups {
+			if name, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	case string:
+		identity.Groups = []string{groups}
+	}
+	return identity, nil
+}
+
+func (p *OIDCProvider) now() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.clock.Now()
+}
+
+// discover fetches the provider metadata once
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	if p.cfg.IssuerURL == "" || p.cfg.ClientID == "" {
+		return nil, ErrOIDCNotConfigured
+	}
+	p.mu.Lock()
+	disc := p.discovery
+	p.mu.Unlock()
+	if disc != nil {
+		return disc, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.IssuerURL+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	disc = &oidcDiscovery{}
+	if err := p.doJSON(req, disc); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if strings.TrimSuffix(disc.Issuer, "/") != p.cfg.IssuerURL {
+		return nil, fmt.Errorf("OIDC discovery failed: issuer %q does not match %q", disc.Issuer, p.cfg.IssuerURL)
+	}
+	if disc.AuthorizationEndpoint == "" || disc.TokenEndpoint == "" || disc.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery failed: provider metadata is incomplete")
+	}
+
+	p.mu.Lock()
+	p.discovery = disc
+	p.mu.Unlock()
+	return disc, nil
+}
+
+// key returns the provider's signing key with the given ID, refetching the
+// key set when the ID is unknown (at most once a minute, for key rotation)
+func (p *OIDCProvider) key(ctx context.Context, disc *oidcDiscovery, kid string) (interface{}, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	stale := p.clock.Now().Sub(p.keysAt) > time.Minute
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale && p.keys != nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, disc.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.doJSON(req, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		} else {
+			p.logger.Warnf("Ignoring OIDC signing key %q: %v", k.Kid, err)
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysAt = p.clock.Now()
+	p.mu.Unlock()
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// doJSON sends req and decodes the JSON response into v, failing on
+// non-2xx statuses after decoding what it can
+func (p *OIDCProvider) doJSON(req *http.Request, v interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	decodeErr := json.NewDecoder(resp.Body).Decode(v)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return decodeErr
+}
+
+// jsonWebKey is an RSA or EC public key from a JWKS document
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
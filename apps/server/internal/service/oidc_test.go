@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// fakeIdP is a minimal OpenID Connect provider issuing ID tokens for one
+// authorization code
+type fakeIdP struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	claims    jwt.MapClaims
+	challenge string
+	nonce     string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "key-1",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, secret, _ := r.BasicAuth()
+		verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if clientID != "nanolink" || secret != "client-secret" || r.FormValue("code") != "auth-code" ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != idp.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss":   idp.URL,
+			"aud":   "nanolink",
+			"exp":   time.Now().Add(5 * time.Minute).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": idp.nonce,
+		}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "key-1"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// login runs the browser side of the flow: it starts a login and returns
+// the state the provider redirects back with
+func (idp *fakeIdP) login(t *testing.T, provider *OIDCProvider, claims jwt.MapClaims) string {
+	t.Helper()
+	authURL, err := provider.AuthURL(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != "nanolink" || q.Get("redirect_uri") != "https://nanolink.example.com/api/auth/oidc/callback" || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("Unexpected authorization request %s", authURL)
+	}
+	idp.challenge = q.Get("code_challenge")
+	idp.nonce = q.Get("nonce")
+	idp.claims = claims
+	return q.Get("state")
+}
+
+func TestOIDCLoginProvisionsExternalUser(t *testing.T) {
+	idp := newFakeIdP(t)
+	db := newTestDB(t)
+	logger := zap.NewNop().Sugar()
+	auth := NewAuthService(db, AuthConfig{JWTSecret: "signing-key"}, logger)
+	provider := NewOIDCProvider(OIDCConfig{
+		IssuerURL:    idp.URL,
+		ClientID:     "nanolink",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://nanolink.example.com/api/auth/oidc/callback",
+		GroupMapping: map[string]string{"Okta-Ops": "ops", "okta-dev": "dev"},
+	}, logger)
+	auth.SetOIDCProvider(provider)
+	for _, name := range []string{"ops", "dev", "finance"} {
+		if err := db.Create(&database.Group{Name: name}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Local accounts keep working side by side
+	if _, err := auth.RegisterUser("bob", "local-pass-1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	state := idp.login(t, provider, jwt.MapClaims{
+		"sub":                "00u123",
+		"email":              "alice@example.com",
+		"preferred_username": "alice",
+		"groups":             []string{"Okta-Ops", "okta-dev", "Everyone"},
+	})
+	identity, err := provider.Exchange(context.Background(), state, "auth-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, user, err := auth.LoginOIDC(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "alice" || user.Email != "alice@example.com" || user.AuthProvider != AuthProviderOIDC || user.ExternalID != "00u123" {
+		t.Errorf("Expected an externally-managed alice, got %+v", user)
+	}
+	if claims, err := auth.VerifyToken(pair.AccessToken); err != nil || claims.UserID != user.ID {
+		t.Errorf("Expected a NanoLink token for alice, got %+v, %v", claims, err)
+	}
+	if got := userGroupNames(t, db, user.ID); len(got) != 2 || got[0] != "dev" || got[1] != "ops" {
+		t.Errorf("Expected the mapped groups dev and ops, got %v", got)
+	}
+
+	// Password login is disabled for the provisioned account
+	if _, _, err := auth.LoginUser("alice", ""); !errors.Is(err, ErrExternalAccount) {
+		t.Errorf("Expected ErrExternalAccount for password login, got %v", err)
+	}
+	if err := auth.UpdatePassword(user.ID, "new-pass-123"); !errors.Is(err, ErrExternalAccount) {
+		t.Errorf("Expected ErrExternalAccount when setting a password, got %v", err)
+	}
+	if _, _, err := auth.LoginUser("bob", "local-pass-1"); err != nil {
+		t.Errorf("Expected local login to keep working, got %v", err)
+	}
+
+	// A later login reuses the account and follows group changes
+	state = idp.login(t, provider, jwt.MapClaims{"sub": "00u123", "preferred_username": "alice", "groups": []string{"okta-dev"}})
+	identity, err = provider.Exchange(context.Background(), state, "auth-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, again, err := auth.LoginOIDC(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != user.ID {
+		t.Errorf("Expected the existing account to be reused, got user %d", again.ID)
+	}
+	if got := userGroupNames(t, db, user.ID); len(got) != 1 || got[0] != "dev" {
+		t.Errorf("Expected only dev after leaving Okta-Ops, got %v", got)
+	}
+
+	// The provider cannot take over a local account
+	state = idp.login(t, provider, jwt.MapClaims{"sub": "00u999", "preferred_username": "bob"})
+	identity, err = provider.Exchange(context.Background(), state, "auth-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := auth.LoginOIDC(identity); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Expected ErrUserExists for a local username, got %v", err)
+	}
+}
+
+func TestOIDCExchangeRejectsBadStateAndNonce(t *testing.T) {
+	idp := newFakeIdP(t)
+	provider := NewOIDCProvider(OIDCConfig{
+		IssuerURL:    idp.URL,
+		ClientID:     "nanolink",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://nanolink.example.com/api/auth/oidc/callback",
+	}, zap.NewNop().Sugar())
+	clock := NewFakeClock(time.Now())
+	provider.SetClock(clock)
+
+	if _, err := provider.Exchange(context.Background(), "forged", "auth-code"); !errors.Is(err, ErrOIDCState) {
+		t.Errorf("Expected ErrOIDCState for an unknown state, got %v", err)
+	}
+
+	state := idp.login(t, provider, jwt.MapClaims{"sub": "00u123"})
+	idp.nonce = "replayed"
+	if _, err := provider.Exchange(context.Background(), state, "auth-code"); !errors.Is(err, ErrOIDCIDToken) {
+		t.Errorf("Expected ErrOIDCIDToken for a nonce mismatch, got %v", err)
+	}
+	// States are single-use
+	if _, err := provider.Exchange(context.Background(), state, "auth-code"); !errors.Is(err, ErrOIDCState) {
+		t.Errorf("Expected ErrOIDCState for a reused state, got %v", err)
+	}
+
+	state = idp.login(t, provider, jwt.MapClaims{"sub": "00u123"})
+	clock.Advance(oidcLoginTimeout + time.Second)
+	if _, err := provider.Exchange(context.Background(), state, "auth-code"); !errors.Is(err, ErrOIDCState) {
+		t.Errorf("Expected ErrOIDCState for an expired login, got %v", err)
+	}
+}
+
+func userGroupNames(t *testing.T, db *gorm.DB, userID uint) []string {
+	t.Helper()
+	var user database.User
+	if err := db.Preload("Groups").First(&user, userID).Error; err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(user.Groups))
+	for _, g := range user.Groups {
+		names = append(names, g.Name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,381 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.uber.org/zap"
+)
+
+// Instrumentation scope of everything the OTel exporter emits
+const otelScope = "github.com/chenqi92/NanoLink/apps/server"
+
+// OTelExporter periodically pushes every agent's current metrics and the
+// server's own counters to an OpenTelemetry collector. Each agent is sent as
+// its own resource, carrying its hostname, OS and architecture.
+type OTelExporter struct {
+	agents   *AgentService
+	metrics  *MetricsService
+	exporter sdkmetric.Exporter
+	interval time.Duration
+	logger   *zap.SugaredLogger
+
+	// Server instruments, collected on each export
+	reader   *sdkmetric.ManualReader
+	provider *sdkmetric.MeterProvider
+
+	mu                 sync.Mutex
+	clock              Clock
+	commandsDispatched func() int64
+	broadcastDrops     func() int64
+	// Ingested count and time of the previous collection, for the ingest rate
+	lastIngested int64
+	lastCollect  time.Time
+
+	started  bool
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewOTelExporter creates an exporter sending to the OTLP/HTTP endpoint in cfg
+func NewOTelExporter(cfg config.OTelConfig, agents *AgentService, metrics *MetricsService, logger *zap.SugaredLogger) (*OTelExporter, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: expected an http or https URL", cfg.Endpoint)
+	}
+	opts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpointURL(cfg.Endpoint)}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlpmetrichttp.WithHeaders(cfg.Headers))
+	}
+	exporter, err := otlpmetrichttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newOTelExporter(cfg, agents, metrics, exporter, logger)
+}
+
+func newOTelExporter(cfg config.OTelConfig, agents *AgentService, metrics *MetricsService, exporter sdkmetric.Exporter, logger *zap.SugaredLogger) (*OTelExporter, error) {
+	interval := time.Duration(cfg.IntervalSec) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	e := &OTelExporter{
+		agents:   agents,
+		metrics:  metrics,
+		exporter: exporter,
+		interval: interval,
+		logger:   logger,
+		reader:   sdkmetric.NewManualReader(),
+		clock:    RealClock,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	e.provider = sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(e.reader),
+		sdkmetric.WithResource(resource.NewSchemaless(attribute.String("service.name", "nanolink-server"))),
+	)
+	if err := e.registerInstruments(e.provider.Meter(otelScope)); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// SetCommandsDispatched sets the source of the commands dispatched counter
+func (e *OTelExporter) SetCommandsDispatched(count func() int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.commandsDispatched = count
+}
+
+// SetBroadcastDrops sets the source of the dashboard broadcast drops counter
+func (e *OTelExporter) SetBroadcastDrops(count func() int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.broadcastDrops = count
+}
+
+// SetClock replaces the time source (for tests)
+func (e *OTelExporter) SetClock(clock Clock) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.clock = clock
+}
+
+// registerInstruments creates the instruments reporting on the server itself
+func (e *OTelExporter) registerInstruments(meter metric.Meter) error {
+	_, err := meter.Int64ObservableGauge("nanolink.agents.connected",
+		metric.WithDescription("Agents currently connected"),
+		metric.WithUnit("{agent}"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(e.agents.GetAgentCount()))
+			return nil
+		}))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.Int64ObservableCounter("nanolink.commands.dispatched",
+		metric.WithDescription("Commands dispatched to agents"),
+		metric.WithUnit("{command}"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			e.mu.Lock()
+			count := e.commandsDispatched
+			e.mu.Unlock()
+			if count != nil {
+				o.Observe(count())
+			}
+			return nil
+		}))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.Int64ObservableCounter("nanolink.metrics.ingested",
+		metric.WithDescription("Full metrics snapshots received from agents"),
+		metric.WithUnit("{snapshot}"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(e.metrics.IngestedCount())
+			return nil
+		}))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.Float64ObservableGauge("nanolink.metrics.ingest_rate",
+		metric.WithDescription("Full metrics snapshots received per second since the previous export"),
+		metric.WithUnit("{snapshot}/s"),
+		metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+			o.Observe(e.ingestRate())
+			return nil
+		}))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.Int64ObservableCounter("nanolink.dashboard.broadcast_drops",
+		metric.WithDescription("Dashboard messages dropped because a client fell behind"),
+		metric.WithUnit("{message}"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			e.mu.Lock()
+			count := e.broadcastDrops
+			e.mu.Unlock()
+			if count != nil {
+				o.Observe(count())
+			}
+			return nil
+		}))
+	return err
+}
+
+// ingestRate returns snapshots ingested per second since it was last called
+func (e *OTelExporter) ingestRate() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.clock.Now()
+	count := e.metrics.IngestedCount()
+	rate := 0.0
+	if !e.lastCollect.IsZero() {
+		if elapsed := now.Sub(e.lastCollect).Seconds(); elapsed > 0 {
+			rate = float64(count-e.lastIngested) / elapsed
+		}
+	}
+	e.lastIngested = count
+	e.lastCollect = now
+	return rate
+}
+
+// Start begins exporting on the configured interval
+func (e *OTelExporter) Start() {
+	e.mu.Lock()
+	e.started = true
+	e.mu.Unlock()
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+				if err := e.Export(ctx); err != nil {
+					e.logger.Warnf("OTLP metrics export failed: %v", err)
+				}
+				cancel()
+			case <-e.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown stops exporting and shuts the OTLP exporter down
+func (e *OTelExporter) Shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() { close(e.stop) })
+	e.mu.Lock()
+	started := e.started
+	e.mu.Unlock()
+	if started {
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return fmt.Errorf("OTLP export still running: %w", ctx.Err())
+		}
+	}
+	return errors.Join(e.provider.Shutdown(ctx), e.exporter.Shutdown(ctx))
+}
+
+// Export sends the server's instruments and every agent's current metrics
+// once, returning the errors of any resources that failed to send
+func (e *OTelExporter) Export(ctx context.Context) error {
+	var server metricdata.ResourceMetrics
+	if err := e.reader.Collect(ctx, &server); err != nil {
+		return err
+	}
+	var errs []error
+	for _, rm := range append([]*metricdata.ResourceMetrics{&server}, e.agentResources()...) {
+		if err := e.exporter.Export(ctx, rm); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// agentResources converts each agent's current metrics into gauges on a
+// resource describing the agent's host
+func (e *OTelExporter) agentResources() []*metricdata.ResourceMetrics {
+	e.mu.Lock()
+	now := e.clock.Now()
+	e.mu.Unlock()
+
+	all := e.metrics.GetAllCurrentMetrics()
+	agentIDs := make([]string, 0, len(all))
+	for id := range all {
+		agentIDs = append(agentIDs, id)
+	}
+	sort.Strings(agentIDs)
+
+	resources := make([]*metricdata.ResourceMetrics, 0, len(agentIDs))
+	for _, id := range agentIDs {
+		data := all[id]
+		// Static fields such as total memory are unknown until the first full snapshot
+		if data.Incomplete {
+			continue
+		}
+		resources = append(resources, &metricdata.ResourceMetrics{
+			Resource: e.agentResource(id, data),
+			ScopeMetrics: []metricdata.ScopeMetrics{{
+				Scope:   instrumentation.Scope{Name: otelScope},
+				Metrics: agentGauges(data, now),
+			}},
+		})
+	}
+	return resources
+}
+
+func (e *OTelExporter) agentResource(agentID string, data *MetricsData) *resource.Resource {
+	var hostname, osName, arch string
+	if agent := e.agents.GetAgent(agentID); agent != nil {
+		hostname, osName, arch = agent.Hostname, agent.OS, agent.Arch
+	}
+	if hostname == "" && data.SystemInfo != nil {
+		hostname = data.SystemInfo.Hostname
+	}
+	return resource.NewSchemaless(
+		attribute.String("service.name", "nanolink-agent"),
+		attribute.String("nanolink.agent.id", agentID),
+		attribute.String("host.name", hostname),
+		attribute.String("os.type", osName),
+		attribute.String("host.arch", arch),
+	)
+}
+
+// agentGauges returns the gauges exported for one agent's metrics
+func agentGauges(data *MetricsData, now time.Time) []metricdata.Metrics {
+	var g otelGauges
+	stale := 0.0
+	if data.Stale {
+		stale = 1
+	}
+	g.add("nanolink.metrics.age", "Seconds since the agent last reported metrics", "s", now, data.AgeSeconds)
+	g.add("nanolink.metrics.stale", "1 if the agent's metrics are stale", "1", now, stale)
+
+	g.add("nanolink.cpu.usage", "CPU usage across all cores", "%", now, data.CPU.UsagePercent)
+	if len(data.LoadAverage) > 0 {
+		g.add("nanolink.load.1m", "1 minute load average", "1", now, data.LoadAverage[0])
+	}
+
+	g.add("nanolink.memory.used", "Memory in use", "By", now, float64(data.Memory.Used))
+	g.add("nanolink.memory.total", "Total memory", "By", now, float64(data.Memory.Total))
+
+	for _, d := range data.Disks {
+		attrs := []attribute.KeyValue{attribute.String("mount", d.MountPoint), attribute.String("device", d.Device)}
+		g.add("nanolink.disk.usage", "Percent of disk space in use", "%", now, d.UsagePercent, attrs...)
+		g.add("nanolink.disk.used", "Disk space in use", "By", now, float64(d.Used), attrs...)
+		g.add("nanolink.disk.total", "Disk size", "By", now, float64(d.Total), attrs...)
+	}
+
+	for _, n := range data.Networks {
+		attrs := []attribute.KeyValue{attribute.String("interface", n.Interface)}
+		g.add("nanolink.network.receive_rate", "Network receive rate", "By/s", now, float64(n.RxBytesPS), attrs...)
+		g.add("nanolink.network.transmit_rate", "Network transmit rate", "By/s", now, float64(n.TxBytesPS), attrs...)
+	}
+
+	for _, gpu := range data.GPUs {
+		attrs := []attribute.KeyValue{attribute.String("index", strconv.Itoa(gpu.Index)), attribute.String("name", gpu.Name)}
+		g.add("nanolink.gpu.usage", "GPU utilization", "%", now, gpu.UsagePercent, attrs...)
+		g.add("nanolink.gpu.memory.used", "GPU memory in use", "By", now, float64(gpu.MemoryUsed), attrs...)
+		g.add("nanolink.gpu.memory.total", "GPU memory size", "By", now, float64(gpu.MemoryTotal), attrs...)
+		g.add("nanolink.gpu.temperature", "GPU temperature", "Cel", now, gpu.Temperature, attrs...)
+		g.add("nanolink.gpu.power", "GPU power draw", "W", now, float64(gpu.PowerWatts), attrs...)
+	}
+	return g.metrics()
+}
+
+// otelGauges collects data points into gauges, one per metric name, in the
+// order the names were first added
+type otelGauges struct {
+	names  []string
+	gauges map[string]*metricdata.Metrics
+	points map[string][]metricdata.DataPoint[float64]
+}
+
+func (g *otelGauges) add(name, description, unit string, now time.Time, value float64, attrs ...attribute.KeyValue) {
+	if g.gauges == nil {
+		g.gauges = make(map[string]*metricdata.Metrics)
+		g.points = make(map[string][]metricdata.DataPoint[float64])
+	}
+	if _, ok := g.gauges[name]; !ok {
+		g.names = append(g.names, name)
+		g.gauges[name] = &metricdata.Metrics{Name: name, Description: description, Unit: unit}
+	}
+	g.points[name] = append(g.points[name], metricdata.DataPoint[float64]{
+		Attributes: attribute.NewSet(attrs...),
+		Time:       now,
+		Value:      value,
+	})
+}
+
+func (g *otelGauges) metrics() []metricdata.Metrics {
+	metrics := make([]metricdata.Metrics, 0, len(g.names))
+	for _, name := range g.names {
+		m := *g.gauges[name]
+		m.Data = metricdata.Gauge[float64]{DataPoints: g.points[name]}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
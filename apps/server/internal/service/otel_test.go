@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.uber.org/zap"
+)
+
+// recordingExporter keeps every batch it is asked to export
+type recordingExporter struct {
+	batches []*metricdata.ResourceMetrics
+}
+
+func (r *recordingExporter) Temporality(k sdkmetric.InstrumentKind) metricdata.Temporality {
+	return sdkmetric.DefaultTemporalitySelector(k)
+}
+
+func (r *recordingExporter) Aggregation(k sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return sdkmetric.DefaultAggregationSelector(k)
+}
+
+func (r *recordingExporter) Export(_ context.Context, rm *metricdata.ResourceMetrics) error {
+	r.batches = append(r.batches, rm)
+	return nil
+}
+
+func (r *recordingExporter) ForceFlush(context.Context) error { return nil }
+func (r *recordingExporter) Shutdown(context.Context) error   { return nil }
+
+// otelValue returns the value of the named metric's first data point
+func otelValue(t *testing.T, rm *metricdata.ResourceMetrics, name string) float64 {
+	t.Helper()
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			switch data := m.Data.(type) {
+			case metricdata.Gauge[float64]:
+				return data.DataPoints[0].Value
+			case metricdata.Gauge[int64]:
+				return float64(data.DataPoints[0].Value)
+			case metricdata.Sum[int64]:
+				return float64(data.DataPoints[0].Value)
+			}
+			t.Fatalf("Unexpected data type %T for %s", m.Data, name)
+		}
+	}
+	t.Fatalf("Metric %s not exported", name)
+	return 0
+}
+
+func TestOTelExporterSendsAgentResourcesAndServerCounters(t *testing.T) {
+	log := zap.NewNop().Sugar()
+	ms := NewMetricsService(log)
+	agents := NewAgentService(log, ms)
+	agents.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "web-1", OS: "linux", Arch: "x86_64"}, 1)
+	ms.StoreMetrics("agent-1", &MetricsData{
+		CPU:   CPUData{UsagePercent: 42.5},
+		Disks: []DiskData{{MountPoint: "/", Device: "sda1", UsagePercent: 71}},
+	})
+
+	exp := &recordingExporter{}
+	e, err := newOTelExporter(config.OTelConfig{IntervalSec: 60}, agents, ms, exp, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewFakeClock(time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC))
+	e.SetClock(clock)
+	e.SetCommandsDispatched(func() int64 { return 3 })
+	e.SetBroadcastDrops(func() int64 { return 2 })
+
+	if err := e.Export(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(exp.batches) != 2 {
+		t.Fatalf("Expected the server and one agent resource, got %d", len(exp.batches))
+	}
+
+	server := exp.batches[0]
+	if name, _ := server.Resource.Set().Value("service.name"); name.AsString() != "nanolink-server" {
+		t.Errorf("Expected the server resource first, got %v", server.Resource)
+	}
+	for name, want := range map[string]float64{
+		"nanolink.agents.connected":          1,
+		"nanolink.commands.dispatched":       3,
+		"nanolink.metrics.ingested":          1,
+		"nanolink.dashboard.broadcast_drops": 2,
+	} {
+		if got := otelValue(t, server, name); got != want {
+			t.Errorf("Expected %s = %v, got %v", name, want, got)
+		}
+	}
+
+	agent := exp.batches[1]
+	for key, want := range map[attribute.Key]string{
+		"nanolink.agent.id": "agent-1",
+		"host.name":         "web-1",
+		"os.type":           "linux",
+		"host.arch":         "x86_64",
+	} {
+		if got, _ := agent.Resource.Set().Value(key); got.AsString() != want {
+			t.Errorf("Expected resource attribute %s = %q, got %q", key, want, got.AsString())
+		}
+	}
+	if got := otelValue(t, agent, "nanolink.cpu.usage"); got != 42.5 {
+		t.Errorf("Expected the agent's CPU usage, got %v", got)
+	}
+	if got := otelValue(t, agent, "nanolink.disk.usage"); got != 71 {
+		t.Errorf("Expected the agent's disk usage, got %v", got)
+	}
+
+	// The ingest rate covers the snapshots since the previous export
+	for i := 0; i < 5; i++ {
+		ms.StoreMetrics("agent-1", &MetricsData{})
+	}
+	clock.Advance(10 * time.Second)
+	exp.batches = nil
+	if err := e.Export(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := otelValue(t, exp.batches[0], "nanolink.metrics.ingest_rate"); got != 0.5 {
+		t.Errorf("Expected 0.5 snapshots per second, got %v", got)
+	}
+}
+
+func TestNewOTelExporterRejectsInvalidEndpoint(t *testing.T) {
+	log := zap.NewNop().Sugar()
+	ms := NewMetricsService(log)
+	for _, endpoint := range []string{"", "localhost:4318", "ftp://collector/v1/metrics"} {
+		if _, err := NewOTelExporter(config.OTelConfig{Endpoint: endpoint}, NewAgentService(log, ms), ms, log); err == nil {
+			t.Errorf("Expected endpoint %q to be rejected", endpoint)
+		}
+	}
+}
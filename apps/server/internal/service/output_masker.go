@@ -0,0 +1,92 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+)
+
+// ErrInvalidMaskPattern is returned for output masking patterns that do not compile
+var ErrInvalidMaskPattern = errors.New("invalid output masking pattern")
+
+// RedactedPlaceholder replaces secrets in stored command output
+const RedactedPlaceholder = "[REDACTED]"
+
+// maskRule replaces matches of re; replacement may reference groups
+type maskRule struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// defaultMaskRules catch the common ways secrets show up in env dumps,
+// config files and shell output. Key/value rules keep the key so the
+// output stays readable.
+var defaultMaskRules = []maskRule{
+	{
+		re:          regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`),
+		replacement: RedactedPlaceholder,
+	},
+	{
+		re:          regexp.MustCompile(`(?i)\b([A-Za-z0-9_.-]*(?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key|private[_-]?key|credentials?)[A-Za-z0-9_.-]*)(\s*[:=]\s*)("[^"\n]*"|'[^'\n]*'|[^\s,;]+)`),
+		replacement: "${1}${2}" + RedactedPlaceholder,
+	},
+	{
+		re:          regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]{8,}`),
+		replacement: "${1} " + RedactedPlaceholder,
+	},
+	{
+		re:          regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`),
+		replacement: RedactedPlaceholder,
+	},
+	{
+		re:          regexp.MustCompile(`(?i)\b([a-z][a-z0-9+.-]*://[^:/\s@]+:)[^@\s]+@`),
+		replacement: "${1}" + RedactedPlaceholder + "@",
+	},
+}
+
+// OutputMasker redacts secrets from command output before it is stored
+type OutputMasker struct {
+	rules    []maskRule
+	hashOnly map[string]bool
+}
+
+// NewOutputMasker compiles the configured patterns on top of the defaults
+func NewOutputMasker(cfg config.OutputMaskingConfig) (*OutputMasker, error) {
+	m := &OutputMasker{hashOnly: make(map[string]bool)}
+	if !cfg.DisableDefaults {
+		m.rules = append(m.rules, defaultMaskRules...)
+	}
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidMaskPattern, pattern, err)
+		}
+		m.rules = append(m.rules, maskRule{re: re, replacement: RedactedPlaceholder})
+	}
+	for _, commandType := range cfg.HashOnlyTypes {
+		m.hashOnly[strings.ToUpper(commandType)] = true
+	}
+	return m, nil
+}
+
+// Mask returns output as it may be stored for a command of the given type:
+// a hash and length for hash-only types, otherwise the output with secrets
+// replaced. A nil masker stores output unchanged.
+func (m *OutputMasker) Mask(commandType, output string) string {
+	if m == nil || output == "" {
+		return output
+	}
+	if m.hashOnly[strings.ToUpper(commandType)] {
+		sum := sha256.Sum256([]byte(output))
+		return fmt.Sprintf("[output withheld: %d bytes, sha256=%s]", len(output), hex.EncodeToString(sum[:]))
+	}
+	for _, rule := range m.rules {
+		output = rule.re.ReplaceAllString(output, rule.replacement)
+	}
+	return output
+}
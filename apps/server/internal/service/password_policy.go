@@ -0,0 +1,124 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Password requirements reported in a PasswordPolicyError
+const (
+	PasswordMinLength = "min_length"
+	PasswordMaxLength = "max_length"
+	PasswordLetter    = "letter"
+	PasswordNumber    = "number"
+	PasswordUpper     = "uppercase"
+	PasswordSpecial   = "special"
+	PasswordCommon    = "common"
+)
+
+// bcryptMaxBytes is the longest password bcrypt can hash
+const bcryptMaxBytes = 72
+
+// PasswordPolicy is the set of rules new passwords must satisfy
+type PasswordPolicy struct {
+	MinLength      int      // Minimum length in characters
+	MaxLength      int      // Maximum length in characters (0 = no limit beyond bcrypt's 72 bytes)
+	RequireLetter  bool     // At least one letter
+	RequireNumber  bool     // At least one digit
+	RequireUpper   bool     // At least one uppercase letter
+	RequireSpecial bool     // At least one character that is not a letter, digit or space
+	Disallowed     []string // Common passwords rejected regardless of the other rules (case-insensitive)
+}
+
+// DefaultPasswordPolicy returns the built-in policy: at least 8 characters
+// with a letter and a number
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:     8,
+		RequireLetter: true,
+		RequireNumber: true,
+	}
+}
+
+// PasswordFailure is a single requirement a password failed
+type PasswordFailure struct {
+	Requirement string `json:"requirement"`
+	Message     string `json:"message"`
+}
+
+// PasswordPolicyError lists every requirement a password failed. It wraps
+// ErrWeakPassword.
+type PasswordPolicyError struct {
+	Failures []PasswordFailure
+}
+
+func (e *PasswordPolicyError) Error() string {
+	msgs := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		msgs[i] = f.Message
+	}
+	return ErrWeakPassword.Error() + ": " + strings.Join(msgs, "; ")
+}
+
+func (e *PasswordPolicyError) Unwrap() error {
+	return ErrWeakPassword
+}
+
+// Validate checks a password against the policy, returning a
+// *PasswordPolicyError listing every failed requirement
+func (p PasswordPolicy) Validate(password string) error {
+	var failures []PasswordFailure
+	fail := func(requirement, format string, args ...interface{}) {
+		failures = append(failures, PasswordFailure{Requirement: requirement, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		fail(PasswordMinLength, "password must be at least %d characters", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		fail(PasswordMaxLength, "password must be at most %d characters", p.MaxLength)
+	} else if len(password) > bcryptMaxBytes {
+		fail(PasswordMaxLength, "password must be at most %d bytes", bcryptMaxBytes)
+	}
+
+	var hasLetter, hasNumber, hasUpper, hasSpecial bool
+	for _, c := range password {
+		switch {
+		case unicode.IsDigit(c):
+			hasNumber = true
+		case unicode.IsLetter(c):
+			hasLetter = true
+			if unicode.IsUpper(c) {
+				hasUpper = true
+			}
+		case !unicode.IsSpace(c):
+			hasSpecial = true
+		}
+	}
+	if p.RequireLetter && !hasLetter {
+		fail(PasswordLetter, "password must contain at least one letter")
+	}
+	if p.RequireNumber && !hasNumber {
+		fail(PasswordNumber, "password must contain at least one number")
+	}
+	if p.RequireUpper && !hasUpper {
+		fail(PasswordUpper, "password must contain at least one uppercase letter")
+	}
+	if p.RequireSpecial && !hasSpecial {
+		fail(PasswordSpecial, "password must contain at least one special character")
+	}
+	for _, common := range p.Disallowed {
+		if strings.EqualFold(password, common) {
+			fail(PasswordCommon, "password is too common")
+			break
+		}
+	}
+
+	if len(failures) > 0 {
+		return &PasswordPolicyError{Failures: failures}
+	}
+	return nil
+}
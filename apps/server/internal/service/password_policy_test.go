@@ -0,0 +1,102 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func failedRequirements(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("Expected a *PasswordPolicyError, got %v", err)
+	}
+	if !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("Expected the policy error to wrap ErrWeakPassword")
+	}
+	var reqs []string
+	for _, f := range policyErr.Failures {
+		reqs = append(reqs, f.Requirement)
+	}
+	return reqs
+}
+
+func TestDefaultPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		password string
+		want     string
+	}{
+		{"abcdefg1", ""},
+		{"abc1", PasswordMinLength},
+		{"abcdefgh", PasswordNumber},
+		{"12345678", PasswordLetter},
+		{"!!!", "min_length,letter,number"},
+		{strings.Repeat("a1", 40), PasswordMaxLength},
+	}
+	for _, tt := range tests {
+		got := strings.Join(failedRequirements(t, ValidatePasswordStrength(tt.password)), ",")
+		if got != tt.want {
+			t.Errorf("ValidatePasswordStrength(%q) failed %q, expected %q", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestPasswordPolicyReportsEveryFailure(t *testing.T) {
+	policy := PasswordPolicy{
+		MinLength:      12,
+		MaxLength:      16,
+		RequireLetter:  true,
+		RequireNumber:  true,
+		RequireUpper:   true,
+		RequireSpecial: true,
+		Disallowed:     []string{"password"},
+	}
+
+	got := failedRequirements(t, policy.Validate("PASSWORD"))
+	want := []string{PasswordMinLength, PasswordNumber, PasswordSpecial, PasswordCommon}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected failures %v, got %v", want, got)
+	}
+	err := policy.Validate("PASSWORD")
+	if msg := err.Error(); !strings.Contains(msg, "at least 12 characters") || !strings.Contains(msg, "too common") {
+		t.Errorf("Expected the error to list every failure, got %q", msg)
+	}
+
+	if got := failedRequirements(t, policy.Validate("correct-Horse-battery-1")); strings.Join(got, ",") != PasswordMaxLength {
+		t.Errorf("Expected only the max length to fail, got %v", got)
+	}
+	if err := policy.Validate("Correct-Horse-1"); err != nil {
+		t.Errorf("Expected a compliant password to pass, got %v", err)
+	}
+}
+
+func TestAuthServiceUsesConfiguredPasswordPolicy(t *testing.T) {
+	db := newTestDB(t)
+	policy := &PasswordPolicy{MinLength: 10, RequireUpper: true}
+	auth := NewAuthService(db, AuthConfig{JWTSecret: "signing-key", PasswordPolicy: policy}, zap.NewNop().Sugar())
+
+	if _, err := auth.RegisterUser("alice", "lowercase-only", ""); !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("Expected registration to enforce the configured policy, got %v", err)
+	}
+	// Digits are not required by this policy
+	user, err := auth.RegisterUser("alice", "Uppercase-only", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := auth.UpdatePassword(user.ID, "Short"); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("Expected password updates to enforce the policy, got %v", err)
+	}
+	if err := auth.VerifyPassword(user.ID, "Uppercase-only"); err != nil {
+		t.Errorf("Expected a rejected update to keep the old password, got %v", err)
+	}
+	if err := auth.UpdatePassword(user.ID, "Another-Passphrase"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,6 +3,8 @@ package service
 import (
 	"errors"
 	"fmt"
+	"path"
+	"strings"
 
 	"github.com/chenqi92/NanoLink/apps/server/internal/database"
 	"go.uber.org/zap"
@@ -13,6 +15,17 @@ import (
 type PermissionService struct {
 	db     *gorm.DB
 	logger *zap.SugaredLogger
+	audit  *AuditService
+	actor  AuditActor
+	cache  *PermissionCache
+	agents AgentLister
+}
+
+// AgentLister looks up the connected agents pattern grants are matched
+// against
+type AgentLister interface {
+	GetAgent(agentID string) *Agent
+	GetAllAgents() []*Agent
 }
 
 // NewPermissionService creates a new permission service
@@ -23,13 +36,141 @@ func NewPermissionService(db *gorm.DB, logger *zap.SugaredLogger) *PermissionSer
 	}
 }
 
+// SetAuditService records permission changes in the audit trail
+func (s *PermissionService) SetAuditService(audit *AuditService) {
+	s.audit = audit
+}
+
+// SetCache caches the grants access is resolved from. Every change made
+// through the service invalidates it.
+func (s *PermissionService) SetCache(cache *PermissionCache) {
+	s.cache = cache
+}
+
+// SetAgents sets where the hostnames and labels pattern grants are matched
+// against come from. Without it, hostname patterns only match agent IDs.
+func (s *PermissionService) SetAgents(agents AgentLister) {
+	s.agents = agents
+}
+
+// WithActor returns a view of the service that attributes the changes it
+// makes to actor in the audit trail
+func (s *PermissionService) WithActor(actor AuditActor) *PermissionService {
+	scoped := *s
+	scoped.actor = actor
+	return &scoped
+}
+
 // Permission errors
 var (
 	ErrAgentNotAssigned       = errors.New("agent not assigned to any group")
 	ErrPermissionNotFound     = errors.New("permission not found")
 	ErrInvalidPermissionLevel = errors.New("invalid permission level")
+	ErrInvalidCapabilities    = errors.New("invalid capabilities")
+	ErrInvalidAgentPattern    = errors.New("invalid agent pattern")
 )
 
+// AgentAccess is a user's effective access to an agent, merged from their
+// direct grant and all group grants
+type AgentAccess struct {
+	View    bool `json:"view"`
+	Control bool `json:"control"`
+	// ControlLevel is the highest permission level among grants that allow
+	// control, or -1 if none do
+	ControlLevel int `json:"controlLevel"`
+}
+
+func validCapabilities(caps int) bool {
+	return caps >= 0 && caps <= database.CapabilityAll
+}
+
+// AgentGrantType returns how the agent ID of a user grant is matched: as a
+// label selector when it has a "=", a hostname pattern when it has glob
+// characters, or as an agent ID
+func AgentGrantType(agentID string) string {
+	switch {
+	case strings.Contains(agentID, "="):
+		return database.GrantTypeLabels
+	case strings.ContainsAny(agentID, "*?["):
+		return database.GrantTypeHostname
+	default:
+		return database.GrantTypeAgent
+	}
+}
+
+// parseLabelSelector parses key=value pairs separated by commas
+func parseLabelSelector(selector string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(selector, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%w: %q is not key=value", ErrInvalidAgentPattern, pair)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// maxAgentPatternLength is the size of the column grants are stored in
+const maxAgentPatternLength = 255
+
+func validateAgentPattern(agentID string) error {
+	if len(agentID) > maxAgentPatternLength {
+		return fmt.Errorf("%w: longer than %d characters", ErrInvalidAgentPattern, maxAgentPatternLength)
+	}
+	switch AgentGrantType(agentID) {
+	case database.GrantTypeLabels:
+		_, err := parseLabelSelector(agentID)
+		return err
+	case database.GrantTypeHostname:
+		if _, err := path.Match(agentID, ""); err != nil {
+			return fmt.Errorf("%w: %q: %v", ErrInvalidAgentPattern, agentID, err)
+		}
+	}
+	return nil
+}
+
+// patternMatches reports whether a pattern grant covers an agent. agent is
+// nil when the agent is not connected, so only its ID is known.
+func patternMatches(perm database.UserAgentPermission, agentID string, agent *Agent) bool {
+	switch perm.GrantType {
+	case database.GrantTypeHostname:
+		pattern := strings.ToLower(perm.AgentID)
+		if ok, _ := path.Match(pattern, strings.ToLower(agentID)); ok {
+			return true
+		}
+		if agent == nil {
+			return false
+		}
+		ok, _ := path.Match(pattern, strings.ToLower(agent.Hostname))
+		return ok
+	case database.GrantTypeLabels:
+		if agent == nil {
+			return false
+		}
+		selector, err := parseLabelSelector(perm.AgentID)
+		if err != nil {
+			return false
+		}
+		for key, value := range selector {
+			if got, ok := agent.Labels[key]; !ok || got != value {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// connectedAgent returns the agent pattern grants are matched against, or
+// nil when it is not connected
+func (s *PermissionService) connectedAgent(agentID string) *Agent {
+	if s.agents == nil {
+		return nil
+	}
+	return s.agents.GetAgent(agentID)
+}
+
 // AssignAgentToGroup assigns an agent to a group with a permission level
 func (s *PermissionService) AssignAgentToGroup(agentID string, groupID uint, permissionLevel int) error {
 	if permissionLevel < 0 || permissionLevel > 3 {
@@ -49,8 +190,10 @@ func (s *PermissionService) AssignAgentToGroup(agentID string, groupID uint, per
 	var existing database.AgentGroup
 	err := s.db.Where("agent_id = ? AND group_id = ?", agentID, groupID).First(&existing).Error
 
+	change := PermissionChange{Action: AuditGroupAgentAssign, AgentID: agentID, GroupID: groupID, NewLevel: intPtr(permissionLevel)}
 	if err == nil {
 		// Update existing assignment
+		change.OldLevel = intPtr(existing.PermissionLevel)
 		existing.PermissionLevel = permissionLevel
 		if updateErr := s.db.Save(&existing).Error; updateErr != nil {
 			return fmt.Errorf("failed to update agent-group assignment: %w", updateErr)
@@ -69,12 +212,17 @@ func (s *PermissionService) AssignAgentToGroup(agentID string, groupID uint, per
 		return fmt.Errorf("database error: %w", err)
 	}
 
+	s.cache.InvalidateAll()
+	recordPermissionChange(s.audit, s.actor, change)
 	s.logger.Infof("Agent '%s' assigned to group '%s' with permission level %d", agentID, group.Name, permissionLevel)
 	return nil
 }
 
 // RemoveAgentFromGroup removes an agent from a group
 func (s *PermissionService) RemoveAgentFromGroup(agentID string, groupID uint) error {
+	var existing database.AgentGroup
+	found := s.db.Where("agent_id = ? AND group_id = ?", agentID, groupID).First(&existing).Error == nil
+
 	result := s.db.Where("agent_id = ? AND group_id = ?", agentID, groupID).Delete(&database.AgentGroup{})
 	if result.Error != nil {
 		return fmt.Errorf("failed to remove agent from group: %w", result.Error)
@@ -82,15 +230,55 @@ func (s *PermissionService) RemoveAgentFromGroup(agentID string, groupID uint) e
 	if result.RowsAffected == 0 {
 		return ErrAgentNotAssigned
 	}
+	s.cache.InvalidateAll()
+	change := PermissionChange{Action: AuditGroupAgentRemove, AgentID: agentID, GroupID: groupID}
+	if found {
+		change.OldLevel = intPtr(existing.PermissionLevel)
+	}
+	recordPermissionChange(s.audit, s.actor, change)
 	s.logger.Infof("Agent '%s' removed from group ID %d", agentID, groupID)
 	return nil
 }
 
-// SetUserAgentPermission sets a user's permission for a specific agent
+// SetAgentGroupCapabilities narrows what a group's grant for an agent allows
+// (0 restores view and control)
+func (s *PermissionService) SetAgentGroupCapabilities(agentID string, groupID uint, caps int) error {
+	if !validCapabilities(caps) {
+		return ErrInvalidCapabilities
+	}
+	var existing database.AgentGroup
+	found := s.db.Where("agent_id = ? AND group_id = ?", agentID, groupID).First(&existing).Error == nil
+
+	result := s.db.Model(&database.AgentGroup{}).
+		Where("agent_id = ? AND group_id = ?", agentID, groupID).
+		Update("capabilities", caps)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update capabilities: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrAgentNotAssigned
+	}
+	s.cache.InvalidateAll()
+	change := PermissionChange{Action: AuditGroupCapabilities, AgentID: agentID, GroupID: groupID, NewCapabilities: intPtr(caps)}
+	if found {
+		change.OldLevel = intPtr(existing.PermissionLevel)
+		change.NewLevel = intPtr(existing.PermissionLevel)
+		change.OldCapabilities = intPtr(existing.Capabilities)
+	}
+	recordPermissionChange(s.audit, s.actor, change)
+	s.logger.Infof("Agent '%s' group ID %d capabilities set to %d", agentID, groupID, caps)
+	return nil
+}
+
+// SetUserAgentPermission sets a user's permission for a specific agent, or
+// for every agent matching a pattern (see AgentGrantType)
 func (s *PermissionService) SetUserAgentPermission(userID uint, agentID string, permissionLevel int, grantedBy uint) error {
 	if permissionLevel < 0 || permissionLevel > 3 {
 		return ErrInvalidPermissionLevel
 	}
+	if err := validateAgentPattern(agentID); err != nil {
+		return err
+	}
 
 	// Check if user exists
 	var user database.User
@@ -105,8 +293,10 @@ func (s *PermissionService) SetUserAgentPermission(userID uint, agentID string,
 	var existing database.UserAgentPermission
 	err := s.db.Where("user_id = ? AND agent_id = ?", userID, agentID).First(&existing).Error
 
+	change := PermissionChange{Action: AuditPermissionGrant, AgentID: agentID, UserID: userID, NewLevel: intPtr(permissionLevel)}
 	if err == nil {
 		// Update existing permission
+		change.OldLevel = intPtr(existing.PermissionLevel)
 		existing.PermissionLevel = permissionLevel
 		existing.GrantedBy = grantedBy
 		if updateErr := s.db.Save(&existing).Error; updateErr != nil {
@@ -117,6 +307,7 @@ func (s *PermissionService) SetUserAgentPermission(userID uint, agentID string,
 		perm := &database.UserAgentPermission{
 			UserID:          userID,
 			AgentID:         agentID,
+			GrantType:       AgentGrantType(agentID),
 			PermissionLevel: permissionLevel,
 			GrantedBy:       grantedBy,
 		}
@@ -127,62 +318,242 @@ func (s *PermissionService) SetUserAgentPermission(userID uint, agentID string,
 		return fmt.Errorf("database error: %w", err)
 	}
 
+	s.cache.InvalidateUser(userID)
+	recordPermissionChange(s.audit, s.actor, change)
 	s.logger.Infof("User ID %d granted permission level %d for agent '%s'", userID, permissionLevel, agentID)
 	return nil
 }
 
 // RemoveUserAgentPermission removes a user's permission for an agent
 func (s *PermissionService) RemoveUserAgentPermission(userID uint, agentID string) error {
+	var existing database.UserAgentPermission
+	found := s.db.Where("user_id = ? AND agent_id = ?", userID, agentID).First(&existing).Error == nil
+
 	result := s.db.Where("user_id = ? AND agent_id = ?", userID, agentID).Delete(&database.UserAgentPermission{})
 	if result.Error != nil {
 		return fmt.Errorf("failed to remove permission: %w", result.Error)
 	}
+	if result.RowsAffected > 0 {
+		s.cache.InvalidateUser(userID)
+		change := PermissionChange{Action: AuditPermissionRevoke, AgentID: agentID, UserID: userID}
+		if found {
+			change.OldLevel = intPtr(existing.PermissionLevel)
+		}
+		recordPermissionChange(s.audit, s.actor, change)
+	}
 	return nil
 }
 
-// GetUserAgentPermission returns a user's permission level for an agent
-// Returns the highest permission level from: direct assignment, or group membership
-func (s *PermissionService) GetUserAgentPermission(userID uint, agentID string) (int, error) {
+// SetUserAgentCapabilities narrows what a user's direct grant for an agent
+// allows (0 restores view and control)
+func (s *PermissionService) SetUserAgentCapabilities(userID uint, agentID string, caps int) error {
+	if !validCapabilities(caps) {
+		return ErrInvalidCapabilities
+	}
+	var existing database.UserAgentPermission
+	found := s.db.Where("user_id = ? AND agent_id = ?", userID, agentID).First(&existing).Error == nil
+
+	result := s.db.Model(&database.UserAgentPermission{}).
+		Where("user_id = ? AND agent_id = ?", userID, agentID).
+		Update("capabilities", caps)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update capabilities: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrPermissionNotFound
+	}
+	s.cache.InvalidateUser(userID)
+	change := PermissionChange{Action: AuditPermissionCapabilities, AgentID: agentID, UserID: userID, NewCapabilities: intPtr(caps)}
+	if found {
+		change.OldLevel = intPtr(existing.PermissionLevel)
+		change.NewLevel = intPtr(existing.PermissionLevel)
+		change.OldCapabilities = intPtr(existing.Capabilities)
+	}
+	recordPermissionChange(s.audit, s.actor, change)
+	s.logger.Infof("User ID %d capabilities for agent '%s' set to %d", userID, agentID, caps)
+	return nil
+}
+
+// userGrants loads what a user's access to agents is resolved from, from
+// the cache when it holds them
+func (s *PermissionService) userGrants(userID uint) (*userGrants, error) {
+	if grants, ok := s.cache.grants(userID); ok {
+		return grants, nil
+	}
+	generation := s.cache.begin()
+
 	var user database.User
 	if err := s.db.Preload("Groups").First(&user, userID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return -1, ErrUserNotFound
+			return nil, ErrUserNotFound
 		}
-		return -1, fmt.Errorf("database error: %w", err)
+		return nil, fmt.Errorf("database error: %w", err)
 	}
 
-	// Super admin has max permission
-	if user.IsSuperAdmin {
-		// Get the agent's max granted permission from any group
-		var maxPerm int
-		err := s.db.Model(&database.AgentGroup{}).
-			Where("agent_id = ?", agentID).
-			Select("COALESCE(MAX(permission_level), 3)").
-			Scan(&maxPerm).Error
-		if err != nil {
-			return 3, nil // Default to system admin for superadmin
+	// Super admins are not limited by grants
+	grants := &userGrants{superAdmin: user.IsSuperAdmin}
+	if !user.IsSuperAdmin {
+		var direct []database.UserAgentPermission
+		if err := s.db.Where("user_id = ?", userID).Find(&direct).Error; err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+		for _, perm := range direct {
+			if perm.GrantType == "" || perm.GrantType == database.GrantTypeAgent {
+				grants.direct = append(grants.direct, perm)
+			} else {
+				grants.patterns = append(grants.patterns, perm)
+			}
+		}
+		if len(user.Groups) > 0 {
+			groupIDs := make([]uint, len(user.Groups))
+			for i, group := range user.Groups {
+				groupIDs[i] = group.ID
+			}
+			if err := s.db.Where("group_id IN ?", groupIDs).Find(&grants.groups).Error; err != nil {
+				return nil, fmt.Errorf("database error: %w", err)
+			}
+		}
+	}
+
+	s.cache.putGrants(userID, grants, generation)
+	return grants, nil
+}
+
+// agentGroupLevel returns the highest level any group is granted for an
+// agent
+func (s *PermissionService) agentGroupLevel(agentID string) (groupLevel, error) {
+	if level, ok := s.cache.agentLevel(agentID); ok {
+		return level, nil
+	}
+	generation := s.cache.begin()
+
+	var row struct {
+		MaxLevel   int
+		GroupCount int64
+	}
+	err := s.db.Model(&database.AgentGroup{}).
+		Where("agent_id = ?", agentID).
+		Select("COALESCE(MAX(permission_level), 0) AS max_level, COUNT(*) AS group_count").
+		Scan(&row).Error
+	if err != nil {
+		return groupLevel{}, fmt.Errorf("database error: %w", err)
+	}
+	level := groupLevel{level: row.MaxLevel, grouped: row.GroupCount > 0}
+	s.cache.putAgentLevel(agentID, level, generation)
+	return level, nil
+}
+
+// agentMaxGroupLevel returns the highest level any group is granted for an
+// agent, or 3 when it is in no group
+func (s *PermissionService) agentMaxGroupLevel(agentID string) int {
+	level, err := s.agentGroupLevel(agentID)
+	if err != nil || !level.grouped {
+		return 3 // Default to system admin for superadmin
+	}
+	return level.level
+}
+
+// patternGrantLevel clamps the level of a pattern grant to the most a
+// super admin could grant for the agent it matched, as GetAgentMaxPermissionLevel
+// bounds exact grants when they are made: a pattern also matches agents
+// that connect later, and the labels it selects by are reported by the
+// agents themselves
+func (s *PermissionService) patternGrantLevel(level int, agentID string) int {
+	ceiling, err := s.agentGroupLevel(agentID)
+	if err != nil {
+		return database.PermissionReadOnly
+	}
+	return min(level, ceiling.level)
+}
+
+// GetUserAgentAccess returns a user's effective view and control access to
+// an agent. Super admins can always view and control.
+func (s *PermissionService) GetUserAgentAccess(userID uint, agentID string) (*AgentAccess, error) {
+	grants, err := s.userGrants(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if grants.superAdmin {
+		return &AgentAccess{View: true, Control: true, ControlLevel: s.agentMaxGroupLevel(agentID)}, nil
+	}
+
+	access := &AgentAccess{ControlLevel: -1}
+	grant := func(level, caps int) {
+		caps = database.EffectiveCapabilities(caps)
+		if caps&database.CapabilityView != 0 {
+			access.View = true
+		}
+		if caps&database.CapabilityControl != 0 {
+			access.Control = true
+			if level > access.ControlLevel {
+				access.ControlLevel = level
+			}
 		}
-		return maxPerm, nil
+	}
+
+	for _, perm := range grants.direct {
+		if perm.AgentID == agentID {
+			grant(perm.PermissionLevel, perm.Capabilities)
+		}
+	}
+	if len(grants.patterns) > 0 {
+		agent := s.connectedAgent(agentID)
+		for _, perm := range grants.patterns {
+			if patternMatches(perm, agentID, agent) {
+				grant(s.patternGrantLevel(perm.PermissionLevel, agentID), perm.Capabilities)
+			}
+		}
+	}
+	for _, agentGroup := range grants.groups {
+		if agentGroup.AgentID == agentID {
+			grant(agentGroup.PermissionLevel, agentGroup.Capabilities)
+		}
+	}
+
+	return access, nil
+}
+
+// GetUserAgentPermission returns a user's permission level for an agent
+// Returns the highest permission level from: direct assignment, matching
+// pattern grants, or group membership
+func (s *PermissionService) GetUserAgentPermission(userID uint, agentID string) (int, error) {
+	grants, err := s.userGrants(userID)
+	if err != nil {
+		return -1, err
+	}
+
+	// Super admin has the agent's max granted permission from any group
+	if grants.superAdmin {
+		return s.agentMaxGroupLevel(agentID), nil
 	}
 
 	maxPermission := -1
 
 	// Check direct user-agent permission
-	var directPerm database.UserAgentPermission
-	if err := s.db.Where("user_id = ? AND agent_id = ?", userID, agentID).First(&directPerm).Error; err == nil {
-		maxPermission = directPerm.PermissionLevel
+	for _, perm := range grants.direct {
+		if perm.AgentID == agentID && perm.PermissionLevel > maxPermission {
+			maxPermission = perm.PermissionLevel
+		}
 	}
 
-	// Check group-based permissions
-	for _, group := range user.Groups {
-		var agentGroup database.AgentGroup
-		if err := s.db.Where("agent_id = ? AND group_id = ?", agentID, group.ID).First(&agentGroup).Error; err == nil {
-			if agentGroup.PermissionLevel > maxPermission {
-				maxPermission = agentGroup.PermissionLevel
+	// Check pattern grants
+	if len(grants.patterns) > 0 {
+		agent := s.connectedAgent(agentID)
+		for _, perm := range grants.patterns {
+			if perm.PermissionLevel > maxPermission && patternMatches(perm, agentID, agent) {
+				maxPermission = max(maxPermission, s.patternGrantLevel(perm.PermissionLevel, agentID))
 			}
 		}
 	}
 
+	// Check group-based permissions
+	for _, agentGroup := range grants.groups {
+		if agentGroup.AgentID == agentID && agentGroup.PermissionLevel > maxPermission {
+			maxPermission = agentGroup.PermissionLevel
+		}
+	}
+
 	if maxPermission == -1 {
 		return -1, ErrPermissionDenied
 	}
@@ -190,41 +561,48 @@ func (s *PermissionService) GetUserAgentPermission(userID uint, agentID string)
 	return maxPermission, nil
 }
 
-// GetVisibleAgents returns a list of agent IDs that a user can see
+// GetVisibleAgents returns a list of agent IDs that a user can see. Grants
+// without the view capability do not make an agent visible. Pattern grants
+// make the connected agents they match visible.
 func (s *PermissionService) GetVisibleAgents(userID uint) ([]string, error) {
-	var user database.User
-	if err := s.db.Preload("Groups").First(&user, userID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, ErrUserNotFound
-		}
-		return nil, fmt.Errorf("database error: %w", err)
+	grants, err := s.userGrants(userID)
+	if err != nil {
+		return nil, err
 	}
 
 	// Super admin can see all agents
-	if user.IsSuperAdmin {
+	if grants.superAdmin {
 		return nil, nil // nil means "all agents"
 	}
 
 	agentMap := make(map[string]bool)
 
 	// Get agents from user's groups
-	for _, group := range user.Groups {
-		var agentGroups []database.AgentGroup
-		if err := s.db.Where("group_id = ?", group.ID).Find(&agentGroups).Error; err == nil {
-			for _, ag := range agentGroups {
-				agentMap[ag.AgentID] = true
-			}
+	for _, ag := range grants.groups {
+		if database.EffectiveCapabilities(ag.Capabilities)&database.CapabilityView != 0 {
+			agentMap[ag.AgentID] = true
 		}
 	}
 
 	// Get agents from direct permissions
-	var directPerms []database.UserAgentPermission
-	if err := s.db.Where("user_id = ?", userID).Find(&directPerms).Error; err == nil {
-		for _, perm := range directPerms {
+	for _, perm := range grants.direct {
+		if database.EffectiveCapabilities(perm.Capabilities)&database.CapabilityView != 0 {
 			agentMap[perm.AgentID] = true
 		}
 	}
 
+	// Get connected agents matching pattern grants
+	if len(grants.patterns) > 0 && s.agents != nil {
+		for _, agent := range s.agents.GetAllAgents() {
+			for _, perm := range grants.patterns {
+				if database.EffectiveCapabilities(perm.Capabilities)&database.CapabilityView != 0 && patternMatches(perm, agent.ID, agent) {
+					agentMap[agent.ID] = true
+					break
+				}
+			}
+		}
+	}
+
 	agents := make([]string, 0, len(agentMap))
 	for agentID := range agentMap {
 		agents = append(agents, agentID)
@@ -233,28 +611,27 @@ func (s *PermissionService) GetVisibleAgents(userID uint) ([]string, error) {
 	return agents, nil
 }
 
-// CanUserAccessAgent checks if a user can access a specific agent
+// CanUserAccessAgent checks if a user can view a specific agent
 func (s *PermissionService) CanUserAccessAgent(userID uint, agentID string) (bool, error) {
-	perm, err := s.GetUserAgentPermission(userID, agentID)
+	access, err := s.GetUserAgentAccess(userID, agentID)
 	if err != nil {
-		if errors.Is(err, ErrPermissionDenied) {
-			return false, nil
-		}
 		return false, err
 	}
-	return perm >= 0, nil
+	return access.View, nil
 }
 
-// CanUserExecuteCommand checks if a user has sufficient permission to execute a command
+// CanUserExecuteCommand checks if a user has sufficient permission to execute a command.
+// Read-only operations (level 0) need the view capability; anything above
+// needs the control capability on a grant of at least the required level.
 func (s *PermissionService) CanUserExecuteCommand(userID uint, agentID string, requiredLevel int) (bool, error) {
-	perm, err := s.GetUserAgentPermission(userID, agentID)
+	access, err := s.GetUserAgentAccess(userID, agentID)
 	if err != nil {
-		if errors.Is(err, ErrPermissionDenied) {
-			return false, nil
-		}
 		return false, err
 	}
-	return perm >= requiredLevel, nil
+	if requiredLevel <= database.PermissionReadOnly {
+		return access.View, nil
+	}
+	return access.Control && access.ControlLevel >= requiredLevel, nil
 }
 
 // GetAgentGroups returns all groups an agent is assigned to
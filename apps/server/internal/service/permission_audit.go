@@ -0,0 +1,228 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+)
+
+// Permission change actions, recorded as the audit log command type
+const (
+	AuditPermissionGrant        = "PERMISSION_GRANT"
+	AuditPermissionRevoke       = "PERMISSION_REVOKE"
+	AuditPermissionCapabilities = "PERMISSION_CAPABILITIES"
+	AuditGroupAgentAssign       = "GROUP_AGENT_ASSIGN"
+	AuditGroupAgentRemove       = "GROUP_AGENT_REMOVE"
+	AuditGroupCapabilities      = "GROUP_CAPABILITIES"
+	AuditGroupMemberAdd         = "GROUP_MEMBER_ADD"
+	AuditGroupMemberRemove      = "GROUP_MEMBER_REMOVE"
+)
+
+var permissionAuditTypes = []string{
+	AuditPermissionGrant,
+	AuditPermissionRevoke,
+	AuditPermissionCapabilities,
+	AuditGroupAgentAssign,
+	AuditGroupAgentRemove,
+	AuditGroupCapabilities,
+	AuditGroupMemberAdd,
+	AuditGroupMemberRemove,
+}
+
+// AuditActor identifies who made a change. The zero value is the server
+// itself (startup seeding, config restores).
+type AuditActor struct {
+	UserID    uint
+	Username  string
+	IPAddress string
+}
+
+// PermissionChange is one audited change to who can see or control what.
+// Levels and capabilities are nil where there was no grant before or after.
+type PermissionChange struct {
+	ID              uint      `json:"id"`
+	Timestamp       time.Time `json:"timestamp"`
+	Action          string    `json:"action"`
+	ActorID         uint      `json:"actorId"`
+	ActorName       string    `json:"actorName"`
+	IPAddress       string    `json:"ipAddress,omitempty"`
+	AgentID         string    `json:"agentId,omitempty"`
+	UserID          uint      `json:"userId,omitempty"`
+	GroupID         uint      `json:"groupId,omitempty"`
+	OldLevel        *int      `json:"oldLevel"`
+	NewLevel        *int      `json:"newLevel"`
+	OldCapabilities *int      `json:"oldCapabilities,omitempty"`
+	NewCapabilities *int      `json:"newCapabilities,omitempty"`
+}
+
+// permissionChangeParams is the part of a change stored in the audit log params
+type permissionChangeParams struct {
+	UserID          uint `json:"userId,omitempty"`
+	GroupID         uint `json:"groupId,omitempty"`
+	OldLevel        *int `json:"oldLevel,omitempty"`
+	NewLevel        *int `json:"newLevel,omitempty"`
+	OldCapabilities *int `json:"oldCapabilities,omitempty"`
+	NewCapabilities *int `json:"newCapabilities,omitempty"`
+}
+
+// PermissionChangeQuery filters permission changes
+type PermissionChangeQuery struct {
+	ActorID   uint
+	AgentID   string
+	Action    string
+	StartTime *time.Time
+	EndTime   *time.Time
+	Limit     int
+	Offset    int
+}
+
+// PermissionChangeResult contains paginated permission changes
+type PermissionChangeResult struct {
+	Changes []PermissionChange `json:"changes"`
+	Total   int64              `json:"total"`
+	Limit   int                `json:"limit"`
+	Offset  int                `json:"offset"`
+	HasMore bool               `json:"hasMore"`
+}
+
+// LogPermissionChange records a permission change in the audit trail
+func (s *AuditService) LogPermissionChange(change PermissionChange) error {
+	params, err := json.Marshal(permissionChangeParams{
+		UserID:          change.UserID,
+		GroupID:         change.GroupID,
+		OldLevel:        change.OldLevel,
+		NewLevel:        change.NewLevel,
+		OldCapabilities: change.OldCapabilities,
+		NewCapabilities: change.NewCapabilities,
+	})
+	if err != nil {
+		return err
+	}
+
+	timestamp := change.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	auditLog := &database.AuditLog{
+		Timestamp:   timestamp,
+		UserID:      change.ActorID,
+		Username:    change.ActorName,
+		AgentID:     change.AgentID,
+		CommandType: change.Action,
+		Target:      permissionChangeTarget(change),
+		Params:      string(params),
+		Success:     true,
+		IPAddress:   change.IPAddress,
+	}
+	if err := s.db.Create(auditLog).Error; err != nil {
+		s.logger.Errorf("Failed to create permission audit log: %v", err)
+		return err
+	}
+	return nil
+}
+
+// QueryPermissionChanges returns audited permission changes, newest first
+func (s *AuditService) QueryPermissionChanges(query PermissionChangeQuery) (*PermissionChangeResult, error) {
+	if query.Limit <= 0 || query.Limit > 1000 {
+		query.Limit = 100
+	}
+
+	db := s.db.Model(&database.AuditLog{}).Where("command_type IN ?", permissionAuditTypes)
+	if query.ActorID > 0 {
+		db = db.Where("user_id = ?", query.ActorID)
+	}
+	if query.AgentID != "" {
+		db = db.Where("agent_id = ?", query.AgentID)
+	}
+	if query.Action != "" {
+		db = db.Where("command_type = ?", query.Action)
+	}
+	if query.StartTime != nil {
+		db = db.Where("timestamp >= ?", *query.StartTime)
+	}
+	if query.EndTime != nil {
+		db = db.Where("timestamp <= ?", *query.EndTime)
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	var logs []database.AuditLog
+	if err := db.Order("timestamp DESC, id DESC").
+		Offset(query.Offset).
+		Limit(query.Limit).
+		Find(&logs).Error; err != nil {
+		return nil, err
+	}
+
+	changes := make([]PermissionChange, 0, len(logs))
+	for _, log := range logs {
+		change := PermissionChange{
+			ID:        log.ID,
+			Timestamp: log.Timestamp,
+			Action:    log.CommandType,
+			ActorID:   log.UserID,
+			ActorName: log.Username,
+			IPAddress: log.IPAddress,
+			AgentID:   log.AgentID,
+		}
+		var params permissionChangeParams
+		if log.Params != "" {
+			if err := json.Unmarshal([]byte(log.Params), &params); err != nil {
+				s.logger.Warnf("Malformed permission audit params in log %d: %v", log.ID, err)
+			}
+		}
+		change.UserID = params.UserID
+		change.GroupID = params.GroupID
+		change.OldLevel = params.OldLevel
+		change.NewLevel = params.NewLevel
+		change.OldCapabilities = params.OldCapabilities
+		change.NewCapabilities = params.NewCapabilities
+		changes = append(changes, change)
+	}
+
+	return &PermissionChangeResult{
+		Changes: changes,
+		Total:   total,
+		Limit:   query.Limit,
+		Offset:  query.Offset,
+		HasMore: int64(query.Offset+len(logs)) < total,
+	}, nil
+}
+
+func permissionChangeTarget(change PermissionChange) string {
+	switch {
+	case change.UserID != 0 && change.GroupID != 0:
+		return fmt.Sprintf("user:%d group:%d", change.UserID, change.GroupID)
+	case change.UserID != 0:
+		return fmt.Sprintf("user:%d", change.UserID)
+	case change.GroupID != 0:
+		return fmt.Sprintf("group:%d", change.GroupID)
+	}
+	return ""
+}
+
+// recordPermissionChange stamps a change with the actor and writes it to the
+// audit trail. Audit failures are logged but never undo the change.
+func recordPermissionChange(audit *AuditService, actor AuditActor, change PermissionChange) {
+	if audit == nil {
+		return
+	}
+	change.ActorID = actor.UserID
+	change.ActorName = actor.Username
+	change.IPAddress = actor.IPAddress
+	if change.ActorName == "" && change.ActorID == 0 {
+		change.ActorName = "system"
+	}
+	if err := audit.LogPermissionChange(change); err != nil {
+		audit.logger.Warnf("Failed to audit %s: %v", change.Action, err)
+	}
+}
+
+func intPtr(v int) *int {
+	return &v
+}
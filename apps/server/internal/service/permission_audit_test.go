@@ -0,0 +1,132 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+)
+
+func levelOf(p *int) int {
+	if p == nil {
+		return -1
+	}
+	return *p
+}
+
+func TestPermissionGrantAndRevokeAreAudited(t *testing.T) {
+	db, perms, user, _ := newPermissionFixture(t)
+	audit := NewAuditService(db, zap.NewNop().Sugar())
+	perms.SetAuditService(audit)
+
+	actor := AuditActor{UserID: 42, Username: "root", IPAddress: "10.0.0.5"}
+	scoped := perms.WithActor(actor)
+
+	if err := scoped.SetUserAgentPermission(user.ID, "agent-1", database.PermissionReadOnly, actor.UserID); err != nil {
+		t.Fatal(err)
+	}
+	if err := scoped.SetUserAgentPermission(user.ID, "agent-1", database.PermissionServiceControl, actor.UserID); err != nil {
+		t.Fatal(err)
+	}
+	if err := scoped.RemoveUserAgentPermission(user.ID, "agent-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := audit.QueryPermissionChanges(PermissionChangeQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 3 {
+		t.Fatalf("Expected 3 permission changes, got %d", result.Total)
+	}
+
+	// Newest first: revoke, upgrade, initial grant
+	expected := []struct {
+		action   string
+		old, new int
+	}{
+		{AuditPermissionRevoke, database.PermissionServiceControl, -1},
+		{AuditPermissionGrant, database.PermissionReadOnly, database.PermissionServiceControl},
+		{AuditPermissionGrant, -1, database.PermissionReadOnly},
+	}
+	for i, want := range expected {
+		got := result.Changes[i]
+		if got.Action != want.action {
+			t.Errorf("Change %d: expected action %s, got %s", i, want.action, got.Action)
+		}
+		if levelOf(got.OldLevel) != want.old || levelOf(got.NewLevel) != want.new {
+			t.Errorf("Change %d: expected levels %d -> %d, got %d -> %d",
+				i, want.old, want.new, levelOf(got.OldLevel), levelOf(got.NewLevel))
+		}
+		if got.ActorID != actor.UserID || got.ActorName != "root" || got.IPAddress != "10.0.0.5" {
+			t.Errorf("Change %d: expected actor root (42) from 10.0.0.5, got %s (%d) from %s",
+				i, got.ActorName, got.ActorID, got.IPAddress)
+		}
+		if got.UserID != user.ID || got.AgentID != "agent-1" {
+			t.Errorf("Change %d: expected target user %d on agent-1, got user %d on %s",
+				i, user.ID, got.UserID, got.AgentID)
+		}
+		if got.Timestamp.IsZero() {
+			t.Errorf("Change %d: expected a timestamp", i)
+		}
+	}
+}
+
+func TestRevokingMissingPermissionIsNotAudited(t *testing.T) {
+	db, perms, user, _ := newPermissionFixture(t)
+	audit := NewAuditService(db, zap.NewNop().Sugar())
+	perms.SetAuditService(audit)
+
+	if err := perms.RemoveUserAgentPermission(user.ID, "agent-9"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := audit.QueryPermissionChanges(PermissionChangeQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 0 {
+		t.Errorf("Expected no permission changes, got %d", result.Total)
+	}
+}
+
+func TestGroupChangesAreAudited(t *testing.T) {
+	db, perms, user, group := newPermissionFixture(t)
+	logger := zap.NewNop().Sugar()
+	audit := NewAuditService(db, logger)
+	perms.SetAuditService(audit)
+	groups := NewGroupService(db, logger)
+	groups.SetAuditService(audit)
+
+	if err := perms.AssignAgentToGroup("agent-1", group.ID, database.PermissionBasicWrite); err != nil {
+		t.Fatal(err)
+	}
+	if err := perms.RemoveAgentFromGroup("agent-1", group.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := groups.WithActor(AuditActor{UserID: 7, Username: "ops"}).RemoveUserFromGroup(user.ID, group.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := audit.QueryPermissionChanges(PermissionChangeQuery{Action: AuditGroupAgentRemove})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 1 {
+		t.Fatalf("Expected 1 group removal, got %d", result.Total)
+	}
+	removal := result.Changes[0]
+	if levelOf(removal.OldLevel) != database.PermissionBasicWrite || removal.NewLevel != nil {
+		t.Errorf("Expected levels %d -> none, got %d -> %d", database.PermissionBasicWrite, levelOf(removal.OldLevel), levelOf(removal.NewLevel))
+	}
+	if removal.ActorName != "system" || removal.GroupID != group.ID {
+		t.Errorf("Expected a system change on group %d, got %s on group %d", group.ID, removal.ActorName, removal.GroupID)
+	}
+
+	result, err = audit.QueryPermissionChanges(PermissionChangeQuery{ActorID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 1 || result.Changes[0].Action != AuditGroupMemberRemove || result.Changes[0].UserID != user.ID {
+		t.Errorf("Expected one membership removal by ops, got %+v", result.Changes)
+	}
+}
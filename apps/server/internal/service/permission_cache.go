@@ -0,0 +1,148 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+)
+
+// PermissionCache keeps the grants users' agent access is resolved from for
+// a short time, so authorizing a request does not query the database each
+// time. Anything that changes users, groups or grants must invalidate it;
+// a nil cache caches nothing.
+type PermissionCache struct {
+	ttl   time.Duration
+	clock Clock
+
+	mu sync.Mutex
+	// Bumped by every invalidation, so a load that raced one is not stored
+	generation  uint64
+	users       map[uint]cachedGrants
+	agentLevels map[string]cachedLevel
+}
+
+// userGrants is what a user's access to agents is resolved from
+type userGrants struct {
+	superAdmin bool
+	direct     []database.UserAgentPermission // Grants for exact agent IDs
+	patterns   []database.UserAgentPermission // Grants for hostname patterns and label selectors
+	groups     []database.AgentGroup          // Grants of the groups the user is in
+}
+
+type cachedGrants struct {
+	grants  *userGrants
+	expires time.Time
+}
+
+// groupLevel is the highest level any group is granted for an agent, which
+// bounds what users can be granted and is what super admins get
+type groupLevel struct {
+	level   int
+	grouped bool // Whether the agent is in any group
+}
+
+type cachedLevel struct {
+	level   groupLevel
+	expires time.Time
+}
+
+// NewPermissionCache creates a cache holding entries for ttl; a ttl of 0
+// disables caching
+func NewPermissionCache(ttl time.Duration) *PermissionCache {
+	return &PermissionCache{
+		ttl:         ttl,
+		clock:       RealClock,
+		users:       make(map[uint]cachedGrants),
+		agentLevels: make(map[string]cachedLevel),
+	}
+}
+
+// SetClock replaces the clock entries expire by. Intended for tests.
+func (c *PermissionCache) SetClock(clock Clock) {
+	c.clock = clock
+}
+
+// InvalidateUser drops what is cached for a user, after their group
+// membership, direct grants or account changed
+func (c *PermissionCache) InvalidateUser(userID uint) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	delete(c.users, userID)
+}
+
+// InvalidateAll drops everything cached, after a change that can affect
+// many users such as an agent's group grants
+func (c *PermissionCache) InvalidateAll() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	clear(c.users)
+	clear(c.agentLevels)
+}
+
+// begin returns the generation to pass to the put after loading from the
+// database
+func (c *PermissionCache) begin() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+func (c *PermissionCache) grants(userID uint) (*userGrants, bool) {
+	if c == nil || c.ttl <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.users[userID]
+	if !ok || !c.clock.Now().Before(entry.expires) {
+		return nil, false
+	}
+	return entry.grants, true
+}
+
+func (c *PermissionCache) putGrants(userID uint, grants *userGrants, generation uint64) {
+	if c == nil || c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation == c.generation {
+		c.users[userID] = cachedGrants{grants: grants, expires: c.clock.Now().Add(c.ttl)}
+	}
+}
+
+func (c *PermissionCache) agentLevel(agentID string) (groupLevel, bool) {
+	if c == nil || c.ttl <= 0 {
+		return groupLevel{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.agentLevels[agentID]
+	if !ok || !c.clock.Now().Before(entry.expires) {
+		return groupLevel{}, false
+	}
+	return entry.level, true
+}
+
+func (c *PermissionCache) putAgentLevel(agentID string, level groupLevel, generation uint64) {
+	if c == nil || c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation == c.generation {
+		c.agentLevels[agentID] = cachedLevel{level: level, expires: c.clock.Now().Add(c.ttl)}
+	}
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// countQueries counts the queries run on db from now on
+func countQueries(tb testing.TB, db *gorm.DB) *atomic.Int64 {
+	tb.Helper()
+	var n atomic.Int64
+	count := func(*gorm.DB) { n.Add(1) }
+	if err := db.Callback().Query().After("gorm:query").Register("test:count_queries", count); err != nil {
+		tb.Fatal(err)
+	}
+	if err := db.Callback().Row().After("gorm:row").Register("test:count_rows", count); err != nil {
+		tb.Fatal(err)
+	}
+	return &n
+}
+
+func TestPermissionCacheServesRepeatedLookups(t *testing.T) {
+	db, perms, user, group := newPermissionFixture(t)
+	if err := perms.AssignAgentToGroup("agent-1", group.ID, database.PermissionBasicWrite); err != nil {
+		t.Fatal(err)
+	}
+	perms.SetCache(NewPermissionCache(time.Minute))
+	queries := countQueries(t, db)
+
+	var loaded int64
+	for i := range 3 {
+		if visible, err := perms.GetVisibleAgents(user.ID); err != nil || !slices.Equal(visible, []string{"agent-1"}) {
+			t.Fatalf("Expected agent-1 to be visible, got %v %v", visible, err)
+		}
+		if level, err := perms.GetUserAgentPermission(user.ID, "agent-1"); err != nil || level != database.PermissionBasicWrite {
+			t.Fatalf("Expected level 1, got %d %v", level, err)
+		}
+		if ok, _ := perms.CanUserExecuteCommand(user.ID, "agent-1", database.PermissionBasicWrite); !ok {
+			t.Fatal("Expected commands to be allowed")
+		}
+		if i == 0 {
+			loaded = queries.Load()
+		}
+	}
+	if n := queries.Load(); loaded == 0 || n != loaded {
+		t.Errorf("Expected the grants to be loaded once in %d queries, got %d", loaded, n)
+	}
+}
+
+func TestPermissionCacheInvalidatedByChanges(t *testing.T) {
+	db, perms, user, group := newPermissionFixture(t)
+	cache := NewPermissionCache(time.Hour)
+	perms.SetCache(cache)
+	groups := NewGroupService(db, zap.NewNop().Sugar())
+	groups.SetPermissionCache(cache)
+
+	visible := func() []string {
+		t.Helper()
+		agents, err := perms.GetVisibleAgents(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(agents)
+		return agents
+	}
+
+	if got := visible(); len(got) != 0 {
+		t.Fatalf("Expected nothing visible yet, got %v", got)
+	}
+	if err := perms.SetUserAgentPermission(user.ID, "agent-1", database.PermissionReadOnly, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := visible(); !slices.Equal(got, []string{"agent-1"}) {
+		t.Errorf("Expected a direct grant to show at once, got %v", got)
+	}
+	if err := perms.AssignAgentToGroup("agent-2", group.ID, database.PermissionReadOnly); err != nil {
+		t.Fatal(err)
+	}
+	if got := visible(); !slices.Equal(got, []string{"agent-1", "agent-2"}) {
+		t.Errorf("Expected a group grant to show at once, got %v", got)
+	}
+	if err := groups.RemoveUserFromGroup(user.ID, group.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := visible(); !slices.Equal(got, []string{"agent-1"}) {
+		t.Errorf("Expected leaving the group to hide its agents at once, got %v", got)
+	}
+	if err := perms.RemoveUserAgentPermission(user.ID, "agent-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := visible(); len(got) != 0 {
+		t.Errorf("Expected revoking the grant to hide the agent at once, got %v", got)
+	}
+}
+
+func TestPermissionCacheExpires(t *testing.T) {
+	db, perms, user, group := newPermissionFixture(t)
+	cache := NewPermissionCache(30 * time.Second)
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	cache.SetClock(clock)
+	perms.SetCache(cache)
+
+	if _, err := perms.GetVisibleAgents(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	// Changes made behind the service's back show once the entry expires
+	if err := db.Create(&database.AgentGroup{AgentID: "agent-1", GroupID: group.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := perms.GetVisibleAgents(user.ID); len(got) != 0 {
+		t.Errorf("Expected the cached result within the TTL, got %v", got)
+	}
+	clock.Advance(31 * time.Second)
+	if got, _ := perms.GetVisibleAgents(user.ID); !slices.Equal(got, []string{"agent-1"}) {
+		t.Errorf("Expected a fresh result after the TTL, got %v", got)
+	}
+}
+
+// BenchmarkGetVisibleAgents compares the database queries made to
+// authorize a dashboard request with and without the cache
+func BenchmarkGetVisibleAgents(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		ttl  time.Duration
+	}{
+		{"uncached", 0},
+		{"cached", 30 * time.Second},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			db, perms, user, group := newPermissionFixture(b)
+			for i := range 20 {
+				if err := perms.AssignAgentToGroup(fmt.Sprintf("agent-%d", i), group.ID, database.PermissionReadOnly); err != nil {
+					b.Fatal(err)
+				}
+			}
+			perms.SetCache(NewPermissionCache(bc.ttl))
+			queries := countQueries(b, db)
+
+			b.ResetTimer()
+			for range b.N {
+				if _, err := perms.GetVisibleAgents(user.ID); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := perms.CanUserAccessAgent(user.ID, "agent-0"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(queries.Load())/float64(b.N), "queries/op")
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func newPermissionFixture(t testing.TB) (*gorm.DB, *PermissionService, *database.User, *database.Group) {
+	t.Helper()
+	db := newTestDB(t)
+	logger := zap.NewNop().Sugar()
+
+	user := &database.User{Username: "oncall", PasswordHash: "x", Email: "oncall@example.com"}
+	admin := &database.User{Username: "admin", PasswordHash: "x", Email: "admin@example.com", IsSuperAdmin: true}
+	for _, u := range []*database.User{user, admin} {
+		if err := db.Create(u).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	groups := NewGroupService(db, logger)
+	group, err := groups.CreateGroup("sre", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := groups.AddUserToGroup(user.ID, group.ID); err != nil {
+		t.Fatal(err)
+	}
+	return db, NewPermissionService(db, logger), user, group
+}
+
+func TestViewWithoutControl(t *testing.T) {
+	_, perms, user, group := newPermissionFixture(t)
+
+	if err := perms.AssignAgentToGroup("agent-1", group.ID, database.PermissionServiceControl); err != nil {
+		t.Fatal(err)
+	}
+	if err := perms.SetAgentGroupCapabilities("agent-1", group.ID, database.CapabilityView); err != nil {
+		t.Fatal(err)
+	}
+
+	visible, err := perms.GetVisibleAgents(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(visible) != 1 || visible[0] != "agent-1" {
+		t.Errorf("Expected agent-1 to be visible, got %v", visible)
+	}
+	if ok, _ := perms.CanUserAccessAgent(user.ID, "agent-1"); !ok {
+		t.Error("Expected view access")
+	}
+	if ok, _ := perms.CanUserExecuteCommand(user.ID, "agent-1", database.PermissionReadOnly); !ok {
+		t.Error("Expected read-only operations to be allowed")
+	}
+	if ok, _ := perms.CanUserExecuteCommand(user.ID, "agent-1", database.PermissionBasicWrite); ok {
+		t.Error("Expected commands to be denied without the control capability")
+	}
+}
+
+func TestControlGrantedWithoutView(t *testing.T) {
+	_, perms, user, group := newPermissionFixture(t)
+
+	// Incident access: control up to SERVICE_CONTROL without permanent visibility
+	if err := perms.SetUserAgentPermission(user.ID, "agent-2", database.PermissionServiceControl, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := perms.SetUserAgentCapabilities(user.ID, "agent-2", database.CapabilityControl); err != nil {
+		t.Fatal(err)
+	}
+
+	visible, _ := perms.GetVisibleAgents(user.ID)
+	if len(visible) != 0 {
+		t.Errorf("Expected no visible agents, got %v", visible)
+	}
+	if ok, _ := perms.CanUserAccessAgent(user.ID, "agent-2"); ok {
+		t.Error("Expected no view access")
+	}
+	if ok, _ := perms.CanUserExecuteCommand(user.ID, "agent-2", database.PermissionServiceControl); !ok {
+		t.Error("Expected SERVICE_CONTROL commands to be allowed")
+	}
+	if ok, _ := perms.CanUserExecuteCommand(user.ID, "agent-2", database.PermissionSystemAdmin); ok {
+		t.Error("Expected commands above the grant level to be denied")
+	}
+
+	// Adding a view-only group grant makes the agent visible too
+	if err := perms.AssignAgentToGroup("agent-2", group.ID, database.PermissionReadOnly); err != nil {
+		t.Fatal(err)
+	}
+	if err := perms.SetAgentGroupCapabilities("agent-2", group.ID, database.CapabilityView); err != nil {
+		t.Fatal(err)
+	}
+	access, err := perms.GetUserAgentAccess(user.ID, "agent-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !access.View || !access.Control || access.ControlLevel != database.PermissionServiceControl {
+		t.Errorf("Expected merged view and control access, got %+v", access)
+	}
+}
+
+func TestLegacyGrantAllowsViewAndControl(t *testing.T) {
+	_, perms, user, group := newPermissionFixture(t)
+
+	if err := perms.AssignAgentToGroup("agent-3", group.ID, database.PermissionBasicWrite); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := perms.CanUserAccessAgent(user.ID, "agent-3"); !ok {
+		t.Error("Expected view access")
+	}
+	if ok, _ := perms.CanUserExecuteCommand(user.ID, "agent-3", database.PermissionBasicWrite); !ok {
+		t.Error("Expected control access")
+	}
+	if err := perms.SetAgentGroupCapabilities("agent-3", group.ID, 7); err != ErrInvalidCapabilities {
+		t.Errorf("Expected ErrInvalidCapabilities, got %v", err)
+	}
+	if err := perms.SetAgentGroupCapabilities("agent-9", group.ID, database.CapabilityView); err != ErrAgentNotAssigned {
+		t.Errorf("Expected ErrAgentNotAssigned, got %v", err)
+	}
+}
+
+// fakeAgents lists a fixed set of connected agents
+type fakeAgents []*Agent
+
+func (f fakeAgents) GetAgent(agentID string) *Agent {
+	for _, agent := range f {
+		if agent.ID == agentID {
+			return agent
+		}
+	}
+	return nil
+}
+
+func (f fakeAgents) GetAllAgents() []*Agent { return f }
+
+func TestPatternGrants(t *testing.T) {
+	db, perms, user, _ := newPermissionFixture(t)
+	perms.SetAgents(fakeAgents{
+		{ID: "a1", Hostname: "WEB-1", Labels: map[string]string{"env": "prod", "role": "web"}},
+		{ID: "a2", Hostname: "web-2", Labels: map[string]string{"env": "staging", "role": "web"}},
+		{ID: "a3", Hostname: "db-1", Labels: map[string]string{"env": "prod", "role": "db"}},
+	})
+
+	if err := perms.SetUserAgentPermission(user.ID, "web-*", database.PermissionReadOnly, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := perms.SetUserAgentPermission(user.ID, "env=prod, role=db", database.PermissionServiceControl, 0); err != nil {
+		t.Fatal(err)
+	}
+	// Pattern grants are capped at what the matched agent's groups allow,
+	// here below the selector's level
+	ops, err := NewGroupService(db, zap.NewNop().Sugar()).CreateGroup("ops", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := perms.AssignAgentToGroup("a3", ops.ID, database.PermissionBasicWrite); err != nil {
+		t.Fatal(err)
+	}
+	// An exact grant above the pattern's level; the highest level wins
+	if err := perms.SetUserAgentPermission(user.ID, "a2", database.PermissionBasicWrite, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	visible, err := perms.GetVisibleAgents(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(visible)
+	if !slices.Equal(visible, []string{"a1", "a2", "a3"}) {
+		t.Errorf("Expected every matching agent to be visible, got %v", visible)
+	}
+	for agentID, want := range map[string]int{"a1": database.PermissionReadOnly, "a2": database.PermissionBasicWrite, "a3": database.PermissionBasicWrite} {
+		if level, err := perms.GetUserAgentPermission(user.ID, agentID); err != nil || level != want {
+			t.Errorf("%s: expected level %d, got %d %v", agentID, want, level, err)
+		}
+	}
+	if ok, _ := perms.CanUserExecuteCommand(user.ID, "a1", database.PermissionBasicWrite); ok {
+		t.Error("Expected the hostname pattern to allow read-only access only")
+	}
+	if ok, _ := perms.CanUserExecuteCommand(user.ID, "a3", database.PermissionServiceControl); ok {
+		t.Error("Expected the label selector to be capped at the agent's group level")
+	}
+
+	// Offline agents only match hostname patterns by ID
+	if ok, _ := perms.CanUserAccessAgent(user.ID, "web-9"); !ok {
+		t.Error("Expected an offline agent whose ID matches to be accessible")
+	}
+	if ok, _ := perms.CanUserAccessAgent(user.ID, "mail-1"); ok {
+		t.Error("Expected an unmatched agent to be denied")
+	}
+}
+
+func TestInvalidAgentPatterns(t *testing.T) {
+	_, perms, user, _ := newPermissionFixture(t)
+	for _, pattern := range []string{"web-[", "=prod", "env=prod,role", "env=" + strings.Repeat("x", 300)} {
+		if err := perms.SetUserAgentPermission(user.ID, pattern, database.PermissionReadOnly, 0); !errors.Is(err, ErrInvalidAgentPattern) {
+			t.Errorf("%q: expected ErrInvalidAgentPattern, got %v", pattern, err)
+		}
+	}
+
+	if err := perms.SetUserAgentPermission(user.ID, "web-*", database.PermissionReadOnly, 0); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := perms.GetUserPermissions(user.ID)
+	if len(stored) != 1 || stored[0].GrantType != database.GrantTypeHostname {
+		t.Errorf("Expected a hostname grant, got %+v", stored)
+	}
+}
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ErrUnknownPersistenceBackend is returned for unsupported metrics.backend values
+var ErrUnknownPersistenceBackend = errors.New("unknown metrics persistence backend")
+
+// Metrics persistence backends selectable with metrics.backend
+const (
+	PersistenceBackendSQL  = "sql"  // monthly tables in the main database (default)
+	PersistenceBackendNone = "none" // discard samples; history queries return nothing
+)
+
+// PersistenceBackend stores metrics samples for MetricsPersistence. Samples
+// are saved one at a time; queries return samples oldest first.
+type PersistenceBackend interface {
+	// Save stores one sample
+	Save(record database.MetricsHistory) error
+	// QueryRange returns an agent's samples between start and end; limit <= 0
+	// returns all of them
+	QueryRange(agentID string, start, end time.Time, limit int) ([]database.MetricsHistory, error)
+	// QueryAggregated returns an agent's samples between start and end
+	// averaged into buckets of the given width
+	QueryAggregated(agentID string, start, end time.Time, bucket time.Duration) ([]database.MetricsHistory, error)
+	// Agents returns the IDs of agents with samples between start and end
+	Agents(start, end time.Time) ([]string, error)
+	// Cleanup removes data past its retention
+	Cleanup() error
+}
+
+// RollupBackend is implemented by backends that keep hourly rollups
+// alongside raw samples
+type RollupBackend interface {
+	// RollupHour aggregates the samples of the hour starting at hour
+	RollupHour(hour time.Time) (agents int, err error)
+}
+
+// AgentRetentionBackend is implemented by backends that can keep agents'
+// raw samples for different lengths of time
+type AgentRetentionBackend interface {
+	// CleanupKeeping is Cleanup keeping raw samples for keepDays instead of
+	// the configured retention
+	CleanupKeeping(keepDays int) error
+	// DeleteBefore removes an agent's raw samples older than before,
+	// returning how many were removed
+	DeleteBefore(agentID string, before time.Time) (removed int, err error)
+}
+
+// CompactingBackend is implemented by backends that can collapse old raw
+// samples into coarser ones
+type CompactingBackend interface {
+	// Compact replaces the samples older than before with one average per
+	// bucket, returning how many raw samples were replaced
+	Compact(before time.Time, bucket time.Duration) (removed int, err error)
+}
+
+// NewPersistenceBackend creates the backend selected by cfg.Backend
+func NewPersistenceBackend(db *gorm.DB, cfg config.MetricsConfig, logger *zap.SugaredLogger) (PersistenceBackend, error) {
+	switch cfg.Backend {
+	case PersistenceBackendSQL, "":
+		return NewGormPersistenceBackend(db, cfg, logger)
+	case PersistenceBackendNone:
+		return noopPersistenceBackend{}, nil
+	}
+	return nil, ErrUnknownPersistenceBackend
+}
+
+// noopPersistenceBackend discards everything
+type noopPersistenceBackend struct{}
+
+func (noopPersistenceBackend) Save(database.MetricsHistory) error { return nil }
+
+func (noopPersistenceBackend) QueryRange(string, time.Time, time.Time, int) ([]database.MetricsHistory, error) {
+	return nil, nil
+}
+
+func (noopPersistenceBackend) QueryAggregated(string, time.Time, time.Time, time.Duration) ([]database.MetricsHistory, error) {
+	return nil, nil
+}
+
+func (noopPersistenceBackend) Agents(time.Time, time.Time) ([]string, error) { return nil, nil }
+
+func (noopPersistenceBackend) Cleanup() error { return nil }
+
+// AggregateMetrics averages samples into buckets of the given width, with
+// the range of CPU, memory and GPU values in each, for backends that cannot
+// aggregate natively
+func AggregateMetrics(raw []database.MetricsHistory, bucketDuration time.Duration) []database.MetricsHistory {
+	if len(raw) == 0 {
+		return raw
+	}
+
+	buckets := make(map[int64]*aggregationBucket)
+
+	for _, m := range raw {
+		bucketKey := m.Timestamp.Truncate(bucketDuration).Unix()
+		bucket, exists := buckets[bucketKey]
+		if !exists {
+			bucket = &aggregationBucket{
+				timestamp: time.Unix(bucketKey, 0),
+			}
+			buckets[bucketKey] = bucket
+		}
+		bucket.add(m)
+	}
+
+	// Convert buckets to results
+	results := make([]database.MetricsHistory, 0, len(buckets))
+	for _, bucket := range buckets {
+		results = append(results, bucket.toMetrics())
+	}
+
+	// Sort by timestamp
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Timestamp.Before(results[j].Timestamp)
+	})
+
+	return results
+}
+
+type aggregationBucket struct {
+	timestamp    time.Time
+	cpuSum       float64
+	memSum       float64
+	diskReadSum  uint64
+	diskWriteSum uint64
+	diskPctSum   float64
+	diskPctCount int // samples with a recorded disk usage
+	netRxSum     uint64
+	netTxSum     uint64
+	gpuSum       float64
+	loadSum      float64
+	count        int
+
+	// Lowest and highest values, set by the first sample
+	cpuMin, cpuMax float64
+	memMin, memMax float64
+	gpuMin, gpuMax float64
+
+	// Per-device sums, in the order devices were first seen
+	disks    []*diskSums
+	networks []*netSums
+}
+
+// diskSums accumulates one disk's values over the samples reporting it
+type diskSums struct {
+	io                database.DiskIO // device and mount points
+	readSum, writeSum uint64
+	usageSum          float64
+	count             int
+}
+
+// netSums accumulates one interface's values over the samples reporting it
+type netSums struct {
+	iface        string
+	rxSum, txSum uint64
+	count        int
+}
+
+func (b *aggregationBucket) add(m database.MetricsHistory) {
+	if b.count == 0 {
+		b.cpuMin, b.cpuMax = m.CPUPercent, m.CPUPercent
+		b.memMin, b.memMax = m.MemPercent, m.MemPercent
+		b.gpuMin, b.gpuMax = m.GPUPercent, m.GPUPercent
+	}
+	b.cpuMin, b.cpuMax = min(b.cpuMin, m.CPUPercent), max(b.cpuMax, m.CPUPercent)
+	b.memMin, b.memMax = min(b.memMin, m.MemPercent), max(b.memMax, m.MemPercent)
+	b.gpuMin, b.gpuMax = min(b.gpuMin, m.GPUPercent), max(b.gpuMax, m.GPUPercent)
+	b.cpuSum += m.CPUPercent
+	b.memSum += m.MemPercent
+	b.diskReadSum += m.DiskReadPS
+	b.diskWriteSum += m.DiskWritePS
+	if m.DiskPercent != nil {
+		b.diskPctSum += *m.DiskPercent
+		b.diskPctCount++
+	}
+	b.netRxSum += m.NetRxPS
+	b.netTxSum += m.NetTxPS
+	b.gpuSum += m.GPUPercent
+	b.loadSum += m.LoadAvg1
+	b.count++
+	if m.Devices != nil {
+		b.addDevices(m.Devices)
+	}
+}
+
+func (b *aggregationBucket) addDevices(devices *database.DeviceMetrics) {
+	for _, d := range devices.Disks {
+		var sums *diskSums
+		for _, existing := range b.disks {
+			if existing.io.Device == d.Device {
+				sums = existing
+				break
+			}
+		}
+		if sums == nil {
+			sums = &diskSums{io: database.DiskIO{Device: d.Device, MountPoints: d.MountPoints}}
+			b.disks = append(b.disks, sums)
+		}
+		sums.readSum += d.ReadPS
+		sums.writeSum += d.WritePS
+		sums.usageSum += d.UsagePercent
+		sums.count++
+	}
+	for _, n := range devices.Networks {
+		var sums *netSums
+		for _, existing := range b.networks {
+			if existing.iface == n.Interface {
+				sums = existing
+				break
+			}
+		}
+		if sums == nil {
+			sums = &netSums{iface: n.Interface}
+			b.networks = append(b.networks, sums)
+		}
+		sums.rxSum += n.RxPS
+		sums.txSum += n.TxPS
+		sums.count++
+	}
+}
+
+// devices averages each device over the samples that reported it, or
+// returns nil when no sample had a breakdown
+func (b *aggregationBucket) devices() *database.DeviceMetrics {
+	if len(b.disks) == 0 && len(b.networks) == 0 {
+		return nil
+	}
+	devices := &database.DeviceMetrics{}
+	for _, d := range b.disks {
+		io := d.io
+		io.ReadPS = d.readSum / uint64(d.count)
+		io.WritePS = d.writeSum / uint64(d.count)
+		io.UsagePercent = d.usageSum / float64(d.count)
+		devices.Disks = append(devices.Disks, io)
+	}
+	for _, n := range b.networks {
+		devices.Networks = append(devices.Networks, database.NetIO{
+			Interface: n.iface,
+			RxPS:      n.rxSum / uint64(n.count),
+			TxPS:      n.txSum / uint64(n.count),
+		})
+	}
+	return devices
+}
+
+func (b *aggregationBucket) toMetrics() database.MetricsHistory {
+	if b.count == 0 {
+		return database.MetricsHistory{Timestamp: b.timestamp}
+	}
+	var diskPercent *float64
+	if b.diskPctCount > 0 {
+		avg := b.diskPctSum / float64(b.diskPctCount)
+		diskPercent = &avg
+	}
+	return database.MetricsHistory{
+		Timestamp:   b.timestamp,
+		CPUPercent:  b.cpuSum / float64(b.count),
+		MemPercent:  b.memSum / float64(b.count),
+		DiskReadPS:  b.diskReadSum / uint64(b.count),
+		DiskWritePS: b.diskWriteSum / uint64(b.count),
+		DiskPercent: diskPercent,
+		NetRxPS:     b.netRxSum / uint64(b.count),
+		NetTxPS:     b.netTxSum / uint64(b.count),
+		GPUPercent:  b.gpuSum / float64(b.count),
+		LoadAvg1:    b.loadSum / float64(b.count),
+		Devices:     b.devices(),
+		Range: &database.MetricsRange{
+			CPUMin:  b.cpuMin,
+			CPUMax:  b.cpuMax,
+			MemMin:  b.memMin,
+			MemMax:  b.memMax,
+			GPUMin:  b.gpuMin,
+			GPUMax:  b.gpuMax,
+			Samples: b.count,
+		},
+	}
+}
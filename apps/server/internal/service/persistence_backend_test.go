@@ -0,0 +1,558 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// memoryBackend is a minimal PersistenceBackend keeping samples in a slice
+type memoryBackend struct {
+	records  []database.MetricsHistory
+	cleanups int
+	mu       sync.Mutex
+}
+
+func (b *memoryBackend) Save(record database.MetricsHistory) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.records = append(b.records, record)
+	return nil
+}
+
+func (b *memoryBackend) QueryRange(agentID string, start, end time.Time, limit int) ([]database.MetricsHistory, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var results []database.MetricsHistory
+	for _, r := range b.records {
+		if r.AgentID == agentID && !r.Timestamp.Before(start) && !r.Timestamp.After(end) {
+			results = append(results, r)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Timestamp.Before(results[j].Timestamp) })
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (b *memoryBackend) QueryAggregated(agentID string, start, end time.Time, bucket time.Duration) ([]database.MetricsHistory, error) {
+	raw, err := b.QueryRange(agentID, start, end, 0)
+	if err != nil {
+		return nil, err
+	}
+	return AggregateMetrics(raw, bucket), nil
+}
+
+func (b *memoryBackend) Agents(start, end time.Time) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	seen := make(map[string]bool)
+	var agentIDs []string
+	for _, r := range b.records {
+		if !seen[r.AgentID] && !r.Timestamp.Before(start) && !r.Timestamp.After(end) {
+			seen[r.AgentID] = true
+			agentIDs = append(agentIDs, r.AgentID)
+		}
+	}
+	return agentIDs, nil
+}
+
+func (b *memoryBackend) Cleanup() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cleanups++
+	return nil
+}
+
+func TestMetricsPersistenceBackendsAreInterchangeable(t *testing.T) {
+	cfg := config.MetricsConfig{PersistToDB: true, RetentionDays: 7}
+	logger := zap.NewNop().Sugar()
+
+	backends := map[string]func(t *testing.T) PersistenceBackend{
+		"memory": func(t *testing.T) PersistenceBackend { return &memoryBackend{} },
+		"sql": func(t *testing.T) PersistenceBackend {
+			db := newTestDB(t)
+			backend := newGormBackend(t, db, cfg)
+			// SQLite index names are database-wide, so keep to one monthly table
+			if err := db.Migrator().DropTable(database.GetCurrentMetricsTableName()); err != nil {
+				t.Fatal(err)
+			}
+			return backend
+		},
+	}
+
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			mp := NewMetricsPersistenceWithBackend(newBackend(t), cfg, logger)
+			start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local)
+			clock := NewFakeClock(start)
+			mp.SetClock(clock)
+
+			// 2 hours of samples every 10 minutes, CPU rising by 1 each time
+			for i := 0; i < 12; i++ {
+				data := &MetricsData{
+					Timestamp: clock.Now(),
+					CPU:       CPUData{UsagePercent: float64(i)},
+					Memory:    MemData{Total: 100, Used: 50},
+					Disks:     []DiskData{{MountPoint: "/", UsagePercent: 40}},
+				}
+				if err := mp.SaveMetrics("agent-1", data); err != nil {
+					t.Fatal(err)
+				}
+				clock.Advance(10 * time.Minute)
+			}
+			if err := mp.SaveMetrics("agent-2", &MetricsData{Timestamp: start}); err != nil {
+				t.Fatal(err)
+			}
+
+			history, err := mp.QueryHistory("agent-1", start, clock.Now(), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history) != 12 {
+				t.Fatalf("Expected 12 samples, got %d", len(history))
+			}
+			if history[11].CPUPercent != 11 || history[0].MemPercent != 50 || history[0].DiskPercent == nil || *history[0].DiskPercent != 40 {
+				t.Errorf("Expected samples converted from metrics, got first %+v last %+v", history[0], history[11])
+			}
+
+			limited, err := mp.QueryHistory("agent-1", start, clock.Now(), 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(limited) != 5 {
+				t.Errorf("Expected limit to apply, got %d samples", len(limited))
+			}
+
+			hourly, err := mp.QueryAggregated("agent-1", start, clock.Now(), "1h")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hourly) != 2 {
+				t.Fatalf("Expected 2 hourly buckets, got %d", len(hourly))
+			}
+			if hourly[0].CPUPercent != 2.5 || hourly[1].CPUPercent != 8.5 {
+				t.Errorf("Expected bucket averages 2.5 and 8.5, got %v and %v", hourly[0].CPUPercent, hourly[1].CPUPercent)
+			}
+
+			forecast, err := mp.Forecast("agent-1", ForecastCPU, 90, 24*time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if forecast.Samples == 0 {
+				t.Error("Expected the forecast to read history through the backend")
+			}
+		})
+	}
+}
+
+// newGormBackend creates the SQL backend on db, failing the test if its
+// tables cannot be set up
+func newGormBackend(t *testing.T, db *gorm.DB, cfg config.MetricsConfig) *GormPersistenceBackend {
+	t.Helper()
+	backend, err := NewGormPersistenceBackend(db, cfg, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func TestNewPersistenceBackend(t *testing.T) {
+	db := newTestDB(t)
+	logger := zap.NewNop().Sugar()
+
+	backend, err := NewPersistenceBackend(db, config.MetricsConfig{}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := backend.(*GormPersistenceBackend); !ok {
+		t.Errorf("Expected the SQL backend by default, got %T", backend)
+	}
+
+	// Native partitions fall back to monthly tables off PostgreSQL
+	sqlite := newGormBackend(t, db, config.MetricsConfig{NativePartitions: true})
+	if sqlite.partitioned {
+		t.Error("Expected native partitions to be ignored on SQLite")
+	}
+	if err := sqlite.Save(database.MetricsHistory{AgentID: "agent-1", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Migrator().HasTable(database.GetCurrentMetricsTableName()) {
+		t.Error("Expected the sample in the current monthly table")
+	}
+
+	none, err := NewPersistenceBackend(db, config.MetricsConfig{Backend: PersistenceBackendNone}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp := NewMetricsPersistenceWithBackend(none, config.MetricsConfig{PersistToDB: true}, logger)
+	if err := mp.SaveMetrics("agent-1", &MetricsData{Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if history, _ := mp.QueryHistory("agent-1", time.Now().Add(-time.Hour), time.Now(), 0); len(history) != 0 {
+		t.Errorf("Expected the none backend to keep nothing, got %d samples", len(history))
+	}
+
+	if _, err := NewPersistenceBackend(db, config.MetricsConfig{Backend: "clickhouse"}, logger); !errors.Is(err, ErrUnknownPersistenceBackend) {
+		t.Errorf("Expected ErrUnknownPersistenceBackend, got %v", err)
+	}
+}
+
+func TestCleanupRunsOnBackend(t *testing.T) {
+	backend := &memoryBackend{}
+	mp := NewMetricsPersistenceWithBackend(backend, config.MetricsConfig{PersistToDB: true}, zap.NewNop().Sugar())
+	mp.runCleanup()
+	// Backends without rollups are skipped
+	mp.runHourlyAggregation()
+	if backend.cleanups != 1 {
+		t.Errorf("Expected cleanup to run on the backend once, got %d", backend.cleanups)
+	}
+}
+
+func TestPersistIntervalKeepsLatestSamplePerInterval(t *testing.T) {
+	backend := &memoryBackend{}
+	cfg := config.MetricsConfig{PersistToDB: true, PersistIntervalSec: 10}
+	mp := NewMetricsPersistenceWithBackend(backend, cfg, zap.NewNop().Sugar())
+
+	// 35 seconds of 1s updates, as broadcast live
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 35; i++ {
+		mp.SaveMetricsAsync("agent-1", &MetricsData{
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			CPU:       CPUData{UsagePercent: float64(i)},
+		})
+	}
+	// Shutdown writes the sample still held for the last interval
+	if err := mp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	history, _ := backend.QueryRange("agent-1", start, start.Add(time.Minute), 0)
+	if len(history) != 4 {
+		t.Fatalf("Expected one row per 10s interval, got %d: %+v", len(history), history)
+	}
+	for i, want := range []float64{9, 19, 29, 34} {
+		if history[i].CPUPercent != want {
+			t.Errorf("Expected row %d to be the interval's latest sample (cpu %g), got %+v", i, want, history[i])
+		}
+	}
+}
+
+func TestReleaseWritesHeldSample(t *testing.T) {
+	backend := &memoryBackend{}
+	cfg := config.MetricsConfig{PersistToDB: true, PersistIntervalSec: 10}
+	mp := NewMetricsPersistenceWithBackend(backend, cfg, zap.NewNop().Sugar())
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		mp.SaveMetricsAsync("agent-1", &MetricsData{Timestamp: start.Add(time.Duration(i) * time.Second)})
+	}
+	mp.Release("agent-1")
+	mp.pending.Wait()
+
+	history, _ := backend.QueryRange("agent-1", start, start.Add(time.Minute), 0)
+	if len(history) != 1 || !history[0].Timestamp.Equal(start.Add(2*time.Second)) {
+		t.Errorf("Expected the held sample to be written on release, got %+v", history)
+	}
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if len(mp.held) != 0 {
+		t.Errorf("Expected nothing held for the released agent, got %v", mp.held)
+	}
+}
+
+func TestCompactionCollapsesOldSamplesToMinutes(t *testing.T) {
+	cfg := config.MetricsConfig{PersistToDB: true, RetentionDays: 7, CompactAfterHours: 1, CompactBucketSec: 60}
+	logger := zap.NewNop().Sugar()
+	backend := newGormBackend(t, newTestDB(t), cfg)
+	mp := NewMetricsPersistenceWithBackend(backend, cfg, logger)
+	now := time.Now().Truncate(time.Minute)
+	mp.SetClock(NewFakeClock(now))
+
+	old := now.Add(-3 * time.Hour)
+	for i := 0; i < 12; i++ {
+		// Two minutes of samples every 10s, at 10%..120% CPU
+		ts := old.Add(time.Duration(i) * 10 * time.Second)
+		if err := backend.Save(database.MetricsHistory{AgentID: "agent-1", Timestamp: ts, CPUPercent: float64(i+1) * 10, NetRxPS: 100}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recent := now.Add(-10 * time.Minute)
+	for i := 0; i < 3; i++ {
+		if err := backend.Save(database.MetricsHistory{AgentID: "agent-1", Timestamp: recent.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mp.runCleanup()
+
+	compacted, err := backend.QueryRange("agent-1", old.Add(-time.Minute), old.Add(time.Hour), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compacted) != 2 {
+		t.Fatalf("Expected the old samples to become 2 per-minute samples, got %d", len(compacted))
+	}
+	for i, want := range []float64{35, 95} {
+		m := compacted[i]
+		if !m.Timestamp.Equal(old.Add(time.Duration(i)*time.Minute)) || m.CPUPercent != want || m.NetRxPS != 100 || m.AgentID != "agent-1" {
+			t.Errorf("Expected minute %d to average to %.0f%% CPU, got %+v", i, want, m)
+		}
+	}
+
+	kept, err := backend.QueryRange("agent-1", recent, now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 3 {
+		t.Errorf("Expected samples newer than the threshold to be kept raw, got %d", len(kept))
+	}
+
+	// Already compacted minutes are left alone
+	if removed, err := backend.Compact(now.Add(-time.Hour), time.Minute); err != nil || removed != 0 {
+		t.Errorf("Expected a second compaction to change nothing, got %d, %v", removed, err)
+	}
+}
+
+func TestCompactionResumesFromStoredProgress(t *testing.T) {
+	cfg := config.MetricsConfig{PersistToDB: true}
+	db := newTestDB(t)
+	backend := newGormBackend(t, db, cfg)
+	// Samples in the current month, whose table the backend creates up front
+	now := time.Now()
+	base := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+
+	// Two minutes far enough apart to need separate windows
+	far := base.Add((compactWindowBuckets + 100) * time.Minute)
+	for _, start := range []time.Time{base, far} {
+		for i := 0; i < 3; i++ {
+			if err := backend.Save(database.MetricsHistory{AgentID: "agent-1", Timestamp: start.Add(time.Duration(i) * 10 * time.Second), CPUPercent: 10}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	before := far.Add(time.Hour)
+	if removed, err := backend.Compact(before, time.Minute); err != nil || removed != 6 {
+		t.Fatalf("Expected 6 samples compacted, got %d, %v", removed, err)
+	}
+
+	var progress database.MetricsCompaction
+	if err := db.Where("agent_id = ?", "agent-1").First(&progress).Error; err != nil {
+		t.Fatal(err)
+	}
+	if progress.MetricsTable != database.GetMetricsTableName(base) || !progress.CompactedUntil.Equal(before) {
+		t.Errorf("Expected progress up to %s, got %+v", before, progress)
+	}
+
+	// A late sample in a compacted period stays raw, even after a restart
+	late := base.Add(30 * time.Second)
+	if err := backend.Save(database.MetricsHistory{AgentID: "agent-1", Timestamp: late, CPUPercent: 90}); err != nil {
+		t.Fatal(err)
+	}
+	restarted := newGormBackend(t, db, cfg)
+	if removed, err := restarted.Compact(before, time.Minute); err != nil || removed != 0 {
+		t.Errorf("Expected compacted periods to be skipped, got %d, %v", removed, err)
+	}
+	rows, err := restarted.QueryRange("agent-1", base, base.Add(time.Minute), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].CPUPercent != 10 || rows[1].CPUPercent != 90 {
+		t.Errorf("Expected the compacted minute and the late sample, got %+v", rows)
+	}
+}
+
+func TestCompactionKeepsPerDeviceAverages(t *testing.T) {
+	cfg := config.MetricsConfig{PersistToDB: true}
+	backend := newGormBackend(t, newTestDB(t), cfg)
+	now := time.Now()
+	base := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+
+	samples := []*database.DeviceMetrics{
+		{
+			Disks:    []database.DiskIO{{Device: "/dev/sda", MountPoints: []string{"/"}, ReadPS: 100, UsagePercent: 40}},
+			Networks: []database.NetIO{{Interface: "eth0", RxPS: 1000, TxPS: 100}},
+		},
+		{
+			Disks: []database.DiskIO{
+				{Device: "/dev/sda", MountPoints: []string{"/"}, ReadPS: 300, UsagePercent: 42},
+				{Device: "/dev/sdb", ReadPS: 50},
+			},
+			Networks: []database.NetIO{{Interface: "eth0", RxPS: 3000, TxPS: 300}},
+		},
+		nil,
+	}
+	for i, devices := range samples {
+		if err := backend.Save(database.MetricsHistory{AgentID: "agent-1", Timestamp: base.Add(time.Duration(i) * 10 * time.Second), Devices: devices}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := backend.Compact(base.Add(time.Hour), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := backend.QueryRange("agent-1", base, base.Add(time.Minute), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Devices == nil {
+		t.Fatalf("Expected one compacted sample with a breakdown, got %+v", rows)
+	}
+	devices := rows[0].Devices
+	if len(devices.Disks) != 2 || len(devices.Networks) != 1 {
+		t.Fatalf("Expected 2 disks and 1 interface, got %+v", devices)
+	}
+	// Each device is averaged over the samples that reported it
+	if sda := devices.Disks[0]; sda.Device != "/dev/sda" || sda.ReadPS != 200 || sda.UsagePercent != 41 || len(sda.MountPoints) != 1 {
+		t.Errorf("Expected /dev/sda to average to 200B/s at 41%%, got %+v", sda)
+	}
+	if sdb := devices.Disks[1]; sdb.Device != "/dev/sdb" || sdb.ReadPS != 50 {
+		t.Errorf("Expected /dev/sdb's only reading, got %+v", sdb)
+	}
+	if eth0 := devices.Networks[0]; eth0.RxPS != 2000 || eth0.TxPS != 200 {
+		t.Errorf("Expected eth0 to average to 2000/200B/s, got %+v", eth0)
+	}
+}
+
+func TestSaveMetricsCountsSharedDisksOnce(t *testing.T) {
+	data := &MetricsData{
+		Timestamp: time.Now().Truncate(time.Second),
+		Disks: []DiskData{
+			{Device: "/dev/sda1", MountPoint: "/", UsagePercent: 40, ReadBytesPS: 1000, WriteBytesPS: 500},
+			{Device: "/dev/sda1", MountPoint: "/home", UsagePercent: 40, ReadBytesPS: 1000, WriteBytesPS: 500},
+			{Device: "/dev/sdb1", MountPoint: "/data", UsagePercent: 90, ReadBytesPS: 200, WriteBytesPS: 100},
+		},
+		Networks: []NetData{
+			{Interface: "eth0", RxBytesPS: 300, TxBytesPS: 30},
+			{Interface: "eth1", RxBytesPS: 100, TxBytesPS: 10},
+		},
+	}
+
+	for _, perDevice := range []bool{false, true} {
+		cfg := config.MetricsConfig{PersistToDB: true, PerDeviceBreakdown: perDevice}
+		logger := zap.NewNop().Sugar()
+		backend := newGormBackend(t, newTestDB(t), cfg)
+		mp := NewMetricsPersistenceWithBackend(backend, cfg, logger)
+		if err := mp.SaveMetrics("agent-1", data); err != nil {
+			t.Fatal(err)
+		}
+
+		records, err := mp.QueryHistory("agent-1", data.Timestamp.Add(-time.Minute), data.Timestamp.Add(time.Minute), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 {
+			t.Fatalf("Expected 1 record, got %d", len(records))
+		}
+		r := records[0]
+		if r.DiskReadPS != 1200 || r.DiskWritePS != 600 || r.DiskPercent == nil || *r.DiskPercent != 90 || r.NetRxPS != 400 || r.NetTxPS != 40 {
+			t.Errorf("Expected totals counting /dev/sda1 once, got %+v", r)
+		}
+
+		if !perDevice {
+			if r.Devices != nil {
+				t.Errorf("Expected no breakdown unless enabled, got %+v", r.Devices)
+			}
+			continue
+		}
+		if r.Devices == nil || len(r.Devices.Disks) != 2 || len(r.Devices.Networks) != 2 {
+			t.Fatalf("Expected 2 disks and 2 interfaces in the breakdown, got %+v", r.Devices)
+		}
+		sda := r.Devices.Disks[0]
+		if sda.Device != "/dev/sda1" || len(sda.MountPoints) != 2 || sda.ReadPS != 1000 || sda.WritePS != 500 {
+			t.Errorf("Expected /dev/sda1 once with both mount points, got %+v", sda)
+		}
+		if eth1 := r.Devices.Networks[1]; eth1.Interface != "eth1" || eth1.RxPS != 100 || eth1.TxPS != 10 {
+			t.Errorf("Expected eth1's own throughput, got %+v", eth1)
+		}
+	}
+}
+
+func TestQueryAggregatedKeepsSpikes(t *testing.T) {
+	cfg := config.MetricsConfig{PersistToDB: true}
+	logger := zap.NewNop().Sugar()
+	mp := NewMetricsPersistenceWithBackend(newGormBackend(t, newTestDB(t), cfg), cfg, logger)
+	hour := time.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+
+	// A quiet hour with one 100% CPU spike
+	for i, cpu := range []float64{20, 30, 100, 10} {
+		sample := &MetricsData{
+			Timestamp: hour.Add(time.Duration(i) * 10 * time.Minute),
+			CPU:       CPUData{UsagePercent: cpu},
+			Memory:    MemData{Total: 100, Used: uint64(40 + i*10)},
+		}
+		if err := mp.SaveMetrics("agent-1", sample); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buckets, err := mp.QueryAggregated("agent-1", hour, hour.Add(time.Hour), "1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 {
+		t.Fatalf("Expected one hourly bucket, got %d", len(buckets))
+	}
+	b := buckets[0]
+	if b.CPUPercent != 40 {
+		t.Errorf("Expected a 40%% CPU average, got %v", b.CPUPercent)
+	}
+	want := database.MetricsRange{CPUMin: 10, CPUMax: 100, MemMin: 40, MemMax: 70, Samples: 4}
+	if b.Range == nil || *b.Range != want {
+		t.Errorf("Expected range %+v, got %+v", want, b.Range)
+	}
+}
+
+func TestRowsWithoutDiskUsageReadAsUnknown(t *testing.T) {
+	cfg := config.MetricsConfig{PersistToDB: true}
+	db := newTestDB(t)
+	mp := NewMetricsPersistenceWithBackend(newGormBackend(t, db, cfg), cfg, zap.NewNop().Sugar())
+	hour := time.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+
+	// A row from before disk usage was recorded, then a current sample
+	table := database.GetMetricsTableName(hour)
+	if err := database.EnsureMetricsTable(db, table); err != nil {
+		t.Fatal(err)
+	}
+	old := database.MetricsHistory{AgentID: "agent-1", Timestamp: hour, CPUPercent: 10}
+	if err := db.Table(table).Create(&old).Error; err != nil {
+		t.Fatal(err)
+	}
+	sample := &MetricsData{
+		Timestamp: hour.Add(10 * time.Minute),
+		Disks:     []DiskData{{MountPoint: "/", UsagePercent: 60}},
+	}
+	if err := mp.SaveMetrics("agent-1", sample); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := mp.QueryHistory("agent-1", hour, hour.Add(time.Hour), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].DiskPercent != nil || history[1].DiskPercent == nil {
+		t.Fatalf("Expected the old row's disk usage to be unknown, got %+v", history)
+	}
+
+	buckets, err := mp.QueryAggregated("agent-1", hour, hour.Add(time.Hour), "1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 || buckets[0].DiskPercent == nil || *buckets[0].DiskPercent != 60 {
+		t.Errorf("Expected the bucket to average only recorded disk usage, got %+v", buckets)
+	}
+
+	rule := MetricAlertRule{Metric: RuleMetricDisk, Operator: "<", Threshold: 50}
+	if firings := ReplayRule(rule, history); len(firings) != 0 {
+		t.Errorf("Expected unknown disk usage not to match a rule, got %+v", firings)
+	}
+}
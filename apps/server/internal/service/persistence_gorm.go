@@ -0,0 +1,412 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GormPersistenceBackend stores raw samples in monthly metrics_history_*
+// tables and hourly rollups in metrics_hourly. With native partitions on
+// PostgreSQL the monthly tables are partitions of metrics_history, which
+// is queried directly and left to prune them.
+type GormPersistenceBackend struct {
+	db          *gorm.DB
+	cfg         config.MetricsConfig
+	logger      *zap.SugaredLogger
+	partitioned bool
+	// Partitions known to exist
+	partitions map[string]bool
+	// Serializes table creation, rollups and cleanup
+	mu sync.Mutex
+}
+
+// NewGormPersistenceBackend creates the SQL backend and its tables. It
+// fails when the tables cannot be set up, including monthly tables that
+// cannot be attached to the partitioned table.
+func NewGormPersistenceBackend(db *gorm.DB, cfg config.MetricsConfig, logger *zap.SugaredLogger) (*GormPersistenceBackend, error) {
+	b := &GormPersistenceBackend{db: db, cfg: cfg, logger: logger}
+	if cfg.NativePartitions {
+		if database.SupportsNativePartitions(db) {
+			b.partitioned = true
+			b.partitions = make(map[string]bool)
+		} else {
+			logger.Warnf("metrics.native_partitions needs PostgreSQL, using monthly tables on %s", db.Dialector.Name())
+		}
+	}
+
+	// Initialize tables
+	initTables := database.InitMetricsTables
+	if b.partitioned {
+		initTables = database.InitPartitionedMetricsTables
+	}
+	if err := initTables(db); err != nil {
+		return nil, fmt.Errorf("failed to initialize metrics tables: %w", err)
+	}
+	return b, nil
+}
+
+// Save stores a sample in the table for its month
+func (b *GormPersistenceBackend) Save(record database.MetricsHistory) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.partitioned {
+		partition := database.MetricsPartitionName(record.Timestamp)
+		if !b.partitions[partition] {
+			if err := database.EnsureMetricsPartition(b.db, record.Timestamp); err != nil {
+				return fmt.Errorf("failed to ensure metrics partition: %w", err)
+			}
+			b.partitions[partition] = true
+		}
+		return b.db.Table(database.MetricsParentTable).Create(&record).Error
+	}
+
+	tableName := database.GetMetricsTableName(record.Timestamp)
+	if err := database.EnsureMetricsTable(b.db, tableName); err != nil {
+		return fmt.Errorf("failed to ensure metrics table: %w", err)
+	}
+	return b.db.Table(tableName).Create(&record).Error
+}
+
+// QueryRange queries an agent's samples across the tables covering the range
+func (b *GormPersistenceBackend) QueryRange(agentID string, start, end time.Time, limit int) ([]database.MetricsHistory, error) {
+	var results []database.MetricsHistory
+
+	// Determine which monthly tables to query
+	tables := b.getTablesForRange(start, end)
+
+	for _, table := range tables {
+		if !b.db.Migrator().HasTable(table) {
+			continue
+		}
+
+		var partial []database.MetricsHistory
+		query := b.db.Table(table).
+			Where("agent_id = ? AND timestamp >= ? AND timestamp <= ?", agentID, start, end).
+			Order("timestamp ASC")
+
+		if limit > 0 {
+			query = query.Limit(limit)
+		}
+
+		if err := query.Find(&partial).Error; err != nil {
+			b.logger.Warnf("Error querying table %s: %v", table, err)
+			continue
+		}
+
+		results = append(results, partial...)
+	}
+
+	return results, nil
+}
+
+// QueryAggregated averages raw samples into buckets
+func (b *GormPersistenceBackend) QueryAggregated(agentID string, start, end time.Time, bucket time.Duration) ([]database.MetricsHistory, error) {
+	raw, err := b.QueryRange(agentID, start, end, 0)
+	if err != nil {
+		return nil, err
+	}
+	return AggregateMetrics(raw, bucket), nil
+}
+
+// Agents returns the agents with samples in the range
+func (b *GormPersistenceBackend) Agents(start, end time.Time) ([]string, error) {
+	return b.getAgentsWithData(start, end), nil
+}
+
+// Cleanup drops monthly tables and rollups past their retention
+func (b *GormPersistenceBackend) Cleanup() error {
+	return b.CleanupKeeping(b.cfg.RetentionDays)
+}
+
+// CleanupKeeping drops monthly tables past keepDays and rollups past their
+// retention
+func (b *GormPersistenceBackend) CleanupKeeping(keepDays int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Cleanup old monthly tables, and partitions when partitioned
+	if err := database.CleanupOldMetricsTables(b.db, keepDays); err != nil {
+		return fmt.Errorf("failed to cleanup old metrics tables: %w", err)
+	}
+	if b.partitioned {
+		if err := database.CleanupOldMetricsPartitions(b.db, keepDays); err != nil {
+			return fmt.Errorf("failed to cleanup old metrics partitions: %w", err)
+		}
+		b.partitions = make(map[string]bool)
+	}
+
+	// Cleanup old aggregated data
+	if err := database.CleanupOldAggregatedData(b.db, b.cfg.HourlyRetentionDays, b.cfg.DailyRetentionDays); err != nil {
+		return fmt.Errorf("failed to cleanup old aggregated data: %w", err)
+	}
+	return nil
+}
+
+// DeleteBefore removes an agent's raw samples older than before from every
+// monthly table, or from the partitioned table
+func (b *GormPersistenceBackend) DeleteBefore(agentID string, before time.Time) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tables := database.ListMetricsTables(b.db)
+	if b.partitioned {
+		tables = []string{database.MetricsParentTable}
+	}
+
+	removed := 0
+	for _, table := range tables {
+		result := b.db.Table(table).Where("agent_id = ? AND timestamp < ?", agentID, before).Delete(&database.MetricsHistory{})
+		if result.Error != nil {
+			return removed, fmt.Errorf("failed to delete from %s: %w", table, result.Error)
+		}
+		removed += int(result.RowsAffected)
+	}
+	return removed, nil
+}
+
+// compactWindowBuckets bounds how many buckets of samples one compaction
+// step reads and rewrites
+const compactWindowBuckets = 720
+
+// Compact replaces the raw samples older than before with one averaged
+// sample per agent and bucket. Each agent's progress in each table is kept,
+// so repeated runs only read samples newer than the last compacted bucket.
+// Samples that arrive later for an already compacted period stay raw.
+func (b *GormPersistenceBackend) Compact(before time.Time, bucket time.Duration) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tables := database.ListMetricsTables(b.db)
+	if b.partitioned {
+		tables = append(tables, database.MetricsParentTable)
+	}
+
+	removed := 0
+	for _, table := range tables {
+		var agentIDs []string
+		if err := b.db.Table(table).Where("timestamp < ?", before).Distinct("agent_id").Pluck("agent_id", &agentIDs).Error; err != nil {
+			return removed, fmt.Errorf("failed to list agents in %s: %w", table, err)
+		}
+		for _, agentID := range agentIDs {
+			n, err := b.compactAgent(table, agentID, before, bucket)
+			removed += n
+			if err != nil {
+				return removed, err
+			}
+		}
+	}
+	return removed, nil
+}
+
+// compactAgent compacts one agent's samples in a table, a window of
+// buckets at a time, starting where the last run stopped
+func (b *GormPersistenceBackend) compactAgent(table, agentID string, before time.Time, bucket time.Duration) (int, error) {
+	var progress database.MetricsCompaction
+	if err := b.db.Where("metrics_table = ? AND agent_id = ?", table, agentID).
+		Limit(1).Find(&progress).Error; err != nil {
+		return 0, fmt.Errorf("failed to read compaction progress of %s in %s: %w", agentID, table, err)
+	}
+
+	removed := 0
+	from := progress.CompactedUntil
+	for from.Before(before) {
+		// Skip gaps by starting at the bucket of the next sample
+		var next database.MetricsHistory
+		result := b.db.Table(table).Select("timestamp").
+			Where("agent_id = ? AND timestamp >= ? AND timestamp < ?", agentID, from, before).
+			Order("timestamp ASC").Limit(1).Find(&next)
+		if result.Error != nil {
+			return removed, fmt.Errorf("failed to read %s samples from %s: %w", agentID, table, result.Error)
+		}
+		if result.RowsAffected == 0 {
+			break
+		}
+		start := next.Timestamp.Truncate(bucket)
+		end := start.Add(compactWindowBuckets * bucket)
+		if end.After(before) {
+			end = before
+		}
+		n, err := b.compactWindow(table, agentID, start, end, bucket)
+		if err != nil {
+			return removed, err
+		}
+		removed += n
+		from = end
+	}
+	return removed, nil
+}
+
+// compactWindow compacts one agent's samples in [start, end) and records
+// end as its compaction progress in the same transaction
+func (b *GormPersistenceBackend) compactWindow(table, agentID string, start, end time.Time, bucket time.Duration) (int, error) {
+	var raw []database.MetricsHistory
+	if err := b.db.Table(table).
+		Where("agent_id = ? AND timestamp >= ? AND timestamp < ?", agentID, start, end).
+		Order("timestamp ASC").
+		Find(&raw).Error; err != nil {
+		return 0, fmt.Errorf("failed to read %s samples from %s: %w", agentID, table, err)
+	}
+
+	// Samples are ordered, so each bucket is a contiguous run
+	var runs [][]database.MetricsHistory
+	for i, m := range raw {
+		if i == 0 || !m.Timestamp.Truncate(bucket).Equal(raw[i-1].Timestamp.Truncate(bucket)) {
+			runs = append(runs, nil)
+		}
+		runs[len(runs)-1] = append(runs[len(runs)-1], m)
+	}
+
+	removed := 0
+	err := b.db.Transaction(func(tx *gorm.DB) error {
+		for _, run := range runs {
+			start := run[0].Timestamp.Truncate(bucket)
+			if len(run) == 1 && run[0].Timestamp.Equal(start) {
+				continue
+			}
+			agg := &aggregationBucket{timestamp: start}
+			ids := make([]uint64, len(run))
+			for i, m := range run {
+				agg.add(m)
+				ids[i] = m.ID
+			}
+			// IDs are only unique per month once tables are attached as
+			// partitions, so the bucket's range scopes the delete
+			if err := tx.Table(table).
+				Where("agent_id = ? AND timestamp >= ? AND timestamp <= ? AND id IN ?", agentID, start, run[len(run)-1].Timestamp, ids).
+				Delete(&database.MetricsHistory{}).Error; err != nil {
+				return err
+			}
+			compacted := agg.toMetrics()
+			compacted.AgentID = agentID
+			if err := tx.Table(table).Create(&compacted).Error; err != nil {
+				return err
+			}
+			removed += len(run)
+		}
+		progress := database.MetricsCompaction{MetricsTable: table, AgentID: agentID, CompactedUntil: end}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "metrics_table"}, {Name: "agent_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"compacted_until"}),
+		}).Create(&progress).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to compact %s samples in %s: %w", agentID, table, err)
+	}
+	return removed, nil
+}
+
+// RollupHour stores hourly aggregates for every agent with samples in the hour
+func (b *GormPersistenceBackend) RollupHour(hour time.Time) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	endHour := hour.Add(time.Hour)
+
+	// Get all agents with data in the hour
+	agentIDs := b.getAgentsWithData(hour, endHour)
+
+	for _, agentID := range agentIDs {
+		raw, err := b.QueryRange(agentID, hour, endHour, 0)
+		if err != nil || len(raw) == 0 {
+			continue
+		}
+
+		// Calculate aggregates
+		var cpuSum, memSum, gpuSum float64
+		var cpuMax, memMax float64
+		var netRxTotal, netTxTotal uint64
+
+		for _, m := range raw {
+			cpuSum += m.CPUPercent
+			memSum += m.MemPercent
+			gpuSum += m.GPUPercent
+			netRxTotal += m.NetRxPS
+			netTxTotal += m.NetTxPS
+
+			if m.CPUPercent > cpuMax {
+				cpuMax = m.CPUPercent
+			}
+			if m.MemPercent > memMax {
+				memMax = m.MemPercent
+			}
+		}
+
+		count := len(raw)
+		hourly := database.MetricsHourly{
+			AgentID:    agentID,
+			Hour:       hour,
+			CPUAvg:     cpuSum / float64(count),
+			CPUMax:     cpuMax,
+			MemAvg:     memSum / float64(count),
+			MemMax:     memMax,
+			NetRxTotal: netRxTotal,
+			NetTxTotal: netTxTotal,
+			DataPoints: count,
+		}
+
+		if err := b.db.Create(&hourly).Error; err != nil {
+			b.logger.Warnf("Failed to save hourly aggregation for %s: %v", agentID, err)
+		}
+	}
+
+	return len(agentIDs), nil
+}
+
+// getTablesForRange returns the table names that cover the given time range
+func (b *GormPersistenceBackend) getTablesForRange(start, end time.Time) []string {
+	if b.partitioned {
+		return []string{database.MetricsParentTable}
+	}
+
+	var tables []string
+	current := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.Local)
+	endMonth := time.Date(end.Year(), end.Month(), 1, 0, 0, 0, 0, time.Local)
+
+	for !current.After(endMonth) {
+		tables = append(tables, database.GetMetricsTableName(current))
+		current = current.AddDate(0, 1, 0)
+	}
+
+	return tables
+}
+
+// getAgentsWithData returns agent IDs that have data in the given time range
+func (b *GormPersistenceBackend) getAgentsWithData(start, end time.Time) []string {
+	var agentIDs []string
+	tables := b.getTablesForRange(start, end)
+
+	for _, table := range tables {
+		if !b.db.Migrator().HasTable(table) {
+			continue
+		}
+
+		var ids []string
+		b.db.Table(table).
+			Where("timestamp >= ? AND timestamp <= ?", start, end).
+			Distinct("agent_id").
+			Pluck("agent_id", &ids)
+
+		for _, id := range ids {
+			found := false
+			for _, existing := range agentIDs {
+				if existing == id {
+					found = true
+					break
+				}
+			}
+			if !found {
+				agentIDs = append(agentIDs, id)
+			}
+		}
+	}
+
+	return agentIDs
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"sort"
+	"time"
+)
+
+// Process sort orders accepted by TopProcesses
+const (
+	ProcessSortCPU    = "cpu"
+	ProcessSortMemory = "memory"
+)
+
+// ProcessInfo is a process reported by an agent
+type ProcessInfo struct {
+	PID         uint32  `json:"pid"`
+	Name        string  `json:"name"`
+	User        string  `json:"user"`
+	CPUPercent  float64 `json:"cpuPercent"`
+	MemoryBytes uint64  `json:"memoryBytes"`
+	Status      string  `json:"status,omitempty"`
+}
+
+// ProcessSnapshot is the latest process list reported by an agent
+type ProcessSnapshot struct {
+	AgentID    string        `json:"agentId"`
+	Processes  []ProcessInfo `json:"processes"`
+	CapturedAt time.Time     `json:"capturedAt"`
+}
+
+// StoreProcesses records an agent's process list as its latest snapshot
+func (s *MetricsService) StoreProcesses(agentID string, processes []ProcessInfo) *ProcessSnapshot {
+	snapshot := &ProcessSnapshot{
+		AgentID:    agentID,
+		Processes:  processes,
+		CapturedAt: s.settings().clock.Now(),
+	}
+	shard := s.shard(agentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.processes[agentID] = snapshot
+	return snapshot
+}
+
+// GetProcesses returns an agent's latest process snapshot, or nil if it has
+// not reported one
+func (s *MetricsService) GetProcesses(agentID string) *ProcessSnapshot {
+	shard := s.shard(agentID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.processes[agentID]
+}
+
+// TopProcesses returns up to limit processes with the highest CPU or memory
+// usage, highest first. A limit of 0 or less returns them all.
+func TopProcesses(processes []ProcessInfo, sortBy string, limit int) []ProcessInfo {
+	sorted := append([]ProcessInfo(nil), processes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sortBy == ProcessSortMemory {
+			return sorted[i].MemoryBytes > sorted[j].MemoryBytes
+		}
+		return sorted[i].CPUPercent > sorted[j].CPUPercent
+	})
+	if limit > 0 && len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
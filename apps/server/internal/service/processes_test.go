@@ -0,0 +1,36 @@
+package service
+
+import "testing"
+
+func TestTopProcesses(t *testing.T) {
+	processes := []ProcessInfo{
+		{PID: 1, Name: "systemd", CPUPercent: 0.1, MemoryBytes: 12 << 20},
+		{PID: 812, Name: "postgres", CPUPercent: 35.5, MemoryBytes: 900 << 20},
+		{PID: 1044, Name: "java", CPUPercent: 12, MemoryBytes: 2 << 30},
+		{PID: 2210, Name: "nginx", CPUPercent: 4, MemoryBytes: 40 << 20},
+	}
+
+	tests := []struct {
+		sortBy string
+		limit  int
+		want   []string
+	}{
+		{ProcessSortCPU, 2, []string{"postgres", "java"}},
+		{ProcessSortMemory, 3, []string{"java", "postgres", "nginx"}},
+		{ProcessSortCPU, 0, []string{"postgres", "java", "nginx", "systemd"}},
+	}
+	for _, tt := range tests {
+		got := TopProcesses(processes, tt.sortBy, tt.limit)
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s/%d: expected %d processes, got %d", tt.sortBy, tt.limit, len(tt.want), len(got))
+		}
+		for i, name := range tt.want {
+			if got[i].Name != name {
+				t.Errorf("%s/%d: expected %s at %d, got %s", tt.sortBy, tt.limit, name, i, got[i].Name)
+			}
+		}
+	}
+	if processes[0].Name != "systemd" {
+		t.Error("Expected the input to be left unsorted")
+	}
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"go.uber.org/zap"
+)
+
+// DeltaRealtimeCapability is advertised in AgentInit by agents that can send
+// delta-only realtime metrics
+const DeltaRealtimeCapability = "delta_realtime"
+
+// Realtime field groups carried by a delta update (mirrors the RealtimeField proto enum)
+const (
+	RealtimeFieldCPUUsage       uint32 = 1 << 0
+	RealtimeFieldCPUPerCore     uint32 = 1 << 1
+	RealtimeFieldCPUTemperature uint32 = 1 << 2
+	RealtimeFieldCPUFrequency   uint32 = 1 << 3
+	RealtimeFieldMemory         uint32 = 1 << 4
+	RealtimeFieldDiskIO         uint32 = 1 << 5
+	RealtimeFieldNetworkIO      uint32 = 1 << 6
+	RealtimeFieldLoadAverage    uint32 = 1 << 7
+	RealtimeFieldGPUUsage       uint32 = 1 << 8
+	RealtimeFieldNPUUsage       uint32 = 1 << 9
+)
+
+// RealtimeMode is how an agent is advised to report realtime metrics
+// (mirrors the RealtimeReportMode proto enum)
+type RealtimeMode int
+
+const (
+	RealtimeModeFull RealtimeMode = iota
+	RealtimeModeDelta
+)
+
+func (m RealtimeMode) String() string {
+	if m == RealtimeModeDelta {
+		return "delta"
+	}
+	return "full"
+}
+
+// linkSmoothing is the weight of a new sample in the moving averages
+const linkSmoothing = 0.3
+
+// linkStats tracks the observed quality of one agent's link
+type linkStats struct {
+	rttMs     float64
+	sizeBytes float64
+	hasRTT    bool
+	hasSize   bool
+	mode      RealtimeMode
+}
+
+// RealtimeModeAdvisor decides whether an agent on a slow or lossy link should
+// switch to delta-only realtime reporting. The decision is based on the
+// heartbeat round trip reported by the agent and the size of its full
+// realtime messages. Only agents that advertised DeltaRealtimeCapability
+// receive advice.
+type RealtimeModeAdvisor struct {
+	cfg    config.DeltaRealtimeConfig
+	links  map[string]*linkStats
+	mu     sync.Mutex
+	logger *zap.SugaredLogger
+}
+
+// NewRealtimeModeAdvisor creates an advisor with the given thresholds
+func NewRealtimeModeAdvisor(logger *zap.SugaredLogger, cfg config.DeltaRealtimeConfig) *RealtimeModeAdvisor {
+	return &RealtimeModeAdvisor{
+		cfg:    cfg,
+		links:  make(map[string]*linkStats),
+		logger: logger,
+	}
+}
+
+// Register records an agent's advertised capabilities. Agents without
+// DeltaRealtimeCapability are ignored.
+func (a *RealtimeModeAdvisor) Register(agentID string, capabilities []string) bool {
+	for _, c := range capabilities {
+		if c == DeltaRealtimeCapability {
+			a.mu.Lock()
+			a.links[agentID] = &linkStats{}
+			a.mu.Unlock()
+			return true
+		}
+	}
+	return false
+}
+
+// Forget drops an agent's link state
+func (a *RealtimeModeAdvisor) Forget(agentID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.links, agentID)
+}
+
+// ObserveRTT records a heartbeat round trip measured by the agent
+func (a *RealtimeModeAdvisor) ObserveRTT(agentID string, rtt time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	link := a.links[agentID]
+	if link == nil {
+		return
+	}
+	link.rttMs = smooth(link.rttMs, float64(rtt.Milliseconds()), link.hasRTT)
+	link.hasRTT = true
+}
+
+// ObserveRealtimeSize records the encoded size of a realtime message. Delta
+// messages are not counted because they say nothing about the full payload.
+func (a *RealtimeModeAdvisor) ObserveRealtimeSize(agentID string, bytes int, delta bool) {
+	if delta {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	link := a.links[agentID]
+	if link == nil {
+		return
+	}
+	link.sizeBytes = smooth(link.sizeBytes, float64(bytes), link.hasSize)
+	link.hasSize = true
+}
+
+// Advise returns the reporting mode for an agent and whether the agent
+// should be told about it at all. A link switches to delta when its RTT or
+// full message size crosses the configured thresholds, and only returns to
+// full reporting once the RTT falls below half the threshold, so that a
+// borderline link does not flap between modes.
+func (a *RealtimeModeAdvisor) Advise(agentID string) (RealtimeMode, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	link := a.links[agentID]
+	if link == nil || !a.cfg.Enabled {
+		return RealtimeModeFull, false
+	}
+
+	slowRTT := float64(a.cfg.SlowRTTMs)
+	slow := a.cfg.SlowRTTMs > 0 && link.hasRTT && link.rttMs >= slowRTT
+	large := a.cfg.LargeMessageBytes > 0 && link.hasSize && link.sizeBytes >= float64(a.cfg.LargeMessageBytes)
+
+	mode := link.mode
+	switch {
+	case slow || large:
+		mode = RealtimeModeDelta
+	case link.mode == RealtimeModeDelta && (!link.hasRTT || link.rttMs < slowRTT/2):
+		mode = RealtimeModeFull
+	}
+
+	if mode != link.mode {
+		a.logger.Infof("Advising agent %s to use %s realtime reporting (rtt=%.0fms, size=%.0fB)",
+			agentID, mode, link.rttMs, link.sizeBytes)
+		link.mode = mode
+	}
+	return mode, true
+}
+
+func smooth(avg, sample float64, seeded bool) float64 {
+	if !seeded {
+		return sample
+	}
+	return avg + linkSmoothing*(sample-avg)
+}
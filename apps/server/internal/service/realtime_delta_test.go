@@ -0,0 +1,121 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"go.uber.org/zap"
+)
+
+func TestDeltaRealtimeReconstructsFullState(t *testing.T) {
+	ms := NewMetricsService(zap.NewNop().Sugar())
+
+	// Initial full snapshot
+	ms.MergeRealtimeMetrics("agent-1", &RealtimeUpdate{
+		CPUUsage:     40,
+		CPUPerCore:   []float64{30, 50},
+		CPUTemp:      55,
+		CPUFrequency: 3200,
+		MemoryUsed:   4096,
+		MemoryCached: 1024,
+		SwapUsed:     128,
+		LoadAverage:  []float64{1, 2, 3},
+		DiskIO: []DiskData{
+			{Device: "sda", ReadBytesPS: 100, WriteBytesPS: 200},
+			{Device: "sdb", ReadBytesPS: 10, WriteBytesPS: 20},
+		},
+		NetworkIO: []NetData{{Interface: "eth0", RxBytesPS: 1000, TxBytesPS: 500, IsUp: true}},
+		GPUUsage:  []GPUData{{Index: 0, UsagePercent: 80, Temperature: 70}},
+	})
+
+	// Delta: CPU usage, memory and one disk changed. Zero values in the
+	// other fields must not overwrite the previous state.
+	ms.MergeRealtimeMetrics("agent-1", &RealtimeUpdate{
+		Delta:      true,
+		Fields:     RealtimeFieldCPUUsage | RealtimeFieldMemory | RealtimeFieldDiskIO,
+		CPUUsage:   65,
+		MemoryUsed: 5000,
+		DiskIO:     []DiskData{{Device: "sdb", ReadBytesPS: 11, WriteBytesPS: 22}},
+	})
+
+	got := ms.GetCurrentMetrics("agent-1")
+	if got.CPU.UsagePercent != 65 {
+		t.Errorf("Expected CPU usage 65, got %v", got.CPU.UsagePercent)
+	}
+	if got.CPU.Temperature != 55 || got.CPU.FrequencyMhz != 3200 || len(got.CPU.PerCoreUsage) != 2 {
+		t.Errorf("Expected untouched CPU fields to be kept, got %+v", got.CPU)
+	}
+	if got.Memory.Used != 5000 || got.Memory.Cached != 0 || got.Memory.SwapUsed != 0 {
+		t.Errorf("Expected memory group to be replaced as a whole, got %+v", got.Memory)
+	}
+	if len(got.LoadAverage) != 3 {
+		t.Errorf("Expected load average to be kept, got %v", got.LoadAverage)
+	}
+	if len(got.Disks) != 2 || got.Disks[0].ReadBytesPS != 100 || got.Disks[1].ReadBytesPS != 11 {
+		t.Errorf("Expected only sdb to change, got %+v", got.Disks)
+	}
+	if len(got.Networks) != 1 || got.Networks[0].RxBytesPS != 1000 {
+		t.Errorf("Expected network state to be kept, got %+v", got.Networks)
+	}
+	if len(got.GPUs) != 1 || got.GPUs[0].UsagePercent != 80 {
+		t.Errorf("Expected GPU state to be kept, got %+v", got.GPUs)
+	}
+
+	// A later full update overwrites everything it carries
+	ms.MergeRealtimeMetrics("agent-1", &RealtimeUpdate{CPUUsage: 10})
+	got = ms.GetCurrentMetrics("agent-1")
+	if got.CPU.UsagePercent != 10 || got.CPU.Temperature != 0 {
+		t.Errorf("Expected full update to overwrite CPU fields, got %+v", got.CPU)
+	}
+}
+
+func TestRealtimeModeAdvisor(t *testing.T) {
+	advisor := NewRealtimeModeAdvisor(zap.NewNop().Sugar(), config.DeltaRealtimeConfig{
+		Enabled:           true,
+		SlowRTTMs:         500,
+		LargeMessageBytes: 8192,
+	})
+
+	if advisor.Register("legacy", nil) {
+		t.Error("Expected agent without capability to be ignored")
+	}
+	if _, ok := advisor.Advise("legacy"); ok {
+		t.Error("Expected no advice for agents that did not opt in")
+	}
+
+	advisor.Register("edge", []string{DeltaRealtimeCapability})
+	advisor.ObserveRTT("edge", 50*time.Millisecond)
+	if mode, ok := advisor.Advise("edge"); !ok || mode != RealtimeModeFull {
+		t.Errorf("Expected full mode on a fast link, got %v (ok=%v)", mode, ok)
+	}
+
+	for i := 0; i < 10; i++ {
+		advisor.ObserveRTT("edge", 900*time.Millisecond)
+	}
+	if mode, _ := advisor.Advise("edge"); mode != RealtimeModeDelta {
+		t.Errorf("Expected delta mode on a slow link, got %v", mode)
+	}
+
+	// Just under the threshold is not enough to switch back
+	for i := 0; i < 10; i++ {
+		advisor.ObserveRTT("edge", 400*time.Millisecond)
+	}
+	if mode, _ := advisor.Advise("edge"); mode != RealtimeModeDelta {
+		t.Errorf("Expected delta mode to stick near the threshold, got %v", mode)
+	}
+
+	for i := 0; i < 20; i++ {
+		advisor.ObserveRTT("edge", 50*time.Millisecond)
+	}
+	if mode, _ := advisor.Advise("edge"); mode != RealtimeModeFull {
+		t.Errorf("Expected full mode after the link recovers, got %v", mode)
+	}
+
+	// Large full payloads also warrant deltas; delta payloads are not counted
+	advisor.ObserveRealtimeSize("edge", 200, true)
+	advisor.ObserveRealtimeSize("edge", 20000, false)
+	if mode, _ := advisor.Advise("edge"); mode != RealtimeModeDelta {
+		t.Errorf("Expected delta mode for large messages, got %v", mode)
+	}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultRecentLogSize is how many warnings and errors RecentLogs keeps
+const DefaultRecentLogSize = 200
+
+// LogEntry is a captured log line
+type LogEntry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Caller  string                 `json:"caller,omitempty"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// RecentLogs keeps the latest warnings and errors logged by the server, for
+// diagnostics bundles. Tee its Core into the server's logger.
+type RecentLogs struct {
+	mu      sync.Mutex
+	entries []LogEntry
+	size    int
+}
+
+// NewRecentLogs keeps up to size entries (DefaultRecentLogSize if size <= 0)
+func NewRecentLogs(size int) *RecentLogs {
+	if size <= 0 {
+		size = DefaultRecentLogSize
+	}
+	return &RecentLogs{size: size}
+}
+
+// Core returns a zap core recording warnings and above
+func (r *RecentLogs) Core() zapcore.Core {
+	return &recentLogsCore{logs: r}
+}
+
+// Entries returns the captured entries, oldest first
+func (r *RecentLogs) Entries() []LogEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]LogEntry(nil), r.entries...)
+}
+
+func (r *RecentLogs) add(entry LogEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+	if len(r.entries) > r.size {
+		r.entries = append(r.entries[:0:0], r.entries[len(r.entries)-r.size:]...)
+	}
+}
+
+// recentLogsCore is the zapcore.Core feeding RecentLogs
+type recentLogsCore struct {
+	logs   *RecentLogs
+	fields []zapcore.Field
+}
+
+func (c *recentLogsCore) Enabled(level zapcore.Level) bool {
+	return level >= zapcore.WarnLevel
+}
+
+func (c *recentLogsCore) With(fields []zapcore.Field) zapcore.Core {
+	return &recentLogsCore{logs: c.logs, fields: append(append([]zapcore.Field(nil), c.fields...), fields...)}
+}
+
+func (c *recentLogsCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *recentLogsCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	logged := LogEntry{
+		Time:    entry.Time,
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+	}
+	if entry.Caller.Defined {
+		logged.Caller = entry.Caller.TrimmedPath()
+	}
+	if all := append(append([]zapcore.Field(nil), c.fields...), fields...); len(all) > 0 {
+		enc := zapcore.NewMapObjectEncoder()
+		for _, f := range all {
+			f.AddTo(enc)
+		}
+		logged.Fields = enc.Fields
+	}
+	c.logs.add(logged)
+	return nil
+}
+
+func (c *recentLogsCore) Sync() error {
+	return nil
+}
@@ -0,0 +1,331 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Retention policy errors
+var (
+	ErrInvalidRetentionPolicy  = errors.New("invalid retention policy")
+	ErrRetentionPolicyNotFound = errors.New("retention policy not found")
+)
+
+// DefaultMaxMemoryHistory is the number of samples kept in memory per agent
+// when metrics.max_memory_history is not set
+const DefaultMaxMemoryHistory = 600
+
+// RetentionSettings is how much metrics history is kept for an agent
+type RetentionSettings struct {
+	MaxMemoryHistory   int `json:"maxMemoryHistory"`   // Samples kept in memory
+	RetentionDays      int `json:"retentionDays"`      // Days raw samples are kept in the database
+	PersistIntervalSec int `json:"persistIntervalSec"` // Persist only the latest sample of each interval (0 = every sample)
+}
+
+// RetentionOverride replaces some of the global retention settings. Nil
+// fields keep the setting from the next policy that applies, or the global one.
+type RetentionOverride struct {
+	MaxMemoryHistory   *int `json:"maxMemoryHistory,omitempty"`
+	RetentionDays      *int `json:"retentionDays,omitempty"`
+	PersistIntervalSec *int `json:"persistIntervalSec,omitempty"`
+}
+
+// validate rejects overrides that set nothing or set unusable values
+func (o RetentionOverride) validate() error {
+	if o.MaxMemoryHistory == nil && o.RetentionDays == nil && o.PersistIntervalSec == nil {
+		return fmt.Errorf("%w: no setting overridden", ErrInvalidRetentionPolicy)
+	}
+	if o.MaxMemoryHistory != nil && *o.MaxMemoryHistory < 1 {
+		return fmt.Errorf("%w: maxMemoryHistory must be at least 1", ErrInvalidRetentionPolicy)
+	}
+	if o.RetentionDays != nil && *o.RetentionDays < 1 {
+		return fmt.Errorf("%w: retentionDays must be at least 1", ErrInvalidRetentionPolicy)
+	}
+	if o.PersistIntervalSec != nil && *o.PersistIntervalSec < 0 {
+		return fmt.Errorf("%w: persistIntervalSec must not be negative", ErrInvalidRetentionPolicy)
+	}
+	return nil
+}
+
+// applyTo fills the settings not yet taken from a more specific policy
+func (o RetentionOverride) applyTo(s *RetentionSettings, set *[3]bool) {
+	if o.MaxMemoryHistory != nil && !set[0] {
+		s.MaxMemoryHistory, set[0] = *o.MaxMemoryHistory, true
+	}
+	if o.RetentionDays != nil && !set[1] {
+		s.RetentionDays, set[1] = *o.RetentionDays, true
+	}
+	if o.PersistIntervalSec != nil && !set[2] {
+		s.PersistIntervalSec, set[2] = *o.PersistIntervalSec, true
+	}
+}
+
+// RetentionPolicies lists the configured overrides and the global settings
+// they fall back to
+type RetentionPolicies struct {
+	Defaults RetentionSettings            `json:"defaults"`
+	Agents   map[string]RetentionOverride `json:"agents"` // Agent ID -> override
+	Labels   map[string]RetentionOverride `json:"labels"` // key=value -> override
+}
+
+// RetentionPolicyService resolves per-agent and per-label overrides of the
+// metrics history size, database retention and persistence interval. An
+// agent's own policy wins over label policies, which apply in label order,
+// and settings no policy sets come from the metrics config. Policies are
+// stored in the database and cached, since every ingested sample resolves one.
+type RetentionPolicyService struct {
+	db       *gorm.DB
+	defaults RetentionSettings
+	logger   *zap.SugaredLogger
+
+	mu     sync.RWMutex
+	agents map[string]RetentionOverride
+	labels map[string]RetentionOverride
+	// Labels with a policy, in the order their policies apply
+	labelOrder []string
+
+	// Current labels of an agent, or nil when it is not connected
+	labelSource func(agentID string) map[string]string
+	// Reports agents connected under an ID the server assigned, which
+	// changes on reconnect
+	volatileID func(agentID string) bool
+	// Agent ID -> labels last seen, so label policies still apply while it
+	// is offline. Only agents keeping their ID are remembered, so this
+	// grows with the fleet rather than with reconnects.
+	seen sync.Map
+}
+
+// NewRetentionPolicyService creates a retention policy service falling back
+// to the settings in cfg
+func NewRetentionPolicyService(db *gorm.DB, cfg config.MetricsConfig, logger *zap.SugaredLogger) *RetentionPolicyService {
+	defaults := RetentionSettings{
+		MaxMemoryHistory:   cfg.MaxMemoryHistory,
+		RetentionDays:      cfg.RetentionDays,
+		PersistIntervalSec: cfg.PersistIntervalSec,
+	}
+	if defaults.MaxMemoryHistory <= 0 {
+		defaults.MaxMemoryHistory = DefaultMaxMemoryHistory
+	}
+	return &RetentionPolicyService{
+		db:       db,
+		defaults: defaults,
+		logger:   logger,
+		agents:   make(map[string]RetentionOverride),
+		labels:   make(map[string]RetentionOverride),
+	}
+}
+
+// Load reads the stored policies into the cache
+func (s *RetentionPolicyService) Load() error {
+	var records []database.RetentionPolicy
+	if err := s.db.Find(&records).Error; err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range records {
+		o := RetentionOverride{MaxMemoryHistory: r.MaxMemoryHistory, RetentionDays: r.RetentionDays, PersistIntervalSec: r.PersistIntervalSec}
+		if r.AgentID != "" {
+			s.agents[r.AgentID] = o
+		} else {
+			s.labels[r.Label] = o
+		}
+	}
+	s.sortLabelsLocked()
+	s.logger.Infof("Loaded %d metrics retention policies", len(records))
+	return nil
+}
+
+// SetLabelSource sets the lookup of an agent's current labels, used to match
+// label policies
+func (s *RetentionPolicyService) SetLabelSource(source func(agentID string) map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.labelSource = source
+}
+
+// SetVolatileIDCheck sets the check for agents whose IDs change on
+// reconnect, which cannot have policies of their own
+func (s *RetentionPolicyService) SetVolatileIDCheck(volatile func(agentID string) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.volatileID = volatile
+}
+
+// Defaults returns the global settings
+func (s *RetentionPolicyService) Defaults() RetentionSettings {
+	return s.defaults
+}
+
+// Resolve returns the settings that apply to an agent
+func (s *RetentionPolicyService) Resolve(agentID string) RetentionSettings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	settings := s.defaults
+	var set [3]bool
+	if o, ok := s.agents[agentID]; ok {
+		o.applyTo(&settings, &set)
+	}
+	if len(s.labelOrder) == 0 {
+		return settings
+	}
+
+	var labels map[string]string
+	if s.labelSource != nil {
+		labels = s.labelSource(agentID)
+	}
+	if labels != nil {
+		if s.volatileID == nil || !s.volatileID(agentID) {
+			s.seen.Store(agentID, labels)
+		}
+	} else if seen, ok := s.seen.Load(agentID); ok {
+		labels = seen.(map[string]string)
+	}
+	for _, label := range s.labelOrder {
+		key, value, _ := strings.Cut(label, "=")
+		if v, ok := labels[key]; ok && v == value {
+			s.labels[label].applyTo(&settings, &set)
+		}
+	}
+	return settings
+}
+
+// sortLabelsLocked refreshes labelOrder after the label policies change
+func (s *RetentionPolicyService) sortLabelsLocked() {
+	s.labelOrder = s.labelOrder[:0]
+	for label := range s.labels {
+		s.labelOrder = append(s.labelOrder, label)
+	}
+	sort.Strings(s.labelOrder)
+}
+
+// LongestRetentionDays returns the longest database retention any agent may
+// have, which bounds what cleanup can drop for all agents at once
+func (s *RetentionPolicyService) LongestRetentionDays() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	longest := s.defaults.RetentionDays
+	for _, policies := range []map[string]RetentionOverride{s.agents, s.labels} {
+		for _, o := range policies {
+			if o.RetentionDays != nil && *o.RetentionDays > longest {
+				longest = *o.RetentionDays
+			}
+		}
+	}
+	return longest
+}
+
+// List returns the configured policies
+func (s *RetentionPolicyService) List() RetentionPolicies {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	policies := RetentionPolicies{
+		Defaults: s.defaults,
+		Agents:   make(map[string]RetentionOverride, len(s.agents)),
+		Labels:   make(map[string]RetentionOverride, len(s.labels)),
+	}
+	for id, o := range s.agents {
+		policies.Agents[id] = o
+	}
+	for label, o := range s.labels {
+		policies.Labels[label] = o
+	}
+	return policies
+}
+
+// SetAgentPolicy stores an agent's override, replacing any earlier one.
+// Agents whose IDs change on reconnect are refused, as the policy would
+// stop applying to them; a label policy can cover them instead.
+func (s *RetentionPolicyService) SetAgentPolicy(agentID string, o RetentionOverride) error {
+	if agentID == "" {
+		return fmt.Errorf("%w: agent ID is required", ErrInvalidRetentionPolicy)
+	}
+	s.mu.RLock()
+	volatile := s.volatileID
+	s.mu.RUnlock()
+	if volatile != nil && volatile(agentID) {
+		return fmt.Errorf("%w: agent %s gets a new ID on every reconnect; use a label policy", ErrInvalidRetentionPolicy, agentID)
+	}
+	if err := s.save(database.RetentionPolicy{AgentID: agentID}, o); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.agents[agentID] = o
+	s.mu.Unlock()
+	return nil
+}
+
+// SetLabelPolicy stores the override for agents carrying a label, given as
+// key=value, replacing any earlier one
+func (s *RetentionPolicyService) SetLabelPolicy(label string, o RetentionOverride) error {
+	if key, _, ok := strings.Cut(label, "="); !ok || key == "" {
+		return fmt.Errorf("%w: label must be key=value", ErrInvalidRetentionPolicy)
+	}
+	if err := s.save(database.RetentionPolicy{Label: label}, o); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.labels[label] = o
+	s.sortLabelsLocked()
+	s.mu.Unlock()
+	return nil
+}
+
+// save upserts the policy for target's agent or label
+func (s *RetentionPolicyService) save(target database.RetentionPolicy, o RetentionOverride) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	record := target
+	if err := s.db.Where("agent_id = ? AND label = ?", target.AgentID, target.Label).
+		FirstOrInit(&record).Error; err != nil {
+		return err
+	}
+	record.MaxMemoryHistory = o.MaxMemoryHistory
+	record.RetentionDays = o.RetentionDays
+	record.PersistIntervalSec = o.PersistIntervalSec
+	return s.db.Save(&record).Error
+}
+
+// DeleteAgentPolicy removes an agent's override
+func (s *RetentionPolicyService) DeleteAgentPolicy(agentID string) error {
+	if err := s.delete(database.RetentionPolicy{AgentID: agentID}); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.agents, agentID)
+	s.mu.Unlock()
+	return nil
+}
+
+// DeleteLabelPolicy removes the override for a label
+func (s *RetentionPolicyService) DeleteLabelPolicy(label string) error {
+	if err := s.delete(database.RetentionPolicy{Label: label}); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.labels, label)
+	s.sortLabelsLocked()
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *RetentionPolicyService) delete(target database.RetentionPolicy) error {
+	result := s.db.Where("agent_id = ? AND label = ?", target.AgentID, target.Label).Delete(&database.RetentionPolicy{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRetentionPolicyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+)
+
+func newTestRetentionPolicies(t *testing.T) *RetentionPolicyService {
+	t.Helper()
+	cfg := config.MetricsConfig{MaxMemoryHistory: 600, RetentionDays: 7, PersistIntervalSec: 60}
+	policies := NewRetentionPolicyService(newTestDB(t), cfg, zap.NewNop().Sugar())
+	labels := map[string]map[string]string{
+		"db-1":  {"env": "prod", "role": "db"},
+		"dev-1": {"env": "dev"},
+	}
+	policies.SetLabelSource(func(agentID string) map[string]string { return labels[agentID] })
+	return policies
+}
+
+func TestRetentionPolicyResolution(t *testing.T) {
+	policies := newTestRetentionPolicies(t)
+	if err := policies.SetLabelPolicy("env=prod", RetentionOverride{RetentionDays: intPtr(90), PersistIntervalSec: intPtr(0)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := policies.SetLabelPolicy("role=db", RetentionOverride{RetentionDays: intPtr(30), MaxMemoryHistory: intPtr(3600)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := policies.SetAgentPolicy("dev-1", RetentionOverride{MaxMemoryHistory: intPtr(60), RetentionDays: intPtr(1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		agentID string
+		want    RetentionSettings
+	}{
+		// No policy: global settings
+		{"web-1", RetentionSettings{MaxMemoryHistory: 600, RetentionDays: 7, PersistIntervalSec: 60}},
+		// Label policies merge in label order, env=prod before role=db
+		{"db-1", RetentionSettings{MaxMemoryHistory: 3600, RetentionDays: 90, PersistIntervalSec: 0}},
+		// The agent's own policy, then the global interval
+		{"dev-1", RetentionSettings{MaxMemoryHistory: 60, RetentionDays: 1, PersistIntervalSec: 60}},
+	}
+	for _, tt := range tests {
+		if got := policies.Resolve(tt.agentID); got != tt.want {
+			t.Errorf("%s: expected %+v, got %+v", tt.agentID, tt.want, got)
+		}
+	}
+	if longest := policies.LongestRetentionDays(); longest != 90 {
+		t.Errorf("Expected the longest retention to be 90 days, got %d", longest)
+	}
+
+	// Policies survive a restart
+	reloaded := NewRetentionPolicyService(policies.db, config.MetricsConfig{RetentionDays: 7}, zap.NewNop().Sugar())
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	list := reloaded.List()
+	if len(list.Agents) != 1 || len(list.Labels) != 2 || *list.Labels["role=db"].MaxMemoryHistory != 3600 {
+		t.Errorf("Expected the stored policies to be loaded, got %+v", list)
+	}
+	if list.Defaults.MaxMemoryHistory != DefaultMaxMemoryHistory {
+		t.Errorf("Expected the default history size without config, got %d", list.Defaults.MaxMemoryHistory)
+	}
+
+	// Updating replaces the override; deleting falls back to the labels
+	if err := policies.SetAgentPolicy("db-1", RetentionOverride{RetentionDays: intPtr(3)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := policies.SetAgentPolicy("db-1", RetentionOverride{RetentionDays: intPtr(365)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := policies.Resolve("db-1").RetentionDays; got != 365 {
+		t.Errorf("Expected the agent policy to win, got %d days", got)
+	}
+	if err := policies.DeleteAgentPolicy("db-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := policies.Resolve("db-1").RetentionDays; got != 90 {
+		t.Errorf("Expected the label policy after deleting the agent's, got %d days", got)
+	}
+	if err := policies.DeleteAgentPolicy("db-1"); !errors.Is(err, ErrRetentionPolicyNotFound) {
+		t.Errorf("Expected ErrRetentionPolicyNotFound, got %v", err)
+	}
+}
+
+func TestRetentionPolicyValidation(t *testing.T) {
+	policies := newTestRetentionPolicies(t)
+	invalid := []struct {
+		label string
+		o     RetentionOverride
+	}{
+		{"env=prod", RetentionOverride{}},
+		{"env=prod", RetentionOverride{RetentionDays: intPtr(0)}},
+		{"env=prod", RetentionOverride{MaxMemoryHistory: intPtr(-1)}},
+		{"env=prod", RetentionOverride{PersistIntervalSec: intPtr(-5)}},
+		{"prod", RetentionOverride{RetentionDays: intPtr(30)}},
+		{"=prod", RetentionOverride{RetentionDays: intPtr(30)}},
+	}
+	for _, tt := range invalid {
+		if err := policies.SetLabelPolicy(tt.label, tt.o); !errors.Is(err, ErrInvalidRetentionPolicy) {
+			t.Errorf("%q %+v: expected ErrInvalidRetentionPolicy, got %v", tt.label, tt.o, err)
+		}
+	}
+}
+
+func TestRetentionPolicyRefusesVolatileIDs(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	agents := NewAgentService(logger, NewMetricsService(logger))
+	agents.RegisterGrpcAgent("agent-1", AgentInfo{Hostname: "web-1", Labels: map[string]string{"env": "prod"}}, 0)
+	agents.RegisterGrpcAgent("legacy-1", AgentInfo{Hostname: "web-2", Labels: map[string]string{"env": "prod"}, VolatileID: true}, 0)
+	ws := agents.RegisterAgent(nil, AgentInfo{Hostname: "web-3"}, 0)
+
+	policies := newTestRetentionPolicies(t)
+	policies.SetLabelSource(func(agentID string) map[string]string {
+		if agent := agents.GetAgent(agentID); agent != nil {
+			return agent.Labels
+		}
+		return nil
+	})
+	policies.SetVolatileIDCheck(agents.HasVolatileID)
+	if err := policies.SetLabelPolicy("env=prod", RetentionOverride{RetentionDays: intPtr(90)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := policies.SetAgentPolicy("agent-1", RetentionOverride{RetentionDays: intPtr(30)}); err != nil {
+		t.Errorf("Expected an agent keeping its ID to get a policy, got %v", err)
+	}
+	for _, id := range []string{"legacy-1", ws.ID} {
+		if err := policies.SetAgentPolicy(id, RetentionOverride{RetentionDays: intPtr(30)}); !errors.Is(err, ErrInvalidRetentionPolicy) {
+			t.Errorf("%s: expected ErrInvalidRetentionPolicy, got %v", id, err)
+		}
+	}
+
+	// Label policies still apply to volatile agents, but their labels are
+	// not remembered once they leave
+	if got := policies.Resolve("legacy-1").RetentionDays; got != 90 {
+		t.Errorf("Expected the label policy for a volatile agent, got %d days", got)
+	}
+	policies.Resolve("agent-1")
+	if _, ok := policies.seen.Load("legacy-1"); ok {
+		t.Error("Expected a volatile agent's labels not to be remembered")
+	}
+	if _, ok := policies.seen.Load("agent-1"); !ok {
+		t.Error("Expected a persistent agent's labels to be remembered")
+	}
+}
+
+func TestRetentionPolicyAppliesAtIngestion(t *testing.T) {
+	policies := newTestRetentionPolicies(t)
+	if err := policies.SetLabelPolicy("env=dev", RetentionOverride{MaxMemoryHistory: intPtr(5), PersistIntervalSec: intPtr(10)}); err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.NewNop().Sugar()
+	ms := NewMetricsService(logger)
+	ms.SetRetentionPolicies(policies)
+	backend := &memoryBackend{}
+	mp := NewMetricsPersistenceWithBackend(backend, config.MetricsConfig{PersistToDB: true}, logger)
+	mp.SetRetentionPolicies(policies)
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		for _, agentID := range []string{"dev-1", "web-1"} {
+			ms.StoreMetrics(agentID, &MetricsData{})
+			mp.SaveMetricsAsync(agentID, &MetricsData{Timestamp: start.Add(time.Duration(i) * time.Second)})
+		}
+	}
+	// Shutdown writes the samples still held for the last interval
+	if err := mp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(ms.GetMetricsHistory("dev-1", 0)); n != 5 {
+		t.Errorf("Expected 5 samples in memory for the dev agent, got %d", n)
+	}
+	if n := len(ms.GetMetricsHistory("web-1", 0)); n != 20 {
+		t.Errorf("Expected every sample in memory for the other agent, got %d", n)
+	}
+	dev, _ := backend.QueryRange("dev-1", start, start.Add(time.Minute), 0)
+	web, _ := backend.QueryRange("web-1", start, start.Add(time.Minute), 0)
+	if len(dev) != 2 || len(web) != 1 {
+		t.Errorf("Expected the dev agent persisted per 10s and the other per 60s interval, got %d and %d", len(dev), len(web))
+	}
+}
+
+func TestCleanupAppliesPerAgentRetention(t *testing.T) {
+	policies := newTestRetentionPolicies(t)
+	if err := policies.SetLabelPolicy("env=prod", RetentionOverride{RetentionDays: intPtr(30)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := policies.SetAgentPolicy("dev-1", RetentionOverride{RetentionDays: intPtr(1)}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.MetricsConfig{PersistToDB: true, RetentionDays: 7, HourlyRetentionDays: 30, DailyRetentionDays: 365}
+	logger := zap.NewNop().Sugar()
+	backend := newGormBackend(t, policies.db, cfg)
+	mp := NewMetricsPersistenceWithBackend(backend, cfg, logger)
+	mp.SetRetentionPolicies(policies)
+	now := time.Now()
+	mp.SetClock(NewFakeClock(now))
+
+	for _, agentID := range []string{"db-1", "dev-1", "web-1"} {
+		for _, age := range []int{2, 10} {
+			if err := backend.Save(database.MetricsHistory{AgentID: agentID, Timestamp: now.AddDate(0, 0, -age)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := mp.cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	for agentID, want := range map[string]int{"db-1": 2, "dev-1": 0, "web-1": 1} {
+		kept, err := backend.QueryRange(agentID, now.AddDate(0, 0, -30), now, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(kept) != want {
+			t.Errorf("%s: expected %d samples kept, got %d", agentID, want, len(kept))
+		}
+	}
+}
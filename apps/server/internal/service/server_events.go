@@ -0,0 +1,268 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Kinds of server-wide events
+const (
+	ServerEventStarted         = "server_started"
+	ServerEventStopping        = "server_stopping"
+	ServerEventDegradedEntered = "degraded_entered"
+	ServerEventDegradedExited  = "degraded_exited"
+	ServerEventMassDisconnect  = "mass_disconnect"
+	ServerEventConfigImported  = "config_imported"
+)
+
+// Components that can put the server into degraded mode
+const (
+	DegradedMetricsPersistence = "metrics_persistence"
+)
+
+// ServerEvent is a server-level event, as opposed to one about a single agent
+type ServerEvent struct {
+	ID         uint64            `json:"id"`
+	Kind       string            `json:"kind"`
+	Severity   string            `json:"severity"`
+	Message    string            `json:"message"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Timestamp  time.Time         `json:"timestamp"`
+}
+
+// ServerEventBus fans server events out to subscribers and keeps the most
+// recent ones in a ring buffer. It also tracks which components have put
+// the server into degraded mode.
+type ServerEventBus struct {
+	ring        []ServerEvent
+	start       int
+	count       int
+	nextID      uint64
+	subscribers map[chan ServerEvent]struct{}
+	degraded    map[string]string // component -> reason
+	clock       Clock
+	logger      *zap.SugaredLogger
+	mu          sync.Mutex
+}
+
+// NewServerEventBus creates a bus remembering up to maxEvents events
+func NewServerEventBus(maxEvents int, logger *zap.SugaredLogger) *ServerEventBus {
+	if maxEvents <= 0 {
+		maxEvents = 500
+	}
+	return &ServerEventBus{
+		ring:        make([]ServerEvent, maxEvents),
+		subscribers: make(map[chan ServerEvent]struct{}),
+		degraded:    make(map[string]string),
+		clock:       RealClock,
+		logger:      logger,
+	}
+}
+
+// SetClock replaces the time source (for tests)
+func (b *ServerEventBus) SetClock(clock Clock) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clock = clock
+}
+
+// Publish records an event and sends it to every subscriber. Subscribers
+// that are not keeping up miss the event rather than block the publisher.
+func (b *ServerEventBus) Publish(kind, severity, message string, attributes map[string]string) ServerEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.publishLocked(kind, severity, message, attributes)
+}
+
+func (b *ServerEventBus) publishLocked(kind, severity, message string, attributes map[string]string) ServerEvent {
+	b.nextID++
+	event := ServerEvent{
+		ID:         b.nextID,
+		Kind:       kind,
+		Severity:   severity,
+		Message:    message,
+		Attributes: attributes,
+		Timestamp:  b.clock.Now(),
+	}
+
+	size := len(b.ring)
+	if b.count < size {
+		b.ring[(b.start+b.count)%size] = event
+		b.count++
+	} else {
+		b.ring[b.start] = event
+		b.start = (b.start + 1) % size
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+
+	if b.logger != nil {
+		b.logger.Infof("Server event %s: %s", kind, message)
+	}
+	return event
+}
+
+// Subscribe returns a channel receiving events published from now on and a
+// function that unsubscribes and closes it
+func (b *ServerEventBus) Subscribe(buffer int) (<-chan ServerEvent, func()) {
+	if buffer <= 0 {
+		buffer = 64
+	}
+	ch := make(chan ServerEvent, buffer)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Recent returns up to limit of the latest events, oldest first. A limit
+// of 0 or less returns everything kept.
+func (b *ServerEventBus) Recent(limit int) []ServerEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := b.count
+	if limit > 0 && limit < n {
+		n = limit
+	}
+	events := make([]ServerEvent, 0, n)
+	for i := b.count - n; i < b.count; i++ {
+		events = append(events, b.ring[(b.start+i)%len(b.ring)])
+	}
+	return events
+}
+
+// SetDegraded marks a component as failing. The first failing component
+// puts the server into degraded mode.
+func (b *ServerEventBus) SetDegraded(component, reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, already := b.degraded[component]; already {
+		b.degraded[component] = reason
+		return
+	}
+	b.degraded[component] = reason
+	if len(b.degraded) == 1 {
+		b.publishLocked(ServerEventDegradedEntered, "critical",
+			fmt.Sprintf("server entered degraded mode: %s failing", component),
+			map[string]string{"component": component, "reason": reason})
+	}
+}
+
+// ClearDegraded marks a component as healthy again. The server leaves
+// degraded mode once no component is failing.
+func (b *ServerEventBus) ClearDegraded(component string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, failing := b.degraded[component]; !failing {
+		return
+	}
+	delete(b.degraded, component)
+	if len(b.degraded) == 0 {
+		b.publishLocked(ServerEventDegradedExited, "info",
+			fmt.Sprintf("server left degraded mode: %s recovered", component),
+			map[string]string{"component": component})
+	}
+}
+
+// Degraded returns the failing components and why, or nil when healthy
+func (b *ServerEventBus) Degraded() map[string]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.degraded) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(b.degraded))
+	for component, reason := range b.degraded {
+		result[component] = reason
+	}
+	return result
+}
+
+// DisconnectBurstDetector spots many agents dropping at once, which points
+// at a network or server problem rather than individual hosts
+type DisconnectBurstDetector struct {
+	threshold int
+	window    time.Duration
+	recent    map[string]time.Time // agent ID -> last unexpected disconnect
+	active    bool
+	clock     Clock
+	mu        sync.Mutex
+}
+
+// NewDisconnectBurstDetector reports a burst once threshold distinct agents
+// disconnect within window
+func NewDisconnectBurstDetector(threshold int, window time.Duration) *DisconnectBurstDetector {
+	return &DisconnectBurstDetector{
+		threshold: threshold,
+		window:    window,
+		recent:    make(map[string]time.Time),
+		clock:     RealClock,
+	}
+}
+
+// SetClock replaces the time source (for tests)
+func (d *DisconnectBurstDetector) SetClock(clock Clock) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clock = clock
+}
+
+// Observe records a disconnect and, when it completes a burst, returns the
+// agents involved. A burst is reported once; the detector re-arms after
+// disconnects fall back below the threshold.
+func (d *DisconnectBurstDetector) Observe(agentID string) (agents []string, burst bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.clock.Now()
+	d.recent[agentID] = now
+	for id, at := range d.recent {
+		if now.Sub(at) > d.window {
+			delete(d.recent, id)
+		}
+	}
+
+	if len(d.recent) < d.threshold {
+		d.active = false
+		return nil, false
+	}
+	if d.active {
+		return nil, false
+	}
+	d.active = true
+	for id := range d.recent {
+		agents = append(agents, id)
+	}
+	sort.Strings(agents)
+	return agents, true
+}
+
+// PublishMassDisconnect reports a disconnect burst on the bus
+func (b *ServerEventBus) PublishMassDisconnect(agents []string, window time.Duration) ServerEvent {
+	return b.Publish(ServerEventMassDisconnect, "critical",
+		fmt.Sprintf("%d agents disconnected within %s", len(agents), window),
+		map[string]string{"count": fmt.Sprint(len(agents)), "agents": strings.Join(agents, ",")})
+}
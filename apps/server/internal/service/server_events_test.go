@@ -0,0 +1,144 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"github.com/chenqi92/NanoLink/apps/server/internal/database"
+	"go.uber.org/zap"
+)
+
+func receiveServerEvent(t *testing.T, events <-chan ServerEvent) ServerEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("Expected a server event")
+	}
+	return ServerEvent{}
+}
+
+func TestDegradedModeEmitsServerEvents(t *testing.T) {
+	bus := NewServerEventBus(10, zap.NewNop().Sugar())
+	events, unsubscribe := bus.Subscribe(10)
+	defer unsubscribe()
+
+	bus.SetDegraded(DegradedMetricsPersistence, "disk full")
+	event := receiveServerEvent(t, events)
+	if event.Kind != ServerEventDegradedEntered || event.Attributes["component"] != DegradedMetricsPersistence {
+		t.Errorf("Expected degraded_entered for metrics persistence, got %+v", event)
+	}
+
+	// Further failures, from the same or another component, stay in degraded mode
+	bus.SetDegraded(DegradedMetricsPersistence, "disk still full")
+	bus.SetDegraded("other", "broken")
+	bus.ClearDegraded(DegradedMetricsPersistence)
+	select {
+	case event := <-events:
+		t.Fatalf("Expected no event while another component is failing, got %+v", event)
+	default:
+	}
+	if degraded := bus.Degraded(); len(degraded) != 1 || degraded["other"] != "broken" {
+		t.Errorf("Expected only the other component to be failing, got %v", degraded)
+	}
+
+	bus.ClearDegraded("other")
+	if event := receiveServerEvent(t, events); event.Kind != ServerEventDegradedExited {
+		t.Errorf("Expected degraded_exited, got %+v", event)
+	}
+	if bus.Degraded() != nil {
+		t.Errorf("Expected no failing components, got %v", bus.Degraded())
+	}
+}
+
+func TestPersistenceFailureEntersDegradedMode(t *testing.T) {
+	backend := &failingBackend{err: errors.New("database is locked")}
+	mp := NewMetricsPersistenceWithBackend(backend, config.MetricsConfig{PersistToDB: true}, zap.NewNop().Sugar())
+	bus := NewServerEventBus(10, zap.NewNop().Sugar())
+	mp.SetServerEvents(bus)
+	events, unsubscribe := bus.Subscribe(10)
+	defer unsubscribe()
+
+	if err := mp.SaveMetrics("agent-1", &MetricsData{Timestamp: time.Now()}); err == nil {
+		t.Fatal("Expected the save to fail")
+	}
+	if event := receiveServerEvent(t, events); event.Kind != ServerEventDegradedEntered {
+		t.Errorf("Expected degraded_entered, got %+v", event)
+	}
+
+	backend.err = nil
+	if err := mp.SaveMetrics("agent-1", &MetricsData{Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveServerEvent(t, events); event.Kind != ServerEventDegradedExited {
+		t.Errorf("Expected degraded_exited, got %+v", event)
+	}
+}
+
+func TestServerEventBusKeepsRecentEvents(t *testing.T) {
+	bus := NewServerEventBus(3, nil)
+	for _, kind := range []string{"a", "b", "c", "d", "e"} {
+		bus.Publish(kind, "info", kind, nil)
+	}
+
+	recent := bus.Recent(0)
+	if len(recent) != 3 || recent[0].Kind != "c" || recent[2].Kind != "e" {
+		t.Fatalf("Expected the last three events oldest first, got %+v", recent)
+	}
+	if recent := bus.Recent(2); len(recent) != 2 || recent[0].Kind != "d" {
+		t.Errorf("Expected the last two events, got %+v", recent)
+	}
+
+	// Unsubscribed channels are closed and no longer receive events
+	events, unsubscribe := bus.Subscribe(1)
+	unsubscribe()
+	bus.Publish("f", "info", "f", nil)
+	if _, open := <-events; open {
+		t.Error("Expected the channel to be closed after unsubscribing")
+	}
+}
+
+func TestDisconnectBurstDetector(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	detector := NewDisconnectBurstDetector(3, time.Minute)
+	detector.SetClock(clock)
+
+	detector.Observe("a")
+	clock.Advance(10 * time.Second)
+	detector.Observe("b")
+	// The same agent flapping does not count twice
+	if _, burst := detector.Observe("b"); burst {
+		t.Fatal("Expected no burst from a repeated agent")
+	}
+	agents, burst := detector.Observe("c")
+	if !burst || len(agents) != 3 {
+		t.Fatalf("Expected a burst of 3 agents, got %v %v", agents, burst)
+	}
+	if _, burst := detector.Observe("d"); burst {
+		t.Error("Expected an ongoing burst to be reported once")
+	}
+
+	// Re-arms once disconnects age out of the window
+	clock.Advance(2 * time.Minute)
+	detector.Observe("e")
+	detector.Observe("f")
+	if _, burst := detector.Observe("g"); !burst {
+		t.Error("Expected a new burst after the window passed")
+	}
+}
+
+// failingBackend fails saves while err is set
+type failingBackend struct {
+	memoryBackend
+	err error
+}
+
+func (b *failingBackend) Save(record database.MetricsHistory) error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.memoryBackend.Save(record)
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ways an agent stream can end
+const (
+	StreamEndEOF          = "eof"           // Agent closed the stream cleanly
+	StreamEndCanceled     = "canceled"      // Client context cancelled (agent process or connection gone)
+	StreamEndDeadline     = "deadline"      // Deadline or keepalive timeout exceeded
+	StreamEndTransport    = "transport"     // Network/transport failure (connection reset, unavailable)
+	StreamEndServerClosed = "server_closed" // Closed by this server, e.g. a forced disconnect
+	StreamEndOther        = "other"
+)
+
+// AgentStreamStats counts one agent's connections and how its streams ended.
+// Agents are keyed by identity (see AgentService.Identity), so counts
+// survive reconnects, even for agents that get a new ID per connection, for
+// the lifetime of the server.
+type AgentStreamStats struct {
+	AgentID       string         `json:"agentId"` // the agent's identity
+	Connects      int            `json:"connects"`
+	Reconnects    int            `json:"reconnects"`
+	StreamEnds    map[string]int `json:"streamEnds"`
+	LastEnd       string         `json:"lastEnd,omitempty"`
+	LastError     string         `json:"lastError,omitempty"`
+	LastEndAt     *time.Time     `json:"lastEndAt,omitempty"`
+	LastConnectAt time.Time      `json:"lastConnectAt"`
+}
+
+// StreamStatsTotals sums stream statistics across all agents
+type StreamStatsTotals struct {
+	Agents     int            `json:"agents"`
+	Connects   int            `json:"connects"`
+	Reconnects int            `json:"reconnects"`
+	StreamEnds map[string]int `json:"streamEnds"`
+}
+
+// StreamStats tracks agent reconnects and stream errors to help diagnose
+// flaky networks
+type StreamStats struct {
+	agents map[string]*AgentStreamStats
+	mu     sync.Mutex
+	clock  Clock
+}
+
+// NewStreamStats creates an empty stream statistics tracker
+func NewStreamStats() *StreamStats {
+	return &StreamStats{
+		agents: make(map[string]*AgentStreamStats),
+		clock:  RealClock,
+	}
+}
+
+// SetClock replaces the time source (for tests)
+func (s *StreamStats) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+// RecordConnect counts a new connection; every connection after the first is a reconnect
+func (s *StreamStats) RecordConnect(agentID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.get(agentID)
+	if stats.Connects > 0 {
+		stats.Reconnects++
+	}
+	stats.Connects++
+	stats.LastConnectAt = s.clock.Now()
+}
+
+// RecordStreamEnd counts how an agent's stream ended. Agents that were never
+// seen connecting, or were forgotten since, are ignored.
+func (s *StreamStats) RecordStreamEnd(agentID, kind string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.agents[agentID]
+	if !ok {
+		return
+	}
+	stats.StreamEnds[kind]++
+	stats.LastEnd = kind
+	stats.LastError = ""
+	if err != nil {
+		stats.LastError = err.Error()
+	}
+	now := s.clock.Now()
+	stats.LastEndAt = &now
+}
+
+// Forget drops an agent's statistics
+func (s *StreamStats) Forget(agentID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.agents, agentID)
+}
+
+// Agent returns a copy of one agent's statistics
+func (s *StreamStats) Agent(agentID string) (AgentStreamStats, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.agents[agentID]
+	if !ok {
+		return AgentStreamStats{}, false
+	}
+	return stats.copy(), true
+}
+
+// All returns a copy of every agent's statistics, most reconnects first
+func (s *StreamStats) All() []AgentStreamStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]AgentStreamStats, 0, len(s.agents))
+	for _, stats := range s.agents {
+		result = append(result, stats.copy())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Reconnects != result[j].Reconnects {
+			return result[i].Reconnects > result[j].Reconnects
+		}
+		return result[i].AgentID < result[j].AgentID
+	})
+	return result
+}
+
+// Totals sums statistics across all agents
+func (s *StreamStats) Totals() StreamStatsTotals {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totals := StreamStatsTotals{Agents: len(s.agents), StreamEnds: make(map[string]int)}
+	for _, stats := range s.agents {
+		totals.Connects += stats.Connects
+		totals.Reconnects += stats.Reconnects
+		for kind, n := range stats.StreamEnds {
+			totals.StreamEnds[kind] += n
+		}
+	}
+	return totals
+}
+
+func (s *StreamStats) get(agentID string) *AgentStreamStats {
+	stats, ok := s.agents[agentID]
+	if !ok {
+		stats = &AgentStreamStats{AgentID: agentID, StreamEnds: make(map[string]int)}
+		s.agents[agentID] = stats
+	}
+	return stats
+}
+
+func (a *AgentStreamStats) copy() AgentStreamStats {
+	copied := *a
+	copied.StreamEnds = make(map[string]int, len(a.StreamEnds))
+	for kind, n := range a.StreamEnds {
+		copied.StreamEnds[kind] = n
+	}
+	if a.LastEndAt != nil {
+		at := *a.LastEndAt
+		copied.LastEndAt = &at
+	}
+	return copied
+}
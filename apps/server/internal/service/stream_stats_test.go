@@ -0,0 +1,55 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStreamStatsCountsReconnectsAndEnds(t *testing.T) {
+	stats := NewStreamStats()
+	stats.SetClock(NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+
+	stats.RecordConnect("agent-1")
+	stats.RecordStreamEnd("agent-1", StreamEndTransport, errors.New("connection reset"))
+	stats.RecordConnect("agent-1")
+	stats.RecordStreamEnd("agent-1", StreamEndDeadline, errors.New("keepalive timeout"))
+	stats.RecordConnect("agent-2")
+
+	a, ok := stats.Agent("agent-1")
+	if !ok {
+		t.Fatal("Expected stats for agent-1")
+	}
+	if a.Connects != 2 || a.Reconnects != 1 {
+		t.Errorf("Expected 2 connects and 1 reconnect, got %d and %d", a.Connects, a.Reconnects)
+	}
+	if a.StreamEnds[StreamEndTransport] != 1 || a.StreamEnds[StreamEndDeadline] != 1 {
+		t.Errorf("Unexpected stream ends: %v", a.StreamEnds)
+	}
+	if a.LastEnd != StreamEndDeadline || a.LastError != "keepalive timeout" || a.LastEndAt == nil {
+		t.Errorf("Expected last end to be the deadline, got %+v", a)
+	}
+
+	// Returned stats are copies
+	a.StreamEnds[StreamEndOther] = 99
+	if again, _ := stats.Agent("agent-1"); again.StreamEnds[StreamEndOther] != 0 {
+		t.Error("Expected Agent to return a copy")
+	}
+
+	all := stats.All()
+	if len(all) != 2 || all[0].AgentID != "agent-1" {
+		t.Errorf("Expected agent-1 first by reconnects, got %+v", all)
+	}
+}
+
+func TestStreamStatsIgnoresForgottenAgents(t *testing.T) {
+	stats := NewStreamStats()
+	stats.RecordConnect("agent-1")
+	stats.Forget("agent-1")
+
+	// A stream ending after the agent was deregistered does not bring it back
+	stats.RecordStreamEnd("agent-1", StreamEndServerClosed, nil)
+	if _, ok := stats.Agent("agent-1"); ok {
+		t.Error("Expected forgotten agent to stay forgotten")
+	}
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"go.uber.org/zap"
+)
+
+// Agent webhook event types
+const (
+	WebhookEventConnect    = "connect"
+	WebhookEventDisconnect = "disconnect"
+	WebhookEventAlert      = "alert"
+)
+
+// WebhookEvent is the agent webhook payload
+type WebhookEvent struct {
+	Event     string          `json:"event"`
+	AgentID   string          `json:"agentId"`
+	Hostname  string          `json:"hostname,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+	Metrics   *WebhookMetrics `json:"metrics,omitempty"` // Last metrics seen from the agent
+	Alert     interface{}     `json:"alert,omitempty"`
+}
+
+// WebhookMetrics summarizes an agent's last metrics for incident tooling
+type WebhookMetrics struct {
+	Timestamp     time.Time `json:"timestamp"`
+	CPUPercent    float64   `json:"cpuPercent"`
+	MemoryPercent float64   `json:"memoryPercent"`
+	LoadAverage   []float64 `json:"loadAverage,omitempty"`
+}
+
+// DeadLetter is an event that could not be delivered to a URL
+type DeadLetter struct {
+	URL      string       `json:"url"`
+	Error    string       `json:"error"`
+	Attempts int          `json:"attempts"`
+	FailedAt time.Time    `json:"failedAt"`
+	Event    WebhookEvent `json:"event"`
+}
+
+// WebhookNotifier posts agent connects, disconnects and alerts to incident
+// tooling such as Slack or PagerDuty. Each URL gets its own delivery with
+// retries; events still failing afterwards are written to the dead-letter log.
+type WebhookNotifier struct {
+	urls       []string
+	secret     string
+	events     map[string]bool
+	client     *http.Client
+	maxRetries int
+	backoff    time.Duration
+	deadLetter string
+
+	agents  *AgentService
+	metrics *MetricsService
+	clock   Clock
+	logger  *zap.SugaredLogger
+
+	// Serializes dead-letter writes
+	deadLetterMu sync.Mutex
+	// Deliveries still in flight, including their retries
+	inFlight sync.WaitGroup
+}
+
+// NewWebhookNotifier creates a notifier posting to the configured URLs
+func NewWebhookNotifier(cfg config.AgentWebhookConfig, agents *AgentService, metrics *MetricsService, logger *zap.SugaredLogger) *WebhookNotifier {
+	timeout := time.Duration(cfg.TimeoutSec) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	backoff := time.Duration(cfg.RetryBackoffSec) * time.Second
+	if backoff <= 0 {
+		backoff = 2 * time.Second
+	}
+
+	n := &WebhookNotifier{
+		urls:       cfg.URLs,
+		secret:     cfg.Secret,
+		client:     &http.Client{Timeout: timeout},
+		maxRetries: cfg.MaxRetries,
+		backoff:    backoff,
+		deadLetter: cfg.DeadLetterFile,
+		agents:     agents,
+		metrics:    metrics,
+		clock:      RealClock,
+		logger:     logger,
+	}
+	if n.maxRetries < 0 {
+		n.maxRetries = 0
+	}
+	if len(cfg.Events) > 0 {
+		n.events = make(map[string]bool, len(cfg.Events))
+		for _, e := range cfg.Events {
+			n.events[e] = true
+		}
+	}
+	return n
+}
+
+// SetClock replaces the time source (for tests)
+func (n *WebhookNotifier) SetClock(clock Clock) {
+	n.clock = clock
+}
+
+// Shutdown waits for deliveries in flight to finish or ctx to be done
+func (n *WebhookNotifier) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		n.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("agent webhooks still in flight: %w", ctx.Err())
+	}
+}
+
+// AgentConnected reports an agent connecting
+func (n *WebhookNotifier) AgentConnected(agentID, hostname string) {
+	n.emit(WebhookEvent{Event: WebhookEventConnect, AgentID: agentID, Hostname: hostname})
+}
+
+// AgentDisconnected reports an agent disconnecting, with the last metrics
+// it sent
+func (n *WebhookNotifier) AgentDisconnected(agentID, hostname string) {
+	n.emit(WebhookEvent{Event: WebhookEventDisconnect, AgentID: agentID, Hostname: hostname})
+}
+
+// Alert reports an alert raised for an agent. alert is sent as is.
+func (n *WebhookNotifier) Alert(agentID string, alert interface{}) {
+	event := WebhookEvent{Event: WebhookEventAlert, AgentID: agentID, Alert: alert}
+	if n.agents != nil {
+		if agent := n.agents.GetAgent(agentID); agent != nil {
+			event.Hostname = agent.Hostname
+		}
+	}
+	n.emit(event)
+}
+
+func (n *WebhookNotifier) emit(event WebhookEvent) {
+	if len(n.urls) == 0 || (n.events != nil && !n.events[event.Event]) {
+		return
+	}
+	event.Timestamp = n.clock.Now()
+	event.Metrics = n.lastMetrics(event.AgentID)
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		n.logger.Errorf("Failed to encode %s webhook for agent %s: %v", event.Event, event.AgentID, err)
+		return
+	}
+	for _, url := range n.urls {
+		n.inFlight.Add(1)
+		go func(url string) {
+			defer n.inFlight.Done()
+			n.deliver(url, event, body)
+		}(url)
+	}
+}
+
+// lastMetrics summarizes the agent's current metrics, if any
+func (n *WebhookNotifier) lastMetrics(agentID string) *WebhookMetrics {
+	if n.metrics == nil {
+		return nil
+	}
+	data := n.metrics.GetCurrentMetrics(agentID)
+	if data == nil {
+		return nil
+	}
+	summary := &WebhookMetrics{
+		Timestamp:   data.Timestamp,
+		CPUPercent:  data.CPU.UsagePercent,
+		LoadAverage: data.LoadAverage,
+	}
+	if data.Memory.Total > 0 {
+		summary.MemoryPercent = float64(data.Memory.Used) / float64(data.Memory.Total) * 100
+	}
+	return summary
+}
+
+// deliver posts body to url, retrying with exponential backoff, and
+// dead-letters the event if every attempt fails
+func (n *WebhookNotifier) deliver(url string, event WebhookEvent, body []byte) {
+	delay := n.backoff
+	var err error
+	for attempt := 0; attempt <= n.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		if err = n.post(url, event.Event, body); err == nil {
+			return
+		}
+		n.logger.Debugf("Webhook %s for agent %s to %s failed (attempt %d): %v", event.Event, event.AgentID, url, attempt+1, err)
+	}
+	n.writeDeadLetter(DeadLetter{
+		URL:      url,
+		Error:    err.Error(),
+		Attempts: n.maxRetries + 1,
+		FailedAt: n.clock.Now(),
+		Event:    event,
+	})
+}
+
+// post sends one event to one URL
+func (n *WebhookNotifier) post(url, eventType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(LifecycleEventHeader, eventType)
+	if n.secret != "" {
+		req.Header.Set(LifecycleSignatureHeader, "sha256="+SignWebhookBody(n.secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// writeDeadLetter logs an undeliverable event and appends it to the
+// dead-letter file, if configured, as a JSON line
+func (n *WebhookNotifier) writeDeadLetter(letter DeadLetter) {
+	n.logger.Errorf("Webhook %s for agent %s to %s failed after %d attempts, dead-lettered: %s",
+		letter.Event.Event, letter.Event.AgentID, letter.URL, letter.Attempts, letter.Error)
+	if n.deadLetter == "" {
+		return
+	}
+
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return
+	}
+	n.deadLetterMu.Lock()
+	defer n.deadLetterMu.Unlock()
+	f, err := os.OpenFile(n.deadLetter, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		n.logger.Errorf("Failed to open webhook dead-letter file: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		n.logger.Errorf("Failed to write webhook dead-letter file: %v", err)
+	}
+}
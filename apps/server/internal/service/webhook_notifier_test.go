@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenqi92/NanoLink/apps/server/internal/config"
+	"go.uber.org/zap"
+)
+
+// webhookReceiver records deliveries after refusing the first failures attempts
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	failures int
+	attempts int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func newWebhookReceiver(t *testing.T, failures int) *webhookReceiver {
+	r := &webhookReceiver{failures: failures}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.attempts++
+		if r.attempts <= r.failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r.bodies = append(r.bodies, body)
+		r.headers = append(r.headers, req.Header.Clone())
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func newTestWebhookNotifier(t *testing.T, cfg config.AgentWebhookConfig) (*WebhookNotifier, *MetricsService) {
+	logger := zap.NewNop().Sugar()
+	metrics := NewMetricsService(logger)
+	agents := NewAgentService(logger, metrics)
+	n := NewWebhookNotifier(cfg, agents, metrics, logger)
+	n.SetClock(NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+	n.backoff = time.Millisecond
+	return n, metrics
+}
+
+func flushWebhooks(t *testing.T, n *WebhookNotifier) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := n.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookNotifierRetriesAndSigns(t *testing.T) {
+	receiver := newWebhookReceiver(t, 2)
+	n, metrics := newTestWebhookNotifier(t, config.AgentWebhookConfig{
+		URLs:       []string{receiver.URL},
+		Secret:     "hook-secret",
+		MaxRetries: 3,
+	})
+	metrics.StoreMetrics("agent-1", &MetricsData{
+		CPU:    CPUData{UsagePercent: 42},
+		Memory: MemData{Total: 1000, Used: 250},
+	})
+
+	n.AgentDisconnected("agent-1", "web-1")
+	flushWebhooks(t, n)
+
+	if receiver.attempts != 3 || len(receiver.bodies) != 1 {
+		t.Fatalf("Expected delivery on the third attempt, got %d attempts and %d deliveries", receiver.attempts, len(receiver.bodies))
+	}
+	body, header := receiver.bodies[0], receiver.headers[0]
+	if got := header.Get(LifecycleSignatureHeader); got != "sha256="+SignWebhookBody("hook-secret", body) {
+		t.Errorf("Expected an HMAC signature of the body, got %q", got)
+	}
+	if got := header.Get(LifecycleEventHeader); got != WebhookEventDisconnect {
+		t.Errorf("Expected event header %q, got %q", WebhookEventDisconnect, got)
+	}
+
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Event != WebhookEventDisconnect || event.AgentID != "agent-1" || event.Hostname != "web-1" {
+		t.Errorf("Expected the disconnect of web-1, got %+v", event)
+	}
+	if !event.Timestamp.Equal(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the event timestamp from the clock, got %v", event.Timestamp)
+	}
+	if event.Metrics == nil || event.Metrics.CPUPercent != 42 || event.Metrics.MemoryPercent != 25 {
+		t.Errorf("Expected the last-seen metrics, got %+v", event.Metrics)
+	}
+}
+
+func TestWebhookNotifierFiltersEvents(t *testing.T) {
+	receiver := newWebhookReceiver(t, 0)
+	n, _ := newTestWebhookNotifier(t, config.AgentWebhookConfig{
+		URLs:   []string{receiver.URL},
+		Events: []string{WebhookEventDisconnect, WebhookEventAlert},
+	})
+
+	n.AgentConnected("agent-1", "web-1")
+	n.Alert("agent-1", AlertEvent{AgentID: "agent-1", Firing: true, Value: 97})
+	flushWebhooks(t, n)
+
+	if len(receiver.bodies) != 1 {
+		t.Fatalf("Expected only the alert to be sent, got %d deliveries", len(receiver.bodies))
+	}
+	var event struct {
+		Event string     `json:"event"`
+		Alert AlertEvent `json:"alert"`
+	}
+	if err := json.Unmarshal(receiver.bodies[0], &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Event != WebhookEventAlert || !event.Alert.Firing || event.Alert.Value != 97 {
+		t.Errorf("Expected the alert payload, got %+v", event)
+	}
+}
+
+func TestWebhookNotifierDeadLettersFailedDeliveries(t *testing.T) {
+	failing := newWebhookReceiver(t, 100)
+	working := newWebhookReceiver(t, 0)
+	deadLetters := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	n, _ := newTestWebhookNotifier(t, config.AgentWebhookConfig{
+		URLs:           []string{failing.URL, working.URL},
+		MaxRetries:     2,
+		DeadLetterFile: deadLetters,
+	})
+
+	n.AgentConnected("agent-1", "web-1")
+	n.AgentDisconnected("agent-1", "web-1")
+	flushWebhooks(t, n)
+
+	if failing.attempts != 6 {
+		t.Errorf("Expected 3 attempts per event at the failing URL, got %d", failing.attempts)
+	}
+	if len(working.bodies) != 2 {
+		t.Errorf("Expected the working URL to get both events, got %d", len(working.bodies))
+	}
+
+	data, err := os.ReadFile(deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 dead letters, got %d", len(lines))
+	}
+	for _, line := range lines {
+		var letter DeadLetter
+		if err := json.Unmarshal([]byte(line), &letter); err != nil {
+			t.Fatal(err)
+		}
+		if letter.URL != failing.URL || letter.Attempts != 3 || letter.Event.AgentID != "agent-1" || !strings.Contains(letter.Error, "503") {
+			t.Errorf("Unexpected dead letter %+v", letter)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package service
+
+// DefaultAgentWeight is the importance weight of agents without an explicit setting
+const DefaultAgentWeight = 1.0
+
+// Thresholds above which an agent is considered unhealthy in the weighted summary
+const (
+	UnhealthyCPUPercent    = 90.0
+	UnhealthyMemoryPercent = 90.0
+)
+
+// UnhealthyAgent describes a high-importance agent exceeding health thresholds
+type UnhealthyAgent struct {
+	AgentID       string  `json:"agentId"`
+	Weight        float64 `json:"weight"`
+	CPUPercent    float64 `json:"cpuPercent"`
+	MemoryPercent float64 `json:"memoryPercent"`
+}
+
+// SetAgentWeight sets the importance weight of an agent.
+// A weight <= 0 resets the agent to DefaultAgentWeight.
+func (s *MetricsService) SetAgentWeight(agentID string, weight float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if weight <= 0 {
+		delete(s.weights, agentID)
+		return
+	}
+	s.weights[agentID] = weight
+}
+
+// GetAgentWeight returns the importance weight of an agent
+func (s *MetricsService) GetAgentWeight(agentID string) float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.agentWeight(agentID)
+}
+
+// agentWeight returns the weight of an agent (internal, must hold lock)
+func (s *MetricsService) agentWeight(agentID string) float64 {
+	if w, ok := s.weights[agentID]; ok {
+		return w
+	}
+	return DefaultAgentWeight
+}
+
+// GetWeightedSummary returns a fleet summary where each agent contributes in
+// proportion to its importance weight. Agents weighted above the default that
+// exceed the CPU or memory thresholds are listed even if the fleet average
+// looks healthy. include limits the summary to some agents; nil includes all.
+func (s *MetricsService) GetWeightedSummary(include func(agentID string) bool) map[string]interface{} {
+	s.mu.RLock()
+	weights := make(map[string]float64, len(s.weights))
+	for id, w := range s.weights {
+		weights[id] = w
+	}
+	s.mu.RUnlock()
+
+	totalWeight := 0.0
+	weightedCPU := 0.0
+	weightedMem := 0.0
+	totalCPU := 0.0
+	totalMemPercent := 0.0
+	unhealthy := make([]UnhealthyAgent, 0)
+
+	agentCount := 0
+	s.forEachCurrent(func(agentID string, data *MetricsData) {
+		if include != nil && !include(agentID) {
+			return
+		}
+		agentCount++
+		weight, ok := weights[agentID]
+		if !ok {
+			weight = DefaultAgentWeight
+		}
+		memPercent := 0.0
+		if data.Memory.Total > 0 {
+			memPercent = float64(data.Memory.Used) / float64(data.Memory.Total) * 100
+		}
+
+		totalWeight += weight
+		weightedCPU += data.CPU.UsagePercent * weight
+		weightedMem += memPercent * weight
+		totalCPU += data.CPU.UsagePercent
+		totalMemPercent += memPercent
+
+		if weight > DefaultAgentWeight &&
+			(data.CPU.UsagePercent >= UnhealthyCPUPercent || memPercent >= UnhealthyMemoryPercent) {
+			unhealthy = append(unhealthy, UnhealthyAgent{
+				AgentID:       agentID,
+				Weight:        weight,
+				CPUPercent:    data.CPU.UsagePercent,
+				MemoryPercent: memPercent,
+			})
+		}
+	})
+
+	avgCPU, avgMem := 0.0, 0.0
+	if agentCount > 0 {
+		avgCPU = totalCPU / float64(agentCount)
+		avgMem = totalMemPercent / float64(agentCount)
+	}
+	wCPU, wMem := 0.0, 0.0
+	if totalWeight > 0 {
+		wCPU = weightedCPU / totalWeight
+		wMem = weightedMem / totalWeight
+	}
+
+	return map[string]interface{}{
+		"agentCount":               agentCount,
+		"totalWeight":              totalWeight,
+		"avgCpuPercent":            avgCPU,
+		"avgMemoryPercent":         avgMem,
+		"weightedCpuPercent":       wCPU,
+		"weightedMemoryPercent":    wMem,
+		"unhealthyImportantAgents": unhealthy,
+		"importantAgentsUnhealthy": len(unhealthy) > 0,
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestWeightedSummaryHighlightsImportantAgent(t *testing.T) {
+	ms := NewMetricsService(zap.NewNop().Sugar())
+
+	// Nine idle dev boxes and one saturated database server
+	for _, id := range []string{"dev-1", "dev-2", "dev-3", "dev-4", "dev-5", "dev-6", "dev-7", "dev-8", "dev-9"} {
+		ms.StoreMetrics(id, &MetricsData{
+			CPU:    CPUData{UsagePercent: 10},
+			Memory: MemData{Total: 100, Used: 20},
+		})
+	}
+	ms.StoreMetrics("db-1", &MetricsData{
+		CPU:    CPUData{UsagePercent: 100},
+		Memory: MemData{Total: 100, Used: 95},
+	})
+
+	equal := ms.GetWeightedSummary(nil)
+	if cpu := equal["weightedCpuPercent"].(float64); cpu != 19 {
+		t.Errorf("Expected equal-weight CPU 19%%, got %.2f", cpu)
+	}
+	if equal["importantAgentsUnhealthy"].(bool) {
+		t.Error("Expected no important agents flagged with default weights")
+	}
+
+	ms.SetAgentWeight("db-1", 9)
+
+	weighted := ms.GetWeightedSummary(nil)
+	if cpu := weighted["weightedCpuPercent"].(float64); cpu != 55 {
+		t.Errorf("Expected weighted CPU 55%%, got %.2f", cpu)
+	}
+	if avg := weighted["avgCpuPercent"].(float64); avg != 19 {
+		t.Errorf("Expected unweighted average to stay 19%%, got %.2f", avg)
+	}
+	if !weighted["importantAgentsUnhealthy"].(bool) {
+		t.Error("Expected unhealthy important agent to be flagged")
+	}
+	unhealthy := weighted["unhealthyImportantAgents"].([]UnhealthyAgent)
+	if len(unhealthy) != 1 || unhealthy[0].AgentID != "db-1" {
+		t.Errorf("Expected db-1 to be flagged, got %+v", unhealthy)
+	}
+}
+
+func TestAgentWeightDefault(t *testing.T) {
+	ms := NewMetricsService(zap.NewNop().Sugar())
+
+	if w := ms.GetAgentWeight("agent-1"); w != DefaultAgentWeight {
+		t.Errorf("Expected default weight, got %.2f", w)
+	}
+	ms.SetAgentWeight("agent-1", 5)
+	if w := ms.GetAgentWeight("agent-1"); w != 5 {
+		t.Errorf("Expected weight 5, got %.2f", w)
+	}
+	ms.SetAgentWeight("agent-1", 0)
+	if w := ms.GetAgentWeight("agent-1"); w != DefaultAgentWeight {
+		t.Errorf("Expected reset to default weight, got %.2f", w)
+	}
+}
@@ -1,20 +1,26 @@
-import { useState } from "react"
+import { useEffect, useState } from "react"
 import { useTranslation } from "react-i18next"
 import { Loader2 } from "lucide-react"
 import { Button } from "@/components/ui/button"
 import { Input } from "@/components/ui/input"
 import { Card, CardHeader, CardTitle, CardDescription, CardContent, CardFooter } from "@/components/ui/card"
 import { useAuth } from "@/contexts/AuthContext"
+import { authApi } from "@/lib/api"
 
 export function LoginForm() {
   const { t } = useTranslation()
-  const { login, register } = useAuth()
+  const { login, register, error: authError } = useAuth()
   const [mode, setMode] = useState<"login" | "register">("login")
   const [username, setUsername] = useState("")
   const [password, setPassword] = useState("")
   const [email, setEmail] = useState("")
   const [error, setError] = useState<string | null>(null)
   const [loading, setLoading] = useState(false)
+  const [ssoEnabled, setSsoEnabled] = useState(false)
+
+  useEffect(() => {
+    authApi.providers().then((p) => setSsoEnabled(p.oidc)).catch(() => {})
+  }, [])
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault()
@@ -67,9 +73,9 @@ export function LoginForm() {
           </CardHeader>
           <form onSubmit={handleSubmit}>
             <CardContent className="space-y-4">
-              {error && (
+              {(error || authError) && (
                 <div className="rounded-lg bg-red-500/10 border border-red-500/50 p-3 text-sm text-red-500">
-                  {error}
+                  {error || authError}
                 </div>
               )}
 
@@ -118,6 +124,16 @@ export function LoginForm() {
                 {loading && <Loader2 className="mr-2 h-4 w-4 animate-spin" />}
                 {mode === "login" ? t("auth.signIn") : t("auth.signUp")}
               </Button>
+              {mode === "login" && ssoEnabled && (
+                <Button
+                  type="button"
+                  variant="outline"
+                  className="w-full"
+                  onClick={() => window.location.assign(authApi.oidcLoginUrl)}
+                >
+                  {t("auth.signInWithSSO")}
+                </Button>
+              )}
               <Button
                 type="button"
                 variant="link"
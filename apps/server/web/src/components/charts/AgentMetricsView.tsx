@@ -3,7 +3,7 @@ import { useTranslation } from "react-i18next"
 import { ArrowLeft, RefreshCw, Calendar, AlertTriangle, Wifi, WifiOff } from "lucide-react"
 import { Button } from "@/components/ui/button"
 import { MetricsChart, type ChartDataPoint } from "./MetricsChart"
-import { api, type Metrics } from "@/lib/api"
+import { api, type Metrics, type MetricsRange } from "@/lib/api"
 import { useData } from "@/contexts/DataContext"
 
 interface AgentMetricsViewProps {
@@ -26,6 +26,10 @@ const timeRangeToMs: Record<Exclude<TimeRange, "custom">, number> = {
   "30d": 30 * 24 * 60 * 60 * 1000,
 }
 
+// Min/max band behind an averaged history sample
+const rangeBand = (range: MetricsRange | undefined, metric: "cpu" | "mem" | "gpu"): [number, number] | undefined =>
+  range ? [range[`${metric}Min`], range[`${metric}Max`]] : undefined
+
 // Map time range to aggregation interval
 const timeRangeToInterval: Record<Exclude<TimeRange, "custom">, string> = {
   "5m": "auto",
@@ -167,6 +171,7 @@ export function AgentMetricsView({ agentId, agentName, onBack }: AgentMetricsVie
     return history.map((m) => ({
       timestamp: m.timestamp,
       value: m.cpu?.usagePercent || 0,
+      band: rangeBand(m.range, "cpu"),
     }))
   }, [history])
 
@@ -178,6 +183,7 @@ export function AgentMetricsView({ agentId, agentName, onBack }: AgentMetricsVie
       return {
         timestamp: m.timestamp,
         value: (used / total) * 100,
+        band: rangeBand(m.range, "mem"),
       }
     })
   }, [history])
@@ -223,6 +229,7 @@ export function AgentMetricsView({ agentId, agentName, onBack }: AgentMetricsVie
         timestamp: m.timestamp,
         value: avgUsage,
         value2: avgTemp, // Temperature as secondary value
+        band: rangeBand(m.range, "gpu"),
       }
     })
   }, [history])
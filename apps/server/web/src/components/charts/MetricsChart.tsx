@@ -17,6 +17,7 @@ export interface ChartDataPoint {
   timestamp: string | number
   value: number
   value2?: number
+  band?: [number, number] // Min and max behind an averaged value
 }
 
 export interface MetricsChartProps {
@@ -99,7 +100,14 @@ export function MetricsChart({
         style={{ backgroundColor: bgColor }}
       >
         <p className="text-xs text-[var(--color-muted-foreground)] mb-1">{tooltipLabel}</p>
-        {payload.map((entry: { value: number; dataKey: string; color: string }, i: number) => {
+        {payload.map((entry: { value: number | [number, number]; dataKey: string; color: string }, i: number) => {
+          if (Array.isArray(entry.value)) {
+            return (
+              <p key={i} className="text-xs text-[var(--color-muted-foreground)]">
+                min {formatValue(entry.value[0], unit)} / max {formatValue(entry.value[1], unit)}
+              </p>
+            )
+          }
           const isValue2 = entry.dataKey === "value2"
           const displayUnit = isValue2 && unit2 ? unit2 : unit
           const displayLabel = isValue2 ? (label2 || "Value 2") : label
@@ -114,6 +122,7 @@ export function MetricsChart({
   }
 
   const ChartComponent = showArea ? AreaChart : LineChart
+  const hasBand = showArea && data.some((d) => d.band)
 
   return (
     <div className="rounded-lg border border-[var(--color-border)] bg-[var(--color-card)] p-4">
@@ -147,6 +156,17 @@ export function MetricsChart({
           )}
           {showArea ? (
             <>
+              {hasBand && (
+                <Area
+                  type="monotone"
+                  dataKey="band"
+                  stroke="none"
+                  fill={color}
+                  fillOpacity={0.12}
+                  dot={false}
+                  isAnimationActive={false}
+                />
+              )}
               <Area
                 type="monotone"
                 dataKey="value"
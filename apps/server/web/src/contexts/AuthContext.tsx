@@ -24,6 +24,17 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
 
   useEffect(() => {
     const initAuth = async () => {
+      // An OIDC login returns here with the tokens or an error in the fragment
+      const fragment = new URLSearchParams(window.location.hash.slice(1))
+      if (fragment.has('token') || fragment.has('oidcError')) {
+        window.history.replaceState(null, '', window.location.pathname + window.location.search)
+        if (fragment.get('token')) {
+          api.setToken(fragment.get('token'), fragment.get('refreshToken') ?? undefined)
+        } else {
+          setError(fragment.get('oidcError'))
+        }
+      }
+
       const storedToken = api.getToken()
       if (!storedToken) {
         setIsLoading(false)
@@ -51,7 +62,7 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
     setError(null)
     try {
       const response = await authApi.login({ username, password })
-      api.setToken(response.token)
+      api.setToken(response.token, response.refreshToken)
       setTokenState(response.token)
       setUser(response.user)
     } catch (err) {
@@ -68,7 +79,7 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
     setError(null)
     try {
       const response = await authApi.register({ username, password, email })
-      api.setToken(response.token)
+      api.setToken(response.token, response.refreshToken)
       setTokenState(response.token)
       setUser(response.user)
     } catch (err) {
@@ -81,6 +92,8 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
   }, [])
 
   const logout = useCallback(() => {
+    // Revoke the tokens server side; the local session ends regardless
+    authApi.logout().catch(() => {})
     api.setToken(null)
     setTokenState(null)
     setUser(null)
@@ -38,6 +38,10 @@ export function useWebSocket({
 }: UseWebSocketOptions) {
   const [status, setStatus] = useState<WebSocketStatus>('disconnected')
   const wsRef = useRef<WebSocket | null>(null)
+  // Server-Sent Events fallback, for proxies that break WebSocket upgrades
+  const esRef = useRef<EventSource | null>(null)
+  const sseFallbackRef = useRef(false)
+  const failedUpgradesRef = useRef(0)
   const reconnectTimeoutRef = useRef<ReturnType<typeof setTimeout> | null>(null)
   const pingIntervalRef = useRef<ReturnType<typeof setInterval> | null>(null)
   
@@ -70,15 +74,101 @@ export function useWebSocket({
       wsRef.current.close(1000, 'User disconnect')
       wsRef.current = null
     }
+    if (esRef.current) {
+      esRef.current.close()
+      esRef.current = null
+    }
     setStatus('disconnected')
   }, [])
 
+  const handleMessage = useCallback((raw: string) => {
+    try {
+      const msg = JSON.parse(raw) as DashboardMessage
+
+      switch (msg.type) {
+        case 'agents':
+          if (onAgentsRef.current && Array.isArray(msg.data)) {
+            onAgentsRef.current(msg.data as Agent[])
+          }
+          break
+
+        case 'metrics':
+          if (onMetricsRef.current && typeof msg.data === 'object' && msg.data !== null) {
+            // Handle both full metrics update and single-agent update
+            const data = msg.data as Record<string, unknown>
+            if ('agentId' in data && 'metrics' in data) {
+              // Single agent metrics update
+              const agentId = data.agentId as string
+              const metrics = data.metrics as Metrics
+              onMetricsRef.current({ [agentId]: metrics })
+            } else {
+              // Full metrics update
+              onMetricsRef.current(data as Record<string, Metrics>)
+            }
+          }
+          break
+
+        case 'agent_update':
+          if (onAgentUpdateRef.current && typeof msg.data === 'object' && msg.data !== null) {
+            const agent = msg.data as Agent
+            onAgentUpdateRef.current(agent.id, agent)
+          }
+          break
+
+        case 'agent_offline':
+          if (onAgentOfflineRef.current && typeof msg.data === 'string') {
+            onAgentOfflineRef.current(msg.data)
+          }
+          break
+
+        case 'summary':
+          if (onSummaryRef.current && typeof msg.data === 'object') {
+            onSummaryRef.current(msg.data as Summary)
+          }
+          break
+
+        case 'pong':
+          // Heartbeat response, ignore
+          break
+      }
+    } catch (e) {
+      console.error('[WS] Failed to parse message:', e)
+    }
+  }, [])
+
+  // connectSSE streams the same messages over /api/stream. EventSource
+  // reconnects by itself, resuming from the last event it saw.
+  const connectSSE = useCallback(() => {
+    if (!token) return
+    if (esRef.current) {
+      esRef.current.close()
+    }
+
+    setStatus('connecting')
+    const es = new EventSource(`/api/stream?token=${encodeURIComponent(token)}`)
+    esRef.current = es
+
+    es.onopen = () => {
+      console.log('[SSE] Dashboard event stream connected')
+      setStatus('connected')
+    }
+    es.onmessage = (event) => handleMessage(event.data)
+    es.onerror = () => {
+      setStatus(es.readyState === EventSource.CLOSED ? 'error' : 'connecting')
+    }
+  }, [token, handleMessage])
+
   const connect = useCallback(() => {
     if (!token) {
       setStatus('disconnected')
       return
     }
 
+    if (sseFallbackRef.current) {
+      connectSSE()
+      return
+    }
+
     // Clean up existing connection
     if (wsRef.current) {
       wsRef.current.close()
@@ -94,9 +184,12 @@ export function useWebSocket({
     console.log('[WS] Connecting to:', wsUrl)
     const ws = new WebSocket(wsUrl)
     wsRef.current = ws
+    let opened = false
 
     ws.onopen = () => {
       console.log('[WS] Dashboard WebSocket connected')
+      opened = true
+      failedUpgradesRef.current = 0
       setStatus('connected')
 
       // Start ping interval to keep connection alive
@@ -107,60 +200,7 @@ export function useWebSocket({
       }, 30000)
     }
 
-    ws.onmessage = (event) => {
-      try {
-        const msg = JSON.parse(event.data) as DashboardMessage
-
-        switch (msg.type) {
-          case 'agents':
-            if (onAgentsRef.current && Array.isArray(msg.data)) {
-              onAgentsRef.current(msg.data as Agent[])
-            }
-            break
-
-          case 'metrics':
-            if (onMetricsRef.current && typeof msg.data === 'object' && msg.data !== null) {
-              // Handle both full metrics update and single-agent update
-              const data = msg.data as Record<string, unknown>
-              if ('agentId' in data && 'metrics' in data) {
-                // Single agent metrics update
-                const agentId = data.agentId as string
-                const metrics = data.metrics as Metrics
-                onMetricsRef.current({ [agentId]: metrics })
-              } else {
-                // Full metrics update
-                onMetricsRef.current(data as Record<string, Metrics>)
-              }
-            }
-            break
-
-          case 'agent_update':
-            if (onAgentUpdateRef.current && typeof msg.data === 'object' && msg.data !== null) {
-              const agent = msg.data as Agent
-              onAgentUpdateRef.current(agent.id, agent)
-            }
-            break
-
-          case 'agent_offline':
-            if (onAgentOfflineRef.current && typeof msg.data === 'string') {
-              onAgentOfflineRef.current(msg.data)
-            }
-            break
-
-          case 'summary':
-            if (onSummaryRef.current && typeof msg.data === 'object') {
-              onSummaryRef.current(msg.data as Summary)
-            }
-            break
-
-          case 'pong':
-            // Heartbeat response, ignore
-            break
-        }
-      } catch (e) {
-        console.error('[WS] Failed to parse message:', e)
-      }
-    }
+    ws.onmessage = (event) => handleMessage(event.data)
 
     ws.onerror = (error) => {
       console.error('[WS] WebSocket error:', error)
@@ -177,6 +217,13 @@ export function useWebSocket({
         pingIntervalRef.current = null
       }
 
+      // Fall back to SSE when the upgrade keeps failing, e.g. behind a
+      // proxy that does not support WebSockets
+      if (!opened && ++failedUpgradesRef.current >= 2) {
+        console.log('[WS] WebSocket unavailable, falling back to Server-Sent Events')
+        sseFallbackRef.current = true
+      }
+
       // Attempt to reconnect if not intentionally closed
       if (token && event.code !== 1000) {
         reconnectTimeoutRef.current = setTimeout(() => {
@@ -185,7 +232,7 @@ export function useWebSocket({
         }, reconnectInterval)
       }
     }
-  }, [token, reconnectInterval]) // Only depend on token and reconnectInterval
+  }, [token, reconnectInterval, connectSSE, handleMessage]) // connectSSE and handleMessage only change with token
 
   // Connect when token changes
   useEffect(() => {
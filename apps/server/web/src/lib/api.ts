@@ -1,20 +1,60 @@
 const API_BASE = "/api"
 
+// Endpoints whose 401 must not trigger a token refresh
+const TOKEN_ENDPOINTS = ["/auth/login", "/auth/register", "/auth/refresh", "/auth/logout"]
+
 export interface ApiError {
   error: string
   status: number
+  // Every password policy requirement a new password failed
+  requirements?: { requirement: string; message: string }[]
 }
 
 class ApiClient {
   private token: string | null = null
+  private refreshing: Promise<boolean> | null = null
 
-  setToken(token: string | null) {
+  setToken(token: string | null, refreshToken?: string) {
     this.token = token
     if (token) {
       localStorage.setItem("nanolink_token", token)
     } else {
       localStorage.removeItem("nanolink_token")
     }
+    if (refreshToken) {
+      localStorage.setItem("nanolink_refresh_token", refreshToken)
+    } else if (!token) {
+      localStorage.removeItem("nanolink_refresh_token")
+    }
+  }
+
+  getRefreshToken(): string | null {
+    return localStorage.getItem("nanolink_refresh_token")
+  }
+
+  // Exchanges the refresh token for a new token pair; concurrent callers
+  // share one request since a refresh token only works once
+  private refresh(): Promise<boolean> {
+    if (!this.refreshing) {
+      this.refreshing = (async () => {
+        const refreshToken = this.getRefreshToken()
+        if (!refreshToken) return false
+        const response = await fetch(`${API_BASE}/auth/refresh`, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ refreshToken }),
+        })
+        if (!response.ok) return false
+        const data = (await response.json()) as AuthResponse
+        this.setToken(data.token, data.refreshToken)
+        return true
+      })()
+        .catch(() => false)
+        .finally(() => {
+          this.refreshing = null
+        })
+    }
+    return this.refreshing
   }
 
   getToken(): string | null {
@@ -35,7 +75,7 @@ class ApiClient {
     return headers
   }
 
-  async fetch<T>(url: string, options: RequestInit = {}): Promise<T> {
+  async fetch<T>(url: string, options: RequestInit = {}, retry = true): Promise<T> {
     const response = await fetch(`${API_BASE}${url}`, {
       ...options,
       headers: {
@@ -44,6 +84,10 @@ class ApiClient {
       },
     })
 
+    if (response.status === 401 && retry && !TOKEN_ENDPOINTS.includes(url) && (await this.refresh())) {
+      return this.fetch<T>(url, options, false)
+    }
+
     if (response.status === 401) {
       this.setToken(null)
       // Don't redirect - let the auth hooks handle this
@@ -52,7 +96,7 @@ class ApiClient {
 
     if (!response.ok) {
       const data = await response.json().catch(() => ({}))
-      throw { error: data.error || "Request failed", status: response.status } as ApiError
+      throw { error: data.error || "Request failed", status: response.status, requirements: data.requirements } as ApiError
     }
 
     return response.json()
@@ -97,6 +141,8 @@ export interface RegisterRequest {
 
 export interface AuthResponse {
   token: string
+  refreshToken: string
+  expiresAt: string
   user: User
 }
 
@@ -133,6 +179,18 @@ export interface Metrics {
   userSessions: UserSession[]
   systemInfo?: SystemInfo
   loadAverage: number[]
+  // Min/max behind averaged history samples
+  range?: MetricsRange
+}
+
+export interface MetricsRange {
+  cpuMin: number
+  cpuMax: number
+  memMin: number
+  memMax: number
+  gpuMin: number
+  gpuMax: number
+  samples: number
 }
 
 export interface CpuMetrics {
@@ -261,13 +319,17 @@ export const authApi = {
   login: (data: LoginRequest) => api.post<AuthResponse>("/auth/login", data),
   register: (data: RegisterRequest) => api.post<AuthResponse>("/auth/register", data),
   me: () => api.get<User>("/auth/me"),
+  logout: () => api.post<{ message: string }>("/auth/logout", { refreshToken: api.getRefreshToken() }),
+  providers: () => api.get<{ local: boolean; oidc: boolean }>("/auth/providers"),
+  // Single sign-on is a full page redirect through the identity provider
+  oidcLoginUrl: `${API_BASE}/auth/oidc/login`,
 }
 
 export const agentsApi = {
   list: () => api.get<Agent[]>("/agents"),
   get: (id: string) => api.get<Agent>(`/agents/${id}`),
   sendCommand: (id: string, command: string) =>
-    api.post<{ status: string }>(`/agents/${id}/command`, { type: "shell", command }),
+    api.post<{ status: string }>(`/agents/${id}/command`, { type: "SHELL_EXECUTE", target: command }),
 }
 
 export const metricsApi = {
@@ -6,6 +6,7 @@ toolchain go1.24.5
 
 require (
 	github.com/google/uuid v1.6.0
+	github.com/gorilla/websocket v1.5.3
 	google.golang.org/grpc v1.78.0
 	google.golang.org/protobuf v1.36.11
 )
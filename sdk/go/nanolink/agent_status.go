@@ -0,0 +1,85 @@
+package nanolink
+
+import (
+	"log"
+	"time"
+)
+
+// AgentStatus is an agent's health, derived from its heartbeat age
+type AgentStatus string
+
+// Agent health statuses
+const (
+	AgentStatusHealthy AgentStatus = "healthy" // Heartbeats arriving on schedule
+	AgentStatusStale   AgentStatus = "stale"   // Heartbeats late; losing contact
+	AgentStatusDead    AgentStatus = "dead"    // No heartbeat within HeartbeatTimeout
+)
+
+// Status returns the agent's health: healthy until its last heartbeat is
+// Config.HeartbeatStaleAfter old, stale until it is past
+// Config.HeartbeatTimeout, then dead, matching when it is disconnected
+func (c *AgentConnection) Status() AgentStatus {
+	c.mu.Lock()
+	staleAfter, deadAfter := c.staleAfter, c.deadAfter
+	c.mu.Unlock()
+	if staleAfter == 0 {
+		staleAfter = 2 * DefaultHeartbeatInterval
+	}
+	if deadAfter == 0 {
+		deadAfter = DefaultHeartbeatTimeout
+	}
+
+	age := c.HeartbeatAge()
+	switch {
+	case age > deadAfter:
+		return AgentStatusDead
+	case age >= staleAfter:
+		return AgentStatusStale
+	default:
+		return AgentStatusHealthy
+	}
+}
+
+// setHealthThresholds sets the heartbeat ages Status compares against
+func (c *AgentConnection) setHealthThresholds(staleAfter, deadAfter time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.staleAfter = staleAfter
+	c.deadAfter = deadAfter
+}
+
+// updateStatus records the agent's current status, returning the previous
+// one and whether it changed
+func (c *AgentConnection) updateStatus() (previous, current AgentStatus, changed bool) {
+	current = c.Status()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous = c.lastStatus
+	if previous == "" {
+		previous = AgentStatusHealthy
+	}
+	c.lastStatus = current
+	return previous, current, previous != current
+}
+
+// OnAgentStatusChange sets the callback for when an agent's health status
+// changes, e.g. to show agents losing contact before they are disconnected
+func (s *Server) OnAgentStatusChange(callback func(agent *AgentConnection, previous, current AgentStatus)) {
+	s.onAgentStatusChange = callback
+}
+
+// notifyStatusChanges reports each agent whose status changed since the
+// previous heartbeat check
+func (s *Server) notifyStatusChanges(agents []*AgentConnection) {
+	for _, agent := range agents {
+		previous, current, changed := agent.updateStatus()
+		if !changed {
+			continue
+		}
+		log.Printf("Agent %s (%s) is %s, was %s (heartbeat age: %v)",
+			agent.Hostname, agent.AgentID, current, previous, agent.HeartbeatAge())
+		if s.onAgentStatusChange != nil {
+			s.onAgentStatusChange(agent, previous, current)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package nanolink
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAgentStatusFollowsHeartbeatAge(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	server := NewServer(Config{
+		HeartbeatStaleAfter: 60 * time.Second,
+		HeartbeatTimeout:    90 * time.Second,
+		Clock:               clock,
+	})
+
+	type change struct {
+		host              string
+		previous, current AgentStatus
+	}
+	var changes []change
+	server.OnAgentStatusChange(func(a *AgentConnection, previous, current AgentStatus) {
+		changes = append(changes, change{a.Hostname, previous, current})
+	})
+	var disconnected []string
+	server.OnAgentDisconnect(func(a *AgentConnection) {
+		disconnected = append(disconnected, a.Hostname)
+	})
+
+	agent := NewAgentConnectionFromGRPC("web-1", "linux", "amd64", "0.3.0", PermissionReadOnly)
+	server.registerAgent(agent)
+	if agent.Status() != AgentStatusHealthy {
+		t.Fatalf("Expected a new agent to be healthy, got %s", agent.Status())
+	}
+
+	clock.Advance(59 * time.Second)
+	server.checkHeartbeatTimeouts()
+	if len(changes) != 0 {
+		t.Fatalf("Expected no change while healthy, got %v", changes)
+	}
+
+	clock.Advance(time.Second)
+	server.checkHeartbeatTimeouts()
+	if agent.Status() != AgentStatusStale || len(changes) != 1 || changes[0] != (change{"web-1", AgentStatusHealthy, AgentStatusStale}) {
+		t.Fatalf("Expected the agent to go stale, got %s and %v", agent.Status(), changes)
+	}
+	server.checkHeartbeatTimeouts()
+	if len(changes) != 1 {
+		t.Fatalf("Expected one event per change, got %v", changes)
+	}
+
+	// A heartbeat brings it back
+	agent.UpdateHeartbeat()
+	server.checkHeartbeatTimeouts()
+	if len(changes) != 2 || changes[1].current != AgentStatusHealthy {
+		t.Fatalf("Expected the agent to recover, got %v", changes)
+	}
+
+	// Reported dead before it is disconnected
+	clock.Advance(91 * time.Second)
+	server.checkHeartbeatTimeouts()
+	if len(changes) != 3 || changes[2] != (change{"web-1", AgentStatusHealthy, AgentStatusDead}) {
+		t.Fatalf("Expected the agent to be reported dead, got %v", changes)
+	}
+	if len(disconnected) != 1 {
+		t.Errorf("Expected the dead agent to be disconnected, got %v", disconnected)
+	}
+}
+
+func TestHeartbeatStaleAfterDefaultsToTwoCheckIntervals(t *testing.T) {
+	server := NewServer(Config{HeartbeatCheckInterval: 10 * time.Second})
+	if server.config.HeartbeatStaleAfter != 20*time.Second {
+		t.Errorf("Expected 20s, got %v", server.config.HeartbeatStaleAfter)
+	}
+}
@@ -0,0 +1,126 @@
+package nanolink
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// Policies for a full callback queue
+const (
+	// CallbackOverflowDropOldest discards the oldest queued callback
+	CallbackOverflowDropOldest = "drop-oldest"
+	// CallbackOverflowDropNewest discards the callback being queued
+	CallbackOverflowDropNewest = "drop-newest"
+	// CallbackOverflowBlock waits for room, backing up the agent stream
+	CallbackOverflowBlock = "block"
+)
+
+// CallbackQueueStats reports the state of the callback queue
+type CallbackQueueStats struct {
+	Queued  int    `json:"queued"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// callbackQueue runs data callbacks on a single worker so slow consumers do
+// not block stream processing, holding at most a fixed number of them
+type callbackQueue struct {
+	items    chan func()
+	overflow string
+	mu       sync.Mutex // Serializes drop-oldest so each drop makes room
+	dropped  atomic.Uint64
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newCallbackQueue(size int, overflow string) *callbackQueue {
+	switch overflow {
+	case CallbackOverflowDropOldest, CallbackOverflowDropNewest, CallbackOverflowBlock:
+	default:
+		if overflow != "" {
+			log.Printf("WARNING: unknown callback overflow policy %q, using %s", overflow, CallbackOverflowDropOldest)
+		}
+		overflow = CallbackOverflowDropOldest
+	}
+	q := &callbackQueue{
+		items:    make(chan func(), size),
+		overflow: overflow,
+		stop:     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *callbackQueue) run() {
+	for {
+		select {
+		case fn := <-q.items:
+			fn()
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// enqueue adds a callback, applying the overflow policy when the queue is full
+func (q *callbackQueue) enqueue(fn func()) {
+	switch q.overflow {
+	case CallbackOverflowBlock:
+		select {
+		case q.items <- fn:
+		case <-q.stop:
+		}
+	case CallbackOverflowDropNewest:
+		select {
+		case q.items <- fn:
+		default:
+			q.dropped.Add(1)
+		}
+	default:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for {
+			select {
+			case q.items <- fn:
+				return
+			default:
+			}
+			select {
+			case <-q.items:
+				q.dropped.Add(1)
+			default:
+			}
+		}
+	}
+}
+
+func (q *callbackQueue) stats() CallbackQueueStats {
+	return CallbackQueueStats{Queued: len(q.items), Dropped: q.dropped.Load()}
+}
+
+// close stops the worker; callbacks still queued are discarded
+func (q *callbackQueue) close() {
+	q.stopOnce.Do(func() { close(q.stop) })
+}
+
+// CallbackQueueStats returns the callback queue's length and how many
+// callbacks it has dropped. It is zero when Config.CallbackQueueSize is unset.
+func (s *Server) CallbackQueueStats() CallbackQueueStats {
+	if s.callbacks == nil {
+		return CallbackQueueStats{}
+	}
+	return s.callbacks.stats()
+}
+
+// dispatch runs a data callback through the queue if one is configured,
+// otherwise on its own goroutine with AsyncCallbacks or inline
+func (s *Server) dispatch(fn func()) {
+	switch {
+	case s.callbacks != nil:
+		s.callbacks.enqueue(fn)
+	case s.config.AsyncCallbacks:
+		go fn()
+	default:
+		fn()
+	}
+}
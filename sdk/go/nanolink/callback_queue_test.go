@@ -0,0 +1,78 @@
+package nanolink
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/chenqi92/NanoLink/sdk/go/nanolink/proto"
+)
+
+func TestSlowCallbackDropsOldestQueued(t *testing.T) {
+	server := NewServer(Config{CallbackQueueSize: 2, CallbackOverflow: CallbackOverflowDropOldest})
+	defer server.Stop()
+	servicer := NewNanoLinkServicer(server)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	delivered := make(chan float64, 10)
+	server.OnMetrics(func(m *Metrics) {
+		if m.CPU.UsagePercent == 0 {
+			close(started)
+			<-release
+		}
+		delivered <- m.CPU.UsagePercent
+	})
+
+	// Keep the worker busy so the stream's messages pile up in the queue
+	server.handleMetrics(&Metrics{CPU: &CPUMetrics{}})
+	<-started
+
+	var requests []*pb.MetricsStreamRequest
+	for i := 1; i <= 5; i++ {
+		requests = append(requests, &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_Metrics{
+			Metrics: &pb.Metrics{Hostname: "web-1", Cpu: &pb.CpuMetrics{UsagePercent: float64(i)}},
+		}})
+	}
+	done := make(chan error, 1)
+	go func() { done <- servicer.StreamMetrics(&scriptedStream{requests: requests}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the stream to keep ingesting while the callback is blocked")
+	}
+
+	if stats := server.CallbackQueueStats(); stats.Dropped != 3 || stats.Queued != 2 {
+		t.Errorf("Expected 3 dropped and 2 queued callbacks, got %+v", stats)
+	}
+
+	close(release)
+	var got []float64
+	for len(got) < 3 {
+		select {
+		case v := <-delivered:
+			got = append(got, v)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected 3 callbacks to run, got %v", got)
+		}
+	}
+	if got[1] != 4 || got[2] != 5 {
+		t.Errorf("Expected the newest metrics to be kept, got %v", got)
+	}
+}
+
+func TestCallbackQueueDropNewest(t *testing.T) {
+	q := &callbackQueue{items: make(chan func(), 1), overflow: CallbackOverflowDropNewest, stop: make(chan struct{})}
+	var ran []int
+	q.enqueue(func() { ran = append(ran, 1) })
+	q.enqueue(func() { ran = append(ran, 2) })
+	if stats := q.stats(); stats.Dropped != 1 || stats.Queued != 1 {
+		t.Fatalf("Expected the second callback to be dropped, got %+v", stats)
+	}
+	(<-q.items)()
+	if len(ran) != 1 || ran[0] != 1 {
+		t.Errorf("Expected the first callback to be kept, got %v", ran)
+	}
+}
@@ -71,9 +71,11 @@ func (c *Command) ToProtobuf() []byte {
 // CommandResult represents the result of a command execution
 type CommandResult struct {
 	CommandID   string          `json:"commandId"`
+	AgentID     string          `json:"agentId,omitempty"`
 	Success     bool            `json:"success"`
 	Output      string          `json:"output"`
 	Error       string          `json:"error"`
+	ExitCode    int             `json:"exitCode"` // 0 unless the agent reports one
 	FileContent []byte          `json:"fileContent,omitempty"`
 	Processes   []ProcessInfo   `json:"processes,omitempty"`
 	Containers  []ContainerInfo `json:"containers,omitempty"`
@@ -0,0 +1,26 @@
+package nanolink
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/encoding/gzip"
+)
+
+// Importing the gzip codec registers it with gRPC, so agents can opt in to
+// compressing their messages by sending them with grpc-encoding: gzip.
+// Responses on their streams, such as commands, are then compressed the same way.
+
+// CompressionGzip is the name agents use to negotiate gzip compression
+const CompressionGzip = gzip.Name
+
+// setCompressionLevel sets the gzip level for compressed messages, leaving
+// gzip's default when level is 0
+func setCompressionLevel(level int) error {
+	if level == 0 {
+		return nil
+	}
+	if err := gzip.SetLevel(level); err != nil {
+		return fmt.Errorf("invalid gRPC compression level %d: %w", level, err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package nanolink
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "github.com/chenqi92/NanoLink/sdk/go/nanolink/proto"
+)
+
+// payloadStats records the encoded and on-the-wire size of sent messages
+type payloadStats struct {
+	mu           sync.Mutex
+	length, wire int
+}
+
+func (p *payloadStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context   { return ctx }
+func (p *payloadStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context { return ctx }
+func (p *payloadStats) HandleConn(context.Context, stats.ConnStats)                       {}
+func (p *payloadStats) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if out, ok := s.(*stats.OutPayload); ok {
+		p.mu.Lock()
+		p.length += out.Length
+		p.wire += out.WireLength
+		p.mu.Unlock()
+	}
+}
+
+// fullMetrics builds a full metrics message for a large host
+func fullMetrics() *pb.Metrics {
+	m := &pb.Metrics{
+		Hostname: "db-primary-01",
+		Cpu: &pb.CpuMetrics{
+			UsagePercent:  37.5,
+			CoreCount:     64,
+			Model:         "AMD EPYC 7543 32-Core Processor",
+			Vendor:        "AMD",
+			Architecture:  "x86_64",
+			LogicalCores:  64,
+			PhysicalCores: 32,
+		},
+		Memory:      &pb.MemoryMetrics{Total: 512 << 30, Used: 301 << 30},
+		LoadAverage: []float64{12.4, 10.1, 9.7},
+	}
+	for i := 0; i < 64; i++ {
+		m.Cpu.PerCoreUsage = append(m.Cpu.PerCoreUsage, float64(20+i%40)+0.25)
+	}
+	for i := 0; i < 24; i++ {
+		m.Disks = append(m.Disks, &pb.DiskMetrics{
+			MountPoint:   fmt.Sprintf("/data/%02d", i),
+			Device:       fmt.Sprintf("/dev/nvme%dn1", i),
+			FsType:       "xfs",
+			Total:        3840 << 30,
+			Used:         uint64(1000+i*37) << 30,
+			Available:    uint64(2840-i*37) << 30,
+			Model:        "Samsung SSD PM9A3 3.84TB",
+			Serial:       fmt.Sprintf("S64HNE0R%06d", 100000+i),
+			DiskType:     "NVMe",
+			HealthStatus: "PASSED",
+		})
+	}
+	for i := 0; i < 8; i++ {
+		m.Gpus = append(m.Gpus, &pb.GpuMetrics{
+			Index:          uint32(i),
+			Name:           "NVIDIA A100-SXM4-80GB",
+			Vendor:         "NVIDIA",
+			UsagePercent:   float64(50 + i),
+			MemoryTotal:    80 << 30,
+			MemoryUsed:     uint64(40+i) << 30,
+			DriverVersion:  "535.129.03",
+			PcieGeneration: "Gen4 x16",
+		})
+	}
+	for i := 0; i < 16; i++ {
+		m.UserSessions = append(m.UserSessions, &pb.UserSession{
+			Username:    fmt.Sprintf("user%02d", i),
+			Tty:         fmt.Sprintf("pts/%d", i),
+			RemoteHost:  fmt.Sprintf("10.0.%d.%d", i/4, 10+i),
+			SessionType: "ssh",
+		})
+	}
+	return m
+}
+
+// reportMetrics sends a full metrics message to the SDK's gRPC server,
+// returning the encoded and on-the-wire size of what was sent
+func reportMetrics(t *testing.T, callOpts ...grpc.CallOption) (length, wire int) {
+	t.Helper()
+	server := NewServer(Config{})
+	received := make(chan *Metrics, 1)
+	server.OnMetrics(func(m *Metrics) { received <- m })
+
+	lis := bufconn.Listen(1 << 20)
+	grpcServer := CreateGRPCServer(NewNanoLinkServicer(server))
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	sent := &payloadStats{}
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(sent),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	ack, err := pb.NewNanoLinkServiceClient(conn).ReportMetrics(context.Background(), fullMetrics(), callOpts...)
+	if err != nil || !ack.Success {
+		t.Fatalf("ReportMetrics failed: %v (%v)", ack, err)
+	}
+	if m := <-received; m.Hostname != "db-primary-01" || len(m.Disks) != 24 {
+		t.Fatalf("Expected the metrics to arrive intact, got %+v", m)
+	}
+	return sent.length, sent.wire
+}
+
+func TestAgentsCanNegotiateGzip(t *testing.T) {
+	length, plain := reportMetrics(t)
+	if plain < length {
+		t.Fatalf("Expected an uncompressed message, got %d of %d bytes on the wire", plain, length)
+	}
+
+	_, compressed := reportMetrics(t, grpc.UseCompressor(CompressionGzip))
+	reduction := 100 * (1 - float64(compressed)/float64(plain))
+	t.Logf("Full metrics message: %d bytes plain, %d bytes gzip (%.0f%% smaller)", plain, compressed, reduction)
+	// Repeated models, mount points and labels compress well: around 75% smaller
+	if reduction < 50 {
+		t.Errorf("Expected gzip to at least halve the message, got %.0f%% smaller", reduction)
+	}
+}
+
+func TestSetCompressionLevel(t *testing.T) {
+	if err := setCompressionLevel(0); err != nil {
+		t.Errorf("Expected 0 to keep the default, got %v", err)
+	}
+	if err := setCompressionLevel(10); err == nil {
+		t.Error("Expected an out of range level to be rejected")
+	}
+	defer setCompressionLevel(-1) // gzip.DefaultCompression
+	if err := setCompressionLevel(9); err != nil {
+		t.Errorf("Expected level 9 to be accepted, got %v", err)
+	}
+}
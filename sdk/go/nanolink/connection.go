@@ -16,6 +16,7 @@ type AgentConnection struct {
 	Arch            string
 	Version         string
 	PermissionLevel int
+	Labels          map[string]string // Sent by the agent when it authenticates
 	ConnectedAt     time.Time
 	LastHeartbeat   time.Time
 	LastMetrics     *Metrics
@@ -23,6 +24,10 @@ type AgentConnection struct {
 	// For gRPC stream management
 	streamSend func(interface{}) error
 
+	clock       Clock
+	staleAfter  time.Duration // Heartbeat age at which Status turns stale
+	deadAfter   time.Duration // Heartbeat age at which Status turns dead
+	lastStatus  AgentStatus   // Status as of the last heartbeat check
 	mu          sync.Mutex
 	done        chan struct{}
 	closed      bool // Track if connection is closed
@@ -41,14 +46,24 @@ func (c *AgentConnection) IsClosed() bool {
 func (c *AgentConnection) UpdateHeartbeat() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.LastHeartbeat = time.Now()
+	c.LastHeartbeat = c.clock.Now()
 }
 
 // HeartbeatAge returns the duration since last heartbeat
 func (c *AgentConnection) HeartbeatAge() time.Duration {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return time.Since(c.LastHeartbeat)
+	return c.clock.Since(c.LastHeartbeat)
+}
+
+// setClock switches the connection to the given time source and restarts
+// its connection and heartbeat timestamps from it
+func (c *AgentConnection) setClock(clock Clock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock = clock
+	c.ConnectedAt = clock.Now()
+	c.LastHeartbeat = c.ConnectedAt
 }
 
 // NewAgentConnectionFromGRPC creates a new agent connection from gRPC stream
@@ -62,6 +77,7 @@ func NewAgentConnectionFromGRPC(hostname, os, arch, version string, permissionLe
 		PermissionLevel: permissionLevel,
 		ConnectedAt:     time.Now(),
 		LastHeartbeat:   time.Now(),
+		clock:           RealClock,
 		done:            make(chan struct{}),
 		pendingCmds:     make(map[string]chan *CommandResult),
 	}
@@ -2,10 +2,12 @@ package nanolink
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
@@ -19,6 +21,8 @@ type AgentStream struct {
 	Stream   pb.NanoLinkService_StreamMetricsServer
 	Agent    *AgentConnection
 	IsActive bool // Track if stream is still active
+
+	sender *streamSender
 }
 
 // NanoLinkServicer implements the NanoLinkService gRPC server
@@ -28,8 +32,11 @@ type NanoLinkServicer struct {
 	server         *Server
 	tokenValidator TokenValidator
 	streamAgents   map[interface{}]*AgentConnection
-	agentStreams   map[string]*AgentStream // agentID -> stream
-	hostnameIndex  map[string]string       // hostname -> agentID for quick lookup
+	senders        map[interface{}]*streamSender // one per open stream
+	agentStreams   map[string]*AgentStream       // agentID -> stream
+	hostnameIndex  map[string]string             // hostname -> agentID for quick lookup
+	syncBuffer     syncBuffer                    // Recently received metrics for SyncMetrics
+	reaped         atomic.Uint64                 // Stale stream entries removed by reapStaleStreams
 	mu             sync.RWMutex
 }
 
@@ -39,12 +46,15 @@ func NewNanoLinkServicer(server *Server) *NanoLinkServicer {
 		server:         server,
 		tokenValidator: server.config.TokenValidator,
 		streamAgents:   make(map[interface{}]*AgentConnection),
+		senders:        make(map[interface{}]*streamSender),
 		agentStreams:   make(map[string]*AgentStream),
 		hostnameIndex:  make(map[string]string),
 	}
 }
 
-// cleanupAgent safely removes an agent from all internal maps
+// cleanupAgent safely removes an agent from all internal maps. Entries
+// that now belong to another agent or stream, such as a replacement
+// connection for the same hostname, are left alone.
 func (s *NanoLinkServicer) cleanupAgent(agent *AgentConnection, stream interface{}) {
 	if agent == nil {
 		return
@@ -54,14 +64,17 @@ func (s *NanoLinkServicer) cleanupAgent(agent *AgentConnection, stream interface
 	defer s.mu.Unlock()
 
 	// Mark stream as inactive
-	if agentStream, ok := s.agentStreams[agent.AgentID]; ok {
+	if agentStream, ok := s.agentStreams[agent.AgentID]; ok && agentStream.Agent == agent {
 		agentStream.IsActive = false
+		delete(s.agentStreams, agent.AgentID)
 	}
 
-	// Remove from all maps
-	delete(s.streamAgents, stream)
-	delete(s.agentStreams, agent.AgentID)
-	delete(s.hostnameIndex, agent.Hostname)
+	if owner, ok := s.streamAgents[stream]; ok && owner == agent {
+		delete(s.streamAgents, stream)
+	}
+	if s.hostnameIndex[agent.Hostname] == agent.AgentID {
+		delete(s.hostnameIndex, agent.Hostname)
+	}
 }
 
 // registerAgentStream registers an agent and its stream
@@ -69,15 +82,37 @@ func (s *NanoLinkServicer) registerAgentStream(agent *AgentConnection, stream pb
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	// A stream carries one agent; drop whatever it registered before
+	if previous, ok := s.streamAgents[stream]; ok && previous != agent {
+		s.removeStreamLocked(previous.AgentID, previous)
+	}
+
 	s.streamAgents[stream] = agent
 	s.agentStreams[agent.AgentID] = &AgentStream{
 		Stream:   stream,
 		Agent:    agent,
 		IsActive: true,
+		sender:   s.senderLocked(stream),
 	}
 	s.hostnameIndex[agent.Hostname] = agent.AgentID
 }
 
+// senderFor returns the sender every send on stream goes through
+func (s *NanoLinkServicer) senderFor(stream pb.NanoLinkService_StreamMetricsServer) *streamSender {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.senderLocked(stream)
+}
+
+func (s *NanoLinkServicer) senderLocked(stream pb.NanoLinkService_StreamMetricsServer) *streamSender {
+	sender, ok := s.senders[stream]
+	if !ok {
+		sender = newStreamSender(stream)
+		s.senders[stream] = sender
+	}
+	return sender
+}
+
 // getAgentStreamByHostname returns the agent stream for a hostname
 func (s *NanoLinkServicer) getAgentStreamByHostname(hostname string) (*AgentStream, bool) {
 	s.mu.RLock()
@@ -123,6 +158,7 @@ func (s *NanoLinkServicer) Authenticate(ctx context.Context, req *pb.AuthRequest
 			req.AgentVersion,
 			result.PermissionLevel,
 		)
+		agent.Labels = req.Labels
 		agentID := agent.AgentID
 
 		s.server.registerAgent(agent)
@@ -141,8 +177,9 @@ func (s *NanoLinkServicer) Authenticate(ctx context.Context, req *pb.AuthRequest
 		errMsg = "Invalid token"
 	}
 	return &pb.AuthResponse{
-		Success:      false,
-		ErrorMessage: errMsg,
+		Success:       false,
+		ErrorMessage:  errMsg,
+		FailureReason: pb.AuthFailureReason_AUTH_FAILURE_INVALID_TOKEN,
 	}, nil
 }
 
@@ -152,15 +189,23 @@ func (s *NanoLinkServicer) StreamMetrics(stream pb.NanoLinkService_StreamMetrics
 
 	var agent *AgentConnection
 	var agentID string
+	metricsLimit := newIngestBucket("metrics", s.server.config.MaxMetricsPerSec)
+	realtimeLimit := newIngestBucket("realtime", s.server.config.MaxRealtimePerSec)
+	sender := s.senderFor(stream)
+	defer func() {
+		s.mu.Lock()
+		delete(s.senders, stream)
+		s.mu.Unlock()
+	}()
 
 	// Send initial heartbeat ack to establish stream
-	if err := stream.Send(&pb.MetricsStreamResponse{
+	if err := sender.send(&pb.MetricsStreamResponse{
 		Response: &pb.MetricsStreamResponse_HeartbeatAck{
 			HeartbeatAck: &pb.HeartbeatAck{
 				Timestamp: uint64(time.Now().UnixMilli()),
 			},
 		},
-	}); err != nil {
+	}, 0); err != nil {
 		return err
 	}
 	log.Printf("Sent initial heartbeat ack")
@@ -170,6 +215,7 @@ func (s *NanoLinkServicer) StreamMetrics(stream pb.NanoLinkService_StreamMetrics
 			agent.Close()
 			s.server.unregisterAgent(agent)
 			s.cleanupAgent(agent, stream)
+			s.syncBuffer.release(agentID, s.server.config.Clock.Now(), s.server.config.SyncBufferRetention)
 			log.Printf("Agent disconnected: %s (%s)", agent.Hostname, agentID)
 		}
 	}()
@@ -187,6 +233,10 @@ func (s *NanoLinkServicer) StreamMetrics(stream pb.NanoLinkService_StreamMetrics
 		switch payload := req.Request.(type) {
 		case *pb.MetricsStreamRequest_Metrics:
 			protoMetrics := payload.Metrics
+			// The registering message is never dropped
+			if agent != nil && !metricsLimit.admit(agent, s.server.config.Clock.Now()) {
+				continue
+			}
 
 			// Register agent from first metrics
 			if agent == nil {
@@ -232,6 +282,7 @@ func (s *NanoLinkServicer) StreamMetrics(stream pb.NanoLinkService_StreamMetrics
 			// Convert and handle metrics
 			sdkMetrics := s.convertMetrics(protoMetrics)
 			sdkMetrics.Hostname = agent.Hostname
+			s.bufferForSync(agentID, protoMetrics)
 			s.server.handleMetrics(sdkMetrics)
 
 		case *pb.MetricsStreamRequest_Heartbeat:
@@ -239,19 +290,19 @@ func (s *NanoLinkServicer) StreamMetrics(stream pb.NanoLinkService_StreamMetrics
 				agent.UpdateHeartbeat()
 			}
 			// Send heartbeat ack
-			if err := stream.Send(&pb.MetricsStreamResponse{
+			if err := sender.send(&pb.MetricsStreamResponse{
 				Response: &pb.MetricsStreamResponse_HeartbeatAck{
 					HeartbeatAck: &pb.HeartbeatAck{
 						Timestamp: uint64(time.Now().UnixMilli()),
 					},
 				},
-			}); err != nil {
+			}, 0); err != nil {
 				return err
 			}
 
 		case *pb.MetricsStreamRequest_Realtime:
 			protoRealtime := payload.Realtime
-			if agent != nil {
+			if agent != nil && realtimeLimit.admit(agent, s.server.config.Clock.Now()) {
 				sdkRealtime := s.convertRealtimeMetrics(protoRealtime)
 				sdkRealtime.Hostname = agent.Hostname
 				s.server.handleRealtimeMetrics(sdkRealtime)
@@ -312,8 +363,12 @@ func (s *NanoLinkServicer) StreamMetrics(stream pb.NanoLinkService_StreamMetrics
 			}
 
 		case *pb.MetricsStreamRequest_CommandResult:
-			result := payload.CommandResult
-			log.Printf("Command result: %s success=%v", result.CommandId, result.Success)
+			result := s.convertCommandResult(payload.CommandResult)
+			if agent != nil {
+				result.AgentID = agent.AgentID
+				agent.HandleCommandResult(result.CommandID, result)
+			}
+			s.server.handleCommandResult(result)
 		}
 	}
 }
@@ -323,6 +378,8 @@ func (s *NanoLinkServicer) ReportMetrics(ctx context.Context, req *pb.Metrics) (
 	log.Printf("Received one-time metrics from: %s", req.Hostname)
 
 	sdkMetrics := s.convertMetrics(req)
+	// One-time reports carry no agent ID, so they are kept by hostname
+	s.bufferForSync(SanitizeHostname(req.Hostname), req)
 	s.server.handleMetrics(sdkMetrics)
 
 	return &pb.MetricsAck{
@@ -352,13 +409,19 @@ func (s *NanoLinkServicer) ExecuteCommand(ctx context.Context, req *pb.Command)
 	}, nil
 }
 
-// SyncMetrics handles metrics sync requests
+// SyncMetrics handles metrics sync requests. It returns the metrics the
+// server recorded for the agent after its last sync timestamp, and asks the
+// agent to resend its own buffer when the server's does not reach back that far.
 func (s *NanoLinkServicer) SyncMetrics(ctx context.Context, req *pb.MetricsSyncRequest) (*pb.MetricsSyncResponse, error) {
 	log.Printf("Metrics sync request from: %s", req.AgentId)
 
+	now := s.server.config.Clock.Now()
+	metrics, covered := s.syncBuffer.since(req.AgentId, req.LastSyncTimestamp, now, s.server.config.SyncBufferRetention)
 	return &pb.MetricsSyncResponse{
 		Success:         true,
-		ServerTimestamp: uint64(time.Now().UnixMilli()),
+		Metrics:         metrics,
+		ServerTimestamp: uint64(now.UnixMilli()),
+		ResendBuffer:    !covered,
 	}, nil
 }
 
@@ -383,7 +446,8 @@ func (s *NanoLinkServicer) GetAgentInfo(ctx context.Context, req *pb.AgentInfoRe
 	return &pb.AgentInfoResponse{AgentId: req.AgentId}, nil
 }
 
-// CreateGRPCServer creates a gRPC server with the NanoLink servicer
+// CreateGRPCServer creates a gRPC server with the NanoLink servicer. Agents
+// may compress their messages with gzip (see CompressionGzip).
 func CreateGRPCServer(servicer *NanoLinkServicer) *grpc.Server {
 	server := grpc.NewServer(
 		grpc.KeepaliveParams(keepalive.ServerParameters{
@@ -423,11 +487,11 @@ func (s *NanoLinkServicer) SendDataRequest(agentID string, requestType pb.DataRe
 		Target:      target,
 	}
 
-	err := agentStream.Stream.Send(&pb.MetricsStreamResponse{
+	err := agentStream.sender.send(&pb.MetricsStreamResponse{
 		Response: &pb.MetricsStreamResponse_DataRequest{
 			DataRequest: request,
 		},
-	})
+	}, 0)
 
 	if err != nil {
 		log.Printf("Failed to send data request to agent %s: %v", agentID, err)
@@ -442,8 +506,23 @@ func (s *NanoLinkServicer) SendDataRequest(agentID string, requestType pb.DataRe
 	return true
 }
 
+// BroadcastResult summarizes the outcome of a broadcast by agent ID
+type BroadcastResult struct {
+	Succeeded []string
+	Failed    []string
+	TimedOut  []string
+}
+
 // BroadcastDataRequest sends a data request to all connected agents
 func (s *NanoLinkServicer) BroadcastDataRequest(requestType pb.DataRequestType) {
+	s.BroadcastDataRequestWithResult(requestType)
+}
+
+// BroadcastDataRequestWithResult sends a data request to all connected
+// agents and reports which received it. Sends run on a bounded worker pool
+// so a slow agent only holds up its own worker; sends exceeding the
+// configured timeout are reported as timed out.
+func (s *NanoLinkServicer) BroadcastDataRequestWithResult(requestType pb.DataRequestType) BroadcastResult {
 	s.mu.RLock()
 	streams := make([]*AgentStream, 0, len(s.agentStreams))
 	for _, stream := range s.agentStreams {
@@ -463,16 +542,49 @@ func (s *NanoLinkServicer) BroadcastDataRequest(requestType pb.DataRequestType)
 		},
 	}
 
-	successCount := 0
-	var failedAgents []*AgentStream
+	workers := s.server.config.BroadcastConcurrency
+	if workers > len(streams) {
+		workers = len(streams)
+	}
+	timeout := s.server.config.BroadcastSendTimeout
+
+	var (
+		result       BroadcastResult
+		failedAgents []*AgentStream
+		resultMu     sync.Mutex
+		wg           sync.WaitGroup
+	)
+	jobs := make(chan *AgentStream)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for agentStream := range jobs {
+				err := agentStream.sender.send(response, timeout)
+
+				resultMu.Lock()
+				switch {
+				case errors.Is(err, errSendTimeout):
+					log.Printf("Broadcast to agent %s timed out after %v", agentStream.Agent.Hostname, timeout)
+					result.TimedOut = append(result.TimedOut, agentStream.Agent.AgentID)
+				case err != nil:
+					log.Printf("Failed to send broadcast to agent %s: %v", agentStream.Agent.Hostname, err)
+					result.Failed = append(result.Failed, agentStream.Agent.AgentID)
+					failedAgents = append(failedAgents, agentStream)
+				default:
+					result.Succeeded = append(result.Succeeded, agentStream.Agent.AgentID)
+				}
+				resultMu.Unlock()
+			}
+		}()
+	}
+
 	for _, agentStream := range streams {
-		if err := agentStream.Stream.Send(response); err == nil {
-			successCount++
-		} else {
-			log.Printf("Failed to send broadcast to agent %s: %v", agentStream.Agent.Hostname, err)
-			failedAgents = append(failedAgents, agentStream)
-		}
+		jobs <- agentStream
 	}
+	close(jobs)
+	wg.Wait()
 
 	// Mark failed streams as inactive (they will be cleaned up by their defer)
 	if len(failedAgents) > 0 {
@@ -483,7 +595,9 @@ func (s *NanoLinkServicer) BroadcastDataRequest(requestType pb.DataRequestType)
 		s.mu.Unlock()
 	}
 
-	log.Printf("Broadcast data request %v to %d/%d agents", requestType, successCount, len(streams))
+	log.Printf("Broadcast data request %v to %d/%d agents (%d failed, %d timed out)",
+		requestType, len(result.Succeeded), len(streams), len(result.Failed), len(result.TimedOut))
+	return result
 }
 
 // Conversion functions
@@ -772,6 +886,41 @@ func (s *NanoLinkServicer) convertPeriodicData(proto *pb.PeriodicData) *Periodic
 	return periodic
 }
 
+func (s *NanoLinkServicer) convertCommandResult(proto *pb.CommandResult) *CommandResult {
+	result := &CommandResult{
+		CommandID:   proto.CommandId,
+		Success:     proto.Success,
+		Output:      proto.Output,
+		Error:       proto.Error,
+		FileContent: proto.FileContent,
+	}
+
+	for _, p := range proto.Processes {
+		result.Processes = append(result.Processes, ProcessInfo{
+			PID:         int(p.Pid),
+			Name:        p.Name,
+			User:        p.User,
+			CPUPercent:  p.CpuPercent,
+			MemoryBytes: p.MemoryBytes,
+			Status:      p.Status,
+			StartTime:   int64(p.StartTime),
+		})
+	}
+
+	for _, c := range proto.Containers {
+		result.Containers = append(result.Containers, ContainerInfo{
+			ID:      c.Id,
+			Name:    c.Name,
+			Image:   c.Image,
+			Status:  c.Status,
+			State:   c.State,
+			Created: int64(c.Created),
+		})
+	}
+
+	return result
+}
+
 // getVersionOrDefault returns the version or "unknown" if empty
 func getVersionOrDefault(version string) string {
 	if version == "" {
@@ -0,0 +1,201 @@
+package nanolink
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/chenqi92/NanoLink/sdk/go/nanolink/proto"
+)
+
+// fakeStream is a minimal agent stream whose Send behaviour is scripted
+type fakeStream struct {
+	pb.NanoLinkService_StreamMetricsServer
+
+	block chan struct{} // if set, Send waits until it is closed
+	delay time.Duration
+	err   error
+
+	mu   sync.Mutex
+	sent int
+
+	// Set when two Sends ran at once, which gRPC does not allow
+	active     atomic.Int32
+	overlapped atomic.Bool
+}
+
+func (f *fakeStream) Send(resp *pb.MetricsStreamResponse) error {
+	if f.active.Add(1) > 1 {
+		f.overlapped.Store(true)
+	}
+	defer f.active.Add(-1)
+	if f.block != nil {
+		<-f.block
+	}
+	time.Sleep(f.delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.sent++
+	return nil
+}
+
+func (f *fakeStream) sentCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sent
+}
+
+func addFakeAgent(s *NanoLinkServicer, hostname string, stream *fakeStream) *AgentConnection {
+	agent := NewAgentConnectionFromGRPC(hostname, "linux", "amd64", "0.3.0", PermissionReadOnly)
+	s.registerAgentStream(agent, stream)
+	return agent
+}
+
+func TestBroadcastSlowAgentDoesNotBlockOthers(t *testing.T) {
+	server := NewServer(Config{
+		BroadcastConcurrency: 2,
+		BroadcastSendTimeout: 50 * time.Millisecond,
+	})
+	servicer := NewNanoLinkServicer(server)
+
+	slow := &fakeStream{block: make(chan struct{})}
+	defer close(slow.block)
+	broken := &fakeStream{err: errors.New("stream closed")}
+
+	slowAgent := addFakeAgent(servicer, "slow-host", slow)
+	brokenAgent := addFakeAgent(servicer, "broken-host", broken)
+	var fast []*fakeStream
+	var fastIDs []string
+	for _, host := range []string{"fast-1", "fast-2", "fast-3", "fast-4"} {
+		stream := &fakeStream{}
+		fast = append(fast, stream)
+		fastIDs = append(fastIDs, addFakeAgent(servicer, host, stream).AgentID)
+	}
+
+	start := time.Now()
+	result := servicer.BroadcastDataRequestWithResult(pb.DataRequestType_DATA_REQUEST_FULL)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Broadcast took %v, expected it to be bounded by the send timeout", elapsed)
+	}
+
+	for i, stream := range fast {
+		if stream.sentCount() != 1 {
+			t.Errorf("Expected fast agent %d to receive the request", i)
+		}
+	}
+
+	sort.Strings(fastIDs)
+	sort.Strings(result.Succeeded)
+	if len(result.Succeeded) != len(fastIDs) {
+		t.Fatalf("Expected %d successes, got %v", len(fastIDs), result.Succeeded)
+	}
+	for i := range fastIDs {
+		if result.Succeeded[i] != fastIDs[i] {
+			t.Errorf("Unexpected successes: %v", result.Succeeded)
+			break
+		}
+	}
+	if len(result.TimedOut) != 1 || result.TimedOut[0] != slowAgent.AgentID {
+		t.Errorf("Expected slow agent to time out, got %v", result.TimedOut)
+	}
+	if len(result.Failed) != 1 || result.Failed[0] != brokenAgent.AgentID {
+		t.Errorf("Expected broken agent to fail, got %v", result.Failed)
+	}
+
+	if stream, ok := servicer.agentStreams[brokenAgent.AgentID]; !ok || stream.IsActive {
+		t.Error("Expected failed stream to be marked inactive")
+	}
+}
+
+func TestSendsToOneStreamDoNotOverlap(t *testing.T) {
+	servicer := NewNanoLinkServicer(NewServer(Config{BroadcastSendTimeout: 5 * time.Millisecond}))
+	stream := &fakeStream{delay: 20 * time.Millisecond}
+	agent := addFakeAgent(servicer, "slow-host", stream)
+
+	// Broadcasts give up waiting long before each send completes, and the
+	// direct request waits for whatever they left running
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			servicer.BroadcastDataRequest(pb.DataRequestType_DATA_REQUEST_FULL)
+		}()
+	}
+	wg.Wait()
+	if !servicer.SendDataRequest(agent.AgentID, pb.DataRequestType_DATA_REQUEST_STATIC, "") {
+		t.Fatal("Expected the direct request to be sent")
+	}
+
+	if stream.overlapped.Load() {
+		t.Error("Expected sends on one stream to run one at a time")
+	}
+}
+
+func TestBroadcastNoAgents(t *testing.T) {
+	servicer := NewNanoLinkServicer(NewServer(Config{}))
+
+	result := servicer.BroadcastDataRequestWithResult(pb.DataRequestType_DATA_REQUEST_FULL)
+	if len(result.Succeeded)+len(result.Failed)+len(result.TimedOut) != 0 {
+		t.Errorf("Expected empty result, got %+v", result)
+	}
+}
+
+// scriptedStream replays requests from an agent and then ends the stream
+type scriptedStream struct {
+	fakeStream
+	requests []*pb.MetricsStreamRequest
+}
+
+func (f *scriptedStream) Recv() (*pb.MetricsStreamRequest, error) {
+	if len(f.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := f.requests[0]
+	f.requests = f.requests[1:]
+	return req, nil
+}
+
+func TestStreamCommandResultInvokesCallback(t *testing.T) {
+	server := NewServer(Config{})
+	servicer := NewNanoLinkServicer(server)
+
+	var results []*CommandResult
+	var connected *AgentConnection
+	server.OnAgentConnect(func(agent *AgentConnection) { connected = agent })
+	server.OnCommandResult(func(result *CommandResult) { results = append(results, result) })
+
+	stream := &scriptedStream{requests: []*pb.MetricsStreamRequest{
+		{Request: &pb.MetricsStreamRequest_Metrics{Metrics: &pb.Metrics{Hostname: "web-1"}}},
+		{Request: &pb.MetricsStreamRequest_CommandResult{CommandResult: &pb.CommandResult{
+			CommandId: "cmd-1",
+			Success:   true,
+			Output:    "restarted nginx",
+			Processes: []*pb.ProcessInfo{{Pid: 42, Name: "nginx"}},
+		}}},
+	}}
+	if err := servicer.StreamMetrics(stream); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("Expected one command result, got %d", len(results))
+	}
+	result := results[0]
+	if result.CommandID != "cmd-1" || !result.Success || result.Output != "restarted nginx" {
+		t.Errorf("Unexpected command result: %+v", result)
+	}
+	if connected == nil || result.AgentID != connected.AgentID {
+		t.Errorf("Expected the result to carry the agent ID, got %q", result.AgentID)
+	}
+	if len(result.Processes) != 1 || result.Processes[0].PID != 42 {
+		t.Errorf("Expected the process list to be converted, got %+v", result.Processes)
+	}
+}
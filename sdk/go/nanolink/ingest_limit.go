@@ -0,0 +1,51 @@
+package nanolink
+
+import (
+	"log"
+	"time"
+)
+
+// ingestBucket is a token bucket capping how fast one kind of metrics
+// message is accepted from an agent stream. A nil bucket accepts everything.
+type ingestBucket struct {
+	kind    string
+	rate    float64 // tokens per second, also the burst size
+	tokens  float64
+	last    time.Time
+	dropped int // messages dropped since the last accepted one
+}
+
+// newIngestBucket returns a full bucket, or nil for a rate of 0 or less
+func newIngestBucket(kind string, rate float64) *ingestBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &ingestBucket{kind: kind, rate: rate, tokens: max(rate, 1)}
+}
+
+// admit takes a token if one is available at now, logging when the agent
+// starts and stops exceeding the limit
+func (b *ingestBucket) admit(agent *AgentConnection, now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	if !b.last.IsZero() {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, max(b.rate, 1))
+	}
+	b.last = now
+	if b.tokens < 1 {
+		b.dropped++
+		if b.dropped == 1 {
+			log.Printf("WARNING: Agent %s (%s) exceeds %g %s messages/sec, dropping the excess",
+				agent.Hostname, agent.AgentID, b.rate, b.kind)
+		}
+		return false
+	}
+	b.tokens--
+	if b.dropped > 0 {
+		log.Printf("Dropped %d %s messages from %s (%s) over the rate limit",
+			b.dropped, b.kind, agent.Hostname, agent.AgentID)
+		b.dropped = 0
+	}
+	return true
+}
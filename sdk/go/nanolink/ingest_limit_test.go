@@ -0,0 +1,65 @@
+package nanolink
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/chenqi92/NanoLink/sdk/go/nanolink/proto"
+)
+
+func TestStreamDropsMetricsOverTheLimit(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	server := NewServer(Config{MaxMetricsPerSec: 2, MaxRealtimePerSec: 3, Clock: clock})
+	servicer := NewNanoLinkServicer(server)
+
+	var metrics, realtime int
+	server.OnMetrics(func(*Metrics) { metrics++ })
+	server.OnRealtimeMetrics(func(*RealtimeMetrics) { realtime++ })
+
+	full := &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_Metrics{Metrics: &pb.Metrics{Hostname: "web-1"}}}
+	rt := &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_Realtime{Realtime: &pb.RealtimeMetrics{}}}
+	// The registering message plus a burst of two more are accepted
+	var requests []*pb.MetricsStreamRequest
+	for i := 0; i < 6; i++ {
+		requests = append(requests, full)
+	}
+	for i := 0; i < 5; i++ {
+		requests = append(requests, rt)
+	}
+	stream := &scriptedStream{requests: requests}
+	if err := servicer.StreamMetrics(stream); err != nil {
+		t.Fatal(err)
+	}
+
+	if metrics != 3 {
+		t.Errorf("Expected 3 of 6 metrics messages to be handled, got %d", metrics)
+	}
+	if realtime != 3 {
+		t.Errorf("Expected 3 of 5 realtime messages to be handled, got %d", realtime)
+	}
+}
+
+func TestIngestBucketRefills(t *testing.T) {
+	agent := &AgentConnection{AgentID: "agent-1", Hostname: "web-1"}
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	bucket := newIngestBucket("metrics", 2)
+	for i := 0; i < 2; i++ {
+		if !bucket.admit(agent, start) {
+			t.Fatalf("Expected message %d of the burst to be admitted", i+1)
+		}
+	}
+	if bucket.admit(agent, start) {
+		t.Fatal("Expected the bucket to be empty after its burst")
+	}
+	if !bucket.admit(agent, start.Add(500*time.Millisecond)) {
+		t.Error("Expected a token to refill after half a second")
+	}
+	if bucket.dropped != 0 {
+		t.Errorf("Expected the dropped count to reset, got %d", bucket.dropped)
+	}
+
+	var unlimited *ingestBucket
+	if !unlimited.admit(agent, start) {
+		t.Error("Expected a nil bucket to admit everything")
+	}
+}
@@ -2,12 +2,14 @@ package nanolink
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"sort"
 	"sync"
 	"time"
 )
@@ -18,6 +20,12 @@ const MaxMCPMessageSize = 1024 * 1024
 // DefaultToolTimeout is the default timeout for tool execution
 const DefaultToolTimeout = 30 * time.Second
 
+// MCPProtocolVersions lists the MCP protocol revisions the SDK speaks, newest first
+var MCPProtocolVersions = []string{"2025-03-26", "2024-11-05"}
+
+// mcpBatchProtocolVersion is the first revision that allows JSON-RPC batches
+const mcpBatchProtocolVersion = "2025-03-26"
+
 // MCPServer wraps NanoLinkServer with MCP (Model Context Protocol) capabilities.
 // This allows AI/LLM applications like Claude Desktop to interact with NanoLink.
 type MCPServer struct {
@@ -29,6 +37,31 @@ type MCPServer struct {
 	mu        sync.RWMutex
 	started   bool
 	shutdown  chan struct{}
+
+	// protocolVersions are offered to clients newest first
+	protocolVersions []string
+
+	// WebSocket clients must present a token this accepts; nil accepts any
+	wsTokenValidator TokenValidator
+	// Browser origins allowed besides the endpoint's own
+	wsAllowedOrigins []string
+}
+
+// mcpSession holds the state of one client connection
+type mcpSession struct {
+	protocolVersion string // negotiated during initialize
+}
+
+type mcpSessionKey struct{}
+
+// MCPProtocolVersion returns the MCP protocol revision negotiated with the
+// client a tool, resource or prompt handler is serving, or an empty string
+// before initialize
+func MCPProtocolVersion(ctx context.Context) string {
+	if session, ok := ctx.Value(mcpSessionKey{}).(*mcpSession); ok {
+		return session.protocolVersion
+	}
+	return ""
 }
 
 // MCPOption configures the MCP server
@@ -55,6 +88,23 @@ func WithDefaultPrompts() MCPOption {
 	}
 }
 
+// WithProtocolVersions restricts the MCP protocol revisions offered to
+// clients. Revisions the SDK does not implement are ignored.
+func WithProtocolVersions(versions ...string) MCPOption {
+	return func(m *MCPServer) {
+		var offered []string
+		for _, v := range versions {
+			for _, supported := range MCPProtocolVersions {
+				if v == supported {
+					offered = append(offered, v)
+					break
+				}
+			}
+		}
+		m.protocolVersions = sortMCPProtocolVersions(offered)
+	}
+}
+
 // NewMCPServer creates a new MCP server wrapping an existing NanoLink server
 func NewMCPServer(nano *Server, opts ...MCPOption) *MCPServer {
 	m := &MCPServer{
@@ -64,6 +114,8 @@ func NewMCPServer(nano *Server, opts ...MCPOption) *MCPServer {
 		resources: make(map[string]*MCPResource),
 		prompts:   make(map[string]*MCPPrompt),
 		shutdown:  make(chan struct{}),
+
+		protocolVersions: sortMCPProtocolVersions(MCPProtocolVersions),
 	}
 
 	for _, opt := range opts {
@@ -100,18 +152,31 @@ func (m *MCPServer) ServeStdio(ctx context.Context) error {
 	return m.serve(ctx)
 }
 
-// serve is the main message processing loop
+// serve runs the message loop over the configured transport
 func (m *MCPServer) serve(ctx context.Context) error {
+	if err := m.markStarted(); err != nil {
+		return err
+	}
+
+	log.Println("MCP server starting...")
+	return m.serveTransport(ctx, m.transport)
+}
+
+// markStarted fails if the server is already serving
+func (m *MCPServer) markStarted() error {
 	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.started {
-		m.mu.Unlock()
 		return fmt.Errorf("MCP server already started")
 	}
 	m.started = true
-	m.mu.Unlock()
-
-	log.Println("MCP server starting...")
+	return nil
+}
 
+// serveTransport is the message processing loop for one client
+func (m *MCPServer) serveTransport(ctx context.Context, transport MCPTransport) error {
+	session := &mcpSession{}
+	ctx = context.WithValue(ctx, mcpSessionKey{}, session)
 	for {
 		select {
 		case <-ctx.Done():
@@ -123,7 +188,7 @@ func (m *MCPServer) serve(ctx context.Context) error {
 			msgChan := make(chan []byte, 1)
 			errChan := make(chan error, 1)
 			go func() {
-				msg, err := m.transport.ReadMessage()
+				msg, err := transport.ReadMessage()
 				if err != nil {
 					errChan <- err
 					return
@@ -143,14 +208,14 @@ func (m *MCPServer) serve(ctx context.Context) error {
 				log.Printf("MCP read error: %v", err)
 				continue
 			case msg := <-msgChan:
-				response, err := m.handleMessage(ctx, msg)
+				response, err := m.handleMessage(ctx, session, msg)
 				if err != nil {
 					log.Printf("MCP handle error: %v", err)
 					continue
 				}
 
 				if response != nil {
-					if err := m.transport.WriteMessage(response); err != nil {
+					if err := transport.WriteMessage(response); err != nil {
 						log.Printf("MCP write error: %v", err)
 					}
 				}
@@ -164,8 +229,12 @@ func (m *MCPServer) Stop() {
 	close(m.shutdown)
 }
 
-// handleMessage processes incoming JSON-RPC messages
-func (m *MCPServer) handleMessage(ctx context.Context, data []byte) ([]byte, error) {
+// handleMessage processes incoming JSON-RPC messages and batches from a client session
+func (m *MCPServer) handleMessage(ctx context.Context, session *mcpSession, data []byte) ([]byte, error) {
+	if isMCPBatch(data) {
+		return m.handleBatch(ctx, session, data)
+	}
+
 	var msg jsonRPCMessage
 	if err := json.Unmarshal(data, &msg); err != nil {
 		return m.errorResponse(nil, -32700, "Parse error", nil)
@@ -177,7 +246,7 @@ func (m *MCPServer) handleMessage(ctx context.Context, data []byte) ([]byte, err
 
 	switch msg.Method {
 	case "initialize":
-		return m.handleInitialize(msg)
+		return m.handleInitialize(session, msg)
 	case "initialized":
 		return nil, nil
 	case "tools/list":
@@ -199,9 +268,75 @@ func (m *MCPServer) handleMessage(ctx context.Context, data []byte) ([]byte, err
 	}
 }
 
-func (m *MCPServer) handleInitialize(msg jsonRPCMessage) ([]byte, error) {
+// handleBatch processes a JSON-RPC batch, which sessions may send once they
+// have negotiated a revision that allows them. Requests are handled in order
+// and their responses returned together; notifications get no response.
+func (m *MCPServer) handleBatch(ctx context.Context, session *mcpSession, data []byte) ([]byte, error) {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return m.errorResponse(nil, -32700, "Parse error", nil)
+	}
+	if session.protocolVersion < mcpBatchProtocolVersion {
+		return m.errorResponse(nil, -32600, fmt.Sprintf("Batches are not supported by protocol version %q", session.protocolVersion), nil)
+	}
+	if len(batch) == 0 {
+		return m.errorResponse(nil, -32600, "Empty batch", nil)
+	}
+
+	var responses []json.RawMessage
+	for _, raw := range batch {
+		var response []byte
+		var err error
+		if isMCPBatch(raw) {
+			response, err = m.errorResponse(nil, -32600, "Nested batch", nil)
+		} else {
+			response, err = m.handleMessage(ctx, session, raw)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if response != nil {
+			responses = append(responses, response)
+		}
+	}
+	if len(responses) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(responses)
+}
+
+// isMCPBatch reports whether a raw message is a JSON-RPC batch
+func isMCPBatch(data []byte) bool {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+func (m *MCPServer) handleInitialize(session *mcpSession, msg jsonRPCMessage) ([]byte, error) {
+	var params struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return m.errorResponse(msg.ID, -32602, "Invalid parameters", nil)
+		}
+	}
+
+	m.mu.RLock()
+	version, ok := negotiateMCPProtocolVersion(params.ProtocolVersion, m.protocolVersions)
+	supported := m.protocolVersions
+	m.mu.RUnlock()
+
+	if !ok {
+		return m.errorResponse(msg.ID, -32602, "Unsupported protocol version", map[string]interface{}{
+			"requested": params.ProtocolVersion,
+			"supported": supported,
+		})
+	}
+
+	session.protocolVersion = version
+
 	result := map[string]interface{}{
-		"protocolVersion": "2024-11-05",
+		"protocolVersion": version,
 		"serverInfo": map[string]string{
 			"name":    "nanolink-sdk",
 			"version": Version,
@@ -215,6 +350,34 @@ func (m *MCPServer) handleInitialize(msg jsonRPCMessage) ([]byte, error) {
 	return m.successResponse(msg.ID, result)
 }
 
+// negotiateMCPProtocolVersion picks the newest supported revision that is not
+// newer than the one requested. A missing version falls back to the oldest
+// revision; malformed or too-old versions cannot be negotiated.
+func negotiateMCPProtocolVersion(requested string, supported []string) (string, bool) {
+	if len(supported) == 0 {
+		return "", false
+	}
+	if requested == "" {
+		return supported[len(supported)-1], true
+	}
+	if _, err := time.Parse("2006-01-02", requested); err != nil {
+		return "", false
+	}
+	// Revisions are ISO dates, so string order is chronological
+	for _, v := range supported {
+		if v <= requested {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func sortMCPProtocolVersions(versions []string) []string {
+	sorted := append([]string(nil), versions...)
+	sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+	return sorted
+}
+
 func (m *MCPServer) handleToolsList(msg jsonRPCMessage) ([]byte, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -480,6 +643,7 @@ func (m *MCPServer) registerDefaultTools() {
 			for id, agent := range agents {
 				result = append(result, map[string]interface{}{
 					"id": id, "hostname": agent.Hostname, "os": agent.OS, "arch": agent.Arch,
+					"labels": agent.Labels, "status": agent.Status(),
 				})
 			}
 			return map[string]interface{}{"count": len(result), "agents": result}, nil
@@ -604,6 +768,7 @@ func (m *MCPServer) registerDefaultResources() {
 			for id, agent := range agents {
 				result = append(result, map[string]interface{}{
 					"id": id, "hostname": agent.Hostname, "os": agent.OS, "arch": agent.Arch,
+					"status": agent.Status(),
 				})
 			}
 			return json.MarshalIndent(map[string]interface{}{"count": len(result), "agents": result}, "", "  ")
@@ -0,0 +1,105 @@
+package nanolink
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func initializeMCP(t *testing.T, m *MCPServer, session *mcpSession, version string) (string, *jsonRPCError) {
+	t.Helper()
+	params, _ := json.Marshal(map[string]string{"protocolVersion": version})
+	data, err := m.handleInitialize(session, jsonRPCMessage{JSONRPC: "2.0", ID: 1, Method: "initialize", Params: params})
+	if err != nil {
+		t.Fatalf("handleInitialize failed: %v", err)
+	}
+
+	var resp struct {
+		Result struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		} `json:"result"`
+		Error *jsonRPCError `json:"error"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	return resp.Result.ProtocolVersion, resp.Error
+}
+
+func TestMCPInitializeEchoesSupportedVersion(t *testing.T) {
+	m := NewMCPServer(NewServer(Config{}))
+
+	session := &mcpSession{}
+
+	got, rpcErr := initializeMCP(t, m, session, "2025-03-26")
+	if rpcErr != nil {
+		t.Fatalf("Expected success, got %+v", rpcErr)
+	}
+	if got != "2025-03-26" || session.protocolVersion != "2025-03-26" {
+		t.Errorf("Expected 2025-03-26, got response %s session %s", got, session.protocolVersion)
+	}
+}
+
+func TestMCPInitializeNegotiatesDown(t *testing.T) {
+	m := NewMCPServer(NewServer(Config{}))
+
+	got, rpcErr := initializeMCP(t, m, &mcpSession{}, "2025-01-15")
+	if rpcErr != nil {
+		t.Fatalf("Expected success, got %+v", rpcErr)
+	}
+	if got != "2024-11-05" {
+		t.Errorf("Expected 2024-11-05, got %s", got)
+	}
+
+	// Revisions the SDK does not implement are never offered
+	restricted := NewMCPServer(NewServer(Config{}), WithProtocolVersions("2024-11-05", "2025-06-18"))
+	if got, _ := initializeMCP(t, restricted, &mcpSession{}, "2025-06-18"); got != "2024-11-05" {
+		t.Errorf("Expected restricted server to offer 2024-11-05, got %s", got)
+	}
+}
+
+func TestMCPInitializeRejectsUnknownVersion(t *testing.T) {
+	for _, version := range []string{"2023-01-01", "draft"} {
+		m := NewMCPServer(NewServer(Config{}))
+		session := &mcpSession{}
+
+		_, rpcErr := initializeMCP(t, m, session, version)
+		if rpcErr == nil || rpcErr.Code != -32602 {
+			t.Errorf("Expected -32602 for %q, got %+v", version, rpcErr)
+		}
+		if session.protocolVersion != "" {
+			t.Errorf("Expected no negotiated version for %q, got %s", version, session.protocolVersion)
+		}
+	}
+}
+
+func TestMCPBatchesRequireNegotiatedSupport(t *testing.T) {
+	m := NewMCPServer(NewServer(Config{}))
+	batch := []byte(`[{"jsonrpc":"2.0","id":1,"method":"ping"},{"jsonrpc":"2.0","method":"initialized"},{"jsonrpc":"2.0","id":2,"method":"nope"}]`)
+
+	data, err := m.handleMessage(context.Background(), &mcpSession{protocolVersion: "2024-11-05"}, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rejected jsonRPCMessage
+	if err := json.Unmarshal(data, &rejected); err != nil || rejected.Error == nil || rejected.Error.Code != -32600 {
+		t.Errorf("Expected -32600 for a batch on 2024-11-05, got %s", data)
+	}
+
+	session := &mcpSession{protocolVersion: "2025-03-26"}
+	data, err = m.handleMessage(context.Background(), session, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var responses []jsonRPCMessage
+	if err := json.Unmarshal(data, &responses); err != nil {
+		t.Fatalf("Expected a batch response, got %s", data)
+	}
+	if len(responses) != 2 || responses[0].Error != nil || responses[1].Error == nil || responses[1].Error.Code != -32601 {
+		t.Errorf("Expected a ping result and a -32601 error, got %s", data)
+	}
+
+	if data, _ := m.handleMessage(context.Background(), session, []byte(`[{"jsonrpc":"2.0","method":"initialized"}]`)); data != nil {
+		t.Errorf("Expected no response to a batch of notifications, got %s", data)
+	}
+}
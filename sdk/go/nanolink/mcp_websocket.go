@@ -0,0 +1,160 @@
+package nanolink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// WithWebSocketTokenValidator requires WebSocket clients to send a token
+// the validator accepts as "Authorization: Bearer <token>". Without it any
+// client that can reach the endpoint can call tools.
+func WithWebSocketTokenValidator(validator TokenValidator) MCPOption {
+	return func(m *MCPServer) {
+		m.wsTokenValidator = validator
+	}
+}
+
+// WithWebSocketAllowedOrigins lets browser clients from the given origins,
+// such as "https://dashboard.example.com", connect to the WebSocket endpoint.
+// Clients from the endpoint's own origin and clients that send no Origin
+// header, like most AI hosts, are always allowed.
+func WithWebSocketAllowedOrigins(origins ...string) MCPOption {
+	return func(m *MCPServer) {
+		m.wsAllowedOrigins = append(m.wsAllowedOrigins, origins...)
+	}
+}
+
+// ServeWebSocket runs the MCP server on a WebSocket endpoint at addr so
+// remote AI hosts can connect over the network. Each client gets its own
+// transport and message loop; tools, resources and prompts are shared.
+// Browser clients from another origin are refused unless allowed with
+// WithWebSocketAllowedOrigins.
+func (m *MCPServer) ServeWebSocket(ctx context.Context, addr string) error {
+	if err := m.markStarted(); err != nil {
+		return err
+	}
+	if m.wsTokenValidator == nil {
+		log.Printf("WARNING: MCP WebSocket server on %s has no token validator, so any client "+
+			"that can reach it can call tools. Set WithWebSocketTokenValidator before exposing it", addr)
+	}
+
+	srv := &http.Server{Addr: addr, Handler: m.webSocketHandler(ctx)}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- srv.ListenAndServe()
+	}()
+	log.Printf("MCP WebSocket server listening on %s", addr)
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		srv.Close()
+		return ctx.Err()
+	case <-m.shutdown:
+		// Client loops see the shutdown too and close their connections
+		srv.Close()
+		return nil
+	}
+}
+
+// webSocketHandler authenticates and upgrades each request and serves the
+// client until it disconnects or the server stops
+func (m *MCPServer) webSocketHandler(ctx context.Context) http.Handler {
+	upgrader := websocket.Upgrader{CheckOrigin: m.checkWebSocketOrigin}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.wsTokenValidator != nil {
+			token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if result := m.wsTokenValidator(token); !result.Valid {
+				log.Printf("MCP WebSocket client %s rejected: %s", r.RemoteAddr, result.ErrorMessage)
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("MCP WebSocket upgrade failed: %v", err)
+			return
+		}
+		transport := NewWebSocketMCPTransport(conn)
+		defer transport.Close()
+
+		log.Printf("MCP client connected from %s", r.RemoteAddr)
+		if err := m.serveTransport(ctx, transport); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("MCP client %s: %v", r.RemoteAddr, err)
+		}
+		log.Printf("MCP client disconnected from %s", r.RemoteAddr)
+	})
+}
+
+// checkWebSocketOrigin allows requests without an Origin header, from the
+// endpoint's own origin, or from an allowed origin
+func (m *MCPServer) checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range m.wsAllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebSocketMCPTransport implements MCPTransport over a WebSocket connection,
+// one JSON-RPC message per text frame
+type WebSocketMCPTransport struct {
+	conn *websocket.Conn
+	mu   sync.Mutex // gorilla allows one concurrent writer
+}
+
+// NewWebSocketMCPTransport creates a transport on an upgraded connection.
+// Messages larger than MaxMCPMessageSize close the connection.
+func NewWebSocketMCPTransport(conn *websocket.Conn) *WebSocketMCPTransport {
+	conn.SetReadLimit(MaxMCPMessageSize)
+	return &WebSocketMCPTransport{conn: conn}
+}
+
+func (t *WebSocketMCPTransport) ReadMessage() ([]byte, error) {
+	messageType, data, err := t.conn.ReadMessage()
+	if err != nil {
+		if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+			log.Printf("MCP WebSocket read error: %v", err)
+		}
+		// The connection cannot be read again after an error
+		return nil, io.EOF
+	}
+	if messageType != websocket.TextMessage {
+		return nil, fmt.Errorf("expected a text frame, got frame type %d", messageType)
+	}
+	return data, nil
+}
+
+func (t *WebSocketMCPTransport) WriteMessage(data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (t *WebSocketMCPTransport) Close() error {
+	return t.conn.Close()
+}
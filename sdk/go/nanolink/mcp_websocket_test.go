@@ -0,0 +1,173 @@
+package nanolink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialMCP(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func callMCP(t *testing.T, conn *websocket.Conn, id int, method string, params ...string) jsonRPCMessage {
+	t.Helper()
+	req := fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":%q}`, id, method)
+	if len(params) > 0 {
+		req = fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":%q,"params":%s}`, id, method, params[0])
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(req)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if messageType != websocket.TextMessage {
+		t.Errorf("Expected a text frame, got %d", messageType)
+	}
+	var resp jsonRPCMessage
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatalf("Invalid response %s: %v", data, err)
+	}
+	return resp
+}
+
+func TestMCPWebSocketServesConcurrentClients(t *testing.T) {
+	m := NewMCPServer(NewServer(Config{}), WithDefaultTools())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewServer(m.webSocketHandler(ctx))
+	defer srv.Close()
+
+	first := dialMCP(t, srv.URL)
+	second := dialMCP(t, srv.URL)
+
+	// Each client gets its own loop, answering in the order it asked
+	if resp := callMCP(t, second, 1, "ping"); resp.Error != nil {
+		t.Errorf("Expected ping to succeed, got %+v", resp.Error)
+	}
+	resp := callMCP(t, first, 2, "tools/list")
+	if resp.Error != nil || !strings.Contains(string(mustJSON(t, resp.Result)), "list_agents") {
+		t.Errorf("Expected the default tools to be listed, got %+v", resp)
+	}
+
+	// An oversized message closes only that client's connection
+	huge := `{"jsonrpc":"2.0","id":3,"method":"ping","params":"` + strings.Repeat("x", MaxMCPMessageSize) + `"}`
+	first.WriteMessage(websocket.TextMessage, []byte(huge))
+	first.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := first.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("Expected the connection to close as too big, got %v", err)
+	}
+	if resp := callMCP(t, second, 4, "ping"); resp.Error != nil {
+		t.Errorf("Expected the other client to keep working, got %+v", resp.Error)
+	}
+}
+
+func TestMCPWebSocketNegotiatesVersionPerClient(t *testing.T) {
+	m := NewMCPServer(NewServer(Config{}))
+	m.RegisterTool(&MCPTool{
+		Name: "protocol_version",
+		Handler: func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+			return MCPProtocolVersion(ctx), nil
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewServer(m.webSocketHandler(ctx))
+	defer srv.Close()
+
+	older := dialMCP(t, srv.URL)
+	newer := dialMCP(t, srv.URL)
+	callMCP(t, older, 1, "initialize", `{"protocolVersion":"2024-11-05"}`)
+	callMCP(t, newer, 1, "initialize", `{"protocolVersion":"2025-03-26"}`)
+
+	// A later client's initialize leaves the earlier one's version alone
+	call := `{"name":"protocol_version","arguments":{}}`
+	for conn, want := range map[*websocket.Conn]string{older: "2024-11-05", newer: "2025-03-26"} {
+		resp := callMCP(t, conn, 2, "tools/call", call)
+		if resp.Error != nil || !strings.Contains(string(mustJSON(t, resp.Result)), want) {
+			t.Errorf("Expected the tool to see %s, got %+v", want, resp)
+		}
+	}
+}
+
+func TestMCPWebSocketRequiresValidToken(t *testing.T) {
+	validator := StaticTokenValidator(map[string]int{"mcp-secret": 0})
+	m := NewMCPServer(NewServer(Config{}), WithWebSocketTokenValidator(validator))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewServer(m.webSocketHandler(ctx))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	for name, header := range map[string]http.Header{
+		"missing": nil,
+		"wrong":   {"Authorization": {"Bearer guess"}},
+	} {
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected a %s token to be refused with 401, got %v", name, err)
+		}
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer mcp-secret"}})
+	if err != nil {
+		t.Fatalf("Expected the valid token to connect, got %v", err)
+	}
+	defer conn.Close()
+	if resp := callMCP(t, conn, 1, "ping"); resp.Error != nil {
+		t.Errorf("Expected ping to succeed, got %+v", resp.Error)
+	}
+}
+
+func TestMCPWebSocketChecksOrigin(t *testing.T) {
+	m := NewMCPServer(NewServer(Config{}), WithWebSocketAllowedOrigins("https://dashboard.example.com"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewServer(m.webSocketHandler(ctx))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	for origin, allowed := range map[string]bool{
+		"":                              true, // not a browser
+		srv.URL:                         true,
+		"https://dashboard.example.com": true,
+		"https://evil.example.com":      false,
+	} {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
+		if (err == nil) != allowed {
+			t.Errorf("Origin %q: expected allowed=%v, got %v", origin, allowed, err)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -21,6 +21,64 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type AuthFailureReason int32
+
+const (
+	AuthFailureReason_AUTH_FAILURE_NONE                 AuthFailureReason = 0
+	AuthFailureReason_AUTH_FAILURE_INVALID_TOKEN        AuthFailureReason = 1 // Permanent: fix the configured token
+	AuthFailureReason_AUTH_FAILURE_SESSION_EXPIRED      AuthFailureReason = 2 // Retry right away with the token
+	AuthFailureReason_AUTH_FAILURE_AGENT_DENIED         AuthFailureReason = 3 // Force-disconnected; retry once the denial lapses
+	AuthFailureReason_AUTH_FAILURE_VERSION_TOO_OLD      AuthFailureReason = 4 // Permanent: upgrade the agent
+	AuthFailureReason_AUTH_FAILURE_TOO_MANY_CONNECTIONS AuthFailureReason = 5 // The source IP holds too many connections; retry later
+)
+
+// Enum value maps for AuthFailureReason.
+var (
+	AuthFailureReason_name = map[int32]string{
+		0: "AUTH_FAILURE_NONE",
+		1: "AUTH_FAILURE_INVALID_TOKEN",
+		2: "AUTH_FAILURE_SESSION_EXPIRED",
+		3: "AUTH_FAILURE_AGENT_DENIED",
+		4: "AUTH_FAILURE_VERSION_TOO_OLD",
+		5: "AUTH_FAILURE_TOO_MANY_CONNECTIONS",
+	}
+	AuthFailureReason_value = map[string]int32{
+		"AUTH_FAILURE_NONE":                 0,
+		"AUTH_FAILURE_INVALID_TOKEN":        1,
+		"AUTH_FAILURE_SESSION_EXPIRED":      2,
+		"AUTH_FAILURE_AGENT_DENIED":         3,
+		"AUTH_FAILURE_VERSION_TOO_OLD":      4,
+		"AUTH_FAILURE_TOO_MANY_CONNECTIONS": 5,
+	}
+)
+
+func (x AuthFailureReason) Enum() *AuthFailureReason {
+	p := new(AuthFailureReason)
+	*p = x
+	return p
+}
+
+func (x AuthFailureReason) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (AuthFailureReason) Descriptor() protoreflect.EnumDescriptor {
+	return file_nanolink_proto_enumTypes[0].Descriptor()
+}
+
+func (AuthFailureReason) Type() protoreflect.EnumType {
+	return &file_nanolink_proto_enumTypes[0]
+}
+
+func (x AuthFailureReason) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use AuthFailureReason.Descriptor instead.
+func (AuthFailureReason) EnumDescriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{0}
+}
+
 // ========== Metrics Type ==========
 // Defines what type of metrics data is being sent
 type MetricsType int32
@@ -59,11 +117,11 @@ func (x MetricsType) String() string {
 }
 
 func (MetricsType) Descriptor() protoreflect.EnumDescriptor {
-	return file_nanolink_proto_enumTypes[0].Descriptor()
+	return file_nanolink_proto_enumTypes[1].Descriptor()
 }
 
 func (MetricsType) Type() protoreflect.EnumType {
-	return &file_nanolink_proto_enumTypes[0]
+	return &file_nanolink_proto_enumTypes[1]
 }
 
 func (x MetricsType) Number() protoreflect.EnumNumber {
@@ -72,7 +130,7 @@ func (x MetricsType) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use MetricsType.Descriptor instead.
 func (MetricsType) EnumDescriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{0}
+	return file_nanolink_proto_rawDescGZIP(), []int{1}
 }
 
 // ========== Data Request Types ==========
@@ -122,11 +180,11 @@ func (x DataRequestType) String() string {
 }
 
 func (DataRequestType) Descriptor() protoreflect.EnumDescriptor {
-	return file_nanolink_proto_enumTypes[1].Descriptor()
+	return file_nanolink_proto_enumTypes[2].Descriptor()
 }
 
 func (DataRequestType) Type() protoreflect.EnumType {
-	return &file_nanolink_proto_enumTypes[1]
+	return &file_nanolink_proto_enumTypes[2]
 }
 
 func (x DataRequestType) Number() protoreflect.EnumNumber {
@@ -135,7 +193,182 @@ func (x DataRequestType) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use DataRequestType.Descriptor instead.
 func (DataRequestType) EnumDescriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{1}
+	return file_nanolink_proto_rawDescGZIP(), []int{2}
+}
+
+// RealtimeField flags the groups of RealtimeMetrics carried by a delta message.
+// Device lists (disk_io, network_io, gpu_usage, npu_usage) only need to contain
+// the devices that changed.
+type RealtimeField int32
+
+const (
+	RealtimeField_REALTIME_FIELD_NONE            RealtimeField = 0
+	RealtimeField_REALTIME_FIELD_CPU_USAGE       RealtimeField = 1
+	RealtimeField_REALTIME_FIELD_CPU_PER_CORE    RealtimeField = 2
+	RealtimeField_REALTIME_FIELD_CPU_TEMPERATURE RealtimeField = 4
+	RealtimeField_REALTIME_FIELD_CPU_FREQUENCY   RealtimeField = 8
+	RealtimeField_REALTIME_FIELD_MEMORY          RealtimeField = 16 // memory_used, memory_cached and swap_used
+	RealtimeField_REALTIME_FIELD_DISK_IO         RealtimeField = 32
+	RealtimeField_REALTIME_FIELD_NETWORK_IO      RealtimeField = 64
+	RealtimeField_REALTIME_FIELD_LOAD_AVERAGE    RealtimeField = 128
+	RealtimeField_REALTIME_FIELD_GPU_USAGE       RealtimeField = 256
+	RealtimeField_REALTIME_FIELD_NPU_USAGE       RealtimeField = 512
+)
+
+// Enum value maps for RealtimeField.
+var (
+	RealtimeField_name = map[int32]string{
+		0:   "REALTIME_FIELD_NONE",
+		1:   "REALTIME_FIELD_CPU_USAGE",
+		2:   "REALTIME_FIELD_CPU_PER_CORE",
+		4:   "REALTIME_FIELD_CPU_TEMPERATURE",
+		8:   "REALTIME_FIELD_CPU_FREQUENCY",
+		16:  "REALTIME_FIELD_MEMORY",
+		32:  "REALTIME_FIELD_DISK_IO",
+		64:  "REALTIME_FIELD_NETWORK_IO",
+		128: "REALTIME_FIELD_LOAD_AVERAGE",
+		256: "REALTIME_FIELD_GPU_USAGE",
+		512: "REALTIME_FIELD_NPU_USAGE",
+	}
+	RealtimeField_value = map[string]int32{
+		"REALTIME_FIELD_NONE":            0,
+		"REALTIME_FIELD_CPU_USAGE":       1,
+		"REALTIME_FIELD_CPU_PER_CORE":    2,
+		"REALTIME_FIELD_CPU_TEMPERATURE": 4,
+		"REALTIME_FIELD_CPU_FREQUENCY":   8,
+		"REALTIME_FIELD_MEMORY":          16,
+		"REALTIME_FIELD_DISK_IO":         32,
+		"REALTIME_FIELD_NETWORK_IO":      64,
+		"REALTIME_FIELD_LOAD_AVERAGE":    128,
+		"REALTIME_FIELD_GPU_USAGE":       256,
+		"REALTIME_FIELD_NPU_USAGE":       512,
+	}
+)
+
+func (x RealtimeField) Enum() *RealtimeField {
+	p := new(RealtimeField)
+	*p = x
+	return p
+}
+
+func (x RealtimeField) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (RealtimeField) Descriptor() protoreflect.EnumDescriptor {
+	return file_nanolink_proto_enumTypes[3].Descriptor()
+}
+
+func (RealtimeField) Type() protoreflect.EnumType {
+	return &file_nanolink_proto_enumTypes[3]
+}
+
+func (x RealtimeField) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use RealtimeField.Descriptor instead.
+func (RealtimeField) EnumDescriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{3}
+}
+
+// RealtimeReportMode is the server's advice on how an agent should report
+// realtime metrics
+type RealtimeReportMode int32
+
+const (
+	RealtimeReportMode_REALTIME_REPORT_FULL  RealtimeReportMode = 0 // Send every field on each tick
+	RealtimeReportMode_REALTIME_REPORT_DELTA RealtimeReportMode = 1 // Send only changed fields (slow or lossy link)
+)
+
+// Enum value maps for RealtimeReportMode.
+var (
+	RealtimeReportMode_name = map[int32]string{
+		0: "REALTIME_REPORT_FULL",
+		1: "REALTIME_REPORT_DELTA",
+	}
+	RealtimeReportMode_value = map[string]int32{
+		"REALTIME_REPORT_FULL":  0,
+		"REALTIME_REPORT_DELTA": 1,
+	}
+)
+
+func (x RealtimeReportMode) Enum() *RealtimeReportMode {
+	p := new(RealtimeReportMode)
+	*p = x
+	return p
+}
+
+func (x RealtimeReportMode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (RealtimeReportMode) Descriptor() protoreflect.EnumDescriptor {
+	return file_nanolink_proto_enumTypes[4].Descriptor()
+}
+
+func (RealtimeReportMode) Type() protoreflect.EnumType {
+	return &file_nanolink_proto_enumTypes[4]
+}
+
+func (x RealtimeReportMode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use RealtimeReportMode.Descriptor instead.
+func (RealtimeReportMode) EnumDescriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{4}
+}
+
+// ========== Collector Health ==========
+// Lets the server distinguish "no GPU" from "GPU collector failed"
+type CollectorState int32
+
+const (
+	CollectorState_COLLECTOR_OK       CollectorState = 0
+	CollectorState_COLLECTOR_ERROR    CollectorState = 1
+	CollectorState_COLLECTOR_DISABLED CollectorState = 2
+)
+
+// Enum value maps for CollectorState.
+var (
+	CollectorState_name = map[int32]string{
+		0: "COLLECTOR_OK",
+		1: "COLLECTOR_ERROR",
+		2: "COLLECTOR_DISABLED",
+	}
+	CollectorState_value = map[string]int32{
+		"COLLECTOR_OK":       0,
+		"COLLECTOR_ERROR":    1,
+		"COLLECTOR_DISABLED": 2,
+	}
+)
+
+func (x CollectorState) Enum() *CollectorState {
+	p := new(CollectorState)
+	*p = x
+	return p
+}
+
+func (x CollectorState) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (CollectorState) Descriptor() protoreflect.EnumDescriptor {
+	return file_nanolink_proto_enumTypes[5].Descriptor()
+}
+
+func (CollectorState) Type() protoreflect.EnumType {
+	return &file_nanolink_proto_enumTypes[5]
+}
+
+func (x CollectorState) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use CollectorState.Descriptor instead.
+func (CollectorState) EnumDescriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{5}
 }
 
 type CommandType int32
@@ -165,29 +398,80 @@ const (
 	CommandType_SYSTEM_REBOOT CommandType = 40
 	// Shell Command (requires SuperToken)
 	CommandType_SHELL_EXECUTE CommandType = 50
+	// Agent Self-Upgrade Operations (requires SYSTEM_ADMIN permission)
+	CommandType_AGENT_CHECK_UPDATE    CommandType = 60 // Check for available updates
+	CommandType_AGENT_DOWNLOAD_UPDATE CommandType = 61 // Download update package
+	CommandType_AGENT_APPLY_UPDATE    CommandType = 62 // Apply update and restart agent
+	CommandType_AGENT_GET_VERSION     CommandType = 63 // Get current agent version info
+	// ========== DevOps Extension Commands ==========
+	// Log Query Commands (Level 0/1+ with sanitization)
+	CommandType_SERVICE_LOGS CommandType = 70 // Query journald/systemd service logs
+	CommandType_SYSTEM_LOGS  CommandType = 71 // Query /var/log system logs
+	CommandType_AUDIT_LOGS   CommandType = 72 // Query auditd audit logs
+	CommandType_LOG_STREAM   CommandType = 73 // Realtime log stream (tail -f)
+	// Package Management Commands
+	CommandType_PACKAGE_LIST          CommandType = 80 // List installed packages
+	CommandType_PACKAGE_CHECK_UPDATES CommandType = 81 // Check for available updates
+	CommandType_PACKAGE_UPDATE        CommandType = 82 // Update specific package (SYSTEM_ADMIN)
+	CommandType_SYSTEM_UPDATE         CommandType = 83 // Full system update (SYSTEM_ADMIN)
+	// Script Execution Commands
+	CommandType_SCRIPT_LIST    CommandType = 90 // List available scripts
+	CommandType_SCRIPT_EXECUTE CommandType = 91 // Execute predefined script
+	CommandType_SCRIPT_UPLOAD  CommandType = 92 // Upload new script (SYSTEM_ADMIN)
+	// Config Management Commands
+	CommandType_CONFIG_READ         CommandType = 100 // Read config file
+	CommandType_CONFIG_WRITE        CommandType = 101 // Write config file (with backup)
+	CommandType_CONFIG_VALIDATE     CommandType = 102 // Validate config syntax
+	CommandType_CONFIG_ROLLBACK     CommandType = 103 // Rollback to previous version
+	CommandType_CONFIG_LIST_BACKUPS CommandType = 104 // List available backups
+	// Health Check Commands
+	CommandType_HEALTH_CHECK      CommandType = 110 // Custom health check
+	CommandType_CONNECTIVITY_TEST CommandType = 111 // Network connectivity test
 )
 
 // Enum value maps for CommandType.
 var (
 	CommandType_name = map[int32]string{
-		0:  "COMMAND_TYPE_UNSPECIFIED",
-		1:  "PROCESS_LIST",
-		2:  "PROCESS_KILL",
-		10: "SERVICE_START",
-		11: "SERVICE_STOP",
-		12: "SERVICE_RESTART",
-		13: "SERVICE_STATUS",
-		20: "FILE_TAIL",
-		21: "FILE_DOWNLOAD",
-		22: "FILE_UPLOAD",
-		23: "FILE_TRUNCATE",
-		30: "DOCKER_LIST",
-		31: "DOCKER_START",
-		32: "DOCKER_STOP",
-		33: "DOCKER_RESTART",
-		34: "DOCKER_LOGS",
-		40: "SYSTEM_REBOOT",
-		50: "SHELL_EXECUTE",
+		0:   "COMMAND_TYPE_UNSPECIFIED",
+		1:   "PROCESS_LIST",
+		2:   "PROCESS_KILL",
+		10:  "SERVICE_START",
+		11:  "SERVICE_STOP",
+		12:  "SERVICE_RESTART",
+		13:  "SERVICE_STATUS",
+		20:  "FILE_TAIL",
+		21:  "FILE_DOWNLOAD",
+		22:  "FILE_UPLOAD",
+		23:  "FILE_TRUNCATE",
+		30:  "DOCKER_LIST",
+		31:  "DOCKER_START",
+		32:  "DOCKER_STOP",
+		33:  "DOCKER_RESTART",
+		34:  "DOCKER_LOGS",
+		40:  "SYSTEM_REBOOT",
+		50:  "SHELL_EXECUTE",
+		60:  "AGENT_CHECK_UPDATE",
+		61:  "AGENT_DOWNLOAD_UPDATE",
+		62:  "AGENT_APPLY_UPDATE",
+		63:  "AGENT_GET_VERSION",
+		70:  "SERVICE_LOGS",
+		71:  "SYSTEM_LOGS",
+		72:  "AUDIT_LOGS",
+		73:  "LOG_STREAM",
+		80:  "PACKAGE_LIST",
+		81:  "PACKAGE_CHECK_UPDATES",
+		82:  "PACKAGE_UPDATE",
+		83:  "SYSTEM_UPDATE",
+		90:  "SCRIPT_LIST",
+		91:  "SCRIPT_EXECUTE",
+		92:  "SCRIPT_UPLOAD",
+		100: "CONFIG_READ",
+		101: "CONFIG_WRITE",
+		102: "CONFIG_VALIDATE",
+		103: "CONFIG_ROLLBACK",
+		104: "CONFIG_LIST_BACKUPS",
+		110: "HEALTH_CHECK",
+		111: "CONNECTIVITY_TEST",
 	}
 	CommandType_value = map[string]int32{
 		"COMMAND_TYPE_UNSPECIFIED": 0,
@@ -208,6 +492,28 @@ var (
 		"DOCKER_LOGS":              34,
 		"SYSTEM_REBOOT":            40,
 		"SHELL_EXECUTE":            50,
+		"AGENT_CHECK_UPDATE":       60,
+		"AGENT_DOWNLOAD_UPDATE":    61,
+		"AGENT_APPLY_UPDATE":       62,
+		"AGENT_GET_VERSION":        63,
+		"SERVICE_LOGS":             70,
+		"SYSTEM_LOGS":              71,
+		"AUDIT_LOGS":               72,
+		"LOG_STREAM":               73,
+		"PACKAGE_LIST":             80,
+		"PACKAGE_CHECK_UPDATES":    81,
+		"PACKAGE_UPDATE":           82,
+		"SYSTEM_UPDATE":            83,
+		"SCRIPT_LIST":              90,
+		"SCRIPT_EXECUTE":           91,
+		"SCRIPT_UPLOAD":            92,
+		"CONFIG_READ":              100,
+		"CONFIG_WRITE":             101,
+		"CONFIG_VALIDATE":          102,
+		"CONFIG_ROLLBACK":          103,
+		"CONFIG_LIST_BACKUPS":      104,
+		"HEALTH_CHECK":             110,
+		"CONNECTIVITY_TEST":        111,
 	}
 )
 
@@ -222,11 +528,11 @@ func (x CommandType) String() string {
 }
 
 func (CommandType) Descriptor() protoreflect.EnumDescriptor {
-	return file_nanolink_proto_enumTypes[2].Descriptor()
+	return file_nanolink_proto_enumTypes[6].Descriptor()
 }
 
 func (CommandType) Type() protoreflect.EnumType {
-	return &file_nanolink_proto_enumTypes[2]
+	return &file_nanolink_proto_enumTypes[6]
 }
 
 func (x CommandType) Number() protoreflect.EnumNumber {
@@ -235,7 +541,7 @@ func (x CommandType) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use CommandType.Descriptor instead.
 func (CommandType) EnumDescriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{2}
+	return file_nanolink_proto_rawDescGZIP(), []int{6}
 }
 
 type AgentEvent_EventType int32
@@ -271,11 +577,11 @@ func (x AgentEvent_EventType) String() string {
 }
 
 func (AgentEvent_EventType) Descriptor() protoreflect.EnumDescriptor {
-	return file_nanolink_proto_enumTypes[3].Descriptor()
+	return file_nanolink_proto_enumTypes[7].Descriptor()
 }
 
 func (AgentEvent_EventType) Type() protoreflect.EnumType {
-	return &file_nanolink_proto_enumTypes[3]
+	return &file_nanolink_proto_enumTypes[7]
 }
 
 func (x AgentEvent_EventType) Number() protoreflect.EnumNumber {
@@ -284,7 +590,7 @@ func (x AgentEvent_EventType) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use AgentEvent_EventType.Descriptor instead.
 func (AgentEvent_EventType) EnumDescriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{46, 0}
+	return file_nanolink_proto_rawDescGZIP(), []int{63, 0}
 }
 
 // ========== Message Envelope ==========
@@ -484,14 +790,18 @@ func (*Envelope_HeartbeatAck) isEnvelope_Payload() {}
 
 // ========== Authentication ==========
 type AuthRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Token         string                 `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
-	Hostname      string                 `protobuf:"bytes,2,opt,name=hostname,proto3" json:"hostname,omitempty"`
-	AgentVersion  string                 `protobuf:"bytes,3,opt,name=agent_version,json=agentVersion,proto3" json:"agent_version,omitempty"`
-	Os            string                 `protobuf:"bytes,4,opt,name=os,proto3" json:"os,omitempty"`
-	Arch          string                 `protobuf:"bytes,5,opt,name=arch,proto3" json:"arch,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Token             string                 `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	Hostname          string                 `protobuf:"bytes,2,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	AgentVersion      string                 `protobuf:"bytes,3,opt,name=agent_version,json=agentVersion,proto3" json:"agent_version,omitempty"`
+	Os                string                 `protobuf:"bytes,4,opt,name=os,proto3" json:"os,omitempty"`
+	Arch              string                 `protobuf:"bytes,5,opt,name=arch,proto3" json:"arch,omitempty"`
+	RequestMetricsAck bool                   `protobuf:"varint,6,opt,name=request_metrics_ack,json=requestMetricsAck,proto3" json:"request_metrics_ack,omitempty"`                         // Ask for per-message acks on the metrics stream
+	SessionToken      string                 `protobuf:"bytes,7,opt,name=session_token,json=sessionToken,proto3" json:"session_token,omitempty"`                                           // Resume a previous session; the token may then be omitted
+	Labels            map[string]string      `protobuf:"bytes,8,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // Agent labels such as env=prod, kept for the session
+	CommandPolicy     *CommandPolicy         `protobuf:"bytes,9,opt,name=command_policy,json=commandPolicy,proto3" json:"command_policy,omitempty"`                                        // The agent's shell policy, so the server skips commands it would refuse
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
 }
 
 func (x *AuthRequest) Reset() {
@@ -559,18 +869,121 @@ func (x *AuthRequest) GetArch() string {
 	return ""
 }
 
+func (x *AuthRequest) GetRequestMetricsAck() bool {
+	if x != nil {
+		return x.RequestMetricsAck
+	}
+	return false
+}
+
+func (x *AuthRequest) GetSessionToken() string {
+	if x != nil {
+		return x.SessionToken
+	}
+	return ""
+}
+
+func (x *AuthRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *AuthRequest) GetCommandPolicy() *CommandPolicy {
+	if x != nil {
+		return x.CommandPolicy
+	}
+	return nil
+}
+
+// CommandPolicy is the shell whitelist/blacklist an agent enforces. Patterns
+// match as in the agent config: "*" is a wildcard, no "*" means exact.
+type CommandPolicy struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	ShellEnabled   bool                   `protobuf:"varint,1,opt,name=shell_enabled,json=shellEnabled,proto3" json:"shell_enabled,omitempty"`
+	ShellWhitelist []string               `protobuf:"bytes,2,rep,name=shell_whitelist,json=shellWhitelist,proto3" json:"shell_whitelist,omitempty"` // Empty allows any command not blacklisted
+	ShellBlacklist []string               `protobuf:"bytes,3,rep,name=shell_blacklist,json=shellBlacklist,proto3" json:"shell_blacklist,omitempty"` // Substrings that are always refused
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *CommandPolicy) Reset() {
+	*x = CommandPolicy{}
+	mi := &file_nanolink_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommandPolicy) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommandPolicy) ProtoMessage() {}
+
+func (x *CommandPolicy) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommandPolicy.ProtoReflect.Descriptor instead.
+func (*CommandPolicy) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *CommandPolicy) GetShellEnabled() bool {
+	if x != nil {
+		return x.ShellEnabled
+	}
+	return false
+}
+
+func (x *CommandPolicy) GetShellWhitelist() []string {
+	if x != nil {
+		return x.ShellWhitelist
+	}
+	return nil
+}
+
+func (x *CommandPolicy) GetShellBlacklist() []string {
+	if x != nil {
+		return x.ShellBlacklist
+	}
+	return nil
+}
+
 type AuthResponse struct {
 	state           protoimpl.MessageState `protogen:"open.v1"`
 	Success         bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
 	PermissionLevel int32                  `protobuf:"varint,2,opt,name=permission_level,json=permissionLevel,proto3" json:"permission_level,omitempty"` // 0=READ_ONLY, 1=BASIC_WRITE, 2=SERVICE_CONTROL, 3=SYSTEM_ADMIN
 	ErrorMessage    string                 `protobuf:"bytes,3,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"`
-	unknownFields   protoimpl.UnknownFields
-	sizeCache       protoimpl.SizeCache
+	// Granted when requested and allowed by the server. The agent then puts
+	// "metrics_ack" in AgentInit.capabilities and numbers its metrics messages.
+	MetricsAck bool `protobuf:"varint,4,opt,name=metrics_ack,json=metricsAck,proto3" json:"metrics_ack,omitempty"`
+	// Present session_token in AgentInit (and in AuthRequest on reconnect) to
+	// keep the same agent ID and get back commands queued while disconnected.
+	SessionToken     string `protobuf:"bytes,5,opt,name=session_token,json=sessionToken,proto3" json:"session_token,omitempty"`
+	SessionExpiresAt uint64 `protobuf:"varint,6,opt,name=session_expires_at,json=sessionExpiresAt,proto3" json:"session_expires_at,omitempty"` // Unix ms
+	AgentId          string `protobuf:"bytes,7,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`                               // Agent ID restored from a resumed session
+	// Set when success is false so the agent can tell a failure worth retrying
+	// from one it should stop on
+	FailureReason AuthFailureReason `protobuf:"varint,8,opt,name=failure_reason,json=failureReason,proto3,enum=nanolink.AuthFailureReason" json:"failure_reason,omitempty"`
+	Retryable     bool              `protobuf:"varint,9,opt,name=retryable,proto3" json:"retryable,omitempty"`
+	RetryAfterMs  uint32            `protobuf:"varint,10,opt,name=retry_after_ms,json=retryAfterMs,proto3" json:"retry_after_ms,omitempty"` // Suggested wait before retrying; 0 means retry now
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *AuthResponse) Reset() {
 	*x = AuthResponse{}
-	mi := &file_nanolink_proto_msgTypes[2]
+	mi := &file_nanolink_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -582,7 +995,7 @@ func (x *AuthResponse) String() string {
 func (*AuthResponse) ProtoMessage() {}
 
 func (x *AuthResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[2]
+	mi := &file_nanolink_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -595,7 +1008,7 @@ func (x *AuthResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use AuthResponse.ProtoReflect.Descriptor instead.
 func (*AuthResponse) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{2}
+	return file_nanolink_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *AuthResponse) GetSuccess() bool {
@@ -619,6 +1032,55 @@ func (x *AuthResponse) GetErrorMessage() string {
 	return ""
 }
 
+func (x *AuthResponse) GetMetricsAck() bool {
+	if x != nil {
+		return x.MetricsAck
+	}
+	return false
+}
+
+func (x *AuthResponse) GetSessionToken() string {
+	if x != nil {
+		return x.SessionToken
+	}
+	return ""
+}
+
+func (x *AuthResponse) GetSessionExpiresAt() uint64 {
+	if x != nil {
+		return x.SessionExpiresAt
+	}
+	return 0
+}
+
+func (x *AuthResponse) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *AuthResponse) GetFailureReason() AuthFailureReason {
+	if x != nil {
+		return x.FailureReason
+	}
+	return AuthFailureReason_AUTH_FAILURE_NONE
+}
+
+func (x *AuthResponse) GetRetryable() bool {
+	if x != nil {
+		return x.Retryable
+	}
+	return false
+}
+
+func (x *AuthResponse) GetRetryAfterMs() uint32 {
+	if x != nil {
+		return x.RetryAfterMs
+	}
+	return 0
+}
+
 // Data request message from server to agent
 type DataRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -630,7 +1092,7 @@ type DataRequest struct {
 
 func (x *DataRequest) Reset() {
 	*x = DataRequest{}
-	mi := &file_nanolink_proto_msgTypes[3]
+	mi := &file_nanolink_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -642,7 +1104,7 @@ func (x *DataRequest) String() string {
 func (*DataRequest) ProtoMessage() {}
 
 func (x *DataRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[3]
+	mi := &file_nanolink_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -655,7 +1117,7 @@ func (x *DataRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DataRequest.ProtoReflect.Descriptor instead.
 func (*DataRequest) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{3}
+	return file_nanolink_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *DataRequest) GetRequestType() DataRequestType {
@@ -674,27 +1136,28 @@ func (x *DataRequest) GetTarget() string {
 
 // ========== Complete Metrics (for first connection or full request) ==========
 type Metrics struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Timestamp     uint64                 `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
-	Cpu           *CpuMetrics            `protobuf:"bytes,2,opt,name=cpu,proto3" json:"cpu,omitempty"`
-	Memory        *MemoryMetrics         `protobuf:"bytes,3,opt,name=memory,proto3" json:"memory,omitempty"`
-	Disks         []*DiskMetrics         `protobuf:"bytes,4,rep,name=disks,proto3" json:"disks,omitempty"`
-	Networks      []*NetworkMetrics      `protobuf:"bytes,5,rep,name=networks,proto3" json:"networks,omitempty"`
-	LoadAverage   []float64              `protobuf:"fixed64,6,rep,packed,name=load_average,json=loadAverage,proto3" json:"load_average,omitempty"` // [1min, 5min, 15min]
-	Hostname      string                 `protobuf:"bytes,7,opt,name=hostname,proto3" json:"hostname,omitempty"`
-	Gpus          []*GpuMetrics          `protobuf:"bytes,8,rep,name=gpus,proto3" json:"gpus,omitempty"`
-	SystemInfo    *SystemInfo            `protobuf:"bytes,9,opt,name=system_info,json=systemInfo,proto3" json:"system_info,omitempty"`
-	UserSessions  []*UserSession         `protobuf:"bytes,10,rep,name=user_sessions,json=userSessions,proto3" json:"user_sessions,omitempty"`                         // Logged-in users
-	Npus          []*NpuMetrics          `protobuf:"bytes,11,rep,name=npus,proto3" json:"npus,omitempty"`                                                             // AI accelerators (NPU/TPU)
-	MetricsType   MetricsType            `protobuf:"varint,12,opt,name=metrics_type,json=metricsType,proto3,enum=nanolink.MetricsType" json:"metrics_type,omitempty"` // Type of this metrics message
-	IsInitial     bool                   `protobuf:"varint,13,opt,name=is_initial,json=isInitial,proto3" json:"is_initial,omitempty"`                                 // True if this is initial full data
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Timestamp       uint64                 `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Cpu             *CpuMetrics            `protobuf:"bytes,2,opt,name=cpu,proto3" json:"cpu,omitempty"`
+	Memory          *MemoryMetrics         `protobuf:"bytes,3,opt,name=memory,proto3" json:"memory,omitempty"`
+	Disks           []*DiskMetrics         `protobuf:"bytes,4,rep,name=disks,proto3" json:"disks,omitempty"`
+	Networks        []*NetworkMetrics      `protobuf:"bytes,5,rep,name=networks,proto3" json:"networks,omitempty"`
+	LoadAverage     []float64              `protobuf:"fixed64,6,rep,packed,name=load_average,json=loadAverage,proto3" json:"load_average,omitempty"` // [1min, 5min, 15min]
+	Hostname        string                 `protobuf:"bytes,7,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Gpus            []*GpuMetrics          `protobuf:"bytes,8,rep,name=gpus,proto3" json:"gpus,omitempty"`
+	SystemInfo      *SystemInfo            `protobuf:"bytes,9,opt,name=system_info,json=systemInfo,proto3" json:"system_info,omitempty"`
+	UserSessions    []*UserSession         `protobuf:"bytes,10,rep,name=user_sessions,json=userSessions,proto3" json:"user_sessions,omitempty"`                         // Logged-in users
+	Npus            []*NpuMetrics          `protobuf:"bytes,11,rep,name=npus,proto3" json:"npus,omitempty"`                                                             // AI accelerators (NPU/TPU)
+	MetricsType     MetricsType            `protobuf:"varint,12,opt,name=metrics_type,json=metricsType,proto3,enum=nanolink.MetricsType" json:"metrics_type,omitempty"` // Type of this metrics message
+	IsInitial       bool                   `protobuf:"varint,13,opt,name=is_initial,json=isInitial,proto3" json:"is_initial,omitempty"`                                 // True if this is initial full data
+	CollectorStatus []*CollectorStatus     `protobuf:"bytes,14,rep,name=collector_status,json=collectorStatus,proto3" json:"collector_status,omitempty"`                // Per-collector health
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
 }
 
 func (x *Metrics) Reset() {
 	*x = Metrics{}
-	mi := &file_nanolink_proto_msgTypes[4]
+	mi := &file_nanolink_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -706,7 +1169,7 @@ func (x *Metrics) String() string {
 func (*Metrics) ProtoMessage() {}
 
 func (x *Metrics) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[4]
+	mi := &file_nanolink_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -719,7 +1182,7 @@ func (x *Metrics) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Metrics.ProtoReflect.Descriptor instead.
 func (*Metrics) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{4}
+	return file_nanolink_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *Metrics) GetTimestamp() uint64 {
@@ -813,6 +1276,13 @@ func (x *Metrics) GetIsInitial() bool {
 	return false
 }
 
+func (x *Metrics) GetCollectorStatus() []*CollectorStatus {
+	if x != nil {
+		return x.CollectorStatus
+	}
+	return nil
+}
+
 // ========== Realtime Metrics (sent every second) ==========
 // Lightweight message for frequently changing data
 type RealtimeMetrics struct {
@@ -830,13 +1300,15 @@ type RealtimeMetrics struct {
 	LoadAverage     []float64              `protobuf:"fixed64,11,rep,packed,name=load_average,json=loadAverage,proto3" json:"load_average,omitempty"`
 	GpuUsage        []*GpuUsage            `protobuf:"bytes,12,rep,name=gpu_usage,json=gpuUsage,proto3" json:"gpu_usage,omitempty"`
 	NpuUsage        []*NpuUsage            `protobuf:"bytes,13,rep,name=npu_usage,json=npuUsage,proto3" json:"npu_usage,omitempty"`
+	Delta           bool                   `protobuf:"varint,14,opt,name=delta,proto3" json:"delta,omitempty"`                                      // Only the fields flagged in changed_fields are set
+	ChangedFields   uint32                 `protobuf:"varint,15,opt,name=changed_fields,json=changedFields,proto3" json:"changed_fields,omitempty"` // Bitmask of RealtimeField values present in a delta
 	unknownFields   protoimpl.UnknownFields
 	sizeCache       protoimpl.SizeCache
 }
 
 func (x *RealtimeMetrics) Reset() {
 	*x = RealtimeMetrics{}
-	mi := &file_nanolink_proto_msgTypes[5]
+	mi := &file_nanolink_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -848,7 +1320,7 @@ func (x *RealtimeMetrics) String() string {
 func (*RealtimeMetrics) ProtoMessage() {}
 
 func (x *RealtimeMetrics) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[5]
+	mi := &file_nanolink_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -861,7 +1333,7 @@ func (x *RealtimeMetrics) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RealtimeMetrics.ProtoReflect.Descriptor instead.
 func (*RealtimeMetrics) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{5}
+	return file_nanolink_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *RealtimeMetrics) GetTimestamp() uint64 {
@@ -955,6 +1427,20 @@ func (x *RealtimeMetrics) GetNpuUsage() []*NpuUsage {
 	return nil
 }
 
+func (x *RealtimeMetrics) GetDelta() bool {
+	if x != nil {
+		return x.Delta
+	}
+	return false
+}
+
+func (x *RealtimeMetrics) GetChangedFields() uint32 {
+	if x != nil {
+		return x.ChangedFields
+	}
+	return 0
+}
+
 // Disk IO metrics (realtime)
 type DiskIO struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -969,7 +1455,7 @@ type DiskIO struct {
 
 func (x *DiskIO) Reset() {
 	*x = DiskIO{}
-	mi := &file_nanolink_proto_msgTypes[6]
+	mi := &file_nanolink_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -981,7 +1467,7 @@ func (x *DiskIO) String() string {
 func (*DiskIO) ProtoMessage() {}
 
 func (x *DiskIO) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[6]
+	mi := &file_nanolink_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -994,7 +1480,7 @@ func (x *DiskIO) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DiskIO.ProtoReflect.Descriptor instead.
 func (*DiskIO) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{6}
+	return file_nanolink_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *DiskIO) GetDevice() string {
@@ -1047,7 +1533,7 @@ type NetworkIO struct {
 
 func (x *NetworkIO) Reset() {
 	*x = NetworkIO{}
-	mi := &file_nanolink_proto_msgTypes[7]
+	mi := &file_nanolink_proto_msgTypes[8]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1059,7 +1545,7 @@ func (x *NetworkIO) String() string {
 func (*NetworkIO) ProtoMessage() {}
 
 func (x *NetworkIO) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[7]
+	mi := &file_nanolink_proto_msgTypes[8]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1072,7 +1558,7 @@ func (x *NetworkIO) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NetworkIO.ProtoReflect.Descriptor instead.
 func (*NetworkIO) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{7}
+	return file_nanolink_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *NetworkIO) GetInterface() string {
@@ -1134,7 +1620,7 @@ type GpuUsage struct {
 
 func (x *GpuUsage) Reset() {
 	*x = GpuUsage{}
-	mi := &file_nanolink_proto_msgTypes[8]
+	mi := &file_nanolink_proto_msgTypes[9]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1146,7 +1632,7 @@ func (x *GpuUsage) String() string {
 func (*GpuUsage) ProtoMessage() {}
 
 func (x *GpuUsage) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[8]
+	mi := &file_nanolink_proto_msgTypes[9]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1159,7 +1645,7 @@ func (x *GpuUsage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GpuUsage.ProtoReflect.Descriptor instead.
 func (*GpuUsage) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{8}
+	return file_nanolink_proto_rawDescGZIP(), []int{9}
 }
 
 func (x *GpuUsage) GetIndex() uint32 {
@@ -1232,7 +1718,7 @@ type NpuUsage struct {
 
 func (x *NpuUsage) Reset() {
 	*x = NpuUsage{}
-	mi := &file_nanolink_proto_msgTypes[9]
+	mi := &file_nanolink_proto_msgTypes[10]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1244,7 +1730,7 @@ func (x *NpuUsage) String() string {
 func (*NpuUsage) ProtoMessage() {}
 
 func (x *NpuUsage) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[9]
+	mi := &file_nanolink_proto_msgTypes[10]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1257,7 +1743,7 @@ func (x *NpuUsage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NpuUsage.ProtoReflect.Descriptor instead.
 func (*NpuUsage) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{9}
+	return file_nanolink_proto_rawDescGZIP(), []int{10}
 }
 
 func (x *NpuUsage) GetIndex() uint32 {
@@ -1313,7 +1799,7 @@ type StaticInfo struct {
 
 func (x *StaticInfo) Reset() {
 	*x = StaticInfo{}
-	mi := &file_nanolink_proto_msgTypes[10]
+	mi := &file_nanolink_proto_msgTypes[11]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1325,7 +1811,7 @@ func (x *StaticInfo) String() string {
 func (*StaticInfo) ProtoMessage() {}
 
 func (x *StaticInfo) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[10]
+	mi := &file_nanolink_proto_msgTypes[11]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1338,7 +1824,7 @@ func (x *StaticInfo) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use StaticInfo.ProtoReflect.Descriptor instead.
 func (*StaticInfo) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{10}
+	return file_nanolink_proto_rawDescGZIP(), []int{11}
 }
 
 func (x *StaticInfo) GetTimestamp() uint64 {
@@ -1421,7 +1907,7 @@ type CpuStaticInfo struct {
 
 func (x *CpuStaticInfo) Reset() {
 	*x = CpuStaticInfo{}
-	mi := &file_nanolink_proto_msgTypes[11]
+	mi := &file_nanolink_proto_msgTypes[12]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1433,7 +1919,7 @@ func (x *CpuStaticInfo) String() string {
 func (*CpuStaticInfo) ProtoMessage() {}
 
 func (x *CpuStaticInfo) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[11]
+	mi := &file_nanolink_proto_msgTypes[12]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1446,7 +1932,7 @@ func (x *CpuStaticInfo) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CpuStaticInfo.ProtoReflect.Descriptor instead.
 func (*CpuStaticInfo) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{11}
+	return file_nanolink_proto_rawDescGZIP(), []int{12}
 }
 
 func (x *CpuStaticInfo) GetModel() string {
@@ -1525,7 +2011,7 @@ type MemoryStaticInfo struct {
 
 func (x *MemoryStaticInfo) Reset() {
 	*x = MemoryStaticInfo{}
-	mi := &file_nanolink_proto_msgTypes[12]
+	mi := &file_nanolink_proto_msgTypes[13]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1537,7 +2023,7 @@ func (x *MemoryStaticInfo) String() string {
 func (*MemoryStaticInfo) ProtoMessage() {}
 
 func (x *MemoryStaticInfo) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[12]
+	mi := &file_nanolink_proto_msgTypes[13]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1550,7 +2036,7 @@ func (x *MemoryStaticInfo) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MemoryStaticInfo.ProtoReflect.Descriptor instead.
 func (*MemoryStaticInfo) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{12}
+	return file_nanolink_proto_rawDescGZIP(), []int{13}
 }
 
 func (x *MemoryStaticInfo) GetTotal() uint64 {
@@ -1604,7 +2090,7 @@ type DiskStaticInfo struct {
 
 func (x *DiskStaticInfo) Reset() {
 	*x = DiskStaticInfo{}
-	mi := &file_nanolink_proto_msgTypes[13]
+	mi := &file_nanolink_proto_msgTypes[14]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1616,7 +2102,7 @@ func (x *DiskStaticInfo) String() string {
 func (*DiskStaticInfo) ProtoMessage() {}
 
 func (x *DiskStaticInfo) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[13]
+	mi := &file_nanolink_proto_msgTypes[14]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1629,7 +2115,7 @@ func (x *DiskStaticInfo) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DiskStaticInfo.ProtoReflect.Descriptor instead.
 func (*DiskStaticInfo) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{13}
+	return file_nanolink_proto_rawDescGZIP(), []int{14}
 }
 
 func (x *DiskStaticInfo) GetDevice() string {
@@ -1702,7 +2188,7 @@ type NetworkStaticInfo struct {
 
 func (x *NetworkStaticInfo) Reset() {
 	*x = NetworkStaticInfo{}
-	mi := &file_nanolink_proto_msgTypes[14]
+	mi := &file_nanolink_proto_msgTypes[15]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1714,7 +2200,7 @@ func (x *NetworkStaticInfo) String() string {
 func (*NetworkStaticInfo) ProtoMessage() {}
 
 func (x *NetworkStaticInfo) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[14]
+	mi := &file_nanolink_proto_msgTypes[15]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1727,7 +2213,7 @@ func (x *NetworkStaticInfo) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NetworkStaticInfo.ProtoReflect.Descriptor instead.
 func (*NetworkStaticInfo) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{14}
+	return file_nanolink_proto_rawDescGZIP(), []int{15}
 }
 
 func (x *NetworkStaticInfo) GetInterface() string {
@@ -1787,7 +2273,7 @@ type GpuStaticInfo struct {
 
 func (x *GpuStaticInfo) Reset() {
 	*x = GpuStaticInfo{}
-	mi := &file_nanolink_proto_msgTypes[15]
+	mi := &file_nanolink_proto_msgTypes[16]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1799,7 +2285,7 @@ func (x *GpuStaticInfo) String() string {
 func (*GpuStaticInfo) ProtoMessage() {}
 
 func (x *GpuStaticInfo) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[15]
+	mi := &file_nanolink_proto_msgTypes[16]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1812,7 +2298,7 @@ func (x *GpuStaticInfo) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GpuStaticInfo.ProtoReflect.Descriptor instead.
 func (*GpuStaticInfo) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{15}
+	return file_nanolink_proto_rawDescGZIP(), []int{16}
 }
 
 func (x *GpuStaticInfo) GetIndex() uint32 {
@@ -1877,7 +2363,7 @@ type NpuStaticInfo struct {
 
 func (x *NpuStaticInfo) Reset() {
 	*x = NpuStaticInfo{}
-	mi := &file_nanolink_proto_msgTypes[16]
+	mi := &file_nanolink_proto_msgTypes[17]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1889,7 +2375,7 @@ func (x *NpuStaticInfo) String() string {
 func (*NpuStaticInfo) ProtoMessage() {}
 
 func (x *NpuStaticInfo) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[16]
+	mi := &file_nanolink_proto_msgTypes[17]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1902,7 +2388,7 @@ func (x *NpuStaticInfo) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NpuStaticInfo.ProtoReflect.Descriptor instead.
 func (*NpuStaticInfo) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{16}
+	return file_nanolink_proto_rawDescGZIP(), []int{17}
 }
 
 func (x *NpuStaticInfo) GetIndex() uint32 {
@@ -1942,18 +2428,19 @@ func (x *NpuStaticInfo) GetDriverVersion() string {
 
 // ========== Periodic Data (disk usage, user sessions) ==========
 type PeriodicData struct {
-	state          protoimpl.MessageState  `protogen:"open.v1"`
-	Timestamp      uint64                  `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
-	DiskUsage      []*DiskUsage            `protobuf:"bytes,2,rep,name=disk_usage,json=diskUsage,proto3" json:"disk_usage,omitempty"`
-	UserSessions   []*UserSession          `protobuf:"bytes,3,rep,name=user_sessions,json=userSessions,proto3" json:"user_sessions,omitempty"`
-	NetworkUpdates []*NetworkAddressUpdate `protobuf:"bytes,4,rep,name=network_updates,json=networkUpdates,proto3" json:"network_updates,omitempty"`
-	unknownFields  protoimpl.UnknownFields
-	sizeCache      protoimpl.SizeCache
+	state           protoimpl.MessageState  `protogen:"open.v1"`
+	Timestamp       uint64                  `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	DiskUsage       []*DiskUsage            `protobuf:"bytes,2,rep,name=disk_usage,json=diskUsage,proto3" json:"disk_usage,omitempty"`
+	UserSessions    []*UserSession          `protobuf:"bytes,3,rep,name=user_sessions,json=userSessions,proto3" json:"user_sessions,omitempty"`
+	NetworkUpdates  []*NetworkAddressUpdate `protobuf:"bytes,4,rep,name=network_updates,json=networkUpdates,proto3" json:"network_updates,omitempty"`
+	CollectorStatus []*CollectorStatus      `protobuf:"bytes,5,rep,name=collector_status,json=collectorStatus,proto3" json:"collector_status,omitempty"` // Per-collector health
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
 }
 
 func (x *PeriodicData) Reset() {
 	*x = PeriodicData{}
-	mi := &file_nanolink_proto_msgTypes[17]
+	mi := &file_nanolink_proto_msgTypes[18]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1965,7 +2452,7 @@ func (x *PeriodicData) String() string {
 func (*PeriodicData) ProtoMessage() {}
 
 func (x *PeriodicData) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[17]
+	mi := &file_nanolink_proto_msgTypes[18]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1978,7 +2465,7 @@ func (x *PeriodicData) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PeriodicData.ProtoReflect.Descriptor instead.
 func (*PeriodicData) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{17}
+	return file_nanolink_proto_rawDescGZIP(), []int{18}
 }
 
 func (x *PeriodicData) GetTimestamp() uint64 {
@@ -2009,6 +2496,73 @@ func (x *PeriodicData) GetNetworkUpdates() []*NetworkAddressUpdate {
 	return nil
 }
 
+func (x *PeriodicData) GetCollectorStatus() []*CollectorStatus {
+	if x != nil {
+		return x.CollectorStatus
+	}
+	return nil
+}
+
+type CollectorStatus struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"` // cpu, memory, disk, network, gpu, npu, ...
+	State         CollectorState         `protobuf:"varint,2,opt,name=state,proto3,enum=nanolink.CollectorState" json:"state,omitempty"`
+	Reason        string                 `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"` // e.g. "NVML driver/library version mismatch"
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CollectorStatus) Reset() {
+	*x = CollectorStatus{}
+	mi := &file_nanolink_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CollectorStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CollectorStatus) ProtoMessage() {}
+
+func (x *CollectorStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CollectorStatus.ProtoReflect.Descriptor instead.
+func (*CollectorStatus) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *CollectorStatus) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *CollectorStatus) GetState() CollectorState {
+	if x != nil {
+		return x.State
+	}
+	return CollectorState_COLLECTOR_OK
+}
+
+func (x *CollectorStatus) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
 type DiskUsage struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Device        string                 `protobuf:"bytes,1,opt,name=device,proto3" json:"device,omitempty"`
@@ -2023,7 +2577,7 @@ type DiskUsage struct {
 
 func (x *DiskUsage) Reset() {
 	*x = DiskUsage{}
-	mi := &file_nanolink_proto_msgTypes[18]
+	mi := &file_nanolink_proto_msgTypes[20]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2035,7 +2589,7 @@ func (x *DiskUsage) String() string {
 func (*DiskUsage) ProtoMessage() {}
 
 func (x *DiskUsage) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[18]
+	mi := &file_nanolink_proto_msgTypes[20]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2048,7 +2602,7 @@ func (x *DiskUsage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DiskUsage.ProtoReflect.Descriptor instead.
 func (*DiskUsage) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{18}
+	return file_nanolink_proto_rawDescGZIP(), []int{20}
 }
 
 func (x *DiskUsage) GetDevice() string {
@@ -2104,7 +2658,7 @@ type NetworkAddressUpdate struct {
 
 func (x *NetworkAddressUpdate) Reset() {
 	*x = NetworkAddressUpdate{}
-	mi := &file_nanolink_proto_msgTypes[19]
+	mi := &file_nanolink_proto_msgTypes[21]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2116,7 +2670,7 @@ func (x *NetworkAddressUpdate) String() string {
 func (*NetworkAddressUpdate) ProtoMessage() {}
 
 func (x *NetworkAddressUpdate) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[19]
+	mi := &file_nanolink_proto_msgTypes[21]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2129,7 +2683,7 @@ func (x *NetworkAddressUpdate) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NetworkAddressUpdate.ProtoReflect.Descriptor instead.
 func (*NetworkAddressUpdate) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{19}
+	return file_nanolink_proto_rawDescGZIP(), []int{21}
 }
 
 func (x *NetworkAddressUpdate) GetInterface() string {
@@ -2172,7 +2726,7 @@ type CpuMetrics struct {
 
 func (x *CpuMetrics) Reset() {
 	*x = CpuMetrics{}
-	mi := &file_nanolink_proto_msgTypes[20]
+	mi := &file_nanolink_proto_msgTypes[22]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2184,7 +2738,7 @@ func (x *CpuMetrics) String() string {
 func (*CpuMetrics) ProtoMessage() {}
 
 func (x *CpuMetrics) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[20]
+	mi := &file_nanolink_proto_msgTypes[22]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2197,7 +2751,7 @@ func (x *CpuMetrics) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CpuMetrics.ProtoReflect.Descriptor instead.
 func (*CpuMetrics) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{20}
+	return file_nanolink_proto_rawDescGZIP(), []int{22}
 }
 
 func (x *CpuMetrics) GetUsagePercent() float64 {
@@ -2294,7 +2848,7 @@ type MemoryMetrics struct {
 
 func (x *MemoryMetrics) Reset() {
 	*x = MemoryMetrics{}
-	mi := &file_nanolink_proto_msgTypes[21]
+	mi := &file_nanolink_proto_msgTypes[23]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2306,7 +2860,7 @@ func (x *MemoryMetrics) String() string {
 func (*MemoryMetrics) ProtoMessage() {}
 
 func (x *MemoryMetrics) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[21]
+	mi := &file_nanolink_proto_msgTypes[23]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2319,7 +2873,7 @@ func (x *MemoryMetrics) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MemoryMetrics.ProtoReflect.Descriptor instead.
 func (*MemoryMetrics) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{21}
+	return file_nanolink_proto_rawDescGZIP(), []int{23}
 }
 
 func (x *MemoryMetrics) GetTotal() uint64 {
@@ -2408,7 +2962,7 @@ type DiskMetrics struct {
 
 func (x *DiskMetrics) Reset() {
 	*x = DiskMetrics{}
-	mi := &file_nanolink_proto_msgTypes[22]
+	mi := &file_nanolink_proto_msgTypes[24]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2420,7 +2974,7 @@ func (x *DiskMetrics) String() string {
 func (*DiskMetrics) ProtoMessage() {}
 
 func (x *DiskMetrics) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[22]
+	mi := &file_nanolink_proto_msgTypes[24]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2433,7 +2987,7 @@ func (x *DiskMetrics) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DiskMetrics.ProtoReflect.Descriptor instead.
 func (*DiskMetrics) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{22}
+	return file_nanolink_proto_rawDescGZIP(), []int{24}
 }
 
 func (x *DiskMetrics) GetMountPoint() string {
@@ -2559,7 +3113,7 @@ type NetworkMetrics struct {
 
 func (x *NetworkMetrics) Reset() {
 	*x = NetworkMetrics{}
-	mi := &file_nanolink_proto_msgTypes[23]
+	mi := &file_nanolink_proto_msgTypes[25]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2571,7 +3125,7 @@ func (x *NetworkMetrics) String() string {
 func (*NetworkMetrics) ProtoMessage() {}
 
 func (x *NetworkMetrics) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[23]
+	mi := &file_nanolink_proto_msgTypes[25]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2584,7 +3138,7 @@ func (x *NetworkMetrics) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NetworkMetrics.ProtoReflect.Descriptor instead.
 func (*NetworkMetrics) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{23}
+	return file_nanolink_proto_rawDescGZIP(), []int{25}
 }
 
 func (x *NetworkMetrics) GetInterface() string {
@@ -2681,7 +3235,7 @@ type GpuMetrics struct {
 
 func (x *GpuMetrics) Reset() {
 	*x = GpuMetrics{}
-	mi := &file_nanolink_proto_msgTypes[24]
+	mi := &file_nanolink_proto_msgTypes[26]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2693,7 +3247,7 @@ func (x *GpuMetrics) String() string {
 func (*GpuMetrics) ProtoMessage() {}
 
 func (x *GpuMetrics) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[24]
+	mi := &file_nanolink_proto_msgTypes[26]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2706,7 +3260,7 @@ func (x *GpuMetrics) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GpuMetrics.ProtoReflect.Descriptor instead.
 func (*GpuMetrics) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{24}
+	return file_nanolink_proto_rawDescGZIP(), []int{26}
 }
 
 func (x *GpuMetrics) GetIndex() uint32 {
@@ -2840,7 +3394,7 @@ type SystemInfo struct {
 
 func (x *SystemInfo) Reset() {
 	*x = SystemInfo{}
-	mi := &file_nanolink_proto_msgTypes[25]
+	mi := &file_nanolink_proto_msgTypes[27]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2852,7 +3406,7 @@ func (x *SystemInfo) String() string {
 func (*SystemInfo) ProtoMessage() {}
 
 func (x *SystemInfo) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[25]
+	mi := &file_nanolink_proto_msgTypes[27]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2865,7 +3419,7 @@ func (x *SystemInfo) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SystemInfo.ProtoReflect.Descriptor instead.
 func (*SystemInfo) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{25}
+	return file_nanolink_proto_rawDescGZIP(), []int{27}
 }
 
 func (x *SystemInfo) GetOsName() string {
@@ -2959,7 +3513,7 @@ type UserSession struct {
 
 func (x *UserSession) Reset() {
 	*x = UserSession{}
-	mi := &file_nanolink_proto_msgTypes[26]
+	mi := &file_nanolink_proto_msgTypes[28]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -2971,7 +3525,7 @@ func (x *UserSession) String() string {
 func (*UserSession) ProtoMessage() {}
 
 func (x *UserSession) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[26]
+	mi := &file_nanolink_proto_msgTypes[28]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2984,7 +3538,7 @@ func (x *UserSession) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use UserSession.ProtoReflect.Descriptor instead.
 func (*UserSession) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{26}
+	return file_nanolink_proto_rawDescGZIP(), []int{28}
 }
 
 func (x *UserSession) GetUsername() string {
@@ -3046,7 +3600,7 @@ type NpuMetrics struct {
 
 func (x *NpuMetrics) Reset() {
 	*x = NpuMetrics{}
-	mi := &file_nanolink_proto_msgTypes[27]
+	mi := &file_nanolink_proto_msgTypes[29]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -3058,7 +3612,7 @@ func (x *NpuMetrics) String() string {
 func (*NpuMetrics) ProtoMessage() {}
 
 func (x *NpuMetrics) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[27]
+	mi := &file_nanolink_proto_msgTypes[29]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3071,7 +3625,7 @@ func (x *NpuMetrics) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NpuMetrics.ProtoReflect.Descriptor instead.
 func (*NpuMetrics) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{27}
+	return file_nanolink_proto_rawDescGZIP(), []int{29}
 }
 
 func (x *NpuMetrics) GetIndex() uint32 {
@@ -3148,7 +3702,7 @@ type MetricsSync struct {
 
 func (x *MetricsSync) Reset() {
 	*x = MetricsSync{}
-	mi := &file_nanolink_proto_msgTypes[28]
+	mi := &file_nanolink_proto_msgTypes[30]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -3160,7 +3714,7 @@ func (x *MetricsSync) String() string {
 func (*MetricsSync) ProtoMessage() {}
 
 func (x *MetricsSync) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[28]
+	mi := &file_nanolink_proto_msgTypes[30]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3173,7 +3727,7 @@ func (x *MetricsSync) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MetricsSync.ProtoReflect.Descriptor instead.
 func (*MetricsSync) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{28}
+	return file_nanolink_proto_rawDescGZIP(), []int{30}
 }
 
 func (x *MetricsSync) GetLastSyncTimestamp() uint64 {
@@ -3204,7 +3758,7 @@ type Command struct {
 
 func (x *Command) Reset() {
 	*x = Command{}
-	mi := &file_nanolink_proto_msgTypes[29]
+	mi := &file_nanolink_proto_msgTypes[31]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -3216,7 +3770,7 @@ func (x *Command) String() string {
 func (*Command) ProtoMessage() {}
 
 func (x *Command) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[29]
+	mi := &file_nanolink_proto_msgTypes[31]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3229,7 +3783,7 @@ func (x *Command) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Command.ProtoReflect.Descriptor instead.
 func (*Command) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{29}
+	return file_nanolink_proto_rawDescGZIP(), []int{31}
 }
 
 func (x *Command) GetCommandId() string {
@@ -3268,21 +3822,30 @@ func (x *Command) GetSuperToken() string {
 }
 
 type CommandResult struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
-	Success       bool                   `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`
-	Output        string                 `protobuf:"bytes,3,opt,name=output,proto3" json:"output,omitempty"`
-	Error         string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
-	FileContent   []byte                 `protobuf:"bytes,5,opt,name=file_content,json=fileContent,proto3" json:"file_content,omitempty"` // Used for file download
-	Processes     []*ProcessInfo         `protobuf:"bytes,6,rep,name=processes,proto3" json:"processes,omitempty"`                        // Used for PROCESS_LIST
-	Containers    []*ContainerInfo       `protobuf:"bytes,7,rep,name=containers,proto3" json:"containers,omitempty"`                      // Used for DOCKER_LIST
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	CommandId   string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	Success     bool                   `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`
+	Output      string                 `protobuf:"bytes,3,opt,name=output,proto3" json:"output,omitempty"`
+	Error       string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	FileContent []byte                 `protobuf:"bytes,5,opt,name=file_content,json=fileContent,proto3" json:"file_content,omitempty"` // Used for file download
+	Processes   []*ProcessInfo         `protobuf:"bytes,6,rep,name=processes,proto3" json:"processes,omitempty"`                        // Used for PROCESS_LIST
+	Containers  []*ContainerInfo       `protobuf:"bytes,7,rep,name=containers,proto3" json:"containers,omitempty"`                      // Used for DOCKER_LIST
+	UpdateInfo  *UpdateInfo            `protobuf:"bytes,8,opt,name=update_info,json=updateInfo,proto3" json:"update_info,omitempty"`    // Used for AGENT_CHECK_UPDATE/GET_VERSION
+	// DevOps extension result fields
+	LogResult     *LogQueryResult    `protobuf:"bytes,10,opt,name=log_result,json=logResult,proto3" json:"log_result,omitempty"`          // For SERVICE_LOGS/SYSTEM_LOGS/AUDIT_LOGS
+	Packages      []*PackageInfo     `protobuf:"bytes,11,rep,name=packages,proto3" json:"packages,omitempty"`                             // For PACKAGE_LIST/PACKAGE_CHECK_UPDATES
+	Scripts       []*ScriptInfo      `protobuf:"bytes,12,rep,name=scripts,proto3" json:"scripts,omitempty"`                               // For SCRIPT_LIST
+	ConfigResult  *ConfigResult      `protobuf:"bytes,13,opt,name=config_result,json=configResult,proto3" json:"config_result,omitempty"` // For CONFIG_READ/CONFIG_WRITE/CONFIG_ROLLBACK
+	HealthResult  *HealthCheckResult `protobuf:"bytes,14,opt,name=health_result,json=healthResult,proto3" json:"health_result,omitempty"` // For HEALTH_CHECK/CONNECTIVITY_TEST
+	TimedOut      bool               `protobuf:"varint,15,opt,name=timed_out,json=timedOut,proto3" json:"timed_out,omitempty"`            // Set by the server when the agent did not answer in time
+	ExitCode      int32              `protobuf:"varint,16,opt,name=exit_code,json=exitCode,proto3" json:"exit_code,omitempty"`            // Exit code of SHELL_EXECUTE (-1 if it did not exit normally)
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *CommandResult) Reset() {
 	*x = CommandResult{}
-	mi := &file_nanolink_proto_msgTypes[30]
+	mi := &file_nanolink_proto_msgTypes[32]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -3294,7 +3857,7 @@ func (x *CommandResult) String() string {
 func (*CommandResult) ProtoMessage() {}
 
 func (x *CommandResult) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[30]
+	mi := &file_nanolink_proto_msgTypes[32]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3307,7 +3870,7 @@ func (x *CommandResult) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CommandResult.ProtoReflect.Descriptor instead.
 func (*CommandResult) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{30}
+	return file_nanolink_proto_rawDescGZIP(), []int{32}
 }
 
 func (x *CommandResult) GetCommandId() string {
@@ -3359,34 +3922,887 @@ func (x *CommandResult) GetContainers() []*ContainerInfo {
 	return nil
 }
 
-type ProcessInfo struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Pid           uint32                 `protobuf:"varint,1,opt,name=pid,proto3" json:"pid,omitempty"`
-	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
-	User          string                 `protobuf:"bytes,3,opt,name=user,proto3" json:"user,omitempty"`
-	CpuPercent    float64                `protobuf:"fixed64,4,opt,name=cpu_percent,json=cpuPercent,proto3" json:"cpu_percent,omitempty"`
-	MemoryBytes   uint64                 `protobuf:"varint,5,opt,name=memory_bytes,json=memoryBytes,proto3" json:"memory_bytes,omitempty"`
-	Status        string                 `protobuf:"bytes,6,opt,name=status,proto3" json:"status,omitempty"`
-	StartTime     uint64                 `protobuf:"varint,7,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+func (x *CommandResult) GetUpdateInfo() *UpdateInfo {
+	if x != nil {
+		return x.UpdateInfo
+	}
+	return nil
 }
 
-func (x *ProcessInfo) Reset() {
-	*x = ProcessInfo{}
-	mi := &file_nanolink_proto_msgTypes[31]
-	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-	ms.StoreMessageInfo(mi)
+func (x *CommandResult) GetLogResult() *LogQueryResult {
+	if x != nil {
+		return x.LogResult
+	}
+	return nil
 }
 
-func (x *ProcessInfo) String() string {
-	return protoimpl.X.MessageStringOf(x)
+func (x *CommandResult) GetPackages() []*PackageInfo {
+	if x != nil {
+		return x.Packages
+	}
+	return nil
 }
 
-func (*ProcessInfo) ProtoMessage() {}
+func (x *CommandResult) GetScripts() []*ScriptInfo {
+	if x != nil {
+		return x.Scripts
+	}
+	return nil
+}
+
+func (x *CommandResult) GetConfigResult() *ConfigResult {
+	if x != nil {
+		return x.ConfigResult
+	}
+	return nil
+}
+
+func (x *CommandResult) GetHealthResult() *HealthCheckResult {
+	if x != nil {
+		return x.HealthResult
+	}
+	return nil
+}
+
+func (x *CommandResult) GetTimedOut() bool {
+	if x != nil {
+		return x.TimedOut
+	}
+	return false
+}
+
+func (x *CommandResult) GetExitCode() int32 {
+	if x != nil {
+		return x.ExitCode
+	}
+	return 0
+}
+
+// LogQueryResult contains log query results with sanitization info
+type LogQueryResult struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Lines          []*LogEntry            `protobuf:"bytes,1,rep,name=lines,proto3" json:"lines,omitempty"`
+	TotalLines     int64                  `protobuf:"varint,2,opt,name=total_lines,json=totalLines,proto3" json:"total_lines,omitempty"`             // Total matching lines (before limit)
+	LogSource      string                 `protobuf:"bytes,3,opt,name=log_source,json=logSource,proto3" json:"log_source,omitempty"`                 // Source: "journald", "syslog", "audit"
+	Sanitized      bool                   `protobuf:"varint,4,opt,name=sanitized,proto3" json:"sanitized,omitempty"`                                 // Whether sensitive data was redacted
+	SanitizedCount int32                  `protobuf:"varint,5,opt,name=sanitized_count,json=sanitizedCount,proto3" json:"sanitized_count,omitempty"` // Number of redacted entries
+	StartTime      string                 `protobuf:"bytes,6,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`                 // Query start time (ISO 8601)
+	EndTime        string                 `protobuf:"bytes,7,opt,name=end_time,json=endTime,proto3" json:"end_time,omitempty"`                       // Query end time (ISO 8601)
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *LogQueryResult) Reset() {
+	*x = LogQueryResult{}
+	mi := &file_nanolink_proto_msgTypes[33]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogQueryResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogQueryResult) ProtoMessage() {}
+
+func (x *LogQueryResult) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[33]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogQueryResult.ProtoReflect.Descriptor instead.
+func (*LogQueryResult) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{33}
+}
+
+func (x *LogQueryResult) GetLines() []*LogEntry {
+	if x != nil {
+		return x.Lines
+	}
+	return nil
+}
+
+func (x *LogQueryResult) GetTotalLines() int64 {
+	if x != nil {
+		return x.TotalLines
+	}
+	return 0
+}
+
+func (x *LogQueryResult) GetLogSource() string {
+	if x != nil {
+		return x.LogSource
+	}
+	return ""
+}
+
+func (x *LogQueryResult) GetSanitized() bool {
+	if x != nil {
+		return x.Sanitized
+	}
+	return false
+}
+
+func (x *LogQueryResult) GetSanitizedCount() int32 {
+	if x != nil {
+		return x.SanitizedCount
+	}
+	return 0
+}
+
+func (x *LogQueryResult) GetStartTime() string {
+	if x != nil {
+		return x.StartTime
+	}
+	return ""
+}
+
+func (x *LogQueryResult) GetEndTime() string {
+	if x != nil {
+		return x.EndTime
+	}
+	return ""
+}
+
+// LogEntry represents a single log line
+type LogEntry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Timestamp     string                 `protobuf:"bytes,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`                                                                         // Log timestamp (ISO 8601)
+	Level         string                 `protobuf:"bytes,2,opt,name=level,proto3" json:"level,omitempty"`                                                                                 // Log level: debug, info, warning, error, critical
+	Source        string                 `protobuf:"bytes,3,opt,name=source,proto3" json:"source,omitempty"`                                                                               // Source unit/service/file
+	Message       string                 `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`                                                                             // Log message (sanitized if needed)
+	Metadata      map[string]string      `protobuf:"bytes,5,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // Additional metadata (PID, hostname, etc.)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogEntry) Reset() {
+	*x = LogEntry{}
+	mi := &file_nanolink_proto_msgTypes[34]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogEntry) ProtoMessage() {}
+
+func (x *LogEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[34]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogEntry.ProtoReflect.Descriptor instead.
+func (*LogEntry) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{34}
+}
+
+func (x *LogEntry) GetTimestamp() string {
+	if x != nil {
+		return x.Timestamp
+	}
+	return ""
+}
+
+func (x *LogEntry) GetLevel() string {
+	if x != nil {
+		return x.Level
+	}
+	return ""
+}
+
+func (x *LogEntry) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *LogEntry) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *LogEntry) GetMetadata() map[string]string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+// PackageInfo contains information about a system package
+type PackageInfo struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Name            string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Version         string                 `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+	Description     string                 `protobuf:"bytes,3,opt,name=description,proto3" json:"description,omitempty"`
+	Architecture    string                 `protobuf:"bytes,4,opt,name=architecture,proto3" json:"architecture,omitempty"`
+	InstalledSize   int64                  `protobuf:"varint,5,opt,name=installed_size,json=installedSize,proto3" json:"installed_size,omitempty"` // Size in bytes
+	InstallDate     string                 `protobuf:"bytes,6,opt,name=install_date,json=installDate,proto3" json:"install_date,omitempty"`        // Install date (ISO 8601)
+	UpdateAvailable bool                   `protobuf:"varint,7,opt,name=update_available,json=updateAvailable,proto3" json:"update_available,omitempty"`
+	NewVersion      string                 `protobuf:"bytes,8,opt,name=new_version,json=newVersion,proto3" json:"new_version,omitempty"`              // New version if update available
+	Repository      string                 `protobuf:"bytes,9,opt,name=repository,proto3" json:"repository,omitempty"`                                // Package repository/source
+	PackageManager  string                 `protobuf:"bytes,10,opt,name=package_manager,json=packageManager,proto3" json:"package_manager,omitempty"` // apt, yum, dnf, pacman, brew, etc.
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *PackageInfo) Reset() {
+	*x = PackageInfo{}
+	mi := &file_nanolink_proto_msgTypes[35]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PackageInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PackageInfo) ProtoMessage() {}
+
+func (x *PackageInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[35]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PackageInfo.ProtoReflect.Descriptor instead.
+func (*PackageInfo) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{35}
+}
+
+func (x *PackageInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *PackageInfo) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *PackageInfo) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *PackageInfo) GetArchitecture() string {
+	if x != nil {
+		return x.Architecture
+	}
+	return ""
+}
+
+func (x *PackageInfo) GetInstalledSize() int64 {
+	if x != nil {
+		return x.InstalledSize
+	}
+	return 0
+}
+
+func (x *PackageInfo) GetInstallDate() string {
+	if x != nil {
+		return x.InstallDate
+	}
+	return ""
+}
+
+func (x *PackageInfo) GetUpdateAvailable() bool {
+	if x != nil {
+		return x.UpdateAvailable
+	}
+	return false
+}
+
+func (x *PackageInfo) GetNewVersion() string {
+	if x != nil {
+		return x.NewVersion
+	}
+	return ""
+}
+
+func (x *PackageInfo) GetRepository() string {
+	if x != nil {
+		return x.Repository
+	}
+	return ""
+}
+
+func (x *PackageInfo) GetPackageManager() string {
+	if x != nil {
+		return x.PackageManager
+	}
+	return ""
+}
+
+// ScriptInfo contains information about a predefined script
+type ScriptInfo struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	Name               string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Description        string                 `protobuf:"bytes,2,opt,name=description,proto3" json:"description,omitempty"`
+	Category           string                 `protobuf:"bytes,3,opt,name=category,proto3" json:"category,omitempty"`                                                // Category: maintenance, monitoring, backup, etc.
+	RequiredArgs       []string               `protobuf:"bytes,4,rep,name=required_args,json=requiredArgs,proto3" json:"required_args,omitempty"`                    // Required argument names
+	RequiredPermission int32                  `protobuf:"varint,5,opt,name=required_permission,json=requiredPermission,proto3" json:"required_permission,omitempty"` // Minimum permission level required
+	Checksum           string                 `protobuf:"bytes,6,opt,name=checksum,proto3" json:"checksum,omitempty"`                                                // SHA256 checksum for verification
+	FileSize           int64                  `protobuf:"varint,7,opt,name=file_size,json=fileSize,proto3" json:"file_size,omitempty"`
+	LastModified       string                 `protobuf:"bytes,8,opt,name=last_modified,json=lastModified,proto3" json:"last_modified,omitempty"`                 // Last modified time (ISO 8601)
+	SignatureVerified  bool                   `protobuf:"varint,9,opt,name=signature_verified,json=signatureVerified,proto3" json:"signature_verified,omitempty"` // Whether signature is verified
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *ScriptInfo) Reset() {
+	*x = ScriptInfo{}
+	mi := &file_nanolink_proto_msgTypes[36]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScriptInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScriptInfo) ProtoMessage() {}
+
+func (x *ScriptInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[36]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScriptInfo.ProtoReflect.Descriptor instead.
+func (*ScriptInfo) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{36}
+}
+
+func (x *ScriptInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ScriptInfo) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *ScriptInfo) GetCategory() string {
+	if x != nil {
+		return x.Category
+	}
+	return ""
+}
+
+func (x *ScriptInfo) GetRequiredArgs() []string {
+	if x != nil {
+		return x.RequiredArgs
+	}
+	return nil
+}
+
+func (x *ScriptInfo) GetRequiredPermission() int32 {
+	if x != nil {
+		return x.RequiredPermission
+	}
+	return 0
+}
+
+func (x *ScriptInfo) GetChecksum() string {
+	if x != nil {
+		return x.Checksum
+	}
+	return ""
+}
+
+func (x *ScriptInfo) GetFileSize() int64 {
+	if x != nil {
+		return x.FileSize
+	}
+	return 0
+}
+
+func (x *ScriptInfo) GetLastModified() string {
+	if x != nil {
+		return x.LastModified
+	}
+	return ""
+}
+
+func (x *ScriptInfo) GetSignatureVerified() bool {
+	if x != nil {
+		return x.SignatureVerified
+	}
+	return false
+}
+
+// ConfigResult contains config operation results
+type ConfigResult struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Path            string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`                                              // Config file path
+	Content         string                 `protobuf:"bytes,2,opt,name=content,proto3" json:"content,omitempty"`                                        // Config content (for read)
+	Sanitized       bool                   `protobuf:"varint,3,opt,name=sanitized,proto3" json:"sanitized,omitempty"`                                   // Whether sensitive data was redacted
+	BackupPath      string                 `protobuf:"bytes,4,opt,name=backup_path,json=backupPath,proto3" json:"backup_path,omitempty"`                // Backup file path (for write)
+	Backups         []*ConfigBackup        `protobuf:"bytes,5,rep,name=backups,proto3" json:"backups,omitempty"`                                        // Available backups (for list)
+	Valid           bool                   `protobuf:"varint,6,opt,name=valid,proto3" json:"valid,omitempty"`                                           // Syntax validation result
+	ValidationError string                 `protobuf:"bytes,7,opt,name=validation_error,json=validationError,proto3" json:"validation_error,omitempty"` // Syntax error message if invalid
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *ConfigResult) Reset() {
+	*x = ConfigResult{}
+	mi := &file_nanolink_proto_msgTypes[37]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConfigResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConfigResult) ProtoMessage() {}
+
+func (x *ConfigResult) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[37]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConfigResult.ProtoReflect.Descriptor instead.
+func (*ConfigResult) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{37}
+}
+
+func (x *ConfigResult) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *ConfigResult) GetContent() string {
+	if x != nil {
+		return x.Content
+	}
+	return ""
+}
+
+func (x *ConfigResult) GetSanitized() bool {
+	if x != nil {
+		return x.Sanitized
+	}
+	return false
+}
+
+func (x *ConfigResult) GetBackupPath() string {
+	if x != nil {
+		return x.BackupPath
+	}
+	return ""
+}
+
+func (x *ConfigResult) GetBackups() []*ConfigBackup {
+	if x != nil {
+		return x.Backups
+	}
+	return nil
+}
+
+func (x *ConfigResult) GetValid() bool {
+	if x != nil {
+		return x.Valid
+	}
+	return false
+}
+
+func (x *ConfigResult) GetValidationError() string {
+	if x != nil {
+		return x.ValidationError
+	}
+	return ""
+}
+
+// ConfigBackup represents a config backup
+type ConfigBackup struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	CreatedAt     string                 `protobuf:"bytes,2,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"` // Backup creation time (ISO 8601)
+	Size          int64                  `protobuf:"varint,3,opt,name=size,proto3" json:"size,omitempty"`
+	Checksum      string                 `protobuf:"bytes,4,opt,name=checksum,proto3" json:"checksum,omitempty"` // SHA256 checksum
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConfigBackup) Reset() {
+	*x = ConfigBackup{}
+	mi := &file_nanolink_proto_msgTypes[38]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConfigBackup) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConfigBackup) ProtoMessage() {}
+
+func (x *ConfigBackup) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[38]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConfigBackup.ProtoReflect.Descriptor instead.
+func (*ConfigBackup) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{38}
+}
+
+func (x *ConfigBackup) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *ConfigBackup) GetCreatedAt() string {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return ""
+}
+
+func (x *ConfigBackup) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *ConfigBackup) GetChecksum() string {
+	if x != nil {
+		return x.Checksum
+	}
+	return ""
+}
+
+// HealthCheckResult contains health check results
+type HealthCheckResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Healthy       bool                   `protobuf:"varint,1,opt,name=healthy,proto3" json:"healthy,omitempty"`
+	Checks        []*HealthCheckItem     `protobuf:"bytes,2,rep,name=checks,proto3" json:"checks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HealthCheckResult) Reset() {
+	*x = HealthCheckResult{}
+	mi := &file_nanolink_proto_msgTypes[39]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HealthCheckResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HealthCheckResult) ProtoMessage() {}
+
+func (x *HealthCheckResult) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[39]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HealthCheckResult.ProtoReflect.Descriptor instead.
+func (*HealthCheckResult) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{39}
+}
+
+func (x *HealthCheckResult) GetHealthy() bool {
+	if x != nil {
+		return x.Healthy
+	}
+	return false
+}
+
+func (x *HealthCheckResult) GetChecks() []*HealthCheckItem {
+	if x != nil {
+		return x.Checks
+	}
+	return nil
+}
+
+// HealthCheckItem represents a single health check
+type HealthCheckItem struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Passed        bool                   `protobuf:"varint,2,opt,name=passed,proto3" json:"passed,omitempty"`
+	Message       string                 `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	DurationMs    int64                  `protobuf:"varint,4,opt,name=duration_ms,json=durationMs,proto3" json:"duration_ms,omitempty"`                                                  // Check duration
+	Details       map[string]string      `protobuf:"bytes,5,rep,name=details,proto3" json:"details,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // Additional details
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HealthCheckItem) Reset() {
+	*x = HealthCheckItem{}
+	mi := &file_nanolink_proto_msgTypes[40]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HealthCheckItem) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HealthCheckItem) ProtoMessage() {}
+
+func (x *HealthCheckItem) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[40]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HealthCheckItem.ProtoReflect.Descriptor instead.
+func (*HealthCheckItem) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{40}
+}
+
+func (x *HealthCheckItem) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *HealthCheckItem) GetPassed() bool {
+	if x != nil {
+		return x.Passed
+	}
+	return false
+}
+
+func (x *HealthCheckItem) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *HealthCheckItem) GetDurationMs() int64 {
+	if x != nil {
+		return x.DurationMs
+	}
+	return 0
+}
+
+func (x *HealthCheckItem) GetDetails() map[string]string {
+	if x != nil {
+		return x.Details
+	}
+	return nil
+}
+
+// UpdateInfo contains agent version and update information
+type UpdateInfo struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	CurrentVersion  string                 `protobuf:"bytes,1,opt,name=current_version,json=currentVersion,proto3" json:"current_version,omitempty"`     // Current agent version
+	LatestVersion   string                 `protobuf:"bytes,2,opt,name=latest_version,json=latestVersion,proto3" json:"latest_version,omitempty"`        // Latest available version
+	UpdateAvailable bool                   `protobuf:"varint,3,opt,name=update_available,json=updateAvailable,proto3" json:"update_available,omitempty"` // Whether an update is available
+	DownloadUrl     string                 `protobuf:"bytes,4,opt,name=download_url,json=downloadUrl,proto3" json:"download_url,omitempty"`              // URL to download the update
+	Changelog       string                 `protobuf:"bytes,5,opt,name=changelog,proto3" json:"changelog,omitempty"`                                     // Changelog for the update
+	ReleaseDate     string                 `protobuf:"bytes,6,opt,name=release_date,json=releaseDate,proto3" json:"release_date,omitempty"`              // Release date (ISO 8601)
+	DownloadSize    int64                  `protobuf:"varint,7,opt,name=download_size,json=downloadSize,proto3" json:"download_size,omitempty"`          // Download size in bytes
+	Checksum        string                 `protobuf:"bytes,8,opt,name=checksum,proto3" json:"checksum,omitempty"`                                       // SHA256 checksum of the update file
+	MinVersion      string                 `protobuf:"bytes,9,opt,name=min_version,json=minVersion,proto3" json:"min_version,omitempty"`                 // Minimum version required to update
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *UpdateInfo) Reset() {
+	*x = UpdateInfo{}
+	mi := &file_nanolink_proto_msgTypes[41]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateInfo) ProtoMessage() {}
+
+func (x *UpdateInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[41]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateInfo.ProtoReflect.Descriptor instead.
+func (*UpdateInfo) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{41}
+}
+
+func (x *UpdateInfo) GetCurrentVersion() string {
+	if x != nil {
+		return x.CurrentVersion
+	}
+	return ""
+}
+
+func (x *UpdateInfo) GetLatestVersion() string {
+	if x != nil {
+		return x.LatestVersion
+	}
+	return ""
+}
+
+func (x *UpdateInfo) GetUpdateAvailable() bool {
+	if x != nil {
+		return x.UpdateAvailable
+	}
+	return false
+}
+
+func (x *UpdateInfo) GetDownloadUrl() string {
+	if x != nil {
+		return x.DownloadUrl
+	}
+	return ""
+}
+
+func (x *UpdateInfo) GetChangelog() string {
+	if x != nil {
+		return x.Changelog
+	}
+	return ""
+}
+
+func (x *UpdateInfo) GetReleaseDate() string {
+	if x != nil {
+		return x.ReleaseDate
+	}
+	return ""
+}
+
+func (x *UpdateInfo) GetDownloadSize() int64 {
+	if x != nil {
+		return x.DownloadSize
+	}
+	return 0
+}
+
+func (x *UpdateInfo) GetChecksum() string {
+	if x != nil {
+		return x.Checksum
+	}
+	return ""
+}
+
+func (x *UpdateInfo) GetMinVersion() string {
+	if x != nil {
+		return x.MinVersion
+	}
+	return ""
+}
+
+type ProcessInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pid           uint32                 `protobuf:"varint,1,opt,name=pid,proto3" json:"pid,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	User          string                 `protobuf:"bytes,3,opt,name=user,proto3" json:"user,omitempty"`
+	CpuPercent    float64                `protobuf:"fixed64,4,opt,name=cpu_percent,json=cpuPercent,proto3" json:"cpu_percent,omitempty"`
+	MemoryBytes   uint64                 `protobuf:"varint,5,opt,name=memory_bytes,json=memoryBytes,proto3" json:"memory_bytes,omitempty"`
+	Status        string                 `protobuf:"bytes,6,opt,name=status,proto3" json:"status,omitempty"`
+	StartTime     uint64                 `protobuf:"varint,7,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProcessInfo) Reset() {
+	*x = ProcessInfo{}
+	mi := &file_nanolink_proto_msgTypes[42]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProcessInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProcessInfo) ProtoMessage() {}
 
 func (x *ProcessInfo) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[31]
+	mi := &file_nanolink_proto_msgTypes[42]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3399,7 +4815,7 @@ func (x *ProcessInfo) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ProcessInfo.ProtoReflect.Descriptor instead.
 func (*ProcessInfo) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{31}
+	return file_nanolink_proto_rawDescGZIP(), []int{42}
 }
 
 func (x *ProcessInfo) GetPid() uint32 {
@@ -3465,7 +4881,7 @@ type ContainerInfo struct {
 
 func (x *ContainerInfo) Reset() {
 	*x = ContainerInfo{}
-	mi := &file_nanolink_proto_msgTypes[32]
+	mi := &file_nanolink_proto_msgTypes[43]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -3477,7 +4893,7 @@ func (x *ContainerInfo) String() string {
 func (*ContainerInfo) ProtoMessage() {}
 
 func (x *ContainerInfo) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[32]
+	mi := &file_nanolink_proto_msgTypes[43]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3490,7 +4906,7 @@ func (x *ContainerInfo) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ContainerInfo.ProtoReflect.Descriptor instead.
 func (*ContainerInfo) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{32}
+	return file_nanolink_proto_rawDescGZIP(), []int{43}
 }
 
 func (x *ContainerInfo) GetId() string {
@@ -3540,13 +4956,14 @@ type Heartbeat struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Timestamp     uint64                 `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
 	UptimeSeconds uint64                 `protobuf:"varint,2,opt,name=uptime_seconds,json=uptimeSeconds,proto3" json:"uptime_seconds,omitempty"`
+	RttMs         uint32                 `protobuf:"varint,3,opt,name=rtt_ms,json=rttMs,proto3" json:"rtt_ms,omitempty"` // Round trip of the previous heartbeat as measured by the agent
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Heartbeat) Reset() {
 	*x = Heartbeat{}
-	mi := &file_nanolink_proto_msgTypes[33]
+	mi := &file_nanolink_proto_msgTypes[44]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -3558,7 +4975,7 @@ func (x *Heartbeat) String() string {
 func (*Heartbeat) ProtoMessage() {}
 
 func (x *Heartbeat) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[33]
+	mi := &file_nanolink_proto_msgTypes[44]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3571,7 +4988,7 @@ func (x *Heartbeat) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Heartbeat.ProtoReflect.Descriptor instead.
 func (*Heartbeat) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{33}
+	return file_nanolink_proto_rawDescGZIP(), []int{44}
 }
 
 func (x *Heartbeat) GetTimestamp() uint64 {
@@ -3588,16 +5005,24 @@ func (x *Heartbeat) GetUptimeSeconds() uint64 {
 	return 0
 }
 
+func (x *Heartbeat) GetRttMs() uint32 {
+	if x != nil {
+		return x.RttMs
+	}
+	return 0
+}
+
 type HeartbeatAck struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Timestamp     uint64                 `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	RealtimeMode  RealtimeReportMode     `protobuf:"varint,2,opt,name=realtime_mode,json=realtimeMode,proto3,enum=nanolink.RealtimeReportMode" json:"realtime_mode,omitempty"` // Only set for agents that advertised delta_realtime
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *HeartbeatAck) Reset() {
 	*x = HeartbeatAck{}
-	mi := &file_nanolink_proto_msgTypes[34]
+	mi := &file_nanolink_proto_msgTypes[45]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -3608,8 +5033,163 @@ func (x *HeartbeatAck) String() string {
 
 func (*HeartbeatAck) ProtoMessage() {}
 
-func (x *HeartbeatAck) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[34]
+func (x *HeartbeatAck) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[45]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeartbeatAck.ProtoReflect.Descriptor instead.
+func (*HeartbeatAck) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{45}
+}
+
+func (x *HeartbeatAck) GetTimestamp() uint64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *HeartbeatAck) GetRealtimeMode() RealtimeReportMode {
+	if x != nil {
+		return x.RealtimeMode
+	}
+	return RealtimeReportMode_REALTIME_REPORT_FULL
+}
+
+// AgentInit is sent as the first message when agent connects
+// Contains the persistent agent ID for data continuity
+type AgentInit struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`                                                          // Agent's persistent UUID (generated once, stored in config)
+	Hostname      string                 `protobuf:"bytes,2,opt,name=hostname,proto3" json:"hostname,omitempty"`                                                                       // Machine hostname
+	Os            string                 `protobuf:"bytes,3,opt,name=os,proto3" json:"os,omitempty"`                                                                                   // Operating system name
+	Arch          string                 `protobuf:"bytes,4,opt,name=arch,proto3" json:"arch,omitempty"`                                                                               // Architecture (x86_64, aarch64, etc.)
+	AgentVersion  string                 `protobuf:"bytes,5,opt,name=agent_version,json=agentVersion,proto3" json:"agent_version,omitempty"`                                           // Agent software version
+	Capabilities  []string               `protobuf:"bytes,6,rep,name=capabilities,proto3" json:"capabilities,omitempty"`                                                               // Optional protocol features, e.g. "delta_realtime"
+	SessionToken  string                 `protobuf:"bytes,7,opt,name=session_token,json=sessionToken,proto3" json:"session_token,omitempty"`                                           // Session from AuthResponse, restores the prior agent ID
+	Labels        map[string]string      `protobuf:"bytes,8,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // Agent labels such as env=prod; replace the session's labels
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentInit) Reset() {
+	*x = AgentInit{}
+	mi := &file_nanolink_proto_msgTypes[46]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentInit) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentInit) ProtoMessage() {}
+
+func (x *AgentInit) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[46]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentInit.ProtoReflect.Descriptor instead.
+func (*AgentInit) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{46}
+}
+
+func (x *AgentInit) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *AgentInit) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *AgentInit) GetOs() string {
+	if x != nil {
+		return x.Os
+	}
+	return ""
+}
+
+func (x *AgentInit) GetArch() string {
+	if x != nil {
+		return x.Arch
+	}
+	return ""
+}
+
+func (x *AgentInit) GetAgentVersion() string {
+	if x != nil {
+		return x.AgentVersion
+	}
+	return ""
+}
+
+func (x *AgentInit) GetCapabilities() []string {
+	if x != nil {
+		return x.Capabilities
+	}
+	return nil
+}
+
+func (x *AgentInit) GetSessionToken() string {
+	if x != nil {
+		return x.SessionToken
+	}
+	return ""
+}
+
+func (x *AgentInit) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+// GracefulDisconnect is sent by the agent right before it closes the stream
+// on a clean shutdown, so the server can tell it apart from a crash
+type GracefulDisconnect struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Reason        string                 `protobuf:"bytes,1,opt,name=reason,proto3" json:"reason,omitempty"` // Why the agent is going offline (e.g. "shutdown", "upgrade")
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GracefulDisconnect) Reset() {
+	*x = GracefulDisconnect{}
+	mi := &file_nanolink_proto_msgTypes[47]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GracefulDisconnect) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GracefulDisconnect) ProtoMessage() {}
+
+func (x *GracefulDisconnect) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[47]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3620,16 +5200,16 @@ func (x *HeartbeatAck) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use HeartbeatAck.ProtoReflect.Descriptor instead.
-func (*HeartbeatAck) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{34}
+// Deprecated: Use GracefulDisconnect.ProtoReflect.Descriptor instead.
+func (*GracefulDisconnect) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{47}
 }
 
-func (x *HeartbeatAck) GetTimestamp() uint64 {
+func (x *GracefulDisconnect) GetReason() string {
 	if x != nil {
-		return x.Timestamp
+		return x.Reason
 	}
-	return 0
+	return ""
 }
 
 // MetricsStreamRequest is sent by agent in the bidirectional stream
@@ -3643,14 +5223,22 @@ type MetricsStreamRequest struct {
 	//	*MetricsStreamRequest_Realtime
 	//	*MetricsStreamRequest_StaticInfo
 	//	*MetricsStreamRequest_Periodic
-	Request       isMetricsStreamRequest_Request `protobuf_oneof:"request"`
+	//	*MetricsStreamRequest_AgentInit
+	//	*MetricsStreamRequest_GracefulDisconnect
+	//	*MetricsStreamRequest_LogChunk
+	//	*MetricsStreamRequest_CommandOutput
+	Request isMetricsStreamRequest_Request `protobuf_oneof:"request"`
+	// Per-stream sequence number of a metrics message (metrics, realtime,
+	// static_info, periodic). In ack mode the server acks every message with a
+	// non-zero sequence, so the agent can retransmit gaps. 0 = not acked.
+	Sequence      uint64 `protobuf:"varint,9,opt,name=sequence,proto3" json:"sequence,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *MetricsStreamRequest) Reset() {
 	*x = MetricsStreamRequest{}
-	mi := &file_nanolink_proto_msgTypes[35]
+	mi := &file_nanolink_proto_msgTypes[48]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -3662,7 +5250,7 @@ func (x *MetricsStreamRequest) String() string {
 func (*MetricsStreamRequest) ProtoMessage() {}
 
 func (x *MetricsStreamRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[35]
+	mi := &file_nanolink_proto_msgTypes[48]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3675,7 +5263,7 @@ func (x *MetricsStreamRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MetricsStreamRequest.ProtoReflect.Descriptor instead.
 func (*MetricsStreamRequest) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{35}
+	return file_nanolink_proto_rawDescGZIP(), []int{48}
 }
 
 func (x *MetricsStreamRequest) GetRequest() isMetricsStreamRequest_Request {
@@ -3739,6 +5327,49 @@ func (x *MetricsStreamRequest) GetPeriodic() *PeriodicData {
 	return nil
 }
 
+func (x *MetricsStreamRequest) GetAgentInit() *AgentInit {
+	if x != nil {
+		if x, ok := x.Request.(*MetricsStreamRequest_AgentInit); ok {
+			return x.AgentInit
+		}
+	}
+	return nil
+}
+
+func (x *MetricsStreamRequest) GetGracefulDisconnect() *GracefulDisconnect {
+	if x != nil {
+		if x, ok := x.Request.(*MetricsStreamRequest_GracefulDisconnect); ok {
+			return x.GracefulDisconnect
+		}
+	}
+	return nil
+}
+
+func (x *MetricsStreamRequest) GetLogChunk() *LogChunk {
+	if x != nil {
+		if x, ok := x.Request.(*MetricsStreamRequest_LogChunk); ok {
+			return x.LogChunk
+		}
+	}
+	return nil
+}
+
+func (x *MetricsStreamRequest) GetCommandOutput() *CommandOutput {
+	if x != nil {
+		if x, ok := x.Request.(*MetricsStreamRequest_CommandOutput); ok {
+			return x.CommandOutput
+		}
+	}
+	return nil
+}
+
+func (x *MetricsStreamRequest) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
 type isMetricsStreamRequest_Request interface {
 	isMetricsStreamRequest_Request()
 }
@@ -3767,6 +5398,22 @@ type MetricsStreamRequest_Periodic struct {
 	Periodic *PeriodicData `protobuf:"bytes,6,opt,name=periodic,proto3,oneof"` // Periodic data (disk usage, sessions)
 }
 
+type MetricsStreamRequest_AgentInit struct {
+	AgentInit *AgentInit `protobuf:"bytes,7,opt,name=agent_init,json=agentInit,proto3,oneof"` // Agent initialization (MUST be first message)
+}
+
+type MetricsStreamRequest_GracefulDisconnect struct {
+	GracefulDisconnect *GracefulDisconnect `protobuf:"bytes,8,opt,name=graceful_disconnect,json=gracefulDisconnect,proto3,oneof"` // Sent before a clean shutdown
+}
+
+type MetricsStreamRequest_LogChunk struct {
+	LogChunk *LogChunk `protobuf:"bytes,10,opt,name=log_chunk,json=logChunk,proto3,oneof"` // Lines of a log file streamed on request
+}
+
+type MetricsStreamRequest_CommandOutput struct {
+	CommandOutput *CommandOutput `protobuf:"bytes,11,opt,name=command_output,json=commandOutput,proto3,oneof"` // Output of a running command, when streaming was asked for
+}
+
 func (*MetricsStreamRequest_Metrics) isMetricsStreamRequest_Request() {}
 
 func (*MetricsStreamRequest_Heartbeat) isMetricsStreamRequest_Request() {}
@@ -3779,6 +5426,14 @@ func (*MetricsStreamRequest_StaticInfo) isMetricsStreamRequest_Request() {}
 
 func (*MetricsStreamRequest_Periodic) isMetricsStreamRequest_Request() {}
 
+func (*MetricsStreamRequest_AgentInit) isMetricsStreamRequest_Request() {}
+
+func (*MetricsStreamRequest_GracefulDisconnect) isMetricsStreamRequest_Request() {}
+
+func (*MetricsStreamRequest_LogChunk) isMetricsStreamRequest_Request() {}
+
+func (*MetricsStreamRequest_CommandOutput) isMetricsStreamRequest_Request() {}
+
 // MetricsStreamResponse is sent by server in the bidirectional stream
 type MetricsStreamResponse struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
@@ -3788,6 +5443,9 @@ type MetricsStreamResponse struct {
 	//	*MetricsStreamResponse_HeartbeatAck
 	//	*MetricsStreamResponse_ConfigUpdate
 	//	*MetricsStreamResponse_DataRequest
+	//	*MetricsStreamResponse_MetricsAck
+	//	*MetricsStreamResponse_StartLogStream
+	//	*MetricsStreamResponse_StopLogStream
 	Response      isMetricsStreamResponse_Response `protobuf_oneof:"response"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
@@ -3795,7 +5453,7 @@ type MetricsStreamResponse struct {
 
 func (x *MetricsStreamResponse) Reset() {
 	*x = MetricsStreamResponse{}
-	mi := &file_nanolink_proto_msgTypes[36]
+	mi := &file_nanolink_proto_msgTypes[49]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -3807,7 +5465,7 @@ func (x *MetricsStreamResponse) String() string {
 func (*MetricsStreamResponse) ProtoMessage() {}
 
 func (x *MetricsStreamResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[36]
+	mi := &file_nanolink_proto_msgTypes[49]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3820,7 +5478,7 @@ func (x *MetricsStreamResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MetricsStreamResponse.ProtoReflect.Descriptor instead.
 func (*MetricsStreamResponse) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{36}
+	return file_nanolink_proto_rawDescGZIP(), []int{49}
 }
 
 func (x *MetricsStreamResponse) GetResponse() isMetricsStreamResponse_Response {
@@ -3848,64 +5506,374 @@ func (x *MetricsStreamResponse) GetHeartbeatAck() *HeartbeatAck {
 	return nil
 }
 
-func (x *MetricsStreamResponse) GetConfigUpdate() *ServerConfig {
+func (x *MetricsStreamResponse) GetConfigUpdate() *ServerConfig {
+	if x != nil {
+		if x, ok := x.Response.(*MetricsStreamResponse_ConfigUpdate); ok {
+			return x.ConfigUpdate
+		}
+	}
+	return nil
+}
+
+func (x *MetricsStreamResponse) GetDataRequest() *DataRequest {
+	if x != nil {
+		if x, ok := x.Response.(*MetricsStreamResponse_DataRequest); ok {
+			return x.DataRequest
+		}
+	}
+	return nil
+}
+
+func (x *MetricsStreamResponse) GetMetricsAck() *MetricsAck {
+	if x != nil {
+		if x, ok := x.Response.(*MetricsStreamResponse_MetricsAck); ok {
+			return x.MetricsAck
+		}
+	}
+	return nil
+}
+
+func (x *MetricsStreamResponse) GetStartLogStream() *StartLogStream {
+	if x != nil {
+		if x, ok := x.Response.(*MetricsStreamResponse_StartLogStream); ok {
+			return x.StartLogStream
+		}
+	}
+	return nil
+}
+
+func (x *MetricsStreamResponse) GetStopLogStream() *StopLogStream {
+	if x != nil {
+		if x, ok := x.Response.(*MetricsStreamResponse_StopLogStream); ok {
+			return x.StopLogStream
+		}
+	}
+	return nil
+}
+
+type isMetricsStreamResponse_Response interface {
+	isMetricsStreamResponse_Response()
+}
+
+type MetricsStreamResponse_Command struct {
+	Command *Command `protobuf:"bytes,1,opt,name=command,proto3,oneof"` // Command to execute
+}
+
+type MetricsStreamResponse_HeartbeatAck struct {
+	HeartbeatAck *HeartbeatAck `protobuf:"bytes,2,opt,name=heartbeat_ack,json=heartbeatAck,proto3,oneof"` // Heartbeat acknowledgment
+}
+
+type MetricsStreamResponse_ConfigUpdate struct {
+	ConfigUpdate *ServerConfig `protobuf:"bytes,3,opt,name=config_update,json=configUpdate,proto3,oneof"` // Configuration update from server
+}
+
+type MetricsStreamResponse_DataRequest struct {
+	DataRequest *DataRequest `protobuf:"bytes,4,opt,name=data_request,json=dataRequest,proto3,oneof"` // Request for specific data from agent
+}
+
+type MetricsStreamResponse_MetricsAck struct {
+	MetricsAck *MetricsAck `protobuf:"bytes,5,opt,name=metrics_ack,json=metricsAck,proto3,oneof"` // Receipt of a sequenced metrics message (ack mode)
+}
+
+type MetricsStreamResponse_StartLogStream struct {
+	StartLogStream *StartLogStream `protobuf:"bytes,6,opt,name=start_log_stream,json=startLogStream,proto3,oneof"` // Start streaming a log file as LogChunk messages
+}
+
+type MetricsStreamResponse_StopLogStream struct {
+	StopLogStream *StopLogStream `protobuf:"bytes,7,opt,name=stop_log_stream,json=stopLogStream,proto3,oneof"` // Stop a log stream
+}
+
+func (*MetricsStreamResponse_Command) isMetricsStreamResponse_Response() {}
+
+func (*MetricsStreamResponse_HeartbeatAck) isMetricsStreamResponse_Response() {}
+
+func (*MetricsStreamResponse_ConfigUpdate) isMetricsStreamResponse_Response() {}
+
+func (*MetricsStreamResponse_DataRequest) isMetricsStreamResponse_Response() {}
+
+func (*MetricsStreamResponse_MetricsAck) isMetricsStreamResponse_Response() {}
+
+func (*MetricsStreamResponse_StartLogStream) isMetricsStreamResponse_Response() {}
+
+func (*MetricsStreamResponse_StopLogStream) isMetricsStreamResponse_Response() {}
+
+// ========== Log Streaming ==========
+// StartLogStream asks the agent to send a log file's lines as LogChunk
+// messages. Agents only stream files they allow log queries on.
+type StartLogStream struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	StreamId       string                 `protobuf:"bytes,1,opt,name=stream_id,json=streamId,proto3" json:"stream_id,omitempty"`
+	Path           string                 `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	Follow         bool                   `protobuf:"varint,3,opt,name=follow,proto3" json:"follow,omitempty"`                                           // Keep sending lines appended to the file, like tail -f
+	TailLines      uint32                 `protobuf:"varint,4,opt,name=tail_lines,json=tailLines,proto3" json:"tail_lines,omitempty"`                    // Lines from the end of the file sent first (0 = agent default)
+	MaxBytesPerSec uint32                 `protobuf:"varint,5,opt,name=max_bytes_per_sec,json=maxBytesPerSec,proto3" json:"max_bytes_per_sec,omitempty"` // Lines beyond this are dropped and counted (0 = no limit)
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *StartLogStream) Reset() {
+	*x = StartLogStream{}
+	mi := &file_nanolink_proto_msgTypes[50]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StartLogStream) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartLogStream) ProtoMessage() {}
+
+func (x *StartLogStream) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[50]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartLogStream.ProtoReflect.Descriptor instead.
+func (*StartLogStream) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{50}
+}
+
+func (x *StartLogStream) GetStreamId() string {
+	if x != nil {
+		return x.StreamId
+	}
+	return ""
+}
+
+func (x *StartLogStream) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *StartLogStream) GetFollow() bool {
+	if x != nil {
+		return x.Follow
+	}
+	return false
+}
+
+func (x *StartLogStream) GetTailLines() uint32 {
+	if x != nil {
+		return x.TailLines
+	}
+	return 0
+}
+
+func (x *StartLogStream) GetMaxBytesPerSec() uint32 {
+	if x != nil {
+		return x.MaxBytesPerSec
+	}
+	return 0
+}
+
+// StopLogStream ends a log stream; the agent sends no further chunks for it
+type StopLogStream struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	StreamId      string                 `protobuf:"bytes,1,opt,name=stream_id,json=streamId,proto3" json:"stream_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StopLogStream) Reset() {
+	*x = StopLogStream{}
+	mi := &file_nanolink_proto_msgTypes[51]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StopLogStream) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StopLogStream) ProtoMessage() {}
+
+func (x *StopLogStream) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[51]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StopLogStream.ProtoReflect.Descriptor instead.
+func (*StopLogStream) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{51}
+}
+
+func (x *StopLogStream) GetStreamId() string {
+	if x != nil {
+		return x.StreamId
+	}
+	return ""
+}
+
+// LogChunk carries lines of a streamed log file
+type LogChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	StreamId      string                 `protobuf:"bytes,1,opt,name=stream_id,json=streamId,proto3" json:"stream_id,omitempty"`
+	Lines         []string               `protobuf:"bytes,2,rep,name=lines,proto3" json:"lines,omitempty"`
+	DroppedLines  uint32                 `protobuf:"varint,3,opt,name=dropped_lines,json=droppedLines,proto3" json:"dropped_lines,omitempty"` // Lines left out since the previous chunk, over the throughput limit
+	Eof           bool                   `protobuf:"varint,4,opt,name=eof,proto3" json:"eof,omitempty"`                                       // Last chunk: the file was read without follow, or the stream failed
+	Error         string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`                                    // Why the stream failed
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogChunk) Reset() {
+	*x = LogChunk{}
+	mi := &file_nanolink_proto_msgTypes[52]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogChunk) ProtoMessage() {}
+
+func (x *LogChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[52]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogChunk.ProtoReflect.Descriptor instead.
+func (*LogChunk) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{52}
+}
+
+func (x *LogChunk) GetStreamId() string {
+	if x != nil {
+		return x.StreamId
+	}
+	return ""
+}
+
+func (x *LogChunk) GetLines() []string {
+	if x != nil {
+		return x.Lines
+	}
+	return nil
+}
+
+func (x *LogChunk) GetDroppedLines() uint32 {
 	if x != nil {
-		if x, ok := x.Response.(*MetricsStreamResponse_ConfigUpdate); ok {
-			return x.ConfigUpdate
-		}
+		return x.DroppedLines
 	}
-	return nil
+	return 0
 }
 
-func (x *MetricsStreamResponse) GetDataRequest() *DataRequest {
+func (x *LogChunk) GetEof() bool {
 	if x != nil {
-		if x, ok := x.Response.(*MetricsStreamResponse_DataRequest); ok {
-			return x.DataRequest
-		}
+		return x.Eof
 	}
-	return nil
+	return false
 }
 
-type isMetricsStreamResponse_Response interface {
-	isMetricsStreamResponse_Response()
+func (x *LogChunk) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
 }
 
-type MetricsStreamResponse_Command struct {
-	Command *Command `protobuf:"bytes,1,opt,name=command,proto3,oneof"` // Command to execute
+// CommandOutput carries output of a command as it runs. The server asks for
+// it by setting the "stream_output" param to "true"; the CommandResult that
+// follows ends the command and still holds the complete output.
+type CommandOutput struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	Stream        string                 `protobuf:"bytes,2,opt,name=stream,proto3" json:"stream,omitempty"` // "stdout" or "stderr"
+	Data          string                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
-type MetricsStreamResponse_HeartbeatAck struct {
-	HeartbeatAck *HeartbeatAck `protobuf:"bytes,2,opt,name=heartbeat_ack,json=heartbeatAck,proto3,oneof"` // Heartbeat acknowledgment
+func (x *CommandOutput) Reset() {
+	*x = CommandOutput{}
+	mi := &file_nanolink_proto_msgTypes[53]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
-type MetricsStreamResponse_ConfigUpdate struct {
-	ConfigUpdate *ServerConfig `protobuf:"bytes,3,opt,name=config_update,json=configUpdate,proto3,oneof"` // Configuration update from server
+func (x *CommandOutput) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
 
-type MetricsStreamResponse_DataRequest struct {
-	DataRequest *DataRequest `protobuf:"bytes,4,opt,name=data_request,json=dataRequest,proto3,oneof"` // Request for specific data from agent
+func (*CommandOutput) ProtoMessage() {}
+
+func (x *CommandOutput) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[53]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-func (*MetricsStreamResponse_Command) isMetricsStreamResponse_Response() {}
+// Deprecated: Use CommandOutput.ProtoReflect.Descriptor instead.
+func (*CommandOutput) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{53}
+}
 
-func (*MetricsStreamResponse_HeartbeatAck) isMetricsStreamResponse_Response() {}
+func (x *CommandOutput) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
 
-func (*MetricsStreamResponse_ConfigUpdate) isMetricsStreamResponse_Response() {}
+func (x *CommandOutput) GetStream() string {
+	if x != nil {
+		return x.Stream
+	}
+	return ""
+}
 
-func (*MetricsStreamResponse_DataRequest) isMetricsStreamResponse_Response() {}
+func (x *CommandOutput) GetData() string {
+	if x != nil {
+		return x.Data
+	}
+	return ""
+}
 
 // MetricsAck acknowledges receipt of metrics
 type MetricsAck struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
 	Timestamp     uint64                 `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Sequence      uint64                 `protobuf:"varint,3,opt,name=sequence,proto3" json:"sequence,omitempty"` // Sequence of the acknowledged stream message
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *MetricsAck) Reset() {
 	*x = MetricsAck{}
-	mi := &file_nanolink_proto_msgTypes[37]
+	mi := &file_nanolink_proto_msgTypes[54]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -3917,7 +5885,7 @@ func (x *MetricsAck) String() string {
 func (*MetricsAck) ProtoMessage() {}
 
 func (x *MetricsAck) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[37]
+	mi := &file_nanolink_proto_msgTypes[54]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3930,7 +5898,7 @@ func (x *MetricsAck) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MetricsAck.ProtoReflect.Descriptor instead.
 func (*MetricsAck) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{37}
+	return file_nanolink_proto_rawDescGZIP(), []int{54}
 }
 
 func (x *MetricsAck) GetSuccess() bool {
@@ -3947,19 +5915,27 @@ func (x *MetricsAck) GetTimestamp() uint64 {
 	return 0
 }
 
+func (x *MetricsAck) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
 // HeartbeatRequest for unary heartbeat RPC
 type HeartbeatRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
 	Timestamp     uint64                 `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
 	UptimeSeconds uint64                 `protobuf:"varint,3,opt,name=uptime_seconds,json=uptimeSeconds,proto3" json:"uptime_seconds,omitempty"`
+	RttMs         uint32                 `protobuf:"varint,4,opt,name=rtt_ms,json=rttMs,proto3" json:"rtt_ms,omitempty"` // Round trip of the previous heartbeat as measured by the agent
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *HeartbeatRequest) Reset() {
 	*x = HeartbeatRequest{}
-	mi := &file_nanolink_proto_msgTypes[38]
+	mi := &file_nanolink_proto_msgTypes[55]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -3971,7 +5947,7 @@ func (x *HeartbeatRequest) String() string {
 func (*HeartbeatRequest) ProtoMessage() {}
 
 func (x *HeartbeatRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[38]
+	mi := &file_nanolink_proto_msgTypes[55]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -3984,7 +5960,7 @@ func (x *HeartbeatRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use HeartbeatRequest.ProtoReflect.Descriptor instead.
 func (*HeartbeatRequest) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{38}
+	return file_nanolink_proto_rawDescGZIP(), []int{55}
 }
 
 func (x *HeartbeatRequest) GetAgentId() string {
@@ -4008,6 +5984,13 @@ func (x *HeartbeatRequest) GetUptimeSeconds() uint64 {
 	return 0
 }
 
+func (x *HeartbeatRequest) GetRttMs() uint32 {
+	if x != nil {
+		return x.RttMs
+	}
+	return 0
+}
+
 // HeartbeatResponse for unary heartbeat RPC
 type HeartbeatResponse struct {
 	state           protoimpl.MessageState `protogen:"open.v1"`
@@ -4019,7 +6002,7 @@ type HeartbeatResponse struct {
 
 func (x *HeartbeatResponse) Reset() {
 	*x = HeartbeatResponse{}
-	mi := &file_nanolink_proto_msgTypes[39]
+	mi := &file_nanolink_proto_msgTypes[56]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -4031,7 +6014,7 @@ func (x *HeartbeatResponse) String() string {
 func (*HeartbeatResponse) ProtoMessage() {}
 
 func (x *HeartbeatResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[39]
+	mi := &file_nanolink_proto_msgTypes[56]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -4044,7 +6027,7 @@ func (x *HeartbeatResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use HeartbeatResponse.ProtoReflect.Descriptor instead.
 func (*HeartbeatResponse) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{39}
+	return file_nanolink_proto_rawDescGZIP(), []int{56}
 }
 
 func (x *HeartbeatResponse) GetServerTimestamp() uint64 {
@@ -4072,7 +6055,7 @@ type MetricsSyncRequest struct {
 
 func (x *MetricsSyncRequest) Reset() {
 	*x = MetricsSyncRequest{}
-	mi := &file_nanolink_proto_msgTypes[40]
+	mi := &file_nanolink_proto_msgTypes[57]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -4084,7 +6067,7 @@ func (x *MetricsSyncRequest) String() string {
 func (*MetricsSyncRequest) ProtoMessage() {}
 
 func (x *MetricsSyncRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[40]
+	mi := &file_nanolink_proto_msgTypes[57]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -4097,7 +6080,7 @@ func (x *MetricsSyncRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MetricsSyncRequest.ProtoReflect.Descriptor instead.
 func (*MetricsSyncRequest) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{40}
+	return file_nanolink_proto_rawDescGZIP(), []int{57}
 }
 
 func (x *MetricsSyncRequest) GetAgentId() string {
@@ -4118,15 +6101,18 @@ func (x *MetricsSyncRequest) GetLastSyncTimestamp() uint64 {
 type MetricsSyncResponse struct {
 	state           protoimpl.MessageState `protogen:"open.v1"`
 	Success         bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
-	Metrics         []*Metrics             `protobuf:"bytes,2,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	Metrics         []*Metrics             `protobuf:"bytes,2,rep,name=metrics,proto3" json:"metrics,omitempty"` // Metrics the server recorded after last_sync_timestamp
 	ServerTimestamp uint64                 `protobuf:"varint,3,opt,name=server_timestamp,json=serverTimestamp,proto3" json:"server_timestamp,omitempty"`
-	unknownFields   protoimpl.UnknownFields
-	sizeCache       protoimpl.SizeCache
+	// The server's buffer does not reach back to last_sync_timestamp, so it may
+	// have missed metrics; the agent should resend its own buffer
+	ResendBuffer  bool `protobuf:"varint,4,opt,name=resend_buffer,json=resendBuffer,proto3" json:"resend_buffer,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *MetricsSyncResponse) Reset() {
 	*x = MetricsSyncResponse{}
-	mi := &file_nanolink_proto_msgTypes[41]
+	mi := &file_nanolink_proto_msgTypes[58]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -4138,7 +6124,7 @@ func (x *MetricsSyncResponse) String() string {
 func (*MetricsSyncResponse) ProtoMessage() {}
 
 func (x *MetricsSyncResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[41]
+	mi := &file_nanolink_proto_msgTypes[58]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -4151,7 +6137,7 @@ func (x *MetricsSyncResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MetricsSyncResponse.ProtoReflect.Descriptor instead.
 func (*MetricsSyncResponse) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{41}
+	return file_nanolink_proto_rawDescGZIP(), []int{58}
 }
 
 func (x *MetricsSyncResponse) GetSuccess() bool {
@@ -4175,6 +6161,13 @@ func (x *MetricsSyncResponse) GetServerTimestamp() uint64 {
 	return 0
 }
 
+func (x *MetricsSyncResponse) GetResendBuffer() bool {
+	if x != nil {
+		return x.ResendBuffer
+	}
+	return false
+}
+
 // AgentInfoRequest requests agent information
 type AgentInfoRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -4185,7 +6178,7 @@ type AgentInfoRequest struct {
 
 func (x *AgentInfoRequest) Reset() {
 	*x = AgentInfoRequest{}
-	mi := &file_nanolink_proto_msgTypes[42]
+	mi := &file_nanolink_proto_msgTypes[59]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -4197,7 +6190,7 @@ func (x *AgentInfoRequest) String() string {
 func (*AgentInfoRequest) ProtoMessage() {}
 
 func (x *AgentInfoRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[42]
+	mi := &file_nanolink_proto_msgTypes[59]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -4210,7 +6203,7 @@ func (x *AgentInfoRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use AgentInfoRequest.ProtoReflect.Descriptor instead.
 func (*AgentInfoRequest) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{42}
+	return file_nanolink_proto_rawDescGZIP(), []int{59}
 }
 
 func (x *AgentInfoRequest) GetAgentId() string {
@@ -4232,13 +6225,16 @@ type AgentInfoResponse struct {
 	ConnectedAt      uint64                 `protobuf:"varint,7,opt,name=connected_at,json=connectedAt,proto3" json:"connected_at,omitempty"`
 	LastMetricsAt    uint64                 `protobuf:"varint,8,opt,name=last_metrics_at,json=lastMetricsAt,proto3" json:"last_metrics_at,omitempty"`
 	ConnectedServers []string               `protobuf:"bytes,9,rep,name=connected_servers,json=connectedServers,proto3" json:"connected_servers,omitempty"`
+	Labels           map[string]string      `protobuf:"bytes,10,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	CommandPolicy    *CommandPolicy         `protobuf:"bytes,11,opt,name=command_policy,json=commandPolicy,proto3" json:"command_policy,omitempty"` // Unset when the agent did not report one
+	Outdated         bool                   `protobuf:"varint,12,opt,name=outdated,proto3" json:"outdated,omitempty"`                               // Version is below the server's supported agent version
 	unknownFields    protoimpl.UnknownFields
 	sizeCache        protoimpl.SizeCache
 }
 
 func (x *AgentInfoResponse) Reset() {
 	*x = AgentInfoResponse{}
-	mi := &file_nanolink_proto_msgTypes[43]
+	mi := &file_nanolink_proto_msgTypes[60]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -4250,7 +6246,7 @@ func (x *AgentInfoResponse) String() string {
 func (*AgentInfoResponse) ProtoMessage() {}
 
 func (x *AgentInfoResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[43]
+	mi := &file_nanolink_proto_msgTypes[60]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -4263,7 +6259,7 @@ func (x *AgentInfoResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use AgentInfoResponse.ProtoReflect.Descriptor instead.
 func (*AgentInfoResponse) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{43}
+	return file_nanolink_proto_rawDescGZIP(), []int{60}
 }
 
 func (x *AgentInfoResponse) GetAgentId() string {
@@ -4329,6 +6325,27 @@ func (x *AgentInfoResponse) GetConnectedServers() []string {
 	return nil
 }
 
+func (x *AgentInfoResponse) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *AgentInfoResponse) GetCommandPolicy() *CommandPolicy {
+	if x != nil {
+		return x.CommandPolicy
+	}
+	return nil
+}
+
+func (x *AgentInfoResponse) GetOutdated() bool {
+	if x != nil {
+		return x.Outdated
+	}
+	return false
+}
+
 // ServerConfig allows server to push configuration updates
 type ServerConfig struct {
 	state                 protoimpl.MessageState `protogen:"open.v1"`
@@ -4342,7 +6359,7 @@ type ServerConfig struct {
 
 func (x *ServerConfig) Reset() {
 	*x = ServerConfig{}
-	mi := &file_nanolink_proto_msgTypes[44]
+	mi := &file_nanolink_proto_msgTypes[61]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -4354,7 +6371,7 @@ func (x *ServerConfig) String() string {
 func (*ServerConfig) ProtoMessage() {}
 
 func (x *ServerConfig) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[44]
+	mi := &file_nanolink_proto_msgTypes[61]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -4367,7 +6384,7 @@ func (x *ServerConfig) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ServerConfig.ProtoReflect.Descriptor instead.
 func (*ServerConfig) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{44}
+	return file_nanolink_proto_rawDescGZIP(), []int{61}
 }
 
 func (x *ServerConfig) GetMetricsIntervalMs() uint64 {
@@ -4408,7 +6425,7 @@ type WatchAgentsRequest struct {
 
 func (x *WatchAgentsRequest) Reset() {
 	*x = WatchAgentsRequest{}
-	mi := &file_nanolink_proto_msgTypes[45]
+	mi := &file_nanolink_proto_msgTypes[62]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -4420,7 +6437,7 @@ func (x *WatchAgentsRequest) String() string {
 func (*WatchAgentsRequest) ProtoMessage() {}
 
 func (x *WatchAgentsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[45]
+	mi := &file_nanolink_proto_msgTypes[62]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -4433,7 +6450,7 @@ func (x *WatchAgentsRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use WatchAgentsRequest.ProtoReflect.Descriptor instead.
 func (*WatchAgentsRequest) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{45}
+	return file_nanolink_proto_rawDescGZIP(), []int{62}
 }
 
 func (x *WatchAgentsRequest) GetIncludeInitial() bool {
@@ -4455,7 +6472,7 @@ type AgentEvent struct {
 
 func (x *AgentEvent) Reset() {
 	*x = AgentEvent{}
-	mi := &file_nanolink_proto_msgTypes[46]
+	mi := &file_nanolink_proto_msgTypes[63]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -4467,7 +6484,7 @@ func (x *AgentEvent) String() string {
 func (*AgentEvent) ProtoMessage() {}
 
 func (x *AgentEvent) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[46]
+	mi := &file_nanolink_proto_msgTypes[63]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -4480,7 +6497,7 @@ func (x *AgentEvent) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use AgentEvent.ProtoReflect.Descriptor instead.
 func (*AgentEvent) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{46}
+	return file_nanolink_proto_rawDescGZIP(), []int{63}
 }
 
 func (x *AgentEvent) GetEventType() AgentEvent_EventType {
@@ -4515,7 +6532,7 @@ type WatchMetricsRequest struct {
 
 func (x *WatchMetricsRequest) Reset() {
 	*x = WatchMetricsRequest{}
-	mi := &file_nanolink_proto_msgTypes[47]
+	mi := &file_nanolink_proto_msgTypes[64]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -4527,7 +6544,7 @@ func (x *WatchMetricsRequest) String() string {
 func (*WatchMetricsRequest) ProtoMessage() {}
 
 func (x *WatchMetricsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[47]
+	mi := &file_nanolink_proto_msgTypes[64]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -4540,7 +6557,7 @@ func (x *WatchMetricsRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use WatchMetricsRequest.ProtoReflect.Descriptor instead.
 func (*WatchMetricsRequest) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{47}
+	return file_nanolink_proto_rawDescGZIP(), []int{64}
 }
 
 func (x *WatchMetricsRequest) GetAgentIds() []string {
@@ -4567,7 +6584,7 @@ type GetAgentsRequest struct {
 
 func (x *GetAgentsRequest) Reset() {
 	*x = GetAgentsRequest{}
-	mi := &file_nanolink_proto_msgTypes[48]
+	mi := &file_nanolink_proto_msgTypes[65]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -4579,7 +6596,7 @@ func (x *GetAgentsRequest) String() string {
 func (*GetAgentsRequest) ProtoMessage() {}
 
 func (x *GetAgentsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[48]
+	mi := &file_nanolink_proto_msgTypes[65]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -4592,7 +6609,7 @@ func (x *GetAgentsRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetAgentsRequest.ProtoReflect.Descriptor instead.
 func (*GetAgentsRequest) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{48}
+	return file_nanolink_proto_rawDescGZIP(), []int{65}
 }
 
 func (x *GetAgentsRequest) GetIncludeOffline() bool {
@@ -4612,7 +6629,7 @@ type GetAgentsResponse struct {
 
 func (x *GetAgentsResponse) Reset() {
 	*x = GetAgentsResponse{}
-	mi := &file_nanolink_proto_msgTypes[49]
+	mi := &file_nanolink_proto_msgTypes[66]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -4624,7 +6641,7 @@ func (x *GetAgentsResponse) String() string {
 func (*GetAgentsResponse) ProtoMessage() {}
 
 func (x *GetAgentsResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[49]
+	mi := &file_nanolink_proto_msgTypes[66]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -4637,7 +6654,7 @@ func (x *GetAgentsResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetAgentsResponse.ProtoReflect.Descriptor instead.
 func (*GetAgentsResponse) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{49}
+	return file_nanolink_proto_rawDescGZIP(), []int{66}
 }
 
 func (x *GetAgentsResponse) GetAgents() []*AgentInfoResponse {
@@ -4657,7 +6674,7 @@ type GetAgentMetricsRequest struct {
 
 func (x *GetAgentMetricsRequest) Reset() {
 	*x = GetAgentMetricsRequest{}
-	mi := &file_nanolink_proto_msgTypes[50]
+	mi := &file_nanolink_proto_msgTypes[67]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -4669,7 +6686,7 @@ func (x *GetAgentMetricsRequest) String() string {
 func (*GetAgentMetricsRequest) ProtoMessage() {}
 
 func (x *GetAgentMetricsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[50]
+	mi := &file_nanolink_proto_msgTypes[67]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -4682,7 +6699,7 @@ func (x *GetAgentMetricsRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetAgentMetricsRequest.ProtoReflect.Descriptor instead.
 func (*GetAgentMetricsRequest) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{50}
+	return file_nanolink_proto_rawDescGZIP(), []int{67}
 }
 
 func (x *GetAgentMetricsRequest) GetAgentId() string {
@@ -4698,13 +6715,14 @@ type DashboardCommandRequest struct {
 	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
 	Command       *Command               `protobuf:"bytes,2,opt,name=command,proto3" json:"command,omitempty"`
 	DashboardUser string                 `protobuf:"bytes,3,opt,name=dashboard_user,json=dashboardUser,proto3" json:"dashboard_user,omitempty"` // For audit logging
+	TimeoutMs     uint32                 `protobuf:"varint,4,opt,name=timeout_ms,json=timeoutMs,proto3" json:"timeout_ms,omitempty"`            // How long to wait for the agent's result (default: server setting)
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *DashboardCommandRequest) Reset() {
 	*x = DashboardCommandRequest{}
-	mi := &file_nanolink_proto_msgTypes[51]
+	mi := &file_nanolink_proto_msgTypes[68]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -4716,7 +6734,7 @@ func (x *DashboardCommandRequest) String() string {
 func (*DashboardCommandRequest) ProtoMessage() {}
 
 func (x *DashboardCommandRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_nanolink_proto_msgTypes[51]
+	mi := &file_nanolink_proto_msgTypes[68]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -4729,7 +6747,7 @@ func (x *DashboardCommandRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DashboardCommandRequest.ProtoReflect.Descriptor instead.
 func (*DashboardCommandRequest) Descriptor() ([]byte, []int) {
-	return file_nanolink_proto_rawDescGZIP(), []int{51}
+	return file_nanolink_proto_rawDescGZIP(), []int{68}
 }
 
 func (x *DashboardCommandRequest) GetAgentId() string {
@@ -4753,6 +6771,144 @@ func (x *DashboardCommandRequest) GetDashboardUser() string {
 	return ""
 }
 
+func (x *DashboardCommandRequest) GetTimeoutMs() uint32 {
+	if x != nil {
+		return x.TimeoutMs
+	}
+	return 0
+}
+
+// WatchServerEventsRequest to start watching server events
+type WatchServerEventsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Recent        uint32                 `protobuf:"varint,1,opt,name=recent,proto3" json:"recent,omitempty"` // Replay up to this many recent events first
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchServerEventsRequest) Reset() {
+	*x = WatchServerEventsRequest{}
+	mi := &file_nanolink_proto_msgTypes[69]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchServerEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchServerEventsRequest) ProtoMessage() {}
+
+func (x *WatchServerEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[69]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchServerEventsRequest.ProtoReflect.Descriptor instead.
+func (*WatchServerEventsRequest) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{69}
+}
+
+func (x *WatchServerEventsRequest) GetRecent() uint32 {
+	if x != nil {
+		return x.Recent
+	}
+	return 0
+}
+
+// ServerEvent is a server-level event such as degraded mode or a mass
+// agent disconnect
+type ServerEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Kind          string                 `protobuf:"bytes,2,opt,name=kind,proto3" json:"kind,omitempty"`         // server_started, degraded_entered, mass_disconnect, ...
+	Severity      string                 `protobuf:"bytes,3,opt,name=severity,proto3" json:"severity,omitempty"` // info, warning or critical
+	Message       string                 `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+	Attributes    map[string]string      `protobuf:"bytes,5,rep,name=attributes,proto3" json:"attributes,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Timestamp     uint64                 `protobuf:"varint,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"` // Unix milliseconds
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServerEvent) Reset() {
+	*x = ServerEvent{}
+	mi := &file_nanolink_proto_msgTypes[70]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServerEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServerEvent) ProtoMessage() {}
+
+func (x *ServerEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_nanolink_proto_msgTypes[70]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServerEvent.ProtoReflect.Descriptor instead.
+func (*ServerEvent) Descriptor() ([]byte, []int) {
+	return file_nanolink_proto_rawDescGZIP(), []int{70}
+}
+
+func (x *ServerEvent) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *ServerEvent) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *ServerEvent) GetSeverity() string {
+	if x != nil {
+		return x.Severity
+	}
+	return ""
+}
+
+func (x *ServerEvent) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *ServerEvent) GetAttributes() map[string]string {
+	if x != nil {
+		return x.Attributes
+	}
+	return nil
+}
+
+func (x *ServerEvent) GetTimestamp() uint64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
 var File_nanolink_proto protoreflect.FileDescriptor
 
 const file_nanolink_proto_rawDesc = "" +
@@ -4771,20 +6927,40 @@ const file_nanolink_proto_rawDesc = "" +
 	"\x0ecommand_result\x18\x1f \x01(\v2\x17.nanolink.CommandResultH\x00R\rcommandResult\x123\n" +
 	"\theartbeat\x18( \x01(\v2\x13.nanolink.HeartbeatH\x00R\theartbeat\x12=\n" +
 	"\rheartbeat_ack\x18) \x01(\v2\x16.nanolink.HeartbeatAckH\x00R\fheartbeatAckB\t\n" +
-	"\apayload\"\x88\x01\n" +
+	"\apayload\"\x93\x03\n" +
 	"\vAuthRequest\x12\x14\n" +
 	"\x05token\x18\x01 \x01(\tR\x05token\x12\x1a\n" +
 	"\bhostname\x18\x02 \x01(\tR\bhostname\x12#\n" +
 	"\ragent_version\x18\x03 \x01(\tR\fagentVersion\x12\x0e\n" +
 	"\x02os\x18\x04 \x01(\tR\x02os\x12\x12\n" +
-	"\x04arch\x18\x05 \x01(\tR\x04arch\"x\n" +
+	"\x04arch\x18\x05 \x01(\tR\x04arch\x12.\n" +
+	"\x13request_metrics_ack\x18\x06 \x01(\bR\x11requestMetricsAck\x12#\n" +
+	"\rsession_token\x18\a \x01(\tR\fsessionToken\x129\n" +
+	"\x06labels\x18\b \x03(\v2!.nanolink.AuthRequest.LabelsEntryR\x06labels\x12>\n" +
+	"\x0ecommand_policy\x18\t \x01(\v2\x17.nanolink.CommandPolicyR\rcommandPolicy\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x86\x01\n" +
+	"\rCommandPolicy\x12#\n" +
+	"\rshell_enabled\x18\x01 \x01(\bR\fshellEnabled\x12'\n" +
+	"\x0fshell_whitelist\x18\x02 \x03(\tR\x0eshellWhitelist\x12'\n" +
+	"\x0fshell_blacklist\x18\x03 \x03(\tR\x0eshellBlacklist\"\x8f\x03\n" +
 	"\fAuthResponse\x12\x18\n" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12)\n" +
 	"\x10permission_level\x18\x02 \x01(\x05R\x0fpermissionLevel\x12#\n" +
-	"\rerror_message\x18\x03 \x01(\tR\ferrorMessage\"c\n" +
+	"\rerror_message\x18\x03 \x01(\tR\ferrorMessage\x12\x1f\n" +
+	"\vmetrics_ack\x18\x04 \x01(\bR\n" +
+	"metricsAck\x12#\n" +
+	"\rsession_token\x18\x05 \x01(\tR\fsessionToken\x12,\n" +
+	"\x12session_expires_at\x18\x06 \x01(\x04R\x10sessionExpiresAt\x12\x19\n" +
+	"\bagent_id\x18\a \x01(\tR\aagentId\x12B\n" +
+	"\x0efailure_reason\x18\b \x01(\x0e2\x1b.nanolink.AuthFailureReasonR\rfailureReason\x12\x1c\n" +
+	"\tretryable\x18\t \x01(\bR\tretryable\x12$\n" +
+	"\x0eretry_after_ms\x18\n" +
+	" \x01(\rR\fretryAfterMs\"c\n" +
 	"\vDataRequest\x12<\n" +
 	"\frequest_type\x18\x01 \x01(\x0e2\x19.nanolink.DataRequestTypeR\vrequestType\x12\x16\n" +
-	"\x06target\x18\x02 \x01(\tR\x06target\"\xc2\x04\n" +
+	"\x06target\x18\x02 \x01(\tR\x06target\"\x88\x05\n" +
 	"\aMetrics\x12\x1c\n" +
 	"\ttimestamp\x18\x01 \x01(\x04R\ttimestamp\x12&\n" +
 	"\x03cpu\x18\x02 \x01(\v2\x14.nanolink.CpuMetricsR\x03cpu\x12/\n" +
@@ -4801,7 +6977,8 @@ const file_nanolink_proto_rawDesc = "" +
 	"\x04npus\x18\v \x03(\v2\x14.nanolink.NpuMetricsR\x04npus\x128\n" +
 	"\fmetrics_type\x18\f \x01(\x0e2\x15.nanolink.MetricsTypeR\vmetricsType\x12\x1d\n" +
 	"\n" +
-	"is_initial\x18\r \x01(\bR\tisInitial\"\x99\x04\n" +
+	"is_initial\x18\r \x01(\bR\tisInitial\x12D\n" +
+	"\x10collector_status\x18\x0e \x03(\v2\x19.nanolink.CollectorStatusR\x0fcollectorStatus\"\xd6\x04\n" +
 	"\x0fRealtimeMetrics\x12\x1c\n" +
 	"\ttimestamp\x18\x01 \x01(\x04R\ttimestamp\x12*\n" +
 	"\x11cpu_usage_percent\x18\x02 \x01(\x01R\x0fcpuUsagePercent\x12 \n" +
@@ -4819,7 +6996,9 @@ const file_nanolink_proto_rawDesc = "" +
 	" \x03(\v2\x13.nanolink.NetworkIOR\tnetworkIo\x12!\n" +
 	"\fload_average\x18\v \x03(\x01R\vloadAverage\x12/\n" +
 	"\tgpu_usage\x18\f \x03(\v2\x12.nanolink.GpuUsageR\bgpuUsage\x12/\n" +
-	"\tnpu_usage\x18\r \x03(\v2\x12.nanolink.NpuUsageR\bnpuUsage\"\xaa\x01\n" +
+	"\tnpu_usage\x18\r \x03(\v2\x12.nanolink.NpuUsageR\bnpuUsage\x12\x14\n" +
+	"\x05delta\x18\x0e \x01(\bR\x05delta\x12%\n" +
+	"\x0echanged_fields\x18\x0f \x01(\rR\rchangedFields\"\xaa\x01\n" +
 	"\x06DiskIO\x12\x16\n" +
 	"\x06device\x18\x01 \x01(\tR\x06device\x12$\n" +
 	"\x0eread_bytes_sec\x18\x02 \x01(\x04R\freadBytesSec\x12&\n" +
@@ -4919,13 +7098,18 @@ const file_nanolink_proto_rawDesc = "" +
 	"\x04name\x18\x02 \x01(\tR\x04name\x12\x16\n" +
 	"\x06vendor\x18\x03 \x01(\tR\x06vendor\x12!\n" +
 	"\fmemory_total\x18\x04 \x01(\x04R\vmemoryTotal\x12%\n" +
-	"\x0edriver_version\x18\x05 \x01(\tR\rdriverVersion\"\xe5\x01\n" +
+	"\x0edriver_version\x18\x05 \x01(\tR\rdriverVersion\"\xab\x02\n" +
 	"\fPeriodicData\x12\x1c\n" +
 	"\ttimestamp\x18\x01 \x01(\x04R\ttimestamp\x122\n" +
 	"\n" +
 	"disk_usage\x18\x02 \x03(\v2\x13.nanolink.DiskUsageR\tdiskUsage\x12:\n" +
 	"\ruser_sessions\x18\x03 \x03(\v2\x15.nanolink.UserSessionR\fuserSessions\x12G\n" +
-	"\x0fnetwork_updates\x18\x04 \x03(\v2\x1e.nanolink.NetworkAddressUpdateR\x0enetworkUpdates\"\xae\x01\n" +
+	"\x0fnetwork_updates\x18\x04 \x03(\v2\x1e.nanolink.NetworkAddressUpdateR\x0enetworkUpdates\x12D\n" +
+	"\x10collector_status\x18\x05 \x03(\v2\x19.nanolink.CollectorStatusR\x0fcollectorStatus\"m\n" +
+	"\x0fCollectorStatus\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12.\n" +
+	"\x05state\x18\x02 \x01(\x0e2\x18.nanolink.CollectorStateR\x05state\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason\"\xae\x01\n" +
 	"\tDiskUsage\x12\x16\n" +
 	"\x06device\x18\x01 \x01(\tR\x06device\x12\x1f\n" +
 	"\vmount_point\x18\x02 \x01(\tR\n" +
@@ -5071,7 +7255,7 @@ const file_nanolink_proto_rawDesc = "" +
 	"superToken\x1a9\n" +
 	"\vParamsEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x87\x02\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x93\x05\n" +
 	"\rCommandResult\x12\x1d\n" +
 	"\n" +
 	"command_id\x18\x01 \x01(\tR\tcommandId\x12\x18\n" +
@@ -5082,7 +7266,104 @@ const file_nanolink_proto_rawDesc = "" +
 	"\tprocesses\x18\x06 \x03(\v2\x15.nanolink.ProcessInfoR\tprocesses\x127\n" +
 	"\n" +
 	"containers\x18\a \x03(\v2\x17.nanolink.ContainerInfoR\n" +
-	"containers\"\xc2\x01\n" +
+	"containers\x125\n" +
+	"\vupdate_info\x18\b \x01(\v2\x14.nanolink.UpdateInfoR\n" +
+	"updateInfo\x127\n" +
+	"\n" +
+	"log_result\x18\n" +
+	" \x01(\v2\x18.nanolink.LogQueryResultR\tlogResult\x121\n" +
+	"\bpackages\x18\v \x03(\v2\x15.nanolink.PackageInfoR\bpackages\x12.\n" +
+	"\ascripts\x18\f \x03(\v2\x14.nanolink.ScriptInfoR\ascripts\x12;\n" +
+	"\rconfig_result\x18\r \x01(\v2\x16.nanolink.ConfigResultR\fconfigResult\x12@\n" +
+	"\rhealth_result\x18\x0e \x01(\v2\x1b.nanolink.HealthCheckResultR\fhealthResult\x12\x1b\n" +
+	"\ttimed_out\x18\x0f \x01(\bR\btimedOut\x12\x1b\n" +
+	"\texit_code\x18\x10 \x01(\x05R\bexitCode\"\xfb\x01\n" +
+	"\x0eLogQueryResult\x12(\n" +
+	"\x05lines\x18\x01 \x03(\v2\x12.nanolink.LogEntryR\x05lines\x12\x1f\n" +
+	"\vtotal_lines\x18\x02 \x01(\x03R\n" +
+	"totalLines\x12\x1d\n" +
+	"\n" +
+	"log_source\x18\x03 \x01(\tR\tlogSource\x12\x1c\n" +
+	"\tsanitized\x18\x04 \x01(\bR\tsanitized\x12'\n" +
+	"\x0fsanitized_count\x18\x05 \x01(\x05R\x0esanitizedCount\x12\x1d\n" +
+	"\n" +
+	"start_time\x18\x06 \x01(\tR\tstartTime\x12\x19\n" +
+	"\bend_time\x18\a \x01(\tR\aendTime\"\xeb\x01\n" +
+	"\bLogEntry\x12\x1c\n" +
+	"\ttimestamp\x18\x01 \x01(\tR\ttimestamp\x12\x14\n" +
+	"\x05level\x18\x02 \x01(\tR\x05level\x12\x16\n" +
+	"\x06source\x18\x03 \x01(\tR\x06source\x12\x18\n" +
+	"\amessage\x18\x04 \x01(\tR\amessage\x12<\n" +
+	"\bmetadata\x18\x05 \x03(\v2 .nanolink.LogEntry.MetadataEntryR\bmetadata\x1a;\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xe0\x02\n" +
+	"\vPackageInfo\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\tR\aversion\x12 \n" +
+	"\vdescription\x18\x03 \x01(\tR\vdescription\x12\"\n" +
+	"\farchitecture\x18\x04 \x01(\tR\farchitecture\x12%\n" +
+	"\x0einstalled_size\x18\x05 \x01(\x03R\rinstalledSize\x12!\n" +
+	"\finstall_date\x18\x06 \x01(\tR\vinstallDate\x12)\n" +
+	"\x10update_available\x18\a \x01(\bR\x0fupdateAvailable\x12\x1f\n" +
+	"\vnew_version\x18\b \x01(\tR\n" +
+	"newVersion\x12\x1e\n" +
+	"\n" +
+	"repository\x18\t \x01(\tR\n" +
+	"repository\x12'\n" +
+	"\x0fpackage_manager\x18\n" +
+	" \x01(\tR\x0epackageManager\"\xc1\x02\n" +
+	"\n" +
+	"ScriptInfo\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12 \n" +
+	"\vdescription\x18\x02 \x01(\tR\vdescription\x12\x1a\n" +
+	"\bcategory\x18\x03 \x01(\tR\bcategory\x12#\n" +
+	"\rrequired_args\x18\x04 \x03(\tR\frequiredArgs\x12/\n" +
+	"\x13required_permission\x18\x05 \x01(\x05R\x12requiredPermission\x12\x1a\n" +
+	"\bchecksum\x18\x06 \x01(\tR\bchecksum\x12\x1b\n" +
+	"\tfile_size\x18\a \x01(\x03R\bfileSize\x12#\n" +
+	"\rlast_modified\x18\b \x01(\tR\flastModified\x12-\n" +
+	"\x12signature_verified\x18\t \x01(\bR\x11signatureVerified\"\xee\x01\n" +
+	"\fConfigResult\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x18\n" +
+	"\acontent\x18\x02 \x01(\tR\acontent\x12\x1c\n" +
+	"\tsanitized\x18\x03 \x01(\bR\tsanitized\x12\x1f\n" +
+	"\vbackup_path\x18\x04 \x01(\tR\n" +
+	"backupPath\x120\n" +
+	"\abackups\x18\x05 \x03(\v2\x16.nanolink.ConfigBackupR\abackups\x12\x14\n" +
+	"\x05valid\x18\x06 \x01(\bR\x05valid\x12)\n" +
+	"\x10validation_error\x18\a \x01(\tR\x0fvalidationError\"q\n" +
+	"\fConfigBackup\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x1d\n" +
+	"\n" +
+	"created_at\x18\x02 \x01(\tR\tcreatedAt\x12\x12\n" +
+	"\x04size\x18\x03 \x01(\x03R\x04size\x12\x1a\n" +
+	"\bchecksum\x18\x04 \x01(\tR\bchecksum\"`\n" +
+	"\x11HealthCheckResult\x12\x18\n" +
+	"\ahealthy\x18\x01 \x01(\bR\ahealthy\x121\n" +
+	"\x06checks\x18\x02 \x03(\v2\x19.nanolink.HealthCheckItemR\x06checks\"\xf6\x01\n" +
+	"\x0fHealthCheckItem\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x16\n" +
+	"\x06passed\x18\x02 \x01(\bR\x06passed\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\x12\x1f\n" +
+	"\vduration_ms\x18\x04 \x01(\x03R\n" +
+	"durationMs\x12@\n" +
+	"\adetails\x18\x05 \x03(\v2&.nanolink.HealthCheckItem.DetailsEntryR\adetails\x1a:\n" +
+	"\fDetailsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xcd\x02\n" +
+	"\n" +
+	"UpdateInfo\x12'\n" +
+	"\x0fcurrent_version\x18\x01 \x01(\tR\x0ecurrentVersion\x12%\n" +
+	"\x0elatest_version\x18\x02 \x01(\tR\rlatestVersion\x12)\n" +
+	"\x10update_available\x18\x03 \x01(\bR\x0fupdateAvailable\x12!\n" +
+	"\fdownload_url\x18\x04 \x01(\tR\vdownloadUrl\x12\x1c\n" +
+	"\tchangelog\x18\x05 \x01(\tR\tchangelog\x12!\n" +
+	"\frelease_date\x18\x06 \x01(\tR\vreleaseDate\x12#\n" +
+	"\rdownload_size\x18\a \x01(\x03R\fdownloadSize\x12\x1a\n" +
+	"\bchecksum\x18\b \x01(\tR\bchecksum\x12\x1f\n" +
+	"\vmin_version\x18\t \x01(\tR\n" +
+	"minVersion\"\xc2\x01\n" +
 	"\vProcessInfo\x12\x10\n" +
 	"\x03pid\x18\x01 \x01(\rR\x03pid\x12\x12\n" +
 	"\x04name\x18\x02 \x01(\tR\x04name\x12\x12\n" +
@@ -5099,12 +7380,28 @@ const file_nanolink_proto_rawDesc = "" +
 	"\x05image\x18\x03 \x01(\tR\x05image\x12\x16\n" +
 	"\x06status\x18\x04 \x01(\tR\x06status\x12\x14\n" +
 	"\x05state\x18\x05 \x01(\tR\x05state\x12\x18\n" +
-	"\acreated\x18\x06 \x01(\x04R\acreated\"P\n" +
+	"\acreated\x18\x06 \x01(\x04R\acreated\"g\n" +
 	"\tHeartbeat\x12\x1c\n" +
 	"\ttimestamp\x18\x01 \x01(\x04R\ttimestamp\x12%\n" +
-	"\x0euptime_seconds\x18\x02 \x01(\x04R\ruptimeSeconds\",\n" +
+	"\x0euptime_seconds\x18\x02 \x01(\x04R\ruptimeSeconds\x12\x15\n" +
+	"\x06rtt_ms\x18\x03 \x01(\rR\x05rttMs\"o\n" +
 	"\fHeartbeatAck\x12\x1c\n" +
-	"\ttimestamp\x18\x01 \x01(\x04R\ttimestamp\"\xef\x02\n" +
+	"\ttimestamp\x18\x01 \x01(\x04R\ttimestamp\x12A\n" +
+	"\rrealtime_mode\x18\x02 \x01(\x0e2\x1c.nanolink.RealtimeReportModeR\frealtimeMode\"\xc8\x02\n" +
+	"\tAgentInit\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x1a\n" +
+	"\bhostname\x18\x02 \x01(\tR\bhostname\x12\x0e\n" +
+	"\x02os\x18\x03 \x01(\tR\x02os\x12\x12\n" +
+	"\x04arch\x18\x04 \x01(\tR\x04arch\x12#\n" +
+	"\ragent_version\x18\x05 \x01(\tR\fagentVersion\x12\"\n" +
+	"\fcapabilities\x18\x06 \x03(\tR\fcapabilities\x12#\n" +
+	"\rsession_token\x18\a \x01(\tR\fsessionToken\x127\n" +
+	"\x06labels\x18\b \x03(\v2\x1f.nanolink.AgentInit.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\",\n" +
+	"\x12GracefulDisconnect\x12\x16\n" +
+	"\x06reason\x18\x01 \x01(\tR\x06reason\"\x87\x05\n" +
 	"\x14MetricsStreamRequest\x12-\n" +
 	"\ametrics\x18\x01 \x01(\v2\x11.nanolink.MetricsH\x00R\ametrics\x123\n" +
 	"\theartbeat\x18\x02 \x01(\v2\x13.nanolink.HeartbeatH\x00R\theartbeat\x12@\n" +
@@ -5112,35 +7409,69 @@ const file_nanolink_proto_rawDesc = "" +
 	"\brealtime\x18\x04 \x01(\v2\x19.nanolink.RealtimeMetricsH\x00R\brealtime\x127\n" +
 	"\vstatic_info\x18\x05 \x01(\v2\x14.nanolink.StaticInfoH\x00R\n" +
 	"staticInfo\x124\n" +
-	"\bperiodic\x18\x06 \x01(\v2\x16.nanolink.PeriodicDataH\x00R\bperiodicB\t\n" +
-	"\arequest\"\x8c\x02\n" +
+	"\bperiodic\x18\x06 \x01(\v2\x16.nanolink.PeriodicDataH\x00R\bperiodic\x124\n" +
+	"\n" +
+	"agent_init\x18\a \x01(\v2\x13.nanolink.AgentInitH\x00R\tagentInit\x12O\n" +
+	"\x13graceful_disconnect\x18\b \x01(\v2\x1c.nanolink.GracefulDisconnectH\x00R\x12gracefulDisconnect\x121\n" +
+	"\tlog_chunk\x18\n" +
+	" \x01(\v2\x12.nanolink.LogChunkH\x00R\blogChunk\x12@\n" +
+	"\x0ecommand_output\x18\v \x01(\v2\x17.nanolink.CommandOutputH\x00R\rcommandOutput\x12\x1a\n" +
+	"\bsequence\x18\t \x01(\x04R\bsequenceB\t\n" +
+	"\arequest\"\xce\x03\n" +
 	"\x15MetricsStreamResponse\x12-\n" +
 	"\acommand\x18\x01 \x01(\v2\x11.nanolink.CommandH\x00R\acommand\x12=\n" +
 	"\rheartbeat_ack\x18\x02 \x01(\v2\x16.nanolink.HeartbeatAckH\x00R\fheartbeatAck\x12=\n" +
 	"\rconfig_update\x18\x03 \x01(\v2\x16.nanolink.ServerConfigH\x00R\fconfigUpdate\x12:\n" +
-	"\fdata_request\x18\x04 \x01(\v2\x15.nanolink.DataRequestH\x00R\vdataRequestB\n" +
+	"\fdata_request\x18\x04 \x01(\v2\x15.nanolink.DataRequestH\x00R\vdataRequest\x127\n" +
+	"\vmetrics_ack\x18\x05 \x01(\v2\x14.nanolink.MetricsAckH\x00R\n" +
+	"metricsAck\x12D\n" +
+	"\x10start_log_stream\x18\x06 \x01(\v2\x18.nanolink.StartLogStreamH\x00R\x0estartLogStream\x12A\n" +
+	"\x0fstop_log_stream\x18\a \x01(\v2\x17.nanolink.StopLogStreamH\x00R\rstopLogStreamB\n" +
 	"\n" +
-	"\bresponse\"D\n" +
+	"\bresponse\"\xa3\x01\n" +
+	"\x0eStartLogStream\x12\x1b\n" +
+	"\tstream_id\x18\x01 \x01(\tR\bstreamId\x12\x12\n" +
+	"\x04path\x18\x02 \x01(\tR\x04path\x12\x16\n" +
+	"\x06follow\x18\x03 \x01(\bR\x06follow\x12\x1d\n" +
+	"\n" +
+	"tail_lines\x18\x04 \x01(\rR\ttailLines\x12)\n" +
+	"\x11max_bytes_per_sec\x18\x05 \x01(\rR\x0emaxBytesPerSec\",\n" +
+	"\rStopLogStream\x12\x1b\n" +
+	"\tstream_id\x18\x01 \x01(\tR\bstreamId\"\x8a\x01\n" +
+	"\bLogChunk\x12\x1b\n" +
+	"\tstream_id\x18\x01 \x01(\tR\bstreamId\x12\x14\n" +
+	"\x05lines\x18\x02 \x03(\tR\x05lines\x12#\n" +
+	"\rdropped_lines\x18\x03 \x01(\rR\fdroppedLines\x12\x10\n" +
+	"\x03eof\x18\x04 \x01(\bR\x03eof\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\"Z\n" +
+	"\rCommandOutput\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x01 \x01(\tR\tcommandId\x12\x16\n" +
+	"\x06stream\x18\x02 \x01(\tR\x06stream\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\tR\x04data\"`\n" +
 	"\n" +
 	"MetricsAck\x12\x18\n" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x1c\n" +
-	"\ttimestamp\x18\x02 \x01(\x04R\ttimestamp\"r\n" +
+	"\ttimestamp\x18\x02 \x01(\x04R\ttimestamp\x12\x1a\n" +
+	"\bsequence\x18\x03 \x01(\x04R\bsequence\"\x89\x01\n" +
 	"\x10HeartbeatRequest\x12\x19\n" +
 	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x1c\n" +
 	"\ttimestamp\x18\x02 \x01(\x04R\ttimestamp\x12%\n" +
-	"\x0euptime_seconds\x18\x03 \x01(\x04R\ruptimeSeconds\"e\n" +
+	"\x0euptime_seconds\x18\x03 \x01(\x04R\ruptimeSeconds\x12\x15\n" +
+	"\x06rtt_ms\x18\x04 \x01(\rR\x05rttMs\"e\n" +
 	"\x11HeartbeatResponse\x12)\n" +
 	"\x10server_timestamp\x18\x01 \x01(\x04R\x0fserverTimestamp\x12%\n" +
 	"\x0econfig_changed\x18\x02 \x01(\bR\rconfigChanged\"_\n" +
 	"\x12MetricsSyncRequest\x12\x19\n" +
 	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12.\n" +
-	"\x13last_sync_timestamp\x18\x02 \x01(\x04R\x11lastSyncTimestamp\"\x87\x01\n" +
+	"\x13last_sync_timestamp\x18\x02 \x01(\x04R\x11lastSyncTimestamp\"\xac\x01\n" +
 	"\x13MetricsSyncResponse\x12\x18\n" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12+\n" +
 	"\ametrics\x18\x02 \x03(\v2\x11.nanolink.MetricsR\ametrics\x12)\n" +
-	"\x10server_timestamp\x18\x03 \x01(\x04R\x0fserverTimestamp\"-\n" +
+	"\x10server_timestamp\x18\x03 \x01(\x04R\x0fserverTimestamp\x12#\n" +
+	"\rresend_buffer\x18\x04 \x01(\bR\fresendBuffer\"-\n" +
 	"\x10AgentInfoRequest\x12\x19\n" +
-	"\bagent_id\x18\x01 \x01(\tR\aagentId\"\xab\x02\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\"\x83\x04\n" +
 	"\x11AgentInfoResponse\x12\x19\n" +
 	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x1a\n" +
 	"\bhostname\x18\x02 \x01(\tR\bhostname\x12\x0e\n" +
@@ -5150,7 +7481,14 @@ const file_nanolink_proto_rawDesc = "" +
 	"\x10permission_level\x18\x06 \x01(\x05R\x0fpermissionLevel\x12!\n" +
 	"\fconnected_at\x18\a \x01(\x04R\vconnectedAt\x12&\n" +
 	"\x0flast_metrics_at\x18\b \x01(\x04R\rlastMetricsAt\x12+\n" +
-	"\x11connected_servers\x18\t \x03(\tR\x10connectedServers\"\xd9\x01\n" +
+	"\x11connected_servers\x18\t \x03(\tR\x10connectedServers\x12?\n" +
+	"\x06labels\x18\n" +
+	" \x03(\v2'.nanolink.AgentInfoResponse.LabelsEntryR\x06labels\x12>\n" +
+	"\x0ecommand_policy\x18\v \x01(\v2\x17.nanolink.CommandPolicyR\rcommandPolicy\x12\x1a\n" +
+	"\boutdated\x18\f \x01(\bR\boutdated\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xd9\x01\n" +
 	"\fServerConfig\x12.\n" +
 	"\x13metrics_interval_ms\x18\x01 \x01(\x04R\x11metricsIntervalMs\x122\n" +
 	"\x15heartbeat_interval_ms\x18\x02 \x01(\x04R\x13heartbeatIntervalMs\x126\n" +
@@ -5177,11 +7515,34 @@ const file_nanolink_proto_rawDesc = "" +
 	"\x11GetAgentsResponse\x123\n" +
 	"\x06agents\x18\x01 \x03(\v2\x1b.nanolink.AgentInfoResponseR\x06agents\"3\n" +
 	"\x16GetAgentMetricsRequest\x12\x19\n" +
-	"\bagent_id\x18\x01 \x01(\tR\aagentId\"\x88\x01\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\"\xa7\x01\n" +
 	"\x17DashboardCommandRequest\x12\x19\n" +
 	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12+\n" +
 	"\acommand\x18\x02 \x01(\v2\x11.nanolink.CommandR\acommand\x12%\n" +
-	"\x0edashboard_user\x18\x03 \x01(\tR\rdashboardUser*_\n" +
+	"\x0edashboard_user\x18\x03 \x01(\tR\rdashboardUser\x12\x1d\n" +
+	"\n" +
+	"timeout_ms\x18\x04 \x01(\rR\ttimeoutMs\"2\n" +
+	"\x18WatchServerEventsRequest\x12\x16\n" +
+	"\x06recent\x18\x01 \x01(\rR\x06recent\"\x8b\x02\n" +
+	"\vServerEvent\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x12\n" +
+	"\x04kind\x18\x02 \x01(\tR\x04kind\x12\x1a\n" +
+	"\bseverity\x18\x03 \x01(\tR\bseverity\x12\x18\n" +
+	"\amessage\x18\x04 \x01(\tR\amessage\x12E\n" +
+	"\n" +
+	"attributes\x18\x05 \x03(\v2%.nanolink.ServerEvent.AttributesEntryR\n" +
+	"attributes\x12\x1c\n" +
+	"\ttimestamp\x18\x06 \x01(\x04R\ttimestamp\x1a=\n" +
+	"\x0fAttributesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01*\xd4\x01\n" +
+	"\x11AuthFailureReason\x12\x15\n" +
+	"\x11AUTH_FAILURE_NONE\x10\x00\x12\x1e\n" +
+	"\x1aAUTH_FAILURE_INVALID_TOKEN\x10\x01\x12 \n" +
+	"\x1cAUTH_FAILURE_SESSION_EXPIRED\x10\x02\x12\x1d\n" +
+	"\x19AUTH_FAILURE_AGENT_DENIED\x10\x03\x12 \n" +
+	"\x1cAUTH_FAILURE_VERSION_TOO_OLD\x10\x04\x12%\n" +
+	"!AUTH_FAILURE_TOO_MANY_CONNECTIONS\x10\x05*_\n" +
 	"\vMetricsType\x12\x10\n" +
 	"\fMETRICS_FULL\x10\x00\x12\x14\n" +
 	"\x10METRICS_REALTIME\x10\x01\x12\x14\n" +
@@ -5194,7 +7555,26 @@ const file_nanolink_proto_rawDesc = "" +
 	"\x19DATA_REQUEST_NETWORK_INFO\x10\x03\x12\x1e\n" +
 	"\x1aDATA_REQUEST_USER_SESSIONS\x10\x04\x12\x19\n" +
 	"\x15DATA_REQUEST_GPU_INFO\x10\x05\x12\x17\n" +
-	"\x13DATA_REQUEST_HEALTH\x10\x06*\xe2\x02\n" +
+	"\x13DATA_REQUEST_HEALTH\x10\x06*\xe3\x02\n" +
+	"\rRealtimeField\x12\x17\n" +
+	"\x13REALTIME_FIELD_NONE\x10\x00\x12\x1c\n" +
+	"\x18REALTIME_FIELD_CPU_USAGE\x10\x01\x12\x1f\n" +
+	"\x1bREALTIME_FIELD_CPU_PER_CORE\x10\x02\x12\"\n" +
+	"\x1eREALTIME_FIELD_CPU_TEMPERATURE\x10\x04\x12 \n" +
+	"\x1cREALTIME_FIELD_CPU_FREQUENCY\x10\b\x12\x19\n" +
+	"\x15REALTIME_FIELD_MEMORY\x10\x10\x12\x1a\n" +
+	"\x16REALTIME_FIELD_DISK_IO\x10 \x12\x1d\n" +
+	"\x19REALTIME_FIELD_NETWORK_IO\x10@\x12 \n" +
+	"\x1bREALTIME_FIELD_LOAD_AVERAGE\x10\x80\x01\x12\x1d\n" +
+	"\x18REALTIME_FIELD_GPU_USAGE\x10\x80\x02\x12\x1d\n" +
+	"\x18REALTIME_FIELD_NPU_USAGE\x10\x80\x04*I\n" +
+	"\x12RealtimeReportMode\x12\x18\n" +
+	"\x14REALTIME_REPORT_FULL\x10\x00\x12\x19\n" +
+	"\x15REALTIME_REPORT_DELTA\x10\x01*O\n" +
+	"\x0eCollectorState\x12\x10\n" +
+	"\fCOLLECTOR_OK\x10\x00\x12\x13\n" +
+	"\x0fCOLLECTOR_ERROR\x10\x01\x12\x16\n" +
+	"\x12COLLECTOR_DISABLED\x10\x02*\xa2\x06\n" +
 	"\vCommandType\x12\x1c\n" +
 	"\x18COMMAND_TYPE_UNSPECIFIED\x10\x00\x12\x10\n" +
 	"\fPROCESS_LIST\x10\x01\x12\x10\n" +
@@ -5214,7 +7594,31 @@ const file_nanolink_proto_rawDesc = "" +
 	"\x0eDOCKER_RESTART\x10!\x12\x0f\n" +
 	"\vDOCKER_LOGS\x10\"\x12\x11\n" +
 	"\rSYSTEM_REBOOT\x10(\x12\x11\n" +
-	"\rSHELL_EXECUTE\x1022\xf9\x03\n" +
+	"\rSHELL_EXECUTE\x102\x12\x16\n" +
+	"\x12AGENT_CHECK_UPDATE\x10<\x12\x19\n" +
+	"\x15AGENT_DOWNLOAD_UPDATE\x10=\x12\x16\n" +
+	"\x12AGENT_APPLY_UPDATE\x10>\x12\x15\n" +
+	"\x11AGENT_GET_VERSION\x10?\x12\x10\n" +
+	"\fSERVICE_LOGS\x10F\x12\x0f\n" +
+	"\vSYSTEM_LOGS\x10G\x12\x0e\n" +
+	"\n" +
+	"AUDIT_LOGS\x10H\x12\x0e\n" +
+	"\n" +
+	"LOG_STREAM\x10I\x12\x10\n" +
+	"\fPACKAGE_LIST\x10P\x12\x19\n" +
+	"\x15PACKAGE_CHECK_UPDATES\x10Q\x12\x12\n" +
+	"\x0ePACKAGE_UPDATE\x10R\x12\x11\n" +
+	"\rSYSTEM_UPDATE\x10S\x12\x0f\n" +
+	"\vSCRIPT_LIST\x10Z\x12\x12\n" +
+	"\x0eSCRIPT_EXECUTE\x10[\x12\x11\n" +
+	"\rSCRIPT_UPLOAD\x10\\\x12\x0f\n" +
+	"\vCONFIG_READ\x10d\x12\x10\n" +
+	"\fCONFIG_WRITE\x10e\x12\x13\n" +
+	"\x0fCONFIG_VALIDATE\x10f\x12\x13\n" +
+	"\x0fCONFIG_ROLLBACK\x10g\x12\x17\n" +
+	"\x13CONFIG_LIST_BACKUPS\x10h\x12\x10\n" +
+	"\fHEALTH_CHECK\x10n\x12\x15\n" +
+	"\x11CONNECTIVITY_TEST\x10o2\xf9\x03\n" +
 	"\x0fNanoLinkService\x12=\n" +
 	"\fAuthenticate\x12\x15.nanolink.AuthRequest\x1a\x16.nanolink.AuthResponse\x12T\n" +
 	"\rStreamMetrics\x12\x1e.nanolink.MetricsStreamRequest\x1a\x1f.nanolink.MetricsStreamResponse(\x010\x01\x128\n" +
@@ -5222,13 +7626,14 @@ const file_nanolink_proto_rawDesc = "" +
 	"\x0eExecuteCommand\x12\x11.nanolink.Command\x1a\x17.nanolink.CommandResult\x12D\n" +
 	"\tHeartbeat\x12\x1a.nanolink.HeartbeatRequest\x1a\x1b.nanolink.HeartbeatResponse\x12J\n" +
 	"\vSyncMetrics\x12\x1c.nanolink.MetricsSyncRequest\x1a\x1d.nanolink.MetricsSyncResponse\x12G\n" +
-	"\fGetAgentInfo\x12\x1a.nanolink.AgentInfoRequest\x1a\x1b.nanolink.AgentInfoResponse2\xf4\x02\n" +
+	"\fGetAgentInfo\x12\x1a.nanolink.AgentInfoRequest\x1a\x1b.nanolink.AgentInfoResponse2\xc6\x03\n" +
 	"\x10DashboardService\x12C\n" +
 	"\vWatchAgents\x12\x1c.nanolink.WatchAgentsRequest\x1a\x14.nanolink.AgentEvent0\x01\x12B\n" +
 	"\fWatchMetrics\x12\x1d.nanolink.WatchMetricsRequest\x1a\x11.nanolink.Metrics0\x01\x12D\n" +
 	"\tGetAgents\x12\x1a.nanolink.GetAgentsRequest\x1a\x1b.nanolink.GetAgentsResponse\x12F\n" +
 	"\x0fGetAgentMetrics\x12 .nanolink.GetAgentMetricsRequest\x1a\x11.nanolink.Metrics\x12I\n" +
-	"\vSendCommand\x12!.nanolink.DashboardCommandRequest\x1a\x17.nanolink.CommandResultBI\n" +
+	"\vSendCommand\x12!.nanolink.DashboardCommandRequest\x1a\x17.nanolink.CommandResult\x12P\n" +
+	"\x11WatchServerEvents\x12\".nanolink.WatchServerEventsRequest\x1a\x15.nanolink.ServerEvent0\x01BI\n" +
 	"\x11io.nanolink.protoP\x01Z2github.com/chenqi92/NanoLink/sdk/go/nanolink/protob\x06proto3"
 
 var (
@@ -5243,149 +7648,209 @@ func file_nanolink_proto_rawDescGZIP() []byte {
 	return file_nanolink_proto_rawDescData
 }
 
-var file_nanolink_proto_enumTypes = make([]protoimpl.EnumInfo, 4)
-var file_nanolink_proto_msgTypes = make([]protoimpl.MessageInfo, 53)
+var file_nanolink_proto_enumTypes = make([]protoimpl.EnumInfo, 8)
+var file_nanolink_proto_msgTypes = make([]protoimpl.MessageInfo, 78)
 var file_nanolink_proto_goTypes = []any{
-	(MetricsType)(0),                // 0: nanolink.MetricsType
-	(DataRequestType)(0),            // 1: nanolink.DataRequestType
-	(CommandType)(0),                // 2: nanolink.CommandType
-	(AgentEvent_EventType)(0),       // 3: nanolink.AgentEvent.EventType
-	(*Envelope)(nil),                // 4: nanolink.Envelope
-	(*AuthRequest)(nil),             // 5: nanolink.AuthRequest
-	(*AuthResponse)(nil),            // 6: nanolink.AuthResponse
-	(*DataRequest)(nil),             // 7: nanolink.DataRequest
-	(*Metrics)(nil),                 // 8: nanolink.Metrics
-	(*RealtimeMetrics)(nil),         // 9: nanolink.RealtimeMetrics
-	(*DiskIO)(nil),                  // 10: nanolink.DiskIO
-	(*NetworkIO)(nil),               // 11: nanolink.NetworkIO
-	(*GpuUsage)(nil),                // 12: nanolink.GpuUsage
-	(*NpuUsage)(nil),                // 13: nanolink.NpuUsage
-	(*StaticInfo)(nil),              // 14: nanolink.StaticInfo
-	(*CpuStaticInfo)(nil),           // 15: nanolink.CpuStaticInfo
-	(*MemoryStaticInfo)(nil),        // 16: nanolink.MemoryStaticInfo
-	(*DiskStaticInfo)(nil),          // 17: nanolink.DiskStaticInfo
-	(*NetworkStaticInfo)(nil),       // 18: nanolink.NetworkStaticInfo
-	(*GpuStaticInfo)(nil),           // 19: nanolink.GpuStaticInfo
-	(*NpuStaticInfo)(nil),           // 20: nanolink.NpuStaticInfo
-	(*PeriodicData)(nil),            // 21: nanolink.PeriodicData
-	(*DiskUsage)(nil),               // 22: nanolink.DiskUsage
-	(*NetworkAddressUpdate)(nil),    // 23: nanolink.NetworkAddressUpdate
-	(*CpuMetrics)(nil),              // 24: nanolink.CpuMetrics
-	(*MemoryMetrics)(nil),           // 25: nanolink.MemoryMetrics
-	(*DiskMetrics)(nil),             // 26: nanolink.DiskMetrics
-	(*NetworkMetrics)(nil),          // 27: nanolink.NetworkMetrics
-	(*GpuMetrics)(nil),              // 28: nanolink.GpuMetrics
-	(*SystemInfo)(nil),              // 29: nanolink.SystemInfo
-	(*UserSession)(nil),             // 30: nanolink.UserSession
-	(*NpuMetrics)(nil),              // 31: nanolink.NpuMetrics
-	(*MetricsSync)(nil),             // 32: nanolink.MetricsSync
-	(*Command)(nil),                 // 33: nanolink.Command
-	(*CommandResult)(nil),           // 34: nanolink.CommandResult
-	(*ProcessInfo)(nil),             // 35: nanolink.ProcessInfo
-	(*ContainerInfo)(nil),           // 36: nanolink.ContainerInfo
-	(*Heartbeat)(nil),               // 37: nanolink.Heartbeat
-	(*HeartbeatAck)(nil),            // 38: nanolink.HeartbeatAck
-	(*MetricsStreamRequest)(nil),    // 39: nanolink.MetricsStreamRequest
-	(*MetricsStreamResponse)(nil),   // 40: nanolink.MetricsStreamResponse
-	(*MetricsAck)(nil),              // 41: nanolink.MetricsAck
-	(*HeartbeatRequest)(nil),        // 42: nanolink.HeartbeatRequest
-	(*HeartbeatResponse)(nil),       // 43: nanolink.HeartbeatResponse
-	(*MetricsSyncRequest)(nil),      // 44: nanolink.MetricsSyncRequest
-	(*MetricsSyncResponse)(nil),     // 45: nanolink.MetricsSyncResponse
-	(*AgentInfoRequest)(nil),        // 46: nanolink.AgentInfoRequest
-	(*AgentInfoResponse)(nil),       // 47: nanolink.AgentInfoResponse
-	(*ServerConfig)(nil),            // 48: nanolink.ServerConfig
-	(*WatchAgentsRequest)(nil),      // 49: nanolink.WatchAgentsRequest
-	(*AgentEvent)(nil),              // 50: nanolink.AgentEvent
-	(*WatchMetricsRequest)(nil),     // 51: nanolink.WatchMetricsRequest
-	(*GetAgentsRequest)(nil),        // 52: nanolink.GetAgentsRequest
-	(*GetAgentsResponse)(nil),       // 53: nanolink.GetAgentsResponse
-	(*GetAgentMetricsRequest)(nil),  // 54: nanolink.GetAgentMetricsRequest
-	(*DashboardCommandRequest)(nil), // 55: nanolink.DashboardCommandRequest
-	nil,                             // 56: nanolink.Command.ParamsEntry
+	(AuthFailureReason)(0),           // 0: nanolink.AuthFailureReason
+	(MetricsType)(0),                 // 1: nanolink.MetricsType
+	(DataRequestType)(0),             // 2: nanolink.DataRequestType
+	(RealtimeField)(0),               // 3: nanolink.RealtimeField
+	(RealtimeReportMode)(0),          // 4: nanolink.RealtimeReportMode
+	(CollectorState)(0),              // 5: nanolink.CollectorState
+	(CommandType)(0),                 // 6: nanolink.CommandType
+	(AgentEvent_EventType)(0),        // 7: nanolink.AgentEvent.EventType
+	(*Envelope)(nil),                 // 8: nanolink.Envelope
+	(*AuthRequest)(nil),              // 9: nanolink.AuthRequest
+	(*CommandPolicy)(nil),            // 10: nanolink.CommandPolicy
+	(*AuthResponse)(nil),             // 11: nanolink.AuthResponse
+	(*DataRequest)(nil),              // 12: nanolink.DataRequest
+	(*Metrics)(nil),                  // 13: nanolink.Metrics
+	(*RealtimeMetrics)(nil),          // 14: nanolink.RealtimeMetrics
+	(*DiskIO)(nil),                   // 15: nanolink.DiskIO
+	(*NetworkIO)(nil),                // 16: nanolink.NetworkIO
+	(*GpuUsage)(nil),                 // 17: nanolink.GpuUsage
+	(*NpuUsage)(nil),                 // 18: nanolink.NpuUsage
+	(*StaticInfo)(nil),               // 19: nanolink.StaticInfo
+	(*CpuStaticInfo)(nil),            // 20: nanolink.CpuStaticInfo
+	(*MemoryStaticInfo)(nil),         // 21: nanolink.MemoryStaticInfo
+	(*DiskStaticInfo)(nil),           // 22: nanolink.DiskStaticInfo
+	(*NetworkStaticInfo)(nil),        // 23: nanolink.NetworkStaticInfo
+	(*GpuStaticInfo)(nil),            // 24: nanolink.GpuStaticInfo
+	(*NpuStaticInfo)(nil),            // 25: nanolink.NpuStaticInfo
+	(*PeriodicData)(nil),             // 26: nanolink.PeriodicData
+	(*CollectorStatus)(nil),          // 27: nanolink.CollectorStatus
+	(*DiskUsage)(nil),                // 28: nanolink.DiskUsage
+	(*NetworkAddressUpdate)(nil),     // 29: nanolink.NetworkAddressUpdate
+	(*CpuMetrics)(nil),               // 30: nanolink.CpuMetrics
+	(*MemoryMetrics)(nil),            // 31: nanolink.MemoryMetrics
+	(*DiskMetrics)(nil),              // 32: nanolink.DiskMetrics
+	(*NetworkMetrics)(nil),           // 33: nanolink.NetworkMetrics
+	(*GpuMetrics)(nil),               // 34: nanolink.GpuMetrics
+	(*SystemInfo)(nil),               // 35: nanolink.SystemInfo
+	(*UserSession)(nil),              // 36: nanolink.UserSession
+	(*NpuMetrics)(nil),               // 37: nanolink.NpuMetrics
+	(*MetricsSync)(nil),              // 38: nanolink.MetricsSync
+	(*Command)(nil),                  // 39: nanolink.Command
+	(*CommandResult)(nil),            // 40: nanolink.CommandResult
+	(*LogQueryResult)(nil),           // 41: nanolink.LogQueryResult
+	(*LogEntry)(nil),                 // 42: nanolink.LogEntry
+	(*PackageInfo)(nil),              // 43: nanolink.PackageInfo
+	(*ScriptInfo)(nil),               // 44: nanolink.ScriptInfo
+	(*ConfigResult)(nil),             // 45: nanolink.ConfigResult
+	(*ConfigBackup)(nil),             // 46: nanolink.ConfigBackup
+	(*HealthCheckResult)(nil),        // 47: nanolink.HealthCheckResult
+	(*HealthCheckItem)(nil),          // 48: nanolink.HealthCheckItem
+	(*UpdateInfo)(nil),               // 49: nanolink.UpdateInfo
+	(*ProcessInfo)(nil),              // 50: nanolink.ProcessInfo
+	(*ContainerInfo)(nil),            // 51: nanolink.ContainerInfo
+	(*Heartbeat)(nil),                // 52: nanolink.Heartbeat
+	(*HeartbeatAck)(nil),             // 53: nanolink.HeartbeatAck
+	(*AgentInit)(nil),                // 54: nanolink.AgentInit
+	(*GracefulDisconnect)(nil),       // 55: nanolink.GracefulDisconnect
+	(*MetricsStreamRequest)(nil),     // 56: nanolink.MetricsStreamRequest
+	(*MetricsStreamResponse)(nil),    // 57: nanolink.MetricsStreamResponse
+	(*StartLogStream)(nil),           // 58: nanolink.StartLogStream
+	(*StopLogStream)(nil),            // 59: nanolink.StopLogStream
+	(*LogChunk)(nil),                 // 60: nanolink.LogChunk
+	(*CommandOutput)(nil),            // 61: nanolink.CommandOutput
+	(*MetricsAck)(nil),               // 62: nanolink.MetricsAck
+	(*HeartbeatRequest)(nil),         // 63: nanolink.HeartbeatRequest
+	(*HeartbeatResponse)(nil),        // 64: nanolink.HeartbeatResponse
+	(*MetricsSyncRequest)(nil),       // 65: nanolink.MetricsSyncRequest
+	(*MetricsSyncResponse)(nil),      // 66: nanolink.MetricsSyncResponse
+	(*AgentInfoRequest)(nil),         // 67: nanolink.AgentInfoRequest
+	(*AgentInfoResponse)(nil),        // 68: nanolink.AgentInfoResponse
+	(*ServerConfig)(nil),             // 69: nanolink.ServerConfig
+	(*WatchAgentsRequest)(nil),       // 70: nanolink.WatchAgentsRequest
+	(*AgentEvent)(nil),               // 71: nanolink.AgentEvent
+	(*WatchMetricsRequest)(nil),      // 72: nanolink.WatchMetricsRequest
+	(*GetAgentsRequest)(nil),         // 73: nanolink.GetAgentsRequest
+	(*GetAgentsResponse)(nil),        // 74: nanolink.GetAgentsResponse
+	(*GetAgentMetricsRequest)(nil),   // 75: nanolink.GetAgentMetricsRequest
+	(*DashboardCommandRequest)(nil),  // 76: nanolink.DashboardCommandRequest
+	(*WatchServerEventsRequest)(nil), // 77: nanolink.WatchServerEventsRequest
+	(*ServerEvent)(nil),              // 78: nanolink.ServerEvent
+	nil,                              // 79: nanolink.AuthRequest.LabelsEntry
+	nil,                              // 80: nanolink.Command.ParamsEntry
+	nil,                              // 81: nanolink.LogEntry.MetadataEntry
+	nil,                              // 82: nanolink.HealthCheckItem.DetailsEntry
+	nil,                              // 83: nanolink.AgentInit.LabelsEntry
+	nil,                              // 84: nanolink.AgentInfoResponse.LabelsEntry
+	nil,                              // 85: nanolink.ServerEvent.AttributesEntry
 }
 var file_nanolink_proto_depIdxs = []int32{
-	5,  // 0: nanolink.Envelope.auth_request:type_name -> nanolink.AuthRequest
-	6,  // 1: nanolink.Envelope.auth_response:type_name -> nanolink.AuthResponse
-	8,  // 2: nanolink.Envelope.metrics:type_name -> nanolink.Metrics
-	32, // 3: nanolink.Envelope.metrics_sync:type_name -> nanolink.MetricsSync
-	33, // 4: nanolink.Envelope.command:type_name -> nanolink.Command
-	34, // 5: nanolink.Envelope.command_result:type_name -> nanolink.CommandResult
-	37, // 6: nanolink.Envelope.heartbeat:type_name -> nanolink.Heartbeat
-	38, // 7: nanolink.Envelope.heartbeat_ack:type_name -> nanolink.HeartbeatAck
-	1,  // 8: nanolink.DataRequest.request_type:type_name -> nanolink.DataRequestType
-	24, // 9: nanolink.Metrics.cpu:type_name -> nanolink.CpuMetrics
-	25, // 10: nanolink.Metrics.memory:type_name -> nanolink.MemoryMetrics
-	26, // 11: nanolink.Metrics.disks:type_name -> nanolink.DiskMetrics
-	27, // 12: nanolink.Metrics.networks:type_name -> nanolink.NetworkMetrics
-	28, // 13: nanolink.Metrics.gpus:type_name -> nanolink.GpuMetrics
-	29, // 14: nanolink.Metrics.system_info:type_name -> nanolink.SystemInfo
-	30, // 15: nanolink.Metrics.user_sessions:type_name -> nanolink.UserSession
-	31, // 16: nanolink.Metrics.npus:type_name -> nanolink.NpuMetrics
-	0,  // 17: nanolink.Metrics.metrics_type:type_name -> nanolink.MetricsType
-	10, // 18: nanolink.RealtimeMetrics.disk_io:type_name -> nanolink.DiskIO
-	11, // 19: nanolink.RealtimeMetrics.network_io:type_name -> nanolink.NetworkIO
-	12, // 20: nanolink.RealtimeMetrics.gpu_usage:type_name -> nanolink.GpuUsage
-	13, // 21: nanolink.RealtimeMetrics.npu_usage:type_name -> nanolink.NpuUsage
-	15, // 22: nanolink.StaticInfo.cpu:type_name -> nanolink.CpuStaticInfo
-	16, // 23: nanolink.StaticInfo.memory:type_name -> nanolink.MemoryStaticInfo
-	17, // 24: nanolink.StaticInfo.disks:type_name -> nanolink.DiskStaticInfo
-	18, // 25: nanolink.StaticInfo.networks:type_name -> nanolink.NetworkStaticInfo
-	19, // 26: nanolink.StaticInfo.gpus:type_name -> nanolink.GpuStaticInfo
-	20, // 27: nanolink.StaticInfo.npus:type_name -> nanolink.NpuStaticInfo
-	29, // 28: nanolink.StaticInfo.system_info:type_name -> nanolink.SystemInfo
-	22, // 29: nanolink.PeriodicData.disk_usage:type_name -> nanolink.DiskUsage
-	30, // 30: nanolink.PeriodicData.user_sessions:type_name -> nanolink.UserSession
-	23, // 31: nanolink.PeriodicData.network_updates:type_name -> nanolink.NetworkAddressUpdate
-	8,  // 32: nanolink.MetricsSync.buffered_metrics:type_name -> nanolink.Metrics
-	2,  // 33: nanolink.Command.type:type_name -> nanolink.CommandType
-	56, // 34: nanolink.Command.params:type_name -> nanolink.Command.ParamsEntry
-	35, // 35: nanolink.CommandResult.processes:type_name -> nanolink.ProcessInfo
-	36, // 36: nanolink.CommandResult.containers:type_name -> nanolink.ContainerInfo
-	8,  // 37: nanolink.MetricsStreamRequest.metrics:type_name -> nanolink.Metrics
-	37, // 38: nanolink.MetricsStreamRequest.heartbeat:type_name -> nanolink.Heartbeat
-	34, // 39: nanolink.MetricsStreamRequest.command_result:type_name -> nanolink.CommandResult
-	9,  // 40: nanolink.MetricsStreamRequest.realtime:type_name -> nanolink.RealtimeMetrics
-	14, // 41: nanolink.MetricsStreamRequest.static_info:type_name -> nanolink.StaticInfo
-	21, // 42: nanolink.MetricsStreamRequest.periodic:type_name -> nanolink.PeriodicData
-	33, // 43: nanolink.MetricsStreamResponse.command:type_name -> nanolink.Command
-	38, // 44: nanolink.MetricsStreamResponse.heartbeat_ack:type_name -> nanolink.HeartbeatAck
-	48, // 45: nanolink.MetricsStreamResponse.config_update:type_name -> nanolink.ServerConfig
-	7,  // 46: nanolink.MetricsStreamResponse.data_request:type_name -> nanolink.DataRequest
-	8,  // 47: nanolink.MetricsSyncResponse.metrics:type_name -> nanolink.Metrics
-	3,  // 48: nanolink.AgentEvent.event_type:type_name -> nanolink.AgentEvent.EventType
-	47, // 49: nanolink.AgentEvent.agent:type_name -> nanolink.AgentInfoResponse
-	47, // 50: nanolink.GetAgentsResponse.agents:type_name -> nanolink.AgentInfoResponse
-	33, // 51: nanolink.DashboardCommandRequest.command:type_name -> nanolink.Command
-	5,  // 52: nanolink.NanoLinkService.Authenticate:input_type -> nanolink.AuthRequest
-	39, // 53: nanolink.NanoLinkService.StreamMetrics:input_type -> nanolink.MetricsStreamRequest
-	8,  // 54: nanolink.NanoLinkService.ReportMetrics:input_type -> nanolink.Metrics
-	33, // 55: nanolink.NanoLinkService.ExecuteCommand:input_type -> nanolink.Command
-	42, // 56: nanolink.NanoLinkService.Heartbeat:input_type -> nanolink.HeartbeatRequest
-	44, // 57: nanolink.NanoLinkService.SyncMetrics:input_type -> nanolink.MetricsSyncRequest
-	46, // 58: nanolink.NanoLinkService.GetAgentInfo:input_type -> nanolink.AgentInfoRequest
-	49, // 59: nanolink.DashboardService.WatchAgents:input_type -> nanolink.WatchAgentsRequest
-	51, // 60: nanolink.DashboardService.WatchMetrics:input_type -> nanolink.WatchMetricsRequest
-	52, // 61: nanolink.DashboardService.GetAgents:input_type -> nanolink.GetAgentsRequest
-	54, // 62: nanolink.DashboardService.GetAgentMetrics:input_type -> nanolink.GetAgentMetricsRequest
-	55, // 63: nanolink.DashboardService.SendCommand:input_type -> nanolink.DashboardCommandRequest
-	6,  // 64: nanolink.NanoLinkService.Authenticate:output_type -> nanolink.AuthResponse
-	40, // 65: nanolink.NanoLinkService.StreamMetrics:output_type -> nanolink.MetricsStreamResponse
-	41, // 66: nanolink.NanoLinkService.ReportMetrics:output_type -> nanolink.MetricsAck
-	34, // 67: nanolink.NanoLinkService.ExecuteCommand:output_type -> nanolink.CommandResult
-	43, // 68: nanolink.NanoLinkService.Heartbeat:output_type -> nanolink.HeartbeatResponse
-	45, // 69: nanolink.NanoLinkService.SyncMetrics:output_type -> nanolink.MetricsSyncResponse
-	47, // 70: nanolink.NanoLinkService.GetAgentInfo:output_type -> nanolink.AgentInfoResponse
-	50, // 71: nanolink.DashboardService.WatchAgents:output_type -> nanolink.AgentEvent
-	8,  // 72: nanolink.DashboardService.WatchMetrics:output_type -> nanolink.Metrics
-	53, // 73: nanolink.DashboardService.GetAgents:output_type -> nanolink.GetAgentsResponse
-	8,  // 74: nanolink.DashboardService.GetAgentMetrics:output_type -> nanolink.Metrics
-	34, // 75: nanolink.DashboardService.SendCommand:output_type -> nanolink.CommandResult
-	64, // [64:76] is the sub-list for method output_type
-	52, // [52:64] is the sub-list for method input_type
-	52, // [52:52] is the sub-list for extension type_name
-	52, // [52:52] is the sub-list for extension extendee
-	0,  // [0:52] is the sub-list for field type_name
+	9,  // 0: nanolink.Envelope.auth_request:type_name -> nanolink.AuthRequest
+	11, // 1: nanolink.Envelope.auth_response:type_name -> nanolink.AuthResponse
+	13, // 2: nanolink.Envelope.metrics:type_name -> nanolink.Metrics
+	38, // 3: nanolink.Envelope.metrics_sync:type_name -> nanolink.MetricsSync
+	39, // 4: nanolink.Envelope.command:type_name -> nanolink.Command
+	40, // 5: nanolink.Envelope.command_result:type_name -> nanolink.CommandResult
+	52, // 6: nanolink.Envelope.heartbeat:type_name -> nanolink.Heartbeat
+	53, // 7: nanolink.Envelope.heartbeat_ack:type_name -> nanolink.HeartbeatAck
+	79, // 8: nanolink.AuthRequest.labels:type_name -> nanolink.AuthRequest.LabelsEntry
+	10, // 9: nanolink.AuthRequest.command_policy:type_name -> nanolink.CommandPolicy
+	0,  // 10: nanolink.AuthResponse.failure_reason:type_name -> nanolink.AuthFailureReason
+	2,  // 11: nanolink.DataRequest.request_type:type_name -> nanolink.DataRequestType
+	30, // 12: nanolink.Metrics.cpu:type_name -> nanolink.CpuMetrics
+	31, // 13: nanolink.Metrics.memory:type_name -> nanolink.MemoryMetrics
+	32, // 14: nanolink.Metrics.disks:type_name -> nanolink.DiskMetrics
+	33, // 15: nanolink.Metrics.networks:type_name -> nanolink.NetworkMetrics
+	34, // 16: nanolink.Metrics.gpus:type_name -> nanolink.GpuMetrics
+	35, // 17: nanolink.Metrics.system_info:type_name -> nanolink.SystemInfo
+	36, // 18: nanolink.Metrics.user_sessions:type_name -> nanolink.UserSession
+	37, // 19: nanolink.Metrics.npus:type_name -> nanolink.NpuMetrics
+	1,  // 20: nanolink.Metrics.metrics_type:type_name -> nanolink.MetricsType
+	27, // 21: nanolink.Metrics.collector_status:type_name -> nanolink.CollectorStatus
+	15, // 22: nanolink.RealtimeMetrics.disk_io:type_name -> nanolink.DiskIO
+	16, // 23: nanolink.RealtimeMetrics.network_io:type_name -> nanolink.NetworkIO
+	17, // 24: nanolink.RealtimeMetrics.gpu_usage:type_name -> nanolink.GpuUsage
+	18, // 25: nanolink.RealtimeMetrics.npu_usage:type_name -> nanolink.NpuUsage
+	20, // 26: nanolink.StaticInfo.cpu:type_name -> nanolink.CpuStaticInfo
+	21, // 27: nanolink.StaticInfo.memory:type_name -> nanolink.MemoryStaticInfo
+	22, // 28: nanolink.StaticInfo.disks:type_name -> nanolink.DiskStaticInfo
+	23, // 29: nanolink.StaticInfo.networks:type_name -> nanolink.NetworkStaticInfo
+	24, // 30: nanolink.StaticInfo.gpus:type_name -> nanolink.GpuStaticInfo
+	25, // 31: nanolink.StaticInfo.npus:type_name -> nanolink.NpuStaticInfo
+	35, // 32: nanolink.StaticInfo.system_info:type_name -> nanolink.SystemInfo
+	28, // 33: nanolink.PeriodicData.disk_usage:type_name -> nanolink.DiskUsage
+	36, // 34: nanolink.PeriodicData.user_sessions:type_name -> nanolink.UserSession
+	29, // 35: nanolink.PeriodicData.network_updates:type_name -> nanolink.NetworkAddressUpdate
+	27, // 36: nanolink.PeriodicData.collector_status:type_name -> nanolink.CollectorStatus
+	5,  // 37: nanolink.CollectorStatus.state:type_name -> nanolink.CollectorState
+	13, // 38: nanolink.MetricsSync.buffered_metrics:type_name -> nanolink.Metrics
+	6,  // 39: nanolink.Command.type:type_name -> nanolink.CommandType
+	80, // 40: nanolink.Command.params:type_name -> nanolink.Command.ParamsEntry
+	50, // 41: nanolink.CommandResult.processes:type_name -> nanolink.ProcessInfo
+	51, // 42: nanolink.CommandResult.containers:type_name -> nanolink.ContainerInfo
+	49, // 43: nanolink.CommandResult.update_info:type_name -> nanolink.UpdateInfo
+	41, // 44: nanolink.CommandResult.log_result:type_name -> nanolink.LogQueryResult
+	43, // 45: nanolink.CommandResult.packages:type_name -> nanolink.PackageInfo
+	44, // 46: nanolink.CommandResult.scripts:type_name -> nanolink.ScriptInfo
+	45, // 47: nanolink.CommandResult.config_result:type_name -> nanolink.ConfigResult
+	47, // 48: nanolink.CommandResult.health_result:type_name -> nanolink.HealthCheckResult
+	42, // 49: nanolink.LogQueryResult.lines:type_name -> nanolink.LogEntry
+	81, // 50: nanolink.LogEntry.metadata:type_name -> nanolink.LogEntry.MetadataEntry
+	46, // 51: nanolink.ConfigResult.backups:type_name -> nanolink.ConfigBackup
+	48, // 52: nanolink.HealthCheckResult.checks:type_name -> nanolink.HealthCheckItem
+	82, // 53: nanolink.HealthCheckItem.details:type_name -> nanolink.HealthCheckItem.DetailsEntry
+	4,  // 54: nanolink.HeartbeatAck.realtime_mode:type_name -> nanolink.RealtimeReportMode
+	83, // 55: nanolink.AgentInit.labels:type_name -> nanolink.AgentInit.LabelsEntry
+	13, // 56: nanolink.MetricsStreamRequest.metrics:type_name -> nanolink.Metrics
+	52, // 57: nanolink.MetricsStreamRequest.heartbeat:type_name -> nanolink.Heartbeat
+	40, // 58: nanolink.MetricsStreamRequest.command_result:type_name -> nanolink.CommandResult
+	14, // 59: nanolink.MetricsStreamRequest.realtime:type_name -> nanolink.RealtimeMetrics
+	19, // 60: nanolink.MetricsStreamRequest.static_info:type_name -> nanolink.StaticInfo
+	26, // 61: nanolink.MetricsStreamRequest.periodic:type_name -> nanolink.PeriodicData
+	54, // 62: nanolink.MetricsStreamRequest.agent_init:type_name -> nanolink.AgentInit
+	55, // 63: nanolink.MetricsStreamRequest.graceful_disconnect:type_name -> nanolink.GracefulDisconnect
+	60, // 64: nanolink.MetricsStreamRequest.log_chunk:type_name -> nanolink.LogChunk
+	61, // 65: nanolink.MetricsStreamRequest.command_output:type_name -> nanolink.CommandOutput
+	39, // 66: nanolink.MetricsStreamResponse.command:type_name -> nanolink.Command
+	53, // 67: nanolink.MetricsStreamResponse.heartbeat_ack:type_name -> nanolink.HeartbeatAck
+	69, // 68: nanolink.MetricsStreamResponse.config_update:type_name -> nanolink.ServerConfig
+	12, // 69: nanolink.MetricsStreamResponse.data_request:type_name -> nanolink.DataRequest
+	62, // 70: nanolink.MetricsStreamResponse.metrics_ack:type_name -> nanolink.MetricsAck
+	58, // 71: nanolink.MetricsStreamResponse.start_log_stream:type_name -> nanolink.StartLogStream
+	59, // 72: nanolink.MetricsStreamResponse.stop_log_stream:type_name -> nanolink.StopLogStream
+	13, // 73: nanolink.MetricsSyncResponse.metrics:type_name -> nanolink.Metrics
+	84, // 74: nanolink.AgentInfoResponse.labels:type_name -> nanolink.AgentInfoResponse.LabelsEntry
+	10, // 75: nanolink.AgentInfoResponse.command_policy:type_name -> nanolink.CommandPolicy
+	7,  // 76: nanolink.AgentEvent.event_type:type_name -> nanolink.AgentEvent.EventType
+	68, // 77: nanolink.AgentEvent.agent:type_name -> nanolink.AgentInfoResponse
+	68, // 78: nanolink.GetAgentsResponse.agents:type_name -> nanolink.AgentInfoResponse
+	39, // 79: nanolink.DashboardCommandRequest.command:type_name -> nanolink.Command
+	85, // 80: nanolink.ServerEvent.attributes:type_name -> nanolink.ServerEvent.AttributesEntry
+	9,  // 81: nanolink.NanoLinkService.Authenticate:input_type -> nanolink.AuthRequest
+	56, // 82: nanolink.NanoLinkService.StreamMetrics:input_type -> nanolink.MetricsStreamRequest
+	13, // 83: nanolink.NanoLinkService.ReportMetrics:input_type -> nanolink.Metrics
+	39, // 84: nanolink.NanoLinkService.ExecuteCommand:input_type -> nanolink.Command
+	63, // 85: nanolink.NanoLinkService.Heartbeat:input_type -> nanolink.HeartbeatRequest
+	65, // 86: nanolink.NanoLinkService.SyncMetrics:input_type -> nanolink.MetricsSyncRequest
+	67, // 87: nanolink.NanoLinkService.GetAgentInfo:input_type -> nanolink.AgentInfoRequest
+	70, // 88: nanolink.DashboardService.WatchAgents:input_type -> nanolink.WatchAgentsRequest
+	72, // 89: nanolink.DashboardService.WatchMetrics:input_type -> nanolink.WatchMetricsRequest
+	73, // 90: nanolink.DashboardService.GetAgents:input_type -> nanolink.GetAgentsRequest
+	75, // 91: nanolink.DashboardService.GetAgentMetrics:input_type -> nanolink.GetAgentMetricsRequest
+	76, // 92: nanolink.DashboardService.SendCommand:input_type -> nanolink.DashboardCommandRequest
+	77, // 93: nanolink.DashboardService.WatchServerEvents:input_type -> nanolink.WatchServerEventsRequest
+	11, // 94: nanolink.NanoLinkService.Authenticate:output_type -> nanolink.AuthResponse
+	57, // 95: nanolink.NanoLinkService.StreamMetrics:output_type -> nanolink.MetricsStreamResponse
+	62, // 96: nanolink.NanoLinkService.ReportMetrics:output_type -> nanolink.MetricsAck
+	40, // 97: nanolink.NanoLinkService.ExecuteCommand:output_type -> nanolink.CommandResult
+	64, // 98: nanolink.NanoLinkService.Heartbeat:output_type -> nanolink.HeartbeatResponse
+	66, // 99: nanolink.NanoLinkService.SyncMetrics:output_type -> nanolink.MetricsSyncResponse
+	68, // 100: nanolink.NanoLinkService.GetAgentInfo:output_type -> nanolink.AgentInfoResponse
+	71, // 101: nanolink.DashboardService.WatchAgents:output_type -> nanolink.AgentEvent
+	13, // 102: nanolink.DashboardService.WatchMetrics:output_type -> nanolink.Metrics
+	74, // 103: nanolink.DashboardService.GetAgents:output_type -> nanolink.GetAgentsResponse
+	13, // 104: nanolink.DashboardService.GetAgentMetrics:output_type -> nanolink.Metrics
+	40, // 105: nanolink.DashboardService.SendCommand:output_type -> nanolink.CommandResult
+	78, // 106: nanolink.DashboardService.WatchServerEvents:output_type -> nanolink.ServerEvent
+	94, // [94:107] is the sub-list for method output_type
+	81, // [81:94] is the sub-list for method input_type
+	81, // [81:81] is the sub-list for extension type_name
+	81, // [81:81] is the sub-list for extension extendee
+	0,  // [0:81] is the sub-list for field type_name
 }
 
 func init() { file_nanolink_proto_init() }
@@ -5403,27 +7868,34 @@ func file_nanolink_proto_init() {
 		(*Envelope_Heartbeat)(nil),
 		(*Envelope_HeartbeatAck)(nil),
 	}
-	file_nanolink_proto_msgTypes[35].OneofWrappers = []any{
+	file_nanolink_proto_msgTypes[48].OneofWrappers = []any{
 		(*MetricsStreamRequest_Metrics)(nil),
 		(*MetricsStreamRequest_Heartbeat)(nil),
 		(*MetricsStreamRequest_CommandResult)(nil),
 		(*MetricsStreamRequest_Realtime)(nil),
 		(*MetricsStreamRequest_StaticInfo)(nil),
 		(*MetricsStreamRequest_Periodic)(nil),
+		(*MetricsStreamRequest_AgentInit)(nil),
+		(*MetricsStreamRequest_GracefulDisconnect)(nil),
+		(*MetricsStreamRequest_LogChunk)(nil),
+		(*MetricsStreamRequest_CommandOutput)(nil),
 	}
-	file_nanolink_proto_msgTypes[36].OneofWrappers = []any{
+	file_nanolink_proto_msgTypes[49].OneofWrappers = []any{
 		(*MetricsStreamResponse_Command)(nil),
 		(*MetricsStreamResponse_HeartbeatAck)(nil),
 		(*MetricsStreamResponse_ConfigUpdate)(nil),
 		(*MetricsStreamResponse_DataRequest)(nil),
+		(*MetricsStreamResponse_MetricsAck)(nil),
+		(*MetricsStreamResponse_StartLogStream)(nil),
+		(*MetricsStreamResponse_StopLogStream)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_nanolink_proto_rawDesc), len(file_nanolink_proto_rawDesc)),
-			NumEnums:      4,
-			NumMessages:   53,
+			NumEnums:      8,
+			NumMessages:   78,
 			NumExtensions: 0,
 			NumServices:   2,
 		},
@@ -1,9 +1,11 @@
 package nanolink
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"log"
 	"net"
+	"reflect"
 	"sync"
 	"time"
 
@@ -18,6 +20,24 @@ const (
 	DefaultHeartbeatInterval = 30 * time.Second  // Check interval
 )
 
+// Default broadcast fan-out settings
+const (
+	DefaultBroadcastConcurrency = 32              // Parallel sends per broadcast
+	DefaultBroadcastSendTimeout = 5 * time.Second // Per-agent send timeout
+)
+
+// Default per-agent ingestion limits
+const (
+	DefaultMaxMetricsPerSec  = 10 // Full metrics messages per second
+	DefaultMaxRealtimePerSec = 50 // Realtime messages per second
+)
+
+// Default SyncMetrics buffer settings
+const (
+	DefaultSyncBufferSize      = 120              // Metrics messages kept per agent
+	DefaultSyncBufferRetention = 10 * time.Minute // Age after which they are dropped
+)
+
 // Default ports
 const (
 	DefaultGrpcPort = 39100
@@ -41,10 +61,48 @@ type Config struct {
 	HeartbeatTimeout time.Duration
 	// HeartbeatCheckInterval is the interval for checking heartbeat timeouts (default: 30s)
 	HeartbeatCheckInterval time.Duration
+	// HeartbeatStaleAfter is the heartbeat age after which an agent's Status is
+	// stale rather than healthy (default: twice HeartbeatCheckInterval)
+	HeartbeatStaleAfter time.Duration
 
 	// AsyncCallbacks if true, callbacks are executed in separate goroutines (default: false)
 	// This prevents slow callbacks from blocking message processing
 	AsyncCallbacks bool
+
+	// CallbackQueueSize if set, queues metrics, realtime, static info and periodic
+	// callbacks for a single worker instead, holding at most this many (default: 0,
+	// disabled). Takes precedence over AsyncCallbacks for those callbacks.
+	CallbackQueueSize int
+	// CallbackOverflow is the policy for a full queue: CallbackOverflowDropOldest
+	// (default), CallbackOverflowDropNewest or CallbackOverflowBlock
+	CallbackOverflow string
+
+	// GrpcCompressionLevel forces the gzip level (1-9) for messages on agent
+	// streams that negotiated compression (default: 0, gzip's default). The
+	// level applies to every gRPC server in the process.
+	GrpcCompressionLevel int
+
+	// Clock is the time source for heartbeat tracking (default: RealClock)
+	Clock Clock
+
+	// Broadcast fan-out settings
+	// BroadcastConcurrency is the maximum number of agents sent to in parallel (default: 32)
+	BroadcastConcurrency int
+	// BroadcastSendTimeout is how long a single agent send may take before it is
+	// reported as timed out (default: 5s)
+	BroadcastSendTimeout time.Duration
+
+	// Ingestion limits per agent stream; excess messages are dropped
+	// MaxMetricsPerSec caps full metrics messages (default: 10, negative disables)
+	MaxMetricsPerSec float64
+	// MaxRealtimePerSec caps realtime messages (default: 50, negative disables)
+	MaxRealtimePerSec float64
+
+	// Recently received metrics kept per agent for SyncMetrics after a reconnect
+	// SyncBufferSize is the number of metrics messages kept (default: 120, negative disables)
+	SyncBufferSize int
+	// SyncBufferRetention is the age after which they are dropped (default: 10m, negative = no limit)
+	SyncBufferRetention time.Duration
 }
 
 // Token validation result
@@ -58,10 +116,48 @@ type ValidationResult struct {
 type TokenValidator func(token string) ValidationResult
 
 // Default token validator (accepts all)
+//
+// It grants every token read-only access and is intended for demos only;
+// production servers should use StaticTokenValidator or their own validator.
 func DefaultTokenValidator(token string) ValidationResult {
 	return ValidationResult{Valid: true, PermissionLevel: 0}
 }
 
+// StaticTokenValidator returns a validator that accepts only the given
+// tokens, each granted its mapped permission level. Unknown and empty
+// tokens are rejected. The map is copied, so later changes to it have no
+// effect.
+func StaticTokenValidator(tokens map[string]int) TokenValidator {
+	known := make(map[string]int, len(tokens))
+	for token, level := range tokens {
+		if token != "" {
+			known[token] = level
+		}
+	}
+
+	return func(token string) ValidationResult {
+		if token == "" {
+			return ValidationResult{Valid: false, ErrorMessage: "missing token"}
+		}
+		// Compare against every token so timing does not reveal near matches
+		level, found := 0, false
+		for candidate, candidateLevel := range known {
+			if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+				level, found = candidateLevel, true
+			}
+		}
+		if !found {
+			return ValidationResult{Valid: false, ErrorMessage: "invalid token"}
+		}
+		return ValidationResult{Valid: true, PermissionLevel: level}
+	}
+}
+
+// isDefaultTokenValidator reports whether v is the permissive DefaultTokenValidator
+func isDefaultTokenValidator(v TokenValidator) bool {
+	return reflect.ValueOf(v).Pointer() == reflect.ValueOf(DefaultTokenValidator).Pointer()
+}
+
 // Permission levels
 const (
 	PermissionReadOnly       = 0
@@ -72,18 +168,21 @@ const (
 
 // Server is the NanoLink gRPC server
 type Server struct {
-	config            Config
-	agents            map[string]*AgentConnection
-	agentsMu          sync.RWMutex
-	onAgentConnect    func(*AgentConnection)
-	onAgentDisconnect func(*AgentConnection)
-	onMetrics         func(*Metrics)
-	onRealtimeMetrics func(*RealtimeMetrics)
-	onStaticInfo      func(*StaticInfo)
-	onPeriodicData    func(*PeriodicData)
-	grpcServer        *grpc.Server
-	grpcServicer      *NanoLinkServicer
-	heartbeatStop     chan struct{} // Channel to stop heartbeat checker
+	config              Config
+	agents              map[string]*AgentConnection
+	agentsMu            sync.RWMutex
+	onAgentConnect      func(*AgentConnection)
+	onAgentDisconnect   func(*AgentConnection)
+	onAgentStatusChange func(agent *AgentConnection, previous, current AgentStatus)
+	onMetrics           func(*Metrics)
+	onRealtimeMetrics   func(*RealtimeMetrics)
+	onStaticInfo        func(*StaticInfo)
+	onPeriodicData      func(*PeriodicData)
+	onCommandResult     func(*CommandResult)
+	grpcServer          *grpc.Server
+	grpcServicer        *NanoLinkServicer
+	heartbeatStop       chan struct{}  // Channel to stop heartbeat checker
+	callbacks           *callbackQueue // Set when Config.CallbackQueueSize > 0
 }
 
 // NewServer creates a new NanoLink gRPC server
@@ -94,18 +193,50 @@ func NewServer(config Config) *Server {
 	if config.TokenValidator == nil {
 		config.TokenValidator = DefaultTokenValidator
 	}
+	if isDefaultTokenValidator(config.TokenValidator) {
+		log.Printf("WARNING: using DefaultTokenValidator, which accepts ANY token. " +
+			"Set Config.TokenValidator (e.g. StaticTokenValidator) before running in production")
+	}
 	if config.HeartbeatTimeout == 0 {
 		config.HeartbeatTimeout = DefaultHeartbeatTimeout
 	}
 	if config.HeartbeatCheckInterval == 0 {
 		config.HeartbeatCheckInterval = DefaultHeartbeatInterval
 	}
+	if config.HeartbeatStaleAfter == 0 {
+		config.HeartbeatStaleAfter = 2 * config.HeartbeatCheckInterval
+	}
+	if config.Clock == nil {
+		config.Clock = RealClock
+	}
+	if config.BroadcastConcurrency <= 0 {
+		config.BroadcastConcurrency = DefaultBroadcastConcurrency
+	}
+	if config.BroadcastSendTimeout == 0 {
+		config.BroadcastSendTimeout = DefaultBroadcastSendTimeout
+	}
+	if config.MaxMetricsPerSec == 0 {
+		config.MaxMetricsPerSec = DefaultMaxMetricsPerSec
+	}
+	if config.MaxRealtimePerSec == 0 {
+		config.MaxRealtimePerSec = DefaultMaxRealtimePerSec
+	}
+	if config.SyncBufferSize == 0 {
+		config.SyncBufferSize = DefaultSyncBufferSize
+	}
+	if config.SyncBufferRetention == 0 {
+		config.SyncBufferRetention = DefaultSyncBufferRetention
+	}
 
-	return &Server{
+	s := &Server{
 		config:        config,
 		agents:        make(map[string]*AgentConnection),
 		heartbeatStop: make(chan struct{}),
 	}
+	if config.CallbackQueueSize > 0 {
+		s.callbacks = newCallbackQueue(config.CallbackQueueSize, config.CallbackOverflow)
+	}
+	return s
 }
 
 // OnAgentConnect sets the callback for when an agent connects
@@ -138,6 +269,11 @@ func (s *Server) OnPeriodicData(callback func(*PeriodicData)) {
 	s.onPeriodicData = callback
 }
 
+// OnCommandResult sets the callback for receiving command results from agents
+func (s *Server) OnCommandResult(callback func(*CommandResult)) {
+	s.onCommandResult = callback
+}
+
 // Start starts the gRPC server for agent connections
 func (s *Server) Start() error {
 	if err := s.startGRPC(); err != nil {
@@ -161,6 +297,9 @@ func (s *Server) startHeartbeatChecker() {
 			select {
 			case <-ticker.C:
 				s.checkHeartbeatTimeouts()
+				if s.grpcServicer != nil {
+					s.grpcServicer.reapStaleStreams()
+				}
 			case <-s.heartbeatStop:
 				return
 			}
@@ -172,16 +311,20 @@ func (s *Server) startHeartbeatChecker() {
 
 // checkHeartbeatTimeouts checks for agents that have timed out
 func (s *Server) checkHeartbeatTimeouts() {
-	var deadAgents []*AgentConnection
+	var agents, deadAgents []*AgentConnection
 
 	s.agentsMu.RLock()
 	for _, agent := range s.agents {
+		agents = append(agents, agent)
 		if agent.HeartbeatAge() > s.config.HeartbeatTimeout {
 			deadAgents = append(deadAgents, agent)
 		}
 	}
 	s.agentsMu.RUnlock()
 
+	// Report agents going stale, recovering or dying before any disconnect
+	s.notifyStatusChanges(agents)
+
 	// Unregister dead agents outside of the lock
 	for _, agent := range deadAgents {
 		log.Printf("Agent %s (%s) heartbeat timeout, disconnecting", agent.Hostname, agent.AgentID)
@@ -192,6 +335,10 @@ func (s *Server) checkHeartbeatTimeouts() {
 
 // startGRPC starts the gRPC server
 func (s *Server) startGRPC() error {
+	if err := setCompressionLevel(s.config.GrpcCompressionLevel); err != nil {
+		return err
+	}
+
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", s.config.GrpcPort))
 	if err != nil {
 		return err
@@ -234,6 +381,10 @@ func (s *Server) Stop() error {
 	s.agents = make(map[string]*AgentConnection)
 	s.agentsMu.Unlock()
 
+	if s.callbacks != nil {
+		s.callbacks.close()
+	}
+
 	return nil
 }
 
@@ -267,8 +418,25 @@ func (s *Server) GetAgents() map[string]*AgentConnection {
 	return result
 }
 
+// GetAgentsByLabel returns the connected agents carrying a label. An empty
+// value matches any agent that has the key.
+func (s *Server) GetAgentsByLabel(key, value string) map[string]*AgentConnection {
+	s.agentsMu.RLock()
+	defer s.agentsMu.RUnlock()
+	result := make(map[string]*AgentConnection)
+	for k, v := range s.agents {
+		if got, ok := v.Labels[key]; ok && (value == "" || got == value) {
+			result[k] = v
+		}
+	}
+	return result
+}
+
 // registerAgent registers a new agent
 func (s *Server) registerAgent(agent *AgentConnection) {
+	agent.setClock(s.config.Clock)
+	agent.setHealthThresholds(s.config.HeartbeatStaleAfter, s.config.HeartbeatTimeout)
+
 	s.agentsMu.Lock()
 	s.agents[agent.AgentID] = agent
 	s.agentsMu.Unlock()
@@ -296,43 +464,37 @@ func (s *Server) unregisterAgent(agent *AgentConnection) {
 // handleMetrics handles incoming metrics
 func (s *Server) handleMetrics(metrics *Metrics) {
 	if s.onMetrics != nil {
-		if s.config.AsyncCallbacks {
-			go s.onMetrics(metrics)
-		} else {
-			s.onMetrics(metrics)
-		}
+		s.dispatch(func() { s.onMetrics(metrics) })
 	}
 }
 
 // handleRealtimeMetrics handles incoming realtime metrics
 func (s *Server) handleRealtimeMetrics(realtime *RealtimeMetrics) {
 	if s.onRealtimeMetrics != nil {
-		if s.config.AsyncCallbacks {
-			go s.onRealtimeMetrics(realtime)
-		} else {
-			s.onRealtimeMetrics(realtime)
-		}
+		s.dispatch(func() { s.onRealtimeMetrics(realtime) })
 	}
 }
 
 // handleStaticInfo handles incoming static hardware info
 func (s *Server) handleStaticInfo(staticInfo *StaticInfo) {
 	if s.onStaticInfo != nil {
-		if s.config.AsyncCallbacks {
-			go s.onStaticInfo(staticInfo)
-		} else {
-			s.onStaticInfo(staticInfo)
-		}
+		s.dispatch(func() { s.onStaticInfo(staticInfo) })
 	}
 }
 
 // handlePeriodicData handles incoming periodic data
 func (s *Server) handlePeriodicData(periodic *PeriodicData) {
 	if s.onPeriodicData != nil {
+		s.dispatch(func() { s.onPeriodicData(periodic) })
+	}
+}
+
+func (s *Server) handleCommandResult(result *CommandResult) {
+	if s.onCommandResult != nil {
 		if s.config.AsyncCallbacks {
-			go s.onPeriodicData(periodic)
+			go s.onCommandResult(result)
 		} else {
-			s.onPeriodicData(periodic)
+			s.onCommandResult(result)
 		}
 	}
 }
@@ -359,9 +521,15 @@ func (s *Server) RequestDataWithTarget(agentID string, requestType int32, target
 
 // BroadcastDataRequest sends a data request to all connected agents.
 func (s *Server) BroadcastDataRequest(requestType int32) {
+	s.BroadcastDataRequestWithResult(requestType)
+}
+
+// BroadcastDataRequestWithResult sends a data request to all connected
+// agents and returns which agents received it, failed, or timed out.
+func (s *Server) BroadcastDataRequestWithResult(requestType int32) BroadcastResult {
 	if s.grpcServicer != nil {
-		s.grpcServicer.BroadcastDataRequest(pb.DataRequestType(requestType))
-	} else {
-		log.Printf("Cannot broadcast data request - gRPC service not available")
+		return s.grpcServicer.BroadcastDataRequestWithResult(pb.DataRequestType(requestType))
 	}
+	log.Printf("Cannot broadcast data request - gRPC service not available")
+	return BroadcastResult{}
 }
@@ -2,6 +2,7 @@ package nanolink
 
 import (
 	"testing"
+	"time"
 )
 
 func TestNewServer(t *testing.T) {
@@ -65,6 +66,54 @@ func TestCustomTokenValidator(t *testing.T) {
 	}
 }
 
+func TestStaticTokenValidatorAcceptsKnownTokens(t *testing.T) {
+	validate := StaticTokenValidator(map[string]int{
+		"reader-token": PermissionReadOnly,
+		"admin-token":  PermissionSystemAdmin,
+	})
+
+	result := validate("admin-token")
+	if !result.Valid {
+		t.Fatalf("Expected known token to be accepted, got %+v", result)
+	}
+	if result.PermissionLevel != PermissionSystemAdmin {
+		t.Errorf("Expected permission level %d, got %d", PermissionSystemAdmin, result.PermissionLevel)
+	}
+
+	result = validate("reader-token")
+	if !result.Valid || result.PermissionLevel != PermissionReadOnly {
+		t.Errorf("Expected read-only access for reader-token, got %+v", result)
+	}
+}
+
+func TestStaticTokenValidatorRejectsUnknownTokens(t *testing.T) {
+	tokens := map[string]int{"admin-token": PermissionSystemAdmin}
+	validate := StaticTokenValidator(tokens)
+
+	for _, token := range []string{"", "unknown", "admin-token-suffix", "admin"} {
+		if result := validate(token); result.Valid {
+			t.Errorf("Expected token %q to be rejected", token)
+		} else if result.ErrorMessage == "" {
+			t.Errorf("Expected an error message for token %q", token)
+		}
+	}
+
+	// Changes to the caller's map do not affect the validator
+	tokens["late-token"] = PermissionSystemAdmin
+	if validate("late-token").Valid {
+		t.Error("Expected token added after construction to be rejected")
+	}
+}
+
+func TestIsDefaultTokenValidator(t *testing.T) {
+	if !isDefaultTokenValidator(NewServer(Config{}).config.TokenValidator) {
+		t.Error("Expected unset validator to fall back to DefaultTokenValidator")
+	}
+	if isDefaultTokenValidator(StaticTokenValidator(nil)) {
+		t.Error("Expected StaticTokenValidator not to be reported as the default")
+	}
+}
+
 func TestGetAgentByHostname(t *testing.T) {
 	server := NewServer(Config{})
 
@@ -98,3 +147,45 @@ func TestPermissionConstants(t *testing.T) {
 		t.Errorf("Expected PermissionSystemAdmin to be 3, got %d", PermissionSystemAdmin)
 	}
 }
+
+func TestHeartbeatTimeout(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	server := NewServer(Config{
+		HeartbeatTimeout: 90 * time.Second,
+		Clock:            clock,
+	})
+
+	var disconnected []string
+	server.OnAgentDisconnect(func(a *AgentConnection) {
+		disconnected = append(disconnected, a.Hostname)
+	})
+
+	quiet := NewAgentConnectionFromGRPC("quiet-host", "linux", "amd64", "0.3.0", PermissionReadOnly)
+	chatty := NewAgentConnectionFromGRPC("chatty-host", "linux", "amd64", "0.3.0", PermissionReadOnly)
+	server.registerAgent(quiet)
+	server.registerAgent(chatty)
+
+	// Exactly at the timeout is still alive
+	clock.Advance(60 * time.Second)
+	chatty.UpdateHeartbeat()
+	clock.Advance(30 * time.Second)
+	server.checkHeartbeatTimeouts()
+	if len(disconnected) != 0 {
+		t.Fatalf("Expected no timeouts at the limit, got %v", disconnected)
+	}
+
+	clock.Advance(time.Second)
+	server.checkHeartbeatTimeouts()
+	if len(disconnected) != 1 || disconnected[0] != "quiet-host" {
+		t.Fatalf("Expected only quiet-host to time out, got %v", disconnected)
+	}
+	if !quiet.IsClosed() {
+		t.Error("Expected timed out agent connection to be closed")
+	}
+	if server.GetAgent(chatty.AgentID) == nil {
+		t.Error("Expected agent with recent heartbeat to stay registered")
+	}
+	if age := chatty.HeartbeatAge(); age != 31*time.Second {
+		t.Errorf("Expected heartbeat age 31s, got %v", age)
+	}
+}
@@ -0,0 +1,77 @@
+package nanolink
+
+import "log"
+
+// removeStreamLocked removes agentID's stream entries that still belong to
+// agent, returning how many were removed. s.mu must be held.
+func (s *NanoLinkServicer) removeStreamLocked(agentID string, agent *AgentConnection) int {
+	removed := 0
+	if as, ok := s.agentStreams[agentID]; ok && as.Agent == agent {
+		as.IsActive = false
+		delete(s.agentStreams, agentID)
+		removed++
+		if owner, ok := s.streamAgents[as.Stream]; ok && owner == agent {
+			delete(s.streamAgents, as.Stream)
+			removed++
+		}
+	}
+	if agent != nil && s.hostnameIndex[agent.Hostname] == agentID {
+		delete(s.hostnameIndex, agent.Hostname)
+		removed++
+	}
+	return removed
+}
+
+// streamEnded reports whether a stream's RPC has finished
+func streamEnded(as *AgentStream) bool {
+	if as.Stream == nil {
+		return true
+	}
+	ctx := as.Stream.Context()
+	return ctx != nil && ctx.Err() != nil
+}
+
+// reapStaleStreams reconciles the stream maps against the server's
+// registered agents, removing entries for agents that are gone, streams
+// that have ended and index entries pointing nowhere. A stream's own
+// cleanup normally does this; the sweep catches entries orphaned by
+// replaced connections and registration races. It returns how many
+// entries were removed.
+func (s *NanoLinkServicer) reapStaleStreams() int {
+	s.mu.Lock()
+	removed := 0
+	for agentID, as := range s.agentStreams {
+		// Looked up under s.mu so an agent registering meanwhile is not reaped
+		if !as.IsActive || s.server.GetAgent(agentID) != as.Agent || streamEnded(as) {
+			removed += s.removeStreamLocked(agentID, as.Agent)
+		}
+	}
+	for stream, agent := range s.streamAgents {
+		if as, ok := s.agentStreams[agent.AgentID]; !ok || as.Stream != stream {
+			delete(s.streamAgents, stream)
+			removed++
+		}
+	}
+	for hostname, agentID := range s.hostnameIndex {
+		if _, ok := s.agentStreams[agentID]; !ok {
+			delete(s.hostnameIndex, hostname)
+			removed++
+		}
+	}
+	s.mu.Unlock()
+
+	if removed > 0 {
+		s.reaped.Add(uint64(removed))
+		log.Printf("Reaped %d stale agent stream entries", removed)
+	}
+	return removed
+}
+
+// StaleStreamsReaped returns how many orphaned agent stream entries the
+// periodic sweep has removed since the server started
+func (s *Server) StaleStreamsReaped() uint64 {
+	if s.grpcServicer == nil {
+		return 0
+	}
+	return s.grpcServicer.reaped.Load()
+}
@@ -0,0 +1,113 @@
+package nanolink
+
+import (
+	"context"
+	"testing"
+)
+
+// contextStream is a fake agent stream whose RPC context can be ended
+type contextStream struct {
+	fakeStream
+	ctx context.Context
+}
+
+func (c *contextStream) Context() context.Context { return c.ctx }
+
+func newContextStream() (*contextStream, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &contextStream{ctx: ctx}, cancel
+}
+
+func TestReapStaleStreamsRemovesOrphanedEntries(t *testing.T) {
+	server := NewServer(Config{})
+	servicer := NewNanoLinkServicer(server)
+	server.grpcServicer = servicer
+
+	register := func(hostname string) (*AgentConnection, context.CancelFunc) {
+		stream, cancel := newContextStream()
+		agent := NewAgentConnectionFromGRPC(hostname, "linux", "amd64", "0.3.0", PermissionReadOnly)
+		server.registerAgent(agent)
+		servicer.registerAgentStream(agent, stream)
+		return agent, cancel
+	}
+
+	live, cancelLive := register("live")
+	defer cancelLive()
+	// Unregistered by the heartbeat checker while its stream never returned
+	timedOut, cancelTimedOut := register("timed-out")
+	defer cancelTimedOut()
+	server.unregisterAgent(timedOut)
+	// Stream ended without its cleanup running
+	ended, cancelEnded := register("ended")
+	cancelEnded()
+
+	if removed := servicer.reapStaleStreams(); removed != 6 {
+		t.Errorf("Expected 3 entries reaped for each of 2 agents, got %d", removed)
+	}
+	if _, ok := servicer.agentStreams[live.AgentID]; !ok {
+		t.Error("Expected the live agent's stream to be kept")
+	}
+	for _, agent := range []*AgentConnection{timedOut, ended} {
+		if _, ok := servicer.agentStreams[agent.AgentID]; ok {
+			t.Errorf("Expected %s's stream to be reaped", agent.Hostname)
+		}
+		if _, ok := servicer.hostnameIndex[agent.Hostname]; ok {
+			t.Errorf("Expected %s's hostname entry to be reaped", agent.Hostname)
+		}
+	}
+	if len(servicer.streamAgents) != 1 {
+		t.Errorf("Expected only the live stream left, got %d", len(servicer.streamAgents))
+	}
+	if server.StaleStreamsReaped() != 6 {
+		t.Errorf("Expected the reaped count to be reported, got %d", server.StaleStreamsReaped())
+	}
+	if removed := servicer.reapStaleStreams(); removed != 0 {
+		t.Errorf("Expected nothing left to reap, got %d", removed)
+	}
+}
+
+func TestCleanupAgentKeepsReplacementConnection(t *testing.T) {
+	server := NewServer(Config{})
+	servicer := NewNanoLinkServicer(server)
+
+	oldStream, cancelOld := newContextStream()
+	defer cancelOld()
+	old := NewAgentConnectionFromGRPC("web-1", "linux", "amd64", "0.3.0", PermissionReadOnly)
+	servicer.registerAgentStream(old, oldStream)
+
+	newStream, cancelNew := newContextStream()
+	defer cancelNew()
+	replacement := NewAgentConnectionFromGRPC("web-1", "linux", "amd64", "0.3.0", PermissionReadOnly)
+	servicer.registerAgentStream(replacement, newStream)
+
+	// The replaced stream's cleanup runs after the new connection registered
+	servicer.cleanupAgent(old, oldStream)
+
+	if stream, ok := servicer.getAgentStreamByHostname("web-1"); !ok || stream.Agent != replacement {
+		t.Error("Expected the hostname to still resolve to the replacement connection")
+	}
+	if _, ok := servicer.streamAgents[oldStream]; ok {
+		t.Error("Expected the replaced stream to be removed")
+	}
+}
+
+func TestRegisterAgentStreamReplacesEarlierAgentOnSameStream(t *testing.T) {
+	servicer := NewNanoLinkServicer(NewServer(Config{}))
+	stream, cancel := newContextStream()
+	defer cancel()
+
+	first := NewAgentConnectionFromGRPC("web-1", "linux", "amd64", "0.3.0", PermissionReadOnly)
+	servicer.registerAgentStream(first, stream)
+	second := NewAgentConnectionFromGRPC("web-2", "linux", "amd64", "0.3.0", PermissionReadOnly)
+	servicer.registerAgentStream(second, stream)
+
+	if _, ok := servicer.agentStreams[first.AgentID]; ok {
+		t.Error("Expected the stream's earlier agent to be dropped")
+	}
+	if _, ok := servicer.hostnameIndex["web-1"]; ok {
+		t.Error("Expected the earlier agent's hostname entry to be dropped")
+	}
+	if servicer.streamAgents[stream] != second {
+		t.Error("Expected the stream to map to its latest agent")
+	}
+}
@@ -0,0 +1,55 @@
+package nanolink
+
+import (
+	"errors"
+	"time"
+
+	pb "github.com/chenqi92/NanoLink/sdk/go/nanolink/proto"
+)
+
+// errSendTimeout is returned by streamSender.send when the send did not complete in time
+var errSendTimeout = errors.New("send timed out")
+
+// streamSender serializes sends on one agent stream, as gRPC does not allow
+// concurrent Send calls. A send that times out keeps running until the
+// stream unblocks or closes, and later sends wait for it within their own
+// timeout.
+type streamSender struct {
+	stream pb.NanoLinkService_StreamMetricsServer
+	turn   chan struct{} // holds a value while a Send is in flight
+}
+
+func newStreamSender(stream pb.NanoLinkService_StreamMetricsServer) *streamSender {
+	return &streamSender{stream: stream, turn: make(chan struct{}, 1)}
+}
+
+// send sends response, giving up waiting after timeout; zero waits as long
+// as the send takes
+func (w *streamSender) send(response *pb.MetricsStreamResponse, timeout time.Duration) error {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case w.turn <- struct{}{}:
+	case <-expired:
+		return errSendTimeout
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err := w.stream.Send(response)
+		<-w.turn
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-expired:
+		return errSendTimeout
+	}
+}
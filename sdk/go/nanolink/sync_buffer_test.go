@@ -0,0 +1,83 @@
+package nanolink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/chenqi92/NanoLink/sdk/go/nanolink/proto"
+)
+
+func TestSyncMetricsReturnsStreamedMetrics(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	server := NewServer(Config{SyncBufferSize: 3, SyncBufferRetention: time.Minute, Clock: clock})
+	servicer := NewNanoLinkServicer(server)
+	var connected *AgentConnection
+	server.OnAgentConnect(func(agent *AgentConnection) { connected = agent })
+
+	var requests []*pb.MetricsStreamRequest
+	for ts := uint64(1000); ts <= 4000; ts += 1000 {
+		requests = append(requests, &pb.MetricsStreamRequest{Request: &pb.MetricsStreamRequest_Metrics{
+			Metrics: &pb.Metrics{Hostname: "web-1", Timestamp: ts},
+		}})
+	}
+	if err := servicer.StreamMetrics(&scriptedStream{requests: requests}); err != nil {
+		t.Fatal(err)
+	}
+	if connected == nil {
+		t.Fatal("Expected the agent to connect")
+	}
+
+	// The agent reconnects after a blip and asks what the server has
+	resp, err := servicer.SyncMetrics(context.Background(), &pb.MetricsSyncRequest{
+		AgentId: connected.AgentID, LastSyncTimestamp: 2000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Metrics) != 2 || resp.Metrics[0].Timestamp != 3000 || resp.Metrics[1].Timestamp != 4000 {
+		t.Errorf("Expected the metrics after 2000, got %v", resp.Metrics)
+	}
+	if resp.ResendBuffer {
+		t.Error("Expected no resend when the buffer covers the last sync")
+	}
+
+	// 1000 was evicted, so the server cannot vouch for what followed it
+	resp, _ = servicer.SyncMetrics(context.Background(), &pb.MetricsSyncRequest{
+		AgentId: connected.AgentID, LastSyncTimestamp: 1000,
+	})
+	if !resp.ResendBuffer {
+		t.Error("Expected a resend request when the buffer starts after the last sync")
+	}
+
+	clock.Advance(2 * time.Minute)
+	resp, _ = servicer.SyncMetrics(context.Background(), &pb.MetricsSyncRequest{
+		AgentId: connected.AgentID, LastSyncTimestamp: 2000,
+	})
+	if len(resp.Metrics) != 0 || !resp.ResendBuffer {
+		t.Errorf("Expected expired metrics to be dropped, got %d, resend=%v", len(resp.Metrics), resp.ResendBuffer)
+	}
+}
+
+func TestSyncBufferReleasedAfterDisconnect(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	server := NewServer(Config{SyncBufferSize: 3, SyncBufferRetention: time.Minute, Clock: clock})
+	servicer := NewNanoLinkServicer(server)
+
+	for _, hostname := range []string{"web-1", "web-2"} {
+		stream := &scriptedStream{requests: []*pb.MetricsStreamRequest{{Request: &pb.MetricsStreamRequest_Metrics{
+			Metrics: &pb.Metrics{Hostname: hostname, Timestamp: 1000},
+		}}}}
+		if err := servicer.StreamMetrics(stream); err != nil {
+			t.Fatal(err)
+		}
+		// The next disconnect drops buffers of agents gone past the retention
+		clock.Advance(2 * time.Minute)
+	}
+
+	servicer.syncBuffer.mu.Lock()
+	defer servicer.syncBuffer.mu.Unlock()
+	if n := len(servicer.syncBuffer.agents); n != 1 {
+		t.Errorf("Expected only the latest agent's buffer to be kept, got %d", n)
+	}
+}